@@ -0,0 +1,76 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func publishAMQP(ctx context.Context, m *Message) error {
+	scheme := "amqp"
+	if m.TLSConfig != nil {
+		scheme = "amqps"
+	}
+	properties := amqp.NewConnectionProperties()
+	properties.SetClientConnectionName(fmt.Sprintf("%s %s", clientName, getClientVersion()))
+	conn, err := amqp.DialConfig(fmt.Sprintf("%s://%s/", scheme, m.Address), amqp.Config{
+		SASL:            []amqp.Authentication{&amqp.PlainAuth{Username: m.Username, Password: m.Password}},
+		TLSClientConfig: m.TLSConfig,
+		Properties:      properties,
+		Dial:            amqp.DefaultDial(getTimeout(ctx)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to connect to the AMQP server: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("unable to open an AMQP channel: %w", err)
+	}
+	closeNotify := ch.NotifyClose(make(chan *amqp.Error, 1))
+	// enable publisher confirms, this way we know if the message was accepted
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("unable to enable AMQP publisher confirms: %w", err)
+	}
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, m.Topic, m.RoutingKey, false, false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         m.Payload,
+		})
+	if err != nil {
+		return fmt.Errorf("unable to publish to AMQP exchange %q: %w", m.Topic, err)
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to publish to AMQP exchange %q: %w", m.Topic, err)
+	}
+	if !acked {
+		// pending confirmations are not acked if the server closes the channel
+		select {
+		case closeErr := <-closeNotify:
+			if closeErr != nil {
+				return fmt.Errorf("AMQP server closed the channel: %w", closeErr)
+			}
+		default:
+		}
+		return errors.New("the message was rejected by the AMQP server")
+	}
+	return nil
+}
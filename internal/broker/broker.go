@@ -0,0 +1,96 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package broker publishes messages to the supported message brokers
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+// Supported protocols
+const (
+	ProtocolKafka = "kafka"
+	ProtocolNATS  = "nats"
+	ProtocolAMQP  = "amqp"
+	ProtocolMQTT  = "mqtt"
+)
+
+const (
+	defaultTimeout = 20 * time.Second
+	clientName     = "SFTPGo"
+)
+
+// SupportedProtocols defines the supported message broker protocols
+var SupportedProtocols = []string{ProtocolKafka, ProtocolNATS, ProtocolAMQP, ProtocolMQTT}
+
+// Message defines a message to publish
+type Message struct {
+	// Protocol, see the above constants
+	Protocol string
+	// Address as host:port
+	Address string
+	// Kafka topic, NATS subject, AMQP exchange or MQTT topic
+	Topic string
+	// AMQP routing key
+	RoutingKey string
+	// Credentials. For Kafka SASL PLAIN authentication is used, for AMQP PLAIN authentication.
+	// For NATS, if only the password is set, it is used as authentication token
+	Username string
+	Password string
+	// TLSConfig, nil means plain text connections
+	TLSConfig *tls.Config
+	// Message content
+	Payload []byte
+}
+
+// Publish publishes the message using the configured protocol.
+// The message broker must acknowledge the message before the context deadline,
+// if no deadline is set a default timeout is applied
+func Publish(ctx context.Context, m *Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	switch m.Protocol {
+	case ProtocolKafka:
+		return publishKafka(ctx, m)
+	case ProtocolNATS:
+		return publishNATS(ctx, m)
+	case ProtocolAMQP:
+		return publishAMQP(ctx, m)
+	case ProtocolMQTT:
+		return publishMQTT(ctx, m)
+	default:
+		return fmt.Errorf("unsupported message broker protocol %q", m.Protocol)
+	}
+}
+
+// getTimeout returns the time left before the context deadline
+func getTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultTimeout
+}
+
+func getClientVersion() string {
+	return version.Get().Version
+}
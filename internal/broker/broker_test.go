@@ -0,0 +1,766 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const (
+	testUsername = "user"
+	testPassword = "password"
+)
+
+var testPayload = []byte(`{"event":"upload","name":"user1"}`)
+
+type publishedMessage struct {
+	topic      string
+	routingKey string
+	payload    []byte
+}
+
+func startTestServer(t *testing.T, handler func(net.Conn) error) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	errCh := make(chan error, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+				err := handler(conn)
+				select {
+				case errCh <- err:
+				default:
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), errCh
+}
+
+func getTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	clientConfig := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return serverConfig, clientConfig
+}
+
+func readNATSLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func expectNATSLine(r *bufio.Reader, expected string) error {
+	line, err := readNATSLine(r)
+	if err != nil {
+		return err
+	}
+	if line != expected {
+		return fmt.Errorf("unexpected command %q", line)
+	}
+	return nil
+}
+
+func getNATSHandler(messages chan publishedMessage, tlsConfig *tls.Config) func(net.Conn) error {
+	return func(conn net.Conn) error {
+		info := `INFO {"server_id":"test","proto":1,"max_payload":1048576}`
+		if tlsConfig != nil {
+			info = `INFO {"server_id":"test","proto":1,"max_payload":1048576,"tls_required":true}`
+		}
+		if _, err := conn.Write([]byte(info + "\r\n")); err != nil {
+			return err
+		}
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
+		reader := bufio.NewReader(conn)
+		connect, err := readNATSLine(reader)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(connect, fmt.Sprintf(`"pass":%q`, testPassword)) &&
+			!strings.Contains(connect, fmt.Sprintf(`"auth_token":%q`, testPassword)) {
+			_, err = conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+			return err
+		}
+		if err := expectNATSLine(reader, "PING"); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+			return err
+		}
+		pub, err := readNATSLine(reader)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(pub)
+		if len(fields) != 3 || fields[0] != "PUB" {
+			return fmt.Errorf("unexpected command %q", pub)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		payload := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+		if fields[1] == "denied" {
+			msg := fmt.Sprintf("-ERR 'Permissions Violation for Publish to %q'\r\n", fields[1])
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				return err
+			}
+		}
+		if err := expectNATSLine(reader, "PING"); err != nil {
+			return err
+		}
+		if fields[1] != "denied" {
+			messages <- publishedMessage{topic: fields[1], payload: payload[:size]}
+		}
+		if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+			return err
+		}
+		// wait for the client to close the connection
+		_, err = io.Copy(io.Discard, reader)
+		return err
+	}
+}
+
+func TestPublishNATS(t *testing.T) {
+	messages := make(chan publishedMessage, 1)
+	address, _ := startTestServer(t, getNATSHandler(messages, nil))
+	m := &Message{
+		Protocol: ProtocolNATS,
+		Address:  address,
+		Topic:    "sftpgo.events",
+		Password: testPassword,
+		Payload:  testPayload,
+	}
+	err := Publish(context.Background(), m)
+	require.NoError(t, err)
+	msg := <-messages
+	assert.Equal(t, m.Topic, msg.topic)
+	assert.Equal(t, testPayload, msg.payload)
+
+	m.Topic = "denied"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Permissions Violation")
+	}
+	m.Topic = "sftpgo.events"
+	m.Username = testUsername
+	m.Password = "wrong"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Authorization Violation")
+	}
+
+	serverTLSConfig, clientTLSConfig := getTestTLSConfigs(t)
+	tlsAddress, _ := startTestServer(t, getNATSHandler(messages, serverTLSConfig))
+	m.Address = tlsAddress
+	m.Password = testPassword
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "certificate")
+	}
+	m.TLSConfig = clientTLSConfig
+	err = Publish(context.Background(), m)
+	require.NoError(t, err)
+	msg = <-messages
+	assert.Equal(t, testPayload, msg.payload)
+}
+
+func TestPublishMQTT(t *testing.T) {
+	messages := make(chan publishedMessage, 1)
+	handler := func(conn net.Conn) error {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		connect, ok := cp.(*packets.ConnectPacket)
+		if !ok {
+			return fmt.Errorf("unexpected packet %s", cp.String())
+		}
+		connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		if connect.Username != testUsername || string(connect.Password) != testPassword {
+			connack.ReturnCode = packets.ErrRefusedBadUsernameOrPassword
+		}
+		if err := connack.Write(conn); err != nil {
+			return err
+		}
+		if connack.ReturnCode != packets.Accepted {
+			return nil
+		}
+		cp, err = packets.ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		publish, ok := cp.(*packets.PublishPacket)
+		if !ok || publish.Qos != mqttQoSAtLeastOnce {
+			return fmt.Errorf("unexpected packet %s", cp.String())
+		}
+		puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		puback.MessageID = publish.MessageID
+		if err := puback.Write(conn); err != nil {
+			return err
+		}
+		cp, err = packets.ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		if _, ok := cp.(*packets.DisconnectPacket); !ok {
+			return fmt.Errorf("unexpected packet %s", cp.String())
+		}
+		messages <- publishedMessage{
+			topic:   publish.TopicName,
+			payload: publish.Payload,
+		}
+		return nil
+	}
+	address, errCh := startTestServer(t, handler)
+	payload := bytes.Repeat(testPayload, 1000)
+	m := &Message{
+		Protocol: ProtocolMQTT,
+		Address:  address,
+		Topic:    "sftpgo/events",
+		Username: testUsername,
+		Password: testPassword,
+		Payload:  payload,
+	}
+	err := Publish(context.Background(), m)
+	require.NoError(t, err)
+	msg := <-messages
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, m.Topic, msg.topic)
+	assert.Equal(t, payload, msg.payload)
+
+	m.Password = "wrong"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bad user name or password")
+	}
+}
+
+// AMQP 0-9-1 frame types, class and method identifiers used by the test server
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+
+	amqpClassConnection = 10
+	amqpClassChannel    = 20
+	amqpClassBasic      = 60
+	amqpClassConfirm    = 85
+)
+
+func writeAMQPFrame(w io.Writer, frameType uint8, channel uint16, payload []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(frameType)
+	binary.Write(&buf, binary.BigEndian, channel)              //nolint:errcheck
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload))) //nolint:errcheck
+	buf.Write(payload)
+	buf.WriteByte(amqpFrameEnd)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeAMQPMethod(w io.Writer, channel, classID, methodID uint16, args []byte) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, classID)  //nolint:errcheck
+	binary.Write(&buf, binary.BigEndian, methodID) //nolint:errcheck
+	buf.Write(args)
+	return writeAMQPFrame(w, amqpFrameMethod, channel, buf.Bytes())
+}
+
+// readAMQPFrame reads the next frame, heartbeats are skipped
+func readAMQPFrame(r io.Reader) (uint8, []byte, error) {
+	for {
+		var header [7]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, nil, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, nil, err
+		}
+		if payload[len(payload)-1] != amqpFrameEnd {
+			return 0, nil, errors.New("invalid AMQP frame end")
+		}
+		if header[0] != amqpFrameHeartbeat {
+			return header[0], payload[:len(payload)-1], nil
+		}
+	}
+}
+
+func expectAMQPMethod(r io.Reader, classID, methodID uint16) ([]byte, error) {
+	frameType, payload, err := readAMQPFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if frameType != amqpFrameMethod || len(payload) < 4 || binary.BigEndian.Uint16(payload) != classID ||
+		binary.BigEndian.Uint16(payload[2:]) != methodID {
+		return nil, fmt.Errorf("unexpected AMQP frame, expected method %d.%d", classID, methodID)
+	}
+	return payload[4:], nil
+}
+
+// waitAMQPClose waits for the client to close the connection
+func waitAMQPClose(conn net.Conn) error {
+	for {
+		frameType, payload, err := readAMQPFrame(conn)
+		if err != nil {
+			return err
+		}
+		if frameType == amqpFrameMethod && binary.BigEndian.Uint16(payload) == amqpClassConnection &&
+			binary.BigEndian.Uint16(payload[2:]) == 50 {
+			return writeAMQPMethod(conn, 0, amqpClassConnection, 51, nil)
+		}
+	}
+}
+
+func appendAMQPShortString(buf *bytes.Buffer, s string) {
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
+
+func appendAMQPLongString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s))) //nolint:errcheck
+	buf.WriteString(s)
+}
+
+func getAMQPHandler(messages chan publishedMessage) func(net.Conn) error {
+	return func(conn net.Conn) error {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		if !bytes.Equal(header, []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}) {
+			return errors.New("invalid protocol header")
+		}
+		var args bytes.Buffer
+		args.Write([]byte{0, 9, 0, 0, 0, 0})
+		appendAMQPLongString(&args, "PLAIN")
+		appendAMQPLongString(&args, "en_US")
+		if err := writeAMQPMethod(conn, 0, amqpClassConnection, 10, args.Bytes()); err != nil {
+			return err
+		}
+		startOk, err := expectAMQPMethod(conn, amqpClassConnection, 11)
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(startOk, []byte("\x00"+testUsername+"\x00"+testPassword)) {
+			// RabbitMQ closes the socket if the authentication fails
+			return nil
+		}
+		args.Reset()
+		binary.Write(&args, binary.BigEndian, uint16(2047)) //nolint:errcheck
+		binary.Write(&args, binary.BigEndian, uint32(4096)) //nolint:errcheck
+		binary.Write(&args, binary.BigEndian, uint16(0))    //nolint:errcheck
+		if err := writeAMQPMethod(conn, 0, amqpClassConnection, 30, args.Bytes()); err != nil {
+			return err
+		}
+		// tune-ok, open/open-ok, channel open/open-ok, confirm select/select-ok
+		for _, step := range []struct {
+			channel         uint16
+			classID         uint16
+			methodID        uint16
+			replyMethodID   uint16
+			replyArgs       []byte
+			replyIsRequired bool
+		}{
+			{0, amqpClassConnection, 31, 0, nil, false},
+			{0, amqpClassConnection, 40, 41, []byte{0}, true},
+			{1, amqpClassChannel, 10, 11, []byte{0, 0, 0, 0}, true},
+			{1, amqpClassConfirm, 10, 11, nil, true},
+		} {
+			if _, err := expectAMQPMethod(conn, step.classID, step.methodID); err != nil {
+				return err
+			}
+			if step.replyIsRequired {
+				if err := writeAMQPMethod(conn, step.channel, step.classID, step.replyMethodID, step.replyArgs); err != nil {
+					return err
+				}
+			}
+		}
+		publish, err := expectAMQPMethod(conn, amqpClassBasic, 40)
+		if err != nil {
+			return err
+		}
+		exchangeLen := int(publish[2])
+		msg := publishedMessage{
+			topic:      string(publish[3 : 3+exchangeLen]),
+			routingKey: string(publish[4+exchangeLen : 4+exchangeLen+int(publish[3+exchangeLen])]),
+		}
+		frameType, contentHeader, err := readAMQPFrame(conn)
+		if err != nil {
+			return err
+		}
+		if frameType != amqpFrameHeader {
+			return fmt.Errorf("unexpected frame type %d", frameType)
+		}
+		bodySize := binary.BigEndian.Uint64(contentHeader[4:])
+		for uint64(len(msg.payload)) < bodySize {
+			frameType, body, err := readAMQPFrame(conn)
+			if err != nil {
+				return err
+			}
+			if frameType != amqpFrameBody {
+				return fmt.Errorf("unexpected frame type %d", frameType)
+			}
+			msg.payload = append(msg.payload, body...)
+		}
+		// the delivery tag of the first message is 1
+		confirm := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0}
+		switch msg.topic {
+		case "missing":
+			args.Reset()
+			binary.Write(&args, binary.BigEndian, uint16(404)) //nolint:errcheck
+			appendAMQPShortString(&args, "NOT_FOUND - no exchange 'missing'")
+			binary.Write(&args, binary.BigEndian, uint32(0)) //nolint:errcheck
+			err = writeAMQPMethod(conn, 1, amqpClassChannel, 40, args.Bytes())
+		case "nack":
+			err = writeAMQPMethod(conn, 1, amqpClassBasic, 120, confirm)
+		default:
+			messages <- msg
+			err = writeAMQPMethod(conn, 1, amqpClassBasic, 80, confirm)
+		}
+		if err != nil {
+			return err
+		}
+		return waitAMQPClose(conn)
+	}
+}
+
+func TestPublishAMQP(t *testing.T) {
+	messages := make(chan publishedMessage, 1)
+	address, errCh := startTestServer(t, getAMQPHandler(messages))
+	// the payload is split in multiple frames
+	payload := bytes.Repeat(testPayload, 500)
+	m := &Message{
+		Protocol:   ProtocolAMQP,
+		Address:    address,
+		Topic:      "sftpgo",
+		RoutingKey: "events.upload",
+		Username:   testUsername,
+		Password:   testPassword,
+		Payload:    payload,
+	}
+	err := Publish(context.Background(), m)
+	require.NoError(t, err)
+	msg := <-messages
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, m.Topic, msg.topic)
+	assert.Equal(t, m.RoutingKey, msg.routingKey)
+	assert.Equal(t, payload, msg.payload)
+
+	m.Topic = "missing"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AMQP server closed the channel")
+		assert.Contains(t, err.Error(), "404")
+	}
+	m.Topic = "nack"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rejected")
+	}
+	m.Password = "wrong"
+	err = Publish(context.Background(), m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "username or password not allowed")
+	}
+}
+
+// getKafkaHandler returns a single broker Kafka cluster, the topic "missing"
+// does not exist and the topic "readonly" cannot be written
+func getKafkaHandler(messages chan publishedMessage) func(net.Conn) error {
+	return func(conn net.Conn) error {
+		for {
+			var size int32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return err
+			}
+			// request header: api key, api version, correlation id and client id
+			key := int16(binary.BigEndian.Uint16(buf))
+			version := int16(binary.BigEndian.Uint16(buf[2:]))
+			correlationID := binary.BigEndian.Uint32(buf[4:])
+			pos := 10
+			if clientIDLen := int16(binary.BigEndian.Uint16(buf[8:])); clientIDLen > 0 {
+				pos += int(clientIDLen)
+			}
+			req := kmsg.RequestForKey(key)
+			if req == nil {
+				return fmt.Errorf("unsupported Kafka api key %d", key)
+			}
+			req.SetVersion(version)
+			if req.IsFlexible() {
+				// the tagged fields in the request header are not used
+				numTags, n := binary.Uvarint(buf[pos:])
+				pos += n
+				for i := uint64(0); i < numTags; i++ {
+					_, n = binary.Uvarint(buf[pos:])
+					pos += n
+					tagLen, n := binary.Uvarint(buf[pos:])
+					pos += n + int(tagLen)
+				}
+			}
+			if err := req.ReadFrom(buf[pos:]); err != nil {
+				return err
+			}
+			resp, err := handleKafkaRequest(req, conn.LocalAddr().String(), messages)
+			if err != nil {
+				return err
+			}
+			resp.SetVersion(version)
+			header := binary.BigEndian.AppendUint32(nil, correlationID)
+			// the ApiVersions response header is never flexible
+			if resp.IsFlexible() && key != 18 {
+				header = append(header, 0)
+			}
+			body := resp.AppendTo(header)
+			if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func handleKafkaRequest(req kmsg.Request, address string, messages chan publishedMessage) (kmsg.Response, error) {
+	switch r := req.(type) {
+	case *kmsg.ApiVersionsRequest:
+		resp := kmsg.NewPtrApiVersionsResponse()
+		for _, k := range []int16{0, 3, 17, 18, 22, 36} {
+			apiKey := kmsg.NewApiVersionsResponseApiKey()
+			apiKey.ApiKey = k
+			apiKey.MaxVersion = kmsg.RequestForKey(k).MaxVersion()
+			resp.ApiKeys = append(resp.ApiKeys, apiKey)
+		}
+		return resp, nil
+	case *kmsg.SASLHandshakeRequest:
+		resp := kmsg.NewPtrSASLHandshakeResponse()
+		resp.SupportedMechanisms = []string{"PLAIN"}
+		if r.Mechanism != "PLAIN" {
+			resp.ErrorCode = 33 // UNSUPPORTED_SASL_MECHANISM
+		}
+		return resp, nil
+	case *kmsg.SASLAuthenticateRequest:
+		resp := kmsg.NewPtrSASLAuthenticateResponse()
+		if !bytes.Equal(r.SASLAuthBytes, []byte("\x00"+testUsername+"\x00"+testPassword)) {
+			resp.ErrorCode = 58 // SASL_AUTHENTICATION_FAILED
+			resp.ErrorMessage = kmsg.StringPtr("invalid credentials")
+		}
+		return resp, nil
+	case *kmsg.MetadataRequest:
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, err
+		}
+		resp := kmsg.NewPtrMetadataResponse()
+		broker := kmsg.NewMetadataResponseBroker()
+		broker.Host = host
+		broker.Port = int32(portNumber)
+		resp.Brokers = append(resp.Brokers, broker)
+		for _, t := range r.Topics {
+			topic := kmsg.NewMetadataResponseTopic()
+			topic.Topic = t.Topic
+			if t.Topic != nil && *t.Topic == "missing" {
+				topic.ErrorCode = 3 // UNKNOWN_TOPIC_OR_PARTITION
+			} else {
+				partition := kmsg.NewMetadataResponseTopicPartition()
+				partition.Replicas = []int32{0}
+				partition.ISR = []int32{0}
+				topic.Partitions = append(topic.Partitions, partition)
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp, nil
+	case *kmsg.InitProducerIDRequest:
+		resp := kmsg.NewPtrInitProducerIDResponse()
+		resp.ProducerID = 1
+		return resp, nil
+	case *kmsg.ProduceRequest:
+		resp := kmsg.NewPtrProduceResponse()
+		for _, t := range r.Topics {
+			topic := kmsg.NewProduceResponseTopic()
+			topic.Topic = t.Topic
+			for _, p := range t.Partitions {
+				partition := kmsg.NewProduceResponseTopicPartition()
+				partition.Partition = p.Partition
+				if t.Topic == "readonly" {
+					partition.ErrorCode = 29 // TOPIC_AUTHORIZATION_FAILED
+				} else {
+					batch := kmsg.NewRecordBatch()
+					if err := batch.ReadFrom(p.Records); err != nil {
+						return nil, err
+					}
+					record := kmsg.NewRecord()
+					if err := record.ReadFrom(batch.Records); err != nil {
+						return nil, err
+					}
+					messages <- publishedMessage{topic: t.Topic, payload: record.Value}
+				}
+				topic.Partitions = append(topic.Partitions, partition)
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp, nil
+	default:
+		return nil, fmt.Errorf("unexpected Kafka request %T", req)
+	}
+}
+
+func TestPublishKafka(t *testing.T) {
+	messages := make(chan publishedMessage, 1)
+	address, _ := startTestServer(t, getKafkaHandler(messages))
+	m := &Message{
+		Protocol: ProtocolKafka,
+		Address:  address,
+		Topic:    "sftpgo-events",
+		Username: testUsername,
+		Password: testPassword,
+		Payload:  testPayload,
+	}
+	err := Publish(context.Background(), m)
+	require.NoError(t, err)
+	msg := <-messages
+	assert.Equal(t, m.Topic, msg.topic)
+	assert.Equal(t, testPayload, msg.payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m.Topic = "missing"
+	err = Publish(ctx, m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "UNKNOWN_TOPIC_OR_PARTITION")
+	}
+	m.Topic = "readonly"
+	err = Publish(ctx, m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "TOPIC_AUTHORIZATION_FAILED")
+	}
+	m.Topic = "sftpgo-events"
+	m.Password = "wrong"
+	err = Publish(ctx, m)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "SASL_AUTHENTICATION_FAILED")
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	err := Publish(context.Background(), &Message{Protocol: "unknown"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported message broker protocol")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	err = listener.Close()
+	require.NoError(t, err)
+	assertPublishErrors := func(address string) {
+		for _, protocol := range SupportedProtocols {
+			// the clients may retry until the deadline
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			err := Publish(ctx, &Message{Protocol: protocol, Address: address, Topic: "topic"})
+			assert.Error(t, err, "protocol %s", protocol)
+			cancel()
+		}
+	}
+	assertPublishErrors(address)
+	// a server that never replies
+	address, _ = startTestServer(t, func(conn net.Conn) error {
+		_, err := io.Copy(io.Discard, conn)
+		return err
+	})
+	assertPublishErrors(address)
+	// invalid replies
+	address, _ = startTestServer(t, func(conn net.Conn) error {
+		_, err := conn.Write([]byte("invalid reply\r\n\r\n\r\n\r\n"))
+		return err
+	})
+	assertPublishErrors(address)
+	_, clientTLSConfig := getTestTLSConfigs(t)
+	err = Publish(context.Background(), &Message{Protocol: ProtocolMQTT, Address: address, TLSConfig: clientTLSConfig})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "tls")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+func publishKafka(ctx context.Context, m *Message) error {
+	timeout := getTimeout(ctx)
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(m.Address),
+		kgo.ClientID(clientName),
+		kgo.DialTimeout(timeout),
+		// the context is not used while the connection is initialized,
+		// the minimum allowed overhead is one second
+		kgo.RequestTimeoutOverhead(max(timeout, time.Second)),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// the events are small and they are published one at a time
+		kgo.ProducerBatchCompression(kgo.NoCompression()),
+	}
+	if m.TLSConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(m.TLSConfig))
+	}
+	if m.Username != "" {
+		opts = append(opts, kgo.SASL(plain.Auth{User: m.Username, Pass: m.Password}.AsMechanism()))
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return fmt.Errorf("unable to create the Kafka client: %w", err)
+	}
+	defer client.Close()
+
+	// the producer retries connection and authentication errors until the
+	// context expires, ping the seed broker so these errors are reported
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("unable to connect to the Kafka broker: %w", err)
+	}
+	record := &kgo.Record{
+		Topic: m.Topic,
+		Value: m.Payload,
+	}
+	if err := client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return fmt.Errorf("unable to publish to Kafka topic %q: %w", m.Topic, err)
+	}
+	return nil
+}
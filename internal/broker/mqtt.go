@@ -0,0 +1,65 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/xid"
+)
+
+const (
+	mqttProtocolVersion = 4 // MQTT 3.1.1
+	mqttQoSAtLeastOnce  = 1
+	mqttQuiesce         = 250
+)
+
+func publishMQTT(ctx context.Context, m *Message) error {
+	scheme := "tcp"
+	if m.TLSConfig != nil {
+		scheme = "ssl"
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(fmt.Sprintf("%s://%s", scheme, m.Address)).
+		SetClientID(fmt.Sprintf("sftpgo-%s", xid.New().String())).
+		SetProtocolVersion(mqttProtocolVersion).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(getTimeout(ctx)).
+		SetWriteTimeout(getTimeout(ctx)).
+		SetTLSConfig(m.TLSConfig).
+		SetUsername(m.Username).
+		SetPassword(m.Password)
+	client := mqtt.NewClient(opts)
+	if err := waitMQTTToken(ctx, client.Connect()); err != nil {
+		return fmt.Errorf("unable to connect to the MQTT broker: %w", err)
+	}
+	defer client.Disconnect(mqttQuiesce)
+
+	if err := waitMQTTToken(ctx, client.Publish(m.Topic, mqttQoSAtLeastOnce, false, m.Payload)); err != nil {
+		return fmt.Errorf("unable to publish to MQTT topic %q: %w", m.Topic, err)
+	}
+	return nil
+}
+
+func waitMQTTToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+func publishNATS(ctx context.Context, m *Message) error {
+	opts := []nats.Option{
+		nats.Name(clientName),
+		nats.Timeout(getTimeout(ctx)),
+		nats.NoReconnect(),
+		// asynchronous errors are checked after the flush, the default
+		// handler would print them to stderr
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {}),
+	}
+	if m.TLSConfig != nil {
+		opts = append(opts, nats.Secure(m.TLSConfig))
+	}
+	if m.Username != "" {
+		opts = append(opts, nats.UserInfo(m.Username, m.Password))
+	} else if m.Password != "" {
+		opts = append(opts, nats.Token(m.Password))
+	}
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s", m.Address), opts...)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the NATS server: %w", err)
+	}
+	defer nc.Close()
+
+	if err := nc.Publish(m.Topic, m.Payload); err != nil {
+		return fmt.Errorf("unable to publish to NATS subject %q: %w", m.Topic, err)
+	}
+	// the server processes the commands in order, after a successful flush
+	// the message was accepted unless an asynchronous error was reported
+	if err := nc.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("unable to publish to NATS subject %q: %w", m.Topic, err)
+	}
+	if err := nc.LastError(); err != nil {
+		return fmt.Errorf("unable to publish to NATS subject %q: %w", m.Topic, err)
+	}
+	return nil
+}
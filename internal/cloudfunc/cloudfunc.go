@@ -0,0 +1,294 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package cloudfunc allows to invoke serverless functions such as AWS Lambda
+// and Google Cloud Functions
+package cloudfunc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+)
+
+// Supported providers
+const (
+	ProviderAWSLambda = "aws_lambda"
+	ProviderGCF       = "gcp_functions"
+)
+
+const (
+	defaultTimeout  = 30 * time.Second
+	maxResultSize   = 1048576 // 1 MB
+	lambdaAPIPath   = "/2015-03-31/functions/%s/invocations"
+	lambdaService   = "lambda"
+	lambdaErrHeader = "X-Amz-Function-Error"
+)
+
+var (
+	// SupportedProviders defines the supported serverless providers
+	SupportedProviders = []string{ProviderAWSLambda, ProviderGCF}
+)
+
+// Request defines a function invocation
+type Request struct {
+	// Provider, see the above constants
+	Provider string
+	// AWS Lambda function name, ARN or partial ARN, optionally with a version
+	// or alias suffix. Google Cloud Functions URL
+	Function string
+	// AWS region
+	Region string
+	// AWS static credentials, if empty the default credentials chain is used
+	AccessKey    string
+	AccessSecret string
+	// optional AWS role to assume
+	RoleARN string
+	// optional custom AWS Lambda endpoint, for example for compatible services
+	Endpoint string
+	// Google service account credentials as JSON,
+	// if empty the application default credentials are used
+	Credentials []byte
+	// If true the AWS Lambda function is invoked asynchronously, the function
+	// result is not available and only errors queuing the event are reported
+	Async bool
+	// Event payload
+	Payload []byte
+}
+
+// Result defines the function invocation result
+type Result struct {
+	// HTTP status code
+	StatusCode int
+	// Function response, empty for async invocations
+	Payload []byte
+}
+
+// Invoke invokes the function described by the specified request.
+// For synchronous invocations an error is returned if the function fails.
+// If the context has no deadline a default timeout is applied
+func Invoke(ctx context.Context, r *Request) (*Result, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	switch r.Provider {
+	case ProviderAWSLambda:
+		return invokeLambda(ctx, r)
+	case ProviderGCF:
+		return invokeGCF(ctx, r)
+	default:
+		return nil, fmt.Errorf("unsupported serverless provider %q", r.Provider)
+	}
+}
+
+func getAWSConfig(ctx context.Context, r *Request) (aws.Config, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return awsConfig, fmt.Errorf("unable to get AWS config: %w", err)
+	}
+	if r.Region != "" {
+		awsConfig.Region = r.Region
+	}
+	if awsConfig.Region == "" {
+		return awsConfig, errors.New("AWS region is required")
+	}
+	if r.AccessSecret != "" {
+		awsConfig.Credentials = aws.NewCredentialsCache(
+			credentials.NewStaticCredentialsProvider(r.AccessKey, r.AccessSecret, ""))
+	}
+	if r.RoleARN != "" {
+		client := sts.NewFromConfig(awsConfig)
+		awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, r.RoleARN))
+	}
+	return awsConfig, nil
+}
+
+func getLambdaEndpoint(r *Request, region string) string {
+	endpoint := strings.TrimSuffix(r.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://lambda.%s.amazonaws.com", region)
+	}
+	return endpoint + fmt.Sprintf(lambdaAPIPath, url.PathEscape(r.Function))
+}
+
+func invokeLambda(ctx context.Context, r *Request) (*Result, error) {
+	awsConfig, err := getAWSConfig(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig.Credentials == nil {
+		return nil, errors.New("no AWS credentials found")
+	}
+	creds, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get AWS credentials: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getLambdaEndpoint(r, awsConfig.Region),
+		bytes.NewReader(r.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Async {
+		req.Header.Set("X-Amz-Invocation-Type", "Event")
+	} else {
+		req.Header.Set("X-Amz-Invocation-Type", "RequestResponse")
+	}
+	payloadHash := sha256.Sum256(r.Payload)
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), lambdaService,
+		awsConfig.Region, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign the AWS Lambda request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &Result{
+		StatusCode: resp.StatusCode,
+	}
+	result.Payload, err = io.ReadAll(io.LimitReader(resp.Body, maxResultSize))
+	if err != nil {
+		return result, fmt.Errorf("unable to read the AWS Lambda response: %w", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
+		return result, fmt.Errorf("unexpected AWS Lambda status code: %d, %s", resp.StatusCode,
+			getErrorMessage(result.Payload, "message", "Message"))
+	}
+	if functionError := resp.Header.Get(lambdaErrHeader); functionError != "" {
+		return result, fmt.Errorf("AWS Lambda function error %q: %s", functionError,
+			getErrorMessage(result.Payload, "errorMessage"))
+	}
+	return result, nil
+}
+
+func getGCFClient(ctx context.Context, r *Request) (*http.Client, error) {
+	u, err := url.Parse(r.Function)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google Cloud Functions URL: %w", err)
+	}
+	// the audience is the function URL without query parameters
+	u.RawQuery = ""
+	u.Fragment = ""
+	var opts []idtoken.ClientOption
+	if len(r.Credentials) > 0 {
+		opts = append(opts, option.WithCredentialsJSON(r.Credentials))
+	}
+	ts, err := idtoken.NewTokenSource(ctx, u.String(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Google ID token source: %w", err)
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+func invokeGCF(ctx context.Context, r *Request) (*Result, error) {
+	client, err := getGCFClient(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Function, bytes.NewReader(r.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &Result{
+		StatusCode: resp.StatusCode,
+	}
+	result.Payload, err = io.ReadAll(io.LimitReader(resp.Body, maxResultSize))
+	if err != nil {
+		return result, fmt.Errorf("unable to read the Google Cloud Functions response: %w", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
+		return result, fmt.Errorf("unexpected Google Cloud Functions status code: %d, %s", resp.StatusCode,
+			getErrorMessage(result.Payload))
+	}
+	return result, nil
+}
+
+// GetResultError returns the value of the specified top level field of the JSON result,
+// if the field is not set or is empty, false or null, an empty string is returned
+func (r *Result) GetResultError(field string) string {
+	if field == "" || len(r.Payload) == 0 {
+		return ""
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(r.Payload, &fields); err != nil {
+		return ""
+	}
+	switch v := fields[field].(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "true"
+		}
+		return ""
+	case string:
+		return v
+	case float64:
+		if v == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+// getErrorMessage returns the first non empty field found in the JSON payload,
+// if none is found the payload is returned as is
+func getErrorMessage(payload []byte, fields ...string) string {
+	var resp map[string]any
+	if err := json.Unmarshal(payload, &resp); err == nil {
+		for _, field := range fields {
+			if msg, ok := resp[field].(string); ok && msg != "" {
+				return msg
+			}
+		}
+	}
+	return strings.TrimSpace(string(payload))
+}
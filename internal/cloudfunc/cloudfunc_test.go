@@ -0,0 +1,240 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cloudfunc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAccessKey = "AKIDTEST"
+	testRegion    = "eu-west-1"
+)
+
+var testPayload = []byte(`{"event":"upload","name":"user1"}`)
+
+func setTestAWSEnv(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+}
+
+func TestInvokeLambda(t *testing.T) {
+	setTestAWSEnv(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != string(testPayload) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		expectedCredential := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/", testAccessKey)
+		if !strings.HasPrefix(auth, expectedCredential) ||
+			!strings.Contains(auth, fmt.Sprintf("/%s/lambda/aws4_request", testRegion)) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"The security token included in the request is invalid."}`)) //nolint:errcheck
+			return
+		}
+		switch r.URL.Path {
+		case "/2015-03-31/functions/ok/invocations":
+			if r.Header.Get("X-Amz-Invocation-Type") == "Event" {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Write([]byte(`{"status":"done"}`)) //nolint:errcheck
+		case "/2015-03-31/functions/fail/invocations":
+			w.Header().Set(lambdaErrHeader, "Unhandled")
+			w.Write([]byte(`{"errorMessage":"something went wrong","errorType":"Error"}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"Message":"Function not found","Type":"User"}`)) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	r := &Request{
+		Provider:     ProviderAWSLambda,
+		Function:     "ok",
+		Region:       testRegion,
+		AccessKey:    testAccessKey,
+		AccessSecret: "secret",
+		Endpoint:     server.URL + "/",
+		Payload:      testPayload,
+	}
+	result, err := Invoke(context.Background(), r)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, `{"status":"done"}`, string(result.Payload))
+
+	r.Async = true
+	result, err = Invoke(context.Background(), r)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, result.StatusCode)
+	assert.Len(t, result.Payload, 0)
+
+	r.Async = false
+	r.Function = "fail"
+	result, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `AWS Lambda function error "Unhandled": something went wrong`)
+	}
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+
+	r.Function = "missing"
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected AWS Lambda status code: 404, Function not found")
+	}
+
+	r.AccessKey = "invalid"
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "The security token included in the request is invalid")
+	}
+
+	r.Region = ""
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AWS region is required")
+	}
+
+	r.Region = testRegion
+	r.Endpoint = "http://127.0.0.1:1"
+	_, err = Invoke(context.Background(), r)
+	assert.Error(t, err)
+}
+
+func getTestGoogleCredentials(t *testing.T, tokenURL string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "sftpgo",
+		"private_key_id": "1",
+		"private_key":    string(keyPEM),
+		"client_email":   "sftpgo@sftpgo.iam.gserviceaccount.com",
+		"client_id":      "1",
+		"token_uri":      tokenURL,
+	})
+	require.NoError(t, err)
+	return creds
+}
+
+func TestInvokeGCF(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(time.Hour).Unix())))
+	idToken := "eyJhbGciOiJSUzI1NiJ9." + claims + ".c2lnbmF0dXJl"
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"id_token":%q}`, idToken))) //nolint:errcheck
+	}))
+	defer tokenServer.Close()
+
+	functionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+idToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != string(testPayload) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error: could not handle the request\n")) //nolint:errcheck
+			return
+		}
+		w.Write([]byte(`{"error":"file rejected"}`)) //nolint:errcheck
+	}))
+	defer functionServer.Close()
+
+	r := &Request{
+		Provider:    ProviderGCF,
+		Function:    functionServer.URL + "/ok?param=value",
+		Credentials: getTestGoogleCredentials(t, tokenServer.URL),
+		Payload:     testPayload,
+	}
+	result, err := Invoke(context.Background(), r)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "file rejected", result.GetResultError("error"))
+
+	r.Function = functionServer.URL + "/fail"
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected Google Cloud Functions status code: 500, Error: could not handle the request")
+	}
+
+	r.Credentials = []byte("{}")
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to get Google ID token source")
+	}
+
+	r.Function = "http://foo\x7f.com/"
+	_, err = Invoke(context.Background(), r)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid Google Cloud Functions URL")
+	}
+}
+
+func TestGetResultError(t *testing.T) {
+	result := &Result{}
+	assert.Empty(t, result.GetResultError("error"))
+	result.Payload = []byte("not JSON")
+	assert.Empty(t, result.GetResultError("error"))
+	result.Payload = []byte(`{"error":null,"failed":false,"code":0,"message":""}`)
+	for _, field := range []string{"", "error", "failed", "code", "message", "missing"} {
+		assert.Empty(t, result.GetResultError(field), field)
+	}
+	result.Payload = []byte(`{"error":{"code":1},"failed":true,"code":3,"message":"rejected"}`)
+	assert.Equal(t, `{"code":1}`, result.GetResultError("error"))
+	assert.Equal(t, "true", result.GetResultError("failed"))
+	assert.Equal(t, "3", result.GetResultError("code"))
+	assert.Equal(t, "rejected", result.GetResultError("message"))
+}
+
+func TestInvokeErrors(t *testing.T) {
+	_, err := Invoke(context.Background(), &Request{Provider: "unknown"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported serverless provider")
+	}
+}
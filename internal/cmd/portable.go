@@ -96,6 +96,7 @@ var (
 	portableSFTPPrefix                 string
 	portableSFTPDisableConcurrentReads bool
 	portableSFTPDBufferSize            int64
+	portableFUSEMountPoint             string
 	portableCmd                        = &cobra.Command{
 		Use:   "portable",
 		Short: "Serve a single directory/account",
@@ -268,7 +269,7 @@ Please take a look at the usage below to customize the serving parameters`,
 			}
 			err := service.StartPortableMode(portableSFTPDPort, portableFTPDPort, portableWebDAVPort, portableSSHCommands,
 				portableFTPSCert, portableFTPSKey, portableWebDAVCert,
-				portableWebDAVKey)
+				portableWebDAVKey, portableFUSEMountPoint)
 			if err == nil {
 				service.Wait()
 				if service.Error == nil {
@@ -409,6 +410,12 @@ multiple concurrent requests and this
 allows data to be transferred at a
 faster rate, over high latency networks,
 by overlapping round-trip times`)
+	portableCmd.Flags().StringVar(&portableFUSEMountPoint, "fuse-mount", "", `Mount the served filesystem, read-only,
+at the specified local directory using
+FUSE. The filesystem is shown exactly
+as the portable user sees it. Supported
+on Linux, macOS and FreeBSD. Empty
+means disabled`)
 	portableCmd.Flags().IntVar(&graceTime, graceTimeFlag, 0,
 		`This grace time defines the number of
 seconds allowed for existing transfers
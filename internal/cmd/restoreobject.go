@@ -0,0 +1,152 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpd"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	restoreObjectInputFile string
+	restoreObjectType      string
+	restoreObjectName      string
+	restoreObjectMode      int
+	restoreObjectPreview   bool
+	restoreObjectCmd       = &cobra.Command{
+		Use:   "restoreobject",
+		Short: "Restore a single user, group or folder from a dump file",
+		Long: `This command reads the data provider connection details from the specified
+configuration file and restores the specified user, group or folder from a
+dump file, created using dumpdata or a scheduled backup.
+The changes are printed as JSON. Use the "--preview" flag to only print the
+changes without restoring the object.
+This command is not supported for the memory provider.
+For embedded providers like bolt and SQLite you should stop the running SFTPGo
+instance to avoid database corruption.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			if !filepath.IsAbs(restoreObjectInputFile) {
+				logger.ErrorToConsole("Invalid input file %q, it must be an absolute path", restoreObjectInputFile)
+				os.Exit(1)
+			}
+			if restoreObjectMode < 0 || restoreObjectMode > 1 {
+				logger.ErrorToConsole("Invalid mode %d", restoreObjectMode)
+				os.Exit(1)
+			}
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("memory provider is not supported")
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Initializing provider: %q config file: %q", providerConf.Driver, viper.ConfigFileUsed())
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+				os.Exit(1)
+			}
+			info, err := os.Stat(restoreObjectInputFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to stat file %q: %v", restoreObjectInputFile, err)
+				os.Exit(1)
+			}
+			if info.Size() > httpd.MaxRestoreSize {
+				logger.ErrorToConsole("Unable to restore input file %q size too big: %d/%d bytes",
+					restoreObjectInputFile, info.Size(), httpd.MaxRestoreSize)
+				os.Exit(1)
+			}
+			content, err := os.ReadFile(restoreObjectInputFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to read input file %q: %v", restoreObjectInputFile, err)
+				os.Exit(1)
+			}
+			dump, err := dataprovider.ParseDumpData(content)
+			if err != nil {
+				logger.ErrorToConsole("Unable to parse file to restore %q: %v", restoreObjectInputFile, err)
+				os.Exit(1)
+			}
+			var result httpd.RestoreObjectPreview
+			if restoreObjectPreview {
+				result, err = httpd.PreviewRestoreObject(&dump, restoreObjectType, restoreObjectName, restoreObjectMode)
+			} else {
+				result, err = httpd.RestoreObject(&dump, restoreObjectType, restoreObjectName, restoreObjectInputFile,
+					restoreObjectMode, 0, dataprovider.ActionExecutorSystem, "", "")
+			}
+			if err != nil {
+				logger.ErrorToConsole("Unable to restore %s %q: %v", restoreObjectType, restoreObjectName, err)
+				os.Exit(1)
+			}
+			output, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				logger.ErrorToConsole("Unable to marshal the changes as JSON: %v", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(output))
+			if !restoreObjectPreview {
+				logger.InfoToConsole("%s %q restored from file %q", restoreObjectType, restoreObjectName,
+					restoreObjectInputFile)
+			}
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(restoreObjectCmd)
+	restoreObjectCmd.Flags().StringVar(&restoreObjectInputFile, "input-file", "", `Absolute path to the dump file
+to restore from. Encrypted scheduled backups are
+decrypted using the configured backup passphrase`)
+	restoreObjectCmd.Flags().StringVar(&restoreObjectType, "object-type", "", `Type of the object to restore:
+"user", "group" or "folder"`)
+	restoreObjectCmd.Flags().StringVar(&restoreObjectName, "object-name", "", `Name of the object to restore`)
+	restoreObjectCmd.Flags().IntVar(&restoreObjectMode, "mode", 0, `Restore mode:
+0 - new objects are added, existing ones are
+    updated
+1 - new objects are added, existing ones are
+    not modified`)
+	restoreObjectCmd.Flags().BoolVar(&restoreObjectPreview, "preview", false, `Print the changes without restoring
+the object`)
+	restoreObjectCmd.MarkFlagRequired("input-file")  //nolint:errcheck
+	restoreObjectCmd.MarkFlagRequired("object-type") //nolint:errcheck
+	restoreObjectCmd.MarkFlagRequired("object-name") //nolint:errcheck
+
+	rootCmd.AddCommand(restoreObjectCmd)
+}
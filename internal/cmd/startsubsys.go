@@ -119,6 +119,11 @@ Command-line flags should be specified in the Subsystem declaration.
 				logger.Error(logSender, connectionID, "unable to initialize SMTP configuration: %v", err)
 				os.Exit(1)
 			}
+			geoIPConfig := config.GetGeoIPConfig()
+			if err := geoIPConfig.Initialize(configDir); err != nil {
+				logger.Error(logSender, connectionID, "unable to initialize GeoIP: %v", err)
+				os.Exit(1)
+			}
 			commonConfig := config.GetCommonConfig()
 			// idle connection are managed externally
 			commonConfig.IdleTimeout = 0
@@ -0,0 +1,89 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	wrapMasterKeyCmd = &cobra.Command{
+		Use:   "wrapmasterkey",
+		Short: "Wrap the KMS master key using the configured PKCS#11 token",
+		Long: `This command reads the PKCS#11 configuration from the KMS section of the
+specified configuration file, asks for the master key to protect and prints
+it wrapped using the configured token.
+The printed value can be used as master key, or stored inside the master key
+file, to protect the master key using the PKCS#11 token.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			if !kmsConfig.Secrets.PKCS11.IsEnabled() {
+				logger.ErrorToConsole("PKCS#11 is not configured")
+				os.Exit(1)
+			}
+			fmt.Printf("Enter Master Key: ")
+			masterKey, err := term.ReadPassword(int(os.Stdin.Fd()))
+			if err != nil {
+				logger.ErrorToConsole("Unable to read the master key: %v", err)
+				os.Exit(1)
+			}
+			fmt.Println("")
+			fmt.Printf("Confirm Master Key: ")
+			confirmKey, err := term.ReadPassword(int(os.Stdin.Fd()))
+			if err != nil {
+				logger.ErrorToConsole("Unable to read the master key: %v", err)
+				os.Exit(1)
+			}
+			fmt.Println("")
+			if !bytes.Equal(masterKey, confirmKey) {
+				logger.ErrorToConsole("Master keys do not match")
+				os.Exit(1)
+			}
+			wrappedKey, err := kms.WrapMasterKey(kmsConfig.Secrets.PKCS11, string(bytes.TrimSpace(masterKey)))
+			if err != nil {
+				logger.ErrorToConsole("Unable to wrap the master key: %v", err)
+				os.Exit(1)
+			}
+			fmt.Println(wrappedKey)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(wrapMasterKeyCmd)
+
+	rootCmd.AddCommand(wrapMasterKeyCmd)
+}
@@ -42,12 +42,14 @@ const (
 	HookPostLogin           = "post_login"
 	HookExternalAuth        = "external_auth"
 	HookKeyboardInteractive = "keyboard_interactive"
+	HookFTPPassiveIP        = "ftp_passive_ip"
 )
 
 var (
 	config         Config
 	supportedHooks = []string{HookFsActions, HookProviderActions, HookStartup, HookPostConnect, HookPostDisconnect,
-		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive}
+		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive,
+		HookFTPPassiveIP}
 )
 
 // Command define the configuration for a specific commands
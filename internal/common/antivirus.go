@@ -0,0 +1,735 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported antivirus engines
+const (
+	AntivirusEngineClamd = "clamd"
+	AntivirusEngineICAP  = "icap"
+)
+
+// Supported antivirus modes
+const (
+	// uploads are scanned before completing them, infected files are rejected
+	AntivirusModeInline = iota
+	// uploads are scanned in background after completing them
+	AntivirusModePostUpload
+)
+
+// Antivirus scan statuses stored as file metadata
+const (
+	antivirusStatusClean    = "clean"
+	antivirusStatusInfected = "infected"
+	antivirusStatusError    = "error"
+)
+
+const (
+	defaultAntivirusTimeout = 60 * time.Second
+	defaultICAPPort         = "1344"
+	// size of the data chunks sent to the scan engine
+	antivirusChunkSize = 32768
+	// maximum size for the out of order writes buffered while streaming an upload
+	maxAntivirusPendingSize = 16 * 1024 * 1024
+)
+
+var (
+	supportedAntivirusEngines = []string{AntivirusEngineClamd, AntivirusEngineICAP}
+	errAntivirusStreamAborted = errors.New("antivirus stream aborted")
+)
+
+// AntivirusConfig defines the configuration to scan the uploaded files
+// using clamd or an ICAP server
+type AntivirusConfig struct {
+	// Scan engine, supported values: "clamd", "icap". Empty means disabled
+	Engine string `json:"engine" mapstructure:"engine"`
+	// For clamd the absolute path to the UNIX socket or host:port for a TCP
+	// connection. For ICAP the service URL, for example icap://127.0.0.1:1344/avscan
+	Address string `json:"address" mapstructure:"address"`
+	// 0 means inline: the uploads are streamed to the scan engine while they
+	// are received and infected files are rejected.
+	// 1 means post-upload: the uploads are scanned in background after
+	// completing them and infected files are moved to the quarantine path
+	Mode int `json:"mode" mapstructure:"mode"`
+	// Absolute path to the local directory for the infected files. Empty means
+	// that the infected files are deleted
+	QuarantinePath string `json:"quarantine_path" mapstructure:"quarantine_path"`
+	// Maximum size, in MB, for the files to scan. Larger files are not scanned.
+	// 0 means no limit
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Timeout, in seconds, for each network operation with the scan engine.
+	// 0 means the default (60)
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// If enabled, uploads that cannot be scanned are rejected. Inline mode only
+	RejectOnError bool `json:"reject_on_error" mapstructure:"reject_on_error"`
+}
+
+func (c *AntivirusConfig) isEnabled() bool {
+	return c.Engine != ""
+}
+
+func (c *AntivirusConfig) getTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultAntivirusTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *AntivirusConfig) getMaxSize() int64 {
+	return c.MaxSize * 1048576
+}
+
+func (c *AntivirusConfig) isSizeAllowed(size int64) bool {
+	return c.MaxSize <= 0 || size <= c.getMaxSize()
+}
+
+func (c *AntivirusConfig) validate() error {
+	if !util.Contains(supportedAntivirusEngines, c.Engine) {
+		return fmt.Errorf("unsupported antivirus engine %q", c.Engine)
+	}
+	if c.Address == "" {
+		return errors.New("the antivirus address is required")
+	}
+	if c.Mode != AntivirusModeInline && c.Mode != AntivirusModePostUpload {
+		return fmt.Errorf("invalid antivirus mode %d", c.Mode)
+	}
+	if c.QuarantinePath != "" && !filepath.IsAbs(c.QuarantinePath) {
+		return fmt.Errorf("invalid antivirus quarantine path %q, it must be an absolute path", c.QuarantinePath)
+	}
+	if c.MaxSize < 0 {
+		return errors.New("invalid antivirus max size")
+	}
+	return nil
+}
+
+func (c *AntivirusConfig) getScanner() (avScanner, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.QuarantinePath != "" {
+		if err := os.MkdirAll(c.QuarantinePath, 0700); err != nil {
+			return nil, fmt.Errorf("unable to create the antivirus quarantine path: %w", err)
+		}
+	}
+	if c.Engine == AntivirusEngineClamd {
+		network := "tcp"
+		if filepath.IsAbs(c.Address) {
+			network = "unix"
+		}
+		return &clamdScanner{
+			network: network,
+			address: c.Address,
+			timeout: c.getTimeout(),
+		}, nil
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICAP address %q: %w", c.Address, err)
+	}
+	if u.Scheme != "icap" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ICAP address %q", c.Address)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), defaultICAPPort)
+	}
+	return &icapScanner{
+		address:    address,
+		serviceURL: u.String(),
+		host:       u.Hostname(),
+		timeout:    c.getTimeout(),
+	}, nil
+}
+
+// avScanner scans the data read from r and returns the detected threat, if any
+type avScanner interface {
+	scan(r io.Reader) (string, error)
+}
+
+// clamdScanner scans the data using the clamd INSTREAM command
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func (s *clamdScanner) scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+antivirusChunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+			if _, errWrite := conn.Write(buf[:4+n]); errWrite != nil {
+				return "", errWrite
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	resp, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && resp == "" {
+		return "", fmt.Errorf("unable to read the clamd response: %w", err)
+	}
+	return parseClamdResponse(resp)
+}
+
+// parseClamdResponse parses responses such as "stream: OK" and
+// "stream: Eicar-Signature FOUND"
+func parseClamdResponse(resp string) (string, error) {
+	resp = strings.TrimSpace(strings.TrimRight(resp, "\x00"))
+	if strings.HasSuffix(resp, " OK") {
+		return "", nil
+	}
+	if threat, ok := strings.CutSuffix(resp, " FOUND"); ok {
+		if _, after, found := strings.Cut(threat, ": "); found {
+			threat = after
+		}
+		return strings.TrimSpace(threat), nil
+	}
+	return "", fmt.Errorf("unexpected clamd response: %q", resp)
+}
+
+// icapScanner scans the data using an ICAP RESPMOD request
+type icapScanner struct {
+	address    string
+	serviceURL string
+	host       string
+	timeout    time.Duration
+}
+
+func (s *icapScanner) scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to the ICAP server: %w", err)
+	}
+	defer conn.Close()
+
+	reqHdr := "GET /upload HTTP/1.1\r\nHost: sftpgo\r\n\r\n"
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("RESPMOD %s ICAP/1.0\r\n", s.serviceURL))
+	sb.WriteString(fmt.Sprintf("Host: %s\r\n", s.host))
+	sb.WriteString("User-Agent: SFTPGo\r\n")
+	sb.WriteString("Allow: 204\r\n")
+	sb.WriteString(fmt.Sprintf("Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr),
+		len(reqHdr)+len(resHdr)))
+	sb.WriteString(reqHdr)
+	sb.WriteString(resHdr)
+
+	conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+	if _, err := conn.Write([]byte(sb.String())); err != nil {
+		return "", err
+	}
+	buf := make([]byte, antivirusChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, 0, n+16)
+			chunk = append(chunk, strconv.FormatInt(int64(n), 16)...)
+			chunk = append(chunk, "\r\n"...)
+			chunk = append(chunk, buf[:n]...)
+			chunk = append(chunk, "\r\n"...)
+			conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+			if _, errWrite := conn.Write(chunk); errWrite != nil {
+				return "", errWrite
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+	if _, err := conn.Write([]byte("0\r\n\r\n")); err != nil {
+		return "", err
+	}
+	return parseICAPResponse(textproto.NewReader(bufio.NewReader(conn)))
+}
+
+// parseICAPResponse parses the ICAP response status and headers. 204 means
+// that the content is clean, 200 means that the content was modified by the
+// server and so a threat was detected
+func parseICAPResponse(tp *textproto.Reader) (string, error) {
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("unable to read the ICAP response: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("invalid ICAP response: %q", line)
+	}
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil && len(headers) == 0 {
+		return "", fmt.Errorf("unable to read the ICAP response headers: %w", err)
+	}
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+		return getICAPThreat(headers), nil
+	default:
+		return "", fmt.Errorf("unexpected ICAP response: %q", line)
+	}
+}
+
+func getICAPThreat(headers textproto.MIMEHeader) string {
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Signature;
+	if value := headers.Get("X-Infection-Found"); value != "" {
+		for _, param := range strings.Split(value, ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(param), "Threat="); ok && threat != "" {
+				return threat
+			}
+		}
+	}
+	if value := strings.TrimSpace(headers.Get("X-Virus-ID")); value != "" {
+		return value
+	}
+	// X-Violations-Found: count, filename, threat description, problem ID, resolution
+	if fields := strings.Fields(headers.Get("X-Violations-Found")); len(fields) >= 3 {
+		return fields[2]
+	}
+	return "unknown"
+}
+
+// antivirusResult is the result of a scan
+type antivirusResult struct {
+	threat   string
+	err      error
+	scanTime time.Time
+}
+
+func (r *antivirusResult) getStatus() string {
+	if r.threat != "" {
+		return antivirusStatusInfected
+	}
+	if r.err != nil {
+		return antivirusStatusError
+	}
+	return antivirusStatusClean
+}
+
+// isRejected returns true if the scanned upload must be rejected
+func (r *antivirusResult) isRejected() bool {
+	return r.threat != "" || (r.err != nil && Config.Antivirus.RejectOnError)
+}
+
+func (r *antivirusResult) getMetadata() map[string]string {
+	metadata := map[string]string{
+		"av-status":    r.getStatus(),
+		"av-engine":    Config.Antivirus.Engine,
+		"av-scan-time": r.scanTime.UTC().Format(time.RFC3339),
+	}
+	if r.threat != "" {
+		metadata["av-threat"] = r.threat
+	}
+	return metadata
+}
+
+// antivirusStream streams the data received for an upload to the scan engine
+// while the upload is in progress. Writes must be sequential, a limited amount
+// of out of order writes is buffered as for the upload checksums. The stream
+// is aborted, and the file is read back from the storage backend to scan it,
+// for overlapping writes, if the buffered data exceed the limit or if the
+// scan engine returns an error
+type antivirusStream struct {
+	mu          sync.Mutex
+	pw          *io.PipeWriter
+	offset      int64
+	pending     map[int64][]byte
+	pendingSize int
+	aborted     bool
+	done        chan struct{}
+	threat      string
+	err         error
+}
+
+func newAntivirusStream() *antivirusStream {
+	if Config.avScanner == nil || Config.Antivirus.Mode != AntivirusModeInline {
+		return nil
+	}
+	pr, pw := io.Pipe()
+	s := &antivirusStream{
+		pw:      pw,
+		pending: make(map[int64][]byte),
+		done:    make(chan struct{}),
+	}
+	go func(scanner avScanner) {
+		threat, err := scanner.scan(pr)
+		// unblock the pending writes if the scan ends before the stream
+		pr.CloseWithError(errAntivirusStreamAborted)
+		s.threat = threat
+		s.err = err
+		close(s.done)
+	}(Config.avScanner)
+	return s
+}
+
+func (s *antivirusStream) write(p []byte) {
+	if !Config.Antivirus.isSizeAllowed(s.offset + int64(len(p))) {
+		s.abortNoLock()
+		return
+	}
+	if _, err := s.pw.Write(p); err != nil {
+		s.abortNoLock()
+		return
+	}
+	s.offset += int64(len(p))
+}
+
+// update streams the data written at the specified offset
+func (s *antivirusStream) update(p []byte, off int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aborted || len(p) == 0 {
+		return
+	}
+	if off != s.offset {
+		if off < s.offset || s.pendingSize+len(p) > maxAntivirusPendingSize {
+			s.abortNoLock()
+			return
+		}
+		if _, ok := s.pending[off]; ok {
+			s.abortNoLock()
+			return
+		}
+		buf := make([]byte, len(p))
+		copy(buf, p)
+		s.pending[off] = buf
+		s.pendingSize += len(buf)
+		return
+	}
+	s.write(p)
+	for !s.aborted {
+		buf, ok := s.pending[s.offset]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.offset)
+		s.pendingSize -= len(buf)
+		s.write(buf)
+	}
+}
+
+func (s *antivirusStream) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.abortNoLock()
+}
+
+func (s *antivirusStream) abortNoLock() {
+	if !s.aborted {
+		s.pw.CloseWithError(errAntivirusStreamAborted)
+	}
+	s.aborted = true
+	s.pending = nil
+	s.pendingSize = 0
+}
+
+// finish ends the stream and waits for the scan result. It returns nil if
+// the streamed data do not match an upload of the specified size
+func (s *antivirusStream) finish(size int64) *antivirusResult {
+	s.mu.Lock()
+	if s.aborted || len(s.pending) > 0 || s.offset != size {
+		s.abortNoLock()
+		s.mu.Unlock()
+		return nil
+	}
+	s.pw.Close()
+	s.mu.Unlock()
+
+	<-s.done
+	if s.err != nil {
+		return nil
+	}
+	return &antivirusResult{
+		threat:   s.threat,
+		scanTime: time.Now(),
+	}
+}
+
+// scanFile reads the specified file from the storage backend and scans it
+func scanFile(fs vfs.Fs, fsPath string) *antivirusResult {
+	result := &antivirusResult{}
+	reader, cancelFn, err := openFileForScan(fs, fsPath)
+	if err != nil {
+		result.err = err
+	} else {
+		result.threat, result.err = Config.avScanner.scan(reader)
+		reader.Close()
+		if cancelFn != nil {
+			cancelFn()
+		}
+	}
+	result.scanTime = time.Now()
+	return result
+}
+
+func openFileForScan(fs vfs.Fs, fsPath string) (io.ReadCloser, func(), error) {
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f != nil {
+		return f, cancelFn, nil
+	}
+	return r, cancelFn, nil
+}
+
+// setAntivirusMetadata stores the scan result as metadata for the specified
+// file, if supported by the storage backend
+func setAntivirusMetadata(fs vfs.Fs, fsPath string, result *antivirusResult) {
+	setter, ok := fs.(vfs.FsMetadataSetter)
+	if !ok {
+		return
+	}
+	if err := setter.SetMetadata(fsPath, result.getMetadata()); err != nil {
+		logger.Debug(logSender, "", "unable to store the antivirus metadata for %q: %v", fsPath, err)
+	}
+}
+
+// quarantineFile copies the specified file to the quarantine path and stores
+// the scan result as metadata for the copy, if supported by the local
+// filesystem. It returns the path of the quarantined file
+func quarantineFile(fs vfs.Fs, fsPath, username string, result *antivirusResult) (string, error) {
+	dir := filepath.Join(Config.Antivirus.QuarantinePath, username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(fsPath)))
+	reader, cancelFn, err := openFileForScan(fs, fsPath)
+	if err != nil {
+		return "", err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	defer reader.Close()
+
+	dst, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, reader)
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	setAntivirusMetadata(vfs.NewOsFs("", Config.Antivirus.QuarantinePath, ""), name, result)
+	return name, nil
+}
+
+// notifyVirusDetected executes the actions defined for the "virus-detected" event.
+// The target path is the quarantined file, if any
+func notifyVirusDetected(conn *BaseConnection, fsPath, virtualPath, quarantinePath string, fileSize int64,
+	threat string, elapsed int64,
+) {
+	ExecuteActionNotification(conn, operationVirusDetected, fsPath, virtualPath, quarantinePath, "", "", //nolint:errcheck
+		fileSize, fmt.Errorf("%w: %s", ErrVirusDetected, threat), elapsed)
+}
+
+// scanUploadOnClose scans the upload, in inline mode, before completing it.
+// The data streamed while receiving the upload are used if possible,
+// otherwise the file is read back from the storage backend
+func (t *BaseTransfer) scanUploadOnClose() {
+	if Config.avScanner == nil || Config.Antivirus.Mode != AntivirusModeInline {
+		return
+	}
+	if t.ErrTransfer != nil || t.isUploadContentDenied() {
+		if t.avStream != nil {
+			t.avStream.abort()
+		}
+		return
+	}
+	info, err := t.Fs.Stat(t.effectiveFsPath)
+	if err != nil || !Config.Antivirus.isSizeAllowed(info.Size()) {
+		if t.avStream != nil {
+			t.avStream.abort()
+		}
+		if err == nil {
+			t.Connection.Log(logger.LevelDebug, "upload %q not scanned, size %d exceeds the antivirus limit",
+				t.requestPath, info.Size())
+			return
+		}
+		t.avResult = &antivirusResult{err: err, scanTime: time.Now()}
+	} else {
+		if t.avStream != nil {
+			t.avResult = t.avStream.finish(info.Size())
+		}
+		if t.avResult == nil {
+			t.avResult = scanFile(t.Fs, t.effectiveFsPath)
+		}
+	}
+	if t.avResult.threat != "" {
+		t.Connection.Log(logger.LevelWarn, "upload %q rejected, threat detected: %q", t.requestPath, t.avResult.threat)
+		t.TransferError(ErrVirusDetected)
+		return
+	}
+	if t.avResult.err != nil {
+		t.Connection.Log(logger.LevelWarn, "unable to scan upload %q: %v", t.requestPath, t.avResult.err)
+		if Config.Antivirus.RejectOnError {
+			t.TransferError(t.Connection.GetGenericError(fmt.Errorf("unable to scan the uploaded file: %w",
+				t.avResult.err)))
+		}
+	}
+}
+
+func (t *BaseTransfer) isUploadRejectedByAntivirus() bool {
+	return t.avResult != nil && t.avResult.isRejected()
+}
+
+// removeRejectedUpload removes the upload rejected by the antivirus.
+// Infected files are moved to the quarantine path, if configured
+func (t *BaseTransfer) removeRejectedUpload() error {
+	var quarantinePath string
+	var size int64
+	if info, err := t.Fs.Stat(t.effectiveFsPath); err == nil {
+		size = info.Size()
+	}
+	if t.avResult.threat != "" && Config.Antivirus.QuarantinePath != "" {
+		var err error
+		quarantinePath, err = quarantineFile(t.Fs, t.effectiveFsPath, t.Connection.User.Username, t.avResult)
+		if err != nil {
+			t.Connection.Log(logger.LevelError, "unable to quarantine the infected file %q: %v", t.effectiveFsPath, err)
+		}
+	}
+	err := t.Fs.Remove(t.effectiveFsPath, false)
+	if err == nil {
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	}
+	t.Connection.Log(logger.LevelWarn, "upload rejected by the antivirus, delete file: %q, quarantined file: %q, "+
+		"deletion error: %v", t.effectiveFsPath, quarantinePath, err)
+	if t.avResult.threat != "" {
+		notifyVirusDetected(t.Connection, t.fsPath, t.requestPath, quarantinePath, size, t.avResult.threat,
+			time.Since(t.start).Milliseconds())
+	}
+	return err
+}
+
+// handleAntivirusOnUploadDone stores the inline scan result as metadata for
+// the completed upload or schedules the background scan in post-upload mode
+func (t *BaseTransfer) handleAntivirusOnUploadDone(fileSize int64) {
+	if Config.avScanner == nil || t.ErrTransfer != nil {
+		return
+	}
+	if t.avResult != nil {
+		setAntivirusMetadata(t.Fs, t.fsPath, t.avResult)
+		return
+	}
+	if Config.Antivirus.Mode != AntivirusModePostUpload {
+		return
+	}
+	if !Config.Antivirus.isSizeAllowed(fileSize) {
+		t.Connection.Log(logger.LevelDebug, "upload %q not scanned, size %d exceeds the antivirus limit",
+			t.requestPath, fileSize)
+		return
+	}
+	go scanUploadedFile(t.Connection, t.requestPath, t.start)
+}
+
+// scanUploadedFile scans a completed upload in background. Infected files are
+// moved to the quarantine path, if configured, or deleted
+func scanUploadedFile(conn *BaseConnection, virtualPath string, start time.Time) {
+	user, err := dataprovider.GetUserWithGroupSettings(conn.User.Username, "")
+	if err != nil {
+		conn.Log(logger.LevelError, "unable to get user %q to scan upload %q: %v", conn.User.Username, virtualPath, err)
+		return
+	}
+	scanConn := NewBaseConnection("", "", conn.localAddr, conn.remoteAddr, user)
+	scanConn.SetProtocol(conn.protocol)
+	scanConn.ID = conn.ID
+	defer scanConn.CloseFS() //nolint:errcheck
+
+	fs, fsPath, err := scanConn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		scanConn.Log(logger.LevelError, "unable to scan upload %q: %v", virtualPath, err)
+		return
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		scanConn.Log(logger.LevelWarn, "unable to scan upload %q: %v", virtualPath, err)
+		return
+	}
+	result := scanFile(fs, fsPath)
+	if result.threat == "" {
+		if result.err != nil {
+			scanConn.Log(logger.LevelWarn, "unable to scan upload %q: %v", virtualPath, result.err)
+		}
+		setAntivirusMetadata(fs, fsPath, result)
+		return
+	}
+	var quarantinePath string
+	if Config.Antivirus.QuarantinePath != "" {
+		quarantinePath, err = quarantineFile(fs, fsPath, user.Username, result)
+		if err != nil {
+			scanConn.Log(logger.LevelError, "unable to quarantine the infected file %q: %v", fsPath, err)
+		}
+	}
+	err = fs.Remove(fsPath, false)
+	scanConn.Log(logger.LevelWarn, "threat %q detected for upload %q, delete file: %q, quarantined file: %q, "+
+		"deletion error: %v", result.threat, virtualPath, fsPath, quarantinePath, err)
+	if err == nil {
+		scanConn.updateQuotaAfterRemove(virtualPath, info.Size())
+	}
+	notifyVirusDetected(scanConn, fsPath, virtualPath, quarantinePath, info.Size(), result.threat,
+		time.Since(start).Milliseconds())
+}
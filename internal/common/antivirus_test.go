@@ -0,0 +1,445 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	testThreatName = "Eicar-Test-Signature"
+)
+
+var testInfectedContent = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// startFakeAntivirus starts a fake scan engine, handleFn reads the request and
+// writes the response for the received data
+func startFakeAntivirus(t *testing.T, handleFn func(*bufio.Reader, net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				handleFn(bufio.NewReader(conn), conn)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func handleFakeClamd(r *bufio.Reader, w net.Conn) {
+	cmd, err := r.ReadString(0)
+	if err != nil || cmd != "zINSTREAM\x00" {
+		w.Write([]byte("UNKNOWN COMMAND\x00")) //nolint:errcheck
+		return
+	}
+	var data []byte
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return
+		}
+		data = append(data, chunk...)
+	}
+	if bytes.Contains(data, testInfectedContent) {
+		w.Write([]byte(fmt.Sprintf("stream: %s FOUND\x00", testThreatName))) //nolint:errcheck
+		return
+	}
+	w.Write([]byte("stream: OK\x00")) //nolint:errcheck
+}
+
+func handleFakeICAP(r *bufio.Reader, w net.Conn) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil || !strings.HasPrefix(line, "RESPMOD icap://") {
+		w.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n")) //nolint:errcheck
+		return
+	}
+	// ICAP headers, encapsulated request and response headers
+	for i := 0; i < 3; i++ {
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			if line == "" {
+				break
+			}
+		}
+	}
+	var data []byte
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		n, err := strconv.ParseInt(line, 16, 64)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			tp.ReadLine() //nolint:errcheck
+			break
+		}
+		chunk := make([]byte, n+2)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return
+		}
+		data = append(data, chunk[:n]...)
+	}
+	if bytes.Contains(data, testInfectedContent) {
+		w.Write([]byte(fmt.Sprintf("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=%s;\r\n"+ //nolint:errcheck
+			"Encapsulated: null-body=0\r\n\r\n", testThreatName)))
+		return
+	}
+	w.Write([]byte("ICAP/1.0 204 No Content\r\n\r\n")) //nolint:errcheck
+}
+
+func TestAntivirusConfig(t *testing.T) {
+	c := AntivirusConfig{}
+	assert.False(t, c.isEnabled())
+	assert.Equal(t, defaultAntivirusTimeout, c.getTimeout())
+	assert.True(t, c.isSizeAllowed(1<<40))
+	c.Engine = "unknown"
+	assert.True(t, c.isEnabled())
+	_, err := c.getScanner()
+	assert.ErrorContains(t, err, "unsupported antivirus engine")
+	c.Engine = AntivirusEngineClamd
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "address is required")
+	c.Address = "127.0.0.1:3310"
+	c.Mode = 2
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "invalid antivirus mode")
+	c.Mode = AntivirusModePostUpload
+	c.QuarantinePath = "relative"
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "must be an absolute path")
+	c.QuarantinePath = ""
+	c.MaxSize = -1
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "invalid antivirus max size")
+	c.MaxSize = 1
+	c.Timeout = 10
+	assert.False(t, c.isSizeAllowed(1048577))
+	assert.True(t, c.isSizeAllowed(1048576))
+	scanner, err := c.getScanner()
+	require.NoError(t, err)
+	clamd, ok := scanner.(*clamdScanner)
+	require.True(t, ok)
+	assert.Equal(t, "tcp", clamd.network)
+	assert.Equal(t, 10*time.Second, clamd.timeout)
+	c.Address = "/run/clamav/clamd.ctl"
+	scanner, err = c.getScanner()
+	require.NoError(t, err)
+	assert.Equal(t, "unix", scanner.(*clamdScanner).network)
+
+	c.Engine = AntivirusEngineICAP
+	c.Address = "http://127.0.0.1/avscan"
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "invalid ICAP address")
+	c.Address = "icap://%gh"
+	_, err = c.getScanner()
+	assert.ErrorContains(t, err, "invalid ICAP address")
+	c.Address = "icap://127.0.0.1/avscan"
+	scanner, err = c.getScanner()
+	require.NoError(t, err)
+	icap, ok := scanner.(*icapScanner)
+	require.True(t, ok)
+	assert.Equal(t, "127.0.0.1:1344", icap.address)
+	assert.Equal(t, "icap://127.0.0.1/avscan", icap.serviceURL)
+	c.Address = "icap://localhost:11344/avscan"
+	scanner, err = c.getScanner()
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:11344", scanner.(*icapScanner).address)
+
+	c.QuarantinePath = filepath.Join(os.TempDir(), "av_quarantine")
+	_, err = c.getScanner()
+	assert.NoError(t, err)
+	assert.DirExists(t, c.QuarantinePath)
+	err = os.RemoveAll(c.QuarantinePath)
+	assert.NoError(t, err)
+}
+
+func TestAntivirusResponses(t *testing.T) {
+	threat, err := parseClamdResponse("stream: OK\x00")
+	assert.NoError(t, err)
+	assert.Empty(t, threat)
+	threat, err = parseClamdResponse("stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+	assert.NoError(t, err)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", threat)
+	_, err = parseClamdResponse("INSTREAM size limit exceeded. ERROR\x00")
+	assert.ErrorContains(t, err, "unexpected clamd response")
+
+	parseICAP := func(resp string) (string, error) {
+		return parseICAPResponse(textproto.NewReader(bufio.NewReader(strings.NewReader(resp))))
+	}
+	threat, err = parseICAP("ICAP/1.0 204 No Content\r\n\r\n")
+	assert.NoError(t, err)
+	assert.Empty(t, threat)
+	threat, err = parseICAP("ICAP/1.0 200 OK\r\nX-Virus-ID: EICAR\r\n\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "EICAR", threat)
+	threat, err = parseICAP("ICAP/1.0 200 OK\r\nX-Violations-Found: 1\r\n\tfile\r\n\tEICAR\r\n\t0\r\n\t2\r\n\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "EICAR", threat)
+	threat, err = parseICAP("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2;\r\n\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown", threat)
+	_, err = parseICAP("ICAP/1.0 500 Server Error\r\n\r\n")
+	assert.ErrorContains(t, err, "unexpected ICAP response")
+	_, err = parseICAP("HTTP/1.1 200 OK\r\n\r\n")
+	assert.ErrorContains(t, err, "invalid ICAP response")
+	_, err = parseICAP("")
+	assert.Error(t, err)
+}
+
+func TestAntivirusScanners(t *testing.T) {
+	clamd := &clamdScanner{
+		network: "tcp",
+		address: startFakeAntivirus(t, handleFakeClamd),
+		timeout: 5 * time.Second,
+	}
+	icapAddress := startFakeAntivirus(t, handleFakeICAP)
+	icap := &icapScanner{
+		address:    icapAddress,
+		serviceURL: "icap://" + icapAddress + "/avscan",
+		host:       "127.0.0.1",
+		timeout:    5 * time.Second,
+	}
+	clean := bytes.Repeat([]byte("clean data "), 10000)
+	infected := append(bytes.Repeat([]byte("a"), 40000), testInfectedContent...)
+	for _, scanner := range []avScanner{clamd, icap} {
+		threat, err := scanner.scan(bytes.NewReader(clean))
+		assert.NoError(t, err)
+		assert.Empty(t, threat)
+		threat, err = scanner.scan(bytes.NewReader(infected))
+		assert.NoError(t, err)
+		assert.Equal(t, testThreatName, threat)
+		threat, err = scanner.scan(bytes.NewReader(nil))
+		assert.NoError(t, err)
+		assert.Empty(t, threat)
+		_, err = scanner.scan(iotestErrReader{})
+		assert.Error(t, err)
+	}
+	clamd.address = "127.0.0.1:1"
+	_, err := clamd.scan(bytes.NewReader(clean))
+	assert.ErrorContains(t, err, "unable to connect to clamd")
+	icap.address = "127.0.0.1:1"
+	_, err = icap.scan(bytes.NewReader(clean))
+	assert.ErrorContains(t, err, "unable to connect to the ICAP server")
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read(_ []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestAntivirusInlineUploads(t *testing.T) {
+	oldConfig := Config
+	defer func() {
+		Config = oldConfig
+	}()
+
+	quarantinePath := filepath.Join(os.TempDir(), "av_quarantine")
+	Config.Antivirus = AntivirusConfig{
+		Engine:         AntivirusEngineClamd,
+		Address:        startFakeAntivirus(t, handleFakeClamd),
+		Mode:           AntivirusModeInline,
+		QuarantinePath: quarantinePath,
+		Timeout:        5,
+	}
+	scanner, err := Config.Antivirus.getScanner()
+	require.NoError(t, err)
+	Config.avScanner = scanner
+
+	testFile := filepath.Join(os.TempDir(), "av_inline_file")
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "av_inline_user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	u.Permissions = make(map[string][]string)
+	u.Permissions["/"] = []string{dataprovider.PermAny}
+	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, "", "", u)
+
+	upload := func(data []byte, chunkSize int, reverse bool) error {
+		file, err := os.Create(testFile)
+		require.NoError(t, err)
+		transfer := NewBaseTransfer(file, conn, nil, testFile, testFile, "/av_inline_file", TransferUpload, 0, 0, 0, 0,
+			true, fs, dataprovider.TransferQuota{})
+		require.NotNil(t, transfer.avStream)
+		var offsets []int
+		for off := 0; off < len(data); off += chunkSize {
+			offsets = append(offsets, off)
+		}
+		for idx := range offsets {
+			off := offsets[idx]
+			if reverse {
+				off = offsets[len(offsets)-1-idx]
+			}
+			end := off + chunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err = file.WriteAt(data[off:end], int64(off))
+			require.NoError(t, err)
+			transfer.UpdateChecksums(data[off:end], int64(off))
+			transfer.BytesReceived.Add(int64(end - off))
+		}
+		err = file.Close()
+		require.NoError(t, err)
+		return transfer.Close()
+	}
+
+	clean := bytes.Repeat([]byte("clean data "), 10000)
+	infected := append(bytes.Repeat([]byte("a"), 40000), testInfectedContent...)
+	// sequential writes
+	err = upload(clean, 32768, false)
+	assert.NoError(t, err)
+	assert.FileExists(t, testFile)
+	err = upload(infected, 32768, false)
+	assert.ErrorIs(t, err, ErrVirusDetected)
+	assert.NoFileExists(t, testFile)
+	// out of order writes are buffered
+	err = upload(infected, 1024, true)
+	assert.ErrorIs(t, err, ErrVirusDetected)
+	assert.NoFileExists(t, testFile)
+	entries, err := os.ReadDir(filepath.Join(quarantinePath, u.Username))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.True(t, strings.HasSuffix(entry.Name(), "_av_inline_file"))
+		data, err := os.ReadFile(filepath.Join(quarantinePath, u.Username, entry.Name()))
+		assert.NoError(t, err)
+		assert.Equal(t, infected, data)
+	}
+	// overlapping writes abort the stream, the file is scanned after the upload
+	file, err := os.Create(testFile)
+	require.NoError(t, err)
+	transfer := NewBaseTransfer(file, conn, nil, testFile, testFile, "/av_inline_file", TransferUpload, 0, 0, 0, 0,
+		true, fs, dataprovider.TransferQuota{})
+	_, err = file.Write(infected)
+	require.NoError(t, err)
+	transfer.UpdateChecksums(infected, 0)
+	transfer.UpdateChecksums(infected[:10], 0)
+	transfer.BytesReceived.Add(int64(len(infected)))
+	assert.True(t, transfer.avStream.aborted)
+	err = file.Close()
+	require.NoError(t, err)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, ErrVirusDetected)
+	assert.NoFileExists(t, testFile)
+	// resumed uploads are scanned after the upload
+	err = os.WriteFile(testFile, infected, 0666)
+	require.NoError(t, err)
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, testFile, "/av_inline_file", TransferUpload, 10, 10, 0, 0,
+		false, fs, dataprovider.TransferQuota{})
+	assert.Nil(t, transfer.avStream)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, ErrVirusDetected)
+	assert.NoFileExists(t, testFile)
+	// files larger than the max size are not scanned
+	Config.Antivirus.MaxSize = 1
+	large := append(bytes.Repeat([]byte("a"), 1048576), testInfectedContent...)
+	err = upload(large, 65536, false)
+	assert.NoError(t, err)
+	assert.FileExists(t, testFile)
+	Config.Antivirus.MaxSize = 0
+	// scan errors
+	Config.Antivirus.QuarantinePath = ""
+	Config.avScanner = &clamdScanner{
+		network: "tcp",
+		address: "127.0.0.1:1",
+		timeout: time.Second,
+	}
+	err = upload(clean, 32768, false)
+	assert.NoError(t, err)
+	assert.FileExists(t, testFile)
+	Config.Antivirus.RejectOnError = true
+	err = upload(clean, 32768, false)
+	assert.Error(t, err)
+	assert.NoFileExists(t, testFile)
+
+	err = os.RemoveAll(quarantinePath)
+	assert.NoError(t, err)
+}
+
+func TestAntivirusResult(t *testing.T) {
+	oldConfig := Config
+	defer func() {
+		Config = oldConfig
+	}()
+
+	Config.Antivirus.Engine = AntivirusEngineICAP
+	scanTime := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	result := antivirusResult{scanTime: scanTime}
+	assert.Equal(t, antivirusStatusClean, result.getStatus())
+	assert.False(t, result.isRejected())
+	assert.Equal(t, map[string]string{
+		"av-status":    antivirusStatusClean,
+		"av-engine":    AntivirusEngineICAP,
+		"av-scan-time": "2023-03-01T10:00:00Z",
+	}, result.getMetadata())
+	result.err = io.ErrUnexpectedEOF
+	assert.Equal(t, antivirusStatusError, result.getStatus())
+	assert.False(t, result.isRejected())
+	Config.Antivirus.RejectOnError = true
+	assert.True(t, result.isRejected())
+	result.err = nil
+	result.threat = testThreatName
+	assert.Equal(t, antivirusStatusInfected, result.getStatus())
+	assert.True(t, result.isRejected())
+	assert.Equal(t, testThreatName, result.getMetadata()["av-threat"])
+}
@@ -0,0 +1,369 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported formats for directory downloads as archive
+const (
+	ArchiveFormatZip = "zip"
+	ArchiveFormatTar = "tar"
+)
+
+// ErrArchiveOffset is returned if a client tries to download an archive starting from
+// a non zero offset, archives are generated on the fly and cannot be resumed
+var ErrArchiveOffset = errors.New("resuming archive downloads is not supported")
+
+// ArchiveDownloadsConfig defines the configuration to download directories as
+// archives streamed on the fly. A client can download a directory, for example
+// "/folder", as archive by requesting a non existent file with the directory name
+// and the configured suffix, for example "/folder.zip"
+type ArchiveDownloadsConfig struct {
+	// Suffix for ZIP archives, for example ".zip". Leave empty to disable ZIP downloads
+	ZipSuffix string `json:"zip_suffix" mapstructure:"zip_suffix"`
+	// Suffix for TAR archives, for example ".tar". Leave empty to disable TAR downloads
+	TarSuffix string `json:"tar_suffix" mapstructure:"tar_suffix"`
+}
+
+// IsEnabled returns true if at least an archive format is enabled
+func (c *ArchiveDownloadsConfig) IsEnabled() bool {
+	return c.ZipSuffix != "" || c.TarSuffix != ""
+}
+
+func (c *ArchiveDownloadsConfig) validate() error {
+	for _, suffix := range []string{c.ZipSuffix, c.TarSuffix} {
+		if suffix == "" {
+			continue
+		}
+		if !strings.HasPrefix(suffix, ".") || len(suffix) < 2 || strings.Contains(suffix, "/") {
+			return fmt.Errorf("invalid archive downloads suffix %q", suffix)
+		}
+	}
+	if c.ZipSuffix != "" && c.ZipSuffix == c.TarSuffix {
+		return fmt.Errorf("the same suffix %q is configured for ZIP and TAR archive downloads", c.ZipSuffix)
+	}
+	return nil
+}
+
+// getDirAndFormat returns the directory and the archive format for the specified
+// virtual path. The returned format is empty if the path has no configured suffix
+func (c *ArchiveDownloadsConfig) getDirAndFormat(virtualPath string) (string, string) {
+	if c.ZipSuffix != "" && strings.HasSuffix(virtualPath, c.ZipSuffix) {
+		return util.CleanPath(strings.TrimSuffix(virtualPath, c.ZipSuffix)), ArchiveFormatZip
+	}
+	if c.TarSuffix != "" && strings.HasSuffix(virtualPath, c.TarSuffix) {
+		return util.CleanPath(strings.TrimSuffix(virtualPath, c.TarSuffix)), ArchiveFormatTar
+	}
+	return "", ""
+}
+
+// ArchiveDownload defines a directory to download as archive
+type ArchiveDownload struct {
+	// Requested virtual path, for example "/folder.zip"
+	VirtualPath string
+	// Virtual path for the directory to archive, for example "/folder"
+	Dir string
+	// Archive format
+	Format  string
+	modTime time.Time
+}
+
+// FileInfo returns the file info to report for the archive. The archive size
+// is unknown until the archive is fully generated, so the reported size is 0
+func (a *ArchiveDownload) FileInfo() os.FileInfo {
+	return vfs.NewFileInfo(path.Base(a.VirtualPath), false, 0, a.modTime, false)
+}
+
+// GetContentType returns the content type for the archive
+func (a *ArchiveDownload) GetContentType() string {
+	if a.Format == ArchiveFormatTar {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+// GetArchiveDownload returns the archive download for the specified virtual path, if any.
+// A virtual path is an archive download if it does not exist, it ends with a configured
+// suffix and the path without the suffix is a directory that the user can list and download
+func (c *BaseConnection) GetArchiveDownload(virtualPath string) (*ArchiveDownload, bool) {
+	if !Config.ArchiveDownloads.IsEnabled() {
+		return nil, false
+	}
+	dir, format := Config.ArchiveDownloads.getDirAndFormat(virtualPath)
+	if format == "" {
+		return nil, false
+	}
+	if !c.User.HasPerms([]string{dataprovider.PermListItems, dataprovider.PermDownload}, dir) {
+		return nil, false
+	}
+	if _, err := c.DoStat(virtualPath, 0, false); !c.IsNotExistError(err) {
+		return nil, false
+	}
+	info, err := c.DoStat(dir, 0, false)
+	if err != nil || !info.IsDir() {
+		return nil, false
+	}
+	return &ArchiveDownload{
+		VirtualPath: virtualPath,
+		Dir:         dir,
+		Format:      format,
+		modTime:     info.ModTime(),
+	}, true
+}
+
+// OpenArchiveDownload returns a reader for the specified archive download. The archive
+// is generated in background and it is stored inside a file pipe
+func (c *BaseConnection) OpenArchiveDownload(archive *ArchiveDownload) (*pipeat.PipeReaderAt, error) {
+	r, w, err := pipeat.PipeInDir(vfs.GetTempPath())
+	if err != nil {
+		c.Log(logger.LevelError, "unable to create pipe for archive %q: %v", archive.VirtualPath, err)
+		return nil, err
+	}
+
+	go func() {
+		err := c.WriteArchive(w, archive)
+		w.CloseWithError(err) //nolint:errcheck
+	}()
+
+	return r, nil
+}
+
+// WriteArchive writes the specified archive download to w. A download
+// transfer is executed, and so download events fired, for each archived file
+func (c *BaseConnection) WriteArchive(w io.Writer, archive *ArchiveDownload) error {
+	c.UpdateLastActivity()
+
+	var aw archiveWriter
+	if archive.Format == ArchiveFormatTar {
+		aw = &tarArchiveWriter{w: tar.NewWriter(w)}
+	} else {
+		aw = &zipArchiveWriter{w: zip.NewWriter(w)}
+	}
+	c.Log(logger.LevelDebug, "start writing %s archive for dir %q", archive.Format, archive.Dir)
+	if err := c.addArchiveDir(aw, archive.Dir, archive.Dir); err != nil {
+		c.Log(logger.LevelDebug, "unable to write %s archive for dir %q: %v", archive.Format, archive.Dir, err)
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		c.Log(logger.LevelError, "unable to close %s archive for dir %q: %v", archive.Format, archive.Dir, err)
+		return err
+	}
+	return nil
+}
+
+func (c *BaseConnection) addArchiveDir(aw archiveWriter, virtualPath, baseDir string) error {
+	if !c.User.HasPerm(dataprovider.PermListItems, virtualPath) {
+		c.Log(logger.LevelDebug, "skipping archive entries for dir %q, permission denied", virtualPath)
+		return nil
+	}
+	contents, err := c.ListDir(virtualPath)
+	if err != nil {
+		return err
+	}
+	for _, info := range contents {
+		entryPath := path.Join(virtualPath, info.Name())
+		entryName := strings.TrimPrefix(strings.TrimPrefix(entryPath, baseDir), "/")
+		if info.IsDir() {
+			if err := aw.addDir(entryName, info.ModTime()); err != nil {
+				return err
+			}
+			if err := c.addArchiveDir(aw, entryPath, baseDir); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			c.Log(logger.LevelInfo, "skipping archive entry for non regular file %q", entryPath)
+			continue
+		}
+		if err := c.addArchiveFile(aw, entryPath, entryName, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BaseConnection) addArchiveFile(aw archiveWriter, virtualPath, entryName string, info os.FileInfo) error {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.Log(logger.LevelInfo, "denying archive entry %q due to quota limits", virtualPath)
+		return c.GetReadQuotaExceededError()
+	}
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		c.Log(logger.LevelDebug, "skipping archive entry %q, permission denied", virtualPath)
+		return nil
+	}
+	if ok, _ := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelDebug, "skipping archive entry %q, file not allowed", virtualPath)
+		return nil
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	if _, err := ExecutePreAction(c, OperationPreDownload, fsPath, virtualPath, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "skipping archive entry %q, download denied by pre action: %v", virtualPath, err)
+		return nil
+	}
+	file, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q for reading: %+v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	t := NewBaseTransfer(file, c, cancelFn, fsPath, fsPath, virtualPath, TransferDownload, 0, 0, 0, 0, false,
+		fs, transferQuota)
+	reader := &archiveEntryReader{t: t}
+	if file != nil {
+		reader.r = file
+	} else {
+		reader.r = r
+	}
+
+	ew, err := aw.addFile(entryName, info)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(ew, reader)
+		if err == nil && n != info.Size() && aw.requiresSize() {
+			err = fmt.Errorf("size mismatch for %q, expected: %d, read: %d", virtualPath, info.Size(), n)
+		}
+	}
+	if err != nil {
+		t.TransferError(err)
+	}
+	if file != nil {
+		file.Close()
+	} else {
+		r.Close()
+	}
+	if errClose := t.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+type archiveEntryReader struct {
+	t *BaseTransfer
+	r io.Reader
+}
+
+func (r *archiveEntryReader) Read(p []byte) (int, error) {
+	if r.t.AbortTransfer.Load() {
+		return 0, r.t.GetAbortError()
+	}
+	r.t.Connection.UpdateLastActivity()
+
+	n, err := r.r.Read(p)
+	r.t.BytesSent.Add(int64(n))
+
+	if err == nil {
+		err = r.t.CheckRead()
+	}
+	if err != nil {
+		return n, err
+	}
+	r.t.HandleThrottle()
+	return n, nil
+}
+
+type archiveWriter interface {
+	addDir(name string, modTime time.Time) error
+	addFile(name string, info os.FileInfo) (io.Writer, error)
+	requiresSize() bool
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (a *zipArchiveWriter) addDir(name string, modTime time.Time) error {
+	_, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name + "/",
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	return err
+}
+
+func (a *zipArchiveWriter) addFile(name string, info os.FileInfo) (io.Writer, error) {
+	return a.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: info.ModTime(),
+	})
+}
+
+func (a *zipArchiveWriter) requiresSize() bool {
+	return false
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+type tarArchiveWriter struct {
+	w *tar.Writer
+}
+
+func (a *tarArchiveWriter) addDir(name string, modTime time.Time) error {
+	return a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (a *tarArchiveWriter) addFile(name string, info os.FileInfo) (io.Writer, error) {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.w, nil
+}
+
+// tar headers contain the file size, files modified while archiving cannot be added
+func (a *tarArchiveWriter) requiresSize() bool {
+	return true
+}
+
+func (a *tarArchiveWriter) Close() error {
+	return a.w.Close()
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBandwidthReservation is the maximum number of bytes reserved at once from
+// an aggregate token bucket. Bigger writes are split so that the concurrent
+// transfers sharing a bucket are served in turn and get a fair share of it
+const maxBandwidthReservation = 32768
+
+var (
+	// groupBandwidthLimiters are the token buckets shared by all the transfers
+	// of the users whose primary group defines aggregate bandwidth caps
+	groupBandwidthLimiters = newBandwidthLimiters()
+	// bindingBandwidthLimiters are the token buckets shared by all the transfers
+	// on a binding with aggregate bandwidth caps
+	bindingBandwidthLimiters = newBandwidthLimiters()
+)
+
+// bindingBandwidth defines the aggregate bandwidth caps, as KB/s, for a binding
+type bindingBandwidth struct {
+	binding  string
+	upload   int64
+	download int64
+}
+
+// SetBindingBandwidth sets the aggregate bandwidth caps, as KB/s, shared by all
+// the transfers on the binding this connection was accepted on.
+// 0 means unlimited. It must be called before starting any transfer
+func (c *BaseConnection) SetBindingBandwidth(binding string, uploadBandwidth, downloadBandwidth int64) {
+	c.bindingBandwidth = bindingBandwidth{
+		binding:  binding,
+		upload:   uploadBandwidth,
+		download: downloadBandwidth,
+	}
+}
+
+type aggregateBandwidthLimiter struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+type bandwidthLimiters struct {
+	sync.Mutex
+	limiters map[string]*aggregateBandwidthLimiter
+}
+
+func newBandwidthLimiters() *bandwidthLimiters {
+	return &bandwidthLimiters{
+		limiters: make(map[string]*aggregateBandwidthLimiter),
+	}
+}
+
+// getLimiter returns the token bucket for the specified key and transfer type.
+// The bandwidth is expressed as KB/s, the bucket is updated if it changes
+func (l *bandwidthLimiters) getLimiter(key string, transferType int, bandwidth int64) *rate.Limiter {
+	l.Lock()
+	defer l.Unlock()
+
+	aggregateLimiter, ok := l.limiters[key]
+	if !ok {
+		aggregateLimiter = &aggregateBandwidthLimiter{}
+		l.limiters[key] = aggregateLimiter
+	}
+	limiter := &aggregateLimiter.upload
+	if transferType == TransferDownload {
+		limiter = &aggregateLimiter.download
+	}
+	limit := rate.Limit(bandwidth * 1024)
+	burst := int(bandwidth * 1024)
+	if *limiter == nil {
+		*limiter = rate.NewLimiter(limit, burst)
+	} else if (*limiter).Limit() != limit {
+		(*limiter).SetLimit(limit)
+		(*limiter).SetBurst(burst)
+	}
+	return *limiter
+}
+
+// wait blocks until the specified number of bytes can be transferred
+// without exceeding the aggregate bandwidth for the specified key
+func (l *bandwidthLimiters) wait(key string, transferType int, bandwidth, size int64) {
+	limiter := l.getLimiter(key, transferType, bandwidth)
+	chunkSize := int64(limiter.Burst())
+	if chunkSize > maxBandwidthReservation {
+		chunkSize = maxBandwidthReservation
+	}
+	for size > 0 {
+		n := size
+		if n > chunkSize {
+			n = chunkSize
+		}
+		r := limiter.ReserveN(time.Now(), int(n))
+		if r.OK() {
+			time.Sleep(r.Delay())
+		}
+		size -= n
+	}
+}
+
+// transferThrottle tracks the bytes transferred since the last bandwidth change,
+// bandwidth schedules can change the bandwidth while a transfer is in progress
+type transferThrottle struct {
+	sync.Mutex
+	start     time.Time
+	bandwidth int64
+	bytes     int64
+	// bytes already accounted to the aggregate token buckets
+	aggregateBytes int64
+}
+
+// update returns the reference time and the bytes transferred since then for the
+// specified bandwidth and the bytes not yet accounted to the aggregate token buckets
+func (t *transferThrottle) update(transferStart time.Time, bandwidth, transferred int64) (time.Time, int64, int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.start.IsZero() {
+		t.start = transferStart
+		t.bandwidth = bandwidth
+	} else if t.bandwidth != bandwidth {
+		t.start = time.Now()
+		t.bandwidth = bandwidth
+		t.bytes = transferred
+	}
+	aggregateBytes := transferred - t.aggregateBytes
+	t.aggregateBytes = transferred
+	return t.start, transferred - t.bytes, aggregateBytes
+}
@@ -0,0 +1,223 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// manifests bigger than this size are not loaded
+	maxChecksumManifestSize = 16 * 1024 * 1024
+)
+
+var (
+	// serialize the manifest updates, multiple files can be uploaded at the same time
+	// in the same directory
+	checksumManifestMu sync.Mutex
+)
+
+func getChecksumManifestPath(c *dataprovider.EventActionChecksumManifest, params *EventParams) string {
+	if c.ManifestPath == "" {
+		return path.Join(path.Dir(params.VirtualPath), strings.ToUpper(c.Algorithm)+"SUMS")
+	}
+	replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+	return util.CleanPath(replaceWithReplacer(c.ManifestPath, replacer))
+}
+
+// getChecksumManifestEntryName returns the name for the specified virtual path
+// inside the manifest, relative to the manifest directory if possible
+func getChecksumManifestEntryName(manifestPath, virtualPath string) string {
+	dir := path.Dir(manifestPath)
+	if dir == "/" {
+		return strings.TrimPrefix(virtualPath, "/")
+	}
+	if strings.HasPrefix(virtualPath, dir+"/") {
+		return virtualPath[len(dir)+1:]
+	}
+	return virtualPath
+}
+
+// parseChecksumManifestLine parses a line in sha256sum format, both text and
+// binary mode are supported. It returns false if the line is not valid
+func parseChecksumManifestLine(line string) (string, string, bool) {
+	line = strings.TrimRight(line, "\r")
+	checksum, name, ok := strings.Cut(line, " ")
+	if !ok || checksum == "" || len(name) < 2 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return "", "", false
+	}
+	if name[0] != ' ' && name[0] != '*' {
+		return "", "", false
+	}
+	return strings.ToLower(checksum), name[1:], true
+}
+
+func readChecksumManifest(conn *BaseConnection, manifestPath string) ([]byte, error) {
+	info, err := conn.DoStat(manifestPath, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("manifest %q is not a regular file", manifestPath)
+	}
+	if info.Size() > maxChecksumManifestSize {
+		return nil, fmt.Errorf("manifest %q is too large, size: %d", manifestPath, info.Size())
+	}
+	reader, cancelFn, err := getFileReader(conn, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, maxChecksumManifestSize))
+}
+
+func writeChecksumManifest(conn *BaseConnection, manifestPath string, data []byte) error {
+	if err := conn.CheckParentDirs(path.Dir(manifestPath)); err != nil {
+		return err
+	}
+	writer, numFiles, truncatedSize, cancelFn, err := getFileWriter(conn, manifestPath, int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", manifestPath, err)
+	}
+	defer cancelFn()
+
+	startTime := time.Now()
+	_, err = writer.Write(data)
+	return closeWriterAndUpdateQuota(writer, conn, manifestPath, "", numFiles, truncatedSize, err, operationUpload, startTime)
+}
+
+func generateChecksumManifestEntry(conn *BaseConnection, c *dataprovider.EventActionChecksumManifest,
+	params *EventParams, manifestPath, checksum string,
+) error {
+	name := getChecksumManifestEntryName(manifestPath, params.VirtualPath)
+	var line string
+	if c.Template != "" {
+		replacements := append(params.getStringReplacements(false), "{{Checksum}}", checksum)
+		replacer := strings.NewReplacer(replacements...)
+		line = strings.TrimRight(replaceWithReplacer(c.Template, replacer), "\r\n") + "\n"
+	} else {
+		line = fmt.Sprintf("%s  %s\n", checksum, name)
+	}
+
+	checksumManifestMu.Lock()
+	defer checksumManifestMu.Unlock()
+
+	var buf bytes.Buffer
+	content, err := readChecksumManifest(conn, manifestPath)
+	if err != nil && !conn.IsNotExistError(err) {
+		return fmt.Errorf("unable to read manifest %q: %w", manifestPath, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 4096), maxChecksumManifestSize)
+	for scanner.Scan() {
+		existing := scanner.Text()
+		if c.Template == "" {
+			// the entry for an overwritten file is replaced
+			if _, entryName, ok := parseChecksumManifestLine(existing); ok && entryName == name {
+				continue
+			}
+		}
+		buf.WriteString(existing)
+		buf.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to parse manifest %q: %w", manifestPath, err)
+	}
+	buf.WriteString(line)
+	return writeChecksumManifest(conn, manifestPath, buf.Bytes())
+}
+
+func verifyChecksumManifestEntry(conn *BaseConnection, params *EventParams, manifestPath, checksum string) error {
+	content, err := readChecksumManifest(conn, manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to read manifest %q: %w", manifestPath, err)
+	}
+	name := getChecksumManifestEntryName(manifestPath, params.VirtualPath)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 4096), maxChecksumManifestSize)
+	for scanner.Scan() {
+		expected, entryName, ok := parseChecksumManifestLine(scanner.Text())
+		if !ok || entryName != name {
+			continue
+		}
+		if expected != checksum {
+			return fmt.Errorf("checksum mismatch for %q, expected: %s, actual: %s", params.VirtualPath,
+				expected, checksum)
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to parse manifest %q: %w", manifestPath, err)
+	}
+	return fmt.Errorf("no entry for %q in manifest %q", name, manifestPath)
+}
+
+func executeChecksumManifestRuleAction(c dataprovider.EventActionChecksumManifest, params *EventParams) error {
+	if params.sender == "" || params.VirtualPath == "" {
+		return errors.New("checksum manifests are only supported for filesystem events")
+	}
+	manifestPath := getChecksumManifestPath(&c, params)
+	if manifestPath == params.VirtualPath {
+		eventManagerLog(logger.LevelDebug, "checksum manifest action skipped for the manifest %q", manifestPath)
+		return nil
+	}
+	user, err := params.getUserFromSender()
+	if err != nil {
+		return err
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+
+	result, err := conn.ComputeFileHash(params.VirtualPath, c.Algorithm, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("unable to compute %s checksum for %q: %w", c.Algorithm, params.VirtualPath, err)
+	}
+	checksum := hex.EncodeToString(result)
+	params.setStepOutput([]byte(checksum))
+
+	if c.Mode == dataprovider.ChecksumManifestVerify {
+		return verifyChecksumManifestEntry(conn, params, manifestPath, checksum)
+	}
+	return generateChecksumManifestEntry(conn, &c, params, manifestPath, checksum)
+}
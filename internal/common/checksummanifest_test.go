@@ -0,0 +1,176 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestChecksumManifestHelpers(t *testing.T) {
+	c := &dataprovider.EventActionChecksumManifest{
+		Algorithm: "sha256",
+	}
+	params := &EventParams{
+		Name:        "user",
+		VirtualPath: "/dir/file.txt",
+	}
+	assert.Equal(t, "/dir/SHA256SUMS", getChecksumManifestPath(c, params))
+	c.ManifestPath = "/manifests/{{Name}}.sha256"
+	assert.Equal(t, "/manifests/user.sha256", getChecksumManifestPath(c, params))
+
+	assert.Equal(t, "file.txt", getChecksumManifestEntryName("/dir/SHA256SUMS", "/dir/file.txt"))
+	assert.Equal(t, "sub/file.txt", getChecksumManifestEntryName("/dir/SHA256SUMS", "/dir/sub/file.txt"))
+	assert.Equal(t, "dir/file.txt", getChecksumManifestEntryName("/SHA256SUMS", "/dir/file.txt"))
+	assert.Equal(t, "/other/file.txt", getChecksumManifestEntryName("/dir/SHA256SUMS", "/other/file.txt"))
+
+	checksum, name, ok := parseChecksumManifestLine("ABCDEF01  file name.txt\r")
+	assert.True(t, ok)
+	assert.Equal(t, "abcdef01", checksum)
+	assert.Equal(t, "file name.txt", name)
+	checksum, name, ok = parseChecksumManifestLine("abcdef01 *file.bin")
+	assert.True(t, ok)
+	assert.Equal(t, "abcdef01", checksum)
+	assert.Equal(t, "file.bin", name)
+	for _, line := range []string{"", "abcdef01", "abcdef01 file", "zz  file", "abcdef01  ", "# comment"} {
+		_, _, ok = parseChecksumManifestLine(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestChecksumManifestRuleAction(t *testing.T) {
+	username := "test_user_checksum_manifest"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	content1 := []byte("content1")
+	content2 := []byte("content2")
+	sum1 := sha256.Sum256(content1)
+	sum2 := sha256.Sum256(content2)
+	checksum1 := hex.EncodeToString(sum1[:])
+	checksum2 := hex.EncodeToString(sum2[:])
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file1.txt"), content1, 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file2.txt"), content2, 0666)
+	assert.NoError(t, err)
+
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeChecksumManifest,
+		Options: dataprovider.BaseEventActionOptions{
+			ChecksumConfig: dataprovider.EventActionChecksumManifest{
+				Mode:      dataprovider.ChecksumManifestGenerate,
+				Algorithm: "sha256",
+			},
+		},
+	}
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for filesystem events")
+	}
+	params := &EventParams{
+		Name:        username,
+		Event:       operationUpload,
+		VirtualPath: "/dir/file1.txt",
+		sender:      username,
+	}
+	manifestPath := filepath.Join(user.GetHomeDir(), "dir", "SHA256SUMS")
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	params.VirtualPath = "/dir/file2.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	// the entry for an overwritten file is replaced
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(manifestPath)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum1+"  file1.txt\n"+checksum2+"  file2.txt\n", string(data))
+	// the manifest itself is skipped
+	params.VirtualPath = "/dir/SHA256SUMS"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	// missing file
+	params.VirtualPath = "/dir/missing.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+	// verify
+	action.Options.ChecksumConfig.Mode = dataprovider.ChecksumManifestVerify
+	params.VirtualPath = "/dir/file1.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, checksum1, params.stepOutput)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file1.txt"), content2, 0666)
+	assert.NoError(t, err)
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "checksum mismatch")
+	}
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file3.txt"), content2, 0666)
+	assert.NoError(t, err)
+	params.VirtualPath = "/dir/file3.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no entry for")
+	}
+	err = os.Remove(manifestPath)
+	assert.NoError(t, err)
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to read manifest")
+	}
+	// custom template and manifest path
+	action.Options.ChecksumConfig = dataprovider.EventActionChecksumManifest{
+		Mode:         dataprovider.ChecksumManifestGenerate,
+		Algorithm:    "md5",
+		ManifestPath: "/manifests/{{Name}}.md5",
+		Template:     "{{VirtualPath}},{{Checksum}}",
+	}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	md5Sum := md5.Sum(content2)
+	line := "/dir/file3.txt," + hex.EncodeToString(md5Sum[:]) + "\n"
+	data, err = os.ReadFile(filepath.Join(user.GetHomeDir(), "manifests", username+".md5"))
+	assert.NoError(t, err)
+	assert.Equal(t, line+line, string(data))
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,329 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// maximum size for the out of order writes buffered while computing upload checksums
+	maxChecksumPendingSize = 16 * 1024 * 1024
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+	// SupportedFileHashes defines the hash algorithms supported for server side file hashing
+	SupportedFileHashes = []string{"md5", "sha1", "sha224", "sha256", "sha384", "sha512", "crc32c"}
+)
+
+func newFileHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha224":
+		return sha256.New224(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "crc32":
+		// not listed in SupportedFileHashes, only FTP clients request it using XCRC
+		return crc32.NewIEEE(), nil
+	case "crc32c":
+		return crc32.New(crc32cTable), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+}
+
+// ComputeFileHash returns the hash, computed using the specified algorithm, for the given
+// range of the file at the specified virtual path. A length of 0 means up to the end of the file.
+// If blockSize is greater than 0, the range is split in blocks of blockSize bytes and the
+// hashes of each block are concatenated. For Cloud Storage backends the checksums stored
+// while uploading are used, if available, instead of reading the whole file
+func (c *BaseConnection) ComputeFileHash(virtualPath, algo string, offset, length, blockSize int64) ([]byte, error) {
+	c.UpdateLastActivity()
+
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "hashing file %q is not allowed", virtualPath)
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+	if offset < 0 || length < 0 || blockSize < 0 {
+		return nil, c.GetGenericError(errors.New("invalid hash range"))
+	}
+	if _, err := newFileHash(algo); err != nil {
+		return nil, c.GetOpUnsupportedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return nil, c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, c.GetGenericError(fmt.Errorf("%q is not a regular file", virtualPath))
+	}
+	if offset > info.Size() {
+		return nil, c.GetGenericError(fmt.Errorf("invalid offset %d for %q", offset, virtualPath))
+	}
+	if length == 0 || offset+length > info.Size() {
+		length = info.Size() - offset
+	}
+	if blockSize == 0 || blockSize >= length {
+		blockSize = length
+		if offset == 0 && length == info.Size() {
+			if result, ok := c.getStoredFileHash(fs, fsPath, algo); ok {
+				return result, nil
+			}
+		}
+	}
+	return c.readFileHash(fs, fsPath, algo, offset, length, blockSize)
+}
+
+// IsIdenticalUpload returns true if skipping identical uploads is enabled and the
+// file at the specified virtual path has the given size and checksums. It allows
+// to skip uploads for protocols where the client sends the size and the checksums
+// before the data. The SHA-256 is required, weaker checksums, such as MD5, are
+// never enough alone, any other given checksum must match too.
+// The user must be allowed to overwrite and download the file
+func (c *BaseConnection) IsIdenticalUpload(virtualPath string, size int64, checksums map[string][]byte) bool {
+	if !Config.SkipIdenticalUploads || !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualPath)) {
+		return false
+	}
+	if len(checksums[vfs.ChecksumSHA256]) == 0 {
+		return false
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return false
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != size {
+		return false
+	}
+	for algo, checksum := range checksums {
+		result, err := c.ComputeFileHash(virtualPath, algo, 0, 0, 0)
+		if err != nil {
+			c.Log(logger.LevelDebug, "unable to compute %s hash for %q: %v", algo, virtualPath, err)
+			return false
+		}
+		if !bytes.Equal(result, checksum) {
+			return false
+		}
+	}
+	c.Log(logger.LevelDebug, "upload of identical contents for %q, the data are not stored", virtualPath)
+	return true
+}
+
+// getStoredFileHash returns the checksum stored for the specified file, if any.
+// Stored checksums are used only for Cloud Storage backends, they cannot be
+// modified without replacing the whole object
+func (c *BaseConnection) getStoredFileHash(fs vfs.Fs, fsPath, algo string) ([]byte, bool) {
+	checksummer, ok := fs.(vfs.FsChecksummer)
+	if !ok || vfs.IsLocalOrCryptoFs(fs) {
+		return nil, false
+	}
+	checksums, err := checksummer.GetChecksums(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get stored checksums for %q: %v", fsPath, err)
+		return nil, false
+	}
+	value, ok := checksums[algo]
+	if !ok {
+		return nil, false
+	}
+	result, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+func (c *BaseConnection) readFileHash(fs vfs.Fs, fsPath, algo string, offset, length, blockSize int64) ([]byte, error) {
+	f, r, cancelFn, err := fs.Open(fsPath, offset)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q for hashing: %+v", fsPath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.ReadCloser
+	if f != nil {
+		reader = f
+	} else {
+		reader = r
+	}
+	defer reader.Close()
+
+	var result []byte
+	for length > 0 {
+		h, err := newFileHash(algo)
+		if err != nil {
+			return nil, err
+		}
+		n := blockSize
+		if n > length {
+			n = length
+		}
+		if _, err := io.CopyN(h, reader, n); err != nil {
+			c.Log(logger.LevelError, "unable to compute %s hash for file %q: %v", algo, fsPath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+		result = h.Sum(result)
+		length -= n
+	}
+	if result == nil {
+		// empty range, return the hash for no data
+		h, _ := newFileHash(algo)
+		result = h.Sum(nil)
+	}
+	return result, nil
+}
+
+// uploadChecksums computes the configured checksums while receiving an upload.
+// Writes must be sequential, a limited amount of out of order writes, as the
+// ones issued by SFTP clients using concurrent requests, are buffered.
+// The computation is disabled for overlapping writes or if the buffered
+// data exceed the limit
+type uploadChecksums struct {
+	sync.Mutex
+	hashes      map[string]hash.Hash
+	offset      int64
+	pending     map[int64][]byte
+	pendingSize int
+	disabled    bool
+}
+
+func newUploadChecksums(algos []string) *uploadChecksums {
+	if len(algos) == 0 {
+		return nil
+	}
+	c := &uploadChecksums{
+		hashes:  make(map[string]hash.Hash),
+		pending: make(map[int64][]byte),
+	}
+	for _, algo := range algos {
+		switch algo {
+		case vfs.ChecksumSHA256:
+			c.hashes[algo] = sha256.New()
+		case vfs.ChecksumMD5:
+			c.hashes[algo] = md5.New()
+		case vfs.ChecksumCRC32C:
+			c.hashes[algo] = crc32.New(crc32cTable)
+		}
+	}
+	if len(c.hashes) == 0 {
+		return nil
+	}
+	return c
+}
+
+func (c *uploadChecksums) write(p []byte) {
+	for _, h := range c.hashes {
+		h.Write(p) //nolint:errcheck
+	}
+	c.offset += int64(len(p))
+}
+
+// update adds the data written at the specified offset
+func (c *uploadChecksums) update(p []byte, off int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.disabled || len(p) == 0 {
+		return
+	}
+	if off != c.offset {
+		if off < c.offset || c.pendingSize+len(p) > maxChecksumPendingSize {
+			c.disableNoLock()
+			return
+		}
+		if _, ok := c.pending[off]; ok {
+			c.disableNoLock()
+			return
+		}
+		buf := make([]byte, len(p))
+		copy(buf, p)
+		c.pending[off] = buf
+		c.pendingSize += len(buf)
+		return
+	}
+	c.write(p)
+	for {
+		buf, ok := c.pending[c.offset]
+		if !ok {
+			break
+		}
+		delete(c.pending, c.offset)
+		c.pendingSize -= len(buf)
+		c.write(buf)
+	}
+}
+
+func (c *uploadChecksums) disable() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.disableNoLock()
+}
+
+func (c *uploadChecksums) disableNoLock() {
+	c.disabled = true
+	c.pending = nil
+	c.pendingSize = 0
+}
+
+// getChecksums returns the hex encoded checksums and the size of the hashed data.
+// The last return value is false if the checksums cannot be computed
+func (c *uploadChecksums) getChecksums() (map[string]string, int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.disabled || len(c.pending) > 0 {
+		return nil, 0, false
+	}
+	result := make(map[string]string)
+	for algo, h := range c.hashes {
+		result[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+	return result, c.offset, true
+}
@@ -35,6 +35,8 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/dlp"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
@@ -65,6 +67,8 @@ const (
 	operationFirstUpload   = "first-upload"
 	operationDelete        = "delete"
 	operationCopy          = "copy"
+	operationVirusDetected = "virus-detected"
+	operationDLPViolation  = "dlp-violation"
 	// Pre-download action name
 	OperationPreDownload = "pre-download"
 	// Pre-upload action name
@@ -102,6 +106,10 @@ const (
 	ProtocolFTP           = "FTP"
 	ProtocolWebDAV        = "DAV"
 	ProtocolHTTP          = "HTTP"
+	ProtocolS3            = "S3"
+	ProtocolTFTP          = "TFTP"
+	ProtocolGRPC          = "GRPC"
+	ProtocolSMTP          = "SMTP"
 	ProtocolHTTPShare     = "HTTPShare"
 	ProtocolDataRetention = "DataRetention"
 	ProtocolOIDC          = "OIDC"
@@ -139,6 +147,9 @@ var (
 	ErrInternalFailure   = errors.New("internal failure")
 	ErrTransferAborted   = errors.New("transfer aborted")
 	ErrShuttingDown      = errors.New("the service is shutting down")
+	ErrTooManyTransfers  = errors.New("too many concurrent transfers")
+	ErrVirusDetected     = errors.New("upload rejected, virus detected")
+	ErrDLPViolation      = errors.New("DLP policy violation")
 	errNoTransfer        = errors.New("requested transfer not found")
 	errTransferMismatch  = errors.New("transfer mismatch")
 )
@@ -154,7 +165,7 @@ var (
 	ActiveMetadataChecks MetadataChecks
 	transfersChecker     TransfersChecker
 	supportedProtocols   = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
-		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC}
+		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC, ProtocolS3, ProtocolTFTP, ProtocolGRPC, ProtocolSMTP}
 	disconnHookProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP}
 	// the map key is the protocol, for each protocol we can have multiple rate limiters
 	rateLimiters     map[string][]*rateLimiter
@@ -172,6 +183,7 @@ func Initialize(c Configuration, isShared int) error {
 	Config.idleLoginTimeout = 2 * time.Minute
 	Config.idleTimeoutAsDuration = time.Duration(Config.IdleTimeout) * time.Minute
 	startPeriodicChecks(periodicTimeoutCheckInterval, isShared)
+	WebSessions = newWebSessionManager(isShared)
 	Config.defender = nil
 	Config.allowList = nil
 	Config.rateLimitersList = nil
@@ -203,6 +215,13 @@ func Initialize(c Configuration, isShared int) error {
 		switch c.DefenderConfig.Driver {
 		case DefenderDriverProvider:
 			defender, err = newDBDefender(&c.DefenderConfig)
+		case DefenderDriverRedis:
+			defender, err = newRedisDefender(&c.DefenderConfig)
+		case DefenderDriverCrowdSec:
+			defender, err = newCrowdSecDefender(&c.DefenderConfig)
+			if err == nil {
+				err = defender.(*crowdSecDefender).scheduleDecisionsUpdate()
+			}
 		default:
 			defender, err = newInMemoryDefender(&c.DefenderConfig)
 		}
@@ -224,6 +243,55 @@ func Initialize(c Configuration, isShared int) error {
 	dataprovider.SetTempPath(c.TempPath)
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	vfs.SetRenameMode(c.RenameMode)
+	vfs.SetSFTPFsPoolConfig(c.SFTPFsPool)
+	vfs.SetListingCacheConfig(c.ListingCache)
+	for _, algo := range c.UploadChecksums {
+		if !util.Contains(vfs.SupportedChecksums, algo) {
+			return fmt.Errorf("unsupported upload checksum %q", algo)
+		}
+	}
+	if err := c.ArchiveDownloads.validate(); err != nil {
+		return err
+	}
+	if err := c.QuotaReconciliation.validate(); err != nil {
+		return err
+	}
+	if err := c.DeadLetters.validate(); err != nil {
+		return err
+	}
+	deadLetters = newDeadLetterManager(dataprovider.GetProviderStatus().Driver)
+	if err := c.IPApproval.validate(); err != nil {
+		return err
+	}
+	Config.IPApproval = c.IPApproval
+	ipApprovals = newIPApprovalManager(dataprovider.GetProviderStatus().Driver)
+	if err := c.CredentialsRotation.validate(); err != nil {
+		return err
+	}
+	Config.avScanner = nil
+	if c.Antivirus.isEnabled() {
+		scanner, err := c.Antivirus.getScanner()
+		if err != nil {
+			return fmt.Errorf("antivirus initialization error: %w", err)
+		}
+		logger.Info(logSender, "", "antivirus initialized with config %+v", c.Antivirus)
+		Config.avScanner = scanner
+	}
+	Config.dlpInspector = nil
+	if c.DLP.IsEnabled() {
+		inspector, err := dlp.NewInspector(c.DLP)
+		if err != nil {
+			return fmt.Errorf("DLP initialization error: %w", err)
+		}
+		logger.Info(logSender, "", "DLP initialized with config %+v", c.DLP)
+		Config.dlpInspector = inspector
+	}
+	if err := vfs.SetReadCacheConfig(c.ReadCache); err != nil {
+		return fmt.Errorf("read cache initialization error: %w", err)
+	}
+	if err := vfs.SetUploadStagingConfig(c.UploadStaging); err != nil {
+		return err
+	}
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	transfersChecker = getTransfersChecker(isShared)
 	return nil
@@ -310,6 +378,29 @@ func LimitRate(protocol, ip string) (time.Duration, error) {
 	return 0, nil
 }
 
+// CheckAnonymousAccess applies the rate limiters defined for anonymous users,
+// they are applied in addition to the ones defined for the protocol, and adds
+// a defender event for the anonymous access, if requested.
+// It returns an error if the time to wait exceeds the max allowed delay
+func CheckAnonymousAccess(ip, protocol string, addDefenderEvent bool) (time.Duration, error) {
+	isListed := false
+	if Config.rateLimitersList != nil {
+		isListed, _, _ = Config.rateLimitersList.IsListed(ip, protocol)
+	}
+	if !isListed {
+		for _, limiter := range rateLimiters[rateLimiterProtocolAnonymous] {
+			if delay, err := limiter.Wait(ip, protocol); err != nil {
+				logger.Debug(logSender, "", "anonymous access, protocol %s ip %s: %v", protocol, ip, err)
+				return delay, err
+			}
+		}
+	}
+	if addDefenderEvent && Config.DefenderConfig.ScoreAnonymous > 0 {
+		AddDefenderEvent(ip, protocol, HostEventAnonymousAccess)
+	}
+	return 0, nil
+}
+
 // Reload reloads the whitelist, the IP filter plugin and the defender's block and safe lists
 func Reload() error {
 	plugin.Handler.ReloadFilter()
@@ -328,6 +419,17 @@ func IsBanned(ip, protocol string) bool {
 	return Config.defender.IsBanned(ip, protocol)
 }
 
+// IsAllowedByGeoIP returns true if the specified IP address is allowed by
+// the GeoIP filter configured for a binding
+func IsAllowedByGeoIP(filter *geoip.Filter, ip, protocol string) bool {
+	if filter == nil || filter.IsAllowed(ip) {
+		return true
+	}
+	logger.Log(logger.LevelDebug, protocol, "", "connection refused, ip %q is not allowed by the GeoIP filters, country: %q",
+		ip, geoip.GetCountry(ip))
+	return false
+}
+
 // GetDefenderBanTime returns the ban time for the given IP
 // or nil if the IP is not banned or the defender is disabled
 func GetDefenderBanTime(ip string) (*time.Time, error) {
@@ -394,6 +496,15 @@ func startPeriodicChecks(duration time.Duration, isShared int) {
 		_, err := eventScheduler.AddFunc("@every 10m", smtp.ReloadProviderConf)
 		util.PanicOnError(err)
 	}
+	_, err = eventScheduler.AddFunc(spec, Connections.checkAccessTime)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled access time check, schedule %q", spec)
+	_, err = eventScheduler.AddFunc("@every 1h", partialDownloads.removeExpired)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled expired partial downloads check, schedule %q", "@every 1h")
+	_, err = eventScheduler.AddFunc("@every 1h", cleanupIPApprovals)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled expired IP approval requests cleanup, schedule %q", "@every 1h")
 	if Config.IdleTimeout > 0 {
 		ratio := idleTimeoutCheckInterval / periodicTimeoutCheckInterval
 		spec = fmt.Sprintf("@every %s", duration*ratio)
@@ -401,6 +512,23 @@ func startPeriodicChecks(duration time.Duration, isShared int) {
 		util.PanicOnError(err)
 		logger.Info(logSender, "", "scheduled idle connections check, schedule %q", spec)
 	}
+	if Config.QuotaReconciliation.isEnabled() {
+		spec = fmt.Sprintf("@every %dm", Config.QuotaReconciliation.Interval)
+		_, err = eventScheduler.AddFunc(spec, checkQuotaReconciliation)
+		util.PanicOnError(err)
+		logger.Info(logSender, "", "scheduled quota reconciliation, schedule %q", spec)
+	}
+	if Config.CredentialsRotation.isEnabled() {
+		spec = fmt.Sprintf("@every %dm", Config.CredentialsRotation.CheckInterval)
+		_, err = eventScheduler.AddFunc(spec, checkCredentialsRotation)
+		util.PanicOnError(err)
+		logger.Info(logSender, "", "scheduled credentials rotation check, schedule %q", spec)
+	}
+	if Config.DeadLetters.Enabled && Config.DeadLetters.MaxRetries > 0 {
+		_, err = eventScheduler.AddFunc("@every 1m", checkDeadLetters)
+		util.PanicOnError(err)
+		logger.Info(logSender, "", "scheduled dead letters retry check, schedule %q", "@every 1m")
+	}
 }
 
 // ActiveTransfer defines the interface for the current active transfers
@@ -418,6 +546,7 @@ type ActiveTransfer interface {
 	SetTimes(fsPath string, atime time.Time, mtime time.Time) bool
 	GetTruncatedSize() int64
 	HasSizeLimit() bool
+	GetExpectedSize() int64
 }
 
 // ActiveConnection defines the interface for the current active connections
@@ -426,6 +555,7 @@ type ActiveConnection interface {
 	GetUsername() string
 	GetRole() string
 	GetMaxSessions() int
+	IsOutsideAccessTime(t time.Time) bool
 	GetLocalAddress() string
 	GetRemoteAddress() string
 	GetClientVersion() string
@@ -462,6 +592,22 @@ type ConnectionTransfer struct {
 	HasSizeLimit  bool   `json:"-"`
 	ULSize        int64  `json:"-"`
 	DLSize        int64  `json:"-"`
+	// total bytes expected, omitted if unknown
+	TotalSize int64 `json:"total_size,omitempty"`
+	// average speed as bytes per second
+	Speed int64 `json:"speed"`
+	// estimated time to completion in seconds, omitted if unknown
+	ETA int64 `json:"eta,omitempty"`
+}
+
+func (t *ConnectionTransfer) updateProgress(elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	t.Speed = int64(float64(t.Size) / elapsed.Seconds())
+	if t.TotalSize > t.Size && t.Speed > 0 {
+		t.ETA = (t.TotalSize - t.Size + t.Speed - 1) / t.Speed
+	}
 }
 
 func (t *ConnectionTransfer) getConnectionTransferAsString() string {
@@ -510,6 +656,12 @@ type Configuration struct {
 	// renames for these providers, they may be slow, there is no atomic rename API like for local
 	// filesystem, so SFTPGo will recursively list the directory contents and do a rename for each entry
 	RenameMode int `json:"rename_mode" mapstructure:"rename_mode"`
+	// CrossResourceRename defines how to handle renames between paths stored on different resources,
+	// for example between the user home directory and a virtual folder with a different storage backend.
+	// By default such renames are not allowed. Set to 1 to execute them as a server-side copy followed
+	// by the removal of the source. Data is streamed between the storage backends so it is not atomic
+	// and it may be slow for big files or directories
+	CrossResourceRename int `json:"cross_resource_rename" mapstructure:"cross_resource_rename"`
 	// TempPath defines the path for temporary files such as those used for atomic uploads or file pipes.
 	// If you set this option you must make sure that the defined path exists, is accessible for writing
 	// by the user running SFTPGo, and is on the same filesystem as the users home directories otherwise
@@ -563,12 +715,48 @@ type Configuration struct {
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
 	// Rate limiter configurations
-	RateLimitersConfig    []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	RateLimitersConfig []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	// Configuration for the pool of connections to SFTP storage backends
+	SFTPFsPool vfs.SFTPFsPoolConfig `json:"sftpfs_pool" mapstructure:"sftpfs_pool"`
+	// Local disk cache for the files downloaded from Cloud Storage backends
+	ReadCache vfs.ReadCacheConfig `json:"read_cache" mapstructure:"read_cache"`
+	// Per connection directory listing and stat cache for Cloud Storage backends
+	ListingCache vfs.ListingCacheConfig `json:"listing_cache" mapstructure:"listing_cache"`
+	// Staged uploads for Cloud Storage backends, they allow random access writes
+	// and truncation of the files being uploaded
+	UploadStaging vfs.UploadStagingConfig `json:"upload_staging" mapstructure:"upload_staging"`
+	// Checksums to compute while receiving uploads. Supported values: "sha256", "md5", "crc32c".
+	// The checksums are verified against the ones reported by the storage backend, if any,
+	// and are stored alongside the uploaded files
+	UploadChecksums []string `json:"upload_checksums" mapstructure:"upload_checksums"`
+	// If enabled, uploads replacing a file with identical contents do not trigger
+	// upload notifications. The contents are compared using the upload checksums
+	// and the ones stored for the replaced file. For the S3 API, if the client
+	// sends the Content-MD5 header, the upload is skipped without storing the data
+	SkipIdenticalUploads bool `json:"skip_identical_uploads" mapstructure:"skip_identical_uploads"`
+	// Configuration to download directories as archives streamed on the fly
+	// using SFTP, FTP and WebDAV clients
+	ArchiveDownloads ArchiveDownloadsConfig `json:"archive_downloads" mapstructure:"archive_downloads"`
+	// Periodic reconciliation of the used quota for a sample of users and folders
+	QuotaReconciliation QuotaReconciliationConfig `json:"quota_reconciliation" mapstructure:"quota_reconciliation"`
+	// Virus scanning for the uploaded files using clamd or an ICAP server
+	Antivirus AntivirusConfig `json:"antivirus" mapstructure:"antivirus"`
+	// Data loss prevention policies to inspect the uploaded files.
+	// Policies are assigned to users and groups by name
+	DLP dlp.Config `json:"dlp" mapstructure:"dlp"`
+	// Persistence and automatic retries for failed event actions
+	DeadLetters DeadLetterConfig `json:"dead_letters" mapstructure:"dead_letters"`
+	// Approval of logins from IP addresses not included in the users' allowed lists
+	IPApproval IPApprovalConfig `json:"ip_approval" mapstructure:"ip_approval"`
+	// Automatic rotation of the Cloud Storage credentials
+	CredentialsRotation   CredentialsRotationConfig `json:"credentials_rotation" mapstructure:"credentials_rotation"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
 	allowList             *dataprovider.IPList
 	rateLimitersList      *dataprovider.IPList
+	avScanner             avScanner
+	dlpInspector          *dlp.Inspector
 }
 
 // IsAtomicUploadEnabled returns true if atomic upload is enabled
@@ -862,6 +1050,8 @@ func (conns *ActiveConnections) GetActiveSessions(username string) int {
 
 // Add adds a new connection to the active ones
 func (conns *ActiveConnections) Add(c ActiveConnection) error {
+	tenant, hasTenantLimit := getTenantWithSessionsLimit(c)
+
 	conns.Lock()
 	defer conns.Unlock()
 
@@ -871,6 +1061,11 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 				return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 			}
 		}
+		if hasTenantLimit {
+			if err := conns.checkTenantSessions(&tenant, ""); err != nil {
+				return err
+			}
+		}
 		conns.addUserConnection(username)
 	}
 	conns.mapping[c.GetID()] = len(conns.connections)
@@ -886,6 +1081,8 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 // for example for FTP is used to update the connection once the user
 // authenticates
 func (conns *ActiveConnections) Swap(c ActiveConnection) error {
+	tenant, hasTenantLimit := getTenantWithSessionsLimit(c)
+
 	conns.Lock()
 	defer conns.Unlock()
 
@@ -899,6 +1096,12 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 					return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 				}
 			}
+			if hasTenantLimit {
+				if err := conns.checkTenantSessions(&tenant, c.GetID()); err != nil {
+					conns.addUserConnection(conn.GetUsername())
+					return err
+				}
+			}
 			conns.addUserConnection(username)
 		}
 		err := conn.CloseFS()
@@ -911,6 +1114,38 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 	return errors.New("connection to swap not found")
 }
 
+// getTenantWithSessionsLimit returns the tenant the connection role belongs to
+// if it limits the number of concurrent sessions
+func getTenantWithSessionsLimit(c ActiveConnection) (dataprovider.Tenant, bool) {
+	if c.GetUsername() == "" || c.GetRole() == "" {
+		return dataprovider.Tenant{}, false
+	}
+	tenant, ok := dataprovider.GetTenantForRole(c.GetRole())
+	if !ok || tenant.MaxSessions <= 0 {
+		return tenant, false
+	}
+	return tenant, true
+}
+
+// checkTenantSessions returns an error if the users of the specified tenant have
+// reached the maximum number of concurrent sessions. The connection with the
+// specified ID is not counted. It must be called with the lock held
+func (conns *ActiveConnections) checkTenantSessions(tenant *dataprovider.Tenant, excludeID string) error {
+	sessions := 0
+	for _, conn := range conns.connections {
+		if conn.GetID() == excludeID || conn.GetUsername() == "" {
+			continue
+		}
+		if tenant.HasRole(conn.GetRole()) {
+			sessions++
+		}
+	}
+	if sessions >= tenant.MaxSessions {
+		return fmt.Errorf("too many open sessions for tenant %q: %d/%d", tenant.Name, sessions, tenant.MaxSessions)
+	}
+	return nil
+}
+
 // Remove removes a connection from the active ones
 func (conns *ActiveConnections) Remove(connectionID string) {
 	conns.Lock()
@@ -951,6 +1186,20 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 // Close closes an active connection.
 // It returns true on success
 func (conns *ActiveConnections) Close(connectionID, role string) bool {
+	return conns.close(connectionID, func(connRole string) bool {
+		return role == "" || connRole == role
+	})
+}
+
+// CloseForRoles closes an active connection if its role is one of the specified ones.
+// It returns true on success
+func (conns *ActiveConnections) CloseForRoles(connectionID string, roles []string) bool {
+	return conns.close(connectionID, func(connRole string) bool {
+		return connRole != "" && util.Contains(roles, connRole)
+	})
+}
+
+func (conns *ActiveConnections) close(connectionID string, roleMatches func(string) bool) bool {
 	conns.RLock()
 
 	var result bool
@@ -958,7 +1207,7 @@ func (conns *ActiveConnections) Close(connectionID, role string) bool {
 	if idx, ok := conns.mapping[connectionID]; ok {
 		c := conns.connections[idx]
 
-		if role == "" || c.GetRole() == role {
+		if roleMatches(c.GetRole()) {
 			defer func(conn ActiveConnection) {
 				err := conn.Disconnect()
 				logger.Debug(conn.GetProtocol(), conn.GetID(), "close connection requested, close err: %v", err)
@@ -1044,6 +1293,23 @@ func (conns *ActiveConnections) checkIdles() {
 	conns.RUnlock()
 }
 
+func (conns *ActiveConnections) checkAccessTime() {
+	now := time.Now()
+	conns.RLock()
+
+	for _, c := range conns.connections {
+		if c.IsOutsideAccessTime(now) {
+			defer func(conn ActiveConnection) {
+				err := conn.Disconnect()
+				logger.Info(conn.GetProtocol(), conn.GetID(), "close connection outside the allowed access time, username: %q, close err: %v",
+					conn.GetUsername(), err)
+			}(c)
+		}
+	}
+
+	conns.RUnlock()
+}
+
 func (conns *ActiveConnections) checkTransfers() {
 	if conns.transfersCheckStatus.Load() {
 		logger.Warn(logSender, "", "the previous transfer check is still running, skipping execution")
@@ -1175,18 +1441,32 @@ func (conns *ActiveConnections) IsNewConnectionAllowed(ipAddr, protocol string)
 
 // GetStats returns stats for active connections
 func (conns *ActiveConnections) GetStats(role string) []ConnectionStatus {
+	return conns.getStats(func(connRole string) bool {
+		return role == "" || connRole == role
+	})
+}
+
+// GetStatsForRoles returns stats for the active connections whose role is one of the specified ones
+func (conns *ActiveConnections) GetStatsForRoles(roles []string) []ConnectionStatus {
+	return conns.getStats(func(connRole string) bool {
+		return connRole != "" && util.Contains(roles, connRole)
+	})
+}
+
+func (conns *ActiveConnections) getStats(roleMatches func(string) bool) []ConnectionStatus {
 	conns.RLock()
 	defer conns.RUnlock()
 
 	stats := make([]ConnectionStatus, 0, len(conns.connections))
 	node := dataprovider.GetNodeName()
 	for _, c := range conns.connections {
-		if role == "" || c.GetRole() == role {
+		if roleMatches(c.GetRole()) {
 			stat := ConnectionStatus{
 				Username:       c.GetUsername(),
 				ConnectionID:   c.GetID(),
 				ClientVersion:  c.GetClientVersion(),
 				RemoteAddress:  c.GetRemoteAddress(),
+				Country:        geoip.GetCountry(util.GetIPFromRemoteAddress(c.GetRemoteAddress())),
 				ConnectionTime: util.GetTimeAsMsSinceEpoch(c.GetConnectionTime()),
 				LastActivity:   util.GetTimeAsMsSinceEpoch(c.GetLastActivity()),
 				Protocol:       c.GetProtocol(),
@@ -1210,6 +1490,8 @@ type ConnectionStatus struct {
 	ClientVersion string `json:"client_version,omitempty"`
 	// Remote address for this connection
 	RemoteAddress string `json:"remote_address"`
+	// ISO country code for the remote address, empty if GeoIP is disabled or the country is unknown
+	Country string `json:"country,omitempty"`
 	// Connection time as unix timestamp in milliseconds
 	ConnectionTime int64 `json:"connection_time"`
 	// Last activity as unix timestamp in milliseconds
@@ -1231,20 +1513,23 @@ func (c *ConnectionStatus) GetConnectionDuration() string {
 }
 
 // GetConnectionInfo returns connection info.
-// Protocol,Client Version and RemoteAddress are returned.
+// Protocol,Client Version, RemoteAddress and Country, if known, are returned.
 func (c *ConnectionStatus) GetConnectionInfo() string {
 	var result strings.Builder
 
 	result.WriteString(fmt.Sprintf("%v. Client: %q From: %q", c.Protocol, c.ClientVersion, c.RemoteAddress))
+	if c.Country != "" {
+		result.WriteString(fmt.Sprintf(" Country: %q", c.Country))
+	}
 
 	if c.Command == "" {
 		return result.String()
 	}
 
 	switch c.Protocol {
-	case ProtocolSSH, ProtocolFTP:
+	case ProtocolSSH, ProtocolFTP, ProtocolTFTP:
 		result.WriteString(fmt.Sprintf(". Command: %q", c.Command))
-	case ProtocolWebDAV:
+	case ProtocolWebDAV, ProtocolS3, ProtocolGRPC:
 		result.WriteString(fmt.Sprintf(". Method: %q", c.Command))
 	}
 
@@ -1263,6 +1548,34 @@ func (c *ConnectionStatus) GetTransfersAsString() string {
 	return result
 }
 
+// GetTransfersProgress returns the progress for the active transfers
+func (c *ConnectionStatus) GetTransfersProgress() []TransferProgress {
+	progress := make([]TransferProgress, 0, len(c.Transfers))
+	for _, t := range c.Transfers {
+		progress = append(progress, TransferProgress{
+			ConnectionID:       c.ConnectionID,
+			Username:           c.Username,
+			Protocol:           c.Protocol,
+			Node:               c.Node,
+			ConnectionTransfer: t,
+		})
+	}
+	return progress
+}
+
+// TransferProgress defines the progress for an active transfer
+type TransferProgress struct {
+	// Unique identifier for the connection
+	ConnectionID string `json:"connection_id"`
+	// Logged in username
+	Username string `json:"username"`
+	// Protocol for the connection
+	Protocol string `json:"protocol"`
+	// Node identifier, omitted for single node installations
+	Node string `json:"node,omitempty"`
+	ConnectionTransfer
+}
+
 // ActiveQuotaScan defines an active quota scan for a user
 type ActiveQuotaScan struct {
 	// Username to which the quota scan refers
@@ -35,6 +35,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -215,6 +216,60 @@ func TestConnections(t *testing.T) {
 	Connections.RUnlock()
 }
 
+func TestReadCacheInitialization(t *testing.T) {
+	configCopy := Config
+
+	config := Configuration{
+		ReadCache: vfs.ReadCacheConfig{
+			Path:    "relative",
+			MaxSize: 10,
+		},
+	}
+	err := Initialize(config, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "read cache initialization error")
+	}
+	cachePath := filepath.Join(os.TempDir(), "readcache")
+	config.ReadCache.Path = cachePath
+	config.ReadCache.MaxFileSize = -1
+	err = Initialize(config, 0)
+	assert.Error(t, err)
+	config.ReadCache.MaxFileSize = 1
+	err = Initialize(config, 0)
+	assert.NoError(t, err)
+	assert.DirExists(t, cachePath)
+	// disable the read cache
+	err = Initialize(configCopy, 0)
+	assert.NoError(t, err)
+	err = os.RemoveAll(cachePath)
+	assert.NoError(t, err)
+
+	Config = configCopy
+}
+
+func TestUploadStagingInitialization(t *testing.T) {
+	configCopy := Config
+
+	config := Configuration{
+		UploadStaging: vfs.UploadStagingConfig{
+			Enabled:         true,
+			MemoryThreshold: -1,
+		},
+	}
+	err := Initialize(config, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "memory threshold")
+	}
+	config.UploadStaging.MemoryThreshold = 4
+	err = Initialize(config, 0)
+	assert.NoError(t, err)
+	// disable upload staging
+	err = Initialize(configCopy, 0)
+	assert.NoError(t, err)
+
+	Config = configCopy
+}
+
 func TestInitializationClosedProvider(t *testing.T) {
 	configCopy := Config
 
@@ -502,19 +557,25 @@ func TestRateLimitersIntegration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, Config.rateLimitersList)
 
-	assert.Len(t, rateLimiters, 4)
+	assert.Len(t, rateLimiters, 9)
 	assert.Len(t, rateLimiters[ProtocolSSH], 1)
 	assert.Len(t, rateLimiters[ProtocolFTP], 2)
 	assert.Len(t, rateLimiters[ProtocolWebDAV], 2)
 	assert.Len(t, rateLimiters[ProtocolHTTP], 1)
+	assert.Len(t, rateLimiters[ProtocolS3], 1)
+	assert.Len(t, rateLimiters[ProtocolTFTP], 1)
+	assert.Len(t, rateLimiters[ProtocolGRPC], 1)
+	assert.Len(t, rateLimiters[ProtocolSMTP], 1)
+	assert.Len(t, rateLimiters[rateLimiterProtocolAnonymous], 1)
 
 	enabled, protocols = Config.GetRateLimitersStatus()
 	assert.True(t, enabled)
-	assert.Len(t, protocols, 4)
+	assert.Len(t, protocols, 9)
 	assert.Contains(t, protocols, ProtocolFTP)
 	assert.Contains(t, protocols, ProtocolSSH)
 	assert.Contains(t, protocols, ProtocolHTTP)
 	assert.Contains(t, protocols, ProtocolWebDAV)
+	assert.Contains(t, protocols, ProtocolS3)
 
 	source1 := "127.1.1.1"
 	source2 := "127.1.1.2"
@@ -550,6 +611,67 @@ func TestRateLimitersIntegration(t *testing.T) {
 	Config = configCopy
 }
 
+func TestAnonymousAccess(t *testing.T) {
+	configCopy := Config
+
+	Config.RateLimitersConfig = []RateLimiterConfig{
+		{
+			Average:          1,
+			Period:           1000,
+			Burst:            2,
+			Type:             int(rateLimiterTypeSource),
+			Protocols:        []string{rateLimiterProtocolAnonymous},
+			EntriesSoftLimit: 100,
+			EntriesHardLimit: 150,
+		},
+	}
+	Config.DefenderConfig = DefenderConfig{
+		Enabled:          true,
+		Driver:           DefenderDriverMemory,
+		BanTime:          10,
+		BanTimeIncrement: 50,
+		Threshold:        6,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ScoreNoAuth:      2,
+		ScoreAnonymous:   3,
+		ObservationTime:  15,
+		EntriesSoftLimit: 100,
+		EntriesHardLimit: 150,
+	}
+	err := Initialize(Config, 0)
+	require.NoError(t, err)
+
+	source1 := "127.1.2.1"
+	source2 := "127.1.2.2"
+	// anonymous rate limiters must not affect the protocol ones
+	_, err = LimitRate(ProtocolFTP, source1)
+	assert.NoError(t, err)
+	_, err = LimitRate(ProtocolFTP, source1)
+	assert.NoError(t, err)
+	_, err = CheckAnonymousAccess(source1, ProtocolFTP, true)
+	assert.NoError(t, err)
+	score, err := GetDefenderScore(source1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, score)
+	assert.False(t, IsBanned(source1, ProtocolFTP))
+	_, err = CheckAnonymousAccess(source1, ProtocolHTTP, true)
+	assert.NoError(t, err)
+	assert.True(t, IsBanned(source1, ProtocolFTP))
+	_, err = CheckAnonymousAccess(source1, ProtocolHTTP, true)
+	assert.Error(t, err)
+	// no defender event requested
+	_, err = CheckAnonymousAccess(source2, ProtocolHTTP, false)
+	assert.NoError(t, err)
+	score, err = GetDefenderScore(source2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, score)
+
+	Config = configCopy
+	err = Initialize(Config, 0)
+	require.NoError(t, err)
+}
+
 func TestUserMaxSessions(t *testing.T) {
 	c := NewBaseConnection("id", ProtocolSFTP, "", "", dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -662,6 +784,93 @@ func TestMaxConnectionPerHost(t *testing.T) {
 	Config.MaxPerHostConnections = oldValue
 }
 
+func TestAccessTimeWindows(t *testing.T) {
+	// 2023-06-05 is a Monday
+	monday := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "access_time_user",
+			Status:   1,
+		},
+	}
+	assert.True(t, user.IsLoginTimeAllowed(monday))
+	assert.False(t, user.IsOutsideAccessTime(monday))
+	user.Filters.AccessTimeWindows = []dataprovider.AccessTimeWindow{
+		{
+			Days: []int{1, 2, 3, 4, 5},
+			From: "08:00",
+			To:   "18:00",
+		},
+	}
+	assert.True(t, user.Filters.AccessTimeWindows[0].HasDay(1))
+	assert.False(t, user.Filters.AccessTimeWindows[0].HasDay(0))
+	assert.True(t, user.IsLoginTimeAllowed(monday))
+	assert.False(t, user.IsLoginTimeAllowed(monday.Add(9*time.Hour)))
+	// Sunday
+	assert.False(t, user.IsLoginTimeAllowed(monday.Add(-24*time.Hour)))
+	// 10:00 UTC is 12:00 in Rome and 06:00 in New York
+	user.Filters.AccessTimeZone = "Europe/Rome"
+	assert.True(t, user.IsLoginTimeAllowed(monday))
+	user.Filters.AccessTimeZone = "America/New_York"
+	assert.False(t, user.IsLoginTimeAllowed(monday))
+	assert.True(t, user.IsLoginTimeAllowed(monday.Add(3*time.Hour)))
+	// the session termination is not enabled
+	assert.False(t, user.IsOutsideAccessTime(monday))
+	user.Filters.DisconnectOutsideAccessTime = true
+	assert.True(t, user.IsOutsideAccessTime(monday))
+	assert.False(t, user.IsOutsideAccessTime(monday.Add(3*time.Hour)))
+	user.Filters.AccessTimeZone = "invalid"
+	assert.False(t, user.IsLoginTimeAllowed(monday.Add(3*time.Hour)))
+	// windows spanning midnight
+	user.Filters.AccessTimeZone = ""
+	user.Filters.AccessTimeWindows = []dataprovider.AccessTimeWindow{
+		{
+			Days: []int{1},
+			From: "22:00",
+			To:   "02:00",
+		},
+	}
+	assert.False(t, user.IsLoginTimeAllowed(monday))
+	assert.True(t, user.IsLoginTimeAllowed(monday.Add(13*time.Hour)))
+	assert.True(t, user.IsLoginTimeAllowed(monday.Add(15*time.Hour)))
+	assert.False(t, user.IsLoginTimeAllowed(monday.Add(17*time.Hour)))
+	// the current time is never allowed
+	now := time.Now().UTC()
+	user.Filters.AccessTimeWindows = []dataprovider.AccessTimeWindow{
+		{
+			Days: []int{(int(now.Weekday()) + 3) % 7},
+			From: "00:00",
+			To:   "23:59",
+		},
+	}
+	err := user.CheckLoginConditions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not allowed to login at this time")
+	}
+
+	c := NewBaseConnection("access_time_id", ProtocolSFTP, "", "", user)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	err = Connections.Add(fakeConn)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, Connections.GetActiveSessions(user.Username))
+	Connections.checkAccessTime()
+	assert.Equal(t, 0, Connections.GetActiveSessions(user.Username))
+	// no windows, the connection is not closed
+	user.Filters.AccessTimeWindows = nil
+	c = NewBaseConnection("access_time_id", ProtocolSFTP, "", "", user)
+	fakeConn = &fakeConnection{
+		BaseConnection: c,
+	}
+	err = Connections.Add(fakeConn)
+	assert.NoError(t, err)
+	Connections.checkAccessTime()
+	assert.Equal(t, 1, Connections.GetActiveSessions(user.Username))
+	Connections.Remove(fakeConn.GetID())
+	assert.Equal(t, 0, Connections.GetActiveSessions(user.Username))
+}
+
 func TestIdleConnections(t *testing.T) {
 	configCopy := Config
 
@@ -908,6 +1117,74 @@ func TestConnectionStatus(t *testing.T) {
 	assert.Len(t, stats, 0)
 }
 
+func TestTransfersProgress(t *testing.T) {
+	tr := ConnectionTransfer{
+		Size:      100,
+		TotalSize: 1000,
+	}
+	tr.updateProgress(0)
+	assert.Equal(t, int64(0), tr.Speed)
+	assert.Equal(t, int64(0), tr.ETA)
+	tr.updateProgress(2 * time.Second)
+	assert.Equal(t, int64(50), tr.Speed)
+	assert.Equal(t, int64(18), tr.ETA)
+	tr.Size = 1000
+	tr.ETA = 0
+	tr.updateProgress(4 * time.Second)
+	assert.Equal(t, int64(250), tr.Speed)
+	assert.Equal(t, int64(0), tr.ETA)
+	tr = ConnectionTransfer{
+		Size: 100,
+	}
+	tr.updateProgress(time.Second)
+	assert.Equal(t, int64(100), tr.Speed)
+	assert.Equal(t, int64(0), tr.ETA)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "test_user",
+		},
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	c := NewBaseConnection("id", ProtocolSFTP, "", "", user)
+	t1 := NewBaseTransfer(nil, c, nil, "/p1", "/p1", "/r1", TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	t1.SetExpectedSize(500)
+	t1.BytesSent.Store(200)
+	t2 := NewBaseTransfer(nil, c, nil, "/p2", "/p2", "/r2", TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	t2.BytesReceived.Store(100)
+	stat := ConnectionStatus{
+		Username:     user.Username,
+		ConnectionID: c.GetID(),
+		Protocol:     c.GetProtocol(),
+		Transfers:    c.GetTransfers(),
+	}
+	progress := stat.GetTransfersProgress()
+	if assert.Len(t, progress, 2) {
+		for _, p := range progress {
+			assert.Equal(t, user.Username, p.Username)
+			assert.Equal(t, c.GetID(), p.ConnectionID)
+			assert.Equal(t, ProtocolSFTP, p.Protocol)
+			if p.OperationType == operationDownload {
+				assert.Equal(t, "/r1", p.VirtualPath)
+				assert.Equal(t, int64(200), p.Size)
+				assert.Equal(t, int64(500), p.TotalSize)
+				assert.Greater(t, p.Speed, int64(0))
+				assert.Greater(t, p.ETA, int64(0))
+			} else {
+				assert.Equal(t, "/r2", p.VirtualPath)
+				assert.Equal(t, int64(100), p.Size)
+				assert.Equal(t, int64(0), p.TotalSize)
+				assert.Equal(t, int64(0), p.ETA)
+			}
+		}
+	}
+	err := t1.Close()
+	assert.NoError(t, err)
+	err = t2.Close()
+	assert.NoError(t, err)
+	assert.Len(t, c.GetTransfers(), 0)
+}
+
 func TestQuotaScans(t *testing.T) {
 	username := "username"
 	assert.True(t, QuotaScans.AddUserQuotaScan(username, ""))
@@ -1669,3 +1946,21 @@ func BenchmarkAddRemoveSSHConnections(b *testing.B) {
 	conn1.Close()
 	conn2.Close()
 }
+
+func TestGeoIPConnectionChecks(t *testing.T) {
+	assert.True(t, IsAllowedByGeoIP(nil, "1.1.1.1", ProtocolSSH))
+	filter := geoip.Filter{
+		DeniedCountries: []string{"IT"},
+	}
+	// GeoIP is not configured, the filter is ignored
+	assert.True(t, IsAllowedByGeoIP(&filter, "1.1.1.1", ProtocolFTP))
+
+	stat := ConnectionStatus{
+		Protocol:      ProtocolSFTP,
+		ClientVersion: "client",
+		RemoteAddress: "1.1.1.1:1234",
+	}
+	assert.NotContains(t, stat.GetConnectionInfo(), "Country")
+	stat.Country = "IT"
+	assert.Contains(t, stat.GetConnectionInfo(), `From: "1.1.1.1:1234" Country: "IT"`)
+}
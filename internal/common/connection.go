@@ -54,6 +54,8 @@ type BaseConnection struct {
 	protocol   string
 	remoteAddr string
 	localAddr  string
+	// aggregate bandwidth caps for the binding the connection was accepted on
+	bindingBandwidth bindingBandwidth
 	sync.RWMutex
 	activeTransfers []ActiveTransfer
 }
@@ -109,6 +111,12 @@ func (c *BaseConnection) GetMaxSessions() int {
 	return c.User.MaxSessions
 }
 
+// IsOutsideAccessTime returns true if the connection must be closed because the
+// specified time is outside the access time windows allowed for the user
+func (c *BaseConnection) IsOutsideAccessTime(t time.Time) bool {
+	return c.User.IsOutsideAccessTime(t)
+}
+
 // GetProtocol returns the protocol for the connection
 func (c *BaseConnection) GetProtocol() string {
 	return c.protocol
@@ -228,7 +236,7 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 		case TransferUpload:
 			operationType = operationUpload
 		}
-		transfers = append(transfers, ConnectionTransfer{
+		transfer := ConnectionTransfer{
 			ID:            t.GetID(),
 			OperationType: operationType,
 			StartTime:     util.GetTimeAsMsSinceEpoch(t.GetStartTime()),
@@ -237,7 +245,10 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 			HasSizeLimit:  t.HasSizeLimit(),
 			ULSize:        t.GetUploadedSize(),
 			DLSize:        t.GetDownloadedSize(),
-		})
+			TotalSize:     t.GetExpectedSize(),
+		}
+		transfer.updateProgress(time.Since(t.GetStartTime()))
+		transfers = append(transfers, transfer)
 	}
 
 	return transfers
@@ -342,6 +353,40 @@ func (c *BaseConnection) CheckParentDirs(virtualPath string) error {
 	return nil
 }
 
+// CheckDirLimits returns an error if adding the specified virtual path exceeds
+// the maximum path depth or the maximum number of entries for its parent directory
+func (c *BaseConnection) CheckDirLimits(virtualPath string) error {
+	if c.User.Filters.MaxPathDepth > 0 {
+		depth := strings.Count(util.CleanPath(virtualPath), "/")
+		if depth > c.User.Filters.MaxPathDepth {
+			c.Log(logger.LevelInfo, "denying %q, path depth %d exceeds the limit %d", virtualPath, depth,
+				c.User.Filters.MaxPathDepth)
+			return c.GetPermissionDeniedError()
+		}
+	}
+	if c.User.Filters.MaxDirEntries > 0 {
+		dirPath := path.Dir(util.CleanPath(virtualPath))
+		fs, fsPath, err := c.GetFsAndResolvedPath(dirPath)
+		if err != nil {
+			return err
+		}
+		files, err := fs.ReadDir(fsPath)
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			c.Log(logger.LevelError, "unable to list directory %q to check the entries limit: %v", dirPath, err)
+			return c.GetFsError(fs, err)
+		}
+		if len(files) >= c.User.Filters.MaxDirEntries {
+			c.Log(logger.LevelInfo, "denying %q, the directory %q already has %d entries, limit %d", virtualPath,
+				dirPath, len(files), c.User.Filters.MaxDirEntries)
+			return c.GetPermissionDeniedError()
+		}
+	}
+	return nil
+}
+
 // CreateDir creates a new directory at the specified fsPath
 func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) error {
 	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(virtualPath)) {
@@ -356,6 +401,9 @@ func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) e
 		c.Log(logger.LevelWarn, "mkdir not allowed %q is a virtual folder", virtualPath)
 		return c.GetPermissionDeniedError()
 	}
+	if err := c.CheckDirLimits(virtualPath); err != nil {
+		return err
+	}
 	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return err
@@ -400,7 +448,7 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 	}
 	updateQuota := true
 	startTime := time.Now()
-	if err := fs.Remove(fsPath, false); err != nil {
+	if err := c.removeFile(fs, fsPath, virtualPath, info); err != nil {
 		if status > 0 && fs.IsNotExist(err) {
 			// file removed in the pre-action, if the file was deleted from the EventManager the quota is already updated
 			c.Log(logger.LevelDebug, "file deleted from the hook, status: %d", status)
@@ -409,26 +457,41 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 			c.Log(logger.LevelError, "failed to remove file/symlink %q: %+v", fsPath, err)
 			return c.GetFsError(fs, err)
 		}
+	} else if c.canMoveToTrash(info) {
+		// the file is still stored, the quota is updated when it is removed from the trash
+		updateQuota = false
 	}
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr, elapsed)
 	if updateQuota && info.Mode()&os.ModeSymlink == 0 {
-		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
-		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, -1, -size, false) //nolint:errcheck
-			if vfolder.IsIncludedInUserQuota() {
-				dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
-			}
-		} else {
-			dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
-		}
+		c.updateQuotaAfterRemove(virtualPath, size)
 	}
 	ExecuteActionNotification(c, operationDelete, fsPath, virtualPath, "", "", "", size, nil, elapsed) //nolint:errcheck
 	return nil
 }
 
+// removeFile removes the specified file or moves it to the trash if enabled
+func (c *BaseConnection) removeFile(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo) error {
+	if c.canMoveToTrash(info) {
+		return c.moveToTrash(fs, fsPath, virtualPath)
+	}
+	return fs.Remove(fsPath, false)
+}
+
+func (c *BaseConnection) updateQuotaAfterRemove(virtualPath string, size int64) {
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err == nil {
+		dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, -1, -size, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
+		}
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
+	}
+}
+
 // IsRemoveDirAllowed returns an error if removing this directory is not allowed
 func (c *BaseConnection) IsRemoveDirAllowed(fs vfs.Fs, fsPath, virtualPath string) error {
 	if virtualPath == "/" || fs.GetRelativePath(fsPath) == "/" {
@@ -576,7 +639,7 @@ func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, s
 	if ok, _ := c.User.IsFileAllowed(virtualTargetPath); !ok {
 		return fmt.Errorf("file %q is not allowed: %w", virtualTargetPath, c.GetPermissionDeniedError())
 	}
-	if c.isSameResource(virtualSourcePath, virtualSourcePath) {
+	if c.isSameResource(virtualSourcePath, virtualTargetPath) {
 		fs, fsTargetPath, err := c.GetFsAndResolvedPath(virtualTargetPath)
 		if err != nil {
 			return err
@@ -586,7 +649,7 @@ func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, s
 			if err != nil {
 				return err
 			}
-			return copier.CopyFile(fsSourcePath, fsTargetPath, srcSize)
+			return c.copyFileServerSide(copier, fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath, srcSize)
 		}
 	}
 
@@ -609,6 +672,46 @@ func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, s
 		err, operationCopy, startTime)
 }
 
+// copyFileServerSide copies a file using the storage backend copy capabilities,
+// the data is not transferred through SFTPGo
+func (c *BaseConnection) copyFileServerSide(copier vfs.FsFileCopier, fsSourcePath, fsTargetPath, virtualSourcePath,
+	virtualTargetPath string, srcSize int64,
+) error {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualSourcePath)) {
+		return c.GetPermissionDeniedError()
+	}
+	var truncatedSize int64
+	numFiles := 1
+
+	info, err := copier.Lstat(fsTargetPath)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("cannot write to a directory: %q", virtualTargetPath)
+		}
+		if info.Mode().IsRegular() {
+			if cryptFs, ok := copier.(*vfs.CryptFs); ok {
+				info = cryptFs.ConvertFileInfo(info)
+			}
+			truncatedSize = info.Size()
+		}
+		numFiles = 0
+	}
+	if err != nil && !copier.IsNotExist(err) {
+		return c.GetFsError(copier, err)
+	}
+	if err := checkWriterPermsAndQuota(c, virtualTargetPath, numFiles, srcSize, truncatedSize); err != nil {
+		return err
+	}
+	startTime := time.Now()
+	err = copier.CopyFile(fsSourcePath, fsTargetPath, srcSize)
+	if err != nil {
+		err = c.GetFsError(copier, err)
+	}
+	vfs.SetPathPermissions(copier, fsTargetPath, c.User.GetUID(), c.User.GetGID())
+	return updateQuotaAndNotify(c, virtualSourcePath, virtualTargetPath, numFiles, truncatedSize, err, nil,
+		operationCopy, startTime)
+}
+
 func (c *BaseConnection) doRecursiveCopy(virtualSourcePath, virtualTargetPath string, srcInfo os.FileInfo,
 	createTargetDir bool,
 ) error {
@@ -722,6 +825,12 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 	if err != nil {
 		return c.GetFsError(fsSrc, err)
 	}
+	crossResource := !c.isSameResource(virtualSourcePath, virtualTargetPath)
+	if crossResource && Config.CrossResourceRename == 0 {
+		c.Log(logger.LevelInfo, "rename %q->%q is not allowed: the paths must be on the same resource",
+			virtualSourcePath, virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
 	if !c.isRenamePermitted(fsSrc, fsDst, fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath, srcInfo) {
 		return c.GetPermissionDeniedError()
 	}
@@ -743,6 +852,10 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 			return c.GetPermissionDeniedError()
 		}
 	}
+	if crossResource {
+		return c.renameAcrossResources(fsSrc, fsDst, fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath,
+			srcInfo, checkParentDestination, startTime)
+	}
 	if srcInfo.IsDir() {
 		if err := c.checkFolderRename(fsSrc, fsDst, fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath, srcInfo); err != nil {
 			return err
@@ -775,6 +888,65 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 	return nil
 }
 
+// renameAcrossResources moves virtualSourcePath to virtualTargetPath if they are on different
+// resources, for example a local directory and an S3 virtual folder. There is no rename API
+// in this case: the data is streamed from the source to the target storage backend and the
+// source is removed after a successful copy
+func (c *BaseConnection) renameAcrossResources(fsSrc, fsDst vfs.Fs, fsSourcePath, fsTargetPath, virtualSourcePath,
+	virtualTargetPath string, srcInfo os.FileInfo, checkParentDestination bool, startTime time.Time,
+) error {
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		c.Log(logger.LevelInfo, "renaming the symlink %q across resources is not supported", virtualSourcePath)
+		return c.GetOpUnsupportedError()
+	}
+	deletePerms := []string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}
+	if srcInfo.IsDir() {
+		deletePerms = []string{dataprovider.PermDeleteDirs, dataprovider.PermDelete}
+	}
+	if !c.User.HasAnyPerm(deletePerms, path.Dir(virtualSourcePath)) {
+		c.Log(logger.LevelDebug, "renaming %q -> %q across resources is not allowed, the source cannot be removed",
+			virtualSourcePath, virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
+	var dstInfo os.FileInfo
+	if info, err := fsDst.Lstat(fsTargetPath); err == nil {
+		dstInfo = info
+	} else if !fsDst.IsNotExist(err) {
+		return c.GetFsError(fsDst, err)
+	}
+	if err := c.checkCopy(srcInfo, dstInfo, virtualSourcePath, virtualTargetPath); err != nil {
+		return err
+	}
+	if srcInfo.IsDir() && (c.User.HasVirtualFoldersInside(virtualSourcePath) ||
+		c.User.HasVirtualFoldersInside(virtualTargetPath)) {
+		c.Log(logger.LevelDebug, "renaming the folder %q -> %q is not supported: virtual folders inside",
+			virtualSourcePath, virtualTargetPath)
+		return c.GetOpUnsupportedError()
+	}
+	if checkParentDestination {
+		c.CheckParentDirs(path.Dir(virtualTargetPath)) //nolint:errcheck
+	}
+	done := make(chan bool)
+	defer close(done)
+	go keepConnectionAlive(c, done, 2*time.Minute)
+
+	if err := c.doRecursiveCopy(virtualSourcePath, virtualTargetPath, srcInfo, true); err != nil {
+		c.Log(logger.LevelError, "failed to copy %q -> %q across resources: %+v", fsSourcePath, fsTargetPath, err)
+		return err
+	}
+	if err := c.RemoveAll(virtualSourcePath); err != nil {
+		c.Log(logger.LevelError, "failed to remove %q after copying it to %q: %+v", fsSourcePath, fsTargetPath, err)
+		return err
+	}
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1, c.localAddr, c.remoteAddr, elapsed)
+	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, //nolint:errcheck
+		virtualTargetPath, "", 0, nil, elapsed)
+
+	return nil
+}
+
 // CreateSymlink creates fsTargetPath as a symbolic link to fsSourcePath
 func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath string) error {
 	var relativePath string
@@ -817,7 +989,8 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 		c.Log(logger.LevelError, "symlink target path %q is not allowed", virtualTargetPath)
 		return c.GetPermissionDeniedError()
 	}
-	if relativePath != "" {
+	// emulated symlinks always store the resolved target
+	if relativePath != "" && !fs.HasVirtualFolders() {
 		fsSourcePath = relativePath
 	}
 	startTime := time.Now()
@@ -886,6 +1059,25 @@ func (c *BaseConnection) DoStat(virtualPath string, mode int, checkFilePatterns
 	return c.doStatInternal(virtualPath, mode, checkFilePatterns, true)
 }
 
+// GetChecksums returns the checksums stored for the specified virtual path.
+// vfs.ErrVfsUnsupported is returned if the storage backend cannot store checksums
+func (c *BaseConnection) GetChecksums(virtualPath string) (map[string]string, error) {
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	checksummer, ok := fs.(vfs.FsChecksummer)
+	if !ok || vfs.IsCryptOsFs(fs) {
+		return nil, vfs.ErrVfsUnsupported
+	}
+	checksums, err := checksummer.GetChecksums(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get checksums for path %q: %v", fsPath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+	return checksums, nil
+}
+
 func (c *BaseConnection) createDirIfMissing(name string) error {
 	_, err := c.DoStat(name, 0, false)
 	if c.IsNotExistError(err) {
@@ -898,7 +1090,7 @@ func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
 	if Config.SetstatMode == 1 {
 		return true
 	}
-	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) {
+	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) && !vfs.IsCompressedFs(fs) {
 		return true
 	}
 	return false
@@ -1033,7 +1225,7 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 		sizeDiff := initialSize - size
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -sizeDiff, false) //nolint:errcheck
+			dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -sizeDiff, false) //nolint:errcheck
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, 0, -sizeDiff, false) //nolint:errcheck
 			}
@@ -1141,11 +1333,6 @@ func (c *BaseConnection) checkFolderRename(fsSrc, fsDst vfs.Fs, fsSourcePath, fs
 func (c *BaseConnection) isRenamePermitted(fsSrc, fsDst vfs.Fs, fsSourcePath, fsTargetPath, virtualSourcePath,
 	virtualTargetPath string, fi os.FileInfo,
 ) bool {
-	if !c.isSameResource(virtualSourcePath, virtualTargetPath) {
-		c.Log(logger.LevelInfo, "rename %#q->%q is not allowed: the paths must be on the same resource",
-			virtualSourcePath, virtualTargetPath)
-		return false
-	}
 	if c.User.IsMappedPath(fsSourcePath) && vfs.IsLocalOrCryptoFs(fsSrc) {
 		c.Log(logger.LevelWarn, "renaming a directory mapped as virtual folder is not allowed: %q", fsSourcePath)
 		return false
@@ -1190,7 +1377,7 @@ func (c *BaseConnection) hasSpaceForRename(fs vfs.Fs, virtualSourcePath, virtual
 			return true
 		}
 	}
-	if errSrc != nil && dstFolder.IsIncludedInUserQuota() {
+	if errSrc != nil && dstFolder.IsIncludedInUserQuota() && !dstFolder.HasUserQuotaRestrictions(true) {
 		// rename between user root dir and a virtual folder included in user quota
 		return true
 	}
@@ -1352,17 +1539,17 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 	transferQuota, usedFiles, usedSize := c.checkUserQuota()
 
 	var err error
-	var vfolder vfs.VirtualFolder
-	vfolder, err = c.User.GetVirtualFolderForPath(path.Dir(requestPath))
-	if err == nil && !vfolder.IsIncludedInUserQuota() {
-		if vfolder.HasNoQuotaRestrictions(checkFiles) && !getUsage {
+	vfolder, errFolder := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+	hasUserFolderQuota := errFolder == nil && vfolder.HasUserQuotaRestrictions(checkFiles)
+	if errFolder == nil && !vfolder.IsIncludedInUserQuota() {
+		if vfolder.HasNoQuotaRestrictions(checkFiles) && !getUsage && !hasUserFolderQuota {
 			return result, transferQuota
 		}
 		result.QuotaSize = vfolder.QuotaSize
 		result.QuotaFiles = vfolder.QuotaFiles
 		result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedVirtualFolderQuota(vfolder.Name)
 	} else {
-		if c.User.HasNoQuotaRestrictions(checkFiles) && !getUsage {
+		if c.User.HasNoQuotaRestrictions(checkFiles) && !getUsage && !hasUserFolderQuota {
 			return result, transferQuota
 		}
 		result.QuotaSize = c.User.QuotaSize
@@ -1375,6 +1562,9 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 			result.UsedSize = usedSize
 		}
 	}
+	if err == nil && errFolder == nil {
+		err = c.applyUserFolderQuota(&vfolder, checkFiles || getUsage, &result)
+	}
 	if err != nil {
 		c.Log(logger.LevelError, "error getting used quota for %q request path %q: %v", c.User.Username, requestPath, err)
 		result.HasSpace = false
@@ -1392,6 +1582,33 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 	return result, transferQuota
 }
 
+// applyUserFolderQuota restricts the quota check result using the per-user limits
+// defined for the virtual folder, if they are more restrictive
+func (c *BaseConnection) applyUserFolderQuota(vfolder *vfs.VirtualFolder, checkFiles bool,
+	result *vfs.QuotaCheckResult,
+) error {
+	if !vfolder.HasUserQuotaRestrictions(checkFiles) {
+		return nil
+	}
+	usedFiles, usedSize, err := dataprovider.GetUsedUserVirtualFolderQuota(c.User.Username, vfolder.Name)
+	if err != nil {
+		return err
+	}
+	if vfolder.UserQuotaSize > 0 {
+		if result.QuotaSize <= 0 || vfolder.UserQuotaSize-usedSize < result.QuotaSize-result.UsedSize {
+			result.QuotaSize = vfolder.UserQuotaSize
+			result.UsedSize = usedSize
+		}
+	}
+	if checkFiles && vfolder.UserQuotaFiles > 0 {
+		if result.QuotaFiles <= 0 || vfolder.UserQuotaFiles-usedFiles < result.QuotaFiles-result.UsedFiles {
+			result.QuotaFiles = vfolder.UserQuotaFiles
+			result.UsedFiles = usedFiles
+		}
+	}
+	return nil
+}
+
 func (c *BaseConnection) isSameResource(virtualSourcePath, virtualTargetPath string) bool {
 	sourceFolder, errSrc := c.User.GetVirtualFolderForPath(virtualSourcePath)
 	dstFolder, errDst := c.User.GetVirtualFolderForPath(virtualTargetPath)
@@ -1428,7 +1645,7 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 	if sourceFolder.Name == dstFolder.Name {
 		// both files are inside the same virtual folder
 		if initialSize != -1 {
-			dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, -numFiles, -initialSize, false) //nolint:errcheck
+			dataprovider.UpdateUserVirtualFolderQuota(&c.User, dstFolder, -numFiles, -initialSize, false) //nolint:errcheck
 			if dstFolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, -numFiles, -initialSize, false) //nolint:errcheck
 			}
@@ -1436,18 +1653,18 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 		return
 	}
 	// files are inside different virtual folders
-	dataprovider.UpdateVirtualFolderQuota(&sourceFolder.BaseVirtualFolder, -numFiles, -filesSize, false) //nolint:errcheck
+	dataprovider.UpdateUserVirtualFolderQuota(&c.User, sourceFolder, -numFiles, -filesSize, false) //nolint:errcheck
 	if sourceFolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	}
 	if initialSize == -1 {
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, numFiles, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateUserVirtualFolderQuota(&c.User, dstFolder, numFiles, filesSize, false) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, numFiles, filesSize, false) //nolint:errcheck
 		}
 	} else {
 		// we cannot have a directory here, initialSize != -1 only for files
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, 0, filesSize-initialSize, false) //nolint:errcheck
+		dataprovider.UpdateUserVirtualFolderQuota(&c.User, dstFolder, 0, filesSize-initialSize, false) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, 0, filesSize-initialSize, false) //nolint:errcheck
 		}
@@ -1456,7 +1673,7 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 
 func (c *BaseConnection) updateQuotaMoveFromVFolder(sourceFolder *vfs.VirtualFolder, initialSize, filesSize int64, numFiles int) {
 	// move between a virtual folder and the user home dir
-	dataprovider.UpdateVirtualFolderQuota(&sourceFolder.BaseVirtualFolder, -numFiles, -filesSize, false) //nolint:errcheck
+	dataprovider.UpdateUserVirtualFolderQuota(&c.User, sourceFolder, -numFiles, -filesSize, false) //nolint:errcheck
 	if sourceFolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	}
@@ -1472,13 +1689,13 @@ func (c *BaseConnection) updateQuotaMoveToVFolder(dstFolder *vfs.VirtualFolder,
 	// move between the user home dir and a virtual folder
 	dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	if initialSize == -1 {
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, numFiles, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateUserVirtualFolderQuota(&c.User, dstFolder, numFiles, filesSize, false) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, numFiles, filesSize, false) //nolint:errcheck
 		}
 	} else {
 		// we cannot have a directory here, initialSize != -1 only for files
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, 0, filesSize-initialSize, false) //nolint:errcheck
+		dataprovider.UpdateUserVirtualFolderQuota(&c.User, dstFolder, 0, filesSize-initialSize, false) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, 0, filesSize-initialSize, false) //nolint:errcheck
 		}
@@ -15,7 +15,11 @@
 package common
 
 import (
+	"bytes"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -27,6 +31,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
@@ -634,7 +639,10 @@ func TestFsFileCopier(t *testing.T) {
 	assert.True(t, ok)
 	fs = vfs.Fs(&vfs.OsFs{})
 	_, ok = fs.(vfs.FsFileCopier)
-	assert.False(t, ok)
+	assert.True(t, ok)
+	fs = vfs.Fs(&vfs.CryptFs{})
+	_, ok = fs.(vfs.FsFileCopier)
+	assert.True(t, ok)
 	fs = vfs.Fs(&vfs.SFTPFs{})
 	_, ok = fs.(vfs.FsFileCopier)
 	assert.False(t, ok)
@@ -645,3 +653,241 @@ func TestFsFileCopier(t *testing.T) {
 	_, ok = fs.(vfs.FsFileCopier)
 	assert.True(t, ok)
 }
+
+func TestOsFsCopyFile(t *testing.T) {
+	rootDir := t.TempDir()
+	fs := vfs.NewOsFs(xid.New().String(), rootDir, "")
+	copier, ok := fs.(vfs.FsFileCopier)
+	require.True(t, ok)
+
+	for _, size := range []int{0, 1, 65535, 3*1024*1024 + 17} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		source := filepath.Join(rootDir, "source")
+		target := filepath.Join(rootDir, "target")
+		err = os.WriteFile(source, content, 0640)
+		require.NoError(t, err)
+		err = copier.CopyFile(source, target, int64(size))
+		assert.NoError(t, err)
+		copied, err := os.ReadFile(target)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(content, copied), "size %d", size)
+		info, err := os.Stat(target)
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		}
+		// overwrite a bigger file, the target must be truncated
+		err = os.WriteFile(target, make([]byte, size+4096), 0640)
+		require.NoError(t, err)
+		err = copier.CopyFile(source, target, int64(size))
+		assert.NoError(t, err)
+		copied, err = os.ReadFile(target)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(content, copied), "size %d", size)
+	}
+	err := copier.CopyFile(filepath.Join(rootDir, "missing"), filepath.Join(rootDir, "target"), 0)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = copier.CopyFile(filepath.Join(rootDir, "source"), filepath.Join(rootDir, "missing", "target"), 0)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = copier.CopyFile(filepath.Join(rootDir, "source"), rootDir, 0)
+	assert.Error(t, err)
+}
+
+func TestOsFsCopyFileCrossDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("this test is only available on Linux")
+	}
+	srcDir, err := os.MkdirTemp("/dev/shm", "copy")
+	if err != nil {
+		t.Skip("/dev/shm is not available")
+	}
+	defer os.RemoveAll(srcDir)
+
+	dstDir := t.TempDir()
+	if isSameDevice(srcDir, dstDir) {
+		t.Skip("source and target are on the same device")
+	}
+	// clone is not supported across devices and copy_file_range could
+	// be unsupported too, the contents must be copied anyway
+	content := make([]byte, 1024*1024+3)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	source := filepath.Join(srcDir, "source")
+	target := filepath.Join(dstDir, "target")
+	err = os.WriteFile(source, content, 0600)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs(xid.New().String(), dstDir, "")
+	err = fs.(vfs.FsFileCopier).CopyFile(source, target, int64(len(content)))
+	assert.NoError(t, err)
+	copied, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, copied))
+}
+
+func TestCryptFsCopyFile(t *testing.T) {
+	rootDir := t.TempDir()
+	fs, err := vfs.NewCryptFs(xid.New().String(), rootDir, "", vfs.CryptFsConfig{
+		Passphrase: kms.NewPlainSecret("crypt secret"),
+	})
+	require.NoError(t, err)
+	content := make([]byte, 512*1024+5)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	source := filepath.Join(rootDir, "source")
+	target := filepath.Join(rootDir, "target")
+	writeCryptFile(t, fs, source, content)
+
+	copier, ok := fs.(vfs.FsFileCopier)
+	require.True(t, ok)
+	err = copier.CopyFile(source, target, int64(len(content)))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, readCryptFile(t, fs, target)))
+	// the copied file must be readable using another CryptFs with the same passphrase
+	otherFs, err := vfs.NewCryptFs(xid.New().String(), rootDir, "", vfs.CryptFsConfig{
+		Passphrase: kms.NewPlainSecret("crypt secret"),
+	})
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, readCryptFile(t, otherFs, target)))
+}
+
+func TestServerSideCopy(t *testing.T) {
+	homeDir := t.TempDir()
+	cryptDir1 := t.TempDir()
+	cryptDir2 := t.TempDir()
+	cryptDir3 := t.TempDir()
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	permissions["/nodownload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload,
+		dataprovider.PermCreateDirs}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    userTestUsername,
+			Permissions: permissions,
+			HomeDir:     homeDir,
+		},
+	}
+	for idx, mappedPath := range []string{cryptDir1, cryptDir2, cryptDir3} {
+		passphrase := "secret"
+		if idx == 2 {
+			passphrase = "another secret"
+		}
+		user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name:       fmt.Sprintf("crypt%d", idx+1),
+				MappedPath: mappedPath,
+				FsConfig: vfs.Filesystem{
+					Provider: sdk.CryptedFilesystemProvider,
+					CryptConfig: vfs.CryptFsConfig{
+						Passphrase: kms.NewPlainSecret(passphrase),
+					},
+				},
+			},
+			VirtualPath: fmt.Sprintf("/crypt%d", idx+1),
+			QuotaSize:   -1,
+			QuotaFiles:  -1,
+		})
+	}
+	conn := NewBaseConnection(xid.New().String(), ProtocolSFTP, "", "", user)
+	content := []byte("server side copy content")
+	err := os.WriteFile(filepath.Join(homeDir, "file"), content, 0644)
+	require.NoError(t, err)
+	// local to local, the server side copy is used
+	err = conn.Copy("/file", "/file_copy")
+	assert.NoError(t, err)
+	copied, err := os.ReadFile(filepath.Join(homeDir, "file_copy"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, copied)
+	// overwrite an existing file
+	err = os.WriteFile(filepath.Join(homeDir, "file_copy"), []byte("more data than the source file"), 0644)
+	require.NoError(t, err)
+	err = conn.Copy("/file", "/file_copy")
+	assert.NoError(t, err)
+	copied, err = os.ReadFile(filepath.Join(homeDir, "file_copy"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, copied)
+	// local to crypt, different resources, the file must be encrypted
+	err = conn.Copy("/file", "/crypt1/file")
+	assert.NoError(t, err)
+	rawContent, err := os.ReadFile(filepath.Join(cryptDir1, "file"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, content, rawContent)
+	cryptFs1, _, err := conn.GetFsAndResolvedPath("/crypt1")
+	require.NoError(t, err)
+	assert.Equal(t, content, readCryptFile(t, cryptFs1, filepath.Join(cryptDir1, "file")))
+	// crypt to crypt with the same passphrase, the server side copy is used
+	err = conn.Copy("/crypt1/file", "/crypt2/file")
+	assert.NoError(t, err)
+	cryptFs2, _, err := conn.GetFsAndResolvedPath("/crypt2")
+	require.NoError(t, err)
+	assert.Equal(t, content, readCryptFile(t, cryptFs2, filepath.Join(cryptDir2, "file")))
+	// crypt to crypt with a different passphrase, the file must be encrypted again
+	err = conn.Copy("/crypt1/file", "/crypt3/file")
+	assert.NoError(t, err)
+	cryptFs3, _, err := conn.GetFsAndResolvedPath("/crypt3")
+	require.NoError(t, err)
+	assert.Equal(t, content, readCryptFile(t, cryptFs3, filepath.Join(cryptDir3, "file")))
+	// crypt to local, the file must be decrypted
+	err = conn.Copy("/crypt3/file", "/file_decrypted")
+	assert.NoError(t, err)
+	copied, err = os.ReadFile(filepath.Join(homeDir, "file_decrypted"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, copied)
+	// download permission is required on the source
+	err = os.Mkdir(filepath.Join(homeDir, "nodownload"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "nodownload", "file"), content, 0644)
+	require.NoError(t, err)
+	err = conn.Copy("/nodownload/file", "/file_copy1")
+	assert.ErrorIs(t, err, conn.GetPermissionDeniedError())
+	assert.NoFileExists(t, filepath.Join(homeDir, "file_copy1"))
+
+	fs, fsSourcePath, err := conn.GetFsAndResolvedPath("/file")
+	require.NoError(t, err)
+	err = conn.copyFileServerSide(fs.(vfs.FsFileCopier), fsSourcePath, filepath.Join(homeDir, "nodownload"),
+		"/file", "/nodownload", int64(len(content)))
+	assert.ErrorContains(t, err, "cannot write to a directory")
+	err = conn.copyFileServerSide(fs.(vfs.FsFileCopier), filepath.Join(homeDir, "missing"),
+		filepath.Join(homeDir, "file_copy2"), "/missing", "/file_copy2", 0)
+	assert.Error(t, err)
+}
+
+func writeCryptFile(t *testing.T, fs vfs.Fs, name string, content []byte) {
+	_, w, cancelFn, err := fs.Create(name, 0)
+	require.NoError(t, err)
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	_, err = w.Write(content)
+	assert.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+}
+
+func readCryptFile(t *testing.T, fs vfs.Fs, name string) []byte {
+	_, r, cancelFn, err := fs.Open(name, 0)
+	require.NoError(t, err)
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	err = r.Close()
+	assert.NoError(t, err)
+	return content
+}
+
+func isSameDevice(dir1, dir2 string) bool {
+	name := filepath.Join(dir1, "link_test")
+	if err := os.WriteFile(name, nil, 0600); err != nil {
+		return true
+	}
+	defer os.Remove(name)
+
+	link := filepath.Join(dir2, "link_test")
+	if err := os.Link(name, link); err != nil {
+		return false
+	}
+	os.Remove(link)
+	return true
+}
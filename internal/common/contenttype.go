@@ -0,0 +1,198 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// number of bytes used to detect the content type of the uploaded files
+const contentTypeSniffLen = 512
+
+// signatures for common file types not detected by http.DetectContentType
+var contentTypeSignatures = []struct {
+	offset      int
+	signature   []byte
+	contentType string
+}{
+	{0, []byte("MZ"), "application/x-msdownload"},
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{0, []byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{257, []byte("ustar"), "application/x-tar"},
+}
+
+// detectContentType returns the content type for the specified data, without
+// parameters. The signatures of executables and some archive formats are
+// checked before the ones detected by http.DetectContentType
+func detectContentType(data []byte) string {
+	if len(data) == 0 {
+		return "application/octet-stream"
+	}
+	for _, s := range contentTypeSignatures {
+		if len(data) >= s.offset+len(s.signature) && bytes.Equal(data[s.offset:s.offset+len(s.signature)], s.signature) {
+			return s.contentType
+		}
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return contentType
+}
+
+// uploadContentChecker enforces the content types filters for an upload.
+// The first bytes of the upload are collected and the content type is
+// checked as soon as they are available, before writing them to the storage
+// backend, or when the upload is closed for smaller files
+type uploadContentChecker struct {
+	filter dataprovider.ContentTypesFilter
+	mu     sync.Mutex
+	head   []byte
+	done   bool
+	denied bool
+	// true if the content type was checked when the upload was closed,
+	// after writing the data to the storage backend
+	checkedOnClose bool
+}
+
+func newUploadContentChecker(filter dataprovider.ContentTypesFilter) *uploadContentChecker {
+	return &uploadContentChecker{
+		filter: filter,
+		head:   make([]byte, 0, contentTypeSniffLen),
+	}
+}
+
+// check adds the data written at the specified offset to the collected bytes.
+// It returns the detected content type and true, if the upload must be
+// rejected. Out of order writes stop the collection
+func (c *uploadContentChecker) check(p []byte, off int64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return "", c.denied
+	}
+	if off == int64(len(c.head)) {
+		n := contentTypeSniffLen - len(c.head)
+		if n > len(p) {
+			n = len(p)
+		}
+		c.head = append(c.head, p[:n]...)
+		if len(c.head) < contentTypeSniffLen {
+			return "", false
+		}
+	}
+	return c.setDone(false)
+}
+
+func (c *uploadContentChecker) checkOnClose() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return "", false
+	}
+	return c.setDone(true)
+}
+
+func (c *uploadContentChecker) setDone(onClose bool) (string, bool) {
+	contentType := detectContentType(c.head)
+	c.done = true
+	c.denied = !c.filter.IsAllowed(contentType)
+	c.checkedOnClose = onClose
+	c.head = nil
+	return contentType, c.denied
+}
+
+func (c *uploadContentChecker) isDenied() (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.denied, c.checkedOnClose
+}
+
+func (t *BaseTransfer) isUploadContentDenied() bool {
+	if t.contentChecker == nil {
+		return false
+	}
+	denied, _ := t.contentChecker.isDenied()
+	return denied
+}
+
+// CheckUploadContent returns an error if the content type detected for the
+// upload is not allowed. It must be called before writing the data received
+// at the specified offset
+func (t *BaseTransfer) CheckUploadContent(p []byte, off int64) error {
+	if t.contentChecker == nil {
+		return nil
+	}
+	contentType, denied := t.contentChecker.check(p, off)
+	if denied {
+		if contentType != "" {
+			t.Connection.Log(logger.LevelInfo, "upload to %q denied, content type %q not allowed",
+				t.requestPath, contentType)
+		}
+		return t.Connection.GetPermissionDeniedError()
+	}
+	return nil
+}
+
+// checkUploadContentOnClose checks the content type for uploads smaller
+// than the bytes needed to detect it
+func (t *BaseTransfer) checkUploadContentOnClose() {
+	if t.contentChecker == nil || t.ErrTransfer != nil {
+		return
+	}
+	contentType, denied := t.contentChecker.checkOnClose()
+	if denied {
+		t.Connection.Log(logger.LevelInfo, "upload to %q denied, content type %q not allowed",
+			t.requestPath, contentType)
+		t.TransferError(t.Connection.GetPermissionDeniedError())
+	}
+}
+
+// removeDeniedUpload removes the file uploaded with a content type not allowed.
+// Cloud storage uploads denied while in progress are aborted, the replaced
+// file, if any, is preserved
+func (t *BaseTransfer) removeDeniedUpload() error {
+	_, checkedOnClose := t.contentChecker.isDenied()
+	var name string
+	if t.File != nil {
+		name = t.File.Name()
+	} else if checkedOnClose {
+		name = t.fsPath
+	} else {
+		return nil
+	}
+	err := t.Fs.Remove(name, false)
+	if err == nil {
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	}
+	t.Connection.Log(logger.LevelWarn, "upload denied due to its content type, delete file: %q, deletion error: %v",
+		name, err)
+	return err
+}
@@ -0,0 +1,266 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	credentialsRotationEventName = "Credentials rotation"
+	credentialsRotationPageSize  = 100
+	credentialsRotationTimeout   = 2 * time.Minute
+)
+
+var (
+	credentialsRotationRunning atomic.Bool
+)
+
+// CredentialsRotationConfig defines the configuration for the automatic
+// rotation of the Cloud Storage credentials. The rotation interval is
+// configured for each filesystem, the scheduler periodically checks the users,
+// virtual folders and groups and rotates the credentials that are due.
+// If the data provider is shared, enable the scheduler in a single instance
+type CredentialsRotationConfig struct {
+	// Interval, in minutes, between checks. 0 means disabled
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+}
+
+func (c *CredentialsRotationConfig) isEnabled() bool {
+	return c.CheckInterval > 0
+}
+
+func (c *CredentialsRotationConfig) validate() error {
+	if c.CheckInterval < 0 {
+		return errors.New("invalid credentials rotation check interval")
+	}
+	return nil
+}
+
+func checkCredentialsRotation() {
+	if !credentialsRotationRunning.CompareAndSwap(false, true) {
+		logger.Debug(logSender, "", "credentials rotation check already in progress")
+		return
+	}
+	defer credentialsRotationRunning.Store(false)
+
+	startTime := time.Now()
+	logger.Debug(logSender, "", "credentials rotation check started")
+	numUsers := rotateUsersCredentials(startTime)
+	numFolders := rotateFoldersCredentials(startTime)
+	numGroups := rotateGroupsCredentials(startTime)
+	logger.Debug(logSender, "", "credentials rotation check completed, rotations for users: %d, folders: %d, groups: %d, elapsed: %s",
+		numUsers, numFolders, numGroups, time.Since(startTime))
+}
+
+// isCredentialsRotationDue returns true if the rotation is due for the specified filesystem
+func isCredentialsRotationDue(fs *vfs.Filesystem, now time.Time) bool {
+	rotation := fs.GetCredentialsRotation()
+	return rotation != nil && rotation.IsRotationDue(now)
+}
+
+// rotateFsCredentials rotates the credentials for the specified filesystem and
+// persists them using the save function. The previous credentials are revoked
+// only after saving the new ones
+func rotateFsCredentials(fs *vfs.Filesystem, save func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsRotationTimeout)
+	defer cancel()
+
+	revoke, err := fs.RotateCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return fmt.Errorf("unable to save the new credentials, the previous ones are still in use: %w", err)
+	}
+	if revoke != nil {
+		if err := revoke(ctx); err != nil {
+			return fmt.Errorf("the new credentials are in use but the previous ones cannot be revoked: %w", err)
+		}
+	}
+	return nil
+}
+
+func rotateUsersCredentials(now time.Time) int {
+	var rotated int
+	for offset := 0; ; offset += credentialsRotationPageSize {
+		users, err := dataprovider.GetUsers(credentialsRotationPageSize, offset, dataprovider.OrderASC, "")
+		if err != nil {
+			logger.Warn(logSender, "", "credentials rotation, unable to get users: %v", err)
+			return rotated
+		}
+		for idx := range users {
+			if !isCredentialsRotationDue(&users[idx].FsConfig, now) {
+				continue
+			}
+			if rotateUserCredentials(users[idx].Username, now) {
+				rotated++
+			}
+		}
+		if len(users) < credentialsRotationPageSize {
+			return rotated
+		}
+	}
+}
+
+func rotateUserCredentials(username string, now time.Time) bool {
+	user, err := dataprovider.UserExists(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "credentials rotation, unable to get user %q: %v", username, err)
+		return false
+	}
+	if !isCredentialsRotationDue(&user.FsConfig, now) {
+		return false
+	}
+	fs := user.FsConfig.GetACopy()
+	err = rotateFsCredentials(&fs, func() error {
+		user.FsConfig = fs
+		return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSystem, "", "")
+	})
+	params := EventParams{
+		Name:       user.Username,
+		Groups:     user.Groups,
+		ObjectName: user.Username,
+		ObjectType: "user",
+		Role:       user.Role,
+	}
+	user.PrepareForRendering()
+	handleCredentialsRotationEvent(params, &user, err)
+	return err == nil
+}
+
+func rotateFoldersCredentials(now time.Time) int {
+	var rotated int
+	for offset := 0; ; offset += credentialsRotationPageSize {
+		folders, err := dataprovider.GetFolders(credentialsRotationPageSize, offset, dataprovider.OrderASC, false)
+		if err != nil {
+			logger.Warn(logSender, "", "credentials rotation, unable to get folders: %v", err)
+			return rotated
+		}
+		for idx := range folders {
+			if !isCredentialsRotationDue(&folders[idx].FsConfig, now) {
+				continue
+			}
+			if rotateFolderCredentials(folders[idx].Name, now) {
+				rotated++
+			}
+		}
+		if len(folders) < credentialsRotationPageSize {
+			return rotated
+		}
+	}
+}
+
+func rotateFolderCredentials(name string, now time.Time) bool {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		logger.Warn(logSender, "", "credentials rotation, unable to get folder %q: %v", name, err)
+		return false
+	}
+	if !isCredentialsRotationDue(&folder.FsConfig, now) {
+		return false
+	}
+	fs := folder.FsConfig.GetACopy()
+	err = rotateFsCredentials(&fs, func() error {
+		folder.FsConfig = fs
+		return dataprovider.UpdateFolder(&folder, folder.Users, folder.Groups, dataprovider.ActionExecutorSystem, "", "")
+	})
+	params := EventParams{
+		Name:       folder.Name,
+		ObjectName: folder.Name,
+		ObjectType: "folder",
+	}
+	folder.PrepareForRendering()
+	handleCredentialsRotationEvent(params, &folder, err)
+	return err == nil
+}
+
+func rotateGroupsCredentials(now time.Time) int {
+	var rotated int
+	for offset := 0; ; offset += credentialsRotationPageSize {
+		groups, err := dataprovider.GetGroups(credentialsRotationPageSize, offset, dataprovider.OrderASC, false)
+		if err != nil {
+			logger.Warn(logSender, "", "credentials rotation, unable to get groups: %v", err)
+			return rotated
+		}
+		for idx := range groups {
+			if !isCredentialsRotationDue(&groups[idx].UserSettings.FsConfig, now) {
+				continue
+			}
+			if rotateGroupCredentials(groups[idx].Name, now) {
+				rotated++
+			}
+		}
+		if len(groups) < credentialsRotationPageSize {
+			return rotated
+		}
+	}
+}
+
+func rotateGroupCredentials(name string, now time.Time) bool {
+	group, err := dataprovider.GroupExists(name)
+	if err != nil {
+		logger.Warn(logSender, "", "credentials rotation, unable to get group %q: %v", name, err)
+		return false
+	}
+	if !isCredentialsRotationDue(&group.UserSettings.FsConfig, now) {
+		return false
+	}
+	fs := group.UserSettings.FsConfig.GetACopy()
+	err = rotateFsCredentials(&fs, func() error {
+		group.UserSettings.FsConfig = fs
+		return dataprovider.UpdateGroup(&group, group.Users, dataprovider.ActionExecutorSystem, "", "")
+	})
+	params := EventParams{
+		Name:       group.Name,
+		ObjectName: group.Name,
+		ObjectType: "group",
+	}
+	group.PrepareForRendering()
+	handleCredentialsRotationEvent(params, &group, err)
+	return err == nil
+}
+
+// handleCredentialsRotationEvent logs the rotation result and executes the
+// matching event rules. The object must be already prepared for rendering
+func handleCredentialsRotationEvent(params EventParams, object any, err error) {
+	if err != nil {
+		logger.Warn(logSender, "", "credentials rotation failed for %s %q: %v", params.ObjectType, params.ObjectName, err)
+	} else {
+		logger.Info(logSender, "", "credentials rotated for %s %q", params.ObjectType, params.ObjectName)
+	}
+	params.Event = credentialsRotationEventName
+	params.Timestamp = time.Now().UnixNano()
+	params.Status = 1
+	if err != nil {
+		params.Status = 2
+		params.AddError(err)
+	}
+	data, errMarshal := json.Marshal(object)
+	if errMarshal == nil {
+		params.Object = renderedObject(data)
+	}
+	params.sender = params.ObjectName
+	eventManager.handleCredentialsRotationEvent(params)
+}
@@ -0,0 +1,220 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestCredentialsRotationConfig(t *testing.T) {
+	c := CredentialsRotationConfig{
+		CheckInterval: -1,
+	}
+	assert.Error(t, c.validate())
+	assert.False(t, c.isEnabled())
+	c.CheckInterval = 60
+	assert.NoError(t, c.validate())
+	assert.True(t, c.isEnabled())
+
+	rotation := vfs.CredentialsRotation{
+		RotationInterval: 1,
+	}
+	assert.True(t, rotation.IsRotationDue(time.Now()))
+	assert.Empty(t, rotation.GetRotatedAtAsString())
+	rotation.RotatedAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-23 * time.Hour))
+	assert.False(t, rotation.IsRotationDue(time.Now()))
+	assert.NotEmpty(t, rotation.GetRotatedAtAsString())
+	rotation.RotatedAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-25 * time.Hour))
+	assert.True(t, rotation.IsRotationDue(time.Now()))
+	rotation.RotationInterval = 0
+	assert.False(t, rotation.IsRotationDue(time.Now()))
+
+	conditions := dataprovider.EventConditions{
+		Options: dataprovider.ConditionOptions{
+			Names: []dataprovider.ConditionPattern{
+				{
+					Pattern: "group*",
+				},
+			},
+			ProviderObjects: []string{"group"},
+		},
+	}
+	params := EventParams{
+		Name:       "group1",
+		ObjectName: "group1",
+		ObjectType: "group",
+		Event:      credentialsRotationEventName,
+	}
+	assert.True(t, eventManager.checkCredentialsRotationMatch(conditions, params))
+	params.ObjectType = "user"
+	assert.False(t, eventManager.checkCredentialsRotationMatch(conditions, params))
+	params.ObjectType = "group"
+	params.ObjectName = "user1"
+	assert.False(t, eventManager.checkCredentialsRotationMatch(conditions, params))
+
+	rule := dataprovider.EventRule{
+		Name:       "credentials rotation rule",
+		Status:     1,
+		Trigger:    dataprovider.EventTriggerCredentialsRotation,
+		Conditions: conditions,
+	}
+	rules := eventRulesContainer{}
+	rules.addUpdateRuleInternal(rule)
+	assert.Len(t, rules.CredentialsRotationEvents, 1)
+	rules.removeRuleInternal(rule.Name)
+	assert.Len(t, rules.CredentialsRotationEvents, 0)
+}
+
+func TestCredentialsRotationValidation(t *testing.T) {
+	username := "user_test_credentials_rotation_validation"
+	user := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	user.FsConfig.Provider = sdk.S3FilesystemProvider
+	user.FsConfig.S3Config.Bucket = "bucket"
+	user.FsConfig.S3Config.Region = "us-east-1"
+	user.FsConfig.S3Config.RotationInterval = 30
+	err := dataprovider.AddUser(user, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	user.FsConfig.S3Config.AccessKey = "access key"
+	user.FsConfig.S3Config.AccessSecret = kms.NewPlainSecret("access secret")
+	user.FsConfig.S3Config.Endpoint = "http://127.0.0.1:9000"
+	err = dataprovider.AddUser(user, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	user.FsConfig.S3Config.RotationInterval = 366
+	user.FsConfig.S3Config.Endpoint = ""
+	err = dataprovider.AddUser(user, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+
+	user.FsConfig.Provider = sdk.GCSFilesystemProvider
+	user.FsConfig.GCSConfig.Bucket = "bucket"
+	user.FsConfig.GCSConfig.AutomaticCredentials = 1
+	user.FsConfig.GCSConfig.RotationInterval = 30
+	err = dataprovider.AddUser(user, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+
+	user.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
+	user.FsConfig.AzBlobConfig.Container = "container"
+	user.FsConfig.AzBlobConfig.SASURL = kms.NewPlainSecret("https://account.blob.core.windows.net/container?sig=a")
+	user.FsConfig.AzBlobConfig.RotationInterval = 30
+	err = dataprovider.AddUser(user, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	// the rotation interval starts when the rotation is enabled
+	user.FsConfig.AzBlobConfig.AccountName = "account"
+	user.FsConfig.AzBlobConfig.AccountKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString([]byte("key")))
+	err = dataprovider.AddUser(user, "", "", "")
+	require.NoError(t, err)
+	assert.Greater(t, user.FsConfig.AzBlobConfig.RotatedAt, int64(0))
+	assert.False(t, user.FsConfig.AzBlobConfig.IsRotationDue(time.Now()))
+	user.FsConfig.AzBlobConfig.RotationInterval = 0
+	err = dataprovider.UpdateUser(user, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), user.FsConfig.AzBlobConfig.RotatedAt)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestCredentialsRotation(t *testing.T) {
+	username := "user_test_credentials_rotation"
+	folderName := "folder_test_credentials_rotation"
+	rotatedAt := util.GetTimeAsMsSinceEpoch(time.Now().Add(-48 * time.Hour))
+	user := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	user.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
+	user.FsConfig.AzBlobConfig.Container = "container"
+	user.FsConfig.AzBlobConfig.AccountName = "account"
+	user.FsConfig.AzBlobConfig.AccountKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString([]byte("key")))
+	user.FsConfig.AzBlobConfig.RotationInterval = 1
+	user.FsConfig.AzBlobConfig.RotatedAt = rotatedAt
+	err := dataprovider.AddUser(user, "", "", "")
+	require.NoError(t, err)
+	// a service account key is required to rotate GCS credentials
+	folder := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: filepath.Join(os.TempDir(), folderName),
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.GCSFilesystemProvider,
+			GCSConfig: vfs.GCSFsConfig{
+				BaseGCSFsConfig: sdk.BaseGCSFsConfig{
+					Bucket: "bucket",
+				},
+				Credentials: kms.NewPlainSecret(`{"type":"authorized_user"}`),
+				CredentialsRotation: vfs.CredentialsRotation{
+					RotationInterval: 1,
+					RotatedAt:        rotatedAt,
+				},
+			},
+		},
+	}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+
+	checkCredentialsRotation()
+
+	rotatedUser, err := dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	user = &rotatedUser
+	lastRotation := user.FsConfig.AzBlobConfig.RotatedAt
+	assert.Greater(t, user.FsConfig.AzBlobConfig.RotatedAt, rotatedAt)
+	assert.True(t, user.FsConfig.AzBlobConfig.SASURL.IsEncrypted())
+	err = user.FsConfig.AzBlobConfig.SASURL.TryDecrypt()
+	assert.NoError(t, err)
+	assert.Contains(t, user.FsConfig.AzBlobConfig.SASURL.GetPayload(), "https://account.blob.core.windows.net/container?")
+	assert.Contains(t, user.FsConfig.AzBlobConfig.SASURL.GetPayload(), "sig=")
+	assert.True(t, user.FsConfig.AzBlobConfig.AccountKey.IsEncrypted())
+
+	folder, err = dataprovider.GetFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Equal(t, rotatedAt, folder.FsConfig.GCSConfig.RotatedAt)
+	// not due
+	checkCredentialsRotation()
+	rotatedUser, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, lastRotation, rotatedUser.FsConfig.AzBlobConfig.RotatedAt)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(folderName, "", "", "")
+	assert.NoError(t, err)
+}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -50,6 +51,11 @@ const (
 	RetentionCheckNotificationEmail = "Email"
 )
 
+const (
+	// the archive folder is mounted on this virtual path for the archive connection
+	retentionArchiveMountPath = "/archive"
+)
+
 var (
 	// RetentionChecks is the list of active retention checks
 	RetentionChecks ActiveRetentionChecks
@@ -79,6 +85,7 @@ func (c *ActiveRetentionChecks) Get(role string) []RetentionCheck {
 				Notifications: notificationsCopy,
 				Email:         check.Email,
 				Folders:       foldersCopy,
+				DryRun:        check.DryRun,
 			})
 		}
 	}
@@ -130,13 +137,16 @@ func (c *ActiveRetentionChecks) remove(username string) bool {
 }
 
 type folderRetentionCheckResult struct {
-	Path         string        `json:"path"`
-	Retention    int           `json:"retention"`
-	DeletedFiles int           `json:"deleted_files"`
-	DeletedSize  int64         `json:"deleted_size"`
-	Elapsed      time.Duration `json:"-"`
-	Info         string        `json:"info,omitempty"`
-	Error        string        `json:"error,omitempty"`
+	Path         string `json:"path"`
+	Retention    int    `json:"retention"`
+	DeletedFiles int    `json:"deleted_files"`
+	DeletedSize  int64  `json:"deleted_size"`
+	// archived files are included in the deleted ones
+	ArchivedFiles int           `json:"archived_files"`
+	ArchivedSize  int64         `json:"archived_size"`
+	Elapsed       time.Duration `json:"-"`
+	Info          string        `json:"info,omitempty"`
+	Error         string        `json:"error,omitempty"`
 }
 
 // RetentionCheck defines an active retention check
@@ -151,10 +161,14 @@ type RetentionCheck struct {
 	Notifications []RetentionCheckNotification `json:"notifications,omitempty"`
 	// email to use if the notification method is set to email
 	Email string `json:"email,omitempty"`
-	Role  string `json:"-"`
+	// if true the files to delete or archive are only reported
+	DryRun bool   `json:"dry_run,omitempty"`
+	Role   string `json:"-"`
 	// Cleanup results
 	results []folderRetentionCheckResult `json:"-"`
 	conn    *BaseConnection
+	// connections to the archive folders
+	archiveConns map[string]*BaseConnection
 }
 
 // Validate returns an error if the specified folders are not valid
@@ -226,6 +240,99 @@ func (c *RetentionCheck) removeFile(virtualPath string, info os.FileInfo) error
 	return c.conn.RemoveFile(fs, fsPath, virtualPath, info)
 }
 
+func (c *RetentionCheck) getArchiveConnection(folderName string) (*BaseConnection, error) {
+	if conn, ok := c.archiveConns[folderName]; ok {
+		return conn, nil
+	}
+	user, err := getVirtualFolderUser(folderName, c.conn.User.Username, retentionArchiveMountPath)
+	if err != nil {
+		return nil, err
+	}
+	// archived files must not trigger filesystem events
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, c.conn.ID)
+	if err := user.CheckFsRoot(connectionID); err != nil {
+		user.CloseFs() //nolint:errcheck
+		return nil, fmt.Errorf("unable to check root fs for folder %q: %w", folderName, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	if c.archiveConns == nil {
+		c.archiveConns = make(map[string]*BaseConnection)
+	}
+	c.archiveConns[folderName] = conn
+	return conn, nil
+}
+
+func (c *RetentionCheck) closeArchiveConnections() {
+	for _, conn := range c.archiveConns {
+		conn.CloseFS() //nolint:errcheck
+	}
+	c.archiveConns = nil
+}
+
+// archiveFile copies the specified file to the archive folder. The file is
+// read bypassing the user permissions, the delete permission is already checked
+func (c *RetentionCheck) archiveFile(folderName, virtualPath string, info os.FileInfo) error {
+	dstConn, err := c.getArchiveConnection(folderName)
+	if err != nil {
+		return err
+	}
+	target := path.Join(retentionArchiveMountPath, c.conn.User.Username, virtualPath)
+	if err := dstConn.CheckParentDirs(path.Dir(target)); err != nil {
+		return err
+	}
+	fs, fsPath, err := c.conn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return c.conn.GetFsError(fs, err)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.ReadCloser = r
+	if f != nil {
+		reader = f
+	}
+	defer reader.Close()
+
+	writer, numFiles, truncatedSize, cancelWriterFn, err := getFileWriter(dstConn, target, info.Size())
+	if err != nil {
+		return err
+	}
+	defer cancelWriterFn()
+
+	startTime := time.Now()
+	_, err = io.Copy(writer, reader)
+	return closeWriterAndUpdateQuota(writer, dstConn, target, "", numFiles, truncatedSize, err, operationUpload, startTime)
+}
+
+// expireFile archives, if required, and removes the specified file.
+// In dry-run mode the file is only added to the results
+func (c *RetentionCheck) expireFile(folderRetention dataprovider.FolderRetention, virtualPath string,
+	info os.FileInfo, result *folderRetentionCheckResult,
+) error {
+	if folderRetention.ArchiveFolder != "" {
+		if !c.DryRun {
+			if err := c.archiveFile(folderRetention.ArchiveFolder, virtualPath, info); err != nil {
+				return fmt.Errorf("unable to archive file %q to folder %q: %w", virtualPath,
+					folderRetention.ArchiveFolder, err)
+			}
+		}
+		result.ArchivedFiles++
+		result.ArchivedSize += info.Size()
+	}
+	if !c.DryRun {
+		if err := c.removeFile(virtualPath, info); err != nil {
+			return fmt.Errorf("unable to remove file %q: %w", virtualPath, err)
+		}
+	}
+	result.DeletedFiles++
+	result.DeletedSize += info.Size()
+	return nil
+}
+
 func (c *RetentionCheck) cleanupFolder(folderPath string) error {
 	deleteFilesPerms := []string{dataprovider.PermDelete, dataprovider.PermDeleteFiles}
 	startTime := time.Now()
@@ -283,27 +390,28 @@ func (c *RetentionCheck) cleanupFolder(folderPath string) error {
 		} else {
 			retentionTime := info.ModTime().Add(time.Duration(folderRetention.Retention) * time.Hour)
 			if retentionTime.Before(time.Now()) {
-				if err := c.removeFile(virtualPath, info); err != nil {
+				if err := c.expireFile(folderRetention, virtualPath, info, &result); err != nil {
 					result.Elapsed = time.Since(startTime)
-					result.Error = fmt.Sprintf("unable to remove file %q: %v", virtualPath, err)
-					c.conn.Log(logger.LevelError, "unable to remove file %q, retention %v: %v",
+					result.Error = err.Error()
+					c.conn.Log(logger.LevelError, "unable to expire file %q, retention %v: %v",
 						virtualPath, retentionTime, err)
 					return err
 				}
-				c.conn.Log(logger.LevelDebug, "removed file %q, modification time: %v, retention: %v hours, retention time: %v",
-					virtualPath, info.ModTime(), folderRetention.Retention, retentionTime)
-				result.DeletedFiles++
-				result.DeletedSize += info.Size()
+				c.conn.Log(logger.LevelDebug, "expired file %q, modification time: %v, retention: %v hours, retention time: %v, archive folder: %q, dry run? %v",
+					virtualPath, info.ModTime(), folderRetention.Retention, retentionTime, folderRetention.ArchiveFolder,
+					c.DryRun)
 			}
 		}
 	}
 
-	if folderRetention.DeleteEmptyDirs {
+	if c.DryRun {
+		result.Info = "dry run, no file was deleted or archived"
+	} else if folderRetention.DeleteEmptyDirs {
 		c.checkEmptyDirRemoval(folderPath)
 	}
 	result.Elapsed = time.Since(startTime)
-	c.conn.Log(logger.LevelDebug, "retention check completed for folder %q, deleted files: %v, deleted size: %v bytes",
-		folderPath, result.DeletedFiles, result.DeletedSize)
+	c.conn.Log(logger.LevelDebug, "retention check completed for folder %q, deleted files: %v, deleted size: %v bytes, archived files: %v, archived size: %v bytes",
+		folderPath, result.DeletedFiles, result.DeletedSize, result.ArchivedFiles, result.ArchivedSize)
 
 	return nil
 }
@@ -335,6 +443,7 @@ func (c *RetentionCheck) Start() error {
 	c.conn.Log(logger.LevelInfo, "retention check started")
 	defer RetentionChecks.remove(c.conn.User.Username)
 	defer c.conn.CloseFS() //nolint:errcheck
+	defer c.closeArchiveConnections()
 
 	startTime := time.Now()
 	for _, folder := range c.Folders {
@@ -406,9 +515,13 @@ func (c *RetentionCheck) sendHookNotification(elapsed time.Duration, errCheck er
 	data := make(map[string]any)
 	totalDeletedFiles := 0
 	totalDeletedSize := int64(0)
+	totalArchivedFiles := 0
+	totalArchivedSize := int64(0)
 	for _, result := range c.results {
 		totalDeletedFiles += result.DeletedFiles
 		totalDeletedSize += result.DeletedSize
+		totalArchivedFiles += result.ArchivedFiles
+		totalArchivedSize += result.ArchivedSize
 	}
 	data["username"] = c.conn.User.Username
 	data["start_time"] = c.StartTime
@@ -420,6 +533,9 @@ func (c *RetentionCheck) sendHookNotification(elapsed time.Duration, errCheck er
 	}
 	data["total_deleted_files"] = totalDeletedFiles
 	data["total_deleted_size"] = totalDeletedSize
+	data["total_archived_files"] = totalArchivedFiles
+	data["total_archived_size"] = totalArchivedSize
+	data["dry_run"] = c.DryRun
 	data["details"] = c.results
 	jsonData, _ := json.Marshal(data)
 
@@ -17,7 +17,9 @@ package common
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -28,6 +30,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 func TestRetentionValidation(t *testing.T) {
@@ -396,3 +399,103 @@ func TestCleanupErrors(t *testing.T) {
 
 	assert.True(t, RetentionChecks.remove(user.Username))
 }
+
+func TestRetentionArchive(t *testing.T) {
+	username := "test_user_retention_archive"
+	foldername := "test_folder_retention_archive"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	folder := vfs.BaseVirtualFolder{
+		Name:       foldername,
+		MappedPath: filepath.Join(os.TempDir(), foldername),
+	}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+
+	content := []byte("expired content")
+	expiredFile := filepath.Join(user.GetHomeDir(), "dir", "sub", "expired.txt")
+	recentFile := filepath.Join(user.GetHomeDir(), "dir", "recent.txt")
+	err = os.MkdirAll(filepath.Dir(expiredFile), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(expiredFile, content, 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(recentFile, content, 0666)
+	assert.NoError(t, err)
+	modTime := time.Now().Add(-48 * time.Hour)
+	err = os.Chtimes(expiredFile, modTime, modTime)
+	assert.NoError(t, err)
+
+	folders := []dataprovider.FolderRetention{
+		{
+			Path:          "/dir",
+			Retention:     24,
+			ArchiveFolder: foldername,
+		},
+	}
+	// dry run
+	c := RetentionChecks.Add(RetentionCheck{
+		Folders: folders,
+		DryRun:  true,
+	}, &user)
+	require.NotNil(t, c)
+	err = c.Start()
+	assert.NoError(t, err)
+	// sub directories have their own results
+	require.Len(t, c.results, 2)
+	assert.Equal(t, "/dir/sub", c.results[0].Path)
+	assert.Equal(t, 1, c.results[0].DeletedFiles)
+	assert.Equal(t, int64(len(content)), c.results[0].DeletedSize)
+	assert.Equal(t, 1, c.results[0].ArchivedFiles)
+	assert.Equal(t, int64(len(content)), c.results[0].ArchivedSize)
+	assert.Contains(t, c.results[0].Info, "dry run")
+	assert.FileExists(t, expiredFile)
+	assert.NoDirExists(t, folder.MappedPath)
+	// archive
+	c = RetentionChecks.Add(RetentionCheck{
+		Folders: folders,
+	}, &user)
+	require.NotNil(t, c)
+	err = c.Start()
+	assert.NoError(t, err)
+	require.Len(t, c.results, 2)
+	assert.Equal(t, 1, c.results[0].DeletedFiles)
+	assert.Equal(t, 1, c.results[0].ArchivedFiles)
+	assert.Empty(t, c.results[0].Info)
+	assert.NoFileExists(t, expiredFile)
+	assert.FileExists(t, recentFile)
+	data, err := os.ReadFile(filepath.Join(folder.MappedPath, username, "dir", "sub", "expired.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	// missing archive folder, the expired file is not removed
+	err = os.Chtimes(recentFile, modTime, modTime)
+	assert.NoError(t, err)
+	folders[0].ArchiveFolder = "missing folder"
+	c = RetentionChecks.Add(RetentionCheck{
+		Folders: folders,
+	}, &user)
+	require.NotNil(t, c)
+	err = c.Start()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to archive file")
+	}
+	assert.FileExists(t, recentFile)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(foldername, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(folder.MappedPath)
+	assert.NoError(t, err)
+}
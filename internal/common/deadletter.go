@@ -0,0 +1,474 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	deadLetterMaxRetryDelay = 6 * time.Hour
+)
+
+var (
+	deadLetters = newDeadLetterManager(dataprovider.MemoryDataProviderName)
+	// only notifications are dead-lettered, the other actions are not safe to
+	// execute again at a later time
+	deadLetterActionTypes = []int{dataprovider.ActionTypeHTTP, dataprovider.ActionTypeEmail,
+		dataprovider.ActionTypeCommand}
+)
+
+// DeadLetterConfig defines the configuration for the dead-letter queue.
+// Failed HTTP, email and command actions are persisted and automatically
+// retried, with exponential backoff, so transient errors, for example an
+// SMTP outage, do not drop notifications. Actions that still fail after
+// the configured retries are kept until they are manually retried or discarded
+type DeadLetterConfig struct {
+	// Set to true to enable the dead-letter queue
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Maximum number of automatic retries. 0 means that failed actions are
+	// only retried manually
+	MaxRetries int `json:"max_retries" mapstructure:"max_retries"`
+	// Delay, in seconds, before the first automatic retry. It is doubled for
+	// each subsequent retry, up to 6 hours
+	RetryDelay int `json:"retry_delay" mapstructure:"retry_delay"`
+}
+
+func (c *DeadLetterConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("invalid dead-letter max retries")
+	}
+	if c.MaxRetries > 0 && c.RetryDelay <= 0 {
+		return errors.New("invalid dead-letter retry delay")
+	}
+	return nil
+}
+
+func (c *DeadLetterConfig) getRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(c.RetryDelay) * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= deadLetterMaxRetryDelay {
+			return deadLetterMaxRetryDelay
+		}
+	}
+	return delay
+}
+
+// DeadLetterEvent defines the event that triggered a dead-lettered action
+type DeadLetterEvent struct {
+	Name              string          `json:"name"`
+	Event             string          `json:"event"`
+	Status            int             `json:"status"`
+	VirtualPath       string          `json:"virtual_path,omitempty"`
+	FsPath            string          `json:"fs_path,omitempty"`
+	VirtualTargetPath string          `json:"virtual_target_path,omitempty"`
+	FsTargetPath      string          `json:"fs_target_path,omitempty"`
+	ObjectName        string          `json:"object_name,omitempty"`
+	ObjectType        string          `json:"object_type,omitempty"`
+	FileSize          int64           `json:"file_size,omitempty"`
+	Elapsed           int64           `json:"elapsed,omitempty"`
+	Protocol          string          `json:"protocol,omitempty"`
+	IP                string          `json:"ip,omitempty"`
+	Role              string          `json:"role,omitempty"`
+	Timestamp         int64           `json:"timestamp"`
+	UsedQuotaSize     int64           `json:"used_quota_size,omitempty"`
+	QuotaSize         int64           `json:"quota_size,omitempty"`
+	QuotaThreshold    int             `json:"quota_threshold,omitempty"`
+	ObjectData        json.RawMessage `json:"object_data,omitempty"`
+	Errors            []string        `json:"errors,omitempty"`
+	Sender            string          `json:"sender,omitempty"`
+}
+
+// renderedObject allows to use the object data saved for a dead-lettered action
+// as placeholder replacement
+type renderedObject []byte
+
+func (o renderedObject) RenderAsJSON(_ bool) ([]byte, error) {
+	return o, nil
+}
+
+func newDeadLetterEvent(params *EventParams) DeadLetterEvent {
+	event := DeadLetterEvent{
+		Name:              params.Name,
+		Event:             params.Event,
+		Status:            params.Status,
+		VirtualPath:       params.VirtualPath,
+		FsPath:            params.FsPath,
+		VirtualTargetPath: params.VirtualTargetPath,
+		FsTargetPath:      params.FsTargetPath,
+		ObjectName:        params.ObjectName,
+		ObjectType:        params.ObjectType,
+		FileSize:          params.FileSize,
+		Elapsed:           params.Elapsed,
+		Protocol:          params.Protocol,
+		IP:                params.IP,
+		Role:              params.Role,
+		Timestamp:         params.Timestamp,
+		UsedQuotaSize:     params.UsedQuotaSize,
+		QuotaSize:         params.QuotaSize,
+		QuotaThreshold:    params.QuotaThreshold,
+		Sender:            params.sender,
+	}
+	event.Errors = make([]string, len(params.errors))
+	copy(event.Errors, params.errors)
+	if params.Object != nil {
+		data, err := params.Object.RenderAsJSON(params.Event != operationDelete)
+		if err == nil && json.Valid(data) {
+			event.ObjectData = data
+		}
+	}
+	return event
+}
+
+func (e *DeadLetterEvent) getParams() *EventParams {
+	params := &EventParams{
+		Name:              e.Name,
+		Event:             e.Event,
+		Status:            e.Status,
+		VirtualPath:       e.VirtualPath,
+		FsPath:            e.FsPath,
+		VirtualTargetPath: e.VirtualTargetPath,
+		FsTargetPath:      e.FsTargetPath,
+		ObjectName:        e.ObjectName,
+		ObjectType:        e.ObjectType,
+		FileSize:          e.FileSize,
+		Elapsed:           e.Elapsed,
+		Protocol:          e.Protocol,
+		IP:                e.IP,
+		Role:              e.Role,
+		Timestamp:         e.Timestamp,
+		UsedQuotaSize:     e.UsedQuotaSize,
+		QuotaSize:         e.QuotaSize,
+		QuotaThreshold:    e.QuotaThreshold,
+		sender:            e.Sender,
+	}
+	params.errors = make([]string, len(e.Errors))
+	copy(params.errors, e.Errors)
+	if len(e.ObjectData) > 0 {
+		params.Object = renderedObject(e.ObjectData)
+	}
+	return params
+}
+
+// DeadLetter defines a failed event action persisted for later retries
+type DeadLetter struct {
+	ID         string          `json:"id"`
+	RuleName   string          `json:"rule"`
+	ActionName string          `json:"action"`
+	ActionType int             `json:"action_type"`
+	Event      DeadLetterEvent `json:"event"`
+	// Last error
+	Error string `json:"error"`
+	// Number of failed executions, including the first one
+	Attempts  int   `json:"attempts"`
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+	// Next automatic retry as unix timestamp in milliseconds, 0 means
+	// no more automatic retries
+	NextRetryAt int64 `json:"next_retry_at,omitempty"`
+}
+
+// GetCreatedAtAsString returns the creation time formatted as string
+func (l *DeadLetter) GetCreatedAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(l.CreatedAt).UTC().Format("2006-01-02 15:04")
+}
+
+// GetNextRetryAsString returns the next automatic retry formatted as string
+func (l *DeadLetter) GetNextRetryAsString() string {
+	if l.NextRetryAt == 0 {
+		return ""
+	}
+	return util.GetTimeFromMsecSinceEpoch(l.NextRetryAt).UTC().Format("2006-01-02 15:04")
+}
+
+// GetEventPath returns the path or the object name for the event
+func (l *DeadLetter) GetEventPath() string {
+	if l.Event.VirtualPath != "" {
+		return l.Event.VirtualPath
+	}
+	return l.Event.ObjectName
+}
+
+func (l *DeadLetter) setNextRetry(now time.Time) {
+	// the first execution is not a retry
+	if Config.DeadLetters.MaxRetries <= 0 || l.Attempts > Config.DeadLetters.MaxRetries {
+		l.NextRetryAt = 0
+		return
+	}
+	l.NextRetryAt = util.GetTimeAsMsSinceEpoch(now.Add(Config.DeadLetters.getRetryDelay(l.Attempts)))
+}
+
+func (l *DeadLetter) isRetryDue(now int64) bool {
+	return l.NextRetryAt > 0 && l.NextRetryAt <= now
+}
+
+type deadLetterStore interface {
+	add(letter *DeadLetter) error
+	get(id string) (DeadLetter, error)
+	getAll() ([]DeadLetter, error)
+	remove(id string) error
+}
+
+func newDeadLetterManager(driver string) *deadLetterManager {
+	var store deadLetterStore
+	switch driver {
+	case dataprovider.MemoryDataProviderName, dataprovider.BoltDataProviderName:
+		store = &memoryDeadLetterStore{
+			letters: make(map[string]DeadLetter),
+		}
+	default:
+		store = &dbDeadLetterStore{}
+	}
+	return &deadLetterManager{
+		store: store,
+	}
+}
+
+type deadLetterManager struct {
+	// serializes the retries, the same dead letter must not be retried concurrently
+	mu    sync.Mutex
+	store deadLetterStore
+}
+
+func (m *deadLetterManager) add(ruleName string, action *dataprovider.BaseEventAction, params *EventParams, errAction error) {
+	if !Config.DeadLetters.Enabled || !util.Contains(deadLetterActionTypes, action.Type) {
+		return
+	}
+	// pre-* actions allow or deny an operation, retrying them later makes no sense
+	if strings.HasPrefix(params.Event, "pre-") {
+		return
+	}
+	now := time.Now()
+	letter := &DeadLetter{
+		ID:         xid.New().String(),
+		RuleName:   ruleName,
+		ActionName: action.Name,
+		ActionType: action.Type,
+		Event:      newDeadLetterEvent(params),
+		Error:      errAction.Error(),
+		Attempts:   1,
+		CreatedAt:  util.GetTimeAsMsSinceEpoch(now),
+		UpdatedAt:  util.GetTimeAsMsSinceEpoch(now),
+	}
+	letter.setNextRetry(now)
+	if err := m.store.add(letter); err != nil {
+		eventManagerLog(logger.LevelError, "unable to add dead letter for action %q, rule %q: %v",
+			action.Name, ruleName, err)
+		return
+	}
+	eventManagerLog(logger.LevelInfo, "action %q for rule %q added to the dead-letter queue, id %q",
+		action.Name, ruleName, letter.ID)
+}
+
+func (m *deadLetterManager) retry(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// reload the dead letter, it could be retried or removed while we wait for the lock
+	letter, err := m.store.get(id)
+	if err != nil {
+		return err
+	}
+	action, err := dataprovider.EventActionExists(letter.ActionName)
+	if err == nil {
+		params := letter.Event.getParams()
+		err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	}
+	if err == nil {
+		eventManagerLog(logger.LevelInfo, "dead letter %q, action %q, successfully retried after %d failed attempts",
+			letter.ID, letter.ActionName, letter.Attempts)
+		if errRemove := m.store.remove(letter.ID); errRemove != nil {
+			eventManagerLog(logger.LevelError, "unable to remove dead letter %q: %v", letter.ID, errRemove)
+		}
+		return nil
+	}
+	now := time.Now()
+	letter.Attempts++
+	letter.Error = err.Error()
+	letter.UpdatedAt = util.GetTimeAsMsSinceEpoch(now)
+	letter.setNextRetry(now)
+	eventManagerLog(logger.LevelWarn, "dead letter %q, action %q, retry failed, attempts %d, next retry at: %d, err: %v",
+		letter.ID, letter.ActionName, letter.Attempts, letter.NextRetryAt, err)
+	if errAdd := m.store.add(&letter); errAdd != nil {
+		eventManagerLog(logger.LevelError, "unable to update dead letter %q: %v", letter.ID, errAdd)
+	}
+	return err
+}
+
+func (m *deadLetterManager) retryDue() {
+	letters, err := m.store.getAll()
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to get dead letters: %v", err)
+		return
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for _, letter := range letters {
+		if letter.isRetryDue(now) {
+			m.retry(letter.ID) //nolint:errcheck
+		}
+	}
+}
+
+type memoryDeadLetterStore struct {
+	sync.RWMutex
+	letters map[string]DeadLetter
+}
+
+func (s *memoryDeadLetterStore) add(letter *DeadLetter) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.letters[letter.ID] = *letter
+	return nil
+}
+
+func (s *memoryDeadLetterStore) get(id string) (DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	letter, ok := s.letters[id]
+	if !ok {
+		return letter, util.NewRecordNotFoundError(fmt.Sprintf("dead letter %q not found", id))
+	}
+	return letter, nil
+}
+
+func (s *memoryDeadLetterStore) getAll() ([]DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].CreatedAt < letters[j].CreatedAt
+	})
+	return letters, nil
+}
+
+func (s *memoryDeadLetterStore) remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.letters[id]; !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("dead letter %q not found", id))
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+// dbDeadLetterStore persists the dead letters as shared sessions so they
+// survive restarts and are visible to all the SFTPGo instances
+type dbDeadLetterStore struct{}
+
+func (s *dbDeadLetterStore) add(letter *DeadLetter) error {
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       letter.ID,
+		Data:      letter,
+		Type:      dataprovider.SessionTypeDeadLetter,
+		Timestamp: letter.CreatedAt,
+	})
+}
+
+func (s *dbDeadLetterStore) get(id string) (DeadLetter, error) {
+	session, err := dataprovider.GetSharedSession(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DeadLetter{}, util.NewRecordNotFoundError(fmt.Sprintf("dead letter %q not found", id))
+		}
+		return DeadLetter{}, err
+	}
+	if session.Type != dataprovider.SessionTypeDeadLetter {
+		return DeadLetter{}, util.NewRecordNotFoundError(fmt.Sprintf("dead letter %q not found", id))
+	}
+	return s.decodeData(session.Data)
+}
+
+func (s *dbDeadLetterStore) getAll() ([]DeadLetter, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeDeadLetter)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(sessions))
+	for _, session := range sessions {
+		letter, err := s.decodeData(session.Data)
+		if err != nil {
+			eventManagerLog(logger.LevelError, "unable to decode dead letter %q: %v", session.Key, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (s *dbDeadLetterStore) remove(id string) error {
+	if _, err := s.get(id); err != nil {
+		return err
+	}
+	return dataprovider.DeleteSharedSession(id)
+}
+
+func (s *dbDeadLetterStore) decodeData(data any) (DeadLetter, error) {
+	var letter DeadLetter
+	val, ok := data.([]byte)
+	if !ok {
+		return letter, fmt.Errorf("invalid dead letter data type %T", data)
+	}
+	err := json.Unmarshal(val, &letter)
+	return letter, err
+}
+
+func checkDeadLetters() {
+	deadLetters.retryDue()
+}
+
+// GetDeadLetters returns the dead-lettered actions
+func GetDeadLetters() ([]DeadLetter, error) {
+	return deadLetters.store.getAll()
+}
+
+// GetDeadLetter returns the dead letter with the specified id
+func GetDeadLetter(id string) (DeadLetter, error) {
+	return deadLetters.store.get(id)
+}
+
+// RetryDeadLetter executes again the dead-lettered action with the specified id.
+// The dead letter is removed if the action succeeds
+func RetryDeadLetter(id string) error {
+	return deadLetters.retry(id)
+}
+
+// DeleteDeadLetter discards the dead letter with the specified id
+func DeleteDeadLetter(id string) error {
+	return deadLetters.store.remove(id)
+}
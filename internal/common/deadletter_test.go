@@ -0,0 +1,243 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestDeadLetterConfig(t *testing.T) {
+	c := DeadLetterConfig{
+		MaxRetries: -1,
+	}
+	assert.NoError(t, c.validate())
+	c.Enabled = true
+	assert.Error(t, c.validate())
+	c.MaxRetries = 3
+	assert.Error(t, c.validate())
+	c.RetryDelay = 60
+	assert.NoError(t, c.validate())
+	c.MaxRetries = 0
+	c.RetryDelay = 0
+	assert.NoError(t, c.validate())
+
+	c.RetryDelay = 60
+	assert.Equal(t, time.Minute, c.getRetryDelay(1))
+	assert.Equal(t, 2*time.Minute, c.getRetryDelay(2))
+	assert.Equal(t, 4*time.Minute, c.getRetryDelay(3))
+	assert.Equal(t, deadLetterMaxRetryDelay, c.getRetryDelay(20))
+}
+
+func TestDeadLetterEvent(t *testing.T) {
+	params := &EventParams{
+		Name:        "user",
+		Event:       operationDelete,
+		Status:      2,
+		VirtualPath: "/dir/file.txt",
+		FsPath:      "/tmp/user/dir/file.txt",
+		FileSize:    123,
+		Protocol:    ProtocolSFTP,
+		IP:          "127.0.0.1",
+		Timestamp:   time.Now().UnixNano(),
+		Object: &dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: "user",
+			},
+		},
+		sender: "user",
+	}
+	params.AddError(io.ErrUnexpectedEOF)
+	event := newDeadLetterEvent(params)
+	assert.NotEmpty(t, event.ObjectData)
+	copied := event.getParams()
+	assert.Equal(t, params.Name, copied.Name)
+	assert.Equal(t, params.Event, copied.Event)
+	assert.Equal(t, params.Status, copied.Status)
+	assert.Equal(t, params.VirtualPath, copied.VirtualPath)
+	assert.Equal(t, params.FsPath, copied.FsPath)
+	assert.Equal(t, params.FileSize, copied.FileSize)
+	assert.Equal(t, params.Protocol, copied.Protocol)
+	assert.Equal(t, params.IP, copied.IP)
+	assert.Equal(t, params.Timestamp, copied.Timestamp)
+	assert.Equal(t, params.sender, copied.sender)
+	assert.Equal(t, params.errors, copied.errors)
+	data, err := copied.Object.RenderAsJSON(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(event.ObjectData), data)
+
+	letter := DeadLetter{
+		Event: event,
+	}
+	assert.Equal(t, params.VirtualPath, letter.GetEventPath())
+	assert.Empty(t, letter.GetNextRetryAsString())
+	letter.Event.VirtualPath = ""
+	letter.Event.ObjectName = "obj"
+	assert.Equal(t, "obj", letter.GetEventPath())
+}
+
+func TestDeadLetters(t *testing.T) {
+	oldConfig := Config.DeadLetters
+	defer func() {
+		Config.DeadLetters = oldConfig
+	}()
+
+	var fail atomic.Bool
+	fail.Store(true)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	action := dataprovider.BaseEventAction{
+		Name: "dead letter action",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint: server.URL,
+				Timeout:  5,
+				Method:   http.MethodPost,
+				Body:     "{{Event}} {{VirtualPath}}",
+			},
+		},
+	}
+	err := dataprovider.AddEventAction(&action, "", "", "")
+	require.NoError(t, err)
+
+	params := &EventParams{
+		Name:        "user",
+		Event:       operationUpload,
+		VirtualPath: "/file.txt",
+		Timestamp:   time.Now().UnixNano(),
+	}
+	errAction := executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	require.Error(t, errAction)
+
+	for _, m := range []*deadLetterManager{deadLetters, newDeadLetterManager(dataprovider.MemoryDataProviderName)} {
+		fail.Store(true)
+		Config.DeadLetters = DeadLetterConfig{}
+		m.add("rule", &action, params, errAction)
+		letters, err := m.store.getAll()
+		assert.NoError(t, err)
+		assert.Len(t, letters, 0)
+
+		Config.DeadLetters = DeadLetterConfig{
+			Enabled:    true,
+			MaxRetries: 1,
+			RetryDelay: 60,
+		}
+		// unsupported action type
+		m.add("rule", &dataprovider.BaseEventAction{Type: dataprovider.ActionTypeBackup}, params, errAction)
+		// pre-* events are not dead-lettered
+		preParams := params.getACopy()
+		preParams.Event = OperationPreUpload
+		m.add("rule", &action, preParams, errAction)
+		letters, err = m.store.getAll()
+		assert.NoError(t, err)
+		assert.Len(t, letters, 0)
+
+		m.add("rule", &action, params, errAction)
+		letters, err = m.store.getAll()
+		assert.NoError(t, err)
+		require.Len(t, letters, 1)
+		letter := letters[0]
+		assert.Equal(t, "rule", letter.RuleName)
+		assert.Equal(t, action.Name, letter.ActionName)
+		assert.Equal(t, action.Type, letter.ActionType)
+		assert.Equal(t, params.VirtualPath, letter.Event.VirtualPath)
+		assert.Equal(t, 1, letter.Attempts)
+		assert.Contains(t, letter.Error, "unexpected status code")
+		assert.Greater(t, letter.NextRetryAt, letter.CreatedAt)
+		assert.NotEmpty(t, letter.GetCreatedAtAsString())
+		assert.NotEmpty(t, letter.GetNextRetryAsString())
+		// the retry is not due yet
+		numRequests := requests.Load()
+		m.retryDue()
+		assert.Equal(t, numRequests, requests.Load())
+		// force the retry
+		letter.NextRetryAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Second))
+		err = m.store.add(&letter)
+		assert.NoError(t, err)
+		m.retryDue()
+		assert.Equal(t, numRequests+1, requests.Load())
+		letter, err = m.store.get(letter.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, letter.Attempts)
+		// no more automatic retries
+		assert.Equal(t, int64(0), letter.NextRetryAt)
+		assert.Empty(t, letter.GetNextRetryAsString())
+		// manual retry
+		fail.Store(false)
+		err = m.retry(letter.ID)
+		assert.NoError(t, err)
+		_, err = m.store.get(letter.ID)
+		assert.ErrorIs(t, err, util.ErrNotFound)
+		err = m.retry(letter.ID)
+		assert.ErrorIs(t, err, util.ErrNotFound)
+		// add and remove
+		m.add("rule", &action, params, errAction)
+		letters, err = m.store.getAll()
+		assert.NoError(t, err)
+		require.Len(t, letters, 1)
+		err = m.store.remove(letters[0].ID)
+		assert.NoError(t, err)
+		err = m.store.remove(letters[0].ID)
+		assert.ErrorIs(t, err, util.ErrNotFound)
+	}
+	// exported functions
+	fail.Store(true)
+	deadLetters.add("rule", &action, params, errAction)
+	letters, err := GetDeadLetters()
+	assert.NoError(t, err)
+	require.Len(t, letters, 1)
+	letter, err := GetDeadLetter(letters[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, letters[0].ID, letter.ID)
+	err = RetryDeadLetter(letter.ID)
+	assert.Error(t, err)
+	checkDeadLetters()
+	err = DeleteDeadLetter(letter.ID)
+	assert.NoError(t, err)
+	_, err = GetDeadLetter(letter.ID)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	// the action no longer exists
+	deadLetters.add("rule", &action, params, errAction)
+	err = dataprovider.DeleteEventAction(action.Name, "", "", "")
+	assert.NoError(t, err)
+	letters, err = GetDeadLetters()
+	assert.NoError(t, err)
+	require.Len(t, letters, 1)
+	err = RetryDeadLetter(letters[0].ID)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	err = DeleteDeadLetter(letters[0].ID)
+	assert.NoError(t, err)
+}
@@ -19,6 +19,8 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
+	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
 // HostEvent is the enumerable for the supported host events
@@ -30,16 +32,20 @@ const (
 	HostEventUserNotFound
 	HostEventNoLoginTried
 	HostEventLimitExceeded
+	HostEventAnonymousAccess
 )
 
 // Supported defender drivers
 const (
 	DefenderDriverMemory   = "memory"
 	DefenderDriverProvider = "provider"
+	DefenderDriverCrowdSec = "crowdsec"
+	DefenderDriverRedis    = "redis"
 )
 
 var (
-	supportedDefenderDrivers = []string{DefenderDriverMemory, DefenderDriverProvider}
+	supportedDefenderDrivers = []string{DefenderDriverMemory, DefenderDriverProvider, DefenderDriverCrowdSec,
+		DefenderDriverRedis}
 )
 
 // Defender defines the interface that a defender must implements
@@ -58,10 +64,11 @@ type Defender interface {
 type DefenderConfig struct {
 	// Set to true to enable the defender
 	Enabled bool `json:"enabled" mapstructure:"enabled"`
-	// Defender implementation to use, we support "memory" and "provider".
-	// Using "provider" as driver you can share the defender events among
+	// Defender implementation to use, we support "memory", "provider", "crowdsec" and "redis".
+	// Using "provider" or "redis" as driver you can share the defender events among
 	// multiple SFTPGo instances. For a single instance "memory" provider will
-	// be much faster
+	// be much faster. Using "crowdsec" the host events are scored in memory and
+	// the ban decisions are shared using a CrowdSec Local API
 	Driver string `json:"driver" mapstructure:"driver"`
 	// BanTime is the number of minutes that a host is banned
 	BanTime int `json:"ban_time" mapstructure:"ban_time"`
@@ -79,6 +86,9 @@ type DefenderConfig struct {
 	// ScoreNoAuth defines the score for clients disconnected without authentication
 	// attempts
 	ScoreNoAuth int `json:"score_no_auth" mapstructure:"score_no_auth"`
+	// ScoreAnonymous defines the score for each anonymous access, it allows to
+	// ban hosts abusing anonymous users
+	ScoreAnonymous int `json:"score_anonymous" mapstructure:"score_anonymous"`
 	// Defines the time window, in minutes, for tracking client errors.
 	// A host is banned if it has exceeded the defined threshold during
 	// the last observation time minutes
@@ -86,9 +96,61 @@ type DefenderConfig struct {
 	// The number of banned IPs and host scores kept in memory will vary between the
 	// soft and hard limit for the "memory" driver. For the "provider" driver the
 	// soft limit is ignored and the hard limit is used to limit the number of entries
-	// to return when you request for the entire host list from the defender
+	// to return when you request for the entire host list from the defender.
+	// The same applies to the "redis" driver
 	EntriesSoftLimit int `json:"entries_soft_limit" mapstructure:"entries_soft_limit"`
 	EntriesHardLimit int `json:"entries_hard_limit" mapstructure:"entries_hard_limit"`
+	// CrowdSec defines the configuration for the "crowdsec" driver
+	CrowdSec CrowdSecConfig `json:"crowdsec" mapstructure:"crowdsec"`
+	// Redis defines the configuration for the "redis" driver
+	Redis RedisConfig `json:"redis" mapstructure:"redis"`
+	// GeoIPScore defines an additional score for events generated by hosts
+	// from the specified countries or autonomous systems
+	GeoIPScore DefenderGeoIPScore `json:"geoip_score" mapstructure:"geoip_score"`
+}
+
+// DefenderGeoIPScore defines an additional score for the events generated
+// by hosts from the specified countries or autonomous systems.
+// It requires GeoIP to be configured
+type DefenderGeoIPScore struct {
+	// ISO 3166-1 alpha-2 country codes
+	Countries []string `json:"countries" mapstructure:"countries"`
+	// Autonomous system numbers
+	ASNs []uint `json:"asns" mapstructure:"asns"`
+	// Score to add to the events with a score greater than zero.
+	// 0 means disabled
+	Score int `json:"score" mapstructure:"score"`
+}
+
+func (s *DefenderGeoIPScore) validate() error {
+	if s.Score < 0 {
+		s.Score = 0
+	}
+	countries, err := geoip.NormalizeCountries(s.Countries)
+	if err != nil {
+		return fmt.Errorf("invalid geoip_score countries: %w", err)
+	}
+	s.Countries = countries
+	s.ASNs = geoip.NormalizeASNs(s.ASNs)
+	return nil
+}
+
+func (s *DefenderGeoIPScore) isEnabled() bool {
+	return s.Score > 0 && (len(s.Countries) > 0 || len(s.ASNs) > 0)
+}
+
+func (s *DefenderGeoIPScore) getScore(ip string) int {
+	if !s.isEnabled() {
+		return 0
+	}
+	info := geoip.Lookup(ip)
+	if info.Country != "" && util.Contains(s.Countries, info.Country) {
+		return s.Score
+	}
+	if info.ASN > 0 && util.Contains(s.ASNs, info.ASN) {
+		return s.Score
+	}
+	return 0
 }
 
 type baseDefender struct {
@@ -116,7 +178,7 @@ func (d *baseDefender) IsSafe(ip, protocol string) bool {
 	return false
 }
 
-func (d *baseDefender) getScore(event HostEvent) int {
+func (d *baseDefender) getScore(ip string, event HostEvent) int {
 	var score int
 
 	switch event {
@@ -128,6 +190,11 @@ func (d *baseDefender) getScore(event HostEvent) int {
 		score = d.config.ScoreInvalid
 	case HostEventNoLoginTried:
 		score = d.config.ScoreNoAuth
+	case HostEventAnonymousAccess:
+		score = d.config.ScoreAnonymous
+	}
+	if score > 0 {
+		score += d.config.GeoIPScore.getScore(ip)
 	}
 	return score
 }
@@ -155,7 +222,11 @@ func (c *DefenderConfig) checkScores() error {
 	if c.ScoreNoAuth < 0 {
 		c.ScoreNoAuth = 0
 	}
-	if c.ScoreInvalid == 0 && c.ScoreValid == 0 && c.ScoreLimitExceeded == 0 && c.ScoreNoAuth == 0 {
+	if c.ScoreAnonymous < 0 {
+		c.ScoreAnonymous = 0
+	}
+	if c.ScoreInvalid == 0 && c.ScoreValid == 0 && c.ScoreLimitExceeded == 0 && c.ScoreNoAuth == 0 &&
+		c.ScoreAnonymous == 0 {
 		return fmt.Errorf("invalid defender configuration: all scores are disabled")
 	}
 	return nil
@@ -169,6 +240,9 @@ func (c *DefenderConfig) validate() error {
 	if err := c.checkScores(); err != nil {
 		return err
 	}
+	if err := c.GeoIPScore.validate(); err != nil {
+		return err
+	}
 	if c.ScoreInvalid >= c.Threshold {
 		return fmt.Errorf("score_invalid %d cannot be greater than threshold %d", c.ScoreInvalid, c.Threshold)
 	}
@@ -181,6 +255,9 @@ func (c *DefenderConfig) validate() error {
 	if c.ScoreNoAuth >= c.Threshold {
 		return fmt.Errorf("score_no_auth %d cannot be greater than threshold %d", c.ScoreNoAuth, c.Threshold)
 	}
+	if c.ScoreAnonymous >= c.Threshold {
+		return fmt.Errorf("score_anonymous %d cannot be greater than threshold %d", c.ScoreAnonymous, c.Threshold)
+	}
 	if c.BanTime <= 0 {
 		return fmt.Errorf("invalid ban_time %v", c.BanTime)
 	}
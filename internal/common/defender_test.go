@@ -462,6 +462,11 @@ func TestDefenderConfig(t *testing.T) {
 	require.Error(t, err)
 
 	c.ScoreNoAuth = 2
+	c.ScoreAnonymous = 10
+	err = c.validate()
+	require.Error(t, err)
+
+	c.ScoreAnonymous = 1
 	c.BanTime = 0
 	err = c.validate()
 	require.Error(t, err)
@@ -492,15 +497,42 @@ func TestDefenderConfig(t *testing.T) {
 	err = c.validate()
 	require.NoError(t, err)
 
+	c.GeoIPScore = DefenderGeoIPScore{
+		Countries: []string{"CHN"},
+		Score:     2,
+	}
+	err = c.validate()
+	require.Error(t, err)
+
+	c.GeoIPScore = DefenderGeoIPScore{
+		Countries: []string{"ru", "cn", "RU"},
+		ASNs:      []uint{3269, 0},
+		Score:     -1,
+	}
+	err = c.validate()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CN", "RU"}, c.GeoIPScore.Countries)
+	assert.Equal(t, []uint{3269}, c.GeoIPScore.ASNs)
+	assert.Equal(t, 0, c.GeoIPScore.Score)
+	assert.False(t, c.GeoIPScore.isEnabled())
+	c.GeoIPScore.Score = 2
+	assert.True(t, c.GeoIPScore.isEnabled())
+	// GeoIP is not configured, no additional score
+	assert.Equal(t, 0, c.GeoIPScore.getScore("1.1.1.1"))
+	d := baseDefender{config: &c}
+	assert.Equal(t, c.ScoreInvalid, d.getScore("1.1.1.1", HostEventUserNotFound))
+
 	c = DefenderConfig{
 		Enabled:            true,
 		ScoreInvalid:       -1,
 		ScoreLimitExceeded: -1,
 		ScoreNoAuth:        -1,
 		ScoreValid:         -1,
+		ScoreAnonymous:     -1,
 	}
 	err = c.validate()
 	require.Error(t, err)
+	assert.Equal(t, 0, c.ScoreAnonymous)
 	assert.Equal(t, 0, c.ScoreInvalid)
 	assert.Equal(t, 0, c.ScoreValid)
 	assert.Equal(t, 0, c.ScoreLimitExceeded)
@@ -0,0 +1,549 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	crowdSecDefaultScenario = "sftpgo/bruteforce"
+	crowdSecDecisionBan     = "ban"
+	crowdSecScopeIP         = "ip"
+	crowdSecScopeRange      = "range"
+	crowdSecMaxResponseSize = 10 * 1048576
+)
+
+// CrowdSecConfig defines the configuration for the "crowdsec" defender driver
+type CrowdSecConfig struct {
+	// CrowdSec Local API URL, for example http://127.0.0.1:8080
+	URL string `json:"url" mapstructure:"url"`
+	// Bouncer API key, it is used to consume the decisions from the Local API
+	APIKey string `json:"api_key" mapstructure:"api_key"`
+	// Machine ID and password, they are used to push the ban decisions
+	// generated by SFTPGo to the Local API. Leave empty to only consume
+	// the decisions
+	MachineID string `json:"machine_id" mapstructure:"machine_id"`
+	Password  string `json:"password" mapstructure:"password"`
+	// Scenario name to use for the pushed alerts
+	Scenario string `json:"scenario" mapstructure:"scenario"`
+	// Interval, in seconds, between two consecutive decisions updates
+	UpdateInterval int `json:"update_interval" mapstructure:"update_interval"`
+}
+
+// String returns a string representation of the configuration without the secrets
+func (c CrowdSecConfig) String() string {
+	return fmt.Sprintf("{URL:%s MachineID:%s Scenario:%s UpdateInterval:%d}", c.URL, c.MachineID,
+		c.Scenario, c.UpdateInterval)
+}
+
+func (c *CrowdSecConfig) canPushDecisions() bool {
+	return c.MachineID != ""
+}
+
+func (c *CrowdSecConfig) validate() error {
+	c.URL = strings.TrimSuffix(strings.TrimSpace(c.URL), "/")
+	if c.URL == "" {
+		return errors.New("crowdsec: the local API URL is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("crowdsec: invalid local API URL %q: %w", c.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("crowdsec: invalid local API URL %q, only http and https are supported", c.URL)
+	}
+	if c.APIKey == "" {
+		return errors.New("crowdsec: the bouncer API key is required")
+	}
+	if c.MachineID != "" && c.Password == "" {
+		return errors.New("crowdsec: the machine password is required")
+	}
+	if c.Scenario == "" {
+		c.Scenario = crowdSecDefaultScenario
+	}
+	if c.UpdateInterval <= 0 {
+		return fmt.Errorf("crowdsec: invalid update_interval %d", c.UpdateInterval)
+	}
+	return nil
+}
+
+type crowdSecDecision struct {
+	Duration string `json:"duration"`
+	Origin   string `json:"origin,omitempty"`
+	Scenario string `json:"scenario"`
+	Scope    string `json:"scope"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+}
+
+type crowdSecDecisionsStream struct {
+	New     []crowdSecDecision `json:"new"`
+	Deleted []crowdSecDecision `json:"deleted"`
+}
+
+type crowdSecSource struct {
+	Scope string `json:"scope"`
+	Value string `json:"value"`
+	IP    string `json:"ip"`
+}
+
+type crowdSecAlert struct {
+	Scenario        string             `json:"scenario"`
+	ScenarioHash    string             `json:"scenario_hash"`
+	ScenarioVersion string             `json:"scenario_version"`
+	Message         string             `json:"message"`
+	EventsCount     int                `json:"events_count"`
+	StartAt         string             `json:"start_at"`
+	StopAt          string             `json:"stop_at"`
+	Capacity        int                `json:"capacity"`
+	Leakspeed       string             `json:"leakspeed"`
+	Simulated       bool               `json:"simulated"`
+	Events          []any              `json:"events"`
+	Source          crowdSecSource     `json:"source"`
+	Decisions       []crowdSecDecision `json:"decisions"`
+}
+
+type crowdSecLoginResponse struct {
+	Expire string `json:"expire"`
+	Token  string `json:"token"`
+}
+
+type crowdSecRange struct {
+	network *net.IPNet
+	expires time.Time
+}
+
+// crowdSecDefender uses an in memory defender to score the local host events
+// and shares the resulting bans using the CrowdSec Local API.
+// The decisions received from the Local API are cached in memory and
+// periodically updated
+type crowdSecDefender struct {
+	*memoryDefender
+	crowdSec *CrowdSecConfig
+	// the first pull and the pulls after an error request the full decisions list
+	isSynced atomic.Bool
+	pullMu   sync.Mutex
+	mu       sync.RWMutex
+	ips      map[string]time.Time // the key is the IP address
+	ranges   map[string]crowdSecRange
+	tokenMu  sync.Mutex
+	token    string
+	expires  time.Time
+}
+
+func newCrowdSecDefender(config *DefenderConfig) (Defender, error) {
+	if err := config.CrowdSec.validate(); err != nil {
+		return nil, err
+	}
+	local, err := newInMemoryDefender(config)
+	if err != nil {
+		return nil, err
+	}
+	defender := &crowdSecDefender{
+		memoryDefender: local.(*memoryDefender),
+		crowdSec:       &config.CrowdSec,
+		ips:            make(map[string]time.Time),
+		ranges:         make(map[string]crowdSecRange),
+	}
+	defender.isSynced.Store(false)
+
+	return defender, nil
+}
+
+// GetHosts returns hosts that are banned or for which some violations have been detected
+func (d *crowdSecDefender) GetHosts() ([]dataprovider.DefenderEntry, error) {
+	result, err := d.memoryDefender.GetHosts()
+	if err != nil {
+		return result, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for ip, banTime := range d.ips {
+		if banTime.After(time.Now()) && !containsDefenderHost(result, ip) {
+			result = append(result, dataprovider.DefenderEntry{
+				IP:      ip,
+				BanTime: banTime,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// GetHost returns a defender host by ip, if any
+func (d *crowdSecDefender) GetHost(ip string) (dataprovider.DefenderEntry, error) {
+	if banTime := d.getDecisionBanTime(ip); banTime != nil {
+		return dataprovider.DefenderEntry{
+			IP:      ip,
+			BanTime: *banTime,
+		}, nil
+	}
+	return d.memoryDefender.GetHost(ip)
+}
+
+// IsBanned returns true if the specified IP is banned locally or by a CrowdSec decision.
+// IP addresses in the safe list are never banned
+func (d *crowdSecDefender) IsBanned(ip, protocol string) bool {
+	if d.memoryDefender.IsBanned(ip, protocol) {
+		return true
+	}
+	if d.IsSafe(ip, protocol) {
+		return false
+	}
+	return d.getDecisionBanTime(ip) != nil
+}
+
+// DeleteHost removes the specified IP from the defender lists.
+// The cached CrowdSec decisions are removed too, they must be deleted
+// from the Local API to avoid that they are pulled again
+func (d *crowdSecDefender) DeleteHost(ip string) bool {
+	d.mu.Lock()
+	_, ok := d.ips[ip]
+	delete(d.ips, ip)
+	d.mu.Unlock()
+
+	if d.memoryDefender.DeleteHost(ip) {
+		return true
+	}
+	return ok
+}
+
+// AddEvent adds an event for the given IP.
+// If the host is banned as result of this event the ban is pushed
+// to the CrowdSec Local API
+func (d *crowdSecDefender) AddEvent(ip, protocol string, event HostEvent) {
+	wasBanned := d.isLocallyBanned(ip)
+
+	d.memoryDefender.AddEvent(ip, protocol, event)
+
+	if wasBanned || !d.crowdSec.canPushDecisions() {
+		return
+	}
+	if banTime, _ := d.memoryDefender.GetBanTime(ip); banTime != nil && banTime.After(time.Now()) {
+		go d.pushBan(ip, *banTime)
+	}
+}
+
+// GetBanTime returns the ban time for the given IP or nil if the IP is not banned
+func (d *crowdSecDefender) GetBanTime(ip string) (*time.Time, error) {
+	if banTime := d.getDecisionBanTime(ip); banTime != nil {
+		return banTime, nil
+	}
+	return d.memoryDefender.GetBanTime(ip)
+}
+
+func (d *crowdSecDefender) isLocallyBanned(ip string) bool {
+	banTime, _ := d.memoryDefender.GetBanTime(ip)
+	return banTime != nil && banTime.After(time.Now())
+}
+
+func (d *crowdSecDefender) getDecisionBanTime(ip string) *time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	if banTime, ok := d.ips[ip]; ok && banTime.After(now) {
+		return &banTime
+	}
+	if len(d.ranges) == 0 {
+		return nil
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil
+	}
+	for _, r := range d.ranges {
+		if r.expires.After(now) && r.network.Contains(parsedIP) {
+			banTime := r.expires
+			return &banTime
+		}
+	}
+	return nil
+}
+
+func (d *crowdSecDefender) scheduleDecisionsUpdate() error {
+	spec := fmt.Sprintf("@every %ds", d.crowdSec.UpdateInterval)
+	_, err := eventScheduler.AddFunc(spec, d.pullDecisions)
+	if err != nil {
+		return fmt.Errorf("unable to schedule crowdsec decisions update: %w", err)
+	}
+	logger.Info(logSender, "", "scheduled crowdsec decisions update, schedule %q", spec)
+	go d.pullDecisions()
+	return nil
+}
+
+func (d *crowdSecDefender) pullDecisions() {
+	// skip this update if the previous one is still running
+	if !d.pullMu.TryLock() {
+		return
+	}
+	defer d.pullMu.Unlock()
+
+	startup := !d.isSynced.Load()
+	stream, err := d.getDecisionsStream(startup)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to pull crowdsec decisions: %v", err)
+		d.isSynced.Store(false)
+		return
+	}
+	d.updateDecisions(stream, startup)
+	d.isSynced.Store(true)
+}
+
+func (d *crowdSecDefender) updateDecisions(stream crowdSecDecisionsStream, startup bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if startup {
+		d.ips = make(map[string]time.Time)
+		d.ranges = make(map[string]crowdSecRange)
+	}
+	for _, decision := range stream.Deleted {
+		switch strings.ToLower(decision.Scope) {
+		case crowdSecScopeIP:
+			delete(d.ips, decision.Value)
+		case crowdSecScopeRange:
+			delete(d.ranges, decision.Value)
+		}
+	}
+	added := 0
+	for _, decision := range stream.New {
+		if !strings.EqualFold(decision.Type, crowdSecDecisionBan) {
+			continue
+		}
+		duration, err := time.ParseDuration(decision.Duration)
+		if err != nil || duration <= 0 {
+			logger.Debug(logSender, "", "ignoring crowdsec decision for %q, invalid duration %q",
+				decision.Value, decision.Duration)
+			continue
+		}
+		expires := now.Add(duration)
+		switch strings.ToLower(decision.Scope) {
+		case crowdSecScopeIP:
+			if net.ParseIP(decision.Value) == nil {
+				continue
+			}
+			if banTime, ok := d.ips[decision.Value]; !ok || banTime.Before(expires) {
+				d.ips[decision.Value] = expires
+			}
+			added++
+		case crowdSecScopeRange:
+			_, network, err := net.ParseCIDR(decision.Value)
+			if err != nil {
+				continue
+			}
+			d.ranges[decision.Value] = crowdSecRange{
+				network: network,
+				expires: expires,
+			}
+			added++
+		}
+	}
+	for ip, banTime := range d.ips {
+		if banTime.Before(now) {
+			delete(d.ips, ip)
+		}
+	}
+	for k, r := range d.ranges {
+		if r.expires.Before(now) {
+			delete(d.ranges, k)
+		}
+	}
+	logger.Debug(logSender, "", "crowdsec decisions updated, startup: %t, added: %d, deleted: %d, cached IPs: %d, ranges: %d",
+		startup, added, len(stream.Deleted), len(d.ips), len(d.ranges))
+}
+
+func (d *crowdSecDefender) getDecisionsStream(startup bool) (crowdSecDecisionsStream, error) {
+	var stream crowdSecDecisionsStream
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/decisions/stream?startup=%t", d.crowdSec.URL, startup), nil)
+	if err != nil {
+		return stream, err
+	}
+	req.Header.Set("X-Api-Key", d.crowdSec.APIKey)
+
+	err = d.doRequest(req, &stream)
+	return stream, err
+}
+
+func (d *crowdSecDefender) pushBan(ip string, banTime time.Time) {
+	now := time.Now()
+	duration := banTime.Sub(now).Round(time.Second)
+	if duration <= 0 {
+		return
+	}
+	ts := now.UTC().Format(time.RFC3339)
+	scope := "Ip"
+	alerts := []crowdSecAlert{
+		{
+			Scenario:        d.crowdSec.Scenario,
+			ScenarioHash:    "",
+			ScenarioVersion: version.Get().Version,
+			Message:         fmt.Sprintf("%s: IP %s banned by SFTPGo defender", d.crowdSec.Scenario, ip),
+			EventsCount:     1,
+			StartAt:         ts,
+			StopAt:          ts,
+			Leakspeed:       "0",
+			Events:          []any{},
+			Source: crowdSecSource{
+				Scope: scope,
+				Value: ip,
+				IP:    ip,
+			},
+			Decisions: []crowdSecDecision{
+				{
+					Duration: duration.String(),
+					Origin:   "sftpgo",
+					Scenario: d.crowdSec.Scenario,
+					Scope:    scope,
+					Type:     crowdSecDecisionBan,
+					Value:    ip,
+				},
+			},
+		},
+	}
+	if err := d.pushAlerts(alerts); err != nil {
+		logger.Warn(logSender, "", "unable to push the ban decision for IP %q to crowdsec: %v", ip, err)
+		return
+	}
+	logger.Debug(logSender, "", "ban decision for IP %q pushed to crowdsec, duration %s", ip, duration)
+}
+
+func (d *crowdSecDefender) pushAlerts(alerts []crowdSecAlert) error {
+	token, err := d.getToken()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.crowdSec.URL+"/v1/alerts", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	err = d.doRequest(req, nil)
+	var statusErr *crowdSecStatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusUnauthorized {
+		d.resetToken()
+	}
+	return err
+}
+
+func (d *crowdSecDefender) getToken() (string, error) {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.token != "" && d.expires.After(time.Now().Add(time.Minute)) {
+		return d.token, nil
+	}
+	body, err := json.Marshal(map[string]any{
+		"machine_id": d.crowdSec.MachineID,
+		"password":   d.crowdSec.Password,
+		"scenarios":  []string{d.crowdSec.Scenario},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.crowdSec.URL+"/v1/watchers/login", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp crowdSecLoginResponse
+	if err := d.doRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("login error: %w", err)
+	}
+	if resp.Token == "" {
+		return "", errors.New("login error: no token returned")
+	}
+	expires, err := time.Parse(time.RFC3339, resp.Expire)
+	if err != nil {
+		// the token is refreshed at the next push
+		expires = time.Now()
+	}
+	d.token = resp.Token
+	d.expires = expires
+	return d.token, nil
+}
+
+func (d *crowdSecDefender) resetToken() {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	d.token = ""
+	d.expires = time.Time{}
+}
+
+func (d *crowdSecDefender) doRequest(req *http.Request, result any) error {
+	req.Header.Set("User-Agent", "SFTPGo/"+version.Get().Version)
+	client := httpclient.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
+		return &crowdSecStatusError{code: resp.StatusCode}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, crowdSecMaxResponseSize)).Decode(result)
+}
+
+type crowdSecStatusError struct {
+	code int
+}
+
+func (e *crowdSecStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
+
+func containsDefenderHost(hosts []dataprovider.DefenderEntry, ip string) bool {
+	for idx := range hosts {
+		if hosts[idx].IP == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type crowdSecLAPIMock struct {
+	sync.Mutex
+	stream       crowdSecDecisionsStream
+	startupCalls int
+	logins       atomic.Int32
+	alerts       []crowdSecAlert
+	failStream   bool
+}
+
+func (m *crowdSecLAPIMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/decisions/stream":
+		if r.Header.Get("X-Api-Key") != "bouncer_key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if m.failStream {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("startup") == "true" {
+			m.startupCalls++
+		}
+		json.NewEncoder(w).Encode(m.stream) //nolint:errcheck
+		m.stream = crowdSecDecisionsStream{}
+	case "/v1/watchers/login":
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["password"] != "machine_pwd" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		m.logins.Add(1)
+		json.NewEncoder(w).Encode(crowdSecLoginResponse{ //nolint:errcheck
+			Expire: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			Token:  "jwt_token",
+		})
+	case "/v1/alerts":
+		if r.Header.Get("Authorization") != "Bearer jwt_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var alerts []crowdSecAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.alerts = append(m.alerts, alerts...)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `["1"]`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *crowdSecLAPIMock) getAlerts() []crowdSecAlert {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.alerts
+}
+
+func TestCrowdSecDefender(t *testing.T) {
+	mock := &crowdSecLAPIMock{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	config := &DefenderConfig{
+		Enabled:            true,
+		Driver:             DefenderDriverCrowdSec,
+		BanTime:            10,
+		BanTimeIncrement:   2,
+		Threshold:          5,
+		ScoreInvalid:       2,
+		ScoreValid:         1,
+		ScoreLimitExceeded: 3,
+		ObservationTime:    15,
+		EntriesSoftLimit:   1,
+		EntriesHardLimit:   2,
+		CrowdSec: CrowdSecConfig{
+			URL:            server.URL + "/",
+			APIKey:         "bouncer_key",
+			MachineID:      "sftpgo",
+			Password:       "machine_pwd",
+			UpdateInterval: 10,
+		},
+	}
+	d, err := newCrowdSecDefender(config)
+	require.NoError(t, err)
+	defender := d.(*crowdSecDefender)
+	assert.Equal(t, crowdSecDefaultScenario, defender.crowdSec.Scenario)
+	assert.Equal(t, server.URL, defender.crowdSec.URL)
+
+	mock.stream = crowdSecDecisionsStream{
+		New: []crowdSecDecision{
+			{
+				Duration: "1h",
+				Scope:    "Ip",
+				Type:     "ban",
+				Value:    "172.16.1.1",
+			},
+			{
+				Duration: "30m",
+				Scope:    "Range",
+				Type:     "ban",
+				Value:    "10.8.0.0/24",
+			},
+			{
+				Duration: "1h",
+				Scope:    "Ip",
+				Type:     "captcha",
+				Value:    "172.16.1.2",
+			},
+			{
+				Duration: "invalid",
+				Scope:    "Ip",
+				Type:     "ban",
+				Value:    "172.16.1.3",
+			},
+			{
+				Duration: "1h",
+				Scope:    "Ip",
+				Type:     "ban",
+				Value:    "not an ip",
+			},
+			{
+				Duration: "1h",
+				Scope:    "Country",
+				Type:     "ban",
+				Value:    "IT",
+			},
+		},
+	}
+	defender.pullDecisions()
+	assert.True(t, defender.isSynced.Load())
+	assert.Equal(t, 1, mock.startupCalls)
+	assert.True(t, defender.IsBanned("172.16.1.1", ProtocolSSH))
+	assert.True(t, defender.IsBanned("10.8.0.100", ProtocolFTP))
+	assert.False(t, defender.IsBanned("10.8.1.100", ProtocolFTP))
+	assert.False(t, defender.IsBanned("172.16.1.2", ProtocolSSH))
+	assert.False(t, defender.IsBanned("172.16.1.3", ProtocolSSH))
+	banTime, err := defender.GetBanTime("10.8.0.1")
+	assert.NoError(t, err)
+	assert.NotNil(t, banTime)
+	host, err := defender.GetHost("172.16.1.1")
+	assert.NoError(t, err)
+	assert.False(t, host.BanTime.IsZero())
+	hosts, err := defender.GetHosts()
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 1)
+	// the following pulls use the stream updates
+	mock.stream = crowdSecDecisionsStream{
+		Deleted: []crowdSecDecision{
+			{
+				Duration: "-1s",
+				Scope:    "Range",
+				Type:     "ban",
+				Value:    "10.8.0.0/24",
+			},
+		},
+	}
+	defender.pullDecisions()
+	assert.Equal(t, 1, mock.startupCalls)
+	assert.False(t, defender.IsBanned("10.8.0.100", ProtocolFTP))
+	assert.True(t, defender.IsBanned("172.16.1.1", ProtocolSSH))
+	// a failed pull forces a full sync
+	mock.failStream = true
+	defender.pullDecisions()
+	assert.False(t, defender.isSynced.Load())
+	assert.True(t, defender.IsBanned("172.16.1.1", ProtocolSSH))
+	mock.failStream = false
+	defender.pullDecisions()
+	assert.True(t, defender.isSynced.Load())
+	assert.Equal(t, 2, mock.startupCalls)
+	assert.False(t, defender.IsBanned("172.16.1.1", ProtocolSSH))
+	// local bans are pushed to the Local API
+	ip := "192.168.1.10"
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	assert.Len(t, mock.getAlerts(), 0)
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	assert.True(t, defender.IsBanned(ip, ProtocolSSH))
+	assert.Eventually(t, func() bool {
+		return len(mock.getAlerts()) == 1
+	}, 2*time.Second, 50*time.Millisecond)
+	alerts := mock.getAlerts()
+	assert.Equal(t, crowdSecDefaultScenario, alerts[0].Scenario)
+	assert.Equal(t, ip, alerts[0].Source.IP)
+	if assert.Len(t, alerts[0].Decisions, 1) {
+		assert.Equal(t, ip, alerts[0].Decisions[0].Value)
+		assert.Equal(t, crowdSecDecisionBan, alerts[0].Decisions[0].Type)
+		duration, err := time.ParseDuration(alerts[0].Decisions[0].Duration)
+		assert.NoError(t, err)
+		assert.Greater(t, duration, 9*time.Minute)
+	}
+	// events for already banned hosts are not pushed again
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	// the token is reused
+	defender.pushBan("192.168.1.11", time.Now().Add(time.Minute))
+	assert.Len(t, mock.getAlerts(), 2)
+	assert.Equal(t, int32(1), mock.logins.Load())
+	// expired bans are not pushed
+	defender.pushBan("192.168.1.12", time.Now().Add(-time.Minute))
+	assert.Len(t, mock.getAlerts(), 2)
+	// delete the cached decisions
+	mock.stream = crowdSecDecisionsStream{
+		New: []crowdSecDecision{
+			{
+				Duration: "1h",
+				Scope:    "ip",
+				Type:     "ban",
+				Value:    "172.16.1.5",
+			},
+		},
+	}
+	defender.pullDecisions()
+	assert.True(t, defender.IsBanned("172.16.1.5", ProtocolSSH))
+	assert.True(t, defender.DeleteHost("172.16.1.5"))
+	assert.False(t, defender.IsBanned("172.16.1.5", ProtocolSSH))
+	assert.False(t, defender.DeleteHost("172.16.1.5"))
+	assert.True(t, defender.DeleteHost(ip))
+	// invalid machine credentials
+	defender.resetToken()
+	defender.crowdSec.Password = "wrong"
+	err = defender.pushAlerts(nil)
+	assert.Error(t, err)
+	// unauthorized push resets the token
+	defender.token = "invalid"
+	defender.expires = time.Now().Add(time.Hour)
+	err = defender.pushAlerts(nil)
+	assert.Error(t, err)
+	assert.Empty(t, defender.token)
+}
+
+func TestCrowdSecDefenderOnlyConsume(t *testing.T) {
+	mock := &crowdSecLAPIMock{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	config := &DefenderConfig{
+		Enabled:            true,
+		Driver:             DefenderDriverCrowdSec,
+		BanTime:            10,
+		BanTimeIncrement:   2,
+		Threshold:          5,
+		ScoreInvalid:       2,
+		ScoreValid:         1,
+		ScoreLimitExceeded: 3,
+		ObservationTime:    15,
+		EntriesSoftLimit:   1,
+		EntriesHardLimit:   2,
+		CrowdSec: CrowdSecConfig{
+			URL:            server.URL,
+			APIKey:         "bouncer_key",
+			UpdateInterval: 10,
+		},
+	}
+	d, err := newCrowdSecDefender(config)
+	require.NoError(t, err)
+	defender := d.(*crowdSecDefender)
+	ip := "192.168.1.20"
+	for i := 0; i < 3; i++ {
+		defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	}
+	assert.True(t, defender.IsBanned(ip, ProtocolSSH))
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, mock.getAlerts(), 0)
+	assert.Equal(t, int32(0), mock.logins.Load())
+	// invalid API key
+	defender.crowdSec.APIKey = "wrong"
+	defender.pullDecisions()
+	assert.False(t, defender.isSynced.Load())
+}
+
+func TestCrowdSecConfig(t *testing.T) {
+	c := CrowdSecConfig{}
+	err := c.validate()
+	require.Error(t, err)
+	c.URL = "ftp://127.0.0.1:8080"
+	err = c.validate()
+	require.Error(t, err)
+	c.URL = "http://127.0.0.1:%2"
+	err = c.validate()
+	require.Error(t, err)
+	c.URL = "http://127.0.0.1:8080/"
+	err = c.validate()
+	require.Error(t, err)
+	c.APIKey = "key"
+	c.MachineID = "sftpgo"
+	err = c.validate()
+	require.Error(t, err)
+	c.Password = "pwd"
+	err = c.validate()
+	require.Error(t, err)
+	c.UpdateInterval = 10
+	err = c.validate()
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8080", c.URL)
+	assert.Equal(t, crowdSecDefaultScenario, c.Scenario)
+	assert.NotContains(t, fmt.Sprintf("%+v", DefenderConfig{CrowdSec: c}), c.Password)
+	assert.NotContains(t, fmt.Sprintf("%+v", DefenderConfig{CrowdSec: c}), c.APIKey)
+
+	config := &DefenderConfig{
+		Enabled: true,
+		Driver:  DefenderDriverCrowdSec,
+	}
+	_, err = newCrowdSecDefender(config)
+	require.Error(t, err)
+	config.CrowdSec = c
+	_, err = newCrowdSecDefender(config)
+	require.Error(t, err)
+}
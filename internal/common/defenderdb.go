@@ -94,7 +94,7 @@ func (d *dbDefender) AddEvent(ip, protocol string, event HostEvent) {
 		return
 	}
 
-	score := d.baseDefender.getScore(event)
+	score := d.baseDefender.getScore(ip, event)
 
 	host, err := dataprovider.AddDefenderEvent(ip, score, d.getStartObservationTime())
 	if err != nil {
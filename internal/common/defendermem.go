@@ -187,7 +187,7 @@ func (d *memoryDefender) AddEvent(ip, protocol string, event HostEvent) {
 		delete(d.banned, ip)
 	}
 
-	score := d.baseDefender.getScore(event)
+	score := d.baseDefender.getScore(ip, event)
 
 	ev := hostEvent{
 		dateTime: time.Now(),
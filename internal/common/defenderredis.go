@@ -0,0 +1,385 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	redisDefaultKeyPrefix = "sftpgo:defender:"
+	redisBanKey           = "ban:"
+	redisScoreKey         = "score:"
+	redisTimeout          = 10 * time.Second
+)
+
+// RedisConfig defines the configuration for the "redis" defender driver
+type RedisConfig struct {
+	// Redis server address as host:port
+	Address string `json:"address" mapstructure:"address"`
+	// Optional credentials
+	Username string `json:"username" mapstructure:"username"`
+	Password string `json:"password" mapstructure:"password"`
+	// Redis database to select
+	DB int `json:"db" mapstructure:"db"`
+	// Prefix for the keys, it allows to share a Redis server among multiple
+	// independent SFTPGo clusters
+	KeyPrefix string `json:"key_prefix" mapstructure:"key_prefix"`
+	// Set to true to connect using TLS
+	TLSEnabled bool `json:"tls_enabled" mapstructure:"tls_enabled"`
+}
+
+// String returns a string representation of the configuration without the secrets
+func (c RedisConfig) String() string {
+	return fmt.Sprintf("{Address:%s Username:%s DB:%d KeyPrefix:%s TLSEnabled:%t}", c.Address, c.Username,
+		c.DB, c.KeyPrefix, c.TLSEnabled)
+}
+
+func (c *RedisConfig) validate() error {
+	c.Address = strings.TrimSpace(c.Address)
+	if c.Address == "" {
+		return errors.New("redis: the server address is required")
+	}
+	if c.DB < 0 {
+		return fmt.Errorf("redis: invalid db %d", c.DB)
+	}
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = redisDefaultKeyPrefix
+	}
+	return nil
+}
+
+func (c *RedisConfig) getClient() *redis.Client {
+	opts := &redis.Options{
+		Addr:     c.Address,
+		Username: c.Username,
+		Password: c.Password,
+		DB:       c.DB,
+	}
+	if c.TLSEnabled {
+		opts.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	return redis.NewClient(opts)
+}
+
+// redisDefender stores the host scores and the banned hosts in Redis,
+// so they are shared among multiple SFTPGo instances.
+// Expired events and bans are automatically removed using the Redis TTLs
+type redisDefender struct {
+	baseDefender
+	client *redis.Client
+}
+
+func newRedisDefender(config *DefenderConfig) (Defender, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Redis.validate(); err != nil {
+		return nil, err
+	}
+	ipList, err := dataprovider.NewIPList(dataprovider.IPListTypeDefender)
+	if err != nil {
+		return nil, err
+	}
+	client := config.Redis.getClient()
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis: unable to connect to %q: %w", config.Redis.Address, err)
+	}
+	defender := &redisDefender{
+		baseDefender: baseDefender{
+			config: config,
+			ipList: ipList,
+		},
+		client: client,
+	}
+
+	return defender, nil
+}
+
+// GetHosts returns hosts that are banned or for which some violations have been detected
+func (d *redisDefender) GetHosts() ([]dataprovider.DefenderEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var result []dataprovider.DefenderEntry
+
+	bannedIPs, err := d.scanIPs(ctx, redisBanKey, d.config.EntriesHardLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range bannedIPs {
+		banTime, err := d.getBanTime(ctx, ip)
+		if err != nil || banTime == nil {
+			continue
+		}
+		result = append(result, dataprovider.DefenderEntry{
+			IP:      ip,
+			BanTime: *banTime,
+		})
+	}
+	if len(result) >= d.config.EntriesHardLimit {
+		return result, nil
+	}
+	scoredIPs, err := d.scanIPs(ctx, redisScoreKey, d.config.EntriesHardLimit-len(result))
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range scoredIPs {
+		score, err := d.getScore(ctx, ip)
+		if err != nil || score == 0 {
+			continue
+		}
+		result = append(result, dataprovider.DefenderEntry{
+			IP:    ip,
+			Score: score,
+		})
+	}
+
+	return result, nil
+}
+
+// GetHost returns a defender host by ip, if any
+func (d *redisDefender) GetHost(ip string) (dataprovider.DefenderEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	banTime, err := d.getBanTime(ctx, ip)
+	if err != nil {
+		return dataprovider.DefenderEntry{}, err
+	}
+	if banTime != nil {
+		return dataprovider.DefenderEntry{
+			IP:      ip,
+			BanTime: *banTime,
+		}, nil
+	}
+	score, err := d.getScore(ctx, ip)
+	if err != nil {
+		return dataprovider.DefenderEntry{}, err
+	}
+	if score > 0 {
+		return dataprovider.DefenderEntry{
+			IP:    ip,
+			Score: score,
+		}, nil
+	}
+
+	return dataprovider.DefenderEntry{}, util.NewRecordNotFoundError("host not found")
+}
+
+// IsBanned returns true if the specified IP is banned
+// and increase ban time if the IP is found.
+// This method must be called as soon as the client connects
+func (d *redisDefender) IsBanned(ip, protocol string) bool {
+	if d.baseDefender.isBanned(ip, protocol) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	banTime, err := d.getBanTime(ctx, ip)
+	if err != nil || banTime == nil {
+		// not found or another error, we allow this host
+		return false
+	}
+	increment := d.config.BanTime * d.config.BanTimeIncrement / 100
+	if increment == 0 {
+		increment++
+	}
+	// concurrent updates from multiple instances may lose an increment,
+	// this should not make much difference
+	newBanTime := banTime.Add(time.Duration(increment) * time.Minute)
+	err = d.client.SetArgs(ctx, d.getBanKey(ip), util.GetTimeAsMsSinceEpoch(newBanTime), redis.SetArgs{
+		Mode:     "XX",
+		ExpireAt: newBanTime,
+	}).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		logger.Warn(logSender, "", "unable to update the ban time for host %q: %v", ip, err)
+	}
+	return true
+}
+
+// DeleteHost removes the specified IP from the defender lists
+func (d *redisDefender) DeleteHost(ip string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	deleted, err := d.client.Del(ctx, d.getBanKey(ip), d.getScoreKey(ip)).Result()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to delete defender host %q: %v", ip, err)
+		return false
+	}
+	return deleted > 0
+}
+
+// AddEvent adds an event for the given IP.
+// This method must be called for clients not yet banned
+func (d *redisDefender) AddEvent(ip, protocol string, event HostEvent) {
+	if d.IsSafe(ip, protocol) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	// ignore events for already banned hosts
+	if banTime, err := d.getBanTime(ctx, ip); err != nil || banTime != nil {
+		return
+	}
+
+	score := d.baseDefender.getScore(ip, event)
+	now := time.Now()
+	key := d.getScoreKey(ip)
+	var members *redis.StringSliceCmd
+
+	_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(d.getStartObservationTime(now), 10))
+		pipe.ZAdd(ctx, key, redis.Z{
+			Score:  float64(util.GetTimeAsMsSinceEpoch(now)),
+			Member: fmt.Sprintf("%s:%d", util.GenerateUniqueID(), score),
+		})
+		members = pipe.ZRange(ctx, key, 0, -1)
+		pipe.PExpire(ctx, key, time.Duration(d.config.ObservationTime)*time.Minute)
+		return nil
+	})
+	if err != nil {
+		logger.Warn(logSender, "", "unable to add defender event for host %q: %v", ip, err)
+		return
+	}
+	if getRedisTotalScore(members.Val()) < d.config.Threshold {
+		return
+	}
+	banTime := now.Add(time.Duration(d.config.BanTime) * time.Minute)
+	// only the first instance that bans the host generates the event
+	isNew, err := d.client.SetNX(ctx, d.getBanKey(ip), util.GetTimeAsMsSinceEpoch(banTime),
+		time.Duration(d.config.BanTime)*time.Minute).Result()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to ban host %q: %v", ip, err)
+		return
+	}
+	d.client.Del(ctx, key)
+	if isNew {
+		eventManager.handleIPBlockedEvent(EventParams{
+			Event:     ipBlockedEventName,
+			IP:        ip,
+			Timestamp: time.Now().UnixNano(),
+			Status:    1,
+		})
+	}
+}
+
+// GetBanTime returns the ban time for the given IP or nil if the IP is not banned
+func (d *redisDefender) GetBanTime(ip string) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return d.getBanTime(ctx, ip)
+}
+
+// GetScore returns the score for the given IP
+func (d *redisDefender) GetScore(ip string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return d.getScore(ctx, ip)
+}
+
+func (d *redisDefender) getBanTime(ctx context.Context, ip string) (*time.Time, error) {
+	val, err := d.client.Get(ctx, d.getBanKey(ip)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	banTime := util.GetTimeFromMsecSinceEpoch(val)
+	if !banTime.After(time.Now()) {
+		return nil, nil
+	}
+	return &banTime, nil
+}
+
+func (d *redisDefender) getScore(ctx context.Context, ip string) (int, error) {
+	members, err := d.client.ZRangeByScore(ctx, d.getScoreKey(ip), &redis.ZRangeBy{
+		Min: strconv.FormatInt(d.getStartObservationTime(time.Now()), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	return getRedisTotalScore(members), nil
+}
+
+func (d *redisDefender) scanIPs(ctx context.Context, keyType string, limit int) ([]string, error) {
+	var result []string
+
+	prefix := d.config.Redis.KeyPrefix + keyType
+	iter := d.client.Scan(ctx, 0, prefix+"*", int64(limit)).Iterator()
+	for iter.Next(ctx) {
+		result = append(result, strings.TrimPrefix(iter.Val(), prefix))
+		if len(result) >= limit {
+			break
+		}
+	}
+	return result, iter.Err()
+}
+
+func (d *redisDefender) getStartObservationTime(now time.Time) int64 {
+	return util.GetTimeAsMsSinceEpoch(now.Add(-time.Duration(d.config.ObservationTime) * time.Minute))
+}
+
+func (d *redisDefender) getBanKey(ip string) string {
+	return d.config.Redis.KeyPrefix + redisBanKey + ip
+}
+
+func (d *redisDefender) getScoreKey(ip string) string {
+	return d.config.Redis.KeyPrefix + redisScoreKey + ip
+}
+
+// getRedisTotalScore returns the sum of the scores for the specified
+// sorted set members, each member has the format <unique id>:<score>
+func getRedisTotalScore(members []string) int {
+	total := 0
+	for _, member := range members {
+		idx := strings.LastIndex(member, ":")
+		if idx < 0 {
+			continue
+		}
+		score, err := strconv.Atoi(member[idx+1:])
+		if err == nil {
+			total += score
+		}
+	}
+	return total
+}
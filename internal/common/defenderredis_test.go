@@ -0,0 +1,246 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getRedisDefenderConfig(address string) *DefenderConfig {
+	return &DefenderConfig{
+		Enabled:            true,
+		Driver:             DefenderDriverRedis,
+		BanTime:            10,
+		BanTimeIncrement:   2,
+		Threshold:          5,
+		ScoreInvalid:       2,
+		ScoreValid:         1,
+		ScoreNoAuth:        2,
+		ScoreLimitExceeded: 3,
+		ObservationTime:    15,
+		EntriesSoftLimit:   1,
+		EntriesHardLimit:   10,
+		Redis: RedisConfig{
+			Address: address,
+		},
+	}
+}
+
+func TestBasicRedisDefender(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	entries := []dataprovider.IPListEntry{
+		{
+			IPOrNet: "172.16.1.1/32",
+			Type:    dataprovider.IPListTypeDefender,
+			Mode:    dataprovider.ListModeDeny,
+		},
+		{
+			IPOrNet: "192.168.1.3/32",
+			Type:    dataprovider.IPListTypeDefender,
+			Mode:    dataprovider.ListModeAllow,
+		},
+	}
+	for idx := range entries {
+		e := entries[idx]
+		err := dataprovider.AddIPListEntry(&e, "", "", "")
+		assert.NoError(t, err)
+	}
+
+	config := getRedisDefenderConfig(server.Addr())
+	d, err := newRedisDefender(config)
+	require.NoError(t, err)
+	assert.Equal(t, redisDefaultKeyPrefix, config.Redis.KeyPrefix)
+	defender := d.(*redisDefender)
+
+	assert.True(t, defender.IsBanned("172.16.1.1", ProtocolSSH))
+	assert.False(t, defender.IsBanned("172.16.1.10", ProtocolSSH))
+	assert.True(t, defender.IsSafe("192.168.1.3", ProtocolFTP))
+	// events for safe hosts are ignored
+	defender.AddEvent("192.168.1.3", ProtocolFTP, HostEventUserNotFound)
+	score, err := defender.GetScore("192.168.1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, score)
+
+	ip := "192.168.1.10"
+	defender.AddEvent(ip, ProtocolSSH, HostEventLoginFailed)
+	defender.AddEvent(ip, ProtocolSSH, HostEventNoLoginTried)
+	score, err = defender.GetScore(ip)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, score)
+	host, err := defender.GetHost(ip)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, host.Score)
+	assert.True(t, host.BanTime.IsZero())
+	banTime, err := defender.GetBanTime(ip)
+	assert.NoError(t, err)
+	assert.Nil(t, banTime)
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	// the score key expires after the observation time
+	ttl := server.TTL(redisDefaultKeyPrefix + redisScoreKey + ip)
+	assert.Equal(t, 15*time.Minute, ttl)
+
+	// a second instance shares the same state
+	d1, err := newRedisDefender(getRedisDefenderConfig(server.Addr()))
+	require.NoError(t, err)
+	d1.AddEvent(ip, ProtocolFTP, HostEventLimitExceeded)
+	assert.True(t, defender.IsBanned(ip, ProtocolSSH))
+	assert.True(t, d1.IsBanned(ip, ProtocolFTP))
+	assert.False(t, server.Exists(redisDefaultKeyPrefix+redisScoreKey+ip))
+	banTime, err = d1.GetBanTime(ip)
+	assert.NoError(t, err)
+	if assert.NotNil(t, banTime) {
+		// the ban time was incremented
+		assert.Greater(t, time.Until(*banTime), 10*time.Minute)
+	}
+	host, err = defender.GetHost(ip)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, host.Score)
+	assert.False(t, host.BanTime.IsZero())
+	// events for banned hosts are ignored
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	assert.False(t, server.Exists(redisDefaultKeyPrefix+redisScoreKey+ip))
+
+	defender.AddEvent("192.168.1.11", ProtocolSSH, HostEventUserNotFound)
+	hosts, err := defender.GetHosts()
+	assert.NoError(t, err)
+	if assert.Len(t, hosts, 2) {
+		for _, h := range hosts {
+			switch h.IP {
+			case ip:
+				assert.False(t, h.BanTime.IsZero())
+			case "192.168.1.11":
+				assert.Equal(t, 2, h.Score)
+			default:
+				t.Errorf("unexpected host %q", h.IP)
+			}
+		}
+	}
+	config.EntriesHardLimit = 1
+	hosts, err = defender.GetHosts()
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 1)
+	config.EntriesHardLimit = 10
+
+	// the ban expires
+	server.FastForward(30 * time.Minute)
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	_, err = defender.GetHost(ip)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	for i := 0; i < 3; i++ {
+		defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	}
+	assert.True(t, defender.IsBanned(ip, ProtocolSSH))
+	assert.True(t, defender.DeleteHost(ip))
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	assert.False(t, defender.DeleteHost(ip))
+	// the score expired too
+	assert.False(t, defender.DeleteHost("192.168.1.11"))
+
+	// expired ban stored without TTL
+	err = server.Set(redisDefaultKeyPrefix+redisBanKey+ip,
+		fmt.Sprintf("%d", util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Minute))))
+	assert.NoError(t, err)
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	// invalid ban value
+	err = server.Set(redisDefaultKeyPrefix+redisBanKey+ip, "invalid")
+	assert.NoError(t, err)
+	assert.False(t, defender.IsBanned(ip, ProtocolSSH))
+	_, err = defender.GetHost(ip)
+	assert.Error(t, err)
+	hosts, err = defender.GetHosts()
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 0)
+	// the event is ignored if the ban time cannot be checked
+	defender.AddEvent(ip, ProtocolSSH, HostEventUserNotFound)
+	assert.False(t, server.Exists(redisDefaultKeyPrefix+redisScoreKey+ip))
+	assert.True(t, defender.DeleteHost(ip))
+	// invalid score member
+	_, err = server.ZAdd(redisDefaultKeyPrefix+redisScoreKey+ip, float64(util.GetTimeAsMsSinceEpoch(time.Now())), "invalid")
+	assert.NoError(t, err)
+	score, err = defender.GetScore(ip)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, score)
+	assert.Equal(t, 5, getRedisTotalScore([]string{"a:2", "b:3", "c:x", "d"}))
+
+	// Redis errors
+	server.Close()
+	assert.False(t, defender.IsBanned("192.168.1.12", ProtocolSSH))
+	defender.AddEvent("192.168.1.12", ProtocolSSH, HostEventUserNotFound)
+	_, err = defender.GetHosts()
+	assert.Error(t, err)
+	_, err = defender.GetHost("192.168.1.12")
+	assert.Error(t, err)
+	_, err = defender.GetScore("192.168.1.12")
+	assert.Error(t, err)
+	_, err = defender.GetBanTime("192.168.1.12")
+	assert.Error(t, err)
+	assert.False(t, defender.DeleteHost("192.168.1.12"))
+
+	for _, e := range entries {
+		err := dataprovider.DeleteIPListEntry(e.IPOrNet, e.Type, "", "", "")
+		assert.NoError(t, err)
+	}
+}
+
+func TestRedisDefenderConfig(t *testing.T) {
+	c := RedisConfig{}
+	err := c.validate()
+	require.Error(t, err)
+	c.Address = "127.0.0.1:6379"
+	c.DB = -1
+	err = c.validate()
+	require.Error(t, err)
+	c.DB = 1
+	c.KeyPrefix = "cluster1:"
+	c.Password = "redis_secret"
+	err = c.validate()
+	require.NoError(t, err)
+	assert.Equal(t, "cluster1:", c.KeyPrefix)
+	assert.NotContains(t, fmt.Sprintf("%+v", DefenderConfig{Redis: c}), c.Password)
+	c.TLSEnabled = true
+	client := c.getClient()
+	assert.NotNil(t, client.Options().TLSConfig)
+	assert.NoError(t, client.Close())
+
+	config := getRedisDefenderConfig("")
+	_, err = newRedisDefender(config)
+	require.Error(t, err)
+	config.Threshold = 0
+	_, err = newRedisDefender(config)
+	require.Error(t, err)
+
+	server := miniredis.RunT(t)
+	server.RequireAuth("redis_secret")
+	config = getRedisDefenderConfig(server.Addr())
+	_, err = newRedisDefender(config)
+	require.Error(t, err)
+	config.Redis.Password = "redis_secret"
+	config.Redis.KeyPrefix = "cluster1:"
+	d, err := newRedisDefender(config)
+	require.NoError(t, err)
+	d.AddEvent("192.168.2.1", ProtocolSSH, HostEventUserNotFound)
+	assert.True(t, server.Exists("cluster1:"+redisScoreKey+"192.168.2.1"))
+}
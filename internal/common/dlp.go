@@ -0,0 +1,144 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dlp"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// inspectFile reads the specified file from the storage backend and checks
+// it against the given DLP policies
+func inspectFile(fs vfs.Fs, fsPath string, policies []string, metadata dlp.Metadata) (*dlp.Result, error) {
+	reader, cancelFn, err := openFileForScan(fs, fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		reader.Close()
+		if cancelFn != nil {
+			cancelFn()
+		}
+	}()
+
+	return Config.dlpInspector.Inspect(reader, policies, metadata)
+}
+
+// setDLPMetadata stores the violated policies with the tag action as metadata
+// for the specified file, if supported by the storage backend
+func setDLPMetadata(fs vfs.Fs, fsPath string, result *dlp.Result) {
+	setter, ok := fs.(vfs.FsMetadataSetter)
+	if !ok {
+		return
+	}
+	metadata := map[string]string{
+		"dlp-status":   "violation",
+		"dlp-policies": strings.Join(result.GetPolicies(dlp.ActionTag), " "),
+	}
+	if err := setter.SetMetadata(fsPath, metadata); err != nil {
+		logger.Debug(logSender, "", "unable to store the DLP metadata for %q: %v", fsPath, err)
+	}
+}
+
+// inspectUploadOnClose checks the upload against the DLP policies assigned
+// to the user before completing it
+func (t *BaseTransfer) inspectUploadOnClose() {
+	if Config.dlpInspector == nil || t.ErrTransfer != nil || t.isUploadContentDenied() ||
+		t.isUploadRejectedByAntivirus() {
+		return
+	}
+	policies := Config.dlpInspector.GetPolicies(t.Connection.User.Filters.DLPPolicies)
+	if len(policies) == 0 {
+		return
+	}
+	info, err := t.Fs.Stat(t.effectiveFsPath)
+	if err == nil {
+		if !Config.dlpInspector.IsSizeAllowed(info.Size()) {
+			t.Connection.Log(logger.LevelDebug, "upload %q not inspected, size %d exceeds the DLP limit",
+				t.requestPath, info.Size())
+			return
+		}
+		t.dlpResult, err = inspectFile(t.Fs, t.effectiveFsPath, policies, dlp.Metadata{
+			Username:    t.Connection.User.Username,
+			VirtualPath: t.requestPath,
+			Protocol:    t.Connection.protocol,
+		})
+	}
+	if err != nil {
+		t.Connection.Log(logger.LevelWarn, "unable to inspect upload %q: %v", t.requestPath, err)
+		if Config.dlpInspector.RejectOnError() && Config.dlpInspector.HasAction(policies, dlp.ActionBlock) {
+			t.dlpBlocked = true
+			t.TransferError(t.Connection.GetGenericError(fmt.Errorf("unable to inspect the uploaded file: %w", err)))
+			return
+		}
+	}
+	if t.dlpResult != nil && t.dlpResult.HasAction(dlp.ActionBlock) {
+		t.Connection.Log(logger.LevelWarn, "upload %q blocked, DLP violations: %s", t.requestPath,
+			t.dlpResult.Describe(dlp.ActionBlock))
+		t.dlpBlocked = true
+		t.TransferError(t.Connection.GetPermissionDeniedError())
+	}
+}
+
+func (t *BaseTransfer) isUploadBlockedByDLP() bool {
+	return t.dlpBlocked
+}
+
+// removeBlockedUpload removes the upload blocked by a DLP policy
+func (t *BaseTransfer) removeBlockedUpload() error {
+	var size int64
+	if info, err := t.Fs.Stat(t.effectiveFsPath); err == nil {
+		size = info.Size()
+	}
+	err := t.Fs.Remove(t.effectiveFsPath, false)
+	if err == nil {
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	}
+	t.Connection.Log(logger.LevelWarn, "upload blocked by a DLP policy, delete file: %q, deletion error: %v",
+		t.effectiveFsPath, err)
+	t.notifyDLPViolation(size)
+	return err
+}
+
+// handleDLPOnUploadDone tags the completed upload and executes the
+// notifications for the violated policies, if any
+func (t *BaseTransfer) handleDLPOnUploadDone(fileSize int64) {
+	if t.dlpResult == nil || len(t.dlpResult.Violations) == 0 || t.ErrTransfer != nil {
+		return
+	}
+	t.Connection.Log(logger.LevelInfo, "upload %q completed with DLP violations: %s", t.requestPath,
+		t.dlpResult.Describe(dlp.ActionTag))
+	if t.dlpResult.HasAction(dlp.ActionTag) {
+		setDLPMetadata(t.Fs, t.fsPath, t.dlpResult)
+	}
+	t.notifyDLPViolation(fileSize)
+}
+
+// notifyDLPViolation executes the actions defined for the "dlp-violation" event
+// if at least a violated policy has the notify action
+func (t *BaseTransfer) notifyDLPViolation(fileSize int64) {
+	if t.dlpResult == nil || !t.dlpResult.HasAction(dlp.ActionNotify) {
+		return
+	}
+	ExecuteActionNotification(t.Connection, operationDLPViolation, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
+		fileSize, fmt.Errorf("%w: %s", ErrDLPViolation, t.dlpResult.Describe(dlp.ActionNotify)),
+		time.Since(t.start).Milliseconds())
+}
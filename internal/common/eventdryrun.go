@@ -0,0 +1,394 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported statuses for dry run action traces
+const (
+	// the action was rendered but not executed
+	DryRunStatusSimulated = "simulated"
+	// the action was executed successfully
+	DryRunStatusSuccess = stepStatusSuccess
+	// the action was executed and failed
+	DryRunStatusFailure = stepStatusFailure
+	// the action was not executed, for example because its dependency is not satisfied
+	DryRunStatusSkipped = stepStatusSkipped
+)
+
+// EventDryRunRequest defines the event to use to test a rule or an action
+type EventDryRunRequest struct {
+	// Synthetic event, ignored if a dead letter is specified
+	Event DeadLetterEvent `json:"event"`
+	// Optional dead letter ID, the event stored for the dead-lettered action is used
+	DeadLetterID string `json:"dead_letter_id,omitempty"`
+	// If enabled HTTP actions are executed and the response is included in the trace.
+	// The other actions are never executed
+	ExecuteHTTP bool `json:"execute_http,omitempty"`
+}
+
+func (r *EventDryRunRequest) getParams() (*EventParams, error) {
+	event := r.Event
+	if r.DeadLetterID != "" {
+		letter, err := GetDeadLetter(r.DeadLetterID)
+		if err != nil {
+			return nil, err
+		}
+		event = letter.Event
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixNano()
+	}
+	if event.Sender == "" {
+		event.Sender = event.ObjectName
+		if event.VirtualPath != "" {
+			event.Sender = event.Name
+		}
+	}
+	return event.getParams(), nil
+}
+
+// EventActionTrace defines the result of an action dry run
+type EventActionTrace struct {
+	Name   string `json:"name"`
+	Type   int    `json:"type"`
+	Status string `json:"status"`
+	Info   string `json:"info,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Rendered fields, for example the HTTP endpoint and body
+	Rendered []dataprovider.KeyValue `json:"rendered,omitempty"`
+	// Output for executed actions, for example the HTTP response body
+	Output string `json:"output,omitempty"`
+}
+
+func (t *EventActionTrace) addRendered(key, value string) {
+	t.Rendered = append(t.Rendered, dataprovider.KeyValue{
+		Key:   key,
+		Value: value,
+	})
+}
+
+func (t *EventActionTrace) setError(err error) {
+	if err != nil {
+		t.Status = DryRunStatusFailure
+		t.Error = err.Error()
+	}
+}
+
+// EventRuleTrace defines the result of a rule dry run
+type EventRuleTrace struct {
+	Rule    string             `json:"rule"`
+	Matched bool               `json:"matched"`
+	Info    string             `json:"info,omitempty"`
+	Actions []EventActionTrace `json:"actions,omitempty"`
+}
+
+func getDryRunReplacer(params *EventParams) *strings.Replacer {
+	return strings.NewReplacer(params.getStringReplacements(params.Object != nil)...)
+}
+
+func traceHTTPAction(c dataprovider.EventActionHTTPConfig, params *EventParams, trace *EventActionTrace) error {
+	replacer := getDryRunReplacer(params)
+	endpoint, err := getHTTPRuleActionEndpoint(c, replacer)
+	if err != nil {
+		return err
+	}
+	trace.addRendered("method", c.Method)
+	trace.addRendered("endpoint", endpoint)
+	if c.Username != "" {
+		trace.addRendered("username", replaceWithReplacer(c.Username, replacer))
+	}
+	for _, keyVal := range c.Headers {
+		trace.addRendered(fmt.Sprintf("header %s", keyVal.Key), replaceWithReplacer(keyVal.Value, replacer))
+	}
+	if c.Method == http.MethodGet {
+		return nil
+	}
+	switch {
+	case c.Body == dataprovider.RetentionReportPlaceHolder:
+		trace.addRendered("body", c.Body)
+	case c.Body != "" && c.TextTemplate:
+		body, err := renderEventTemplate("HTTP body", c.Body, params.getTemplateData())
+		if err != nil {
+			return err
+		}
+		trace.addRendered("body", body)
+	case c.Body != "":
+		trace.addRendered("body", replaceWithReplacer(c.Body, replacer))
+	}
+	for _, part := range c.Parts {
+		if part.Filepath != "" {
+			trace.addRendered(fmt.Sprintf("part %s file", part.Name), replacePathsPlaceholders([]string{part.Filepath}, replacer)[0])
+		} else {
+			trace.addRendered(fmt.Sprintf("part %s body", part.Name), replaceWithReplacer(part.Body, replacer))
+		}
+	}
+	return nil
+}
+
+func traceCommandAction(c dataprovider.EventActionCommandConfig, params *EventParams, trace *EventActionTrace) error {
+	replacer := getDryRunReplacer(params)
+	trace.addRendered("command", c.Cmd)
+	for _, arg := range c.Args {
+		trace.addRendered("argument", replaceWithReplacer(arg, replacer))
+	}
+	env, err := getCommandRuleActionEnv(c, replacer, params)
+	if err != nil {
+		return err
+	}
+	for _, val := range env {
+		trace.addRendered("env", val)
+	}
+	return nil
+}
+
+func traceEmailAction(c dataprovider.EventActionEmailConfig, params *EventParams, trace *EventActionTrace) error {
+	replacer := getDryRunReplacer(params)
+	for _, recipient := range c.Recipients {
+		trace.addRendered("recipient", replaceWithReplacer(recipient, replacer))
+	}
+	body, subject, err := getEmailRuleActionContent(c, replacer, params)
+	if err != nil {
+		return err
+	}
+	trace.addRendered("subject", subject)
+	trace.addRendered("body", body)
+	for _, attachment := range c.Attachments {
+		if attachment != dataprovider.RetentionReportPlaceHolder {
+			attachment = replacePathsPlaceholders([]string{attachment}, replacer)[0]
+		}
+		trace.addRendered("attachment", attachment)
+	}
+	return nil
+}
+
+func traceFsAction(c dataprovider.EventActionFilesystemConfig, params *EventParams, trace *EventActionTrace) {
+	replacer := getDryRunReplacer(params)
+	addKeyValues := func(name string, items []dataprovider.KeyValue) {
+		for _, item := range items {
+			trace.addRendered(name, fmt.Sprintf("%s -> %s", util.CleanPath(replaceWithReplacer(item.Key, replacer)),
+				util.CleanPath(replaceWithReplacer(item.Value, replacer))))
+		}
+	}
+	addPaths := func(name string, paths []string) {
+		for _, p := range replacePathsPlaceholders(paths, replacer) {
+			trace.addRendered(name, p)
+		}
+	}
+	switch c.Type {
+	case dataprovider.FilesystemActionRename:
+		addKeyValues("rename", c.Renames)
+	case dataprovider.FilesystemActionCopy:
+		addKeyValues("copy", c.Copy)
+	case dataprovider.FilesystemActionDelete:
+		addPaths("delete", c.Deletes)
+	case dataprovider.FilesystemActionMkdirs:
+		addPaths("mkdir", c.MkDirs)
+	case dataprovider.FilesystemActionExist:
+		addPaths("exist", c.Exist)
+	case dataprovider.FilesystemActionCompress:
+		addPaths("archive", []string{c.Compress.Name})
+		addPaths("compress", c.Compress.Paths)
+	}
+}
+
+// dryRunAction renders the specified action. Only HTTP actions are executed,
+// if requested, the other actions could have side effects
+func dryRunAction(action dataprovider.BaseEventAction, params *EventParams, executeHTTP bool) EventActionTrace {
+	trace := EventActionTrace{
+		Name:   action.Name,
+		Type:   action.Type,
+		Status: DryRunStatusSimulated,
+	}
+	switch action.Type {
+	case dataprovider.ActionTypeHTTP:
+		if err := traceHTTPAction(action.Options.HTTPConfig, params, &trace); err != nil {
+			trace.setError(err)
+			return trace
+		}
+		if executeHTTP {
+			trace.Status = DryRunStatusSuccess
+			trace.setError(executeHTTPRuleAction(action.Options.HTTPConfig, params))
+			trace.Output = params.stepOutput
+		}
+	case dataprovider.ActionTypeCommand:
+		trace.setError(traceCommandAction(action.Options.CmdConfig, params, &trace))
+	case dataprovider.ActionTypeEmail:
+		trace.setError(traceEmailAction(action.Options.EmailConfig, params, &trace))
+	case dataprovider.ActionTypeMessageBroker:
+		replacer := getDryRunReplacer(params)
+		trace.addRendered("topic", replaceWithReplacer(action.Options.BrokerConfig.Topic, replacer))
+		if action.Options.BrokerConfig.RoutingKey != "" {
+			trace.addRendered("routing key", replaceWithReplacer(action.Options.BrokerConfig.RoutingKey, replacer))
+		}
+		trace.addRendered("body", replaceWithReplacer(action.Options.BrokerConfig.Body, replacer))
+	case dataprovider.ActionTypeCloudFunction:
+		replacer := getDryRunReplacer(params)
+		trace.addRendered("function", action.Options.FunctionConfig.Function)
+		trace.addRendered("body", replaceWithReplacer(action.Options.FunctionConfig.Body, replacer))
+	case dataprovider.ActionTypeFilesystem:
+		traceFsAction(action.Options.FsConfig, params, &trace)
+	case dataprovider.ActionTypePush:
+		targetPath := params.VirtualPath
+		if action.Options.PushConfig.TargetPath != "" {
+			targetPath = util.CleanPath(replaceWithReplacer(action.Options.PushConfig.TargetPath, getDryRunReplacer(params)))
+		}
+		trace.addRendered("endpoint", action.Options.PushConfig.Endpoint)
+		trace.addRendered("target path", targetPath)
+	default:
+		trace.Info = "this action type is not rendered"
+	}
+	return trace
+}
+
+// checkRuleMatchForDryRun returns an empty string if the specified params
+// match the rule conditions, otherwise the reason for the mismatch
+func checkRuleMatchForDryRun(rule *dataprovider.EventRule, params *EventParams) string {
+	switch rule.Trigger {
+	case dataprovider.EventTriggerFsEvent:
+		if !eventManager.checkFsEventMatch(rule.Conditions, *params) {
+			return "the event does not match the rule conditions"
+		}
+		if len(filterRulesByFileContent([]dataprovider.EventRule{*rule}, params)) == 0 {
+			return "the file content does not match the rule conditions"
+		}
+	case dataprovider.EventTriggerProviderEvent:
+		if !eventManager.checkProviderEventMatch(rule.Conditions, *params) {
+			return "the event does not match the rule conditions"
+		}
+	case dataprovider.EventTriggerQuotaThreshold:
+		if !eventManager.checkQuotaThresholdMatch(rule.Conditions, *params) {
+			return "the event does not match the rule conditions"
+		}
+		params.QuotaThreshold = rule.Conditions.QuotaThreshold
+	case dataprovider.EventTriggerCredentialsRotation:
+		if !eventManager.checkCredentialsRotationMatch(rule.Conditions, *params) {
+			return "the event does not match the rule conditions"
+		}
+	}
+	if err := rule.CheckActionsConsistency(params.ObjectType); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// DryRunEventRule checks if the specified event matches the rule with the given
+// name and renders its actions, in execution order, without side effects.
+// HTTP actions are executed if requested
+func DryRunEventRule(name string, req EventDryRunRequest) (EventRuleTrace, error) {
+	rule, err := dataprovider.EventRuleExists(name)
+	if err != nil {
+		return EventRuleTrace{}, err
+	}
+	params, err := req.getParams()
+	if err != nil {
+		return EventRuleTrace{}, err
+	}
+	eventManagerLog(logger.LevelDebug, "dry run for rule %q, event %q", name, params.Event)
+	trace := EventRuleTrace{
+		Rule: rule.Name,
+	}
+	trace.Info = checkRuleMatchForDryRun(&rule, params)
+	trace.Matched = trace.Info == ""
+	if !trace.Matched {
+		return trace, nil
+	}
+	var actions []dataprovider.EventAction
+	for _, action := range rule.Actions {
+		if !action.Options.IsFailureAction && action.Options.ExecuteSync {
+			actions = append(actions, action)
+		}
+	}
+	for _, action := range rule.Actions {
+		if !action.Options.IsFailureAction && !action.Options.ExecuteSync {
+			actions = append(actions, action)
+		}
+	}
+	var failedActions []string
+	for _, action := range actions {
+		actionTrace := dryRunRuleAction(action, params, req.ExecuteHTTP)
+		if actionTrace.Status == DryRunStatusFailure {
+			failedActions = append(failedActions, action.Name)
+		} else if actionTrace.Status != DryRunStatusSkipped {
+			failedActions = removeHandledFailure(failedActions, action.Options)
+		}
+		trace.Actions = append(trace.Actions, actionTrace)
+		if actionTrace.Status == DryRunStatusFailure && action.Options.StopOnFailure {
+			break
+		}
+	}
+	for _, action := range rule.Actions {
+		if !action.Options.IsFailureAction {
+			continue
+		}
+		if len(failedActions) == 0 {
+			trace.Actions = append(trace.Actions, EventActionTrace{
+				Name:   action.Name,
+				Type:   action.Type,
+				Status: DryRunStatusSkipped,
+				Info:   "failure action, no action failed",
+			})
+			continue
+		}
+		trace.Actions = append(trace.Actions, dryRunRuleAction(action, params, req.ExecuteHTTP))
+	}
+	return trace, nil
+}
+
+func dryRunRuleAction(action dataprovider.EventAction, params *EventParams, executeHTTP bool) EventActionTrace {
+	if !params.canExecuteAction(action.Options) {
+		params.addStep(action.Name, stepStatusSkipped, nil)
+		return EventActionTrace{
+			Name:   action.Name,
+			Type:   action.Type,
+			Status: DryRunStatusSkipped,
+			Info:   fmt.Sprintf("dependency on %q not satisfied", action.Options.DependsOn),
+		}
+	}
+	trace := dryRunAction(action.BaseEventAction, params, executeHTTP)
+	if trace.Status == DryRunStatusFailure {
+		params.AddError(fmt.Errorf("action %q failed: %s", action.Name, trace.Error))
+		params.addStep(action.Name, stepStatusFailure, errors.New(trace.Error))
+	} else {
+		// simulated actions are assumed to be successful
+		params.addStep(action.Name, stepStatusSuccess, nil)
+	}
+	return trace
+}
+
+// DryRunEventAction renders the action with the given name using the
+// specified event, without side effects. HTTP actions are executed if requested
+func DryRunEventAction(name string, req EventDryRunRequest) (EventActionTrace, error) {
+	action, err := dataprovider.EventActionExists(name)
+	if err != nil {
+		return EventActionTrace{}, err
+	}
+	params, err := req.getParams()
+	if err != nil {
+		return EventActionTrace{}, err
+	}
+	eventManagerLog(logger.LevelDebug, "dry run for action %q, event %q", name, params.Event)
+	return dryRunAction(action, params, req.ExecuteHTTP), nil
+}
@@ -0,0 +1,316 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestDryRunEventAction(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	action := &dataprovider.BaseEventAction{
+		Name: "dry run http action",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint: server.URL + "/{{Name}}",
+				Timeout:  5,
+				Method:   http.MethodPost,
+				Headers: []dataprovider.KeyValue{
+					{
+						Key:   "X-Event",
+						Value: "{{Event}}",
+					},
+				},
+				Body: "{{VirtualPath}}",
+			},
+		},
+	}
+	err := dataprovider.AddEventAction(action, "", "", "")
+	require.NoError(t, err)
+
+	req := EventDryRunRequest{
+		Event: DeadLetterEvent{
+			Name:        "user",
+			Event:       operationUpload,
+			VirtualPath: "/dir/file.txt",
+		},
+	}
+	trace, err := DryRunEventAction(action.Name, req)
+	assert.NoError(t, err)
+	assert.Equal(t, DryRunStatusSimulated, trace.Status)
+	assert.Contains(t, trace.Rendered, dataprovider.KeyValue{Key: "endpoint", Value: server.URL + "/user"})
+	assert.Contains(t, trace.Rendered, dataprovider.KeyValue{Key: "header X-Event", Value: operationUpload})
+	assert.Contains(t, trace.Rendered, dataprovider.KeyValue{Key: "body", Value: "/dir/file.txt"})
+	assert.Equal(t, 0, requests)
+
+	req.ExecuteHTTP = true
+	trace, err = DryRunEventAction(action.Name, req)
+	assert.NoError(t, err)
+	assert.Equal(t, DryRunStatusSuccess, trace.Status)
+	assert.Equal(t, 1, requests)
+
+	_, err = DryRunEventAction("missing action", req)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	req.DeadLetterID = "missing"
+	_, err = DryRunEventAction(action.Name, req)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	err = dataprovider.DeleteEventAction(action.Name, "", "", "")
+	assert.NoError(t, err)
+
+	params := &EventParams{
+		Name:              "user",
+		Event:             operationRename,
+		VirtualPath:       "/a.txt",
+		VirtualTargetPath: "/b.txt",
+	}
+	trace = dryRunAction(dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients:  []string{"{{Name}}@example.com"},
+				Subject:     "{{Event}}",
+				Body:        "{{VirtualTargetPath}}",
+				Attachments: []string{"/{{VirtualPath}}"},
+			},
+		},
+	}, params, true)
+	assert.Equal(t, DryRunStatusSimulated, trace.Status)
+	assert.Equal(t, []dataprovider.KeyValue{
+		{Key: "recipient", Value: "user@example.com"},
+		{Key: "subject", Value: operationRename},
+		{Key: "body", Value: "/b.txt"},
+		{Key: "attachment", Value: "/a.txt"},
+	}, trace.Rendered)
+
+	trace = dryRunAction(dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeCommand,
+		Options: dataprovider.BaseEventActionOptions{
+			CmdConfig: dataprovider.EventActionCommandConfig{
+				Cmd:  "/bin/true",
+				Args: []string{"{{Name}}"},
+				EnvVars: []dataprovider.KeyValue{
+					{
+						Key:   "TARGET",
+						Value: "{{.TargetName}}",
+					},
+				},
+				TextTemplate: true,
+			},
+		},
+	}, params, true)
+	assert.Equal(t, DryRunStatusSimulated, trace.Status)
+	assert.Contains(t, trace.Rendered, dataprovider.KeyValue{Key: "argument", Value: "user"})
+	assert.Contains(t, trace.Rendered, dataprovider.KeyValue{Key: "env", Value: "TARGET=b.txt"})
+
+	trace = dryRunAction(dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeFilesystem,
+		Options: dataprovider.BaseEventActionOptions{
+			FsConfig: dataprovider.EventActionFilesystemConfig{
+				Type: dataprovider.FilesystemActionRename,
+				Renames: []dataprovider.KeyValue{
+					{
+						Key:   "{{VirtualTargetPath}}",
+						Value: "/archive/{{VirtualTargetPath}}",
+					},
+				},
+			},
+		},
+	}, params, true)
+	assert.Equal(t, DryRunStatusSimulated, trace.Status)
+	assert.Equal(t, []dataprovider.KeyValue{{Key: "rename", Value: "/b.txt -> /archive/b.txt"}}, trace.Rendered)
+
+	trace = dryRunAction(dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint:     server.URL,
+				Method:       http.MethodPost,
+				Body:         "{{.Missing}}",
+				TextTemplate: true,
+			},
+		},
+	}, params, true)
+	assert.Equal(t, DryRunStatusFailure, trace.Status)
+	assert.Contains(t, trace.Error, "unable to render")
+	assert.Equal(t, 1, requests)
+
+	trace = dryRunAction(dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeUserQuotaReset,
+	}, params, true)
+	assert.Equal(t, DryRunStatusSimulated, trace.Status)
+	assert.NotEmpty(t, trace.Info)
+}
+
+func TestDryRunEventRule(t *testing.T) {
+	a1 := &dataprovider.BaseEventAction{
+		Name: "dry run action1",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint:     "http://127.0.0.1:9999/{{Name}}",
+				Timeout:      5,
+				Method:       http.MethodPost,
+				Body:         "{{.Missing}}",
+				TextTemplate: true,
+			},
+		},
+	}
+	a2 := &dataprovider.BaseEventAction{
+		Name: "dry run action2",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"test@example.com"},
+				Subject:    "{{Event}}",
+				Body:       "{{VirtualPath}}",
+			},
+		},
+	}
+	a3 := &dataprovider.BaseEventAction{
+		Name: "dry run action3",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"failure@example.com"},
+				Subject:    "failure",
+				Body:       "{{ErrorString}}",
+			},
+		},
+	}
+	err := dataprovider.AddEventAction(a1, "", "", "")
+	require.NoError(t, err)
+	err = dataprovider.AddEventAction(a2, "", "", "")
+	require.NoError(t, err)
+	err = dataprovider.AddEventAction(a3, "", "", "")
+	require.NoError(t, err)
+	rule := &dataprovider.EventRule{
+		Name:    "dry run rule",
+		Status:  0,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{operationUpload},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: a2.Name,
+				},
+				Order: 1,
+			},
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: a1.Name,
+				},
+				Order: 2,
+				Options: dataprovider.EventActionOptions{
+					ExecuteSync: true,
+				},
+			},
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: a3.Name,
+				},
+				Order: 3,
+				Options: dataprovider.EventActionOptions{
+					IsFailureAction: true,
+				},
+			},
+		},
+	}
+	err = dataprovider.AddEventRule(rule, "", "", "")
+	require.NoError(t, err)
+
+	req := EventDryRunRequest{
+		Event: DeadLetterEvent{
+			Name:        "user",
+			Event:       operationDownload,
+			VirtualPath: "/file.txt",
+		},
+	}
+	trace, err := DryRunEventRule(rule.Name, req)
+	assert.NoError(t, err)
+	assert.False(t, trace.Matched)
+	assert.NotEmpty(t, trace.Info)
+	assert.Len(t, trace.Actions, 0)
+
+	req.Event.Event = operationUpload
+	trace, err = DryRunEventRule(rule.Name, req)
+	assert.NoError(t, err)
+	assert.True(t, trace.Matched)
+	if assert.Len(t, trace.Actions, 3) {
+		// sync actions are executed first
+		assert.Equal(t, a1.Name, trace.Actions[0].Name)
+		assert.Equal(t, DryRunStatusFailure, trace.Actions[0].Status)
+		assert.Equal(t, a2.Name, trace.Actions[1].Name)
+		assert.Equal(t, DryRunStatusSimulated, trace.Actions[1].Status)
+		assert.Contains(t, trace.Actions[1].Rendered, dataprovider.KeyValue{Key: "body", Value: "/file.txt"})
+		assert.Equal(t, a3.Name, trace.Actions[2].Name)
+		assert.Equal(t, DryRunStatusSimulated, trace.Actions[2].Status)
+		assert.Contains(t, trace.Actions[2].Rendered[2].Value, a1.Name)
+	}
+
+	a1.Options.HTTPConfig.Body = "{{VirtualPath}}"
+	a1.Options.HTTPConfig.TextTemplate = false
+	err = dataprovider.UpdateEventAction(a1, "", "", "")
+	assert.NoError(t, err)
+	rule.Actions[0].Order = 2
+	rule.Actions[1].Order = 1
+	rule.Actions[0].Options.DependsOn = a1.Name
+	rule.Actions[0].Options.RunOn = dataprovider.ActionRunOnFailure
+	err = dataprovider.UpdateEventRule(rule, "", "", "")
+	assert.NoError(t, err)
+	trace, err = DryRunEventRule(rule.Name, req)
+	assert.NoError(t, err)
+	assert.True(t, trace.Matched)
+	if assert.Len(t, trace.Actions, 3) {
+		assert.Equal(t, DryRunStatusSimulated, trace.Actions[0].Status)
+		assert.Equal(t, DryRunStatusSkipped, trace.Actions[1].Status)
+		assert.Contains(t, trace.Actions[1].Info, a1.Name)
+		assert.Equal(t, DryRunStatusSkipped, trace.Actions[2].Status)
+	}
+
+	_, err = DryRunEventRule("missing rule", req)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	err = dataprovider.DeleteEventRule(rule.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteEventAction(a1.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteEventAction(a2.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteEventAction(a3.Name, "", "", "")
+	assert.NoError(t, err)
+}
@@ -15,9 +15,11 @@
 package common
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -30,12 +32,15 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/klauspost/compress/zip"
 	"github.com/robfig/cron/v3"
@@ -43,6 +48,8 @@ import (
 	"github.com/sftpgo/sdk"
 	"github.com/wneessen/go-mail"
 
+	"github.com/drakkan/sftpgo/v2/internal/broker"
+	"github.com/drakkan/sftpgo/v2/internal/cloudfunc"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -52,8 +59,18 @@ import (
 )
 
 const (
-	ipBlockedEventName = "IP Blocked"
-	maxAttachmentsSize = int64(10 * 1024 * 1024)
+	ipBlockedEventName      = "IP Blocked"
+	quotaThresholdEventName = "Quota threshold"
+	maxAttachmentsSize      = int64(10 * 1024 * 1024)
+	pgpArmorPrefix          = "-----BEGIN PGP"
+	maxStepOutputSize       = 4096
+)
+
+// executed action statuses, they are available as placeholders for dependent actions
+const (
+	stepStatusSuccess = "success"
+	stepStatusFailure = "failure"
+	stepStatusSkipped = "skipped"
 )
 
 var (
@@ -93,14 +110,16 @@ func HandleCertificateEvent(params EventParams) {
 // eventRulesContainer stores event rules by trigger
 type eventRulesContainer struct {
 	sync.RWMutex
-	lastLoad          atomic.Int64
-	FsEvents          []dataprovider.EventRule
-	ProviderEvents    []dataprovider.EventRule
-	Schedules         []dataprovider.EventRule
-	IPBlockedEvents   []dataprovider.EventRule
-	CertificateEvents []dataprovider.EventRule
-	schedulesMapping  map[string][]cron.EntryID
-	concurrencyGuard  chan struct{}
+	lastLoad                  atomic.Int64
+	FsEvents                  []dataprovider.EventRule
+	ProviderEvents            []dataprovider.EventRule
+	Schedules                 []dataprovider.EventRule
+	IPBlockedEvents           []dataprovider.EventRule
+	CertificateEvents         []dataprovider.EventRule
+	QuotaThresholdEvents      []dataprovider.EventRule
+	CredentialsRotationEvents []dataprovider.EventRule
+	schedulesMapping          map[string][]cron.EntryID
+	concurrencyGuard          chan struct{}
 }
 
 func (r *eventRulesContainer) addAsyncTask() {
@@ -168,6 +187,24 @@ func (r *eventRulesContainer) removeRuleInternal(name string) {
 			return
 		}
 	}
+	for idx := range r.QuotaThresholdEvents {
+		if r.QuotaThresholdEvents[idx].Name == name {
+			lastIdx := len(r.QuotaThresholdEvents) - 1
+			r.QuotaThresholdEvents[idx] = r.QuotaThresholdEvents[lastIdx]
+			r.QuotaThresholdEvents = r.QuotaThresholdEvents[:lastIdx]
+			eventManagerLog(logger.LevelDebug, "removed rule %q from quota threshold events", name)
+			return
+		}
+	}
+	for idx := range r.CredentialsRotationEvents {
+		if r.CredentialsRotationEvents[idx].Name == name {
+			lastIdx := len(r.CredentialsRotationEvents) - 1
+			r.CredentialsRotationEvents[idx] = r.CredentialsRotationEvents[lastIdx]
+			r.CredentialsRotationEvents = r.CredentialsRotationEvents[:lastIdx]
+			eventManagerLog(logger.LevelDebug, "removed rule %q from credentials rotation events", name)
+			return
+		}
+	}
 	for idx := range r.Schedules {
 		if r.Schedules[idx].Name == name {
 			if schedules, ok := r.schedulesMapping[name]; ok {
@@ -213,6 +250,12 @@ func (r *eventRulesContainer) addUpdateRuleInternal(rule dataprovider.EventRule)
 	case dataprovider.EventTriggerCertificate:
 		r.CertificateEvents = append(r.CertificateEvents, rule)
 		eventManagerLog(logger.LevelDebug, "added rule %q to certificate events", rule.Name)
+	case dataprovider.EventTriggerQuotaThreshold:
+		r.QuotaThresholdEvents = append(r.QuotaThresholdEvents, rule)
+		eventManagerLog(logger.LevelDebug, "added rule %q to quota threshold events", rule.Name)
+	case dataprovider.EventTriggerCredentialsRotation:
+		r.CredentialsRotationEvents = append(r.CredentialsRotationEvents, rule)
+		eventManagerLog(logger.LevelDebug, "added rule %q to credentials rotation events", rule.Name)
 	case dataprovider.EventTriggerSchedule:
 		for _, schedule := range rule.Conditions.Schedules {
 			cronSpec := schedule.GetCronSpec()
@@ -253,8 +296,9 @@ func (r *eventRulesContainer) loadRules() {
 			r.addUpdateRuleInternal(rule)
 		}
 	}
-	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d",
-		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents))
+	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d, quota threshold events: %d, credentials rotation events: %d",
+		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents),
+		len(r.QuotaThresholdEvents), len(r.CredentialsRotationEvents))
 
 	r.setLastLoadTime(modTime)
 }
@@ -275,6 +319,42 @@ func (r *eventRulesContainer) checkProviderEventMatch(conditions dataprovider.Ev
 	return true
 }
 
+func (r *eventRulesContainer) checkQuotaThresholdMatch(conditions dataprovider.EventConditions, params EventParams) bool {
+	if !isQuotaThresholdCrossed(conditions.QuotaThreshold, params.UsedQuotaSize-params.FileSize,
+		params.UsedQuotaSize, params.QuotaSize) {
+		return false
+	}
+	if !checkEventConditionPatterns(params.ObjectName, conditions.Options.Names) {
+		return false
+	}
+	if !checkEventConditionPatterns(params.Role, conditions.Options.RoleNames) {
+		return false
+	}
+	if !checkEventGroupConditionPatters(params.Groups, conditions.Options.GroupNames) {
+		return false
+	}
+	if len(conditions.Options.ProviderObjects) > 0 && !util.Contains(conditions.Options.ProviderObjects, params.ObjectType) {
+		return false
+	}
+	return true
+}
+
+func (r *eventRulesContainer) checkCredentialsRotationMatch(conditions dataprovider.EventConditions, params EventParams) bool {
+	if !checkEventConditionPatterns(params.ObjectName, conditions.Options.Names) {
+		return false
+	}
+	if !checkEventConditionPatterns(params.Role, conditions.Options.RoleNames) {
+		return false
+	}
+	if !checkEventGroupConditionPatters(params.Groups, conditions.Options.GroupNames) {
+		return false
+	}
+	if len(conditions.Options.ProviderObjects) > 0 && !util.Contains(conditions.Options.ProviderObjects, params.ObjectType) {
+		return false
+	}
+	return true
+}
+
 func (r *eventRulesContainer) checkFsEventMatch(conditions dataprovider.EventConditions, params EventParams) bool {
 	if !util.Contains(conditions.FsEvents, params.Event) {
 		return false
@@ -309,6 +389,198 @@ func (r *eventRulesContainer) checkFsEventMatch(conditions dataprovider.EventCon
 	return true
 }
 
+// fileContentInfo defines the file properties needed to check the content conditions.
+// The file is read at most once for each event
+type fileContentInfo struct {
+	head  []byte
+	lines int
+}
+
+func (i *fileContentInfo) getHead(size int) []byte {
+	if size > len(i.head) {
+		return i.head
+	}
+	return i.head[:size]
+}
+
+func (i *fileContentInfo) matchMIMEType(patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(i.getHead(512)))
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *fileContentInfo) matchCSVHeader(expected []string) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(i.head, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil || len(header) != len(expected) {
+		return false
+	}
+	for idx, field := range header {
+		if !strings.EqualFold(strings.TrimSpace(field), expected[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *fileContentInfo) match(conditions dataprovider.FileContentConditions) bool {
+	if !i.matchMIMEType(conditions.MIMETypes) {
+		return false
+	}
+	if conditions.MinLines > 0 && i.lines < conditions.MinLines {
+		return false
+	}
+	if conditions.MaxLines > 0 && i.lines > conditions.MaxLines {
+		return false
+	}
+	if !i.matchCSVHeader(conditions.CSVHeader) {
+		return false
+	}
+	if conditions.Pattern != "" {
+		re, err := regexp.Compile(conditions.Pattern)
+		if err != nil {
+			return false
+		}
+		return re.Match(i.getHead(conditions.GetScanSize()))
+	}
+	return true
+}
+
+func (i *fileContentInfo) load(reader io.Reader, headSize int, countLines bool) error {
+	i.head = make([]byte, headSize)
+	n, err := io.ReadFull(reader, i.head)
+	i.head = i.head[:n]
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+			countLines = false
+		} else {
+			return err
+		}
+	}
+	// the last line could be not terminated by a newline
+	partialLine := n > 0 && i.head[n-1] != '\n'
+	i.lines = bytes.Count(i.head, []byte{'\n'})
+	if !countLines {
+		if partialLine {
+			i.lines++
+		}
+		return nil
+	}
+	buf := make([]byte, 32768)
+	for {
+		n, err = reader.Read(buf)
+		if n > 0 {
+			i.lines += bytes.Count(buf[:n], []byte{'\n'})
+			partialLine = buf[n-1] != '\n'
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if partialLine {
+		i.lines++
+	}
+	return nil
+}
+
+func getFileContentInfo(params *EventParams, headSize int, countLines bool) (*fileContentInfo, error) {
+	user, err := params.getUserFromSender()
+	if err != nil {
+		return nil, err
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return nil, err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return nil, fmt.Errorf("unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	reader, cancelFn, err := getFileReader(conn, params.VirtualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	info := &fileContentInfo{}
+	return info, info.load(reader, headSize, countLines)
+}
+
+// filterRulesByFileContent returns the rules whose file content conditions, if any,
+// match the file affected by the specified upload or download event
+func filterRulesByFileContent(rules []dataprovider.EventRule, params *EventParams) []dataprovider.EventRule {
+	if params.Event != operationUpload && params.Event != operationDownload {
+		return rules
+	}
+	headSize := 0
+	countLines := false
+	for _, rule := range rules {
+		content := rule.Conditions.Options.Content
+		if content.IsEmpty() {
+			continue
+		}
+		// 512 bytes are enough to detect the MIME type, the CSV header must
+		// be within the first 64 KB
+		size := 512
+		if len(content.CSVHeader) > 0 {
+			size = 65536
+		}
+		if scanSize := content.GetScanSize(); scanSize > size {
+			size = scanSize
+		}
+		if size > headSize {
+			headSize = size
+		}
+		countLines = countLines || content.NeedsLines()
+	}
+	if headSize == 0 {
+		return rules
+	}
+	info, err := getFileContentInfo(params, headSize, countLines)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to read %q to check the content conditions, user %q: %v",
+			params.VirtualPath, params.Name, err)
+	}
+	result := make([]dataprovider.EventRule, 0, len(rules))
+	for _, rule := range rules {
+		content := rule.Conditions.Options.Content
+		if content.IsEmpty() {
+			result = append(result, rule)
+			continue
+		}
+		if err == nil && info.match(content) {
+			result = append(result, rule)
+			continue
+		}
+		eventManagerLog(logger.LevelDebug, "rule %q skipped, content conditions not matched for %q, user %q",
+			rule.Name, params.VirtualPath, params.Name)
+	}
+	return result
+}
+
 // hasFsRules returns true if there are any rules for filesystem event triggers
 func (r *eventRulesContainer) hasFsRules() bool {
 	r.RLock()
@@ -325,7 +597,7 @@ func (r *eventRulesContainer) handleFsEvent(params EventParams) (bool, error) {
 	}
 	r.RLock()
 
-	var rulesWithSyncActions, rulesAsync []dataprovider.EventRule
+	var rules []dataprovider.EventRule
 	for _, rule := range r.FsEvents {
 		if r.checkFsEventMatch(rule.Conditions, params) {
 			if err := rule.CheckActionsConsistency(""); err != nil {
@@ -333,24 +605,32 @@ func (r *eventRulesContainer) handleFsEvent(params EventParams) (bool, error) {
 					rule.Name, err, params.Event)
 				continue
 			}
-			hasSyncActions := false
-			for _, action := range rule.Actions {
-				if action.Options.ExecuteSync {
-					hasSyncActions = true
-					break
-				}
-			}
-			if hasSyncActions {
-				rulesWithSyncActions = append(rulesWithSyncActions, rule)
-			} else {
-				rulesAsync = append(rulesAsync, rule)
-			}
+			rules = append(rules, rule)
 		}
 	}
 
 	r.RUnlock()
 
 	params.sender = params.Name
+	// the file content is read outside the lock, it could be slow
+	rules = filterRulesByFileContent(rules, &params)
+
+	var rulesWithSyncActions, rulesAsync []dataprovider.EventRule
+	for _, rule := range rules {
+		hasSyncActions := false
+		for _, action := range rule.Actions {
+			if action.Options.ExecuteSync {
+				hasSyncActions = true
+				break
+			}
+		}
+		if hasSyncActions {
+			rulesWithSyncActions = append(rulesWithSyncActions, rule)
+		} else {
+			rulesAsync = append(rulesAsync, rule)
+		}
+	}
+
 	if len(rulesAsync) > 0 {
 		go executeAsyncRulesActions(rulesAsync, params)
 	}
@@ -428,6 +708,62 @@ func (r *eventRulesContainer) handleCertificateEvent(params EventParams) {
 	}
 }
 
+// hasQuotaThresholdRules returns true if there are any rules for quota threshold event triggers
+func (r *eventRulesContainer) hasQuotaThresholdRules() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.QuotaThresholdEvents) > 0
+}
+
+// handleQuotaThresholdEvent executes the rules whose threshold is crossed.
+// Each rule is executed with its own threshold as event parameter
+func (r *eventRulesContainer) handleQuotaThresholdEvent(params EventParams) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, rule := range r.QuotaThresholdEvents {
+		if !r.checkQuotaThresholdMatch(rule.Conditions, params) {
+			continue
+		}
+		if err := rule.CheckActionsConsistency(params.ObjectType); err != nil {
+			eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q object type %q",
+				rule.Name, err, params.Event, params.ObjectType)
+			continue
+		}
+		ruleParams := params.getACopy()
+		ruleParams.QuotaThreshold = rule.Conditions.QuotaThreshold
+		go executeAsyncRulesActions([]dataprovider.EventRule{rule}, *ruleParams)
+	}
+}
+
+func (r *eventRulesContainer) handleCredentialsRotationEvent(params EventParams) {
+	r.RLock()
+	defer r.RUnlock()
+
+	var rules []dataprovider.EventRule
+	for _, rule := range r.CredentialsRotationEvents {
+		if r.checkCredentialsRotationMatch(rule.Conditions, params) {
+			if err := rule.CheckActionsConsistency(params.ObjectType); err == nil {
+				rules = append(rules, rule)
+			} else {
+				eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q object type %q",
+					rule.Name, err, params.Event, params.ObjectType)
+			}
+		}
+	}
+
+	if len(rules) > 0 {
+		go executeAsyncRulesActions(rules, params)
+	}
+}
+
+type executedStep struct {
+	Status string
+	Error  string
+	Output string
+}
+
 type executedRetentionCheck struct {
 	Username   string
 	ActionName string
@@ -447,16 +783,22 @@ type EventParams struct {
 	ObjectName            string
 	ObjectType            string
 	FileSize              int64
+	ResumeOffset          int64
 	Elapsed               int64
 	Protocol              string
 	IP                    string
 	Role                  string
 	Timestamp             int64
+	UsedQuotaSize         int64
+	QuotaSize             int64
+	QuotaThreshold        int
 	Object                plugin.Renderer
 	sender                string
 	updateStatusFromError bool
 	errors                []string
 	retentionChecks       []executedRetentionCheck
+	steps                 map[string]executedStep
+	stepOutput            string
 }
 
 func (p *EventParams) getACopy() *EventParams {
@@ -474,10 +816,59 @@ func (p *EventParams) getACopy() *EventParams {
 		retentionChecks = append(retentionChecks, executedCheck)
 	}
 	params.retentionChecks = retentionChecks
+	if p.steps != nil {
+		params.steps = make(map[string]executedStep, len(p.steps))
+		for k, v := range p.steps {
+			params.steps[k] = v
+		}
+	}
 
 	return &params
 }
 
+// setStepOutput sets the output for the action being executed, it will be
+// available to the actions that depend on it
+func (p *EventParams) setStepOutput(output []byte) {
+	if len(output) > maxStepOutputSize {
+		output = output[:maxStepOutputSize]
+	}
+	p.stepOutput = strings.TrimSpace(string(output))
+}
+
+func (p *EventParams) addStep(name, status string, err error) {
+	if p.steps == nil {
+		p.steps = make(map[string]executedStep)
+	}
+	step := executedStep{
+		Status: status,
+		Output: p.stepOutput,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	p.steps[name] = step
+	p.stepOutput = ""
+}
+
+// canExecuteAction returns true if the dependencies for the specified action are satisfied
+func (p *EventParams) canExecuteAction(options dataprovider.EventActionOptions) bool {
+	if options.DependsOn == "" {
+		return true
+	}
+	step, ok := p.steps[options.DependsOn]
+	if !ok {
+		return false
+	}
+	switch options.RunOn {
+	case dataprovider.ActionRunOnSuccess:
+		return step.Status == stepStatusSuccess
+	case dataprovider.ActionRunOnFailure:
+		return step.Status == stepStatusFailure
+	default:
+		return step.Status != stepStatusSkipped
+	}
+}
+
 // AddError adds a new error to the event params and update the status if needed
 func (p *EventParams) AddError(err error) {
 	if err == nil {
@@ -634,12 +1025,23 @@ func (p *EventParams) getStringReplacements(addObjectData bool) []string {
 		"{{ObjectName}}", p.ObjectName,
 		"{{ObjectType}}", p.ObjectType,
 		"{{FileSize}}", fmt.Sprintf("%d", p.FileSize),
+		"{{ResumeOffset}}", fmt.Sprintf("%d", p.ResumeOffset),
 		"{{Elapsed}}", fmt.Sprintf("%d", p.Elapsed),
 		"{{Protocol}}", p.Protocol,
 		"{{IP}}", p.IP,
 		"{{Role}}", p.Role,
 		"{{Timestamp}}", fmt.Sprintf("%d", p.Timestamp),
 		"{{StatusString}}", p.getStatusString(),
+		"{{UsedQuotaSize}}", fmt.Sprintf("%d", p.UsedQuotaSize),
+		"{{QuotaSize}}", fmt.Sprintf("%d", p.QuotaSize),
+		"{{QuotaThreshold}}", fmt.Sprintf("%d", p.QuotaThreshold),
+	}
+	for name, step := range p.steps {
+		replacements = append(replacements,
+			fmt.Sprintf("{{Steps.%s.Status}}", name), step.Status,
+			fmt.Sprintf("{{Steps.%s.Error}}", name), step.Error,
+			fmt.Sprintf("{{Steps.%s.Output}}", name), step.Output,
+		)
 	}
 	if p.VirtualPath != "" {
 		replacements = append(replacements, "{{VirtualDirPath}}", path.Dir(p.VirtualPath))
@@ -667,14 +1069,15 @@ func getCSVRetentionReport(results []folderRetentionCheckResult) ([]byte, error)
 	var b bytes.Buffer
 	csvWriter := csv.NewWriter(&b)
 	err := csvWriter.Write([]string{"path", "retention (hours)", "deleted files", "deleted size (bytes)",
-		"elapsed (ms)", "info", "error"})
+		"archived files", "archived size (bytes)", "elapsed (ms)", "info", "error"})
 	if err != nil {
 		return nil, err
 	}
 
 	for _, result := range results {
 		err = csvWriter.Write([]string{result.Path, strconv.Itoa(result.Retention), strconv.Itoa(result.DeletedFiles),
-			strconv.FormatInt(result.DeletedSize, 10), strconv.FormatInt(result.Elapsed.Milliseconds(), 10),
+			strconv.FormatInt(result.DeletedSize, 10), strconv.Itoa(result.ArchivedFiles),
+			strconv.FormatInt(result.ArchivedSize, 10), strconv.FormatInt(result.Elapsed.Milliseconds(), 10),
 			result.Info, result.Error})
 		if err != nil {
 			return nil, err
@@ -690,6 +1093,13 @@ func closeWriterAndUpdateQuota(w io.WriteCloser, conn *BaseConnection, virtualSo
 	numFiles int, truncatedSize int64, errTransfer error, operation string, startTime time.Time,
 ) error {
 	errWrite := w.Close()
+	return updateQuotaAndNotify(conn, virtualSourcePath, virtualTargetPath, numFiles, truncatedSize, errTransfer,
+		errWrite, operation, startTime)
+}
+
+func updateQuotaAndNotify(conn *BaseConnection, virtualSourcePath, virtualTargetPath string, numFiles int,
+	truncatedSize int64, errTransfer, errWrite error, operation string, startTime time.Time,
+) error {
 	targetPath := virtualSourcePath
 	if virtualTargetPath != "" {
 		targetPath = virtualTargetPath
@@ -729,12 +1139,79 @@ func updateUserQuotaAfterFileWrite(conn *BaseConnection, virtualPath string, num
 	vfolder, err := conn.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 	if err != nil {
 		dataprovider.UpdateUserQuota(&conn.User, numFiles, fileSize, false) //nolint:errcheck
+		checkQuotaThresholds(&conn.User, nil, fileSize)
 		return
 	}
-	dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, fileSize, false) //nolint:errcheck
+	dataprovider.UpdateUserVirtualFolderQuota(&conn.User, &vfolder, numFiles, fileSize, false) //nolint:errcheck
 	if vfolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&conn.User, numFiles, fileSize, false) //nolint:errcheck
 	}
+	checkQuotaThresholds(&conn.User, &vfolder, fileSize)
+}
+
+// isQuotaThresholdCrossed returns true if the threshold, as percentage of the quota size,
+// is between the previous, excluded, and the current used size
+func isQuotaThresholdCrossed(threshold int, previousSize, usedSize, quotaSize int64) bool {
+	if threshold <= 0 || quotaSize <= 0 {
+		return false
+	}
+	limit := int64(threshold) * quotaSize
+	return previousSize*100 < limit && usedSize*100 >= limit
+}
+
+// checkQuotaThresholds triggers the quota threshold rules for the specified user and, if not nil,
+// virtual folder after adding sizeAdd bytes to the used quota
+func checkQuotaThresholds(user *dataprovider.User, vfolder *vfs.VirtualFolder, sizeAdd int64) {
+	if sizeAdd <= 0 || !eventManager.hasQuotaThresholdRules() {
+		return
+	}
+	params := EventParams{
+		Name:      user.Username,
+		Groups:    user.Groups,
+		Event:     quotaThresholdEventName,
+		Status:    1,
+		FileSize:  sizeAdd,
+		Role:      user.Role,
+		Timestamp: time.Now().UnixNano(),
+	}
+	if vfolder != nil {
+		if vfolder.QuotaSize > 0 {
+			_, usedSize, err := dataprovider.GetUsedVirtualFolderQuota(vfolder.Name)
+			if err != nil {
+				eventManagerLog(logger.LevelDebug, "unable to get used quota for folder %q: %v", vfolder.Name, err)
+			} else {
+				folderParams := params
+				folderParams.ObjectName = vfolder.Name
+				folderParams.ObjectType = "folder"
+				folderParams.UsedQuotaSize = usedSize
+				folderParams.QuotaSize = vfolder.QuotaSize
+				folder := vfolder.BaseVirtualFolder.GetACopy()
+				folder.PrepareForRendering()
+				data, _ := json.Marshal(folder)
+				folderParams.Object = renderedObject(data)
+				folderParams.sender = vfolder.Name
+				eventManager.handleQuotaThresholdEvent(folderParams)
+			}
+		}
+		if !vfolder.IsIncludedInUserQuota() {
+			return
+		}
+	}
+	if user.QuotaSize <= 0 {
+		return
+	}
+	_, usedSize, _, _, err := dataprovider.GetUsedQuota(user.Username)
+	if err != nil {
+		eventManagerLog(logger.LevelDebug, "unable to get used quota for user %q: %v", user.Username, err)
+		return
+	}
+	params.ObjectName = user.Username
+	params.ObjectType = "user"
+	params.UsedQuotaSize = usedSize
+	params.QuotaSize = user.QuotaSize
+	params.Object = user
+	params.sender = user.Username
+	eventManager.handleQuotaThresholdEvent(params)
 }
 
 func checkWriterPermsAndQuota(conn *BaseConnection, virtualPath string, numFiles int, expectedSize, truncatedSize int64) error {
@@ -798,7 +1275,7 @@ func getFileWriter(conn *BaseConnection, virtualPath string, expectedSize int64)
 	vfs.SetPathPermissions(fs, fsPath, conn.User.GetUID(), conn.User.GetGID())
 
 	if isFileOverwrite {
-		if vfs.HasTruncateSupport(fs) || vfs.IsCryptOsFs(fs) {
+		if vfs.HasTruncateSupport(fs) || vfs.IsCryptOsFs(fs) || vfs.IsCompressedFs(fs) {
 			updateUserQuotaAfterFileWrite(conn, virtualPath, numFiles, -fileSize)
 			truncatedSize = 0
 		}
@@ -1110,6 +1587,13 @@ func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strin
 			}
 			return io.NopCloser(bytes.NewBuffer(data)), "", nil
 		}
+		if c.TextTemplate {
+			data, err := renderEventTemplate("HTTP body", c.Body, params.getTemplateData())
+			if err != nil {
+				return body, "", err
+			}
+			return io.NopCloser(bytes.NewBufferString(data)), "", nil
+		}
 		return io.NopCloser(bytes.NewBufferString(replaceWithReplacer(c.Body, replacer))), "", nil
 	}
 	if len(c.Parts) > 0 {
@@ -1230,46 +1714,189 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxStepOutputSize))
+	if err == nil {
+		params.setStepOutput(respBody)
+	}
 
 	return nil
 }
 
-func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *EventParams) error {
+func executeBrokerRuleAction(c dataprovider.EventActionBrokerConfig, params *EventParams) error {
+	if err := c.TryDecryptPassword(); err != nil {
+		return err
+	}
 	addObjectData := false
 	if params.Object != nil {
-		for _, k := range c.EnvVars {
-			if strings.Contains(k.Value, "{{ObjectData}}") {
-				addObjectData = true
-				break
-			}
-		}
+		addObjectData = strings.Contains(c.Body, "{{ObjectData}}")
 	}
 	replacements := params.getStringReplacements(addObjectData)
 	replacer := strings.NewReplacer(replacements...)
 
-	args := make([]string, 0, len(c.Args))
-	for _, arg := range c.Args {
-		args = append(args, replaceWithReplacer(arg, replacer))
+	msg := &broker.Message{
+		Protocol:   c.Protocol,
+		Address:    c.Endpoint,
+		Topic:      replaceWithReplacer(c.Topic, replacer),
+		RoutingKey: replaceWithReplacer(c.RoutingKey, replacer),
+		Username:   c.Username,
+		Password:   c.Password.GetPayload(),
+		TLSConfig:  c.GetTLSConfig(),
+		Payload:    []byte(replaceWithReplacer(c.Body, replacer)),
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+	ctx, cancel := c.GetContext()
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.Cmd, args...)
-	cmd.Env = []string{}
+	startTime := time.Now()
+	if err := broker.Publish(ctx, msg); err != nil {
+		eventManagerLog(logger.LevelDebug, "unable to publish %s message, endpoint: %s, topic: %q, elapsed: %s, err: %v",
+			c.Protocol, c.Endpoint, msg.Topic, time.Since(startTime), err)
+		return fmt.Errorf("error publishing %s message: %w", c.Protocol, err)
+	}
+	eventManagerLog(logger.LevelDebug, "%s message published, endpoint: %s, topic: %q, elapsed: %s",
+		c.Protocol, c.Endpoint, msg.Topic, time.Since(startTime))
+	return nil
+}
+
+func invokeCloudFunction(ctx context.Context, c dataprovider.EventActionFunctionConfig, req *cloudfunc.Request) error {
+	startTime := time.Now()
+	result, err := cloudfunc.Invoke(ctx, req)
+	if err != nil {
+		eventManagerLog(logger.LevelDebug, "unable to invoke cloud function %q, provider: %s, async: %t, elapsed: %s, err: %v",
+			c.Function, c.Provider, c.Async, time.Since(startTime), err)
+		return fmt.Errorf("error invoking cloud function: %w", err)
+	}
+	eventManagerLog(logger.LevelDebug, "cloud function %q invoked, provider: %s, async: %t, elapsed: %s, status code: %d",
+		c.Function, c.Provider, c.Async, time.Since(startTime), result.StatusCode)
+	if !c.Async {
+		if resultErr := result.GetResultError(c.ResultErrorField); resultErr != "" {
+			return fmt.Errorf("cloud function %q returned an error: %s", c.Function, resultErr)
+		}
+	}
+	return nil
+}
+
+func executeFunctionRuleAction(c dataprovider.EventActionFunctionConfig, params *EventParams) error {
+	if err := c.TryDecryptSecrets(); err != nil {
+		return err
+	}
+	addObjectData := false
+	if params.Object != nil {
+		addObjectData = strings.Contains(c.Body, "{{ObjectData}}")
+	}
+	replacements := params.getStringReplacements(addObjectData)
+	replacer := strings.NewReplacer(replacements...)
+
+	req := &cloudfunc.Request{
+		Provider:     c.Provider,
+		Function:     c.Function,
+		Region:       c.Region,
+		AccessKey:    c.AccessKey,
+		AccessSecret: c.AccessSecret.GetPayload(),
+		RoleARN:      c.RoleARN,
+		Endpoint:     c.Endpoint,
+		Credentials:  []byte(c.Credentials.GetPayload()),
+		Async:        c.Async,
+		Payload:      []byte(replaceWithReplacer(c.Body, replacer)),
+	}
+	if c.Async && c.Provider == cloudfunc.ProviderGCF {
+		// HTTP functions have no asynchronous invocation mode, we don't wait for the result
+		go func() {
+			ctx, cancel := c.GetContext()
+			defer cancel()
+
+			if err := invokeCloudFunction(ctx, c, req); err != nil {
+				eventManagerLog(logger.LevelError, "async cloud function invocation failed: %v", err)
+			}
+		}()
+		return nil
+	}
+	ctx, cancel := c.GetContext()
+	defer cancel()
+
+	return invokeCloudFunction(ctx, c, req)
+}
+
+func getCommandRuleActionEnv(c dataprovider.EventActionCommandConfig, replacer *strings.Replacer,
+	params *EventParams,
+) ([]string, error) {
+	env := []string{}
+	var templateData *eventTemplateData
+	if c.TextTemplate {
+		templateData = params.getTemplateData()
+	}
 	for _, keyVal := range c.EnvVars {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", keyVal.Key, replaceWithReplacer(keyVal.Value, replacer)))
+		val := replaceWithReplacer(keyVal.Value, replacer)
+		if c.TextTemplate {
+			var err error
+			val, err = renderEventTemplate(fmt.Sprintf("env var %q", keyVal.Key), keyVal.Value, templateData)
+			if err != nil {
+				return nil, err
+			}
+		}
+		env = append(env, fmt.Sprintf("%s=%s", keyVal.Key, val))
 	}
+	return env, nil
+}
+
+func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *EventParams) error {
+	addObjectData := false
+	if params.Object != nil {
+		for _, k := range c.EnvVars {
+			if strings.Contains(k.Value, "{{ObjectData}}") {
+				addObjectData = true
+				break
+			}
+		}
+	}
+	replacements := params.getStringReplacements(addObjectData)
+	replacer := strings.NewReplacer(replacements...)
+
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, replaceWithReplacer(arg, replacer))
+	}
+	env, err := getCommandRuleActionEnv(c, replacer, params)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Cmd, args...)
+	cmd.Env = env
 
 	startTime := time.Now()
-	err := cmd.Run()
+	output, err := cmd.Output()
 
 	eventManagerLog(logger.LevelDebug, "executed command %q, elapsed: %s, error: %v",
 		c.Cmd, time.Since(startTime), err)
+	if err == nil {
+		params.setStepOutput(output)
+	}
 
 	return err
 }
 
+// getEmailRuleActionContent returns the email body and subject
+func getEmailRuleActionContent(c dataprovider.EventActionEmailConfig, replacer *strings.Replacer,
+	params *EventParams,
+) (string, string, error) {
+	if !c.TextTemplate {
+		return replaceWithReplacer(c.Body, replacer), replaceWithReplacer(c.Subject, replacer), nil
+	}
+	data := params.getTemplateData()
+	body, err := renderEventTemplate("email body", c.Body, data)
+	if err != nil {
+		return "", "", err
+	}
+	subject, err := renderEventTemplate("email subject", c.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	return body, subject, nil
+}
+
 func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *EventParams) error {
 	addObjectData := false
 	if params.Object != nil {
@@ -1279,8 +1906,10 @@ func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *Event
 	}
 	replacements := params.getStringReplacements(addObjectData)
 	replacer := strings.NewReplacer(replacements...)
-	body := replaceWithReplacer(c.Body, replacer)
-	subject := replaceWithReplacer(c.Subject, replacer)
+	body, subject, err := getEmailRuleActionContent(c, replacer, params)
+	if err != nil {
+		return err
+	}
 	recipients := make([]string, 0, len(c.Recipients))
 	for _, recipient := range c.Recipients {
 		recipients = append(recipients, replaceWithReplacer(recipient, replacer))
@@ -1321,7 +1950,7 @@ func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *Event
 		}
 		files = append(files, res...)
 	}
-	err := smtp.SendEmail(recipients, subject, body, smtp.EmailContentTypeTextPlain, files...)
+	err = smtp.SendEmail(recipients, subject, body, smtp.EmailContentTypeTextPlain, files...)
 	eventManagerLog(logger.LevelDebug, "executed email notification action, elapsed: %s, error: %v",
 		time.Since(startTime), err)
 	if err != nil {
@@ -1821,6 +2450,144 @@ func executeCompressFsRuleAction(c dataprovider.EventActionFsCompress, replacer
 	return nil
 }
 
+func copyPGPEncrypted(w io.Writer, r io.Reader, keys openpgp.EntityList, fileName string) error {
+	plaintext, err := openpgp.Encrypt(w, keys, nil, &openpgp.FileHints{IsBinary: true, FileName: fileName}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to PGP encrypt: %w", err)
+	}
+	if _, err := io.Copy(plaintext, r); err != nil {
+		plaintext.Close() //nolint:errcheck
+		return err
+	}
+	return plaintext.Close()
+}
+
+func copyPGPDecrypted(w io.Writer, r io.Reader, keys openpgp.EntityList) error {
+	reader := bufio.NewReader(r)
+	// armored messages are supported too
+	if prefix, err := reader.Peek(len(pgpArmorPrefix)); err == nil && string(prefix) == pgpArmorPrefix {
+		block, err := armor.Decode(reader)
+		if err != nil {
+			return fmt.Errorf("unable to decode PGP armored message: %w", err)
+		}
+		r = block.Body
+	} else {
+		r = reader
+	}
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		return fmt.Errorf("unable to PGP decrypt: %w", err)
+	}
+	if _, err := io.Copy(w, md.UnverifiedBody); err != nil {
+		return err
+	}
+	if md.SignatureError != nil {
+		return fmt.Errorf("invalid PGP signature: %w", md.SignatureError)
+	}
+	return nil
+}
+
+func executePGPFileAction(conn *BaseConnection, source, target string, keys openpgp.EntityList, action int) error {
+	info, err := conn.DoStat(source, 0, false)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", source)
+	}
+	reader, cancelReaderFn, err := getFileReader(conn, source)
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %w", source, err)
+	}
+	defer cancelReaderFn()
+	defer reader.Close()
+
+	writer, numFiles, truncatedSize, cancelWriterFn, err := getFileWriter(conn, target, info.Size())
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", target, err)
+	}
+	defer cancelWriterFn()
+
+	startTime := time.Now()
+	if action == dataprovider.FilesystemActionPGPEncrypt {
+		err = copyPGPEncrypted(writer, reader, keys, path.Base(source))
+	} else {
+		err = copyPGPDecrypted(writer, reader, keys)
+	}
+	if err != nil {
+		eventManagerLog(logger.LevelError, "PGP action %d failed for %q->%q: %v", action, source, target, err)
+		closeWriterAndUpdateQuota(writer, conn, target, "", numFiles, truncatedSize, err, operationUpload, startTime) //nolint:errcheck
+		return err
+	}
+	return closeWriterAndUpdateQuota(writer, conn, target, "", numFiles, truncatedSize, err, operationUpload, startTime)
+}
+
+func executePGPFsActionForUser(c dataprovider.EventActionFsPGP, action int, replacer *strings.Replacer,
+	user dataprovider.User,
+) error {
+	keys, err := c.GetKeys(action)
+	if err != nil {
+		return err
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("PGP error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	for _, item := range c.Paths {
+		source := util.CleanPath(replaceWithReplacer(item.Key, replacer))
+		target := util.CleanPath(replaceWithReplacer(item.Value, replacer))
+		if source == target {
+			return fmt.Errorf("PGP source and target cannot be equal: %q", source)
+		}
+		if err = executePGPFileAction(conn, source, target, keys, action); err != nil {
+			return fmt.Errorf("unable to PGP process %q->%q, user %q: %w", source, target, user.Username, err)
+		}
+		eventManagerLog(logger.LevelDebug, "PGP action %d %q->%q ok, user %q", action, source, target, user.Username)
+	}
+	return nil
+}
+
+func executePGPFsRuleAction(c dataprovider.EventActionFsPGP, action int, replacer *strings.Replacer,
+	conditions dataprovider.ConditionOptions, params *EventParams,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	executed := 0
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkUserConditionOptions(&user, &conditions) {
+				eventManagerLog(logger.LevelDebug, "skipping fs PGP action for user %s, condition options don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executePGPFsActionForUser(c, action, replacer, user); err != nil {
+			failures = append(failures, user.Username)
+			params.AddError(err)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("fs PGP action failed for users: %s", strings.Join(failures, ", "))
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no PGP action executed")
+		return errors.New("no PGP action executed")
+	}
+	return nil
+}
+
 func executeFsRuleAction(c dataprovider.EventActionFilesystemConfig, conditions dataprovider.ConditionOptions,
 	params *EventParams,
 ) error {
@@ -1840,6 +2607,8 @@ func executeFsRuleAction(c dataprovider.EventActionFilesystemConfig, conditions
 		return executeCompressFsRuleAction(c.Compress, replacer, conditions, params)
 	case dataprovider.FilesystemActionCopy:
 		return executeCopyFsRuleAction(c.Copy, replacer, conditions, params)
+	case dataprovider.FilesystemActionPGPEncrypt, dataprovider.FilesystemActionPGPDecrypt:
+		return executePGPFsRuleAction(c.PGP, c.Type, replacer, conditions, params)
 	default:
 		return fmt.Errorf("unsupported filesystem action %d", c.Type)
 	}
@@ -1987,7 +2756,7 @@ func executeTransferQuotaResetRuleAction(conditions dataprovider.ConditionOption
 }
 
 func executeDataRetentionCheckForUser(user dataprovider.User, folders []dataprovider.FolderRetention,
-	params *EventParams, actionName string,
+	dryRun bool, params *EventParams, actionName string,
 ) error {
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		eventManagerLog(logger.LevelError, "skipping scheduled retention check for user %s, cannot apply group settings: %v",
@@ -1996,6 +2765,7 @@ func executeDataRetentionCheckForUser(user dataprovider.User, folders []dataprov
 	}
 	check := RetentionCheck{
 		Folders: folders,
+		DryRun:  dryRun,
 	}
 	c := RetentionChecks.Add(check, &user)
 	if c == nil {
@@ -2035,7 +2805,7 @@ func executeDataRetentionCheckRuleAction(config dataprovider.EventActionDataRete
 			}
 		}
 		executed++
-		if err = executeDataRetentionCheckForUser(user, config.Folders, params, actionName); err != nil {
+		if err = executeDataRetentionCheckForUser(user, config.Folders, config.DryRun, params, actionName); err != nil {
 			failures = append(failures, user.Username)
 			params.AddError(err)
 		}
@@ -2084,6 +2854,68 @@ func executeUserExpirationCheckRuleAction(conditions dataprovider.ConditionOptio
 	return nil
 }
 
+func executeTrashPurgeForUser(user dataprovider.User) error {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	if !user.Filters.Trash.Enabled || user.Filters.Trash.Retention <= 0 {
+		eventManagerLog(logger.LevelDebug, "skipping trash purge for user %q, trash or retention not enabled",
+			user.Username)
+		return nil
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("trash purge error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	startTime := time.Now()
+	deletedBefore := startTime.Add(-time.Duration(user.Filters.Trash.Retention) * 24 * time.Hour)
+	numRemoved, err := conn.PurgeTrash(deletedBefore)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "trash purge failed for user %q, removed files: %d, err: %v",
+			user.Username, numRemoved, err)
+		return fmt.Errorf("trash purge failed for user %q: %w", user.Username, err)
+	}
+	eventManagerLog(logger.LevelDebug, "trash purge completed for user %q, removed files: %d, elapsed: %s",
+		user.Username, numRemoved, time.Since(startTime))
+	return nil
+}
+
+func executeTrashPurgeRuleAction(conditions dataprovider.ConditionOptions, params *EventParams) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	var executed int
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkUserConditionOptions(&user, &conditions) {
+				eventManagerLog(logger.LevelDebug, "skipping trash purge for user %q, condition options don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeTrashPurgeForUser(user); err != nil {
+			params.AddError(err)
+			failures = append(failures, user.Username)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("trash purge failed for users: %s", strings.Join(failures, ", "))
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no trash purge executed")
+		return errors.New("no trash purge executed")
+	}
+	return nil
+}
+
 func executeMetadataCheckForUser(user *dataprovider.User) error {
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		eventManagerLog(logger.LevelError, "skipping scheduled quota reset for user %s, cannot apply group settings: %v",
@@ -2135,7 +2967,62 @@ func executeMetadataCheckRuleAction(conditions dataprovider.ConditionOptions, pa
 	return nil
 }
 
-func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovider.EventActionPasswordExpiration) error {
+func getAdminsForLifecycleNotifications() ([]dataprovider.Admin, error) {
+	var result []dataprovider.Admin
+	limit := 100
+	offset := 0
+	for {
+		admins, err := dataprovider.GetAdmins(limit, offset, dataprovider.OrderASC)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get admins: %w", err)
+		}
+		for _, admin := range admins {
+			if admin.Status == 1 && admin.Email != "" && admin.HasPermission(dataprovider.PermAdminViewUsers) {
+				result = append(result, admin)
+			}
+		}
+		if len(admins) < limit {
+			break
+		}
+		offset += limit
+	}
+	return result, nil
+}
+
+func notifyAdminsAboutLifecycleEvent(admins []dataprovider.Admin, user *dataprovider.User, event string, days int) error {
+	var errRes error
+	for _, admin := range admins {
+		if admin.Role != "" && admin.Role != user.Role {
+			continue
+		}
+		body := new(bytes.Buffer)
+		data := make(map[string]any)
+		data["Admin"] = admin.Username
+		data["Username"] = user.Username
+		data["Event"] = event
+		data["Days"] = days
+		if err := smtp.RenderAccountLifecycleTemplate(body, data); err != nil {
+			eventManagerLog(logger.LevelError, "unable to notify admin %q about %s for user %q: %v",
+				admin.Username, event, user.Username, err)
+			return err
+		}
+		subject := fmt.Sprintf("SFTPGo account lifecycle notification for user %q", user.Username)
+		startTime := time.Now()
+		if err := smtp.SendEmail([]string{admin.Email}, subject, body.String(), smtp.EmailContentTypeTextHTML); err != nil {
+			eventManagerLog(logger.LevelError, "unable to notify admin %q about %s for user %q: %v, elapsed: %s",
+				admin.Username, event, user.Username, err, time.Since(startTime))
+			errRes = err
+			continue
+		}
+		eventManagerLog(logger.LevelDebug, "%s email for user %q sent to admin %q, elapsed: %s",
+			event, user.Username, admin.Username, time.Since(startTime))
+	}
+	return errRes
+}
+
+func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovider.EventActionPasswordExpiration,
+	admins []dataprovider.Admin,
+) error {
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		eventManagerLog(logger.LevelError, "skipping password expiration check for user %q, cannot apply group settings: %v",
 			user.Username, err)
@@ -2153,9 +3040,10 @@ func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovid
 		return nil
 	}
 	days := user.PasswordExpiresIn()
-	if days > config.Threshold {
+	threshold := user.GetExpirationWarningThreshold(config.Threshold)
+	if days > threshold {
 		eventManagerLog(logger.LevelDebug, "password for user %q expires in %d days, threshold %d, no need to notify",
-			user.Username, days, config.Threshold)
+			user.Username, days, threshold)
 		return nil
 	}
 	body := new(bytes.Buffer)
@@ -2176,6 +3064,9 @@ func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovid
 	}
 	eventManagerLog(logger.LevelDebug, "password expiration email sent to user %s, days: %d, elapsed: %s",
 		user.Username, days, time.Since(startTime))
+	if config.NotifyAdmins {
+		return notifyAdminsAboutLifecycleEvent(admins, user, "password_expiration", days)
+	}
 	return nil
 }
 
@@ -2185,6 +3076,13 @@ func executePwdExpirationCheckRuleAction(config dataprovider.EventActionPassword
 	if err != nil {
 		return fmt.Errorf("unable to get users: %w", err)
 	}
+	var admins []dataprovider.Admin
+	if config.NotifyAdmins {
+		admins, err = getAdminsForLifecycleNotifications()
+		if err != nil {
+			return err
+		}
+	}
 	var failures []string
 	for _, user := range users {
 		// if sender is set, the conditions have already been evaluated
@@ -2195,7 +3093,7 @@ func executePwdExpirationCheckRuleAction(config dataprovider.EventActionPassword
 				continue
 			}
 		}
-		if err = executePwdExpirationCheckForUser(&user, config); err != nil {
+		if err = executePwdExpirationCheckForUser(&user, config, admins); err != nil {
 			params.AddError(err)
 			failures = append(failures, user.Username)
 		}
@@ -2207,6 +3105,119 @@ func executePwdExpirationCheckRuleAction(config dataprovider.EventActionPassword
 	return nil
 }
 
+func executeAccountExpirationWarningForUser(user *dataprovider.User, config dataprovider.EventActionAccountLifecycle,
+	admins []dataprovider.Admin,
+) error {
+	if user.ExpirationDate == 0 {
+		return nil
+	}
+	threshold := user.GetExpirationWarningThreshold(config.ExpirationThreshold)
+	if threshold == 0 {
+		return nil
+	}
+	days := user.ExpiresIn()
+	if days <= 0 || days > threshold {
+		eventManagerLog(logger.LevelDebug, "account for user %q expires in %d days, threshold %d, no need to notify",
+			user.Username, days, threshold)
+		return nil
+	}
+	if user.Email != "" {
+		body := new(bytes.Buffer)
+		data := make(map[string]any)
+		data["Username"] = user.Username
+		data["Days"] = days
+		if err := smtp.RenderAccountExpirationTemplate(body, data); err != nil {
+			eventManagerLog(logger.LevelError, "unable to notify account expiration for user %q: %v",
+				user.Username, err)
+			return err
+		}
+		subject := "SFTPGo account expiration notification"
+		startTime := time.Now()
+		if err := smtp.SendEmail([]string{user.Email}, subject, body.String(), smtp.EmailContentTypeTextHTML); err != nil {
+			eventManagerLog(logger.LevelError, "unable to notify account expiration for user %q: %v, elapsed: %s",
+				user.Username, err, time.Since(startTime))
+			return err
+		}
+		eventManagerLog(logger.LevelDebug, "account expiration email sent to user %q, days: %d, elapsed: %s",
+			user.Username, days, time.Since(startTime))
+	}
+	if config.NotifyAdmins {
+		return notifyAdminsAboutLifecycleEvent(admins, user, "account_expiration", days)
+	}
+	return nil
+}
+
+func executeAccountLifecycleCheckForUser(user *dataprovider.User, config dataprovider.EventActionAccountLifecycle,
+	admins []dataprovider.Admin,
+) error {
+	if user.Status == 0 {
+		eventManagerLog(logger.LevelDebug, "skipping account lifecycle check for disabled user %q", user.Username)
+		return nil
+	}
+	if err := user.LoadAndApplyGroupSettings(); err != nil {
+		eventManagerLog(logger.LevelError, "skipping account lifecycle check for user %q, cannot apply group settings: %v",
+			user.Username, err)
+		return err
+	}
+	threshold := user.GetInactivityThreshold(config.InactivityThreshold)
+	if threshold > 0 {
+		if days := user.GetInactiveDays(); days > threshold {
+			if err := dataprovider.DisableUser(user.Username, dataprovider.ActionExecutorSystem, "", user.Role); err != nil {
+				eventManagerLog(logger.LevelError, "unable to disable inactive user %q: %v", user.Username, err)
+				return err
+			}
+			eventManagerLog(logger.LevelInfo, "user %q disabled, inactive for %d days, threshold: %d",
+				user.Username, days, threshold)
+			if config.NotifyAdmins {
+				return notifyAdminsAboutLifecycleEvent(admins, user, "inactivity", days)
+			}
+			return nil
+		}
+	}
+	return executeAccountExpirationWarningForUser(user, config, admins)
+}
+
+func executeAccountLifecycleCheckRuleAction(config dataprovider.EventActionAccountLifecycle,
+	conditions dataprovider.ConditionOptions, params *EventParams,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var admins []dataprovider.Admin
+	if config.NotifyAdmins {
+		admins, err = getAdminsForLifecycleNotifications()
+		if err != nil {
+			return err
+		}
+	}
+	var failures []string
+	var executed int
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkUserConditionOptions(&user, &conditions) {
+				eventManagerLog(logger.LevelDebug, "skipping account lifecycle check for user %q, condition options don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeAccountLifecycleCheckForUser(&user, config, admins); err != nil {
+			params.AddError(err)
+			failures = append(failures, user.Username)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("account lifecycle check failed for users: %s", strings.Join(failures, ", "))
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no account lifecycle check executed")
+		return errors.New("no account lifecycle check executed")
+	}
+	return nil
+}
+
 func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 	conditions dataprovider.ConditionOptions,
 ) error {
@@ -2241,6 +3252,22 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = executePwdExpirationCheckRuleAction(action.Options.PwdExpirationConfig, conditions, params)
 	case dataprovider.ActionTypeUserExpirationCheck:
 		err = executeUserExpirationCheckRuleAction(conditions, params)
+	case dataprovider.ActionTypeTrashPurge:
+		err = executeTrashPurgeRuleAction(conditions, params)
+	case dataprovider.ActionTypeMessageBroker:
+		err = executeBrokerRuleAction(action.Options.BrokerConfig, params)
+	case dataprovider.ActionTypeCloudFunction:
+		err = executeFunctionRuleAction(action.Options.FunctionConfig, params)
+	case dataprovider.ActionTypeReplication:
+		err = executeReplicationRuleAction(action.Options.ReplicationConfig, params)
+	case dataprovider.ActionTypeThumbnail:
+		err = executeThumbnailRuleAction(action.Options.ThumbnailConfig, params)
+	case dataprovider.ActionTypeChecksumManifest:
+		err = executeChecksumManifestRuleAction(action.Options.ChecksumConfig, params)
+	case dataprovider.ActionTypePush:
+		err = executePushRuleAction(action.Options.PushConfig, params)
+	case dataprovider.ActionTypeAccountLifecycleCheck:
+		err = executeAccountLifecycleCheckRuleAction(action.Options.LifecycleConfig, conditions, params)
 	default:
 		err = fmt.Errorf("unsupported action type: %d", action.Type)
 	}
@@ -2260,8 +3287,14 @@ func executeSyncRulesActions(rules []dataprovider.EventRule, params EventParams)
 		paramsCopy := params.getACopy()
 		for _, action := range rule.Actions {
 			if !action.Options.IsFailureAction && action.Options.ExecuteSync {
+				if !paramsCopy.canExecuteAction(action.Options) {
+					eventManagerLog(logger.LevelDebug, "skipping sync action %q for rule %q, dependency on %q not satisfied",
+						action.Name, rule.Name, action.Options.DependsOn)
+					paramsCopy.addStep(action.Name, stepStatusSkipped, nil)
+					continue
+				}
 				startTime := time.Now()
-				if err := executeRuleAction(action.BaseEventAction, paramsCopy, rule.Conditions.Options); err != nil {
+				if err := executeChainedRuleAction(rule.Name, action, paramsCopy, rule.Conditions.Options); err != nil {
 					eventManagerLog(logger.LevelError, "unable to execute sync action %q for rule %q, elapsed %s, err: %v",
 						action.Name, rule.Name, time.Since(startTime), err)
 					failedActions = append(failedActions, action.Name)
@@ -2273,6 +3306,7 @@ func executeSyncRulesActions(rules []dataprovider.EventRule, params EventParams)
 				} else {
 					eventManagerLog(logger.LevelDebug, "executed sync action %q for rule %q, elapsed: %s",
 						action.Name, rule.Name, time.Since(startTime))
+					failedActions = removeHandledFailure(failedActions, action.Options)
 				}
 			}
 		}
@@ -2292,11 +3326,48 @@ func executeAsyncRulesActions(rules []dataprovider.EventRule, params EventParams
 	}
 }
 
+// executeChainedRuleAction executes the specified action, retrying on failure
+// if configured, and records its result so dependent actions can use it
+func executeChainedRuleAction(ruleName string, action dataprovider.EventAction, params *EventParams,
+	conditions dataprovider.ConditionOptions,
+) error {
+	err := executeRuleAction(action.BaseEventAction, params, conditions)
+	for retry := 1; err != nil && retry <= action.Options.Retries; retry++ {
+		delay := action.Options.GetRetryDelay(retry)
+		eventManagerLog(logger.LevelDebug, "retrying action %q in %s, retry %d/%d, last error: %v",
+			action.Name, delay, retry, action.Options.Retries, err)
+		time.Sleep(delay)
+		err = executeRuleAction(action.BaseEventAction, params, conditions)
+	}
+	if err != nil {
+		params.addStep(action.Name, stepStatusFailure, err)
+		deadLetters.add(ruleName, &action.BaseEventAction, params, err)
+	} else {
+		params.addStep(action.Name, stepStatusSuccess, nil)
+	}
+	return err
+}
+
+// removeHandledFailure removes, from the failed actions, the one handled by
+// a successfully executed on-failure branch
+func removeHandledFailure(failedActions []string, options dataprovider.EventActionOptions) []string {
+	if options.DependsOn == "" || options.RunOn != dataprovider.ActionRunOnFailure {
+		return failedActions
+	}
+	return util.Remove(failedActions, options.DependsOn)
+}
+
 func executeRuleAsyncActions(rule dataprovider.EventRule, params *EventParams, failedActions []string) {
 	for _, action := range rule.Actions {
 		if !action.Options.IsFailureAction && !action.Options.ExecuteSync {
+			if !params.canExecuteAction(action.Options) {
+				eventManagerLog(logger.LevelDebug, "skipping action %q for rule %q, dependency on %q not satisfied",
+					action.Name, rule.Name, action.Options.DependsOn)
+				params.addStep(action.Name, stepStatusSkipped, nil)
+				continue
+			}
 			startTime := time.Now()
-			if err := executeRuleAction(action.BaseEventAction, params, rule.Conditions.Options); err != nil {
+			if err := executeChainedRuleAction(rule.Name, action, params, rule.Conditions.Options); err != nil {
 				eventManagerLog(logger.LevelError, "unable to execute action %q for rule %q, elapsed %s, err: %v",
 					action.Name, rule.Name, time.Since(startTime), err)
 				failedActions = append(failedActions, action.Name)
@@ -2306,6 +3377,7 @@ func executeRuleAsyncActions(rule dataprovider.EventRule, params *EventParams, f
 			} else {
 				eventManagerLog(logger.LevelDebug, "executed action %q for rule %q, elapsed %s",
 					action.Name, rule.Name, time.Since(startTime))
+				failedActions = removeHandledFailure(failedActions, action.Options)
 			}
 		}
 	}
@@ -2315,7 +3387,7 @@ func executeRuleAsyncActions(rule dataprovider.EventRule, params *EventParams, f
 		for _, action := range rule.Actions {
 			if action.Options.IsFailureAction {
 				startTime := time.Now()
-				if err := executeRuleAction(action.BaseEventAction, params, rule.Conditions.Options); err != nil {
+				if err := executeChainedRuleAction(rule.Name, action, params, rule.Conditions.Options); err != nil {
 					eventManagerLog(logger.LevelError, "unable to execute failure action %q for rule %q, elapsed %s, err: %v",
 						action.Name, rule.Name, time.Since(startTime), err)
 					if action.Options.StopOnFailure {
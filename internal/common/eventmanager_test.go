@@ -15,22 +15,30 @@
 package common
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/klauspost/compress/zip"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -480,7 +488,7 @@ func TestEventManagerErrors(t *testing.T) {
 				Type: sdk.GroupTypePrimary,
 			},
 		},
-	}, nil, &EventParams{}, "")
+	}, nil, false, &EventParams{}, "")
 	assert.Error(t, err)
 	err = executeDeleteFsActionForUser(nil, nil, dataprovider.User{
 		Groups: []sdk.GroupMapping{
@@ -542,7 +550,18 @@ func TestEventManagerErrors(t *testing.T) {
 				Name: groupName,
 				Type: sdk.GroupTypePrimary,
 			},
-		}}, dataprovider.EventActionPasswordExpiration{})
+		}}, dataprovider.EventActionPasswordExpiration{}, nil)
+	assert.Error(t, err)
+	err = executeAccountLifecycleCheckForUser(&dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Status: 1,
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: groupName,
+				Type: sdk.GroupTypePrimary,
+			},
+		}}, dataprovider.EventActionAccountLifecycle{}, nil)
 	assert.Error(t, err)
 
 	_, _, err = getHTTPRuleActionBody(dataprovider.EventActionHTTPConfig{
@@ -1232,6 +1251,306 @@ func TestUserExpirationCheck(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAccountLifecycleCheck(t *testing.T) {
+	username := "test_user_lifecycle_check"
+	groupName := "test_group_lifecycle_check"
+	group := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: groupName,
+		},
+		UserSettings: dataprovider.GroupUserSettings{
+			InactivityThreshold: -1,
+		},
+	}
+	err := dataprovider.AddGroup(&group, "", "", "")
+	assert.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			HomeDir: filepath.Join(os.TempDir(), username),
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "", "")
+	assert.NoError(t, err)
+
+	config := dataprovider.EventActionAccountLifecycle{
+		ExpirationThreshold: 10,
+		InactivityThreshold: 30,
+	}
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	// the user is not inactive and has no expiration date
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.NoError(t, err)
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.Status)
+	// the account expires within the threshold but the user has no email
+	user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(5 * 24 * time.Hour))
+	assert.Equal(t, 5, user.ExpiresIn())
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.NoError(t, err)
+	// smtp is not configured
+	user.Email = "user@lifecycle.local"
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.Error(t, err)
+	// the group disables the inactivity check
+	user.Email = ""
+	user.ExpirationDate = 0
+	user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now().Add(-40 * 24 * time.Hour))
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: groupName,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	assert.Equal(t, 40, user.GetInactiveDays())
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.NoError(t, err)
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.Status)
+	// without the group the inactive user is disabled
+	user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now().Add(-40 * 24 * time.Hour))
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.NoError(t, err)
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.Status)
+	// disabled users are skipped
+	err = executeAccountLifecycleCheckForUser(&user, config, nil)
+	assert.NoError(t, err)
+
+	conditions := dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "no user match",
+			},
+		},
+	}
+	err = executeAccountLifecycleCheckRuleAction(config, conditions, &EventParams{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no account lifecycle check executed")
+	}
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(groupName, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestTrashPurgeRuleAction(t *testing.T) {
+	username := "test_user_trash_purge"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	assert.NoError(t, err)
+	conditions := dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: username,
+			},
+		},
+	}
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeTrashPurge,
+	}
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "don't match",
+			},
+		},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, getErrorString(err), "no trash purge executed")
+	// the trash is not enabled
+	err = executeRuleAction(action, &EventParams{}, conditions)
+	assert.NoError(t, err)
+
+	user.Filters.Trash.Enabled = true
+	user.Filters.Trash.Retention = 1
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	oldItemID := fmt.Sprintf("%d-%s", util.GetTimeAsMsSinceEpoch(time.Now().Add(-48*time.Hour)), xid.New().String())
+	newItemID := newTrashItemID()
+	for _, id := range []string{oldItemID, newItemID} {
+		dirPath := filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName, id, "dir")
+		err = os.MkdirAll(dirPath, os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dirPath, "file.txt"), []byte("data"), 0666)
+		assert.NoError(t, err)
+	}
+	err = executeRuleAction(action, &EventParams{}, conditions)
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName, oldItemID))
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName, newItemID, "dir", "file.txt"))
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestBrokerRuleAction(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// minimal NATS server, the published subject and payload are sent to the channel
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				_, err := conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n"))
+				if err != nil {
+					return
+				}
+				reader := bufio.NewReader(conn)
+				var lines []string
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSpace(line)
+					if line != "PING" {
+						lines = append(lines, line)
+						continue
+					}
+					// CONNECT, PING and then PUB, payload, PING
+					if len(lines) == 3 {
+						messages <- lines[1] + " " + lines[2]
+					}
+					if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	action := dataprovider.BaseEventAction{
+		Name: "broker action",
+		Type: dataprovider.ActionTypeMessageBroker,
+		Options: dataprovider.BaseEventActionOptions{
+			BrokerConfig: dataprovider.EventActionBrokerConfig{
+				Protocol: "nats",
+				Endpoint: listener.Addr().String(),
+				Topic:    "sftpgo.{{Event}}",
+				Timeout:  5,
+				Body:     `{"event":"{{Event}}","name":"{{Name}}"}`,
+			},
+		},
+	}
+	action.Options.SetEmptySecretsIfNil()
+	params := &EventParams{
+		Name:  "test user",
+		Event: operationUpload,
+	}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	msg := <-messages
+	assert.Equal(t, `PUB sftpgo.upload 37 {"event":"upload","name":"test user"}`, msg)
+
+	action.Options.BrokerConfig.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt message broker password")
+	}
+	action.Options.BrokerConfig.Password = kms.NewEmptySecret()
+	err = listener.Close()
+	assert.NoError(t, err)
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "error publishing nats message")
+	}
+}
+
+func TestCloudFunctionRuleAction(t *testing.T) {
+	payloads := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/2015-03-31/functions/ok/invocations":
+			payloads <- string(body)
+			w.Write([]byte(`{"status":"done","error":""}`)) //nolint:errcheck
+		case "/2015-03-31/functions/result_error/invocations":
+			w.Write([]byte(`{"error":"invalid file"}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"Message":"Function not found"}`)) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	action := dataprovider.BaseEventAction{
+		Name: "function action",
+		Type: dataprovider.ActionTypeCloudFunction,
+		Options: dataprovider.BaseEventActionOptions{
+			FunctionConfig: dataprovider.EventActionFunctionConfig{
+				Provider:         "aws_lambda",
+				Function:         "ok",
+				Region:           "eu-west-1",
+				AccessKey:        "key",
+				AccessSecret:     kms.NewPlainSecret("secret"),
+				Endpoint:         server.URL,
+				Timeout:          5,
+				Body:             `{"event":"{{Event}}","name":"{{Name}}"}`,
+				ResultErrorField: "error",
+			},
+		},
+	}
+	action.Options.SetEmptySecretsIfNil()
+	params := &EventParams{
+		Name:  "test user",
+		Event: operationUpload,
+	}
+	err := executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"event":"upload","name":"test user"}`, <-payloads)
+
+	action.Options.FunctionConfig.Function = "result_error"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `cloud function "result_error" returned an error: invalid file`)
+	}
+	action.Options.FunctionConfig.Function = "missing"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "error invoking cloud function")
+	}
+	action.Options.FunctionConfig.AccessSecret = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt cloud function access secret")
+	}
+}
+
 func TestEventRuleActionsNoGroupMatching(t *testing.T) {
 	username := "test_user_action_group_matching"
 	user := dataprovider.User{
@@ -1868,6 +2187,257 @@ func TestEstimateZipSizeErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func getTestPGPKeys(t *testing.T, algo packet.PublicKeyAlgorithm) (string, string) {
+	config := &packet.Config{
+		Algorithm: algo,
+		RSABits:   2048,
+	}
+	if algo == packet.PubKeyAlgoEdDSA {
+		config.Curve = packet.Curve25519
+	}
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", config)
+	require.NoError(t, err)
+	var publicKey, privateKey bytes.Buffer
+	w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	err = entity.Serialize(w)
+	require.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+	w, err = armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	err = entity.SerializePrivate(w, nil)
+	require.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+	return publicKey.String(), privateKey.String()
+}
+
+func TestPGPCurve25519Keys(t *testing.T) {
+	publicKey, privateKey := getTestPGPKeys(t, packet.PubKeyAlgoEdDSA)
+	encryptionKeys, err := (&dataprovider.EventActionFsPGP{
+		PublicKeys: kms.NewPlainSecret(publicKey),
+	}).GetKeys(dataprovider.FilesystemActionPGPEncrypt)
+	require.NoError(t, err)
+	require.Len(t, encryptionKeys, 1)
+	assert.Equal(t, packet.PubKeyAlgoEdDSA, encryptionKeys[0].PrimaryKey.PubKeyAlgo)
+	if assert.Len(t, encryptionKeys[0].Subkeys, 1) {
+		assert.Equal(t, packet.PubKeyAlgoECDH, encryptionKeys[0].Subkeys[0].PublicKey.PubKeyAlgo)
+	}
+	decryptionKeys, err := (&dataprovider.EventActionFsPGP{
+		PrivateKey: kms.NewPlainSecret(privateKey),
+	}).GetKeys(dataprovider.FilesystemActionPGPDecrypt)
+	require.NoError(t, err)
+
+	content := []byte("test PGP content using Curve25519 keys")
+	var encrypted, decrypted bytes.Buffer
+	err = copyPGPEncrypted(&encrypted, bytes.NewReader(content), encryptionKeys, "file.txt")
+	assert.NoError(t, err)
+	err = copyPGPDecrypted(&decrypted, bytes.NewReader(encrypted.Bytes()), decryptionKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+}
+
+func TestPGPActionErrors(t *testing.T) {
+	publicKey, privateKey := getTestPGPKeys(t, packet.PubKeyAlgoRSA)
+	otherPublicKey, _ := getTestPGPKeys(t, packet.PubKeyAlgoRSA)
+
+	_, err := (&dataprovider.EventActionFsPGP{
+		PublicKeys: kms.NewPlainSecret("invalid"),
+	}).GetKeys(dataprovider.FilesystemActionPGPEncrypt)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse PGP public keys")
+	}
+	_, err = (&dataprovider.EventActionFsPGP{
+		PrivateKey: kms.NewPlainSecret("invalid"),
+		Passphrase: kms.NewEmptySecret(),
+	}).GetKeys(dataprovider.FilesystemActionPGPDecrypt)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse PGP private key")
+	}
+	_, err = (&dataprovider.EventActionFsPGP{
+		PrivateKey: kms.NewSecret(sdkkms.SecretStatusSecretBox, "invalid", "", ""),
+	}).GetKeys(dataprovider.FilesystemActionPGPDecrypt)
+	assert.Error(t, err)
+
+	encryptionKeys, err := (&dataprovider.EventActionFsPGP{
+		PublicKeys: kms.NewPlainSecret(publicKey + "\n" + otherPublicKey),
+	}).GetKeys(dataprovider.FilesystemActionPGPEncrypt)
+	require.NoError(t, err)
+	assert.Len(t, encryptionKeys, 2)
+	decryptionKeys, err := (&dataprovider.EventActionFsPGP{
+		PrivateKey: kms.NewPlainSecret(privateKey),
+		// the passphrase is ignored for unencrypted keys
+		Passphrase: kms.NewPlainSecret("passphrase"),
+	}).GetKeys(dataprovider.FilesystemActionPGPDecrypt)
+	require.NoError(t, err)
+
+	content := []byte("test PGP content")
+	var encrypted bytes.Buffer
+	err = copyPGPEncrypted(&encrypted, bytes.NewReader(content), encryptionKeys, "file.txt")
+	require.NoError(t, err)
+	assert.NotEqual(t, content, encrypted.Bytes())
+	var decrypted bytes.Buffer
+	err = copyPGPDecrypted(&decrypted, bytes.NewReader(encrypted.Bytes()), decryptionKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+	// armored message
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, "PGP MESSAGE", nil)
+	require.NoError(t, err)
+	_, err = w.Write(encrypted.Bytes())
+	require.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+	decrypted.Reset()
+	err = copyPGPDecrypted(&decrypted, bytes.NewReader(armored.Bytes()), decryptionKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+	// encrypted for another recipient
+	otherKeys, err := (&dataprovider.EventActionFsPGP{
+		PublicKeys: kms.NewPlainSecret(otherPublicKey),
+	}).GetKeys(dataprovider.FilesystemActionPGPEncrypt)
+	require.NoError(t, err)
+	encrypted.Reset()
+	err = copyPGPEncrypted(&encrypted, bytes.NewReader(content), otherKeys, "file.txt")
+	require.NoError(t, err)
+	err = copyPGPDecrypted(io.Discard, bytes.NewReader(encrypted.Bytes()), decryptionKeys)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to PGP decrypt")
+	}
+	err = copyPGPDecrypted(io.Discard, strings.NewReader(pgpArmorPrefix+" MESSAGE-----\ninvalid"), decryptionKeys)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decode PGP armored message")
+	}
+	err = copyPGPDecrypted(io.Discard, bytes.NewReader(content), decryptionKeys)
+	assert.Error(t, err)
+
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "pgp_user",
+			HomeDir:  filepath.Join(os.TempDir(), "pgp_user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&u, "", "", "")
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(u.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(u.GetHomeDir(), "file.txt"), content, 0666)
+	assert.NoError(t, err)
+	conn := NewBaseConnection("", protocolEventAction, "", "", u)
+	err = executePGPFileAction(conn, "/missing", "/missing.pgp", encryptionKeys, dataprovider.FilesystemActionPGPEncrypt)
+	assert.True(t, conn.IsNotExistError(err))
+	err = executePGPFileAction(conn, "/dir", "/dir.pgp", encryptionKeys, dataprovider.FilesystemActionPGPEncrypt)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not a regular file")
+	}
+	err = executePGPFileAction(conn, "/file.txt", "/dir", encryptionKeys, dataprovider.FilesystemActionPGPEncrypt)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot write to a directory")
+	}
+	err = executePGPFileAction(conn, "/file.txt", "/file.dec", decryptionKeys, dataprovider.FilesystemActionPGPDecrypt)
+	assert.Error(t, err)
+	err = executePGPFsActionForUser(dataprovider.EventActionFsPGP{
+		Paths: []dataprovider.KeyValue{
+			{
+				Key:   "/{{VirtualPath}}",
+				Value: "/file.txt",
+			},
+		},
+		PublicKeys: kms.NewPlainSecret(publicKey),
+	}, dataprovider.FilesystemActionPGPEncrypt, strings.NewReplacer("{{VirtualPath}}", "file.txt"), u)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "PGP source and target cannot be equal")
+	}
+	err = executePGPFsActionForUser(dataprovider.EventActionFsPGP{
+		PublicKeys: kms.NewPlainSecret("invalid"),
+	}, dataprovider.FilesystemActionPGPEncrypt, strings.NewReplacer(), u)
+	assert.Error(t, err)
+
+	err = executeFsRuleAction(dataprovider.EventActionFilesystemConfig{
+		Type: dataprovider.FilesystemActionPGPEncrypt,
+		PGP: dataprovider.EventActionFsPGP{
+			Paths: []dataprovider.KeyValue{
+				{
+					Key:   "/file.txt",
+					Value: "/file.txt.pgp",
+				},
+			},
+			PublicKeys: kms.NewPlainSecret(publicKey),
+		},
+	}, dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "no match",
+			},
+		},
+	}, &EventParams{})
+	if assert.Error(t, err) {
+		assert.Contains(t, getErrorString(err), "no PGP action executed")
+	}
+
+	err = dataprovider.DeleteUser(u.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestQuotaThresholdEvents(t *testing.T) {
+	assert.False(t, isQuotaThresholdCrossed(0, 0, 100, 100))
+	assert.False(t, isQuotaThresholdCrossed(50, 0, 100, 0))
+	assert.True(t, isQuotaThresholdCrossed(50, 40, 50, 100))
+	assert.True(t, isQuotaThresholdCrossed(50, 0, 100, 100))
+	assert.False(t, isQuotaThresholdCrossed(50, 50, 60, 100))
+	assert.False(t, isQuotaThresholdCrossed(50, 10, 49, 100))
+	assert.True(t, isQuotaThresholdCrossed(100, 99, 101, 100))
+
+	conditions := dataprovider.EventConditions{
+		QuotaThreshold: 80,
+		Options: dataprovider.ConditionOptions{
+			Names: []dataprovider.ConditionPattern{
+				{
+					Pattern: "folder*",
+				},
+			},
+			ProviderObjects: []string{"folder"},
+		},
+	}
+	params := EventParams{
+		Name:          "user",
+		ObjectName:    "folder1",
+		ObjectType:    "folder",
+		FileSize:      20,
+		UsedQuotaSize: 90,
+		QuotaSize:     100,
+	}
+	assert.True(t, eventManager.checkQuotaThresholdMatch(conditions, params))
+	params.FileSize = 5
+	assert.False(t, eventManager.checkQuotaThresholdMatch(conditions, params))
+	params.FileSize = 20
+	params.ObjectType = "user"
+	assert.False(t, eventManager.checkQuotaThresholdMatch(conditions, params))
+	params.ObjectType = "folder"
+	params.ObjectName = "user"
+	assert.False(t, eventManager.checkQuotaThresholdMatch(conditions, params))
+
+	rule := dataprovider.EventRule{
+		Name:       "quota threshold rule",
+		Status:     1,
+		Trigger:    dataprovider.EventTriggerQuotaThreshold,
+		Conditions: conditions,
+	}
+	rules := eventRulesContainer{}
+	assert.False(t, rules.hasQuotaThresholdRules())
+	rules.addUpdateRuleInternal(rule)
+	assert.True(t, rules.hasQuotaThresholdRules())
+	rules.removeRuleInternal(rule.Name)
+	assert.False(t, rules.hasQuotaThresholdRules())
+}
+
 func TestOnDemandRule(t *testing.T) {
 	a := &dataprovider.BaseEventAction{
 		Name:    "a",
@@ -1985,3 +2555,283 @@ func TestHTTPEndpointWithPlaceholders(t *testing.T) {
 	expected = c.Endpoint + "?p=" + url.QueryEscape(vPath) + "&u=" + url.QueryEscape(name)
 	assert.Equal(t, expected, u)
 }
+
+func TestChainedRuleActions(t *testing.T) {
+	var failures atomic.Int32
+	notifications := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/scan":
+			w.Write([]byte("clean\n")) //nolint:errcheck
+		case "/fail":
+			failures.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			notifications <- string(body)
+		}
+	}))
+	defer server.Close()
+
+	getHTTPAction := func(name, endpoint, body string, order int, options dataprovider.EventActionOptions) dataprovider.EventAction {
+		action := dataprovider.EventAction{
+			BaseEventAction: dataprovider.BaseEventAction{
+				Name: name,
+				Type: dataprovider.ActionTypeHTTP,
+				Options: dataprovider.BaseEventActionOptions{
+					HTTPConfig: dataprovider.EventActionHTTPConfig{
+						Endpoint: server.URL + endpoint,
+						Timeout:  5,
+						Method:   http.MethodPost,
+						Body:     body,
+					},
+				},
+			},
+			Order:   order,
+			Options: options,
+		}
+		action.BaseEventAction.Options.SetEmptySecretsIfNil()
+		return action
+	}
+	rule := dataprovider.EventRule{
+		Name: "chained rule",
+		Actions: []dataprovider.EventAction{
+			getHTTPAction("scan", "/scan", "", 1, dataprovider.EventActionOptions{}),
+			getHTTPAction("notify scan", "/notify", "{{Steps.scan.Status}} {{Steps.scan.Output}}", 2,
+				dataprovider.EventActionOptions{
+					DependsOn: "scan",
+					RunOn:     dataprovider.ActionRunOnSuccess,
+				}),
+			getHTTPAction("deliver", "/fail", "", 3, dataprovider.EventActionOptions{
+				Retries: 2,
+			}),
+			getHTTPAction("quarantine", "/notify", "{{Steps.deliver.Status}}: {{Steps.deliver.Error}}", 4,
+				dataprovider.EventActionOptions{
+					DependsOn: "deliver",
+					RunOn:     dataprovider.ActionRunOnFailure,
+				}),
+			getHTTPAction("scan failed", "/notify", "unexpected", 5, dataprovider.EventActionOptions{
+				DependsOn: "notify scan",
+				RunOn:     dataprovider.ActionRunOnFailure,
+			}),
+			getHTTPAction("after skipped", "/notify", "unexpected", 6, dataprovider.EventActionOptions{
+				DependsOn: "scan failed",
+				RunOn:     dataprovider.ActionRunOnCompletion,
+			}),
+			getHTTPAction("failure", "/notify", "unexpected", 7, dataprovider.EventActionOptions{
+				IsFailureAction: true,
+			}),
+		},
+	}
+	params := &EventParams{
+		Name:  "user",
+		Event: operationUpload,
+	}
+	executeRuleAsyncActions(rule, params, nil)
+	assert.Equal(t, int32(3), failures.Load())
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "success clean", <-notifications)
+	assert.Equal(t, `failure: action "deliver" failed: unexpected status code: 500`, <-notifications)
+
+	assert.Equal(t, stepStatusSuccess, params.steps["scan"].Status)
+	assert.Equal(t, "clean", params.steps["scan"].Output)
+	assert.Equal(t, stepStatusFailure, params.steps["deliver"].Status)
+	assert.Equal(t, stepStatusSkipped, params.steps["scan failed"].Status)
+	assert.Equal(t, stepStatusSkipped, params.steps["after skipped"].Status)
+	// the failure is not handled, the failure action must be executed
+	rule.Actions[3].Options.RunOn = dataprovider.ActionRunOnSuccess
+	rule.Actions[2].Options.Retries = 0
+	params = &EventParams{}
+	executeRuleAsyncActions(rule, params, nil)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "success clean", <-notifications)
+	assert.Equal(t, "unexpected", <-notifications)
+	assert.Equal(t, stepStatusSkipped, params.steps["quarantine"].Status)
+	assert.Equal(t, stepStatusSuccess, params.steps["failure"].Status)
+}
+
+func TestChainedActionRetryDelay(t *testing.T) {
+	options := dataprovider.EventActionOptions{
+		Retries:    5,
+		RetryDelay: 10,
+	}
+	assert.Equal(t, 10*time.Second, options.GetRetryDelay(1))
+	assert.Equal(t, 20*time.Second, options.GetRetryDelay(2))
+	assert.Equal(t, 40*time.Second, options.GetRetryDelay(3))
+	assert.Equal(t, 300*time.Second, options.GetRetryDelay(8))
+	params := &EventParams{}
+	assert.True(t, params.canExecuteAction(dataprovider.EventActionOptions{}))
+	assert.False(t, params.canExecuteAction(dataprovider.EventActionOptions{
+		DependsOn: "missing",
+		RunOn:     dataprovider.ActionRunOnCompletion,
+	}))
+	params.setStepOutput(bytes.Repeat([]byte("a"), maxStepOutputSize+10))
+	assert.Len(t, params.stepOutput, maxStepOutputSize)
+	params.addStep("a", stepStatusSuccess, nil)
+	assert.Empty(t, params.stepOutput)
+	replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+	assert.Equal(t, "success", replacer.Replace("{{Steps.a.Status}}"))
+}
+
+func TestFileContentInfo(t *testing.T) {
+	info := &fileContentInfo{}
+	err := info.load(strings.NewReader("a\nb\nc"), 512, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, info.lines)
+	err = info.load(strings.NewReader("a\nb\n"), 512, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.lines)
+	err = info.load(strings.NewReader(""), 512, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, info.lines)
+	// lines after the head
+	content := strings.Repeat("line\n", 1000) + "last"
+	err = info.load(strings.NewReader(content), 10, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1001, info.lines)
+	assert.Len(t, info.head, 10)
+	err = info.load(iotest.ErrReader(errors.New("read error")), 10, true)
+	assert.Error(t, err)
+
+	err = info.load(strings.NewReader("\xef\xbb\xbfID;Name, Amount \n1;a,10\n"), 512, false)
+	assert.NoError(t, err)
+	assert.True(t, info.match(dataprovider.FileContentConditions{
+		MIMETypes: []string{"text/*"},
+		CSVHeader: []string{"id;name", "amount"},
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		CSVHeader: []string{"id;name"},
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		CSVHeader: []string{"id", "name", "amount"},
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		MIMETypes: []string{"application/pdf", "image/*"},
+	}))
+	assert.True(t, info.match(dataprovider.FileContentConditions{
+		MinLines: 2,
+		MaxLines: 2,
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		MinLines: 3,
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		MaxLines: 1,
+	}))
+
+	err = info.load(strings.NewReader("ISA*00*~GS*PO*~ST*850*0001~"), 65536, false)
+	assert.NoError(t, err)
+	assert.True(t, info.match(dataprovider.FileContentConditions{
+		Pattern: `ST\*850\*`,
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		Pattern: `ST\*810\*`,
+	}))
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		Pattern: `[`,
+	}))
+	// the pattern is searched within the scan size only
+	err = info.load(strings.NewReader(strings.Repeat(" ", 2048)+"ST*850*"), 65536, false)
+	assert.NoError(t, err)
+	assert.False(t, info.match(dataprovider.FileContentConditions{
+		Pattern:  `ST\*850\*`,
+		ScanSize: 1,
+	}))
+	assert.True(t, info.match(dataprovider.FileContentConditions{
+		Pattern:  `ST\*850\*`,
+		ScanSize: 4,
+	}))
+}
+
+func TestFilterRulesByFileContent(t *testing.T) {
+	username := "test_user_content"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "po.edi"), []byte("ISA*00*~\nST*850*0001~\n"), 0666)
+	assert.NoError(t, err)
+
+	rules := []dataprovider.EventRule{
+		{
+			Name: "no content",
+		},
+		{
+			Name: "purchase orders",
+			Conditions: dataprovider.EventConditions{
+				Options: dataprovider.ConditionOptions{
+					Content: dataprovider.FileContentConditions{
+						MIMETypes: []string{"text/plain"},
+						Pattern:   `ST\*850\*`,
+					},
+				},
+			},
+		},
+		{
+			Name: "invoices",
+			Conditions: dataprovider.EventConditions{
+				Options: dataprovider.ConditionOptions{
+					Content: dataprovider.FileContentConditions{
+						Pattern: `ST\*810\*`,
+					},
+				},
+			},
+		},
+		{
+			Name: "big files",
+			Conditions: dataprovider.EventConditions{
+				Options: dataprovider.ConditionOptions{
+					Content: dataprovider.FileContentConditions{
+						MinLines: 10,
+					},
+				},
+			},
+		},
+	}
+	params := &EventParams{
+		Name:        username,
+		Event:       operationUpload,
+		VirtualPath: "/po.edi",
+		sender:      username,
+	}
+	res := filterRulesByFileContent(rules, params)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, "no content", res[0].Name)
+		assert.Equal(t, "purchase orders", res[1].Name)
+	}
+	// content conditions are ignored for other events
+	params.Event = operationRename
+	res = filterRulesByFileContent(rules, params)
+	assert.Len(t, res, 4)
+	// missing file
+	params.Event = operationDownload
+	params.VirtualPath = "/missing.edi"
+	res = filterRulesByFileContent(rules, params)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "no content", res[0].Name)
+	}
+	// missing user
+	params.sender = "missing user"
+	params.VirtualPath = "/po.edi"
+	res = filterRulesByFileContent(rules, params)
+	assert.Len(t, res, 1)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+const (
+	// the rendered templates cannot exceed this size
+	maxEventTemplateOutputSize = 1048576
+)
+
+var errEventTemplateTooLarge = errors.New("the rendered template exceeds the maximum allowed size")
+
+// eventTemplateData defines the data available inside event action templates
+type eventTemplateData struct {
+	Name                 string
+	Groups               []string
+	Event                string
+	Status               int
+	StatusString         string
+	VirtualPath          string
+	VirtualDirPath       string
+	FsPath               string
+	VirtualTargetPath    string
+	VirtualTargetDirPath string
+	TargetName           string
+	FsTargetPath         string
+	ObjectName           string
+	ObjectType           string
+	FileSize             int64
+	ResumeOffset         int64
+	Elapsed              int64
+	Protocol             string
+	IP                   string
+	Role                 string
+	Timestamp            time.Time
+	UsedQuotaSize        int64
+	QuotaSize            int64
+	QuotaThreshold       int
+	// Files contains the virtual paths affected by the event
+	Files           []string
+	Errors          []string
+	ErrorString     string
+	Steps           map[string]executedStep
+	RetentionChecks []executedRetentionCheck
+	params          *EventParams
+}
+
+// ObjectData returns the affected object serialized as JSON, the
+// object is rendered only if the template requires it
+func (d *eventTemplateData) ObjectData() string {
+	if d.params.Object == nil {
+		return ""
+	}
+	data, err := d.params.Object.RenderAsJSON(d.params.Event != operationDelete)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (p *EventParams) getTemplateData() *eventTemplateData {
+	data := &eventTemplateData{
+		Name:              p.Name,
+		Event:             p.Event,
+		Status:            p.Status,
+		StatusString:      p.getStatusString(),
+		VirtualPath:       p.VirtualPath,
+		FsPath:            p.FsPath,
+		VirtualTargetPath: p.VirtualTargetPath,
+		FsTargetPath:      p.FsTargetPath,
+		ObjectName:        p.ObjectName,
+		ObjectType:        p.ObjectType,
+		FileSize:          p.FileSize,
+		ResumeOffset:      p.ResumeOffset,
+		Elapsed:           p.Elapsed,
+		Protocol:          p.Protocol,
+		IP:                p.IP,
+		Role:              p.Role,
+		Timestamp:         time.Unix(0, p.Timestamp).UTC(),
+		UsedQuotaSize:     p.UsedQuotaSize,
+		QuotaSize:         p.QuotaSize,
+		QuotaThreshold:    p.QuotaThreshold,
+		Errors:            p.errors,
+		ErrorString:       strings.Join(p.errors, ", "),
+		Steps:             p.steps,
+		RetentionChecks:   p.retentionChecks,
+		params:            p,
+	}
+	for _, g := range p.Groups {
+		data.Groups = append(data.Groups, g.Name)
+	}
+	if p.VirtualPath != "" {
+		data.VirtualDirPath = path.Dir(p.VirtualPath)
+		data.Files = append(data.Files, p.VirtualPath)
+	}
+	if p.VirtualTargetPath != "" {
+		data.VirtualTargetDirPath = path.Dir(p.VirtualTargetPath)
+		data.TargetName = path.Base(p.VirtualTargetPath)
+		data.Files = append(data.Files, p.VirtualTargetPath)
+	}
+	return data
+}
+
+type eventTemplateWriter struct {
+	strings.Builder
+}
+
+func (w *eventTemplateWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > maxEventTemplateOutputSize {
+		return 0, errEventTemplateTooLarge
+	}
+	return w.Builder.Write(p)
+}
+
+// renderEventTemplate executes the specified text as Go template using
+// the event params as data
+func renderEventTemplate(name, text string, data *eventTemplateData) (string, error) {
+	tmpl, err := dataprovider.ParseEventTemplate(name, text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse %s template: %w", name, err)
+	}
+	var w eventTemplateWriter
+	if err := tmpl.Execute(&w, data); err != nil {
+		return "", fmt.Errorf("unable to render %s template: %w", name, err)
+	}
+	return w.String(), nil
+}
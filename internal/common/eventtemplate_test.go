@@ -0,0 +1,193 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestRenderEventTemplate(t *testing.T) {
+	timestamp := time.Date(2023, 5, 10, 12, 30, 0, 0, time.UTC)
+	params := &EventParams{
+		Name:              "user",
+		Groups:            []sdk.GroupMapping{{Name: "g1"}, {Name: "g2"}},
+		Event:             operationRename,
+		Status:            1,
+		VirtualPath:       "/dir/file.txt",
+		VirtualTargetPath: "/other/file.TXT",
+		FileSize:          1536,
+		Timestamp:         timestamp.UnixNano(),
+		Object: &dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: "user",
+			},
+		},
+	}
+	params.AddError(io.ErrUnexpectedEOF)
+	data := params.getTemplateData()
+	assert.Equal(t, []string{"/dir/file.txt", "/other/file.TXT"}, data.Files)
+	assert.Equal(t, "/other", data.VirtualTargetDirPath)
+	assert.Equal(t, "file.TXT", data.TargetName)
+
+	testCases := []struct {
+		template string
+		expected string
+	}{
+		{`{{.Name | upper}} {{.Event | lower}} {{.StatusString}}`, "USER rename OK"},
+		{`{{range .Files}}{{base .}};{{end}}`, "file.txt;file.TXT;"},
+		{`{{.VirtualPath | regexReplace "^/dir" "/archive"}}`, "/archive/file.txt"},
+		{`{{.Files | toJson}}`, `["/dir/file.txt","/other/file.TXT"]`},
+		{`{{.Groups | join ","}}`, "g1,g2"},
+		{`{{.Timestamp | date "2006-01-02 15:04"}}`, "2023-05-10 12:30"},
+		{`{{.Timestamp | dateModify "-24h" | date "2006-01-02"}}`, "2023-05-09"},
+		{`{{.FileSize | formatBytes}}`, "1.5 KiB"},
+		{`{{.ObjectType | default "none"}}`, "none"},
+		{`{{if .VirtualPath | hasSuffix ".txt"}}text{{end}}`, "text"},
+		{`{{.ErrorString}}`, io.ErrUnexpectedEOF.Error()},
+		{`{{.VirtualPath | urlEscape}}`, "%2Fdir%2Ffile.txt"},
+	}
+	for _, tc := range testCases {
+		res, err := renderEventTemplate("test", tc.template, data)
+		if assert.NoError(t, err, tc.template) {
+			assert.Equal(t, tc.expected, res, tc.template)
+		}
+	}
+	// the object is not reloaded from the provider for delete events
+	params.Event = operationDelete
+	res, err := renderEventTemplate("test", `{{.ObjectData}}`, data)
+	assert.NoError(t, err)
+	assert.Contains(t, res, `"username":"user"`)
+	params.Object = nil
+	res, err = renderEventTemplate("test", `{{.ObjectData}}`, data)
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+
+	_, err = renderEventTemplate("test", `{{.Name`, data)
+	assert.ErrorContains(t, err, "unable to parse")
+	_, err = renderEventTemplate("test", `{{env "HOME"}}`, data)
+	assert.ErrorContains(t, err, "unable to parse")
+	_, err = renderEventTemplate("test", `{{.Missing}}`, data)
+	assert.ErrorContains(t, err, "unable to render")
+	_, err = renderEventTemplate("test", `{{.Name | regexReplace "(" ""}}`, data)
+	assert.ErrorContains(t, err, "unable to render")
+	_, err = renderEventTemplate("test", `{{printf "%01048577d" 0}}`, data)
+	assert.ErrorIs(t, err, errEventTemplateTooLarge)
+}
+
+func TestTextTemplateRuleActions(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body = string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	params := &EventParams{
+		Name:        "user",
+		Event:       operationUpload,
+		VirtualPath: "/dir/file.txt",
+		Timestamp:   time.Now().UnixNano(),
+	}
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint:     server.URL,
+				Timeout:      5,
+				Method:       http.MethodPost,
+				Body:         `{"user":{{.Name | toJson}},"files":{{.Files | toJson}}}`,
+				TextTemplate: true,
+			},
+		},
+	}
+	err := executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":"user","files":["/dir/file.txt"]}`, body)
+	action.Options.HTTPConfig.Body = `{{.Missing}}`
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.ErrorContains(t, err, "unable to render HTTP body template")
+
+	if runtime.GOOS != osWindows {
+		outFile := filepath.Join(os.TempDir(), "event_template_env")
+		cmdPath := filepath.Join(os.TempDir(), "event_template.sh")
+		err = os.WriteFile(cmdPath, []byte("#!/bin/sh\necho -n \"$SFTPGO_FILE\" > "+outFile+"\n"), 0755)
+		require.NoError(t, err)
+		action = dataprovider.BaseEventAction{
+			Type: dataprovider.ActionTypeCommand,
+			Options: dataprovider.BaseEventActionOptions{
+				CmdConfig: dataprovider.EventActionCommandConfig{
+					Cmd:     cmdPath,
+					Timeout: 10,
+					EnvVars: []dataprovider.KeyValue{
+						{
+							Key:   "SFTPGO_FILE",
+							Value: `{{.VirtualPath | base | upper}}`,
+						},
+					},
+					TextTemplate: true,
+				},
+			},
+		}
+		err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+		assert.NoError(t, err)
+		data, err := os.ReadFile(outFile)
+		assert.NoError(t, err)
+		assert.Equal(t, "FILE.TXT", string(data))
+		action.Options.CmdConfig.EnvVars[0].Value = `{{.Missing}}`
+		err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+		assert.ErrorContains(t, err, "unable to render")
+		err = os.Remove(cmdPath)
+		assert.NoError(t, err)
+		err = os.Remove(outFile)
+		assert.NoError(t, err)
+	}
+
+	body, subject, err := getEmailRuleActionContent(dataprovider.EventActionEmailConfig{
+		Subject:      `{{.Event}} {{.Name}}`,
+		Body:         `{{range .Files}}{{.}}{{end}}`,
+		TextTemplate: true,
+	}, strings.NewReplacer(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir/file.txt", body)
+	assert.Equal(t, "upload user", subject)
+	_, _, err = getEmailRuleActionContent(dataprovider.EventActionEmailConfig{
+		Subject:      `{{.Missing}}`,
+		Body:         `body`,
+		TextTemplate: true,
+	}, strings.NewReplacer(), params)
+	assert.ErrorContains(t, err, "email subject")
+	_, _, err = getEmailRuleActionContent(dataprovider.EventActionEmailConfig{
+		Subject:      `subject`,
+		Body:         `{{.Missing}}`,
+		TextTemplate: true,
+	}, strings.NewReplacer(), params)
+	assert.ErrorContains(t, err, "email body")
+}
@@ -0,0 +1,450 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultIPApprovalExpiration = 24
+	// maximum number of pending requests for each user, this limits the
+	// notifications sent if the credentials are used from many addresses
+	ipApprovalMaxPendingRequests = 5
+	// IPApprovalWebClientPath is the path, relative to the WebClient base URL,
+	// to approve a pending request
+	IPApprovalWebClientPath = "/ipapproval"
+)
+
+var (
+	ipApprovals = newIPApprovalManager(dataprovider.MemoryDataProviderName)
+)
+
+// IPApprovalConfig defines the configuration for the approval of logins, with
+// valid credentials, from IP addresses not included in the users' allowed lists
+type IPApprovalConfig struct {
+	// Base URL of the WebClient used to build the approval links sent via email,
+	// for example "https://sftpgo.example.com/web/client".
+	// Approval emails are not sent if empty
+	WebClientURL string `json:"webclient_url" mapstructure:"webclient_url"`
+	// Validity of the approval requests, in hours. 0 means the default (24)
+	Expiration int `json:"expiration" mapstructure:"expiration"`
+}
+
+func (c *IPApprovalConfig) validate() error {
+	if c.Expiration < 0 {
+		return fmt.Errorf("invalid IP approval expiration: %d", c.Expiration)
+	}
+	if c.WebClientURL != "" {
+		u, err := url.Parse(c.WebClientURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid IP approval WebClient URL %q", c.WebClientURL)
+		}
+		c.WebClientURL = strings.TrimRight(c.WebClientURL, "/")
+	}
+	return nil
+}
+
+func (c *IPApprovalConfig) getExpiration() time.Duration {
+	if c.Expiration <= 0 {
+		return defaultIPApprovalExpiration * time.Hour
+	}
+	return time.Duration(c.Expiration) * time.Hour
+}
+
+func (c *IPApprovalConfig) getApprovalURL(id string) string {
+	return fmt.Sprintf("%s%s/%s", c.WebClientURL, IPApprovalWebClientPath, url.PathEscape(id))
+}
+
+// IPApprovalRequest defines a pending request to add an IP address to
+// the allowed list of a user
+type IPApprovalRequest struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+	// Approval mode configured for the user when the request was created
+	Mode      string `json:"mode"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// GetCreatedAtAsString returns the creation time formatted as string
+func (r *IPApprovalRequest) GetCreatedAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(r.CreatedAt).UTC().Format("2006-01-02 15:04")
+}
+
+// GetExpiresAtAsString returns the expiration time formatted as string
+func (r *IPApprovalRequest) GetExpiresAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(r.ExpiresAt).UTC().Format("2006-01-02 15:04")
+}
+
+func (r *IPApprovalRequest) isExpired() bool {
+	return r.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// getAllowedIP returns the IP address in CIDR format
+func (r *IPApprovalRequest) getAllowedIP() string {
+	ip := net.ParseIP(r.IP)
+	if ip != nil && ip.To4() != nil {
+		return r.IP + "/32"
+	}
+	return r.IP + "/128"
+}
+
+type ipApprovalStore interface {
+	add(req *IPApprovalRequest) error
+	get(id string) (IPApprovalRequest, error)
+	getAll() ([]IPApprovalRequest, error)
+	remove(id string) error
+	cleanup()
+}
+
+func newIPApprovalManager(driver string) *ipApprovalManager {
+	var store ipApprovalStore
+	switch driver {
+	case dataprovider.MemoryDataProviderName, dataprovider.BoltDataProviderName:
+		store = &memoryIPApprovalStore{
+			requests: make(map[string]IPApprovalRequest),
+		}
+	default:
+		store = &dbIPApprovalStore{}
+	}
+	return &ipApprovalManager{
+		store: store,
+	}
+}
+
+type ipApprovalManager struct {
+	// serializes the additions, so we don't create duplicate requests
+	mu    sync.Mutex
+	store ipApprovalStore
+}
+
+func (m *ipApprovalManager) add(user *dataprovider.User, ip, protocol string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests, err := m.store.getAll()
+	if err != nil {
+		logger.Error(logSender, "", "unable to get the pending IP approval requests: %v", err)
+		return
+	}
+	pending := 0
+	for _, req := range requests {
+		if req.Username != user.Username || req.isExpired() {
+			continue
+		}
+		if req.IP == ip {
+			logger.Debug(logSender, "", "IP approval request for user %q and IP %q already pending", user.Username, ip)
+			return
+		}
+		pending++
+	}
+	if pending >= ipApprovalMaxPendingRequests {
+		logger.Warn(logSender, "", "too many pending IP approval requests for user %q, request for IP %q ignored",
+			user.Username, ip)
+		return
+	}
+	now := time.Now()
+	req := &IPApprovalRequest{
+		ID:        util.GenerateUniqueID(),
+		Username:  user.Username,
+		Role:      user.Role,
+		IP:        ip,
+		Protocol:  protocol,
+		Mode:      user.Filters.IPApproval,
+		CreatedAt: util.GetTimeAsMsSinceEpoch(now),
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(now.Add(Config.IPApproval.getExpiration())),
+	}
+	if err := m.store.add(req); err != nil {
+		logger.Error(logSender, "", "unable to add IP approval request for user %q, IP %q: %v", user.Username, ip, err)
+		return
+	}
+	logger.Info(logSender, "", "IP approval request %q added for user %q, IP %q, protocol %q, mode %q",
+		req.ID, user.Username, ip, protocol, req.Mode)
+	if req.Mode == dataprovider.IPApprovalModeEmail {
+		m.sendEmail(req, user.Email)
+	}
+}
+
+func (m *ipApprovalManager) sendEmail(req *IPApprovalRequest, email string) {
+	if email == "" || Config.IPApproval.WebClientURL == "" || !smtp.IsEnabled() {
+		logger.Debug(logSender, "", "unable to send the IP approval email for request %q, user %q: email, "+
+			"WebClient URL or SMTP not configured", req.ID, req.Username)
+		return
+	}
+	subject := fmt.Sprintf("New login attempt for user %q from %s", req.Username, req.IP)
+	body := fmt.Sprintf("A login to your account %q was attempted, with valid credentials, from the IP address %s "+
+		"using the protocol %s. This IP address is not included in your allowed list.\r\n\r\n"+
+		"If this was you, open the following link to allow this IP address:\r\n\r\n%s\r\n\r\n"+
+		"The link expires on %s UTC. If this wasn't you, ignore this email and change your password.",
+		req.Username, req.IP, req.Protocol, Config.IPApproval.getApprovalURL(req.ID), req.GetExpiresAtAsString())
+	startTime := time.Now()
+	if err := smtp.SendEmail([]string{email}, subject, body, smtp.EmailContentTypeTextPlain); err != nil {
+		logger.Warn(logSender, "", "unable to send IP approval email for request %q, user %q: %v, elapsed: %s",
+			req.ID, req.Username, err, time.Since(startTime))
+		return
+	}
+	logger.Debug(logSender, "", "IP approval email sent for request %q, user %q, elapsed: %s",
+		req.ID, req.Username, time.Since(startTime))
+}
+
+func (m *ipApprovalManager) get(id, role string) (IPApprovalRequest, error) {
+	req, err := m.store.get(id)
+	if err != nil {
+		return req, err
+	}
+	if req.isExpired() || (role != "" && req.Role != role) {
+		return IPApprovalRequest{}, util.NewRecordNotFoundError(fmt.Sprintf("IP approval request %q not found", id))
+	}
+	return req, nil
+}
+
+func (m *ipApprovalManager) getAll(role string) ([]IPApprovalRequest, error) {
+	requests, err := m.store.getAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]IPApprovalRequest, 0, len(requests))
+	for _, req := range requests {
+		if req.isExpired() || (role != "" && req.Role != role) {
+			continue
+		}
+		result = append(result, req)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt < result[j].CreatedAt
+	})
+	return result, nil
+}
+
+func (m *ipApprovalManager) approve(id, executor, ipAddress, role string) (IPApprovalRequest, error) {
+	req, err := m.get(id, role)
+	if err != nil {
+		return req, err
+	}
+	user, err := dataprovider.UserExists(req.Username, role)
+	if err != nil {
+		return req, err
+	}
+	if user.Filters.IPApproval == "" {
+		return req, util.NewValidationError(fmt.Sprintf("IP approval is disabled for user %q", user.Username))
+	}
+	if !user.IsLoginFromAddrAllowed(req.IP) {
+		user.Filters.AllowedIP = append(user.Filters.AllowedIP, req.getAllowedIP())
+		if err := dataprovider.UpdateUser(&user, executor, ipAddress, role); err != nil {
+			return req, err
+		}
+	}
+	if err := m.store.remove(req.ID); err != nil {
+		logger.Warn(logSender, "", "unable to remove approved IP approval request %q: %v", req.ID, err)
+	}
+	logger.Info(logSender, "", "IP approval request %q approved, IP %q added to the allowed list of user %q, executor %q",
+		req.ID, req.IP, req.Username, executor)
+	return req, nil
+}
+
+type memoryIPApprovalStore struct {
+	sync.RWMutex
+	requests map[string]IPApprovalRequest
+}
+
+func (s *memoryIPApprovalStore) add(req *IPApprovalRequest) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests[req.ID] = *req
+	return nil
+}
+
+func (s *memoryIPApprovalStore) get(id string) (IPApprovalRequest, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	req, ok := s.requests[id]
+	if !ok {
+		return req, util.NewRecordNotFoundError(fmt.Sprintf("IP approval request %q not found", id))
+	}
+	return req, nil
+}
+
+func (s *memoryIPApprovalStore) getAll() ([]IPApprovalRequest, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	requests := make([]IPApprovalRequest, 0, len(s.requests))
+	for _, req := range s.requests {
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func (s *memoryIPApprovalStore) remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.requests[id]; !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("IP approval request %q not found", id))
+	}
+	delete(s.requests, id)
+	return nil
+}
+
+func (s *memoryIPApprovalStore) cleanup() {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, req := range s.requests {
+		if req.isExpired() {
+			delete(s.requests, id)
+		}
+	}
+}
+
+// dbIPApprovalStore persists the approval requests as shared sessions so they
+// survive restarts and can be approved from any SFTPGo instance
+type dbIPApprovalStore struct{}
+
+func (s *dbIPApprovalStore) add(req *IPApprovalRequest) error {
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       req.ID,
+		Data:      req,
+		Type:      dataprovider.SessionTypeIPApproval,
+		Timestamp: req.ExpiresAt,
+	})
+}
+
+func (s *dbIPApprovalStore) get(id string) (IPApprovalRequest, error) {
+	session, err := dataprovider.GetSharedSession(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IPApprovalRequest{}, util.NewRecordNotFoundError(fmt.Sprintf("IP approval request %q not found", id))
+		}
+		return IPApprovalRequest{}, err
+	}
+	if session.Type != dataprovider.SessionTypeIPApproval {
+		return IPApprovalRequest{}, util.NewRecordNotFoundError(fmt.Sprintf("IP approval request %q not found", id))
+	}
+	return s.decodeData(session.Data)
+}
+
+func (s *dbIPApprovalStore) getAll() ([]IPApprovalRequest, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeIPApproval)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]IPApprovalRequest, 0, len(sessions))
+	for _, session := range sessions {
+		req, err := s.decodeData(session.Data)
+		if err != nil {
+			logger.Error(logSender, "", "unable to decode IP approval request %q: %v", session.Key, err)
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func (s *dbIPApprovalStore) remove(id string) error {
+	if _, err := s.get(id); err != nil {
+		return err
+	}
+	return dataprovider.DeleteSharedSession(id)
+}
+
+func (s *dbIPApprovalStore) cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeIPApproval, time.Now()) //nolint:errcheck
+}
+
+func (s *dbIPApprovalStore) decodeData(data any) (IPApprovalRequest, error) {
+	var req IPApprovalRequest
+	val, ok := data.([]byte)
+	if !ok {
+		return req, fmt.Errorf("invalid IP approval request data type %T", data)
+	}
+	err := json.Unmarshal(val, &req)
+	return req, err
+}
+
+func cleanupIPApprovals() {
+	ipApprovals.store.cleanup()
+}
+
+// RequestIPApproval creates an approval request for a login, with valid
+// credentials, denied because the IP address is not included in the allowed
+// list of the user. Nothing is done if the IP approval is disabled for the user.
+// For the email approval mode an email with the approval link is sent to the user
+func RequestIPApproval(user *dataprovider.User, remoteAddr, protocol string) {
+	if !user.CanRequestIPApproval(remoteAddr) {
+		return
+	}
+	// the user could be modified by the caller after we return,
+	// we only need these fields
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: user.Username,
+			Email:    user.Email,
+			Role:     user.Role,
+		},
+		Filters: dataprovider.UserFilters{
+			IPApproval: user.Filters.IPApproval,
+		},
+	}
+	go ipApprovals.add(&u, util.GetIPFromRemoteAddress(remoteAddr), protocol)
+}
+
+// GetIPApprovalRequests returns the pending IP approval requests.
+// If a role is specified only the requests for users with this role are returned
+func GetIPApprovalRequests(role string) ([]IPApprovalRequest, error) {
+	return ipApprovals.getAll(role)
+}
+
+// GetIPApprovalRequest returns the pending IP approval request with the specified id
+func GetIPApprovalRequest(id, role string) (IPApprovalRequest, error) {
+	return ipApprovals.get(id, role)
+}
+
+// ApproveIPRequest adds the IP address of the pending request with the
+// specified id to the allowed list of the associated user
+func ApproveIPRequest(id, executor, ipAddress, role string) (IPApprovalRequest, error) {
+	return ipApprovals.approve(id, executor, ipAddress, role)
+}
+
+// RejectIPRequest deletes the pending IP approval request with the specified id
+func RejectIPRequest(id, role string) error {
+	req, err := ipApprovals.get(id, role)
+	if err != nil {
+		return err
+	}
+	return ipApprovals.store.remove(req.ID)
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestIPApprovalConfig(t *testing.T) {
+	c := IPApprovalConfig{
+		Expiration: -1,
+	}
+	assert.Error(t, c.validate())
+	c.Expiration = 0
+	assert.NoError(t, c.validate())
+	assert.Equal(t, defaultIPApprovalExpiration*time.Hour, c.getExpiration())
+	c.Expiration = 2
+	assert.Equal(t, 2*time.Hour, c.getExpiration())
+	c.WebClientURL = "ftp://example.com"
+	assert.Error(t, c.validate())
+	c.WebClientURL = "https://"
+	assert.Error(t, c.validate())
+	c.WebClientURL = "https://sftpgo.example.com/web/client/"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "https://sftpgo.example.com/web/client", c.WebClientURL)
+	assert.Equal(t, "https://sftpgo.example.com/web/client/ipapproval/abc", c.getApprovalURL("abc"))
+
+	req := IPApprovalRequest{IP: "192.168.1.2"}
+	assert.Equal(t, "192.168.1.2/32", req.getAllowedIP())
+	req.IP = "::1"
+	assert.Equal(t, "::1/128", req.getAllowedIP())
+}
+
+func TestIPApprovalFlow(t *testing.T) {
+	username := "user_test_ip_approval"
+	user := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	user.Filters.AllowedIP = []string{"10.8.0.0/24"}
+	user.Filters.IPApproval = "invalid"
+	err := dataprovider.AddUser(user, "", "", "")
+	assert.Error(t, err)
+	user.Filters.IPApproval = dataprovider.IPApprovalModeAdmin
+	err = dataprovider.AddUser(user, "", "", "")
+	require.NoError(t, err)
+
+	assert.False(t, user.CanRequestIPApproval("10.8.0.1:1234"))
+	assert.False(t, user.CanRequestIPApproval("invalid"))
+	assert.True(t, user.CanRequestIPApproval("192.168.1.1:1234"))
+
+	m := newIPApprovalManager(dataprovider.MemoryDataProviderName)
+	m.add(user, "192.168.1.1", ProtocolSFTP)
+	// duplicated request
+	m.add(user, "192.168.1.1", ProtocolSSH)
+	requests, err := m.getAll("")
+	assert.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, username, requests[0].Username)
+	assert.Equal(t, "192.168.1.1", requests[0].IP)
+	assert.Equal(t, ProtocolSFTP, requests[0].Protocol)
+	assert.Equal(t, dataprovider.IPApprovalModeAdmin, requests[0].Mode)
+	approvedID := requests[0].ID
+	_, err = m.get(approvedID, "role")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	requests, err = m.getAll("role")
+	assert.NoError(t, err)
+	assert.Len(t, requests, 0)
+	// max pending requests
+	for i := 2; i < 10; i++ {
+		m.add(user, fmt.Sprintf("192.168.1.%d", i), ProtocolSFTP)
+	}
+	requests, err = m.getAll("")
+	assert.NoError(t, err)
+	assert.Len(t, requests, ipApprovalMaxPendingRequests)
+
+	req, err := m.approve(approvedID, "admin", "127.0.0.1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.1", req.IP)
+	_, err = m.get(approvedID, "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	requests, err = m.getAll("")
+	assert.NoError(t, err)
+	require.Len(t, requests, ipApprovalMaxPendingRequests-1)
+	updatedUser, err := dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.8.0.0/24", "192.168.1.1/32"}, updatedUser.Filters.AllowedIP)
+	assert.False(t, updatedUser.CanRequestIPApproval("192.168.1.1:1234"))
+	// disable the IP approval, pending requests cannot be approved anymore
+	updatedUser.Filters.IPApproval = ""
+	err = dataprovider.UpdateUser(&updatedUser, "", "", "")
+	assert.NoError(t, err)
+	assert.False(t, updatedUser.CanRequestIPApproval("192.168.1.5:1234"))
+	_, err = m.approve(requests[0].ID, "admin", "127.0.0.1", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	// expired requests are ignored
+	expired := requests[1]
+	expired.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Minute))
+	err = m.store.add(&expired)
+	assert.NoError(t, err)
+	_, err = m.get(expired.ID, "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	m.store.cleanup()
+	_, err = m.store.get(expired.ID)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	err = m.store.remove(requests[0].ID)
+	assert.NoError(t, err)
+	err = m.store.remove(requests[0].ID)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	partialDownloadsExpiration = 24 * time.Hour
+	maxPartialDownloadsPerUser = 100
+)
+
+var partialDownloads = newPartialDownloadsTracker()
+
+// PartialDownload defines a download that did not transfer the whole file yet
+type PartialDownload struct {
+	// Virtual path of the file
+	Path string `json:"path"`
+	// File size, in bytes, when the download started
+	Size int64 `json:"size"`
+	// Number of bytes downloaded, across all the attempts
+	DownloadedSize int64 `json:"downloaded_size"`
+	// Offset from which the download can be resumed using a range request.
+	// All the bytes before this offset have been downloaded
+	ResumeOffset int64 `json:"resume_offset"`
+	// Last download attempt as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// GetPartialDownloads returns the downloads not yet completed for the connection user
+func (c *BaseConnection) GetPartialDownloads() []PartialDownload {
+	return partialDownloads.get(c.User.Username)
+}
+
+// RemovePartialDownload stops tracking the partial download for the specified virtual path
+func (c *BaseConnection) RemovePartialDownload(virtualPath string) error {
+	if !partialDownloads.remove(c.User.Username, virtualPath) {
+		return c.GetNotExistError()
+	}
+	c.Log(logger.LevelDebug, "partial download for %q removed", virtualPath)
+	return nil
+}
+
+// resumableDownload defines the file range sent by a download that can be resumed
+type resumableDownload struct {
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+type partialDownload struct {
+	size      int64
+	modTime   time.Time
+	ranges    []byteRange
+	updatedAt time.Time
+}
+
+// addRange adds the specified range and merges it with the existing ones,
+// the ranges are kept sorted by start offset
+func (d *partialDownload) addRange(start, end int64) {
+	ranges := make([]byteRange, 0, len(d.ranges)+1)
+	added := false
+	for _, r := range d.ranges {
+		if !added && start < r.start {
+			ranges = append(ranges, byteRange{start: start, end: end})
+			added = true
+		}
+		ranges = append(ranges, r)
+	}
+	if !added {
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+	d.ranges = ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &d.ranges[len(d.ranges)-1]
+		if r.start <= last.end {
+			if r.end > last.end {
+				last.end = r.end
+			}
+			continue
+		}
+		d.ranges = append(d.ranges, r)
+	}
+}
+
+func (d *partialDownload) isCompleted() bool {
+	return len(d.ranges) == 1 && d.ranges[0].start == 0 && d.ranges[0].end >= d.size
+}
+
+func (d *partialDownload) getResumeOffset() int64 {
+	if len(d.ranges) > 0 && d.ranges[0].start == 0 {
+		return d.ranges[0].end
+	}
+	return 0
+}
+
+func (d *partialDownload) getDownloadedSize() int64 {
+	var size int64
+	for _, r := range d.ranges {
+		size += r.end - r.start
+	}
+	return size
+}
+
+type partialDownloadsTracker struct {
+	sync.RWMutex
+	// the first key is the username, the second one the virtual path
+	downloads map[string]map[string]*partialDownload
+}
+
+func newPartialDownloadsTracker() *partialDownloadsTracker {
+	return &partialDownloadsTracker{
+		downloads: make(map[string]map[string]*partialDownload),
+	}
+}
+
+// update adds the range sent by a download and returns true if the whole
+// file has been downloaded, also across multiple attempts
+func (t *partialDownloadsTracker) update(username, virtualPath string, size int64, modTime time.Time,
+	offset, sent int64,
+) bool {
+	if offset == 0 && sent >= size {
+		t.remove(username, virtualPath)
+		return true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	userDownloads := t.downloads[username]
+	download, ok := userDownloads[virtualPath]
+	if !ok || download.size != size || !download.modTime.Equal(modTime) {
+		if sent == 0 {
+			return false
+		}
+		download = &partialDownload{
+			size:    size,
+			modTime: modTime,
+		}
+	}
+	if sent > 0 {
+		download.addRange(offset, offset+sent)
+	}
+	if download.isCompleted() {
+		delete(userDownloads, virtualPath)
+		if len(userDownloads) == 0 {
+			delete(t.downloads, username)
+		}
+		return true
+	}
+	download.updatedAt = time.Now()
+	if userDownloads == nil {
+		userDownloads = make(map[string]*partialDownload)
+		t.downloads[username] = userDownloads
+	}
+	userDownloads[virtualPath] = download
+	if len(userDownloads) > maxPartialDownloadsPerUser {
+		t.removeOldest(userDownloads)
+	}
+	return false
+}
+
+func (t *partialDownloadsTracker) removeOldest(userDownloads map[string]*partialDownload) {
+	var oldestPath string
+	var oldest time.Time
+	for p, d := range userDownloads {
+		if oldestPath == "" || d.updatedAt.Before(oldest) {
+			oldestPath = p
+			oldest = d.updatedAt
+		}
+	}
+	delete(userDownloads, oldestPath)
+}
+
+func (t *partialDownloadsTracker) remove(username, virtualPath string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	userDownloads, ok := t.downloads[username]
+	if !ok {
+		return false
+	}
+	if _, ok := userDownloads[virtualPath]; !ok {
+		return false
+	}
+	delete(userDownloads, virtualPath)
+	if len(userDownloads) == 0 {
+		delete(t.downloads, username)
+	}
+	return true
+}
+
+func (t *partialDownloadsTracker) get(username string) []PartialDownload {
+	t.RLock()
+	defer t.RUnlock()
+
+	result := make([]PartialDownload, 0, len(t.downloads[username]))
+	for p, d := range t.downloads[username] {
+		if time.Since(d.updatedAt) > partialDownloadsExpiration {
+			continue
+		}
+		result = append(result, PartialDownload{
+			Path:           p,
+			Size:           d.size,
+			DownloadedSize: d.getDownloadedSize(),
+			ResumeOffset:   d.getResumeOffset(),
+			UpdatedAt:      util.GetTimeAsMsSinceEpoch(d.updatedAt),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+func (t *partialDownloadsTracker) removeExpired() {
+	t.Lock()
+	defer t.Unlock()
+
+	var removed int
+	for username, userDownloads := range t.downloads {
+		for p, d := range userDownloads {
+			if time.Since(d.updatedAt) > partialDownloadsExpiration {
+				delete(userDownloads, p)
+				removed++
+			}
+		}
+		if len(userDownloads) == 0 {
+			delete(t.downloads, username)
+		}
+	}
+	logger.Debug(logSender, "", "expired partial downloads removed: %d", removed)
+}
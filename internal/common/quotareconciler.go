@@ -0,0 +1,208 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	defaultQuotaReconciliationSampleSize = 10
+)
+
+var (
+	quotaReconciler = &quotaReconciliation{}
+)
+
+// QuotaReconciliationConfig defines the configuration for the periodic quota
+// reconciliation. The used quota is updated incrementally after each transfer,
+// it could drift if files are modified outside SFTPGo. Each reconciliation run
+// scans a sample of users and virtual folders, chosen in a round robin fashion,
+// and resets their used quota to the scanned values, so all the users and folders
+// are eventually reconciled without scanning them all at once
+type QuotaReconciliationConfig struct {
+	// Interval, in minutes, between reconciliation runs. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Maximum number of users and maximum number of virtual folders to reconcile
+	// in each run. 0 means the default (10)
+	SampleSize int `json:"sample_size" mapstructure:"sample_size"`
+}
+
+func (c *QuotaReconciliationConfig) isEnabled() bool {
+	return c.Interval > 0
+}
+
+func (c *QuotaReconciliationConfig) getSampleSize() int {
+	if c.SampleSize <= 0 {
+		return defaultQuotaReconciliationSampleSize
+	}
+	return c.SampleSize
+}
+
+func (c *QuotaReconciliationConfig) validate() error {
+	if c.Interval < 0 {
+		return errors.New("invalid quota reconciliation interval")
+	}
+	if c.SampleSize < 0 {
+		return errors.New("invalid quota reconciliation sample size")
+	}
+	return nil
+}
+
+// StartQuotaReconciliation starts a quota reconciliation run in background.
+// It returns false if a run is already in progress
+func StartQuotaReconciliation() bool {
+	if !quotaReconciler.running.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer quotaReconciler.running.Store(false)
+
+		quotaReconciler.run(Config.QuotaReconciliation.getSampleSize())
+	}()
+	return true
+}
+
+// IsQuotaReconciliationInProgress returns true if a quota reconciliation run is in progress
+func IsQuotaReconciliationInProgress() bool {
+	return quotaReconciler.running.Load()
+}
+
+func checkQuotaReconciliation() {
+	if !StartQuotaReconciliation() {
+		logger.Debug(logSender, "", "quota reconciliation already in progress")
+	}
+}
+
+type quotaReconciliation struct {
+	running atomic.Bool
+	// offsets for the next users and folders samples
+	mu           sync.Mutex
+	userOffset   int
+	folderOffset int
+}
+
+func (r *quotaReconciliation) run(sampleSize int) {
+	if dataprovider.GetQuotaTracking() == 0 {
+		logger.Debug(logSender, "", "quota tracking is disabled, skip quota reconciliation")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	startTime := time.Now()
+	logger.Debug(logSender, "", "quota reconciliation started, users offset: %d, folders offset: %d, sample size: %d",
+		r.userOffset, r.folderOffset, sampleSize)
+	users, err := dataprovider.GetUsers(sampleSize, r.userOffset, dataprovider.OrderASC, "")
+	if err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to get users: %v", err)
+		return
+	}
+	for idx := range users {
+		r.reconcileUser(users[idx].Username)
+	}
+	r.userOffset = getNextSampleOffset(r.userOffset, len(users), sampleSize)
+
+	folders, err := dataprovider.GetFolders(sampleSize, r.folderOffset, dataprovider.OrderASC, false)
+	if err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to get folders: %v", err)
+		return
+	}
+	for idx := range folders {
+		r.reconcileFolder(folders[idx])
+	}
+	r.folderOffset = getNextSampleOffset(r.folderOffset, len(folders), sampleSize)
+	logger.Debug(logSender, "", "quota reconciliation completed, users: %d, folders: %d, elapsed: %s",
+		len(users), len(folders), time.Since(startTime))
+}
+
+func (r *quotaReconciliation) reconcileUser(username string) {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to get user %q: %v", username, err)
+		return
+	}
+	if dataprovider.GetQuotaTracking() == 2 && !user.HasQuotaRestrictions() {
+		return
+	}
+	// the files uploaded while scanning could be counted twice
+	if Connections.GetActiveSessions(user.Username) > 0 {
+		logger.Debug(logSender, "", "quota reconciliation, skip user %q with active sessions", user.Username)
+		return
+	}
+	if !QuotaScans.AddUserQuotaScan(user.Username, user.Role) {
+		logger.Debug(logSender, "", "quota reconciliation, skip user %q, a quota scan is in progress", user.Username)
+		return
+	}
+	defer QuotaScans.RemoveUserQuotaScan(user.Username)
+
+	numFiles, size, err := user.ScanQuota()
+	if err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to scan user %q: %v", user.Username, err)
+		return
+	}
+	usedFiles, usedSize, _, _, err := dataprovider.GetUsedQuota(user.Username)
+	if err == nil && usedFiles == numFiles && usedSize == size {
+		return
+	}
+	logger.Info(logSender, "", "quota reconciliation, update user %q, files: %d->%d, size: %d->%d",
+		user.Username, usedFiles, numFiles, usedSize, size)
+	if err := dataprovider.UpdateUserQuota(&user, numFiles, size, true); err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to update quota for user %q: %v", user.Username, err)
+	}
+}
+
+func (r *quotaReconciliation) reconcileFolder(folder vfs.BaseVirtualFolder) {
+	if !QuotaScans.AddVFolderQuotaScan(folder.Name) {
+		logger.Debug(logSender, "", "quota reconciliation, skip folder %q, a quota scan is in progress", folder.Name)
+		return
+	}
+	defer QuotaScans.RemoveVFolderQuotaScan(folder.Name)
+
+	f := vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/",
+	}
+	numFiles, size, err := f.ScanQuota()
+	if err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to scan folder %q: %v", folder.Name, err)
+		return
+	}
+	usedFiles, usedSize, err := dataprovider.GetUsedVirtualFolderQuota(folder.Name)
+	if err == nil && usedFiles == numFiles && usedSize == size {
+		return
+	}
+	logger.Info(logSender, "", "quota reconciliation, update folder %q, files: %d->%d, size: %d->%d",
+		folder.Name, usedFiles, numFiles, usedSize, size)
+	if err := dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true); err != nil {
+		logger.Warn(logSender, "", "quota reconciliation, unable to update quota for folder %q: %v", folder.Name, err)
+	}
+}
+
+// getNextSampleOffset returns the offset for the next sample, we restart
+// from the beginning after the last page
+func getNextSampleOffset(offset, numItems, sampleSize int) int {
+	if numItems < sampleSize {
+		return 0
+	}
+	return offset + numItems
+}
@@ -30,9 +30,14 @@ import (
 var (
 	errNoBucket               = errors.New("no bucket found")
 	errReserve                = errors.New("unable to reserve token")
-	rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP}
+	rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP, ProtocolS3, ProtocolTFTP,
+		ProtocolGRPC, ProtocolSMTP, rateLimiterProtocolAnonymous}
 )
 
+// rateLimiterProtocolAnonymous is not a real protocol, rate limiters with this
+// protocol are applied to anonymous users in addition to the protocol ones
+const rateLimiterProtocolAnonymous = "ANONYMOUS"
+
 // RateLimiterType defines the supported rate limiters types
 type RateLimiterType int
 
@@ -58,7 +63,7 @@ type RateLimiterConfig struct {
 	// - rateLimiterTypeSource is a per-source rate limiter
 	Type int `json:"type" mapstructure:"type"`
 	// Protocols defines the protocols for this rate limiter.
-	// Available protocols are: "SFTP", "FTP", "DAV".
+	// Available protocols are: "SSH", "FTP", "DAV", "HTTP", "S3", "TFTP", "GRPC", "SMTP", "ANONYMOUS".
 	// A rate limiter with no protocols defined is disabled
 	Protocols []string `json:"protocols" mapstructure:"protocols"`
 	// If the rate limit is exceeded, the defender is enabled, and this is a per-source limiter,
@@ -0,0 +1,296 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	pushLogSender = "Push"
+)
+
+var (
+	remotePushTimeout = 15 * time.Second
+)
+
+// remoteUploader uploads files to a remote endpoint
+type remoteUploader interface {
+	upload(target string, reader io.Reader) (int64, error)
+	close() error
+}
+
+func newRemoteUploader(endpoint *dataprovider.RemoteEndpoint) (remoteUploader, error) {
+	if err := endpoint.Password.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt the password for remote endpoint %q: %w", endpoint.Name, err)
+	}
+	if err := endpoint.PrivateKey.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt the private key for remote endpoint %q: %w", endpoint.Name, err)
+	}
+	if endpoint.IsFTPS() {
+		return newFTPSUploader(endpoint)
+	}
+	return newSFTPUploader(endpoint)
+}
+
+type sftpUploader struct {
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+}
+
+func newSFTPUploader(endpoint *dataprovider.RemoteEndpoint) (*sftpUploader, error) {
+	var authMethods []ssh.AuthMethod
+	if endpoint.PrivateKey.GetPayload() != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(endpoint.PrivateKey.GetPayload()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the private key for remote endpoint %q: %w", endpoint.Name, err)
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	if endpoint.Password.GetPayload() != "" {
+		authMethods = append(authMethods, ssh.Password(endpoint.Password.GetPayload()))
+	}
+	clientConfig := &ssh.ClientConfig{
+		User: endpoint.Username,
+		Auth: authMethods,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fp := ssh.FingerprintSHA256(key)
+			if util.Contains(endpoint.Fingerprints, fp) {
+				return nil
+			}
+			return fmt.Errorf("host key fingerprint %q does not match the pinned ones", fp)
+		},
+		Timeout:       remotePushTimeout,
+		ClientVersion: fmt.Sprintf("SSH-2.0-SFTPGo_%v", version.Get().Version),
+	}
+	sshClient, err := ssh.Dial("tcp", endpoint.Endpoint, clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %q: %w", endpoint.Endpoint, err)
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("unable to create SFTP client for %q: %w", endpoint.Endpoint, err)
+	}
+	return &sftpUploader{
+		sshClient:  sshClient,
+		sftpClient: sftpClient,
+	}, nil
+}
+
+func (u *sftpUploader) upload(target string, reader io.Reader) (int64, error) {
+	if err := u.sftpClient.MkdirAll(path.Dir(target)); err != nil {
+		return 0, fmt.Errorf("unable to create the directory for %q: %w", target, err)
+	}
+	f, err := u.sftpClient.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create %q: %w", target, err)
+	}
+	n, err := io.Copy(f, reader)
+	errClose := f.Close()
+	if err == nil {
+		err = errClose
+	}
+	return n, err
+}
+
+func (u *sftpUploader) close() error {
+	u.sftpClient.Close()
+	return u.sshClient.Close()
+}
+
+type ftpsUploader struct {
+	client *ftp.ServerConn
+}
+
+func getRemoteEndpointTLSConfig(endpoint *dataprovider.RemoteEndpoint) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(endpoint.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+		// data connections can resume the TLS session of the control connection,
+		// some servers require it
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if len(endpoint.Fingerprints) > 0 {
+		// the certificate is pinned, the chain is not verified
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate provided")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			fp := hex.EncodeToString(sum[:])
+			if util.Contains(endpoint.Fingerprints, fp) {
+				return nil
+			}
+			return fmt.Errorf("certificate fingerprint %q does not match the pinned ones", fp)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func newFTPSUploader(endpoint *dataprovider.RemoteEndpoint) (*ftpsUploader, error) {
+	tlsConfig, err := getRemoteEndpointTLSConfig(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	options := []ftp.DialOption{ftp.DialWithTimeout(remotePushTimeout)}
+	if endpoint.Protocol == dataprovider.RemoteEndpointProtocolFTPSImplicit {
+		options = append(options, ftp.DialWithTLS(tlsConfig))
+	} else {
+		options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+	}
+	client, err := ftp.Dial(endpoint.Endpoint, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %q: %w", endpoint.Endpoint, err)
+	}
+	if err := client.Login(endpoint.Username, endpoint.Password.GetPayload()); err != nil {
+		client.Quit() //nolint:errcheck
+		return nil, fmt.Errorf("unable to login to %q: %w", endpoint.Endpoint, err)
+	}
+	return &ftpsUploader{
+		client: client,
+	}, nil
+}
+
+func (u *ftpsUploader) upload(target string, reader io.Reader) (int64, error) {
+	// FTP has no recursive mkdir, errors are ignored since the
+	// directories may already exist, Stor will fail otherwise
+	dir := path.Dir(target)
+	if dir != "/" {
+		var current string
+		for _, name := range strings.Split(strings.TrimPrefix(dir, "/"), "/") {
+			current = path.Join("/", current, name)
+			u.client.MakeDir(current) //nolint:errcheck
+		}
+	}
+	counter := &countingReader{r: reader}
+	if err := u.client.Stor(target, counter); err != nil {
+		return counter.n, fmt.Errorf("unable to upload %q: %w", target, err)
+	}
+	return counter.n, nil
+}
+
+func (u *ftpsUploader) close() error {
+	return u.client.Quit()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func getRemoteEndpoint(name string) (dataprovider.RemoteEndpoint, error) {
+	configs, err := dataprovider.GetConfigs()
+	if err != nil {
+		return dataprovider.RemoteEndpoint{}, err
+	}
+	return configs.GetRemoteEndpoint(name)
+}
+
+func executePushRuleAction(c dataprovider.EventActionPush, params *EventParams) error {
+	if params.sender == "" || params.VirtualPath == "" {
+		return errors.New("push is only supported for filesystem events")
+	}
+	endpoint, err := getRemoteEndpoint(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("unable to get remote endpoint %q: %w", c.Endpoint, err)
+	}
+	user, err := params.getUserFromSender()
+	if err != nil {
+		return err
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+
+	targetPath := params.VirtualPath
+	if c.TargetPath != "" {
+		replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+		targetPath = util.CleanPath(replaceWithReplacer(c.TargetPath, replacer))
+	}
+	info, err := conn.DoStat(params.VirtualPath, 0, false)
+	if err != nil {
+		return fmt.Errorf("unable to stat %q: %w", params.VirtualPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", params.VirtualPath)
+	}
+	reader, cancelFn, err := getFileReader(conn, params.VirtualPath)
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %w", params.VirtualPath, err)
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	uploader, err := newRemoteUploader(&endpoint)
+	if err != nil {
+		return err
+	}
+	defer uploader.close() //nolint:errcheck
+
+	startTime := time.Now()
+	size, err := uploader.upload(targetPath, reader)
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to push %q to remote endpoint %q, path %q, user %q, elapsed: %d ms, err: %v",
+			params.VirtualPath, endpoint.Name, targetPath, user.Username, elapsed, err)
+		return err
+	}
+	logger.TransferLog(pushLogSender, targetPath, elapsed, size, user.Username, connectionID, protocolEventAction,
+		"", endpoint.Endpoint, "")
+	eventManagerLog(logger.LevelDebug, "%q pushed to remote endpoint %q, path %q, user %q, size: %d, elapsed: %d ms",
+		params.VirtualPath, endpoint.Name, targetPath, user.Username, size, elapsed)
+	params.setStepOutput([]byte(targetPath))
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdkkms "github.com/sftpgo/sdk/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func TestRemoteEndpointTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	endpoint := &dataprovider.RemoteEndpoint{
+		Name:         "ftps",
+		Protocol:     dataprovider.RemoteEndpointProtocolFTPS,
+		Endpoint:     strings.TrimPrefix(server.URL, "https://"),
+		Fingerprints: []string{hex.EncodeToString(sum[:])},
+	}
+	tlsConfig, err := getRemoteEndpointTLSConfig(endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", tlsConfig.ServerName)
+	conn, err := tls.Dial("tcp", endpoint.Endpoint, tlsConfig)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+	endpoint.Fingerprints = []string{strings.Repeat("a", 64)}
+	tlsConfig, err = getRemoteEndpointTLSConfig(endpoint)
+	require.NoError(t, err)
+	_, err = tls.Dial("tcp", endpoint.Endpoint, tlsConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not match the pinned ones")
+	}
+	// without fingerprints the self signed certificate is not trusted
+	endpoint.Fingerprints = nil
+	tlsConfig, err = getRemoteEndpointTLSConfig(endpoint)
+	require.NoError(t, err)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	_, err = tls.Dial("tcp", endpoint.Endpoint, tlsConfig)
+	assert.Error(t, err)
+
+	endpoint.Endpoint = "invalid"
+	_, err = getRemoteEndpointTLSConfig(endpoint)
+	assert.Error(t, err)
+}
+
+func TestRemoteUploaderErrors(t *testing.T) {
+	endpoint := &dataprovider.RemoteEndpoint{
+		Name:         "sftp",
+		Protocol:     dataprovider.RemoteEndpointProtocolSFTP,
+		Endpoint:     "127.0.0.1:4",
+		Username:     "user",
+		Password:     kms.NewPlainSecret("pwd"),
+		PrivateKey:   kms.NewPlainSecret("invalid key"),
+		Fingerprints: []string{"SHA256:fp"},
+	}
+	_, err := newRemoteUploader(endpoint)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse the private key")
+	}
+	endpoint.PrivateKey = kms.NewEmptySecret()
+	_, err = newRemoteUploader(endpoint)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect")
+	}
+	endpoint.Protocol = dataprovider.RemoteEndpointProtocolFTPSImplicit
+	endpoint.Fingerprints = nil
+	_, err = newRemoteUploader(endpoint)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect")
+	}
+	endpoint.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "")
+	_, err = newRemoteUploader(endpoint)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt the password")
+	}
+}
+
+func TestPushRuleActionErrors(t *testing.T) {
+	err := executePushRuleAction(dataprovider.EventActionPush{Endpoint: "missing"}, &EventParams{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for filesystem events")
+	}
+	params := &EventParams{
+		Name:        "user",
+		Event:       operationUpload,
+		VirtualPath: "/file.txt",
+		sender:      "user",
+	}
+	err = executePushRuleAction(dataprovider.EventActionPush{Endpoint: "missing"}, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to get remote endpoint")
+	}
+}
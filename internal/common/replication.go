@@ -0,0 +1,361 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// the destination folder is mounted on this virtual path for the replication user
+	replicationMountPath   = "/replica"
+	maxReplicationFailures = 100
+)
+
+var (
+	// Replications is the queue of the pending replications
+	Replications              = newReplicationQueue()
+	replicationRetryBaseDelay = 30 * time.Second
+	replicationRetryMaxDelay  = 30 * time.Minute
+)
+
+// ReplicationTask defines the replication of a file to a destination folder
+type ReplicationTask struct {
+	ID string `json:"id"`
+	// Username of the user who uploaded the file
+	Username string `json:"username"`
+	// Virtual path of the file to replicate
+	SourcePath string `json:"source_path"`
+	// Name of the destination folder
+	Folder string `json:"folder"`
+	// Path inside the destination folder
+	TargetPath string `json:"target_path"`
+	// Number of executed attempts
+	Attempts int `json:"attempts"`
+	// task creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// next retry as unix timestamp in milliseconds, 0 if no retry is scheduled
+	NextRetry int64 `json:"next_retry,omitempty"`
+	// last replication error, if any
+	LastError      string `json:"last_error,omitempty"`
+	Role           string `json:"-"`
+	conflictPolicy int
+	maxRetries     int
+}
+
+func (t *ReplicationTask) getACopy() ReplicationTask {
+	return ReplicationTask{
+		ID:             t.ID,
+		Username:       t.Username,
+		SourcePath:     t.SourcePath,
+		Folder:         t.Folder,
+		TargetPath:     t.TargetPath,
+		Attempts:       t.Attempts,
+		CreatedAt:      t.CreatedAt,
+		NextRetry:      t.NextRetry,
+		LastError:      t.LastError,
+		Role:           t.Role,
+		conflictPolicy: t.conflictPolicy,
+		maxRetries:     t.maxRetries,
+	}
+}
+
+func (t *ReplicationTask) getDestinationUser(username string) (dataprovider.User, error) {
+	return getVirtualFolderUser(t.Folder, username, replicationMountPath)
+}
+
+// getVirtualFolderUser returns a user with the specified virtual folder mounted
+// on mountPath, it is used to write files to a virtual folder
+func getVirtualFolderUser(folderName, username, mountPath string) (dataprovider.User, error) {
+	folder, err := dataprovider.GetFolderByName(folderName)
+	if err != nil {
+		return dataprovider.User{}, fmt.Errorf("unable to get folder %q: %w", folderName, err)
+	}
+	// the username is preserved so the folder path placeholders are replaced
+	return dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Status:   1,
+			Username: username,
+			HomeDir:  dataprovider.GetBackupsPath(),
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: folder,
+				VirtualPath:       mountPath,
+			},
+		},
+	}, nil
+}
+
+// getTargetPath returns the target virtual path for the destination connection
+// and false if the replication must be skipped
+func (t *ReplicationTask) getTargetPath(conn *BaseConnection) (string, bool, error) {
+	target := path.Join(replicationMountPath, t.TargetPath)
+	info, err := conn.DoStat(target, 0, false)
+	if err != nil {
+		if conn.IsNotExistError(err) {
+			return target, true, nil
+		}
+		return "", false, err
+	}
+	if info.IsDir() {
+		return "", false, fmt.Errorf("target %q is a directory", t.TargetPath)
+	}
+	switch t.conflictPolicy {
+	case dataprovider.ReplicationConflictSkip:
+		return "", false, nil
+	case dataprovider.ReplicationConflictRename:
+		ext := path.Ext(target)
+		return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(target, ext), time.Now().UTC().Format("20060102T150405.000"),
+			ext), true, nil
+	default:
+		return target, true, nil
+	}
+}
+
+func (t *ReplicationTask) replicate() error {
+	user, err := dataprovider.UserExists(t.Username, "")
+	if err != nil {
+		return fmt.Errorf("unable to get user %q: %w", t.Username, err)
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	dstUser, err := t.getDestinationUser(user.Username)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("unable to check root fs for user %q: %w", user.Username, err)
+	}
+	err = dstUser.CheckFsRoot(connectionID)
+	defer dstUser.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("unable to check root fs for folder %q: %w", t.Folder, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	dstConn := NewBaseConnection(connectionID, protocolEventAction, "", "", dstUser)
+
+	info, err := conn.DoStat(t.SourcePath, 0, false)
+	if err != nil {
+		return fmt.Errorf("unable to stat %q: %w", t.SourcePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", t.SourcePath)
+	}
+	target, ok, err := t.getTargetPath(dstConn)
+	if err != nil {
+		return fmt.Errorf("unable to check target %q: %w", t.TargetPath, err)
+	}
+	if !ok {
+		eventManagerLog(logger.LevelDebug, "replication of %q to folder %q skipped, target %q already exists",
+			t.SourcePath, t.Folder, t.TargetPath)
+		return nil
+	}
+	if err = dstConn.CheckParentDirs(path.Dir(target)); err != nil {
+		return err
+	}
+	reader, cancelReaderFn, err := getFileReader(conn, t.SourcePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %w", t.SourcePath, err)
+	}
+	defer cancelReaderFn()
+	defer reader.Close()
+
+	writer, numFiles, truncatedSize, cancelWriterFn, err := getFileWriter(dstConn, target, info.Size())
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", target, err)
+	}
+	defer cancelWriterFn()
+
+	startTime := time.Now()
+	_, err = io.Copy(writer, reader)
+	return closeWriterAndUpdateQuota(writer, dstConn, target, "", numFiles, truncatedSize, err, operationUpload, startTime)
+}
+
+// ReplicationStatus defines the status of the replications
+type ReplicationStatus struct {
+	// Pending replications, including the ones waiting for a retry
+	Pending []ReplicationTask `json:"pending"`
+	// Latest replications failed after all the configured retries
+	Failures []ReplicationTask `json:"failures"`
+	// Number of completed and failed replications since the service started
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	// Age of the oldest pending replication in milliseconds
+	Lag int64 `json:"lag"`
+}
+
+type replicationQueue struct {
+	sync.RWMutex
+	pending   map[string]*ReplicationTask
+	failures  []ReplicationTask
+	completed int64
+	failed    int64
+}
+
+func newReplicationQueue() *replicationQueue {
+	return &replicationQueue{
+		pending: make(map[string]*ReplicationTask),
+	}
+}
+
+// GetStatus returns the replications status for the specified role, an empty role means all
+func (q *replicationQueue) GetStatus(role string) ReplicationStatus {
+	q.RLock()
+	defer q.RUnlock()
+
+	status := ReplicationStatus{
+		Pending:   make([]ReplicationTask, 0, len(q.pending)),
+		Failures:  make([]ReplicationTask, 0, len(q.failures)),
+		Completed: q.completed,
+		Failed:    q.failed,
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for _, task := range q.pending {
+		if role == "" || role == task.Role {
+			status.Pending = append(status.Pending, task.getACopy())
+			if lag := now - task.CreatedAt; lag > status.Lag {
+				status.Lag = lag
+			}
+		}
+	}
+	sort.Slice(status.Pending, func(i, j int) bool {
+		return status.Pending[i].CreatedAt < status.Pending[j].CreatedAt
+	})
+	for _, task := range q.failures {
+		if role == "" || role == task.Role {
+			status.Failures = append(status.Failures, task.getACopy())
+		}
+	}
+	return status
+}
+
+func (q *replicationQueue) add(task *ReplicationTask) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.pending[task.ID] = task
+}
+
+// execute runs a replication attempt. An error is returned if the replication
+// failed and no more retries are allowed
+func (q *replicationQueue) execute(task *ReplicationTask) error {
+	err := task.replicate()
+
+	q.Lock()
+	defer q.Unlock()
+
+	task.Attempts++
+	if err == nil {
+		delete(q.pending, task.ID)
+		q.completed++
+		eventManagerLog(logger.LevelDebug, "replication of %q to folder %q, path %q completed, user %q, attempts: %d",
+			task.SourcePath, task.Folder, task.TargetPath, task.Username, task.Attempts)
+		return nil
+	}
+	task.LastError = err.Error()
+	if task.Attempts > task.maxRetries {
+		delete(q.pending, task.ID)
+		task.NextRetry = 0
+		q.failed++
+		q.failures = append(q.failures, task.getACopy())
+		if len(q.failures) > maxReplicationFailures {
+			q.failures = q.failures[len(q.failures)-maxReplicationFailures:]
+		}
+		eventManagerLog(logger.LevelError, "replication of %q to folder %q, path %q failed, user %q, attempts: %d, err: %v",
+			task.SourcePath, task.Folder, task.TargetPath, task.Username, task.Attempts, err)
+		return err
+	}
+	delay := getReplicationRetryDelay(task.Attempts)
+	task.NextRetry = util.GetTimeAsMsSinceEpoch(time.Now().Add(delay))
+	eventManagerLog(logger.LevelWarn, "replication of %q to folder %q, path %q failed, user %q, attempts: %d, retry in %s, err: %v",
+		task.SourcePath, task.Folder, task.TargetPath, task.Username, task.Attempts, delay, err)
+	time.AfterFunc(delay, func() {
+		q.execute(task) //nolint:errcheck
+	})
+	return nil
+}
+
+func getReplicationRetryDelay(attempts int) time.Duration {
+	delay := replicationRetryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= replicationRetryMaxDelay {
+			return replicationRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+func executeReplicationRuleAction(c dataprovider.EventActionReplication, params *EventParams) error {
+	if params.sender == "" || params.VirtualPath == "" {
+		return errors.New("replication is only supported for filesystem events")
+	}
+	user, err := params.getUserFromSender()
+	if err != nil {
+		return err
+	}
+	targetPath := params.VirtualPath
+	if c.TargetPath != "" {
+		replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+		targetPath = util.CleanPath(replaceWithReplacer(c.TargetPath, replacer))
+	}
+	var failures []string
+	for _, folder := range c.Folders {
+		task := &ReplicationTask{
+			ID:             xid.New().String(),
+			Username:       user.Username,
+			SourcePath:     params.VirtualPath,
+			Folder:         folder,
+			TargetPath:     targetPath,
+			CreatedAt:      util.GetTimeAsMsSinceEpoch(time.Now()),
+			Role:           user.Role,
+			conflictPolicy: c.ConflictPolicy,
+			maxRetries:     c.MaxRetries,
+		}
+		Replications.add(task)
+		if err := Replications.execute(task); err != nil {
+			failures = append(failures, folder)
+			params.AddError(fmt.Errorf("unable to replicate %q to folder %q: %w", params.VirtualPath, folder, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("replication failed for folders: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
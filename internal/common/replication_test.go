@@ -0,0 +1,193 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestReplicationRuleAction(t *testing.T) {
+	username := "test_user_replication"
+	foldername := "test_folder_replication"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	folder := vfs.BaseVirtualFolder{
+		Name:       foldername,
+		MappedPath: filepath.Join(os.TempDir(), foldername),
+	}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file.txt"), []byte("data"), 0666)
+	assert.NoError(t, err)
+
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeReplication,
+		Options: dataprovider.BaseEventActionOptions{
+			ReplicationConfig: dataprovider.EventActionReplication{
+				Folders:        []string{foldername},
+				ConflictPolicy: dataprovider.ReplicationConflictOverwrite,
+			},
+		},
+	}
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for filesystem events")
+	}
+	params := &EventParams{
+		Name:        username,
+		Event:       operationUpload,
+		VirtualPath: "/dir/file.txt",
+		sender:      username,
+	}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(folder.MappedPath, "dir", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	folderGet, err := dataprovider.GetFolderByName(foldername)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, folderGet.UsedQuotaFiles)
+	assert.Equal(t, int64(4), folderGet.UsedQuotaSize)
+
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file.txt"), []byte("new data"), 0666)
+	assert.NoError(t, err)
+	action.Options.ReplicationConfig.ConflictPolicy = dataprovider.ReplicationConflictSkip
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(folder.MappedPath, "dir", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	action.Options.ReplicationConfig.ConflictPolicy = dataprovider.ReplicationConflictRename
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(folder.MappedPath, "dir"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	action.Options.ReplicationConfig.ConflictPolicy = dataprovider.ReplicationConflictOverwrite
+	action.Options.ReplicationConfig.TargetPath = "/{{Name}}/{{ObjectName}}"
+	params.ObjectName = "file.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(folder.MappedPath, username, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new data"), data)
+
+	status := Replications.GetStatus("")
+	assert.Len(t, status.Pending, 0)
+	completed := status.Completed
+	failed := status.Failed
+
+	action.Options.ReplicationConfig.Folders = []string{"missing folder"}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "replication failed for folders: missing folder")
+	}
+	status = Replications.GetStatus("")
+	assert.Equal(t, completed, status.Completed)
+	assert.Equal(t, failed+1, status.Failed)
+	if assert.NotEmpty(t, status.Failures) {
+		failure := status.Failures[len(status.Failures)-1]
+		assert.Equal(t, "missing folder", failure.Folder)
+		assert.Equal(t, 1, failure.Attempts)
+		assert.Contains(t, failure.LastError, "unable to get folder")
+	}
+	assert.Len(t, Replications.GetStatus("missing role").Failures, 0)
+	// the source file is missing, the replication is retried and then fails
+	replicationRetryBaseDelay = 100 * time.Millisecond
+	defer func() {
+		replicationRetryBaseDelay = 30 * time.Second
+	}()
+	action.Options.ReplicationConfig.Folders = []string{foldername}
+	action.Options.ReplicationConfig.MaxRetries = 1
+	params.VirtualPath = "/missing.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	status = Replications.GetStatus("")
+	if assert.Len(t, status.Pending, 1) {
+		assert.Equal(t, 1, status.Pending[0].Attempts)
+		assert.Greater(t, status.Pending[0].NextRetry, int64(0))
+	}
+	assert.Eventually(t, func() bool {
+		status := Replications.GetStatus("")
+		return len(status.Pending) == 0 && status.Failed == failed+2
+	}, 2*time.Second, 50*time.Millisecond)
+	status = Replications.GetStatus("")
+	if assert.NotEmpty(t, status.Failures) {
+		assert.Equal(t, 2, status.Failures[len(status.Failures)-1].Attempts)
+	}
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(foldername, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(folder.MappedPath)
+	assert.NoError(t, err)
+}
+
+func TestReplicationActionConsistency(t *testing.T) {
+	rule := dataprovider.EventRule{
+		Name:    "replication rule",
+		Trigger: dataprovider.EventTriggerSchedule,
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: "replication action",
+					Type: dataprovider.ActionTypeReplication,
+				},
+			},
+		},
+	}
+	err := rule.CheckActionsConsistency("")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for filesystem events")
+	}
+	rule.Trigger = dataprovider.EventTriggerFsEvent
+	err = rule.CheckActionsConsistency("")
+	assert.NoError(t, err)
+}
+
+func TestReplicationRetryDelay(t *testing.T) {
+	assert.Equal(t, replicationRetryBaseDelay, getReplicationRetryDelay(1))
+	assert.Equal(t, 2*replicationRetryBaseDelay, getReplicationRetryDelay(2))
+	assert.Equal(t, 4*replicationRetryBaseDelay, getReplicationRetryDelay(3))
+	assert.Equal(t, replicationRetryMaxDelay, getReplicationRetryDelay(20))
+}
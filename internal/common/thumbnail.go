@@ -0,0 +1,316 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF format
+	"image/jpeg"
+	_ "image/png" // register the PNG format
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// PreviewsDir is the directory, relative to the user home, where the generated previews are stored.
+	// The previews tree mirrors the user files tree
+	PreviewsDir = "/.previews"
+	// images with more pixels than this limit are skipped to avoid excessive memory usage
+	thumbnailMaxPixels = 50 * 1000 * 1000
+)
+
+var (
+	// limit the concurrent thumbnail generations, decoding images is CPU and memory intensive
+	thumbnailSemaphore       = make(chan struct{}, 2)
+	thumbnailImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+	errThumbnailUnsupported  = errors.New("unsupported file for thumbnail generation")
+)
+
+// GetPreviewPath returns the virtual path for the preview of the specified virtual path
+func GetPreviewPath(virtualPath string) string {
+	return path.Join(PreviewsDir, util.CleanPath(virtualPath)) + ".jpg"
+}
+
+func isPreviewPath(virtualPath string) bool {
+	return virtualPath == PreviewsDir || strings.HasPrefix(virtualPath, PreviewsDir+"/")
+}
+
+func isThumbnailSupported(virtualPath string, c *dataprovider.EventActionThumbnail) bool {
+	if isPreviewPath(virtualPath) {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(virtualPath))
+	if ext == ".pdf" {
+		return c.PDFCommand != ""
+	}
+	return util.Contains(thumbnailImageExtensions, ext)
+}
+
+// getThumbnailSize returns the preview size preserving the aspect ratio.
+// Images smaller than the bounding box are never upscaled
+func getThumbnailSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	if width*maxHeight > height*maxWidth {
+		height = (height*maxWidth + width/2) / width
+		width = maxWidth
+	} else {
+		width = (width*maxHeight + height/2) / height
+		height = maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// resizeImage scales the source image using a box filter and composites it on
+// a white background, transparency is not supported by the JPEG format
+func resizeImage(src image.Image, maxWidth, maxHeight int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := getThumbnailSize(srcWidth, srcHeight, maxWidth, maxHeight)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + (y+1)*srcHeight/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + (x+1)*srcWidth/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			// the colors are alpha-premultiplied
+			bg := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + bg) >> 8),
+				G: uint8((g/n + bg) >> 8),
+				B: uint8((b/n + bg) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+func createThumbnail(r io.Reader, c *dataprovider.EventActionThumbnail) ([]byte, error) {
+	var buf bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image config: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > thumbnailMaxPixels {
+		return nil, fmt.Errorf("%w: invalid image size %dx%d", errThumbnailUnsupported, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, resizeImage(img, c.Width, c.Height), &jpeg.Options{Quality: c.Quality})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode preview: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// convertPDF saves the PDF to a local temporary file and executes the configured
+// command to render the first page as image
+func convertPDF(r io.Reader, c *dataprovider.EventActionThumbnail) (io.Reader, error) {
+	tempDir, err := os.MkdirTemp("", "sftpgo-preview")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	src, err := os.CreateTemp(tempDir, "*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(src, r)
+	errClose := src.Close()
+	if err != nil {
+		return nil, err
+	}
+	if errClose != nil {
+		return nil, errClose
+	}
+	dst := strings.TrimSuffix(src.Name(), ".pdf") + ".png"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.PDFCommand, src.Name(), dst)
+	cmd.Env = []string{}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to convert PDF: %w", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func generateThumbnail(conn *BaseConnection, virtualPath string, c *dataprovider.EventActionThumbnail) error {
+	info, err := conn.DoStat(virtualPath, 0, false)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %q is not a regular file", errThumbnailUnsupported, virtualPath)
+	}
+	if c.MaxFileSize > 0 && info.Size() > c.MaxFileSize*1048576 {
+		return fmt.Errorf("%w: %q is too large, size: %d", errThumbnailUnsupported, virtualPath, info.Size())
+	}
+
+	thumbnailSemaphore <- struct{}{}
+	defer func() {
+		<-thumbnailSemaphore
+	}()
+
+	reader, cancelFn, err := getFileReader(conn, virtualPath)
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %w", virtualPath, err)
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	var src io.Reader = reader
+	if strings.EqualFold(path.Ext(virtualPath), ".pdf") {
+		converted, err := convertPDF(reader, c)
+		if err != nil {
+			return err
+		}
+		src = converted
+	}
+	data, err := createThumbnail(src, c)
+	if err != nil {
+		return err
+	}
+
+	previewPath := GetPreviewPath(virtualPath)
+	if err = conn.CheckParentDirs(path.Dir(previewPath)); err != nil {
+		return err
+	}
+	writer, numFiles, truncatedSize, cancelWriterFn, err := getFileWriter(conn, previewPath, int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", previewPath, err)
+	}
+	defer cancelWriterFn()
+
+	startTime := time.Now()
+	_, err = writer.Write(data)
+	return closeWriterAndUpdateQuota(writer, conn, previewPath, "", numFiles, truncatedSize, err, operationUpload, startTime)
+}
+
+func removeThumbnail(conn *BaseConnection, virtualPath string) error {
+	previewPath := GetPreviewPath(virtualPath)
+	fs, fsPath, err := conn.GetFsAndResolvedPath(previewPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Lstat(fsPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return conn.GetFsError(fs, err)
+	}
+	return conn.RemoveFile(fs, fsPath, previewPath, info)
+}
+
+func executeThumbnailRuleAction(c dataprovider.EventActionThumbnail, params *EventParams) error {
+	if params.sender == "" || params.VirtualPath == "" {
+		return errors.New("thumbnail generation is only supported for filesystem events")
+	}
+	user, err := params.getUserFromSender()
+	if err != nil {
+		return err
+	}
+	user, err = getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+
+	virtualPath := params.VirtualPath
+	switch params.Event {
+	case operationDelete:
+		if isPreviewPath(virtualPath) {
+			return nil
+		}
+		return removeThumbnail(conn, virtualPath)
+	case operationRename:
+		if !isPreviewPath(virtualPath) {
+			if err := removeThumbnail(conn, virtualPath); err != nil {
+				eventManagerLog(logger.LevelWarn, "unable to remove preview for renamed file %q: %v", virtualPath, err)
+			}
+		}
+		virtualPath = params.VirtualTargetPath
+	case operationCopy:
+		virtualPath = params.VirtualTargetPath
+	}
+	if !isThumbnailSupported(virtualPath, &c) {
+		eventManagerLog(logger.LevelDebug, "thumbnail generation skipped for %q, unsupported file", virtualPath)
+		return nil
+	}
+	err = generateThumbnail(conn, virtualPath, &c)
+	if errors.Is(err, errThumbnailUnsupported) {
+		eventManagerLog(logger.LevelDebug, "thumbnail generation skipped: %v", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func getTestPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestThumbnailSize(t *testing.T) {
+	w, h := getThumbnailSize(100, 50, 256, 256)
+	assert.Equal(t, 100, w)
+	assert.Equal(t, 50, h)
+	w, h = getThumbnailSize(1024, 512, 256, 256)
+	assert.Equal(t, 256, w)
+	assert.Equal(t, 128, h)
+	w, h = getThumbnailSize(512, 1024, 256, 256)
+	assert.Equal(t, 128, w)
+	assert.Equal(t, 256, h)
+	w, h = getThumbnailSize(10000, 1, 100, 100)
+	assert.Equal(t, 100, w)
+	assert.Equal(t, 1, h)
+
+	assert.Equal(t, "/.previews/dir/img.png.jpg", GetPreviewPath("dir/img.png"))
+	assert.True(t, isPreviewPath(PreviewsDir))
+	assert.True(t, isPreviewPath(GetPreviewPath("/img.png")))
+	assert.False(t, isPreviewPath("/.previewsdir/img.png"))
+	c := dataprovider.EventActionThumbnail{}
+	assert.True(t, isThumbnailSupported("/img.JPG", &c))
+	assert.False(t, isThumbnailSupported("/file.txt", &c))
+	assert.False(t, isThumbnailSupported("/file.pdf", &c))
+	assert.False(t, isThumbnailSupported(GetPreviewPath("/img.png"), &c))
+	c.PDFCommand = "/bin/true"
+	assert.True(t, isThumbnailSupported("/file.pdf", &c))
+}
+
+func TestCreateThumbnail(t *testing.T) {
+	c := &dataprovider.EventActionThumbnail{
+		Width:   64,
+		Height:  64,
+		Quality: 80,
+	}
+	data, err := createThumbnail(bytes.NewReader(getTestPNG(t, 200, 100)), c)
+	require.NoError(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 64, img.Bounds().Dx())
+	assert.Equal(t, 32, img.Bounds().Dy())
+	r, g, b, _ := img.At(10, 10).RGBA()
+	assert.Greater(t, r>>8, uint32(200))
+	assert.Less(t, g>>8, uint32(50))
+	assert.Less(t, b>>8, uint32(50))
+	// transparent pixels are composited on a white background
+	var buf bytes.Buffer
+	err = png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	require.NoError(t, err)
+	data, err = createThumbnail(&buf, c)
+	require.NoError(t, err)
+	img, err = jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	r, g, b, _ = img.At(4, 4).RGBA()
+	assert.Greater(t, r>>8, uint32(240))
+	assert.Greater(t, g>>8, uint32(240))
+	assert.Greater(t, b>>8, uint32(240))
+
+	_, err = createThumbnail(bytes.NewReader([]byte("not an image")), c)
+	assert.Error(t, err)
+}
+
+func TestThumbnailRuleAction(t *testing.T) {
+	username := "test_user_thumbnail"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "img.png"), getTestPNG(t, 300, 300), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("data"), 0666)
+	assert.NoError(t, err)
+
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeThumbnail,
+		Options: dataprovider.BaseEventActionOptions{
+			ThumbnailConfig: dataprovider.EventActionThumbnail{
+				Width:   100,
+				Height:  100,
+				Quality: 80,
+			},
+		},
+	}
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for filesystem events")
+	}
+	params := &EventParams{
+		Name:        username,
+		Event:       operationUpload,
+		VirtualPath: "/dir/img.png",
+		sender:      username,
+	}
+	previewPath := filepath.Join(user.GetHomeDir(), ".previews", "dir", "img.png.jpg")
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(previewPath)
+	assert.NoError(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 100, img.Bounds().Dx())
+		assert.Equal(t, 100, img.Bounds().Dy())
+	}
+	// unsupported files are skipped
+	params.VirtualPath = "/file.txt"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), ".previews", "file.txt.jpg"))
+	// too large files are skipped
+	action.Options.ThumbnailConfig.MaxFileSize = 1
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "large.png"), bytes.Repeat([]byte("a"), 1048577), 0666)
+	assert.NoError(t, err)
+	params.VirtualPath = "/large.png"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), ".previews", "large.png.jpg"))
+	action.Options.ThumbnailConfig.MaxFileSize = 0
+	// invalid image
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+	// missing file
+	params.VirtualPath = "/missing.png"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+	// rename
+	err = os.Rename(filepath.Join(user.GetHomeDir(), "dir", "img.png"), filepath.Join(user.GetHomeDir(), "img.png"))
+	assert.NoError(t, err)
+	params.Event = operationRename
+	params.VirtualPath = "/dir/img.png"
+	params.VirtualTargetPath = "/img.png"
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, previewPath)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), ".previews", "img.png.jpg"))
+	// delete
+	params.Event = operationDelete
+	params.VirtualPath = "/img.png"
+	params.VirtualTargetPath = ""
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), ".previews", "img.png.jpg"))
+	// the preview is already removed
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	// PDF conversion
+	if runtime.GOOS != osWindows {
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.pdf"), []byte("%PDF-1.4"), 0666)
+		assert.NoError(t, err)
+		pngPath := filepath.Join(user.GetHomeDir(), "page.png")
+		err = os.WriteFile(pngPath, getTestPNG(t, 50, 80), 0666)
+		assert.NoError(t, err)
+		cmdPath := filepath.Join(os.TempDir(), "pdf_preview.sh")
+		err = os.WriteFile(cmdPath, []byte("#!/bin/sh\ncp "+pngPath+" \"$2\"\n"), 0755)
+		assert.NoError(t, err)
+		action.Options.ThumbnailConfig.PDFCommand = cmdPath
+		action.Options.ThumbnailConfig.Timeout = 10
+		params.Event = operationUpload
+		params.VirtualPath = "/file.pdf"
+		err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+		assert.NoError(t, err)
+		data, err = os.ReadFile(filepath.Join(user.GetHomeDir(), ".previews", "file.pdf.jpg"))
+		assert.NoError(t, err)
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 50, img.Bounds().Dx())
+			assert.Equal(t, 80, img.Bounds().Dy())
+		}
+		action.Options.ThumbnailConfig.PDFCommand = filepath.Join(os.TempDir(), "missing_pdf_cmd")
+		err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+		assert.Error(t, err)
+		err = os.Remove(cmdPath)
+		assert.NoError(t, err)
+	}
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
@@ -16,17 +16,28 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -35,12 +46,66 @@ const (
 	// DefaultTLSKeyPaidID defines the id to use for non-binding specific key pairs
 	DefaultTLSKeyPaidID = "default"
 	pemCRLType          = "X509 CRL"
+	ocspDefaultTimeout  = 5
+	// OCSP responses without a next update time are cached for this duration
+	ocspDefaultCacheTime = 10 * time.Minute
+	ocspMaxResponseSize  = 1024 * 1024
+)
+
+const (
+	revocationStatusUnknown = iota
+	revocationStatusGood
+	revocationStatusRevoked
 )
 
 var (
 	pemCRLPrefix = []byte("-----BEGIN X509 CRL")
 )
 
+// RevocationConfig defines how the revocation status of client certificates
+// is checked in addition to the configured CRLs
+type RevocationConfig struct {
+	// Set to true to check the revocation status of client certificates using OCSP.
+	// The responder URL is read from the client certificate
+	OCSPEnabled bool `json:"ocsp_enabled" mapstructure:"ocsp_enabled"`
+	// OCSP responder URL to use instead of the one defined in the client certificates
+	OCSPResponder string `json:"ocsp_responder" mapstructure:"ocsp_responder"`
+	// Timeout for OCSP requests as seconds. 0 means the default: 5 seconds
+	OCSPTimeout int `json:"ocsp_timeout" mapstructure:"ocsp_timeout"`
+	// Set to true to reject client certificates whose revocation status cannot be
+	// determined, for example because the OCSP responder is unreachable or no CRL
+	// is available for the issuing CA. By default these certificates are accepted
+	// and a warning is logged
+	HardFail bool `json:"hard_fail" mapstructure:"hard_fail"`
+}
+
+func (c *RevocationConfig) validate() error {
+	if c.OCSPTimeout < 0 {
+		return fmt.Errorf("invalid OCSP timeout: %d", c.OCSPTimeout)
+	}
+	if c.OCSPTimeout == 0 {
+		c.OCSPTimeout = ocspDefaultTimeout
+	}
+	if c.OCSPResponder != "" {
+		u, err := url.Parse(c.OCSPResponder)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid OCSP responder %q", c.OCSPResponder)
+		}
+	}
+	return nil
+}
+
+type ocspCacheEntry struct {
+	status     int
+	expiration time.Time
+}
+
+// GetVirtualHostCertificateID returns the ID of the TLS key pair for the specified
+// virtual host of the binding with the given address
+func GetVirtualHostCertificateID(bindingAddress, host string) string {
+	return fmt.Sprintf("%s#%s", bindingAddress, strings.ToLower(host))
+}
+
 // TLSKeyPair defines the paths and the unique identifier for a TLS key pair
 type TLSKeyPair struct {
 	Cert string
@@ -61,12 +126,14 @@ type CertManager struct {
 	certsInfo         map[string]fs.FileInfo
 	rootCAs           *x509.CertPool
 	crls              []*x509.RevocationList
+	revocation        RevocationConfig
+	ocspCache         map[string]ocspCacheEntry
 }
 
 // Reload tries to reload certificate and CRLs
 func (m *CertManager) Reload() error {
 	errCrt := m.loadCertificates()
-	errCRLs := m.LoadCRLs()
+	errCRLs := m.ReloadRevocationData()
 
 	if errCrt != nil {
 		return errCrt
@@ -111,17 +178,35 @@ func (m *CertManager) loadCertificates() error {
 // GetCertificateFunc returns the loaded certificate
 func (m *CertManager) GetCertificateFunc(certID string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		m.RLock()
-		defer m.RUnlock()
+		return m.getCertificate(certID)
+	}
+}
 
-		val, ok := m.certs[certID]
-		if !ok {
-			logger.Error(m.logSender, "", "no certificate for id %s", certID)
-			return nil, fmt.Errorf("no certificate for id %s", certID)
+// GetCertificateFuncForHosts returns the loaded certificate for the server name
+// requested by the client using SNI. hostCertIDs maps the lower cased host names
+// to certificate IDs, certID is used if the requested server name does not match
+func (m *CertManager) GetCertificateFuncForHosts(certID string, hostCertIDs map[string]string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello != nil {
+			if hostCertID, ok := hostCertIDs[strings.ToLower(hello.ServerName)]; ok {
+				return m.getCertificate(hostCertID)
+			}
 		}
+		return m.getCertificate(certID)
+	}
+}
 
-		return val, nil
+func (m *CertManager) getCertificate(certID string) (*tls.Certificate, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	val, ok := m.certs[certID]
+	if !ok {
+		logger.Error(m.logSender, "", "no certificate for id %s", certID)
+		return nil, fmt.Errorf("no certificate for id %s", certID)
 	}
+
+	return val, nil
 }
 
 // IsRevoked returns true if the specified certificate has been revoked
@@ -134,19 +219,182 @@ func (m *CertManager) IsRevoked(crt *x509.Certificate, caCrt *x509.Certificate)
 		return len(m.crls) > 0
 	}
 
+	isRevoked, _ := m.checkCRLs(crt, caCrt)
+	return isRevoked
+}
+
+// checkCRLs returns if the certificate is revoked and if at least a CRL
+// signed by the specified CA was found. It must be called with the lock held
+func (m *CertManager) checkCRLs(crt *x509.Certificate, caCrt *x509.Certificate) (bool, bool) {
+	isFound := false
 	for _, crl := range m.crls {
 		if crl.CheckSignatureFrom(caCrt) == nil {
+			isFound = true
 			for _, rc := range crl.RevokedCertificates {
 				if rc.SerialNumber.Cmp(crt.SerialNumber) == 0 {
-					return true
+					return true, true
 				}
 			}
 		}
 	}
 
+	return false, isFound
+}
+
+// IsChainRevoked checks the client certificate of the specified verified chain
+// using the configured CRLs and OCSP. It returns true if the certificate has been
+// revoked or if hard fail is enabled and its revocation status cannot be determined
+func (m *CertManager) IsChainRevoked(chain []*x509.Certificate) bool {
+	m.RLock()
+	hasCRLs := len(m.crls) > 0
+	config := m.revocation
+	m.RUnlock()
+
+	if len(chain) == 0 {
+		logger.Error(m.logSender, "", "unable to verify an empty certificate chain")
+		return hasCRLs || config.OCSPEnabled
+	}
+	crt := chain[0]
+	caCrt := chain[len(chain)-1]
+	issuer := caCrt
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	m.RLock()
+	isRevoked, isCRLFound := m.checkCRLs(crt, caCrt)
+	if !isRevoked && !isCRLFound && issuer != caCrt {
+		isRevoked, isCRLFound = m.checkCRLs(crt, issuer)
+	}
+	m.RUnlock()
+
+	if isRevoked {
+		return true
+	}
+	if config.OCSPEnabled {
+		switch m.getOCSPStatus(crt, issuer, config) {
+		case revocationStatusRevoked:
+			return true
+		case revocationStatusGood:
+			return false
+		}
+	} else if !hasCRLs {
+		return false
+	}
+	if isCRLFound {
+		return false
+	}
+	if config.HardFail {
+		logger.Warn(m.logSender, "", "unable to determine the revocation status for certificate %q, serial %s, rejected",
+			crt.Subject.String(), crt.SerialNumber)
+		return true
+	}
+	logger.Debug(m.logSender, "", "unable to determine the revocation status for certificate %q, serial %s, accepted",
+		crt.Subject.String(), crt.SerialNumber)
 	return false
 }
 
+func (m *CertManager) getOCSPStatus(crt, issuer *x509.Certificate, config RevocationConfig) int {
+	hash := sha256.Sum256(crt.Raw)
+	key := hex.EncodeToString(hash[:])
+
+	m.RLock()
+	entry, ok := m.ocspCache[key]
+	m.RUnlock()
+
+	if ok && time.Now().Before(entry.expiration) {
+		return entry.status
+	}
+
+	responder := config.OCSPResponder
+	if responder == "" && len(crt.OCSPServer) > 0 {
+		responder = crt.OCSPServer[0]
+	}
+	if responder == "" {
+		logger.Debug(m.logSender, "", "no OCSP responder for certificate %q", crt.Subject.String())
+		return revocationStatusUnknown
+	}
+	resp, err := m.queryOCSPResponder(responder, crt, issuer, config.OCSPTimeout)
+	if err != nil {
+		logger.Warn(m.logSender, "", "unable to get the OCSP status for certificate %q from responder %q: %v",
+			crt.Subject.String(), responder, err)
+		return revocationStatusUnknown
+	}
+	var status int
+	switch resp.Status {
+	case ocsp.Good:
+		status = revocationStatusGood
+	case ocsp.Revoked:
+		status = revocationStatusRevoked
+	default:
+		logger.Debug(m.logSender, "", "OCSP responder %q returned an unknown status for certificate %q",
+			responder, crt.Subject.String())
+		return revocationStatusUnknown
+	}
+	now := time.Now()
+	expiration := now.Add(ocspDefaultCacheTime)
+	if !resp.NextUpdate.IsZero() {
+		expiration = resp.NextUpdate
+	}
+	if expiration.After(now) {
+		m.Lock()
+		for k, v := range m.ocspCache {
+			if now.After(v.expiration) {
+				delete(m.ocspCache, k)
+			}
+		}
+		m.ocspCache[key] = ocspCacheEntry{
+			status:     status,
+			expiration: expiration,
+		}
+		m.Unlock()
+	}
+	return status
+}
+
+func (m *CertManager) queryOCSPResponder(responder string, crt, issuer *x509.Certificate, timeout int) (*ocsp.Response, error) {
+	reqBody, err := ocsp.CreateRequest(crt, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	client := httpclient.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(body, crt, issuer)
+}
+
+// ReloadRevocationData reloads the CRLs and removes the cached OCSP responses
+func (m *CertManager) ReloadRevocationData() error {
+	m.Lock()
+	m.ocspCache = make(map[string]ocspCacheEntry)
+	m.Unlock()
+
+	return m.LoadCRLs()
+}
+
 // LoadCRLs tries to load certificate revocation lists from the given paths
 func (m *CertManager) LoadCRLs() error {
 	if len(m.caRevocationLists) == 0 {
@@ -251,6 +499,16 @@ func (m *CertManager) SetCARevocationLists(caRevocationLists []string) {
 	m.caRevocationLists = util.RemoveDuplicates(caRevocationLists, true)
 }
 
+// SetRevocationConfig validates and sets the revocation checks configuration.
+// This should not be changed at runtime
+func (m *CertManager) SetRevocationConfig(config RevocationConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	m.revocation = config
+	return nil
+}
+
 func (m *CertManager) monitor() {
 	certsInfo := make(map[string]fs.FileInfo)
 
@@ -292,6 +550,7 @@ func NewCertManager(keyPairs []TLSKeyPair, configDir, logSender string) (*CertMa
 		logSender: logSender,
 		certs:     make(map[string]*tls.Certificate),
 		certsInfo: make(map[string]fs.FileInfo),
+		ocspCache: make(map[string]ocspCacheEntry),
 	}
 	err := manager.loadCertificates()
 	if err != nil {
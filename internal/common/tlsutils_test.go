@@ -15,15 +15,21 @@
 package common
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
 )
 
 const (
@@ -346,6 +352,18 @@ func TestLoadCertificate(t *testing.T) {
 			assert.Contains(t, err.Error(), "no certificate for id unknownID")
 		}
 	}
+	certFunc = certManager.GetCertificateFuncForHosts("unknownID", map[string]string{
+		"localhost": DefaultTLSKeyPaidID,
+	})
+	if assert.NotNil(t, certFunc) {
+		cert, err := certFunc(&tls.ClientHelloInfo{ServerName: "LocalHost"})
+		assert.NoError(t, err)
+		assert.Equal(t, certManager.certs[DefaultTLSKeyPaidID], cert)
+		_, err = certFunc(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "no certificate for id unknownID")
+		}
+	}
 	certManager.SetCACertificates(nil)
 	err = certManager.LoadRootCAs()
 	assert.NoError(t, err)
@@ -433,6 +451,142 @@ func TestLoadCertificate(t *testing.T) {
 	stopEventScheduler()
 }
 
+func TestRevocationChecks(t *testing.T) {
+	caPair, err := tls.X509KeyPair([]byte(caCRT), []byte(caKey))
+	require.NoError(t, err)
+	caCrt, err := x509.ParseCertificate(caPair.Certificate[0])
+	require.NoError(t, err)
+	crt, err := tls.X509KeyPair([]byte(client1Crt), []byte(client1Key))
+	require.NoError(t, err)
+	client1, err := x509.ParseCertificate(crt.Certificate[0])
+	require.NoError(t, err)
+	crt, err = tls.X509KeyPair([]byte(client2Crt), []byte(client2Key))
+	require.NoError(t, err)
+	client2, err := x509.ParseCertificate(crt.Certificate[0])
+	require.NoError(t, err)
+
+	var numRequests atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		switch {
+		case r.URL.Path == "/unknown":
+			template.Status = ocsp.Unknown
+		case r.URL.Path == "/expired":
+			template.NextUpdate = time.Now().Add(-time.Second)
+		case req.SerialNumber.Cmp(client2.SerialNumber) == 0:
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Hour)
+		}
+		resp, err := ocsp.CreateResponse(caCrt, caCrt, template, caPair.PrivateKey.(crypto.Signer))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp) //nolint:errcheck
+	}))
+	defer responder.Close()
+
+	certManager := &CertManager{
+		configDir: configDir,
+		logSender: logSenderTest,
+		ocspCache: make(map[string]ocspCacheEntry),
+	}
+	chain1 := []*x509.Certificate{client1, caCrt}
+	chain2 := []*x509.Certificate{client2, caCrt}
+	// no revocation checks configured
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.False(t, certManager.IsChainRevoked(chain2))
+	assert.False(t, certManager.IsChainRevoked(nil))
+
+	err = certManager.SetRevocationConfig(RevocationConfig{OCSPTimeout: -1})
+	assert.Error(t, err)
+	err = certManager.SetRevocationConfig(RevocationConfig{OCSPResponder: "ftp://127.0.0.1"})
+	assert.Error(t, err)
+	err = certManager.SetRevocationConfig(RevocationConfig{
+		OCSPEnabled:   true,
+		OCSPResponder: responder.URL,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ocspDefaultTimeout, certManager.revocation.OCSPTimeout)
+	assert.True(t, certManager.IsChainRevoked(nil))
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.True(t, certManager.IsChainRevoked(chain2))
+	assert.Equal(t, int32(2), numRequests.Load())
+	// the responses are cached
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.True(t, certManager.IsChainRevoked(chain2))
+	assert.Equal(t, int32(2), numRequests.Load())
+	err = certManager.ReloadRevocationData()
+	assert.NoError(t, err)
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.Equal(t, int32(3), numRequests.Load())
+	// responses already expired are not cached
+	certManager.revocation.OCSPResponder = responder.URL + "/expired"
+	assert.NoError(t, certManager.ReloadRevocationData())
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.Equal(t, int32(5), numRequests.Load())
+	// soft and hard fail
+	for _, p := range []string{"/error", "/unknown"} {
+		certManager.revocation.OCSPResponder = responder.URL + p
+		certManager.revocation.HardFail = false
+		assert.NoError(t, certManager.ReloadRevocationData())
+		assert.False(t, certManager.IsChainRevoked(chain1))
+		certManager.revocation.HardFail = true
+		assert.True(t, certManager.IsChainRevoked(chain1))
+	}
+	// the test certificates do not define an OCSP responder
+	certManager.revocation.OCSPResponder = ""
+	assert.True(t, certManager.IsChainRevoked(chain1))
+	certManager.revocation.HardFail = false
+	assert.False(t, certManager.IsChainRevoked(chain1))
+
+	caCrlPath := filepath.Join(os.TempDir(), "testcrl.crt")
+	err = os.WriteFile(caCrlPath, []byte(caCRL), os.ModePerm)
+	assert.NoError(t, err)
+	certManager.SetCARevocationLists([]string{caCrlPath})
+	err = certManager.ReloadRevocationData()
+	assert.NoError(t, err)
+	certManager.revocation.OCSPEnabled = false
+	certManager.revocation.HardFail = true
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.True(t, certManager.IsChainRevoked(chain2))
+	// no CRL for the issuing CA
+	assert.True(t, certManager.IsChainRevoked([]*x509.Certificate{client1}))
+	certManager.revocation.HardFail = false
+	assert.False(t, certManager.IsChainRevoked([]*x509.Certificate{client1}))
+	// a CRL is available, the OCSP failure is not fatal
+	certManager.revocation.OCSPEnabled = true
+	certManager.revocation.OCSPResponder = responder.URL + "/error"
+	certManager.revocation.HardFail = true
+	assert.False(t, certManager.IsChainRevoked(chain1))
+	assert.True(t, certManager.IsChainRevoked(chain2))
+
+	err = os.Remove(caCrlPath)
+	assert.NoError(t, err)
+}
+
 func TestLoadInvalidCert(t *testing.T) {
 	startEventScheduler()
 	certManager, err := NewCertManager(nil, configDir, logSenderTest)
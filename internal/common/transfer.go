@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/dlp"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -56,6 +57,27 @@ type BaseTransfer struct { //nolint:maligned
 	aTime           time.Time
 	mTime           time.Time
 	transferQuota   dataprovider.TransferQuota
+	checksums       *uploadChecksums
+	download        *resumableDownload
+	throttle        transferThrottle
+	// checksums stored for the file replaced by this upload, if any
+	previousChecksums map[string]string
+	// true if this transfer holds a slot limiting the user's concurrent transfers
+	hasTransferSlot atomic.Bool
+	// set if no transfer slot was available within the queue timeout
+	errTransferSlot error
+	// total bytes expected for this transfer, 0 if unknown
+	expectedSize atomic.Int64
+	// set if the uploaded content type must be checked
+	contentChecker *uploadContentChecker
+	// streams the upload to the antivirus engine in inline mode
+	avStream *antivirusStream
+	// result of the inline antivirus scan, if any
+	avResult *antivirusResult
+	// result of the DLP inspection, if any
+	dlpResult *dlp.Result
+	// true if the upload is blocked by a DLP policy
+	dlpBlocked bool
 	sync.Mutex
 	errAbort    error
 	ErrTransfer error
@@ -87,11 +109,77 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 	t.AbortTransfer.Store(false)
 	t.BytesSent.Store(0)
 	t.BytesReceived.Store(0)
+	if transferType == TransferUpload && minWriteOffset == 0 {
+		if _, ok := fs.(vfs.FsChecksummer); ok && !vfs.IsCryptOsFs(fs) {
+			t.checksums = newUploadChecksums(Config.UploadChecksums)
+		}
+		if t.checksums != nil && !isNewFile && Config.SkipIdenticalUploads {
+			t.previousChecksums = t.getPreviousChecksums()
+		}
+		if filter, ok := conn.User.GetContentTypesFilter(requestPath); ok {
+			t.contentChecker = newUploadContentChecker(filter)
+		}
+		t.avStream = newAntivirusStream()
+	}
+	if conn.User.Filters.MaxTransfers > 0 {
+		t.acquireTransferSlot()
+	}
 
 	conn.AddTransfer(t)
 	return t
 }
 
+// acquireTransferSlot waits for a free slot if the user has reached the
+// maximum number of concurrent transfers. If no slot is available within
+// the queue timeout the transfer will fail on the first read/write
+func (t *BaseTransfer) acquireTransferSlot() {
+	filters := &t.Connection.User.Filters
+	timeout := time.Duration(filters.TransfersQueueTimeout) * time.Second
+	if err := transferSlots.acquire(t.Connection.User.Username, filters.MaxTransfers, timeout); err != nil {
+		t.Connection.Log(logger.LevelInfo, "unable to start transfer %q, max concurrent transfers: %d, queue timeout: %s",
+			t.requestPath, filters.MaxTransfers, timeout)
+		t.errTransferSlot = err
+		return
+	}
+	t.hasTransferSlot.Store(true)
+}
+
+// UpdateChecksums updates the upload checksums, if enabled, with
+// the data written at the specified offset. The data are also
+// streamed to the antivirus engine for inline scans
+func (t *BaseTransfer) UpdateChecksums(p []byte, off int64) {
+	if t.avStream != nil {
+		t.avStream.update(p, off)
+	}
+	if t.checksums == nil {
+		return
+	}
+	t.checksums.update(p, off)
+}
+
+// SetResumableDownload enables the tracking of partial downloads for this transfer.
+// The download starts at the specified offset of a file with the given size and
+// modification time. The download action is executed once the whole file has been
+// downloaded, even across multiple attempts each one downloading a range of the file
+func (t *BaseTransfer) SetResumableDownload(offset, size int64, modTime time.Time) {
+	t.download = &resumableDownload{
+		offset:  offset,
+		size:    size,
+		modTime: modTime,
+	}
+}
+
+// SetExpectedSize sets the total bytes expected for this transfer, if known.
+// It is used to report the transfer progress
+func (t *BaseTransfer) SetExpectedSize(size int64) {
+	t.expectedSize.Store(size)
+}
+
+// GetExpectedSize returns the total bytes expected for this transfer or 0 if unknown
+func (t *BaseTransfer) GetExpectedSize() int64 {
+	return t.expectedSize.Load()
+}
+
 // GetTransferQuota returns data transfer quota limits
 func (t *BaseTransfer) GetTransferQuota() dataprovider.TransferQuota {
 	return t.transferQuota
@@ -213,6 +301,9 @@ func (t *BaseTransfer) SetCancelFn(cancelFn func()) {
 
 // CheckRead returns an error if read if not allowed
 func (t *BaseTransfer) CheckRead() error {
+	if t.errTransferSlot != nil {
+		return t.errTransferSlot
+	}
 	if t.transferQuota.AllowedDLSize == 0 && t.transferQuota.AllowedTotalSize == 0 {
 		return nil
 	}
@@ -230,6 +321,9 @@ func (t *BaseTransfer) CheckRead() error {
 
 // CheckWrite returns an error if write if not allowed
 func (t *BaseTransfer) CheckWrite() error {
+	if t.errTransferSlot != nil {
+		return t.errTransferSlot
+	}
 	if t.MaxWriteSize > 0 && t.BytesReceived.Load() > t.MaxWriteSize {
 		return t.Connection.GetQuotaExceededError()
 	}
@@ -252,6 +346,12 @@ func (t *BaseTransfer) CheckWrite() error {
 // Supported for local fs only
 func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 	if fsPath == t.GetFsPath() {
+		if t.checksums != nil {
+			t.checksums.disable()
+		}
+		if t.avStream != nil {
+			t.avStream.abort()
+		}
 		if t.File != nil {
 			initialSize := t.InitialSize
 			err := t.File.Truncate(size)
@@ -314,10 +414,10 @@ func (t *BaseTransfer) getUploadFileSize() (int64, int, error) {
 	if err == nil {
 		fileSize = info.Size()
 	}
-	if t.ErrTransfer != nil && vfs.IsCryptOsFs(t.Fs) {
+	if t.ErrTransfer != nil && (vfs.IsCryptOsFs(t.Fs) || vfs.IsCompressedFs(t.Fs)) {
 		errDelete := t.Fs.Remove(t.fsPath, false)
 		if errDelete != nil {
-			t.Connection.Log(logger.LevelWarn, "error removing partial crypto file %q: %v", t.fsPath, errDelete)
+			t.Connection.Log(logger.LevelWarn, "error removing partial encrypted/compressed file %q: %v", t.fsPath, errDelete)
 		} else {
 			fileSize = 0
 			deletedFiles = 1
@@ -352,8 +452,16 @@ func (t *BaseTransfer) checkUploadOutsideHomeDir(err error) int {
 // we try to delete the temporary file
 func (t *BaseTransfer) Close() error {
 	defer t.Connection.RemoveTransfer(t)
+	if t.hasTransferSlot.CompareAndSwap(true, false) {
+		defer transferSlots.release(t.Connection.User.Username)
+	}
 
 	var err error
+	if t.transferType == TransferUpload {
+		t.checkUploadContentOnClose()
+		t.scanUploadOnClose()
+		t.inspectUploadOnClose()
+	}
 	numFiles := t.getUploadedFiles()
 	metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 		t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
@@ -370,6 +478,12 @@ func (t *BaseTransfer) Close() error {
 		}
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %q, deletion error: %v",
 			t.File.Name(), err)
+	} else if t.isUploadContentDenied() {
+		err = t.removeDeniedUpload()
+	} else if t.isUploadRejectedByAntivirus() {
+		err = t.removeRejectedUpload()
+	} else if t.isUploadBlockedByDLP() {
+		err = t.removeBlockedUpload()
 	} else if t.transferType == TransferUpload && t.effectiveFsPath != t.fsPath {
 		if t.ErrTransfer == nil || Config.UploadMode == UploadModeAtomicWithResume {
 			_, _, err = t.Fs.Rename(t.effectiveFsPath, t.fsPath)
@@ -392,8 +506,7 @@ func (t *BaseTransfer) Close() error {
 	if t.transferType == TransferDownload {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, t.BytesSent.Load(), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
-		ExecuteActionNotification(t.Connection, operationDownload, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
-			t.BytesSent.Load(), t.ErrTransfer, elapsed)
+		t.executeDownloadHook(elapsed)
 	} else {
 		statSize, deletedFiles, errStat := t.getUploadFileSize()
 		if errStat == nil {
@@ -408,9 +521,16 @@ func (t *BaseTransfer) Close() error {
 		numFiles -= deletedFiles
 		t.Connection.Log(logger.LevelDebug, "upload file size %d, num files %d, deleted files %d, fs path %q",
 			uploadFileSize, numFiles, deletedFiles, t.fsPath)
-		numFiles, uploadFileSize = t.executeUploadHook(numFiles, uploadFileSize, elapsed)
+		numFiles, uploadFileSize = t.checkChecksums(numFiles, uploadFileSize)
+		if t.isIdenticalUpload(uploadFileSize) {
+			t.Connection.Log(logger.LevelDebug, "upload of identical contents for %q, notifications skipped", t.fsPath)
+		} else {
+			numFiles, uploadFileSize = t.executeUploadHook(numFiles, uploadFileSize, elapsed)
+		}
 		t.updateQuota(numFiles, uploadFileSize)
 		t.updateTimes()
+		t.handleAntivirusOnUploadDone(uploadFileSize)
+		t.handleDLPOnUploadDone(uploadFileSize)
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, t.BytesReceived.Load(), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
 	}
@@ -447,6 +567,24 @@ func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize, elapsed int64) {
 	}
 }
 
+func (t *BaseTransfer) executeDownloadHook(elapsed int64) {
+	fileSize := t.BytesSent.Load()
+	var offset int64
+	if t.download != nil {
+		offset = t.download.offset
+		if partialDownloads.update(t.Connection.User.Username, t.requestPath, t.download.size, t.download.modTime,
+			offset, fileSize) {
+			fileSize = t.download.size
+		} else if t.ErrTransfer == nil {
+			t.Connection.Log(logger.LevelDebug, "partial download for %q, offset %d, bytes sent: %d, the whole file "+
+				"is not yet downloaded", t.requestPath, offset, fileSize)
+			return
+		}
+	}
+	executeActionNotification(t.Connection, operationDownload, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
+		fileSize, offset, t.ErrTransfer, elapsed)
+}
+
 func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize, elapsed int64) (int, int64) {
 	err := ExecuteActionNotification(t.Connection, operationUpload, t.fsPath, t.requestPath, "", "", "",
 		fileSize, t.ErrTransfer, elapsed)
@@ -468,6 +606,100 @@ func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize, elapsed int64)
 	return numFiles, fileSize
 }
 
+// checkChecksums verifies and stores the checksums computed while receiving the upload.
+// The uploaded file is removed if the checksums do not match the ones reported by the
+// storage backend
+func (t *BaseTransfer) checkChecksums(numFiles int, fileSize int64) (int, int64) {
+	fs, ok := t.Fs.(vfs.FsChecksummer)
+	if !ok || len(Config.UploadChecksums) == 0 || vfs.IsCryptOsFs(t.Fs) {
+		return numFiles, fileSize
+	}
+	var checksums map[string]string
+	var size int64
+	if t.checksums != nil && t.ErrTransfer == nil {
+		checksums, size, ok = t.checksums.getChecksums()
+	} else {
+		ok = false
+	}
+	if !ok || size != fileSize {
+		t.Connection.Log(logger.LevelDebug, "upload checksums not available for %q, hashed size: %d, file size: %d",
+			t.fsPath, size, fileSize)
+		if !t.isNewFile && vfs.IsLocalOsFs(t.Fs) {
+			// the checksums stored for the overwritten file are no longer valid
+			fs.SetChecksums(t.fsPath, nil) //nolint:errcheck
+		}
+		return numFiles, fileSize
+	}
+	err := fs.SetChecksums(t.fsPath, checksums)
+	if err == nil {
+		t.Connection.Log(logger.LevelDebug, "upload checksums stored for %q: %+v", t.fsPath, checksums)
+		return numFiles, fileSize
+	}
+	if !errors.Is(err, vfs.ErrChecksumMismatch) {
+		t.Connection.Log(logger.LevelWarn, "unable to store upload checksums for %q: %v", t.fsPath, err)
+		return numFiles, fileSize
+	}
+	t.Connection.Log(logger.LevelError, "upload checksums verification failed: %v", err)
+	t.ErrTransfer = err
+	err = t.Fs.Remove(t.fsPath, false)
+	if err == nil {
+		numFiles--
+		fileSize = 0
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	} else {
+		t.Connection.Log(logger.LevelWarn, "unable to remove path %q after checksums verification failure: %v", t.fsPath, err)
+	}
+	return numFiles, fileSize
+}
+
+// getPreviousChecksums returns the checksums stored for the file replaced
+// by this upload. The replaced file is still available: cloud storage
+// backends replace it when the upload completes, while for the local
+// filesystem the checksums survive the truncation and atomic renames
+func (t *BaseTransfer) getPreviousChecksums() map[string]string {
+	fs, ok := t.Fs.(vfs.FsChecksummer)
+	if !ok {
+		return nil
+	}
+	checksums, err := fs.GetChecksums(t.effectiveFsPath)
+	if err != nil {
+		t.Connection.Log(logger.LevelDebug, "unable to get checksums for the replaced file %q: %v",
+			t.effectiveFsPath, err)
+		return nil
+	}
+	return checksums
+}
+
+// isIdenticalUpload returns true if the checksums computed while receiving
+// the upload match the ones stored for the replaced file. The SHA-256 must
+// be available and match, weaker checksums, such as MD5, are not enough
+func (t *BaseTransfer) isIdenticalUpload(fileSize int64) bool {
+	if len(t.previousChecksums) == 0 || t.ErrTransfer != nil || t.checksums == nil {
+		return false
+	}
+	checksums, size, ok := t.checksums.getChecksums()
+	if !ok || size != fileSize {
+		return false
+	}
+	if _, ok := checksums[vfs.ChecksumSHA256]; !ok {
+		return false
+	}
+	if _, ok := t.previousChecksums[vfs.ChecksumSHA256]; !ok {
+		return false
+	}
+	for algo, value := range checksums {
+		previous, ok := t.previousChecksums[algo]
+		if !ok {
+			continue
+		}
+		if previous != value {
+			return false
+		}
+	}
+	return true
+}
+
 func (t *BaseTransfer) getUploadedFiles() int {
 	numFiles := 0
 	if t.isNewFile {
@@ -493,13 +725,15 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	if t.transferType == TransferUpload && (numFiles != 0 || sizeDiff != 0) {
 		vfolder, err := t.Connection.User.GetVirtualFolderForPath(path.Dir(t.requestPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, //nolint:errcheck
+			dataprovider.UpdateUserVirtualFolderQuota(&t.Connection.User, &vfolder, numFiles, //nolint:errcheck
 				sizeDiff, false)
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
 			}
+			checkQuotaThresholds(&t.Connection.User, &vfolder, sizeDiff)
 		} else {
 			dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
+			checkQuotaThresholds(&t.Connection.User, nil, sizeDiff)
 		}
 		return true
 	}
@@ -510,21 +744,46 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 func (t *BaseTransfer) HandleThrottle() {
 	var wantedBandwidth int64
 	var trasferredBytes int64
+	var groupBandwidth int64
+	var bindingBandwidth int64
+	group, groupUpload, groupDownload := t.Connection.User.GetAggregateBandwidth()
+	scheduledUpload, scheduledDownload, hasSchedule := t.Connection.User.GetScheduledBandwidth(time.Now())
 	if t.transferType == TransferDownload {
 		wantedBandwidth = t.Connection.User.DownloadBandwidth
+		if hasSchedule {
+			wantedBandwidth = scheduledDownload
+		}
+		groupBandwidth = groupDownload
+		bindingBandwidth = t.Connection.bindingBandwidth.download
 		trasferredBytes = t.BytesSent.Load()
 	} else {
 		wantedBandwidth = t.Connection.User.UploadBandwidth
+		if hasSchedule {
+			wantedBandwidth = scheduledUpload
+		}
+		groupBandwidth = groupUpload
+		bindingBandwidth = t.Connection.bindingBandwidth.upload
 		trasferredBytes = t.BytesReceived.Load()
 	}
+	start, bytes, aggregateBytes := t.throttle.update(t.start, wantedBandwidth, trasferredBytes)
 	if wantedBandwidth > 0 {
 		// real and wanted elapsed as milliseconds, bytes as kilobytes
-		realElapsed := time.Since(t.start).Nanoseconds() / 1000000
+		realElapsed := time.Since(start).Nanoseconds() / 1000000
 		// trasferredBytes / 1024 = KB/s, we multiply for 1000 to get milliseconds
-		wantedElapsed := 1000 * (trasferredBytes / 1024) / wantedBandwidth
+		wantedElapsed := 1000 * (bytes / 1024) / wantedBandwidth
 		if wantedElapsed > realElapsed {
 			toSleep := time.Duration(wantedElapsed - realElapsed)
 			time.Sleep(toSleep * time.Millisecond)
 		}
 	}
+	if aggregateBytes <= 0 {
+		return
+	}
+	if groupBandwidth > 0 {
+		groupBandwidthLimiters.wait(group, t.transferType, groupBandwidth, aggregateBytes)
+	}
+	if bindingBandwidth > 0 {
+		bindingBandwidthLimiters.wait(t.Connection.bindingBandwidth.binding, t.transferType, bindingBandwidth,
+			aggregateBytes)
+	}
 }
@@ -15,9 +15,15 @@
 package common
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -471,3 +477,609 @@ func TestUploadOutsideHomeRenameError(t *testing.T) {
 
 	Config.TempPath = oldTempPath
 }
+
+func TestUploadChecksums(t *testing.T) {
+	assert.Nil(t, newUploadChecksums(nil))
+	assert.Nil(t, newUploadChecksums([]string{"unknown"}))
+
+	data := []byte("some test data to hash")
+	c := newUploadChecksums([]string{vfs.ChecksumSHA256, vfs.ChecksumMD5, vfs.ChecksumCRC32C})
+	require.NotNil(t, c)
+	// out of order writes are buffered
+	c.update(data[10:], 10)
+	_, _, ok := c.getChecksums()
+	assert.False(t, ok)
+	c.update(data[5:10], 5)
+	c.update(data[:5], 0)
+	checksums, size, ok := c.getChecksums()
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(data)), size)
+	sha256Sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+	assert.Equal(t, hex.EncodeToString(sha256Sum[:]), checksums[vfs.ChecksumSHA256])
+	assert.Equal(t, hex.EncodeToString(md5Sum[:]), checksums[vfs.ChecksumMD5])
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))),
+		checksums[vfs.ChecksumCRC32C])
+	// overlapping writes disable the checksums
+	c.update(data[:5], 0)
+	_, _, ok = c.getChecksums()
+	assert.False(t, ok)
+
+	c = newUploadChecksums([]string{vfs.ChecksumSHA256})
+	require.NotNil(t, c)
+	c.update(data, 0)
+	c.disable()
+	c.update(data, int64(len(data)))
+	_, _, ok = c.getChecksums()
+	assert.False(t, ok)
+
+	c = newUploadChecksums([]string{vfs.ChecksumMD5})
+	require.NotNil(t, c)
+	c.update(make([]byte, maxChecksumPendingSize+1), 10)
+	c.update(data, 0)
+	_, _, ok = c.getChecksums()
+	assert.False(t, ok)
+}
+
+func TestPartialDownloads(t *testing.T) {
+	tracker := newPartialDownloadsTracker()
+	username := "user"
+	modTime := time.Now()
+	// a complete download is never tracked
+	assert.True(t, tracker.update(username, "/file", 100, modTime, 0, 100))
+	assert.Len(t, tracker.get(username), 0)
+	assert.True(t, tracker.update(username, "/empty", 0, modTime, 0, 0))
+	// failed attempts without any data sent are ignored
+	assert.False(t, tracker.update(username, "/file", 100, modTime, 0, 0))
+	assert.Len(t, tracker.get(username), 0)
+
+	assert.False(t, tracker.update(username, "/file", 100, modTime, 50, 30))
+	assert.False(t, tracker.update(username, "/file", 100, modTime, 0, 20))
+	downloads := tracker.get(username)
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, "/file", downloads[0].Path)
+		assert.Equal(t, int64(100), downloads[0].Size)
+		assert.Equal(t, int64(50), downloads[0].DownloadedSize)
+		assert.Equal(t, int64(20), downloads[0].ResumeOffset)
+	}
+	// overlapping ranges are merged
+	assert.False(t, tracker.update(username, "/file", 100, modTime, 10, 50))
+	downloads = tracker.get(username)
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, int64(80), downloads[0].DownloadedSize)
+		assert.Equal(t, int64(80), downloads[0].ResumeOffset)
+	}
+	assert.True(t, tracker.update(username, "/file", 100, modTime, 70, 30))
+	assert.Len(t, tracker.get(username), 0)
+	// a modified file resets the tracked ranges
+	assert.False(t, tracker.update(username, "/file", 100, modTime, 0, 60))
+	assert.False(t, tracker.update(username, "/file", 100, modTime.Add(time.Second), 60, 40))
+	downloads = tracker.get(username)
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, int64(40), downloads[0].DownloadedSize)
+		assert.Equal(t, int64(0), downloads[0].ResumeOffset)
+	}
+	assert.False(t, tracker.update(username, "/file", 80, modTime, 0, 10))
+	downloads = tracker.get(username)
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, int64(80), downloads[0].Size)
+		assert.Equal(t, int64(10), downloads[0].DownloadedSize)
+	}
+	assert.False(t, tracker.remove(username, "/missing"))
+	assert.False(t, tracker.remove("missing", "/file"))
+	assert.True(t, tracker.remove(username, "/file"))
+	assert.Len(t, tracker.downloads, 0)
+	// the oldest downloads are evicted
+	for i := 0; i < maxPartialDownloadsPerUser+1; i++ {
+		assert.False(t, tracker.update(username, fmt.Sprintf("/file%d", i), 100, modTime, 0, 10))
+	}
+	downloads = tracker.get(username)
+	assert.Len(t, downloads, maxPartialDownloadsPerUser)
+	for _, d := range downloads {
+		assert.NotEqual(t, "/file0", d.Path)
+	}
+	tracker.downloads[username]["/file1"].updatedAt = time.Now().Add(-2 * partialDownloadsExpiration)
+	assert.Len(t, tracker.get(username), maxPartialDownloadsPerUser-1)
+	tracker.removeExpired()
+	assert.Len(t, tracker.downloads[username], maxPartialDownloadsPerUser-1)
+	for p, d := range tracker.downloads[username] {
+		if p != "/file2" {
+			d.updatedAt = time.Now().Add(-2 * partialDownloadsExpiration)
+		}
+	}
+	tracker.removeExpired()
+	assert.Len(t, tracker.downloads[username], 1)
+	assert.True(t, tracker.update(username, "/file2", 100, modTime, 10, 90))
+	assert.Len(t, tracker.downloads, 0)
+}
+
+func TestResumableDownloadHook(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "resumeuser",
+			HomeDir:  filepath.Clean(os.TempDir()),
+		},
+	}
+	conn := NewBaseConnection("", ProtocolHTTP, "", "", user)
+	modTime := time.Now()
+	transfer := NewBaseTransfer(nil, conn, nil, "/file", "/file", "/file", TransferDownload, 0, 0, 0, 0, true,
+		vfs.NewOsFs("", os.TempDir(), ""), dataprovider.TransferQuota{})
+	transfer.SetResumableDownload(0, 100, modTime)
+	transfer.BytesSent.Store(40)
+	err := transfer.Close()
+	assert.NoError(t, err)
+	downloads := conn.GetPartialDownloads()
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, int64(40), downloads[0].ResumeOffset)
+	}
+	transfer = NewBaseTransfer(nil, conn, nil, "/file", "/file", "/file", TransferDownload, 0, 0, 0, 0, true,
+		vfs.NewOsFs("", os.TempDir(), ""), dataprovider.TransferQuota{})
+	transfer.SetResumableDownload(40, 100, modTime)
+	transfer.BytesSent.Store(10)
+	transfer.TransferError(errors.New("client disconnected"))
+	err = transfer.Close()
+	assert.Error(t, err)
+	downloads = conn.GetPartialDownloads()
+	if assert.Len(t, downloads, 1) {
+		assert.Equal(t, int64(50), downloads[0].ResumeOffset)
+	}
+	err = conn.RemovePartialDownload("/file")
+	assert.NoError(t, err)
+	err = conn.RemovePartialDownload("/file")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Len(t, conn.GetPartialDownloads(), 0)
+}
+
+func TestBandwidthSchedules(t *testing.T) {
+	// 2023-06-05 is a Monday
+	monday := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+	schedule := dataprovider.BandwidthSchedule{
+		Days: []int{1},
+		From: "08:00",
+		To:   "18:00",
+	}
+	assert.True(t, schedule.IsActive(monday))
+	assert.True(t, schedule.IsActive(monday.Add(-2*time.Hour)))
+	assert.False(t, schedule.IsActive(monday.Add(8*time.Hour)))
+	assert.False(t, schedule.IsActive(monday.Add(24*time.Hour)))
+	assert.True(t, schedule.HasDay(1))
+	assert.False(t, schedule.HasDay(2))
+	schedule.Days = nil
+	assert.True(t, schedule.IsActive(monday.Add(24*time.Hour)))
+	assert.False(t, schedule.HasDay(2))
+	// the schedule ends the next day
+	schedule.Days = []int{1}
+	schedule.From = "20:00"
+	schedule.To = "06:00"
+	assert.False(t, schedule.IsActive(monday))
+	assert.True(t, schedule.IsActive(monday.Add(11*time.Hour)))
+	assert.True(t, schedule.IsActive(monday.Add(19*time.Hour)))
+	assert.False(t, schedule.IsActive(monday.Add(20*time.Hour)))
+	assert.False(t, schedule.IsActive(monday.Add(-5*time.Hour)))
+	schedule.From = "00:00"
+	schedule.To = "00:00"
+	assert.True(t, schedule.IsActive(monday))
+	assert.False(t, schedule.IsActive(monday.Add(24*time.Hour)))
+	schedule.From = "invalid"
+	assert.False(t, schedule.IsActive(monday))
+	schedule.From = "00:00"
+	schedule.To = "invalid"
+	assert.False(t, schedule.IsActive(monday))
+
+	user := dataprovider.User{}
+	user.Filters.BandwidthSchedules = []dataprovider.BandwidthSchedule{
+		{
+			Days:              []int{2},
+			From:              "00:00",
+			To:                "00:00",
+			UploadBandwidth:   10,
+			DownloadBandwidth: 20,
+		},
+		{
+			From:              "08:00",
+			To:                "18:00",
+			UploadBandwidth:   30,
+			DownloadBandwidth: 40,
+		},
+	}
+	_, _, ok := user.GetScheduledBandwidth(monday.Add(-4 * time.Hour))
+	assert.False(t, ok)
+	ul, dl, ok := user.GetScheduledBandwidth(monday)
+	assert.True(t, ok)
+	assert.Equal(t, int64(30), ul)
+	assert.Equal(t, int64(40), dl)
+	ul, dl, ok = user.GetScheduledBandwidth(monday.Add(24 * time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), ul)
+	assert.Equal(t, int64(20), dl)
+}
+
+func TestScheduledThrottle(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			DownloadBandwidth: 1,
+		},
+	}
+	user.Filters.BandwidthSchedules = []dataprovider.BandwidthSchedule{
+		{
+			From:              "00:00",
+			To:                "00:00",
+			DownloadBandwidth: 50,
+		},
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "", TransferDownload, 0, 0, 0, 0, false,
+		vfs.NewOsFs("", os.TempDir(), ""), dataprovider.TransferQuota{})
+	transfer.BytesSent.Store(10240)
+	startTime := time.Now()
+	transfer.HandleThrottle()
+	assert.GreaterOrEqual(t, time.Since(startTime), 150*time.Millisecond)
+	// an unlimited schedule resets the bytes used to compute the bandwidth
+	transfer.Connection.User.Filters.BandwidthSchedules[0].DownloadBandwidth = 0
+	transfer.BytesSent.Store(1024 * 1024)
+	startTime = time.Now()
+	transfer.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	transfer.Connection.User.Filters.BandwidthSchedules[0].DownloadBandwidth = 100
+	startTime = time.Now()
+	transfer.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	transfer.BytesSent.Store(1024*1024 + 10240)
+	transfer.HandleThrottle()
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond)
+	err := transfer.Close()
+	assert.NoError(t, err)
+}
+
+func TestGroupBandwidthLimiters(t *testing.T) {
+	limiters := newBandwidthLimiters()
+	startTime := time.Now()
+	limiters.wait("group", TransferUpload, 10, 10240)
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	limiters.wait("group", TransferUpload, 10, 5120)
+	assert.GreaterOrEqual(t, time.Since(startTime), 400*time.Millisecond)
+	// downloads use a different bucket
+	startTime = time.Now()
+	limiters.wait("group", TransferDownload, 10, 10240)
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	// transfers larger than the burst size are split
+	limiters.wait("group1", TransferDownload, 1, 2048)
+	assert.GreaterOrEqual(t, time.Since(startTime), 900*time.Millisecond)
+	limiter := limiters.getLimiter("group", TransferUpload, 20)
+	assert.Equal(t, 20480, limiter.Burst())
+	assert.Len(t, limiters.limiters, 2)
+}
+
+func TestBandwidthLimitersFairSharing(t *testing.T) {
+	limiters := newBandwidthLimiters()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		limiters.wait("binding", TransferUpload, 128, 256*1024)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// the concurrent transfer must not wait for the whole reservation of the first one
+	startTime := time.Now()
+	limiters.wait("binding", TransferUpload, 128, 1024)
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond)
+	wg.Wait()
+}
+
+func TestBindingBandwidth(t *testing.T) {
+	conn := NewBaseConnection("", ProtocolFTP, "", "", dataprovider.User{})
+	conn.SetBindingBandwidth("127.0.0.1:2121", 0, 10)
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "", TransferDownload, 0, 0, 0, 0, false, fs,
+		dataprovider.TransferQuota{})
+	startTime := time.Now()
+	transfer.BytesSent.Store(10240)
+	transfer.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	transfer.BytesSent.Store(15360)
+	transfer.HandleThrottle()
+	assert.GreaterOrEqual(t, time.Since(startTime), 400*time.Millisecond)
+	// uploads are not limited
+	upload := NewBaseTransfer(nil, conn, nil, "", "", "", TransferUpload, 0, 0, 0, 0, true, fs,
+		dataprovider.TransferQuota{})
+	startTime = time.Now()
+	upload.BytesReceived.Store(1024 * 1024)
+	upload.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 100*time.Millisecond)
+	err := transfer.Close()
+	assert.NoError(t, err)
+	err = upload.Close()
+	assert.NoError(t, err)
+}
+
+func TestTransferSlots(t *testing.T) {
+	limiter := newTransferSlotsLimiter()
+	username := "slots_user"
+	err := limiter.acquire(username, 1, 0)
+	assert.NoError(t, err)
+	err = limiter.acquire(username, 1, 0)
+	assert.ErrorIs(t, err, ErrTooManyTransfers)
+	startTime := time.Now()
+	err = limiter.acquire(username, 1, 100*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTooManyTransfers)
+	assert.GreaterOrEqual(t, time.Since(startTime), 100*time.Millisecond)
+	assert.Len(t, limiter.users[username].waiting, 0)
+	// a queued transfer gets the released slot
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- limiter.acquire(username, 1, 5*time.Second)
+	}()
+	assert.Eventually(t, func() bool {
+		limiter.Lock()
+		defer limiter.Unlock()
+
+		return len(limiter.users[username].waiting) == 1
+	}, 1*time.Second, 50*time.Millisecond)
+	limiter.release(username)
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, 1, limiter.users[username].active)
+	limiter.release(username)
+	assert.Len(t, limiter.users, 0)
+	// releasing a missing user is a no-op
+	limiter.release(username)
+	assert.Len(t, limiter.users, 0)
+}
+
+func TestMaxTransfers(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "max_transfers_user",
+		},
+	}
+	user.Filters.MaxTransfers = 1
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	transfer1 := NewBaseTransfer(nil, conn, nil, "", "", "/file1", TransferDownload, 0, 0, 0, 0, false, fs,
+		dataprovider.TransferQuota{})
+	assert.NoError(t, transfer1.CheckRead())
+	transfer2 := NewBaseTransfer(nil, conn, nil, "", "", "/file2", TransferUpload, 0, 0, 0, 0, true, fs,
+		dataprovider.TransferQuota{})
+	assert.ErrorIs(t, transfer2.CheckWrite(), ErrTooManyTransfers)
+	assert.ErrorIs(t, transfer2.CheckRead(), ErrTooManyTransfers)
+	err := transfer2.Close()
+	assert.NoError(t, err)
+	// the failed transfer does not release the slot in use
+	transferSlots.Lock()
+	assert.Equal(t, 1, transferSlots.users[user.Username].active)
+	transferSlots.Unlock()
+	// queued transfers start when a slot is released
+	conn.User.Filters.TransfersQueueTimeout = 5
+	transferCh := make(chan *BaseTransfer, 1)
+	go func() {
+		transferCh <- NewBaseTransfer(nil, conn, nil, "", "", "/file3", TransferDownload, 0, 0, 0, 0, false, fs,
+			dataprovider.TransferQuota{})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	err = transfer1.Close()
+	assert.NoError(t, err)
+	transfer3 := <-transferCh
+	assert.NoError(t, transfer3.CheckRead())
+	err = transfer3.Close()
+	assert.NoError(t, err)
+	transferSlots.Lock()
+	assert.Len(t, transferSlots.users, 0)
+	transferSlots.Unlock()
+}
+
+func TestIdenticalUploads(t *testing.T) {
+	data := []byte("identical upload contents")
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{})
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "/file", TransferUpload, 0, 0, 0, 0, false,
+		vfs.NewOsFs("", os.TempDir(), ""), dataprovider.TransferQuota{})
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	transfer.checksums = newUploadChecksums([]string{vfs.ChecksumMD5, vfs.ChecksumSHA256})
+	transfer.checksums.update(data, 0)
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	// a matching MD5 alone is not enough
+	transfer.previousChecksums = map[string]string{
+		vfs.ChecksumMD5: hex.EncodeToString(md5Sum[:]),
+	}
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	transfer.previousChecksums[vfs.ChecksumSHA256] = hex.EncodeToString(sha256Sum[:])
+	assert.True(t, transfer.isIdenticalUpload(int64(len(data))))
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))+1))
+	// MD5 matches, SHA-256 differs, for example for a crafted MD5 collision
+	transfer.previousChecksums[vfs.ChecksumSHA256] = "mismatch"
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	// SHA-256 matches, MD5 differs
+	transfer.previousChecksums[vfs.ChecksumSHA256] = hex.EncodeToString(sha256Sum[:])
+	transfer.previousChecksums[vfs.ChecksumMD5] = "mismatch"
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	// the SHA-256 is not computed for the upload
+	transfer.checksums = newUploadChecksums([]string{vfs.ChecksumMD5})
+	transfer.checksums.update(data, 0)
+	transfer.previousChecksums[vfs.ChecksumMD5] = hex.EncodeToString(md5Sum[:])
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	transfer.checksums = newUploadChecksums([]string{vfs.ChecksumMD5, vfs.ChecksumSHA256})
+	transfer.checksums.update(data, 0)
+	assert.True(t, transfer.isIdenticalUpload(int64(len(data))))
+	// no common algorithms
+	transfer.previousChecksums = map[string]string{
+		vfs.ChecksumCRC32C: "value",
+	}
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	transfer.previousChecksums = map[string]string{
+		vfs.ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+		vfs.ChecksumSHA256: hex.EncodeToString(sha256Sum[:]),
+	}
+	transfer.TransferError(errors.New("upload error"))
+	assert.False(t, transfer.isIdenticalUpload(int64(len(data))))
+	err := transfer.Close()
+	assert.Error(t, err)
+}
+
+func TestIsIdenticalUpload(t *testing.T) {
+	Config.SkipIdenticalUploads = true
+	defer func() {
+		Config.SkipIdenticalUploads = false
+	}()
+
+	data := []byte("identical upload contents")
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	homeDir := filepath.Join(os.TempDir(), "identical_upload")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(homeDir)
+	err = os.WriteFile(filepath.Join(homeDir, "file"), data, 0666)
+	require.NoError(t, err)
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			HomeDir:  homeDir,
+		},
+	}
+	u.Permissions = make(map[string][]string)
+	u.Permissions["/"] = []string{dataprovider.PermAny}
+	conn := NewBaseConnection("", ProtocolS3, "", "", u)
+	size := int64(len(data))
+	// MD5 alone is not enough
+	assert.False(t, conn.IsIdenticalUpload("/file", size, map[string][]byte{
+		vfs.ChecksumMD5: md5Sum[:],
+	}))
+	// MD5 matches, SHA-256 differs
+	assert.False(t, conn.IsIdenticalUpload("/file", size, map[string][]byte{
+		vfs.ChecksumMD5:    md5Sum[:],
+		vfs.ChecksumSHA256: md5Sum[:],
+	}))
+	assert.True(t, conn.IsIdenticalUpload("/file", size, map[string][]byte{
+		vfs.ChecksumMD5:    md5Sum[:],
+		vfs.ChecksumSHA256: sha256Sum[:],
+	}))
+	assert.True(t, conn.IsIdenticalUpload("/file", size, map[string][]byte{
+		vfs.ChecksumSHA256: sha256Sum[:],
+	}))
+	assert.False(t, conn.IsIdenticalUpload("/file", size+1, map[string][]byte{
+		vfs.ChecksumSHA256: sha256Sum[:],
+	}))
+	assert.False(t, conn.IsIdenticalUpload("/missing", size, map[string][]byte{
+		vfs.ChecksumSHA256: sha256Sum[:],
+	}))
+	// the user must be allowed to download the file
+	conn.User.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload,
+		dataprovider.PermOverwrite}
+	assert.False(t, conn.IsIdenticalUpload("/file", size, map[string][]byte{
+		vfs.ChecksumSHA256: sha256Sum[:],
+	}))
+}
+
+func TestDetectContentType(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar")
+	testCases := []struct {
+		data        []byte
+		contentType string
+	}{
+		{nil, "application/octet-stream"},
+		{[]byte("MZ\x90\x00\x03"), "application/x-msdownload"},
+		{[]byte("\x7fELF\x02\x01\x01"), "application/x-executable"},
+		{[]byte("#!/bin/sh\necho test"), "text/x-shellscript"},
+		{[]byte("\x89PNG\x0d\x0a\x1a\x0a"), "image/png"},
+		{[]byte("%PDF-1.7"), "application/pdf"},
+		{[]byte("plain text"), "text/plain"},
+		{tarHeader, "application/x-tar"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.contentType, detectContentType(tc.data), "data: %q", tc.data)
+	}
+	filter := dataprovider.ContentTypesFilter{
+		Path:         "/",
+		AllowedTypes: []string{"image/*", "text/plain"},
+		DeniedTypes:  []string{"image/gif"},
+	}
+	assert.True(t, filter.IsAllowed("image/png"))
+	assert.True(t, filter.IsAllowed("text/plain"))
+	assert.False(t, filter.IsAllowed("image/gif"))
+	assert.False(t, filter.IsAllowed("application/x-msdownload"))
+	assert.False(t, filter.IsAllowed("imagex/png"))
+}
+
+func TestUploadContentTypes(t *testing.T) {
+	testFile := filepath.Join(os.TempDir(), "content_type_file")
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "content_types_user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	u.Permissions = make(map[string][]string)
+	u.Permissions["/"] = []string{dataprovider.PermAny}
+	u.Filters.ContentTypes = []dataprovider.ContentTypesFilter{
+		{
+			Path:        "/",
+			DeniedTypes: []string{"application/x-msdownload"},
+		},
+	}
+	exe := append([]byte("MZ"), make([]byte, 1024)...)
+	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, "", "", u)
+	// the content type is checked before writing the first chunk
+	file, err := os.Create(testFile)
+	require.NoError(t, err)
+	transfer := NewBaseTransfer(file, conn, nil, testFile, testFile, "/content_type_file", TransferUpload, 0, 0, 0, 0,
+		true, fs, dataprovider.TransferQuota{})
+	err = transfer.CheckUploadContent(exe, 0)
+	assert.ErrorIs(t, err, conn.GetPermissionDeniedError())
+	transfer.TransferError(err)
+	err = file.Close()
+	assert.NoError(t, err)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, conn.GetPermissionDeniedError())
+	assert.NoFileExists(t, testFile)
+	// small chunks are collected until the content type can be detected
+	file, err = os.Create(testFile)
+	require.NoError(t, err)
+	transfer = NewBaseTransfer(file, conn, nil, testFile, testFile, "/content_type_file", TransferUpload, 0, 0, 0, 0,
+		true, fs, dataprovider.TransferQuota{})
+	for off := 0; off < contentTypeSniffLen; off += 128 {
+		err = transfer.CheckUploadContent(exe[off:off+128], int64(off))
+		if off+128 < contentTypeSniffLen {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+	transfer.TransferError(err)
+	err = file.Close()
+	assert.NoError(t, err)
+	err = transfer.Close()
+	assert.Error(t, err)
+	assert.NoFileExists(t, testFile)
+	// small files are checked on close, after writing them to the storage backend
+	err = os.WriteFile(testFile, exe[:100], 0666)
+	require.NoError(t, err)
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, testFile, "/content_type_file", TransferUpload, 0, 0, 0, 0,
+		true, fs, dataprovider.TransferQuota{})
+	err = transfer.CheckUploadContent(exe[:100], 0)
+	assert.NoError(t, err)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, conn.GetPermissionDeniedError())
+	assert.NoFileExists(t, testFile)
+	// allowed content type
+	err = os.WriteFile(testFile, []byte("text content"), 0666)
+	require.NoError(t, err)
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, testFile, "/content_type_file", TransferUpload, 0, 0, 0, 0,
+		true, fs, dataprovider.TransferQuota{})
+	err = transfer.CheckUploadContent([]byte("text content"), 0)
+	assert.NoError(t, err)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	assert.FileExists(t, testFile)
+	// resumed uploads are not checked
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, testFile, "/content_type_file", TransferUpload, 12, 12, 0, 0,
+		false, fs, dataprovider.TransferQuota{})
+	assert.Nil(t, transfer.contentChecker)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	err = os.Remove(testFile)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// transferSlots limits the concurrent transfers for the users with a maximum
+// number of concurrent transfers, it is shared by all the connections
+var transferSlots = newTransferSlotsLimiter()
+
+type userTransferSlots struct {
+	active int
+	// transfers waiting for a free slot, in arrival order
+	waiting []chan struct{}
+}
+
+type transferSlotsLimiter struct {
+	sync.Mutex
+	users map[string]*userTransferSlots
+}
+
+func newTransferSlotsLimiter() *transferSlotsLimiter {
+	return &transferSlotsLimiter{
+		users: make(map[string]*userTransferSlots),
+	}
+}
+
+// acquire gets a transfer slot for the specified user. If all the slots are
+// in use it waits, up to the specified timeout, for a slot to be released
+func (l *transferSlotsLimiter) acquire(username string, limit int, timeout time.Duration) error {
+	l.Lock()
+	slots, ok := l.users[username]
+	if !ok {
+		slots = &userTransferSlots{}
+		l.users[username] = slots
+	}
+	if slots.active < limit {
+		slots.active++
+		l.Unlock()
+		return nil
+	}
+	if timeout <= 0 {
+		l.Unlock()
+		return ErrTooManyTransfers
+	}
+	ch := make(chan struct{})
+	slots.waiting = append(slots.waiting, ch)
+	l.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	for idx, waiting := range slots.waiting {
+		if waiting == ch {
+			slots.waiting = append(slots.waiting[:idx], slots.waiting[idx+1:]...)
+			return ErrTooManyTransfers
+		}
+	}
+	// the slot was handed over while the timeout expired
+	return nil
+}
+
+// release frees a transfer slot for the specified user, the slot is handed
+// over to the first waiting transfer, if any
+func (l *transferSlotsLimiter) release(username string) {
+	l.Lock()
+	defer l.Unlock()
+
+	slots, ok := l.users[username]
+	if !ok {
+		return
+	}
+	if len(slots.waiting) > 0 {
+		close(slots.waiting[0])
+		slots.waiting = slots.waiting[1:]
+		return
+	}
+	slots.active--
+	if slots.active <= 0 {
+		delete(l.users, username)
+	}
+}
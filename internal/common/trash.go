@@ -0,0 +1,376 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Deleted files are moved inside the trash directory of the filesystem containing
+// them, so moving a file to the trash is a rename within the same filesystem: a
+// real rename for the local filesystem and a server side copy followed by a delete
+// for Cloud Storage backends. Each deleted file is stored as:
+//
+//	<mount path>/.trash/<deletion time in ms>-<unique id>/<path relative to the mount path>
+//
+// so we can restore it to its original path
+
+var (
+	errTrashItemFound    = errors.New("trash item found")
+	errTrashItemNotFound = errors.New("trash item not found")
+)
+
+// TrashItem defines a file moved to the trash
+type TrashItem struct {
+	// Unique identifier
+	ID string `json:"id"`
+	// Original virtual path
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Deletion time as unix timestamp in milliseconds
+	DeletedAt int64 `json:"deleted_at"`
+}
+
+// GetSizeAsString returns the item size in a human readable format
+func (t *TrashItem) GetSizeAsString() string {
+	return util.ByteCountIEC(t.Size)
+}
+
+// GetDeletedAtAsString returns the deletion time formatted as string
+func (t *TrashItem) GetDeletedAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(t.DeletedAt).UTC().Format("2006-01-02 15:04")
+}
+
+func newTrashItemID() string {
+	return fmt.Sprintf("%d-%s", util.GetTimeAsMsSinceEpoch(time.Now()), xid.New().String())
+}
+
+// parseTrashItemID returns the deletion time for the specified trash item ID
+func parseTrashItemID(id string) (int64, bool) {
+	timestamp, uid, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, false
+	}
+	if _, err := xid.FromString(uid); err != nil {
+		return 0, false
+	}
+	deletedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || deletedAt <= 0 {
+		return 0, false
+	}
+	return deletedAt, true
+}
+
+// isTrashEnabled returns true if deleted files must be moved to the trash.
+// Files deleted by data retention checks and event actions are always removed
+func (c *BaseConnection) isTrashEnabled() bool {
+	if !c.User.Filters.Trash.Enabled {
+		return false
+	}
+	return c.protocol != ProtocolDataRetention && c.protocol != protocolEventAction
+}
+
+// canMoveToTrash returns true if the file with the specified info must be moved
+// to the trash instead of being removed. Symlinks are always removed
+func (c *BaseConnection) canMoveToTrash(info os.FileInfo) bool {
+	return c.isTrashEnabled() && info.Mode()&os.ModeSymlink == 0
+}
+
+func (c *BaseConnection) getTrashMountPaths() []string {
+	mountPaths := []string{"/"}
+	for idx := range c.User.VirtualFolders {
+		mountPaths = append(mountPaths, c.User.VirtualFolders[idx].VirtualPath)
+	}
+	return mountPaths
+}
+
+func (c *BaseConnection) moveToTrash(fs vfs.Fs, fsPath, virtualPath string) error {
+	mountPath := c.User.GetTrashMountPath(virtualPath)
+	trashPath := path.Join(mountPath, dataprovider.TrashDirName, newTrashItemID(),
+		strings.TrimPrefix(virtualPath, mountPath))
+	trashFsPath, err := fs.ResolvePath(trashPath)
+	if err != nil {
+		return err
+	}
+	if err := c.createTrashDirs(fs, mountPath, path.Dir(trashPath)); err != nil {
+		return err
+	}
+	if _, _, err := fs.Rename(fsPath, trashFsPath); err != nil {
+		return err
+	}
+	c.Log(logger.LevelDebug, "file %q moved to the trash as %q", virtualPath, trashPath)
+	return nil
+}
+
+// createTrashDirs creates the missing directories between the trash root
+// and the specified virtual path
+func (c *BaseConnection) createTrashDirs(fs vfs.Fs, mountPath, virtualPath string) error {
+	if fs.HasVirtualFolders() {
+		return nil
+	}
+	trashRoot := path.Join(mountPath, dataprovider.TrashDirName)
+	dirs := util.GetDirsForVirtualPath(virtualPath)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if dirs[idx] != trashRoot && !strings.HasPrefix(dirs[idx], trashRoot+"/") {
+			continue
+		}
+		fsPath, err := fs.ResolvePath(dirs[idx])
+		if err != nil {
+			return err
+		}
+		if _, err := fs.Lstat(fsPath); err == nil || !fs.IsNotExist(err) {
+			continue
+		}
+		if err := fs.Mkdir(fsPath); err != nil {
+			if _, errStat := fs.Lstat(fsPath); errStat != nil {
+				return fmt.Errorf("unable to create trash dir %q: %w", dirs[idx], err)
+			}
+		}
+	}
+	return nil
+}
+
+// ListTrash returns the files moved to the trash, most recently deleted first
+func (c *BaseConnection) ListTrash() ([]TrashItem, error) {
+	if !c.User.Filters.Trash.Enabled {
+		return nil, fmt.Errorf("the trash is not enabled: %w", c.GetOpUnsupportedError())
+	}
+	items := make([]TrashItem, 0)
+	for _, mountPath := range c.getTrashMountPaths() {
+		mountItems, err := c.listTrashItems(mountPath)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, mountItems...)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt > items[j].DeletedAt
+	})
+	return items, nil
+}
+
+func (c *BaseConnection) listTrashItems(mountPath string) ([]TrashItem, error) {
+	fs, fsPath, err := c.GetFsAndResolvedPath(path.Join(mountPath, dataprovider.TrashDirName))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(fsPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil, nil
+		}
+		c.Log(logger.LevelError, "unable to list trash for mount path %q: %v", mountPath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+	var items []TrashItem
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		item, _, err := c.getTrashItem(fs, mountPath, entry.Name())
+		if err != nil {
+			c.Log(logger.LevelDebug, "skipping trash entry %q, mount path %q: %v", entry.Name(), mountPath, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// getTrashItem returns the trash item with the specified ID inside the trash of
+// the given mount path and its filesystem path
+func (c *BaseConnection) getTrashItem(fs vfs.Fs, mountPath, id string) (TrashItem, string, error) {
+	deletedAt, ok := parseTrashItemID(id)
+	if !ok {
+		return TrashItem{}, "", errTrashItemNotFound
+	}
+	itemPath := path.Join(mountPath, dataprovider.TrashDirName, id)
+	itemFsPath, err := fs.ResolvePath(itemPath)
+	if err != nil {
+		return TrashItem{}, "", err
+	}
+	var item TrashItem
+	var fsPath string
+	err = fs.Walk(itemFsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		item = TrashItem{
+			ID:        id,
+			Path:      path.Join(mountPath, strings.TrimPrefix(fs.GetRelativePath(walkedPath), itemPath)),
+			Size:      info.Size(),
+			DeletedAt: deletedAt,
+		}
+		fsPath = walkedPath
+		return errTrashItemFound
+	})
+	if errors.Is(err, errTrashItemFound) {
+		return item, fsPath, nil
+	}
+	if err == nil || fs.IsNotExist(err) {
+		return item, "", errTrashItemNotFound
+	}
+	return item, "", err
+}
+
+func (c *BaseConnection) findTrashItem(id string) (vfs.Fs, string, TrashItem, string, error) {
+	if !c.User.Filters.Trash.Enabled {
+		return nil, "", TrashItem{}, "", fmt.Errorf("the trash is not enabled: %w", c.GetOpUnsupportedError())
+	}
+	if _, ok := parseTrashItemID(id); !ok {
+		return nil, "", TrashItem{}, "", c.GetNotExistError()
+	}
+	for _, mountPath := range c.getTrashMountPaths() {
+		fs, _, err := c.GetFsAndResolvedPath(mountPath)
+		if err != nil {
+			return nil, "", TrashItem{}, "", err
+		}
+		item, fsPath, err := c.getTrashItem(fs, mountPath, id)
+		if err == nil {
+			return fs, mountPath, item, fsPath, nil
+		}
+		if !errors.Is(err, errTrashItemNotFound) {
+			c.Log(logger.LevelError, "unable to get trash item %q, mount path %q: %v", id, mountPath, err)
+			return nil, "", TrashItem{}, "", c.GetFsError(fs, err)
+		}
+	}
+	return nil, "", TrashItem{}, "", c.GetNotExistError()
+}
+
+// RestoreFromTrash restores the trash item with the specified ID to its original path
+func (c *BaseConnection) RestoreFromTrash(id string) error {
+	fs, mountPath, item, fsPath, err := c.findTrashItem(id)
+	if err != nil {
+		return err
+	}
+	if c.User.GetTrashMountPath(item.Path) != mountPath {
+		return fmt.Errorf("cannot restore %q, the filesystem mounted on this path has changed: %w",
+			item.Path, c.GetOpUnsupportedError())
+	}
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(item.Path)) {
+		return c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(item.Path); !ok {
+		c.Log(logger.LevelDebug, "restoring file %q is not allowed", item.Path)
+		return c.GetErrorForDeniedFile(policy)
+	}
+	targetFsPath, err := fs.ResolvePath(item.Path)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if _, err := fs.Lstat(targetFsPath); err == nil {
+		return fmt.Errorf("cannot restore %q, the path already exists: %w", item.Path, c.GetOpUnsupportedError())
+	}
+	if err := c.CheckParentDirs(path.Dir(item.Path)); err != nil {
+		return err
+	}
+	if _, _, err := fs.Rename(fsPath, targetFsPath); err != nil {
+		c.Log(logger.LevelError, "unable to restore trash item %q to %q: %v", id, item.Path, err)
+		return c.GetFsError(fs, err)
+	}
+	c.removeTrashItemDirs(fs, mountPath, id)
+	c.Log(logger.LevelInfo, "trash item %q restored to %q", id, item.Path)
+	return nil
+}
+
+// DeleteFromTrash permanently removes the trash item with the specified ID
+func (c *BaseConnection) DeleteFromTrash(id string) error {
+	fs, mountPath, item, fsPath, err := c.findTrashItem(id)
+	if err != nil {
+		return err
+	}
+	if !c.User.HasAnyPerm([]string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}, path.Dir(item.Path)) {
+		return c.GetPermissionDeniedError()
+	}
+	return c.deleteTrashItem(fs, mountPath, item, fsPath)
+}
+
+func (c *BaseConnection) deleteTrashItem(fs vfs.Fs, mountPath string, item TrashItem, fsPath string) error {
+	if err := fs.Remove(fsPath, false); err != nil {
+		c.Log(logger.LevelError, "unable to remove trash item %q: %v", item.ID, err)
+		return c.GetFsError(fs, err)
+	}
+	c.removeTrashItemDirs(fs, mountPath, item.ID)
+	c.updateQuotaAfterRemove(item.Path, item.Size)
+	c.Log(logger.LevelDebug, "trash item %q, path %q, permanently removed", item.ID, item.Path)
+	return nil
+}
+
+// PurgeTrash permanently removes the files moved to the trash before the
+// specified time and returns the number of removed files
+func (c *BaseConnection) PurgeTrash(deletedBefore time.Time) (int, error) {
+	items, err := c.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+	limit := util.GetTimeAsMsSinceEpoch(deletedBefore)
+	numRemoved := 0
+	for _, item := range items {
+		if item.DeletedAt >= limit {
+			continue
+		}
+		mountPath := c.User.GetTrashMountPath(item.Path)
+		fs, _, err := c.GetFsAndResolvedPath(mountPath)
+		if err != nil {
+			return numRemoved, err
+		}
+		_, fsPath, err := c.getTrashItem(fs, mountPath, item.ID)
+		if err != nil {
+			return numRemoved, fmt.Errorf("unable to get trash item %q: %w", item.ID, err)
+		}
+		if err := c.deleteTrashItem(fs, mountPath, item, fsPath); err != nil {
+			return numRemoved, err
+		}
+		numRemoved++
+	}
+	return numRemoved, nil
+}
+
+// removeTrashItemDirs removes the, now empty, directories for the specified trash item
+func (c *BaseConnection) removeTrashItemDirs(fs vfs.Fs, mountPath, id string) {
+	itemFsPath, err := fs.ResolvePath(path.Join(mountPath, dataprovider.TrashDirName, id))
+	if err != nil {
+		return
+	}
+	var dirs []string
+	fs.Walk(itemFsPath, func(walkedPath string, info os.FileInfo, err error) error { //nolint:errcheck
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, walkedPath)
+		}
+		return nil
+	})
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if err := fs.Remove(dirs[idx], true); err != nil {
+			c.Log(logger.LevelDebug, "unable to remove trash dir %q: %v", dirs[idx], err)
+		}
+	}
+}
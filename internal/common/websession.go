@@ -0,0 +1,274 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	tokensRevocationSessionKey = "tokens_revocation"
+)
+
+// WebSessions tracks the web sessions and the tokens revocation, it is shared
+// among the services that accept the tokens issued by the REST API
+var WebSessions WebSessionManager = &memoryWebSessionManager{}
+
+// WebSessionManager defines the interface to store the web sessions and the
+// tokens revocation time
+type WebSessionManager interface {
+	Add(session *WebSession) error
+	Get(id string) (*WebSession, error)
+	GetAll() ([]WebSession, error)
+	Delete(id string) error
+	// SetRevokedBefore invalidates all the tokens issued before the specified time
+	SetRevokedBefore(t time.Time) error
+	GetRevokedBefore() time.Time
+	Cleanup()
+}
+
+func newWebSessionManager(isShared int) WebSessionManager {
+	if isShared == 1 {
+		logger.Info(logSender, "", "using provider web session manager")
+		return &dbWebSessionManager{}
+	}
+	logger.Info(logSender, "", "using memory web session manager")
+	return &memoryWebSessionManager{}
+}
+
+// WebSession tracks the tokens issued, and refreshed, after a successful
+// login to the WebAdmin, the WebClient or the REST API
+type WebSession struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	IsAdmin   bool   `json:"is_admin"`
+	Role      string `json:"role,omitempty"`
+	Audience  string `json:"audience"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	Revoked   bool   `json:"revoked,omitempty"`
+	// set in API responses for the session of the logged in account
+	Current bool `json:"current,omitempty"`
+}
+
+func (s *WebSession) isExpired() bool {
+	return s.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (s *WebSession) getACopy() WebSession {
+	return WebSession{
+		ID:        s.ID,
+		Username:  s.Username,
+		IsAdmin:   s.IsAdmin,
+		Role:      s.Role,
+		Audience:  s.Audience,
+		IP:        s.IP,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+		ExpiresAt: s.ExpiresAt,
+		Revoked:   s.Revoked,
+	}
+}
+
+// GetCreatedAtAsString returns the session creation time as string
+func (s *WebSession) GetCreatedAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(s.CreatedAt).UTC().Format("2006-01-02 15:04:05")
+}
+
+// GetUpdatedAtAsString returns the time of the last token refresh as string
+func (s *WebSession) GetUpdatedAtAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(s.UpdatedAt).UTC().Format("2006-01-02 15:04:05")
+}
+
+type memoryWebSessionManager struct {
+	sessions      sync.Map
+	revokedBefore atomic.Int64
+}
+
+func (m *memoryWebSessionManager) Add(session *WebSession) error {
+	m.sessions.Store(session.ID, session)
+	return nil
+}
+
+func (m *memoryWebSessionManager) Get(id string) (*WebSession, error) {
+	s, ok := m.sessions.Load(id)
+	if !ok {
+		return nil, util.NewRecordNotFoundError("web session not found")
+	}
+	session := s.(*WebSession)
+	if session.isExpired() {
+		return nil, util.NewRecordNotFoundError("web session expired")
+	}
+	res := session.getACopy()
+	return &res, nil
+}
+
+func (m *memoryWebSessionManager) GetAll() ([]WebSession, error) {
+	var result []WebSession
+	m.sessions.Range(func(key, value any) bool {
+		s, ok := value.(*WebSession)
+		if ok && !s.isExpired() {
+			result = append(result, s.getACopy())
+		}
+		return true
+	})
+	return result, nil
+}
+
+func (m *memoryWebSessionManager) Delete(id string) error {
+	m.sessions.Delete(id)
+	return nil
+}
+
+func (m *memoryWebSessionManager) SetRevokedBefore(t time.Time) error {
+	m.revokedBefore.Store(util.GetTimeAsMsSinceEpoch(t))
+	return nil
+}
+
+func (m *memoryWebSessionManager) GetRevokedBefore() time.Time {
+	val := m.revokedBefore.Load()
+	if val == 0 {
+		return time.Time{}
+	}
+	return util.GetTimeFromMsecSinceEpoch(val)
+}
+
+func (m *memoryWebSessionManager) Cleanup() {
+	m.sessions.Range(func(key, value any) bool {
+		s, ok := value.(*WebSession)
+		if !ok || s.isExpired() {
+			m.sessions.Delete(key)
+		}
+		return true
+	})
+}
+
+type dbWebSessionManager struct{}
+
+func (m *dbWebSessionManager) Add(session *WebSession) error {
+	s := dataprovider.Session{
+		Key:       session.ID,
+		Data:      session,
+		Type:      dataprovider.SessionTypeWebSession,
+		Timestamp: session.ExpiresAt,
+	}
+	return dataprovider.AddSharedSession(s)
+}
+
+func (m *dbWebSessionManager) Get(id string) (*WebSession, error) {
+	s, err := dataprovider.GetSharedSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Type != dataprovider.SessionTypeWebSession {
+		return nil, util.NewRecordNotFoundError("web session not found")
+	}
+	if s.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		return nil, util.NewRecordNotFoundError("web session expired")
+	}
+	return m.decodeData(s.Data)
+}
+
+func (m *dbWebSessionManager) GetAll() ([]WebSession, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeWebSession)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]WebSession, 0, len(sessions))
+	for _, s := range sessions {
+		session, err := m.decodeData(s.Data)
+		if err != nil {
+			continue
+		}
+		if !session.isExpired() {
+			result = append(result, *session)
+		}
+	}
+	return result, nil
+}
+
+func (m *dbWebSessionManager) decodeData(data any) (*WebSession, error) {
+	if val, ok := data.([]byte); ok {
+		session := &WebSession{}
+		err := json.Unmarshal(val, session)
+		return session, err
+	}
+	logger.Error(logSender, "", "invalid web session data type %T", data)
+	return nil, util.NewRecordNotFoundError("invalid web session")
+}
+
+func (m *dbWebSessionManager) Delete(id string) error {
+	return dataprovider.DeleteSharedSession(id)
+}
+
+func (m *dbWebSessionManager) SetRevokedBefore(t time.Time) error {
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       tokensRevocationSessionKey,
+		Data:      map[string]int64{"revoked_before": util.GetTimeAsMsSinceEpoch(t)},
+		Type:      dataprovider.SessionTypeTokensRevocation,
+		Timestamp: util.GetTimeAsMsSinceEpoch(t),
+	})
+}
+
+func (m *dbWebSessionManager) GetRevokedBefore() time.Time {
+	s, err := dataprovider.GetSharedSession(tokensRevocationSessionKey)
+	if err != nil || s.Type != dataprovider.SessionTypeTokensRevocation {
+		return time.Time{}
+	}
+	return util.GetTimeFromMsecSinceEpoch(s.Timestamp)
+}
+
+func (m *dbWebSessionManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeWebSession, time.Now()) //nolint:errcheck
+}
+
+// IsTokenRevoked returns true if the token was issued before the revocation
+// time or if the associated web session was revoked
+func IsTokenRevoked(issuedAt time.Time, sessionID string) bool {
+	if revokedBefore := WebSessions.GetRevokedBefore(); !revokedBefore.IsZero() {
+		// the issued at claim has a seconds precision, tokens issued within
+		// the same second of the revocation time are revoked too
+		if !issuedAt.After(revokedBefore.Truncate(time.Second)) {
+			return true
+		}
+	}
+	if sessionID == "" {
+		return false
+	}
+	session, err := WebSessions.Get(sessionID)
+	if err != nil {
+		return false
+	}
+	return session.Revoked
+}
+
+// RevokeWebSession marks the web session with the specified ID as revoked
+func RevokeWebSession(id string) error {
+	session, err := WebSessions.Get(id)
+	if err != nil {
+		return err
+	}
+	session.Revoked = true
+	return WebSessions.Add(session)
+}
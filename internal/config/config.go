@@ -30,18 +30,28 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/dlp"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/ldap"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/smtpd"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -64,6 +74,12 @@ var (
 		Address:          "",
 		Port:             2022,
 		ApplyProxyConfig: true,
+		KexAlgorithms:    []string{},
+		Ciphers:          []string{},
+		MACs:             []string{},
+		LDAPDirectory:    "",
+		RADIUSServer:     "",
+		KerberosService:  "",
 	}
 	defaultFTPDBinding = ftpd.Binding{
 		Address:                    "",
@@ -76,16 +92,25 @@ var (
 		ForcePassiveIP:             "",
 		PassiveIPOverrides:         nil,
 		PassiveHost:                "",
+		PassiveIPHook:              "",
+		PassivePortRange:           ftpd.PortRange{},
 		ClientAuthType:             0,
 		TLSCipherSuites:            nil,
 		PassiveConnectionsSecurity: 0,
 		ActiveConnectionsSecurity:  0,
+		TLSSessionReuse:            0,
+		AggregateUploadBandwidth:   0,
+		AggregateDownloadBandwidth: 0,
+		LDAPDirectory:              "",
+		RADIUSServer:               "",
 		Debug:                      false,
+		VirtualHosts:               nil,
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
 		Address:              "",
 		Port:                 0,
 		EnableHTTPS:          false,
+		EnableHTTP3:          false,
 		CertificateFile:      "",
 		CertificateKeyFile:   "",
 		MinTLSVersion:        12,
@@ -96,6 +121,51 @@ var (
 		ClientIPProxyHeader:  "",
 		ClientIPHeaderDepth:  0,
 		DisableWWWAuthHeader: false,
+		LDAPDirectory:        "",
+		KerberosService:      "",
+		VirtualHosts:         nil,
+	}
+	defaultS3DBinding = s3d.Binding{
+		Address:             "",
+		Port:                0,
+		EnableHTTPS:         false,
+		CertificateFile:     "",
+		CertificateKeyFile:  "",
+		MinTLSVersion:       12,
+		TLSCipherSuites:     nil,
+		ProxyAllowed:        nil,
+		ClientIPProxyHeader: "",
+		ClientIPHeaderDepth: 0,
+	}
+	defaultTFTPDBinding = tftpd.Binding{
+		Address:  "",
+		Port:     0,
+		Username: "",
+	}
+	defaultGRPCDBinding = grpcd.Binding{
+		Address:            "",
+		Port:               0,
+		EnableTLS:          false,
+		CertificateFile:    "",
+		CertificateKeyFile: "",
+		MinTLSVersion:      12,
+		ClientAuthType:     0,
+		TLSCipherSuites:    nil,
+	}
+	defaultSMTPDBinding = smtpd.Binding{
+		Address:            "",
+		Port:               0,
+		TLSMode:            0,
+		CertificateFile:    "",
+		CertificateKeyFile: "",
+		MinTLSVersion:      12,
+		TLSCipherSuites:    nil,
+	}
+	defaultSMTPDMapping = smtpd.Mapping{
+		Address:        "",
+		Username:       "",
+		Path:           "",
+		AllowedSenders: nil,
 	}
 	defaultHTTPDBinding = httpd.Binding{
 		Address:               "",
@@ -105,6 +175,7 @@ var (
 		EnableRESTAPI:         true,
 		EnabledLoginMethods:   0,
 		EnableHTTPS:           false,
+		EnableHTTP3:           false,
 		CertificateFile:       "",
 		CertificateKeyFile:    "",
 		MinTLSVersion:         12,
@@ -124,6 +195,8 @@ var (
 			UsernameField:              "",
 			RoleField:                  "",
 			ImplicitRoles:              false,
+			GroupsField:                "",
+			RequireGroupMapping:        false,
 			Scopes:                     []string{"openid", "profile", "email"},
 			CustomFields:               []string{},
 			InsecureSkipSignatureCheck: false,
@@ -146,14 +219,17 @@ var (
 			CrossOriginOpenerPolicy: "",
 			ExpectCTHeader:          "",
 		},
-		Branding: httpd.Branding{},
+		Branding:        httpd.Branding{},
+		LDAPDirectory:   "",
+		KerberosService: "",
+		VirtualHosts:    nil,
 	}
 	defaultRateLimiter = common.RateLimiterConfig{
 		Average:                0,
 		Period:                 1000,
 		Burst:                  1,
 		Type:                   2,
-		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP},
+		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP, common.ProtocolS3, common.ProtocolTFTP, common.ProtocolGRPC, common.ProtocolSMTP},
 		GenerateDefenderEvents: false,
 		EntriesSoftLimit:       100,
 		EntriesHardLimit:       150,
@@ -171,6 +247,10 @@ type globalConfig struct {
 	SFTPD           sftpd.Configuration   `json:"sftpd" mapstructure:"sftpd"`
 	FTPD            ftpd.Configuration    `json:"ftpd" mapstructure:"ftpd"`
 	WebDAVD         webdavd.Configuration `json:"webdavd" mapstructure:"webdavd"`
+	S3D             s3d.Configuration     `json:"s3d" mapstructure:"s3d"`
+	TFTPD           tftpd.Configuration   `json:"tftpd" mapstructure:"tftpd"`
+	GRPCD           grpcd.Configuration   `json:"grpcd" mapstructure:"grpcd"`
+	SMTPD           smtpd.Configuration   `json:"smtpd" mapstructure:"smtpd"`
 	ProviderConf    dataprovider.Config   `json:"data_provider" mapstructure:"data_provider"`
 	HTTPDConfig     httpd.Conf            `json:"httpd" mapstructure:"httpd"`
 	HTTPConfig      httpclient.Config     `json:"http" mapstructure:"http"`
@@ -180,6 +260,7 @@ type globalConfig struct {
 	TelemetryConfig telemetry.Conf        `json:"telemetry" mapstructure:"telemetry"`
 	PluginsConfig   []plugin.Config       `json:"plugins" mapstructure:"plugins"`
 	SMTPConfig      smtp.Config           `json:"smtp" mapstructure:"smtp"`
+	GeoIPConfig     geoip.Config          `json:"geoip" mapstructure:"geoip"`
 }
 
 func init() {
@@ -202,6 +283,7 @@ func Init() {
 			},
 			SetstatMode:           0,
 			RenameMode:            0,
+			CrossResourceRename:   0,
 			TempPath:              "",
 			ProxyProtocol:         0,
 			ProxyAllowed:          []string{},
@@ -221,12 +303,74 @@ func Init() {
 				ScoreInvalid:       2,
 				ScoreValid:         1,
 				ScoreLimitExceeded: 3,
+				ScoreAnonymous:     0,
 				ScoreNoAuth:        0,
 				ObservationTime:    30,
 				EntriesSoftLimit:   100,
 				EntriesHardLimit:   150,
+				CrowdSec: common.CrowdSecConfig{
+					Scenario:       "sftpgo/bruteforce",
+					UpdateInterval: 10,
+				},
+				Redis: common.RedisConfig{
+					KeyPrefix: "sftpgo:defender:",
+				},
 			},
 			RateLimitersConfig: []common.RateLimiterConfig{defaultRateLimiter},
+			SFTPFsPool: vfs.SFTPFsPoolConfig{
+				MaxConnections:           0,
+				MaxSessionsPerConnection: 5,
+				IdleTimeout:              30,
+			},
+			ReadCache: vfs.ReadCacheConfig{
+				Path:        "",
+				MaxSize:     0,
+				MaxFileSize: 0,
+			},
+			ListingCache: vfs.ListingCacheConfig{
+				TTL:        0,
+				MaxEntries: 100,
+			},
+			UploadStaging: vfs.UploadStagingConfig{
+				Enabled:         false,
+				MemoryThreshold: 0,
+			},
+			UploadChecksums:      []string{},
+			SkipIdenticalUploads: false,
+			ArchiveDownloads: common.ArchiveDownloadsConfig{
+				ZipSuffix: "",
+				TarSuffix: "",
+			},
+			QuotaReconciliation: common.QuotaReconciliationConfig{
+				Interval:   0,
+				SampleSize: 10,
+			},
+			Antivirus: common.AntivirusConfig{
+				Engine:         "",
+				Address:        "",
+				Mode:           0,
+				QuarantinePath: "",
+				MaxSize:        0,
+				Timeout:        60,
+				RejectOnError:  false,
+			},
+			DLP: dlp.Config{
+				Policies:      []dlp.Policy{},
+				MaxSize:       0,
+				RejectOnError: false,
+			},
+			DeadLetters: common.DeadLetterConfig{
+				Enabled:    false,
+				MaxRetries: 5,
+				RetryDelay: 60,
+			},
+			IPApproval: common.IPApprovalConfig{
+				WebClientURL: "",
+				Expiration:   24,
+			},
+			CredentialsRotation: common.CredentialsRotationConfig{
+				CheckInterval: 0,
+			},
 		},
 		ACME: acme.Configuration{
 			Email:      "",
@@ -257,12 +401,15 @@ func Init() {
 			MACs:                              []string{},
 			TrustedUserCAKeys:                 []string{},
 			RevokedUserCertsFile:              "",
+			UserCAKey:                         "",
+			UserCertValidity:                  60,
 			LoginBannerFile:                   "",
 			EnabledSSHCommands:                []string{},
 			KeyboardInteractiveAuthentication: true,
 			KeyboardInteractiveHook:           "",
 			PasswordAuthentication:            true,
 			FolderPrefix:                      "",
+			AllowTCPForwarding:                false,
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -281,6 +428,12 @@ func Init() {
 			CertificateKeyFile: "",
 			CACertificates:     []string{},
 			CARevocationLists:  []string{},
+			RevocationCheck: common.RevocationConfig{
+				OCSPEnabled:   false,
+				OCSPResponder: "",
+				OCSPTimeout:   5,
+				HardFail:      false,
+			},
 		},
 		WebDAVD: webdavd.Configuration{
 			Bindings:           []webdavd.Binding{defaultWebDAVDBinding},
@@ -288,6 +441,12 @@ func Init() {
 			CertificateKeyFile: "",
 			CACertificates:     []string{},
 			CARevocationLists:  []string{},
+			RevocationCheck: common.RevocationConfig{
+				OCSPEnabled:   false,
+				OCSPResponder: "",
+				OCSPTimeout:   5,
+				HardFail:      false,
+			},
 			Cors: webdavd.CorsConfig{
 				Enabled:              false,
 				AllowedOrigins:       []string{},
@@ -312,6 +471,37 @@ func Init() {
 				},
 			},
 		},
+		S3D: s3d.Configuration{
+			Bindings:                   []s3d.Binding{defaultS3DBinding},
+			CertificateFile:            "",
+			CertificateKeyFile:         "",
+			Region:                     "us-east-1",
+			MultipartUploadsPath:       "s3_multipart_uploads",
+			MultipartUploadsExpiration: 24,
+		},
+		TFTPD: tftpd.Configuration{
+			Bindings: []tftpd.Binding{defaultTFTPDBinding},
+			Timeout:  5,
+			Retries:  5,
+		},
+		GRPCD: grpcd.Configuration{
+			Bindings:           []grpcd.Binding{defaultGRPCDBinding},
+			CertificateFile:    "",
+			CertificateKeyFile: "",
+			CACertificates:     []string{},
+			CARevocationLists:  []string{},
+			SigningPassphrase:  "",
+			TokenValidation:    0,
+		},
+		SMTPD: smtpd.Configuration{
+			Bindings:           []smtpd.Binding{defaultSMTPDBinding},
+			Hostname:           "",
+			MaxMessageSize:     25,
+			MaxAttachments:     20,
+			Mappings:           []smtpd.Mapping{},
+			CertificateFile:    "",
+			CertificateKeyFile: "",
+		},
 		ProviderConf: dataprovider.Config{
 			Driver:             "sqlite",
 			Name:               "sftpgo.db",
@@ -320,6 +510,8 @@ func Init() {
 			Username:           "",
 			Password:           "",
 			ConnectionString:   "",
+			ReadReplicas:       []string{},
+			MaxReplicaLag:      30,
 			SQLTablesPrefix:    "",
 			SSLMode:            0,
 			DisableSNI:         false,
@@ -373,6 +565,26 @@ func Init() {
 				Proto: "http",
 			},
 			BackupsPath: "backups",
+			AuditLog: dataprovider.AuditLogConfig{
+				Enabled:       false,
+				RetentionDays: 0,
+				UserLogins:    false,
+				Syslog: dataprovider.AuditLogSyslogConfig{
+					Enabled: false,
+					Network: "",
+					Address: "",
+				},
+				WebhookURL: "",
+			},
+			ChangeEvents: dataprovider.ChangeEventsConfig{
+				Enabled:       false,
+				RetentionDays: 0,
+				WebhookURL:    "",
+			},
+			SoftDelete: dataprovider.SoftDeleteConfig{
+				RetentionDays: 0,
+				PurgeHomeDirs: false,
+			},
 		},
 		HTTPDConfig: httpd.Conf{
 			Bindings:           []httpd.Binding{defaultHTTPDBinding},
@@ -384,9 +596,15 @@ func Init() {
 			CertificateKeyFile: "",
 			CACertificates:     nil,
 			CARevocationLists:  nil,
-			SigningPassphrase:  "",
-			TokenValidation:    0,
-			MaxUploadFileSize:  0,
+			RevocationCheck: common.RevocationConfig{
+				OCSPEnabled:   false,
+				OCSPResponder: "",
+				OCSPTimeout:   5,
+				HardFail:      false,
+			},
+			SigningPassphrase: "",
+			TokenValidation:   0,
+			MaxUploadFileSize: 0,
 			Cors: httpd.CorsConfig{
 				Enabled:              false,
 				AllowedOrigins:       []string{},
@@ -403,7 +621,27 @@ func Init() {
 				InstallationCode:     "",
 				InstallationCodeHint: defaultInstallCodeHint,
 			},
+			Tus: httpd.TusConfig{
+				Enabled:     false,
+				UploadsPath: "tus_uploads",
+				Expiration:  24,
+			},
 			HideSupportLink: false,
+			RememberedDevices: httpd.RememberedDevicesConfig{
+				WebAdminDays:    0,
+				WebClientDays:   0,
+				BindToIP:        true,
+				BindToUserAgent: true,
+			},
+			LoginProtection: httpd.LoginProtectionConfig{
+				CaptchaProvider:      "",
+				CaptchaSiteKey:       "",
+				CaptchaSecretKey:     "",
+				CaptchaAfterFailures: 3,
+				BaseDelay:            0,
+				MaxDelay:             10000,
+				ObservationTime:      30,
+			},
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
@@ -425,10 +663,34 @@ func Init() {
 				URL:             "",
 				MasterKeyString: "",
 				MasterKeyPath:   "",
+				PKCS11: kms.PKCS11Config{
+					ModulePath: "",
+					TokenLabel: "",
+					Pin:        "",
+					KeyLabel:   "",
+				},
+				Vault: kms.VaultConfig{
+					Address:   "",
+					Namespace: "",
+					MountPath: "transit",
+					Token:     "",
+					AppRole: kms.VaultAppRoleConfig{
+						RoleID:    "",
+						SecretID:  "",
+						MountPath: "approle",
+					},
+				},
 			},
 		},
 		MFAConfig: mfa.Config{
 			TOTP: []mfa.TOTPConfig{defaultTOTP},
+			WebAuthn: mfa.WebAuthnConfig{
+				RPID:          "",
+				RPDisplayName: "",
+				RPOrigins:     nil,
+				Timeout:       0,
+				RequiredRoles: nil,
+			},
 		},
 		TelemetryConfig: telemetry.Conf{
 			BindPort:           0,
@@ -451,6 +713,10 @@ func Init() {
 			Domain:        "",
 			TemplatesPath: "templates",
 		},
+		GeoIPConfig: geoip.Config{
+			CountryDBPath: "",
+			ASNDBPath:     "",
+		},
 		PluginsConfig: nil,
 	}
 
@@ -503,6 +769,46 @@ func SetWebDAVDConfig(config webdavd.Configuration) {
 	globalConf.WebDAVD = config
 }
 
+// GetS3DConfig returns the configuration for the S3 compatible API
+func GetS3DConfig() s3d.Configuration {
+	return globalConf.S3D
+}
+
+// SetS3DConfig sets the configuration for the S3 compatible API
+func SetS3DConfig(config s3d.Configuration) {
+	globalConf.S3D = config
+}
+
+// GetTFTPDConfig returns the configuration for the TFTP server
+func GetTFTPDConfig() tftpd.Configuration {
+	return globalConf.TFTPD
+}
+
+// SetTFTPDConfig sets the configuration for the TFTP server
+func SetTFTPDConfig(config tftpd.Configuration) {
+	globalConf.TFTPD = config
+}
+
+// GetGRPCDConfig returns the configuration for the gRPC server
+func GetGRPCDConfig() grpcd.Configuration {
+	return globalConf.GRPCD
+}
+
+// SetGRPCDConfig sets the configuration for the gRPC server
+func SetGRPCDConfig(config grpcd.Configuration) {
+	globalConf.GRPCD = config
+}
+
+// GetSMTPDConfig returns the configuration for the SMTP server
+func GetSMTPDConfig() smtpd.Configuration {
+	return globalConf.SMTPD
+}
+
+// SetSMTPDConfig sets the configuration for the SMTP server
+func SetSMTPDConfig(config smtpd.Configuration) {
+	globalConf.SMTPD = config
+}
+
 // GetHTTPDConfig returns the configuration for the HTTP server
 func GetHTTPDConfig() httpd.Conf {
 	return globalConf.HTTPDConfig
@@ -573,13 +879,18 @@ func GetSMTPConfig() smtp.Config {
 	return globalConf.SMTPConfig
 }
 
+// GetGeoIPConfig returns the GeoIP configuration
+func GetGeoIPConfig() geoip.Config {
+	return globalConf.GeoIPConfig
+}
+
 // GetACMEConfig returns the ACME configuration
 func GetACMEConfig() acme.Configuration {
 	return globalConf.ACME
 }
 
 // HasServicesToStart returns true if the config defines at least a service to start.
-// Supported services are SFTP, FTP and WebDAV
+// Supported services are SFTP, FTP, WebDAV, S3, TFTP, gRPC, SMTP and HTTP
 func HasServicesToStart() bool {
 	if globalConf.SFTPD.ShouldBind() {
 		return true
@@ -590,6 +901,18 @@ func HasServicesToStart() bool {
 	if globalConf.WebDAVD.ShouldBind() {
 		return true
 	}
+	if globalConf.S3D.ShouldBind() {
+		return true
+	}
+	if globalConf.TFTPD.ShouldBind() {
+		return true
+	}
+	if globalConf.GRPCD.ShouldBind() {
+		return true
+	}
+	if globalConf.SMTPD.ShouldBind() {
+		return true
+	}
 	if globalConf.HTTPDConfig.ShouldBind() {
 		return true
 	}
@@ -801,10 +1124,19 @@ func loadBindingsFromEnv() {
 	for idx := 0; idx < 10; idx++ {
 		getTOTPFromEnv(idx)
 		getRateLimitersFromEnv(idx)
+		getDLPPoliciesFromEnv(idx)
 		getPluginsFromEnv(idx)
+		getLDAPDirectoriesFromEnv(idx)
+		getRADIUSServersFromEnv(idx)
+		getKerberosServicesFromEnv(idx)
 		getSFTPDBindindFromEnv(idx)
 		getFTPDBindingFromEnv(idx)
 		getWebDAVDBindingFromEnv(idx)
+		getS3DBindingFromEnv(idx)
+		getTFTPDBindingFromEnv(idx)
+		getGRPCDBindingFromEnv(idx)
+		getSMTPDBindingFromEnv(idx)
+		getSMTPDMappingFromEnv(idx)
 		getHTTPDBindingFromEnv(idx)
 		getHTTPClientCertificatesFromEnv(idx)
 		getHTTPClientHeadersFromEnv(idx)
@@ -912,450 +1244,1317 @@ func getRateLimitersFromEnv(idx int) {
 	}
 }
 
-func getKMSPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
+func getDLPPoliciesFromEnv(idx int) {
+	policy := dlp.Policy{}
+	if len(globalConf.Common.DLP.Policies) > idx {
+		policy = globalConf.Common.DLP.Policies[idx]
+	}
+
 	isSet := false
 
-	kmsScheme, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__SCHEME", idx))
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__NAME", idx))
 	if ok {
-		pluginConfig.KMSOptions.Scheme = kmsScheme
+		policy.Name = name
 		isSet = true
 	}
 
-	kmsEncStatus, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__ENCRYPTED_STATUS", idx))
+	detectors, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__DETECTORS", idx))
 	if ok {
-		pluginConfig.KMSOptions.EncryptedStatus = kmsEncStatus
+		policy.Detectors = detectors
 		isSet = true
 	}
 
-	return isSet
-}
+	patterns, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__PATTERNS", idx))
+	if ok {
+		policy.Patterns = patterns
+		isSet = true
+	}
 
-func getAuthPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
-	isSet := false
+	minMatches, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__MIN_MATCHES", idx), 0)
+	if ok {
+		policy.MinMatches = int(minMatches)
+		isSet = true
+	}
 
-	authScope, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__AUTH_OPTIONS__SCOPE", idx), 0)
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__CLASSIFIER__ADDRESS", idx))
 	if ok {
-		pluginConfig.AuthOptions.Scope = int(authScope)
+		policy.Classifier.Address = address
 		isSet = true
 	}
 
-	return isSet
+	useTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__CLASSIFIER__USE_TLS", idx))
+	if ok {
+		policy.Classifier.UseTLS = useTLS
+		isSet = true
+	}
+
+	timeout, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__CLASSIFIER__TIMEOUT", idx), 0)
+	if ok {
+		policy.Classifier.Timeout = int(timeout)
+		isSet = true
+	}
+
+	actions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__DLP__POLICIES__%v__ACTIONS", idx))
+	if ok {
+		policy.Actions = actions
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.Common.DLP.Policies) > idx {
+			globalConf.Common.DLP.Policies[idx] = policy
+		} else {
+			globalConf.Common.DLP.Policies = append(globalConf.Common.DLP.Policies, policy)
+		}
+	}
 }
 
-func getNotifierPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
+func getLDAPGroupMappingsFromEnv(idx int) ([]ldap.GroupMapping, bool) {
+	var mappings []ldap.GroupMapping
+	if len(globalConf.ProviderConf.LDAPDirectories) > idx {
+		mappings = globalConf.ProviderConf.LDAPDirectories[idx].GroupMappings
+	}
 	isSet := false
 
-	notifierFsEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__FS_EVENTS", idx))
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var mapping ldap.GroupMapping
+		replace := false
+		if len(mappings) > subIdx {
+			mapping = mappings[subIdx]
+			replace = true
+		}
+		mappingSet := false
+
+		ldapGroup, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_MAPPINGS__%v__LDAP_GROUP",
+			idx, subIdx))
+		if ok {
+			mapping.LDAPGroup = ldapGroup
+			mappingSet = true
+		}
+		group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_MAPPINGS__%v__GROUP",
+			idx, subIdx))
+		if ok {
+			mapping.Group = group
+			mappingSet = true
+		}
+		groupType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_MAPPINGS__%v__TYPE",
+			idx, subIdx), 0)
+		if ok {
+			mapping.Type = int(groupType)
+			mappingSet = true
+		}
+		if mappingSet {
+			isSet = true
+			if replace {
+				mappings[subIdx] = mapping
+			} else {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+	return mappings, isSet
+}
+
+func getLDAPDirectoryConnectionFromEnv(idx int, directory *ldap.Config) bool {
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__NAME", idx))
 	if ok {
-		pluginConfig.NotifierOptions.FsEvents = notifierFsEvents
+		directory.Name = name
 		isSet = true
 	}
 
-	notifierProviderEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_EVENTS", idx))
+	url, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__URL", idx))
 	if ok {
-		pluginConfig.NotifierOptions.ProviderEvents = notifierProviderEvents
+		directory.URL = url
 		isSet = true
 	}
 
-	notifierProviderObjects, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_OBJECTS", idx))
+	startTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__START_TLS", idx))
 	if ok {
-		pluginConfig.NotifierOptions.ProviderObjects = notifierProviderObjects
+		directory.StartTLS = startTLS
 		isSet = true
 	}
 
-	notifierRetryMaxTime, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__RETRY_MAX_TIME", idx), 0)
+	skipTLSVerify, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__SKIP_TLS_VERIFY", idx))
 	if ok {
-		pluginConfig.NotifierOptions.RetryMaxTime = int(notifierRetryMaxTime)
+		directory.SkipTLSVerify = skipTLSVerify
 		isSet = true
 	}
 
-	notifierRetryQueueMaxSize, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__RETRY_QUEUE_MAX_SIZE", idx), 0)
+	caCertificate, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__CA_CERTIFICATE", idx))
 	if ok {
-		pluginConfig.NotifierOptions.RetryQueueMaxSize = int(notifierRetryQueueMaxSize)
+		directory.CACertificate = caCertificate
 		isSet = true
 	}
 
-	return isSet
-}
+	timeout, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__TIMEOUT", idx), 0)
+	if ok {
+		directory.Timeout = int(timeout)
+		isSet = true
+	}
 
-func getPluginsFromEnv(idx int) {
-	pluginConfig := plugin.Config{}
-	if len(globalConf.PluginsConfig) > idx {
-		pluginConfig = globalConf.PluginsConfig[idx]
+	userDNTemplate, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__USER_DN_TEMPLATE", idx))
+	if ok {
+		directory.UserDNTemplate = userDNTemplate
+		isSet = true
+	}
+
+	bindDN, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__BIND_DN", idx))
+	if ok {
+		directory.BindDN = bindDN
+		isSet = true
+	}
+
+	bindPassword, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__BIND_PASSWORD", idx))
+	if ok {
+		directory.BindPassword = bindPassword
+		isSet = true
+	}
+
+	baseDN, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__BASE_DN", idx))
+	if ok {
+		directory.BaseDN = baseDN
+		isSet = true
+	}
+
+	userFilter, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__USER_FILTER", idx))
+	if ok {
+		directory.UserFilter = userFilter
+		isSet = true
 	}
 
+	return isSet
+}
+
+func getLDAPDirectoryProvisioningFromEnv(idx int, directory *ldap.Config) bool {
 	isSet := false
 
-	pluginType, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__TYPE", idx))
+	groupAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_ATTRIBUTE", idx))
 	if ok {
-		pluginConfig.Type = pluginType
+		directory.GroupAttribute = groupAttribute
 		isSet = true
 	}
 
-	if getNotifierPluginFromEnv(idx, &pluginConfig) {
+	groupBaseDN, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_BASE_DN", idx))
+	if ok {
+		directory.GroupBaseDN = groupBaseDN
 		isSet = true
 	}
 
-	if getKMSPluginFromEnv(idx, &pluginConfig) {
+	groupFilter, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__GROUP_FILTER", idx))
+	if ok {
+		directory.GroupFilter = groupFilter
 		isSet = true
 	}
 
-	if getAuthPluginFromEnv(idx, &pluginConfig) {
+	requireGroupMapping, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__REQUIRE_GROUP_MAPPING", idx))
+	if ok {
+		directory.RequireGroupMapping = requireGroupMapping
 		isSet = true
 	}
 
-	cmd, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__CMD", idx))
+	homeDir, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__HOME_DIR", idx))
 	if ok {
-		pluginConfig.Cmd = cmd
+		directory.HomeDir = homeDir
 		isSet = true
 	}
 
-	cmdArgs, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__ARGS", idx))
+	email, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__EMAIL", idx))
 	if ok {
-		pluginConfig.Args = cmdArgs
+		directory.Email = email
 		isSet = true
 	}
 
-	pluginHash, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__SHA256SUM", idx))
+	quotaSize, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__QUOTA_SIZE", idx))
 	if ok {
-		pluginConfig.SHA256Sum = pluginHash
+		directory.QuotaSize = quotaSize
 		isSet = true
 	}
 
-	autoMTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__AUTO_MTLS", idx))
+	quotaFiles, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__QUOTA_FILES", idx))
 	if ok {
-		pluginConfig.AutoMTLS = autoMTLS
+		directory.QuotaFiles = quotaFiles
 		isSet = true
 	}
 
-	if isSet {
-		if len(globalConf.PluginsConfig) > idx {
-			globalConf.PluginsConfig[idx] = pluginConfig
-		} else {
-			globalConf.PluginsConfig = append(globalConf.PluginsConfig, pluginConfig)
-		}
+	permissions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__%v__PERMISSIONS", idx))
+	if ok {
+		directory.Permissions = permissions
+		isSet = true
 	}
+
+	return isSet
 }
 
-func getSFTPDBindindFromEnv(idx int) {
-	binding := defaultSFTPDBinding
-	if len(globalConf.SFTPD.Bindings) > idx {
-		binding = globalConf.SFTPD.Bindings[idx]
+func getLDAPDirectoriesFromEnv(idx int) {
+	directory := ldap.Config{}
+	if len(globalConf.ProviderConf.LDAPDirectories) > idx {
+		directory = globalConf.ProviderConf.LDAPDirectories[idx]
 	}
 
 	isSet := false
 
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PORT", idx), 0)
-	if ok {
-		binding.Port = int(port)
+	if getLDAPDirectoryConnectionFromEnv(idx, &directory) {
 		isSet = true
 	}
 
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__ADDRESS", idx))
-	if ok {
-		binding.Address = address
+	if getLDAPDirectoryProvisioningFromEnv(idx, &directory) {
 		isSet = true
 	}
 
-	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
+	mappings, ok := getLDAPGroupMappingsFromEnv(idx)
 	if ok {
-		binding.ApplyProxyConfig = applyProxyConfig
+		directory.GroupMappings = mappings
 		isSet = true
 	}
 
 	if isSet {
-		if len(globalConf.SFTPD.Bindings) > idx {
-			globalConf.SFTPD.Bindings[idx] = binding
+		if len(globalConf.ProviderConf.LDAPDirectories) > idx {
+			globalConf.ProviderConf.LDAPDirectories[idx] = directory
 		} else {
-			globalConf.SFTPD.Bindings = append(globalConf.SFTPD.Bindings, binding)
+			globalConf.ProviderConf.LDAPDirectories = append(globalConf.ProviderConf.LDAPDirectories, directory)
 		}
 	}
 }
 
-func getFTPDPassiveIPOverridesFromEnv(idx int) []ftpd.PassiveIPOverride {
-	var overrides []ftpd.PassiveIPOverride
-	if len(globalConf.FTPD.Bindings) > idx {
-		overrides = globalConf.FTPD.Bindings[idx].PassiveIPOverrides
+func getRADIUSServersFromEnv(idx int) { //nolint:gocyclo
+	server := radius.Config{}
+	if len(globalConf.ProviderConf.RADIUSServers) > idx {
+		server = globalConf.ProviderConf.RADIUSServers[idx]
 	}
 
-	for subIdx := 0; subIdx < 10; subIdx++ {
-		var override ftpd.PassiveIPOverride
-		var replace bool
-		if len(globalConf.FTPD.Bindings) > idx && len(globalConf.FTPD.Bindings[idx].PassiveIPOverrides) > subIdx {
-			override = globalConf.FTPD.Bindings[idx].PassiveIPOverrides[subIdx]
-			replace = true
-		}
+	isSet := false
 
-		ip, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_IP_OVERRIDES__%v__IP", idx, subIdx))
-		if ok {
-			override.IP = ip
-		}
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__NAME", idx))
+	if ok {
+		server.Name = name
+		isSet = true
+	}
 
-		networks, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_IP_OVERRIDES__%v__NETWORKS",
-			idx, subIdx))
-		if ok {
-			override.Networks = networks
-		}
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__SERVER", idx))
+	if ok {
+		server.Server = address
+		isSet = true
+	}
 
-		if len(override.Networks) > 0 {
-			if replace {
-				overrides[subIdx] = override
-			} else {
-				overrides = append(overrides, override)
-			}
-		}
+	secret, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__SECRET", idx))
+	if ok {
+		server.Secret = secret
+		isSet = true
 	}
 
-	return overrides
-}
+	authType, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__AUTH_TYPE", idx))
+	if ok {
+		server.AuthType = authType
+		isSet = true
+	}
 
-func getDefaultFTPDBinding(idx int) ftpd.Binding {
-	binding := defaultFTPDBinding
-	if len(globalConf.FTPD.Bindings) > idx {
-		binding = globalConf.FTPD.Bindings[idx]
+	timeout, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__TIMEOUT", idx), 0)
+	if ok {
+		server.Timeout = int(timeout)
+		isSet = true
 	}
-	return binding
+
+	retries, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__RETRIES", idx), 0)
+	if ok {
+		server.Retries = int(retries)
+		isSet = true
+	}
+
+	nasIdentifier, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__NAS_IDENTIFIER", idx))
+	if ok {
+		server.NASIdentifier = nasIdentifier
+		isSet = true
+	}
+
+	requireMessageAuth, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__REQUIRE_MESSAGE_AUTHENTICATOR", idx))
+	if ok {
+		server.RequireMessageAuthenticator = requireMessageAuth
+		isSet = true
+	}
+
+	createUsers, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__CREATE_USERS", idx))
+	if ok {
+		server.CreateUsers = createUsers
+		isSet = true
+	}
+
+	permissions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__%v__PERMISSIONS", idx))
+	if ok {
+		server.Permissions = permissions
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.ProviderConf.RADIUSServers) > idx {
+			globalConf.ProviderConf.RADIUSServers[idx] = server
+		} else {
+			globalConf.ProviderConf.RADIUSServers = append(globalConf.ProviderConf.RADIUSServers, server)
+		}
+	}
+}
+
+func getKerberosServicesFromEnv(idx int) {
+	service := kerberos.Config{}
+	if len(globalConf.ProviderConf.KerberosServices) > idx {
+		service = globalConf.ProviderConf.KerberosServices[idx]
+	}
+
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__%v__NAME", idx))
+	if ok {
+		service.Name = name
+		isSet = true
+	}
+
+	keytab, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__%v__KEYTAB", idx))
+	if ok {
+		service.Keytab = keytab
+		isSet = true
+	}
+
+	realms, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__%v__REALMS", idx))
+	if ok {
+		service.Realms = realms
+		isSet = true
+	}
+
+	keepRealm, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__%v__KEEP_REALM", idx))
+	if ok {
+		service.KeepRealm = keepRealm
+		isSet = true
+	}
+
+	maxClockSkew, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__%v__MAX_CLOCK_SKEW", idx), 0)
+	if ok {
+		service.MaxClockSkew = int(maxClockSkew)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.ProviderConf.KerberosServices) > idx {
+			globalConf.ProviderConf.KerberosServices[idx] = service
+		} else {
+			globalConf.ProviderConf.KerberosServices = append(globalConf.ProviderConf.KerberosServices, service)
+		}
+	}
+}
+
+func getKMSPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
+	isSet := false
+
+	kmsScheme, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__SCHEME", idx))
+	if ok {
+		pluginConfig.KMSOptions.Scheme = kmsScheme
+		isSet = true
+	}
+
+	kmsEncStatus, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__ENCRYPTED_STATUS", idx))
+	if ok {
+		pluginConfig.KMSOptions.EncryptedStatus = kmsEncStatus
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getAuthPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
+	isSet := false
+
+	authScope, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__AUTH_OPTIONS__SCOPE", idx), 0)
+	if ok {
+		pluginConfig.AuthOptions.Scope = int(authScope)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getNotifierPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
+	isSet := false
+
+	notifierFsEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__FS_EVENTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.FsEvents = notifierFsEvents
+		isSet = true
+	}
+
+	notifierProviderEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_EVENTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.ProviderEvents = notifierProviderEvents
+		isSet = true
+	}
+
+	notifierProviderObjects, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_OBJECTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.ProviderObjects = notifierProviderObjects
+		isSet = true
+	}
+
+	notifierRetryMaxTime, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__RETRY_MAX_TIME", idx), 0)
+	if ok {
+		pluginConfig.NotifierOptions.RetryMaxTime = int(notifierRetryMaxTime)
+		isSet = true
+	}
+
+	notifierRetryQueueMaxSize, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__RETRY_QUEUE_MAX_SIZE", idx), 0)
+	if ok {
+		pluginConfig.NotifierOptions.RetryQueueMaxSize = int(notifierRetryQueueMaxSize)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getPluginsFromEnv(idx int) {
+	pluginConfig := plugin.Config{}
+	if len(globalConf.PluginsConfig) > idx {
+		pluginConfig = globalConf.PluginsConfig[idx]
+	}
+
+	isSet := false
+
+	pluginType, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__TYPE", idx))
+	if ok {
+		pluginConfig.Type = pluginType
+		isSet = true
+	}
+
+	if getNotifierPluginFromEnv(idx, &pluginConfig) {
+		isSet = true
+	}
+
+	if getKMSPluginFromEnv(idx, &pluginConfig) {
+		isSet = true
+	}
+
+	if getAuthPluginFromEnv(idx, &pluginConfig) {
+		isSet = true
+	}
+
+	cmd, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__CMD", idx))
+	if ok {
+		pluginConfig.Cmd = cmd
+		isSet = true
+	}
+
+	cmdArgs, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__ARGS", idx))
+	if ok {
+		pluginConfig.Args = cmdArgs
+		isSet = true
+	}
+
+	pluginHash, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__SHA256SUM", idx))
+	if ok {
+		pluginConfig.SHA256Sum = pluginHash
+		isSet = true
+	}
+
+	autoMTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__AUTO_MTLS", idx))
+	if ok {
+		pluginConfig.AutoMTLS = autoMTLS
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.PluginsConfig) > idx {
+			globalConf.PluginsConfig[idx] = pluginConfig
+		} else {
+			globalConf.PluginsConfig = append(globalConf.PluginsConfig, pluginConfig)
+		}
+	}
+}
+
+func getSFTPDBindindFromEnv(idx int) {
+	binding := defaultSFTPDBinding
+	if len(globalConf.SFTPD.Bindings) > idx {
+		binding = globalConf.SFTPD.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PORT", idx), 0)
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
+	if ok {
+		binding.ApplyProxyConfig = applyProxyConfig
+		isSet = true
+	}
+
+	kexAlgorithms, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__KEX_ALGORITHMS", idx))
+	if ok {
+		binding.KexAlgorithms = kexAlgorithms
+		isSet = true
+	}
+
+	ciphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__CIPHERS", idx))
+	if ok {
+		binding.Ciphers = ciphers
+		isSet = true
+	}
+
+	macs, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__MACS", idx))
+	if ok {
+		binding.MACs = macs
+		isSet = true
+	}
+
+	ldapDirectory, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__LDAP_DIRECTORY", idx))
+	if ok {
+		binding.LDAPDirectory = ldapDirectory
+		isSet = true
+	}
+
+	radiusServer, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__RADIUS_SERVER", idx))
+	if ok {
+		binding.RADIUSServer = radiusServer
+		isSet = true
+	}
+
+	kerberosService, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__KERBEROS_SERVICE", idx))
+	if ok {
+		binding.KerberosService = kerberosService
+		isSet = true
+	}
+
+	if getGeoIPFilterFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__GEOIP__", idx), &binding.GeoIP) {
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.SFTPD.Bindings) > idx {
+			globalConf.SFTPD.Bindings[idx] = binding
+		} else {
+			globalConf.SFTPD.Bindings = append(globalConf.SFTPD.Bindings, binding)
+		}
+	}
+}
+
+func getFTPDPassiveIPOverridesFromEnv(idx int) []ftpd.PassiveIPOverride {
+	var overrides []ftpd.PassiveIPOverride
+	if len(globalConf.FTPD.Bindings) > idx {
+		overrides = globalConf.FTPD.Bindings[idx].PassiveIPOverrides
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var override ftpd.PassiveIPOverride
+		var replace bool
+		if len(globalConf.FTPD.Bindings) > idx && len(globalConf.FTPD.Bindings[idx].PassiveIPOverrides) > subIdx {
+			override = globalConf.FTPD.Bindings[idx].PassiveIPOverrides[subIdx]
+			replace = true
+		}
+
+		ip, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_IP_OVERRIDES__%v__IP", idx, subIdx))
+		if ok {
+			override.IP = ip
+		}
+
+		networks, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_IP_OVERRIDES__%v__NETWORKS",
+			idx, subIdx))
+		if ok {
+			override.Networks = networks
+		}
+
+		if len(override.Networks) > 0 {
+			if replace {
+				overrides[subIdx] = override
+			} else {
+				overrides = append(overrides, override)
+			}
+		}
+	}
+
+	return overrides
+}
+
+func getDefaultFTPDBinding(idx int) ftpd.Binding {
+	binding := defaultFTPDBinding
+	if len(globalConf.FTPD.Bindings) > idx {
+		binding = globalConf.FTPD.Bindings[idx]
+	}
+	return binding
+}
+
+func getFTPDBindingSecurityFromEnv(idx int, binding *ftpd.Binding) bool {
+	isSet := false
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsMode, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_MODE", idx), 0)
+	if ok {
+		binding.TLSMode = int(tlsMode)
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	if ok {
+		binding.MinTLSVersion = int(tlsVer)
+		isSet = true
+	}
+
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
+
+	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx), 0)
+	if ok {
+		binding.ClientAuthType = int(clientAuthType)
+		isSet = true
+	}
+
+	pasvSecurity, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_CONNECTIONS_SECURITY", idx), 0)
+	if ok {
+		binding.PassiveConnectionsSecurity = int(pasvSecurity)
+		isSet = true
+	}
+
+	activeSecurity, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ACTIVE_CONNECTIONS_SECURITY", idx), 0)
+	if ok {
+		binding.ActiveConnectionsSecurity = int(activeSecurity)
+		isSet = true
+	}
+
+	tlsSessionReuse, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_SESSION_REUSE", idx), 0)
+	if ok {
+		binding.TLSSessionReuse = int(tlsSessionReuse)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getFTPDBindingBandwidthFromEnv(idx int, binding *ftpd.Binding) bool {
+	isSet := false
+
+	uploadBandwidth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__AGGREGATE_UPLOAD_BANDWIDTH", idx), 64)
+	if ok {
+		binding.AggregateUploadBandwidth = uploadBandwidth
+		isSet = true
+	}
+
+	downloadBandwidth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__AGGREGATE_DOWNLOAD_BANDWIDTH", idx), 64)
+	if ok {
+		binding.AggregateDownloadBandwidth = downloadBandwidth
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getFTPDBindingFromEnv(idx int) {
+	binding := getDefaultFTPDBinding(idx)
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PORT", idx), 0)
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
+	if ok {
+		binding.ApplyProxyConfig = applyProxyConfig
+		isSet = true
+	}
+
+	passiveIP, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__FORCE_PASSIVE_IP", idx))
+	if ok {
+		binding.ForcePassiveIP = passiveIP
+		isSet = true
+	}
+
+	passiveIPOverrides := getFTPDPassiveIPOverridesFromEnv(idx)
+	if len(passiveIPOverrides) > 0 {
+		binding.PassiveIPOverrides = passiveIPOverrides
+		isSet = true
+	}
+
+	passiveHost, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_HOST", idx))
+	if ok {
+		binding.PassiveHost = passiveHost
+		isSet = true
+	}
+
+	passiveIPHook, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_IP_HOOK", idx))
+	if ok {
+		binding.PassiveIPHook = passiveIPHook
+		isSet = true
+	}
+
+	passivePortStart, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_PORT_RANGE__START", idx), 0)
+	if ok {
+		binding.PassivePortRange.Start = int(passivePortStart)
+		isSet = true
+	}
+
+	passivePortEnd, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_PORT_RANGE__END", idx), 0)
+	if ok {
+		binding.PassivePortRange.End = int(passivePortEnd)
+		isSet = true
+	}
+
+	ldapDirectory, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__LDAP_DIRECTORY", idx))
+	if ok {
+		binding.LDAPDirectory = ldapDirectory
+		isSet = true
+	}
+
+	radiusServer, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__RADIUS_SERVER", idx))
+	if ok {
+		binding.RADIUSServer = radiusServer
+		isSet = true
+	}
+
+	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__DEBUG", idx))
+	if ok {
+		binding.Debug = debug
+		isSet = true
+	}
+
+	if getFTPDBindingSecurityFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if getFTPDBindingBandwidthFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if getGeoIPFilterFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__GEOIP__", idx), &binding.GeoIP) {
+		isSet = true
+	}
+
+	virtualHosts := getFTPDVirtualHostsFromEnv(idx)
+	if len(virtualHosts) > 0 {
+		binding.VirtualHosts = virtualHosts
+		isSet = true
+	}
+
+	applyFTPDBindingFromEnv(idx, isSet, binding)
+}
+
+func getFTPDVirtualHostsFromEnv(idx int) []ftpd.VirtualHost {
+	var virtualHosts []ftpd.VirtualHost
+	if len(globalConf.FTPD.Bindings) > idx {
+		virtualHosts = globalConf.FTPD.Bindings[idx].VirtualHosts
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var vh ftpd.VirtualHost
+		var replace bool
+		if len(virtualHosts) > subIdx {
+			vh = virtualHosts[subIdx]
+			replace = true
+		}
+		prefix := fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__VIRTUAL_HOSTS__%v__", idx, subIdx)
+		isSet := getVirtualHostFromEnv(prefix, &vh.Host, &vh.CertificateFile, &vh.CertificateKeyFile, &vh.AllowedRoles)
+
+		if isSet && vh.Host != "" {
+			if replace {
+				virtualHosts[subIdx] = vh
+			} else {
+				virtualHosts = append(virtualHosts, vh)
+			}
+		}
+	}
+
+	return virtualHosts
+}
+
+func applyFTPDBindingFromEnv(idx int, isSet bool, binding ftpd.Binding) {
+	if isSet {
+		if len(globalConf.FTPD.Bindings) > idx {
+			globalConf.FTPD.Bindings[idx] = binding
+		} else {
+			globalConf.FTPD.Bindings = append(globalConf.FTPD.Bindings, binding)
+		}
+	}
+}
+
+func getWebDAVDBindingProxyConfigsFromEnv(idx int, binding *webdavd.Binding) bool {
+	isSet := false
+
+	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PROXY_ALLOWED", idx))
+	if ok {
+		binding.ProxyAllowed = proxyAllowed
+		isSet = true
+	}
+
+	clientIPProxyHeader, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_IP_PROXY_HEADER", idx))
+	if ok {
+		binding.ClientIPProxyHeader = clientIPProxyHeader
+		isSet = true
+	}
+
+	clientIPHeaderDepth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_IP_HEADER_DEPTH", idx), 0)
+	if ok {
+		binding.ClientIPHeaderDepth = int(clientIPHeaderDepth)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func loadWebDAVCacheMappingsFromEnv() []webdavd.CustomMimeMapping {
+	for idx := 0; idx < 30; idx++ {
+		ext, extOK := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__CACHE__MIME_TYPES__CUSTOM_MAPPINGS__%d__EXT", idx))
+		mime, mimeOK := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__CACHE__MIME_TYPES__CUSTOM_MAPPINGS__%d__MIME", idx))
+		if extOK && mimeOK {
+			if len(globalConf.WebDAVD.Cache.MimeTypes.CustomMappings) > idx {
+				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings[idx].Ext = ext
+				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings[idx].Mime = mime
+			} else {
+				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings = append(globalConf.WebDAVD.Cache.MimeTypes.CustomMappings,
+					webdavd.CustomMimeMapping{
+						Ext:  ext,
+						Mime: mime,
+					})
+			}
+		}
+	}
+
+	return globalConf.WebDAVD.Cache.MimeTypes.CustomMappings
+}
+
+func getWebDAVDBindingFromEnv(idx int) {
+	binding := defaultWebDAVDBinding
+	if len(globalConf.WebDAVD.Bindings) > idx {
+		binding = globalConf.WebDAVD.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PORT", idx), 0)
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ENABLE_HTTPS", idx))
+	if ok {
+		binding.EnableHTTPS = enableHTTPS
+		isSet = true
+	}
+
+	enableHTTP3, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ENABLE_HTTP3", idx))
+	if ok {
+		binding.EnableHTTP3 = enableHTTP3
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	if ok {
+		binding.MinTLSVersion = int(tlsVer)
+		isSet = true
+	}
+
+	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx), 0)
+	if ok {
+		binding.ClientAuthType = int(clientAuthType)
+		isSet = true
+	}
+
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
+
+	prefix, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PREFIX", idx))
+	if ok {
+		binding.Prefix = prefix
+		isSet = true
+	}
+
+	if getWebDAVDBindingProxyConfigsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	disableWWWAuth, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__DISABLE_WWW_AUTH_HEADER", idx))
+	if ok {
+		binding.DisableWWWAuthHeader = disableWWWAuth
+		isSet = true
+	}
+
+	ldapDirectory, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__LDAP_DIRECTORY", idx))
+	if ok {
+		binding.LDAPDirectory = ldapDirectory
+		isSet = true
+	}
+
+	kerberosService, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__KERBEROS_SERVICE", idx))
+	if ok {
+		binding.KerberosService = kerberosService
+		isSet = true
+	}
+
+	if getGeoIPFilterFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__GEOIP__", idx), &binding.GeoIP) {
+		isSet = true
+	}
+
+	virtualHosts := getWebDAVDVirtualHostsFromEnv(idx)
+	if len(virtualHosts) > 0 {
+		binding.VirtualHosts = virtualHosts
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.WebDAVD.Bindings) > idx {
+			globalConf.WebDAVD.Bindings[idx] = binding
+		} else {
+			globalConf.WebDAVD.Bindings = append(globalConf.WebDAVD.Bindings, binding)
+		}
+	}
+}
+
+func getWebDAVDVirtualHostsFromEnv(idx int) []webdavd.VirtualHost {
+	var virtualHosts []webdavd.VirtualHost
+	if len(globalConf.WebDAVD.Bindings) > idx {
+		virtualHosts = globalConf.WebDAVD.Bindings[idx].VirtualHosts
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var vh webdavd.VirtualHost
+		var replace bool
+		if len(virtualHosts) > subIdx {
+			vh = virtualHosts[subIdx]
+			replace = true
+		}
+		prefix := fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__VIRTUAL_HOSTS__%v__", idx, subIdx)
+		isSet := getVirtualHostFromEnv(prefix, &vh.Host, &vh.CertificateFile, &vh.CertificateKeyFile, &vh.AllowedRoles)
+
+		if isSet && vh.Host != "" {
+			if replace {
+				virtualHosts[subIdx] = vh
+			} else {
+				virtualHosts = append(virtualHosts, vh)
+			}
+		}
+	}
+
+	return virtualHosts
 }
 
-func getFTPDBindingSecurityFromEnv(idx int, binding *ftpd.Binding) bool {
+func getS3DBindingFromEnv(idx int) {
+	binding := defaultS3DBinding
+	if len(globalConf.S3D.Bindings) > idx {
+		binding = globalConf.S3D.Bindings[idx]
+	}
+
 	isSet := false
 
-	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__PORT", idx), 0)
 	if ok {
-		binding.CertificateFile = certificateFile
+		binding.Port = int(port)
 		isSet = true
 	}
 
-	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__ADDRESS", idx))
 	if ok {
-		binding.CertificateKeyFile = certificateKeyFile
+		binding.Address = address
 		isSet = true
 	}
 
-	tlsMode, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_MODE", idx), 0)
+	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__ENABLE_HTTPS", idx))
 	if ok {
-		binding.TLSMode = int(tlsMode)
+		binding.EnableHTTPS = enableHTTPS
 		isSet = true
 	}
 
-	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
 	if ok {
 		binding.MinTLSVersion = int(tlsVer)
 		isSet = true
 	}
 
-	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
 	if ok {
 		binding.TLSCipherSuites = tlsCiphers
 		isSet = true
 	}
 
-	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx), 0)
+	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__PROXY_ALLOWED", idx))
 	if ok {
-		binding.ClientAuthType = int(clientAuthType)
+		binding.ProxyAllowed = proxyAllowed
 		isSet = true
 	}
 
-	pasvSecurity, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_CONNECTIONS_SECURITY", idx), 0)
+	clientIPProxyHeader, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CLIENT_IP_PROXY_HEADER", idx))
 	if ok {
-		binding.PassiveConnectionsSecurity = int(pasvSecurity)
+		binding.ClientIPProxyHeader = clientIPProxyHeader
 		isSet = true
 	}
 
-	activeSecurity, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ACTIVE_CONNECTIONS_SECURITY", idx), 0)
+	clientIPHeaderDepth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CLIENT_IP_HEADER_DEPTH", idx), 0)
 	if ok {
-		binding.ActiveConnectionsSecurity = int(activeSecurity)
+		binding.ClientIPHeaderDepth = int(clientIPHeaderDepth)
 		isSet = true
 	}
 
-	return isSet
+	if isSet {
+		if len(globalConf.S3D.Bindings) > idx {
+			globalConf.S3D.Bindings[idx] = binding
+		} else {
+			globalConf.S3D.Bindings = append(globalConf.S3D.Bindings, binding)
+		}
+	}
 }
 
-func getFTPDBindingFromEnv(idx int) {
-	binding := getDefaultFTPDBinding(idx)
+func getTFTPDBindingFromEnv(idx int) {
+	binding := defaultTFTPDBinding
+	if len(globalConf.TFTPD.Bindings) > idx {
+		binding = globalConf.TFTPD.Bindings[idx]
+	}
+
 	isSet := false
 
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PORT", idx), 0)
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_TFTPD__BINDINGS__%v__PORT", idx), 0)
 	if ok {
 		binding.Port = int(port)
 		isSet = true
 	}
 
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ADDRESS", idx))
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_TFTPD__BINDINGS__%v__ADDRESS", idx))
 	if ok {
 		binding.Address = address
 		isSet = true
 	}
 
-	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
+	username, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_TFTPD__BINDINGS__%v__USERNAME", idx))
 	if ok {
-		binding.ApplyProxyConfig = applyProxyConfig
+		binding.Username = username
 		isSet = true
 	}
 
-	passiveIP, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__FORCE_PASSIVE_IP", idx))
-	if ok {
-		binding.ForcePassiveIP = passiveIP
-		isSet = true
+	if isSet {
+		if len(globalConf.TFTPD.Bindings) > idx {
+			globalConf.TFTPD.Bindings[idx] = binding
+		} else {
+			globalConf.TFTPD.Bindings = append(globalConf.TFTPD.Bindings, binding)
+		}
 	}
+}
 
-	passiveIPOverrides := getFTPDPassiveIPOverridesFromEnv(idx)
-	if len(passiveIPOverrides) > 0 {
-		binding.PassiveIPOverrides = passiveIPOverrides
-		isSet = true
+func getGRPCDBindingFromEnv(idx int) {
+	binding := defaultGRPCDBinding
+	if len(globalConf.GRPCD.Bindings) > idx {
+		binding = globalConf.GRPCD.Bindings[idx]
 	}
 
-	passiveHost, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_HOST", idx))
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__PORT", idx), 0)
 	if ok {
-		binding.PassiveHost = passiveHost
+		binding.Port = int(port)
 		isSet = true
 	}
 
-	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__DEBUG", idx))
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__ADDRESS", idx))
 	if ok {
-		binding.Debug = debug
+		binding.Address = address
 		isSet = true
 	}
 
-	if getFTPDBindingSecurityFromEnv(idx, &binding) {
+	enableTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__ENABLE_TLS", idx))
+	if ok {
+		binding.EnableTLS = enableTLS
 		isSet = true
 	}
 
-	applyFTPDBindingFromEnv(idx, isSet, binding)
-}
-
-func applyFTPDBindingFromEnv(idx int, isSet bool, binding ftpd.Binding) {
-	if isSet {
-		if len(globalConf.FTPD.Bindings) > idx {
-			globalConf.FTPD.Bindings[idx] = binding
-		} else {
-			globalConf.FTPD.Bindings = append(globalConf.FTPD.Bindings, binding)
-		}
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
 	}
-}
-
-func getWebDAVDBindingProxyConfigsFromEnv(idx int, binding *webdavd.Binding) bool {
-	isSet := false
 
-	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PROXY_ALLOWED", idx))
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
 	if ok {
-		binding.ProxyAllowed = proxyAllowed
+		binding.CertificateKeyFile = certificateKeyFile
 		isSet = true
 	}
 
-	clientIPProxyHeader, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_IP_PROXY_HEADER", idx))
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
 	if ok {
-		binding.ClientIPProxyHeader = clientIPProxyHeader
+		binding.MinTLSVersion = int(tlsVer)
 		isSet = true
 	}
 
-	clientIPHeaderDepth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_IP_HEADER_DEPTH", idx), 0)
+	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx), 0)
 	if ok {
-		binding.ClientIPHeaderDepth = int(clientIPHeaderDepth)
+		binding.ClientAuthType = int(clientAuthType)
 		isSet = true
 	}
 
-	return isSet
-}
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
 
-func loadWebDAVCacheMappingsFromEnv() []webdavd.CustomMimeMapping {
-	for idx := 0; idx < 30; idx++ {
-		ext, extOK := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__CACHE__MIME_TYPES__CUSTOM_MAPPINGS__%d__EXT", idx))
-		mime, mimeOK := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__CACHE__MIME_TYPES__CUSTOM_MAPPINGS__%d__MIME", idx))
-		if extOK && mimeOK {
-			if len(globalConf.WebDAVD.Cache.MimeTypes.CustomMappings) > idx {
-				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings[idx].Ext = ext
-				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings[idx].Mime = mime
-			} else {
-				globalConf.WebDAVD.Cache.MimeTypes.CustomMappings = append(globalConf.WebDAVD.Cache.MimeTypes.CustomMappings,
-					webdavd.CustomMimeMapping{
-						Ext:  ext,
-						Mime: mime,
-					})
-			}
+	if isSet {
+		if len(globalConf.GRPCD.Bindings) > idx {
+			globalConf.GRPCD.Bindings[idx] = binding
+		} else {
+			globalConf.GRPCD.Bindings = append(globalConf.GRPCD.Bindings, binding)
 		}
 	}
-
-	return globalConf.WebDAVD.Cache.MimeTypes.CustomMappings
 }
 
-func getWebDAVDBindingFromEnv(idx int) {
-	binding := defaultWebDAVDBinding
-	if len(globalConf.WebDAVD.Bindings) > idx {
-		binding = globalConf.WebDAVD.Bindings[idx]
+func getSMTPDBindingFromEnv(idx int) {
+	binding := defaultSMTPDBinding
+	if len(globalConf.SMTPD.Bindings) > idx {
+		binding = globalConf.SMTPD.Bindings[idx]
 	}
 
 	isSet := false
 
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PORT", idx), 0)
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__PORT", idx), 0)
 	if ok {
 		binding.Port = int(port)
 		isSet = true
 	}
 
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ADDRESS", idx))
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__ADDRESS", idx))
 	if ok {
 		binding.Address = address
 		isSet = true
 	}
 
-	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ENABLE_HTTPS", idx))
+	tlsMode, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__TLS_MODE", idx), 0)
 	if ok {
-		binding.EnableHTTPS = enableHTTPS
+		binding.TLSMode = int(tlsMode)
 		isSet = true
 	}
 
-	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__CERTIFICATE_FILE", idx))
 	if ok {
 		binding.CertificateFile = certificateFile
 		isSet = true
 	}
 
-	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
 	if ok {
 		binding.CertificateKeyFile = certificateKeyFile
 		isSet = true
 	}
 
-	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
 	if ok {
 		binding.MinTLSVersion = int(tlsVer)
 		isSet = true
 	}
 
-	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx), 0)
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SMTPD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
 	if ok {
-		binding.ClientAuthType = int(clientAuthType)
+		binding.TLSCipherSuites = tlsCiphers
 		isSet = true
 	}
 
-	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if isSet {
+		if len(globalConf.SMTPD.Bindings) > idx {
+			globalConf.SMTPD.Bindings[idx] = binding
+		} else {
+			globalConf.SMTPD.Bindings = append(globalConf.SMTPD.Bindings, binding)
+		}
+	}
+}
+
+func getSMTPDMappingFromEnv(idx int) {
+	mapping := defaultSMTPDMapping
+	if len(globalConf.SMTPD.Mappings) > idx {
+		mapping = globalConf.SMTPD.Mappings[idx]
+	}
+
+	isSet := false
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__MAPPINGS__%v__ADDRESS", idx))
 	if ok {
-		binding.TLSCipherSuites = tlsCiphers
+		mapping.Address = address
 		isSet = true
 	}
 
-	prefix, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PREFIX", idx))
+	username, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__MAPPINGS__%v__USERNAME", idx))
 	if ok {
-		binding.Prefix = prefix
+		mapping.Username = username
 		isSet = true
 	}
 
-	if getWebDAVDBindingProxyConfigsFromEnv(idx, &binding) {
+	mappingPath, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SMTPD__MAPPINGS__%v__PATH", idx))
+	if ok {
+		mapping.Path = mappingPath
 		isSet = true
 	}
 
-	disableWWWAuth, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__DISABLE_WWW_AUTH_HEADER", idx))
+	allowedSenders, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SMTPD__MAPPINGS__%v__ALLOWED_SENDERS", idx))
 	if ok {
-		binding.DisableWWWAuthHeader = disableWWWAuth
+		mapping.AllowedSenders = allowedSenders
 		isSet = true
 	}
 
 	if isSet {
-		if len(globalConf.WebDAVD.Bindings) > idx {
-			globalConf.WebDAVD.Bindings[idx] = binding
+		if len(globalConf.SMTPD.Mappings) > idx {
+			globalConf.SMTPD.Mappings[idx] = mapping
 		} else {
-			globalConf.WebDAVD.Bindings = append(globalConf.WebDAVD.Bindings, binding)
+			globalConf.SMTPD.Mappings = append(globalConf.SMTPD.Mappings, mapping)
 		}
 	}
 }
@@ -1500,69 +2699,86 @@ func getHTTPDOIDCFromEnv(idx int) (httpd.OIDC, bool) {
 	if len(globalConf.HTTPDConfig.Bindings) > idx {
 		result = globalConf.HTTPDConfig.Bindings[idx].OIDC
 	}
+
+	return getHTTPDOIDCConfFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__", idx), result)
+}
+
+func getHTTPDOIDCConfFromEnv(prefix string, result httpd.OIDC) (httpd.OIDC, bool) {
 	isSet := false
 
-	clientID, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CLIENT_ID", idx))
+	clientID, ok := os.LookupEnv(prefix + "CLIENT_ID")
 	if ok {
 		result.ClientID = clientID
 		isSet = true
 	}
 
-	clientSecret, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CLIENT_SECRET", idx))
+	clientSecret, ok := os.LookupEnv(prefix + "CLIENT_SECRET")
 	if ok {
 		result.ClientSecret = clientSecret
 		isSet = true
 	}
 
-	configURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CONFIG_URL", idx))
+	configURL, ok := os.LookupEnv(prefix + "CONFIG_URL")
 	if ok {
 		result.ConfigURL = configURL
 		isSet = true
 	}
 
-	redirectBaseURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__REDIRECT_BASE_URL", idx))
+	redirectBaseURL, ok := os.LookupEnv(prefix + "REDIRECT_BASE_URL")
 	if ok {
 		result.RedirectBaseURL = redirectBaseURL
 		isSet = true
 	}
 
-	usernameField, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__USERNAME_FIELD", idx))
+	usernameField, ok := os.LookupEnv(prefix + "USERNAME_FIELD")
 	if ok {
 		result.UsernameField = usernameField
 		isSet = true
 	}
 
-	scopes, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__SCOPES", idx))
+	scopes, ok := lookupStringListFromEnv(prefix + "SCOPES")
 	if ok {
 		result.Scopes = scopes
 		isSet = true
 	}
 
-	roleField, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__ROLE_FIELD", idx))
+	roleField, ok := os.LookupEnv(prefix + "ROLE_FIELD")
 	if ok {
 		result.RoleField = roleField
 		isSet = true
 	}
 
-	implicitRoles, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__IMPLICIT_ROLES", idx))
+	implicitRoles, ok := lookupBoolFromEnv(prefix + "IMPLICIT_ROLES")
 	if ok {
 		result.ImplicitRoles = implicitRoles
 		isSet = true
 	}
 
-	customFields, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CUSTOM_FIELDS", idx))
+	groupsField, ok := os.LookupEnv(prefix + "GROUPS_FIELD")
+	if ok {
+		result.GroupsField = groupsField
+		isSet = true
+	}
+
+	requireGroupMapping, ok := lookupBoolFromEnv(prefix + "REQUIRE_GROUP_MAPPING")
+	if ok {
+		result.RequireGroupMapping = requireGroupMapping
+		isSet = true
+	}
+
+	customFields, ok := lookupStringListFromEnv(prefix + "CUSTOM_FIELDS")
 	if ok {
 		result.CustomFields = customFields
 		isSet = true
 	}
 
-	skipSignatureCheck, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", idx))
+	skipSignatureCheck, ok := lookupBoolFromEnv(prefix + "INSECURE_SKIP_SIGNATURE_CHECK")
 	if ok {
 		result.InsecureSkipSignatureCheck = skipSignatureCheck
 		isSet = true
 	}
 
-	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__DEBUG", idx))
+	debug, ok := lookupBoolFromEnv(prefix + "DEBUG")
 	if ok {
 		result.Debug = debug
 		isSet = true
@@ -1701,9 +2917,59 @@ func getDefaultHTTPBinding(idx int) httpd.Binding {
 	return binding
 }
 
+func getHTTPDVirtualHostsFromEnv(idx int) []httpd.VirtualHost {
+	var virtualHosts []httpd.VirtualHost
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		virtualHosts = globalConf.HTTPDConfig.Bindings[idx].VirtualHosts
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var vh httpd.VirtualHost
+		var replace bool
+		if len(virtualHosts) > subIdx {
+			vh = virtualHosts[subIdx]
+			replace = true
+		}
+		prefix := fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__VIRTUAL_HOSTS__%v__", idx, subIdx)
+		isSet := getVirtualHostFromEnv(prefix, &vh.Host, &vh.CertificateFile, &vh.CertificateKeyFile, &vh.AllowedRoles)
+
+		webAdmin, ok := getHTTPDUIBrandingFromEnv(prefix+"BRANDING__WEB_ADMIN", vh.Branding.WebAdmin)
+		if ok {
+			vh.Branding.WebAdmin = webAdmin
+			isSet = true
+		}
+		webClient, ok := getHTTPDUIBrandingFromEnv(prefix+"BRANDING__WEB_CLIENT", vh.Branding.WebClient)
+		if ok {
+			vh.Branding.WebClient = webClient
+			isSet = true
+		}
+		oidc, ok := getHTTPDOIDCConfFromEnv(prefix+"OIDC__", vh.OIDC)
+		if ok {
+			vh.OIDC = oidc
+			isSet = true
+		}
+
+		if isSet && vh.Host != "" {
+			if replace {
+				virtualHosts[subIdx] = vh
+			} else {
+				virtualHosts = append(virtualHosts, vh)
+			}
+		}
+	}
+
+	return virtualHosts
+}
+
 func getHTTPDNestedObjectsFromEnv(idx int, binding *httpd.Binding) bool {
 	isSet := false
 
+	virtualHosts := getHTTPDVirtualHostsFromEnv(idx)
+	if len(virtualHosts) > 0 {
+		binding.VirtualHosts = virtualHosts
+		isSet = true
+	}
+
 	webClientIntegrations := getHTTPDWebClientIntegrationsFromEnv(idx)
 	if len(webClientIntegrations) > 0 {
 		binding.WebClientIntegrations = webClientIntegrations
@@ -1819,6 +3085,12 @@ func getHTTPDBindingFromEnv(idx int) { //nolint:gocyclo
 		isSet = true
 	}
 
+	enableHTTP3, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ENABLE_HTTP3", idx))
+	if ok {
+		binding.EnableHTTP3 = enableHTTP3
+		isSet = true
+	}
+
 	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
 	if ok {
 		binding.MinTLSVersion = int(tlsVer)
@@ -1847,6 +3119,22 @@ func getHTTPDBindingFromEnv(idx int) { //nolint:gocyclo
 		isSet = true
 	}
 
+	ldapDirectory, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__LDAP_DIRECTORY", idx))
+	if ok {
+		binding.LDAPDirectory = ldapDirectory
+		isSet = true
+	}
+
+	kerberosService, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__KERBEROS_SERVICE", idx))
+	if ok {
+		binding.KerberosService = kerberosService
+		isSet = true
+	}
+
+	if getGeoIPFilterFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__GEOIP__", idx), &binding.GeoIP) {
+		isSet = true
+	}
+
 	if getHTTPDNestedObjectsFromEnv(idx, &binding) {
 		isSet = true
 	}
@@ -1957,6 +3245,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
 	viper.SetDefault("common.rename_mode", globalConf.Common.RenameMode)
+	viper.SetDefault("common.cross_resource_rename", globalConf.Common.CrossResourceRename)
 	viper.SetDefault("common.temp_path", globalConf.Common.TempPath)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
@@ -1975,10 +3264,57 @@ func setViperDefaults() {
 	viper.SetDefault("common.defender.score_invalid", globalConf.Common.DefenderConfig.ScoreInvalid)
 	viper.SetDefault("common.defender.score_valid", globalConf.Common.DefenderConfig.ScoreValid)
 	viper.SetDefault("common.defender.score_limit_exceeded", globalConf.Common.DefenderConfig.ScoreLimitExceeded)
+	viper.SetDefault("common.defender.score_anonymous", globalConf.Common.DefenderConfig.ScoreAnonymous)
 	viper.SetDefault("common.defender.score_no_auth", globalConf.Common.DefenderConfig.ScoreNoAuth)
 	viper.SetDefault("common.defender.observation_time", globalConf.Common.DefenderConfig.ObservationTime)
 	viper.SetDefault("common.defender.entries_soft_limit", globalConf.Common.DefenderConfig.EntriesSoftLimit)
 	viper.SetDefault("common.defender.entries_hard_limit", globalConf.Common.DefenderConfig.EntriesHardLimit)
+	viper.SetDefault("common.defender.crowdsec.url", globalConf.Common.DefenderConfig.CrowdSec.URL)
+	viper.SetDefault("common.defender.crowdsec.api_key", globalConf.Common.DefenderConfig.CrowdSec.APIKey)
+	viper.SetDefault("common.defender.crowdsec.machine_id", globalConf.Common.DefenderConfig.CrowdSec.MachineID)
+	viper.SetDefault("common.defender.crowdsec.password", globalConf.Common.DefenderConfig.CrowdSec.Password)
+	viper.SetDefault("common.defender.crowdsec.scenario", globalConf.Common.DefenderConfig.CrowdSec.Scenario)
+	viper.SetDefault("common.defender.crowdsec.update_interval", globalConf.Common.DefenderConfig.CrowdSec.UpdateInterval)
+	viper.SetDefault("common.defender.redis.address", globalConf.Common.DefenderConfig.Redis.Address)
+	viper.SetDefault("common.defender.redis.username", globalConf.Common.DefenderConfig.Redis.Username)
+	viper.SetDefault("common.defender.redis.password", globalConf.Common.DefenderConfig.Redis.Password)
+	viper.SetDefault("common.defender.redis.db", globalConf.Common.DefenderConfig.Redis.DB)
+	viper.SetDefault("common.defender.redis.key_prefix", globalConf.Common.DefenderConfig.Redis.KeyPrefix)
+	viper.SetDefault("common.defender.redis.tls_enabled", globalConf.Common.DefenderConfig.Redis.TLSEnabled)
+	viper.SetDefault("common.defender.geoip_score.countries", globalConf.Common.DefenderConfig.GeoIPScore.Countries)
+	viper.SetDefault("common.defender.geoip_score.asns", globalConf.Common.DefenderConfig.GeoIPScore.ASNs)
+	viper.SetDefault("common.defender.geoip_score.score", globalConf.Common.DefenderConfig.GeoIPScore.Score)
+	viper.SetDefault("common.sftpfs_pool.max_connections", globalConf.Common.SFTPFsPool.MaxConnections)
+	viper.SetDefault("common.sftpfs_pool.max_sessions_per_connection", globalConf.Common.SFTPFsPool.MaxSessionsPerConnection)
+	viper.SetDefault("common.sftpfs_pool.idle_timeout", globalConf.Common.SFTPFsPool.IdleTimeout)
+	viper.SetDefault("common.read_cache.path", globalConf.Common.ReadCache.Path)
+	viper.SetDefault("common.read_cache.max_size", globalConf.Common.ReadCache.MaxSize)
+	viper.SetDefault("common.read_cache.max_file_size", globalConf.Common.ReadCache.MaxFileSize)
+	viper.SetDefault("common.listing_cache.ttl", globalConf.Common.ListingCache.TTL)
+	viper.SetDefault("common.listing_cache.max_entries", globalConf.Common.ListingCache.MaxEntries)
+	viper.SetDefault("common.upload_staging.enabled", globalConf.Common.UploadStaging.Enabled)
+	viper.SetDefault("common.upload_staging.memory_threshold", globalConf.Common.UploadStaging.MemoryThreshold)
+	viper.SetDefault("common.upload_checksums", globalConf.Common.UploadChecksums)
+	viper.SetDefault("common.skip_identical_uploads", globalConf.Common.SkipIdenticalUploads)
+	viper.SetDefault("common.archive_downloads.zip_suffix", globalConf.Common.ArchiveDownloads.ZipSuffix)
+	viper.SetDefault("common.archive_downloads.tar_suffix", globalConf.Common.ArchiveDownloads.TarSuffix)
+	viper.SetDefault("common.quota_reconciliation.interval", globalConf.Common.QuotaReconciliation.Interval)
+	viper.SetDefault("common.quota_reconciliation.sample_size", globalConf.Common.QuotaReconciliation.SampleSize)
+	viper.SetDefault("common.antivirus.engine", globalConf.Common.Antivirus.Engine)
+	viper.SetDefault("common.antivirus.address", globalConf.Common.Antivirus.Address)
+	viper.SetDefault("common.antivirus.mode", globalConf.Common.Antivirus.Mode)
+	viper.SetDefault("common.antivirus.quarantine_path", globalConf.Common.Antivirus.QuarantinePath)
+	viper.SetDefault("common.antivirus.max_size", globalConf.Common.Antivirus.MaxSize)
+	viper.SetDefault("common.antivirus.timeout", globalConf.Common.Antivirus.Timeout)
+	viper.SetDefault("common.antivirus.reject_on_error", globalConf.Common.Antivirus.RejectOnError)
+	viper.SetDefault("common.dlp.max_size", globalConf.Common.DLP.MaxSize)
+	viper.SetDefault("common.dlp.reject_on_error", globalConf.Common.DLP.RejectOnError)
+	viper.SetDefault("common.dead_letters.enabled", globalConf.Common.DeadLetters.Enabled)
+	viper.SetDefault("common.dead_letters.max_retries", globalConf.Common.DeadLetters.MaxRetries)
+	viper.SetDefault("common.dead_letters.retry_delay", globalConf.Common.DeadLetters.RetryDelay)
+	viper.SetDefault("common.ip_approval.webclient_url", globalConf.Common.IPApproval.WebClientURL)
+	viper.SetDefault("common.ip_approval.expiration", globalConf.Common.IPApproval.Expiration)
+	viper.SetDefault("common.credentials_rotation.check_interval", globalConf.Common.CredentialsRotation.CheckInterval)
 	viper.SetDefault("acme.email", globalConf.ACME.Email)
 	viper.SetDefault("acme.key_type", globalConf.ACME.KeyType)
 	viper.SetDefault("acme.certs_path", globalConf.ACME.CertsPath)
@@ -2000,12 +3336,15 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.macs", globalConf.SFTPD.MACs)
 	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
 	viper.SetDefault("sftpd.revoked_user_certs_file", globalConf.SFTPD.RevokedUserCertsFile)
+	viper.SetDefault("sftpd.user_ca_key", globalConf.SFTPD.UserCAKey)
+	viper.SetDefault("sftpd.user_cert_validity", globalConf.SFTPD.UserCertValidity)
 	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
 	viper.SetDefault("sftpd.enabled_ssh_commands", sftpd.GetDefaultSSHCommands())
 	viper.SetDefault("sftpd.keyboard_interactive_authentication", globalConf.SFTPD.KeyboardInteractiveAuthentication)
 	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
 	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
 	viper.SetDefault("sftpd.folder_prefix", globalConf.SFTPD.FolderPrefix)
+	viper.SetDefault("sftpd.allow_tcp_forwarding", globalConf.SFTPD.AllowTCPForwarding)
 	viper.SetDefault("ftpd.banner", globalConf.FTPD.Banner)
 	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
 	viper.SetDefault("ftpd.active_transfers_port_non_20", globalConf.FTPD.ActiveTransfersPortNon20)
@@ -2019,10 +3358,18 @@ func setViperDefaults() {
 	viper.SetDefault("ftpd.certificate_key_file", globalConf.FTPD.CertificateKeyFile)
 	viper.SetDefault("ftpd.ca_certificates", globalConf.FTPD.CACertificates)
 	viper.SetDefault("ftpd.ca_revocation_lists", globalConf.FTPD.CARevocationLists)
+	viper.SetDefault("ftpd.revocation_check.ocsp_enabled", globalConf.FTPD.RevocationCheck.OCSPEnabled)
+	viper.SetDefault("ftpd.revocation_check.ocsp_responder", globalConf.FTPD.RevocationCheck.OCSPResponder)
+	viper.SetDefault("ftpd.revocation_check.ocsp_timeout", globalConf.FTPD.RevocationCheck.OCSPTimeout)
+	viper.SetDefault("ftpd.revocation_check.hard_fail", globalConf.FTPD.RevocationCheck.HardFail)
 	viper.SetDefault("webdavd.certificate_file", globalConf.WebDAVD.CertificateFile)
 	viper.SetDefault("webdavd.certificate_key_file", globalConf.WebDAVD.CertificateKeyFile)
 	viper.SetDefault("webdavd.ca_certificates", globalConf.WebDAVD.CACertificates)
 	viper.SetDefault("webdavd.ca_revocation_lists", globalConf.WebDAVD.CARevocationLists)
+	viper.SetDefault("webdavd.revocation_check.ocsp_enabled", globalConf.WebDAVD.RevocationCheck.OCSPEnabled)
+	viper.SetDefault("webdavd.revocation_check.ocsp_responder", globalConf.WebDAVD.RevocationCheck.OCSPResponder)
+	viper.SetDefault("webdavd.revocation_check.ocsp_timeout", globalConf.WebDAVD.RevocationCheck.OCSPTimeout)
+	viper.SetDefault("webdavd.revocation_check.hard_fail", globalConf.WebDAVD.RevocationCheck.HardFail)
 	viper.SetDefault("webdavd.cors.enabled", globalConf.WebDAVD.Cors.Enabled)
 	viper.SetDefault("webdavd.cors.allowed_origins", globalConf.WebDAVD.Cors.AllowedOrigins)
 	viper.SetDefault("webdavd.cors.allowed_methods", globalConf.WebDAVD.Cors.AllowedMethods)
@@ -2038,6 +3385,24 @@ func setViperDefaults() {
 	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
 	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
 	viper.SetDefault("webdavd.cache.mime_types.custom_mappings", globalConf.WebDAVD.Cache.MimeTypes.CustomMappings)
+	viper.SetDefault("s3d.certificate_file", globalConf.S3D.CertificateFile)
+	viper.SetDefault("s3d.certificate_key_file", globalConf.S3D.CertificateKeyFile)
+	viper.SetDefault("s3d.region", globalConf.S3D.Region)
+	viper.SetDefault("s3d.multipart_uploads_path", globalConf.S3D.MultipartUploadsPath)
+	viper.SetDefault("s3d.multipart_uploads_expiration", globalConf.S3D.MultipartUploadsExpiration)
+	viper.SetDefault("tftpd.timeout", globalConf.TFTPD.Timeout)
+	viper.SetDefault("tftpd.retries", globalConf.TFTPD.Retries)
+	viper.SetDefault("grpcd.certificate_file", globalConf.GRPCD.CertificateFile)
+	viper.SetDefault("grpcd.certificate_key_file", globalConf.GRPCD.CertificateKeyFile)
+	viper.SetDefault("grpcd.ca_certificates", globalConf.GRPCD.CACertificates)
+	viper.SetDefault("grpcd.ca_revocation_lists", globalConf.GRPCD.CARevocationLists)
+	viper.SetDefault("grpcd.signing_passphrase", globalConf.GRPCD.SigningPassphrase)
+	viper.SetDefault("grpcd.token_validation", globalConf.GRPCD.TokenValidation)
+	viper.SetDefault("smtpd.hostname", globalConf.SMTPD.Hostname)
+	viper.SetDefault("smtpd.max_message_size", globalConf.SMTPD.MaxMessageSize)
+	viper.SetDefault("smtpd.max_attachments", globalConf.SMTPD.MaxAttachments)
+	viper.SetDefault("smtpd.certificate_file", globalConf.SMTPD.CertificateFile)
+	viper.SetDefault("smtpd.certificate_key_file", globalConf.SMTPD.CertificateKeyFile)
 	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
 	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
 	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
@@ -2051,6 +3416,8 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.client_cert", globalConf.ProviderConf.ClientCert)
 	viper.SetDefault("data_provider.client_key", globalConf.ProviderConf.ClientKey)
 	viper.SetDefault("data_provider.connection_string", globalConf.ProviderConf.ConnectionString)
+	viper.SetDefault("data_provider.read_replicas", globalConf.ProviderConf.ReadReplicas)
+	viper.SetDefault("data_provider.max_replica_lag", globalConf.ProviderConf.MaxReplicaLag)
 	viper.SetDefault("data_provider.sql_tables_prefix", globalConf.ProviderConf.SQLTablesPrefix)
 	viper.SetDefault("data_provider.track_quota", globalConf.ProviderConf.TrackQuota)
 	viper.SetDefault("data_provider.pool_size", globalConf.ProviderConf.PoolSize)
@@ -2082,6 +3449,18 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.node.port", globalConf.ProviderConf.Node.Port)
 	viper.SetDefault("data_provider.node.proto", globalConf.ProviderConf.Node.Proto)
 	viper.SetDefault("data_provider.backups_path", globalConf.ProviderConf.BackupsPath)
+	viper.SetDefault("data_provider.audit_log.enabled", globalConf.ProviderConf.AuditLog.Enabled)
+	viper.SetDefault("data_provider.audit_log.retention_days", globalConf.ProviderConf.AuditLog.RetentionDays)
+	viper.SetDefault("data_provider.audit_log.user_logins", globalConf.ProviderConf.AuditLog.UserLogins)
+	viper.SetDefault("data_provider.audit_log.syslog.enabled", globalConf.ProviderConf.AuditLog.Syslog.Enabled)
+	viper.SetDefault("data_provider.audit_log.syslog.network", globalConf.ProviderConf.AuditLog.Syslog.Network)
+	viper.SetDefault("data_provider.audit_log.syslog.address", globalConf.ProviderConf.AuditLog.Syslog.Address)
+	viper.SetDefault("data_provider.audit_log.webhook_url", globalConf.ProviderConf.AuditLog.WebhookURL)
+	viper.SetDefault("data_provider.change_events.enabled", globalConf.ProviderConf.ChangeEvents.Enabled)
+	viper.SetDefault("data_provider.change_events.retention_days", globalConf.ProviderConf.ChangeEvents.RetentionDays)
+	viper.SetDefault("data_provider.change_events.webhook_url", globalConf.ProviderConf.ChangeEvents.WebhookURL)
+	viper.SetDefault("data_provider.soft_delete.retention_days", globalConf.ProviderConf.SoftDelete.RetentionDays)
+	viper.SetDefault("data_provider.soft_delete.purge_home_dirs", globalConf.ProviderConf.SoftDelete.PurgeHomeDirs)
 	viper.SetDefault("httpd.templates_path", globalConf.HTTPDConfig.TemplatesPath)
 	viper.SetDefault("httpd.static_files_path", globalConf.HTTPDConfig.StaticFilesPath)
 	viper.SetDefault("httpd.openapi_path", globalConf.HTTPDConfig.OpenAPIPath)
@@ -2090,6 +3469,10 @@ func setViperDefaults() {
 	viper.SetDefault("httpd.certificate_key_file", globalConf.HTTPDConfig.CertificateKeyFile)
 	viper.SetDefault("httpd.ca_certificates", globalConf.HTTPDConfig.CACertificates)
 	viper.SetDefault("httpd.ca_revocation_lists", globalConf.HTTPDConfig.CARevocationLists)
+	viper.SetDefault("httpd.revocation_check.ocsp_enabled", globalConf.HTTPDConfig.RevocationCheck.OCSPEnabled)
+	viper.SetDefault("httpd.revocation_check.ocsp_responder", globalConf.HTTPDConfig.RevocationCheck.OCSPResponder)
+	viper.SetDefault("httpd.revocation_check.ocsp_timeout", globalConf.HTTPDConfig.RevocationCheck.OCSPTimeout)
+	viper.SetDefault("httpd.revocation_check.hard_fail", globalConf.HTTPDConfig.RevocationCheck.HardFail)
 	viper.SetDefault("httpd.signing_passphrase", globalConf.HTTPDConfig.SigningPassphrase)
 	viper.SetDefault("httpd.token_validation", globalConf.HTTPDConfig.TokenValidation)
 	viper.SetDefault("httpd.max_upload_file_size", globalConf.HTTPDConfig.MaxUploadFileSize)
@@ -2105,7 +3488,21 @@ func setViperDefaults() {
 	viper.SetDefault("httpd.cors.allow_private_network", globalConf.HTTPDConfig.Cors.AllowPrivateNetwork)
 	viper.SetDefault("httpd.setup.installation_code", globalConf.HTTPDConfig.Setup.InstallationCode)
 	viper.SetDefault("httpd.setup.installation_code_hint", globalConf.HTTPDConfig.Setup.InstallationCodeHint)
+	viper.SetDefault("httpd.tus.enabled", globalConf.HTTPDConfig.Tus.Enabled)
+	viper.SetDefault("httpd.tus.uploads_path", globalConf.HTTPDConfig.Tus.UploadsPath)
+	viper.SetDefault("httpd.tus.expiration", globalConf.HTTPDConfig.Tus.Expiration)
 	viper.SetDefault("httpd.hide_support_link", globalConf.HTTPDConfig.HideSupportLink)
+	viper.SetDefault("httpd.remembered_devices.webadmin_days", globalConf.HTTPDConfig.RememberedDevices.WebAdminDays)
+	viper.SetDefault("httpd.remembered_devices.webclient_days", globalConf.HTTPDConfig.RememberedDevices.WebClientDays)
+	viper.SetDefault("httpd.remembered_devices.bind_to_ip", globalConf.HTTPDConfig.RememberedDevices.BindToIP)
+	viper.SetDefault("httpd.remembered_devices.bind_to_user_agent", globalConf.HTTPDConfig.RememberedDevices.BindToUserAgent)
+	viper.SetDefault("httpd.login_protection.captcha_provider", globalConf.HTTPDConfig.LoginProtection.CaptchaProvider)
+	viper.SetDefault("httpd.login_protection.captcha_site_key", globalConf.HTTPDConfig.LoginProtection.CaptchaSiteKey)
+	viper.SetDefault("httpd.login_protection.captcha_secret_key", globalConf.HTTPDConfig.LoginProtection.CaptchaSecretKey)
+	viper.SetDefault("httpd.login_protection.captcha_after_failures", globalConf.HTTPDConfig.LoginProtection.CaptchaAfterFailures)
+	viper.SetDefault("httpd.login_protection.base_delay", globalConf.HTTPDConfig.LoginProtection.BaseDelay)
+	viper.SetDefault("httpd.login_protection.max_delay", globalConf.HTTPDConfig.LoginProtection.MaxDelay)
+	viper.SetDefault("httpd.login_protection.observation_time", globalConf.HTTPDConfig.LoginProtection.ObservationTime)
 	viper.SetDefault("http.timeout", globalConf.HTTPConfig.Timeout)
 	viper.SetDefault("http.retry_wait_min", globalConf.HTTPConfig.RetryWaitMin)
 	viper.SetDefault("http.retry_wait_max", globalConf.HTTPConfig.RetryWaitMax)
@@ -2117,6 +3514,22 @@ func setViperDefaults() {
 	viper.SetDefault("kms.secrets.url", globalConf.KMSConfig.Secrets.URL)
 	viper.SetDefault("kms.secrets.master_key", globalConf.KMSConfig.Secrets.MasterKeyString)
 	viper.SetDefault("kms.secrets.master_key_path", globalConf.KMSConfig.Secrets.MasterKeyPath)
+	viper.SetDefault("kms.secrets.pkcs11.module_path", globalConf.KMSConfig.Secrets.PKCS11.ModulePath)
+	viper.SetDefault("kms.secrets.pkcs11.token_label", globalConf.KMSConfig.Secrets.PKCS11.TokenLabel)
+	viper.SetDefault("kms.secrets.pkcs11.pin", globalConf.KMSConfig.Secrets.PKCS11.Pin)
+	viper.SetDefault("kms.secrets.pkcs11.key_label", globalConf.KMSConfig.Secrets.PKCS11.KeyLabel)
+	viper.SetDefault("kms.secrets.vault.address", globalConf.KMSConfig.Secrets.Vault.Address)
+	viper.SetDefault("kms.secrets.vault.namespace", globalConf.KMSConfig.Secrets.Vault.Namespace)
+	viper.SetDefault("kms.secrets.vault.mount_path", globalConf.KMSConfig.Secrets.Vault.MountPath)
+	viper.SetDefault("kms.secrets.vault.token", globalConf.KMSConfig.Secrets.Vault.Token)
+	viper.SetDefault("kms.secrets.vault.approle.role_id", globalConf.KMSConfig.Secrets.Vault.AppRole.RoleID)
+	viper.SetDefault("kms.secrets.vault.approle.secret_id", globalConf.KMSConfig.Secrets.Vault.AppRole.SecretID)
+	viper.SetDefault("kms.secrets.vault.approle.mount_path", globalConf.KMSConfig.Secrets.Vault.AppRole.MountPath)
+	viper.SetDefault("mfa.webauthn.rp_id", globalConf.MFAConfig.WebAuthn.RPID)
+	viper.SetDefault("mfa.webauthn.rp_display_name", globalConf.MFAConfig.WebAuthn.RPDisplayName)
+	viper.SetDefault("mfa.webauthn.rp_origins", globalConf.MFAConfig.WebAuthn.RPOrigins)
+	viper.SetDefault("mfa.webauthn.timeout", globalConf.MFAConfig.WebAuthn.Timeout)
+	viper.SetDefault("mfa.webauthn.required_roles", globalConf.MFAConfig.WebAuthn.RequiredRoles)
 	viper.SetDefault("telemetry.bind_port", globalConf.TelemetryConfig.BindPort)
 	viper.SetDefault("telemetry.bind_address", globalConf.TelemetryConfig.BindAddress)
 	viper.SetDefault("telemetry.enable_profiler", globalConf.TelemetryConfig.EnableProfiler)
@@ -2134,6 +3547,70 @@ func setViperDefaults() {
 	viper.SetDefault("smtp.encryption", globalConf.SMTPConfig.Encryption)
 	viper.SetDefault("smtp.domain", globalConf.SMTPConfig.Domain)
 	viper.SetDefault("smtp.templates_path", globalConf.SMTPConfig.TemplatesPath)
+	viper.SetDefault("geoip.country_db_path", globalConf.GeoIPConfig.CountryDBPath)
+	viper.SetDefault("geoip.asn_db_path", globalConf.GeoIPConfig.ASNDBPath)
+}
+
+// getVirtualHostFromEnv reads the virtual host settings shared by the
+// FTP, WebDAV and HTTP bindings
+func getVirtualHostFromEnv(prefix string, host, certificateFile, certificateKeyFile *string, allowedRoles *[]string) bool {
+	isSet := false
+
+	val, ok := os.LookupEnv(prefix + "HOST")
+	if ok {
+		*host = val
+		isSet = true
+	}
+
+	val, ok = os.LookupEnv(prefix + "CERTIFICATE_FILE")
+	if ok {
+		*certificateFile = val
+		isSet = true
+	}
+
+	val, ok = os.LookupEnv(prefix + "CERTIFICATE_KEY_FILE")
+	if ok {
+		*certificateKeyFile = val
+		isSet = true
+	}
+
+	roles, ok := lookupStringListFromEnv(prefix + "ALLOWED_ROLES")
+	if ok {
+		*allowedRoles = roles
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getGeoIPFilterFromEnv(prefix string, filter *geoip.Filter) bool {
+	isSet := false
+
+	allowedCountries, ok := lookupStringListFromEnv(prefix + "ALLOWED_COUNTRIES")
+	if ok {
+		filter.AllowedCountries = allowedCountries
+		isSet = true
+	}
+
+	deniedCountries, ok := lookupStringListFromEnv(prefix + "DENIED_COUNTRIES")
+	if ok {
+		filter.DeniedCountries = deniedCountries
+		isSet = true
+	}
+
+	allowedASNs, ok := lookupUintListFromEnv(prefix + "ALLOWED_ASNS")
+	if ok {
+		filter.AllowedASNs = allowedASNs
+		isSet = true
+	}
+
+	deniedASNs, ok := lookupUintListFromEnv(prefix + "DENIED_ASNS")
+	if ok {
+		filter.DeniedASNs = deniedASNs
+		isSet = true
+	}
+
+	return isSet
 }
 
 func lookupBoolFromEnv(envName string) (bool, bool) {
@@ -2160,6 +3637,21 @@ func lookupIntFromEnv(envName string, bitSize int) (int64, bool) {
 	return 0, false
 }
 
+func lookupUintListFromEnv(envName string) ([]uint, bool) {
+	values, ok := lookupStringListFromEnv(envName)
+	if !ok {
+		return nil, false
+	}
+	var result []uint
+	for _, v := range values {
+		converted, err := strconv.ParseUint(v, 10, 32)
+		if err == nil {
+			result = append(result, uint(converted))
+		}
+	}
+	return result, true
+}
+
 func lookupStringListFromEnv(envName string) ([]string, bool) {
 	value, ok := os.LookupEnv(envName)
 	if ok {
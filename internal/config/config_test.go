@@ -89,7 +89,7 @@ func TestLoadConfigFileNotFound(t *testing.T) {
 	mfaConf := config.GetMFAConfig()
 	require.Len(t, mfaConf.TOTP, 1)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
-	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 4)
+	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 8)
 	require.Len(t, config.GetHTTPDConfig().Bindings, 1)
 	require.Len(t, config.GetHTTPDConfig().Bindings[0].OIDC.Scopes, 3)
 }
@@ -355,6 +355,24 @@ func TestSetGetConfig(t *testing.T) {
 	config.SetWebDAVDConfig(webDavConf)
 	assert.Equal(t, webDavConf.CertificateFile, config.GetWebDAVDConfig().CertificateFile)
 	assert.Equal(t, webDavConf.CertificateKeyFile, config.GetWebDAVDConfig().CertificateKeyFile)
+	s3dConf := config.GetS3DConfig()
+	s3dConf.Region = "eu-west-1"
+	s3dConf.MultipartUploadsExpiration = 48
+	config.SetS3DConfig(s3dConf)
+	assert.Equal(t, s3dConf.Region, config.GetS3DConfig().Region)
+	assert.Equal(t, s3dConf.MultipartUploadsExpiration, config.GetS3DConfig().MultipartUploadsExpiration)
+	tftpdConf := config.GetTFTPDConfig()
+	tftpdConf.Timeout = 10
+	config.SetTFTPDConfig(tftpdConf)
+	assert.Equal(t, tftpdConf.Timeout, config.GetTFTPDConfig().Timeout)
+	grpcdConf := config.GetGRPCDConfig()
+	grpcdConf.TokenValidation = 1
+	config.SetGRPCDConfig(grpcdConf)
+	assert.Equal(t, grpcdConf.TokenValidation, config.GetGRPCDConfig().TokenValidation)
+	smtpdConf := config.GetSMTPDConfig()
+	smtpdConf.MaxAttachments = 5
+	config.SetSMTPDConfig(smtpdConf)
+	assert.Equal(t, smtpdConf.MaxAttachments, config.GetSMTPDConfig().MaxAttachments)
 	kmsConf := config.GetKMSConfig()
 	kmsConf.Secrets.MasterKeyPath = "apath"
 	kmsConf.Secrets.URL = "aurl"
@@ -405,6 +423,38 @@ func TestServiceToStart(t *testing.T) {
 	webdavdConf.Bindings[0].Port = 0
 	config.SetWebDAVDConfig(webdavdConf)
 	assert.False(t, config.HasServicesToStart())
+	s3dConf := config.GetS3DConfig()
+	s3dConf.Bindings[0].Port = 9000
+	config.SetS3DConfig(s3dConf)
+	assert.True(t, config.HasServicesToStart())
+	s3dConf.Bindings[0].Port = 0
+	config.SetS3DConfig(s3dConf)
+	assert.False(t, config.HasServicesToStart())
+	tftpdConf := config.GetTFTPDConfig()
+	tftpdConf.Bindings[0].Port = 6969
+	config.SetTFTPDConfig(tftpdConf)
+	// a user is required for TFTP bindings
+	assert.False(t, config.HasServicesToStart())
+	tftpdConf.Bindings[0].Username = "tftp"
+	config.SetTFTPDConfig(tftpdConf)
+	assert.True(t, config.HasServicesToStart())
+	tftpdConf.Bindings[0].Port = 0
+	config.SetTFTPDConfig(tftpdConf)
+	assert.False(t, config.HasServicesToStart())
+	grpcdConf := config.GetGRPCDConfig()
+	grpcdConf.Bindings[0].Port = 9095
+	config.SetGRPCDConfig(grpcdConf)
+	assert.True(t, config.HasServicesToStart())
+	grpcdConf.Bindings[0].Port = 0
+	config.SetGRPCDConfig(grpcdConf)
+	assert.False(t, config.HasServicesToStart())
+	smtpdConf := config.GetSMTPDConfig()
+	smtpdConf.Bindings[0].Port = 2525
+	config.SetSMTPDConfig(smtpdConf)
+	assert.True(t, config.HasServicesToStart())
+	smtpdConf.Bindings[0].Port = 0
+	config.SetSMTPDConfig(smtpdConf)
+	assert.False(t, config.HasServicesToStart())
 	sftpdConf.Bindings[0].Port = 2022
 	config.SetSFTPDConfig(sftpdConf)
 	assert.True(t, config.HasServicesToStart())
@@ -414,14 +464,23 @@ func TestSSHCommandsFromEnv(t *testing.T) {
 	reset()
 
 	os.Setenv("SFTPGO_SFTPD__ENABLED_SSH_COMMANDS", "cd,scp")
+	os.Setenv("SFTPGO_SFTPD__ALLOW_TCP_FORWARDING", "true")
+	os.Setenv("SFTPGO_SFTPD__USER_CA_KEY", "user_ca")
+	os.Setenv("SFTPGO_SFTPD__USER_CERT_VALIDITY", "30")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__ENABLED_SSH_COMMANDS")
+		os.Unsetenv("SFTPGO_SFTPD__ALLOW_TCP_FORWARDING")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CA_KEY")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CERT_VALIDITY")
 	})
 
 	err := config.LoadConfig(configDir, "")
 	assert.NoError(t, err)
 
 	sftpdConf := config.GetSFTPDConfig()
+	assert.True(t, sftpdConf.AllowTCPForwarding)
+	assert.Equal(t, "user_ca", sftpdConf.UserCAKey)
+	assert.Equal(t, 30, sftpdConf.UserCertValidity)
 	if assert.Len(t, sftpdConf.EnabledSSHCommands, 2) {
 		assert.Equal(t, "cd", sftpdConf.EnabledSSHCommands[0])
 		assert.Equal(t, "scp", sftpdConf.EnabledSSHCommands[1])
@@ -471,6 +530,30 @@ func TestMFAFromEnv(t *testing.T) {
 	require.Equal(t, "sha256", mfaConf.TOTP[1].Algo)
 }
 
+func TestWebAuthnFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__RP_ID", "sftpgo.example.com")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__RP_ORIGINS", "https://sftpgo.example.com:8443,https://sftpgo.example.com")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__TIMEOUT", "120")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__REQUIRED_ROLES", "role1")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__RP_ID")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__RP_ORIGINS")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__TIMEOUT")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__REQUIRED_ROLES")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	mfaConf := config.GetMFAConfig()
+	assert.Equal(t, "sftpgo.example.com", mfaConf.WebAuthn.RPID)
+	assert.Empty(t, mfaConf.WebAuthn.RPDisplayName)
+	assert.Equal(t, []string{"https://sftpgo.example.com:8443", "https://sftpgo.example.com"}, mfaConf.WebAuthn.RPOrigins)
+	assert.Equal(t, 120, mfaConf.WebAuthn.Timeout)
+	assert.Equal(t, []string{"role1"}, mfaConf.WebAuthn.RequiredRoles)
+}
+
 func TestDisabledMFAConfig(t *testing.T) {
 	reset()
 
@@ -533,7 +616,7 @@ func TestOverrideSliceValues(t *testing.T) {
 	assert.NoError(t, err)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
 	rl := config.GetCommonConfig().RateLimitersConfig[0]
-	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP"}, rl.Protocols)
+	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP", "S3", "TFTP", "GRPC", "SMTP"}, rl.Protocols)
 	require.Equal(t, int64(1000), rl.Period)
 
 	reset()
@@ -838,16 +921,232 @@ func TestRateLimitersFromEnv(t *testing.T) {
 	require.Equal(t, 1, limiters[1].Burst)
 	require.Equal(t, 2, limiters[1].Type)
 	protocols = limiters[1].Protocols
-	require.Len(t, protocols, 4)
+	require.Len(t, protocols, 8)
 	require.True(t, util.Contains(protocols, common.ProtocolFTP))
 	require.True(t, util.Contains(protocols, common.ProtocolSSH))
 	require.True(t, util.Contains(protocols, common.ProtocolWebDAV))
 	require.True(t, util.Contains(protocols, common.ProtocolHTTP))
+	require.True(t, util.Contains(protocols, common.ProtocolS3))
+	require.True(t, util.Contains(protocols, common.ProtocolTFTP))
+	require.True(t, util.Contains(protocols, common.ProtocolGRPC))
+	require.True(t, util.Contains(protocols, common.ProtocolSMTP))
 	require.False(t, limiters[1].GenerateDefenderEvents)
 	require.Equal(t, 100, limiters[1].EntriesSoftLimit)
 	require.Equal(t, 150, limiters[1].EntriesHardLimit)
 }
 
+func TestDLPPoliciesFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__0__NAME", "pci")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__0__DETECTORS", "credit_card, ssn")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__0__PATTERNS", "secret-[0-9]+")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__0__MIN_MATCHES", "3")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__0__ACTIONS", "block,notify")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__1__NAME", "classifier")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__ADDRESS", "127.0.0.1:9000")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__USE_TLS", "true")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__TIMEOUT", "10")
+	os.Setenv("SFTPGO_COMMON__DLP__POLICIES__1__ACTIONS", "tag")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__0__NAME")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__0__DETECTORS")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__0__PATTERNS")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__0__MIN_MATCHES")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__0__ACTIONS")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__1__NAME")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__ADDRESS")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__USE_TLS")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__1__CLASSIFIER__TIMEOUT")
+		os.Unsetenv("SFTPGO_COMMON__DLP__POLICIES__1__ACTIONS")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	policies := config.GetCommonConfig().DLP.Policies
+	require.Len(t, policies, 2)
+	require.Equal(t, "pci", policies[0].Name)
+	require.Equal(t, []string{"credit_card", "ssn"}, policies[0].Detectors)
+	require.Equal(t, []string{"secret-[0-9]+"}, policies[0].Patterns)
+	require.Equal(t, 3, policies[0].MinMatches)
+	require.Equal(t, []string{"block", "notify"}, policies[0].Actions)
+	require.Empty(t, policies[0].Classifier.Address)
+	require.Equal(t, "classifier", policies[1].Name)
+	require.Equal(t, "127.0.0.1:9000", policies[1].Classifier.Address)
+	require.True(t, policies[1].Classifier.UseTLS)
+	require.Equal(t, 10, policies[1].Classifier.Timeout)
+	require.Equal(t, []string{"tag"}, policies[1].Actions)
+}
+
+func TestLDAPDirectoriesFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__NAME", "ad")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__URL", "ldaps://dc.example.com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__SKIP_TLS_VERIFY", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__TIMEOUT", "5")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BIND_DN", "cn=sftpgo,dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BIND_PASSWORD", "secret")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BASE_DN", "dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__USER_FILTER", "(sAMAccountName={{Username}})")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__HOME_DIR", "/srv/sftpgo/{{Username}}")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__QUOTA_SIZE", "{{Attribute:quota}}")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__PERMISSIONS", "list,download")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__REQUIRE_GROUP_MAPPING", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__LDAP_GROUP", "sftp-users")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__GROUP", "users")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__TYPE", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__LDAP_GROUP", "cn=admins,dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__GROUP", "admins")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__TYPE", "2")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__NAME", "openldap")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__URL", "ldap://127.0.0.1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__START_TLS", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__USER_DN_TEMPLATE", "uid={{Username}},ou=people,dc=example,dc=org")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__GROUP_ATTRIBUTE", "memberOf")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__SKIP_TLS_VERIFY")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__TIMEOUT")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BIND_DN")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BIND_PASSWORD")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__BASE_DN")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__USER_FILTER")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__HOME_DIR")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__QUOTA_SIZE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__PERMISSIONS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__REQUIRE_GROUP_MAPPING")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__LDAP_GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__0__TYPE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__LDAP_GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__0__GROUP_MAPPINGS__2__TYPE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__START_TLS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__USER_DN_TEMPLATE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_DIRECTORIES__1__GROUP_ATTRIBUTE")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	directories := config.GetProviderConf().LDAPDirectories
+	require.Len(t, directories, 2)
+	require.Equal(t, "ad", directories[0].Name)
+	require.Equal(t, "ldaps://dc.example.com", directories[0].URL)
+	require.True(t, directories[0].SkipTLSVerify)
+	require.False(t, directories[0].StartTLS)
+	require.Equal(t, 5, directories[0].Timeout)
+	require.Equal(t, "cn=sftpgo,dc=example,dc=com", directories[0].BindDN)
+	require.Equal(t, "secret", directories[0].BindPassword)
+	require.Equal(t, "dc=example,dc=com", directories[0].BaseDN)
+	require.Equal(t, "(sAMAccountName={{Username}})", directories[0].UserFilter)
+	require.Equal(t, "/srv/sftpgo/{{Username}}", directories[0].HomeDir)
+	require.Equal(t, "{{Attribute:quota}}", directories[0].QuotaSize)
+	require.Equal(t, []string{"list", "download"}, directories[0].Permissions)
+	require.True(t, directories[0].RequireGroupMapping)
+	require.Len(t, directories[0].GroupMappings, 2)
+	require.Equal(t, "sftp-users", directories[0].GroupMappings[0].LDAPGroup)
+	require.Equal(t, "users", directories[0].GroupMappings[0].Group)
+	require.Equal(t, 1, directories[0].GroupMappings[0].Type)
+	require.Equal(t, "cn=admins,dc=example,dc=com", directories[0].GroupMappings[1].LDAPGroup)
+	require.Equal(t, "admins", directories[0].GroupMappings[1].Group)
+	require.Equal(t, 2, directories[0].GroupMappings[1].Type)
+	require.Equal(t, "openldap", directories[1].Name)
+	require.True(t, directories[1].StartTLS)
+	require.Equal(t, "uid={{Username}},ou=people,dc=example,dc=org", directories[1].UserDNTemplate)
+	require.Equal(t, "memberOf", directories[1].GroupAttribute)
+	require.Len(t, directories[1].GroupMappings, 0)
+}
+
+func TestRADIUSServersFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__NAME", "otp")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__SERVER", "radius.example.com:1812")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__SECRET", "secret")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__AUTH_TYPE", "mschapv2")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__TIMEOUT", "3")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__RETRIES", "2")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__NAS_IDENTIFIER", "sftp1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__REQUIRE_MESSAGE_AUTHENTICATOR", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__CREATE_USERS", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__PERMISSIONS", "list,download")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__1__NAME", "backup")
+	os.Setenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__1__SERVER", "127.0.0.1")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__SERVER")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__SECRET")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__AUTH_TYPE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__TIMEOUT")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__RETRIES")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__NAS_IDENTIFIER")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__REQUIRE_MESSAGE_AUTHENTICATOR")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__CREATE_USERS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__0__PERMISSIONS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__1__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__RADIUS_SERVERS__1__SERVER")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	servers := config.GetProviderConf().RADIUSServers
+	require.Len(t, servers, 2)
+	require.Equal(t, "otp", servers[0].Name)
+	require.Equal(t, "radius.example.com:1812", servers[0].Server)
+	require.Equal(t, "secret", servers[0].Secret)
+	require.Equal(t, "mschapv2", servers[0].AuthType)
+	require.Equal(t, 3, servers[0].Timeout)
+	require.Equal(t, 2, servers[0].Retries)
+	require.Equal(t, "sftp1", servers[0].NASIdentifier)
+	require.True(t, servers[0].RequireMessageAuthenticator)
+	require.True(t, servers[0].CreateUsers)
+	require.Equal(t, []string{"list", "download"}, servers[0].Permissions)
+	require.Equal(t, "backup", servers[1].Name)
+	require.Equal(t, "127.0.0.1", servers[1].Server)
+	require.Empty(t, servers[1].Secret)
+	require.False(t, servers[1].CreateUsers)
+}
+
+func TestKerberosServicesFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__NAME", "ad")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__KEYTAB", "/etc/sftpgo/sftpgo.keytab")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__REALMS", "EXAMPLE.COM,CORP.EXAMPLE.COM")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__KEEP_REALM", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__MAX_CLOCK_SKEW", "60")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__1__NAME", "mit")
+	os.Setenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__1__KEYTAB", "/etc/krb5.keytab")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__KEYTAB")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__REALMS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__KEEP_REALM")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__0__MAX_CLOCK_SKEW")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__1__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__KERBEROS_SERVICES__1__KEYTAB")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	services := config.GetProviderConf().KerberosServices
+	require.Len(t, services, 2)
+	require.Equal(t, "ad", services[0].Name)
+	require.Equal(t, "/etc/sftpgo/sftpgo.keytab", services[0].Keytab)
+	require.Equal(t, []string{"EXAMPLE.COM", "CORP.EXAMPLE.COM"}, services[0].Realms)
+	require.True(t, services[0].KeepRealm)
+	require.Equal(t, 60, services[0].MaxClockSkew)
+	require.Equal(t, "mit", services[1].Name)
+	require.Equal(t, "/etc/krb5.keytab", services[1].Keytab)
+	require.Len(t, services[1].Realms, 0)
+	require.False(t, services[1].KeepRealm)
+	require.Equal(t, 0, services[1].MaxClockSkew)
+}
+
 func TestSFTPDBindingsFromEnv(t *testing.T) {
 	reset()
 
@@ -856,12 +1155,28 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG", "false")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS", "127.0.1.1")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__PORT", "2203")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__KEX_ALGORITHMS", "diffie-hellman-group14-sha1")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__CIPHERS", "aes128-cbc,aes256-ctr")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__MACS", "hmac-sha1")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__LDAP_DIRECTORY", "ad")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__RADIUS_SERVER", "otp")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__KERBEROS_SERVICE", "ad")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__GEOIP__DENIED_COUNTRIES", "CN,RU")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__GEOIP__ALLOWED_ASNS", "12345, invalid,3269")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__PORT")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__PORT")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__KEX_ALGORITHMS")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__CIPHERS")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__MACS")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__LDAP_DIRECTORY")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__RADIUS_SERVER")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__KERBEROS_SERVICE")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__GEOIP__DENIED_COUNTRIES")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__GEOIP__ALLOWED_ASNS")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -874,6 +1189,133 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
 	require.True(t, bindings[1].ApplyProxyConfig) // default value
+	require.Len(t, bindings[0].KexAlgorithms, 0)
+	require.Equal(t, []string{"diffie-hellman-group14-sha1"}, bindings[1].KexAlgorithms)
+	require.Equal(t, []string{"aes128-cbc", "aes256-ctr"}, bindings[1].Ciphers)
+	require.Equal(t, []string{"hmac-sha1"}, bindings[1].MACs)
+	require.Empty(t, bindings[0].LDAPDirectory)
+	require.Equal(t, "ad", bindings[1].LDAPDirectory)
+	require.Equal(t, "otp", bindings[0].RADIUSServer)
+	require.Empty(t, bindings[1].RADIUSServer)
+	require.Empty(t, bindings[0].KerberosService)
+	require.Equal(t, "ad", bindings[1].KerberosService)
+	require.True(t, bindings[0].GeoIP.IsEmpty())
+	require.Equal(t, []string{"CN", "RU"}, bindings[1].GeoIP.DeniedCountries)
+	require.Equal(t, []uint{12345, 3269}, bindings[1].GeoIP.AllowedASNs)
+	require.Empty(t, bindings[1].GeoIP.AllowedCountries)
+	require.Empty(t, bindings[1].GeoIP.DeniedASNs)
+}
+
+func TestGeoIPFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_GEOIP__COUNTRY_DB_PATH", "GeoLite2-Country.mmdb")
+	os.Setenv("SFTPGO_GEOIP__ASN_DB_PATH", "/var/lib/GeoIP/GeoLite2-ASN.mmdb")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__COUNTRIES", "CN,RU")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__ASNS", "12345,3269")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__SCORE", "2")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__GEOIP__ALLOWED_COUNTRIES", "IT")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__0__GEOIP__DENIED_ASNS", "3269")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__GEOIP__DENIED_COUNTRIES", "CN")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_GEOIP__COUNTRY_DB_PATH")
+		os.Unsetenv("SFTPGO_GEOIP__ASN_DB_PATH")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__COUNTRIES")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__ASNS")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__GEOIP_SCORE__SCORE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__GEOIP__ALLOWED_COUNTRIES")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__0__GEOIP__DENIED_ASNS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__GEOIP__DENIED_COUNTRIES")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	geoIPConfig := config.GetGeoIPConfig()
+	assert.Equal(t, "GeoLite2-Country.mmdb", geoIPConfig.CountryDBPath)
+	assert.Equal(t, "/var/lib/GeoIP/GeoLite2-ASN.mmdb", geoIPConfig.ASNDBPath)
+	geoIPScore := config.GetCommonConfig().DefenderConfig.GeoIPScore
+	assert.Equal(t, []string{"CN", "RU"}, geoIPScore.Countries)
+	assert.Equal(t, []uint{12345, 3269}, geoIPScore.ASNs)
+	assert.Equal(t, 2, geoIPScore.Score)
+	assert.Equal(t, []string{"IT"}, config.GetFTPDConfig().Bindings[0].GeoIP.AllowedCountries)
+	assert.Equal(t, []uint{3269}, config.GetWebDAVDConfig().Bindings[0].GeoIP.DeniedASNs)
+	assert.Equal(t, []string{"CN"}, config.GetHTTPDConfig().Bindings[0].GeoIP.DeniedCountries)
+}
+
+func TestRevocationCheckFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_FTPD__REVOCATION_CHECK__OCSP_ENABLED", "true")
+	os.Setenv("SFTPGO_FTPD__REVOCATION_CHECK__HARD_FAIL", "1")
+	os.Setenv("SFTPGO_WEBDAVD__REVOCATION_CHECK__OCSP_RESPONDER", "http://ocsp.example.com")
+	os.Setenv("SFTPGO_HTTPD__REVOCATION_CHECK__OCSP_TIMEOUT", "10")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_FTPD__REVOCATION_CHECK__OCSP_ENABLED")
+		os.Unsetenv("SFTPGO_FTPD__REVOCATION_CHECK__HARD_FAIL")
+		os.Unsetenv("SFTPGO_WEBDAVD__REVOCATION_CHECK__OCSP_RESPONDER")
+		os.Unsetenv("SFTPGO_HTTPD__REVOCATION_CHECK__OCSP_TIMEOUT")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	ftpdConf := config.GetFTPDConfig()
+	assert.True(t, ftpdConf.RevocationCheck.OCSPEnabled)
+	assert.True(t, ftpdConf.RevocationCheck.HardFail)
+	assert.Equal(t, 5, ftpdConf.RevocationCheck.OCSPTimeout)
+	webdavdConf := config.GetWebDAVDConfig()
+	assert.False(t, webdavdConf.RevocationCheck.OCSPEnabled)
+	assert.Equal(t, "http://ocsp.example.com", webdavdConf.RevocationCheck.OCSPResponder)
+	assert.Equal(t, 10, config.GetHTTPDConfig().RevocationCheck.OCSPTimeout)
+}
+
+func TestKMSPKCS11FromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_KMS__SECRETS__PKCS11__MODULE_PATH", "/usr/lib/softhsm/libsofthsm2.so")
+	os.Setenv("SFTPGO_KMS__SECRETS__PKCS11__TOKEN_LABEL", "sftpgo")
+	os.Setenv("SFTPGO_KMS__SECRETS__PKCS11__PIN", "1234")
+	os.Setenv("SFTPGO_KMS__SECRETS__PKCS11__KEY_LABEL", "master")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_KMS__SECRETS__PKCS11__MODULE_PATH")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__PKCS11__TOKEN_LABEL")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__PKCS11__PIN")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__PKCS11__KEY_LABEL")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	kmsConfig := config.GetKMSConfig()
+	assert.True(t, kmsConfig.Secrets.PKCS11.IsEnabled())
+	assert.Equal(t, "/usr/lib/softhsm/libsofthsm2.so", kmsConfig.Secrets.PKCS11.ModulePath)
+	assert.Equal(t, "sftpgo", kmsConfig.Secrets.PKCS11.TokenLabel)
+	assert.Equal(t, "1234", kmsConfig.Secrets.PKCS11.Pin)
+	assert.Equal(t, "master", kmsConfig.Secrets.PKCS11.KeyLabel)
+}
+
+func TestKMSVaultFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_KMS__SECRETS__VAULT__ADDRESS", "https://vault.example.com:8200")
+	os.Setenv("SFTPGO_KMS__SECRETS__VAULT__TOKEN", "token")
+	os.Setenv("SFTPGO_KMS__SECRETS__VAULT__APPROLE__ROLE_ID", "role")
+	os.Setenv("SFTPGO_KMS__SECRETS__VAULT__APPROLE__SECRET_ID", "secret")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_KMS__SECRETS__VAULT__ADDRESS")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__VAULT__TOKEN")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__VAULT__APPROLE__ROLE_ID")
+		os.Unsetenv("SFTPGO_KMS__SECRETS__VAULT__APPROLE__SECRET_ID")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	vaultConfig := config.GetKMSConfig().Secrets.Vault
+	assert.True(t, vaultConfig.IsEnabled())
+	assert.Equal(t, "https://vault.example.com:8200", vaultConfig.Address)
+	assert.Equal(t, "transit", vaultConfig.MountPath)
+	assert.Equal(t, "token", vaultConfig.Token)
+	assert.Equal(t, "role", vaultConfig.AppRole.RoleID)
+	assert.Equal(t, "secret", vaultConfig.AppRole.SecretID)
+	assert.Equal(t, "approle", vaultConfig.AppRole.MountPath)
 }
 
 func TestCommandsFromEnv(t *testing.T) {
@@ -948,6 +1390,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_FTPD__BINDINGS__0__FORCE_PASSIVE_IP", "127.0.1.2")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_IP_OVERRIDES__0__IP", "172.16.1.1")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_HOST", "127.0.1.3")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_IP_HOOK", "/usr/bin/passive_ip")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_PORT_RANGE__START", "51000")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_PORT_RANGE__END", "51100")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__0__TLS_CIPHER_SUITES", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_CONNECTIONS_SECURITY", "1")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__ADDRESS", "127.0.1.1")
@@ -960,6 +1405,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CLIENT_AUTH_TYPE", "2")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__DEBUG", "1")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY", "1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_REUSE", "1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__AGGREGATE_UPLOAD_BANDWIDTH", "2048")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__AGGREGATE_DOWNLOAD_BANDWIDTH", "4096")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE", "cert.crt")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE", "cert.key")
 
@@ -971,6 +1419,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__FORCE_PASSIVE_IP")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_IP_OVERRIDES__0__IP")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_HOST")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_IP_HOOK")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_PORT_RANGE__START")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__PASSIVE_PORT_RANGE__END")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__TLS_CIPHER_SUITES")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__ACTIVE_CONNECTIONS_SECURITY")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__ADDRESS")
@@ -983,6 +1434,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CLIENT_AUTH_TYPE")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__DEBUG")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_REUSE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__AGGREGATE_UPLOAD_BANDWIDTH")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__AGGREGATE_DOWNLOAD_BANDWIDTH")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE")
 	})
@@ -999,6 +1453,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "127.0.1.2", bindings[0].ForcePassiveIP)
 	require.Len(t, bindings[0].PassiveIPOverrides, 0)
 	require.Equal(t, "127.0.1.3", bindings[0].PassiveHost)
+	require.Equal(t, "/usr/bin/passive_ip", bindings[0].PassiveIPHook)
+	require.Equal(t, 51000, bindings[0].PassivePortRange.Start)
+	require.Equal(t, 51100, bindings[0].PassivePortRange.End)
 	require.Equal(t, 0, bindings[0].ClientAuthType)
 	require.Len(t, bindings[0].TLSCipherSuites, 2)
 	require.Equal(t, "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", bindings[0].TLSCipherSuites[0])
@@ -1006,6 +1463,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.False(t, bindings[0].Debug)
 	require.Equal(t, 1, bindings[0].PassiveConnectionsSecurity)
 	require.Equal(t, 0, bindings[0].ActiveConnectionsSecurity)
+	require.Equal(t, 0, bindings[0].TLSSessionReuse)
+	require.Equal(t, int64(0), bindings[0].AggregateUploadBandwidth)
+	require.Equal(t, int64(0), bindings[0].AggregateDownloadBandwidth)
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
 	require.True(t, bindings[1].ApplyProxyConfig) // default value
@@ -1013,6 +1473,8 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 13, bindings[1].MinTLSVersion)
 	require.Equal(t, "127.0.1.1", bindings[1].ForcePassiveIP)
 	require.Empty(t, bindings[1].PassiveHost)
+	require.Empty(t, bindings[1].PassiveIPHook)
+	require.Equal(t, 0, bindings[1].PassivePortRange.Start)
 	require.Len(t, bindings[1].PassiveIPOverrides, 1)
 	require.Equal(t, "192.168.1.1", bindings[1].PassiveIPOverrides[0].IP)
 	require.Len(t, bindings[1].PassiveIPOverrides[0].Networks, 2)
@@ -1022,6 +1484,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.Nil(t, bindings[1].TLSCipherSuites)
 	require.Equal(t, 0, bindings[1].PassiveConnectionsSecurity)
 	require.Equal(t, 1, bindings[1].ActiveConnectionsSecurity)
+	require.Equal(t, 1, bindings[1].TLSSessionReuse)
+	require.Equal(t, int64(2048), bindings[1].AggregateUploadBandwidth)
+	require.Equal(t, int64(4096), bindings[1].AggregateDownloadBandwidth)
 	require.True(t, bindings[1].Debug)
 	require.Equal(t, "cert.crt", bindings[1].CertificateFile)
 	require.Equal(t, "cert.key", bindings[1].CertificateKeyFile)
@@ -1106,12 +1571,14 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ADDRESS", "127.0.1.1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__PORT", "9000")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTPS", "1")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTP3", "1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__MIN_TLS_VERSION", "13")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CLIENT_AUTH_TYPE", "1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__PREFIX", "/dav2")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_FILE", "webdav.crt")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_KEY_FILE", "webdav.key")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__DISABLE_WWW_AUTH_HEADER", "1")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__KERBEROS_SERVICE", "ad")
 
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__1__ADDRESS")
@@ -1124,12 +1591,14 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ADDRESS")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__PORT")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTP3")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__MIN_TLS_VERSION")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CLIENT_AUTH_TYPE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__PREFIX")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_FILE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_KEY_FILE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__DISABLE_WWW_AUTH_HEADER")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__KERBEROS_SERVICE")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -1147,6 +1616,7 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 8000, bindings[1].Port)
 	require.Equal(t, "127.0.0.1", bindings[1].Address)
 	require.False(t, bindings[1].EnableHTTPS)
+	require.False(t, bindings[1].EnableHTTP3)
 	require.Equal(t, 12, bindings[1].MinTLSVersion)
 	require.Equal(t, 0, bindings[1].ClientAuthType)
 	require.Len(t, bindings[1].TLSCipherSuites, 1)
@@ -1159,6 +1629,7 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 9000, bindings[2].Port)
 	require.Equal(t, "127.0.1.1", bindings[2].Address)
 	require.True(t, bindings[2].EnableHTTPS)
+	require.True(t, bindings[2].EnableHTTP3)
 	require.Equal(t, 13, bindings[2].MinTLSVersion)
 	require.Equal(t, 1, bindings[2].ClientAuthType)
 	require.Nil(t, bindings[2].TLSCipherSuites)
@@ -1167,6 +1638,272 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "webdav.key", bindings[2].CertificateKeyFile)
 	require.Equal(t, 0, bindings[2].ClientIPHeaderDepth)
 	require.True(t, bindings[2].DisableWWWAuthHeader)
+	require.Empty(t, bindings[1].KerberosService)
+	require.Equal(t, "ad", bindings[2].KerberosService)
+}
+
+func TestS3DBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__PORT", "9000")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_RSA_WITH_AES_128_CBC_SHA ")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__PROXY_ALLOWED", "192.168.10.1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_PROXY_HEADER", "X-Forwarded-For")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_HEADER_DEPTH", "2")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__PORT", "9443")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__ENABLE_HTTPS", "1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_FILE", "s3.crt")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_KEY_FILE", "s3.key")
+	os.Setenv("SFTPGO_S3D__REGION", "eu-central-1")
+	os.Setenv("SFTPGO_S3D__MULTIPART_UPLOADS_EXPIRATION", "12")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__PROXY_ALLOWED")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_PROXY_HEADER")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_HEADER_DEPTH")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__PORT")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_S3D__REGION")
+		os.Unsetenv("SFTPGO_S3D__MULTIPART_UPLOADS_EXPIRATION")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	s3dConf := config.GetS3DConfig()
+	require.Equal(t, "eu-central-1", s3dConf.Region)
+	require.Equal(t, "s3_multipart_uploads", s3dConf.MultipartUploadsPath)
+	require.Equal(t, 12, s3dConf.MultipartUploadsExpiration)
+	bindings := s3dConf.Bindings
+	require.Len(t, bindings, 3)
+	require.Equal(t, 0, bindings[0].Port)
+	require.Empty(t, bindings[0].Address)
+	require.False(t, bindings[0].EnableHTTPS)
+	require.Equal(t, 12, bindings[0].MinTLSVersion)
+	require.Equal(t, 9000, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.False(t, bindings[1].EnableHTTPS)
+	require.Equal(t, 12, bindings[1].MinTLSVersion)
+	require.Len(t, bindings[1].TLSCipherSuites, 1)
+	require.Equal(t, "TLS_RSA_WITH_AES_128_CBC_SHA", bindings[1].TLSCipherSuites[0])
+	require.Equal(t, "192.168.10.1", bindings[1].ProxyAllowed[0])
+	require.Equal(t, "X-Forwarded-For", bindings[1].ClientIPProxyHeader)
+	require.Equal(t, 2, bindings[1].ClientIPHeaderDepth)
+	require.Equal(t, 9443, bindings[2].Port)
+	require.Empty(t, bindings[2].Address)
+	require.True(t, bindings[2].EnableHTTPS)
+	require.Equal(t, 13, bindings[2].MinTLSVersion)
+	require.Nil(t, bindings[2].TLSCipherSuites)
+	require.Equal(t, "s3.crt", bindings[2].CertificateFile)
+	require.Equal(t, "s3.key", bindings[2].CertificateKeyFile)
+	require.Equal(t, 0, bindings[2].ClientIPHeaderDepth)
+}
+
+func TestTFTPDBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_TFTPD__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_TFTPD__BINDINGS__1__PORT", "69")
+	os.Setenv("SFTPGO_TFTPD__BINDINGS__1__USERNAME", "backups")
+	os.Setenv("SFTPGO_TFTPD__TIMEOUT", "3")
+	os.Setenv("SFTPGO_TFTPD__RETRIES", "2")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_TFTPD__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_TFTPD__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_TFTPD__BINDINGS__1__USERNAME")
+		os.Unsetenv("SFTPGO_TFTPD__TIMEOUT")
+		os.Unsetenv("SFTPGO_TFTPD__RETRIES")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	tftpdConf := config.GetTFTPDConfig()
+	require.Equal(t, 3, tftpdConf.Timeout)
+	require.Equal(t, 2, tftpdConf.Retries)
+	bindings := tftpdConf.Bindings
+	require.Len(t, bindings, 2)
+	require.Equal(t, 0, bindings[0].Port)
+	require.Empty(t, bindings[0].Address)
+	require.Empty(t, bindings[0].Username)
+	require.Equal(t, 69, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.Equal(t, "backups", bindings[1].Username)
+}
+
+func TestGRPCDBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__PORT", "9095")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__ENABLE_TLS", "1")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_FILE", "cert.crt")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_KEY_FILE", "cert.key")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__CLIENT_AUTH_TYPE", "2")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_AES_128_GCM_SHA256")
+	os.Setenv("SFTPGO_GRPCD__SIGNING_PASSPHRASE", "secret")
+	os.Setenv("SFTPGO_GRPCD__TOKEN_VALIDATION", "1")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__ENABLE_TLS")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__CLIENT_AUTH_TYPE")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_GRPCD__SIGNING_PASSPHRASE")
+		os.Unsetenv("SFTPGO_GRPCD__TOKEN_VALIDATION")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	grpcdConf := config.GetGRPCDConfig()
+	require.Equal(t, "secret", grpcdConf.SigningPassphrase)
+	require.Equal(t, 1, grpcdConf.TokenValidation)
+	bindings := grpcdConf.Bindings
+	require.Len(t, bindings, 2)
+	require.Equal(t, 0, bindings[0].Port)
+	require.Empty(t, bindings[0].Address)
+	require.False(t, bindings[0].EnableTLS)
+	require.Equal(t, 12, bindings[0].MinTLSVersion)
+	require.Equal(t, 0, bindings[0].ClientAuthType)
+	require.Equal(t, 9095, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.True(t, bindings[1].EnableTLS)
+	require.Equal(t, "cert.crt", bindings[1].CertificateFile)
+	require.Equal(t, "cert.key", bindings[1].CertificateKeyFile)
+	require.Equal(t, 13, bindings[1].MinTLSVersion)
+	require.Equal(t, 2, bindings[1].ClientAuthType)
+	require.Equal(t, []string{"TLS_AES_128_GCM_SHA256"}, bindings[1].TLSCipherSuites)
+}
+
+func TestSMTPDFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__PORT", "2525")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__TLS_MODE", "1")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__CERTIFICATE_FILE", "cert.crt")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__CERTIFICATE_KEY_FILE", "cert.key")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_SMTPD__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_AES_128_GCM_SHA256")
+	os.Setenv("SFTPGO_SMTPD__MAPPINGS__0__ADDRESS", "partner@files.example.com")
+	os.Setenv("SFTPGO_SMTPD__MAPPINGS__0__USERNAME", "partner")
+	os.Setenv("SFTPGO_SMTPD__MAPPINGS__0__PATH", "/inbox")
+	os.Setenv("SFTPGO_SMTPD__MAPPINGS__0__ALLOWED_SENDERS", "*@partner.com, *@partner.org")
+	os.Setenv("SFTPGO_SMTPD__MAX_MESSAGE_SIZE", "50")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__TLS_MODE")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_SMTPD__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_SMTPD__MAPPINGS__0__ADDRESS")
+		os.Unsetenv("SFTPGO_SMTPD__MAPPINGS__0__USERNAME")
+		os.Unsetenv("SFTPGO_SMTPD__MAPPINGS__0__PATH")
+		os.Unsetenv("SFTPGO_SMTPD__MAPPINGS__0__ALLOWED_SENDERS")
+		os.Unsetenv("SFTPGO_SMTPD__MAX_MESSAGE_SIZE")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	smtpdConf := config.GetSMTPDConfig()
+	require.Equal(t, 50, smtpdConf.MaxMessageSize)
+	require.Equal(t, 20, smtpdConf.MaxAttachments)
+	bindings := smtpdConf.Bindings
+	require.Len(t, bindings, 2)
+	require.Equal(t, 0, bindings[0].Port)
+	require.Empty(t, bindings[0].Address)
+	require.Equal(t, 0, bindings[0].TLSMode)
+	require.Equal(t, 12, bindings[0].MinTLSVersion)
+	require.Equal(t, 2525, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.Equal(t, 1, bindings[1].TLSMode)
+	require.Equal(t, "cert.crt", bindings[1].CertificateFile)
+	require.Equal(t, "cert.key", bindings[1].CertificateKeyFile)
+	require.Equal(t, 13, bindings[1].MinTLSVersion)
+	require.Equal(t, []string{"TLS_AES_128_GCM_SHA256"}, bindings[1].TLSCipherSuites)
+	mappings := smtpdConf.Mappings
+	require.Len(t, mappings, 1)
+	require.Equal(t, "partner@files.example.com", mappings[0].Address)
+	require.Equal(t, "partner", mappings[0].Username)
+	require.Equal(t, "/inbox", mappings[0].Path)
+	require.Equal(t, []string{"*@partner.com", "*@partner.org"}, mappings[0].AllowedSenders)
+}
+
+func TestVirtualHostsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__HOST", "ftp.example.com")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__CERTIFICATE_FILE", "ftp.crt")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__CERTIFICATE_KEY_FILE", "ftp.key")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__ALLOWED_ROLES", "role1, role2")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__1__ALLOWED_ROLES", "role3")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__0__VIRTUAL_HOSTS__2__HOST", "dav.example.com")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__0__VIRTUAL_HOSTS__2__ALLOWED_ROLES", "role1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__HOST", "files.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__BRANDING__WEB_CLIENT__NAME", "Example WebClient")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__OIDC__CLIENT_ID", "example_client_id")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__OIDC__CONFIG_URL", "https://idp.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__1__HOST", "admin.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__1__BRANDING__WEB_ADMIN__SHORT_NAME", "Admin")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__HOST")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__0__ALLOWED_ROLES")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__VIRTUAL_HOSTS__1__ALLOWED_ROLES")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__0__VIRTUAL_HOSTS__2__HOST")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__0__VIRTUAL_HOSTS__2__ALLOWED_ROLES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__HOST")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__BRANDING__WEB_CLIENT__NAME")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__OIDC__CLIENT_ID")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__0__OIDC__CONFIG_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__1__HOST")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__0__VIRTUAL_HOSTS__1__BRANDING__WEB_ADMIN__SHORT_NAME")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	ftpBindings := config.GetFTPDConfig().Bindings
+	require.Len(t, ftpBindings, 1)
+	// a virtual host without a host name is ignored
+	require.Len(t, ftpBindings[0].VirtualHosts, 1)
+	require.Equal(t, "ftp.example.com", ftpBindings[0].VirtualHosts[0].Host)
+	require.Equal(t, "ftp.crt", ftpBindings[0].VirtualHosts[0].CertificateFile)
+	require.Equal(t, "ftp.key", ftpBindings[0].VirtualHosts[0].CertificateKeyFile)
+	require.Equal(t, []string{"role1", "role2"}, ftpBindings[0].VirtualHosts[0].AllowedRoles)
+	webDAVBindings := config.GetWebDAVDConfig().Bindings
+	require.Len(t, webDAVBindings, 1)
+	require.Len(t, webDAVBindings[0].VirtualHosts, 1)
+	require.Equal(t, "dav.example.com", webDAVBindings[0].VirtualHosts[0].Host)
+	require.Empty(t, webDAVBindings[0].VirtualHosts[0].CertificateFile)
+	require.Equal(t, []string{"role1"}, webDAVBindings[0].VirtualHosts[0].AllowedRoles)
+	httpBindings := config.GetHTTPDConfig().Bindings
+	require.Len(t, httpBindings, 1)
+	require.Len(t, httpBindings[0].VirtualHosts, 2)
+	require.Equal(t, "files.example.com", httpBindings[0].VirtualHosts[0].Host)
+	require.Equal(t, "Example WebClient", httpBindings[0].VirtualHosts[0].Branding.WebClient.Name)
+	require.Equal(t, "example_client_id", httpBindings[0].VirtualHosts[0].OIDC.ClientID)
+	require.Equal(t, "https://idp.example.com", httpBindings[0].VirtualHosts[0].OIDC.ConfigURL)
+	require.Len(t, httpBindings[0].VirtualHosts[0].AllowedRoles, 0)
+	require.Equal(t, "admin.example.com", httpBindings[0].VirtualHosts[1].Host)
+	require.Equal(t, "Admin", httpBindings[0].VirtualHosts[1].Branding.WebAdmin.ShortName)
+	require.Empty(t, httpBindings[0].VirtualHosts[1].OIDC.ClientID)
 }
 
 func TestHTTPDBindingsFromEnv(t *testing.T) {
@@ -1191,6 +1928,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLED_LOGIN_METHODS", "3")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__RENDER_OPENAPI", "0")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTPS", "1 ")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTP3", "true")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__MIN_TLS_VERSION", "13")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_AUTH_TYPE", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__TLS_CIPHER_SUITES", " TLS_AES_256_GCM_SHA384 , TLS_CHACHA20_POLY1305_SHA256")
@@ -1198,6 +1936,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_IP_PROXY_HEADER", "X-Real-IP")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_IP_HEADER_DEPTH", "2")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__HIDE_LOGIN_URL", "3")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__KERBEROS_SERVICE", "ad")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__1__URL", "http://127.0.0.1/")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__1__FILE_EXTENSIONS", ".pdf, .txt")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__2__URL", "http://127.0.1.1/")
@@ -1210,6 +1949,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__ROLE_FIELD", "sftpgo_role")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__SCOPES", "openid")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__IMPLICIT_ROLES", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUPS_FIELD", "groups")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__REQUIRE_GROUP_MAPPING", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS", "field1,field2")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG", "1")
@@ -1255,6 +1996,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ADDRESS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__PORT")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTP3")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__MIN_TLS_VERSION")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_WEB_ADMIN")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_WEB_CLIENT")
@@ -1267,6 +2009,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_IP_PROXY_HEADER")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_IP_HEADER_DEPTH")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__HIDE_LOGIN_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__KERBEROS_SERVICE")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__1__URL")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__1__FILE_EXTENSIONS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__WEB_CLIENT_INTEGRATIONS__2__URL")
@@ -1279,6 +2022,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__ROLE_FIELD")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__SCOPES")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__IMPLICIT_ROLES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUPS_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__REQUIRE_GROUP_MAPPING")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG")
@@ -1349,12 +2094,14 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.False(t, bindings[1].OIDC.InsecureSkipSignatureCheck)
 	require.False(t, bindings[1].OIDC.Debug)
 	require.False(t, bindings[1].Security.Enabled)
+	require.False(t, bindings[1].EnableHTTP3)
 	require.Equal(t, "Web Admin", bindings[1].Branding.WebAdmin.Name)
 	require.Equal(t, "WebClient", bindings[1].Branding.WebClient.ShortName)
 	require.Equal(t, 0, bindings[1].ClientIPHeaderDepth)
 	require.Equal(t, 9000, bindings[2].Port)
 	require.Equal(t, "127.0.1.1", bindings[2].Address)
 	require.True(t, bindings[2].EnableHTTPS)
+	require.True(t, bindings[2].EnableHTTP3)
 	require.Equal(t, 13, bindings[2].MinTLSVersion)
 	require.False(t, bindings[2].EnableWebAdmin)
 	require.False(t, bindings[2].EnableWebClient)
@@ -1371,6 +2118,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "X-Real-IP", bindings[2].ClientIPProxyHeader)
 	require.Equal(t, 2, bindings[2].ClientIPHeaderDepth)
 	require.Equal(t, 3, bindings[2].HideLoginURL)
+	require.Empty(t, bindings[1].KerberosService)
+	require.Equal(t, "ad", bindings[2].KerberosService)
 	require.Len(t, bindings[2].WebClientIntegrations, 1)
 	require.Equal(t, "http://127.0.0.1/", bindings[2].WebClientIntegrations[0].URL)
 	require.Equal(t, []string{".pdf", ".txt"}, bindings[2].WebClientIntegrations[0].FileExtensions)
@@ -1383,6 +2132,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Len(t, bindings[2].OIDC.Scopes, 1)
 	require.Equal(t, "openid", bindings[2].OIDC.Scopes[0])
 	require.True(t, bindings[2].OIDC.ImplicitRoles)
+	require.Equal(t, "groups", bindings[2].OIDC.GroupsField)
+	require.True(t, bindings[2].OIDC.RequireGroupMapping)
 	require.Len(t, bindings[2].OIDC.CustomFields, 2)
 	require.Equal(t, "field1", bindings[2].OIDC.CustomFields[0])
 	require.Equal(t, "field2", bindings[2].OIDC.CustomFields[1])
@@ -1565,6 +2316,19 @@ func TestConfigFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA")
 	os.Setenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE", "123")
 	os.Setenv("SFTPGO_ACME__HTTP01_CHALLENGE__PORT", "5002")
+	os.Setenv("SFTPGO_COMMON__SFTPFS_POOL__MAX_CONNECTIONS", "50")
+	os.Setenv("SFTPGO_COMMON__SFTPFS_POOL__IDLE_TIMEOUT", "120")
+	os.Setenv("SFTPGO_COMMON__READ_CACHE__PATH", "/tmp/cache")
+	os.Setenv("SFTPGO_COMMON__READ_CACHE__MAX_SIZE", "1024")
+	os.Setenv("SFTPGO_COMMON__LISTING_CACHE__TTL", "10")
+	os.Setenv("SFTPGO_COMMON__UPLOAD_STAGING__ENABLED", "true")
+	os.Setenv("SFTPGO_COMMON__UPLOAD_STAGING__MEMORY_THRESHOLD", "8")
+	os.Setenv("SFTPGO_COMMON__UPLOAD_CHECKSUMS", "sha256,md5")
+	os.Setenv("SFTPGO_COMMON__SKIP_IDENTICAL_UPLOADS", "true")
+	os.Setenv("SFTPGO_COMMON__ARCHIVE_DOWNLOADS__ZIP_SUFFIX", ".zip")
+	os.Setenv("SFTPGO_COMMON__QUOTA_RECONCILIATION__INTERVAL", "60")
+	os.Setenv("SFTPGO_COMMON__DEAD_LETTERS__ENABLED", "true")
+	os.Setenv("SFTPGO_COMMON__DEAD_LETTERS__MAX_RETRIES", "3")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__0__PORT")
@@ -1577,6 +2341,19 @@ func TestConfigFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES")
 		os.Unsetenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE")
 		os.Unsetenv("SFTPGO_ACME__HTTP01_CHALLENGE_PORT")
+		os.Unsetenv("SFTPGO_COMMON__SFTPFS_POOL__MAX_CONNECTIONS")
+		os.Unsetenv("SFTPGO_COMMON__SFTPFS_POOL__IDLE_TIMEOUT")
+		os.Unsetenv("SFTPGO_COMMON__READ_CACHE__PATH")
+		os.Unsetenv("SFTPGO_COMMON__READ_CACHE__MAX_SIZE")
+		os.Unsetenv("SFTPGO_COMMON__LISTING_CACHE__TTL")
+		os.Unsetenv("SFTPGO_COMMON__UPLOAD_STAGING__ENABLED")
+		os.Unsetenv("SFTPGO_COMMON__UPLOAD_STAGING__MEMORY_THRESHOLD")
+		os.Unsetenv("SFTPGO_COMMON__UPLOAD_CHECKSUMS")
+		os.Unsetenv("SFTPGO_COMMON__SKIP_IDENTICAL_UPLOADS")
+		os.Unsetenv("SFTPGO_COMMON__ARCHIVE_DOWNLOADS__ZIP_SUFFIX")
+		os.Unsetenv("SFTPGO_COMMON__QUOTA_RECONCILIATION__INTERVAL")
+		os.Unsetenv("SFTPGO_COMMON__DEAD_LETTERS__ENABLED")
+		os.Unsetenv("SFTPGO_COMMON__DEAD_LETTERS__MAX_RETRIES")
 	})
 	err := config.LoadConfig(".", "invalid config")
 	assert.NoError(t, err)
@@ -1599,4 +2376,24 @@ func TestConfigFromEnv(t *testing.T) {
 	assert.Equal(t, "123", config.GetHTTPDConfig().Setup.InstallationCode)
 	acmeConfig := config.GetACMEConfig()
 	assert.Equal(t, 5002, acmeConfig.HTTP01Challenge.Port)
+	commonConfig := config.GetCommonConfig()
+	assert.Equal(t, 50, commonConfig.SFTPFsPool.MaxConnections)
+	assert.Equal(t, 5, commonConfig.SFTPFsPool.MaxSessionsPerConnection)
+	assert.Equal(t, 120, commonConfig.SFTPFsPool.IdleTimeout)
+	assert.Equal(t, "/tmp/cache", commonConfig.ReadCache.Path)
+	assert.Equal(t, int64(1024), commonConfig.ReadCache.MaxSize)
+	assert.Equal(t, int64(0), commonConfig.ReadCache.MaxFileSize)
+	assert.Equal(t, 10, commonConfig.ListingCache.TTL)
+	assert.Equal(t, 100, commonConfig.ListingCache.MaxEntries)
+	assert.True(t, commonConfig.UploadStaging.Enabled)
+	assert.Equal(t, int64(8), commonConfig.UploadStaging.MemoryThreshold)
+	assert.Equal(t, []string{"sha256", "md5"}, commonConfig.UploadChecksums)
+	assert.True(t, commonConfig.SkipIdenticalUploads)
+	assert.Equal(t, ".zip", commonConfig.ArchiveDownloads.ZipSuffix)
+	assert.Empty(t, commonConfig.ArchiveDownloads.TarSuffix)
+	assert.Equal(t, 60, commonConfig.QuotaReconciliation.Interval)
+	assert.Equal(t, 10, commonConfig.QuotaReconciliation.SampleSize)
+	assert.True(t, commonConfig.DeadLetters.Enabled)
+	assert.Equal(t, 3, commonConfig.DeadLetters.MaxRetries)
+	assert.Equal(t, 60, commonConfig.DeadLetters.RetryDelay)
 }
@@ -0,0 +1,156 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// AccessTimeWindow defines a time window in which users are allowed to login.
+// The time zone configured for the user, or UTC, is used
+type AccessTimeWindow struct {
+	// Days of the week, 0 is Sunday. Empty means every day.
+	// For windows spanning midnight, this is the start day
+	Days []int `json:"days,omitempty"`
+	// Start time in HH:MM format
+	From string `json:"from"`
+	// End time in HH:MM format, excluded. If it is not after the start time
+	// the window ends the next day
+	To string `json:"to"`
+}
+
+// HasDay returns true if the specified day of the week is explicitly set
+func (w *AccessTimeWindow) HasDay(day int) bool {
+	return util.Contains(w.Days, day)
+}
+
+// IsActive returns true if the specified time is inside the window.
+// The time must be already converted to the configured time zone
+func (w *AccessTimeWindow) IsActive(t time.Time) bool {
+	from, err := parseBandwidthScheduleTime(w.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseBandwidthScheduleTime(w.To)
+	if err != nil {
+		return false
+	}
+	return isTimeInDailyWindow(t, w.Days, from, to)
+}
+
+func (w *AccessTimeWindow) getACopy() AccessTimeWindow {
+	days := make([]int, len(w.Days))
+	copy(days, w.Days)
+
+	return AccessTimeWindow{
+		Days: days,
+		From: w.From,
+		To:   w.To,
+	}
+}
+
+// isTimeInDailyWindow returns true if t is inside the window starting at from
+// and ending at to, expressed as minutes since midnight, on the specified days
+func isTimeInDailyWindow(t time.Time, days []int, from, to int) bool {
+	startsOn := func(day int) bool {
+		return len(days) == 0 || util.Contains(days, day)
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if from < to {
+		return startsOn(day) && minutes >= from && minutes < to
+	}
+	if minutes >= from {
+		return startsOn(day)
+	}
+	return minutes < to && startsOn((day+6)%7)
+}
+
+func validateAccessTimeWindows(windows []AccessTimeWindow, timeZone string) (string, error) {
+	timeZone = strings.TrimSpace(timeZone)
+	if timeZone != "" {
+		if _, err := time.LoadLocation(timeZone); err != nil {
+			return timeZone, util.NewValidationError(fmt.Sprintf("invalid access time zone %q: %v", timeZone, err))
+		}
+	}
+	for idx := range windows {
+		w := &windows[idx]
+		if _, err := parseBandwidthScheduleTime(w.From); err != nil {
+			return timeZone, util.NewValidationError(fmt.Sprintf("invalid access time window start time %q, the HH:MM format is required", w.From))
+		}
+		if _, err := parseBandwidthScheduleTime(w.To); err != nil {
+			return timeZone, util.NewValidationError(fmt.Sprintf("invalid access time window end time %q, the HH:MM format is required", w.To))
+		}
+		for _, day := range w.Days {
+			if day < 0 || day > 6 {
+				return timeZone, util.NewValidationError(fmt.Sprintf("invalid access time window day %d, allowed values are 0-6", day))
+			}
+		}
+		sort.Ints(w.Days)
+		days := make([]int, 0, len(w.Days))
+		for _, day := range w.Days {
+			if !util.Contains(days, day) {
+				days = append(days, day)
+			}
+		}
+		w.Days = days
+	}
+	return timeZone, nil
+}
+
+func copyAccessTimeWindows(windows []AccessTimeWindow) []AccessTimeWindow {
+	result := make([]AccessTimeWindow, 0, len(windows))
+	for idx := range windows {
+		result = append(result, windows[idx].getACopy())
+	}
+	return result
+}
+
+// IsLoginTimeAllowed returns true if the user is allowed to login at the
+// specified time. Users without access time windows can always login
+func (u *User) IsLoginTimeAllowed(t time.Time) bool {
+	if len(u.Filters.AccessTimeWindows) == 0 {
+		return true
+	}
+	loc := time.UTC
+	if u.Filters.AccessTimeZone != "" {
+		l, err := time.LoadLocation(u.Filters.AccessTimeZone)
+		if err != nil {
+			return false
+		}
+		loc = l
+	}
+	t = t.In(loc)
+	for idx := range u.Filters.AccessTimeWindows {
+		if u.Filters.AccessTimeWindows[idx].IsActive(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOutsideAccessTime returns true if the user sessions must be terminated
+// because the specified time is outside the allowed access time windows
+func (u *User) IsOutsideAccessTime(t time.Time) bool {
+	if !u.Filters.DisconnectOutsideAccessTime {
+		return false
+	}
+	return !u.IsLoginTimeAllowed(t)
+}
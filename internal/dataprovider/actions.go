@@ -53,6 +53,8 @@ const (
 	actionObjectRole        = "role"
 	actionObjectIPListEntry = "ip_list_entry"
 	actionObjectConfigs     = "configs"
+	actionObjectTemplate    = "template"
+	actionObjectTenant      = "tenant"
 )
 
 var (
@@ -60,7 +62,17 @@ var (
 	reservedUsers           = []string{ActionExecutorSelf, ActionExecutorSystem}
 )
 
+// executeAuditedAction records the operation in the audit log and then executes the configured actions.
+// before is the object snapshot taken, using getAuditLogSnapshot, before an update or a delete
+func executeAuditedAction(operation, executor, ip, objectType, objectName, role string, before []byte,
+	object plugin.Renderer,
+) {
+	addObjectAuditLogEntry(operation, executor, ip, objectType, objectName, role, before, object)
+	executeAction(operation, executor, ip, objectType, objectName, role, object)
+}
+
 func executeAction(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer) {
+	addChangeEvent(operation, objectType, objectName, object)
 	if plugin.Handler.HasNotifiers() {
 		plugin.Handler.NotifyProviderEvent(&notifier.ProviderEvent{
 			Action:     operation,
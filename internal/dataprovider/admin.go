@@ -42,6 +42,7 @@ const (
 	PermAdminChangeUsers      = "edit_users"
 	PermAdminDeleteUsers      = "del_users"
 	PermAdminViewUsers        = "view_users"
+	PermAdminResetUserPwds    = "reset_user_pwds"
 	PermAdminViewConnections  = "view_conns"
 	PermAdminCloseConnections = "close_conns"
 	PermAdminViewServerStatus = "view_status"
@@ -58,6 +59,7 @@ const (
 	PermAdminManageEventRules = "manage_event_rules"
 	PermAdminManageRoles      = "manage_roles"
 	PermAdminManageIPLists    = "manage_ip_lists"
+	PermAdminManageTenants    = "manage_tenants"
 )
 
 const (
@@ -71,13 +73,24 @@ const (
 
 var (
 	validAdminPerms = []string{PermAdminAny, PermAdminAddUsers, PermAdminChangeUsers, PermAdminDeleteUsers,
-		PermAdminViewUsers, PermAdminManageGroups, PermAdminViewConnections, PermAdminCloseConnections,
+		PermAdminViewUsers, PermAdminResetUserPwds, PermAdminManageGroups, PermAdminViewConnections, PermAdminCloseConnections,
 		PermAdminViewServerStatus, PermAdminManageAdmins, PermAdminManageRoles, PermAdminManageEventRules,
 		PermAdminManageAPIKeys, PermAdminQuotaScans, PermAdminManageSystem, PermAdminManageDefender,
 		PermAdminViewDefender, PermAdminManageIPLists, PermAdminRetentionChecks, PermAdminMetadataChecks,
-		PermAdminViewEvents}
+		PermAdminViewEvents, PermAdminManageTenants}
 	forbiddenPermsForRoleAdmins = []string{PermAdminAny, PermAdminManageAdmins, PermAdminManageSystem,
-		PermAdminManageEventRules, PermAdminManageIPLists, PermAdminManageRoles}
+		PermAdminManageEventRules, PermAdminManageIPLists, PermAdminManageRoles, PermAdminManageTenants}
+	// tenant admins cannot have permissions that allow to view or change objects shared among tenants
+	forbiddenPermsForTenantAdmins = []string{PermAdminAny, PermAdminManageAdmins, PermAdminManageSystem,
+		PermAdminManageIPLists, PermAdminManageRoles, PermAdminManageTenants, PermAdminManageAPIKeys,
+		PermAdminQuotaScans, PermAdminRetentionChecks, PermAdminMetadataChecks, PermAdminViewEvents,
+		PermAdminManageDefender}
+	// admins allowed to manage other admins or to backup/restore the data provider could view
+	// the filesystem configurations anyway
+	forbiddenPermsForHiddenFsConfig = []string{PermAdminAny, PermAdminManageAdmins, PermAdminManageSystem}
+	// permissions that can be granted on the users of a group
+	validAdminGrantPerms = []string{PermAdminViewUsers, PermAdminChangeUsers, PermAdminDeleteUsers,
+		PermAdminResetUserPwds}
 )
 
 // AdminTOTPConfig defines the time-based one time password configuration
@@ -206,6 +219,62 @@ type AdminFilters struct {
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode   `json:"recovery_codes,omitempty"`
 	Preferences   AdminPreferences `json:"preferences"`
+	// FIDO2/WebAuthn security keys registered as second factor for the WebAdmin
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// If enabled, the admin must use a security key as second factor for the WebAdmin,
+	// TOTP is not accepted
+	RequireWebAuthn bool `json:"require_webauthn,omitempty"`
+	// If enabled, the admin cannot view or change the filesystem configurations,
+	// and so the related credentials, for users, groups and virtual folders.
+	// Only the storage provider is visible
+	HideFsConfig bool `json:"hide_fs_config,omitempty"`
+	// Policy for the browsers remembered after the second factor authentication
+	// to the WebAdmin
+	RememberedDevices RememberedDevicesPolicy `json:"remembered_devices,omitempty"`
+	// Tenant the admin belongs to. A tenant admin can only view and manage
+	// the users, groups, folders and event rules of its tenant
+	Tenant string `json:"tenant,omitempty"`
+	// Permissions granted only on the users that are members of specific groups,
+	// in addition to the global permissions
+	Grants []AdminPermissionGrant `json:"grants,omitempty"`
+}
+
+// AdminPermissionGrant defines the permissions granted to an admin on the users
+// that are members of a group. For example an admin can be allowed to view the
+// users of a group and reset their passwords without any global user permission
+type AdminPermissionGrant struct {
+	// Group name, the grant applies to the users that are members of this group,
+	// regardless of the membership type
+	Group string `json:"group"`
+	// Granted permissions, only user related permissions are supported
+	Permissions []string `json:"permissions"`
+}
+
+func (g *AdminPermissionGrant) validate() error {
+	if g.Group == "" {
+		return util.NewValidationError("the group name is mandatory for permission grants")
+	}
+	g.Permissions = util.RemoveDuplicates(g.Permissions, false)
+	if len(g.Permissions) == 0 {
+		return util.NewValidationError(fmt.Sprintf("no permission granted for group %q", g.Group))
+	}
+	for _, perm := range g.Permissions {
+		if !util.Contains(validAdminGrantPerms, perm) {
+			return util.NewValidationError(fmt.Sprintf("permission %q cannot be granted for group %q, allowed permissions: %q",
+				perm, g.Group, strings.Join(validAdminGrantPerms, ",")))
+		}
+	}
+	return nil
+}
+
+func (g *AdminPermissionGrant) getACopy() AdminPermissionGrant {
+	permissions := make([]string, len(g.Permissions))
+	copy(permissions, g.Permissions)
+
+	return AdminPermissionGrant{
+		Group:       g.Group,
+		Permissions: permissions,
+	}
 }
 
 // AdminGroupMappingOptions defines the options for admin/group mapping
@@ -327,7 +396,7 @@ func (a *Admin) validateRecoveryCodes() error {
 
 func (a *Admin) validatePermissions() error {
 	a.Permissions = util.RemoveDuplicates(a.Permissions, false)
-	if len(a.Permissions) == 0 {
+	if len(a.Permissions) == 0 && len(a.Filters.Grants) == 0 {
 		return util.NewValidationError("please grant some permissions to this admin")
 	}
 	if util.Contains(a.Permissions, PermAdminAny) {
@@ -343,6 +412,32 @@ func (a *Admin) validatePermissions() error {
 					strings.Join(forbiddenPermsForRoleAdmins, ",")))
 			}
 		}
+		if a.Filters.Tenant != "" && util.Contains(forbiddenPermsForTenantAdmins, perm) {
+			return util.NewValidationError(fmt.Sprintf("a tenant admin cannot have the following permissions: %q",
+				strings.Join(forbiddenPermsForTenantAdmins, ",")))
+		}
+		if a.Filters.HideFsConfig && util.Contains(forbiddenPermsForHiddenFsConfig, perm) {
+			return util.NewValidationError(fmt.Sprintf("an admin that cannot view filesystem configurations cannot have the following permissions: %q",
+				strings.Join(forbiddenPermsForHiddenFsConfig, ",")))
+		}
+	}
+	return nil
+}
+
+func (a *Admin) validateGrants() error {
+	if len(a.Filters.Grants) > 0 && a.Filters.Tenant != "" {
+		return util.NewValidationError("permission grants are not supported for tenant admins")
+	}
+	groups := make(map[string]bool)
+	for idx := range a.Filters.Grants {
+		grant := &a.Filters.Grants[idx]
+		if err := grant.validate(); err != nil {
+			return err
+		}
+		if groups[grant.Group] {
+			return util.NewValidationError(fmt.Sprintf("duplicated permission grant for group %q", grant.Group))
+		}
+		groups[grant.Group] = true
 	}
 	return nil
 }
@@ -386,12 +481,21 @@ func (a *Admin) validate() error {
 	if err := a.validateRecoveryCodes(); err != nil {
 		return err
 	}
+	if err := validateWebAuthnCredentials(a.Filters.WebAuthnCredentials); err != nil {
+		return err
+	}
+	if err := a.Filters.RememberedDevices.validate(); err != nil {
+		return err
+	}
 	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(a.Username) {
 		return util.NewValidationError(fmt.Sprintf("username %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", a.Username))
 	}
 	if err := a.hashPassword(); err != nil {
 		return err
 	}
+	if err := a.validateGrants(); err != nil {
+		return err
+	}
 	if err := a.validatePermissions(); err != nil {
 		return err
 	}
@@ -540,6 +644,42 @@ func (a *Admin) HasPermission(perm string) bool {
 	return util.Contains(a.Permissions, perm)
 }
 
+// HasGrantedPermission returns true if the admin has the specified permission
+// globally or on the users of at least one group
+func (a *Admin) HasGrantedPermission(perm string) bool {
+	if a.HasPermission(perm) {
+		return true
+	}
+	return len(a.GetGrantedGroups(perm)) > 0
+}
+
+// HasPermissionOnGroups returns true if the admin has the specified permission
+// globally or on at least one of the given groups
+func (a *Admin) HasPermissionOnGroups(perm string, groups []sdk.GroupMapping) bool {
+	if a.HasPermission(perm) {
+		return true
+	}
+	granted := a.GetGrantedGroups(perm)
+	for _, g := range groups {
+		if util.Contains(granted, g.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGrantedGroups returns the groups whose users can be managed with the
+// specified permission
+func (a *Admin) GetGrantedGroups(perm string) []string {
+	var result []string
+	for _, grant := range a.Filters.Grants {
+		if util.Contains(grant.Permissions, perm) {
+			result = append(result, grant.Group)
+		}
+	}
+	return result
+}
+
 // GetPermissionsAsString returns permission as string
 func (a *Admin) GetPermissionsAsString() string {
 	return strings.Join(a.Permissions, ", ")
@@ -563,9 +703,14 @@ func (a *Admin) GetValidPerms() []string {
 	return validAdminPerms
 }
 
+// GetValidGrantPerms returns the permissions that can be granted on groups
+func (a *Admin) GetValidGrantPerms() []string {
+	return validAdminGrantPerms
+}
+
 // CanManageMFA returns true if the admin can add a multi-factor authentication configuration
 func (a *Admin) CanManageMFA() bool {
-	return len(mfa.GetAvailableTOTPConfigs()) > 0
+	return len(mfa.GetAvailableTOTPConfigs()) > 0 || mfa.IsWebAuthnEnabled()
 }
 
 // GetSignature returns a signature for this admin.
@@ -595,6 +740,15 @@ func (a *Admin) getACopy() Admin {
 			Used:   code.Used,
 		})
 	}
+	filters.WebAuthnCredentials = copyWebAuthnCredentials(a.Filters.WebAuthnCredentials)
+	filters.RequireWebAuthn = a.Filters.RequireWebAuthn
+	filters.HideFsConfig = a.Filters.HideFsConfig
+	filters.RememberedDevices = a.Filters.RememberedDevices
+	filters.Tenant = a.Filters.Tenant
+	filters.Grants = make([]AdminPermissionGrant, 0, len(a.Filters.Grants))
+	for idx := range a.Filters.Grants {
+		filters.Grants = append(filters.Grants, a.Filters.Grants[idx].getACopy())
+	}
 	filters.Preferences = AdminPreferences{
 		HideUserPageSections:   a.Filters.Preferences.HideUserPageSections,
 		DefaultUsersExpiration: a.Filters.Preferences.DefaultUsersExpiration,
@@ -17,6 +17,8 @@ package dataprovider
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -38,6 +40,43 @@ const (
 	APIKeyScopeUser
 )
 
+// Supported API key access scopes
+const (
+	// only read requests are allowed
+	APIKeyAccessReadOnly = "read_only"
+	// only users, groups and virtual folders related requests are allowed
+	APIKeyAccessUsers = "users"
+	// only events related requests are allowed
+	APIKeyAccessEvents = "events"
+)
+
+var (
+	validAPIKeyAccessScopes = []string{APIKeyAccessReadOnly, APIKeyAccessUsers, APIKeyAccessEvents}
+)
+
+// APIKeyFilters defines additional restrictions for an API key
+type APIKeyFilters struct {
+	// Access scopes restrict the requests allowed using the API key.
+	// Empty means no restrictions other than the permissions of the
+	// associated admin or user
+	AccessScopes []string `json:"access_scopes,omitempty"`
+	// only clients connecting from these IP/Mask are allowed.
+	// IP/Mask must be in CIDR notation as defined in RFC 4632 and RFC 4291
+	// for example "192.0.2.0/24" or "2001:db8::/32"
+	AllowList []string `json:"allow_list,omitempty"`
+}
+
+func (f *APIKeyFilters) getACopy() APIKeyFilters {
+	accessScopes := make([]string, len(f.AccessScopes))
+	copy(accessScopes, f.AccessScopes)
+	allowList := make([]string, len(f.AllowList))
+	copy(allowList, f.AllowList)
+	return APIKeyFilters{
+		AccessScopes: accessScopes,
+		AllowList:    allowList,
+	}
+}
+
 // APIKey defines a SFTPGo API key.
 // API keys can be used as authentication alternative to short lived tokens
 // for REST API
@@ -66,6 +105,8 @@ type APIKey struct {
 	// Admin username associated with this API key.
 	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
 	Admin string `json:"admin,omitempty"`
+	// Additional restrictions
+	Filters APIKeyFilters `json:"filters"`
 	// these fields are for internal use
 	userID   int64
 	adminID  int64
@@ -86,6 +127,7 @@ func (k *APIKey) getACopy() APIKey {
 		Description: k.Description,
 		User:        k.User,
 		Admin:       k.Admin,
+		Filters:     k.Filters.getACopy(),
 		userID:      k.userID,
 		adminID:     k.adminID,
 	}
@@ -139,6 +181,13 @@ func (k *APIKey) generateKey() {
 	k.plainKey = k.Key
 }
 
+// regenerateKey generates a new key, preserving the key identifier
+func (k *APIKey) regenerateKey() error {
+	k.Key = util.GenerateUniqueID()
+	k.plainKey = k.Key
+	return k.hashKey()
+}
+
 // DisplayKey returns the key to show to the user
 func (k *APIKey) DisplayKey() string {
 	return fmt.Sprintf("%v.%v", k.KeyID, k.plainKey)
@@ -164,6 +213,9 @@ func (k *APIKey) validate() error {
 	if k.Scope == APIKeyScopeUser {
 		k.Admin = ""
 	}
+	if err := k.validateFilters(); err != nil {
+		return err
+	}
 	if k.User != "" {
 		_, err := provider.userExists(k.User, "")
 		if err != nil {
@@ -179,6 +231,60 @@ func (k *APIKey) validate() error {
 	return nil
 }
 
+func (k *APIKey) validateFilters() error {
+	k.Filters.AccessScopes = util.RemoveDuplicates(k.Filters.AccessScopes, false)
+	for _, accessScope := range k.Filters.AccessScopes {
+		if !util.Contains(validAPIKeyAccessScopes, accessScope) {
+			return util.NewValidationError(fmt.Sprintf("invalid access scope: %q", accessScope))
+		}
+		if k.Scope == APIKeyScopeUser && accessScope != APIKeyAccessReadOnly {
+			return util.NewValidationError(fmt.Sprintf("access scope %q is not supported for user API keys", accessScope))
+		}
+	}
+	k.Filters.AllowList = util.RemoveDuplicates(k.Filters.AllowList, false)
+	for _, IPMask := range k.Filters.AllowList {
+		_, _, err := net.ParseCIDR(IPMask)
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not parse allow list entry %q : %v", IPMask, err))
+		}
+	}
+	return nil
+}
+
+// IsAllowedFromIP returns true if the API key can be used from the given IP
+func (k *APIKey) IsAllowedFromIP(ip string) bool {
+	if len(k.Filters.AllowList) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, ipMask := range k.Filters.AllowList {
+		_, network, err := net.ParseCIDR(ipMask)
+		if err != nil {
+			continue
+		}
+		if network.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAccessScope returns true if the API key is restricted to the given access scope
+func (k *APIKey) HasAccessScope(accessScope string) bool {
+	return util.Contains(k.Filters.AccessScopes, accessScope)
+}
+
+// IsMethodAllowed returns true if the API key access scopes allow the given HTTP method
+func (k *APIKey) IsMethodAllowed(method string) bool {
+	if !k.HasAccessScope(APIKeyAccessReadOnly) {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 // Authenticate tries to authenticate the provided plain key
 func (k *APIKey) Authenticate(plainKey string) error {
 	if k.ExpiresAt > 0 && k.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
@@ -0,0 +1,483 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Audit log actions, in addition to the provider operations: add, update, delete
+const (
+	AuditActionLogin            = "login"
+	AuditActionLoginFailed      = "login_failed"
+	AuditActionAPIKeyRequest    = "api_key_request"
+	AuditActionSessionRevoke    = "session_revoke"
+	AuditActionTokensRevocation = "tokens_revoke"
+	AuditActionPermissionDenied = "permission_denied"
+)
+
+const (
+	auditLogMaxChangeValueLen = 1024
+)
+
+var (
+	// fields that change too often to be meaningful in the audit log
+	auditLogIgnoredFields = []string{"updated_at", "last_login", "last_use_at", "used_quota_size",
+		"used_quota_files", "used_upload_data_transfer", "used_download_data_transfer", "last_quota_update",
+		"first_download", "first_upload"}
+	auditLogSyslog auditLogSyslogWriter
+	auditLogMu     sync.RWMutex
+)
+
+// auditLogSyslogWriter defines the interface to forward audit log entries to syslog
+type auditLogSyslogWriter interface {
+	Info(m string) error
+	Close() error
+}
+
+// AuditLogSyslogConfig defines the configuration to forward audit log entries to syslog
+type AuditLogSyslogConfig struct {
+	// Set to true to forward the audit log entries to syslog
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Network and address of the syslog server, for example "udp" and "192.168.1.2:514".
+	// Leave both empty to use the local syslog server
+	Network string `json:"network" mapstructure:"network"`
+	Address string `json:"address" mapstructure:"address"`
+}
+
+// AuditLogConfig defines the audit log configuration
+type AuditLogConfig struct {
+	// Set to true to record administrative changes and security relevant
+	// actions in the data provider
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Audit log entries older than the specified number of days are automatically removed.
+	// 0 means no automatic removal
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days"`
+	// Set to true to also record users logins, successful and failed, for all the
+	// supported protocols. Admins logins are always recorded
+	UserLogins bool `json:"user_logins" mapstructure:"user_logins"`
+	// Optional forwarding to syslog
+	Syslog AuditLogSyslogConfig `json:"syslog" mapstructure:"syslog"`
+	// Optional URL where the audit log entries are sent, as JSON, using a POST request
+	WebhookURL string `json:"webhook_url" mapstructure:"webhook_url"`
+}
+
+func (c *AuditLogConfig) initialize() error {
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+
+	if auditLogSyslog != nil {
+		auditLogSyslog.Close() //nolint:errcheck
+		auditLogSyslog = nil
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid audit log retention days: %d", c.RetentionDays)
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid audit log webhook URL %q", c.WebhookURL)
+		}
+	}
+	if c.Syslog.Enabled {
+		w, err := newAuditLogSyslogWriter(c.Syslog.Network, c.Syslog.Address)
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog for the audit log: %w", err)
+		}
+		auditLogSyslog = w
+	}
+	return nil
+}
+
+// AuditLogChange defines a changed field
+type AuditLogChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// AuditLogEntry defines an audit log entry
+type AuditLogEntry struct {
+	ID int64 `json:"id"`
+	// unix timestamp in milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	Action     string `json:"action"`
+	ObjectType string `json:"object_type,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+	// Username is the admin or user that executed the action
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Changes for add, update and delete actions
+	Changes []AuditLogChange `json:"changes,omitempty"`
+	// Additional details, for example the error for failed logins
+	Info string `json:"info,omitempty"`
+}
+
+func (e *AuditLogEntry) getChangesAsJSON() (string, error) {
+	if len(e.Changes) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(e.Changes)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (e *AuditLogEntry) getACopy() AuditLogEntry {
+	changes := make([]AuditLogChange, len(e.Changes))
+	copy(changes, e.Changes)
+
+	return AuditLogEntry{
+		ID:         e.ID,
+		Timestamp:  e.Timestamp,
+		Action:     e.Action,
+		ObjectType: e.ObjectType,
+		ObjectName: e.ObjectName,
+		Username:   e.Username,
+		Role:       e.Role,
+		IP:         e.IP,
+		Protocol:   e.Protocol,
+		APIKeyID:   e.APIKeyID,
+		Changes:    changes,
+		Info:       e.Info,
+	}
+}
+
+// GetChangesAsString returns the changes as a human readable string
+func (e *AuditLogEntry) GetChangesAsString() string {
+	var sb strings.Builder
+	for _, c := range e.Changes {
+		if sb.Len() > 0 {
+			sb.WriteString("; ")
+		}
+		before, _ := json.Marshal(c.Before)
+		after, _ := json.Marshal(c.After)
+		sb.WriteString(fmt.Sprintf("%s: %s -> %s", c.Field, before, after))
+	}
+	return sb.String()
+}
+
+// GetCSVHeader returns the CSV header for audit log entries
+func (e *AuditLogEntry) GetCSVHeader() []string {
+	return []string{"ID", "Time", "Action", "Object type", "Object name", "Username", "Role", "IP", "Protocol",
+		"API key", "Changes", "Info"}
+}
+
+// GetCSVData returns the audit log entry as CSV row
+func (e *AuditLogEntry) GetCSVData() []string {
+	return []string{fmt.Sprintf("%d", e.ID), util.GetTimeFromMsecSinceEpoch(e.Timestamp).UTC().Format(time.RFC3339Nano),
+		e.Action, e.ObjectType, e.ObjectName, e.Username, e.Role, e.IP, e.Protocol, e.APIKeyID,
+		e.GetChangesAsString(), e.Info}
+}
+
+func (e *AuditLogEntry) matches(s *AuditLogSearch) bool {
+	if s.StartTimestamp > 0 && e.Timestamp < s.StartTimestamp {
+		return false
+	}
+	if s.EndTimestamp > 0 && e.Timestamp > s.EndTimestamp {
+		return false
+	}
+	if len(s.Actions) > 0 && !util.Contains(s.Actions, e.Action) {
+		return false
+	}
+	if len(s.ObjectTypes) > 0 && !util.Contains(s.ObjectTypes, e.ObjectType) {
+		return false
+	}
+	if s.ObjectName != "" && s.ObjectName != e.ObjectName {
+		return false
+	}
+	if s.Username != "" && s.Username != e.Username {
+		return false
+	}
+	if s.IP != "" && s.IP != e.IP {
+		return false
+	}
+	if s.Role != "" && s.Role != e.Role {
+		return false
+	}
+	return true
+}
+
+// AuditLogSearch defines the parameters to search audit log entries
+type AuditLogSearch struct {
+	// unix timestamps in milliseconds, 0 means no limit
+	StartTimestamp int64
+	EndTimestamp   int64
+	Actions        []string
+	ObjectTypes    []string
+	ObjectName     string
+	Username       string
+	IP             string
+	Role           string
+	Limit          int
+	Offset         int
+	Order          string
+}
+
+// Validate validates the search parameters
+func (s *AuditLogSearch) Validate() error {
+	if s.Limit <= 0 || s.Limit > 1000 {
+		return util.NewValidationError(fmt.Sprintf("limit is out of the 1-1000 range: %d", s.Limit))
+	}
+	if s.Offset < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid offset: %d", s.Offset))
+	}
+	if len(s.Actions) > 20 || len(s.ObjectTypes) > 20 {
+		return util.NewValidationError("too many actions or object types, max allowed: 20")
+	}
+	if s.Order != OrderASC && s.Order != OrderDESC {
+		return util.NewValidationError(fmt.Sprintf("invalid order %q", s.Order))
+	}
+	return nil
+}
+
+// IsAuditLogEnabled returns true if the audit log is enabled
+func IsAuditLogEnabled() bool {
+	return config.AuditLog.Enabled
+}
+
+// AddAuditLogEntry records the given entry in the audit log, if enabled,
+// and forwards it to the configured destinations
+func AddAuditLogEntry(entry *AuditLogEntry) {
+	if !config.AuditLog.Enabled {
+		return
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	if err := provider.addAuditLogEntry(entry); err != nil {
+		providerLog(logger.LevelError, "unable to add audit log entry, action %q, object %q, username %q: %v",
+			entry.Action, entry.ObjectName, entry.Username, err)
+	}
+	forwardAuditLogEntry(entry)
+}
+
+// SearchAuditLogs returns the audit log entries matching the given search parameters
+func SearchAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	if err := search.Validate(); err != nil {
+		return nil, err
+	}
+	return provider.getAuditLogs(search)
+}
+
+func cleanupAuditLogs() {
+	before := time.Now().Add(-time.Duration(config.AuditLog.RetentionDays) * 24 * time.Hour)
+	if err := provider.cleanupAuditLogs(util.GetTimeAsMsSinceEpoch(before)); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup audit logs: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "audit logs older than %s removed", before)
+	}
+}
+
+func forwardAuditLogEntry(entry *AuditLogEntry) {
+	auditLogMu.RLock()
+	w := auditLogSyslog
+	auditLogMu.RUnlock()
+
+	if w == nil && config.AuditLog.WebhookURL == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to marshal audit log entry: %v", err)
+		return
+	}
+	if w != nil {
+		if err := w.Info(string(data)); err != nil {
+			providerLog(logger.LevelError, "unable to forward audit log entry to syslog: %v", err)
+		}
+	}
+	if config.AuditLog.WebhookURL != "" {
+		go func() {
+			actionsConcurrencyGuard <- struct{}{}
+			defer func() {
+				<-actionsConcurrencyGuard
+			}()
+
+			startTime := time.Now()
+			resp, err := httpclient.RetryablePost(config.AuditLog.WebhookURL, "application/json", bytes.NewBuffer(data))
+			respCode := 0
+			if err == nil {
+				respCode = resp.StatusCode
+				resp.Body.Close()
+			}
+			providerLog(logger.LevelDebug, "audit log entry %q sent to webhook, status code: %d, elapsed: %s err: %v",
+				entry.Action, respCode, time.Since(startTime), err)
+		}()
+	}
+}
+
+// getAuditLogSnapshot returns the current state of the given object, if the audit log is enabled.
+// It must be called before an update so that the changes can be recorded
+func getAuditLogSnapshot(object plugin.Renderer) []byte {
+	if !config.AuditLog.Enabled {
+		return nil
+	}
+	data, err := object.RenderAsJSON(true)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func addObjectAuditLogEntry(operation, executor, ip, objectType, objectName, role string, before []byte,
+	object plugin.Renderer,
+) {
+	if !config.AuditLog.Enabled {
+		return
+	}
+	var after []byte
+	if operation != operationDelete {
+		data, err := object.RenderAsJSON(true)
+		if err == nil {
+			after = data
+		}
+	}
+	changes, err := getAuditLogChanges(before, after)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to compute audit log changes for %s %q: %v", objectType, objectName, err)
+	}
+	if operation == operationUpdate && len(changes) == 0 && err == nil {
+		return
+	}
+	AddAuditLogEntry(&AuditLogEntry{
+		Action:     operation,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Username:   executor,
+		Role:       role,
+		IP:         ip,
+		Changes:    changes,
+	})
+}
+
+func addLoginAuditLogEntry(username, ip, protocol, loginMethod, role string, err error) {
+	entry := &AuditLogEntry{
+		Action:   AuditActionLogin,
+		Username: username,
+		Role:     role,
+		IP:       ip,
+		Protocol: protocol,
+		Info:     loginMethod,
+	}
+	if err != nil {
+		entry.Action = AuditActionLoginFailed
+		entry.Info = fmt.Sprintf("%s: %v", loginMethod, err)
+	}
+	AddAuditLogEntry(entry)
+}
+
+func getAuditLogChanges(before, after []byte) ([]AuditLogChange, error) {
+	beforeFields := make(map[string]any)
+	afterFields := make(map[string]any)
+	if err := flattenAuditLogObject(before, beforeFields); err != nil {
+		return nil, err
+	}
+	if err := flattenAuditLogObject(after, afterFields); err != nil {
+		return nil, err
+	}
+	var changes []AuditLogChange
+	for k, v := range beforeFields {
+		if newValue, ok := afterFields[k]; ok {
+			if !reflect.DeepEqual(v, newValue) {
+				changes = append(changes, AuditLogChange{
+					Field:  k,
+					Before: getAuditLogChangeValue(v),
+					After:  getAuditLogChangeValue(newValue),
+				})
+			}
+			continue
+		}
+		changes = append(changes, AuditLogChange{
+			Field:  k,
+			Before: getAuditLogChangeValue(v),
+		})
+	}
+	for k, v := range afterFields {
+		if _, ok := beforeFields[k]; !ok {
+			changes = append(changes, AuditLogChange{
+				Field: k,
+				After: getAuditLogChangeValue(v),
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+func getAuditLogChangeValue(v any) any {
+	if s, ok := v.(string); ok && len(s) > auditLogMaxChangeValueLen {
+		return s[:auditLogMaxChangeValueLen] + "..."
+	}
+	return v
+}
+
+func flattenAuditLogObject(data []byte, fields map[string]any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	if object == nil {
+		return errors.New("unable to flatten a non object value")
+	}
+	flattenAuditLogMap("", object, fields)
+	return nil
+}
+
+func flattenAuditLogMap(prefix string, object map[string]any, fields map[string]any) {
+	for k, v := range object {
+		if prefix == "" && util.Contains(auditLogIgnoredFields, k) {
+			continue
+		}
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if m, ok := v.(map[string]any); ok {
+			flattenAuditLogMap(key, m, fields)
+			continue
+		}
+		if v == nil || v == "" {
+			continue
+		}
+		fields[key] = v
+	}
+}
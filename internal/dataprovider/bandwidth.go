@@ -0,0 +1,150 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const bandwidthScheduleTimeFormat = "15:04"
+
+// BandwidthSchedule defines bandwidth limits that apply only on the specified
+// days of the week and time of day. UTC time is used
+type BandwidthSchedule struct {
+	// Days of the week, 0 is Sunday. Empty means every day.
+	// For schedules spanning midnight, this is the start day
+	Days []int `json:"days,omitempty"`
+	// Start time in HH:MM format
+	From string `json:"from"`
+	// End time in HH:MM format, excluded. If it is not after the start time
+	// the schedule ends the next day
+	To string `json:"to"`
+	// Maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth,omitempty"`
+	// Maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth,omitempty"`
+}
+
+// HasDay returns true if the specified day of the week is explicitly set
+func (s *BandwidthSchedule) HasDay(day int) bool {
+	return util.Contains(s.Days, day)
+}
+
+// IsActive returns true if the schedule applies at the specified time
+func (s *BandwidthSchedule) IsActive(t time.Time) bool {
+	t = t.UTC()
+	from, err := parseBandwidthScheduleTime(s.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseBandwidthScheduleTime(s.To)
+	if err != nil {
+		return false
+	}
+	return isTimeInDailyWindow(t, s.Days, from, to)
+}
+
+func (s *BandwidthSchedule) getACopy() BandwidthSchedule {
+	days := make([]int, len(s.Days))
+	copy(days, s.Days)
+
+	return BandwidthSchedule{
+		Days:              days,
+		From:              s.From,
+		To:                s.To,
+		UploadBandwidth:   s.UploadBandwidth,
+		DownloadBandwidth: s.DownloadBandwidth,
+	}
+}
+
+func parseBandwidthScheduleTime(val string) (int, error) {
+	t, err := time.Parse(bandwidthScheduleTimeFormat, val)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateBandwidthSchedules(schedules []BandwidthSchedule) error {
+	for idx := range schedules {
+		s := &schedules[idx]
+		if _, err := parseBandwidthScheduleTime(s.From); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid bandwidth schedule start time %q, the HH:MM format is required", s.From))
+		}
+		if _, err := parseBandwidthScheduleTime(s.To); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid bandwidth schedule end time %q, the HH:MM format is required", s.To))
+		}
+		for _, day := range s.Days {
+			if day < 0 || day > 6 {
+				return util.NewValidationError(fmt.Sprintf("invalid bandwidth schedule day %d, allowed values are 0-6", day))
+			}
+		}
+		sort.Ints(s.Days)
+		days := make([]int, 0, len(s.Days))
+		for _, day := range s.Days {
+			if !util.Contains(days, day) {
+				days = append(days, day)
+			}
+		}
+		s.Days = days
+		if s.UploadBandwidth < 0 {
+			s.UploadBandwidth = 0
+		}
+		if s.DownloadBandwidth < 0 {
+			s.DownloadBandwidth = 0
+		}
+	}
+	return nil
+}
+
+func copyBandwidthSchedules(schedules []BandwidthSchedule) []BandwidthSchedule {
+	result := make([]BandwidthSchedule, 0, len(schedules))
+	for idx := range schedules {
+		result = append(result, schedules[idx].getACopy())
+	}
+	return result
+}
+
+// aggregateBandwidth defines the bandwidth caps, as KB/s, shared by all the
+// transfers of the users for whom the group is the primary one
+type aggregateBandwidth struct {
+	group    string
+	upload   int64
+	download int64
+}
+
+// GetScheduledBandwidth returns the upload and download bandwidth, as KB/s, defined
+// by the first bandwidth schedule active at the specified time.
+// The last returned value is false if no schedule is active
+func (u *User) GetScheduledBandwidth(t time.Time) (int64, int64, bool) {
+	for idx := range u.Filters.BandwidthSchedules {
+		schedule := &u.Filters.BandwidthSchedules[idx]
+		if schedule.IsActive(t) {
+			return schedule.UploadBandwidth, schedule.DownloadBandwidth, true
+		}
+	}
+	return 0, 0, false
+}
+
+// GetAggregateBandwidth returns the primary group name and the upload and download
+// bandwidth, as KB/s, shared by all the transfers of the users in that group.
+// 0 means unlimited
+func (u *User) GetAggregateBandwidth() (string, int64, int64) {
+	return u.aggregateBandwidth.group, u.aggregateBandwidth.upload, u.aggregateBandwidth.download
+}
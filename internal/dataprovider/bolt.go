@@ -20,6 +20,7 @@ package dataprovider
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -52,11 +53,18 @@ var (
 	rolesBucket     = []byte("roles")
 	ipListsBucket   = []byte("ip_lists")
 	configsBucket   = []byte("configs")
+	auditLogsBucket = []byte("audit_logs")
+	changesBucket   = []byte("change_events")
+	deletedBucket   = []byte("deleted_objects")
+	templatesBucket = []byte("templates")
+	tenantsBucket   = []byte("tenants")
+	foldersQBucket  = []byte("users_folders_quota")
 	dbVersionBucket = []byte("db_version")
 	dbVersionKey    = []byte("version")
 	configsKey      = []byte("configs")
 	boltBuckets     = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, dbVersionBucket}
+		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, auditLogsBucket,
+		changesBucket, deletedBucket, templatesBucket, tenantsBucket, foldersQBucket, dbVersionBucket}
 )
 
 // BoltProvider defines the auth provider for bolt key/value store
@@ -181,6 +189,31 @@ func (p *BoltProvider) updateAPIKeyLastUse(keyID string) error {
 	})
 }
 
+func (p *BoltProvider) rotateAPIKey(keyID, key string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(keyID)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to rotate", keyID))
+		}
+		var apiKey APIKey
+		err = json.Unmarshal(u, &apiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.Key = key
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(keyID), buf)
+	})
+}
+
 func (p *BoltProvider) setUpdatedAt(username string) {
 	p.dbHandle.Update(func(tx *bolt.Tx) error { //nolint:errcheck
 		bucket, err := p.getUsersBucket(tx)
@@ -777,10 +810,36 @@ func (p *BoltProvider) deleteUser(user User, softDelete bool) error {
 		if err := p.deleteRelatedShares(tx, user.Username); err != nil {
 			return err
 		}
+		if err := p.deleteUsersFoldersQuota(tx, user.Username, ""); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(user.Username))
 	})
 }
 
+func (p *BoltProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	var username string
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			if _, ok := user.GetS3AccessKey(accessKeyID); ok {
+				username = user.Username
+				return nil
+			}
+		}
+		return util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+	})
+	return username, err
+}
+
 func (p *BoltProvider) updateUserPassword(username, password string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
@@ -879,6 +938,9 @@ func (p *BoltProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 					}
 					groupMapping[group.Name] = group
 				}
+				addIncludedGroupsToMapping(groupMapping, func(name string) (Group, error) {
+					return p.groupExistsInternal(name, groupsBucket)
+				})
 				user.applyGroupSettings(groupMapping)
 			}
 			user.SetEmptySecretsIfNil()
@@ -935,6 +997,9 @@ func (p *BoltProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, e
 						}
 						groupMapping[group.Name] = group
 					}
+					addIncludedGroupsToMapping(groupMapping, func(name string) (Group, error) {
+						return p.groupExistsInternal(name, groupsBucket)
+					})
 					user.applyGroupSettings(groupMapping)
 				}
 
@@ -1234,6 +1299,9 @@ func (p *BoltProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
 		if err = p.deleteFolderMappings(folder, usersBucket, groupsBucket); err != nil {
 			return err
 		}
+		if err = p.deleteUsersFoldersQuota(tx, "", folder.Name); err != nil {
+			return err
+		}
 
 		return bucket.Delete([]byte(folder.Name))
 	})
@@ -1279,6 +1347,82 @@ func (p *BoltProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
 }
 
+func (p *BoltProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersFoldersQuotaBucket(tx)
+		if err != nil {
+			return err
+		}
+		if u := tx.Bucket(usersBucket).Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update quota", username))
+		}
+		if f := tx.Bucket(foldersBucket).Get([]byte(folderName)); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", folderName))
+		}
+		key := getUserFolderQuotaKey(username, folderName)
+		quota := userFolderQuota{
+			Username:   username,
+			FolderName: folderName,
+		}
+		if q := bucket.Get(key); q != nil {
+			if err := json.Unmarshal(q, &quota); err != nil {
+				return err
+			}
+		}
+		quota.update(filesAdd, sizeAdd, reset)
+		buf, err := json.Marshal(quota)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *BoltProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	var quota userFolderQuota
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersFoldersQuotaBucket(tx)
+		if err != nil {
+			return err
+		}
+		if q := bucket.Get(getUserFolderQuotaKey(username, folderName)); q != nil {
+			return json.Unmarshal(q, &quota)
+		}
+		return nil
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %q inside folder %q error: %v", username, folderName, err)
+		return 0, 0, err
+	}
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
+// deleteUsersFoldersQuota removes the per-user folder quota usage for the specified
+// username or folder name
+func (p *BoltProvider) deleteUsersFoldersQuota(tx *bolt.Tx, username, folderName string) error {
+	bucket, err := p.getUsersFoldersQuotaBucket(tx)
+	if err != nil {
+		return err
+	}
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var quota userFolderQuota
+		if err := json.Unmarshal(v, &quota); err != nil {
+			return err
+		}
+		if (username != "" && quota.Username == username) || (folderName != "" && quota.FolderName == folderName) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *BoltProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
 	groups := make([]Group, 0, limit)
 	var err error
@@ -2065,6 +2209,10 @@ func (p *BoltProvider) getSharedSession(key string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *BoltProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *BoltProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return ErrNotImplemented
 }
@@ -2754,6 +2902,321 @@ func (p *BoltProvider) dumpRoles() ([]Role, error) {
 	return roles, err
 }
 
+func (p *BoltProvider) templateExists(name string) (Template, error) {
+	var template Template
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		t := bucket.Get([]byte(name))
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", name))
+		}
+		return json.Unmarshal(t, &template)
+	})
+	return template, err
+}
+
+func (p *BoltProvider) addTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(template.Name)); t != nil {
+			return fmt.Errorf("template %q already exists", template.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		template.ID = int64(id)
+		template.Version = 1
+		template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(template.Name), buf)
+	})
+}
+
+func (p *BoltProvider) updateTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		var t []byte
+		if t = bucket.Get([]byte(template.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		var oldTemplate Template
+		err = json.Unmarshal(t, &oldTemplate)
+		if err != nil {
+			return err
+		}
+		template.ID = oldTemplate.ID
+		template.Type = oldTemplate.Type
+		template.Version = oldTemplate.Version + 1
+		template.CreatedAt = oldTemplate.CreatedAt
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(template.Name), buf)
+	})
+}
+
+func (p *BoltProvider) deleteTemplate(template Template) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(template.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		return bucket.Delete([]byte(template.Name))
+	})
+}
+
+func (p *BoltProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	templates := make([]Template, 0, limit)
+	if limit <= 0 {
+		return templates, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template Template
+				err = json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template Template
+				err = json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (p *BoltProvider) dumpTemplates() ([]Template, error) {
+	templates := make([]Template, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var template Template
+			err = json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, template)
+		}
+		return err
+	})
+	return templates, err
+}
+
+func (p *BoltProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		t := bucket.Get([]byte(name))
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return json.Unmarshal(t, &tenant)
+	})
+	return tenant, err
+}
+
+func (p *BoltProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(tenant.Name)); t != nil {
+			return fmt.Errorf("tenant %q already exists", tenant.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		tenant.ID = int64(id)
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(tenant.Name), buf)
+	})
+}
+
+func (p *BoltProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var t []byte
+		if t = bucket.Get([]byte(tenant.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		var oldTenant Tenant
+		err = json.Unmarshal(t, &oldTenant)
+		if err != nil {
+			return err
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(tenant.Name), buf)
+	})
+}
+
+func (p *BoltProvider) deleteTenant(tenant Tenant) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(tenant.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		return bucket.Delete([]byte(tenant.Name))
+	})
+}
+
+func (p *BoltProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err = json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err = json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return tenants, err
+}
+
+func (p *BoltProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var tenant Tenant
+			err = json.Unmarshal(v, &tenant)
+			if err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+		}
+		return err
+	})
+	return tenants, err
+}
+
 func (p *BoltProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	entry := IPListEntry{
 		IPOrNet: ipOrNet,
@@ -3012,6 +3475,248 @@ func (p *BoltProvider) setConfigs(configs *Configs) error {
 	})
 }
 
+func (p *BoltProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditLogsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find audit logs bucket")
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = int64(id)
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltItob(id), buf)
+	})
+}
+
+func (p *BoltProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, search.Limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditLogsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find audit logs bucket")
+		}
+		itNum := 0
+		cursor := bucket.Cursor()
+		appendEntry := func(v []byte) (bool, error) {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return false, err
+			}
+			if !entry.matches(search) {
+				return false, nil
+			}
+			itNum++
+			if itNum <= search.Offset {
+				return false, nil
+			}
+			entries = append(entries, entry)
+			return len(entries) >= search.Limit, nil
+		}
+		if search.Order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				done, err := appendEntry(v)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				done, err := appendEntry(v)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *BoltProvider) cleanupAuditLogs(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditLogsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find audit logs bucket")
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) addChangeEvent(event *ChangeEvent) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find change events bucket")
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.ID = int64(id)
+		buf, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltItob(id), buf)
+	})
+}
+
+func (p *BoltProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	events := make([]ChangeEvent, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find change events bucket")
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(boltItob(uint64(after + 1))); k != nil && len(events) < limit; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *BoltProvider) cleanupChangeEvents(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find change events bucket")
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if event.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) addDeletedObject(object *DeletedObject) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deletedBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find deleted objects bucket")
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		object.ID = int64(id)
+		buf, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltItob(id), buf)
+	})
+}
+
+func (p *BoltProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	objects := make([]DeletedObject, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deletedBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find deleted objects bucket")
+		}
+		itNum := 0
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(objects) < limit; k, v = cursor.Prev() {
+			var object DeletedObject
+			if err := json.Unmarshal(v, &object); err != nil {
+				return err
+			}
+			if objectType != "" && object.ObjectType != objectType {
+				continue
+			}
+			if before > 0 && object.DeletedAt >= before {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			objects = append(objects, object)
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (p *BoltProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	var object DeletedObject
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deletedBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find deleted objects bucket")
+		}
+		v := bucket.Get(boltItob(uint64(id)))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+		}
+		return json.Unmarshal(v, &object)
+	})
+	return object, err
+}
+
+func (p *BoltProvider) removeDeletedObject(id int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deletedBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find deleted objects bucket")
+		}
+		key := boltItob(uint64(id))
+		if bucket.Get(key) == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+		}
+		return bucket.Delete(key)
+	})
+}
+
 func (p *BoltProvider) setFirstDownloadTimestamp(username string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
@@ -3904,6 +4609,33 @@ func (p *BoltProvider) getRolesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getTemplatesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(templatesBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find templates bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func (p *BoltProvider) getTenantsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(tenantsBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find tenants bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func (p *BoltProvider) getUsersFoldersQuotaBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(foldersQBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find users folders quota bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getIPListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 	bucket := tx.Bucket(rolesBucket)
@@ -3976,3 +4708,9 @@ func updateBoltDatabaseVersion(dbHandle *bolt.DB, version int) error {
 	})
 	return err
 }
+
+func boltItob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -18,8 +18,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/drakkan/webdav"
-
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -47,7 +45,6 @@ type CachedUser struct {
 	User       User
 	Expiration time.Time
 	Password   string
-	LockSystem webdav.LockSystem
 }
 
 // IsExpired returns true if the cached user is expired
@@ -0,0 +1,331 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// MaxChangeEventsLimit defines the maximum number of change events returned in a single request
+	MaxChangeEventsLimit         = 1000
+	changeEventsWebhookBatchSize = 100
+	changeEventsCursorSessionKey = "change_events_webhook_cursor"
+	// other instances cannot notify us about their changes, so for shared
+	// providers the waiting requests check for new events periodically
+	changeEventsSharedPollInterval = 2 * time.Second
+)
+
+var (
+	// the object types included in the change events stream
+	changeEventsObjectTypes = []string{actionObjectUser, actionObjectGroup, actionObjectFolder, actionObjectShare}
+	// additions are serialized, so the assigned IDs follow the commit order
+	changeEventsMu sync.Mutex
+	// cursor for the webhook deliveries, used if it cannot be persisted in the data provider
+	changeEventsWebhookCursor atomic.Int64
+	changeEventsDelivering    atomic.Bool
+	changeEventsSignal        = newChangeEventsNotifier()
+)
+
+// ChangeEventsConfig defines the configuration for the change data capture stream
+type ChangeEventsConfig struct {
+	// Set to true to record the changes to users, groups, folders and shares
+	// in the data provider as an ordered stream of events
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Change events older than the specified number of days are automatically removed.
+	// 0 means no automatic removal
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days"`
+	// Optional URL where the change events are sent, in batches, using a POST request.
+	// Each batch is retried until it is acknowledged with a 2xx status code
+	WebhookURL string `json:"webhook_url" mapstructure:"webhook_url"`
+}
+
+func (c *ChangeEventsConfig) initialize() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid change events retention days: %d", c.RetentionDays)
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid change events webhook URL %q", c.WebhookURL)
+		}
+	}
+	return nil
+}
+
+// ChangeEvent defines a change to a user, group, folder or share.
+// The ID is increasing and can be used as cursor to resume reading the stream
+type ChangeEvent struct {
+	ID int64 `json:"id"`
+	// unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// add, update or delete
+	Action     string `json:"action"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// the object after the change, sensitive data are hidden.
+	// For delete actions this is the deleted object
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+func (e *ChangeEvent) getACopy() ChangeEvent {
+	object := make(json.RawMessage, len(e.Object))
+	copy(object, e.Object)
+
+	return ChangeEvent{
+		ID:         e.ID,
+		Timestamp:  e.Timestamp,
+		Action:     e.Action,
+		ObjectType: e.ObjectType,
+		ObjectName: e.ObjectName,
+		Object:     object,
+	}
+}
+
+// ChangeEventsBatch defines a batch of change events
+type ChangeEventsBatch struct {
+	Events []ChangeEvent `json:"events"`
+	// Cursor is the ID of the last event in the batch or the requested
+	// cursor if there are no events. Use it to request the next batch
+	Cursor int64 `json:"cursor"`
+}
+
+type changeEventsNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newChangeEventsNotifier() *changeEventsNotifier {
+	return &changeEventsNotifier{
+		ch: make(chan struct{}),
+	}
+}
+
+// wait returns a channel closed when a new event is added
+func (n *changeEventsNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.ch
+}
+
+func (n *changeEventsNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+// IsChangeEventsEnabled returns true if the change events stream is enabled
+func IsChangeEventsEnabled() bool {
+	return config.ChangeEvents.Enabled
+}
+
+// GetChangeEvents returns up to limit change events recorded after the specified cursor.
+// If no events are available, it waits up to the specified duration for new ones
+func GetChangeEvents(ctx context.Context, cursor int64, limit int, wait time.Duration) (ChangeEventsBatch, error) {
+	batch := ChangeEventsBatch{
+		Events: []ChangeEvent{},
+		Cursor: cursor,
+	}
+	if limit <= 0 || limit > MaxChangeEventsLimit {
+		return batch, util.NewValidationError(fmt.Sprintf("limit is out of the 1-%d range: %d", MaxChangeEventsLimit, limit))
+	}
+	if cursor < 0 {
+		return batch, util.NewValidationError(fmt.Sprintf("invalid cursor: %d", cursor))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// get the channel before reading, so events added after the read are not missed
+		newEvents := changeEventsSignal.wait()
+		events, err := provider.getChangeEvents(cursor, limit)
+		if err != nil {
+			return batch, err
+		}
+		if len(events) > 0 {
+			batch.Events = events
+			batch.Cursor = events[len(events)-1].ID
+			return batch, nil
+		}
+		var poll <-chan time.Time
+		if config.IsShared == 1 {
+			poll = time.After(changeEventsSharedPollInterval)
+		}
+		select {
+		case <-newEvents:
+		case <-poll:
+		case <-timer.C:
+			return batch, nil
+		case <-ctx.Done():
+			return batch, nil
+		}
+	}
+}
+
+func addChangeEvent(operation, objectType, objectName string, object plugin.Renderer) {
+	if !config.ChangeEvents.Enabled || !util.Contains(changeEventsObjectTypes, objectType) {
+		return
+	}
+	if operation == operationDisable {
+		operation = operationUpdate
+	}
+	data, err := object.RenderAsJSON(operation != operationDelete)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to render %s %q for the change event: %v", objectType, objectName, err)
+		return
+	}
+	event := &ChangeEvent{
+		Timestamp:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		Action:     operation,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Object:     data,
+	}
+	changeEventsMu.Lock()
+	err = provider.addChangeEvent(event)
+	changeEventsMu.Unlock()
+
+	if err != nil {
+		providerLog(logger.LevelError, "unable to add change event, action %q, object type %q, object name %q: %v",
+			operation, objectType, objectName, err)
+		return
+	}
+	changeEventsSignal.notify()
+	if config.ChangeEvents.WebhookURL != "" {
+		go deliverChangeEvents()
+	}
+}
+
+func cleanupChangeEvents() {
+	before := time.Now().Add(-time.Duration(config.ChangeEvents.RetentionDays) * 24 * time.Hour)
+	if err := provider.cleanupChangeEvents(util.GetTimeAsMsSinceEpoch(before)); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup change events: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "change events older than %s removed", before)
+	}
+}
+
+// deliverChangeEvents sends the change events not yet acknowledged to the
+// configured webhook. A failed batch is sent again on the next run, so each
+// event is delivered at least once
+func deliverChangeEvents() {
+	if !changeEventsDelivering.CompareAndSwap(false, true) {
+		return
+	}
+	defer changeEventsDelivering.Store(false)
+
+	cursor := getChangeEventsWebhookCursor()
+	for {
+		events, err := provider.getChangeEvents(cursor, changeEventsWebhookBatchSize)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get change events to deliver after cursor %d: %v", cursor, err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+		batch := ChangeEventsBatch{
+			Events: events,
+			Cursor: events[len(events)-1].ID,
+		}
+		if err := postChangeEvents(&batch); err != nil {
+			providerLog(logger.LevelError, "unable to deliver change events after cursor %d: %v", cursor, err)
+			return
+		}
+		cursor = batch.Cursor
+		setChangeEventsWebhookCursor(cursor)
+		if len(events) < changeEventsWebhookBatchSize {
+			return
+		}
+	}
+}
+
+func postChangeEvents(batch *ChangeEventsBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	resp, err := httpclient.RetryablePost(config.ChangeEvents.WebhookURL, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	providerLog(logger.LevelDebug, "%d change events sent to webhook, cursor: %d, status code: %d, elapsed: %s",
+		len(batch.Events), batch.Cursor, resp.StatusCode, time.Since(startTime))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// getChangeEventsWebhookCursor returns the cursor persisted in the data provider,
+// if supported, so the deliveries resume after a restart and are shared among
+// the instances using the same data provider
+func getChangeEventsWebhookCursor() int64 {
+	session, err := provider.getSharedSession(changeEventsCursorSessionKey)
+	if err != nil || session.Type != SessionTypeChangeEventsCursor {
+		return changeEventsWebhookCursor.Load()
+	}
+	var data []byte
+	switch v := session.Data.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	}
+	var cursor map[string]int64
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		providerLog(logger.LevelError, "unable to decode the change events webhook cursor: %v", err)
+		return changeEventsWebhookCursor.Load()
+	}
+	if cursor["cursor"] > changeEventsWebhookCursor.Load() {
+		return cursor["cursor"]
+	}
+	return changeEventsWebhookCursor.Load()
+}
+
+func setChangeEventsWebhookCursor(cursor int64) {
+	changeEventsWebhookCursor.Store(cursor)
+	err := provider.addSharedSession(Session{
+		Key:       changeEventsCursorSessionKey,
+		Data:      map[string]int64{"cursor": cursor},
+		Type:      SessionTypeChangeEventsCursor,
+		Timestamp: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	if err != nil && !errors.Is(err, ErrNotImplemented) {
+		providerLog(logger.LevelError, "unable to save the change events webhook cursor %d: %v", cursor, err)
+	}
+}
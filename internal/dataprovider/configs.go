@@ -17,6 +17,8 @@ package dataprovider
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -42,6 +44,21 @@ var (
 		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-512",
 		"hmac-sha1", "hmac-sha1-96",
 	}
+	// algorithms enabled by default in the SFTP service, together with the
+	// legacy ones above they are the allowed values for per-user restrictions
+	defaultKexAlgos = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+	}
+	defaultCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	defaultMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
+	}
 )
 
 // SFTPDConfigs defines configurations for SFTPD
@@ -278,13 +295,215 @@ func (c *ACMEConfigs) getACopy() *ACMEConfigs {
 	}
 }
 
+// Supported protocols for remote endpoints
+const (
+	RemoteEndpointProtocolSFTP = iota + 1
+	// FTP with explicit TLS, the connection is upgraded using AUTH TLS
+	RemoteEndpointProtocolFTPS
+	// FTP with implicit TLS, the connection is encrypted from the start
+	RemoteEndpointProtocolFTPSImplicit
+)
+
+var (
+	supportedRemoteEndpointProtocols = []int{RemoteEndpointProtocolSFTP, RemoteEndpointProtocolFTPS,
+		RemoteEndpointProtocolFTPSImplicit}
+	// RemoteEndpointProtocols defines the supported protocols for remote endpoints
+	RemoteEndpointProtocols []EnumMapping
+)
+
+func init() {
+	for _, p := range supportedRemoteEndpointProtocols {
+		RemoteEndpointProtocols = append(RemoteEndpointProtocols, EnumMapping{
+			Value: p,
+			Name:  getRemoteEndpointProtocolAsString(p),
+		})
+	}
+}
+
+func getRemoteEndpointProtocolAsString(value int) string {
+	switch value {
+	case RemoteEndpointProtocolFTPS:
+		return "FTPS explicit"
+	case RemoteEndpointProtocolFTPSImplicit:
+		return "FTPS implicit"
+	default:
+		return "SFTP"
+	}
+}
+
+// RemoteEndpoint defines a remote SFTP or FTPS server. Remote endpoints are
+// reusable, they can be referenced by name, for example in push event actions
+type RemoteEndpoint struct {
+	// Unique name
+	Name string `json:"name"`
+	// Protocol, see the above enum
+	Protocol int `json:"protocol"`
+	// Remote server address as host:port
+	Endpoint string      `json:"endpoint"`
+	Username string      `json:"username"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// Private key for public key authentication, SFTP only
+	PrivateKey *kms.Secret `json:"private_key,omitempty"`
+	// Pinned fingerprints. For SFTP the SHA256 fingerprints of the accepted
+	// host keys, they are required. For FTPS the SHA256 fingerprints, hex
+	// encoded, of the accepted server certificates, if empty the certificate
+	// is verified using the system root CAs
+	Fingerprints []string `json:"fingerprints,omitempty"`
+}
+
+// GetProtocolAsString returns the protocol as string
+func (e *RemoteEndpoint) GetProtocolAsString() string {
+	return getRemoteEndpointProtocolAsString(e.Protocol)
+}
+
+// GetFingerprintsAsString returns the fingerprints as newline separated string
+func (e *RemoteEndpoint) GetFingerprintsAsString() string {
+	return strings.Join(e.Fingerprints, "\n")
+}
+
+// IsFTPS returns true if the endpoint uses FTP over TLS
+func (e *RemoteEndpoint) IsFTPS() bool {
+	return e.Protocol == RemoteEndpointProtocolFTPS || e.Protocol == RemoteEndpointProtocolFTPSImplicit
+}
+
+func (e *RemoteEndpoint) validateSecret(secret *kms.Secret, name string) error {
+	if secret.IsRedacted() {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: cannot save a redacted %s", e.Name, name))
+	}
+	if secret.IsEncrypted() && !secret.IsValid() {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid encrypted %s", e.Name, name))
+	}
+	if !secret.IsEmpty() && !secret.IsValidInput() {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid %s", e.Name, name))
+	}
+	if secret.IsPlain() {
+		// the name is not used as additional data so an endpoint can be renamed
+		secret.SetAdditionalData("remote_endpoint")
+		if err := secret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("remote endpoint %q: could not encrypt %s: %v", e.Name, name, err))
+		}
+	}
+	return nil
+}
+
+func (e *RemoteEndpoint) validateFingerprints() error {
+	fingerprints := make([]string, 0, len(e.Fingerprints))
+	for _, fp := range e.Fingerprints {
+		fp = strings.TrimSpace(fp)
+		if fp == "" {
+			continue
+		}
+		if e.IsFTPS() {
+			fp = strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+			if len(fp) != 64 || strings.Trim(fp, "0123456789abcdef") != "" {
+				return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid certificate fingerprint %q",
+					e.Name, fp))
+			}
+		} else if !strings.HasPrefix(fp, "SHA256:") {
+			return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid host key fingerprint %q, only SHA256 fingerprints are supported",
+				e.Name, fp))
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	e.Fingerprints = util.RemoveDuplicates(fingerprints, false)
+	if e.Protocol == RemoteEndpointProtocolSFTP && len(e.Fingerprints) == 0 {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: at least a host key fingerprint is required", e.Name))
+	}
+	return nil
+}
+
+func (e *RemoteEndpoint) validate() error {
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" {
+		return util.NewValidationError("remote endpoint: name is mandatory")
+	}
+	if !util.Contains(supportedRemoteEndpointProtocols, e.Protocol) {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid protocol %d", e.Name, e.Protocol))
+	}
+	e.Endpoint = strings.TrimSpace(e.Endpoint)
+	host, port, err := net.SplitHostPort(e.Endpoint)
+	if err != nil || host == "" {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid address %q, host:port is required",
+			e.Name, e.Endpoint))
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: invalid port %q", e.Name, port))
+	}
+	if e.Username == "" {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: username is mandatory", e.Name))
+	}
+	e.setEmptySecretsIfNil()
+	if e.IsFTPS() && !e.PrivateKey.IsEmpty() {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: private keys are only supported for SFTP", e.Name))
+	}
+	if e.Password.IsEmpty() && e.PrivateKey.IsEmpty() {
+		return util.NewValidationError(fmt.Sprintf("remote endpoint %q: credentials are required", e.Name))
+	}
+	if err := e.validateSecret(e.Password, "password"); err != nil {
+		return err
+	}
+	if err := e.validateSecret(e.PrivateKey, "private key"); err != nil {
+		return err
+	}
+	return e.validateFingerprints()
+}
+
+func (e *RemoteEndpoint) setEmptySecretsIfNil() {
+	if e.Password == nil {
+		e.Password = kms.NewEmptySecret()
+	}
+	if e.PrivateKey == nil {
+		e.PrivateKey = kms.NewEmptySecret()
+	}
+}
+
+func (e *RemoteEndpoint) hideConfidentialData() {
+	if e.Password != nil {
+		e.Password.Hide()
+		if e.Password.IsEmpty() {
+			e.Password = nil
+		}
+	}
+	if e.PrivateKey != nil {
+		e.PrivateKey.Hide()
+		if e.PrivateKey.IsEmpty() {
+			e.PrivateKey = nil
+		}
+	}
+}
+
+func (e *RemoteEndpoint) getACopy() RemoteEndpoint {
+	var password, privateKey *kms.Secret
+	if e.Password != nil {
+		password = e.Password.Clone()
+	}
+	if e.PrivateKey != nil {
+		privateKey = e.PrivateKey.Clone()
+	}
+	fingerprints := make([]string, len(e.Fingerprints))
+	copy(fingerprints, e.Fingerprints)
+
+	return RemoteEndpoint{
+		Name:         e.Name,
+		Protocol:     e.Protocol,
+		Endpoint:     e.Endpoint,
+		Username:     e.Username,
+		Password:     password,
+		PrivateKey:   privateKey,
+		Fingerprints: fingerprints,
+	}
+}
+
 // Configs allows to set configuration keys disabled by default without
 // modifying the config file or setting env vars
 type Configs struct {
-	SFTPD     *SFTPDConfigs `json:"sftpd,omitempty"`
-	SMTP      *SMTPConfigs  `json:"smtp,omitempty"`
-	ACME      *ACMEConfigs  `json:"acme,omitempty"`
-	UpdatedAt int64         `json:"updated_at,omitempty"`
+	SFTPD           *SFTPDConfigs    `json:"sftpd,omitempty"`
+	SMTP            *SMTPConfigs     `json:"smtp,omitempty"`
+	ACME            *ACMEConfigs     `json:"acme,omitempty"`
+	RemoteEndpoints []RemoteEndpoint `json:"remote_endpoints,omitempty"`
+	OIDCMappings    []OIDCMapping    `json:"oidc_mappings,omitempty"`
+	Backups         *BackupConfigs   `json:"backups,omitempty"`
+	UpdatedAt       int64            `json:"updated_at,omitempty"`
 }
 
 func (c *Configs) validate() error {
@@ -303,7 +522,35 @@ func (c *Configs) validate() error {
 			return err
 		}
 	}
-	return nil
+	names := make(map[string]bool)
+	for idx := range c.RemoteEndpoints {
+		endpoint := &c.RemoteEndpoints[idx]
+		if err := endpoint.validate(); err != nil {
+			return err
+		}
+		if names[endpoint.Name] {
+			return util.NewValidationError(fmt.Sprintf("remote endpoint %q is duplicated", endpoint.Name))
+		}
+		names[endpoint.Name] = true
+	}
+	if c.Backups != nil {
+		if err := c.Backups.validate(); err != nil {
+			return err
+		}
+	}
+	return validateOIDCMappings(c.OIDCMappings)
+}
+
+// GetRemoteEndpoint returns the remote endpoint with the specified name
+func (c *Configs) GetRemoteEndpoint(name string) (RemoteEndpoint, error) {
+	for idx := range c.RemoteEndpoints {
+		if c.RemoteEndpoints[idx].Name == name {
+			endpoint := c.RemoteEndpoints[idx].getACopy()
+			endpoint.setEmptySecretsIfNil()
+			return endpoint, nil
+		}
+	}
+	return RemoteEndpoint{}, util.NewRecordNotFoundError(fmt.Sprintf("remote endpoint %q does not exist", name))
 }
 
 // PrepareForRendering prepares configs for rendering.
@@ -325,6 +572,16 @@ func (c *Configs) PrepareForRendering() {
 			c.SMTP.Password = nil
 		}
 	}
+	for idx := range c.RemoteEndpoints {
+		c.RemoteEndpoints[idx].hideConfidentialData()
+	}
+	if c.Backups != nil {
+		if c.Backups.isEmpty() {
+			c.Backups = nil
+		} else {
+			c.Backups.HideConfidentialData()
+		}
+	}
 }
 
 // SetNilsToEmpty sets nil fields to empty
@@ -341,6 +598,9 @@ func (c *Configs) SetNilsToEmpty() {
 	if c.ACME == nil {
 		c.ACME = &ACMEConfigs{}
 	}
+	for idx := range c.RemoteEndpoints {
+		c.RemoteEndpoints[idx].setEmptySecretsIfNil()
+	}
 }
 
 // RenderAsJSON implements the renderer interface used within plugins
@@ -369,6 +629,15 @@ func (c *Configs) getACopy() Configs {
 	if c.ACME != nil {
 		result.ACME = c.ACME.getACopy()
 	}
+	for idx := range c.RemoteEndpoints {
+		result.RemoteEndpoints = append(result.RemoteEndpoints, c.RemoteEndpoints[idx].getACopy())
+	}
+	for idx := range c.OIDCMappings {
+		result.OIDCMappings = append(result.OIDCMappings, c.OIDCMappings[idx].getACopy())
+	}
+	if c.Backups != nil {
+		result.Backups = c.Backups.getACopy()
+	}
 	result.UpdatedAt = c.UpdatedAt
 	return result
 }
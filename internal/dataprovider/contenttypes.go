@@ -0,0 +1,138 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ContentTypesFilter defines the allowed and denied content types for the
+// files uploaded to a virtual path. The content type is detected from the
+// first bytes of the uploaded data, the file extension is not considered
+type ContentTypesFilter struct {
+	// Virtual path, if no other specific filter is defined, the filter
+	// applies to sub directories too
+	Path string `json:"path"`
+	// Allowed content types, for example "image/png" or "image/*".
+	// Empty means any content type not explicitly denied
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+	// Denied content types, they are evaluated before the allowed ones
+	DeniedTypes []string `json:"denied_types,omitempty"`
+}
+
+// IsAllowed returns true if the specified content type is allowed
+func (f *ContentTypesFilter) IsAllowed(contentType string) bool {
+	for _, t := range f.DeniedTypes {
+		if matchContentType(t, contentType) {
+			return false
+		}
+	}
+	if len(f.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range f.AllowedTypes {
+		if matchContentType(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ContentTypesFilter) getACopy() ContentTypesFilter {
+	allowed := make([]string, len(f.AllowedTypes))
+	copy(allowed, f.AllowedTypes)
+	denied := make([]string, len(f.DeniedTypes))
+	copy(denied, f.DeniedTypes)
+
+	return ContentTypesFilter{
+		Path:         f.Path,
+		AllowedTypes: allowed,
+		DeniedTypes:  denied,
+	}
+}
+
+// matchContentType returns true if the content type matches the specified
+// entry. An entry can use "*" as subtype to match all the subtypes
+func matchContentType(entry, contentType string) bool {
+	if strings.HasSuffix(entry, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(entry, "*"))
+	}
+	return entry == contentType
+}
+
+func isValidContentTypeEntry(entry string) bool {
+	mainType, subType, ok := strings.Cut(entry, "/")
+	if !ok || mainType == "" || subType == "" || mainType == "*" {
+		return false
+	}
+	return !strings.ContainsAny(entry, " ;,") && strings.Count(entry, "/") == 1 &&
+		(subType == "*" || !strings.Contains(subType, "*"))
+}
+
+func cleanContentTypeEntries(filterPath string, entries []string) ([]string, error) {
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if !isValidContentTypeEntry(entry) {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid content type %q for path %q", entry, filterPath))
+		}
+		result = append(result, entry)
+	}
+	return util.RemoveDuplicates(result, false), nil
+}
+
+func validateContentTypesFilters(user *User) error {
+	var filteredPaths []string
+	filters := make([]ContentTypesFilter, 0, len(user.Filters.ContentTypes))
+	for _, f := range user.Filters.ContentTypes {
+		cleanedPath := filepath.ToSlash(path.Clean(f.Path))
+		if !path.IsAbs(cleanedPath) {
+			return util.NewValidationError(fmt.Sprintf("invalid path %q for content types filter", f.Path))
+		}
+		if util.Contains(filteredPaths, cleanedPath) {
+			return util.NewValidationError(fmt.Sprintf("duplicate content types filter for path %q", f.Path))
+		}
+		if len(f.AllowedTypes) == 0 && len(f.DeniedTypes) == 0 {
+			return util.NewValidationError(fmt.Sprintf("empty content types filter for path %q", f.Path))
+		}
+		var err error
+		f.Path = cleanedPath
+		f.AllowedTypes, err = cleanContentTypeEntries(cleanedPath, f.AllowedTypes)
+		if err != nil {
+			return err
+		}
+		f.DeniedTypes, err = cleanContentTypeEntries(cleanedPath, f.DeniedTypes)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, f)
+		filteredPaths = append(filteredPaths, cleanedPath)
+	}
+	user.Filters.ContentTypes = filters
+	return nil
+}
+
+func copyContentTypesFilters(filters []ContentTypesFilter) []ContentTypesFilter {
+	result := make([]ContentTypesFilter, 0, len(filters))
+	for idx := range filters {
+		result = append(result, filters[idx].getACopy())
+	}
+	return result
+}
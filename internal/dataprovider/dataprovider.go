@@ -64,10 +64,13 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/ldap"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -85,6 +88,10 @@ const (
 	MemoryDataProviderName = "memory"
 	// CockroachDataProviderName defines the for CockroachDB provider
 	CockroachDataProviderName = "cockroachdb"
+	// MongoDBDataProviderName defines the name for MongoDB provider
+	MongoDBDataProviderName = "mongodb"
+	// EtcdDataProviderName defines the name for etcd provider
+	EtcdDataProviderName = "etcd"
 	// DumpVersion defines the version for the dump.
 	// For restore/load we support the current version and the previous one
 	DumpVersion = 16
@@ -104,6 +111,7 @@ const (
 	operationAdd              = "add"
 	operationUpdate           = "update"
 	operationDelete           = "delete"
+	operationDisable          = "disable"
 	sqlPrefixValidChars       = "abcdefghijklmnopqrstuvwxyz_0123456789"
 	maxHookResponseSize       = 1048576 // 1MB
 	iso8601UTCFormat          = "2006-01-02T15:04:05Z"
@@ -127,12 +135,17 @@ const (
 	protocolFTP    = "FTP"
 	protocolWebDAV = "DAV"
 	protocolHTTP   = "HTTP"
+	protocolS3     = "S3"
+	protocolTFTP   = "TFTP"
+	protocolGRPC   = "GRPC"
+	protocolSMTP   = "SMTP"
 )
 
 var (
 	// SupportedProviders defines the supported data providers
 	SupportedProviders = []string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
-		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName}
+		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName, MongoDBDataProviderName,
+		EtcdDataProviderName}
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename,
 		PermRenameFiles, PermRenameDirs, PermDelete, PermDeleteFiles, PermDeleteDirs, PermCreateSymlinks, PermChmod,
@@ -140,7 +153,7 @@ var (
 	// ValidLoginMethods defines all the valid login methods
 	ValidLoginMethods = []string{SSHLoginMethodPublicKey, LoginMethodPassword, SSHLoginMethodPassword,
 		SSHLoginMethodKeyboardInteractive, SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt,
-		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd}
+		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd, LoginMethodKerberos}
 	// SSHMultiStepsLoginMethods defines the supported Multi-Step Authentications
 	SSHMultiStepsLoginMethods = []string{SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
 	// ErrNoAuthTryed defines the error for connection closed before authentication
@@ -148,7 +161,8 @@ var (
 	// ErrNotImplemented defines the error for features not supported for a particular data provider
 	ErrNotImplemented = errors.New("feature not supported with the configured data provider")
 	// ValidProtocols defines all the valid protcols
-	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP}
+	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP, protocolS3, protocolTFTP, protocolGRPC,
+		protocolSMTP}
 	// MFAProtocols defines the supported protocols for multi-factor authentication
 	MFAProtocols = []string{protocolHTTP, protocolSSH, protocolFTP}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
@@ -169,7 +183,7 @@ var (
 	pbkdfPwdPrefixes             = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes      = []string{pbkdf2SHA256B64SaltPrefix}
 	unixPwdPrefixes              = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix}
-	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
+	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName, MongoDBDataProviderName, EtcdDataProviderName}
 	logSender                    = "dataprovider"
 	sqlTableUsers                string
 	sqlTableFolders              string
@@ -193,10 +207,17 @@ var (
 	sqlTableRoles                string
 	sqlTableIPLists              string
 	sqlTableConfigs              string
+	sqlTableAuditLogs            string
+	sqlTableChangeEvents         string
+	sqlTableDeletedObjects       string
+	sqlTableTemplates            string
+	sqlTableTenants              string
+	sqlTableUsersFoldersQuota    string
 	sqlTableSchemaVersion        string
 	argon2Params                 *argon2id.Params
 	lastLoginMinDelay            = 10 * time.Minute
 	usernameRegex                = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
+	s3AccessKeyIDRegex           = regexp.MustCompile("^[a-zA-Z0-9]{16,128}$")
 	tempPath                     string
 	allowSelfConnections         int
 	fnReloadRules                FnReloadRules
@@ -227,6 +248,12 @@ func initSQLTables() {
 	sqlTableRoles = "roles"
 	sqlTableIPLists = "ip_lists"
 	sqlTableConfigs = "configurations"
+	sqlTableAuditLogs = "audit_logs"
+	sqlTableChangeEvents = "change_events"
+	sqlTableDeletedObjects = "deleted_objects"
+	sqlTableTemplates = "templates"
+	sqlTableTenants = "tenants"
+	sqlTableUsersFoldersQuota = "users_folders_quota"
 	sqlTableSchemaVersion = "schema_version"
 }
 
@@ -359,6 +386,13 @@ type Config struct {
 	// Custom database connection string.
 	// If not empty this connection string will be used instead of build one using the previous parameters
 	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
+	// Connection strings for read-only replicas. Supported for mysql and postgresql drivers.
+	// Authentication and listing queries are load balanced among the healthy replicas,
+	// all the other queries use the primary
+	ReadReplicas []string `json:"read_replicas" mapstructure:"read_replicas"`
+	// Maximum replication lag, in seconds, for a read replica. Replicas lagging behind
+	// are not used until they catch up. 0 means the lag is not checked
+	MaxReplicaLag int `json:"max_replica_lag" mapstructure:"max_replica_lag"`
 	// prefix for SQL tables
 	SQLTablesPrefix string `json:"sql_tables_prefix" mapstructure:"sql_tables_prefix"`
 	// Set the preferred way to track users quota between the following choices:
@@ -398,6 +432,18 @@ type Config struct {
 	// you can combine the scopes, for example 3 means password and public key, 5 password and keyboard
 	// interactive and so on
 	ExternalAuthScope int `json:"external_auth_scope" mapstructure:"external_auth_scope"`
+	// LDAPDirectories defines the LDAP/Active Directory servers to authenticate users against.
+	// Password authentication is done using the directory configured for the binding the
+	// user is connecting to, if any. As for the external authentication hook, the user is
+	// automatically added/updated inside the defined data provider
+	LDAPDirectories []ldap.Config `json:"ldap_directories" mapstructure:"ldap_directories"`
+	// RADIUSServers defines the RADIUS servers to authenticate users against.
+	// SFTP and FTP bindings can reference a server by name
+	RADIUSServers []radius.Config `json:"radius_servers" mapstructure:"radius_servers"`
+	// KerberosServices defines the Kerberos services, each one with its keytab, used to
+	// authenticate users via GSSAPI for SSH and SPNEGO for HTTP and WebDAV.
+	// Bindings can reference a service by name
+	KerberosServices []kerberos.Config `json:"kerberos_services" mapstructure:"kerberos_services"`
 	// Absolute path to an external program or an HTTP URL to invoke just before the user login.
 	// This program/URL allows to modify or create the user trying to login.
 	// It is useful if you have users with dynamic fields to update just before the login.
@@ -481,6 +527,12 @@ type Config struct {
 	Node NodeConfig `json:"node" mapstructure:"node"`
 	// Path to the backup directory. This can be an absolute path or a path relative to the config dir
 	BackupsPath string `json:"backups_path" mapstructure:"backups_path"`
+	// Audit log configuration
+	AuditLog AuditLogConfig `json:"audit_log" mapstructure:"audit_log"`
+	// Change data capture stream configuration
+	ChangeEvents ChangeEventsConfig `json:"change_events" mapstructure:"change_events"`
+	// Soft delete configuration for users and folders
+	SoftDelete SoftDeleteConfig `json:"soft_delete" mapstructure:"soft_delete"`
 }
 
 // GetShared returns the provider share mode.
@@ -509,7 +561,7 @@ func (c *Config) convertName(name string) string {
 // IsDefenderSupported returns true if the configured provider supports the defender
 func (c *Config) IsDefenderSupported() bool {
 	switch c.Driver {
-	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName:
+	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName, MongoDBDataProviderName:
 		return true
 	default:
 		return false
@@ -568,6 +620,30 @@ func ConvertName(name string) string {
 	return config.convertName(name)
 }
 
+// userFolderQuota defines the quota used by a user inside a virtual folder
+type userFolderQuota struct {
+	Username        string `json:"username,omitempty" bson:"username,omitempty"`
+	FolderName      string `json:"folder_name,omitempty" bson:"folder_name,omitempty"`
+	UsedQuotaSize   int64  `json:"used_quota_size" bson:"used_quota_size"`
+	UsedQuotaFiles  int    `json:"used_quota_files" bson:"used_quota_files"`
+	LastQuotaUpdate int64  `json:"last_quota_update" bson:"last_quota_update"`
+}
+
+func getUserFolderQuotaKey(username, folderName string) []byte {
+	return []byte(username + "\x00" + folderName)
+}
+
+func (q *userFolderQuota) update(filesAdd int, sizeAdd int64, reset bool) {
+	if reset {
+		q.UsedQuotaSize = sizeAdd
+		q.UsedQuotaFiles = filesAdd
+	} else {
+		q.UsedQuotaSize = max(q.UsedQuotaSize+sizeAdd, 0)
+		q.UsedQuotaFiles = max(q.UsedQuotaFiles+filesAdd, 0)
+	}
+	q.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
 // ActiveTransfer defines an active protocol transfer
 type ActiveTransfer struct {
 	ID            int64
@@ -669,6 +745,8 @@ type BackupData struct {
 	Roles        []Role                  `json:"roles"`
 	IPLists      []IPListEntry           `json:"ip_lists"`
 	Configs      *Configs                `json:"configs"`
+	Templates    []Template              `json:"templates"`
+	Tenants      []Tenant                `json:"tenants"`
 	Version      int                     `json:"version"`
 }
 
@@ -720,6 +798,7 @@ type Provider interface {
 	updateUser(user *User) error
 	deleteUser(user User, softDelete bool) error
 	updateUserPassword(username, password string) error // used internally when converting passwords from other hash
+	getUsernameWithS3AccessKey(accessKeyID string) (string, error)
 	getUsers(limit int, offset int, order, role string) ([]User, error)
 	dumpUsers() ([]User, error)
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
@@ -734,6 +813,8 @@ type Provider interface {
 	deleteFolder(folder vfs.BaseVirtualFolder) error
 	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedFolderQuota(name string) (int, int64, error)
+	updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error
+	getUsedUserFolderQuota(username, folderName string) (int, int64, error)
 	dumpFolders() ([]vfs.BaseVirtualFolder, error)
 	getGroups(limit, offset int, order string, minimal bool) ([]Group, error)
 	getGroupsWithNames(names []string) ([]Group, error)
@@ -757,6 +838,7 @@ type Provider interface {
 	getAPIKeys(limit int, offset int, order string) ([]APIKey, error)
 	dumpAPIKeys() ([]APIKey, error)
 	updateAPIKeyLastUse(keyID string) error
+	rotateAPIKey(keyID, key string) error
 	shareExists(shareID, username string) (Share, error)
 	addShare(share *Share) error
 	updateShare(share *Share) error
@@ -780,6 +862,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string) error
 	getSharedSession(key string) (Session, error)
+	getSharedSessions(sessionType SessionType) ([]Session, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -811,6 +894,18 @@ type Provider interface {
 	deleteRole(role Role) error
 	getRoles(limit int, offset int, order string, minimal bool) ([]Role, error)
 	dumpRoles() ([]Role, error)
+	templateExists(name string) (Template, error)
+	addTemplate(template *Template) error
+	updateTemplate(template *Template) error
+	deleteTemplate(template Template) error
+	getTemplates(limit int, offset int, order string) ([]Template, error)
+	dumpTemplates() ([]Template, error)
+	tenantExists(name string) (Tenant, error)
+	addTenant(tenant *Tenant) error
+	updateTenant(tenant *Tenant) error
+	deleteTenant(tenant Tenant) error
+	getTenants(limit int, offset int, order string) ([]Tenant, error)
+	dumpTenants() ([]Tenant, error)
 	ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error)
 	addIPListEntry(entry *IPListEntry) error
 	updateIPListEntry(entry *IPListEntry) error
@@ -822,6 +917,16 @@ type Provider interface {
 	getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error)
 	getConfigs() (Configs, error)
 	setConfigs(configs *Configs) error
+	addAuditLogEntry(entry *AuditLogEntry) error
+	getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error)
+	cleanupAuditLogs(before int64) error
+	addChangeEvent(event *ChangeEvent) error
+	getChangeEvents(after int64, limit int) ([]ChangeEvent, error)
+	cleanupChangeEvents(before int64) error
+	addDeletedObject(object *DeletedObject) error
+	getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error)
+	getDeletedObject(id int64) (DeletedObject, error)
+	removeDeletedObject(id int64) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -847,11 +952,20 @@ func checkSharedMode() {
 	}
 }
 
+func checkReadReplicas() {
+	config.ReadReplicas = util.RemoveDuplicates(config.ReadReplicas, true)
+	if len(config.ReadReplicas) > 0 && config.Driver != MySQLDataProviderName && config.Driver != PGSQLDataProviderName {
+		providerLog(logger.LevelWarn, "read replicas are not supported for driver %q, ignoring", config.Driver)
+		config.ReadReplicas = nil
+	}
+}
+
 // Initialize the data provider.
 // An error is returned if the configured driver is invalid or if the data provider cannot be initialized
 func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	config = cnf
 	checkSharedMode()
+	checkReadReplicas()
 	config.Actions.ExecuteOn = util.RemoveDuplicates(config.Actions.ExecuteOn, true)
 	config.Actions.ExecuteFor = util.RemoveDuplicates(config.Actions.ExecuteFor, true)
 
@@ -872,6 +986,24 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := validateHooks(); err != nil {
 		return err
 	}
+	if err := validateLDAPDirectories(); err != nil {
+		return err
+	}
+	if err := validateRADIUSServers(); err != nil {
+		return err
+	}
+	if err := validateKerberosServices(); err != nil {
+		return err
+	}
+	if err := config.AuditLog.initialize(); err != nil {
+		return err
+	}
+	if err := config.ChangeEvents.initialize(); err != nil {
+		return err
+	}
+	if err := config.SoftDelete.initialize(); err != nil {
+		return err
+	}
 	if err := createProvider(basePath); err != nil {
 		return err
 	}
@@ -1003,17 +1135,24 @@ func validateSQLTablesPrefix() error {
 		sqlTableRoles = config.SQLTablesPrefix + sqlTableRoles
 		sqlTableIPLists = config.SQLTablesPrefix + sqlTableIPLists
 		sqlTableConfigs = config.SQLTablesPrefix + sqlTableConfigs
+		sqlTableAuditLogs = config.SQLTablesPrefix + sqlTableAuditLogs
+		sqlTableChangeEvents = config.SQLTablesPrefix + sqlTableChangeEvents
+		sqlTableDeletedObjects = config.SQLTablesPrefix + sqlTableDeletedObjects
+		sqlTableTemplates = config.SQLTablesPrefix + sqlTableTemplates
+		sqlTableTenants = config.SQLTablesPrefix + sqlTableTenants
+		sqlTableUsersFoldersQuota = config.SQLTablesPrefix + sqlTableUsersFoldersQuota
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %q, folders %q users folders mapping %q admins %q "+
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
 			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q"+
-			"ip lists %q configs %q",
+			"ip lists %q configs %q audit logs %q change events %q deleted objects %q templates %q tenants %q users folders quota %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs)
+			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs, sqlTableAuditLogs,
+			sqlTableChangeEvents, sqlTableDeletedObjects, sqlTableTemplates, sqlTableTenants, sqlTableUsersFoldersQuota)
 	}
 	return nil
 }
@@ -1082,7 +1221,11 @@ func ResetDatabase(cnf Config, basePath string) error {
 // CheckAdminAndPass validates the given admin and password connecting from ip
 func CheckAdminAndPass(username, password, ip string) (Admin, error) {
 	username = config.convertName(username)
-	return provider.validateAdminAndPass(username, password, ip)
+	admin, err := provider.validateAdminAndPass(username, password, ip)
+	if config.AuditLog.Enabled {
+		addLoginAuditLogEntry(username, ip, protocolHTTP, LoginMethodPassword, admin.Role, err)
+	}
+	return admin, err
 }
 
 // CheckCachedUserCredentials checks the credentials for a cached user
@@ -1122,20 +1265,22 @@ func CheckCachedUserCredentials(user *CachedUser, password, loginMethod, protoco
 
 // CheckCompositeCredentials checks multiple credentials.
 // WebDAV users can send both a password and a TLS certificate within the same request
-func CheckCompositeCredentials(username, password, ip, loginMethod, protocol string, tlsCert *x509.Certificate) (User, string, error) {
+func CheckCompositeCredentials(username, password, ip, loginMethod, protocol string, tlsCert *x509.Certificate,
+	ldapDirectory string,
+) (User, string, error) {
 	username = config.convertName(username)
 	if loginMethod == LoginMethodPassword {
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := CheckUserAndPass(username, password, ip, protocol, ldapDirectory)
 		return user, loginMethod, err
 	}
 	user, err := CheckUserBeforeTLSAuth(username, ip, protocol, tlsCert)
 	if err != nil {
 		return user, loginMethod, err
 	}
-	if !user.IsTLSUsernameVerificationEnabled() {
+	if !user.IsTLSCertificateAuthEnabled() {
 		// for backward compatibility with 2.0.x we only check the password and change the login method here
 		// in future updates we have to return an error
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := CheckUserAndPass(username, password, ip, protocol, ldapDirectory)
 		return user, LoginMethodPassword, err
 	}
 	user, err = checkUserAndTLSCertificate(&user, protocol, tlsCert)
@@ -1146,7 +1291,9 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 		return user, loginMethod, fmt.Errorf("certificate login method is not allowed for user %q", user.Username)
 	}
 	if loginMethod == LoginMethodTLSCertificateAndPwd {
-		if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
+		if ldapDirectory != "" {
+			user, err = doLDAPAuth(username, password, ldapDirectory)
+		} else if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
 			user, err = doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 			user, err = doExternalAuth(username, password, nil, "", ip, protocol, nil)
@@ -1224,9 +1371,17 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
 }
 
-// CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
-func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
+// CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error.
+// If an LDAP directory is specified the credentials are checked against it
+func CheckUserAndPass(username, password, ip, protocol, ldapDirectory string) (User, error) {
 	username = config.convertName(username)
+	if ldapDirectory != "" {
+		user, err := doLDAPAuth(username, password, ldapDirectory)
+		if err != nil {
+			return user, err
+		}
+		return checkUserAndPass(&user, password, ip, protocol)
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
 		user, err := doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		if err != nil {
@@ -1318,6 +1473,28 @@ func GetFTPPreAuthUser(username, ip string) (User, error) {
 	return user, err
 }
 
+// GetAnonymousHTTPUser returns the anonymous user with the specified username
+// if it allows unauthenticated HTTP browsing. Pre-login hooks are not executed
+// for unauthenticated access
+func GetAnonymousHTTPUser(username string) (User, error) {
+	user, err := UserExists(config.convertName(username), "")
+	if err != nil {
+		return user, err
+	}
+	if !user.Filters.IsAnonymous || len(user.Filters.AnonymousHTTPPaths) == 0 {
+		return user, util.NewRecordNotFoundError(fmt.Sprintf("anonymous HTTP access is not enabled for user %q",
+			user.Username))
+	}
+	if err := user.LoadAndApplyGroupSettings(); err != nil {
+		return user, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return user, err
+	}
+	user.setAnonymousSettings()
+	return user, nil
+}
+
 // GetUserAfterIDPAuth returns the SFTPGo user with the specified username
 // after a successful authentication with an external identity provider.
 // If a pre-login hook is defined it will be executed so the SFTPGo user
@@ -1458,6 +1635,24 @@ func UpdateVirtualFolderQuota(vfolder *vfs.BaseVirtualFolder, filesAdd int, size
 	return nil
 }
 
+// UpdateUserVirtualFolderQuota updates the quota for the given virtual folder and,
+// if per-user limits are defined for the folder, the quota used by the specified
+// user inside the folder.
+// Per-user usage is always updated immediately, delayed quota update does not apply
+func UpdateUserVirtualFolderQuota(user *User, vfolder *vfs.VirtualFolder, filesAdd int, sizeAdd int64, reset bool) error {
+	err := UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, filesAdd, sizeAdd, reset)
+	if err != nil {
+		return err
+	}
+	if !vfolder.HasUserQuotaRestrictions(true) {
+		return nil
+	}
+	if filesAdd == 0 && sizeAdd == 0 && !reset {
+		return nil
+	}
+	return provider.updateUserFolderQuota(user.Username, vfolder.Name, filesAdd, sizeAdd, reset)
+}
+
 // UpdateUserTransferQuota updates the transfer quota for the given SFTPGo user.
 // If reset is true uploadSize and downloadSize indicates the actual sizes instead of the difference.
 func UpdateUserTransferQuota(user *User, uploadSize, downloadSize int64, reset bool) error {
@@ -1524,6 +1719,15 @@ func GetUsedVirtualFolderQuota(name string) (int, int64, error) {
 	return files + delayedFiles, size + delayedSize, err
 }
 
+// GetUsedUserVirtualFolderQuota returns the quota used by the specified user
+// inside the given virtual folder
+func GetUsedUserVirtualFolderQuota(username, folderName string) (int, int64, error) {
+	if config.TrackQuota == 0 {
+		return 0, 0, util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	return provider.getUsedUserFolderQuota(username, folderName)
+}
+
 // GetConfigs returns the configurations
 func GetConfigs() (Configs, error) {
 	return provider.getConfigs()
@@ -1536,9 +1740,10 @@ func UpdateConfigs(configs *Configs, executor, ipAddress, role string) error {
 	} else {
 		configs.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	}
+	before := getAuditLogSnapshot(configs)
 	err := provider.setConfigs(configs)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectConfigs, "configs", role, configs)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectConfigs, "configs", role, before, configs)
 	}
 	return err
 }
@@ -1547,16 +1752,17 @@ func UpdateConfigs(configs *Configs, executor, ipAddress, role string) error {
 func AddShare(share *Share, executor, ipAddress, role string) error {
 	err := provider.addShare(share)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectShare, share.ShareID, role, share)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectShare, share.ShareID, role, nil, share)
 	}
 	return err
 }
 
 // UpdateShare updates an existing share
 func UpdateShare(share *Share, executor, ipAddress, role string) error {
+	before := getAuditLogSnapshot(share)
 	err := provider.updateShare(share)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectShare, share.ShareID, role, share)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectShare, share.ShareID, role, before, share)
 	}
 	return err
 }
@@ -1567,9 +1773,10 @@ func DeleteShare(shareID string, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&share)
 	err = provider.deleteShare(share)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectShare, shareID, role, &share)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectShare, shareID, role, before, &share)
 	}
 	return err
 }
@@ -1586,7 +1793,7 @@ func ShareExists(shareID, username string) (Share, error) {
 func AddIPListEntry(entry *IPListEntry, executor, ipAddress, executorRole string) error {
 	err := provider.addIPListEntry(entry)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, entry)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, nil, entry)
 		for _, l := range inMemoryLists {
 			l.addEntry(entry)
 		}
@@ -1596,9 +1803,10 @@ func AddIPListEntry(entry *IPListEntry, executor, ipAddress, executorRole string
 
 // UpdateIPListEntry updates an existing IP list entry
 func UpdateIPListEntry(entry *IPListEntry, executor, ipAddress, executorRole string) error {
+	before := getAuditLogSnapshot(entry)
 	err := provider.updateIPListEntry(entry)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, entry)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, before, entry)
 		for _, l := range inMemoryLists {
 			l.updateEntry(entry)
 		}
@@ -1612,9 +1820,10 @@ func DeleteIPListEntry(ipOrNet string, listType IPListType, executor, ipAddress,
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&entry)
 	err = provider.deleteIPListEntry(entry, config.IsShared == 1)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, &entry)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, before, &entry)
 		for _, l := range inMemoryLists {
 			l.removeEntry(&entry)
 		}
@@ -1640,16 +1849,17 @@ func AddRole(role *Role, executor, ipAddress, executorRole string) error {
 	role.Name = config.convertName(role.Name)
 	err := provider.addRole(role)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectRole, role.Name, executorRole, role)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectRole, role.Name, executorRole, nil, role)
 	}
 	return err
 }
 
 // UpdateRole updates an existing Role
 func UpdateRole(role *Role, executor, ipAddress, executorRole string) error {
+	before := getAuditLogSnapshot(role)
 	err := provider.updateRole(role)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectRole, role.Name, executorRole, role)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectRole, role.Name, executorRole, before, role)
 	}
 	return err
 }
@@ -1665,9 +1875,11 @@ func DeleteRole(name string, executor, ipAddress, executorRole string) error {
 		errorString := fmt.Sprintf("the role %q is referenced, it cannot be removed", role.Name)
 		return util.NewValidationError(errorString)
 	}
+	before := getAuditLogSnapshot(&role)
 	err = provider.deleteRole(role)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectRole, role.Name, executorRole, &role)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectRole, role.Name, executorRole, before, &role)
+		removeFromTenants(actionObjectRole, role.Name)
 		for _, user := range role.Users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
@@ -1689,17 +1901,27 @@ func RoleExists(name string) (Role, error) {
 // AddGroup adds a new group
 func AddGroup(group *Group, executor, ipAddress, role string) error {
 	group.Name = config.convertName(group.Name)
+	if err := validateIncludedGroups(group); err != nil {
+		return err
+	}
 	err := provider.addGroup(group)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, role, group)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, role, nil, group)
 	}
 	return err
 }
 
 // UpdateGroup updates an existing Group
 func UpdateGroup(group *Group, users []string, executor, ipAddress, role string) error {
+	if err := validateIncludedGroups(group); err != nil {
+		return err
+	}
+	before := getAuditLogSnapshot(group)
 	err := provider.updateGroup(group)
 	if err == nil {
+		// the members of the groups including this one inherit its settings
+		users = util.RemoveDuplicates(append(users[:len(users):len(users)], getUsersInIncludingGroups(group.Name)...),
+			false)
 		for _, user := range users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
@@ -1709,7 +1931,7 @@ func UpdateGroup(group *Group, users []string, executor, ipAddress, role string)
 				RemoveCachedWebDAVUser(user)
 			}
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, role, group)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, role, before, group)
 	}
 	return err
 }
@@ -1725,6 +1947,15 @@ func DeleteGroup(name string, executor, ipAddress, role string) error {
 		errorString := fmt.Sprintf("the group %q is referenced, it cannot be removed", group.Name)
 		return util.NewValidationError(errorString)
 	}
+	includingGroups, err := getIncludingGroups(group.Name)
+	if err != nil {
+		return err
+	}
+	if len(includingGroups) > 0 {
+		errorString := fmt.Sprintf("the group %q is included in other groups, it cannot be removed", group.Name)
+		return util.NewValidationError(errorString)
+	}
+	before := getAuditLogSnapshot(&group)
 	err = provider.deleteGroup(group)
 	if err == nil {
 		for _, user := range group.Users {
@@ -1735,7 +1966,8 @@ func DeleteGroup(name string, executor, ipAddress, role string) error {
 			}
 			RemoveCachedWebDAVUser(user)
 		}
-		executeAction(operationDelete, executor, ipAddress, actionObjectGroup, group.Name, role, &group)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectGroup, group.Name, role, before, &group)
+		removeFromTenants(actionObjectGroup, group.Name)
 	}
 	return err
 }
@@ -1750,29 +1982,49 @@ func GroupExists(name string) (Group, error) {
 func AddAPIKey(apiKey *APIKey, executor, ipAddress, role string) error {
 	err := provider.addAPIKey(apiKey)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, apiKey)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, nil, apiKey)
 	}
 	return err
 }
 
 // UpdateAPIKey updates an existing API key
 func UpdateAPIKey(apiKey *APIKey, executor, ipAddress, role string) error {
+	before := getAuditLogSnapshot(apiKey)
 	err := provider.updateAPIKey(apiKey)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, apiKey)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, before, apiKey)
 	}
 	return err
 }
 
+// RotateAPIKey generates a new key for an existing API key, the previous key
+// is immediately invalidated. The returned API key allows to display the new key
+func RotateAPIKey(keyID string, executor, ipAddress, role string) (APIKey, error) {
+	apiKey, err := provider.apiKeyExists(keyID)
+	if err != nil {
+		return apiKey, err
+	}
+	if err := apiKey.regenerateKey(); err != nil {
+		return apiKey, err
+	}
+	before := getAuditLogSnapshot(&apiKey)
+	if err := provider.rotateAPIKey(apiKey.KeyID, apiKey.Key); err != nil {
+		return apiKey, err
+	}
+	executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, before, &apiKey)
+	return apiKey, nil
+}
+
 // DeleteAPIKey deletes an existing API key
 func DeleteAPIKey(keyID string, executor, ipAddress, role string) error {
 	apiKey, err := provider.apiKeyExists(keyID)
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&apiKey)
 	err = provider.deleteAPIKey(apiKey)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, &apiKey)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, before, &apiKey)
 	}
 	return err
 }
@@ -1801,19 +2053,20 @@ func AddEventAction(action *BaseEventAction, executor, ipAddress, role string) e
 	action.Name = config.convertName(action.Name)
 	err := provider.addEventAction(action)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectEventAction, action.Name, role, action)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectEventAction, action.Name, role, nil, action)
 	}
 	return err
 }
 
 // UpdateEventAction updates an existing event action
 func UpdateEventAction(action *BaseEventAction, executor, ipAddress, role string) error {
+	before := getAuditLogSnapshot(action)
 	err := provider.updateEventAction(action)
 	if err == nil {
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectEventAction, action.Name, role, action)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectEventAction, action.Name, role, before, action)
 	}
 	return err
 }
@@ -1829,9 +2082,10 @@ func DeleteEventAction(name string, executor, ipAddress, role string) error {
 		errorString := fmt.Sprintf("the event action %#q is referenced, it cannot be removed", action.Name)
 		return util.NewValidationError(errorString)
 	}
+	before := getAuditLogSnapshot(&action)
 	err = provider.deleteEventAction(action)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectEventAction, action.Name, role, &action)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectEventAction, action.Name, role, before, &action)
 	}
 	return err
 }
@@ -1860,19 +2114,20 @@ func AddEventRule(rule *EventRule, executor, ipAddress, role string) error {
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
-		executeAction(operationAdd, executor, ipAddress, actionObjectEventRule, rule.Name, role, rule)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectEventRule, rule.Name, role, nil, rule)
 	}
 	return err
 }
 
 // UpdateEventRule updates an existing event rule
 func UpdateEventRule(rule *EventRule, executor, ipAddress, role string) error {
+	before := getAuditLogSnapshot(rule)
 	err := provider.updateEventRule(rule)
 	if err == nil {
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectEventRule, rule.Name, role, rule)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectEventRule, rule.Name, role, before, rule)
 	}
 	return err
 }
@@ -1884,12 +2139,14 @@ func DeleteEventRule(name string, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&rule)
 	err = provider.deleteEventRule(rule, config.IsShared == 1)
 	if err == nil {
 		if fnRemoveRule != nil {
 			fnRemoveRule(rule.Name)
 		}
-		executeAction(operationDelete, executor, ipAddress, actionObjectEventRule, rule.Name, role, &rule)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectEventRule, rule.Name, role, before, &rule)
+		removeFromTenants(actionObjectEventRule, rule.Name)
 	}
 	return err
 }
@@ -1954,20 +2211,28 @@ func AddAdmin(admin *Admin, executor, ipAddress, role string) error {
 	admin.Filters.TOTPConfig = AdminTOTPConfig{
 		Enabled: false,
 	}
+	admin.Filters.WebAuthnCredentials = nil
 	admin.Username = config.convertName(admin.Username)
+	if err := validateAdminTenant(admin); err != nil {
+		return err
+	}
 	err := provider.addAdmin(admin)
 	if err == nil {
 		isAdminCreated.Store(true)
-		executeAction(operationAdd, executor, ipAddress, actionObjectAdmin, admin.Username, role, admin)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectAdmin, admin.Username, role, nil, admin)
 	}
 	return err
 }
 
 // UpdateAdmin updates an existing SFTPGo admin
 func UpdateAdmin(admin *Admin, executor, ipAddress, role string) error {
+	if err := validateAdminTenant(admin); err != nil {
+		return err
+	}
+	before := getAuditLogSnapshot(admin)
 	err := provider.updateAdmin(admin)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectAdmin, admin.Username, role, admin)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectAdmin, admin.Username, role, before, admin)
 	}
 	return err
 }
@@ -1979,9 +2244,10 @@ func DeleteAdmin(username, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&admin)
 	err = provider.deleteAdmin(admin)
 	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, role, &admin)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, role, before, &admin)
 	}
 	return err
 }
@@ -2026,13 +2292,49 @@ func GetUserVariants(username, role string) (User, User, error) {
 // AddUser adds a new SFTPGo user.
 func AddUser(user *User, executor, ipAddress, role string) error {
 	user.Username = config.convertName(user.Username)
+	if err := checkTenantLimits(user); err != nil {
+		return err
+	}
 	err := provider.addUser(user)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectUser, user.Username, role, user)
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectUser, user.Username, role, nil, user)
 	}
 	return err
 }
 
+// GetUserWithS3AccessKey returns the user, with group settings applied, owning
+// the specified S3 access key and the decrypted secret access key.
+// The login conditions are checked
+func GetUserWithS3AccessKey(accessKeyID string) (User, string, error) {
+	if !s3AccessKeyIDRegex.MatchString(accessKeyID) {
+		return User{}, "", util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+	}
+	username, err := provider.getUsernameWithS3AccessKey(accessKeyID)
+	if err != nil {
+		return User{}, "", err
+	}
+	user, err := provider.userExists(username, "")
+	if err != nil {
+		return user, "", err
+	}
+	if err := user.LoadAndApplyGroupSettings(); err != nil {
+		return user, "", err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return user, "", err
+	}
+	key, ok := user.GetS3AccessKey(accessKeyID)
+	if !ok {
+		return user, "", util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+	}
+	if err := key.SecretAccessKey.TryDecrypt(); err != nil {
+		providerLog(logger.LevelError, "unable to decrypt s3 secret access key %q for user %q: %v",
+			accessKeyID, user.Username, err)
+		return user, "", err
+	}
+	return user, key.SecretAccessKey.GetPayload(), nil
+}
+
 // UpdateUserPassword updates the user password
 func UpdateUserPassword(username, plainPwd, executor, ipAddress, role string) error {
 	user, err := provider.userExists(username, role)
@@ -2051,12 +2353,13 @@ func UpdateUserPassword(username, plainPwd, executor, ipAddress, role string) er
 	user.Password = userCopy.Password
 	user.Filters.RequirePasswordChange = false
 	// the last password change is set when validating the user
+	before := getAuditLogSnapshot(&user)
 	if err := provider.updateUser(&user); err != nil {
 		return err
 	}
 	webDAVUsersCache.swap(&user)
 	cachedPasswords.Remove(username)
-	executeAction(operationUpdate, executor, ipAddress, actionObjectUser, username, role, &user)
+	executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectUser, username, role, before, &user)
 	return nil
 }
 
@@ -2065,11 +2368,15 @@ func UpdateUser(user *User, executor, ipAddress, role string) error {
 	if user.groupSettingsApplied {
 		return errors.New("cannot save a user with group settings applied")
 	}
+	if err := checkTenantLimits(user); err != nil {
+		return err
+	}
+	before := getAuditLogSnapshot(user)
 	err := provider.updateUser(user)
 	if err == nil {
 		webDAVUsersCache.swap(user)
 		cachedPasswords.Remove(user.Username)
-		executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, user)
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, before, user)
 	}
 	return err
 }
@@ -2081,12 +2388,19 @@ func DeleteUser(username, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&user)
+	deletedID, err := softDeleteObject(actionObjectUser, user.Username, &user)
+	if err != nil {
+		return err
+	}
 	err = provider.deleteUser(user, config.IsShared == 1)
-	if err == nil {
+	if err != nil {
+		undoSoftDelete(deletedID)
+	} else {
 		RemoveCachedWebDAVUser(user.Username)
 		delayedQuotaUpdater.resetUserQuota(user.Username)
 		cachedPasswords.Remove(username)
-		executeAction(operationDelete, executor, ipAddress, actionObjectUser, user.Username, role, &user)
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectUser, user.Username, role, before, &user)
 	}
 	return err
 }
@@ -2155,6 +2469,11 @@ func GetSharedSession(key string) (Session, error) {
 	return provider.getSharedSession(key)
 }
 
+// GetSharedSessions returns the sessions with the specified type
+func GetSharedSessions(sessionType SessionType) ([]Session, error) {
+	return provider.getSharedSessions(sessionType)
+}
+
 // CleanupSharedSessions removes the shared session with the specified type and
 // before the specified time
 func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
@@ -2204,6 +2523,44 @@ func GetUsers(limit, offset int, order, role string) ([]User, error) {
 	return provider.getUsers(limit, offset, order, role)
 }
 
+// GetGroupsUsers returns the users that are members of the specified groups respecting
+// limit and offset. If role is not empty only the users with the specified role are returned
+func GetGroupsUsers(groups []string, limit, offset int, order, role string) ([]User, error) {
+	var usernames []string
+	for _, groupName := range groups {
+		group, err := provider.groupExists(groupName)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		usernames = append(usernames, group.Users...)
+	}
+	if role != "" {
+		filtered := make([]string, 0, len(usernames))
+		for _, username := range util.RemoveDuplicates(usernames, false) {
+			if _, err := provider.userExists(username, role); err == nil {
+				filtered = append(filtered, username)
+			}
+		}
+		usernames = filtered
+	}
+	users := make([]User, 0, limit)
+	for _, username := range getTenantPage(usernames, limit, offset, order) {
+		user, err := provider.userExists(username, role)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		user.PrepareForRendering()
+		users = append(users, user)
+	}
+	return users, nil
+}
+
 // GetUsersForQuotaCheck returns the users with the fields required for a quota check
 func GetUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
 	return provider.getUsersForQuotaCheck(toFetch)
@@ -2214,16 +2571,17 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string)
 	folder.Name = config.convertName(folder.Name)
 	err := provider.addFolder(folder)
 	if err == nil {
-		executeAction(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, role, nil, &wrappedFolder{Folder: *folder})
 	}
 	return err
 }
 
 // UpdateFolder updates the specified virtual folder
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress, role string) error {
+	before := getAuditLogSnapshot(&wrappedFolder{Folder: *folder})
 	err := provider.updateFolder(folder)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, before, &wrappedFolder{Folder: *folder})
 		usersInGroups, errGrp := provider.getUsersInGroups(groups)
 		if errGrp == nil {
 			users = append(users, usersInGroups...)
@@ -2252,9 +2610,17 @@ func DeleteFolder(folderName, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	before := getAuditLogSnapshot(&wrappedFolder{Folder: folder})
+	deletedID, err := softDeleteObject(actionObjectFolder, folder.Name, &folder)
+	if err != nil {
+		return err
+	}
 	err = provider.deleteFolder(folder)
-	if err == nil {
-		executeAction(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: folder})
+	if err != nil {
+		undoSoftDelete(deletedID)
+	} else {
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, role, before, &wrappedFolder{Folder: folder})
+		removeFromTenants(actionObjectFolder, folder.Name)
 		users := folder.Users
 		usersInGroups, errGrp := provider.getUsersInGroups(folder.Groups)
 		if errGrp == nil {
@@ -2297,6 +2663,51 @@ func DumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return provider.dumpFolders()
 }
 
+// RewrapSecrets re-encrypts the filesystem secrets of users and folders using
+// the latest key version, if supported by the configured KMS provider.
+// Users are processed first, updating a user also saves its virtual folders.
+// It returns the number of updated users and folders
+func RewrapSecrets(executor, ipAddress string) (int, int, error) {
+	var updatedUsers, updatedFolders int
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return updatedUsers, updatedFolders, err
+	}
+	for idx := range users {
+		user := &users[idx]
+		rewrapped, err := user.FsConfig.RewrapSecrets()
+		if err != nil {
+			return updatedUsers, updatedFolders, fmt.Errorf("unable to rewrap the secrets for user %q: %w", user.Username, err)
+		}
+		if !rewrapped {
+			continue
+		}
+		if err := UpdateUser(user, executor, ipAddress, ""); err != nil {
+			return updatedUsers, updatedFolders, fmt.Errorf("unable to update user %q: %w", user.Username, err)
+		}
+		updatedUsers++
+	}
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return updatedUsers, updatedFolders, err
+	}
+	for idx := range folders {
+		folder := &folders[idx]
+		rewrapped, err := folder.FsConfig.RewrapSecrets()
+		if err != nil {
+			return updatedUsers, updatedFolders, fmt.Errorf("unable to rewrap the secrets for folder %q: %w", folder.Name, err)
+		}
+		if !rewrapped {
+			continue
+		}
+		if err := UpdateFolder(folder, folder.Users, folder.Groups, executor, ipAddress, ""); err != nil {
+			return updatedUsers, updatedFolders, fmt.Errorf("unable to update folder %q: %w", folder.Name, err)
+		}
+		updatedFolders++
+	}
+	return updatedUsers, updatedFolders, nil
+}
+
 // DumpData returns all users, groups, folders, admins, api keys, shares, actions, rules
 func DumpData() (BackupData, error) {
 	var data BackupData
@@ -2344,6 +2755,14 @@ func DumpData() (BackupData, error) {
 	if err != nil {
 		return data, err
 	}
+	templates, err := provider.dumpTemplates()
+	if err != nil {
+		return data, err
+	}
+	tenants, err := provider.dumpTenants()
+	if err != nil {
+		return data, err
+	}
 	data.Users = users
 	data.Groups = groups
 	data.Folders = folders
@@ -2355,13 +2774,23 @@ func DumpData() (BackupData, error) {
 	data.Roles = roles
 	data.IPLists = ipLists
 	data.Configs = &configs
+	data.Templates = templates
+	data.Tenants = tenants
 	data.Version = DumpVersion
 	return data, err
 }
 
-// ParseDumpData tries to parse data as BackupData
+// ParseDumpData tries to parse data as BackupData.
+// Encrypted backups are decrypted using the configured backup passphrase
 func ParseDumpData(data []byte) (BackupData, error) {
 	var dump BackupData
+	if isEncryptedBackup(data) {
+		decrypted, err := decryptBackupWithConfiguredPassphrase(data)
+		if err != nil {
+			return dump, err
+		}
+		data = decrypted
+	}
 	err := json.Unmarshal(data, &dump)
 	return dump, err
 }
@@ -2411,6 +2840,10 @@ func createProvider(basePath string) error {
 		return initializeMySQLProvider()
 	case BoltDataProviderName:
 		return initializeBoltProvider(basePath)
+	case MongoDBDataProviderName:
+		return initializeMongoDBProvider()
+	case EtcdDataProviderName:
+		return initializeEtcdProvider()
 	case MemoryDataProviderName:
 		initializeMemoryProvider(basePath)
 		return nil
@@ -2506,6 +2939,12 @@ func validateFolderQuotaLimits(folder vfs.VirtualFolder) error {
 		return util.NewValidationError(fmt.Sprintf("virtual folder quota_size and quota_files must be both -1 or >= 0, quota_size: %v quota_files: %v",
 			folder.QuotaFiles, folder.QuotaSize))
 	}
+	if folder.UserQuotaSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid user_quota_size: %v folder path %q", folder.UserQuotaSize, folder.MappedPath))
+	}
+	if folder.UserQuotaFiles < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid user_quota_files: %v folder path %q", folder.UserQuotaFiles, folder.MappedPath))
+	}
 	return nil
 }
 
@@ -2587,12 +3026,147 @@ func validateAssociatedVirtualFolders(vfolders []vfs.VirtualFolder) ([]vfs.Virtu
 			VirtualPath:       cleanedVPath,
 			QuotaSize:         v.QuotaSize,
 			QuotaFiles:        v.QuotaFiles,
+			UserQuotaSize:     v.UserQuotaSize,
+			UserQuotaFiles:    v.UserQuotaFiles,
 		})
 		folderNames[folder.Name] = true
 	}
 	return virtualFolders, nil
 }
 
+func validateUnionFolders(user *User) error {
+	if len(user.Filters.UnionFolders) == 0 {
+		user.Filters.UnionFolders = nil
+		return nil
+	}
+	mountPaths := []string{"/"}
+	upperFolders := make(map[string]string)
+	for _, v := range user.VirtualFolders {
+		mountPaths = append(mountPaths, v.VirtualPath)
+		upperFolders[v.VirtualPath] = v.Name
+	}
+	var unionFolders []UnionFolder
+	virtualPaths := make(map[string]bool)
+
+	for _, union := range user.Filters.UnionFolders {
+		cleanedVPath := util.CleanPath(union.VirtualPath)
+		if !util.Contains(mountPaths, cleanedVPath) {
+			return util.NewValidationError(fmt.Sprintf("invalid union folder %q, it must be \"/\" or the virtual path of a virtual folder",
+				union.VirtualPath))
+		}
+		if virtualPaths[cleanedVPath] {
+			return util.NewValidationError(fmt.Sprintf("union folder %q is duplicated", cleanedVPath))
+		}
+		folders := util.RemoveDuplicates(union.Folders, false)
+		if len(folders) == 0 {
+			return util.NewValidationError(fmt.Sprintf("union folder %q: at least a lower folder is required", cleanedVPath))
+		}
+		for _, name := range folders {
+			if name == upperFolders[cleanedVPath] {
+				return util.NewValidationError(fmt.Sprintf("union folder %q: the folder %q cannot be a lower layer of itself",
+					cleanedVPath, name))
+			}
+			if _, err := provider.getFolderByName(name); err != nil {
+				return util.NewValidationError(fmt.Sprintf("union folder %q: unable to get lower folder %q: %v",
+					cleanedVPath, name, err))
+			}
+		}
+		unionFolders = append(unionFolders, UnionFolder{
+			VirtualPath: cleanedVPath,
+			Folders:     folders,
+		})
+		virtualPaths[cleanedVPath] = true
+	}
+	user.Filters.UnionFolders = unionFolders
+	return nil
+}
+
+func validateTCPForwards(user *User) error {
+	var forwards []string
+	for _, forward := range util.RemoveDuplicates(user.Filters.AllowedTCPForwards, true) {
+		host, port, err := net.SplitHostPort(forward)
+		if err != nil || host == "" {
+			return util.NewValidationError(fmt.Sprintf("invalid TCP forward %q, the host:port format is required", forward))
+		}
+		if port != "*" {
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 {
+				return util.NewValidationError(fmt.Sprintf("invalid TCP forward %q, port must be * or a number between 1 and 65535",
+					forward))
+			}
+		}
+		forwards = append(forwards, net.JoinHostPort(host, port))
+	}
+	user.Filters.AllowedTCPForwards = forwards
+	return nil
+}
+
+func validateTransfersLimits(user *User) error {
+	if user.Filters.MaxTransfers < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max transfers: %d", user.Filters.MaxTransfers))
+	}
+	if user.Filters.TransfersQueueTimeout < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid transfers queue timeout: %d",
+			user.Filters.TransfersQueueTimeout))
+	}
+	if user.Filters.MaxTransfers == 0 {
+		user.Filters.TransfersQueueTimeout = 0
+	}
+	if user.Filters.MaxDirEntries < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max dir entries: %d", user.Filters.MaxDirEntries))
+	}
+	if user.Filters.MaxPathDepth < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max path depth: %d", user.Filters.MaxPathDepth))
+	}
+	return nil
+}
+
+func validateAnonymousHTTPPaths(user *User) error {
+	if !user.Filters.IsAnonymous {
+		user.Filters.AnonymousHTTPPaths = nil
+		return nil
+	}
+	var paths []string
+	for _, p := range user.Filters.AnonymousHTTPPaths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !path.IsAbs(p) {
+			return util.NewValidationError(fmt.Sprintf("invalid anonymous HTTP path %q, it must be an absolute path", p))
+		}
+		paths = append(paths, util.CleanPath(p))
+	}
+	user.Filters.AnonymousHTTPPaths = util.RemoveDuplicates(paths, false)
+	return nil
+}
+
+func validateSSHAlgorithms(user *User) error {
+	return user.Filters.SSHAlgorithms.validate()
+}
+
+func (algos *UserSSHAlgorithms) validate() error {
+	algos.KexAlgorithms = util.RemoveDuplicates(algos.KexAlgorithms, true)
+	for _, kex := range algos.KexAlgorithms {
+		if !util.Contains(defaultKexAlgos, kex) && !util.Contains(supportedKexAlgos, kex) {
+			return util.NewValidationError(fmt.Sprintf("unsupported key-exchange algorithm %q", kex))
+		}
+	}
+	algos.Ciphers = util.RemoveDuplicates(algos.Ciphers, true)
+	for _, cipher := range algos.Ciphers {
+		if !util.Contains(defaultCiphers, cipher) && !util.Contains(supportedCiphers, cipher) {
+			return util.NewValidationError(fmt.Sprintf("unsupported cipher %q", cipher))
+		}
+	}
+	algos.MACs = util.RemoveDuplicates(algos.MACs, true)
+	for _, mac := range algos.MACs {
+		if !util.Contains(defaultMACs, mac) && !util.Contains(supportedMACs, mac) {
+			return util.NewValidationError(fmt.Sprintf("unsupported MAC algorithm %q", mac))
+		}
+	}
+	return nil
+}
+
 func validateUserTOTPConfig(c *UserTOTPConfig, username string) error {
 	if !c.Enabled {
 		c.ConfigName = ""
@@ -2642,6 +3216,47 @@ func validateUserRecoveryCodes(user *User) error {
 	return nil
 }
 
+func validateS3AccessKeys(user *User) error {
+	if len(user.Filters.S3AccessKeys) == 0 {
+		user.Filters.S3AccessKeys = nil
+		return nil
+	}
+	keyIDs := make(map[string]bool)
+	for idx := range user.Filters.S3AccessKeys {
+		key := &user.Filters.S3AccessKeys[idx]
+		if !s3AccessKeyIDRegex.MatchString(key.AccessKeyID) {
+			return util.NewValidationError(fmt.Sprintf("s3 access key ID %q is not valid, 16-128 alphanumeric characters are required",
+				key.AccessKeyID))
+		}
+		if keyIDs[key.AccessKeyID] {
+			return util.NewValidationError(fmt.Sprintf("s3 access key ID %q is duplicated", key.AccessKeyID))
+		}
+		keyIDs[key.AccessKeyID] = true
+		if key.SecretAccessKey.IsEmpty() {
+			return util.NewValidationError(fmt.Sprintf("s3 access key %q: the secret access key is mandatory", key.AccessKeyID))
+		}
+		if key.SecretAccessKey.IsPlain() {
+			if len(key.SecretAccessKey.GetPayload()) < 16 {
+				return util.NewValidationError(fmt.Sprintf("s3 access key %q: the secret access key must be at least 16 characters",
+					key.AccessKeyID))
+			}
+			key.SecretAccessKey.SetAdditionalData(user.Username)
+			if err := key.SecretAccessKey.Encrypt(); err != nil {
+				return util.NewValidationError(fmt.Sprintf("s3 access key %q: unable to encrypt the secret: %v",
+					key.AccessKeyID, err))
+			}
+		}
+		username, err := provider.getUsernameWithS3AccessKey(key.AccessKeyID)
+		if err == nil && username != user.Username {
+			return util.NewValidationError(fmt.Sprintf("s3 access key ID %q is already in use", key.AccessKeyID))
+		}
+		if err != nil && !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
 func validateUserPermissions(permsToCheck map[string][]string) (map[string][]string, error) {
 	permissions := make(map[string][]string)
 	for dir, perms := range permsToCheck {
@@ -2710,6 +3325,26 @@ func validatePublicKeys(user *User) error {
 	return nil
 }
 
+func validateTLSCertPins(user *User) error {
+	var pins []string
+	for _, pin := range user.Filters.TLSCertPins {
+		pin = strings.TrimSpace(pin)
+		if pin == "" {
+			continue
+		}
+		if hash, err := hex.DecodeString(strings.ReplaceAll(pin, ":", "")); err == nil && len(hash) == sha256.Size {
+			pin = formatTLSCertFingerprint(hash)
+		} else if !strings.Contains(pin, "=") {
+			return util.NewValidationError(fmt.Sprintf("invalid TLS certificate pin %q: a SHA-256 fingerprint or a subject DN is required", pin))
+		}
+		if !util.Contains(pins, pin) {
+			pins = append(pins, pin)
+		}
+	}
+	user.Filters.TLSCertPins = pins
+	return nil
+}
+
 func validateFiltersPatternExtensions(baseFilters *sdk.BaseUserFilters) error {
 	if len(baseFilters.FilePatterns) == 0 {
 		baseFilters.FilePatterns = []sdk.PatternsFilter{}
@@ -2906,6 +3541,10 @@ func validateCombinedUserFilters(user *User) error {
 	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.WebClient, sdk.WebClientMFADisabled) {
 		return util.NewValidationError("two-factor authentication cannot be disabled for a user with an active configuration")
 	}
+	if (user.Filters.RequireWebAuthn || len(user.Filters.WebAuthnCredentials) > 0) &&
+		util.Contains(user.Filters.WebClient, sdk.WebClientMFADisabled) {
+		return util.NewValidationError("two-factor authentication cannot be disabled for a user that requires or has security keys")
+	}
 	if user.Filters.RequirePasswordChange && util.Contains(user.Filters.WebClient, sdk.WebClientPasswordChangeDisabled) {
 		return util.NewValidationError("you cannot require password change and at the same time disallow it")
 	}
@@ -2953,7 +3592,11 @@ func validateBaseParams(user *User) error {
 	if user.Filters.IsAnonymous {
 		user.setAnonymousSettings()
 	}
-	return user.FsConfig.Validate(user.GetEncryptionAdditionalData())
+	if err := user.FsConfig.Validate(user.GetEncryptionAdditionalData()); err != nil {
+		return err
+	}
+	user.FsConfig.OSConfig.ResetOwnership()
+	return nil
 }
 
 func hashPlainPassword(plainPwd string) (string, error) {
@@ -3034,11 +3677,56 @@ func ValidateUser(user *User) error {
 	if err := validateUserRecoveryCodes(user); err != nil {
 		return err
 	}
+	if err := validateWebAuthnCredentials(user.Filters.WebAuthnCredentials); err != nil {
+		return err
+	}
 	vfolders, err := validateAssociatedVirtualFolders(user.VirtualFolders)
 	if err != nil {
 		return err
 	}
 	user.VirtualFolders = vfolders
+	if err := validateUnionFolders(user); err != nil {
+		return err
+	}
+	if user.Filters.Trash.Retention < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid trash retention: %d", user.Filters.Trash.Retention))
+	}
+	if err := validateTCPForwards(user); err != nil {
+		return err
+	}
+	if err := validateSSHAlgorithms(user); err != nil {
+		return err
+	}
+	if err := validateS3AccessKeys(user); err != nil {
+		return err
+	}
+	if err := validateAnonymousHTTPPaths(user); err != nil {
+		return err
+	}
+	if err := validateBandwidthSchedules(user.Filters.BandwidthSchedules); err != nil {
+		return err
+	}
+	timeZone, err := validateAccessTimeWindows(user.Filters.AccessTimeWindows, user.Filters.AccessTimeZone)
+	if err != nil {
+		return err
+	}
+	user.Filters.AccessTimeZone = timeZone
+	if !util.Contains([]string{"", IPApprovalModeEmail, IPApprovalModeAdmin}, user.Filters.IPApproval) {
+		return util.NewValidationError(fmt.Sprintf("invalid IP approval mode: %q", user.Filters.IPApproval))
+	}
+	if err := user.Filters.GeoIP.Validate(); err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	if err := validateTransfersLimits(user); err != nil {
+		return err
+	}
+	if err := user.Filters.RememberedDevices.validate(); err != nil {
+		return err
+	}
+	if err := validateContentTypesFilters(user); err != nil {
+		return err
+	}
+	user.Filters.DLPPolicies = util.RemoveDuplicates(user.Filters.DLPPolicies, true)
 	if user.Status < 0 || user.Status > 1 {
 		return util.NewValidationError(fmt.Sprintf("invalid user status: %v", user.Status))
 	}
@@ -3048,6 +3736,9 @@ func ValidateUser(user *User) error {
 	if err := validatePublicKeys(user); err != nil {
 		return err
 	}
+	if err := validateTLSCertPins(user); err != nil {
+		return err
+	}
 	if err := validateBaseFilters(&user.Filters.BaseUserFilters); err != nil {
 		return err
 	}
@@ -3127,13 +3818,19 @@ func checkUserAndTLSCertificate(user *User, protocol string, tlsCert *x509.Certi
 		return *user, err
 	}
 	switch protocol {
-	case protocolFTP, protocolWebDAV:
+	case protocolFTP, protocolWebDAV, protocolGRPC:
+		if len(user.Filters.TLSCertPins) > 0 && !user.isTLSCertPinned(tlsCert) {
+			return *user, fmt.Errorf("TLS certificate %q is not pinned for user %q", tlsCert.Subject.String(), user.Username)
+		}
 		if user.Filters.TLSUsername == sdk.TLSUsernameCN {
 			if user.Username == tlsCert.Subject.CommonName {
 				return *user, nil
 			}
 			return *user, fmt.Errorf("CN %q does not match username %q", tlsCert.Subject.CommonName, user.Username)
 		}
+		if len(user.Filters.TLSCertPins) > 0 {
+			return *user, nil
+		}
 		return *user, errors.New("TLS certificate is not valid")
 	default:
 		return *user, fmt.Errorf("certificate authentication is not supported for protocol %v", protocol)
@@ -3813,6 +4510,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 	userCreatedAt := u.CreatedAt
 	totpConfig := u.Filters.TOTPConfig
 	recoveryCodes := u.Filters.RecoveryCodes
+	webAuthnCredentials := u.Filters.WebAuthnCredentials
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("invalid pre-login hook response %q, error: %v", string(out), err)
@@ -3832,9 +4530,10 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 		err = provider.addUser(&u)
 	} else {
 		u.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and security keys
 		u.Filters.TOTPConfig = totpConfig
 		u.Filters.RecoveryCodes = recoveryCodes
+		u.Filters.WebAuthnCredentials = webAuthnCredentials
 		err = provider.updateUser(&u)
 		if err == nil {
 			webDAVUsersCache.swap(&u)
@@ -3855,6 +4554,9 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	if config.AuditLog.Enabled && config.AuditLog.UserLogins {
+		addLoginAuditLogEntry(user.Username, ip, protocol, loginMethod, user.Role, err)
+	}
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -4069,9 +4771,10 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.FirstUpload = u.FirstUpload
 		user.CreatedAt = u.CreatedAt
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and security keys
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.WebAuthnCredentials = u.Filters.WebAuthnCredentials
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
@@ -4142,9 +4845,10 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		user.LastPasswordChange = u.LastPasswordChange
 		user.FirstDownload = u.FirstDownload
 		user.FirstUpload = u.FirstUpload
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and security keys
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.WebAuthnCredentials = u.Filters.WebAuthnCredentials
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
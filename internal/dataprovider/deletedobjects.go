@@ -0,0 +1,289 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	deletedObjectsPurgeBatchSize = 100
+)
+
+var (
+	// the object types that can be soft deleted
+	softDeleteObjectTypes = []string{actionObjectUser, actionObjectFolder}
+)
+
+// SoftDeleteConfig defines the configuration for the soft deletion of users and folders
+type SoftDeleteConfig struct {
+	// Deleted users and folders are kept, and can be restored, for the specified number
+	// of days and then permanently removed. 0 means they are removed immediately
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days"`
+	// Set to true to also remove the home directory of the users stored on the local
+	// filesystem when they are permanently removed
+	PurgeHomeDirs bool `json:"purge_home_dirs" mapstructure:"purge_home_dirs"`
+}
+
+func (c *SoftDeleteConfig) initialize() error {
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid soft delete retention days: %d", c.RetentionDays)
+	}
+	return nil
+}
+
+// DeletedObject defines a soft deleted user or folder
+type DeletedObject struct {
+	ID         int64  `json:"id"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// unix timestamp in milliseconds
+	DeletedAt int64 `json:"deleted_at"`
+	// unix timestamp in milliseconds, after this time the object is permanently removed.
+	// It depends on the configured retention and so it is not stored
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// the serialized object as stored in the data provider, it is never
+	// returned by the public methods since it contains confidential data
+	Data string `json:"data,omitempty"`
+}
+
+func (o *DeletedObject) getACopy() DeletedObject {
+	return DeletedObject{
+		ID:         o.ID,
+		ObjectType: o.ObjectType,
+		ObjectName: o.ObjectName,
+		DeletedAt:  o.DeletedAt,
+		Data:       o.Data,
+	}
+}
+
+// IsSoftDeleteEnabled returns true if deleted users and folders can be restored
+func IsSoftDeleteEnabled() bool {
+	return config.SoftDelete.RetentionDays > 0
+}
+
+// GetDeletedObjects returns the soft deleted objects, of the specified type if
+// not empty, ordered by deletion time, most recent first
+func GetDeletedObjects(objectType string, limit, offset int) ([]DeletedObject, error) {
+	if objectType != "" && !util.Contains(softDeleteObjectTypes, objectType) {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid object type %q", objectType))
+	}
+	objects, err := provider.getDeletedObjects(objectType, 0, limit, offset)
+	if err != nil {
+		return objects, err
+	}
+	for idx := range objects {
+		objects[idx].Data = ""
+		objects[idx].ExpiresAt = getDeletedObjectExpiration(objects[idx].DeletedAt)
+	}
+	return objects, nil
+}
+
+// RestoreDeletedObject restores the soft deleted object with the specified ID.
+// The restored users and folders are added again, so their names must not be
+// in use and the referenced groups, folders and roles must still exist.
+// Users are restored without the folders and groups deleted in the meantime,
+// folders are restored without the users and groups they were associated with
+func RestoreDeletedObject(id int64, executor, ipAddress, role string) (DeletedObject, error) {
+	object, err := provider.getDeletedObject(id)
+	if err != nil {
+		return object, err
+	}
+	switch object.ObjectType {
+	case actionObjectUser:
+		var user User
+		if err := json.Unmarshal([]byte(object.Data), &user); err != nil {
+			return object, err
+		}
+		if _, err := provider.userExists(user.Username, ""); err == nil {
+			return object, util.NewValidationError(fmt.Sprintf("user %q already exists", user.Username))
+		}
+		if err := restoreDeletedUser(&user, executor, ipAddress, role); err != nil {
+			return object, err
+		}
+	case actionObjectFolder:
+		var folder vfs.BaseVirtualFolder
+		if err := json.Unmarshal([]byte(object.Data), &folder); err != nil {
+			return object, err
+		}
+		if _, err := provider.getFolderByName(folder.Name); err == nil {
+			return object, util.NewValidationError(fmt.Sprintf("folder %q already exists", folder.Name))
+		}
+		folder.Users = nil
+		folder.Groups = nil
+		if err := AddFolder(&folder, executor, ipAddress, role); err != nil {
+			return object, err
+		}
+	default:
+		return object, fmt.Errorf("unsupported deleted object type %q", object.ObjectType)
+	}
+	if err := provider.removeDeletedObject(object.ID); err != nil {
+		providerLog(logger.LevelError, "unable to remove restored %s %q, id %d: %v",
+			object.ObjectType, object.ObjectName, object.ID, err)
+	}
+	object.Data = ""
+	return object, nil
+}
+
+// PurgeDeletedObject permanently removes the soft deleted object with the specified ID
+func PurgeDeletedObject(id int64) error {
+	object, err := provider.getDeletedObject(id)
+	if err != nil {
+		return err
+	}
+	return purgeDeletedObject(&object)
+}
+
+func restoreDeletedUser(user *User, executor, ipAddress, role string) error {
+	groups := make([]sdk.GroupMapping, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		if _, err := provider.groupExists(g.Name); err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				providerLog(logger.LevelInfo, "group %q not found, it will not be associated to the restored user %q",
+					g.Name, user.Username)
+				continue
+			}
+			return err
+		}
+		groups = append(groups, g)
+	}
+	user.Groups = groups
+	folders := make([]vfs.VirtualFolder, 0, len(user.VirtualFolders))
+	for _, f := range user.VirtualFolders {
+		if _, err := provider.getFolderByName(f.Name); err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				providerLog(logger.LevelInfo, "folder %q not found, it will not be associated to the restored user %q",
+					f.Name, user.Username)
+				continue
+			}
+			return err
+		}
+		folders = append(folders, f)
+	}
+	user.VirtualFolders = folders
+	return AddUser(user, executor, ipAddress, role)
+}
+
+// softDeleteObject stores a copy of the specified object, before its deletion,
+// so it can be restored. It returns the ID of the stored copy, 0 if soft
+// deletion is disabled
+func softDeleteObject(objectType, objectName string, object any) (int64, error) {
+	if !IsSoftDeleteEnabled() {
+		return 0, nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return 0, err
+	}
+	deleted := &DeletedObject{
+		ObjectType: objectType,
+		ObjectName: objectName,
+		DeletedAt:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		Data:       string(data),
+	}
+	if err := provider.addDeletedObject(deleted); err != nil {
+		providerLog(logger.LevelError, "unable to soft delete %s %q: %v", objectType, objectName, err)
+		return 0, err
+	}
+	return deleted.ID, nil
+}
+
+// undoSoftDelete removes the stored copy if the deletion failed
+func undoSoftDelete(id int64) {
+	if id == 0 {
+		return
+	}
+	if err := provider.removeDeletedObject(id); err != nil {
+		providerLog(logger.LevelError, "unable to remove deleted object with id %d: %v", id, err)
+	}
+}
+
+// purgeDeletedObjects permanently removes the deleted objects whose retention expired
+func purgeDeletedObjects() {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(-getSoftDeleteRetention()))
+	for {
+		objects, err := provider.getDeletedObjects("", before, deletedObjectsPurgeBatchSize, 0)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get the deleted objects to purge: %v", err)
+			return
+		}
+		for idx := range objects {
+			if err := purgeDeletedObject(&objects[idx]); err != nil {
+				// the object is not removed and we'll try again on the next run
+				return
+			}
+		}
+		if len(objects) < deletedObjectsPurgeBatchSize {
+			return
+		}
+	}
+}
+
+func getSoftDeleteRetention() time.Duration {
+	return time.Duration(config.SoftDelete.RetentionDays) * 24 * time.Hour
+}
+
+func getDeletedObjectExpiration(deletedAt int64) int64 {
+	return util.GetTimeAsMsSinceEpoch(util.GetTimeFromMsecSinceEpoch(deletedAt).Add(getSoftDeleteRetention()))
+}
+
+func purgeDeletedObject(object *DeletedObject) error {
+	if object.ObjectType == actionObjectUser && config.SoftDelete.PurgeHomeDirs {
+		if err := purgeDeletedUserHomeDir(object); err != nil {
+			providerLog(logger.LevelError, "unable to remove the home dir for the deleted user %q: %v",
+				object.ObjectName, err)
+			return err
+		}
+	}
+	if err := provider.removeDeletedObject(object.ID); err != nil {
+		providerLog(logger.LevelError, "unable to purge deleted %s %q, id %d: %v",
+			object.ObjectType, object.ObjectName, object.ID, err)
+		return err
+	}
+	providerLog(logger.LevelInfo, "deleted %s %q, id %d, purged", object.ObjectType, object.ObjectName, object.ID)
+	return nil
+}
+
+func purgeDeletedUserHomeDir(object *DeletedObject) error {
+	var user User
+	if err := json.Unmarshal([]byte(object.Data), &user); err != nil {
+		return err
+	}
+	if user.FsConfig.Provider != sdk.LocalFilesystemProvider && user.FsConfig.Provider != sdk.CryptedFilesystemProvider {
+		return nil
+	}
+	homeDir := user.GetHomeDir()
+	if homeDir == "" || !filepath.IsAbs(homeDir) {
+		return nil
+	}
+	// the user was added again, its home dir must be preserved
+	if _, err := provider.userExists(user.Username, ""); err == nil {
+		providerLog(logger.LevelInfo, "user %q exists, home dir %q not removed", user.Username, homeDir)
+		return nil
+	}
+	providerLog(logger.LevelInfo, "removing home dir %q for the deleted user %q", homeDir, user.Username)
+	return os.RemoveAll(homeDir)
+}
@@ -0,0 +1,4913 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noetcd
+// +build !noetcd
+
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	etcdDatabaseVersion     = 30
+	etcdDefaultPort         = 2379
+	defaultEtcdQueryTimeout = 20 * time.Second
+	longEtcdQueryTimeout    = 60 * time.Second
+	etcdTxMaxAttempts       = 10
+	etcdWatchRetryInterval  = 5 * time.Second
+)
+
+const (
+	etcdUsersBucket           = "users"
+	etcdGroupsBucket          = "groups"
+	etcdFoldersBucket         = "folders"
+	etcdAdminsBucket          = "admins"
+	etcdAPIKeysBucket         = "api_keys"
+	etcdSharesBucket          = "shares"
+	etcdActionsBucket         = "events_actions"
+	etcdRulesBucket           = "events_rules"
+	etcdRolesBucket           = "roles"
+	etcdIPListsBucket         = "ip_lists"
+	etcdConfigsBucket         = "configs"
+	etcdAuditLogsBucket       = "audit_logs"
+	etcdChangeEventsBucket    = "change_events"
+	etcdDeletedObjectsBucket  = "deleted_objects"
+	etcdTemplatesBucket       = "templates"
+	etcdTenantsBucket         = "tenants"
+	etcdFoldersQuotaBucket    = "users_folders_quota"
+	etcdDeletedUsersBucket    = "deleted_users"
+	etcdDeletedRulesBucket    = "deleted_events_rules"
+	etcdDeletedIPListsBucket  = "deleted_ip_lists"
+	etcdActiveTransfersBucket = "active_transfers"
+	etcdSharedSessionsBucket  = "shared_sessions"
+	etcdTasksBucket           = "tasks"
+	etcdNodesBucket           = "nodes"
+	etcdSequencesBucket       = "sequences"
+	etcdSchemaVersionBucket   = "schema_version"
+	etcdConfigsKey            = "configs"
+	etcdSchemaVersionKey      = "version"
+)
+
+var (
+	errEtcdReadOnlyTx = errors.New("cannot modify a read-only etcd transaction")
+)
+
+// EtcdProvider defines the auth provider for etcd.
+// Objects are stored as JSON values, the keys are grouped in buckets, as for
+// the bolt provider, and prefixed with the configured name so multiple
+// installations can share the same etcd cluster.
+// If the provider is shared, the changes to users and IP list entries are
+// watched to invalidate the local caches without waiting for the periodic checks
+type EtcdProvider struct {
+	client      *clientv3.Client
+	namespace   string
+	cancelWatch context.CancelFunc
+}
+
+// etcdTx is an optimistic transaction. All the reads are executed at the
+// revision of the first read, the writes are buffered and atomically applied
+// on commit if none of the read keys and prefixes was modified in the meantime.
+// Read errors are sticky: after the first error the reads return no data and
+// the error is returned by view and update, so the buckets can mirror the bolt API
+type etcdTx struct {
+	ctx       context.Context
+	client    *clientv3.Client
+	namespace string
+	writable  bool
+	rev       int64
+	err       error
+	// read keys and their mod revision, 0 for missing keys
+	keys     map[string]int64
+	prefixes map[string]bool
+	writes   map[string]etcdWrite
+}
+
+type etcdWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// etcdBucket groups the keys sharing the same prefix within a transaction
+type etcdBucket struct {
+	tx     *etcdTx
+	name   string
+	prefix string
+}
+
+// etcdCursor iterates the keys of a bucket, sorted by key, as seen by the
+// transaction. Empty keys mean that the cursor is exhausted
+type etcdCursor struct {
+	keys  []string
+	items map[string][]byte
+	pos   int
+}
+
+// etcdTombstone stores the objects soft deleted in shared mode, so the other
+// nodes can update their caches before the objects are permanently removed
+type etcdTombstone struct {
+	DeletedAt int64           `json:"deleted_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type etcdSession struct {
+	Key       string      `json:"key"`
+	Data      string      `json:"data"`
+	Type      SessionType `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+func init() {
+	version.AddFeature("+etcd")
+}
+
+func initializeEtcdProvider() error {
+	etcdConfig := clientv3.Config{
+		Endpoints:   getEtcdEndpoints(),
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: 10 * time.Second,
+	}
+	if config.SSLMode > 0 {
+		tlsConfig, err := getEtcdTLSConfig()
+		if err != nil {
+			providerLog(logger.LevelError, "error creating etcd TLS config: %v", err)
+			return err
+		}
+		etcdConfig.TLS = tlsConfig
+	}
+	client, err := clientv3.New(etcdConfig)
+	if err != nil {
+		providerLog(logger.LevelError, "error creating etcd client, endpoints: %v, error: %v", etcdConfig.Endpoints, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "etcd client created, endpoints: %v, namespace: %q", etcdConfig.Endpoints,
+		config.Name)
+	p := &EtcdProvider{
+		client:    client,
+		namespace: config.Name,
+	}
+	if config.IsShared == 1 {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.cancelWatch = cancel
+		go p.watchBucket(ctx, etcdUsersBucket, p.onUserChanged)
+		go p.watchBucket(ctx, etcdIPListsBucket, p.onIPListEntryChanged)
+	}
+	provider = p
+	return nil
+}
+
+// getEtcdEndpoints returns the endpoints from the connection string, if set,
+// or from the configured hosts. Multiple endpoints are separated by commas
+func getEtcdEndpoints() []string {
+	if config.ConnectionString != "" {
+		return util.RemoveDuplicates(strings.Split(config.ConnectionString, ","), true)
+	}
+	port := config.Port
+	if port <= 0 {
+		port = etcdDefaultPort
+	}
+	var endpoints []string
+	for _, host := range strings.Split(config.Host, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return endpoints
+}
+
+func getEtcdTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	providerLog(logger.LevelInfo, "using custom TLS config, root cert %q, client cert %q, client key %q",
+		config.RootCert, config.ClientCert, config.ClientKey)
+	return tlsConfig, nil
+}
+
+func (p *EtcdProvider) bucketPrefix(name string) string {
+	return p.namespace + "/" + name + "/"
+}
+
+func (p *EtcdProvider) newTx(ctx context.Context, writable bool) *etcdTx {
+	return &etcdTx{
+		ctx:       ctx,
+		client:    p.client,
+		namespace: p.namespace,
+		writable:  writable,
+		keys:      make(map[string]int64),
+		prefixes:  make(map[string]bool),
+		writes:    make(map[string]etcdWrite),
+	}
+}
+
+// view executes fn within a read-only transaction
+func (p *EtcdProvider) view(fn func(tx *etcdTx) error) error {
+	return p.viewWithTimeout(defaultEtcdQueryTimeout, fn)
+}
+
+func (p *EtcdProvider) viewWithTimeout(timeout time.Duration, fn func(tx *etcdTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tx := p.newTx(ctx, false)
+	err := fn(tx)
+	if tx.err != nil {
+		return tx.err
+	}
+	return err
+}
+
+// update executes fn within a read-write transaction. If a concurrent
+// transaction modifies the keys read by fn, fn is executed again
+func (p *EtcdProvider) update(fn func(tx *etcdTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultEtcdQueryTimeout)
+	defer cancel()
+
+	for attempt := 1; attempt <= etcdTxMaxAttempts; attempt++ {
+		tx := p.newTx(ctx, true)
+		err := fn(tx)
+		if tx.err != nil {
+			return tx.err
+		}
+		if err != nil {
+			return err
+		}
+		committed, err := tx.commit()
+		if err != nil {
+			return err
+		}
+		if committed {
+			return nil
+		}
+		providerLog(logger.LevelDebug, "etcd transaction conflict, attempt %d/%d", attempt, etcdTxMaxAttempts)
+	}
+	return fmt.Errorf("unable to commit the etcd transaction after %d attempts", etcdTxMaxAttempts)
+}
+
+func (tx *etcdTx) Bucket(name string) *etcdBucket {
+	return &etcdBucket{
+		tx:     tx,
+		name:   name,
+		prefix: tx.namespace + "/" + name + "/",
+	}
+}
+
+func (tx *etcdTx) read(key string, opts ...clientv3.OpOption) *clientv3.GetResponse {
+	if tx.err != nil {
+		return nil
+	}
+	if tx.rev > 0 {
+		opts = append(opts, clientv3.WithRev(tx.rev))
+	}
+	resp, err := tx.client.Get(tx.ctx, key, opts...)
+	if err != nil {
+		tx.err = err
+		return nil
+	}
+	if tx.rev == 0 {
+		tx.rev = resp.Header.Revision
+	}
+	return resp
+}
+
+// commit applies the buffered writes. It returns false if the read keys
+// were modified by another transaction, in this case nothing is applied
+func (tx *etcdTx) commit() (bool, error) {
+	if len(tx.writes) == 0 {
+		return true, nil
+	}
+	cmps := make([]clientv3.Cmp, 0, len(tx.keys)+len(tx.prefixes))
+	for k, rev := range tx.keys {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "=", rev))
+	}
+	for prefix := range tx.prefixes {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(prefix), "<", tx.rev+1).WithPrefix())
+	}
+	ops := make([]clientv3.Op, 0, len(tx.writes))
+	for k, w := range tx.writes {
+		if w.deleted {
+			ops = append(ops, clientv3.OpDelete(k))
+		} else {
+			ops = append(ops, clientv3.OpPut(k, string(w.value)))
+		}
+	}
+	resp, err := tx.client.Txn(tx.ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// Get returns the value for the specified key or nil if the key does not exist
+func (b *etcdBucket) Get(key string) []byte {
+	k := b.prefix + key
+	if w, ok := b.tx.writes[k]; ok {
+		if w.deleted {
+			return nil
+		}
+		return w.value
+	}
+	resp := b.tx.read(k)
+	if resp == nil {
+		return nil
+	}
+	if len(resp.Kvs) == 0 {
+		b.tx.keys[k] = 0
+		return nil
+	}
+	b.tx.keys[k] = resp.Kvs[0].ModRevision
+	return resp.Kvs[0].Value
+}
+
+func (b *etcdBucket) Put(key string, value []byte) error {
+	if !b.tx.writable {
+		return errEtcdReadOnlyTx
+	}
+	b.tx.writes[b.prefix+key] = etcdWrite{value: value}
+	return nil
+}
+
+func (b *etcdBucket) Delete(key string) error {
+	if !b.tx.writable {
+		return errEtcdReadOnlyTx
+	}
+	b.tx.writes[b.prefix+key] = etcdWrite{deleted: true}
+	return nil
+}
+
+// NextSequence returns an autoincrementing integer for the bucket
+func (b *etcdBucket) NextSequence() (uint64, error) {
+	sequences := b.tx.Bucket(etcdSequencesBucket)
+	var seq uint64
+	if v := sequences.Get(b.name); v != nil {
+		var err error
+		seq, err = strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sequence for bucket %q: %w", b.name, err)
+		}
+	}
+	if b.tx.err != nil {
+		return 0, b.tx.err
+	}
+	seq++
+	return seq, sequences.Put(b.name, []byte(strconv.FormatUint(seq, 10)))
+}
+
+// KeyN returns the number of committed keys in the bucket
+func (b *etcdBucket) KeyN() int {
+	resp := b.tx.read(b.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if resp == nil {
+		return 0
+	}
+	return int(resp.Count)
+}
+
+// Cursor returns a cursor over all the keys of the bucket, including the
+// pending writes. The whole bucket is loaded in memory
+func (b *etcdBucket) Cursor() *etcdCursor {
+	c := &etcdCursor{
+		items: make(map[string][]byte),
+		pos:   -1,
+	}
+	resp := b.tx.read(b.prefix, clientv3.WithPrefix())
+	if resp == nil {
+		return c
+	}
+	b.tx.prefixes[b.prefix] = true
+	for _, kv := range resp.Kvs {
+		c.items[strings.TrimPrefix(string(kv.Key), b.prefix)] = kv.Value
+	}
+	for k, w := range b.tx.writes {
+		if !strings.HasPrefix(k, b.prefix) {
+			continue
+		}
+		if w.deleted {
+			delete(c.items, strings.TrimPrefix(k, b.prefix))
+		} else {
+			c.items[strings.TrimPrefix(k, b.prefix)] = w.value
+		}
+	}
+	c.keys = make([]string, 0, len(c.items))
+	for k := range c.items {
+		c.keys = append(c.keys, k)
+	}
+	sort.Strings(c.keys)
+	return c
+}
+
+func (c *etcdCursor) First() (string, []byte) {
+	return c.moveTo(0)
+}
+
+func (c *etcdCursor) Last() (string, []byte) {
+	return c.moveTo(len(c.keys) - 1)
+}
+
+func (c *etcdCursor) Next() (string, []byte) {
+	return c.moveTo(c.pos + 1)
+}
+
+func (c *etcdCursor) Prev() (string, []byte) {
+	return c.moveTo(c.pos - 1)
+}
+
+// Seek moves the cursor to the first key greater than or equal to the given one
+func (c *etcdCursor) Seek(key string) (string, []byte) {
+	return c.moveTo(sort.SearchStrings(c.keys, key))
+}
+
+func (c *etcdCursor) moveTo(pos int) (string, []byte) {
+	if pos < 0 || pos >= len(c.keys) {
+		c.pos = len(c.keys)
+		return "", nil
+	}
+	c.pos = pos
+	return c.keys[pos], c.items[c.keys[pos]]
+}
+
+func (p *EtcdProvider) checkAvailability() error {
+	_, err := p.getDatabaseVersion()
+	return err
+}
+
+func (p *EtcdProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
+	var user User
+	if tlsCert == nil {
+		return user, errors.New("TLS certificate cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+}
+
+func (p *EtcdProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (p *EtcdProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
+	admin, err := p.adminExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating admin %q: %v", username, err)
+		return admin, err
+	}
+	err = admin.checkUserAndPass(password, ip)
+	return admin, err
+}
+
+func (p *EtcdProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+}
+
+func (p *EtcdProvider) updateAPIKeyLastUse(keyID string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+		var u []byte
+		if u = bucket.Get(keyID); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to update last use", keyID))
+		}
+		var apiKey APIKey
+		err := json.Unmarshal(u, &apiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(keyID, buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for key %q: %v", keyID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "last use updated for key %q", keyID)
+		return nil
+	})
+}
+
+func (p *EtcdProvider) rotateAPIKey(keyID, key string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+		var u []byte
+		if u = bucket.Get(keyID); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to rotate", keyID))
+		}
+		var apiKey APIKey
+		err := json.Unmarshal(u, &apiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.Key = key
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(keyID, buf)
+	})
+}
+
+func (p *EtcdProvider) setUpdatedAt(username string) {
+	p.update(func(tx *etcdTx) error { //nolint:errcheck
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update updated at", username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(username, buf)
+		if err == nil {
+			providerLog(logger.LevelDebug, "updated at set for user %q", username)
+			setLastUserUpdate()
+		} else {
+			providerLog(logger.LevelWarn, "error setting updated_at for user %q: %v", username, err)
+		}
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateLastLogin(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update last login", username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(username, buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating last login for user %q: %v", username, err)
+		} else {
+			providerLog(logger.LevelDebug, "last login updated for user %q", username)
+		}
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateAdminLastLogin(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+		var a []byte
+		if a = bucket.Get(username); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist, unable to update last login", username))
+		}
+		var admin Admin
+		err := json.Unmarshal(a, &admin)
+		if err != nil {
+			return err
+		}
+		admin.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(username, buf)
+		if err == nil {
+			providerLog(logger.LevelDebug, "last login updated for admin %q", username)
+			return err
+		}
+		providerLog(logger.LevelWarn, "error updating last login for admin %q: %v", username, err)
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update transfer quota",
+				username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if !reset {
+			user.UsedUploadDataTransfer += uploadSize
+			user.UsedDownloadDataTransfer += downloadSize
+		} else {
+			user.UsedUploadDataTransfer = uploadSize
+			user.UsedDownloadDataTransfer = downloadSize
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(username, buf)
+		providerLog(logger.LevelDebug, "transfer quota updated for user %q, ul increment: %v dl increment: %v is reset? %v",
+			username, uploadSize, downloadSize, reset)
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update quota", username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if reset {
+			user.UsedQuotaSize = sizeAdd
+			user.UsedQuotaFiles = filesAdd
+		} else {
+			user.UsedQuotaSize += sizeAdd
+			user.UsedQuotaFiles += filesAdd
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(username, buf)
+		providerLog(logger.LevelDebug, "quota updated for user %q, files increment: %v size increment: %v is reset? %v",
+			username, filesAdd, sizeAdd, reset)
+		return err
+	})
+}
+
+func (p *EtcdProvider) getUsedQuota(username string) (int, int64, int64, int64, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, err
+}
+
+func (p *EtcdProvider) adminExists(username string) (Admin, error) {
+	var admin Admin
+
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+		a := bucket.Get(username)
+		if a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", username))
+		}
+		return json.Unmarshal(a, &admin)
+	})
+
+	return admin, err
+}
+
+func (p *EtcdProvider) addAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+		groupBucket := tx.Bucket(etcdGroupsBucket)
+		rolesBucket := tx.Bucket(etcdRolesBucket)
+		if a := bucket.Get(admin.Username); a != nil {
+			return fmt.Errorf("admin %q already exists", admin.Username)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		admin.ID = int64(id)
+		admin.LastLogin = 0
+		admin.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		for idx := range admin.Groups {
+			err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, rolesBucket); err != nil {
+			return err
+		}
+
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(admin.Username, buf)
+	})
+}
+
+func (p *EtcdProvider) updateAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+		groupBucket := tx.Bucket(etcdGroupsBucket)
+		rolesBucket := tx.Bucket(etcdRolesBucket)
+		var a []byte
+		if a = bucket.Get(admin.Username); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		var oldAdmin Admin
+		err = json.Unmarshal(a, &oldAdmin)
+		if err != nil {
+			return err
+		}
+
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range admin.Groups {
+			err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupBucket)
+			if err != nil {
+				return err
+			}
+		}
+		admin.ID = oldAdmin.ID
+		admin.CreatedAt = oldAdmin.CreatedAt
+		admin.LastLogin = oldAdmin.LastLogin
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(admin.Username, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteAdmin(admin Admin) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+
+		var a []byte
+		if a = bucket.Get(admin.Username); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		var oldAdmin Admin
+		err := json.Unmarshal(a, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if len(oldAdmin.Groups) > 0 {
+			groupBucket := tx.Bucket(etcdGroupsBucket)
+			for idx := range oldAdmin.Groups {
+				err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupBucket)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if oldAdmin.Role != "" {
+			rolesBucket := tx.Bucket(etcdRolesBucket)
+			if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
+				return err
+			}
+		}
+
+		if err := p.deleteRelatedAPIKey(tx, admin.Username, APIKeyScopeAdmin); err != nil {
+			return err
+		}
+
+		return bucket.Delete(admin.Username)
+	})
+}
+
+func (p *EtcdProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+	admins := make([]Admin, 0, limit)
+
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var admin Admin
+				err := json.Unmarshal(v, &admin)
+				if err != nil {
+					return err
+				}
+				admin.HideConfidentialData()
+				admins = append(admins, admin)
+				if len(admins) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var admin Admin
+				err := json.Unmarshal(v, &admin)
+				if err != nil {
+					return err
+				}
+				admin.HideConfidentialData()
+				admins = append(admins, admin)
+				if len(admins) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+
+	return admins, err
+}
+
+func (p *EtcdProvider) dumpAdmins() ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAdminsBucket)
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var admin Admin
+			err := json.Unmarshal(v, &admin)
+			if err != nil {
+				return err
+			}
+			admins = append(admins, admin)
+		}
+		return nil
+	})
+
+	return admins, err
+}
+
+func (p *EtcdProvider) userExists(username, role string) (User, error) {
+	var user User
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		u := bucket.Get(username)
+		if u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		var err error
+		user, err = p.joinUserAndFolders(u, foldersBucket)
+		if err != nil {
+			return err
+		}
+		if !user.hasRole(role) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (p *EtcdProvider) addUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		groupBucket := tx.Bucket(etcdGroupsBucket)
+		rolesBucket := tx.Bucket(etcdRolesBucket)
+		if u := bucket.Get(user.Username); u != nil {
+			return fmt.Errorf("username %v already exists", user.Username)
+		}
+		if err := tx.Bucket(etcdDeletedUsersBucket).Delete(user.Username); err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		user.ID = int64(id)
+		user.LastQuotaUpdate = 0
+		user.UsedQuotaSize = 0
+		user.UsedQuotaFiles = 0
+		user.UsedUploadDataTransfer = 0
+		user.UsedDownloadDataTransfer = 0
+		user.LastLogin = 0
+		user.FirstDownload = 0
+		user.FirstUpload = 0
+		user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err := p.addUserToRole(user.Username, user.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range user.VirtualFolders {
+			err = p.addRelationToFolderMapping(&user.VirtualFolders[idx].BaseVirtualFolder, user, nil, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		for idx := range user.Groups {
+			err = p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupBucket)
+			if err != nil {
+				return err
+			}
+		}
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(user.Username, buf)
+	})
+}
+
+func (p *EtcdProvider) updateUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(user.Username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		var oldUser User
+		err = json.Unmarshal(u, &oldUser)
+		if err != nil {
+			return err
+		}
+		if err = p.updateUserRelations(tx, user, oldUser); err != nil {
+			return err
+		}
+		user.ID = oldUser.ID
+		user.LastQuotaUpdate = oldUser.LastQuotaUpdate
+		user.UsedQuotaSize = oldUser.UsedQuotaSize
+		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
+		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+		user.LastLogin = oldUser.LastLogin
+		user.FirstDownload = oldUser.FirstDownload
+		user.FirstUpload = oldUser.FirstUpload
+		user.CreatedAt = oldUser.CreatedAt
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put(user.Username, buf)
+		if err == nil {
+			setLastUserUpdate()
+		}
+		return err
+	})
+}
+
+func (p *EtcdProvider) deleteUser(user User, softDelete bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		groupBucket := tx.Bucket(etcdGroupsBucket)
+		rolesBucket := tx.Bucket(etcdRolesBucket)
+		tombstones := tx.Bucket(etcdDeletedUsersBucket)
+		var u []byte
+		if u = bucket.Get(user.Username); u == nil {
+			if !softDelete && tombstones.Get(user.Username) != nil {
+				// a soft deleted user is permanently removed
+				return tombstones.Delete(user.Username)
+			}
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		var oldUser User
+		err := json.Unmarshal(u, &oldUser)
+		if err != nil {
+			return err
+		}
+		if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range oldUser.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldUser.VirtualFolders[idx], oldUser.Username, "", foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		for idx := range oldUser.Groups {
+			err = p.removeUserFromGroupMapping(oldUser.Username, oldUser.Groups[idx].Name, groupBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err := p.deleteRelatedAPIKey(tx, user.Username, APIKeyScopeUser); err != nil {
+			return err
+		}
+		if err := p.deleteRelatedShares(tx, user.Username); err != nil {
+			return err
+		}
+		if err := p.deleteUsersFoldersQuota(tx, user.Username, ""); err != nil {
+			return err
+		}
+		if softDelete {
+			return bucket.softDelete(user.Username, u, tombstones)
+		}
+		return bucket.Delete(user.Username)
+	})
+}
+
+func (p *EtcdProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	var username string
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			if _, ok := user.GetS3AccessKey(accessKeyID); ok {
+				username = user.Username
+				return nil
+			}
+		}
+		return util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+	})
+	return username, err
+}
+
+func (p *EtcdProvider) updateUserPassword(username, password string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		user.Password = password
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(username, buf)
+	})
+}
+
+func (p *EtcdProvider) dumpUsers() ([]User, error) {
+	users := make([]User, 0, 100)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			user, err := p.joinUserAndFolders(v, foldersBucket)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
+	if config.IsShared != 1 && getLastUserUpdate() < after {
+		return nil, nil
+	}
+	users := make([]User, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		groupsBucket := tx.Bucket(etcdGroupsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var user User
+			err := json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			if user.UpdatedAt < after {
+				continue
+			}
+			if len(user.VirtualFolders) > 0 {
+				var folders []vfs.VirtualFolder
+				for idx := range user.VirtualFolders {
+					folder := &user.VirtualFolders[idx]
+					baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+					if err != nil {
+						continue
+					}
+					folder.BaseVirtualFolder = baseFolder
+					folders = append(folders, *folder)
+				}
+				user.VirtualFolders = folders
+			}
+			if len(user.Groups) > 0 {
+				groupMapping := make(map[string]Group)
+				for idx := range user.Groups {
+					group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+					if err != nil {
+						continue
+					}
+					groupMapping[group.Name] = group
+				}
+				addIncludedGroupsToMapping(groupMapping, func(name string) (Group, error) {
+					return p.groupExistsInternal(name, groupsBucket)
+				})
+				user.applyGroupSettings(groupMapping)
+			}
+			user.SetEmptySecretsIfNil()
+			users = append(users, user)
+		}
+		cursor = tx.Bucket(etcdDeletedUsersBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var user User
+			deletedAt, err := unmarshalEtcdTombstone(v, &user)
+			if err != nil {
+				return err
+			}
+			user.DeletedAt = deletedAt
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
+	users := make([]User, 0, 10)
+
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		groupsBucket := tx.Bucket(etcdGroupsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var user User
+			err := json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			if needFolders, ok := toFetch[user.Username]; ok {
+				if needFolders && len(user.VirtualFolders) > 0 {
+					var folders []vfs.VirtualFolder
+					for idx := range user.VirtualFolders {
+						folder := &user.VirtualFolders[idx]
+						baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+						if err != nil {
+							continue
+						}
+						folder.BaseVirtualFolder = baseFolder
+						folders = append(folders, *folder)
+					}
+					user.VirtualFolders = folders
+				}
+				if len(user.Groups) > 0 {
+					groupMapping := make(map[string]Group)
+					for idx := range user.Groups {
+						group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+						if err != nil {
+							continue
+						}
+						groupMapping[group.Name] = group
+					}
+					addIncludedGroupsToMapping(groupMapping, func(name string) (Group, error) {
+						return p.groupExistsInternal(name, groupsBucket)
+					})
+					user.applyGroupSettings(groupMapping)
+				}
+
+				user.SetEmptySecretsIfNil()
+				user.PrepareForRendering()
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+
+	return users, err
+}
+
+func (p *EtcdProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+	users := make([]User, 0, limit)
+	var err error
+	if limit <= 0 {
+		return users, err
+	}
+	err = p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				user, err := p.joinUserAndFolders(v, foldersBucket)
+				if err != nil {
+					return err
+				}
+				if !user.hasRole(role) {
+					continue
+				}
+				user.PrepareForRendering()
+				users = append(users, user)
+				if len(users) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				user, err := p.joinUserAndFolders(v, foldersBucket)
+				if err != nil {
+					return err
+				}
+				if !user.hasRole(role) {
+					continue
+				}
+				user.PrepareForRendering()
+				users = append(users, user)
+				if len(users) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var folder vfs.BaseVirtualFolder
+			err := json.Unmarshal(v, &folder)
+			if err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+		}
+		return nil
+	})
+	return folders, err
+}
+
+func (p *EtcdProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	var err error
+	if limit <= 0 {
+		return folders, err
+	}
+	err = p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var folder vfs.BaseVirtualFolder
+				err = json.Unmarshal(v, &folder)
+				if err != nil {
+					return err
+				}
+				folder.PrepareForRendering()
+				folders = append(folders, folder)
+				if len(folders) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var folder vfs.BaseVirtualFolder
+				err = json.Unmarshal(v, &folder)
+				if err != nil {
+					return err
+				}
+				folder.PrepareForRendering()
+				folders = append(folders, folder)
+				if len(folders) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+	return folders, err
+}
+
+func (p *EtcdProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		var err error
+		folder, err = p.folderExistsInternal(name, bucket)
+		return err
+	})
+	return folder, err
+}
+
+func (p *EtcdProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		if f := bucket.Get(folder.Name); f != nil {
+			return fmt.Errorf("folder %v already exists", folder.Name)
+		}
+		folder.Users = nil
+		folder.Groups = nil
+		return p.addFolderInternal(*folder, bucket)
+	})
+}
+
+func (p *EtcdProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		var f []byte
+
+		if f = bucket.Get(folder.Name); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+		}
+		var oldFolder vfs.BaseVirtualFolder
+		err = json.Unmarshal(f, &oldFolder)
+		if err != nil {
+			return err
+		}
+
+		folder.ID = oldFolder.ID
+		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+		folder.Users = oldFolder.Users
+		folder.Groups = oldFolder.Groups
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(folder.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteFolderMappings(folder vfs.BaseVirtualFolder, usersBucket, groupsBucket *etcdBucket) error {
+	for _, username := range folder.Users {
+		var u []byte
+		if u = usersBucket.Get(username); u == nil {
+			continue
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		var folders []vfs.VirtualFolder
+		for _, userFolder := range user.VirtualFolders {
+			if folder.Name != userFolder.Name {
+				folders = append(folders, userFolder)
+			}
+		}
+		user.VirtualFolders = folders
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = usersBucket.Put(user.Username, buf)
+		if err != nil {
+			return err
+		}
+	}
+	for _, groupname := range folder.Groups {
+		var u []byte
+		if u = groupsBucket.Get(groupname); u == nil {
+			continue
+		}
+		var group Group
+		err := json.Unmarshal(u, &group)
+		if err != nil {
+			return err
+		}
+		var folders []vfs.VirtualFolder
+		for _, groupFolder := range group.VirtualFolders {
+			if folder.Name != groupFolder.Name {
+				folders = append(folders, groupFolder)
+			}
+		}
+		group.VirtualFolders = folders
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		err = groupsBucket.Put(group.Name, buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EtcdProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		usersBucket := tx.Bucket(etcdUsersBucket)
+		groupsBucket := tx.Bucket(etcdGroupsBucket)
+
+		var f []byte
+		if f = bucket.Get(baseFolder.Name); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", baseFolder.Name))
+		}
+		var folder vfs.BaseVirtualFolder
+		err := json.Unmarshal(f, &folder)
+		if err != nil {
+			return err
+		}
+		if err = p.deleteFolderMappings(folder, usersBucket, groupsBucket); err != nil {
+			return err
+		}
+		if err = p.deleteUsersFoldersQuota(tx, "", folder.Name); err != nil {
+			return err
+		}
+
+		return bucket.Delete(folder.Name)
+	})
+}
+
+func (p *EtcdProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersBucket)
+		var f []byte
+		if f = bucket.Get(name); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", name))
+		}
+		var folder vfs.BaseVirtualFolder
+		err := json.Unmarshal(f, &folder)
+		if err != nil {
+			return err
+		}
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(folder.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.getFolderByName(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for folder %q error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p *EtcdProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdFoldersQuotaBucket)
+		if u := tx.Bucket(etcdUsersBucket).Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update quota", username))
+		}
+		if f := tx.Bucket(etcdFoldersBucket).Get(folderName); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", folderName))
+		}
+		key := string(getUserFolderQuotaKey(username, folderName))
+		quota := userFolderQuota{
+			Username:   username,
+			FolderName: folderName,
+		}
+		if q := bucket.Get(key); q != nil {
+			if err := json.Unmarshal(q, &quota); err != nil {
+				return err
+			}
+		}
+		quota.update(filesAdd, sizeAdd, reset)
+		buf, err := json.Marshal(quota)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *EtcdProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	var quota userFolderQuota
+	err := p.view(func(tx *etcdTx) error {
+		if q := tx.Bucket(etcdFoldersQuotaBucket).Get(string(getUserFolderQuotaKey(username, folderName))); q != nil {
+			return json.Unmarshal(q, &quota)
+		}
+		return nil
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %q inside folder %q error: %v", username, folderName, err)
+		return 0, 0, err
+	}
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
+// deleteUsersFoldersQuota removes the per-user folder quota usage for the specified
+// username or folder name
+func (p *EtcdProvider) deleteUsersFoldersQuota(tx *etcdTx, username, folderName string) error {
+	bucket := tx.Bucket(etcdFoldersQuotaBucket)
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+		var quota userFolderQuota
+		if err := json.Unmarshal(v, &quota); err != nil {
+			return err
+		}
+		if (username != "" && quota.Username == username) || (folderName != "" && quota.FolderName == folderName) {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *EtcdProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
+	groups := make([]Group, 0, limit)
+	var err error
+	if limit <= 0 {
+		return groups, err
+	}
+	err = p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var group Group
+				group, err = p.joinGroupAndFolders(v, foldersBucket)
+				if err != nil {
+					return err
+				}
+				group.PrepareForRendering()
+				groups = append(groups, group)
+				if len(groups) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var group Group
+				group, err = p.joinGroupAndFolders(v, foldersBucket)
+				if err != nil {
+					return err
+				}
+				group.PrepareForRendering()
+				groups = append(groups, group)
+				if len(groups) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) getGroupsWithNames(names []string) ([]Group, error) {
+	var groups []Group
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		for _, name := range names {
+			g := bucket.Get(name)
+			if g == nil {
+				continue
+			}
+			group, err := p.joinGroupAndFolders(g, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+		return nil
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) getUsersInGroups(names []string) ([]string, error) {
+	var usernames []string
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		for _, name := range names {
+			g := bucket.Get(name)
+			if g == nil {
+				continue
+			}
+			var group Group
+			err := json.Unmarshal(g, &group)
+			if err != nil {
+				return err
+			}
+			usernames = append(usernames, group.Users...)
+		}
+		return nil
+	})
+	return usernames, err
+}
+
+func (p *EtcdProvider) groupExists(name string) (Group, error) {
+	var group Group
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		g := bucket.Get(name)
+		if g == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+		}
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		var err error
+		group, err = p.joinGroupAndFolders(g, foldersBucket)
+		return err
+	})
+	return group, err
+}
+
+func (p *EtcdProvider) addGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		if u := bucket.Get(group.Name); u != nil {
+			return fmt.Errorf("group %v already exists", group.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		group.ID = int64(id)
+		group.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.Users = nil
+		group.Admins = nil
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(group.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		var g []byte
+		if g = bucket.Get(group.Name); g == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		var oldGroup Group
+		err := json.Unmarshal(g, &oldGroup)
+		if err != nil {
+			return err
+		}
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		group.ID = oldGroup.ID
+		group.CreatedAt = oldGroup.CreatedAt
+		group.Users = oldGroup.Users
+		group.Admins = oldGroup.Admins
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(group.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteGroup(group Group) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		var g []byte
+		if g = bucket.Get(group.Name); g == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		var oldGroup Group
+		err := json.Unmarshal(g, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if len(oldGroup.Users) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", oldGroup.Name))
+		}
+		if len(oldGroup.VirtualFolders) > 0 {
+			foldersBucket := tx.Bucket(etcdFoldersBucket)
+			for idx := range oldGroup.VirtualFolders {
+				err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(oldGroup.Admins) > 0 {
+			adminsBucket := tx.Bucket(etcdAdminsBucket)
+			for idx := range oldGroup.Admins {
+				err = p.removeGroupFromAdminMapping(oldGroup.Name, oldGroup.Admins[idx], adminsBucket)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return bucket.Delete(group.Name)
+	})
+}
+
+func (p *EtcdProvider) dumpGroups() ([]Group, error) {
+	groups := make([]Group, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdGroupsBucket)
+		foldersBucket := tx.Bucket(etcdFoldersBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			group, err := p.joinGroupAndFolders(v, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+		return nil
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) apiKeyExists(keyID string) (APIKey, error) {
+	var apiKey APIKey
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+
+		k := bucket.Get(keyID)
+		if k == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", keyID))
+		}
+		return json.Unmarshal(k, &apiKey)
+	})
+	return apiKey, err
+}
+
+func (p *EtcdProvider) addAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+		if a := bucket.Get(apiKey.KeyID); a != nil {
+			return fmt.Errorf("API key %v already exists", apiKey.KeyID)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		apiKey.ID = int64(id)
+		apiKey.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.LastUseAt = 0
+		if apiKey.User != "" {
+			if err := p.userExistsInternal(tx, apiKey.User); err != nil {
+				return util.NewValidationError(fmt.Sprintf("related user %q does not exists", apiKey.User))
+			}
+		}
+		if apiKey.Admin != "" {
+			if err := p.adminExistsInternal(tx, apiKey.Admin); err != nil {
+				return util.NewValidationError(fmt.Sprintf("related admin %q does not exists", apiKey.User))
+			}
+		}
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(apiKey.KeyID, buf)
+	})
+}
+
+func (p *EtcdProvider) updateAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+		var a []byte
+
+		if a = bucket.Get(apiKey.KeyID); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		var oldAPIKey APIKey
+		err = json.Unmarshal(a, &oldAPIKey)
+		if err != nil {
+			return err
+		}
+
+		apiKey.ID = oldAPIKey.ID
+		apiKey.KeyID = oldAPIKey.KeyID
+		apiKey.Key = oldAPIKey.Key
+		apiKey.CreatedAt = oldAPIKey.CreatedAt
+		apiKey.LastUseAt = oldAPIKey.LastUseAt
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if apiKey.User != "" {
+			if err := p.userExistsInternal(tx, apiKey.User); err != nil {
+				return util.NewValidationError(fmt.Sprintf("related user %q does not exists", apiKey.User))
+			}
+		}
+		if apiKey.Admin != "" {
+			if err := p.adminExistsInternal(tx, apiKey.Admin); err != nil {
+				return util.NewValidationError(fmt.Sprintf("related admin %q does not exists", apiKey.User))
+			}
+		}
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(apiKey.KeyID, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteAPIKey(apiKey APIKey) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+
+		if bucket.Get(apiKey.KeyID) == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+
+		return bucket.Delete(apiKey.KeyID)
+	})
+}
+
+func (p *EtcdProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var apiKey APIKey
+				err := json.Unmarshal(v, &apiKey)
+				if err != nil {
+					return err
+				}
+				apiKey.HideConfidentialData()
+				apiKeys = append(apiKeys, apiKey)
+				if len(apiKeys) >= limit {
+					break
+				}
+			}
+			return nil
+		}
+		for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			var apiKey APIKey
+			err := json.Unmarshal(v, &apiKey)
+			if err != nil {
+				return err
+			}
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			if len(apiKeys) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return apiKeys, err
+}
+
+func (p *EtcdProvider) dumpAPIKeys() ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAPIKeysBucket)
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var apiKey APIKey
+			err := json.Unmarshal(v, &apiKey)
+			if err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+		}
+		return nil
+	})
+
+	return apiKeys, err
+}
+
+func (p *EtcdProvider) shareExists(shareID, username string) (Share, error) {
+	var share Share
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+
+		s := bucket.Get(shareID)
+		if s == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", shareID))
+		}
+		if err := json.Unmarshal(s, &share); err != nil {
+			return err
+		}
+		if username != "" && share.Username != username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", shareID))
+		}
+		return nil
+	})
+	return share, err
+}
+
+func (p *EtcdProvider) addShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+		if a := bucket.Get(share.ShareID); a != nil {
+			return fmt.Errorf("share %v already exists", share.ShareID)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		share.ID = int64(id)
+		if !share.IsRestore {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			share.UpdatedAt = share.CreatedAt
+			share.LastUseAt = 0
+			share.UsedTokens = 0
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		if err := p.userExistsInternal(tx, share.Username); err != nil {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(share.ShareID, buf)
+	})
+}
+
+func (p *EtcdProvider) updateShare(share *Share) error {
+	if err := share.validate(); err != nil {
+		return err
+	}
+
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+		var s []byte
+
+		if s = bucket.Get(share.ShareID); s == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		var oldObject Share
+		if err := json.Unmarshal(s, &oldObject); err != nil {
+			return err
+		}
+		if oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+
+		share.ID = oldObject.ID
+		share.ShareID = oldObject.ShareID
+		if !share.IsRestore {
+			share.UsedTokens = oldObject.UsedTokens
+			share.CreatedAt = oldObject.CreatedAt
+			share.LastUseAt = oldObject.LastUseAt
+			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		if err := p.userExistsInternal(tx, share.Username); err != nil {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(share.ShareID, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteShare(share Share) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+
+		var s []byte
+
+		if s = bucket.Get(share.ShareID); s == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		var oldObject Share
+		if err := json.Unmarshal(s, &oldObject); err != nil {
+			return err
+		}
+		if oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+
+		return bucket.Delete(share.ShareID)
+	})
+}
+
+func (p *EtcdProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				var share Share
+				if err := json.Unmarshal(v, &share); err != nil {
+					return err
+				}
+				if share.Username != username {
+					continue
+				}
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				share.HideConfidentialData()
+				shares = append(shares, share)
+				if len(shares) >= limit {
+					break
+				}
+			}
+			return nil
+		}
+		for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+			var share Share
+			err := json.Unmarshal(v, &share)
+			if err != nil {
+				return err
+			}
+			if share.Username != username {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			share.HideConfidentialData()
+			shares = append(shares, share)
+			if len(shares) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return shares, err
+}
+
+func (p *EtcdProvider) dumpShares() ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var share Share
+			err := json.Unmarshal(v, &share)
+			if err != nil {
+				return err
+			}
+			shares = append(shares, share)
+		}
+		return nil
+	})
+
+	return shares, err
+}
+
+func (p *EtcdProvider) updateShareLastUse(shareID string, numTokens int) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharesBucket)
+		var u []byte
+		if u = bucket.Get(shareID); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update last use", shareID))
+		}
+		var share Share
+		err := json.Unmarshal(u, &share)
+		if err != nil {
+			return err
+		}
+		share.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		share.UsedTokens += numTokens
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(shareID, buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for share %q: %v", shareID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "last use updated for share %q", shareID)
+		return nil
+	})
+}
+
+func (p *EtcdProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
+	return nil, ErrNotImplemented
+}
+
+func (p *EtcdProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) deleteDefenderHost(ip string) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addDefenderEvent(ip string, score int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) cleanupDefender(from int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	return p.update(func(tx *etcdTx) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		transfer.CreatedAt = now
+		transfer.UpdatedAt = now
+		buf, err := json.Marshal(transfer)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(etcdActiveTransfersBucket).Put(getEtcdActiveTransferKey(transfer.ID, transfer.ConnID), buf)
+	})
+}
+
+func (p *EtcdProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActiveTransfersBucket)
+		key := getEtcdActiveTransferKey(transferID, connectionID)
+		t := bucket.Get(key)
+		if t == nil {
+			return nil
+		}
+		var transfer ActiveTransfer
+		if err := json.Unmarshal(t, &transfer); err != nil {
+			return err
+		}
+		transfer.CurrentULSize = ulSize
+		transfer.CurrentDLSize = dlSize
+		transfer.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(transfer)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *EtcdProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	return p.update(func(tx *etcdTx) error {
+		return tx.Bucket(etcdActiveTransfersBucket).Delete(getEtcdActiveTransferKey(transferID, connectionID))
+	})
+}
+
+func (p *EtcdProvider) cleanupActiveTransfers(before time.Time) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActiveTransfersBucket)
+		var toRemove []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var transfer ActiveTransfer
+			if err := json.Unmarshal(v, &transfer); err != nil {
+				return err
+			}
+			if transfer.UpdatedAt < util.GetTimeAsMsSinceEpoch(before) {
+				toRemove = append(toRemove, k)
+			}
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *EtcdProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	transfers := make([]ActiveTransfer, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		cursor := tx.Bucket(etcdActiveTransfersBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var transfer ActiveTransfer
+			if err := json.Unmarshal(v, &transfer); err != nil {
+				return err
+			}
+			if transfer.UpdatedAt > util.GetTimeAsMsSinceEpoch(from) {
+				transfers = append(transfers, transfer)
+			}
+		}
+		return nil
+	})
+	return transfers, err
+}
+
+func (p *EtcdProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(etcdSession{
+		Key:       session.Key,
+		Data:      string(data),
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		return tx.Bucket(etcdSharedSessionsBucket).Put(session.Key, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteSharedSession(key string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharedSessionsBucket)
+		if s := bucket.Get(key); s == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("session %q not found", key))
+		}
+		return bucket.Delete(key)
+	})
+}
+
+func (p *EtcdProvider) getSharedSession(key string) (Session, error) {
+	var session Session
+	err := p.view(func(tx *etcdTx) error {
+		data := tx.Bucket(etcdSharedSessionsBucket).Get(key)
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("session %q not found", key))
+		}
+		var s etcdSession
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		session = s.toSession()
+		return nil
+	})
+	return session, err
+}
+
+func (p *EtcdProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	var sessions []Session
+	err := p.view(func(tx *etcdTx) error {
+		cursor := tx.Bucket(etcdSharedSessionsBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var s etcdSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Type == sessionType {
+				sessions = append(sessions, s.toSession())
+			}
+		}
+		return nil
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Timestamp < sessions[j].Timestamp
+	})
+	return sessions, err
+}
+
+func (p *EtcdProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdSharedSessionsBucket)
+		var toRemove []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var s etcdSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Type == sessionType && s.Timestamp < before {
+				toRemove = append(toRemove, k)
+			}
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *EtcdProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	actions := make([]BaseEventAction, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		itNum := 0
+		cursor := bucket.Cursor()
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var action BaseEventAction
+				err := json.Unmarshal(v, &action)
+				if err != nil {
+					return err
+				}
+				action.PrepareForRendering()
+				actions = append(actions, action)
+				if len(actions) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var action BaseEventAction
+				err := json.Unmarshal(v, &action)
+				if err != nil {
+					return err
+				}
+				action.PrepareForRendering()
+				actions = append(actions, action)
+				if len(actions) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return actions, err
+}
+
+func (p *EtcdProvider) dumpEventActions() ([]BaseEventAction, error) {
+	actions := make([]BaseEventAction, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var action BaseEventAction
+			err := json.Unmarshal(v, &action)
+			if err != nil {
+				return err
+			}
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	return actions, err
+}
+
+func (p *EtcdProvider) eventActionExists(name string) (BaseEventAction, error) {
+	var action BaseEventAction
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		k := bucket.Get(name)
+		if k == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %q does not exist", name))
+		}
+		return json.Unmarshal(k, &action)
+	})
+	return action, err
+}
+
+func (p *EtcdProvider) addEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		if a := bucket.Get(action.Name); a != nil {
+			return fmt.Errorf("event action %s already exists", action.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		action.ID = int64(id)
+		action.Rules = nil
+		buf, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(action.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		var a []byte
+
+		if a = bucket.Get(action.Name); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event action %s does not exist", action.Name))
+		}
+		var oldAction BaseEventAction
+		err = json.Unmarshal(a, &oldAction)
+		if err != nil {
+			return err
+		}
+		action.ID = oldAction.ID
+		action.Name = oldAction.Name
+		action.Rules = nil
+		if len(oldAction.Rules) > 0 {
+			rulesBucket := tx.Bucket(etcdRulesBucket)
+			var relatedRules []string
+			for _, ruleName := range oldAction.Rules {
+				r := rulesBucket.Get(ruleName)
+				if r != nil {
+					relatedRules = append(relatedRules, ruleName)
+					var rule EventRule
+					err := json.Unmarshal(r, &rule)
+					if err != nil {
+						return err
+					}
+					rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+					buf, err := json.Marshal(rule)
+					if err != nil {
+						return err
+					}
+					if err = rulesBucket.Put(rule.Name, buf); err != nil {
+						return err
+					}
+					setLastRuleUpdate()
+				}
+			}
+			action.Rules = relatedRules
+		}
+		buf, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(action.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteEventAction(action BaseEventAction) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdActionsBucket)
+		var a []byte
+
+		if a = bucket.Get(action.Name); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %s does not exist", action.Name))
+		}
+		var oldAction BaseEventAction
+		err := json.Unmarshal(a, &oldAction)
+		if err != nil {
+			return err
+		}
+		if len(oldAction.Rules) > 0 {
+			return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
+		}
+		return bucket.Delete(action.Name)
+	})
+}
+
+func (p *EtcdProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rules := make([]EventRule, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		itNum := 0
+		cursor := bucket.Cursor()
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var rule EventRule
+				var err error
+				rule, err = p.joinRuleAndActions(v, actionsBucket)
+				if err != nil {
+					return err
+				}
+				rule.PrepareForRendering()
+				rules = append(rules, rule)
+				if len(rules) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var rule EventRule
+				var err error
+				rule, err = p.joinRuleAndActions(v, actionsBucket)
+				if err != nil {
+					return err
+				}
+				rule.PrepareForRendering()
+				rules = append(rules, rule)
+				if len(rules) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) dumpEventRules() ([]EventRule, error) {
+	rules := make([]EventRule, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			rule, err := p.joinRuleAndActions(v, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
+	if config.IsShared != 1 && getLastRuleUpdate() < after {
+		return nil, nil
+	}
+	rules := make([]EventRule, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var rule EventRule
+			err := json.Unmarshal(v, &rule)
+			if err != nil {
+				return err
+			}
+			if rule.UpdatedAt < after {
+				continue
+			}
+			var actions []EventAction
+			for idx := range rule.Actions {
+				action := &rule.Actions[idx]
+				var baseAction BaseEventAction
+				k := actionsBucket.Get(action.Name)
+				if k == nil {
+					continue
+				}
+				err = json.Unmarshal(k, &baseAction)
+				if err != nil {
+					continue
+				}
+				baseAction.Options.SetEmptySecretsIfNil()
+				action.BaseEventAction = baseAction
+				actions = append(actions, *action)
+			}
+			rule.Actions = actions
+			rules = append(rules, rule)
+		}
+		cursor = tx.Bucket(etcdDeletedRulesBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var rule EventRule
+			deletedAt, err := unmarshalEtcdTombstone(v, &rule)
+			if err != nil {
+				return err
+			}
+			rule.DeletedAt = deletedAt
+			rules = append(rules, rule)
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) eventRuleExists(name string) (EventRule, error) {
+	var rule EventRule
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		r := bucket.Get(name)
+		if r == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", name))
+		}
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		var err error
+		rule, err = p.joinRuleAndActions(r, actionsBucket)
+		return err
+	})
+	return rule, err
+}
+
+func (p *EtcdProvider) addEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		if r := bucket.Get(rule.Name); r != nil {
+			return fmt.Errorf("event rule %q already exists", rule.Name)
+		}
+		if err := tx.Bucket(etcdDeletedRulesBucket).Delete(rule.Name); err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		rule.ID = int64(id)
+		rule.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		rule.UpdatedAt = rule.CreatedAt
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		buf, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(rule.Name, buf)
+		if err == nil {
+			setLastRuleUpdate()
+		}
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		actionsBucket := tx.Bucket(etcdActionsBucket)
+		var r []byte
+		if r = bucket.Get(rule.Name); r == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		var oldRule EventRule
+		if err := json.Unmarshal(r, &oldRule); err != nil {
+			return err
+		}
+		for idx := range oldRule.Actions {
+			if err := p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		for idx := range rule.Actions {
+			if err := p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		rule.ID = oldRule.ID
+		rule.CreatedAt = oldRule.CreatedAt
+		rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		err = bucket.Put(rule.Name, buf)
+		if err == nil {
+			setLastRuleUpdate()
+		}
+		return err
+	})
+}
+
+func (p *EtcdProvider) deleteEventRule(rule EventRule, softDelete bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRulesBucket)
+		tombstones := tx.Bucket(etcdDeletedRulesBucket)
+		var r []byte
+		if r = bucket.Get(rule.Name); r == nil {
+			if !softDelete && tombstones.Get(rule.Name) != nil {
+				// a soft deleted rule is permanently removed
+				return tombstones.Delete(rule.Name)
+			}
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		var oldRule EventRule
+		if err := json.Unmarshal(r, &oldRule); err != nil {
+			return err
+		}
+		if len(oldRule.Actions) > 0 {
+			actionsBucket := tx.Bucket(etcdActionsBucket)
+			for idx := range oldRule.Actions {
+				if err := p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+					return err
+				}
+			}
+		}
+		if softDelete {
+			return bucket.softDelete(rule.Name, r, tombstones)
+		}
+		return bucket.Delete(rule.Name)
+	})
+}
+
+func (p *EtcdProvider) getTaskByName(name string) (Task, error) {
+	var task Task
+	err := p.view(func(tx *etcdTx) error {
+		t := tx.Bucket(etcdTasksBucket).Get(name)
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q not found", name))
+		}
+		return json.Unmarshal(t, &task)
+	})
+	return task, err
+}
+
+func (p *EtcdProvider) addTask(name string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTasksBucket)
+		if t := bucket.Get(name); t != nil {
+			return fmt.Errorf("task %q already exists", name)
+		}
+		buf, err := json.Marshal(Task{
+			Name:     name,
+			UpdateAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+			Version:  0,
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateTask(name string, version int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTasksBucket)
+		t := bucket.Get(name)
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q not found", name))
+		}
+		var task Task
+		if err := json.Unmarshal(t, &task); err != nil {
+			return err
+		}
+		if task.Version != version {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q with version %d not found", name, version))
+		}
+		task.UpdateAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		task.Version++
+		buf, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateTaskTimestamp(name string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTasksBucket)
+		t := bucket.Get(name)
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q not found", name))
+		}
+		var task Task
+		if err := json.Unmarshal(t, &task); err != nil {
+			return err
+		}
+		task.UpdateAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(name, buf)
+	})
+}
+
+func (p *EtcdProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	buf, err := json.Marshal(Node{
+		Name:      currentNode.Name,
+		Data:      currentNode.Data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	err = p.update(func(tx *etcdTx) error {
+		return tx.Bucket(etcdNodesBucket).Put(currentNode.Name, buf)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+
+	return nil
+}
+
+func (p *EtcdProvider) getNodeByName(name string) (Node, error) {
+	var node Node
+	err := p.view(func(tx *etcdTx) error {
+		n := tx.Bucket(etcdNodesBucket).Get(name)
+		if n == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("node %q not found", name))
+		}
+		if err := json.Unmarshal(n, &node); err != nil {
+			return err
+		}
+		if node.UpdatedAt <= util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff)) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("node %q not found", name))
+		}
+		return nil
+	})
+	return node, err
+}
+
+func (p *EtcdProvider) getNodes() ([]Node, error) {
+	var nodes []Node
+	err := p.view(func(tx *etcdTx) error {
+		cursor := tx.Bucket(etcdNodesBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			if k == currentNode.Name {
+				continue
+			}
+			var node Node
+			if err := json.Unmarshal(v, &node); err != nil {
+				return err
+			}
+			if node.UpdatedAt > util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff)) {
+				nodes = append(nodes, node)
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+func (p *EtcdProvider) updateNodeTimestamp() error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdNodesBucket)
+		n := bucket.Get(currentNode.Name)
+		if n == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("node %q not found", currentNode.Name))
+		}
+		var node Node
+		if err := json.Unmarshal(n, &node); err != nil {
+			return err
+		}
+		node.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(currentNode.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) cleanupNodes() error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdNodesBucket)
+		var toRemove []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var node Node
+			if err := json.Unmarshal(v, &node); err != nil {
+				return err
+			}
+			if node.UpdatedAt < util.GetTimeAsMsSinceEpoch(time.Now().Add(10*activeNodeTimeDiff)) {
+				toRemove = append(toRemove, k)
+			}
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *EtcdProvider) roleExists(name string) (Role, error) {
+	var role Role
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		r := bucket.Get(name)
+		if r == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", name))
+		}
+		return json.Unmarshal(r, &role)
+	})
+	return role, err
+}
+
+func (p *EtcdProvider) addRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		if r := bucket.Get(role.Name); r != nil {
+			return fmt.Errorf("role %q already exists", role.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		role.ID = int64(id)
+		role.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = nil
+		role.Admins = nil
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		var r []byte
+		if r = bucket.Get(role.Name); r == nil {
+			return fmt.Errorf("role %q does not exist", role.Name)
+		}
+		var oldRole Role
+		err := json.Unmarshal(r, &oldRole)
+		if err != nil {
+			return err
+		}
+		role.ID = oldRole.ID
+		role.CreatedAt = oldRole.CreatedAt
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = oldRole.Users
+		role.Admins = oldRole.Admins
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteRole(role Role) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		var r []byte
+		if r = bucket.Get(role.Name); r == nil {
+			return fmt.Errorf("role %q does not exist", role.Name)
+		}
+		var oldRole Role
+		err := json.Unmarshal(r, &oldRole)
+		if err != nil {
+			return err
+		}
+		if len(oldRole.Admins) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
+		}
+		if len(oldRole.Users) > 0 {
+			bucket := tx.Bucket(etcdUsersBucket)
+			for _, username := range oldRole.Users {
+				if err := p.removeRoleFromUser(username, oldRole.Name, bucket); err != nil {
+					return err
+				}
+			}
+		}
+
+		return bucket.Delete(role.Name)
+	})
+}
+
+func (p *EtcdProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
+	roles := make([]Role, 0, limit)
+	if limit <= 0 {
+		return roles, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var role Role
+				err := json.Unmarshal(v, &role)
+				if err != nil {
+					return err
+				}
+				roles = append(roles, role)
+				if len(roles) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var role Role
+				err := json.Unmarshal(v, &role)
+				if err != nil {
+					return err
+				}
+				roles = append(roles, role)
+				if len(roles) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return roles, err
+}
+
+func (p *EtcdProvider) dumpRoles() ([]Role, error) {
+	roles := make([]Role, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdRolesBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var role Role
+			err := json.Unmarshal(v, &role)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, role)
+		}
+		return nil
+	})
+	return roles, err
+}
+
+func (p *EtcdProvider) templateExists(name string) (Template, error) {
+	var template Template
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		t := bucket.Get(name)
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", name))
+		}
+		return json.Unmarshal(t, &template)
+	})
+	return template, err
+}
+
+func (p *EtcdProvider) addTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		if t := bucket.Get(template.Name); t != nil {
+			return fmt.Errorf("template %q already exists", template.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		template.ID = int64(id)
+		template.Version = 1
+		template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(template.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		var t []byte
+		if t = bucket.Get(template.Name); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		var oldTemplate Template
+		err := json.Unmarshal(t, &oldTemplate)
+		if err != nil {
+			return err
+		}
+		template.ID = oldTemplate.ID
+		template.Type = oldTemplate.Type
+		template.Version = oldTemplate.Version + 1
+		template.CreatedAt = oldTemplate.CreatedAt
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(template.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteTemplate(template Template) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		if t := bucket.Get(template.Name); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		return bucket.Delete(template.Name)
+	})
+}
+
+func (p *EtcdProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	templates := make([]Template, 0, limit)
+	if limit <= 0 {
+		return templates, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template Template
+				err := json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template Template
+				err := json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (p *EtcdProvider) dumpTemplates() ([]Template, error) {
+	templates := make([]Template, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTemplatesBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var template Template
+			err := json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, template)
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (p *EtcdProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		t := bucket.Get(name)
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return json.Unmarshal(t, &tenant)
+	})
+	return tenant, err
+}
+
+func (p *EtcdProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		if t := bucket.Get(tenant.Name); t != nil {
+			return fmt.Errorf("tenant %q already exists", tenant.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		tenant.ID = int64(id)
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(tenant.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		var t []byte
+		if t = bucket.Get(tenant.Name); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		var oldTenant Tenant
+		err := json.Unmarshal(t, &oldTenant)
+		if err != nil {
+			return err
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(tenant.Name, buf)
+	})
+}
+
+func (p *EtcdProvider) deleteTenant(tenant Tenant) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		if t := bucket.Get(tenant.Name); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		return bucket.Delete(tenant.Name)
+	})
+}
+
+func (p *EtcdProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err := json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err := json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return tenants, err
+}
+
+func (p *EtcdProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdTenantsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var tenant Tenant
+			err := json.Unmarshal(v, &tenant)
+			if err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+		}
+		return nil
+	})
+	return tenants, err
+}
+
+func (p *EtcdProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
+	entry := IPListEntry{
+		IPOrNet: ipOrNet,
+		Type:    listType,
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		e := bucket.Get(entry.getKey())
+		if e == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		err := json.Unmarshal(e, &entry)
+		if err == nil {
+			entry.PrepareForRendering()
+		}
+		return err
+	})
+	return entry, err
+}
+
+func (p *EtcdProvider) addIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		if e := bucket.Get(entry.getKey()); e != nil {
+			return fmt.Errorf("entry %q already exists", entry.IPOrNet)
+		}
+		if err := tx.Bucket(etcdDeletedIPListsBucket).Delete(entry.getKey()); err != nil {
+			return err
+		}
+		entry.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(entry.getKey(), buf)
+	})
+}
+
+func (p *EtcdProvider) updateIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		var e []byte
+		if e = bucket.Get(entry.getKey()); e == nil {
+			return fmt.Errorf("entry %q does not exist", entry.IPOrNet)
+		}
+		var oldEntry IPListEntry
+		err := json.Unmarshal(e, &oldEntry)
+		if err != nil {
+			return err
+		}
+		entry.CreatedAt = oldEntry.CreatedAt
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(entry.getKey(), buf)
+	})
+}
+
+func (p *EtcdProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		tombstones := tx.Bucket(etcdDeletedIPListsBucket)
+		e := bucket.Get(entry.getKey())
+		if e == nil {
+			if !softDelete && tombstones.Get(entry.getKey()) != nil {
+				// a soft deleted entry is permanently removed
+				return tombstones.Delete(entry.getKey())
+			}
+			return fmt.Errorf("entry %q does not exist", entry.IPOrNet)
+		}
+		if softDelete {
+			return bucket.softDelete(entry.getKey(), e, tombstones)
+		}
+		return bucket.Delete(entry.getKey())
+	})
+}
+
+func (p *EtcdProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 15)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		prefix := fmt.Sprintf("%d_", listType)
+		acceptKey := func(k string) bool {
+			return k != "" && strings.HasPrefix(k, prefix)
+		}
+		cursor := bucket.Cursor()
+		if order == OrderASC {
+			for k, v := cursor.Seek(prefix); acceptKey(k); k, v = cursor.Next() {
+				var entry IPListEntry
+				err := json.Unmarshal(v, &entry)
+				if err != nil {
+					return err
+				}
+				if entry.satisfySearchConstraints(filter, from, order) {
+					entry.PrepareForRendering()
+					entries = append(entries, entry)
+					if limit > 0 && len(entries) >= limit {
+						break
+					}
+				}
+			}
+		} else {
+			cursor.Seek(clientv3.GetPrefixRangeEnd(prefix))
+			for k, v := cursor.Prev(); acceptKey(k); k, v = cursor.Prev() {
+				var entry IPListEntry
+				err := json.Unmarshal(v, &entry)
+				if err != nil {
+					return err
+				}
+				if entry.satisfySearchConstraints(filter, from, order) {
+					entry.PrepareForRendering()
+					entries = append(entries, entry)
+					if limit > 0 && len(entries) >= limit {
+						break
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 5)
+	err := p.view(func(tx *etcdTx) error {
+		cursor := tx.Bucket(etcdIPListsBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var entry IPListEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.UpdatedAt >= after {
+				entries = append(entries, entry)
+			}
+		}
+		cursor = tx.Bucket(etcdDeletedIPListsBucket).Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var entry IPListEntry
+			deletedAt, err := unmarshalEtcdTombstone(v, &entry)
+			if err != nil {
+				return err
+			}
+			entry.DeletedAt = deletedAt
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) dumpIPListEntries() ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		if count := bucket.KeyN(); count > ipListMemoryLimit {
+			providerLog(logger.LevelInfo, "IP lists excluded from dump, too many entries: %d", count)
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var entry IPListEntry
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) countIPListEntries(listType IPListType) (int64, error) {
+	var count int64
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		if listType == 0 {
+			count = int64(bucket.KeyN())
+			return nil
+		}
+		prefix := fmt.Sprintf("%d_", listType)
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != "" && strings.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (p *EtcdProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 3)
+	ipAddr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return entries, fmt.Errorf("invalid ip address %s", ip)
+	}
+	var netType int
+	var ipBytes []byte
+	if ipAddr.Is4() || ipAddr.Is4In6() {
+		netType = ipTypeV4
+		as4 := ipAddr.As4()
+		ipBytes = as4[:]
+	} else {
+		netType = ipTypeV6
+		as16 := ipAddr.As16()
+		ipBytes = as16[:]
+	}
+	err = p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdIPListsBucket)
+		prefix := fmt.Sprintf("%d_", listType)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != "" && strings.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var entry IPListEntry
+			err = json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			if entry.IPType == netType && bytes.Compare(ipBytes, entry.First) >= 0 && bytes.Compare(ipBytes, entry.Last) <= 0 {
+				entry.PrepareForRendering()
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) getConfigs() (Configs, error) {
+	var configs Configs
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdConfigsBucket)
+		data := bucket.Get(etcdConfigsKey)
+		if data != nil {
+			return json.Unmarshal(data, &configs)
+		}
+		return nil
+	})
+	return configs, err
+}
+
+func (p *EtcdProvider) setConfigs(configs *Configs) error {
+	if err := configs.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdConfigsBucket)
+		buf, err := json.Marshal(configs)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(etcdConfigsKey, buf)
+	})
+}
+
+func (p *EtcdProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAuditLogsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = int64(id)
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getEtcdAuditLogKey(id), buf)
+	})
+}
+
+func (p *EtcdProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, search.Limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAuditLogsBucket)
+		itNum := 0
+		cursor := bucket.Cursor()
+		appendEntry := func(v []byte) (bool, error) {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return false, err
+			}
+			if !entry.matches(search) {
+				return false, nil
+			}
+			itNum++
+			if itNum <= search.Offset {
+				return false, nil
+			}
+			entries = append(entries, entry)
+			return len(entries) >= search.Limit, nil
+		}
+		if search.Order == OrderASC {
+			for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+				done, err := appendEntry(v)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != ""; k, v = cursor.Prev() {
+				done, err := appendEntry(v)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) cleanupAuditLogs(before int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdAuditLogsBucket)
+		var keys []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *EtcdProvider) addChangeEvent(event *ChangeEvent) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdChangeEventsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.ID = int64(id)
+		buf, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getEtcdAuditLogKey(id), buf)
+	})
+}
+
+func (p *EtcdProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	events := make([]ChangeEvent, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdChangeEventsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(getEtcdAuditLogKey(uint64(after + 1))); k != "" && len(events) < limit; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *EtcdProvider) cleanupChangeEvents(before int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdChangeEventsBucket)
+		var keys []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if event.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *EtcdProvider) addDeletedObject(object *DeletedObject) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdDeletedObjectsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		object.ID = int64(id)
+		buf, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getEtcdAuditLogKey(id), buf)
+	})
+}
+
+func (p *EtcdProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	objects := make([]DeletedObject, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdDeletedObjectsBucket)
+		itNum := 0
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != "" && len(objects) < limit; k, v = cursor.Prev() {
+			var object DeletedObject
+			if err := json.Unmarshal(v, &object); err != nil {
+				return err
+			}
+			if objectType != "" && object.ObjectType != objectType {
+				continue
+			}
+			if before > 0 && object.DeletedAt >= before {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			objects = append(objects, object)
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (p *EtcdProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	var object DeletedObject
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdDeletedObjectsBucket)
+		v := bucket.Get(getEtcdAuditLogKey(uint64(id)))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+		}
+		return json.Unmarshal(v, &object)
+	})
+	return object, err
+}
+
+func (p *EtcdProvider) removeDeletedObject(id int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdDeletedObjectsBucket)
+		key := getEtcdAuditLogKey(uint64(id))
+		if bucket.Get(key) == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+		}
+		return bucket.Delete(key)
+	})
+}
+
+func (p *EtcdProvider) setFirstDownloadTimestamp(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set download timestamp",
+				username))
+		}
+		var user User
+		err := json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if user.FirstDownload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first download already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstDownload)))
+		}
+		user.FirstDownload = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(username, buf)
+	})
+}
+
+func (p *EtcdProvider) setFirstUploadTimestamp(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.Bucket(etcdUsersBucket)
+		var u []byte
+		if u = bucket.Get(username); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set upload timestamp",
+				username))
+		}
+		var user User
+		if err := json.Unmarshal(u, &user); err != nil {
+			return err
+		}
+		if user.FirstUpload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first upload already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstUpload)))
+		}
+		user.FirstUpload = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(username, buf)
+	})
+}
+
+func (p *EtcdProvider) close() error {
+	if p.cancelWatch != nil {
+		p.cancelWatch()
+	}
+	return p.client.Close()
+}
+
+func (p *EtcdProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase sets the initial schema version, buckets do not need to be created
+func (p *EtcdProvider) initializeDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version > 0 {
+		return ErrNoInitRequired
+	}
+	logger.InfoToConsole("creating initial database schema, version %d", etcdDatabaseVersion)
+	providerLog(logger.LevelInfo, "creating initial database schema, version %d", etcdDatabaseVersion)
+
+	return p.updateDatabaseVersion(etcdDatabaseVersion)
+}
+
+func (p *EtcdProvider) migrateDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == etcdDatabaseVersion:
+		providerLog(logger.LevelDebug, "etcd database is up to date, current version: %d", version)
+		return ErrNoInitRequired
+	case version == 0:
+		err = errors.New("database schema version not found, please initialize the database")
+		providerLog(logger.LevelError, "%v", err)
+		logger.ErrorToConsole("%v", err)
+		return err
+	default:
+		if version > etcdDatabaseVersion {
+			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
+				etcdDatabaseVersion)
+			logger.WarnToConsole("database schema version %d is newer than the supported one: %d", version,
+				etcdDatabaseVersion)
+			return nil
+		}
+		return fmt.Errorf("database schema version not handled: %d", version)
+	}
+}
+
+func (p *EtcdProvider) revertDatabase(targetVersion int) error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
+}
+
+func (p *EtcdProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), longEtcdQueryTimeout)
+	defer cancel()
+
+	_, err := p.client.Delete(ctx, p.namespace+"/", clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("unable to remove the keys with prefix %q: %w", p.namespace+"/", err)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) getDatabaseVersion() (schemaVersion, error) {
+	var dbVersion schemaVersion
+	err := p.view(func(tx *etcdTx) error {
+		v := tx.Bucket(etcdSchemaVersionBucket).Get(etcdSchemaVersionKey)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &dbVersion)
+	})
+	return dbVersion, err
+}
+
+func (p *EtcdProvider) updateDatabaseVersion(version int) error {
+	buf, err := json.Marshal(schemaVersion{
+		Version: version,
+	})
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		return tx.Bucket(etcdSchemaVersionBucket).Put(etcdSchemaVersionKey, buf)
+	})
+}
+
+func (p *EtcdProvider) joinRuleAndActions(r []byte, actionsBucket *etcdBucket) (EventRule, error) {
+	var rule EventRule
+	err := json.Unmarshal(r, &rule)
+	if err != nil {
+		return rule, err
+	}
+	var actions []EventAction
+	for idx := range rule.Actions {
+		action := &rule.Actions[idx]
+		var baseAction BaseEventAction
+		k := actionsBucket.Get(action.Name)
+		if k == nil {
+			continue
+		}
+		err = json.Unmarshal(k, &baseAction)
+		if err != nil {
+			continue
+		}
+		baseAction.Options.SetEmptySecretsIfNil()
+		action.BaseEventAction = baseAction
+		actions = append(actions, *action)
+	}
+	rule.Actions = actions
+	return rule, nil
+}
+
+func (p *EtcdProvider) joinGroupAndFolders(g []byte, foldersBucket *etcdBucket) (Group, error) {
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return group, err
+	}
+	if len(group.VirtualFolders) > 0 {
+		var folders []vfs.VirtualFolder
+		for idx := range group.VirtualFolders {
+			folder := &group.VirtualFolders[idx]
+			baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+			if err != nil {
+				continue
+			}
+			folder.BaseVirtualFolder = baseFolder
+			folders = append(folders, *folder)
+		}
+		group.VirtualFolders = folders
+	}
+	group.SetEmptySecretsIfNil()
+	return group, err
+}
+
+func (p *EtcdProvider) joinUserAndFolders(u []byte, foldersBucket *etcdBucket) (User, error) {
+	var user User
+	err := json.Unmarshal(u, &user)
+	if err != nil {
+		return user, err
+	}
+	if len(user.VirtualFolders) > 0 {
+		var folders []vfs.VirtualFolder
+		for idx := range user.VirtualFolders {
+			folder := &user.VirtualFolders[idx]
+			baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+			if err != nil {
+				continue
+			}
+			folder.BaseVirtualFolder = baseFolder
+			folders = append(folders, *folder)
+		}
+		user.VirtualFolders = folders
+	}
+	user.SetEmptySecretsIfNil()
+	return user, err
+}
+
+func (p *EtcdProvider) groupExistsInternal(name string, bucket *etcdBucket) (Group, error) {
+	var group Group
+	g := bucket.Get(name)
+	if g == nil {
+		err := util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+		return group, err
+	}
+	err := json.Unmarshal(g, &group)
+	return group, err
+}
+
+func (p *EtcdProvider) folderExistsInternal(name string, bucket *etcdBucket) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	f := bucket.Get(name)
+	if f == nil {
+		err := util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
+		return folder, err
+	}
+	err := json.Unmarshal(f, &folder)
+	return folder, err
+}
+
+func (p *EtcdProvider) addFolderInternal(folder vfs.BaseVirtualFolder, bucket *etcdBucket) error {
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	folder.ID = int64(id)
+	buf, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(folder.Name, buf)
+}
+
+func (p *EtcdProvider) removeRoleFromUser(username, role string, bucket *etcdBucket) error {
+	u := bucket.Get(username)
+	if u == nil {
+		providerLog(logger.LevelWarn, "user %q does not exist, cannot remove role %q", username, role)
+		return nil
+	}
+	var user User
+	err := json.Unmarshal(u, &user)
+	if err != nil {
+		return err
+	}
+	if user.Role == role {
+		user.Role = ""
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(user.Username, buf)
+	}
+	providerLog(logger.LevelError, "user %q does not have the expected role %q, actual %q", username, role, user.Role)
+	return nil
+}
+
+func (p *EtcdProvider) removeRoleFromAdmin(username, role string, bucket *etcdBucket) error {
+	a := bucket.Get(username)
+	if a == nil {
+		providerLog(logger.LevelWarn, "admin %q does not exist, cannot remove role %q", username, role)
+		return nil
+	}
+	var admin Admin
+	err := json.Unmarshal(a, &admin)
+	if err != nil {
+		return err
+	}
+	if admin.Role == role {
+		admin.Role = ""
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(admin.Username, buf)
+	}
+	providerLog(logger.LevelError, "admin %q does not have the expected role %q, actual %q", username, role, admin.Role)
+	return nil
+}
+
+func (p *EtcdProvider) addAdminToRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	r := bucket.Get(roleName)
+	if r == nil {
+		return util.NewGenericError(fmt.Sprintf("role %q does not exist", roleName))
+	}
+	var role Role
+	err := json.Unmarshal(r, &role)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(role.Admins, username) {
+		role.Admins = append(role.Admins, username)
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeAdminFromRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	r := bucket.Get(roleName)
+	if r == nil {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove admin %q", roleName, username)
+		return nil
+	}
+	var role Role
+	err := json.Unmarshal(r, &role)
+	if err != nil {
+		return err
+	}
+	if util.Contains(role.Admins, username) {
+		var admins []string
+		for _, admin := range role.Admins {
+			if admin != username {
+				admins = append(admins, admin)
+			}
+		}
+		role.Admins = util.RemoveDuplicates(admins, false)
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addUserToRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	r := bucket.Get(roleName)
+	if r == nil {
+		return util.NewGenericError(fmt.Sprintf("role %q does not exist", roleName))
+	}
+	var role Role
+	err := json.Unmarshal(r, &role)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(role.Users, username) {
+		role.Users = append(role.Users, username)
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeUserFromRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	r := bucket.Get(roleName)
+	if r == nil {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove admin %q", roleName, username)
+		return nil
+	}
+	var role Role
+	err := json.Unmarshal(r, &role)
+	if err != nil {
+		return err
+	}
+	if util.Contains(role.Users, username) {
+		var users []string
+		for _, user := range role.Users {
+			if user != username {
+				users = append(users, user)
+			}
+		}
+		users = util.RemoveDuplicates(users, false)
+		role.Users = users
+		buf, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(role.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addRuleToActionMapping(ruleName, actionName string, bucket *etcdBucket) error {
+	a := bucket.Get(actionName)
+	if a == nil {
+		return util.NewGenericError(fmt.Sprintf("action %q does not exist", actionName))
+	}
+	var action BaseEventAction
+	err := json.Unmarshal(a, &action)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(action.Rules, ruleName) {
+		action.Rules = append(action.Rules, ruleName)
+		buf, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(action.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeRuleFromActionMapping(ruleName, actionName string, bucket *etcdBucket) error {
+	a := bucket.Get(actionName)
+	if a == nil {
+		providerLog(logger.LevelWarn, "action %q does not exist, cannot remove from mapping", actionName)
+		return nil
+	}
+	var action BaseEventAction
+	err := json.Unmarshal(a, &action)
+	if err != nil {
+		return err
+	}
+	if util.Contains(action.Rules, ruleName) {
+		var rules []string
+		for _, r := range action.Rules {
+			if r != ruleName {
+				rules = append(rules, r)
+			}
+		}
+		action.Rules = util.RemoveDuplicates(rules, false)
+		buf, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(action.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addUserToGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	g := bucket.Get(groupname)
+	if g == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(group.Users, username) {
+		group.Users = append(group.Users, username)
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(group.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeUserFromGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	g := bucket.Get(groupname)
+	if g == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return err
+	}
+	var users []string
+	for _, u := range group.Users {
+		if u != username {
+			users = append(users, u)
+		}
+	}
+	group.Users = util.RemoveDuplicates(users, false)
+	buf, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(group.Name, buf)
+}
+
+func (p *EtcdProvider) addAdminToGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	g := bucket.Get(groupname)
+	if g == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(group.Admins, username) {
+		group.Admins = append(group.Admins, username)
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(group.Name, buf)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeAdminFromGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	g := bucket.Get(groupname)
+	if g == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return err
+	}
+	var admins []string
+	for _, a := range group.Admins {
+		if a != username {
+			admins = append(admins, a)
+		}
+	}
+	group.Admins = util.RemoveDuplicates(admins, false)
+	buf, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(group.Name, buf)
+}
+
+func (p *EtcdProvider) removeGroupFromAdminMapping(groupName, adminName string, bucket *etcdBucket) error {
+	var a []byte
+	if a = bucket.Get(adminName); a == nil {
+		// the admin does not exist so there is no associated group
+		return nil
+	}
+	var admin Admin
+	err := json.Unmarshal(a, &admin)
+	if err != nil {
+		return err
+	}
+	var newGroups []AdminGroupMapping
+	for _, g := range admin.Groups {
+		if g.Name != groupName {
+			newGroups = append(newGroups, g)
+		}
+	}
+	admin.Groups = newGroups
+	buf, err := json.Marshal(admin)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(adminName, buf)
+}
+
+func (p *EtcdProvider) addRelationToFolderMapping(baseFolder *vfs.BaseVirtualFolder, user *User, group *Group, bucket *etcdBucket) error {
+	f := bucket.Get(baseFolder.Name)
+	if f == nil {
+		// folder does not exists, try to create
+		baseFolder.LastQuotaUpdate = 0
+		baseFolder.UsedQuotaFiles = 0
+		baseFolder.UsedQuotaSize = 0
+		if user != nil {
+			baseFolder.Users = []string{user.Username}
+		}
+		if group != nil {
+			baseFolder.Groups = []string{group.Name}
+		}
+		return p.addFolderInternal(*baseFolder, bucket)
+	}
+	var oldFolder vfs.BaseVirtualFolder
+	err := json.Unmarshal(f, &oldFolder)
+	if err != nil {
+		return err
+	}
+	baseFolder.ID = oldFolder.ID
+	baseFolder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+	baseFolder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+	baseFolder.UsedQuotaSize = oldFolder.UsedQuotaSize
+	baseFolder.Users = oldFolder.Users
+	baseFolder.Groups = oldFolder.Groups
+	if user != nil && !util.Contains(baseFolder.Users, user.Username) {
+		baseFolder.Users = append(baseFolder.Users, user.Username)
+	}
+	if group != nil && !util.Contains(baseFolder.Groups, group.Name) {
+		baseFolder.Groups = append(baseFolder.Groups, group.Name)
+	}
+	buf, err := json.Marshal(baseFolder)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(baseFolder.Name, buf)
+}
+
+func (p *EtcdProvider) removeRelationFromFolderMapping(folder vfs.VirtualFolder, username, groupname string,
+	bucket *etcdBucket,
+) error {
+	var f []byte
+	if f = bucket.Get(folder.Name); f == nil {
+		// the folder does not exist so there is no associated user/group
+		return nil
+	}
+	var baseFolder vfs.BaseVirtualFolder
+	err := json.Unmarshal(f, &baseFolder)
+	if err != nil {
+		return err
+	}
+	found := false
+	if username != "" {
+		found = true
+		var newUserMapping []string
+		for _, u := range baseFolder.Users {
+			if u != username {
+				newUserMapping = append(newUserMapping, u)
+			}
+		}
+		baseFolder.Users = newUserMapping
+	}
+	if groupname != "" {
+		found = true
+		var newGroupMapping []string
+		for _, g := range baseFolder.Groups {
+			if g != groupname {
+				newGroupMapping = append(newGroupMapping, g)
+			}
+		}
+		baseFolder.Groups = newGroupMapping
+	}
+	if !found {
+		return nil
+	}
+	buf, err := json.Marshal(baseFolder)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(folder.Name, buf)
+}
+
+func (p *EtcdProvider) updateUserRelations(tx *etcdTx, user *User, oldUser User) error {
+	foldersBucket := tx.Bucket(etcdFoldersBucket)
+	groupsBucket := tx.Bucket(etcdGroupsBucket)
+	rolesBucket := tx.Bucket(etcdRolesBucket)
+	for idx := range oldUser.VirtualFolders {
+		err := p.removeRelationFromFolderMapping(oldUser.VirtualFolders[idx], oldUser.Username, "", foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	for idx := range oldUser.Groups {
+		err := p.removeUserFromGroupMapping(user.Username, oldUser.Groups[idx].Name, groupsBucket)
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
+		return err
+	}
+	for idx := range user.VirtualFolders {
+		err := p.addRelationToFolderMapping(&user.VirtualFolders[idx].BaseVirtualFolder, user, nil, foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	for idx := range user.Groups {
+		err := p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket)
+		if err != nil {
+			return err
+		}
+	}
+	return p.addUserToRole(user.Username, user.Role, rolesBucket)
+}
+
+func (p *EtcdProvider) adminExistsInternal(tx *etcdTx, username string) error {
+	bucket := tx.Bucket(etcdAdminsBucket)
+	a := bucket.Get(username)
+	if a == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", username))
+	}
+	return nil
+}
+
+func (p *EtcdProvider) userExistsInternal(tx *etcdTx, username string) error {
+	bucket := tx.Bucket(etcdUsersBucket)
+	u := bucket.Get(username)
+	if u == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+	}
+	return nil
+}
+
+func (p *EtcdProvider) deleteRelatedShares(tx *etcdTx, username string) error {
+	bucket := tx.Bucket(etcdSharesBucket)
+	var toRemove []string
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+		var share Share
+		err := json.Unmarshal(v, &share)
+		if err != nil {
+			return err
+		}
+		if share.Username == username {
+			toRemove = append(toRemove, share.ShareID)
+		}
+	}
+
+	for _, k := range toRemove {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *EtcdProvider) deleteRelatedAPIKey(tx *etcdTx, username string, scope APIKeyScope) error {
+	bucket := tx.Bucket(etcdAPIKeysBucket)
+	var toRemove []string
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != ""; k, v = cursor.Next() {
+		var apiKey APIKey
+		err := json.Unmarshal(v, &apiKey)
+		if err != nil {
+			return err
+		}
+		if scope == APIKeyScopeUser {
+			if apiKey.User == username {
+				toRemove = append(toRemove, apiKey.KeyID)
+			}
+		} else {
+			if apiKey.Admin == username {
+				toRemove = append(toRemove, apiKey.KeyID)
+			}
+		}
+	}
+
+	for _, k := range toRemove {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watchBucket calls fn for each change to the keys of the specified bucket.
+// If the watch is interrupted, it is restarted from the last seen revision,
+// the changes lost because of a compaction are handled by the periodic cache checks
+func (p *EtcdProvider) watchBucket(ctx context.Context, bucket string, fn func(ev *clientv3.Event, key string)) {
+	prefix := p.bucketPrefix(bucket)
+	var lastRev int64
+
+	for {
+		opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV()}
+		if lastRev > 0 {
+			opts = append(opts, clientv3.WithRev(lastRev+1))
+		}
+		providerLog(logger.LevelDebug, "start watching bucket %q, revision: %d", bucket, lastRev)
+		for resp := range p.client.Watch(clientv3.WithRequireLeader(ctx), prefix, opts...) {
+			if err := resp.Err(); err != nil {
+				providerLog(logger.LevelWarn, "unable to watch bucket %q: %v", bucket, err)
+				if resp.CompactRevision > 0 {
+					lastRev = 0
+				}
+				continue
+			}
+			for _, ev := range resp.Events {
+				fn(ev, strings.TrimPrefix(string(ev.Kv.Key), prefix))
+			}
+			lastRev = resp.Header.Revision
+		}
+		select {
+		case <-ctx.Done():
+			providerLog(logger.LevelDebug, "stop watching bucket %q", bucket)
+			return
+		case <-time.After(etcdWatchRetryInterval):
+		}
+	}
+}
+
+func (p *EtcdProvider) onUserChanged(ev *clientv3.Event, username string) {
+	if ev.Type == clientv3.EventTypePut && ev.PrevKv != nil {
+		var user, oldUser User
+		if json.Unmarshal(ev.Kv.Value, &user) == nil && json.Unmarshal(ev.PrevKv.Value, &oldUser) == nil &&
+			user.UpdatedAt == oldUser.UpdatedAt {
+			// quota, last login and similar internal updates do not change the cached data
+			return
+		}
+	}
+	providerLog(logger.LevelDebug, "invalidate caches for user %q, event type: %v", username, ev.Type)
+	webDAVUsersCache.remove(username)
+	cachedPasswords.Remove(username)
+	if ev.Type == clientv3.EventTypeDelete {
+		delayedQuotaUpdater.resetUserQuota(username)
+	}
+}
+
+func (p *EtcdProvider) onIPListEntryChanged(ev *clientv3.Event, key string) {
+	var entry IPListEntry
+	if ev.Type == clientv3.EventTypeDelete {
+		if ev.PrevKv == nil {
+			return
+		}
+		if err := json.Unmarshal(ev.PrevKv.Value, &entry); err != nil {
+			providerLog(logger.LevelError, "unable to unmarshal deleted IP list entry %q: %v", key, err)
+			return
+		}
+		providerLog(logger.LevelDebug, "remove IP list entry %q from cache", entry.getName())
+		for _, l := range inMemoryLists {
+			l.removeEntry(&entry)
+		}
+		return
+	}
+	if err := json.Unmarshal(ev.Kv.Value, &entry); err != nil {
+		providerLog(logger.LevelError, "unable to unmarshal IP list entry %q: %v", key, err)
+		return
+	}
+	providerLog(logger.LevelDebug, "update cache for IP list entry %q", entry.getName())
+	for _, l := range inMemoryLists {
+		l.updateEntry(&entry)
+	}
+}
+
+// softDelete moves the value with the specified key to the tombstones bucket
+func (b *etcdBucket) softDelete(key string, value []byte, tombstones *etcdBucket) error {
+	buf, err := json.Marshal(etcdTombstone{
+		DeletedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+		Data:      value,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tombstones.Put(key, buf); err != nil {
+		return err
+	}
+	return b.Delete(key)
+}
+
+func (s *etcdSession) toSession() Session {
+	return Session{
+		Key:       s.Key,
+		Data:      []byte(s.Data),
+		Type:      s.Type,
+		Timestamp: s.Timestamp,
+	}
+}
+
+func getEtcdActiveTransferKey(transferID int64, connectionID string) string {
+	return fmt.Sprintf("%s_%d", connectionID, transferID)
+}
+
+// getEtcdAuditLogKey returns a zero padded key so the lexicographic order
+// matches the insertion order
+func getEtcdAuditLogKey(id uint64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// unmarshalEtcdTombstone unmarshals the soft deleted object into v and returns the deletion time
+func unmarshalEtcdTombstone(data []byte, v any) (int64, error) {
+	var tombstone etcdTombstone
+	if err := json.Unmarshal(data, &tombstone); err != nil {
+		return 0, err
+	}
+	return tombstone.DeletedAt, json.Unmarshal(tombstone.Data, v)
+}
@@ -15,19 +15,27 @@
 package dataprovider
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/robfig/cron/v3"
 
+	"github.com/drakkan/sftpgo/v2/internal/broker"
+	"github.com/drakkan/sftpgo/v2/internal/cloudfunc"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -47,13 +55,23 @@ const (
 	ActionTypeMetadataCheck
 	ActionTypePasswordExpirationCheck
 	ActionTypeUserExpirationCheck
+	ActionTypeTrashPurge
+	ActionTypeMessageBroker
+	ActionTypeCloudFunction
+	ActionTypeReplication
+	ActionTypeThumbnail
+	ActionTypeChecksumManifest
+	ActionTypePush
+	ActionTypeAccountLifecycleCheck
 )
 
 var (
 	supportedEventActions = []int{ActionTypeHTTP, ActionTypeCommand, ActionTypeEmail, ActionTypeFilesystem,
 		ActionTypeBackup, ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypePasswordExpirationCheck,
-		ActionTypeUserExpirationCheck}
+		ActionTypeUserExpirationCheck, ActionTypeTrashPurge, ActionTypeMessageBroker, ActionTypeCloudFunction,
+		ActionTypeReplication, ActionTypeThumbnail, ActionTypeChecksumManifest, ActionTypePush,
+		ActionTypeAccountLifecycleCheck}
 )
 
 func isActionTypeValid(action int) bool {
@@ -84,6 +102,22 @@ func getActionTypeAsString(action int) string {
 		return "Password expiration check"
 	case ActionTypeUserExpirationCheck:
 		return "User expiration check"
+	case ActionTypeTrashPurge:
+		return "Trash purge"
+	case ActionTypeMessageBroker:
+		return "Message broker"
+	case ActionTypeCloudFunction:
+		return "Cloud function"
+	case ActionTypeReplication:
+		return "Replication"
+	case ActionTypeThumbnail:
+		return "Thumbnail"
+	case ActionTypeChecksumManifest:
+		return "Checksum manifest"
+	case ActionTypePush:
+		return "Push"
+	case ActionTypeAccountLifecycleCheck:
+		return "Account lifecycle check"
 	default:
 		return "Command"
 	}
@@ -99,11 +133,20 @@ const (
 	EventTriggerIPBlocked
 	EventTriggerCertificate
 	EventTriggerOnDemand
+	// Used quota size for users and virtual folders crossing a threshold
+	EventTriggerQuotaThreshold
+	// Automatic rotation of the Cloud Storage credentials
+	EventTriggerCredentialsRotation
 )
 
 var (
 	supportedEventTriggers = []int{EventTriggerFsEvent, EventTriggerProviderEvent, EventTriggerSchedule,
-		EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerOnDemand}
+		EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerOnDemand, EventTriggerQuotaThreshold,
+		EventTriggerCredentialsRotation}
+	// quota threshold rules can be restricted to these provider objects
+	quotaThresholdProviderObjects = []string{actionObjectUser, actionObjectFolder}
+	// credentials rotation rules can be restricted to these provider objects
+	credentialsRotationProviderObjects = []string{actionObjectUser, actionObjectFolder, actionObjectGroup}
 )
 
 func isEventTriggerValid(trigger int) bool {
@@ -122,6 +165,10 @@ func getTriggerTypeAsString(trigger int) string {
 		return "Certificate renewal"
 	case EventTriggerOnDemand:
 		return "On demand"
+	case EventTriggerQuotaThreshold:
+		return "Quota threshold"
+	case EventTriggerCredentialsRotation:
+		return "Credentials rotation"
 	default:
 		return "Schedule"
 	}
@@ -135,6 +182,36 @@ const (
 	FilesystemActionExist
 	FilesystemActionCompress
 	FilesystemActionCopy
+	FilesystemActionPGPEncrypt
+	FilesystemActionPGPDecrypt
+)
+
+// Supported conflict policies for replication actions
+const (
+	// the existing file in the destination folder is overwritten
+	ReplicationConflictOverwrite = iota + 1
+	// the file is not replicated if it already exists in the destination folder
+	ReplicationConflictSkip
+	// the file is replicated using a new name with a timestamp suffix
+	ReplicationConflictRename
+)
+
+// Supported modes for checksum manifest actions
+const (
+	// the checksum of the uploaded file is added to the manifest
+	ChecksumManifestGenerate = iota + 1
+	// the uploaded file is verified against the checksum listed in the manifest
+	ChecksumManifestVerify
+)
+
+// Supported conditions to execute an action depending on the result of a previous one
+const (
+	// the action is executed if the action it depends on succeeded
+	ActionRunOnSuccess = iota + 1
+	// the action is executed if the action it depends on failed
+	ActionRunOnFailure
+	// the action is executed if the action it depends on was executed, regardless of its result
+	ActionRunOnCompletion
 )
 
 const (
@@ -144,9 +221,66 @@ const (
 
 var (
 	supportedFsActions = []int{FilesystemActionRename, FilesystemActionDelete, FilesystemActionMkdirs,
-		FilesystemActionCopy, FilesystemActionCompress, FilesystemActionExist}
+		FilesystemActionCopy, FilesystemActionCompress, FilesystemActionExist, FilesystemActionPGPEncrypt,
+		FilesystemActionPGPDecrypt}
+)
+
+var (
+	supportedReplicationConflictPolicies = []int{ReplicationConflictOverwrite, ReplicationConflictSkip,
+		ReplicationConflictRename}
+)
+
+func getReplicationConflictPolicyAsString(value int) string {
+	switch value {
+	case ReplicationConflictSkip:
+		return "Skip"
+	case ReplicationConflictRename:
+		return "Rename"
+	default:
+		return "Overwrite"
+	}
+}
+
+var (
+	supportedChecksumManifestModes = []int{ChecksumManifestGenerate, ChecksumManifestVerify}
+	// SupportedChecksumManifestAlgorithms defines the supported hash algorithms for checksum manifests
+	SupportedChecksumManifestAlgorithms = []string{"md5", "sha1", "sha224", "sha256", "sha384", "sha512"}
+	fsEventsOnlyActions                 = []int{ActionTypeReplication, ActionTypeThumbnail, ActionTypeChecksumManifest,
+		ActionTypePush}
+)
+
+func getChecksumManifestModeAsString(value int) string {
+	switch value {
+	case ChecksumManifestVerify:
+		return "Verify"
+	default:
+		return "Generate"
+	}
+}
+
+var (
+	supportedActionRunOnConditions = []int{ActionRunOnSuccess, ActionRunOnFailure, ActionRunOnCompletion}
 )
 
+const (
+	maxActionRetries    = 10
+	maxActionRetryDelay = 300
+	// content scan size limits, in KB
+	defaultContentScanSize = 64
+	maxContentScanSize     = 1024
+)
+
+func getActionRunOnAsString(value int) string {
+	switch value {
+	case ActionRunOnFailure:
+		return "On failure"
+	case ActionRunOnCompletion:
+		return "On completion"
+	default:
+		return "On success"
+	}
+}
+
 func isFilesystemActionValid(value int) bool {
 	return util.Contains(supportedFsActions, value)
 }
@@ -163,6 +297,10 @@ func getFsActionTypeAsString(value int) string {
 		return "Compress"
 	case FilesystemActionCopy:
 		return "Copy"
+	case FilesystemActionPGPEncrypt:
+		return "PGP encrypt"
+	case FilesystemActionPGPDecrypt:
+		return "PGP decrypt"
 	default:
 		return "Create directories"
 	}
@@ -172,9 +310,10 @@ func getFsActionTypeAsString(value int) string {
 var (
 	// SupportedFsEvents defines the supported filesystem events
 	SupportedFsEvents = []string{"upload", "pre-upload", "first-upload", "download", "pre-download",
-		"first-download", "delete", "pre-delete", "rename", "mkdir", "rmdir", "copy", "ssh_cmd"}
+		"first-download", "delete", "pre-delete", "rename", "mkdir", "rmdir", "copy", "ssh_cmd", "virus-detected",
+		"dlp-violation"}
 	// SupportedProviderEvents defines the supported provider events
-	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete}
+	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete, operationDisable}
 	// SupportedRuleConditionProtocols defines the supported protcols for rule conditions
 	SupportedRuleConditionProtocols = []string{"SFTP", "SCP", "SSH", "FTP", "DAV", "HTTP", "HTTPShare",
 		"OIDC"}
@@ -192,6 +331,12 @@ var (
 	EventActionTypes  []EnumMapping
 	EventTriggerTypes []EnumMapping
 	FsActionTypes     []EnumMapping
+	// ReplicationConflictPolicies defines the supported replication conflict policies
+	ReplicationConflictPolicies []EnumMapping
+	// ActionRunOnConditions defines the supported conditions for dependent actions
+	ActionRunOnConditions []EnumMapping
+	// ChecksumManifestModes defines the supported modes for checksum manifest actions
+	ChecksumManifestModes []EnumMapping
 )
 
 func init() {
@@ -213,6 +358,24 @@ func init() {
 			Name:  getFsActionTypeAsString(t),
 		})
 	}
+	for _, p := range supportedReplicationConflictPolicies {
+		ReplicationConflictPolicies = append(ReplicationConflictPolicies, EnumMapping{
+			Value: p,
+			Name:  getReplicationConflictPolicyAsString(p),
+		})
+	}
+	for _, m := range supportedChecksumManifestModes {
+		ChecksumManifestModes = append(ChecksumManifestModes, EnumMapping{
+			Value: m,
+			Name:  getChecksumManifestModeAsString(m),
+		})
+	}
+	for _, c := range supportedActionRunOnConditions {
+		ActionRunOnConditions = append(ActionRunOnConditions, EnumMapping{
+			Value: c,
+			Name:  getActionRunOnAsString(c),
+		})
+	}
 }
 
 // EnumMapping defines a mapping between enum values and names
@@ -274,6 +437,8 @@ type EventActionHTTPConfig struct {
 	QueryParameters []KeyValue  `json:"query_parameters,omitempty"`
 	Body            string      `json:"body,omitempty"`
 	Parts           []HTTPPart  `json:"parts,omitempty"`
+	// TextTemplate defines if the body is a Go text/template instead of a text with placeholders
+	TextTemplate bool `json:"text_template,omitempty"`
 }
 
 func (c *EventActionHTTPConfig) isTimeoutNotValid() bool {
@@ -345,7 +510,20 @@ func (c *EventActionHTTPConfig) validate(additionalData string) error {
 			return util.NewValidationError("invalid HTTP query parameters")
 		}
 	}
-	return nil
+	return c.validateTemplate()
+}
+
+func (c *EventActionHTTPConfig) validateTemplate() error {
+	if !c.TextTemplate {
+		return nil
+	}
+	if len(c.Parts) > 0 {
+		return util.NewValidationError("text templates are not supported for multipart requests")
+	}
+	if c.Body == RetentionReportPlaceHolder {
+		return util.NewValidationError("text templates are not supported for the retention report body")
+	}
+	return validateEventTemplate("HTTP body", c.Body)
 }
 
 // GetContext returns the context and the cancel func to use for the HTTP request
@@ -407,12 +585,255 @@ func (c *EventActionHTTPConfig) GetHTTPClient() *http.Client {
 	return client
 }
 
+// EventActionBrokerConfig defines the configuration for a message broker event target
+type EventActionBrokerConfig struct {
+	Protocol      string      `json:"protocol,omitempty"`
+	Endpoint      string      `json:"endpoint,omitempty"`
+	Topic         string      `json:"topic,omitempty"`
+	RoutingKey    string      `json:"routing_key,omitempty"`
+	Username      string      `json:"username,omitempty"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	UseTLS        bool        `json:"use_tls,omitempty"`
+	SkipTLSVerify bool        `json:"skip_tls_verify,omitempty"`
+	Timeout       int         `json:"timeout,omitempty"`
+	Body          string      `json:"body,omitempty"`
+}
+
+func (c *EventActionBrokerConfig) validate(additionalData string) error {
+	if !util.Contains(broker.SupportedProtocols, c.Protocol) {
+		return util.NewValidationError(fmt.Sprintf("unsupported message broker protocol: %q", c.Protocol))
+	}
+	if c.Endpoint == "" {
+		return util.NewValidationError("message broker endpoint is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid message broker endpoint %q, host:port is required", c.Endpoint))
+	}
+	if c.Topic == "" {
+		return util.NewValidationError("message broker topic is required")
+	}
+	if c.Protocol != broker.ProtocolAMQP {
+		c.RoutingKey = ""
+	}
+	if c.Body == "" {
+		return util.NewValidationError("message broker body is required")
+	}
+	if c.Timeout < 1 || c.Timeout > 120 {
+		return util.NewValidationError(fmt.Sprintf("invalid message broker timeout %d", c.Timeout))
+	}
+	if !c.UseTLS {
+		c.SkipTLSVerify = false
+	}
+	if c.Password.IsRedacted() {
+		return util.NewValidationError("cannot save message broker configuration with a redacted secret")
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		err := c.Password.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt message broker password: %v", err))
+		}
+	}
+	return nil
+}
+
+// TryDecryptPassword decrypts the password if encrypted
+func (c *EventActionBrokerConfig) TryDecryptPassword() error {
+	if c.Password != nil && !c.Password.IsEmpty() {
+		if err := c.Password.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt message broker password: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetTLSConfig returns the TLS configuration to use, nil means plain text connections
+func (c *EventActionBrokerConfig) GetTLSConfig() *tls.Config {
+	if !c.UseTLS {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipTLSVerify,
+	}
+}
+
+// GetContext returns the context and the cancel func to use to publish the message
+func (c *EventActionBrokerConfig) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+}
+
+// EventActionFunctionConfig defines the configuration for a cloud function event target
+type EventActionFunctionConfig struct {
+	Provider string `json:"provider,omitempty"`
+	// AWS Lambda function name or ARN, Google Cloud Functions URL
+	Function string `json:"function,omitempty"`
+	// AWS Lambda specific configurations
+	Region       string      `json:"region,omitempty"`
+	AccessKey    string      `json:"access_key,omitempty"`
+	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
+	RoleARN      string      `json:"role_arn,omitempty"`
+	Endpoint     string      `json:"endpoint,omitempty"`
+	// Google service account credentials as JSON
+	Credentials *kms.Secret `json:"credentials,omitempty"`
+	Async       bool        `json:"async,omitempty"`
+	Timeout     int         `json:"timeout,omitempty"`
+	Body        string      `json:"body,omitempty"`
+	// If set, the action fails if this top level field of the JSON
+	// function result is not empty. Ignored for async invocations
+	ResultErrorField string `json:"result_error_field,omitempty"`
+}
+
+func (c *EventActionFunctionConfig) validateSecret(secret *kms.Secret, field, additionalData string) error {
+	if secret.IsRedacted() {
+		return util.NewValidationError(fmt.Sprintf("cannot save cloud function configuration with a redacted %s", field))
+	}
+	if secret.IsPlain() {
+		secret.SetAdditionalData(additionalData)
+		if err := secret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt cloud function %s: %v", field, err))
+		}
+	}
+	return nil
+}
+
+func (c *EventActionFunctionConfig) validateProvider() error {
+	switch c.Provider {
+	case cloudfunc.ProviderAWSLambda:
+		c.Credentials = kms.NewEmptySecret()
+		if c.AccessKey == "" && !c.AccessSecret.IsEmpty() {
+			return util.NewValidationError("AWS access key is required if the access secret is set")
+		}
+		if c.Endpoint != "" && !util.IsStringPrefixInSlice(c.Endpoint, []string{"http://", "https://"}) {
+			return util.NewValidationError("invalid AWS Lambda endpoint schema: http and https are supported")
+		}
+	case cloudfunc.ProviderGCF:
+		c.Region = ""
+		c.AccessKey = ""
+		c.AccessSecret = kms.NewEmptySecret()
+		c.RoleARN = ""
+		c.Endpoint = ""
+		if !util.IsStringPrefixInSlice(c.Function, []string{"http://", "https://"}) {
+			return util.NewValidationError("invalid Google Cloud Functions URL schema: http and https are supported")
+		}
+		if c.Credentials.IsPlain() && !json.Valid([]byte(c.Credentials.GetPayload())) {
+			return util.NewValidationError("invalid Google credentials, a JSON document is required")
+		}
+	default:
+		return util.NewValidationError(fmt.Sprintf("unsupported cloud function provider: %q", c.Provider))
+	}
+	return nil
+}
+
+func (c *EventActionFunctionConfig) validate(additionalData string) error {
+	c.setEmptySecretsIfNil()
+	if c.Function == "" {
+		return util.NewValidationError("cloud function is required")
+	}
+	if err := c.validateProvider(); err != nil {
+		return err
+	}
+	if c.Body == "" {
+		return util.NewValidationError("cloud function body is required")
+	}
+	if c.Timeout < 1 || c.Timeout > 300 {
+		return util.NewValidationError(fmt.Sprintf("invalid cloud function timeout %d", c.Timeout))
+	}
+	if c.Async {
+		c.ResultErrorField = ""
+	}
+	if err := c.validateSecret(c.AccessSecret, "access secret", additionalData); err != nil {
+		return err
+	}
+	return c.validateSecret(c.Credentials, "credentials", additionalData)
+}
+
+// TryDecryptSecrets decrypts the secrets if encrypted
+func (c *EventActionFunctionConfig) TryDecryptSecrets() error {
+	c.setEmptySecretsIfNil()
+	if !c.AccessSecret.IsEmpty() {
+		if err := c.AccessSecret.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt cloud function access secret: %w", err)
+		}
+	}
+	if !c.Credentials.IsEmpty() {
+		if err := c.Credentials.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt cloud function credentials: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetContext returns the context and the cancel func to use to invoke the function
+func (c *EventActionFunctionConfig) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+}
+
+// KeepSecrets replaces the redacted or encrypted secrets with the stored ones.
+// This way an update can leave the existing secrets unchanged
+func (c *EventActionFunctionConfig) KeepSecrets(stored *EventActionFunctionConfig) {
+	c.setEmptySecretsIfNil()
+	if c.AccessSecret.IsNotPlainAndNotEmpty() {
+		c.AccessSecret = stored.AccessSecret
+	}
+	if c.Credentials.IsNotPlainAndNotEmpty() {
+		c.Credentials = stored.Credentials
+	}
+}
+
+func (c *EventActionFunctionConfig) setEmptySecretsIfNil() {
+	if c.AccessSecret == nil {
+		c.AccessSecret = kms.NewEmptySecret()
+	}
+	if c.Credentials == nil {
+		c.Credentials = kms.NewEmptySecret()
+	}
+}
+
+func (c *EventActionFunctionConfig) setNilSecretsIfEmpty() {
+	if c.AccessSecret != nil && c.AccessSecret.IsEmpty() {
+		c.AccessSecret = nil
+	}
+	if c.Credentials != nil && c.Credentials.IsEmpty() {
+		c.Credentials = nil
+	}
+}
+
+func (c *EventActionFunctionConfig) hideConfidentialData() {
+	if c.AccessSecret != nil {
+		c.AccessSecret.Hide()
+	}
+	if c.Credentials != nil {
+		c.Credentials.Hide()
+	}
+}
+
+func (c *EventActionFunctionConfig) getACopy() EventActionFunctionConfig {
+	c.setEmptySecretsIfNil()
+	return EventActionFunctionConfig{
+		Provider:         c.Provider,
+		Function:         c.Function,
+		Region:           c.Region,
+		AccessKey:        c.AccessKey,
+		AccessSecret:     c.AccessSecret.Clone(),
+		RoleARN:          c.RoleARN,
+		Endpoint:         c.Endpoint,
+		Credentials:      c.Credentials.Clone(),
+		Async:            c.Async,
+		Timeout:          c.Timeout,
+		Body:             c.Body,
+		ResultErrorField: c.ResultErrorField,
+	}
+}
+
 // EventActionCommandConfig defines the configuration for a command event target
 type EventActionCommandConfig struct {
 	Cmd     string     `json:"cmd,omitempty"`
 	Args    []string   `json:"args,omitempty"`
 	Timeout int        `json:"timeout,omitempty"`
 	EnvVars []KeyValue `json:"env_vars,omitempty"`
+	// TextTemplate defines if the env vars values are Go text/templates instead of texts with placeholders
+	TextTemplate bool `json:"text_template,omitempty"`
 }
 
 func (c *EventActionCommandConfig) validate() error {
@@ -429,6 +850,11 @@ func (c *EventActionCommandConfig) validate() error {
 		if kv.isNotValid() {
 			return util.NewValidationError("invalid command env vars")
 		}
+		if c.TextTemplate {
+			if err := validateEventTemplate(fmt.Sprintf("env var %q", kv.Key), kv.Value); err != nil {
+				return err
+			}
+		}
 	}
 	c.Args = util.RemoveDuplicates(c.Args, true)
 	for _, arg := range c.Args {
@@ -450,6 +876,8 @@ type EventActionEmailConfig struct {
 	Subject     string   `json:"subject,omitempty"`
 	Body        string   `json:"body,omitempty"`
 	Attachments []string `json:"attachments,omitempty"`
+	// TextTemplate defines if subject and body are Go text/templates instead of texts with placeholders
+	TextTemplate bool `json:"text_template,omitempty"`
 }
 
 // GetRecipientsAsString returns the list of recipients as comma separated string
@@ -487,6 +915,14 @@ func (c *EventActionEmailConfig) validate() error {
 	if c.Body == "" {
 		return util.NewValidationError("email body is required")
 	}
+	if c.TextTemplate {
+		if err := validateEventTemplate("email subject", c.Subject); err != nil {
+			return err
+		}
+		if err := validateEventTemplate("email body", c.Body); err != nil {
+			return err
+		}
+	}
 	for idx, val := range c.Attachments {
 		val = strings.TrimSpace(val)
 		if val == "" {
@@ -518,6 +954,11 @@ type FolderRetention struct {
 	// The default is "false" which means that files will be skipped if the user does not have the permission
 	// to delete them. This applies to sub directories too.
 	IgnoreUserPermissions bool `json:"ignore_user_permissions,omitempty"`
+	// ArchiveFolder is the name of the virtual folder where expired files are moved
+	// before removing them from the user tree, any supported storage backend can be used.
+	// Files are stored inside the folder using the username as top level directory.
+	// If empty, expired files are deleted
+	ArchiveFolder string `json:"archive_folder,omitempty"`
 }
 
 // Validate returns an error if the configuration is not valid
@@ -527,12 +968,19 @@ func (f *FolderRetention) Validate() error {
 		return util.NewValidationError(fmt.Sprintf("invalid folder retention %v, it must be greater or equal to zero",
 			f.Retention))
 	}
+	f.ArchiveFolder = strings.TrimSpace(f.ArchiveFolder)
+	if f.Retention == 0 {
+		f.ArchiveFolder = ""
+	}
 	return nil
 }
 
 // EventActionDataRetentionConfig defines the configuration for a data retention check
 type EventActionDataRetentionConfig struct {
 	Folders []FolderRetention `json:"folders,omitempty"`
+	// DryRun defines whether to only report the files to delete or archive without
+	// changing anything
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 func (c *EventActionDataRetentionConfig) validate() error {
@@ -587,6 +1035,227 @@ func (c *EventActionFsCompress) validate() error {
 	return nil
 }
 
+// readArmoredPGPKeys parses one or more concatenated armored key blocks
+func readArmoredPGPKeys(data string) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	// armor.Decode reuses a bufio.Reader, this way we can read multiple blocks
+	r := bufio.NewReader(strings.NewReader(data))
+	for {
+		block, err := armor.Decode(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		entities, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, entities...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PGP key found")
+	}
+	return keys, nil
+}
+
+// EventActionFsPGP defines the configuration for the PGP encrypt and decrypt filesystem actions
+type EventActionFsPGP struct {
+	// files to encrypt/decrypt, key is the source and target the value
+	Paths []KeyValue `json:"paths,omitempty"`
+	// armored public keys of the recipients, required to encrypt
+	PublicKeys *kms.Secret `json:"public_keys,omitempty"`
+	// armored private key, required to decrypt
+	PrivateKey *kms.Secret `json:"private_key,omitempty"`
+	// optional passphrase for the private key
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+}
+
+func (c *EventActionFsPGP) validatePaths() error {
+	if len(c.Paths) == 0 {
+		return util.NewValidationError("no PGP path specified")
+	}
+	for idx, kv := range c.Paths {
+		key := strings.TrimSpace(kv.Key)
+		value := strings.TrimSpace(kv.Value)
+		if key == "" || value == "" {
+			return util.NewValidationError("invalid PGP paths")
+		}
+		key = util.CleanPath(key)
+		value = util.CleanPath(value)
+		if key == value {
+			return util.NewValidationError("PGP source and target cannot be equal")
+		}
+		if key == "/" || value == "/" {
+			return util.NewValidationError("PGP source and target must be files")
+		}
+		c.Paths[idx] = KeyValue{
+			Key:   key,
+			Value: value,
+		}
+	}
+	return nil
+}
+
+func (c *EventActionFsPGP) validateSecret(secret *kms.Secret, field, additionalData string) error {
+	if secret.IsRedacted() {
+		return util.NewValidationError(fmt.Sprintf("cannot save PGP configuration with a redacted %s", field))
+	}
+	if secret.IsPlain() {
+		secret.SetAdditionalData(additionalData)
+		if err := secret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt PGP %s: %v", field, err))
+		}
+	}
+	return nil
+}
+
+func (c *EventActionFsPGP) validateKeys(secret *kms.Secret, field string) error {
+	if secret.IsEmpty() {
+		return util.NewValidationError(fmt.Sprintf("PGP %s is required", field))
+	}
+	if secret.IsPlain() {
+		if _, err := readArmoredPGPKeys(secret.GetPayload()); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid PGP %s: %v", field, err))
+		}
+	}
+	return nil
+}
+
+func (c *EventActionFsPGP) validate(action int, additionalData string) error {
+	if err := c.validatePaths(); err != nil {
+		return err
+	}
+	if action == FilesystemActionPGPEncrypt {
+		c.PrivateKey = kms.NewEmptySecret()
+		c.Passphrase = kms.NewEmptySecret()
+		if err := c.validateKeys(c.PublicKeys, "public keys"); err != nil {
+			return err
+		}
+		return c.validateSecret(c.PublicKeys, "public keys", additionalData)
+	}
+	c.PublicKeys = kms.NewEmptySecret()
+	if err := c.validateKeys(c.PrivateKey, "private key"); err != nil {
+		return err
+	}
+	if err := c.validateSecret(c.PrivateKey, "private key", additionalData); err != nil {
+		return err
+	}
+	return c.validateSecret(c.Passphrase, "passphrase", additionalData)
+}
+
+func (c *EventActionFsPGP) tryDecryptSecrets() error {
+	for _, secret := range []*kms.Secret{c.PublicKeys, c.PrivateKey, c.Passphrase} {
+		if secret != nil && !secret.IsEmpty() {
+			if err := secret.TryDecrypt(); err != nil {
+				return fmt.Errorf("unable to decrypt PGP secret: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetKeys returns the keys to use for the specified PGP action.
+// For decryption, the private keys are unlocked using the configured passphrase, if any
+func (c *EventActionFsPGP) GetKeys(action int) (openpgp.EntityList, error) {
+	c.setEmptySecretsIfNil()
+	if err := c.tryDecryptSecrets(); err != nil {
+		return nil, err
+	}
+	if action == FilesystemActionPGPEncrypt {
+		keys, err := readArmoredPGPKeys(c.PublicKeys.GetPayload())
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse PGP public keys: %w", err)
+		}
+		return keys, nil
+	}
+	keys, err := readArmoredPGPKeys(c.PrivateKey.GetPayload())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse PGP private key: %w", err)
+	}
+	if c.Passphrase.IsEmpty() {
+		return keys, nil
+	}
+	passphrase := []byte(c.Passphrase.GetPayload())
+	for _, entity := range keys {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+				return nil, fmt.Errorf("unable to decrypt PGP private key: %w", err)
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, fmt.Errorf("unable to decrypt PGP private subkey: %w", err)
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// KeepSecrets restores the secrets from the stored configuration if they are
+// not updated, for example because they are hidden
+func (c *EventActionFsPGP) KeepSecrets(stored *EventActionFsPGP) {
+	c.setEmptySecretsIfNil()
+	if c.PublicKeys.IsNotPlainAndNotEmpty() {
+		c.PublicKeys = stored.PublicKeys
+	}
+	if c.PrivateKey.IsNotPlainAndNotEmpty() {
+		c.PrivateKey = stored.PrivateKey
+	}
+	if c.Passphrase.IsNotPlainAndNotEmpty() {
+		c.Passphrase = stored.Passphrase
+	}
+}
+
+func (c *EventActionFsPGP) setEmptySecretsIfNil() {
+	if c.PublicKeys == nil {
+		c.PublicKeys = kms.NewEmptySecret()
+	}
+	if c.PrivateKey == nil {
+		c.PrivateKey = kms.NewEmptySecret()
+	}
+	if c.Passphrase == nil {
+		c.Passphrase = kms.NewEmptySecret()
+	}
+}
+
+func (c *EventActionFsPGP) setNilSecretsIfEmpty() {
+	if c.PublicKeys != nil && c.PublicKeys.IsEmpty() {
+		c.PublicKeys = nil
+	}
+	if c.PrivateKey != nil && c.PrivateKey.IsEmpty() {
+		c.PrivateKey = nil
+	}
+	if c.Passphrase != nil && c.Passphrase.IsEmpty() {
+		c.Passphrase = nil
+	}
+}
+
+func (c *EventActionFsPGP) hideConfidentialData() {
+	if c.PublicKeys != nil {
+		c.PublicKeys.Hide()
+	}
+	if c.PrivateKey != nil {
+		c.PrivateKey.Hide()
+	}
+	if c.Passphrase != nil {
+		c.Passphrase.Hide()
+	}
+}
+
+func (c *EventActionFsPGP) getACopy() EventActionFsPGP {
+	c.setEmptySecretsIfNil()
+	return EventActionFsPGP{
+		Paths:      cloneKeyValues(c.Paths),
+		PublicKeys: c.PublicKeys.Clone(),
+		PrivateKey: c.PrivateKey.Clone(),
+		Passphrase: c.Passphrase.Clone(),
+	}
+}
+
 // EventActionFilesystemConfig defines the configuration for filesystem actions
 type EventActionFilesystemConfig struct {
 	// Filesystem actions, see the above enum
@@ -603,6 +1272,8 @@ type EventActionFilesystemConfig struct {
 	Copy []KeyValue `json:"copy,omitempty"`
 	// paths to compress and archive name
 	Compress EventActionFsCompress `json:"compress"`
+	// files to PGP encrypt/decrypt and the related keys
+	PGP EventActionFsPGP `json:"pgp"`
 }
 
 // GetDeletesAsString returns the list of items to delete as comma separated string.
@@ -732,7 +1403,7 @@ func (c *EventActionFilesystemConfig) validateExist() error {
 	return nil
 }
 
-func (c *EventActionFilesystemConfig) validate() error {
+func (c *EventActionFilesystemConfig) validate(name string) error {
 	if !isFilesystemActionValid(c.Type) {
 		return util.NewValidationError(fmt.Sprintf("invalid filesystem action type: %d", c.Type))
 	}
@@ -743,6 +1414,7 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Exist = nil
 		c.Copy = nil
 		c.Compress = EventActionFsCompress{}
+		c.PGP = EventActionFsPGP{}
 		if err := c.validateRenames(); err != nil {
 			return err
 		}
@@ -752,6 +1424,7 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Exist = nil
 		c.Copy = nil
 		c.Compress = EventActionFsCompress{}
+		c.PGP = EventActionFsPGP{}
 		if err := c.validateDeletes(); err != nil {
 			return err
 		}
@@ -761,6 +1434,7 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Exist = nil
 		c.Copy = nil
 		c.Compress = EventActionFsCompress{}
+		c.PGP = EventActionFsPGP{}
 		if err := c.validateMkdirs(); err != nil {
 			return err
 		}
@@ -770,6 +1444,7 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.MkDirs = nil
 		c.Copy = nil
 		c.Compress = EventActionFsCompress{}
+		c.PGP = EventActionFsPGP{}
 		if err := c.validateExist(); err != nil {
 			return err
 		}
@@ -779,6 +1454,7 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Deletes = nil
 		c.Exist = nil
 		c.Copy = nil
+		c.PGP = EventActionFsPGP{}
 		if err := c.Compress.validate(); err != nil {
 			return err
 		}
@@ -788,9 +1464,21 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.MkDirs = nil
 		c.Exist = nil
 		c.Compress = EventActionFsCompress{}
+		c.PGP = EventActionFsPGP{}
 		if err := c.validateCopy(); err != nil {
 			return err
 		}
+	case FilesystemActionPGPEncrypt, FilesystemActionPGPDecrypt:
+		c.Renames = nil
+		c.Deletes = nil
+		c.MkDirs = nil
+		c.Exist = nil
+		c.Copy = nil
+		c.Compress = EventActionFsCompress{}
+		c.PGP.setEmptySecretsIfNil()
+		if err := c.PGP.validate(c.Type, name); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -816,14 +1504,18 @@ func (c *EventActionFilesystemConfig) getACopy() EventActionFilesystemConfig {
 			Paths: compressPaths,
 			Name:  c.Compress.Name,
 		},
+		PGP: c.PGP.getACopy(),
 	}
 }
 
 // EventActionPasswordExpiration defines the configuration for password expiration actions
 type EventActionPasswordExpiration struct {
 	// An email notification will be generated for users whose password expires in a number
-	// of days less than or equal to this threshold
+	// of days less than or equal to this threshold.
+	// The threshold can be overridden in the primary group settings
 	Threshold int `json:"threshold,omitempty"`
+	// If enabled, the admins allowed to manage the user are notified too
+	NotifyAdmins bool `json:"notify_admins,omitempty"`
 }
 
 func (c *EventActionPasswordExpiration) validate() error {
@@ -833,6 +1525,202 @@ func (c *EventActionPasswordExpiration) validate() error {
 	return nil
 }
 
+// EventActionAccountLifecycle defines the configuration for account lifecycle actions.
+// Both thresholds can be overridden in the primary group settings
+type EventActionAccountLifecycle struct {
+	// An email notification will be generated for users whose account expires in a number
+	// of days less than or equal to this threshold. 0 means no notification
+	ExpirationThreshold int `json:"expiration_threshold,omitempty"`
+	// Users who have not logged in for more than this number of days are disabled.
+	// Users who never logged in are evaluated based on their creation date.
+	// 0 means no automatic disable
+	InactivityThreshold int `json:"inactivity_threshold,omitempty"`
+	// If enabled, the admins allowed to manage the user are notified about
+	// expiring and disabled accounts
+	NotifyAdmins bool `json:"notify_admins,omitempty"`
+}
+
+func (c *EventActionAccountLifecycle) validate() error {
+	if c.ExpirationThreshold < 0 {
+		return util.NewValidationError("expiration threshold cannot be negative")
+	}
+	if c.InactivityThreshold < 0 {
+		return util.NewValidationError("inactivity threshold cannot be negative")
+	}
+	return nil
+}
+
+// EventActionReplication defines the configuration for replication actions
+type EventActionReplication struct {
+	// Names of the virtual folders to copy the uploaded files to.
+	// Any supported storage backend can be used as destination
+	Folders []string `json:"folders,omitempty"`
+	// Target path inside the destination folders, placeholders are supported.
+	// If empty the virtual path of the uploaded file is used
+	TargetPath string `json:"target_path,omitempty"`
+	// What to do if the target file already exists
+	ConflictPolicy int `json:"conflict_policy,omitempty"`
+	// Number of retries with exponential backoff for failed replications
+	MaxRetries int `json:"max_retries,omitempty"`
+}
+
+func (c *EventActionReplication) validate() error {
+	c.Folders = util.RemoveDuplicates(c.Folders, false)
+	if len(c.Folders) == 0 {
+		return util.NewValidationError("at least one replication folder is required")
+	}
+	for _, folder := range c.Folders {
+		if folder == "" {
+			return util.NewValidationError("invalid replication folder name")
+		}
+	}
+	c.TargetPath = strings.TrimSpace(c.TargetPath)
+	if c.TargetPath != "" {
+		c.TargetPath = util.CleanPath(c.TargetPath)
+		if c.TargetPath == "/" {
+			return util.NewValidationError("invalid replication target path")
+		}
+	}
+	if !util.Contains(supportedReplicationConflictPolicies, c.ConflictPolicy) {
+		return util.NewValidationError(fmt.Sprintf("invalid replication conflict policy: %d", c.ConflictPolicy))
+	}
+	if c.MaxRetries < 0 || c.MaxRetries > 20 {
+		return util.NewValidationError(fmt.Sprintf("invalid replication max retries: %d", c.MaxRetries))
+	}
+	return nil
+}
+
+// GetFoldersAsString returns the list of destination folders as comma separated string
+func (c EventActionReplication) GetFoldersAsString() string {
+	return strings.Join(c.Folders, ",")
+}
+
+func (c *EventActionReplication) getACopy() EventActionReplication {
+	folders := make([]string, len(c.Folders))
+	copy(folders, c.Folders)
+
+	return EventActionReplication{
+		Folders:        folders,
+		TargetPath:     c.TargetPath,
+		ConflictPolicy: c.ConflictPolicy,
+		MaxRetries:     c.MaxRetries,
+	}
+}
+
+// EventActionThumbnail defines the configuration for thumbnail actions
+type EventActionThumbnail struct {
+	// Maximum width and height for the generated previews, the aspect ratio is preserved
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// JPEG quality for the generated previews, 1-100
+	Quality int `json:"quality,omitempty"`
+	// Files bigger than this size, in MB, are skipped. 0 means no limit
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// Optional absolute path to a command used to convert the first page of PDF
+	// files to an image. The command is executed with the PDF file path and the
+	// output image path as arguments. If empty, PDF files are skipped
+	PDFCommand string `json:"pdf_command,omitempty"`
+	// PDF conversion timeout as seconds
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (c *EventActionThumbnail) validate() error {
+	if c.Width < 16 || c.Width > 2048 {
+		return util.NewValidationError(fmt.Sprintf("invalid thumbnail width: %d", c.Width))
+	}
+	if c.Height < 16 || c.Height > 2048 {
+		return util.NewValidationError(fmt.Sprintf("invalid thumbnail height: %d", c.Height))
+	}
+	if c.Quality == 0 {
+		c.Quality = 80
+	}
+	if c.Quality < 1 || c.Quality > 100 {
+		return util.NewValidationError(fmt.Sprintf("invalid thumbnail quality: %d", c.Quality))
+	}
+	if c.MaxFileSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid thumbnail max file size: %d", c.MaxFileSize))
+	}
+	c.PDFCommand = strings.TrimSpace(c.PDFCommand)
+	if c.PDFCommand == "" {
+		c.Timeout = 0
+		return nil
+	}
+	if !filepath.IsAbs(c.PDFCommand) {
+		return util.NewValidationError("the PDF command must be an absolute path")
+	}
+	if c.Timeout < 1 || c.Timeout > 120 {
+		return util.NewValidationError(fmt.Sprintf("invalid PDF command timeout %d", c.Timeout))
+	}
+	return nil
+}
+
+// EventActionChecksumManifest defines the configuration for checksum manifest actions
+type EventActionChecksumManifest struct {
+	// Generate or verify, see the above enum
+	Mode int `json:"mode,omitempty"`
+	// Hash algorithm to use
+	Algorithm string `json:"algorithm,omitempty"`
+	// Virtual path of the manifest, placeholders are supported.
+	// If empty a file named as the uppercase algorithm followed by "SUMS",
+	// for example "SHA256SUMS", in the directory of the uploaded file is used
+	ManifestPath string `json:"manifest_path,omitempty"`
+	// Template for the lines added to the manifest, placeholders and
+	// {{Checksum}} are supported. If empty the sha256sum format is used.
+	// Only manifests in sha256sum format can be verified
+	Template string `json:"template,omitempty"`
+}
+
+func (c *EventActionChecksumManifest) validate() error {
+	if !util.Contains(supportedChecksumManifestModes, c.Mode) {
+		return util.NewValidationError(fmt.Sprintf("invalid checksum manifest mode: %d", c.Mode))
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = "sha256"
+	}
+	if !util.Contains(SupportedChecksumManifestAlgorithms, c.Algorithm) {
+		return util.NewValidationError(fmt.Sprintf("invalid checksum manifest algorithm: %q", c.Algorithm))
+	}
+	c.ManifestPath = strings.TrimSpace(c.ManifestPath)
+	if c.ManifestPath != "" {
+		c.ManifestPath = util.CleanPath(c.ManifestPath)
+		if c.ManifestPath == "/" {
+			return util.NewValidationError("invalid checksum manifest path")
+		}
+	}
+	if c.Mode == ChecksumManifestVerify {
+		c.Template = ""
+	}
+	if c.Template != "" && !strings.Contains(c.Template, "{{Checksum}}") {
+		return util.NewValidationError("the checksum manifest template must contain the {{Checksum}} placeholder")
+	}
+	return nil
+}
+
+// EventActionPush defines the configuration for actions that push the uploaded
+// files to a remote SFTP or FTPS server
+type EventActionPush struct {
+	// Name of the remote endpoint to push the files to
+	Endpoint string `json:"endpoint,omitempty"`
+	// Target path on the remote server, placeholders are supported.
+	// If empty the virtual path of the uploaded file is used
+	TargetPath string `json:"target_path,omitempty"`
+}
+
+func (c *EventActionPush) validate() error {
+	c.Endpoint = strings.TrimSpace(c.Endpoint)
+	if c.Endpoint == "" {
+		return util.NewValidationError("a remote endpoint is required")
+	}
+	c.TargetPath = strings.TrimSpace(c.TargetPath)
+	if c.TargetPath != "" {
+		c.TargetPath = util.CleanPath(c.TargetPath)
+		if c.TargetPath == "/" {
+			return util.NewValidationError("invalid push target path")
+		}
+	}
+	return nil
+}
+
 // BaseEventActionOptions defines the supported configuration options for a base event actions
 type BaseEventActionOptions struct {
 	HTTPConfig          EventActionHTTPConfig          `json:"http_config"`
@@ -841,6 +1729,13 @@ type BaseEventActionOptions struct {
 	RetentionConfig     EventActionDataRetentionConfig `json:"retention_config"`
 	FsConfig            EventActionFilesystemConfig    `json:"fs_config"`
 	PwdExpirationConfig EventActionPasswordExpiration  `json:"pwd_expiration_config"`
+	BrokerConfig        EventActionBrokerConfig        `json:"broker_config"`
+	FunctionConfig      EventActionFunctionConfig      `json:"function_config"`
+	ReplicationConfig   EventActionReplication         `json:"replication_config"`
+	ThumbnailConfig     EventActionThumbnail           `json:"thumbnail_config"`
+	ChecksumConfig      EventActionChecksumManifest    `json:"checksum_config"`
+	PushConfig          EventActionPush                `json:"push_config"`
+	LifecycleConfig     EventActionAccountLifecycle    `json:"lifecycle_config"`
 }
 
 func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
@@ -858,6 +1753,7 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			Retention:             folder.Retention,
 			DeleteEmptyDirs:       folder.DeleteEmptyDirs,
 			IgnoreUserPermissions: folder.IgnoreUserPermissions,
+			ArchiveFolder:         folder.ArchiveFolder,
 		})
 	}
 	httpParts := make([]HTTPPart, 0, len(o.HTTPConfig.Parts))
@@ -882,26 +1778,49 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			QueryParameters: cloneKeyValues(o.HTTPConfig.QueryParameters),
 			Body:            o.HTTPConfig.Body,
 			Parts:           httpParts,
+			TextTemplate:    o.HTTPConfig.TextTemplate,
 		},
 		CmdConfig: EventActionCommandConfig{
-			Cmd:     o.CmdConfig.Cmd,
-			Args:    cmdArgs,
-			Timeout: o.CmdConfig.Timeout,
-			EnvVars: cloneKeyValues(o.CmdConfig.EnvVars),
+			Cmd:          o.CmdConfig.Cmd,
+			Args:         cmdArgs,
+			Timeout:      o.CmdConfig.Timeout,
+			EnvVars:      cloneKeyValues(o.CmdConfig.EnvVars),
+			TextTemplate: o.CmdConfig.TextTemplate,
 		},
 		EmailConfig: EventActionEmailConfig{
-			Recipients:  emailRecipients,
-			Subject:     o.EmailConfig.Subject,
-			Body:        o.EmailConfig.Body,
-			Attachments: emailAttachments,
+			Recipients:   emailRecipients,
+			Subject:      o.EmailConfig.Subject,
+			Body:         o.EmailConfig.Body,
+			Attachments:  emailAttachments,
+			TextTemplate: o.EmailConfig.TextTemplate,
 		},
 		RetentionConfig: EventActionDataRetentionConfig{
 			Folders: folders,
+			DryRun:  o.RetentionConfig.DryRun,
 		},
 		PwdExpirationConfig: EventActionPasswordExpiration{
-			Threshold: o.PwdExpirationConfig.Threshold,
+			Threshold:    o.PwdExpirationConfig.Threshold,
+			NotifyAdmins: o.PwdExpirationConfig.NotifyAdmins,
 		},
 		FsConfig: o.FsConfig.getACopy(),
+		BrokerConfig: EventActionBrokerConfig{
+			Protocol:      o.BrokerConfig.Protocol,
+			Endpoint:      o.BrokerConfig.Endpoint,
+			Topic:         o.BrokerConfig.Topic,
+			RoutingKey:    o.BrokerConfig.RoutingKey,
+			Username:      o.BrokerConfig.Username,
+			Password:      o.BrokerConfig.Password.Clone(),
+			UseTLS:        o.BrokerConfig.UseTLS,
+			SkipTLSVerify: o.BrokerConfig.SkipTLSVerify,
+			Timeout:       o.BrokerConfig.Timeout,
+			Body:          o.BrokerConfig.Body,
+		},
+		FunctionConfig:    o.FunctionConfig.getACopy(),
+		ReplicationConfig: o.ReplicationConfig.getACopy(),
+		ThumbnailConfig:   o.ThumbnailConfig,
+		ChecksumConfig:    o.ChecksumConfig,
+		PushConfig:        o.PushConfig,
+		LifecycleConfig:   o.LifecycleConfig,
 	}
 }
 
@@ -910,18 +1829,33 @@ func (o *BaseEventActionOptions) SetEmptySecretsIfNil() {
 	if o.HTTPConfig.Password == nil {
 		o.HTTPConfig.Password = kms.NewEmptySecret()
 	}
+	if o.BrokerConfig.Password == nil {
+		o.BrokerConfig.Password = kms.NewEmptySecret()
+	}
+	o.FsConfig.PGP.setEmptySecretsIfNil()
+	o.FunctionConfig.setEmptySecretsIfNil()
 }
 
 func (o *BaseEventActionOptions) setNilSecretsIfEmpty() {
 	if o.HTTPConfig.Password != nil && o.HTTPConfig.Password.IsEmpty() {
 		o.HTTPConfig.Password = nil
 	}
+	if o.BrokerConfig.Password != nil && o.BrokerConfig.Password.IsEmpty() {
+		o.BrokerConfig.Password = nil
+	}
+	o.FsConfig.PGP.setNilSecretsIfEmpty()
+	o.FunctionConfig.setNilSecretsIfEmpty()
 }
 
 func (o *BaseEventActionOptions) hideConfidentialData() {
 	if o.HTTPConfig.Password != nil {
 		o.HTTPConfig.Password.Hide()
 	}
+	if o.BrokerConfig.Password != nil {
+		o.BrokerConfig.Password.Hide()
+	}
+	o.FsConfig.PGP.hideConfidentialData()
+	o.FunctionConfig.hideConfidentialData()
 }
 
 func (o *BaseEventActionOptions) validate(action int, name string) error {
@@ -933,6 +1867,13 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 		return o.HTTPConfig.validate(name)
 	case ActionTypeCommand:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -940,6 +1881,13 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 		return o.CmdConfig.validate()
 	case ActionTypeEmail:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -947,6 +1895,13 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 		return o.EmailConfig.validate()
 	case ActionTypeDataRetentionCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -954,6 +1909,13 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.EmailConfig = EventActionEmailConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 		return o.RetentionConfig.validate()
 	case ActionTypeFilesystem:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -961,14 +1923,126 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
-		return o.FsConfig.validate()
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.FsConfig.validate(name)
 	case ActionTypePasswordExpirationCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 		return o.PwdExpirationConfig.validate()
+	case ActionTypeMessageBroker:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.BrokerConfig.validate(name)
+	case ActionTypeCloudFunction:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.FunctionConfig.validate(name)
+	case ActionTypeReplication:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.ReplicationConfig.validate()
+	case ActionTypeThumbnail:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.ThumbnailConfig.validate()
+	case ActionTypeChecksumManifest:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.ChecksumConfig.validate()
+	case ActionTypePush:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
+		return o.PushConfig.validate()
+	case ActionTypeAccountLifecycleCheck:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		return o.LifecycleConfig.validate()
 	default:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
@@ -976,6 +2050,13 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BrokerConfig = EventActionBrokerConfig{}
+		o.FunctionConfig = EventActionFunctionConfig{}
+		o.ReplicationConfig = EventActionReplication{}
+		o.ThumbnailConfig = EventActionThumbnail{}
+		o.ChecksumConfig = EventActionChecksumManifest{}
+		o.PushConfig = EventActionPush{}
+		o.LifecycleConfig = EventActionAccountLifecycle{}
 	}
 	return nil
 }
@@ -1057,6 +2138,60 @@ type EventActionOptions struct {
 	IsFailureAction bool `json:"is_failure_action"`
 	StopOnFailure   bool `json:"stop_on_failure"`
 	ExecuteSync     bool `json:"execute_sync"`
+	// DependsOn is the name of an action, within the same rule, that must be
+	// executed before this one. RunOn defines the result it must have
+	DependsOn string `json:"depends_on,omitempty"`
+	RunOn     int    `json:"run_on,omitempty"`
+	// Retries defines how many times a failed action is retried.
+	// RetryDelay is the delay, in seconds, before the first retry,
+	// it is doubled for each subsequent retry
+	Retries    int `json:"retries,omitempty"`
+	RetryDelay int `json:"retry_delay,omitempty"`
+}
+
+// GetRunOnAsString returns the run on condition as string
+func (o *EventActionOptions) GetRunOnAsString() string {
+	return getActionRunOnAsString(o.RunOn)
+}
+
+// GetRetryDelay returns the delay before the specified retry, starting from 1
+func (o *EventActionOptions) GetRetryDelay(retry int) time.Duration {
+	delay := time.Duration(o.RetryDelay) * time.Second
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if delay >= maxActionRetryDelay*time.Second {
+			return maxActionRetryDelay * time.Second
+		}
+	}
+	return delay
+}
+
+func (o *EventActionOptions) validate(name string) error {
+	if o.DependsOn == "" {
+		o.RunOn = 0
+	} else {
+		if o.DependsOn == name {
+			return util.NewValidationError(fmt.Sprintf("action %q cannot depend on itself", name))
+		}
+		if o.IsFailureAction {
+			return util.NewValidationError("dependencies are not supported for failure actions")
+		}
+		if !util.Contains(supportedActionRunOnConditions, o.RunOn) {
+			return util.NewValidationError(fmt.Sprintf("invalid run on condition: %d", o.RunOn))
+		}
+	}
+	if o.Retries < 0 || o.Retries > maxActionRetries {
+		return util.NewValidationError(fmt.Sprintf("invalid retries %d, it must be between 0 and %d",
+			o.Retries, maxActionRetries))
+	}
+	if o.Retries == 0 {
+		o.RetryDelay = 0
+	}
+	if o.RetryDelay < 0 || o.RetryDelay > maxActionRetryDelay {
+		return util.NewValidationError(fmt.Sprintf("invalid retry delay %d, it must be between 0 and %d",
+			o.RetryDelay, maxActionRetryDelay))
+	}
+	return nil
 }
 
 // EventAction defines an event action
@@ -1075,11 +2210,18 @@ func (a *EventAction) getACopy() EventAction {
 			IsFailureAction: a.Options.IsFailureAction,
 			StopOnFailure:   a.Options.StopOnFailure,
 			ExecuteSync:     a.Options.ExecuteSync,
+			DependsOn:       a.Options.DependsOn,
+			RunOn:           a.Options.RunOn,
+			Retries:         a.Options.Retries,
+			RetryDelay:      a.Options.RetryDelay,
 		},
 	}
 }
 
 func (a *EventAction) validateAssociation(trigger int, fsEvents []string) error {
+	if err := a.Options.validate(a.Name); err != nil {
+		return err
+	}
 	if a.Options.IsFailureAction {
 		if a.Options.ExecuteSync {
 			return util.NewValidationError("sync execution is not supported for failure actions")
@@ -1115,6 +2257,112 @@ func (p *ConditionPattern) validate() error {
 	return nil
 }
 
+// FileContentConditions defines conditions on the content of the files
+// affected by upload and download events
+type FileContentConditions struct {
+	// MIME type patterns, for example "text/*", matched against the type
+	// detected from the first bytes of the file
+	MIMETypes []string `json:"mime_types,omitempty"`
+	MinLines  int      `json:"min_lines,omitempty"`
+	MaxLines  int      `json:"max_lines,omitempty"`
+	// Expected CSV header fields, compared case insensitive
+	CSVHeader []string `json:"csv_header,omitempty"`
+	// Regular expression to search in the first ScanSize KB of the file
+	Pattern  string `json:"pattern,omitempty"`
+	ScanSize int    `json:"scan_size,omitempty"`
+}
+
+// IsEmpty returns true if no content condition is defined
+func (c *FileContentConditions) IsEmpty() bool {
+	return len(c.MIMETypes) == 0 && c.MinLines == 0 && c.MaxLines == 0 && len(c.CSVHeader) == 0 &&
+		c.Pattern == ""
+}
+
+// GetMIMETypesAsString returns the MIME type patterns as comma separated string
+func (c *FileContentConditions) GetMIMETypesAsString() string {
+	return strings.Join(c.MIMETypes, ", ")
+}
+
+// GetCSVHeaderAsString returns the expected CSV header fields as comma separated string
+func (c *FileContentConditions) GetCSVHeaderAsString() string {
+	return strings.Join(c.CSVHeader, ", ")
+}
+
+// NeedsLines returns true if the lines must be counted to check the conditions
+func (c *FileContentConditions) NeedsLines() bool {
+	return c.MinLines > 0 || c.MaxLines > 0
+}
+
+// GetScanSize returns the number of bytes to read to check the content pattern
+func (c *FileContentConditions) GetScanSize() int {
+	if c.Pattern == "" {
+		return 0
+	}
+	if c.ScanSize <= 0 {
+		return defaultContentScanSize * 1024
+	}
+	return c.ScanSize * 1024
+}
+
+func (c *FileContentConditions) getACopy() FileContentConditions {
+	mimeTypes := make([]string, len(c.MIMETypes))
+	copy(mimeTypes, c.MIMETypes)
+	csvHeader := make([]string, len(c.CSVHeader))
+	copy(csvHeader, c.CSVHeader)
+
+	return FileContentConditions{
+		MIMETypes: mimeTypes,
+		MinLines:  c.MinLines,
+		MaxLines:  c.MaxLines,
+		CSVHeader: csvHeader,
+		Pattern:   c.Pattern,
+		ScanSize:  c.ScanSize,
+	}
+}
+
+func (c *FileContentConditions) validate() error {
+	var mimeTypes []string
+	for _, mimeType := range c.MIMETypes {
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		if mimeType == "" {
+			continue
+		}
+		if _, err := path.Match(mimeType, "text/plain"); err != nil || !strings.Contains(mimeType, "/") {
+			return util.NewValidationError(fmt.Sprintf("invalid MIME type pattern %q", mimeType))
+		}
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	c.MIMETypes = util.RemoveDuplicates(mimeTypes, false)
+	if c.MinLines < 0 || c.MaxLines < 0 {
+		return util.NewValidationError("invalid line count condition")
+	}
+	if c.MinLines > 0 && c.MaxLines > 0 && c.MaxLines < c.MinLines {
+		return util.NewValidationError(fmt.Sprintf("invalid max lines %d, it is lesser than min lines %d",
+			c.MaxLines, c.MinLines))
+	}
+	var csvHeader []string
+	for _, field := range c.CSVHeader {
+		csvHeader = append(csvHeader, strings.TrimSpace(field))
+	}
+	if len(csvHeader) == 1 && csvHeader[0] == "" {
+		csvHeader = nil
+	}
+	c.CSVHeader = csvHeader
+	c.Pattern = strings.TrimSpace(c.Pattern)
+	if c.Pattern == "" {
+		c.ScanSize = 0
+		return nil
+	}
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid content pattern %q: %v", c.Pattern, err))
+	}
+	if c.ScanSize < 0 || c.ScanSize > maxContentScanSize {
+		return util.NewValidationError(fmt.Sprintf("invalid content scan size %d KB, max allowed: %d KB",
+			c.ScanSize, maxContentScanSize))
+	}
+	return nil
+}
+
 // ConditionOptions defines options for event conditions
 type ConditionOptions struct {
 	// Usernames or folder names
@@ -1129,6 +2377,8 @@ type ConditionOptions struct {
 	ProviderObjects []string           `json:"provider_objects,omitempty"`
 	MinFileSize     int64              `json:"min_size,omitempty"`
 	MaxFileSize     int64              `json:"max_size,omitempty"`
+	// Conditions on the file content
+	Content FileContentConditions `json:"content"`
 	// allow to execute scheduled tasks concurrently from multiple instances
 	ConcurrentExecution bool `json:"concurrent_execution,omitempty"`
 }
@@ -1148,6 +2398,7 @@ func (f *ConditionOptions) getACopy() ConditionOptions {
 		ProviderObjects:     providerObjects,
 		MinFileSize:         f.MinFileSize,
 		MaxFileSize:         f.MaxFileSize,
+		Content:             f.Content.getACopy(),
 		ConcurrentExecution: f.ConcurrentExecution,
 	}
 }
@@ -1182,6 +2433,9 @@ func (f *ConditionOptions) validate() error {
 				util.ByteCountSI(f.MaxFileSize), util.ByteCountSI(f.MinFileSize)))
 		}
 	}
+	if err := f.Content.validate(); err != nil {
+		return err
+	}
 	if config.IsShared == 0 {
 		f.ConcurrentExecution = false
 	}
@@ -1213,9 +2467,12 @@ func (s *Schedule) validate() error {
 // EventConditions defines the conditions for an event rule
 type EventConditions struct {
 	// Only one between FsEvents, ProviderEvents and Schedule is allowed
-	FsEvents       []string         `json:"fs_events,omitempty"`
-	ProviderEvents []string         `json:"provider_events,omitempty"`
-	Schedules      []Schedule       `json:"schedules,omitempty"`
+	FsEvents       []string   `json:"fs_events,omitempty"`
+	ProviderEvents []string   `json:"provider_events,omitempty"`
+	Schedules      []Schedule `json:"schedules,omitempty"`
+	// Used quota percentage, the rule is triggered when the used quota size
+	// of a user or virtual folder crosses this threshold
+	QuotaThreshold int              `json:"quota_threshold,omitempty"`
 	Options        ConditionOptions `json:"options"`
 }
 
@@ -1238,11 +2495,15 @@ func (c *EventConditions) getACopy() EventConditions {
 		FsEvents:       fsEvents,
 		ProviderEvents: providerEvents,
 		Schedules:      schedules,
+		QuotaThreshold: c.QuotaThreshold,
 		Options:        c.Options.getACopy(),
 	}
 }
 
 func (c *EventConditions) validate(trigger int) error {
+	if trigger != EventTriggerQuotaThreshold {
+		c.QuotaThreshold = 0
+	}
 	switch trigger {
 	case EventTriggerFsEvent:
 		c.ProviderEvents = nil
@@ -1264,6 +2525,7 @@ func (c *EventConditions) validate(trigger int) error {
 		c.Options.Protocols = nil
 		c.Options.MinFileSize = 0
 		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
 		if len(c.ProviderEvents) == 0 {
 			return util.NewValidationError("at least one provider event is required")
 		}
@@ -1279,6 +2541,7 @@ func (c *EventConditions) validate(trigger int) error {
 		c.Options.Protocols = nil
 		c.Options.MinFileSize = 0
 		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
 		c.Options.ProviderObjects = nil
 		if len(c.Schedules) == 0 {
 			return util.NewValidationError("at least one schedule is required")
@@ -1298,6 +2561,7 @@ func (c *EventConditions) validate(trigger int) error {
 		c.Options.Protocols = nil
 		c.Options.MinFileSize = 0
 		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
 		c.Schedules = nil
 	case EventTriggerOnDemand:
 		c.FsEvents = nil
@@ -1306,9 +2570,44 @@ func (c *EventConditions) validate(trigger int) error {
 		c.Options.Protocols = nil
 		c.Options.MinFileSize = 0
 		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
 		c.Options.ProviderObjects = nil
 		c.Schedules = nil
 		c.Options.ConcurrentExecution = false
+	case EventTriggerQuotaThreshold:
+		c.FsEvents = nil
+		c.ProviderEvents = nil
+		c.Options.FsPaths = nil
+		c.Options.Protocols = nil
+		c.Options.MinFileSize = 0
+		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
+		c.Schedules = nil
+		c.Options.ConcurrentExecution = false
+		if c.QuotaThreshold < 1 || c.QuotaThreshold > 100 {
+			return util.NewValidationError(fmt.Sprintf("invalid quota threshold %d, it must be between 1 and 100",
+				c.QuotaThreshold))
+		}
+		for _, obj := range c.Options.ProviderObjects {
+			if !util.Contains(quotaThresholdProviderObjects, obj) {
+				return util.NewValidationError(fmt.Sprintf("unsupported object %q for quota threshold events", obj))
+			}
+		}
+	case EventTriggerCredentialsRotation:
+		c.FsEvents = nil
+		c.ProviderEvents = nil
+		c.Options.FsPaths = nil
+		c.Options.Protocols = nil
+		c.Options.MinFileSize = 0
+		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
+		c.Schedules = nil
+		c.Options.ConcurrentExecution = false
+		for _, obj := range c.Options.ProviderObjects {
+			if !util.Contains(credentialsRotationProviderObjects, obj) {
+				return util.NewValidationError(fmt.Sprintf("unsupported object %q for credentials rotation events", obj))
+			}
+		}
 	default:
 		c.FsEvents = nil
 		c.ProviderEvents = nil
@@ -1318,6 +2617,7 @@ func (c *EventConditions) validate(trigger int) error {
 		c.Options.Protocols = nil
 		c.Options.MinFileSize = 0
 		c.Options.MaxFileSize = 0
+		c.Options.Content = FileContentConditions{}
 		c.Schedules = nil
 	}
 
@@ -1441,12 +2741,45 @@ func (r *EventRule) validate() error {
 	if len(r.Actions) == failureActions {
 		return util.NewValidationError("at least a non-failure action is required")
 	}
+	if err := r.validateDependencies(); err != nil {
+		return err
+	}
 	if !hasSyncAction {
 		return r.validateMandatorySyncActions()
 	}
 	return nil
 }
 
+func (r *EventRule) validateDependencies() error {
+	actions := make(map[string]*EventAction)
+	for idx := range r.Actions {
+		actions[r.Actions[idx].Name] = &r.Actions[idx]
+	}
+	for _, action := range r.Actions {
+		if action.Options.DependsOn == "" {
+			continue
+		}
+		dep, ok := actions[action.Options.DependsOn]
+		if !ok {
+			return util.NewValidationError(fmt.Sprintf("action %q depends on %q that is not associated to the rule",
+				action.Name, action.Options.DependsOn))
+		}
+		if dep.Options.IsFailureAction {
+			return util.NewValidationError(fmt.Sprintf("action %q cannot depend on the failure action %q",
+				action.Name, dep.Name))
+		}
+		if dep.Order >= action.Order {
+			return util.NewValidationError(fmt.Sprintf("action %q must be executed after %q, the action it depends on",
+				action.Name, dep.Name))
+		}
+		if action.Options.ExecuteSync && !dep.Options.ExecuteSync {
+			return util.NewValidationError(fmt.Sprintf("sync action %q cannot depend on the async action %q",
+				action.Name, dep.Name))
+		}
+	}
+	return nil
+}
+
 func (r *EventRule) validateMandatorySyncActions() error {
 	if r.Trigger != EventTriggerFsEvent {
 		return nil
@@ -1462,7 +2795,7 @@ func (r *EventRule) validateMandatorySyncActions() error {
 func (r *EventRule) checkIPBlockedAndCertificateActions() error {
 	unavailableActions := []int{ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem, ActionTypePasswordExpirationCheck,
-		ActionTypeUserExpirationCheck}
+		ActionTypeUserExpirationCheck, ActionTypeTrashPurge, ActionTypeAccountLifecycleCheck}
 	for _, action := range r.Actions {
 		if util.Contains(unavailableActions, action.Type) {
 			return fmt.Errorf("action %q, type %q is not supported for event trigger %q",
@@ -1478,7 +2811,8 @@ func (r *EventRule) checkProviderEventActions(providerObjectType string) error {
 	// affected user. Folder quota reset can be executed only for folders.
 	userSpecificActions := []int{ActionTypeUserQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem,
-		ActionTypePasswordExpirationCheck, ActionTypeUserExpirationCheck}
+		ActionTypePasswordExpirationCheck, ActionTypeUserExpirationCheck, ActionTypeTrashPurge,
+		ActionTypeAccountLifecycleCheck}
 	for _, action := range r.Actions {
 		if util.Contains(userSpecificActions, action.Type) && providerObjectType != actionObjectUser {
 			return fmt.Errorf("action %q, type %q is only supported for provider user events",
@@ -1494,7 +2828,7 @@ func (r *EventRule) checkProviderEventActions(providerObjectType string) error {
 
 func (r *EventRule) hasUserAssociated(providerObjectType string) bool {
 	switch r.Trigger {
-	case EventTriggerProviderEvent:
+	case EventTriggerProviderEvent, EventTriggerQuotaThreshold, EventTriggerCredentialsRotation:
 		return providerObjectType == actionObjectUser
 	case EventTriggerFsEvent:
 		return true
@@ -1511,7 +2845,7 @@ func (r *EventRule) hasUserAssociated(providerObjectType string) bool {
 // CheckActionsConsistency returns an error if the actions cannot be executed
 func (r *EventRule) CheckActionsConsistency(providerObjectType string) error {
 	switch r.Trigger {
-	case EventTriggerProviderEvent:
+	case EventTriggerProviderEvent, EventTriggerQuotaThreshold, EventTriggerCredentialsRotation:
 		if err := r.checkProviderEventActions(providerObjectType); err != nil {
 			return err
 		}
@@ -1539,6 +2873,10 @@ func (r *EventRule) CheckActionsConsistency(providerObjectType string) error {
 				return errors.New("cannot upload file/s for a rule with no user associated")
 			}
 		}
+		if util.Contains(fsEventsOnlyActions, action.Type) && r.Trigger != EventTriggerFsEvent {
+			return fmt.Errorf("action %q, type %q is only supported for filesystem events",
+				action.Name, getActionTypeAsString(action.Type))
+		}
 	}
 	return nil
 }
@@ -0,0 +1,111 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// eventTemplateFuncs defines the functions available inside event action templates.
+// The functions have no side effects, they cannot access the environment, the
+// filesystem or execute commands. Arguments follow the sprig convention so the
+// value to transform is the last one and can be used inside pipelines
+var eventTemplateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, repl, s string) string { return strings.ReplaceAll(s, old, repl) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"quote":      strconv.Quote,
+	"regexMatch": func(regex, s string) (bool, error) {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	},
+	"regexReplace": func(regex, repl, s string) (string, error) {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	"toJson": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	},
+	"default": func(def, v any) any {
+		if v == nil {
+			return def
+		}
+		if s, ok := v.(string); ok && s == "" {
+			return def
+		}
+		return v
+	},
+	"now": func() time.Time { return time.Now().UTC() },
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"dateModify": func(duration string, t time.Time) (time.Time, error) {
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return t, err
+		}
+		return t.Add(d), nil
+	},
+	"unixEpoch":   func(t time.Time) int64 { return t.Unix() },
+	"base":        path.Base,
+	"dir":         path.Dir,
+	"ext":         path.Ext,
+	"urlEscape":   url.QueryEscape,
+	"pathEscape":  url.PathEscape,
+	"formatBytes": util.ByteCountIEC,
+	"add":         func(a, b int64) int64 { return a + b },
+	"sub":         func(a, b int64) int64 { return a - b },
+}
+
+// ParseEventTemplate parses the specified text as Go template using the functions
+// available for event actions
+func ParseEventTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Funcs(eventTemplateFuncs).Parse(text)
+}
+
+func validateEventTemplate(name, text string) error {
+	if _, err := ParseEventTemplate(name, text); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid %s template: %v", name, err))
+	}
+	return nil
+}
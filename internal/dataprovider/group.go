@@ -16,6 +16,7 @@ package dataprovider
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -28,11 +29,49 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
+const (
+	maxGroupNestingDepth = 10
+)
+
 // GroupUserSettings defines the settings to apply to users
 type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// Bandwidth schedules for users without their own schedules
+	BandwidthSchedules []BandwidthSchedule `json:"bandwidth_schedules,omitempty"`
+	// Maximum upload bandwidth, as KB/s, shared by all the transfers of the users
+	// for whom this is the primary group. 0 means unlimited
+	AggregateUploadBandwidth int64 `json:"aggregate_upload_bandwidth,omitempty"`
+	// Maximum download bandwidth, as KB/s, shared by all the transfers of the users
+	// for whom this is the primary group. 0 means unlimited
+	AggregateDownloadBandwidth int64 `json:"aggregate_download_bandwidth,omitempty"`
+	// Names of the DLP policies to apply to the group members, in addition
+	// to the ones assigned to the users and their other groups
+	DLPPolicies []string `json:"dlp_policies,omitempty"`
+	// Overrides the threshold, in days, defined in password and account expiration
+	// check actions for the users for whom this is the primary group. 0 means no override
+	ExpirationWarningThreshold int `json:"expiration_warning_threshold,omitempty"`
+	// Overrides the inactivity threshold, in days, defined in account lifecycle check
+	// actions for the users for whom this is the primary group.
+	// 0 means no override, -1 means that inactive users are never disabled
+	InactivityThreshold int `json:"inactivity_threshold,omitempty"`
+	// Time windows in which the users for whom this is the primary group are
+	// allowed to login, if they have no windows of their own
+	AccessTimeWindows []AccessTimeWindow `json:"access_time_windows,omitempty"`
+	// IANA time zone name used to evaluate the access time windows. Empty means UTC
+	AccessTimeZone string `json:"access_time_zone,omitempty"`
+	// If enabled, the active sessions are terminated outside the access time windows
+	DisconnectOutsideAccessTime bool `json:"disconnect_outside_access_time,omitempty"`
+	// If enabled, the members of this group must use a security key as second
+	// factor for the WebClient
+	RequireWebAuthn bool `json:"require_webauthn,omitempty"`
+	// Policy for the browsers remembered after the second factor authentication
+	// to the WebClient, for the users without their own settings
+	RememberedDevices RememberedDevicesPolicy `json:"remembered_devices,omitempty"`
+	// SSH algorithms allowed for the users for whom this is the primary group.
+	// Each list applies to the users that do not restrict it themselves
+	SSHAlgorithms UserSSHAlgorithms `json:"ssh_algorithms,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -43,6 +82,10 @@ type Group struct {
 	UserSettings GroupUserSettings `json:"user_settings,omitempty"`
 	// Mapping between virtual paths and virtual folders
 	VirtualFolders []vfs.VirtualFolder `json:"virtual_folders,omitempty"`
+	// Names of the groups whose settings are inherited by this group. The settings
+	// defined in the group have precedence over the inherited ones, the included
+	// groups are evaluated in order, so the first ones have precedence
+	IncludedGroups []string `json:"included_groups,omitempty"`
 }
 
 // GetPermissions returns the permissions as list
@@ -100,6 +143,15 @@ func (g *Group) PrepareForRendering() {
 	}
 }
 
+// HideFsConfigs removes the filesystem configurations for the group and the
+// virtual folders, only the storage providers are preserved
+func (g *Group) HideFsConfigs() {
+	g.UserSettings.FsConfig.HideConfig()
+	for idx := range g.VirtualFolders {
+		g.VirtualFolders[idx].FsConfig.HideConfig()
+	}
+}
+
 // RenderAsJSON implements the renderer interface used within plugins
 func (g *Group) RenderAsJSON(reload bool) ([]byte, error) {
 	if reload {
@@ -148,6 +200,10 @@ func (g *Group) validate() error {
 		return err
 	}
 	g.VirtualFolders = vfolders
+	g.normalizeIncludedGroups()
+	if util.Contains(g.IncludedGroups, g.Name) {
+		return util.NewValidationError(fmt.Sprintf("group %q cannot include itself", g.Name))
+	}
 	return g.validateUserSettings()
 }
 
@@ -162,6 +218,7 @@ func (g *Group) validateUserSettings() error {
 	if err := g.UserSettings.FsConfig.Validate(g.GetEncryptionAdditionalData()); err != nil {
 		return err
 	}
+	g.UserSettings.FsConfig.OSConfig.ResetOwnership()
 	if g.UserSettings.TotalDataTransfer > 0 {
 		// if a total data transfer is defined we reset the separate upload and download limits
 		g.UserSettings.UploadDataTransfer = 0
@@ -177,6 +234,33 @@ func (g *Group) validateUserSettings() error {
 	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
 		return err
 	}
+	if err := validateBandwidthSchedules(g.UserSettings.BandwidthSchedules); err != nil {
+		return err
+	}
+	timeZone, err := validateAccessTimeWindows(g.UserSettings.AccessTimeWindows, g.UserSettings.AccessTimeZone)
+	if err != nil {
+		return err
+	}
+	g.UserSettings.AccessTimeZone = timeZone
+	if err := g.UserSettings.RememberedDevices.validate(); err != nil {
+		return err
+	}
+	if err := g.UserSettings.SSHAlgorithms.validate(); err != nil {
+		return err
+	}
+	g.UserSettings.DLPPolicies = util.RemoveDuplicates(g.UserSettings.DLPPolicies, true)
+	if g.UserSettings.AggregateUploadBandwidth < 0 {
+		g.UserSettings.AggregateUploadBandwidth = 0
+	}
+	if g.UserSettings.AggregateDownloadBandwidth < 0 {
+		g.UserSettings.AggregateDownloadBandwidth = 0
+	}
+	if g.UserSettings.ExpirationWarningThreshold < 0 {
+		g.UserSettings.ExpirationWarningThreshold = 0
+	}
+	if g.UserSettings.InactivityThreshold < -1 {
+		return util.NewValidationError("invalid inactivity threshold, it must be -1, 0 or a positive number of days")
+	}
 	if !g.HasExternalAuth() {
 		g.UserSettings.Filters.ExternalAuthCacheTime = 0
 	}
@@ -194,6 +278,13 @@ func (g *Group) getACopy() Group {
 		vfolder := g.VirtualFolders[idx].GetACopy()
 		virtualFolders = append(virtualFolders, vfolder)
 	}
+	dlpPolicies := make([]string, len(g.UserSettings.DLPPolicies))
+	copy(dlpPolicies, g.UserSettings.DLPPolicies)
+	var includedGroups []string
+	if len(g.IncludedGroups) > 0 {
+		includedGroups = make([]string, len(g.IncludedGroups))
+		copy(includedGroups, g.IncludedGroups)
+	}
 	permissions := make(map[string][]string)
 	for k, v := range g.UserSettings.Permissions {
 		perms := make([]string, len(v))
@@ -226,12 +317,30 @@ func (g *Group) getACopy() Group {
 				ExpiresIn:            g.UserSettings.ExpiresIn,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:                    g.UserSettings.FsConfig.GetACopy(),
+			BandwidthSchedules:          copyBandwidthSchedules(g.UserSettings.BandwidthSchedules),
+			AggregateUploadBandwidth:    g.UserSettings.AggregateUploadBandwidth,
+			AggregateDownloadBandwidth:  g.UserSettings.AggregateDownloadBandwidth,
+			DLPPolicies:                 dlpPolicies,
+			ExpirationWarningThreshold:  g.UserSettings.ExpirationWarningThreshold,
+			InactivityThreshold:         g.UserSettings.InactivityThreshold,
+			AccessTimeWindows:           copyAccessTimeWindows(g.UserSettings.AccessTimeWindows),
+			AccessTimeZone:              g.UserSettings.AccessTimeZone,
+			DisconnectOutsideAccessTime: g.UserSettings.DisconnectOutsideAccessTime,
+			RequireWebAuthn:             g.UserSettings.RequireWebAuthn,
+			RememberedDevices:           g.UserSettings.RememberedDevices,
+			SSHAlgorithms:               g.UserSettings.SSHAlgorithms.getACopy(),
 		},
 		VirtualFolders: virtualFolders,
+		IncludedGroups: includedGroups,
 	}
 }
 
+// GetDLPPoliciesAsString returns the DLP policies as comma separated string
+func (g *Group) GetDLPPoliciesAsString() string {
+	return strings.Join(g.UserSettings.DLPPolicies, ",")
+}
+
 // GetMembersAsString returns a string representation for the group members
 func (g *Group) GetMembersAsString() string {
 	var sb strings.Builder
@@ -243,3 +352,362 @@ func (g *Group) GetMembersAsString() string {
 	}
 	return sb.String()
 }
+
+// GetIncludedGroupsAsString returns the included groups as comma separated string
+func (g *Group) GetIncludedGroupsAsString() string {
+	return strings.Join(g.IncludedGroups, ",")
+}
+
+func (g *Group) normalizeIncludedGroups() {
+	var includedGroups []string
+	for _, name := range g.IncludedGroups {
+		name = config.convertName(strings.TrimSpace(name))
+		if name != "" && !util.Contains(includedGroups, name) {
+			includedGroups = append(includedGroups, name)
+		}
+	}
+	g.IncludedGroups = includedGroups
+}
+
+// inheritFrom merges the settings of the given included group. The settings
+// already defined in this group are preserved
+func (g *Group) inheritFrom(included *Group) {
+	settings := &g.UserSettings
+	if settings.HomeDir == "" {
+		settings.HomeDir = included.UserSettings.HomeDir
+	}
+	if settings.FsConfig.Provider == sdk.LocalFilesystemProvider &&
+		included.UserSettings.FsConfig.Provider != sdk.LocalFilesystemProvider {
+		settings.FsConfig = included.UserSettings.FsConfig.GetACopy()
+	}
+	if settings.MaxSessions == 0 {
+		settings.MaxSessions = included.UserSettings.MaxSessions
+	}
+	if settings.QuotaSize == 0 {
+		settings.QuotaSize = included.UserSettings.QuotaSize
+	}
+	if settings.QuotaFiles == 0 {
+		settings.QuotaFiles = included.UserSettings.QuotaFiles
+	}
+	if settings.UploadBandwidth == 0 {
+		settings.UploadBandwidth = included.UserSettings.UploadBandwidth
+	}
+	if settings.DownloadBandwidth == 0 {
+		settings.DownloadBandwidth = included.UserSettings.DownloadBandwidth
+	}
+	if settings.UploadDataTransfer == 0 && settings.DownloadDataTransfer == 0 && settings.TotalDataTransfer == 0 {
+		settings.UploadDataTransfer = included.UserSettings.UploadDataTransfer
+		settings.DownloadDataTransfer = included.UserSettings.DownloadDataTransfer
+		settings.TotalDataTransfer = included.UserSettings.TotalDataTransfer
+	}
+	if settings.ExpiresIn == 0 {
+		settings.ExpiresIn = included.UserSettings.ExpiresIn
+	}
+	if len(settings.BandwidthSchedules) == 0 {
+		settings.BandwidthSchedules = copyBandwidthSchedules(included.UserSettings.BandwidthSchedules)
+	}
+	if settings.AggregateUploadBandwidth == 0 {
+		settings.AggregateUploadBandwidth = included.UserSettings.AggregateUploadBandwidth
+	}
+	if settings.AggregateDownloadBandwidth == 0 {
+		settings.AggregateDownloadBandwidth = included.UserSettings.AggregateDownloadBandwidth
+	}
+	if settings.ExpirationWarningThreshold == 0 {
+		settings.ExpirationWarningThreshold = included.UserSettings.ExpirationWarningThreshold
+	}
+	if settings.InactivityThreshold == 0 {
+		settings.InactivityThreshold = included.UserSettings.InactivityThreshold
+	}
+	if len(settings.AccessTimeWindows) == 0 && len(included.UserSettings.AccessTimeWindows) > 0 {
+		settings.AccessTimeWindows = copyAccessTimeWindows(included.UserSettings.AccessTimeWindows)
+		settings.AccessTimeZone = included.UserSettings.AccessTimeZone
+		settings.DisconnectOutsideAccessTime = included.UserSettings.DisconnectOutsideAccessTime
+	}
+	if included.UserSettings.RequireWebAuthn {
+		settings.RequireWebAuthn = true
+	}
+	settings.RememberedDevices.merge(included.UserSettings.RememberedDevices)
+	settings.SSHAlgorithms.merge(included.UserSettings.SSHAlgorithms)
+	for _, policy := range included.UserSettings.DLPPolicies {
+		if !util.Contains(settings.DLPPolicies, policy) {
+			settings.DLPPolicies = append(settings.DLPPolicies, policy)
+		}
+	}
+	if settings.Permissions == nil {
+		settings.Permissions = make(map[string][]string)
+	}
+	for k, v := range included.UserSettings.Permissions {
+		if _, ok := settings.Permissions[k]; !ok {
+			perms := make([]string, len(v))
+			copy(perms, v)
+			settings.Permissions[k] = perms
+		}
+	}
+	g.inheritFilters(&included.UserSettings.Filters)
+	folderPaths := make(map[string]bool)
+	for _, folder := range g.VirtualFolders {
+		folderPaths[folder.VirtualPath] = true
+	}
+	for idx := range included.VirtualFolders {
+		if _, ok := folderPaths[included.VirtualFolders[idx].VirtualPath]; !ok {
+			g.VirtualFolders = append(g.VirtualFolders, included.VirtualFolders[idx].GetACopy())
+		}
+	}
+}
+
+func (g *Group) inheritFilters(included *sdk.BaseUserFilters) {
+	filters := &g.UserSettings.Filters
+	if filters.MaxUploadFileSize == 0 {
+		filters.MaxUploadFileSize = included.MaxUploadFileSize
+	}
+	if filters.TLSUsername == "" || filters.TLSUsername == sdk.TLSUsernameNone {
+		filters.TLSUsername = included.TLSUsername
+	}
+	filters.Hooks.CheckPasswordDisabled = filters.Hooks.CheckPasswordDisabled || included.Hooks.CheckPasswordDisabled
+	filters.Hooks.PreLoginDisabled = filters.Hooks.PreLoginDisabled || included.Hooks.PreLoginDisabled
+	filters.Hooks.ExternalAuthDisabled = filters.Hooks.ExternalAuthDisabled || included.Hooks.ExternalAuthDisabled
+	filters.DisableFsChecks = filters.DisableFsChecks || included.DisableFsChecks
+	filters.AllowAPIKeyAuth = filters.AllowAPIKeyAuth || included.AllowAPIKeyAuth
+	filters.IsAnonymous = filters.IsAnonymous || included.IsAnonymous
+	if filters.ExternalAuthCacheTime == 0 {
+		filters.ExternalAuthCacheTime = included.ExternalAuthCacheTime
+	}
+	if filters.FTPSecurity == 0 {
+		filters.FTPSecurity = included.FTPSecurity
+	}
+	if filters.StartDirectory == "" {
+		filters.StartDirectory = included.StartDirectory
+	}
+	if filters.DefaultSharesExpiration == 0 {
+		filters.DefaultSharesExpiration = included.DefaultSharesExpiration
+	}
+	if filters.PasswordExpiration == 0 {
+		filters.PasswordExpiration = included.PasswordExpiration
+	}
+	if filters.PasswordStrength == 0 {
+		filters.PasswordStrength = included.PasswordStrength
+	}
+	filters.AllowedIP = util.RemoveDuplicates(append(filters.AllowedIP, included.AllowedIP...), false)
+	filters.DeniedIP = util.RemoveDuplicates(append(filters.DeniedIP, included.DeniedIP...), false)
+	filters.DeniedLoginMethods = util.RemoveDuplicates(append(filters.DeniedLoginMethods,
+		included.DeniedLoginMethods...), false)
+	filters.DeniedProtocols = util.RemoveDuplicates(append(filters.DeniedProtocols, included.DeniedProtocols...), false)
+	filters.WebClient = util.RemoveDuplicates(append(filters.WebClient, included.WebClient...), false)
+	filters.TwoFactorAuthProtocols = util.RemoveDuplicates(append(filters.TwoFactorAuthProtocols,
+		included.TwoFactorAuthProtocols...), false)
+	filters.BandwidthLimits = append(filters.BandwidthLimits, included.BandwidthLimits...)
+	filters.DataTransferLimits = append(filters.DataTransferLimits, included.DataTransferLimits...)
+	patternPaths := make(map[string]bool)
+	for _, pattern := range filters.FilePatterns {
+		patternPaths[pattern.Path] = true
+	}
+	for _, pattern := range included.FilePatterns {
+		if _, ok := patternPaths[pattern.Path]; !ok {
+			filters.FilePatterns = append(filters.FilePatterns, pattern)
+		}
+	}
+}
+
+// resolveGroupInclusions returns a copy of the group with the settings of the
+// included groups, looked up in groupsMapping, merged in.
+// path contains the groups already visited and it is used to stop on cycles
+func resolveGroupInclusions(group *Group, groupsMapping map[string]Group, path []string) Group {
+	resolved := group.getACopy()
+	for _, name := range group.IncludedGroups {
+		if util.Contains(path, name) || len(path) > maxGroupNestingDepth {
+			providerLog(logger.LevelError, "unable to include group %q in %q, cycle or too many nesting levels",
+				name, group.Name)
+			continue
+		}
+		included, ok := groupsMapping[name]
+		if !ok {
+			providerLog(logger.LevelError, "included group %q not found for group %q", name, group.Name)
+			continue
+		}
+		included = resolveGroupInclusions(&included, groupsMapping, append(path[:len(path):len(path)], name))
+		resolved.inheritFrom(&included)
+	}
+	return resolved
+}
+
+// getResolvedGroup returns the group with the given name, from groupsMapping,
+// with the settings of the included groups merged in
+func getResolvedGroup(name string, groupsMapping map[string]Group) (Group, bool) {
+	group, ok := groupsMapping[name]
+	if !ok {
+		return group, false
+	}
+	if len(group.IncludedGroups) == 0 {
+		return group, true
+	}
+	return resolveGroupInclusions(&group, groupsMapping, []string{group.Name}), true
+}
+
+// addIncludedGroupsToMapping adds to groupsMapping the groups included, directly
+// or indirectly, by the already mapped groups. getGroup is used to load them
+func addIncludedGroupsToMapping(groupsMapping map[string]Group, getGroup func(name string) (Group, error)) {
+	for depth := 0; depth < maxGroupNestingDepth; depth++ {
+		var missing []string
+		for _, group := range groupsMapping {
+			for _, name := range group.IncludedGroups {
+				if _, ok := groupsMapping[name]; !ok && !util.Contains(missing, name) {
+					missing = append(missing, name)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		added := false
+		for _, name := range missing {
+			group, err := getGroup(name)
+			if err != nil {
+				providerLog(logger.LevelError, "unable to load included group %q: %v", name, err)
+				continue
+			}
+			groupsMapping[group.Name] = group
+			added = true
+		}
+		if !added {
+			return
+		}
+	}
+}
+
+// getGroupsWithIncludedSettings returns the given groups with the settings of
+// the included groups merged in
+func getGroupsWithIncludedSettings(groups []Group) []Group {
+	groupsMapping := make(map[string]Group)
+	hasInclusions := false
+	for idx := range groups {
+		groupsMapping[groups[idx].Name] = groups[idx]
+		if len(groups[idx].IncludedGroups) > 0 {
+			hasInclusions = true
+		}
+	}
+	if !hasInclusions {
+		return groups
+	}
+	addIncludedGroupsToMapping(groupsMapping, provider.groupExists)
+	result := make([]Group, 0, len(groups))
+	for idx := range groups {
+		group, _ := getResolvedGroup(groups[idx].Name, groupsMapping)
+		result = append(result, group)
+	}
+	return result
+}
+
+// validateIncludedGroups checks that the groups included by the given group
+// exist and that the inclusions do not generate cycles or too many nesting levels
+func validateIncludedGroups(group *Group) error {
+	group.normalizeIncludedGroups()
+	return checkGroupInclusions(group.IncludedGroups, []string{group.Name})
+}
+
+func checkGroupInclusions(names, path []string) error {
+	for _, name := range names {
+		if util.Contains(path, name) {
+			return util.NewValidationError(fmt.Sprintf("group inclusion cycle detected: %s -> %s",
+				strings.Join(path, " -> "), name))
+		}
+		if len(path) >= maxGroupNestingDepth {
+			return util.NewValidationError(fmt.Sprintf("too many group nesting levels, the maximum allowed is %d",
+				maxGroupNestingDepth))
+		}
+		included, err := provider.groupExists(name)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				return util.NewValidationError(fmt.Sprintf("included group %q does not exist", name))
+			}
+			return err
+		}
+		if err := checkGroupInclusions(included.IncludedGroups, append(path[:len(path):len(path)], name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getIncludingGroups returns the names of the groups that include, directly or
+// indirectly, the group with the given name
+func getIncludingGroups(name string) ([]string, error) {
+	groups, err := provider.dumpGroups()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	toVisit := []string{name}
+	for len(toVisit) > 0 {
+		current := toVisit[0]
+		toVisit = toVisit[1:]
+		for idx := range groups {
+			if util.Contains(groups[idx].IncludedGroups, current) && !util.Contains(result, groups[idx].Name) &&
+				groups[idx].Name != name {
+				result = append(result, groups[idx].Name)
+				toVisit = append(toVisit, groups[idx].Name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// getUsersInIncludingGroups returns the members of the groups that include,
+// directly or indirectly, the group with the given name
+func getUsersInIncludingGroups(name string) []string {
+	names, err := getIncludingGroups(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get the groups including %q: %v", name, err)
+		return nil
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	users, err := provider.getUsersInGroups(names)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get the members of the groups including %q: %v", name, err)
+		return nil
+	}
+	return users
+}
+
+// SortGroupsByInclusion returns the groups sorted so that each group follows
+// the groups it includes, if any
+func SortGroupsByInclusion(groups []Group) []Group {
+	sorted := make([]Group, 0, len(groups))
+	added := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, g := range groups {
+		names[g.Name] = true
+	}
+	isReady := func(g *Group) bool {
+		for _, name := range g.IncludedGroups {
+			if names[name] && !added[name] {
+				return false
+			}
+		}
+		return true
+	}
+	for len(sorted) < len(groups) {
+		progress := false
+		for idx := range groups {
+			if added[groups[idx].Name] {
+				continue
+			}
+			if isReady(&groups[idx]) {
+				sorted = append(sorted, groups[idx])
+				added[groups[idx].Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			// inclusion cycle, should never happen, append the remaining ones as is
+			for idx := range groups {
+				if !added[groups[idx].Name] {
+					sorted = append(sorted, groups[idx])
+					added[groups[idx].Name] = true
+				}
+			}
+		}
+	}
+	return sorted
+}
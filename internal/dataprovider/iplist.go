@@ -135,6 +135,14 @@ func (e *IPListEntry) HasProtocol(proto string) bool {
 		return e.Protocols&4 != 0
 	case protocolHTTP:
 		return e.Protocols&8 != 0
+	case protocolS3:
+		return e.Protocols&16 != 0
+	case protocolTFTP:
+		return e.Protocols&32 != 0
+	case protocolGRPC:
+		return e.Protocols&64 != 0
+	case protocolSMTP:
+		return e.Protocols&128 != 0
 	default:
 		return false
 	}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func validateKerberosServices() error {
+	services := make([]kerberos.Config, 0, len(config.KerberosServices))
+	var names []string
+	for _, s := range config.KerberosServices {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if util.Contains(names, s.Name) {
+			return fmt.Errorf("kerberos: duplicated service name %q", s.Name)
+		}
+		names = append(names, s.Name)
+		services = append(services, s)
+	}
+	config.KerberosServices = services
+	return nil
+}
+
+// CheckKerberosService returns an error if the Kerberos service with the given
+// name is not defined. An empty name means no Kerberos service
+func CheckKerberosService(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := GetKerberosService(name)
+	return err
+}
+
+// GetKerberosService returns the Kerberos service with the given name
+func GetKerberosService(name string) (*kerberos.Config, error) {
+	for idx := range config.KerberosServices {
+		if config.KerberosServices[idx].Name == name {
+			return &config.KerberosServices[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("kerberos service %q is not defined", name)
+}
+
+// CheckKerberosAuth returns the SFTPGo user mapped to the given, already
+// authenticated, Kerberos principal. If username is not empty it must match
+// the mapped principal, this is the case for SSH where the client sends the
+// username too
+func CheckKerberosAuth(username, principal, ip, protocol, service string) (User, error) {
+	srv, err := GetKerberosService(service)
+	if err != nil {
+		return User{}, err
+	}
+	mappedName, err := srv.GetUsername(principal)
+	if err != nil {
+		return User{}, err
+	}
+	mappedName = config.convertName(mappedName)
+	if username != "" && config.convertName(username) != mappedName {
+		providerLog(logger.LevelDebug, "kerberos principal %q mapped to %q does not match the username %q",
+			principal, mappedName, username)
+		return User{}, ErrInvalidCredentials
+	}
+	var user User
+	if config.PreLoginHook != "" {
+		user, err = executePreLoginHook(mappedName, LoginMethodKerberos, ip, protocol, nil)
+	} else {
+		user, err = UserExists(mappedName, "")
+	}
+	if err != nil {
+		return user, err
+	}
+	if err := user.LoadAndApplyGroupSettings(); err != nil {
+		return user, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return user, err
+	}
+	return user, nil
+}
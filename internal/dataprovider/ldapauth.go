@@ -0,0 +1,168 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/ldap"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func validateLDAPDirectories() error {
+	directories := make([]ldap.Config, 0, len(config.LDAPDirectories))
+	var names []string
+	for _, d := range config.LDAPDirectories {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		if util.Contains(names, d.Name) {
+			return fmt.Errorf("ldap: duplicated directory name %q", d.Name)
+		}
+		names = append(names, d.Name)
+		directories = append(directories, d)
+	}
+	config.LDAPDirectories = directories
+	return nil
+}
+
+// CheckLDAPDirectory returns an error if the LDAP directory with the given
+// name is not defined. An empty name means no LDAP directory
+func CheckLDAPDirectory(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := getLDAPDirectory(name)
+	return err
+}
+
+func getLDAPDirectory(name string) (*ldap.Config, error) {
+	for idx := range config.LDAPDirectories {
+		if config.LDAPDirectories[idx].Name == name {
+			return &config.LDAPDirectories[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("ldap directory %q is not defined", name)
+}
+
+func doLDAPAuth(username, password, directory string) (User, error) {
+	var user User
+
+	dir, err := getLDAPDirectory(directory)
+	if err != nil {
+		return user, err
+	}
+	u, mergedUser, err := getUserForHook(username, nil)
+	if err != nil {
+		return user, err
+	}
+
+	if mergedUser.Filters.Hooks.ExternalAuthDisabled {
+		return u, nil
+	}
+
+	if mergedUser.isExternalAuthCached() {
+		return u, nil
+	}
+
+	startTime := time.Now()
+	result, err := dir.Authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			providerLog(logger.LevelDebug, "LDAP auth failed for user %q, directory %q, elapsed: %s: %v",
+				username, directory, time.Since(startTime), err)
+			return user, ErrInvalidCredentials
+		}
+		return user, fmt.Errorf("LDAP auth error for user %q, directory %q, elapsed: %s: %w",
+			username, directory, time.Since(startTime), err)
+	}
+	providerLog(logger.LevelDebug, "LDAP auth completed for user %q, directory %q, dn %q, elapsed: %s",
+		username, directory, result.DN, time.Since(startTime))
+
+	user, err = getUserFromLDAPResult(dir, &u, result, password)
+	if err != nil {
+		return user, err
+	}
+	if user.ID > 0 {
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		err = provider.updateUser(&user)
+		if err == nil {
+			webDAVUsersCache.swap(&user)
+			cachedPasswords.Add(user.Username, password)
+		}
+		return user, err
+	}
+	err = provider.addUser(&user)
+	if err != nil {
+		return user, err
+	}
+	return provider.userExists(user.Username, "")
+}
+
+// getUserFromLDAPResult applies the LDAP attributes and groups to the given user.
+// New users are created with the configured root permissions, the existing
+// users keep all the settings not managed by the LDAP directory
+func getUserFromLDAPResult(dir *ldap.Config, u *User, result *ldap.Result, password string) (User, error) {
+	user := u.getACopy()
+	if user.ID == 0 {
+		user = User{
+			BaseUser: sdk.BaseUser{
+				Username: u.Username,
+				Status:   1,
+				Permissions: map[string][]string{
+					"/": dir.Permissions,
+				},
+			},
+		}
+	}
+	user.Password = password
+	user.LastPasswordChange = 0
+	if homeDir := dir.RenderTemplate(dir.HomeDir, user.Username, &result.Entry); homeDir != "" {
+		user.HomeDir = homeDir
+	}
+	if email := dir.RenderTemplate(dir.Email, user.Username, &result.Entry); email != "" {
+		user.Email = email
+	}
+	if quotaSize := dir.RenderTemplate(dir.QuotaSize, user.Username, &result.Entry); quotaSize != "" {
+		size, err := util.ParseBytes(quotaSize)
+		if err != nil {
+			return user, fmt.Errorf("invalid LDAP quota size %q for user %q: %w", quotaSize, user.Username, err)
+		}
+		user.QuotaSize = size
+	}
+	if quotaFiles := dir.RenderTemplate(dir.QuotaFiles, user.Username, &result.Entry); quotaFiles != "" {
+		files, err := strconv.Atoi(quotaFiles)
+		if err != nil {
+			return user, fmt.Errorf("invalid LDAP quota files %q for user %q: %w", quotaFiles, user.Username, err)
+		}
+		user.QuotaFiles = files
+	}
+	if len(dir.GroupMappings) > 0 {
+		user.Groups = nil
+		for _, m := range dir.GetGroupMappings(result.Groups) {
+			user.Groups = append(user.Groups, sdk.GroupMapping{
+				Name: m.Group,
+				Type: m.Type,
+			})
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"math"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// lifecycleThresholds defines the account lifecycle thresholds, in days,
+// inherited from the primary group
+type lifecycleThresholds struct {
+	expirationWarning int
+	inactivity        int
+}
+
+// GetExpirationWarningThreshold returns the number of days before the password
+// or account expiration to start notifying the user.
+// The primary group setting, if any, overrides the specified default
+func (u *User) GetExpirationWarningThreshold(defaultThreshold int) int {
+	if u.lifecycle.expirationWarning > 0 {
+		return u.lifecycle.expirationWarning
+	}
+	return defaultThreshold
+}
+
+// GetInactivityThreshold returns the number of days without logins after which
+// the user must be disabled. The primary group setting, if any, overrides the
+// specified default. 0 means the user must never be disabled
+func (u *User) GetInactivityThreshold(defaultThreshold int) int {
+	switch {
+	case u.lifecycle.inactivity > 0:
+		return u.lifecycle.inactivity
+	case u.lifecycle.inactivity < 0:
+		return 0
+	default:
+		return defaultThreshold
+	}
+}
+
+// ExpiresIn returns the number of days until the account expiration.
+// 0 or a negative value means the account is already expired.
+// The account expiration date must be set
+func (u *User) ExpiresIn() int {
+	expDate := util.GetTimeFromMsecSinceEpoch(u.ExpirationDate)
+	res := int(math.Round(float64(time.Until(expDate)) / float64(24*time.Hour)))
+	if res == 0 && expDate.After(time.Now()) {
+		res = 1
+	}
+	return res
+}
+
+// GetInactiveDays returns the number of days since the last login or since the
+// account creation for users who never logged in
+func (u *User) GetInactiveDays() int {
+	lastActivity := u.LastLogin
+	if lastActivity == 0 {
+		lastActivity = u.CreatedAt
+	}
+	if lastActivity == 0 {
+		return 0
+	}
+	return int(time.Since(util.GetTimeFromMsecSinceEpoch(lastActivity)) / (24 * time.Hour))
+}
+
+// DisableUser disables the user with the specified username.
+// A "disable" provider event is generated
+func DisableUser(username, executor, ipAddress, role string) error {
+	user, err := provider.userExists(config.convertName(username), role)
+	if err != nil {
+		return err
+	}
+	if user.Status == 0 {
+		return nil
+	}
+	user.Status = 0
+	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	before := getAuditLogSnapshot(&user)
+	if err := provider.updateUser(&user); err != nil {
+		return err
+	}
+	webDAVUsersCache.swap(&user)
+	cachedPasswords.Remove(user.Username)
+	providerLog(logger.LevelInfo, "user %q disabled, executor: %q", user.Username, executor)
+	executeAuditedAction(operationDisable, executor, ipAddress, actionObjectUser, user.Username, role, before, &user)
+	return nil
+}
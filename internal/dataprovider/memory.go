@@ -50,6 +50,8 @@ type memoryProviderHandle struct {
 	groups map[string]Group
 	// map for virtual folders, folder name is the key
 	vfolders map[string]vfs.BaseVirtualFolder
+	// per-user quota usage inside virtual folders, username and folder name are the keys
+	usersFoldersQuota map[string]map[string]userFolderQuota
 	// slice with ordered folder names
 	vfoldersNames []string
 	// map for admins, username is the key
@@ -76,12 +78,32 @@ type memoryProviderHandle struct {
 	roles map[string]Role
 	// slice with ordered roles
 	roleNames []string
+	// map for templates, name is the key
+	templates map[string]Template
+	// slice with ordered templates
+	templateNames []string
+	// map for tenants, name is the key
+	tenants map[string]Tenant
+	// slice with ordered tenants
+	tenantNames []string
 	// map for IP List entry
 	ipListEntries map[string]IPListEntry
 	// slice with ordered IP list entries
 	ipListEntriesKeys []string
 	// configurations
 	configs Configs
+	// slice with audit log entries, ordered by ID
+	auditLogs []AuditLogEntry
+	// last assigned audit log entry ID
+	lastAuditLogID int64
+	// slice with change events, ordered by ID
+	changeEvents []ChangeEvent
+	// last assigned change event ID
+	lastChangeEventID int64
+	// slice with soft deleted objects, ordered by ID
+	deletedObjects []DeletedObject
+	// last assigned deleted object ID
+	lastDeletedObjectID int64
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -118,9 +140,17 @@ func initializeMemoryProvider(basePath string) {
 			rulesNames:        []string{},
 			roles:             map[string]Role{},
 			roleNames:         []string{},
+			templates:         map[string]Template{},
+			templateNames:     []string{},
+			tenants:           map[string]Tenant{},
+			tenantNames:       []string{},
+			usersFoldersQuota: map[string]map[string]userFolderQuota{},
 			ipListEntries:     map[string]IPListEntry{},
 			ipListEntriesKeys: []string{},
 			configs:           Configs{},
+			auditLogs:         []AuditLogEntry{},
+			changeEvents:      []ChangeEvent{},
+			deletedObjects:    []DeletedObject{},
 			configFile:        configFile,
 		},
 	}
@@ -209,6 +239,22 @@ func (p *MemoryProvider) updateAPIKeyLastUse(keyID string) error {
 	return nil
 }
 
+func (p *MemoryProvider) rotateAPIKey(keyID, key string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	apiKey, err := p.apiKeyExistsInternal(keyID)
+	if err != nil {
+		return err
+	}
+	apiKey.Key = key
+	apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.apiKeys[apiKey.KeyID] = apiKey
+	return nil
+}
+
 func (p *MemoryProvider) setUpdatedAt(username string) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -449,6 +495,7 @@ func (p *MemoryProvider) deleteUser(user User, softDelete bool) error {
 		p.removeUserFromGroupMapping(u.Username, u.Groups[idx].Name)
 	}
 	delete(p.dbHandle.users, user.Username)
+	delete(p.dbHandle.usersFoldersQuota, user.Username)
 	// this could be more efficient
 	p.dbHandle.usernames = make([]string, 0, len(p.dbHandle.users))
 	for username := range p.dbHandle.users {
@@ -460,6 +507,22 @@ func (p *MemoryProvider) deleteUser(user User, softDelete bool) error {
 	return nil
 }
 
+func (p *MemoryProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return "", errMemoryProviderClosed
+	}
+
+	for _, username := range p.dbHandle.usernames {
+		user := p.dbHandle.users[username]
+		if _, ok := user.GetS3AccessKey(accessKeyID); ok {
+			return username, nil
+		}
+	}
+	return "", util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+}
+
 func (p *MemoryProvider) updateUserPassword(username, password string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -532,6 +595,7 @@ func (p *MemoryProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 				}
 				groupMapping[group.Name] = group
 			}
+			addIncludedGroupsToMapping(groupMapping, p.groupExistsInternal)
 			user.applyGroupSettings(groupMapping)
 		}
 
@@ -565,6 +629,7 @@ func (p *MemoryProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User,
 					}
 					groupMapping[group.Name] = group
 				}
+				addIncludedGroupsToMapping(groupMapping, p.groupExistsInternal)
 				user.applyGroupSettings(groupMapping)
 			}
 			user.SetEmptySecretsIfNil()
@@ -681,6 +746,20 @@ func (p *MemoryProvider) roleExistsInternal(name string) (Role, error) {
 	return Role{}, util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", name))
 }
 
+func (p *MemoryProvider) templateExistsInternal(name string) (Template, error) {
+	if val, ok := p.dbHandle.templates[name]; ok {
+		return val.getACopy(), nil
+	}
+	return Template{}, util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", name))
+}
+
+func (p *MemoryProvider) tenantExistsInternal(name string) (Tenant, error) {
+	if val, ok := p.dbHandle.tenants[name]; ok {
+		return val.getACopy(), nil
+	}
+	return Tenant{}, util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+}
+
 func (p *MemoryProvider) ipListEntryExistsInternal(entry *IPListEntry) (IPListEntry, error) {
 	if val, ok := p.dbHandle.ipListEntries[entry.getKey()]; ok {
 		return val.getACopy(), nil
@@ -1105,6 +1184,41 @@ func (p *MemoryProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
 }
 
+func (p *MemoryProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, err := p.userExistsInternal(username); err != nil {
+		providerLog(logger.LevelError, "unable to update quota for user %q inside folder %q error: %v", username, folderName, err)
+		return err
+	}
+	if _, err := p.folderExistsInternal(folderName); err != nil {
+		providerLog(logger.LevelError, "unable to update quota for user %q inside folder %q error: %v", username, folderName, err)
+		return err
+	}
+	usage, ok := p.dbHandle.usersFoldersQuota[username]
+	if !ok {
+		usage = make(map[string]userFolderQuota)
+		p.dbHandle.usersFoldersQuota[username] = usage
+	}
+	quota := usage[folderName]
+	quota.update(filesAdd, sizeAdd, reset)
+	usage[folderName] = quota
+	return nil
+}
+
+func (p *MemoryProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return 0, 0, errMemoryProviderClosed
+	}
+	quota := p.dbHandle.usersFoldersQuota[username][folderName]
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
 func (p *MemoryProvider) joinGroupVirtualFoldersFields(group *Group) []vfs.VirtualFolder {
 	var folders []vfs.VirtualFolder
 	for idx := range group.VirtualFolders {
@@ -1586,6 +1700,9 @@ func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
 		}
 	}
 	delete(p.dbHandle.vfolders, folder.Name)
+	for _, usage := range p.dbHandle.usersFoldersQuota {
+		delete(usage, folder.Name)
+	}
 	p.dbHandle.vfoldersNames = []string{}
 	for name := range p.dbHandle.vfolders {
 		p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, name)
@@ -2070,6 +2187,10 @@ func (p *MemoryProvider) getSharedSession(key string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *MemoryProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *MemoryProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return ErrNotImplemented
 }
@@ -2608,6 +2729,261 @@ func (p *MemoryProvider) dumpRoles() ([]Role, error) {
 	return roles, nil
 }
 
+func (p *MemoryProvider) templateExists(name string) (Template, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return Template{}, errMemoryProviderClosed
+	}
+	return p.templateExistsInternal(name)
+}
+
+func (p *MemoryProvider) addTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	_, err := p.templateExistsInternal(template.Name)
+	if err == nil {
+		return fmt.Errorf("template %q already exists", template.Name)
+	}
+	template.ID = p.getNextTemplateID()
+	template.Version = 1
+	template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.templates[template.Name] = template.getACopy()
+	p.dbHandle.templateNames = append(p.dbHandle.templateNames, template.Name)
+	sort.Strings(p.dbHandle.templateNames)
+	return nil
+}
+
+func (p *MemoryProvider) updateTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	oldTemplate, err := p.templateExistsInternal(template.Name)
+	if err != nil {
+		return err
+	}
+	template.ID = oldTemplate.ID
+	template.Type = oldTemplate.Type
+	template.Version = oldTemplate.Version + 1
+	template.CreatedAt = oldTemplate.CreatedAt
+	template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.templates[template.Name] = template.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) deleteTemplate(template Template) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	_, err := p.templateExistsInternal(template.Name)
+	if err != nil {
+		return err
+	}
+	delete(p.dbHandle.templates, template.Name)
+	p.dbHandle.templateNames = make([]string, 0, len(p.dbHandle.templates))
+	for name := range p.dbHandle.templates {
+		p.dbHandle.templateNames = append(p.dbHandle.templateNames, name)
+	}
+	sort.Strings(p.dbHandle.templateNames)
+	return nil
+}
+
+func (p *MemoryProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	templates := make([]Template, 0, 10)
+	itNum := 0
+	if order == OrderASC {
+		for _, name := range p.dbHandle.templateNames {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.templates[name]
+			templates = append(templates, t.getACopy())
+			if len(templates) >= limit {
+				break
+			}
+		}
+	} else {
+		for i := len(p.dbHandle.templateNames) - 1; i >= 0; i-- {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.templates[p.dbHandle.templateNames[i]]
+			templates = append(templates, t.getACopy())
+			if len(templates) >= limit {
+				break
+			}
+		}
+	}
+	return templates, nil
+}
+
+func (p *MemoryProvider) dumpTemplates() ([]Template, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+
+	templates := make([]Template, 0, len(p.dbHandle.templates))
+	for _, name := range p.dbHandle.templateNames {
+		t := p.dbHandle.templates[name]
+		templates = append(templates, t.getACopy())
+	}
+	return templates, nil
+}
+
+func (p *MemoryProvider) tenantExists(name string) (Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return Tenant{}, errMemoryProviderClosed
+	}
+	return p.tenantExistsInternal(name)
+}
+
+func (p *MemoryProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	_, err := p.tenantExistsInternal(tenant.Name)
+	if err == nil {
+		return fmt.Errorf("tenant %q already exists", tenant.Name)
+	}
+	tenant.ID = p.getNextTenantID()
+	tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.tenants[tenant.Name] = tenant.getACopy()
+	p.dbHandle.tenantNames = append(p.dbHandle.tenantNames, tenant.Name)
+	sort.Strings(p.dbHandle.tenantNames)
+	return nil
+}
+
+func (p *MemoryProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	oldTenant, err := p.tenantExistsInternal(tenant.Name)
+	if err != nil {
+		return err
+	}
+	tenant.ID = oldTenant.ID
+	tenant.CreatedAt = oldTenant.CreatedAt
+	tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.tenants[tenant.Name] = tenant.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) deleteTenant(tenant Tenant) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	_, err := p.tenantExistsInternal(tenant.Name)
+	if err != nil {
+		return err
+	}
+	delete(p.dbHandle.tenants, tenant.Name)
+	p.dbHandle.tenantNames = make([]string, 0, len(p.dbHandle.tenants))
+	for name := range p.dbHandle.tenants {
+		p.dbHandle.tenantNames = append(p.dbHandle.tenantNames, name)
+	}
+	sort.Strings(p.dbHandle.tenantNames)
+	return nil
+}
+
+func (p *MemoryProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	tenants := make([]Tenant, 0, 10)
+	itNum := 0
+	if order == OrderASC {
+		for _, name := range p.dbHandle.tenantNames {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.tenants[name]
+			tenants = append(tenants, t.getACopy())
+			if len(tenants) >= limit {
+				break
+			}
+		}
+	} else {
+		for i := len(p.dbHandle.tenantNames) - 1; i >= 0; i-- {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.tenants[p.dbHandle.tenantNames[i]]
+			tenants = append(tenants, t.getACopy())
+			if len(tenants) >= limit {
+				break
+			}
+		}
+	}
+	return tenants, nil
+}
+
+func (p *MemoryProvider) dumpTenants() ([]Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+
+	tenants := make([]Tenant, 0, len(p.dbHandle.tenants))
+	for _, name := range p.dbHandle.tenantNames {
+		t := p.dbHandle.tenants[name]
+		tenants = append(tenants, t.getACopy())
+	}
+	return tenants, nil
+}
+
 func (p *MemoryProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2822,6 +3198,185 @@ func (p *MemoryProvider) setConfigs(configs *Configs) error {
 	return nil
 }
 
+func (p *MemoryProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastAuditLogID++
+	entry.ID = p.dbHandle.lastAuditLogID
+	p.dbHandle.auditLogs = append(p.dbHandle.auditLogs, entry.getACopy())
+	return nil
+}
+
+func (p *MemoryProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	entries := make([]AuditLogEntry, 0, search.Limit)
+	itNum := 0
+	numEntries := len(p.dbHandle.auditLogs)
+	for idx := 0; idx < numEntries; idx++ {
+		entry := p.dbHandle.auditLogs[idx]
+		if search.Order == OrderDESC {
+			entry = p.dbHandle.auditLogs[numEntries-1-idx]
+		}
+		if !entry.matches(search) {
+			continue
+		}
+		itNum++
+		if itNum <= search.Offset {
+			continue
+		}
+		entries = append(entries, entry.getACopy())
+		if len(entries) >= search.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (p *MemoryProvider) cleanupAuditLogs(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	entries := make([]AuditLogEntry, 0, len(p.dbHandle.auditLogs))
+	for _, entry := range p.dbHandle.auditLogs {
+		if entry.Timestamp >= before {
+			entries = append(entries, entry)
+		}
+	}
+	p.dbHandle.auditLogs = entries
+	return nil
+}
+
+func (p *MemoryProvider) addChangeEvent(event *ChangeEvent) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastChangeEventID++
+	event.ID = p.dbHandle.lastChangeEventID
+	p.dbHandle.changeEvents = append(p.dbHandle.changeEvents, event.getACopy())
+	return nil
+}
+
+func (p *MemoryProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	events := make([]ChangeEvent, 0, limit)
+	idx := sort.Search(len(p.dbHandle.changeEvents), func(i int) bool {
+		return p.dbHandle.changeEvents[i].ID > after
+	})
+	for ; idx < len(p.dbHandle.changeEvents) && len(events) < limit; idx++ {
+		events = append(events, p.dbHandle.changeEvents[idx].getACopy())
+	}
+	return events, nil
+}
+
+func (p *MemoryProvider) cleanupChangeEvents(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	events := make([]ChangeEvent, 0, len(p.dbHandle.changeEvents))
+	for _, event := range p.dbHandle.changeEvents {
+		if event.Timestamp >= before {
+			events = append(events, event)
+		}
+	}
+	p.dbHandle.changeEvents = events
+	return nil
+}
+
+func (p *MemoryProvider) addDeletedObject(object *DeletedObject) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastDeletedObjectID++
+	object.ID = p.dbHandle.lastDeletedObjectID
+	p.dbHandle.deletedObjects = append(p.dbHandle.deletedObjects, object.getACopy())
+	return nil
+}
+
+func (p *MemoryProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	objects := make([]DeletedObject, 0, limit)
+	itNum := 0
+	for idx := len(p.dbHandle.deletedObjects) - 1; idx >= 0; idx-- {
+		object := p.dbHandle.deletedObjects[idx]
+		if objectType != "" && object.ObjectType != objectType {
+			continue
+		}
+		if before > 0 && object.DeletedAt >= before {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		objects = append(objects, object.getACopy())
+		if len(objects) >= limit {
+			break
+		}
+	}
+	return objects, nil
+}
+
+func (p *MemoryProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return DeletedObject{}, errMemoryProviderClosed
+	}
+	idx := p.findDeletedObject(id)
+	if idx < 0 {
+		return DeletedObject{}, util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+	}
+	return p.dbHandle.deletedObjects[idx].getACopy(), nil
+}
+
+func (p *MemoryProvider) removeDeletedObject(id int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	idx := p.findDeletedObject(id)
+	if idx < 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+	}
+	p.dbHandle.deletedObjects = append(p.dbHandle.deletedObjects[:idx], p.dbHandle.deletedObjects[idx+1:]...)
+	return nil
+}
+
+// findDeletedObject returns the index of the deleted object with the specified ID, -1 if not found
+func (p *MemoryProvider) findDeletedObject(id int64) int {
+	idx := sort.Search(len(p.dbHandle.deletedObjects), func(i int) bool {
+		return p.dbHandle.deletedObjects[i].ID >= id
+	})
+	if idx < len(p.dbHandle.deletedObjects) && p.dbHandle.deletedObjects[idx].ID == id {
+		return idx
+	}
+	return -1
+}
+
 func (p *MemoryProvider) setFirstDownloadTimestamp(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2920,6 +3475,26 @@ func (p *MemoryProvider) getNextRuleID() int64 {
 	return nextID
 }
 
+func (p *MemoryProvider) getNextTemplateID() int64 {
+	nextID := int64(1)
+	for _, t := range p.dbHandle.templates {
+		if t.ID >= nextID {
+			nextID = t.ID + 1
+		}
+	}
+	return nextID
+}
+
+func (p *MemoryProvider) getNextTenantID() int64 {
+	nextID := int64(1)
+	for _, t := range p.dbHandle.tenants {
+		if t.ID >= nextID {
+			nextID = t.ID + 1
+		}
+	}
+	return nextID
+}
+
 func (p *MemoryProvider) getNextRoleID() int64 {
 	nextID := int64(1)
 	for _, r := range p.dbHandle.roles {
@@ -2952,6 +3527,11 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.rulesNames = []string{}
 	p.dbHandle.roles = map[string]Role{}
 	p.dbHandle.roleNames = []string{}
+	p.dbHandle.templates = map[string]Template{}
+	p.dbHandle.templateNames = []string{}
+	p.dbHandle.tenants = map[string]Tenant{}
+	p.dbHandle.tenantNames = []string{}
+	p.dbHandle.usersFoldersQuota = map[string]map[string]userFolderQuota{}
 	p.dbHandle.ipListEntries = map[string]IPListEntry{}
 	p.dbHandle.ipListEntriesKeys = []string{}
 	p.dbHandle.configs = Configs{}
@@ -3006,6 +3586,14 @@ func (p *MemoryProvider) restoreDump(dump *BackupData) error {
 		return err
 	}
 
+	if err := p.restoreTenants(dump); err != nil {
+		return err
+	}
+
+	if err := p.restoreTemplates(dump); err != nil {
+		return err
+	}
+
 	if err := p.restoreFolders(dump); err != nil {
 		return err
 	}
@@ -3213,9 +3801,53 @@ func (p *MemoryProvider) restoreRoles(dump *BackupData) error {
 	return nil
 }
 
+func (p *MemoryProvider) restoreTenants(dump *BackupData) error {
+	for idx := range dump.Tenants {
+		tenant := dump.Tenants[idx]
+		tenant.Name = config.convertName(tenant.Name)
+		t, err := p.tenantExists(tenant.Name)
+		if err == nil {
+			tenant.ID = t.ID
+			err = UpdateTenant(&tenant, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error updating tenant %q: %v", tenant.Name, err)
+				return err
+			}
+		} else {
+			err = AddTenant(&tenant, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error adding tenant %q: %v", tenant.Name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) restoreTemplates(dump *BackupData) error {
+	for _, template := range SortTemplatesByInheritance(dump.Templates) {
+		template.Name = config.convertName(template.Name)
+		t, err := p.templateExists(template.Name)
+		if err == nil {
+			template.ID = t.ID
+			err = UpdateTemplate(&template, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error updating template %q: %v", template.Name, err)
+				return err
+			}
+		} else {
+			err = AddTemplate(&template, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error adding template %q: %v", template.Name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) restoreGroups(dump *BackupData) error {
-	for idx := range dump.Groups {
-		group := dump.Groups[idx]
+	for _, group := range SortGroupsByInclusion(dump.Groups) {
 		group.Name = config.convertName(group.Name)
 		g, err := p.groupExists(group.Name)
 		if err == nil {
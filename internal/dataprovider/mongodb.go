@@ -0,0 +1,4257 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nomongodb
+// +build !nomongodb
+
+package dataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	mongoDatabaseVersion     = 30
+	mongoDefaultPort         = 27017
+	defaultMongoQueryTimeout = 20 * time.Second
+	longMongoQueryTimeout    = 60 * time.Second
+)
+
+const (
+	mongoUsersCollection           = "users"
+	mongoGroupsCollection          = "groups"
+	mongoFoldersCollection         = "folders"
+	mongoAdminsCollection          = "admins"
+	mongoAPIKeysCollection         = "api_keys"
+	mongoSharesCollection          = "shares"
+	mongoActionsCollection         = "events_actions"
+	mongoRulesCollection           = "events_rules"
+	mongoRolesCollection           = "roles"
+	mongoIPListsCollection         = "ip_lists"
+	mongoConfigsCollection         = "configs"
+	mongoAuditLogsCollection       = "audit_logs"
+	mongoChangeEventsCollection    = "change_events"
+	mongoDeletedObjectsCollection  = "deleted_objects"
+	mongoTemplatesCollection       = "templates"
+	mongoTenantsCollection         = "tenants"
+	mongoFoldersQuotaCollection    = "users_folders_quota"
+	mongoDefenderHostsCollection   = "defender_hosts"
+	mongoActiveTransfersCollection = "active_transfers"
+	mongoSharedSessionsCollection  = "shared_sessions"
+	mongoTasksCollection           = "tasks"
+	mongoNodesCollection           = "nodes"
+	mongoCountersCollection        = "counters"
+	mongoSchemaVersionCollection   = "schema_version"
+	mongoConfigsKey                = "configs"
+	mongoSchemaVersionKey          = "version"
+)
+
+var (
+	mongoCollections = []string{mongoUsersCollection, mongoGroupsCollection, mongoFoldersCollection,
+		mongoAdminsCollection, mongoAPIKeysCollection, mongoSharesCollection, mongoActionsCollection,
+		mongoRulesCollection, mongoRolesCollection, mongoIPListsCollection, mongoConfigsCollection,
+		mongoAuditLogsCollection, mongoChangeEventsCollection, mongoDeletedObjectsCollection,
+		mongoTemplatesCollection, mongoTenantsCollection, mongoFoldersQuotaCollection, mongoDefenderHostsCollection, mongoActiveTransfersCollection, mongoSharedSessionsCollection,
+		mongoTasksCollection, mongoNodesCollection, mongoCountersCollection, mongoSchemaVersionCollection}
+)
+
+// MongoDBProvider defines the auth provider for MongoDB.
+// Objects are stored as JSON documents, the fields used to filter and
+// the frequently updated counters are stored as native document fields
+type MongoDBProvider struct {
+	client   *mongo.Client
+	dbHandle *mongo.Database
+	// transactions are only available for replica sets and sharded clusters,
+	// on standalone servers the changes are applied without a transaction
+	supportsTransactions bool
+}
+
+// mongoDocument defines the stored representation of the objects serialized as JSON
+type mongoDocument struct {
+	Key       string      `bson:"_id"`
+	Data      string      `bson:"data"`
+	DeletedAt int64       `bson:"deleted_at"`
+	Stats     *mongoStats `bson:"stats,omitempty"`
+}
+
+// mongoStats defines the counters and timestamps that are atomically updated
+// without rewriting the whole document
+type mongoStats struct {
+	UsedQuotaSize            int64 `bson:"used_quota_size"`
+	UsedQuotaFiles           int   `bson:"used_quota_files"`
+	UsedUploadDataTransfer   int64 `bson:"used_upload_data_transfer"`
+	UsedDownloadDataTransfer int64 `bson:"used_download_data_transfer"`
+	LastQuotaUpdate          int64 `bson:"last_quota_update"`
+	LastLogin                int64 `bson:"last_login"`
+	FirstDownload            int64 `bson:"first_download"`
+	FirstUpload              int64 `bson:"first_upload"`
+	UsedTokens               int   `bson:"used_tokens"`
+	LastUseAt                int64 `bson:"last_use_at"`
+}
+
+type mongoAuditLogDocument struct {
+	ID   int64  `bson:"_id"`
+	Data string `bson:"data"`
+}
+
+type mongoDeletedObject struct {
+	ID         int64  `bson:"_id"`
+	ObjectType string `bson:"object_type"`
+	ObjectName string `bson:"object_name"`
+	DeletedAt  int64  `bson:"deleted_at"`
+	Data       string `bson:"data"`
+}
+
+func (o *mongoDeletedObject) toDeletedObject() DeletedObject {
+	return DeletedObject{
+		ID:         o.ID,
+		ObjectType: o.ObjectType,
+		ObjectName: o.ObjectName,
+		DeletedAt:  o.DeletedAt,
+		Data:       o.Data,
+	}
+}
+
+type mongoDefenderEvent struct {
+	DateTime int64 `bson:"date_time"`
+	Score    int   `bson:"score"`
+}
+
+type mongoDefenderHost struct {
+	IP        string               `bson:"_id"`
+	UpdatedAt int64                `bson:"updated_at"`
+	BanTime   int64                `bson:"ban_time"`
+	Events    []mongoDefenderEvent `bson:"events"`
+}
+
+type mongoActiveTransfer struct {
+	TransferID    int64  `bson:"transfer_id"`
+	ConnectionID  string `bson:"connection_id"`
+	Type          int    `bson:"transfer_type"`
+	Username      string `bson:"username"`
+	FolderName    string `bson:"folder_name"`
+	IP            string `bson:"ip"`
+	TruncatedSize int64  `bson:"truncated_size"`
+	CurrentULSize int64  `bson:"current_ul_size"`
+	CurrentDLSize int64  `bson:"current_dl_size"`
+	CreatedAt     int64  `bson:"created_at"`
+	UpdatedAt     int64  `bson:"updated_at"`
+}
+
+type mongoSession struct {
+	Key       string      `bson:"_id"`
+	Data      string      `bson:"data"`
+	Type      SessionType `bson:"type"`
+	Timestamp int64       `bson:"timestamp"`
+}
+
+type mongoTask struct {
+	Name      string `bson:"_id"`
+	UpdatedAt int64  `bson:"updated_at"`
+	Version   int64  `bson:"version"`
+}
+
+type mongoNode struct {
+	Name      string `bson:"_id"`
+	Data      string `bson:"data"`
+	CreatedAt int64  `bson:"created_at"`
+	UpdatedAt int64  `bson:"updated_at"`
+}
+
+// mongoBucket wraps a collection storing JSON serialized objects keyed by name.
+// The operations use the bucket context so they are executed within the
+// current transaction, if any
+type mongoBucket struct {
+	ctx  context.Context
+	coll *mongo.Collection
+}
+
+func init() {
+	version.AddFeature("+mongodb")
+}
+
+func initializeMongoDBProvider() error {
+	clientOptions := options.Client().ApplyURI(getMongoDBConnectionString(false))
+	if config.PoolSize > 0 {
+		clientOptions.SetMaxPoolSize(uint64(config.PoolSize))
+	}
+	if config.ConnectionString == "" && config.SSLMode > 0 {
+		tlsConfig, err := getMongoDBTLSConfig()
+		if err != nil {
+			providerLog(logger.LevelError, "error creating MongoDB TLS config: %v", err)
+			return err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMongoQueryTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		providerLog(logger.LevelError, "error creating MongoDB client, connection string: %q, error: %v",
+			getMongoDBConnectionString(true), err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "MongoDB client created, connection string: %q, database: %q, pool size: %d",
+		getMongoDBConnectionString(true), config.Name, config.PoolSize)
+	p := &MongoDBProvider{
+		client:   client,
+		dbHandle: client.Database(config.Name),
+	}
+	p.supportsTransactions = p.checkTransactionsSupport(ctx)
+	provider = p
+	return nil
+}
+
+func getMongoDBConnectionString(redactedPwd bool) string {
+	if config.ConnectionString != "" {
+		return config.ConnectionString
+	}
+	port := config.Port
+	if port <= 0 {
+		port = mongoDefaultPort
+	}
+	connectionURL := url.URL{
+		Scheme: "mongodb",
+		Host:   net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		Path:   "/",
+	}
+	if config.Username != "" {
+		connectionURL.User = url.UserPassword(config.Username, config.Password)
+	}
+	if redactedPwd {
+		return connectionURL.Redacted()
+	}
+	return connectionURL.String()
+}
+
+func getMongoDBTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if !filepath.IsAbs(config.Host) && !config.DisableSNI {
+		tlsConfig.ServerName = config.Host
+	}
+	providerLog(logger.LevelInfo, "using custom TLS config, root cert %q, client cert %q, client key %q, disable SNI? %v",
+		config.RootCert, config.ClientCert, config.ClientKey, config.DisableSNI)
+	return tlsConfig, nil
+}
+
+func (p *MongoDBProvider) checkTransactionsSupport(ctx context.Context) bool {
+	var result bson.M
+	if err := p.dbHandle.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&result); err != nil {
+		providerLog(logger.LevelWarn, "unable to check transactions support: %v", err)
+		return false
+	}
+	if _, ok := result["setName"]; ok {
+		return true
+	}
+	if msg, ok := result["msg"].(string); ok && msg == "isdbgrid" {
+		return true
+	}
+	providerLog(logger.LevelWarn, "transactions are not supported, the MongoDB server is not a replica set or a sharded cluster")
+	return false
+}
+
+// view executes fn outside a transaction
+func (p *MongoDBProvider) view(fn func(ctx context.Context) error) error {
+	return p.viewWithTimeout(defaultMongoQueryTimeout, fn)
+}
+
+func (p *MongoDBProvider) viewWithTimeout(timeout time.Duration, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// update executes fn within a transaction, if supported
+func (p *MongoDBProvider) update(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMongoQueryTimeout)
+	defer cancel()
+
+	if !p.supportsTransactions {
+		return fn(ctx)
+	}
+	session, err := p.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (any, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+func (p *MongoDBProvider) collection(name string) *mongo.Collection {
+	return p.dbHandle.Collection(config.SQLTablesPrefix + name)
+}
+
+func (p *MongoDBProvider) bucket(ctx context.Context, name string) *mongoBucket {
+	return &mongoBucket{
+		ctx:  ctx,
+		coll: p.collection(name),
+	}
+}
+
+func (p *MongoDBProvider) nextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := p.collection(mongoCountersCollection).FindOneAndUpdate(ctx, bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	return counter.Seq, err
+}
+
+func (p *MongoDBProvider) checkAvailability() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMongoQueryTimeout)
+	defer cancel()
+
+	return p.client.Ping(ctx, readpref.Primary())
+}
+
+func (p *MongoDBProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
+	var user User
+	if tlsCert == nil {
+		return user, errors.New("TLS certificate cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+}
+
+func (p *MongoDBProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (p *MongoDBProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
+	admin, err := p.adminExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating admin %q: %v", username, err)
+		return admin, err
+	}
+	err = admin.checkUserAndPass(password, ip)
+	return admin, err
+}
+
+func (p *MongoDBProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+}
+
+func (p *MongoDBProvider) updateAPIKeyLastUse(keyID string) error {
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAPIKeysCollection).updateStats(keyID, bson.M{
+			"$set": bson.M{"stats.last_use_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("key %q does not exist, unable to update last use", keyID))
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last use for key %q: %v", keyID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "last use updated for key %q", keyID)
+	return nil
+}
+
+func (p *MongoDBProvider) rotateAPIKey(keyID, key string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAPIKeysCollection)
+		var apiKey APIKey
+		found, err := bucket.get(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to rotate", keyID))
+		}
+		apiKey.Key = key
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(keyID, &apiKey)
+	})
+}
+
+func (p *MongoDBProvider) setUpdatedAt(username string) {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoUsersCollection)
+		var user User
+		found, err := bucket.get(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update updated at", username))
+		}
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "updated at set for user %q", username)
+	} else {
+		providerLog(logger.LevelWarn, "error setting updated_at for user %q: %v", username, err)
+	}
+}
+
+func (p *MongoDBProvider) updateLastLogin(username string) error {
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoUsersCollection).updateStats(username, bson.M{
+			"$set": bson.M{"stats.last_login": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("username %q does not exist, unable to update last login", username))
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last login for user %q: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "last login updated for user %q", username)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) updateAdminLastLogin(username string) error {
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAdminsCollection).updateStats(username, bson.M{
+			"$set": bson.M{"stats.last_login": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("admin %q does not exist, unable to update last login", username))
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "last login updated for admin %q", username)
+		return err
+	}
+	providerLog(logger.LevelWarn, "error updating last login for admin %q: %v", username, err)
+	return err
+}
+
+func (p *MongoDBProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
+	operator := "$inc"
+	if reset {
+		operator = "$set"
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoUsersCollection).updateStats(username, bson.M{
+			operator: bson.M{
+				"stats.used_upload_data_transfer":   uploadSize,
+				"stats.used_download_data_transfer": downloadSize,
+			},
+			"$max": bson.M{"stats.last_quota_update": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("username %q does not exist, unable to update transfer quota", username))
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "transfer quota updated for user %q, ul increment: %v dl increment: %v is reset? %v",
+			username, uploadSize, downloadSize, reset)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	operator := "$inc"
+	if reset {
+		operator = "$set"
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoUsersCollection).updateStats(username, bson.M{
+			operator: bson.M{
+				"stats.used_quota_size":  sizeAdd,
+				"stats.used_quota_files": filesAdd,
+			},
+			"$max": bson.M{"stats.last_quota_update": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("username %q does not exist, unable to update quota", username))
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for user %q, files increment: %v size increment: %v is reset? %v",
+			username, filesAdd, sizeAdd, reset)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) getUsedQuota(username string) (int, int64, int64, int64, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, err
+}
+
+func (p *MongoDBProvider) adminExists(username string) (Admin, error) {
+	var admin Admin
+
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoAdminsCollection).get(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", username))
+		}
+		return nil
+	})
+
+	return admin, err
+}
+
+func (p *MongoDBProvider) addAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAdminsCollection)
+		exists, err := bucket.exists(admin.Username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("admin %q already exists", admin.Username)
+		}
+		id, err := p.nextSequence(ctx, mongoAdminsCollection)
+		if err != nil {
+			return err
+		}
+		admin.ID = id
+		admin.LastLogin = 0
+		admin.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		for idx := range admin.Groups {
+			err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, p.bucket(ctx, mongoRolesCollection)); err != nil {
+			return err
+		}
+		return bucket.insert(admin.Username, admin)
+	})
+}
+
+func (p *MongoDBProvider) updateAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAdminsCollection)
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		rolesBucket := p.bucket(ctx, mongoRolesCollection)
+		var oldAdmin Admin
+		found, err := bucket.get(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range admin.Groups {
+			err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		admin.ID = oldAdmin.ID
+		admin.CreatedAt = oldAdmin.CreatedAt
+		admin.LastLogin = oldAdmin.LastLogin
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(admin.Username, admin)
+	})
+}
+
+func (p *MongoDBProvider) deleteAdmin(admin Admin) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAdminsCollection)
+		var oldAdmin Admin
+		found, err := bucket.get(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, p.bucket(ctx, mongoRolesCollection)); err != nil {
+			return err
+		}
+		_, err = p.bucket(ctx, mongoAPIKeysCollection).coll.DeleteMany(ctx, bson.M{"admin": admin.Username})
+		if err != nil {
+			return err
+		}
+		return bucket.delete(admin.Username)
+	})
+}
+
+func (p *MongoDBProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+	admins := make([]Admin, 0, limit)
+	if limit <= 0 {
+		return admins, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAdminsCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var admin Admin
+			if err := doc.unmarshal(&admin); err != nil {
+				return err
+			}
+			admin.HideConfidentialData()
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+
+	return admins, err
+}
+
+func (p *MongoDBProvider) dumpAdmins() ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAdminsCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var admin Admin
+			if err := doc.unmarshal(&admin); err != nil {
+				return err
+			}
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+
+	return admins, err
+}
+
+func (p *MongoDBProvider) userExists(username, role string) (User, error) {
+	var user User
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoUsersCollection).get(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found || !user.hasRole(role) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		return p.joinUserAndFolders(&user, p.bucket(ctx, mongoFoldersCollection))
+	})
+	return user, err
+}
+
+func (p *MongoDBProvider) addUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoUsersCollection)
+		if err := bucket.removeSoftDeleted(user.Username); err != nil {
+			return err
+		}
+		exists, err := bucket.exists(user.Username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("username %v already exists", user.Username)
+		}
+		id, err := p.nextSequence(ctx, mongoUsersCollection)
+		if err != nil {
+			return err
+		}
+		user.ID = id
+		user.LastQuotaUpdate = 0
+		user.UsedQuotaSize = 0
+		user.UsedQuotaFiles = 0
+		user.UsedUploadDataTransfer = 0
+		user.UsedDownloadDataTransfer = 0
+		user.LastLogin = 0
+		user.FirstDownload = 0
+		user.FirstUpload = 0
+		user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err := p.addUserToRole(user.Username, user.Role, p.bucket(ctx, mongoRolesCollection)); err != nil {
+			return err
+		}
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		for idx := range user.VirtualFolders {
+			err = p.addRelationToFolderMapping(&user.VirtualFolders[idx].BaseVirtualFolder, user, nil, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		for idx := range user.Groups {
+			err = p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.insert(user.Username, user)
+	})
+}
+
+func (p *MongoDBProvider) updateUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoUsersCollection)
+		var oldUser User
+		found, err := bucket.get(user.Username, &oldUser)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		if err = p.updateUserRelations(ctx, user, oldUser); err != nil {
+			return err
+		}
+		user.ID = oldUser.ID
+		user.LastQuotaUpdate = oldUser.LastQuotaUpdate
+		user.UsedQuotaSize = oldUser.UsedQuotaSize
+		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
+		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+		user.LastLogin = oldUser.LastLogin
+		user.FirstDownload = oldUser.FirstDownload
+		user.FirstUpload = oldUser.FirstUpload
+		user.CreatedAt = oldUser.CreatedAt
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(user.Username, user)
+	})
+}
+
+func (p *MongoDBProvider) deleteUser(user User, softDelete bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoUsersCollection)
+		var oldUser User
+		// a soft deleted user is permanently removed later, so we search for it too
+		found, err := bucket.findOne(bson.M{"_id": user.Username}, &oldUser)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, p.bucket(ctx, mongoRolesCollection)); err != nil {
+			return err
+		}
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		for idx := range oldUser.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldUser.VirtualFolders[idx], oldUser.Username, "", foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		for idx := range oldUser.Groups {
+			err = p.removeUserFromGroupMapping(oldUser.Username, oldUser.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = p.bucket(ctx, mongoAPIKeysCollection).coll.DeleteMany(ctx, bson.M{"user": user.Username})
+		if err != nil {
+			return err
+		}
+		_, err = p.bucket(ctx, mongoSharesCollection).coll.DeleteMany(ctx, bson.M{"username": user.Username})
+		if err != nil {
+			return err
+		}
+		_, err = p.collection(mongoFoldersQuotaCollection).DeleteMany(ctx, bson.M{"username": user.Username})
+		if err != nil {
+			return err
+		}
+		if softDelete {
+			return bucket.softDelete(user.Username)
+		}
+		return bucket.delete(user.Username)
+	})
+}
+
+func (p *MongoDBProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	var user User
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoUsersCollection).findOne(bson.M{
+			"s3_access_keys": accessKeyID,
+			"deleted_at":     0,
+		}, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+		}
+		return nil
+	})
+	return user.Username, err
+}
+
+func (p *MongoDBProvider) updateUserPassword(username, password string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoUsersCollection)
+		var user User
+		found, err := bucket.get(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		user.Password = password
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *MongoDBProvider) dumpUsers() ([]User, error) {
+	users := make([]User, 0, 100)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		return p.bucket(ctx, mongoUsersCollection).iterate(bson.M{"deleted_at": 0}, OrderASC, 0, 0,
+			func(doc *mongoDocument) error {
+				var user User
+				if err := doc.unmarshal(&user); err != nil {
+					return err
+				}
+				if err := p.joinUserAndFolders(&user, foldersBucket); err != nil {
+					return err
+				}
+				users = append(users, user)
+				return nil
+			})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
+	users := make([]User, 0, 10)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gte": after}},
+				bson.M{"deleted_at": bson.M{"$gt": 0}},
+			},
+		}
+		return p.bucket(ctx, mongoUsersCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var user User
+			if err := doc.unmarshal(&user); err != nil {
+				return err
+			}
+			if err := p.joinUserAndGroups(&user, foldersBucket, groupsBucket); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
+	users := make([]User, 0, 10)
+	if len(toFetch) == 0 {
+		return users, nil
+	}
+	usernames := make([]string, 0, len(toFetch))
+	for username := range toFetch {
+		usernames = append(usernames, username)
+	}
+
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+		filter := bson.M{
+			"_id":        bson.M{"$in": usernames},
+			"deleted_at": 0,
+		}
+		return p.bucket(ctx, mongoUsersCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var user User
+			if err := doc.unmarshal(&user); err != nil {
+				return err
+			}
+			if !toFetch[user.Username] {
+				user.VirtualFolders = nil
+			}
+			if err := p.joinUserAndGroups(&user, foldersBucket, groupsBucket); err != nil {
+				return err
+			}
+			user.PrepareForRendering()
+			users = append(users, user)
+			return nil
+		})
+	})
+
+	return users, err
+}
+
+func (p *MongoDBProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		filter := bson.M{"deleted_at": 0}
+		if role != "" {
+			filter["role"] = role
+		}
+		return p.bucket(ctx, mongoUsersCollection).iterate(filter, order, offset, limit, func(doc *mongoDocument) error {
+			var user User
+			if err := doc.unmarshal(&user); err != nil {
+				return err
+			}
+			if err := p.joinUserAndFolders(&user, foldersBucket); err != nil {
+				return err
+			}
+			user.PrepareForRendering()
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoFoldersCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var folder vfs.BaseVirtualFolder
+			if err := doc.unmarshal(&folder); err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *MongoDBProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	if limit <= 0 {
+		return folders, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoFoldersCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var folder vfs.BaseVirtualFolder
+			if err := doc.unmarshal(&folder); err != nil {
+				return err
+			}
+			folder.PrepareForRendering()
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *MongoDBProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	err := p.view(func(ctx context.Context) error {
+		var err error
+		folder, err = p.folderExistsInternal(name, p.bucket(ctx, mongoFoldersCollection))
+		return err
+	})
+	return folder, err
+}
+
+func (p *MongoDBProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoFoldersCollection)
+		exists, err := bucket.exists(folder.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("folder %v already exists", folder.Name)
+		}
+		folder.Users = nil
+		folder.Groups = nil
+		return p.addFolderInternal(ctx, *folder, bucket)
+	})
+}
+
+func (p *MongoDBProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoFoldersCollection)
+		var oldFolder vfs.BaseVirtualFolder
+		found, err := bucket.get(folder.Name, &oldFolder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+		}
+		folder.ID = oldFolder.ID
+		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+		folder.Users = oldFolder.Users
+		folder.Groups = oldFolder.Groups
+		return bucket.put(folder.Name, folder)
+	})
+}
+
+func (p *MongoDBProvider) deleteFolderMappings(folder vfs.BaseVirtualFolder, usersBucket, groupsBucket *mongoBucket) error {
+	for _, username := range folder.Users {
+		var user User
+		found, err := usersBucket.get(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		var folders []vfs.VirtualFolder
+		for _, userFolder := range user.VirtualFolders {
+			if folder.Name != userFolder.Name {
+				folders = append(folders, userFolder)
+			}
+		}
+		user.VirtualFolders = folders
+		if err = usersBucket.put(user.Username, &user); err != nil {
+			return err
+		}
+	}
+	for _, groupname := range folder.Groups {
+		var group Group
+		found, err := groupsBucket.get(groupname, &group)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		var folders []vfs.VirtualFolder
+		for _, groupFolder := range group.VirtualFolders {
+			if folder.Name != groupFolder.Name {
+				folders = append(folders, groupFolder)
+			}
+		}
+		group.VirtualFolders = folders
+		if err = groupsBucket.put(group.Name, &group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoFoldersCollection)
+		var folder vfs.BaseVirtualFolder
+		found, err := bucket.get(baseFolder.Name, &folder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", baseFolder.Name))
+		}
+		err = p.deleteFolderMappings(folder, p.bucket(ctx, mongoUsersCollection), p.bucket(ctx, mongoGroupsCollection))
+		if err != nil {
+			return err
+		}
+		_, err = p.collection(mongoFoldersQuotaCollection).DeleteMany(ctx, bson.M{"folder_name": folder.Name})
+		if err != nil {
+			return err
+		}
+		return bucket.delete(folder.Name)
+	})
+}
+
+func (p *MongoDBProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	operator := "$inc"
+	if reset {
+		operator = "$set"
+	}
+	return p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoFoldersCollection).updateStats(name, bson.M{
+			operator: bson.M{
+				"stats.used_quota_size":  sizeAdd,
+				"stats.used_quota_files": filesAdd,
+			},
+			"$max": bson.M{"stats.last_quota_update": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}, fmt.Sprintf("folder %q does not exist, unable to update quota", name))
+	})
+}
+
+func (p *MongoDBProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.getFolderByName(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for folder %q error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p *MongoDBProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.view(func(ctx context.Context) error {
+		if err := p.userExistsInternal(ctx, username); err != nil {
+			return err
+		}
+		exists, err := p.bucket(ctx, mongoFoldersCollection).exists(folderName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", folderName))
+		}
+		var usedSize, usedFiles any = sizeAdd, filesAdd
+		if !reset {
+			// the usage cannot become negative, this could happen for files
+			// uploaded before defining the limits
+			usedSize = bson.M{"$max": bson.A{0, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$used_quota_size", 0}}, sizeAdd}}}}
+			usedFiles = bson.M{"$max": bson.A{0, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$used_quota_files", 0}}, filesAdd}}}}
+		}
+		_, err = p.collection(mongoFoldersQuotaCollection).UpdateOne(ctx,
+			bson.M{"username": username, "folder_name": folderName},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"used_quota_size":   usedSize,
+				"used_quota_files":  usedFiles,
+				"last_quota_update": util.GetTimeAsMsSinceEpoch(time.Now()),
+			}}}},
+			options.Update().SetUpsert(true))
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	var quota userFolderQuota
+	err := p.view(func(ctx context.Context) error {
+		err := p.collection(mongoFoldersQuotaCollection).FindOne(ctx, bson.M{"username": username, "folder_name": folderName}).Decode(&quota)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %q inside folder %q error: %v", username, folderName, err)
+		return 0, 0, err
+	}
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
+func (p *MongoDBProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
+	groups := make([]Group, 0, limit)
+	if limit <= 0 {
+		return groups, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		return p.bucket(ctx, mongoGroupsCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var group Group
+			if err := doc.unmarshal(&group); err != nil {
+				return err
+			}
+			if err := p.joinGroupAndFolders(&group, foldersBucket); err != nil {
+				return err
+			}
+			group.PrepareForRendering()
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) getGroupsWithNames(names []string) ([]Group, error) {
+	var groups []Group
+	if len(names) == 0 {
+		return groups, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		filter := bson.M{"_id": bson.M{"$in": names}}
+		return p.bucket(ctx, mongoGroupsCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var group Group
+			if err := doc.unmarshal(&group); err != nil {
+				return err
+			}
+			if err := p.joinGroupAndFolders(&group, foldersBucket); err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) getUsersInGroups(names []string) ([]string, error) {
+	var usernames []string
+	if len(names) == 0 {
+		return usernames, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.M{"_id": bson.M{"$in": names}}
+		return p.bucket(ctx, mongoGroupsCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var group Group
+			if err := doc.unmarshal(&group); err != nil {
+				return err
+			}
+			usernames = append(usernames, group.Users...)
+			return nil
+		})
+	})
+	return usernames, err
+}
+
+func (p *MongoDBProvider) groupExists(name string) (Group, error) {
+	var group Group
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoGroupsCollection).get(name, &group)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+		}
+		return p.joinGroupAndFolders(&group, p.bucket(ctx, mongoFoldersCollection))
+	})
+	return group, err
+}
+
+func (p *MongoDBProvider) addGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoGroupsCollection)
+		exists, err := bucket.exists(group.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("group %v already exists", group.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoGroupsCollection)
+		if err != nil {
+			return err
+		}
+		group.ID = id
+		group.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.Users = nil
+		group.Admins = nil
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.insert(group.Name, group)
+	})
+}
+
+func (p *MongoDBProvider) updateGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoGroupsCollection)
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		var oldGroup Group
+		found, err := bucket.get(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		group.ID = oldGroup.ID
+		group.CreatedAt = oldGroup.CreatedAt
+		group.Users = oldGroup.Users
+		group.Admins = oldGroup.Admins
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(group.Name, group)
+	})
+}
+
+func (p *MongoDBProvider) deleteGroup(group Group) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoGroupsCollection)
+		var oldGroup Group
+		found, err := bucket.get(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		if len(oldGroup.Users) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", oldGroup.Name))
+		}
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		adminsBucket := p.bucket(ctx, mongoAdminsCollection)
+		for idx := range oldGroup.Admins {
+			err = p.removeGroupFromAdminMapping(oldGroup.Name, oldGroup.Admins[idx], adminsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.delete(group.Name)
+	})
+}
+
+func (p *MongoDBProvider) dumpGroups() ([]Group, error) {
+	groups := make([]Group, 0, 50)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+		return p.bucket(ctx, mongoGroupsCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var group Group
+			if err := doc.unmarshal(&group); err != nil {
+				return err
+			}
+			if err := p.joinGroupAndFolders(&group, foldersBucket); err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) apiKeyExists(keyID string) (APIKey, error) {
+	var apiKey APIKey
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoAPIKeysCollection).get(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", keyID))
+		}
+		return nil
+	})
+	return apiKey, err
+}
+
+func (p *MongoDBProvider) addAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAPIKeysCollection)
+		exists, err := bucket.exists(apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("API key %v already exists", apiKey.KeyID)
+		}
+		id, err := p.nextSequence(ctx, mongoAPIKeysCollection)
+		if err != nil {
+			return err
+		}
+		apiKey.ID = id
+		apiKey.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.LastUseAt = 0
+		if err := p.checkAPIKeyRelations(ctx, apiKey); err != nil {
+			return err
+		}
+		return bucket.insert(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *MongoDBProvider) updateAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoAPIKeysCollection)
+		var oldAPIKey APIKey
+		found, err := bucket.get(apiKey.KeyID, &oldAPIKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		apiKey.ID = oldAPIKey.ID
+		apiKey.KeyID = oldAPIKey.KeyID
+		apiKey.Key = oldAPIKey.Key
+		apiKey.CreatedAt = oldAPIKey.CreatedAt
+		apiKey.LastUseAt = oldAPIKey.LastUseAt
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err := p.checkAPIKeyRelations(ctx, apiKey); err != nil {
+			return err
+		}
+		return bucket.put(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *MongoDBProvider) deleteAPIKey(apiKey APIKey) error {
+	return p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAPIKeysCollection).delete(apiKey.KeyID)
+	})
+}
+
+func (p *MongoDBProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+	if limit <= 0 {
+		return apiKeys, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAPIKeysCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var apiKey APIKey
+			if err := doc.unmarshal(&apiKey); err != nil {
+				return err
+			}
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+
+	return apiKeys, err
+}
+
+func (p *MongoDBProvider) dumpAPIKeys() ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoAPIKeysCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var apiKey APIKey
+			if err := doc.unmarshal(&apiKey); err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+
+	return apiKeys, err
+}
+
+func (p *MongoDBProvider) shareExists(shareID, username string) (Share, error) {
+	var share Share
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoSharesCollection).get(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found || (username != "" && share.Username != username) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", shareID))
+		}
+		return nil
+	})
+	return share, err
+}
+
+func (p *MongoDBProvider) addShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoSharesCollection)
+		exists, err := bucket.exists(share.ShareID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("share %v already exists", share.ShareID)
+		}
+		id, err := p.nextSequence(ctx, mongoSharesCollection)
+		if err != nil {
+			return err
+		}
+		share.ID = id
+		if !share.IsRestore {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			share.UpdatedAt = share.CreatedAt
+			share.LastUseAt = 0
+			share.UsedTokens = 0
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		if err := p.userExistsInternal(ctx, share.Username); err != nil {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		return bucket.insert(share.ShareID, share)
+	})
+}
+
+func (p *MongoDBProvider) updateShare(share *Share) error {
+	if err := share.validate(); err != nil {
+		return err
+	}
+
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoSharesCollection)
+		var oldObject Share
+		found, err := bucket.get(share.ShareID, &oldObject)
+		if err != nil {
+			return err
+		}
+		if !found || oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+
+		share.ID = oldObject.ID
+		share.ShareID = oldObject.ShareID
+		if !share.IsRestore {
+			share.UsedTokens = oldObject.UsedTokens
+			share.CreatedAt = oldObject.CreatedAt
+			share.LastUseAt = oldObject.LastUseAt
+			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		if err := p.userExistsInternal(ctx, share.Username); err != nil {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		if share.IsRestore {
+			// restored shares keep the usage stats from the backup
+			return bucket.replace(share.ShareID, share)
+		}
+		return bucket.put(share.ShareID, share)
+	})
+}
+
+func (p *MongoDBProvider) deleteShare(share Share) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoSharesCollection).DeleteOne(ctx, bson.M{
+			"_id":      share.ShareID,
+			"username": share.Username,
+		})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+	if limit <= 0 {
+		return shares, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.M{"username": username}
+		return p.bucket(ctx, mongoSharesCollection).iterate(filter, order, offset, limit, func(doc *mongoDocument) error {
+			var share Share
+			if err := doc.unmarshal(&share); err != nil {
+				return err
+			}
+			share.HideConfidentialData()
+			shares = append(shares, share)
+			return nil
+		})
+	})
+
+	return shares, err
+}
+
+func (p *MongoDBProvider) dumpShares() ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoSharesCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var share Share
+			if err := doc.unmarshal(&share); err != nil {
+				return err
+			}
+			shares = append(shares, share)
+			return nil
+		})
+	})
+
+	return shares, err
+}
+
+func (p *MongoDBProvider) updateShareLastUse(shareID string, numTokens int) error {
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoSharesCollection).updateStats(shareID, bson.M{
+			"$set": bson.M{"stats.last_use_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+			"$inc": bson.M{"stats.used_tokens": numTokens},
+		}, fmt.Sprintf("share %q does not exist, unable to update last use", shareID))
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last use for share %q: %v", shareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "last use updated for share %q", shareID)
+	return nil
+}
+
+func (p *MongoDBProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
+	hosts := make([]DefenderEntry, 0, 100)
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gte": from}},
+				bson.M{"ban_time": bson.M{"$gt": 0}},
+			},
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}).SetLimit(int64(limit))
+		cursor, err := p.collection(mongoDefenderHostsCollection).Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var host mongoDefenderHost
+			if err := cursor.Decode(&host); err != nil {
+				return err
+			}
+			if entry, ok := host.toDefenderEntry(from); ok {
+				hosts = append(hosts, entry)
+			}
+		}
+		return cursor.Err()
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get defender hosts: %v", err)
+	}
+	return hosts, err
+}
+
+func (p *MongoDBProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	var entry DefenderEntry
+	err := p.view(func(ctx context.Context) error {
+		var host mongoDefenderHost
+		err := p.collection(mongoDefenderHostsCollection).FindOne(ctx, bson.M{
+			"_id": ip,
+			"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gte": from}},
+				bson.M{"ban_time": bson.M{"$gt": 0}},
+			},
+		}).Decode(&host)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError("host not found")
+			}
+			providerLog(logger.LevelError, "unable to get host for ip %q: %v", ip, err)
+			return err
+		}
+		var ok bool
+		entry, ok = host.toDefenderEntry(from)
+		if !ok {
+			return util.NewRecordNotFoundError("host not found")
+		}
+		return nil
+	})
+	return entry, err
+}
+
+func (p *MongoDBProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	var entry DefenderEntry
+	err := p.view(func(ctx context.Context) error {
+		var host mongoDefenderHost
+		err := p.collection(mongoDefenderHostsCollection).FindOne(ctx, bson.M{
+			"_id":      ip,
+			"ban_time": bson.M{"$gte": util.GetTimeAsMsSinceEpoch(time.Now())},
+		}).Decode(&host)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError("host not found")
+			}
+			providerLog(logger.LevelError, "unable to check ban status for host %q: %v", ip, err)
+			return err
+		}
+		entry.IP = host.IP
+		return nil
+	})
+	return entry, err
+}
+
+func (p *MongoDBProvider) updateDefenderBanTime(ip string, minutes int) error {
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip},
+			bson.M{"$inc": bson.M{"ban_time": int64(minutes) * 60000}})
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ban time updated for ip %q, increment (minutes): %v", ip, minutes)
+	} else {
+		providerLog(logger.LevelError, "error updating ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteDefenderHost(ip string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoDefenderHostsCollection).DeleteOne(ctx, bson.M{"_id": ip})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to delete defender host %q: %v", ip, err)
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("host %q not found", ip))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) addDefenderEvent(ip string, score int) error {
+	err := p.view(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip}, bson.M{
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"ban_time": int64(0)},
+			"$push":        bson.M{"events": mongoDefenderEvent{DateTime: now, Score: score}},
+		}, options.Update().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to add defender event for %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) setDefenderBanTime(ip string, banTime int64) error {
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip},
+			bson.M{"$set": bson.M{"ban_time": banTime}})
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ip %q banned until %v", ip, util.GetTimeFromMsecSinceEpoch(banTime))
+	} else {
+		providerLog(logger.LevelError, "error setting ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) cleanupDefender(from int64) error {
+	return p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		coll := p.collection(mongoDefenderHostsCollection)
+		_, err := coll.UpdateMany(ctx, bson.M{"events.date_time": bson.M{"$lt": from}},
+			bson.M{"$pull": bson.M{"events": bson.M{"date_time": bson.M{"$lt": from}}}})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup defender events: %v", err)
+			return err
+		}
+		_, err = coll.DeleteMany(ctx, bson.M{
+			"ban_time": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(time.Now())},
+			"events":   bson.M{"$not": bson.M{"$elemMatch": bson.M{"date_time": bson.M{"$gt": from}}}},
+		})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup defender hosts: %v", err)
+		}
+		return err
+	})
+}
+
+func (p *MongoDBProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	return p.view(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.collection(mongoActiveTransfersCollection).InsertOne(ctx, mongoActiveTransfer{
+			TransferID:    transfer.ID,
+			ConnectionID:  transfer.ConnID,
+			Type:          transfer.Type,
+			Username:      transfer.Username,
+			FolderName:    transfer.FolderName,
+			IP:            transfer.IP,
+			TruncatedSize: transfer.TruncatedSize,
+			CurrentULSize: transfer.CurrentULSize,
+			CurrentDLSize: transfer.CurrentDLSize,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoActiveTransfersCollection).UpdateMany(ctx, bson.M{
+			"connection_id": connectionID,
+			"transfer_id":   transferID,
+		}, bson.M{"$set": bson.M{
+			"current_ul_size": ulSize,
+			"current_dl_size": dlSize,
+			"updated_at":      util.GetTimeAsMsSinceEpoch(time.Now()),
+		}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoActiveTransfersCollection).DeleteMany(ctx, bson.M{
+			"connection_id": connectionID,
+			"transfer_id":   transferID,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) cleanupActiveTransfers(before time.Time) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoActiveTransfersCollection).DeleteMany(ctx, bson.M{
+			"updated_at": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(before)},
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	transfers := make([]ActiveTransfer, 0, 30)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		cursor, err := p.collection(mongoActiveTransfersCollection).Find(ctx, bson.M{
+			"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(from)},
+		})
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var transfer mongoActiveTransfer
+			if err := cursor.Decode(&transfer); err != nil {
+				return err
+			}
+			transfers = append(transfers, ActiveTransfer{
+				ID:            transfer.TransferID,
+				Type:          transfer.Type,
+				ConnID:        transfer.ConnectionID,
+				Username:      transfer.Username,
+				FolderName:    transfer.FolderName,
+				IP:            transfer.IP,
+				TruncatedSize: transfer.TruncatedSize,
+				CurrentULSize: transfer.CurrentULSize,
+				CurrentDLSize: transfer.CurrentDLSize,
+				CreatedAt:     transfer.CreatedAt,
+				UpdatedAt:     transfer.UpdatedAt,
+			})
+		}
+		return cursor.Err()
+	})
+	return transfers, err
+}
+
+func (p *MongoDBProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoSharedSessionsCollection).ReplaceOne(ctx, bson.M{"_id": session.Key}, mongoSession{
+			Key:       session.Key,
+			Data:      string(data),
+			Type:      session.Type,
+			Timestamp: session.Timestamp,
+		}, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (p *MongoDBProvider) deleteSharedSession(key string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoSharedSessionsCollection).DeleteOne(ctx, bson.M{"_id": key})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("session %q not found", key))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) getSharedSession(key string) (Session, error) {
+	var session Session
+	err := p.view(func(ctx context.Context) error {
+		var s mongoSession
+		err := p.collection(mongoSharedSessionsCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&s)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("session %q not found", key))
+			}
+			return err
+		}
+		session = s.toSession()
+		return nil
+	})
+	return session, err
+}
+
+func (p *MongoDBProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	var sessions []Session
+	err := p.view(func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+		cursor, err := p.collection(mongoSharedSessionsCollection).Find(ctx, bson.M{"type": sessionType}, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var s mongoSession
+			if err := cursor.Decode(&s); err != nil {
+				return err
+			}
+			sessions = append(sessions, s.toSession())
+		}
+		return cursor.Err()
+	})
+	return sessions, err
+}
+
+func (p *MongoDBProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoSharedSessionsCollection).DeleteMany(ctx, bson.M{
+			"type":      sessionType,
+			"timestamp": bson.M{"$lt": before},
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	actions := make([]BaseEventAction, 0, limit)
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoActionsCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var action BaseEventAction
+			if err := doc.unmarshal(&action); err != nil {
+				return err
+			}
+			action.PrepareForRendering()
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *MongoDBProvider) dumpEventActions() ([]BaseEventAction, error) {
+	actions := make([]BaseEventAction, 0, 50)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoActionsCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var action BaseEventAction
+			if err := doc.unmarshal(&action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *MongoDBProvider) eventActionExists(name string) (BaseEventAction, error) {
+	var action BaseEventAction
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoActionsCollection).get(name, &action)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %q does not exist", name))
+		}
+		return nil
+	})
+	return action, err
+}
+
+func (p *MongoDBProvider) addEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoActionsCollection)
+		exists, err := bucket.exists(action.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("event action %s already exists", action.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoActionsCollection)
+		if err != nil {
+			return err
+		}
+		action.ID = id
+		action.Rules = nil
+		return bucket.insert(action.Name, action)
+	})
+}
+
+func (p *MongoDBProvider) updateEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoActionsCollection)
+		var oldAction BaseEventAction
+		found, err := bucket.get(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event action %s does not exist", action.Name))
+		}
+		action.ID = oldAction.ID
+		action.Name = oldAction.Name
+		action.Rules = nil
+		if len(oldAction.Rules) > 0 {
+			rulesBucket := p.bucket(ctx, mongoRulesCollection)
+			var relatedRules []string
+			for _, ruleName := range oldAction.Rules {
+				var rule EventRule
+				found, err := rulesBucket.get(ruleName, &rule)
+				if err != nil {
+					return err
+				}
+				if !found {
+					continue
+				}
+				relatedRules = append(relatedRules, ruleName)
+				rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+				if err = rulesBucket.put(rule.Name, &rule); err != nil {
+					return err
+				}
+			}
+			action.Rules = relatedRules
+		}
+		return bucket.put(action.Name, action)
+	})
+}
+
+func (p *MongoDBProvider) deleteEventAction(action BaseEventAction) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoActionsCollection)
+		var oldAction BaseEventAction
+		found, err := bucket.get(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %s does not exist", action.Name))
+		}
+		if len(oldAction.Rules) > 0 {
+			return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
+		}
+		return bucket.delete(action.Name)
+	})
+}
+
+func (p *MongoDBProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rules := make([]EventRule, 0, limit)
+	err := p.view(func(ctx context.Context) error {
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		return p.bucket(ctx, mongoRulesCollection).iterate(bson.M{"deleted_at": 0}, order, offset, limit,
+			func(doc *mongoDocument) error {
+				var rule EventRule
+				if err := doc.unmarshal(&rule); err != nil {
+					return err
+				}
+				if err := p.joinRuleAndActions(&rule, actionsBucket); err != nil {
+					return err
+				}
+				rule.PrepareForRendering()
+				rules = append(rules, rule)
+				return nil
+			})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) dumpEventRules() ([]EventRule, error) {
+	rules := make([]EventRule, 0, 50)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		return p.bucket(ctx, mongoRulesCollection).iterate(bson.M{"deleted_at": 0}, OrderASC, 0, 0,
+			func(doc *mongoDocument) error {
+				var rule EventRule
+				if err := doc.unmarshal(&rule); err != nil {
+					return err
+				}
+				if err := p.joinRuleAndActions(&rule, actionsBucket); err != nil {
+					return err
+				}
+				rules = append(rules, rule)
+				return nil
+			})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
+	rules := make([]EventRule, 0, 10)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gte": after}},
+				bson.M{"deleted_at": bson.M{"$gt": 0}},
+			},
+		}
+		return p.bucket(ctx, mongoRulesCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var rule EventRule
+			if err := doc.unmarshal(&rule); err != nil {
+				return err
+			}
+			if err := p.joinRuleAndActions(&rule, actionsBucket); err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) eventRuleExists(name string) (EventRule, error) {
+	var rule EventRule
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoRulesCollection).get(name, &rule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", name))
+		}
+		return p.joinRuleAndActions(&rule, p.bucket(ctx, mongoActionsCollection))
+	})
+	return rule, err
+}
+
+func (p *MongoDBProvider) addEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRulesCollection)
+		if err := bucket.removeSoftDeleted(rule.Name); err != nil {
+			return err
+		}
+		exists, err := bucket.exists(rule.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("event rule %q already exists", rule.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoRulesCollection)
+		if err != nil {
+			return err
+		}
+		rule.ID = id
+		rule.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		rule.UpdatedAt = rule.CreatedAt
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.insert(rule.Name, rule)
+	})
+}
+
+func (p *MongoDBProvider) updateEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRulesCollection)
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		var oldRule EventRule
+		found, err := bucket.get(rule.Name, &oldRule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		rule.ID = oldRule.ID
+		rule.CreatedAt = oldRule.CreatedAt
+		rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.put(rule.Name, rule)
+	})
+}
+
+func (p *MongoDBProvider) deleteEventRule(rule EventRule, softDelete bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRulesCollection)
+		var oldRule EventRule
+		found, err := bucket.findOne(bson.M{"_id": rule.Name}, &oldRule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		actionsBucket := p.bucket(ctx, mongoActionsCollection)
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		if softDelete {
+			return bucket.softDelete(rule.Name)
+		}
+		if err := bucket.delete(rule.Name); err != nil {
+			return err
+		}
+		_, err = p.collection(mongoTasksCollection).DeleteOne(ctx, bson.M{"_id": rule.Name})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getTaskByName(name string) (Task, error) {
+	task := Task{
+		Name: name,
+	}
+	err := p.view(func(ctx context.Context) error {
+		var t mongoTask
+		err := p.collection(mongoTasksCollection).FindOne(ctx, bson.M{"_id": name}).Decode(&t)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("task %q not found", name))
+			}
+			return err
+		}
+		task.UpdateAt = t.UpdatedAt
+		task.Version = t.Version
+		return nil
+	})
+	return task, err
+}
+
+func (p *MongoDBProvider) addTask(name string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoTasksCollection).InsertOne(ctx, mongoTask{
+			Name:      name,
+			UpdatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+			Version:   0,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) updateTask(name string, version int64) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoTasksCollection).UpdateOne(ctx, bson.M{"_id": name, "version": version}, bson.M{
+			"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+			"$inc": bson.M{"version": int64(1)},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q with version %d not found", name, version))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) updateTaskTimestamp(name string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoTasksCollection).UpdateOne(ctx, bson.M{"_id": name}, bson.M{
+			"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q not found", name))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	data, err := json.Marshal(currentNode.Data)
+	if err != nil {
+		return err
+	}
+	err = p.view(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.collection(mongoNodesCollection).ReplaceOne(ctx, bson.M{"_id": currentNode.Name}, mongoNode{
+			Name:      currentNode.Name,
+			Data:      string(data),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}, options.Replace().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+
+	return nil
+}
+
+func (p *MongoDBProvider) getNodeByName(name string) (Node, error) {
+	var node Node
+	err := p.view(func(ctx context.Context) error {
+		var n mongoNode
+		err := p.collection(mongoNodesCollection).FindOne(ctx, bson.M{
+			"_id":        name,
+			"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))},
+		}).Decode(&n)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("node %q not found", name))
+			}
+			return err
+		}
+		node, err = n.toNode()
+		return err
+	})
+	return node, err
+}
+
+func (p *MongoDBProvider) getNodes() ([]Node, error) {
+	var nodes []Node
+	err := p.view(func(ctx context.Context) error {
+		cursor, err := p.collection(mongoNodesCollection).Find(ctx, bson.M{
+			"_id":        bson.M{"$ne": currentNode.Name},
+			"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))},
+		})
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var n mongoNode
+			if err := cursor.Decode(&n); err != nil {
+				return err
+			}
+			node, err := n.toNode()
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
+		return cursor.Err()
+	})
+	return nodes, err
+}
+
+func (p *MongoDBProvider) updateNodeTimestamp() error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoNodesCollection).UpdateOne(ctx, bson.M{"_id": currentNode.Name}, bson.M{
+			"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("node %q not found", currentNode.Name))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) cleanupNodes() error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoNodesCollection).DeleteMany(ctx, bson.M{
+			"updated_at": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(time.Now().Add(10 * activeNodeTimeDiff))},
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) roleExists(name string) (Role, error) {
+	var role Role
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoRolesCollection).get(name, &role)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", name))
+		}
+		return nil
+	})
+	return role, err
+}
+
+func (p *MongoDBProvider) addRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRolesCollection)
+		exists, err := bucket.exists(role.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("role %q already exists", role.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoRolesCollection)
+		if err != nil {
+			return err
+		}
+		role.ID = id
+		role.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = nil
+		role.Admins = nil
+		return bucket.insert(role.Name, role)
+	})
+}
+
+func (p *MongoDBProvider) updateRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRolesCollection)
+		var oldRole Role
+		found, err := bucket.get(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		role.ID = oldRole.ID
+		role.CreatedAt = oldRole.CreatedAt
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = oldRole.Users
+		role.Admins = oldRole.Admins
+		return bucket.put(role.Name, role)
+	})
+}
+
+func (p *MongoDBProvider) deleteRole(role Role) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoRolesCollection)
+		var oldRole Role
+		found, err := bucket.get(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		if len(oldRole.Admins) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
+		}
+		usersBucket := p.bucket(ctx, mongoUsersCollection)
+		for _, username := range oldRole.Users {
+			if err := p.removeRoleFromUser(username, oldRole.Name, usersBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(role.Name)
+	})
+}
+
+func (p *MongoDBProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
+	roles := make([]Role, 0, limit)
+	if limit <= 0 {
+		return roles, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoRolesCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var role Role
+			if err := doc.unmarshal(&role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *MongoDBProvider) dumpRoles() ([]Role, error) {
+	roles := make([]Role, 0, 10)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoRolesCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var role Role
+			if err := doc.unmarshal(&role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *MongoDBProvider) templateExists(name string) (Template, error) {
+	var template Template
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoTemplatesCollection).get(name, &template)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", name))
+		}
+		return nil
+	})
+	return template, err
+}
+
+func (p *MongoDBProvider) addTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTemplatesCollection)
+		exists, err := bucket.exists(template.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("template %q already exists", template.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoTemplatesCollection)
+		if err != nil {
+			return err
+		}
+		template.ID = id
+		template.Version = 1
+		template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.insert(template.Name, template)
+	})
+}
+
+func (p *MongoDBProvider) updateTemplate(template *Template) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTemplatesCollection)
+		var oldTemplate Template
+		found, err := bucket.get(template.Name, &oldTemplate)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		template.ID = oldTemplate.ID
+		template.Type = oldTemplate.Type
+		template.Version = oldTemplate.Version + 1
+		template.CreatedAt = oldTemplate.CreatedAt
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(template.Name, template)
+	})
+}
+
+func (p *MongoDBProvider) deleteTemplate(template Template) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTemplatesCollection)
+		exists, err := bucket.exists(template.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", template.Name))
+		}
+		return bucket.delete(template.Name)
+	})
+}
+
+func (p *MongoDBProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	templates := make([]Template, 0, limit)
+	if limit <= 0 {
+		return templates, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoTemplatesCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var template Template
+			if err := doc.unmarshal(&template); err != nil {
+				return err
+			}
+			templates = append(templates, template)
+			return nil
+		})
+	})
+	return templates, err
+}
+
+func (p *MongoDBProvider) dumpTemplates() ([]Template, error) {
+	templates := make([]Template, 0, 10)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoTemplatesCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var template Template
+			if err := doc.unmarshal(&template); err != nil {
+				return err
+			}
+			templates = append(templates, template)
+			return nil
+		})
+	})
+	return templates, err
+}
+
+func (p *MongoDBProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoTenantsCollection).get(name, &tenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return nil
+	})
+	return tenant, err
+}
+
+func (p *MongoDBProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTenantsCollection)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("tenant %q already exists", tenant.Name)
+		}
+		id, err := p.nextSequence(ctx, mongoTenantsCollection)
+		if err != nil {
+			return err
+		}
+		tenant.ID = id
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.insert(tenant.Name, tenant)
+	})
+}
+
+func (p *MongoDBProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTenantsCollection)
+		var oldTenant Tenant
+		found, err := bucket.get(tenant.Name, &oldTenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(tenant.Name, tenant)
+	})
+}
+
+func (p *MongoDBProvider) deleteTenant(tenant Tenant) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoTenantsCollection)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		return bucket.delete(tenant.Name)
+	})
+}
+
+func (p *MongoDBProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoTenantsCollection).iterate(bson.M{}, order, offset, limit, func(doc *mongoDocument) error {
+			var tenant Tenant
+			if err := doc.unmarshal(&tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *MongoDBProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoTenantsCollection).iterate(bson.M{}, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var tenant Tenant
+			if err := doc.unmarshal(&tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *MongoDBProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
+	entry := IPListEntry{
+		IPOrNet: ipOrNet,
+		Type:    listType,
+	}
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.bucket(ctx, mongoIPListsCollection).get(entry.getKey(), &entry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.PrepareForRendering()
+		return nil
+	})
+	return entry, err
+}
+
+func (p *MongoDBProvider) addIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoIPListsCollection)
+		if err := bucket.removeSoftDeleted(entry.getKey()); err != nil {
+			return err
+		}
+		exists, err := bucket.exists(entry.getKey())
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("entry %q already exists", entry.IPOrNet)
+		}
+		entry.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.insert(entry.getKey(), entry)
+	})
+}
+
+func (p *MongoDBProvider) updateIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoIPListsCollection)
+		var oldEntry IPListEntry
+		found, err := bucket.get(entry.getKey(), &oldEntry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.CreatedAt = oldEntry.CreatedAt
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(entry.getKey(), entry)
+	})
+}
+
+func (p *MongoDBProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) error {
+	return p.view(func(ctx context.Context) error {
+		bucket := p.bucket(ctx, mongoIPListsCollection)
+		if softDelete {
+			return bucket.softDelete(entry.getKey())
+		}
+		return bucket.delete(entry.getKey())
+	})
+}
+
+func (p *MongoDBProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 15)
+	err := p.view(func(ctx context.Context) error {
+		query := bson.M{
+			"type":       listType,
+			"deleted_at": 0,
+		}
+		if from != "" {
+			fromEntry := IPListEntry{
+				IPOrNet: from,
+				Type:    listType,
+			}
+			if order == OrderASC {
+				query["_id"] = bson.M{"$gt": fromEntry.getKey()}
+			} else {
+				query["_id"] = bson.M{"$lt": fromEntry.getKey()}
+			}
+		}
+		if filter != "" {
+			query["ipornet"] = bson.M{"$regex": "^" + regexp.QuoteMeta(filter)}
+		}
+		return p.bucket(ctx, mongoIPListsCollection).iterate(query, order, 0, limit, func(doc *mongoDocument) error {
+			var entry IPListEntry
+			if err := doc.unmarshal(&entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 5)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gte": after}},
+				bson.M{"deleted_at": bson.M{"$gt": 0}},
+			},
+		}
+		return p.bucket(ctx, mongoIPListsCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var entry IPListEntry
+			if err := doc.unmarshal(&entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) dumpIPListEntries() ([]IPListEntry, error) {
+	count, err := p.countIPListEntries(0)
+	if err != nil {
+		return nil, err
+	}
+	if count > ipListMemoryLimit {
+		providerLog(logger.LevelInfo, "IP lists excluded from dump, too many entries: %d", count)
+		return nil, nil
+	}
+	entries := make([]IPListEntry, 0, 10)
+	err = p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		return p.bucket(ctx, mongoIPListsCollection).iterate(bson.M{"deleted_at": 0}, OrderASC, 0, 0,
+			func(doc *mongoDocument) error {
+				var entry IPListEntry
+				if err := doc.unmarshal(&entry); err != nil {
+					return err
+				}
+				entry.PrepareForRendering()
+				entries = append(entries, entry)
+				return nil
+			})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) countIPListEntries(listType IPListType) (int64, error) {
+	var count int64
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.M{"deleted_at": 0}
+		if listType != 0 {
+			filter["type"] = listType
+		}
+		var err error
+		count, err = p.collection(mongoIPListsCollection).CountDocuments(ctx, filter)
+		return err
+	})
+	return count, err
+}
+
+func (p *MongoDBProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 3)
+	ipAddr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return entries, fmt.Errorf("invalid ip address %s", ip)
+	}
+	var netType int
+	var ipBytes []byte
+	if ipAddr.Is4() || ipAddr.Is4In6() {
+		netType = ipTypeV4
+		as4 := ipAddr.As4()
+		ipBytes = as4[:]
+	} else {
+		netType = ipTypeV6
+		as16 := ipAddr.As16()
+		ipBytes = as16[:]
+	}
+	err = p.view(func(ctx context.Context) error {
+		filter := bson.M{
+			"type":       listType,
+			"deleted_at": 0,
+			"ip_type":    netType,
+			"first":      bson.M{"$lte": ipBytes},
+			"last":       bson.M{"$gte": ipBytes},
+		}
+		return p.bucket(ctx, mongoIPListsCollection).iterate(filter, OrderASC, 0, 0, func(doc *mongoDocument) error {
+			var entry IPListEntry
+			if err := doc.unmarshal(&entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) getConfigs() (Configs, error) {
+	var configs Configs
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.bucket(ctx, mongoConfigsCollection).get(mongoConfigsKey, &configs)
+		return err
+	})
+	return configs, err
+}
+
+func (p *MongoDBProvider) setConfigs(configs *Configs) error {
+	if err := configs.validate(); err != nil {
+		return err
+	}
+	return p.view(func(ctx context.Context) error {
+		return p.bucket(ctx, mongoConfigsCollection).put(mongoConfigsKey, configs)
+	})
+}
+
+func (p *MongoDBProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return p.view(func(ctx context.Context) error {
+		id, err := p.nextSequence(ctx, mongoAuditLogsCollection)
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = p.collection(mongoAuditLogsCollection).InsertOne(ctx, bson.M{
+			"_id":         entry.ID,
+			"data":        string(buf),
+			"timestamp":   entry.Timestamp,
+			"action":      entry.Action,
+			"object_type": entry.ObjectType,
+			"object_name": entry.ObjectName,
+			"username":    entry.Username,
+			"role":        entry.Role,
+			"ip":          entry.IP,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, search.Limit)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: getMongoSortOrder(search.Order)}})
+		if search.Offset > 0 {
+			opts.SetSkip(int64(search.Offset))
+		}
+		if search.Limit > 0 {
+			opts.SetLimit(int64(search.Limit))
+		}
+		cursor, err := p.collection(mongoAuditLogsCollection).Find(ctx, getMongoAuditLogsFilter(search), opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var doc mongoAuditLogDocument
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			var entry AuditLogEntry
+			if err := json.Unmarshal([]byte(doc.Data), &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return cursor.Err()
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) cleanupAuditLogs(before int64) error {
+	return p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		_, err := p.collection(mongoAuditLogsCollection).DeleteMany(ctx, bson.M{"timestamp": bson.M{"$lt": before}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) addChangeEvent(event *ChangeEvent) error {
+	return p.view(func(ctx context.Context) error {
+		id, err := p.nextSequence(ctx, mongoChangeEventsCollection)
+		if err != nil {
+			return err
+		}
+		event.ID = id
+		buf, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = p.collection(mongoChangeEventsCollection).InsertOne(ctx, bson.M{
+			"_id":       event.ID,
+			"data":      string(buf),
+			"timestamp": event.Timestamp,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	events := make([]ChangeEvent, 0, limit)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+		cursor, err := p.collection(mongoChangeEventsCollection).Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var doc mongoAuditLogDocument
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			var event ChangeEvent
+			if err := json.Unmarshal([]byte(doc.Data), &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return cursor.Err()
+	})
+	return events, err
+}
+
+func (p *MongoDBProvider) cleanupChangeEvents(before int64) error {
+	return p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		_, err := p.collection(mongoChangeEventsCollection).DeleteMany(ctx, bson.M{"timestamp": bson.M{"$lt": before}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) addDeletedObject(object *DeletedObject) error {
+	return p.view(func(ctx context.Context) error {
+		id, err := p.nextSequence(ctx, mongoDeletedObjectsCollection)
+		if err != nil {
+			return err
+		}
+		object.ID = id
+		_, err = p.collection(mongoDeletedObjectsCollection).InsertOne(ctx, mongoDeletedObject{
+			ID:         object.ID,
+			ObjectType: object.ObjectType,
+			ObjectName: object.ObjectName,
+			DeletedAt:  object.DeletedAt,
+			Data:       object.Data,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	objects := make([]DeletedObject, 0, limit)
+	err := p.viewWithTimeout(longMongoQueryTimeout, func(ctx context.Context) error {
+		filter := bson.M{}
+		if objectType != "" {
+			filter["object_type"] = objectType
+		}
+		if before > 0 {
+			filter["deleted_at"] = bson.M{"$lt": before}
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+		if offset > 0 {
+			opts.SetSkip(int64(offset))
+		}
+		cursor, err := p.collection(mongoDeletedObjectsCollection).Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var doc mongoDeletedObject
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			objects = append(objects, doc.toDeletedObject())
+		}
+		return cursor.Err()
+	})
+	return objects, err
+}
+
+func (p *MongoDBProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	var object DeletedObject
+	err := p.view(func(ctx context.Context) error {
+		var doc mongoDeletedObject
+		err := p.collection(mongoDeletedObjectsCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+			}
+			return err
+		}
+		object = doc.toDeletedObject()
+		return nil
+	})
+	return object, err
+}
+
+func (p *MongoDBProvider) removeDeletedObject(id int64) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoDeletedObjectsCollection).DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError(fmt.Sprintf("deleted object with id %d does not exist", id))
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) setFirstDownloadTimestamp(username string) error {
+	return p.setFirstTransferTimestamp(username, "first_download", "download")
+}
+
+func (p *MongoDBProvider) setFirstUploadTimestamp(username string) error {
+	return p.setFirstTransferTimestamp(username, "first_upload", "upload")
+}
+
+func (p *MongoDBProvider) setFirstTransferTimestamp(username, field, operation string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.collection(mongoUsersCollection).UpdateOne(ctx, bson.M{
+			"_id":            username,
+			"deleted_at":     0,
+			"stats." + field: 0,
+		}, bson.M{"$set": bson.M{"stats." + field: util.GetTimeAsMsSinceEpoch(time.Now())}})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount > 0 {
+			return nil
+		}
+		var user User
+		found, err := p.bucket(ctx, mongoUsersCollection).get(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set %s timestamp",
+				username, operation))
+		}
+		timestamp := user.FirstDownload
+		if operation == "upload" {
+			timestamp = user.FirstUpload
+		}
+		return util.NewGenericError(fmt.Sprintf("first %s already set to %v", operation,
+			util.GetTimeFromMsecSinceEpoch(timestamp)))
+	})
+}
+
+func (p *MongoDBProvider) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMongoQueryTimeout)
+	defer cancel()
+
+	return p.client.Disconnect(ctx)
+}
+
+func (p *MongoDBProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase creates the collections and the indexes
+func (p *MongoDBProvider) initializeDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version > 0 {
+		return ErrNoInitRequired
+	}
+	logger.InfoToConsole("creating initial database schema, version %d", mongoDatabaseVersion)
+	providerLog(logger.LevelInfo, "creating initial database schema, version %d", mongoDatabaseVersion)
+
+	ctx, cancel := context.WithTimeout(context.Background(), longMongoQueryTimeout)
+	defer cancel()
+
+	for _, name := range mongoCollections {
+		if err := p.dbHandle.CreateCollection(ctx, config.SQLTablesPrefix+name); err != nil {
+			var cmdErr mongo.CommandError
+			// NamespaceExists
+			if !errors.As(err, &cmdErr) || cmdErr.Code != 48 {
+				return fmt.Errorf("unable to create collection %q: %w", name, err)
+			}
+		}
+	}
+	for name, indexes := range getMongoIndexes() {
+		if _, err := p.collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("unable to create indexes for collection %q: %w", name, err)
+		}
+	}
+	return p.updateDatabaseVersion(mongoDatabaseVersion)
+}
+
+func (p *MongoDBProvider) migrateDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == mongoDatabaseVersion:
+		providerLog(logger.LevelDebug, "MongoDB database is up to date, current version: %d", version)
+		return ErrNoInitRequired
+	case version == 0:
+		err = errors.New("database schema version not found, please initialize the database")
+		providerLog(logger.LevelError, "%v", err)
+		logger.ErrorToConsole("%v", err)
+		return err
+	default:
+		if version > mongoDatabaseVersion {
+			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
+				mongoDatabaseVersion)
+			logger.WarnToConsole("database schema version %d is newer than the supported one: %d", version,
+				mongoDatabaseVersion)
+			return nil
+		}
+		return fmt.Errorf("database schema version not handled: %d", version)
+	}
+}
+
+func (p *MongoDBProvider) revertDatabase(targetVersion int) error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
+}
+
+func (p *MongoDBProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), longMongoQueryTimeout)
+	defer cancel()
+
+	for _, name := range mongoCollections {
+		if err := p.collection(name).Drop(ctx); err != nil {
+			return fmt.Errorf("unable to remove collection %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) getDatabaseVersion() (schemaVersion, error) {
+	var dbVersion schemaVersion
+	err := p.view(func(ctx context.Context) error {
+		var doc struct {
+			Version int `bson:"version"`
+		}
+		err := p.collection(mongoSchemaVersionCollection).FindOne(ctx, bson.M{"_id": mongoSchemaVersionKey}).Decode(&doc)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			return err
+		}
+		dbVersion.Version = doc.Version
+		return nil
+	})
+	return dbVersion, err
+}
+
+func (p *MongoDBProvider) updateDatabaseVersion(version int) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.collection(mongoSchemaVersionCollection).UpdateOne(ctx, bson.M{"_id": mongoSchemaVersionKey},
+			bson.M{"$set": bson.M{"version": version}}, options.Update().SetUpsert(true))
+		return err
+	})
+}
+
+func (p *MongoDBProvider) joinRuleAndActions(rule *EventRule, actionsBucket *mongoBucket) error {
+	var actions []EventAction
+	for idx := range rule.Actions {
+		action := &rule.Actions[idx]
+		var baseAction BaseEventAction
+		found, err := actionsBucket.get(action.Name, &baseAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		baseAction.Options.SetEmptySecretsIfNil()
+		action.BaseEventAction = baseAction
+		actions = append(actions, *action)
+	}
+	rule.Actions = actions
+	return nil
+}
+
+func (p *MongoDBProvider) joinGroupAndFolders(group *Group, foldersBucket *mongoBucket) error {
+	if len(group.VirtualFolders) > 0 {
+		var folders []vfs.VirtualFolder
+		for idx := range group.VirtualFolders {
+			folder := &group.VirtualFolders[idx]
+			baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+			if err != nil {
+				if errors.Is(err, util.ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			folder.BaseVirtualFolder = baseFolder
+			folders = append(folders, *folder)
+		}
+		group.VirtualFolders = folders
+	}
+	group.SetEmptySecretsIfNil()
+	return nil
+}
+
+func (p *MongoDBProvider) joinUserAndFolders(user *User, foldersBucket *mongoBucket) error {
+	if len(user.VirtualFolders) > 0 {
+		var folders []vfs.VirtualFolder
+		for idx := range user.VirtualFolders {
+			folder := &user.VirtualFolders[idx]
+			baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+			if err != nil {
+				if errors.Is(err, util.ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			folder.BaseVirtualFolder = baseFolder
+			folders = append(folders, *folder)
+		}
+		user.VirtualFolders = folders
+	}
+	user.SetEmptySecretsIfNil()
+	return nil
+}
+
+// joinUserAndGroups joins the user with its virtual folders and applies the groups settings
+func (p *MongoDBProvider) joinUserAndGroups(user *User, foldersBucket, groupsBucket *mongoBucket) error {
+	if err := p.joinUserAndFolders(user, foldersBucket); err != nil {
+		return err
+	}
+	if len(user.Groups) > 0 {
+		groupMapping := make(map[string]Group)
+		for idx := range user.Groups {
+			group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				if errors.Is(err, util.ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			groupMapping[group.Name] = group
+		}
+		addIncludedGroupsToMapping(groupMapping, func(name string) (Group, error) {
+			return p.groupExistsInternal(name, groupsBucket)
+		})
+		user.applyGroupSettings(groupMapping)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) groupExistsInternal(name string, bucket *mongoBucket) (Group, error) {
+	var group Group
+	found, err := bucket.get(name, &group)
+	if err != nil {
+		return group, err
+	}
+	if !found {
+		return group, util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+	}
+	return group, nil
+}
+
+func (p *MongoDBProvider) folderExistsInternal(name string, bucket *mongoBucket) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	found, err := bucket.get(name, &folder)
+	if err != nil {
+		return folder, err
+	}
+	if !found {
+		return folder, util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
+	}
+	return folder, nil
+}
+
+func (p *MongoDBProvider) addFolderInternal(ctx context.Context, folder vfs.BaseVirtualFolder, bucket *mongoBucket) error {
+	id, err := p.nextSequence(ctx, mongoFoldersCollection)
+	if err != nil {
+		return err
+	}
+	folder.ID = id
+	return bucket.insert(folder.Name, &folder)
+}
+
+func (p *MongoDBProvider) removeRoleFromUser(username, role string, bucket *mongoBucket) error {
+	var user User
+	found, err := bucket.get(username, &user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "user %q does not exist, cannot remove role %q", username, role)
+		return nil
+	}
+	if user.Role == role {
+		user.Role = ""
+		return bucket.put(user.Username, &user)
+	}
+	providerLog(logger.LevelError, "user %q does not have the expected role %q, actual %q", username, role, user.Role)
+	return nil
+}
+
+func (p *MongoDBProvider) addAdminToRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.get(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("role %q does not exist", roleName))
+	}
+	if !util.Contains(role.Admins, username) {
+		role.Admins = append(role.Admins, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeAdminFromRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.get(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove admin %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Admins, username) {
+		var admins []string
+		for _, admin := range role.Admins {
+			if admin != username {
+				admins = append(admins, admin)
+			}
+		}
+		role.Admins = util.RemoveDuplicates(admins, false)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addUserToRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.get(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("role %q does not exist", roleName))
+	}
+	if !util.Contains(role.Users, username) {
+		role.Users = append(role.Users, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeUserFromRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.get(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove user %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Users, username) {
+		var users []string
+		for _, user := range role.Users {
+			if user != username {
+				users = append(users, user)
+			}
+		}
+		role.Users = util.RemoveDuplicates(users, false)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addRuleToActionMapping(ruleName, actionName string, bucket *mongoBucket) error {
+	var action BaseEventAction
+	found, err := bucket.get(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("action %q does not exist", actionName))
+	}
+	if !util.Contains(action.Rules, ruleName) {
+		action.Rules = append(action.Rules, ruleName)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeRuleFromActionMapping(ruleName, actionName string, bucket *mongoBucket) error {
+	var action BaseEventAction
+	found, err := bucket.get(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "action %q does not exist, cannot remove from mapping", actionName)
+		return nil
+	}
+	if util.Contains(action.Rules, ruleName) {
+		var rules []string
+		for _, r := range action.Rules {
+			if r != ruleName {
+				rules = append(rules, r)
+			}
+		}
+		action.Rules = util.RemoveDuplicates(rules, false)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addUserToGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	group, err := p.groupExistsInternal(groupname, bucket)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(group.Users, username) {
+		group.Users = append(group.Users, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeUserFromGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	group, err := p.groupExistsInternal(groupname, bucket)
+	if err != nil {
+		return err
+	}
+	var users []string
+	for _, u := range group.Users {
+		if u != username {
+			users = append(users, u)
+		}
+	}
+	group.Users = util.RemoveDuplicates(users, false)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *MongoDBProvider) addAdminToGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	group, err := p.groupExistsInternal(groupname, bucket)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(group.Admins, username) {
+		group.Admins = append(group.Admins, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeAdminFromGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	group, err := p.groupExistsInternal(groupname, bucket)
+	if err != nil {
+		return err
+	}
+	var admins []string
+	for _, a := range group.Admins {
+		if a != username {
+			admins = append(admins, a)
+		}
+	}
+	group.Admins = util.RemoveDuplicates(admins, false)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *MongoDBProvider) removeGroupFromAdminMapping(groupName, adminName string, bucket *mongoBucket) error {
+	var admin Admin
+	found, err := bucket.get(adminName, &admin)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the admin does not exist so there is no associated group
+		return nil
+	}
+	var newGroups []AdminGroupMapping
+	for _, g := range admin.Groups {
+		if g.Name != groupName {
+			newGroups = append(newGroups, g)
+		}
+	}
+	admin.Groups = newGroups
+	return bucket.put(adminName, &admin)
+}
+
+func (p *MongoDBProvider) addRelationToFolderMapping(baseFolder *vfs.BaseVirtualFolder, user *User, group *Group,
+	bucket *mongoBucket,
+) error {
+	var oldFolder vfs.BaseVirtualFolder
+	found, err := bucket.get(baseFolder.Name, &oldFolder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// folder does not exists, try to create
+		baseFolder.LastQuotaUpdate = 0
+		baseFolder.UsedQuotaFiles = 0
+		baseFolder.UsedQuotaSize = 0
+		if user != nil {
+			baseFolder.Users = []string{user.Username}
+		}
+		if group != nil {
+			baseFolder.Groups = []string{group.Name}
+		}
+		return p.addFolderInternal(bucket.ctx, *baseFolder, bucket)
+	}
+	baseFolder.ID = oldFolder.ID
+	baseFolder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+	baseFolder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+	baseFolder.UsedQuotaSize = oldFolder.UsedQuotaSize
+	baseFolder.Users = oldFolder.Users
+	baseFolder.Groups = oldFolder.Groups
+	if user != nil && !util.Contains(baseFolder.Users, user.Username) {
+		baseFolder.Users = append(baseFolder.Users, user.Username)
+	}
+	if group != nil && !util.Contains(baseFolder.Groups, group.Name) {
+		baseFolder.Groups = append(baseFolder.Groups, group.Name)
+	}
+	return bucket.put(baseFolder.Name, baseFolder)
+}
+
+func (p *MongoDBProvider) removeRelationFromFolderMapping(folder vfs.VirtualFolder, username, groupname string,
+	bucket *mongoBucket,
+) error {
+	var baseFolder vfs.BaseVirtualFolder
+	found, err := bucket.get(folder.Name, &baseFolder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the folder does not exist so there is no associated user/group
+		return nil
+	}
+	if username == "" && groupname == "" {
+		return nil
+	}
+	if username != "" {
+		var newUserMapping []string
+		for _, u := range baseFolder.Users {
+			if u != username {
+				newUserMapping = append(newUserMapping, u)
+			}
+		}
+		baseFolder.Users = newUserMapping
+	}
+	if groupname != "" {
+		var newGroupMapping []string
+		for _, g := range baseFolder.Groups {
+			if g != groupname {
+				newGroupMapping = append(newGroupMapping, g)
+			}
+		}
+		baseFolder.Groups = newGroupMapping
+	}
+	return bucket.put(folder.Name, &baseFolder)
+}
+
+func (p *MongoDBProvider) updateUserRelations(ctx context.Context, user *User, oldUser User) error {
+	foldersBucket := p.bucket(ctx, mongoFoldersCollection)
+	groupsBucket := p.bucket(ctx, mongoGroupsCollection)
+	rolesBucket := p.bucket(ctx, mongoRolesCollection)
+	for idx := range oldUser.VirtualFolders {
+		err := p.removeRelationFromFolderMapping(oldUser.VirtualFolders[idx], oldUser.Username, "", foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	for idx := range oldUser.Groups {
+		err := p.removeUserFromGroupMapping(user.Username, oldUser.Groups[idx].Name, groupsBucket)
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
+		return err
+	}
+	for idx := range user.VirtualFolders {
+		err := p.addRelationToFolderMapping(&user.VirtualFolders[idx].BaseVirtualFolder, user, nil, foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	for idx := range user.Groups {
+		err := p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket)
+		if err != nil {
+			return err
+		}
+	}
+	return p.addUserToRole(user.Username, user.Role, rolesBucket)
+}
+
+func (p *MongoDBProvider) userExistsInternal(ctx context.Context, username string) error {
+	exists, err := p.bucket(ctx, mongoUsersCollection).exists(username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) checkAPIKeyRelations(ctx context.Context, apiKey *APIKey) error {
+	if apiKey.User != "" {
+		if err := p.userExistsInternal(ctx, apiKey.User); err != nil {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", apiKey.User))
+		}
+	}
+	if apiKey.Admin != "" {
+		exists, err := p.bucket(ctx, mongoAdminsCollection).exists(apiKey.Admin)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewValidationError(fmt.Sprintf("related admin %q does not exists", apiKey.Admin))
+		}
+	}
+	return nil
+}
+
+// findOne unmarshals the first object matching the filter into v.
+// It returns false if no object matches
+func (b *mongoBucket) findOne(filter bson.M, v any) (bool, error) {
+	var doc mongoDocument
+	if err := b.coll.FindOne(b.ctx, filter).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, doc.unmarshal(v)
+}
+
+// get unmarshals the object with the specified key into v, soft deleted objects are ignored
+func (b *mongoBucket) get(key string, v any) (bool, error) {
+	return b.findOne(bson.M{"_id": key, "deleted_at": 0}, v)
+}
+
+func (b *mongoBucket) exists(key string) (bool, error) {
+	count, err := b.coll.CountDocuments(b.ctx, bson.M{"_id": key, "deleted_at": 0})
+	return count > 0, err
+}
+
+// insert adds a new object, the stats, if any, are initialized from the object
+func (b *mongoBucket) insert(key string, v any) error {
+	fields, err := getMongoDocumentFields(v)
+	if err != nil {
+		return err
+	}
+	doc := bson.D{{Key: "_id", Value: key}, {Key: "deleted_at", Value: int64(0)}}
+	doc = append(doc, fields...)
+	if stats := getMongoStats(v); stats != nil {
+		doc = append(doc, bson.E{Key: "stats", Value: stats})
+	}
+	_, err = b.coll.InsertOne(b.ctx, doc)
+	return err
+}
+
+// put adds or updates an object, the stats of existing objects are preserved
+func (b *mongoBucket) put(key string, v any) error {
+	fields, err := getMongoDocumentFields(v)
+	if err != nil {
+		return err
+	}
+	onInsert := bson.D{{Key: "deleted_at", Value: int64(0)}}
+	if stats := getMongoStats(v); stats != nil {
+		onInsert = append(onInsert, bson.E{Key: "stats", Value: stats})
+	}
+	_, err = b.coll.UpdateOne(b.ctx, bson.M{"_id": key}, bson.D{
+		{Key: "$set", Value: fields},
+		{Key: "$setOnInsert", Value: onInsert},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// replace updates an existing object, including its stats
+func (b *mongoBucket) replace(key string, v any) error {
+	fields, err := getMongoDocumentFields(v)
+	if err != nil {
+		return err
+	}
+	if stats := getMongoStats(v); stats != nil {
+		fields = append(fields, bson.E{Key: "stats", Value: stats})
+	}
+	_, err = b.coll.UpdateOne(b.ctx, bson.M{"_id": key}, bson.D{{Key: "$set", Value: fields}})
+	return err
+}
+
+// updateStats applies the specified update to the stats of an existing object
+func (b *mongoBucket) updateStats(key string, update bson.M, notFoundMessage string) error {
+	res, err := b.coll.UpdateOne(b.ctx, bson.M{"_id": key, "deleted_at": 0}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return util.NewRecordNotFoundError(notFoundMessage)
+	}
+	return nil
+}
+
+func (b *mongoBucket) delete(key string) error {
+	res, err := b.coll.DeleteOne(b.ctx, bson.M{"_id": key})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("%q does not exist", key))
+	}
+	return nil
+}
+
+// softDelete marks the object as deleted, it will be removed later. This way
+// the other nodes sharing the same database can update their caches
+func (b *mongoBucket) softDelete(key string) error {
+	ts := util.GetTimeAsMsSinceEpoch(time.Now())
+	res, err := b.coll.UpdateOne(b.ctx, bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"deleted_at": ts, "updated_at": ts},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("%q does not exist", key))
+	}
+	return nil
+}
+
+func (b *mongoBucket) removeSoftDeleted(key string) error {
+	if config.IsShared != 1 {
+		return nil
+	}
+	_, err := b.coll.DeleteOne(b.ctx, bson.M{"_id": key, "deleted_at": bson.M{"$gt": 0}})
+	return err
+}
+
+// iterate calls fn for each document matching the filter, sorted by key
+func (b *mongoBucket) iterate(filter bson.M, order string, offset, limit int, fn func(doc *mongoDocument) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: getMongoSortOrder(order)}})
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := b.coll.Find(b.ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(b.ctx)
+
+	for cursor.Next(b.ctx) {
+		var doc mongoDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (d *mongoDocument) unmarshal(v any) error {
+	if err := json.Unmarshal([]byte(d.Data), v); err != nil {
+		return err
+	}
+	switch obj := v.(type) {
+	case *User:
+		obj.DeletedAt = d.DeletedAt
+		if d.Stats != nil {
+			obj.UsedQuotaSize = d.Stats.UsedQuotaSize
+			obj.UsedQuotaFiles = d.Stats.UsedQuotaFiles
+			obj.UsedUploadDataTransfer = d.Stats.UsedUploadDataTransfer
+			obj.UsedDownloadDataTransfer = d.Stats.UsedDownloadDataTransfer
+			obj.LastQuotaUpdate = d.Stats.LastQuotaUpdate
+			obj.LastLogin = d.Stats.LastLogin
+			obj.FirstDownload = d.Stats.FirstDownload
+			obj.FirstUpload = d.Stats.FirstUpload
+		}
+	case *vfs.BaseVirtualFolder:
+		if d.Stats != nil {
+			obj.UsedQuotaSize = d.Stats.UsedQuotaSize
+			obj.UsedQuotaFiles = d.Stats.UsedQuotaFiles
+			obj.LastQuotaUpdate = d.Stats.LastQuotaUpdate
+		}
+	case *Admin:
+		if d.Stats != nil {
+			obj.LastLogin = d.Stats.LastLogin
+		}
+	case *APIKey:
+		if d.Stats != nil {
+			obj.LastUseAt = d.Stats.LastUseAt
+		}
+	case *Share:
+		if d.Stats != nil {
+			obj.UsedTokens = d.Stats.UsedTokens
+			obj.LastUseAt = d.Stats.LastUseAt
+		}
+	case *EventRule:
+		obj.DeletedAt = d.DeletedAt
+	case *IPListEntry:
+		obj.DeletedAt = d.DeletedAt
+	}
+	return nil
+}
+
+// getMongoDocumentFields returns the JSON serialized object and the fields used for searching
+func getMongoDocumentFields(v any) (bson.D, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := bson.D{{Key: "data", Value: string(buf)}}
+	switch obj := v.(type) {
+	case *User:
+		accessKeys := make([]string, 0, len(obj.Filters.S3AccessKeys))
+		for _, key := range obj.Filters.S3AccessKeys {
+			accessKeys = append(accessKeys, key.AccessKeyID)
+		}
+		fields = append(fields, bson.E{Key: "role", Value: obj.Role}, bson.E{Key: "updated_at", Value: obj.UpdatedAt},
+			bson.E{Key: "s3_access_keys", Value: accessKeys})
+	case *APIKey:
+		fields = append(fields, bson.E{Key: "user", Value: obj.User}, bson.E{Key: "admin", Value: obj.Admin})
+	case *Share:
+		fields = append(fields, bson.E{Key: "username", Value: obj.Username})
+	case *EventRule:
+		fields = append(fields, bson.E{Key: "updated_at", Value: obj.UpdatedAt})
+	case *IPListEntry:
+		fields = append(fields, bson.E{Key: "type", Value: obj.Type}, bson.E{Key: "ipornet", Value: obj.IPOrNet},
+			bson.E{Key: "ip_type", Value: obj.IPType}, bson.E{Key: "first", Value: obj.First},
+			bson.E{Key: "last", Value: obj.Last}, bson.E{Key: "updated_at", Value: obj.UpdatedAt})
+	}
+	return fields, nil
+}
+
+func getMongoStats(v any) *mongoStats {
+	switch obj := v.(type) {
+	case *User:
+		return &mongoStats{
+			UsedQuotaSize:            obj.UsedQuotaSize,
+			UsedQuotaFiles:           obj.UsedQuotaFiles,
+			UsedUploadDataTransfer:   obj.UsedUploadDataTransfer,
+			UsedDownloadDataTransfer: obj.UsedDownloadDataTransfer,
+			LastQuotaUpdate:          obj.LastQuotaUpdate,
+			LastLogin:                obj.LastLogin,
+			FirstDownload:            obj.FirstDownload,
+			FirstUpload:              obj.FirstUpload,
+		}
+	case *vfs.BaseVirtualFolder:
+		return &mongoStats{
+			UsedQuotaSize:   obj.UsedQuotaSize,
+			UsedQuotaFiles:  obj.UsedQuotaFiles,
+			LastQuotaUpdate: obj.LastQuotaUpdate,
+		}
+	case *Admin:
+		return &mongoStats{
+			LastLogin: obj.LastLogin,
+		}
+	case *APIKey:
+		return &mongoStats{
+			LastUseAt: obj.LastUseAt,
+		}
+	case *Share:
+		return &mongoStats{
+			UsedTokens: obj.UsedTokens,
+			LastUseAt:  obj.LastUseAt,
+		}
+	default:
+		return nil
+	}
+}
+
+func getMongoSortOrder(order string) int {
+	if order == OrderASC {
+		return 1
+	}
+	return -1
+}
+
+func getMongoAuditLogsFilter(search *AuditLogSearch) bson.M {
+	filter := bson.M{}
+	timestamp := bson.M{}
+	if search.StartTimestamp > 0 {
+		timestamp["$gte"] = search.StartTimestamp
+	}
+	if search.EndTimestamp > 0 {
+		timestamp["$lte"] = search.EndTimestamp
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	if len(search.Actions) > 0 {
+		filter["action"] = bson.M{"$in": search.Actions}
+	}
+	if len(search.ObjectTypes) > 0 {
+		filter["object_type"] = bson.M{"$in": search.ObjectTypes}
+	}
+	if search.ObjectName != "" {
+		filter["object_name"] = search.ObjectName
+	}
+	if search.Username != "" {
+		filter["username"] = search.Username
+	}
+	if search.IP != "" {
+		filter["ip"] = search.IP
+	}
+	if search.Role != "" {
+		filter["role"] = search.Role
+	}
+	return filter
+}
+
+func getMongoIndexes() map[string][]mongo.IndexModel {
+	index := func(keys ...string) mongo.IndexModel {
+		d := bson.D{}
+		for _, k := range keys {
+			d = append(d, bson.E{Key: k, Value: 1})
+		}
+		return mongo.IndexModel{Keys: d}
+	}
+	return map[string][]mongo.IndexModel{
+		mongoUsersCollection:           {index("deleted_at"), index("updated_at"), index("role"), index("s3_access_keys")},
+		mongoAPIKeysCollection:         {index("user"), index("admin")},
+		mongoSharesCollection:          {index("username")},
+		mongoFoldersQuotaCollection:    {index("username", "folder_name"), index("folder_name")},
+		mongoRulesCollection:           {index("deleted_at"), index("updated_at")},
+		mongoIPListsCollection:         {index("deleted_at"), index("updated_at"), index("type", "ipornet"), index("type", "ip_type", "first", "last")},
+		mongoAuditLogsCollection:       {index("timestamp"), index("action"), index("username")},
+		mongoChangeEventsCollection:    {index("timestamp")},
+		mongoDeletedObjectsCollection:  {index("deleted_at"), index("object_type")},
+		mongoDefenderHostsCollection:   {index("updated_at"), index("ban_time")},
+		mongoActiveTransfersCollection: {index("connection_id", "transfer_id"), index("updated_at")},
+		mongoSharedSessionsCollection:  {index("type", "timestamp")},
+		mongoNodesCollection:           {index("updated_at")},
+	}
+}
+
+func (h *mongoDefenderHost) toDefenderEntry(from int64) (DefenderEntry, bool) {
+	entry := DefenderEntry{
+		IP: h.IP,
+	}
+	if h.BanTime > 0 {
+		banTime := util.GetTimeFromMsecSinceEpoch(h.BanTime)
+		if banTime.After(time.Now()) {
+			entry.BanTime = banTime
+			return entry, true
+		}
+	}
+	for _, event := range h.Events {
+		if event.DateTime >= from {
+			entry.Score += event.Score
+		}
+	}
+	return entry, entry.Score > 0
+}
+
+func (s *mongoSession) toSession() Session {
+	return Session{
+		Key:       s.Key,
+		Data:      []byte(s.Data),
+		Type:      s.Type,
+		Timestamp: s.Timestamp,
+	}
+}
+
+func (n *mongoNode) toNode() (Node, error) {
+	node := Node{
+		Name:      n.Name,
+		CreatedAt: n.CreatedAt,
+		UpdatedAt: n.UpdatedAt,
+	}
+	err := json.Unmarshal([]byte(n.Data), &node.Data)
+	return node, err
+}
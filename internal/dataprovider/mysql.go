@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +39,7 @@ import (
 
 const (
 	mysqlResetSQL = "DROP TABLE IF EXISTS `{{api_keys}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{users_folders_quota}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{folders_mapping}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{users_folders_mapping}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{users_groups_mapping}}` CASCADE;" +
@@ -60,6 +62,11 @@ const (
 		"DROP TABLE IF EXISTS `{{roles}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{ip_lists}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{configs}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{audit_logs}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{change_events}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{deleted_objects}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{templates}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{tenants}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
 	mysqlInitialSQL = "CREATE TABLE `{{schema_version}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);" +
 		"CREATE TABLE `{{admins}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `username` varchar(255) NOT NULL UNIQUE, " +
@@ -206,11 +213,66 @@ const (
 	mysqlV28SQL     = "CREATE TABLE `{{configs}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `configs` longtext NOT NULL);" +
 		"INSERT INTO {{configs}} (configs) VALUES ('{}');"
 	mysqlV28DownSQL = "DROP TABLE `{{configs}}` CASCADE;"
+	mysqlV29SQL     = "ALTER TABLE `{{api_keys}}` ADD COLUMN `filters` longtext NULL;"
+	mysqlV29DownSQL = "ALTER TABLE `{{api_keys}}` DROP COLUMN `filters`;"
+	mysqlV30SQL     = "CREATE TABLE `{{audit_logs}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `timestamp` bigint NOT NULL, " +
+		"`action` varchar(50) NOT NULL, `object_type` varchar(50) NULL, `object_name` varchar(255) NULL, " +
+		"`username` varchar(255) NULL, `role` varchar(255) NULL, `ip` varchar(50) NULL, `protocol` varchar(30) NULL, " +
+		"`api_key_id` varchar(50) NULL, `changes` longtext NULL, `info` longtext NULL);" +
+		"CREATE INDEX `{{prefix}}audit_logs_timestamp_idx` ON `{{audit_logs}}` (`timestamp`);" +
+		"CREATE INDEX `{{prefix}}audit_logs_action_idx` ON `{{audit_logs}}` (`action`);" +
+		"CREATE INDEX `{{prefix}}audit_logs_username_idx` ON `{{audit_logs}}` (`username`);"
+	mysqlV30DownSQL = "DROP TABLE `{{audit_logs}}` CASCADE;"
+	mysqlV31SQL     = "CREATE TABLE `{{change_events}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `timestamp` bigint NOT NULL, " +
+		"`action` varchar(50) NOT NULL, `object_type` varchar(50) NOT NULL, `object_name` varchar(255) NOT NULL, " +
+		"`object` longtext NULL);" +
+		"CREATE INDEX `{{prefix}}change_events_timestamp_idx` ON `{{change_events}}` (`timestamp`);"
+	mysqlV31DownSQL = "DROP TABLE `{{change_events}}` CASCADE;"
+	mysqlV32SQL     = "CREATE TABLE `{{deleted_objects}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`object_type` varchar(50) NOT NULL, `object_name` varchar(255) NOT NULL, `deleted_at` bigint NOT NULL, " +
+		"`data` longtext NOT NULL);" +
+		"CREATE INDEX `{{prefix}}deleted_objects_deleted_at_idx` ON `{{deleted_objects}}` (`deleted_at`);"
+	mysqlV32DownSQL = "DROP TABLE `{{deleted_objects}}` CASCADE;"
+	mysqlV33SQL     = "CREATE TABLE `{{templates}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(512) NULL, `type` varchar(50) NOT NULL, " +
+		"`extends` varchar(255) NULL, `version` integer NOT NULL, `settings` longtext NOT NULL, " +
+		"`created_at` bigint NOT NULL, `updated_at` bigint NOT NULL);"
+	mysqlV33DownSQL = "DROP TABLE `{{templates}}` CASCADE;"
+	mysqlV34SQL     = "ALTER TABLE `{{groups}}` ADD COLUMN `included_groups` longtext NULL;"
+	mysqlV34DownSQL = "ALTER TABLE `{{groups}}` DROP COLUMN `included_groups`;"
+	mysqlV35SQL     = "ALTER TABLE `{{users_folders_mapping}}` ADD COLUMN `user_quota_size` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{users_folders_mapping}}` ALTER COLUMN `user_quota_size` DROP DEFAULT;" +
+		"ALTER TABLE `{{users_folders_mapping}}` ADD COLUMN `user_quota_files` integer DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{users_folders_mapping}}` ALTER COLUMN `user_quota_files` DROP DEFAULT;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` ADD COLUMN `user_quota_size` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` ALTER COLUMN `user_quota_size` DROP DEFAULT;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` ADD COLUMN `user_quota_files` integer DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` ALTER COLUMN `user_quota_files` DROP DEFAULT;" +
+		"CREATE TABLE `{{users_folders_quota}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`user_id` integer NOT NULL, `folder_id` integer NOT NULL, `used_quota_size` bigint NOT NULL, " +
+		"`used_quota_files` integer NOT NULL, `last_quota_update` bigint NOT NULL);" +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}unique_user_folder_quota` " +
+		"UNIQUE (`user_id`, `folder_id`);" +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}users_folders_quota_user_id_fk_users_id` " +
+		"FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;" +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}users_folders_quota_folder_id_fk_folders_id` " +
+		"FOREIGN KEY (`folder_id`) REFERENCES `{{folders}}` (`id`) ON DELETE CASCADE;"
+	mysqlV35DownSQL = "DROP TABLE `{{users_folders_quota}}` CASCADE;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` DROP COLUMN `user_quota_files`;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` DROP COLUMN `user_quota_size`;" +
+		"ALTER TABLE `{{users_folders_mapping}}` DROP COLUMN `user_quota_files`;" +
+		"ALTER TABLE `{{users_folders_mapping}}` DROP COLUMN `user_quota_size`;"
+	mysqlV36SQL = "CREATE TABLE `{{tenants}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(512) NULL, `members` longtext NULL, " +
+		"`quota_size` bigint NOT NULL, `quota_files` integer NOT NULL, `max_sessions` integer NOT NULL, " +
+		"`branding` longtext NULL, `created_at` bigint NOT NULL, `updated_at` bigint NOT NULL);"
+	mysqlV36DownSQL = "DROP TABLE `{{tenants}}` CASCADE;"
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReplicas
 }
 
 func init() {
@@ -240,7 +302,12 @@ func initializeMySQLProvider() error {
 		}
 		dbHandle.SetConnMaxLifetime(240 * time.Second)
 		dbHandle.SetConnMaxIdleTime(120 * time.Second)
-		provider = &MySQLProvider{dbHandle: dbHandle}
+		replicas, errReplicas := newSQLReplicas("mysql", getMySQLReplicaLag)
+		if errReplicas != nil {
+			dbHandle.Close() //nolint:errcheck
+			return errReplicas
+		}
+		provider = &MySQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelError, "error creating mysql database handler, connection string: %q, error: %v",
 			redactedConnString, err)
@@ -307,20 +374,77 @@ func registerMySQLCustomTLSConfig() error {
 	return nil
 }
 
+func getMySQLReplicaLag(ctx context.Context, dbHandle *sql.DB) (int64, error) {
+	// MySQL 8.0.22+ and MariaDB 10.5.1+ support SHOW REPLICA STATUS, the
+	// returned columns differ between versions and vendors
+	rows, err := dbHandle.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		// not configured as replica, for example a multi-primary cluster node
+		return 0, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for idx, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[idx].Valid {
+			return 0, errors.New("replication is not running")
+		}
+		return strconv.ParseInt(values[idx].String, 10, 64)
+	}
+	return 0, errors.New("unable to find the replication lag column")
+}
+
 func (p *MySQLProvider) checkAvailability() error {
+	p.replicas.check()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
 func (p *MySQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	var result User
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateUserAndPass(username, password, ip, protocol, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	var result User
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	var result User
+	var fingerprint string
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, fingerprint, err = sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, dbHandle)
+		return err
+	})
+	return result, fingerprint, err
 }
 
 func (p *MySQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -363,6 +487,10 @@ func (p *MySQLProvider) deleteUser(user User, softDelete bool) error {
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
+func (p *MySQLProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	return sqlCommonGetUsernameWithS3AccessKey(accessKeyID, p.dbHandle)
+}
+
 func (p *MySQLProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
@@ -376,7 +504,7 @@ func (p *MySQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 }
 
 func (p *MySQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+	return sqlCommonGetUsers(limit, offset, order, role, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -388,7 +516,7 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetFolders(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -417,8 +545,16 @@ func (p *MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *MySQLProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	return sqlCommonGetUserFolderUsedQuota(username, folderName, p.dbHandle)
+}
+
 func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetGroups(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -466,7 +602,7 @@ func (p *MySQLProvider) deleteAdmin(admin Admin) error {
 }
 
 func (p *MySQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAdmins(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpAdmins() ([]Admin, error) {
@@ -474,7 +610,13 @@ func (p *MySQLProvider) dumpAdmins() ([]Admin, error) {
 }
 
 func (p *MySQLProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
-	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
+	var result Admin
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateAdminAndPass(username, password, ip, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *MySQLProvider) apiKeyExists(keyID string) (APIKey, error) {
@@ -494,7 +636,7 @@ func (p *MySQLProvider) deleteAPIKey(apiKey APIKey) error {
 }
 
 func (p *MySQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAPIKeys(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -505,6 +647,10 @@ func (p *MySQLProvider) updateAPIKeyLastUse(keyID string) error {
 	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
 }
 
+func (p *MySQLProvider) rotateAPIKey(keyID, key string) error {
+	return sqlCommonRotateAPIKey(keyID, key, p.dbHandle)
+}
+
 func (p *MySQLProvider) shareExists(shareID, username string) (Share, error) {
 	return sqlCommonGetShareByID(shareID, username, p.dbHandle)
 }
@@ -522,7 +668,7 @@ func (p *MySQLProvider) deleteShare(share Share) error {
 }
 
 func (p *MySQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlCommonGetShares(limit, offset, order, username, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpShares() ([]Share, error) {
@@ -597,12 +743,16 @@ func (p *MySQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *MySQLProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *MySQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetEventActions(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -626,7 +776,7 @@ func (p *MySQLProvider) deleteEventAction(action BaseEventAction) error {
 }
 
 func (p *MySQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+	return sqlCommonGetEventRules(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -706,7 +856,55 @@ func (p *MySQLProvider) deleteRole(role Role) error {
 }
 
 func (p *MySQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetRoles(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *MySQLProvider) templateExists(name string) (Template, error) {
+	return sqlCommonGetTemplateByName(name, p.dbHandle)
+}
+
+func (p *MySQLProvider) addTemplate(template *Template) error {
+	return sqlCommonAddTemplate(template, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateTemplate(template *Template) error {
+	return sqlCommonUpdateTemplate(template, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteTemplate(template Template) error {
+	return sqlCommonDeleteTemplate(template, p.dbHandle)
+}
+
+func (p *MySQLProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	return sqlCommonGetTemplates(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *MySQLProvider) dumpTemplates() ([]Template, error) {
+	return sqlCommonDumpTemplates(p.dbHandle)
+}
+
+func (p *MySQLProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *MySQLProvider) addTenant(tenant *Tenant) error {
+	return sqlCommonAddTenant(tenant, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *MySQLProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *MySQLProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
 }
 
 func (p *MySQLProvider) dumpRoles() ([]Role, error) {
@@ -730,7 +928,7 @@ func (p *MySQLProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) er
 }
 
 func (p *MySQLProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
-	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.dbHandle)
+	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
@@ -757,6 +955,46 @@ func (p *MySQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *MySQLProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogs(search, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupAuditLogs(before int64) error {
+	return sqlCommonCleanupAuditLogs(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) addChangeEvent(event *ChangeEvent) error {
+	return sqlCommonAddChangeEvent(event, p.dbHandle)
+}
+
+func (p *MySQLProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	return sqlCommonGetChangeEvents(after, limit, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupChangeEvents(before int64) error {
+	return sqlCommonCleanupChangeEvents(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) addDeletedObject(object *DeletedObject) error {
+	return sqlCommonAddDeletedObject(object, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	return sqlCommonGetDeletedObjects(objectType, before, limit, offset, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	return sqlCommonGetDeletedObject(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) removeDeletedObject(id int64) error {
+	return sqlCommonRemoveDeletedObject(id, p.dbHandle)
+}
+
 func (p *MySQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -766,6 +1004,7 @@ func (p *MySQLProvider) setFirstUploadTimestamp(username string) error {
 }
 
 func (p *MySQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -814,6 +1053,22 @@ func (p *MySQLProvider) migrateDatabase() error { //nolint:dupl
 		return updateMySQLDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updateMySQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateMySQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateMySQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateMySQLDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updateMySQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateMySQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateMySQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateMySQLDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updateMySQLDatabaseFromV35(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -846,6 +1101,22 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 		return downgradeMySQLDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradeMySQLDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradeMySQLDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradeMySQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeMySQLDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradeMySQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeMySQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeMySQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeMySQLDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradeMySQLDatabaseFromV36(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -885,7 +1156,63 @@ func updateMySQLDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updateMySQLDatabaseFromV27(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom27To28(dbHandle)
+	if err := updateMySQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV28(dbHandle)
+}
+
+func updateMySQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV29(dbHandle)
+}
+
+func updateMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV30(dbHandle)
+}
+
+func updateMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV31(dbHandle)
+}
+
+func updateMySQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV32(dbHandle)
+}
+
+func updateMySQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV33(dbHandle)
+}
+
+func updateMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV34(dbHandle)
+}
+
+func updateMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV35(dbHandle)
+}
+
+func updateMySQLDatabaseFromV35(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom35To36(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV24(dbHandle *sql.DB) error {
@@ -920,6 +1247,62 @@ func downgradeMySQLDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFromV27(dbHandle)
 }
 
+func downgradeMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV28(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV29(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV30(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV31(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV32(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV33(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV34(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV35(dbHandle)
+}
+
 func updateMySQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -959,6 +1342,65 @@ func updateMySQLDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, true)
 }
 
+func updateMySQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(mysqlV29SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, true)
+}
+
+func updateMySQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(mysqlV30SQL, "{{audit_logs}}", sqlTableAuditLogs)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, true)
+}
+
+func updateMySQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(mysqlV31SQL, "{{change_events}}", sqlTableChangeEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 31, true)
+}
+
+func updateMySQLDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(mysqlV32SQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 32, true)
+}
+
+func updateMySQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := strings.ReplaceAll(mysqlV33SQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, true)
+}
+
+func updateMySQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := strings.ReplaceAll(mysqlV34SQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 34, true)
+}
+
+func updateMySQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(mysqlV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 35, true)
+}
+
+func updateMySQLDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+	sql := sqlReplaceAll(mysqlV36SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 36, true)
+}
+
 func downgradeMySQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -996,3 +1438,59 @@ func downgradeMySQLDatabaseFrom28To27(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(mysqlV28DownSQL, "{{configs}}", sqlTableConfigs)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 27, false)
 }
+
+func downgradeMySQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(mysqlV29DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, false)
+}
+
+func downgradeMySQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(mysqlV30DownSQL, "{{audit_logs}}", sqlTableAuditLogs)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradeMySQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(mysqlV31DownSQL, "{{change_events}}", sqlTableChangeEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+func downgradeMySQLDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(mysqlV32DownSQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, false)
+}
+
+func downgradeMySQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := strings.ReplaceAll(mysqlV33DownSQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func downgradeMySQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := strings.ReplaceAll(mysqlV34DownSQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, false)
+}
+
+func downgradeMySQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(mysqlV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 34, false)
+}
+
+func downgradeMySQLDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+	sql := sqlReplaceAll(mysqlV36DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 35, false)
+}
@@ -0,0 +1,266 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// OIDCMapping maps a value of the OpenID Connect groups claim to SFTPGo
+// groups and role
+type OIDCMapping struct {
+	// Value of the groups claim, matching is case sensitive
+	ClaimValue string `json:"claim_value"`
+	// SFTPGo groups to assign to the users
+	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// SFTPGo role to assign to users and admins
+	Role string `json:"role,omitempty"`
+}
+
+func (m *OIDCMapping) validate() error {
+	m.ClaimValue = strings.TrimSpace(m.ClaimValue)
+	if m.ClaimValue == "" {
+		return util.NewValidationError("oidc mapping: claim value is mandatory")
+	}
+	m.Role = strings.TrimSpace(m.Role)
+	if len(m.Groups) == 0 && m.Role == "" {
+		return util.NewValidationError(fmt.Sprintf("oidc mapping %q: at least a group or a role is required", m.ClaimValue))
+	}
+	hasPrimary := false
+	groupNames := make(map[string]bool)
+	for idx := range m.Groups {
+		g := &m.Groups[idx]
+		g.Name = strings.TrimSpace(g.Name)
+		if g.Name == "" {
+			return util.NewValidationError(fmt.Sprintf("oidc mapping %q: group name is mandatory", m.ClaimValue))
+		}
+		if g.Type < sdk.GroupTypePrimary || g.Type > sdk.GroupTypeMembership {
+			return util.NewValidationError(fmt.Sprintf("oidc mapping %q: invalid type %d for group %q",
+				m.ClaimValue, g.Type, g.Name))
+		}
+		if g.Type == sdk.GroupTypePrimary {
+			if hasPrimary {
+				return util.NewValidationError(fmt.Sprintf("oidc mapping %q: only one primary group is allowed", m.ClaimValue))
+			}
+			hasPrimary = true
+		}
+		if groupNames[g.Name] {
+			return util.NewValidationError(fmt.Sprintf("oidc mapping %q: the group %q is duplicated", m.ClaimValue, g.Name))
+		}
+		groupNames[g.Name] = true
+	}
+	return nil
+}
+
+func (m *OIDCMapping) getACopy() OIDCMapping {
+	groups := make([]sdk.GroupMapping, len(m.Groups))
+	copy(groups, m.Groups)
+	return OIDCMapping{
+		ClaimValue: m.ClaimValue,
+		Groups:     groups,
+		Role:       m.Role,
+	}
+}
+
+func validateOIDCMappings(mappings []OIDCMapping) error {
+	values := make(map[string]bool)
+	for idx := range mappings {
+		m := &mappings[idx]
+		if err := m.validate(); err != nil {
+			return err
+		}
+		if values[m.ClaimValue] {
+			return util.NewValidationError(fmt.Sprintf("oidc mapping %q is duplicated", m.ClaimValue))
+		}
+		values[m.ClaimValue] = true
+	}
+	return nil
+}
+
+// GetOIDCMappings returns the OpenID Connect mappings table
+func GetOIDCMappings() ([]OIDCMapping, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return nil, err
+	}
+	mappings := make([]OIDCMapping, 0, len(configs.OIDCMappings))
+	for idx := range configs.OIDCMappings {
+		mappings = append(mappings, configs.OIDCMappings[idx].getACopy())
+	}
+	return mappings, nil
+}
+
+// UpdateOIDCMappings replaces the OpenID Connect mappings table
+func UpdateOIDCMappings(mappings []OIDCMapping, executor, ipAddress, role string) error {
+	if err := validateOIDCMappings(mappings); err != nil {
+		return err
+	}
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	configs.OIDCMappings = mappings
+	return UpdateConfigs(&configs, executor, ipAddress, role)
+}
+
+// resolveOIDCMappings returns the groups and the role mapped to the given claim
+// values. Mappings are evaluated in table order: the first primary group and
+// the first role found win, any other primary group is added as secondary.
+// The returned bool is false if no mapping matches
+func resolveOIDCMappings(claimValues []string) ([]sdk.GroupMapping, string, bool, error) {
+	if len(claimValues) == 0 {
+		return nil, "", false, nil
+	}
+	mappings, err := GetOIDCMappings()
+	if err != nil {
+		return nil, "", false, err
+	}
+	var groups []sdk.GroupMapping
+	var role string
+	matched := false
+	hasPrimary := false
+	groupNames := make(map[string]bool)
+
+	for _, m := range mappings {
+		if !util.Contains(claimValues, m.ClaimValue) {
+			continue
+		}
+		matched = true
+		if role == "" {
+			role = m.Role
+		}
+		for _, g := range m.Groups {
+			if groupNames[g.Name] {
+				continue
+			}
+			groupNames[g.Name] = true
+			if g.Type == sdk.GroupTypePrimary {
+				if hasPrimary {
+					g.Type = sdk.GroupTypeSecondary
+				}
+				hasPrimary = true
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, role, matched, nil
+}
+
+func areGroupMappingsEqual(g1, g2 []sdk.GroupMapping) bool {
+	if len(g1) != len(g2) {
+		return false
+	}
+	for _, g := range g1 {
+		found := false
+		for _, other := range g2 {
+			if g.Name == other.Name && g.Type == other.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncOIDCUser synchronizes the groups and the role of the user with the given
+// username based on the values of the OpenID Connect groups claim and the
+// mappings table. Missing users are created if at least a mapping matches,
+// their home directory is built using the users base dir.
+// If requireMapping is true, users not matching any mapping are denied
+func SyncOIDCUser(username string, claimValues []string, requireMapping bool) error {
+	groups, role, matched, err := resolveOIDCMappings(claimValues)
+	if err != nil {
+		return err
+	}
+	if !matched && requireMapping {
+		providerLog(logger.LevelDebug, "no oidc mapping matches user %q, claim values: %+v", username, claimValues)
+		return fmt.Errorf("user %q does not match any oidc mapping", username)
+	}
+	user, err := provider.userExists(username, "")
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if !matched {
+			// the user could still be created by the pre-login hook
+			return nil
+		}
+		user = User{
+			BaseUser: sdk.BaseUser{
+				Username: username,
+				Status:   1,
+				Permissions: map[string][]string{
+					"/": {PermAny},
+				},
+				Role: role,
+			},
+			Groups: groups,
+		}
+		if err := provider.addUser(&user); err != nil {
+			return fmt.Errorf("unable to create user %q from oidc mappings: %w", username, err)
+		}
+		providerLog(logger.LevelInfo, "user %q created from oidc mappings, groups: %+v, role: %q",
+			username, groups, role)
+		return nil
+	}
+	if user.Role == role && areGroupMappingsEqual(user.Groups, groups) {
+		return nil
+	}
+	user.Groups = groups
+	user.Role = role
+	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	if err := provider.updateUser(&user); err != nil {
+		return fmt.Errorf("unable to update user %q from oidc mappings: %w", username, err)
+	}
+	webDAVUsersCache.swap(&user)
+	providerLog(logger.LevelInfo, "user %q updated from oidc mappings, groups: %+v, role: %q",
+		username, groups, role)
+	return nil
+}
+
+// SyncOIDCAdmin synchronizes the role of the given admin based on the values of
+// the OpenID Connect groups claim and the mappings table.
+// If requireMapping is true, admins not matching any mapping are denied
+func SyncOIDCAdmin(admin *Admin, claimValues []string, requireMapping bool) error {
+	_, role, matched, err := resolveOIDCMappings(claimValues)
+	if err != nil {
+		return err
+	}
+	if !matched && requireMapping {
+		providerLog(logger.LevelDebug, "no oidc mapping matches admin %q, claim values: %+v", admin.Username, claimValues)
+		return fmt.Errorf("admin %q does not match any oidc mapping", admin.Username)
+	}
+	if admin.Role == role {
+		return nil
+	}
+	admin.Role = role
+	admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	if err := provider.updateAdmin(admin); err != nil {
+		return fmt.Errorf("unable to update admin %q from oidc mappings: %w", admin.Username, err)
+	}
+	providerLog(logger.LevelInfo, "admin %q updated from oidc mappings, role: %q", admin.Username, role)
+	return nil
+}
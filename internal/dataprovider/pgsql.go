@@ -36,6 +36,7 @@ import (
 
 const (
 	pgsqlResetSQL = `DROP TABLE IF EXISTS "{{api_keys}}" CASCADE;
+DROP TABLE IF EXISTS "{{users_folders_quota}}" CASCADE;
 DROP TABLE IF EXISTS "{{folders_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{users_folders_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{users_groups_mapping}}" CASCADE;
@@ -58,6 +59,11 @@ DROP TABLE IF EXISTS "{{nodes}}" CASCADE;
 DROP TABLE IF EXISTS "{{roles}}" CASCADE;
 DROP TABLE IF EXISTS "{{ip_lists}}" CASCADE;
 DROP TABLE IF EXISTS "{{configs}}" CASCADE;
+DROP TABLE IF EXISTS "{{audit_logs}}" CASCADE;
+DROP TABLE IF EXISTS "{{change_events}}" CASCADE;
+DROP TABLE IF EXISTS "{{deleted_objects}}" CASCADE;
+DROP TABLE IF EXISTS "{{templates}}" CASCADE;
+DROP TABLE IF EXISTS "{{tenants}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
 `
 	pgsqlInitial = `CREATE TABLE "{{schema_version}}" ("id" serial NOT NULL PRIMARY KEY, "version" integer NOT NULL);
@@ -221,11 +227,77 @@ CREATE INDEX "{{prefix}}ip_lists_first_last_idx" ON "{{ip_lists}}" ("first", "la
 INSERT INTO {{configs}} (configs) VALUES ('{}');
 `
 	pgsqlV28DownSQL = `DROP TABLE "{{configs}}" CASCADE;`
+	pgsqlV29SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "filters" text NULL;`
+	pgsqlV29DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "filters" CASCADE;`
+	pgsqlV30SQL     = `CREATE TABLE "{{audit_logs}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"timestamp" bigint NOT NULL, "action" varchar(50) NOT NULL, "object_type" varchar(50) NULL,
+"object_name" varchar(255) NULL, "username" varchar(255) NULL, "role" varchar(255) NULL, "ip" varchar(50) NULL,
+"protocol" varchar(30) NULL, "api_key_id" varchar(50) NULL, "changes" text NULL, "info" text NULL);
+CREATE INDEX "{{prefix}}audit_logs_timestamp_idx" ON "{{audit_logs}}" ("timestamp");
+CREATE INDEX "{{prefix}}audit_logs_action_idx" ON "{{audit_logs}}" ("action");
+CREATE INDEX "{{prefix}}audit_logs_username_idx" ON "{{audit_logs}}" ("username");
+`
+	pgsqlV30DownSQL = `DROP TABLE "{{audit_logs}}" CASCADE;`
+	pgsqlV31SQL     = `CREATE TABLE "{{change_events}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"timestamp" bigint NOT NULL, "action" varchar(50) NOT NULL, "object_type" varchar(50) NOT NULL,
+"object_name" varchar(255) NOT NULL, "object" text NULL);
+CREATE INDEX "{{prefix}}change_events_timestamp_idx" ON "{{change_events}}" ("timestamp");
+`
+	pgsqlV31DownSQL = `DROP TABLE "{{change_events}}" CASCADE;`
+	pgsqlV32SQL     = `CREATE TABLE "{{deleted_objects}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL, "deleted_at" bigint NOT NULL,
+"data" text NOT NULL);
+CREATE INDEX "{{prefix}}deleted_objects_deleted_at_idx" ON "{{deleted_objects}}" ("deleted_at");
+`
+	pgsqlV32DownSQL = `DROP TABLE "{{deleted_objects}}" CASCADE;`
+	pgsqlV33SQL     = `CREATE TABLE "{{templates}}" ("id" serial NOT NULL PRIMARY KEY,
+"name" varchar(255) NOT NULL UNIQUE, "description" varchar(512) NULL, "type" varchar(50) NOT NULL,
+"extends" varchar(255) NULL, "version" integer NOT NULL, "settings" text NOT NULL, "created_at" bigint NOT NULL,
+"updated_at" bigint NOT NULL);
+`
+	pgsqlV33DownSQL = `DROP TABLE "{{templates}}" CASCADE;`
+	pgsqlV34SQL     = `ALTER TABLE "{{groups}}" ADD COLUMN "included_groups" text NULL;`
+	pgsqlV34DownSQL = `ALTER TABLE "{{groups}}" DROP COLUMN "included_groups" CASCADE;`
+	pgsqlV35SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ALTER COLUMN "user_quota_size" DROP DEFAULT;
+ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ALTER COLUMN "user_quota_files" DROP DEFAULT;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ALTER COLUMN "user_quota_size" DROP DEFAULT;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ALTER COLUMN "user_quota_files" DROP DEFAULT;
+CREATE TABLE "{{users_folders_quota}}" ("id" serial NOT NULL PRIMARY KEY, "user_id" integer NOT NULL,
+"folder_id" integer NOT NULL, "used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL,
+"last_quota_update" bigint NOT NULL);
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}unique_user_folder_quota" UNIQUE ("user_id", "folder_id");
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}users_folders_quota_folder_id_fk_folders_id"
+FOREIGN KEY ("folder_id") REFERENCES "{{folders}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}users_folders_quota_user_id_fk_users_id"
+FOREIGN KEY ("user_id") REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "{{prefix}}users_folders_quota_folder_id_idx" ON "{{users_folders_quota}}" ("folder_id");
+`
+	pgsqlV35DownSQL = `DROP TABLE "{{users_folders_quota}}" CASCADE;
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_files" CASCADE;
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_size" CASCADE;
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_files" CASCADE;
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_size" CASCADE;
+`
+	pgsqlV36SQL = `CREATE TABLE "{{tenants}}" ("id" serial NOT NULL PRIMARY KEY,
+"name" varchar(255) NOT NULL UNIQUE, "description" varchar(512) NULL, "members" text NULL, "quota_size" bigint NOT NULL,
+"quota_files" integer NOT NULL, "max_sessions" integer NOT NULL, "branding" text NULL, "created_at" bigint NOT NULL,
+"updated_at" bigint NOT NULL);
+`
+	pgsqlV36DownSQL = `DROP TABLE "{{tenants}}" CASCADE;`
+	// a replica that replayed all the received WAL is not lagging even if the
+	// last replayed transaction is old, the primary could be idle
+	pgsqlReplicaLagQuery = `SELECT CASE WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+THEN 0 ELSE CAST(COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS bigint) END`
 )
 
 // PGSQLProvider defines the auth provider for PostgreSQL database
 type PGSQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReplicas
 }
 
 func init() {
@@ -246,7 +318,12 @@ func initializePGSQLProvider() error {
 		}
 		dbHandle.SetConnMaxLifetime(240 * time.Second)
 		dbHandle.SetConnMaxIdleTime(120 * time.Second)
-		provider = &PGSQLProvider{dbHandle: dbHandle}
+		replicas, errReplicas := newSQLReplicas("pgx", getPGSQLReplicaLag)
+		if errReplicas != nil {
+			dbHandle.Close() //nolint:errcheck
+			return errReplicas
+		}
+		provider = &PGSQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelError, "error creating postgres database handler, connection string: %q, error: %v",
 			getPGSQLConnectionString(true), err)
@@ -281,20 +358,46 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 	return connectionString
 }
 
+func getPGSQLReplicaLag(ctx context.Context, dbHandle *sql.DB) (int64, error) {
+	var lag int64
+	err := dbHandle.QueryRowContext(ctx, pgsqlReplicaLagQuery).Scan(&lag)
+	return lag, err
+}
+
 func (p *PGSQLProvider) checkAvailability() error {
+	p.replicas.check()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
 func (p *PGSQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	var result User
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateUserAndPass(username, password, ip, protocol, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	var result User
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	var result User
+	var fingerprint string
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, fingerprint, err = sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, dbHandle)
+		return err
+	})
+	return result, fingerprint, err
 }
 
 func (p *PGSQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -337,6 +440,10 @@ func (p *PGSQLProvider) deleteUser(user User, softDelete bool) error {
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	return sqlCommonGetUsernameWithS3AccessKey(accessKeyID, p.dbHandle)
+}
+
 func (p *PGSQLProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
@@ -350,7 +457,7 @@ func (p *PGSQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 }
 
 func (p *PGSQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+	return sqlCommonGetUsers(limit, offset, order, role, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -362,7 +469,7 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetFolders(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -391,8 +498,16 @@ func (p *PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	return sqlCommonGetUserFolderUsedQuota(username, folderName, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetGroups(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -440,7 +555,7 @@ func (p *PGSQLProvider) deleteAdmin(admin Admin) error {
 }
 
 func (p *PGSQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAdmins(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpAdmins() ([]Admin, error) {
@@ -448,7 +563,13 @@ func (p *PGSQLProvider) dumpAdmins() ([]Admin, error) {
 }
 
 func (p *PGSQLProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
-	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
+	var result Admin
+	err := p.replicas.read(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		result, err = sqlCommonValidateAdminAndPass(username, password, ip, dbHandle)
+		return err
+	})
+	return result, err
 }
 
 func (p *PGSQLProvider) apiKeyExists(keyID string) (APIKey, error) {
@@ -468,7 +589,7 @@ func (p *PGSQLProvider) deleteAPIKey(apiKey APIKey) error {
 }
 
 func (p *PGSQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAPIKeys(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -479,6 +600,10 @@ func (p *PGSQLProvider) updateAPIKeyLastUse(keyID string) error {
 	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
 }
 
+func (p *PGSQLProvider) rotateAPIKey(keyID, key string) error {
+	return sqlCommonRotateAPIKey(keyID, key, p.dbHandle)
+}
+
 func (p *PGSQLProvider) shareExists(shareID, username string) (Share, error) {
 	return sqlCommonGetShareByID(shareID, username, p.dbHandle)
 }
@@ -496,7 +621,7 @@ func (p *PGSQLProvider) deleteShare(share Share) error {
 }
 
 func (p *PGSQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlCommonGetShares(limit, offset, order, username, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpShares() ([]Share, error) {
@@ -571,12 +696,16 @@ func (p *PGSQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetEventActions(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -600,7 +729,7 @@ func (p *PGSQLProvider) deleteEventAction(action BaseEventAction) error {
 }
 
 func (p *PGSQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+	return sqlCommonGetEventRules(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -680,7 +809,55 @@ func (p *PGSQLProvider) deleteRole(role Role) error {
 }
 
 func (p *PGSQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetRoles(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *PGSQLProvider) templateExists(name string) (Template, error) {
+	return sqlCommonGetTemplateByName(name, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addTemplate(template *Template) error {
+	return sqlCommonAddTemplate(template, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateTemplate(template *Template) error {
+	return sqlCommonUpdateTemplate(template, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteTemplate(template Template) error {
+	return sqlCommonDeleteTemplate(template, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	return sqlCommonGetTemplates(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *PGSQLProvider) dumpTemplates() ([]Template, error) {
+	return sqlCommonDumpTemplates(p.dbHandle)
+}
+
+func (p *PGSQLProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addTenant(tenant *Tenant) error {
+	return sqlCommonAddTenant(tenant, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *PGSQLProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
 }
 
 func (p *PGSQLProvider) dumpRoles() ([]Role, error) {
@@ -704,7 +881,7 @@ func (p *PGSQLProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) er
 }
 
 func (p *PGSQLProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
-	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.dbHandle)
+	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
@@ -731,6 +908,46 @@ func (p *PGSQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *PGSQLProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogs(search, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupAuditLogs(before int64) error {
+	return sqlCommonCleanupAuditLogs(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addChangeEvent(event *ChangeEvent) error {
+	return sqlCommonAddChangeEvent(event, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	return sqlCommonGetChangeEvents(after, limit, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupChangeEvents(before int64) error {
+	return sqlCommonCleanupChangeEvents(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addDeletedObject(object *DeletedObject) error {
+	return sqlCommonAddDeletedObject(object, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	return sqlCommonGetDeletedObjects(objectType, before, limit, offset, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	return sqlCommonGetDeletedObject(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) removeDeletedObject(id int64) error {
+	return sqlCommonRemoveDeletedObject(id, p.dbHandle)
+}
+
 func (p *PGSQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -740,6 +957,7 @@ func (p *PGSQLProvider) setFirstUploadTimestamp(username string) error {
 }
 
 func (p *PGSQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -788,6 +1006,22 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 		return updatePgSQLDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updatePgSQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updatePgSQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updatePgSQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updatePgSQLDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updatePgSQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updatePgSQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updatePgSQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updatePgSQLDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updatePgSQLDatabaseFromV35(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -820,6 +1054,22 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 		return downgradePgSQLDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradePgSQLDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradePgSQLDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradePgSQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradePgSQLDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradePgSQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradePgSQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradePgSQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradePgSQLDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradePgSQLDatabaseFromV36(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -859,7 +1109,63 @@ func updatePgSQLDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updatePgSQLDatabaseFromV27(dbHandle *sql.DB) error {
-	return updatePgSQLDatabaseFrom27To28(dbHandle)
+	if err := updatePgSQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV28(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV29(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV30(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV31(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV32(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV33(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV34(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV35(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV35(dbHandle *sql.DB) error {
+	return updatePgSQLDatabaseFrom35To36(dbHandle)
 }
 
 func downgradePgSQLDatabaseFromV24(dbHandle *sql.DB) error {
@@ -894,6 +1200,62 @@ func downgradePgSQLDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradePgSQLDatabaseFromV27(dbHandle)
 }
 
+func downgradePgSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV28(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV29(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV30(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV31(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV32(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV33(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV34(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV35(dbHandle)
+}
+
 func updatePgSQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -945,6 +1307,65 @@ func updatePgSQLDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
 }
 
+func updatePgSQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(pgsqlV29SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updatePgSQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(pgsqlV30SQL, "{{audit_logs}}", sqlTableAuditLogs)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updatePgSQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(pgsqlV31SQL, "{{change_events}}", sqlTableChangeEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
+func updatePgSQLDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(pgsqlV32SQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, true)
+}
+
+func updatePgSQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := strings.ReplaceAll(pgsqlV33SQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func updatePgSQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := strings.ReplaceAll(pgsqlV34SQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func updatePgSQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(pgsqlV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func updatePgSQLDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+	sql := sqlReplaceAll(pgsqlV36SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, true)
+}
+
 func downgradePgSQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -982,3 +1403,59 @@ func downgradePgSQLDatabaseFrom28To27(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(pgsqlV28DownSQL, "{{configs}}", sqlTableConfigs)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
 }
+
+func downgradePgSQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(pgsqlV29DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradePgSQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(pgsqlV30DownSQL, "{{audit_logs}}", sqlTableAuditLogs)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradePgSQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(pgsqlV31DownSQL, "{{change_events}}", sqlTableChangeEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+func downgradePgSQLDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(pgsqlV32DownSQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, false)
+}
+
+func downgradePgSQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := strings.ReplaceAll(pgsqlV33DownSQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func downgradePgSQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := strings.ReplaceAll(pgsqlV34DownSQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func downgradePgSQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(pgsqlV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
+
+func downgradePgSQLDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+	sql := sqlReplaceAll(pgsqlV36DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, false)
+}
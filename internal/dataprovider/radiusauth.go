@@ -0,0 +1,162 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func validateRADIUSServers() error {
+	servers := make([]radius.Config, 0, len(config.RADIUSServers))
+	var names []string
+	for _, s := range config.RADIUSServers {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if util.Contains(names, s.Name) {
+			return fmt.Errorf("radius: duplicated server name %q", s.Name)
+		}
+		names = append(names, s.Name)
+		servers = append(servers, s)
+	}
+	config.RADIUSServers = servers
+	return nil
+}
+
+// CheckRADIUSServer returns an error if the RADIUS server with the given
+// name is not defined. An empty name means no RADIUS server
+func CheckRADIUSServer(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := getRADIUSServer(name)
+	return err
+}
+
+func getRADIUSServer(name string) (*radius.Config, error) {
+	for idx := range config.RADIUSServers {
+		if config.RADIUSServers[idx].Name == name {
+			return &config.RADIUSServers[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("radius server %q is not defined", name)
+}
+
+// CheckRADIUSAuth authenticates the user with the given password using the
+// specified RADIUS server. Challenges are not supported
+func CheckRADIUSAuth(username, password, ip, protocol, server string) (User, error) {
+	username = config.convertName(username)
+	return doRADIUSAuth(username, password, ip, protocol, server, nil)
+}
+
+// CheckRADIUSKeyboardInteractiveAuth asks for the password using the keyboard
+// interactive client and authenticates the user using the specified RADIUS server.
+// The RADIUS challenges are forwarded to the client
+func CheckRADIUSKeyboardInteractiveAuth(username, ip, protocol, server string,
+	client ssh.KeyboardInteractiveChallenge,
+) (User, error) {
+	username = config.convertName(username)
+	answers, err := client("", "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return User{}, err
+	}
+	if len(answers) != 1 {
+		return User{}, fmt.Errorf("unexpected number of answers: %d", len(answers))
+	}
+	return doRADIUSAuth(username, answers[0], ip, protocol, server, func(message string) (string, error) {
+		if message == "" {
+			message = "Response: "
+		}
+		answers, err := client("", "", []string{message}, []bool{false})
+		if err != nil {
+			return "", err
+		}
+		if len(answers) != 1 {
+			return "", fmt.Errorf("unexpected number of answers: %d", len(answers))
+		}
+		return answers[0], nil
+	})
+}
+
+func doRADIUSAuth(username, password, ip, protocol, server string, challenger radius.Challenger) (User, error) {
+	srv, err := getRADIUSServer(server)
+	if err != nil {
+		return User{}, err
+	}
+	u, mergedUser, err := getUserForHook(username, nil)
+	if err != nil {
+		return u, err
+	}
+	if mergedUser.Filters.Hooks.ExternalAuthDisabled {
+		return checkUserAndPass(&u, password, ip, protocol)
+	}
+	if u.ID == 0 && !srv.CreateUsers {
+		return u, util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+	}
+
+	startTime := time.Now()
+	err = srv.Authenticate(username, password, ip, challenger)
+	if err != nil {
+		providerLog(logger.LevelDebug, "RADIUS auth failed for user %q, server %q, elapsed: %s: %v",
+			username, server, time.Since(startTime), err)
+		if errors.Is(err, radius.ErrInvalidCredentials) || errors.Is(err, radius.ErrChallengeNotSupported) {
+			return u, ErrInvalidCredentials
+		}
+		return u, fmt.Errorf("RADIUS auth error for user %q, server %q: %w", username, server, err)
+	}
+	providerLog(logger.LevelDebug, "RADIUS auth completed for user %q, server %q, elapsed: %s",
+		username, server, time.Since(startTime))
+
+	if u.ID == 0 {
+		u, err = addRADIUSUser(username, srv)
+		if err != nil {
+			return u, err
+		}
+	}
+	if err := u.LoadAndApplyGroupSettings(); err != nil {
+		return u, err
+	}
+	if err := u.CheckLoginConditions(); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+func addRADIUSUser(username string, srv *radius.Config) (User, error) {
+	user := User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: util.GenerateUniqueID(),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": srv.Permissions,
+			},
+			Description: fmt.Sprintf("Created by the RADIUS server %q", srv.Name),
+		},
+	}
+	if err := provider.addUser(&user); err != nil {
+		return user, fmt.Errorf("unable to add RADIUS user %q: %w", username, err)
+	}
+	return provider.userExists(username, "")
+}
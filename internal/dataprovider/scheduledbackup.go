@@ -0,0 +1,584 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/sio"
+	"github.com/robfig/cron/v3"
+	"github.com/sftpgo/sdk"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	scheduledBackupPrefix     = "sftpgo_backup_"
+	scheduledBackupTimeFormat = "20060102T150405Z"
+	scheduledBackupTaskName   = "@sftpgo_scheduled_backup"
+	scheduledBackupConnID     = "scheduled_backup"
+	// encrypted backups start with this magic string followed by the version
+	// byte and the nonce used to derive the encryption key
+	encryptedBackupMagic     = "SFTPGOBAK"
+	encryptedBackupVersion10 = byte(0x10)
+	encryptedBackupNonceSize = 32
+)
+
+var (
+	supportedBackupRemoteProviders = []sdk.FilesystemProvider{sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider,
+		sdk.AzureBlobFilesystemProvider, sdk.SFTPFilesystemProvider}
+	lastScheduledBackupCheck    atomic.Int64
+	isScheduledBackupRunning    atomic.Bool
+	errBackupPassphraseRequired = errors.New("the backup is encrypted but no backup passphrase is configured")
+)
+
+// BackupRemoteTarget defines the filesystem where scheduled backups are uploaded
+type BackupRemoteTarget struct {
+	// S3, GCS, Azure Blob and SFTP filesystems are supported
+	Filesystem vfs.Filesystem `json:"filesystem"`
+	// Directory, within the filesystem, where the backups are stored
+	Path string `json:"path,omitempty"`
+}
+
+// BackupConfigs defines the configuration for scheduled provider backups
+type BackupConfigs struct {
+	Enabled bool `json:"enabled"`
+	// Cron expression in the standard 5 fields format, evaluated in UTC
+	Schedule string `json:"schedule,omitempty"`
+	// If set, backups are encrypted using a key derived from this passphrase
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+	// Number of backups to keep, 0 means no limit
+	MaxBackups int `json:"max_backups,omitempty"`
+	// Backups older than this number of days are removed, 0 means no limit
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	// Optional remote target, backups are always saved in the backups path too
+	Remote *BackupRemoteTarget `json:"remote,omitempty"`
+}
+
+func (c *BackupConfigs) isEmpty() bool {
+	return !c.Enabled && c.Schedule == "" && c.Remote == nil && (c.Passphrase == nil || c.Passphrase.IsEmpty())
+}
+
+// IsEncrypted returns true if the backups must be encrypted
+func (c *BackupConfigs) IsEncrypted() bool {
+	return c.Passphrase != nil && !c.Passphrase.IsEmpty()
+}
+
+func (c *BackupConfigs) validatePassphrase() error {
+	if c.Passphrase == nil {
+		return nil
+	}
+	if c.Passphrase.IsRedacted() {
+		return util.NewValidationError("cannot save a redacted backup passphrase")
+	}
+	if c.Passphrase.IsEncrypted() && !c.Passphrase.IsValid() {
+		return util.NewValidationError("invalid encrypted backup passphrase")
+	}
+	if !c.Passphrase.IsEmpty() && !c.Passphrase.IsValidInput() {
+		return util.NewValidationError("invalid backup passphrase")
+	}
+	if c.Passphrase.IsPlain() {
+		c.Passphrase.SetAdditionalData("backups")
+		if err := c.Passphrase.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt backup passphrase: %v", err))
+		}
+	}
+	return nil
+}
+
+func (c *BackupConfigs) validate() error {
+	c.Schedule = strings.TrimSpace(c.Schedule)
+	if c.Enabled && c.Schedule == "" {
+		return util.NewValidationError("backups: a schedule is required")
+	}
+	if c.Schedule != "" {
+		if _, err := cron.ParseStandard(c.Schedule); err != nil {
+			return util.NewValidationError(fmt.Sprintf("backups: invalid schedule %q: %v", c.Schedule, err))
+		}
+	}
+	if c.MaxBackups < 0 {
+		return util.NewValidationError(fmt.Sprintf("backups: invalid max backups %d", c.MaxBackups))
+	}
+	if c.MaxAgeDays < 0 {
+		return util.NewValidationError(fmt.Sprintf("backups: invalid max age %d", c.MaxAgeDays))
+	}
+	if err := c.validatePassphrase(); err != nil {
+		return err
+	}
+	if c.Remote != nil {
+		if !util.Contains(supportedBackupRemoteProviders, c.Remote.Filesystem.Provider) {
+			return util.NewValidationError(fmt.Sprintf("backups: unsupported remote filesystem provider %d",
+				c.Remote.Filesystem.Provider))
+		}
+		if err := c.Remote.Filesystem.Validate("backups"); err != nil {
+			return util.NewValidationError(fmt.Sprintf("backups: invalid remote filesystem: %v", err))
+		}
+		c.Remote.Path = util.CleanPath(c.Remote.Path)
+	}
+	return nil
+}
+
+// HideConfidentialData hides the passphrase and the remote filesystem secrets
+func (c *BackupConfigs) HideConfidentialData() {
+	if c.Passphrase != nil {
+		c.Passphrase.Hide()
+		if c.Passphrase.IsEmpty() {
+			c.Passphrase = nil
+		}
+	}
+	if c.Remote != nil {
+		c.Remote.Filesystem.HideConfidentialData()
+		c.Remote.Filesystem.SetNilSecretsIfEmpty()
+	}
+}
+
+// SetEmptySecretsIfNil sets the nil secrets to empty
+func (c *BackupConfigs) SetEmptySecretsIfNil() {
+	if c.Passphrase == nil {
+		c.Passphrase = kms.NewEmptySecret()
+	}
+	if c.Remote != nil {
+		c.Remote.Filesystem.SetEmptySecretsIfNil()
+	}
+}
+
+func (c *BackupConfigs) getACopy() *BackupConfigs {
+	var passphrase *kms.Secret
+	if c.Passphrase != nil {
+		passphrase = c.Passphrase.Clone()
+	}
+	var remote *BackupRemoteTarget
+	if c.Remote != nil {
+		remote = &BackupRemoteTarget{
+			Filesystem: c.Remote.Filesystem.GetACopy(),
+			Path:       c.Remote.Path,
+		}
+	}
+	return &BackupConfigs{
+		Enabled:    c.Enabled,
+		Schedule:   c.Schedule,
+		Passphrase: passphrase,
+		MaxBackups: c.MaxBackups,
+		MaxAgeDays: c.MaxAgeDays,
+		Remote:     remote,
+	}
+}
+
+// getBackupsToRemove returns the backups to remove from the specified file
+// names. Names that do not match the scheduled backups naming are ignored
+func (c *BackupConfigs) getBackupsToRemove(names []string, now time.Time) []string {
+	type backupFile struct {
+		name      string
+		createdAt time.Time
+	}
+	var backups []backupFile
+	for _, name := range names {
+		if createdAt, ok := getScheduledBackupTime(name); ok {
+			backups = append(backups, backupFile{name: name, createdAt: createdAt})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].createdAt.After(backups[j].createdAt)
+	})
+	var result []string
+	for idx, b := range backups {
+		if c.MaxBackups > 0 && idx >= c.MaxBackups {
+			result = append(result, b.name)
+			continue
+		}
+		if c.MaxAgeDays > 0 && b.createdAt.Before(now.Add(-24*time.Hour*time.Duration(c.MaxAgeDays))) {
+			result = append(result, b.name)
+		}
+	}
+	return result
+}
+
+func (c *BackupConfigs) getRemoteFs() (vfs.Fs, error) {
+	fsConfig := c.Remote.Filesystem.GetACopy()
+	localTempDir := config.BackupsPath
+	switch fsConfig.Provider {
+	case sdk.S3FilesystemProvider:
+		return vfs.NewS3Fs(scheduledBackupConnID, localTempDir, "", fsConfig.S3Config)
+	case sdk.GCSFilesystemProvider:
+		return vfs.NewGCSFs(scheduledBackupConnID, localTempDir, "", fsConfig.GCSConfig)
+	case sdk.AzureBlobFilesystemProvider:
+		return vfs.NewAzBlobFs(scheduledBackupConnID, localTempDir, "", fsConfig.AzBlobConfig)
+	case sdk.SFTPFilesystemProvider:
+		return vfs.NewSFTPFs(scheduledBackupConnID, "", localTempDir, nil, fsConfig.SFTPConfig)
+	default:
+		return nil, fmt.Errorf("unsupported remote filesystem provider %d", fsConfig.Provider)
+	}
+}
+
+func (c *BackupConfigs) uploadToRemote(name string, data []byte, now time.Time) error {
+	fs, err := c.getRemoteFs()
+	if err != nil {
+		return fmt.Errorf("unable to get the remote filesystem: %w", err)
+	}
+	defer fs.Close()
+
+	dirs := util.GetDirsForVirtualPath(c.Remote.Path)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		dir := dirs[idx]
+		if dir == "/" {
+			continue
+		}
+		fsPath, err := fs.ResolvePath(dir)
+		if err != nil {
+			return err
+		}
+		if _, err := fs.Stat(fsPath); err != nil {
+			if !fs.IsNotExist(err) {
+				return err
+			}
+			if err := fs.Mkdir(fsPath); err != nil {
+				return fmt.Errorf("unable to create remote dir %q: %w", dir, err)
+			}
+		}
+	}
+	fsPath, err := fs.ResolvePath(path.Join(c.Remote.Path, name))
+	if err != nil {
+		return err
+	}
+	f, w, cancelFn, err := fs.Create(fsPath, 0)
+	if err != nil {
+		return fmt.Errorf("unable to create remote file %q: %w", fsPath, err)
+	}
+	var writer io.WriteCloser = w
+	if f != nil {
+		writer = f
+	}
+	_, err = io.Copy(writer, bytes.NewReader(data))
+	if err != nil && cancelFn != nil {
+		cancelFn()
+	}
+	errClose := writer.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("unable to upload remote file %q: %w", fsPath, err)
+	}
+	providerLog(logger.LevelDebug, "backup uploaded to remote file %q", fsPath)
+	c.pruneRemoteBackups(fs, now)
+	return nil
+}
+
+func (c *BackupConfigs) pruneRemoteBackups(fs vfs.Fs, now time.Time) {
+	if c.MaxBackups == 0 && c.MaxAgeDays == 0 {
+		return
+	}
+	dirPath, err := fs.ResolvePath(c.Remote.Path)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to resolve remote backups dir %q: %v", c.Remote.Path, err)
+		return
+	}
+	contents, err := fs.ReadDir(dirPath)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to list remote backups dir %q: %v", dirPath, err)
+		return
+	}
+	names := make([]string, 0, len(contents))
+	for _, info := range contents {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	for _, name := range c.getBackupsToRemove(names, now) {
+		fsPath := fs.Join(dirPath, name)
+		if err := fs.Remove(fsPath, false); err != nil {
+			providerLog(logger.LevelError, "unable to remove remote backup %q: %v", fsPath, err)
+		} else {
+			providerLog(logger.LevelDebug, "remote backup %q removed", fsPath)
+		}
+	}
+}
+
+func (c *BackupConfigs) pruneLocalBackups(now time.Time) {
+	if c.MaxBackups == 0 && c.MaxAgeDays == 0 {
+		return
+	}
+	entries, err := os.ReadDir(config.BackupsPath)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to list backups dir %q: %v", config.BackupsPath, err)
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	for _, name := range c.getBackupsToRemove(names, now) {
+		fsPath := filepath.Join(config.BackupsPath, name)
+		if err := os.Remove(fsPath); err != nil {
+			providerLog(logger.LevelError, "unable to remove backup %q: %v", fsPath, err)
+		} else {
+			providerLog(logger.LevelDebug, "backup %q removed", fsPath)
+		}
+	}
+}
+
+func (c *BackupConfigs) execute() (string, error) {
+	if !isScheduledBackupRunning.CompareAndSwap(false, true) {
+		return "", util.NewValidationError("a scheduled backup is already in progress")
+	}
+	defer isScheduledBackupRunning.Store(false)
+
+	now := time.Now().UTC()
+	name := scheduledBackupPrefix + now.Format(scheduledBackupTimeFormat) + ".json"
+	if c.IsEncrypted() {
+		name += ".enc"
+	}
+	outputFile := filepath.Join(config.BackupsPath, name)
+	providerLog(logger.LevelDebug, "starting scheduled backup to file %q", outputFile)
+	if err := os.MkdirAll(config.BackupsPath, 0700); err != nil {
+		providerLog(logger.LevelError, "unable to create backup dir %q: %v", config.BackupsPath, err)
+		return outputFile, fmt.Errorf("unable to create backup dir: %w", err)
+	}
+	backup, err := DumpData()
+	if err != nil {
+		providerLog(logger.LevelError, "unable to execute scheduled backup: %v", err)
+		return outputFile, fmt.Errorf("unable to dump backup data: %w", err)
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to marshal scheduled backup as JSON: %v", err)
+		return outputFile, fmt.Errorf("unable to marshal backup data as JSON: %w", err)
+	}
+	if c.IsEncrypted() {
+		if err := c.Passphrase.TryDecrypt(); err != nil {
+			providerLog(logger.LevelError, "unable to decrypt the backup passphrase: %v", err)
+			return outputFile, fmt.Errorf("unable to decrypt the backup passphrase: %w", err)
+		}
+		data, err = encryptBackup(data, c.Passphrase.GetPayload())
+		if err != nil {
+			providerLog(logger.LevelError, "unable to encrypt scheduled backup: %v", err)
+			return outputFile, fmt.Errorf("unable to encrypt backup data: %w", err)
+		}
+	}
+	if err := os.WriteFile(outputFile, data, 0600); err != nil {
+		providerLog(logger.LevelError, "unable to save scheduled backup: %v", err)
+		return outputFile, fmt.Errorf("unable to save backup: %w", err)
+	}
+	providerLog(logger.LevelDebug, "scheduled backup saved to %q", outputFile)
+	c.pruneLocalBackups(now)
+	if c.Remote != nil {
+		if err := c.uploadToRemote(name, data, now); err != nil {
+			providerLog(logger.LevelError, "unable to upload scheduled backup: %v", err)
+			return outputFile, err
+		}
+	}
+	return outputFile, nil
+}
+
+func getScheduledBackupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, scheduledBackupPrefix) {
+		return time.Time{}, false
+	}
+	name = strings.TrimPrefix(name, scheduledBackupPrefix)
+	if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".json.enc") {
+		return time.Time{}, false
+	}
+	name, _, _ = strings.Cut(name, ".")
+	createdAt, err := time.Parse(scheduledBackupTimeFormat, name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return createdAt, true
+}
+
+func getBackupEncryptionKey(passphrase string, nonce []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, []byte(passphrase), nonce, nil)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func getBackupSIOConfig(key []byte) sio.Config {
+	return sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        key,
+	}
+}
+
+func encryptBackup(data []byte, passphrase string) ([]byte, error) {
+	nonce := make([]byte, encryptedBackupNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	key, err := getBackupEncryptionKey(passphrase, nonce)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(encryptedBackupMagic)
+	buf.WriteByte(encryptedBackupVersion10)
+	buf.Write(nonce)
+	if _, err := sio.Encrypt(&buf, bytes.NewReader(data), getBackupSIOConfig(key)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isEncryptedBackup(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedBackupMagic))
+}
+
+func decryptBackup(data []byte, passphrase string) ([]byte, error) {
+	headerSize := len(encryptedBackupMagic) + 1 + encryptedBackupNonceSize
+	if len(data) < headerSize {
+		return nil, errors.New("invalid encrypted backup, header too short")
+	}
+	version := data[len(encryptedBackupMagic)]
+	if version != encryptedBackupVersion10 {
+		return nil, fmt.Errorf("unsupported backup encryption version: %v", version)
+	}
+	nonce := data[len(encryptedBackupMagic)+1 : headerSize]
+	key, err := getBackupEncryptionKey(passphrase, nonce)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := sio.Decrypt(&buf, bytes.NewReader(data[headerSize:]), getBackupSIOConfig(key)); err != nil {
+		return nil, fmt.Errorf("unable to decrypt backup, wrong passphrase?: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decryptBackupWithConfiguredPassphrase(data []byte) ([]byte, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if configs.Backups == nil || !configs.Backups.IsEncrypted() {
+		return nil, errBackupPassphraseRequired
+	}
+	if err := configs.Backups.Passphrase.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt the backup passphrase: %w", err)
+	}
+	return decryptBackup(data, configs.Backups.Passphrase.GetPayload())
+}
+
+// GetBackupConfigs returns the configuration for scheduled backups
+func GetBackupConfigs() (BackupConfigs, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return BackupConfigs{}, err
+	}
+	if configs.Backups == nil {
+		return BackupConfigs{}, nil
+	}
+	return *configs.Backups.getACopy(), nil
+}
+
+// UpdateBackupConfigs replaces the configuration for scheduled backups
+func UpdateBackupConfigs(backups *BackupConfigs, executor, ipAddress, role string) error {
+	if err := backups.validate(); err != nil {
+		return err
+	}
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	configs.Backups = backups
+	return UpdateConfigs(&configs, executor, ipAddress, role)
+}
+
+// ExecuteScheduledBackup executes a backup using the configured scheduled backups
+// settings, even if the schedule is disabled, and returns the local backup path
+func ExecuteScheduledBackup() (string, error) {
+	backups, err := GetBackupConfigs()
+	if err != nil {
+		return "", err
+	}
+	return backups.execute()
+}
+
+func checkScheduledBackup() {
+	now := time.Now()
+	lastCheck := util.GetTimeFromMsecSinceEpoch(lastScheduledBackupCheck.Load())
+	lastScheduledBackupCheck.Store(util.GetTimeAsMsSinceEpoch(now))
+
+	backups, err := GetBackupConfigs()
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get scheduled backups configs: %v", err)
+		return
+	}
+	if !backups.Enabled {
+		return
+	}
+	schedule, err := cron.ParseStandard(backups.Schedule)
+	if err != nil {
+		providerLog(logger.LevelError, "invalid scheduled backups schedule %q: %v", backups.Schedule, err)
+		return
+	}
+	next := schedule.Next(lastCheck.UTC())
+	if next.After(now) {
+		return
+	}
+	if config.IsShared == 1 && !acquireScheduledBackupTask(next) {
+		return
+	}
+	if _, err := backups.execute(); err != nil {
+		providerLog(logger.LevelError, "scheduled backup failed: %v", err)
+	}
+}
+
+// acquireScheduledBackupTask returns true if this instance must execute the
+// backup scheduled at the specified time, only one instance wins in a cluster
+func acquireScheduledBackupTask(scheduledAt time.Time) bool {
+	task, err := provider.getTaskByName(scheduledBackupTaskName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			providerLog(logger.LevelError, "unable to get scheduled backup task: %v", err)
+			return false
+		}
+		if err := provider.addTask(scheduledBackupTaskName); err != nil {
+			providerLog(logger.LevelInfo, "unable to add scheduled backup task: %v", err)
+			return false
+		}
+		task = Task{Name: scheduledBackupTaskName}
+	}
+	if task.UpdateAt >= util.GetTimeAsMsSinceEpoch(scheduledAt) {
+		providerLog(logger.LevelDebug, "scheduled backup at %s already executed by another instance", scheduledAt)
+		return false
+	}
+	if err := provider.updateTask(scheduledBackupTaskName, task.Version); err != nil {
+		providerLog(logger.LevelInfo, "unable to update scheduled backup task, skip execution: %v", err)
+		return false
+	}
+	return true
+}
@@ -68,6 +68,36 @@ func startScheduler() error {
 	if err != nil {
 		return fmt.Errorf("unable to schedule nodes cleanup: %w", err)
 	}
+	if config.AuditLog.Enabled && config.AuditLog.RetentionDays > 0 {
+		_, err = scheduler.AddFunc("@every 1h", cleanupAuditLogs)
+		if err != nil {
+			return fmt.Errorf("unable to schedule audit logs cleanup: %w", err)
+		}
+	}
+	if config.ChangeEvents.Enabled && config.ChangeEvents.RetentionDays > 0 {
+		_, err = scheduler.AddFunc("@every 1h", cleanupChangeEvents)
+		if err != nil {
+			return fmt.Errorf("unable to schedule change events cleanup: %w", err)
+		}
+	}
+	if config.ChangeEvents.Enabled && config.ChangeEvents.WebhookURL != "" {
+		// retry the failed deliveries and deliver the events added by other instances
+		_, err = scheduler.AddFunc("@every 1m", deliverChangeEvents)
+		if err != nil {
+			return fmt.Errorf("unable to schedule change events delivery: %w", err)
+		}
+	}
+	lastScheduledBackupCheck.Store(util.GetTimeAsMsSinceEpoch(time.Now()))
+	_, err = scheduler.AddFunc("@every 1m", checkScheduledBackup)
+	if err != nil {
+		return fmt.Errorf("unable to schedule backups check: %w", err)
+	}
+	if config.SoftDelete.RetentionDays > 0 {
+		_, err = scheduler.AddFunc("@every 1h", purgeDeletedObjects)
+		if err != nil {
+			return fmt.Errorf("unable to schedule deleted objects purge: %w", err)
+		}
+	}
 	scheduler.Start()
 	return nil
 }
@@ -27,6 +27,13 @@ const (
 	SessionTypeOIDCAuth SessionType = iota + 1
 	SessionTypeOIDCToken
 	SessionTypeResetCode
+	SessionTypeWebDAVLock
+	SessionTypeDeadLetter
+	SessionTypeWebAuthn
+	SessionTypeWebSession
+	SessionTypeTokensRevocation
+	SessionTypeIPApproval
+	SessionTypeChangeEventsCursor
 )
 
 // Session defines a shared session persisted in the data provider
@@ -41,7 +48,7 @@ func (s *Session) validate() error {
 	if s.Key == "" {
 		return errors.New("unable to save a session with an empty key")
 	}
-	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeResetCode {
+	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeChangeEventsCursor {
 		return fmt.Errorf("invalid session type: %v", s.Type)
 	}
 	return nil
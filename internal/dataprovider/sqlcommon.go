@@ -35,7 +35,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 28
+	sqlDatabaseVersion     = 36
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -82,6 +82,12 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{roles}}", sqlTableRoles)
 	sql = strings.ReplaceAll(sql, "{{ip_lists}}", sqlTableIPLists)
 	sql = strings.ReplaceAll(sql, "{{configs}}", sqlTableConfigs)
+	sql = strings.ReplaceAll(sql, "{{audit_logs}}", sqlTableAuditLogs)
+	sql = strings.ReplaceAll(sql, "{{change_events}}", sqlTableChangeEvents)
+	sql = strings.ReplaceAll(sql, "{{deleted_objects}}", sqlTableDeletedObjects)
+	sql = strings.ReplaceAll(sql, "{{templates}}", sqlTableTemplates)
+	sql = strings.ReplaceAll(sql, "{{tenants}}", sqlTableTenants)
+	sql = strings.ReplaceAll(sql, "{{users_folders_quota}}", sqlTableUsersFoldersQuota)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sql
 }
@@ -293,10 +299,15 @@ func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	filters, err := json.Marshal(apiKey.Filters)
+	if err != nil {
+		return err
+	}
+
 	q := getAddAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope,
 		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.LastUseAt,
-		apiKey.ExpiresAt, apiKey.Description, userID, adminID)
+		apiKey.ExpiresAt, apiKey.Description, userID, adminID, string(filters))
 	return err
 }
 
@@ -314,9 +325,26 @@ func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	filters, err := json.Marshal(apiKey.Filters)
+	if err != nil {
+		return err
+	}
+
 	q := getUpdateAPIKeyQuery()
 	res, err := dbHandle.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
-		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
+		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), string(filters), apiKey.KeyID)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonRotateAPIKey(keyID, key string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getRotateAPIKeyQuery()
+	res, err := dbHandle.ExecContext(ctx, q, key, util.GetTimeAsMsSinceEpoch(time.Now()), keyID)
 	if err != nil {
 		return err
 	}
@@ -905,6 +933,223 @@ func sqlCommonDeleteRole(role Role, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonGetTemplateByName(name string, dbHandle sqlQuerier) (Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTemplateByNameQuery()
+	row := dbHandle.QueryRowContext(ctx, q, name)
+	template, err := getTemplateFromDbRow(row)
+	if err != nil && errors.Is(err, util.ErrNotFound) {
+		return template, util.NewRecordNotFoundError(fmt.Sprintf("template %q does not exist", name))
+	}
+	return template, err
+}
+
+func sqlCommonDumpTemplates(dbHandle sqlQuerier) ([]Template, error) {
+	templates := make([]Template, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getDumpTemplatesQuery()
+
+	rows, err := dbHandle.QueryContext(ctx, q)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := getTemplateFromDbRow(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func sqlCommonGetTemplates(limit int, offset int, order string, dbHandle sqlQuerier) ([]Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTemplatesQuery(order)
+
+	templates := make([]Template, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := getTemplateFromDbRow(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func sqlCommonAddTemplate(template *Template, dbHandle *sql.DB) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddTemplateQuery()
+	_, err := dbHandle.ExecContext(ctx, q, template.Name, template.Description, template.Type, template.Extends,
+		string(template.Settings), util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()))
+	return err
+}
+
+func sqlCommonUpdateTemplate(template *Template, dbHandle *sql.DB) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateTemplateQuery()
+	res, err := dbHandle.ExecContext(ctx, q, template.Description, template.Extends, string(template.Settings),
+		util.GetTimeAsMsSinceEpoch(time.Now()), template.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonDeleteTemplate(template Template, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteTemplateQuery()
+	res, err := dbHandle.ExecContext(ctx, q, template.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonGetTenantByName(name string, dbHandle sqlQuerier) (Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTenantByNameQuery()
+	row := dbHandle.QueryRowContext(ctx, q, name)
+	tenant, err := getTenantFromDbRow(row)
+	if err != nil && errors.Is(err, util.ErrNotFound) {
+		return tenant, util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+	}
+	return tenant, err
+}
+
+func sqlCommonDumpTenants(dbHandle sqlQuerier) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getDumpTenantsQuery()
+
+	rows, err := dbHandle.QueryContext(ctx, q)
+	if err != nil {
+		return tenants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tenant, err := getTenantFromDbRow(rows)
+		if err != nil {
+			return tenants, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+func sqlCommonGetTenants(limit int, offset int, order string, dbHandle sqlQuerier) ([]Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTenantsQuery(order)
+
+	tenants := make([]Tenant, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	if err != nil {
+		return tenants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tenant, err := getTenantFromDbRow(rows)
+		if err != nil {
+			return tenants, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+func sqlCommonAddTenant(tenant *Tenant, dbHandle *sql.DB) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	members, err := json.Marshal(tenant.Members)
+	if err != nil {
+		return err
+	}
+	branding, err := json.Marshal(tenant.Branding)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddTenantQuery()
+	_, err = dbHandle.ExecContext(ctx, q, tenant.Name, tenant.Description, string(members), tenant.QuotaSize,
+		tenant.QuotaFiles, tenant.MaxSessions, string(branding), util.GetTimeAsMsSinceEpoch(time.Now()),
+		util.GetTimeAsMsSinceEpoch(time.Now()))
+	return err
+}
+
+func sqlCommonUpdateTenant(tenant *Tenant, dbHandle *sql.DB) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	members, err := json.Marshal(tenant.Members)
+	if err != nil {
+		return err
+	}
+	branding, err := json.Marshal(tenant.Branding)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateTenantQuery()
+	res, err := dbHandle.ExecContext(ctx, q, tenant.Description, string(members), tenant.QuotaSize, tenant.QuotaFiles,
+		tenant.MaxSessions, string(branding), util.GetTimeAsMsSinceEpoch(time.Now()), tenant.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonDeleteTenant(tenant Tenant, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteTenantQuery()
+	res, err := dbHandle.ExecContext(ctx, q, tenant.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonGetGroupByName(name string, dbHandle sqlQuerier) (Group, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1076,13 +1321,17 @@ func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	includedGroups, err := json.Marshal(group.IncludedGroups)
+	if err != nil {
+		return err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
 		q := getAddGroupQuery()
 		_, err := tx.ExecContext(ctx, q, group.Name, group.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), settings)
+			util.GetTimeAsMsSinceEpoch(time.Now()), settings, string(includedGroups))
 		if err != nil {
 			return err
 		}
@@ -1099,12 +1348,17 @@ func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	includedGroups, err := json.Marshal(group.IncludedGroups)
+	if err != nil {
+		return err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
 		q := getUpdateGroupQuery()
-		_, err := tx.ExecContext(ctx, q, group.Description, settings, util.GetTimeAsMsSinceEpoch(time.Now()), group.Name)
+		_, err := tx.ExecContext(ctx, q, group.Description, settings, string(includedGroups),
+			util.GetTimeAsMsSinceEpoch(time.Now()), group.Name)
 		if err != nil {
 			return err
 		}
@@ -1381,6 +1635,44 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 	})
 }
 
+func sqlCommonGetUsernameWithS3AccessKey(accessKeyID string, dbHandle sqlQuerier) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	// the filters are stored as JSON, we use a LIKE query to find the candidates
+	// and then we check the access keys after unmarshaling them
+	q := getUsersWithFiltersLikeQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, fmt.Sprintf(`%%"access_key_id":"%s"%%`, accessKeyID))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		var filters sql.NullString
+		if err := rows.Scan(&username, &filters); err != nil {
+			return "", err
+		}
+		if !filters.Valid {
+			continue
+		}
+		var userFilters UserFilters
+		if err := json.Unmarshal([]byte(filters.String), &userFilters); err != nil {
+			continue
+		}
+		for _, key := range userFilters.S3AccessKeys {
+			if key.AccessKeyID == accessKeyID {
+				return username, nil
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", util.NewRecordNotFoundError(fmt.Sprintf("s3 access key %q not found", accessKeyID))
+}
+
 func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1546,6 +1838,9 @@ func sqlCommonGetRecentlyUpdatedUsers(after int64, dbHandle sqlQuerier) ([]User,
 	for idx := range groups {
 		groupsMapping[groups[idx].Name] = groups[idx]
 	}
+	addIncludedGroupsToMapping(groupsMapping, func(name string) (Group, error) {
+		return sqlCommonGetGroupByName(name, dbHandle)
+	})
 	for idx := range users {
 		ref := &users[idx]
 		ref.applyGroupSettings(groupsMapping)
@@ -1616,6 +1911,9 @@ func sqlCommonGetUsersForQuotaCheck(toFetch map[string]bool, dbHandle sqlQuerier
 	for idx := range groups {
 		groupsMapping[groups[idx].Name] = groups[idx]
 	}
+	addIncludedGroupsToMapping(groupsMapping, func(name string) (Group, error) {
+		return sqlCommonGetGroupByName(name, dbHandle)
+	})
 	for idx := range users {
 		ref := &users[idx]
 		ref.applyGroupSettings(groupsMapping)
@@ -2011,10 +2309,10 @@ func getShareFromDbRow(row sqlScanner) (Share, error) {
 func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	var apiKey APIKey
 	var userID, adminID sql.NullInt64
-	var description sql.NullString
+	var description, filters sql.NullString
 
 	err := row.Scan(&apiKey.KeyID, &apiKey.Name, &apiKey.Key, &apiKey.Scope, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID)
+		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID, &filters)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -2032,6 +2330,13 @@ func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	if description.Valid {
 		apiKey.Description = description.String
 	}
+	if filters.Valid {
+		var apiKeyFilters APIKeyFilters
+		err = json.Unmarshal([]byte(filters.String), &apiKeyFilters)
+		if err == nil {
+			apiKey.Filters = apiKeyFilters
+		}
+	}
 
 	return apiKey, nil
 }
@@ -2165,12 +2470,67 @@ func getRoleFromDbRow(row sqlScanner) (Role, error) {
 	return role, nil
 }
 
+func getTemplateFromDbRow(row sqlScanner) (Template, error) {
+	var template Template
+	var description, extends, settings sql.NullString
+
+	err := row.Scan(&template.ID, &template.Name, &description, &template.Type, &extends, &template.Version,
+		&settings, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return template, util.NewRecordNotFoundError(err.Error())
+		}
+		return template, err
+	}
+	if description.Valid {
+		template.Description = description.String
+	}
+	if extends.Valid {
+		template.Extends = extends.String
+	}
+	if settings.Valid {
+		template.Settings = json.RawMessage(settings.String)
+	}
+
+	return template, nil
+}
+
+func getTenantFromDbRow(row sqlScanner) (Tenant, error) {
+	var tenant Tenant
+	var description, members, branding sql.NullString
+
+	err := row.Scan(&tenant.ID, &tenant.Name, &description, &members, &tenant.QuotaSize, &tenant.QuotaFiles,
+		&tenant.MaxSessions, &branding, &tenant.CreatedAt, &tenant.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return tenant, util.NewRecordNotFoundError(err.Error())
+		}
+		return tenant, err
+	}
+	if description.Valid {
+		tenant.Description = description.String
+	}
+	if members.Valid && members.String != "" {
+		if err := json.Unmarshal([]byte(members.String), &tenant.Members); err != nil {
+			return tenant, err
+		}
+	}
+	if branding.Valid && branding.String != "" {
+		if err := json.Unmarshal([]byte(branding.String), &tenant.Branding); err != nil {
+			return tenant, err
+		}
+	}
+
+	return tenant, nil
+}
+
 func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	var group Group
-	var description sql.NullString
+	var description, includedGroups sql.NullString
 	var userSettings []byte
 
-	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings)
+	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings,
+		&includedGroups)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return group, util.NewRecordNotFoundError(err.Error())
@@ -2186,6 +2546,12 @@ func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	if err == nil {
 		group.UserSettings = settings
 	}
+	if includedGroups.Valid {
+		var names []string
+		if err := json.Unmarshal([]byte(includedGroups.String), &names); err == nil {
+			group.IncludedGroups = names
+		}
+	}
 
 	return group, nil
 }
@@ -2477,7 +2843,8 @@ func sqlCommonClearUserGroupMapping(ctx context.Context, user *User, dbHandle sq
 
 func sqlCommonAddUserFolderMapping(ctx context.Context, user *User, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddUserFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, user.Username)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.UserQuotaSize,
+		folder.UserQuotaFiles, folder.Name, user.Username)
 	return err
 }
 
@@ -2489,7 +2856,8 @@ func sqlCommonClearAdminGroupMapping(ctx context.Context, admin *Admin, dbHandle
 
 func sqlCommonAddGroupFolderMapping(ctx context.Context, group *Group, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddGroupFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, group.Name)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.UserQuotaSize,
+		folder.UserQuotaFiles, folder.Name, group.Name)
 	return err
 }
 
@@ -2705,7 +3073,8 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 		var mappedPath, description sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &userID, &fsConfig,
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles,
+			&folder.UserQuotaSize, &folder.UserQuotaFiles, &userID, &fsConfig,
 			&description)
 		if err != nil {
 			return users, err
@@ -2856,7 +3225,8 @@ func getGroupsWithVirtualFolders(ctx context.Context, groups []Group, dbHandle s
 		var mappedPath, description sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &groupID, &fsConfig,
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles,
+			&folder.UserQuotaSize, &folder.UserQuotaFiles, &groupID, &fsConfig,
 			&description)
 		if err != nil {
 			return groups, err
@@ -3127,6 +3497,41 @@ func sqlCommonGetFolderUsedQuota(mappedPath string, dbHandle *sql.DB) (int, int6
 	return usedFiles, usedSize, err
 }
 
+func sqlCommonUpdateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool,
+	dbHandle *sql.DB,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateUserFolderQuotaQuery(reset)
+	_, err := dbHandle.ExecContext(ctx, q, username, folderName, sizeAdd, filesAdd, util.GetTimeAsMsSinceEpoch(time.Now()))
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for user %q inside folder %q, files increment: %d size increment: %d is reset? %t",
+			username, folderName, filesAdd, sizeAdd, reset)
+	} else {
+		providerLog(logger.LevelWarn, "error updating quota for user %q inside folder %q: %v", username, folderName, err)
+	}
+	return err
+}
+
+func sqlCommonGetUserFolderUsedQuota(username, folderName string, dbHandle *sql.DB) (int, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUserFolderQuotaQuery()
+	var usedFiles int
+	var usedSize int64
+	err := dbHandle.QueryRowContext(ctx, q, username, folderName).Scan(&usedSize, &usedFiles)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, nil
+		}
+		providerLog(logger.LevelError, "error getting quota for user %q inside folder %q: %v", username, folderName, err)
+		return 0, 0, err
+	}
+	return max(usedFiles, 0), max(usedSize, 0), nil
+}
+
 func getAPIKeyWithRelatedFields(ctx context.Context, apiKey APIKey, dbHandle sqlQuerier) (APIKey, error) {
 	var apiKeys []APIKey
 	var err error
@@ -3240,6 +3645,30 @@ func sqlCommonGetSession(key string, dbHandle sqlQuerier) (Session, error) {
 	return session, nil
 }
 
+func sqlCommonGetSessions(sessionType SessionType, dbHandle sqlQuerier) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSessionsQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, sessionType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var data []byte
+		if err := rows.Scan(&session.Key, &data, &session.Type, &session.Timestamp); err != nil {
+			return nil, err
+		}
+		session.Data = data
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 func sqlCommonDeleteSession(key string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3885,6 +4314,187 @@ func sqlCommonSetConfigs(configs *Configs, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonAddAuditLogEntry(entry *AuditLogEntry, dbHandle *sql.DB) error {
+	changes, err := entry.getChangesAsJSON()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddAuditLogEntryQuery()
+	_, err = dbHandle.ExecContext(ctx, q, entry.Timestamp, entry.Action, entry.ObjectType, entry.ObjectName,
+		entry.Username, entry.Role, entry.IP, entry.Protocol, entry.APIKeyID, changes, entry.Info)
+	return err
+}
+
+func sqlCommonGetAuditLogs(search *AuditLogSearch, dbHandle sqlQuerier) ([]AuditLogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q, args := getAuditLogsQuery(search)
+	entries := make([]AuditLogEntry, 0, search.Limit)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditLogEntry
+		var objectType, objectName, username, role, ip, protocol, apiKeyID, changes, info sql.NullString
+		err = rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &objectType, &objectName, &username, &role,
+			&ip, &protocol, &apiKeyID, &changes, &info)
+		if err != nil {
+			return entries, err
+		}
+		entry.ObjectType = objectType.String
+		entry.ObjectName = objectName.String
+		entry.Username = username.String
+		entry.Role = role.String
+		entry.IP = ip.String
+		entry.Protocol = protocol.String
+		entry.APIKeyID = apiKeyID.String
+		entry.Info = info.String
+		if changes.Valid && changes.String != "" {
+			if err := json.Unmarshal([]byte(changes.String), &entry.Changes); err != nil {
+				return entries, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func sqlCommonCleanupAuditLogs(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupAuditLogsQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonAddChangeEvent(event *ChangeEvent, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddChangeEventQuery()
+	_, err := dbHandle.ExecContext(ctx, q, event.Timestamp, event.Action, event.ObjectType, event.ObjectName,
+		string(event.Object))
+	return err
+}
+
+func sqlCommonGetChangeEvents(after int64, limit int, dbHandle sqlQuerier) ([]ChangeEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getChangeEventsQuery()
+	events := make([]ChangeEvent, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, after, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event ChangeEvent
+		var object sql.NullString
+		err = rows.Scan(&event.ID, &event.Timestamp, &event.Action, &event.ObjectType, &event.ObjectName, &object)
+		if err != nil {
+			return events, err
+		}
+		if object.Valid && object.String != "" {
+			event.Object = json.RawMessage(object.String)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func sqlCommonCleanupChangeEvents(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupChangeEventsQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonAddDeletedObject(object *DeletedObject, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getAddDeletedObjectQuery()
+		_, err := tx.ExecContext(ctx, q, object.ObjectType, object.ObjectName, object.DeletedAt, object.Data)
+		if err != nil {
+			return err
+		}
+		q = getLastDeletedObjectIDQuery()
+		return tx.QueryRowContext(ctx, q, object.ObjectType, object.ObjectName, object.DeletedAt).Scan(&object.ID)
+	})
+}
+
+func sqlCommonGetDeletedObjects(objectType string, before int64, limit, offset int, dbHandle sqlQuerier) ([]DeletedObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q, args := getDeletedObjectsQuery(objectType, before, limit, offset)
+	objects := make([]DeletedObject, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return objects, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		object, err := getDeletedObjectFromDbRow(rows)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+func sqlCommonGetDeletedObject(id int64, dbHandle sqlQuerier) (DeletedObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeletedObjectQuery()
+	row := dbHandle.QueryRowContext(ctx, q, id)
+	return getDeletedObjectFromDbRow(row)
+}
+
+func sqlCommonRemoveDeletedObject(id int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getRemoveDeletedObjectQuery()
+	res, err := dbHandle.ExecContext(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func getDeletedObjectFromDbRow(row sqlScanner) (DeletedObject, error) {
+	var object DeletedObject
+	var data sql.NullString
+	err := row.Scan(&object.ID, &object.ObjectType, &object.ObjectName, &object.DeletedAt, &data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return object, util.NewRecordNotFoundError(err.Error())
+		}
+		return object, err
+	}
+	if data.Valid {
+		object.Data = data.String
+	}
+	return object, nil
+}
+
 func sqlCommonGetDatabaseVersion(dbHandle sqlQuerier, showInitWarn bool) (schemaVersion, error) {
 	var result schemaVersion
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
@@ -38,6 +38,7 @@ import (
 
 const (
 	sqliteResetSQL = `DROP TABLE IF EXISTS "{{api_keys}}";
+DROP TABLE IF EXISTS "{{users_folders_quota}}";
 DROP TABLE IF EXISTS "{{folders_mapping}}";
 DROP TABLE IF EXISTS "{{users_folders_mapping}}";
 DROP TABLE IF EXISTS "{{users_groups_mapping}}";
@@ -59,6 +60,11 @@ DROP TABLE IF EXISTS "{{tasks}}";
 DROP TABLE IF EXISTS "{{roles}}";
 DROP TABLE IF EXISTS "{{ip_lists}}";
 DROP TABLE IF EXISTS "{{configs}}";
+DROP TABLE IF EXISTS "{{audit_logs}}";
+DROP TABLE IF EXISTS "{{change_events}}";
+DROP TABLE IF EXISTS "{{deleted_objects}}";
+DROP TABLE IF EXISTS "{{templates}}";
+DROP TABLE IF EXISTS "{{tenants}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
 `
 	sqliteInitialSQL = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "version" integer NOT NULL);
@@ -198,6 +204,60 @@ CREATE INDEX "{{prefix}}ip_lists_first_last_idx" ON "{{ip_lists}}" ("first", "la
 INSERT INTO {{configs}} (configs) VALUES ('{}');
 `
 	sqliteV28DownSQL = `DROP TABLE "{{configs}}";`
+	sqliteV29SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "filters" text NULL;`
+	sqliteV29DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "filters";`
+	sqliteV30SQL     = `CREATE TABLE "{{audit_logs}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"timestamp" bigint NOT NULL, "action" varchar(50) NOT NULL, "object_type" varchar(50) NULL,
+"object_name" varchar(255) NULL, "username" varchar(255) NULL, "role" varchar(255) NULL, "ip" varchar(50) NULL,
+"protocol" varchar(30) NULL, "api_key_id" varchar(50) NULL, "changes" text NULL, "info" text NULL);
+CREATE INDEX "{{prefix}}audit_logs_timestamp_idx" ON "{{audit_logs}}" ("timestamp");
+CREATE INDEX "{{prefix}}audit_logs_action_idx" ON "{{audit_logs}}" ("action");
+CREATE INDEX "{{prefix}}audit_logs_username_idx" ON "{{audit_logs}}" ("username");
+`
+	sqliteV30DownSQL = `DROP TABLE "{{audit_logs}}";`
+	sqliteV31SQL     = `CREATE TABLE "{{change_events}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"timestamp" bigint NOT NULL, "action" varchar(50) NOT NULL, "object_type" varchar(50) NOT NULL,
+"object_name" varchar(255) NOT NULL, "object" text NULL);
+CREATE INDEX "{{prefix}}change_events_timestamp_idx" ON "{{change_events}}" ("timestamp");
+`
+	sqliteV31DownSQL = `DROP TABLE "{{change_events}}";`
+	sqliteV32SQL     = `CREATE TABLE "{{deleted_objects}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL, "deleted_at" bigint NOT NULL,
+"data" text NOT NULL);
+CREATE INDEX "{{prefix}}deleted_objects_deleted_at_idx" ON "{{deleted_objects}}" ("deleted_at");
+`
+	sqliteV32DownSQL = `DROP TABLE "{{deleted_objects}}";`
+	sqliteV33SQL     = `CREATE TABLE "{{templates}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"name" varchar(255) NOT NULL UNIQUE, "description" varchar(512) NULL, "type" varchar(50) NOT NULL,
+"extends" varchar(255) NULL, "version" integer NOT NULL, "settings" text NOT NULL, "created_at" bigint NOT NULL,
+"updated_at" bigint NOT NULL);
+`
+	sqliteV33DownSQL = `DROP TABLE "{{templates}}";`
+	sqliteV34SQL     = `ALTER TABLE "{{groups}}" ADD COLUMN "included_groups" text NULL;`
+	sqliteV34DownSQL = `ALTER TABLE "{{groups}}" DROP COLUMN "included_groups";`
+	sqliteV35SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+CREATE TABLE "{{users_folders_quota}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"user_id" integer NOT NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+"folder_id" integer NOT NULL REFERENCES "{{folders}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+"used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL, "last_quota_update" bigint NOT NULL,
+CONSTRAINT "{{prefix}}unique_user_folder_quota" UNIQUE ("user_id", "folder_id"));
+CREATE INDEX "{{prefix}}users_folders_quota_folder_id_idx" ON "{{users_folders_quota}}" ("folder_id");
+`
+	sqliteV35DownSQL = `DROP TABLE "{{users_folders_quota}}";
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_files";
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_size";
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_files";
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_size";
+`
+	sqliteV36SQL = `CREATE TABLE "{{tenants}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"name" varchar(255) NOT NULL UNIQUE, "description" varchar(512) NULL, "members" text NULL, "quota_size" bigint NOT NULL,
+"quota_files" integer NOT NULL, "max_sessions" integer NOT NULL, "branding" text NULL, "created_at" bigint NOT NULL,
+"updated_at" bigint NOT NULL);
+`
+	sqliteV36DownSQL = `DROP TABLE "{{tenants}}";`
 )
 
 // SQLiteProvider defines the auth provider for SQLite database
@@ -293,6 +353,10 @@ func (p *SQLiteProvider) deleteUser(user User, softDelete bool) error {
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getUsernameWithS3AccessKey(accessKeyID string) (string, error) {
+	return sqlCommonGetUsernameWithS3AccessKey(accessKeyID, p.dbHandle)
+}
+
 func (p *SQLiteProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
@@ -347,6 +411,14 @@ func (p *SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	return sqlCommonGetUserFolderUsedQuota(username, folderName, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
 	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
 }
@@ -435,6 +507,10 @@ func (p *SQLiteProvider) updateAPIKeyLastUse(keyID string) error {
 	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
 }
 
+func (p *SQLiteProvider) rotateAPIKey(keyID, key string) error {
+	return sqlCommonRotateAPIKey(keyID, key, p.dbHandle)
+}
+
 func (p *SQLiteProvider) shareExists(shareID, username string) (Share, error) {
 	return sqlCommonGetShareByID(shareID, username, p.dbHandle)
 }
@@ -527,6 +603,10 @@ func (p *SQLiteProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getSharedSessions(sessionType SessionType) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -639,6 +719,54 @@ func (p *SQLiteProvider) getRoles(limit int, offset int, order string, minimal b
 	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
 }
 
+func (p *SQLiteProvider) templateExists(name string) (Template, error) {
+	return sqlCommonGetTemplateByName(name, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addTemplate(template *Template) error {
+	return sqlCommonAddTemplate(template, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateTemplate(template *Template) error {
+	return sqlCommonUpdateTemplate(template, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteTemplate(template Template) error {
+	return sqlCommonDeleteTemplate(template, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getTemplates(limit int, offset int, order string) ([]Template, error) {
+	return sqlCommonGetTemplates(limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpTemplates() ([]Template, error) {
+	return sqlCommonDumpTemplates(p.dbHandle)
+}
+
+func (p *SQLiteProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addTenant(tenant *Tenant) error {
+	return sqlCommonAddTenant(tenant, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
+}
+
 func (p *SQLiteProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
@@ -687,6 +815,46 @@ func (p *SQLiteProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *SQLiteProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getAuditLogs(search *AuditLogSearch) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogs(search, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupAuditLogs(before int64) error {
+	return sqlCommonCleanupAuditLogs(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addChangeEvent(event *ChangeEvent) error {
+	return sqlCommonAddChangeEvent(event, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getChangeEvents(after int64, limit int) ([]ChangeEvent, error) {
+	return sqlCommonGetChangeEvents(after, limit, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupChangeEvents(before int64) error {
+	return sqlCommonCleanupChangeEvents(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addDeletedObject(object *DeletedObject) error {
+	return sqlCommonAddDeletedObject(object, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDeletedObjects(objectType string, before int64, limit, offset int) ([]DeletedObject, error) {
+	return sqlCommonGetDeletedObjects(objectType, before, limit, offset, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDeletedObject(id int64) (DeletedObject, error) {
+	return sqlCommonGetDeletedObject(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) removeDeletedObject(id int64) error {
+	return sqlCommonRemoveDeletedObject(id, p.dbHandle)
+}
+
 func (p *SQLiteProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -743,6 +911,22 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		return updateSQLiteDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updateSQLiteDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateSQLiteDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateSQLiteDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateSQLiteDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updateSQLiteDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateSQLiteDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateSQLiteDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateSQLiteDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updateSQLiteDatabaseFromV35(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -775,6 +959,22 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 		return downgradeSQLiteDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradeSQLiteDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradeSQLiteDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradeSQLiteDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeSQLiteDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradeSQLiteDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeSQLiteDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeSQLiteDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeSQLiteDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradeSQLiteDatabaseFromV36(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -814,7 +1014,63 @@ func updateSQLiteDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updateSQLiteDatabaseFromV27(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom27To28(dbHandle)
+	if err := updateSQLiteDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV28(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV29(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV30(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV31(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV32(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV33(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV34(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV35(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV35(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom35To36(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV24(dbHandle *sql.DB) error {
@@ -849,6 +1105,62 @@ func downgradeSQLiteDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFromV27(dbHandle)
 }
 
+func downgradeSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV28(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV29(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV30(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV31(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV32(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV33(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV34(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV35(dbHandle)
+}
+
 func updateSQLiteDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -888,6 +1200,65 @@ func updateSQLiteDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
 }
 
+func updateSQLiteDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(sqliteV29SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updateSQLiteDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(sqliteV30SQL, "{{audit_logs}}", sqlTableAuditLogs)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updateSQLiteDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(sqliteV31SQL, "{{change_events}}", sqlTableChangeEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
+func updateSQLiteDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(sqliteV32SQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, true)
+}
+
+func updateSQLiteDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := strings.ReplaceAll(sqliteV33SQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func updateSQLiteDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := strings.ReplaceAll(sqliteV34SQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func updateSQLiteDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(sqliteV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func updateSQLiteDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+	sql := sqlReplaceAll(sqliteV36SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, true)
+}
+
 func downgradeSQLiteDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -926,6 +1297,62 @@ func downgradeSQLiteDatabaseFrom28To27(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
 }
 
+func downgradeSQLiteDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(sqliteV29DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradeSQLiteDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(sqliteV30DownSQL, "{{audit_logs}}", sqlTableAuditLogs)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(sqliteV31DownSQL, "{{change_events}}", sqlTableChangeEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+func downgradeSQLiteDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(sqliteV32DownSQL, "{{deleted_objects}}", sqlTableDeletedObjects)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, false)
+}
+
+func downgradeSQLiteDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := strings.ReplaceAll(sqliteV33DownSQL, "{{templates}}", sqlTableTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func downgradeSQLiteDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := strings.ReplaceAll(sqliteV34DownSQL, "{{groups}}", sqlTableGroups)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func downgradeSQLiteDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(sqliteV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
+
+func downgradeSQLiteDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+	sql := sqlReplaceAll(sqliteV36DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, false)
+}
+
 /*func setPragmaFK(dbHandle *sql.DB, value string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
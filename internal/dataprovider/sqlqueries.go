@@ -30,13 +30,18 @@ const (
 		"u.used_upload_data_transfer,u.used_download_data_transfer,u.deleted_at,u.first_download,u.first_upload,r.name,u.last_password_change"
 	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
 	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at,a.updated_at,a.last_login,r.name"
-	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
+	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,filters"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
 		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
-	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
+	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings,included_groups"
 	selectEventActionFields = "id,name,description,type,options"
 	selectRoleFields        = "id,name,description,created_at,updated_at"
+	selectTemplateFields    = "id,name,description,type,extends,version,settings,created_at,updated_at"
+	selectTenantFields      = "id,name,description,members,quota_size,quota_files,max_sessions,branding,created_at,updated_at"
 	selectIPListEntryFields = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
+	selectAuditLogFields    = "id,timestamp,action,object_type,object_name,username,role,ip,protocol,api_key_id,changes,info"
+	selectChangeEventFields = "id,timestamp,action,object_type,object_name,object"
+	selectDeletedObjFields  = "id,object_type,object_name,deleted_at,data"
 	selectMinimalFields     = "id,name"
 )
 
@@ -102,6 +107,15 @@ func getSessionQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getSessionsQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("SELECT `key`,`data`,`type`,`timestamp` FROM %s WHERE `type` = %s ORDER BY `timestamp` ASC",
+			sqlTableSharedSessions, sqlPlaceholders[0])
+	}
+	return fmt.Sprintf(`SELECT key,data,type,timestamp FROM %s WHERE type = %s ORDER BY timestamp ASC`,
+		sqlTableSharedSessions, sqlPlaceholders[0])
+}
+
 func getCleanupSessionsQuery() string {
 	return fmt.Sprintf(`DELETE from %s WHERE type = %s AND timestamp < %s`,
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -282,6 +296,145 @@ func getUpdateConfigsQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET configs = %s`, sqlTableConfigs, sqlPlaceholders[0])
 }
 
+func getAddAuditLogEntryQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (timestamp,action,object_type,object_name,username,role,ip,protocol,api_key_id,changes,info)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAuditLogs, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10])
+}
+
+func getAuditLogsQuery(search *AuditLogSearch) (string, []any) {
+	var sb strings.Builder
+	var args []any
+
+	addCondition := func(condition string) {
+		if len(args) == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(condition)
+	}
+	addInCondition := func(field string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		placeholders := make([]string, 0, len(values))
+		for idx := range values {
+			placeholders = append(placeholders, sqlPlaceholders[len(args)+idx])
+		}
+		addCondition(fmt.Sprintf("%s IN (%s)", field, strings.Join(placeholders, ",")))
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+
+	sb.WriteString("SELECT ")
+	sb.WriteString(selectAuditLogFields)
+	sb.WriteString(" FROM ")
+	sb.WriteString(sqlTableAuditLogs)
+	if search.StartTimestamp > 0 {
+		addCondition("timestamp >= " + sqlPlaceholders[len(args)])
+		args = append(args, search.StartTimestamp)
+	}
+	if search.EndTimestamp > 0 {
+		addCondition("timestamp <= " + sqlPlaceholders[len(args)])
+		args = append(args, search.EndTimestamp)
+	}
+	addInCondition("action", search.Actions)
+	addInCondition("object_type", search.ObjectTypes)
+	if search.ObjectName != "" {
+		addCondition("object_name = " + sqlPlaceholders[len(args)])
+		args = append(args, search.ObjectName)
+	}
+	if search.Username != "" {
+		addCondition("username = " + sqlPlaceholders[len(args)])
+		args = append(args, search.Username)
+	}
+	if search.IP != "" {
+		addCondition("ip = " + sqlPlaceholders[len(args)])
+		args = append(args, search.IP)
+	}
+	if search.Role != "" {
+		addCondition("role = " + sqlPlaceholders[len(args)])
+		args = append(args, search.Role)
+	}
+	sb.WriteString(" ORDER BY id ")
+	sb.WriteString(search.Order)
+	sb.WriteString(" LIMIT ")
+	sb.WriteString(sqlPlaceholders[len(args)])
+	args = append(args, search.Limit)
+	sb.WriteString(" OFFSET ")
+	sb.WriteString(sqlPlaceholders[len(args)])
+	args = append(args, search.Offset)
+	return sb.String(), args
+}
+
+func getCleanupAuditLogsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE timestamp < %s`, sqlTableAuditLogs, sqlPlaceholders[0])
+}
+
+func getAddChangeEventQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (timestamp,action,object_type,object_name,object) VALUES (%s,%s,%s,%s,%s)`,
+		sqlTableChangeEvents, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+}
+
+func getChangeEventsQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE id > %s ORDER BY id ASC LIMIT %s`, selectChangeEventFields,
+		sqlTableChangeEvents, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getCleanupChangeEventsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE timestamp < %s`, sqlTableChangeEvents, sqlPlaceholders[0])
+}
+
+func getAddDeletedObjectQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (object_type,object_name,deleted_at,data) VALUES (%s,%s,%s,%s)`,
+		sqlTableDeletedObjects, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getLastDeletedObjectIDQuery() string {
+	return fmt.Sprintf(`SELECT id FROM %s WHERE object_type = %s AND object_name = %s AND deleted_at = %s ORDER BY id DESC LIMIT 1`,
+		sqlTableDeletedObjects, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeletedObjectsQuery(objectType string, before int64, limit, offset int) (string, []any) {
+	var sb strings.Builder
+	var args []any
+
+	sb.WriteString("SELECT ")
+	sb.WriteString(selectDeletedObjFields)
+	sb.WriteString(" FROM ")
+	sb.WriteString(sqlTableDeletedObjects)
+	if objectType != "" {
+		sb.WriteString(" WHERE object_type = " + sqlPlaceholders[len(args)])
+		args = append(args, objectType)
+	}
+	if before > 0 {
+		if len(args) == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString("deleted_at < " + sqlPlaceholders[len(args)])
+		args = append(args, before)
+	}
+	sb.WriteString(fmt.Sprintf(" ORDER BY id DESC LIMIT %s OFFSET %s", sqlPlaceholders[len(args)],
+		sqlPlaceholders[len(args)+1]))
+	args = append(args, limit, offset)
+	return sb.String(), args
+}
+
+func getDeletedObjectQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE id = %s`, selectDeletedObjFields, sqlTableDeletedObjects,
+		sqlPlaceholders[0])
+}
+
+func getRemoveDeletedObjectQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE id = %s`, sqlTableDeletedObjects, sqlPlaceholders[0])
+}
+
 func getRoleByNameQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectRoleFields, sqlTableRoles,
 		sqlPlaceholders[0])
@@ -351,6 +504,68 @@ func getDeleteRoleQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableRoles, sqlPlaceholders[0])
 }
 
+func getTemplateByNameQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectTemplateFields, sqlTableTemplates,
+		sqlPlaceholders[0])
+}
+
+func getTemplatesQuery(order string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, selectTemplateFields,
+		sqlTableTemplates, order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpTemplatesQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s`, selectTemplateFields, sqlTableTemplates)
+}
+
+func getAddTemplateQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,type,extends,version,settings,created_at,updated_at)
+		VALUES (%s,%s,%s,%s,1,%s,%s,%s)`, sqlTableTemplates, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getUpdateTemplateQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET description=%s,extends=%s,settings=%s,version=version+1,updated_at=%s
+		WHERE name = %s`, sqlTableTemplates, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getDeleteTemplateQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableTemplates, sqlPlaceholders[0])
+}
+
+func getTenantByNameQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectTenantFields, sqlTableTenants,
+		sqlPlaceholders[0])
+}
+
+func getTenantsQuery(order string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, selectTenantFields,
+		sqlTableTenants, order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpTenantsQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s`, selectTenantFields, sqlTableTenants)
+}
+
+func getAddTenantQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,members,quota_size,quota_files,max_sessions,branding,
+		created_at,updated_at) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableTenants, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8])
+}
+
+func getUpdateTenantQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET description=%s,members=%s,quota_size=%s,quota_files=%s,max_sessions=%s,
+		branding=%s,updated_at=%s WHERE name = %s`, sqlTableTenants, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7])
+}
+
+func getDeleteTenantQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableTenants, sqlPlaceholders[0])
+}
+
 func getGroupByNameQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectGroupFields, getSQLQuotedName(sqlTableGroups),
 		sqlPlaceholders[0])
@@ -409,15 +624,15 @@ func getDumpGroupsQuery() string {
 }
 
 func getAddGroupQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings)
-		VALUES (%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings,included_groups)
+		VALUES (%s,%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5])
 }
 
 func getUpdateGroupQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET description=%s,user_settings=%s,updated_at=%s
+	return fmt.Sprintf(`UPDATE %s SET description=%s,user_settings=%s,included_groups=%s,updated_at=%s
 		WHERE name = %s`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3])
+		sqlPlaceholders[3], sqlPlaceholders[4])
 }
 
 func getDeleteGroupQuery() string {
@@ -520,16 +735,22 @@ func getDumpAPIKeysQuery() string {
 }
 
 func getAddAPIKeyQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
+	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,filters)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
-		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10])
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11])
 }
 
 func getUpdateAPIKeyQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s
+	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s,filters=%s
 		WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8])
+}
+
+func getRotateAPIKeyQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET api_key=%s,updated_at=%s WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
 }
 
 func getDeleteAPIKeyQuery() string {
@@ -715,6 +936,11 @@ func getUpdateUserQuery(role string) string {
 		sqlPlaceholders[23], sqlPlaceholders[24])
 }
 
+func getUsersWithFiltersLikeQuery() string {
+	return fmt.Sprintf(`SELECT username,filters FROM %s WHERE filters LIKE %s AND deleted_at = 0`,
+		sqlTableUsers, sqlPlaceholders[0])
+}
+
 func getUpdateUserPasswordQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET password=%s WHERE username = %s`,
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -795,10 +1021,10 @@ func getClearGroupFolderMappingQuery() string {
 }
 
 func getAddGroupFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,group_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
-		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,user_quota_size,user_quota_files,folder_id,group_id)
+		VALUES (%s,%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
+		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlTableFolders, sqlPlaceholders[5], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[6])
 }
 
 func getClearUserFolderMappingQuery() string {
@@ -807,10 +1033,10 @@ func getClearUserFolderMappingQuery() string {
 }
 
 func getAddUserFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,user_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
-		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,user_quota_size,user_quota_files,folder_id,user_id)
+		VALUES (%s,%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
+		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlTableFolders, sqlPlaceholders[5], sqlTableUsers, sqlPlaceholders[6])
 }
 
 func getFoldersQuery(order string, minimal bool) string {
@@ -838,6 +1064,40 @@ func getQuotaFolderQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getUpdateUserFolderQuotaQuery(reset bool) string {
+	insertQuery := fmt.Sprintf(`INSERT INTO %s (user_id,folder_id,used_quota_size,used_quota_files,last_quota_update)
+		VALUES ((SELECT id FROM %s WHERE username = %s),(SELECT id FROM %s WHERE name = %s),%s,%s,%s)`,
+		sqlTableUsersFoldersQuota, sqlTableUsers, sqlPlaceholders[0], sqlTableFolders, sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+	if config.Driver == MySQLDataProviderName {
+		if reset {
+			return insertQuery + " ON DUPLICATE KEY UPDATE `used_quota_size`=VALUES(`used_quota_size`)," +
+				"`used_quota_files`=VALUES(`used_quota_files`),`last_quota_update`=VALUES(`last_quota_update`)"
+		}
+		return insertQuery + " ON DUPLICATE KEY UPDATE " +
+			"`used_quota_size`=CASE WHEN `used_quota_size` + VALUES(`used_quota_size`) < 0 THEN 0 " +
+			"ELSE `used_quota_size` + VALUES(`used_quota_size`) END," +
+			"`used_quota_files`=CASE WHEN `used_quota_files` + VALUES(`used_quota_files`) < 0 THEN 0 " +
+			"ELSE `used_quota_files` + VALUES(`used_quota_files`) END,`last_quota_update`=VALUES(`last_quota_update`)"
+	}
+	if reset {
+		return insertQuery + ` ON CONFLICT (user_id,folder_id) DO UPDATE SET used_quota_size=EXCLUDED.used_quota_size,
+		used_quota_files=EXCLUDED.used_quota_files,last_quota_update=EXCLUDED.last_quota_update`
+	}
+	return insertQuery + fmt.Sprintf(` ON CONFLICT (user_id,folder_id) DO UPDATE SET
+		used_quota_size=CASE WHEN %[1]s.used_quota_size + EXCLUDED.used_quota_size < 0 THEN 0
+		ELSE %[1]s.used_quota_size + EXCLUDED.used_quota_size END,
+		used_quota_files=CASE WHEN %[1]s.used_quota_files + EXCLUDED.used_quota_files < 0 THEN 0
+		ELSE %[1]s.used_quota_files + EXCLUDED.used_quota_files END,last_quota_update=EXCLUDED.last_quota_update`,
+		sqlTableUsersFoldersQuota)
+}
+
+func getUserFolderQuotaQuery() string {
+	return fmt.Sprintf(`SELECT q.used_quota_size,q.used_quota_files FROM %s q INNER JOIN %s u ON q.user_id = u.id
+		INNER JOIN %s f ON q.folder_id = f.id WHERE u.username = %s AND f.name = %s`, sqlTableUsersFoldersQuota,
+		sqlTableUsers, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getRelatedGroupsForUsersQuery(users []User) string {
 	var sb strings.Builder
 	for _, u := range users {
@@ -886,7 +1146,7 @@ func getRelatedFoldersForUsersQuery(users []User) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.user_quota_size,fm.user_quota_files,fm.user_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
 		fm.user_id IN %s ORDER BY fm.user_id`, sqlTableFolders, sqlTableUsersFoldersMapping, sb.String())
 }
 
@@ -973,7 +1233,7 @@ func getRelatedFoldersForGroupsQuery(groups []Group) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.group_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.user_quota_size,fm.user_quota_files,fm.group_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
 		fm.group_id IN %s ORDER BY fm.group_id`, sqlTableFolders, sqlTableGroupsFoldersMapping, sb.String())
 }
 
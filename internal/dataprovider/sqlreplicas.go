@@ -0,0 +1,160 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// sqlReplicaLagFunc returns the replication lag, in seconds, for the replica
+// connected using the provided handle
+type sqlReplicaLagFunc func(ctx context.Context, dbHandle *sql.DB) (int64, error)
+
+type sqlReplica struct {
+	idx       int
+	dbHandle  *sql.DB
+	isHealthy atomic.Bool
+}
+
+// sqlReplicas load balances read-only queries among the configured replicas.
+// A nil *sqlReplicas is valid and always returns the primary handle
+type sqlReplicas struct {
+	replicas []*sqlReplica
+	next     atomic.Uint32
+	getLag   sqlReplicaLagFunc
+}
+
+func newSQLReplicas(driverName string, getLag sqlReplicaLagFunc) (*sqlReplicas, error) {
+	if len(config.ReadReplicas) == 0 {
+		return nil, nil
+	}
+	r := &sqlReplicas{
+		getLag: getLag,
+	}
+	for idx, connectionString := range config.ReadReplicas {
+		dbHandle, err := sql.Open(driverName, connectionString)
+		if err != nil {
+			providerLog(logger.LevelError, "error creating database handle for read replica %d: %v", idx, err)
+			r.close()
+			return nil, fmt.Errorf("unable to create database handle for read replica %d: %w", idx, err)
+		}
+		dbHandle.SetMaxOpenConns(config.PoolSize)
+		if config.PoolSize > 0 {
+			dbHandle.SetMaxIdleConns(config.PoolSize)
+		} else {
+			dbHandle.SetMaxIdleConns(2)
+		}
+		dbHandle.SetConnMaxLifetime(240 * time.Second)
+		dbHandle.SetConnMaxIdleTime(120 * time.Second)
+		r.replicas = append(r.replicas, &sqlReplica{
+			idx:      idx,
+			dbHandle: dbHandle,
+		})
+	}
+	providerLog(logger.LevelDebug, "%d read replica handles created, max allowed lag: %d seconds",
+		len(r.replicas), config.MaxReplicaLag)
+	// replicas are used only after they are checked
+	go r.check()
+	return r, nil
+}
+
+// getHandle returns the handle for the next healthy replica or the primary
+// handle if no replica is healthy
+func (r *sqlReplicas) getHandle(primary *sql.DB) *sql.DB {
+	if r == nil {
+		return primary
+	}
+	numReplicas := uint32(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint32(0); i < numReplicas; i++ {
+		replica := r.replicas[(start+i)%numReplicas]
+		if replica.isHealthy.Load() {
+			return replica.dbHandle
+		}
+	}
+	return primary
+}
+
+// read executes fn using a healthy replica, if any. If the replica does not
+// find the requested object, fn is executed again using the primary: the
+// object could be recently added and not yet replicated
+func (r *sqlReplicas) read(primary *sql.DB, fn func(dbHandle *sql.DB) error) error {
+	dbHandle := r.getHandle(primary)
+	err := fn(dbHandle)
+	if dbHandle != primary && errors.Is(err, util.ErrNotFound) {
+		return fn(primary)
+	}
+	return err
+}
+
+// check updates the health status for all the replicas. A replica is
+// healthy if it is reachable and its replication lag is acceptable
+func (r *sqlReplicas) check() {
+	if r == nil {
+		return
+	}
+	for _, replica := range r.replicas {
+		err := r.checkReplica(replica)
+		if err != nil {
+			if replica.isHealthy.Swap(false) {
+				providerLog(logger.LevelWarn, "read replica %d is unhealthy, queries will use the primary: %v",
+					replica.idx, err)
+			}
+			continue
+		}
+		if !replica.isHealthy.Swap(true) {
+			providerLog(logger.LevelInfo, "read replica %d is healthy", replica.idx)
+		}
+	}
+}
+
+func (r *sqlReplicas) checkReplica(replica *sqlReplica) error {
+	if err := sqlCommonCheckAvailability(replica.dbHandle); err != nil {
+		return err
+	}
+	if config.MaxReplicaLag <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	lag, err := r.getLag(ctx, replica.dbHandle)
+	if err != nil {
+		return fmt.Errorf("unable to get replication lag: %w", err)
+	}
+	if lag > int64(config.MaxReplicaLag) {
+		return fmt.Errorf("replication lag %d seconds exceeds the allowed limit", lag)
+	}
+	return nil
+}
+
+func (r *sqlReplicas) close() {
+	if r == nil {
+		return
+	}
+	for _, replica := range r.replicas {
+		if err := replica.dbHandle.Close(); err != nil {
+			providerLog(logger.LevelWarn, "unable to close read replica %d: %v", replica.idx, err)
+		}
+	}
+}
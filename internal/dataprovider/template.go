@@ -0,0 +1,792 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported template types
+const (
+	TemplateTypeUser   = "user"
+	TemplateTypeFolder = "folder"
+)
+
+const (
+	maxTemplateInheritanceDepth = 10
+	templateApplyBatchSize      = 100
+)
+
+var (
+	supportedTemplateTypes = []string{TemplateTypeUser, TemplateTypeFolder}
+	// settings that identify an object or are updated at runtime, they cannot be defined in a template
+	templateForbiddenUserFields = []string{"id", "username", "password", "created_at", "updated_at", "last_login",
+		"used_quota_size", "used_quota_files", "last_quota_update", "used_upload_data_transfer",
+		"used_download_data_transfer", "first_download", "first_upload", "last_password_change"}
+	templateForbiddenFolderFields = []string{"id", "name", "used_quota_size", "used_quota_files", "last_quota_update",
+		"users", "groups"}
+)
+
+// TemplateReference identifies the template, and its version, applied to a user
+type TemplateReference struct {
+	Name    string `json:"name,omitempty"`
+	Version int    `json:"version,omitempty"`
+}
+
+// Template defines a user or folder template.
+// The settings are a partial user or folder definition, in JSON format,
+// merged over the ones inherited from the parent template, if any
+type Template struct {
+	// Data provider unique identifier
+	ID int64 `json:"id"`
+	// Template name
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// Template type: user or folder
+	Type string `json:"type"`
+	// Name of the parent template, of the same type, to inherit the settings from
+	Extends string `json:"extends,omitempty"`
+	// Template version, it is incremented each time the template is updated
+	Version int `json:"version"`
+	// Partial user or folder definition. The settings are merged over the inherited
+	// ones using the JSON merge patch semantics, a null value resets the inherited one.
+	// The %username% placeholder for users and the %name% placeholder for folders
+	// are replaced with the object name when the template is applied
+	Settings json.RawMessage `json:"settings"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// RenderAsJSON implements the renderer interface used within plugins
+func (t *Template) RenderAsJSON(reload bool) ([]byte, error) {
+	if reload {
+		template, err := provider.templateExists(t.Name)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to reload template before rendering as json: %v", err)
+			return nil, err
+		}
+		return json.Marshal(template)
+	}
+	return json.Marshal(t)
+}
+
+func (t *Template) validate() error {
+	if t.Name == "" {
+		return util.NewValidationError("name is mandatory")
+	}
+	if len(t.Name) > 255 {
+		return util.NewValidationError("name is too long, 255 is the maximum length allowed")
+	}
+	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(t.Name) {
+		return util.NewValidationError(fmt.Sprintf("name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", t.Name))
+	}
+	if !util.Contains(supportedTemplateTypes, t.Type) {
+		return util.NewValidationError(fmt.Sprintf("invalid template type %q", t.Type))
+	}
+	if t.Extends == t.Name {
+		return util.NewValidationError("a template cannot extend itself")
+	}
+	if len(bytes.TrimSpace(t.Settings)) == 0 || bytes.Equal(bytes.TrimSpace(t.Settings), []byte("null")) {
+		t.Settings = json.RawMessage("{}")
+	}
+	settings, err := t.getSettings()
+	if err != nil {
+		return err
+	}
+	forbiddenFields := templateForbiddenUserFields
+	if t.Type == TemplateTypeFolder {
+		forbiddenFields = templateForbiddenFolderFields
+	}
+	for _, field := range forbiddenFields {
+		if _, ok := settings[field]; ok {
+			return util.NewValidationError(fmt.Sprintf("the field %q cannot be defined in a template", field))
+		}
+	}
+	if filters, ok := settings["filters"].(map[string]any); ok {
+		if _, ok := filters["template"]; ok {
+			return util.NewValidationError("the field \"filters.template\" cannot be defined in a template")
+		}
+	}
+	return validateTemplateSettings(t.Type, settings)
+}
+
+func (t *Template) getSettings() (map[string]any, error) {
+	settings := make(map[string]any)
+	if len(t.Settings) == 0 {
+		return settings, nil
+	}
+	if err := json.Unmarshal(t.Settings, &settings); err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("template settings must be a JSON object: %v", err))
+	}
+	return settings, nil
+}
+
+func (t *Template) getACopy() Template {
+	settings := make(json.RawMessage, len(t.Settings))
+	copy(settings, t.Settings)
+
+	return Template{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		Type:        t.Type,
+		Extends:     t.Extends,
+		Version:     t.Version,
+		Settings:    settings,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
+
+// TemplateChange defines a field that differs from the template value
+type TemplateChange struct {
+	Field    string `json:"field"`
+	Current  any    `json:"current"`
+	Template any    `json:"template"`
+}
+
+// TemplateDrift defines the differences between an object and the template applied to it
+type TemplateDrift struct {
+	// Name of the user or folder
+	Name string `json:"name"`
+	// Name of the applied template, it could be a descendant of the requested one
+	Template string `json:"template"`
+	// Template version recorded for the object when the template was last applied
+	AppliedVersion int `json:"applied_version,omitempty"`
+	// Current template version
+	Version int              `json:"version"`
+	Changes []TemplateChange `json:"changes,omitempty"`
+	// true if the template was applied to the object
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TemplateExists returns the template with the given name if it exists
+func TemplateExists(name string) (Template, error) {
+	name = config.convertName(name)
+	return provider.templateExists(name)
+}
+
+// GetTemplates returns an array of templates respecting limit and offset
+func GetTemplates(limit, offset int, order string) ([]Template, error) {
+	return provider.getTemplates(limit, offset, order)
+}
+
+// AddTemplate adds a new template
+func AddTemplate(template *Template, executor, ipAddress, role string) error {
+	template.Name = config.convertName(template.Name)
+	template.Extends = config.convertName(template.Extends)
+	if err := validateTemplateInheritance(template); err != nil {
+		return err
+	}
+	err := provider.addTemplate(template)
+	if err == nil {
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectTemplate, template.Name, role, nil, template)
+	}
+	return err
+}
+
+// UpdateTemplate updates an existing template, the version is incremented
+func UpdateTemplate(template *Template, executor, ipAddress, role string) error {
+	template.Extends = config.convertName(template.Extends)
+	if err := validateTemplateInheritance(template); err != nil {
+		return err
+	}
+	before := getAuditLogSnapshot(template)
+	err := provider.updateTemplate(template)
+	if err == nil {
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectTemplate, template.Name, role, before, template)
+	}
+	return err
+}
+
+// DeleteTemplate deletes an existing template, templates extended by other
+// templates cannot be removed
+func DeleteTemplate(name, executor, ipAddress, role string) error {
+	name = config.convertName(name)
+	template, err := provider.templateExists(name)
+	if err != nil {
+		return err
+	}
+	templates, err := provider.dumpTemplates()
+	if err != nil {
+		return err
+	}
+	for _, t := range templates {
+		if t.Extends == template.Name {
+			return util.NewValidationError(fmt.Sprintf("the template %q is extended by %q, it cannot be removed",
+				template.Name, t.Name))
+		}
+	}
+	before := getAuditLogSnapshot(&template)
+	err = provider.deleteTemplate(template)
+	if err == nil {
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectTemplate, template.Name, role, before, &template)
+	}
+	return err
+}
+
+// ResolveTemplate returns the template with the given name and the settings
+// merged with the ones inherited from its ancestors
+func ResolveTemplate(name string) (Template, error) {
+	template, err := TemplateExists(name)
+	if err != nil {
+		return template, err
+	}
+	settings, err := resolveTemplateSettings(&template)
+	if err != nil {
+		return template, err
+	}
+	template.Settings, err = json.Marshal(settings)
+	return template, err
+}
+
+// ApplyTemplateToUser merges the settings of the template referenced in the user
+// filters into the user. The template settings override the user ones
+func ApplyTemplateToUser(user *User) error {
+	template, err := TemplateExists(user.Filters.Template.Name)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return util.NewValidationError(fmt.Sprintf("template %q does not exist", user.Filters.Template.Name))
+		}
+		return err
+	}
+	if template.Type != TemplateTypeUser {
+		return util.NewValidationError(fmt.Sprintf("template %q is not a user template", template.Name))
+	}
+	settings, err := resolveTemplateSettings(&template)
+	if err != nil {
+		return err
+	}
+	var updated User
+	if err := mergeTemplateIntoObject(user, settings, "%username%", user.Username, &updated); err != nil {
+		return err
+	}
+	updated.Filters.Template = TemplateReference{
+		Name:    template.Name,
+		Version: template.Version,
+	}
+	*user = updated
+	return nil
+}
+
+// GetPatchedUser returns a copy of the given user with the specified JSON merge
+// patch (RFC 7386) applied. The returned user is not validated
+func GetPatchedUser(user *User, patch map[string]any) (User, error) {
+	var patched User
+	current, err := toTemplateMap(user)
+	if err != nil {
+		return patched, err
+	}
+	data, err := json.Marshal(mergeTemplateSettings(current, patch, false))
+	if err != nil {
+		return patched, err
+	}
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return patched, util.NewValidationError(fmt.Sprintf("invalid user settings: %v", err))
+	}
+	return patched, nil
+}
+
+// ApplyTemplate re-applies the template with the given name, and its descendants,
+// to the specified users or folders and returns the detected drift.
+// If no user is specified, the template is applied to all the users that reference
+// it or one of its descendants. Folders must be always specified.
+// If dryRun is true, the drift is reported but the objects are not updated
+func ApplyTemplate(name string, names []string, dryRun bool, executor, ipAddress, role string) ([]TemplateDrift, error) {
+	template, err := TemplateExists(name)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := getTemplateFamily(&template)
+	if err != nil {
+		return nil, err
+	}
+	if template.Type == TemplateTypeFolder {
+		if len(names) == 0 {
+			return nil, util.NewValidationError("the folders to apply the template to are required")
+		}
+		return applyTemplateToFolders(&template, names, dryRun, executor, ipAddress, role)
+	}
+	return applyTemplateToUsers(&template, templates, names, dryRun, executor, ipAddress, role)
+}
+
+// SortTemplatesByInheritance returns the templates sorted so that each template
+// follows its parent, if included
+func SortTemplatesByInheritance(templates []Template) []Template {
+	sorted := make([]Template, 0, len(templates))
+	added := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, t := range templates {
+		names[t.Name] = true
+	}
+	for len(sorted) < len(templates) {
+		progress := false
+		for _, t := range templates {
+			if added[t.Name] {
+				continue
+			}
+			if t.Extends == "" || added[t.Extends] || !names[t.Extends] {
+				sorted = append(sorted, t)
+				added[t.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			// inheritance cycle, should never happen, append the remaining ones as is
+			for _, t := range templates {
+				if !added[t.Name] {
+					sorted = append(sorted, t)
+					added[t.Name] = true
+				}
+			}
+		}
+	}
+	return sorted
+}
+
+func applyTemplateToUsers(requested *Template, templates map[string]Template, usernames []string, dryRun bool, executor, ipAddress,
+	role string,
+) ([]TemplateDrift, error) {
+	results := make([]TemplateDrift, 0, len(usernames))
+	resolved := make(map[string]map[string]any)
+	apply := func(user *User, template Template) {
+		settings, ok := resolved[template.Name]
+		if !ok {
+			var err error
+			settings, err = resolveTemplateSettings(&template)
+			if err != nil {
+				results = append(results, TemplateDrift{Name: user.Username, Template: template.Name, Error: err.Error()})
+				return
+			}
+			resolved[template.Name] = settings
+		}
+		results = append(results, applyTemplateToUser(user, &template, settings, dryRun, executor, ipAddress, role))
+	}
+
+	if len(usernames) > 0 {
+		for _, username := range usernames {
+			user, err := UserExists(username, role)
+			if err != nil {
+				results = append(results, TemplateDrift{Name: username, Error: err.Error()})
+				continue
+			}
+			template, ok := templates[user.Filters.Template.Name]
+			if !ok {
+				// the user does not reference the template or one of its descendants
+				template = *requested
+			}
+			apply(&user, template)
+		}
+		return results, nil
+	}
+
+	offset := 0
+	for {
+		users, err := provider.getUsers(templateApplyBatchSize, offset, OrderASC, role)
+		if err != nil {
+			return results, err
+		}
+		for idx := range users {
+			if template, ok := templates[users[idx].Filters.Template.Name]; ok {
+				user, err := UserExists(users[idx].Username, role)
+				if err != nil {
+					results = append(results, TemplateDrift{Name: users[idx].Username, Error: err.Error()})
+					continue
+				}
+				apply(&user, template)
+			}
+		}
+		if len(users) < templateApplyBatchSize {
+			break
+		}
+		offset += len(users)
+	}
+	return results, nil
+}
+
+func applyTemplateToUser(user *User, template *Template, settings map[string]any, dryRun bool, executor,
+	ipAddress, role string,
+) TemplateDrift {
+	result := TemplateDrift{
+		Name:           user.Username,
+		Template:       template.Name,
+		AppliedVersion: user.Filters.Template.Version,
+		Version:        template.Version,
+	}
+	if user.Filters.Template.Name != template.Name {
+		result.AppliedVersion = 0
+	}
+	current, err := toTemplateMap(user)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var updated User
+	if err := mergeTemplateIntoObject(user, settings, "%username%", user.Username, &updated); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	desired, err := toTemplateMap(&updated)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Changes = getTemplateChanges("", settings, current, desired)
+	if dryRun || (len(result.Changes) == 0 && result.AppliedVersion == template.Version) {
+		return result
+	}
+	updated.Filters.Template = TemplateReference{
+		Name:    template.Name,
+		Version: template.Version,
+	}
+	if err := UpdateUser(&updated, executor, ipAddress, role); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Applied = true
+	return result
+}
+
+func applyTemplateToFolders(template *Template, names []string, dryRun bool, executor, ipAddress, role string,
+) ([]TemplateDrift, error) {
+	settings, err := resolveTemplateSettings(template)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]TemplateDrift, 0, len(names))
+	for _, name := range names {
+		result := TemplateDrift{
+			Name:     name,
+			Template: template.Name,
+			Version:  template.Version,
+		}
+		folder, err := GetFolderByName(name)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		current, err := toTemplateMap(&folder)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		var updated vfs.BaseVirtualFolder
+		if err := mergeTemplateIntoObject(&folder, settings, "%name%", folder.Name, &updated); err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		desired, err := toTemplateMap(&updated)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Changes = getTemplateChanges("", settings, current, desired)
+		if !dryRun && len(result.Changes) > 0 {
+			err = UpdateFolder(&updated, folder.Users, folder.Groups, executor, ipAddress, role)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Applied = true
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// getTemplateFamily returns the specified template and all its descendants
+func getTemplateFamily(template *Template) (map[string]Template, error) {
+	templates, err := provider.dumpTemplates()
+	if err != nil {
+		return nil, err
+	}
+	family := map[string]Template{
+		template.Name: template.getACopy(),
+	}
+	for {
+		added := false
+		for _, t := range templates {
+			if _, ok := family[t.Name]; ok {
+				continue
+			}
+			if _, ok := family[t.Extends]; ok && t.Extends != "" {
+				family[t.Name] = t
+				added = true
+			}
+		}
+		if !added {
+			return family, nil
+		}
+	}
+}
+
+func validateTemplateInheritance(template *Template) error {
+	if template.Extends == "" {
+		return nil
+	}
+	ancestors := []string{template.Name}
+	parentName := template.Extends
+	for parentName != "" {
+		if util.Contains(ancestors, parentName) {
+			return util.NewValidationError(fmt.Sprintf("template inheritance cycle detected: %s -> %s",
+				strings.Join(ancestors, " -> "), parentName))
+		}
+		if len(ancestors) > maxTemplateInheritanceDepth {
+			return util.NewValidationError(fmt.Sprintf("too many inheritance levels, the maximum allowed is %d",
+				maxTemplateInheritanceDepth))
+		}
+		parent, err := provider.templateExists(parentName)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				return util.NewValidationError(fmt.Sprintf("parent template %q does not exist", parentName))
+			}
+			return err
+		}
+		if parent.Type != template.Type {
+			return util.NewValidationError(fmt.Sprintf("parent template %q has type %q, expected %q",
+				parent.Name, parent.Type, template.Type))
+		}
+		ancestors = append(ancestors, parentName)
+		parentName = parent.Extends
+	}
+	return nil
+}
+
+// resolveTemplateSettings merges the template settings with the ones inherited
+// from its ancestors, the settings defined in the template have precedence
+func resolveTemplateSettings(template *Template) (map[string]any, error) {
+	chain := []Template{template.getACopy()}
+	parentName := template.Extends
+	for parentName != "" {
+		if len(chain) > maxTemplateInheritanceDepth {
+			return nil, util.NewValidationError(fmt.Sprintf("too many inheritance levels for template %q", template.Name))
+		}
+		parent, err := provider.templateExists(parentName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get parent template %q: %w", parentName, err)
+		}
+		chain = append(chain, parent)
+		parentName = parent.Extends
+	}
+	resolved := make(map[string]any)
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		settings, err := chain[idx].getSettings()
+		if err != nil {
+			return nil, err
+		}
+		resolved = mergeTemplateSettings(resolved, settings, false)
+	}
+	return resolved, nil
+}
+
+// mergeTemplateSettings applies patch to target using the JSON merge patch semantics
+// (RFC 7386). If keepNull is true, the null values are preserved
+func mergeTemplateSettings(target, patch map[string]any, keepNull bool) map[string]any {
+	for k, v := range patch {
+		if v == nil {
+			if keepNull {
+				target[k] = nil
+			} else {
+				delete(target, k)
+			}
+			continue
+		}
+		if patchMap, ok := v.(map[string]any); ok {
+			targetMap, ok := target[k].(map[string]any)
+			if !ok {
+				targetMap = make(map[string]any)
+			}
+			target[k] = mergeTemplateSettings(targetMap, patchMap, keepNull)
+			continue
+		}
+		target[k] = v
+	}
+	return target
+}
+
+// mergeTemplateIntoObject merges the template settings into the specified object,
+// replaces the placeholders and unmarshals the result into dst
+func mergeTemplateIntoObject(object any, settings map[string]any, placeholder, name string, dst any) error {
+	current, err := toTemplateMap(object)
+	if err != nil {
+		return err
+	}
+	merged := mergeTemplateSettings(current, replaceTemplatePlaceholder(settings, placeholder, name).(map[string]any),
+		false)
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return util.NewValidationError(fmt.Sprintf("unable to apply template settings: %v", err))
+	}
+	return nil
+}
+
+func replaceTemplatePlaceholder(value any, placeholder, name string) any {
+	switch v := value.(type) {
+	case string:
+		return strings.ReplaceAll(v, placeholder, name)
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, val := range v {
+			result[key] = replaceTemplatePlaceholder(val, placeholder, name)
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, val := range v {
+			result = append(result, replaceTemplatePlaceholder(val, placeholder, name))
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// getTemplateChanges returns the fields defined in the template settings whose
+// current value differs from the desired one
+func getTemplateChanges(prefix string, settings, current, desired map[string]any) []TemplateChange {
+	var changes []TemplateChange
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		field := k
+		if prefix != "" {
+			field = prefix + "." + k
+		}
+		if settingsMap, ok := settings[k].(map[string]any); ok {
+			currentMap, currentOk := current[k].(map[string]any)
+			desiredMap, desiredOk := desired[k].(map[string]any)
+			if currentOk && desiredOk {
+				changes = append(changes, getTemplateChanges(field, settingsMap, currentMap, desiredMap)...)
+				continue
+			}
+		}
+		currentValue := normalizeTemplateValue(field, current[k])
+		desiredValue := normalizeTemplateValue(field, desired[k])
+		if !reflect.DeepEqual(currentValue, desiredValue) {
+			changes = append(changes, TemplateChange{
+				Field:    field,
+				Current:  currentValue,
+				Template: desiredValue,
+			})
+		}
+	}
+	return changes
+}
+
+// normalizeTemplateValue removes the fields not defined in the user when
+// comparing virtual folders and converts empty values to nil
+func normalizeTemplateValue(field string, value any) any {
+	if field == "virtual_folders" {
+		folders, ok := value.([]any)
+		if !ok {
+			return nil
+		}
+		result := make([]any, 0, len(folders))
+		for _, f := range folders {
+			if folder, ok := f.(map[string]any); ok {
+				result = append(result, map[string]any{
+					"name":         folder["name"],
+					"virtual_path": folder["virtual_path"],
+					"quota_size":   folder["quota_size"],
+					"quota_files":  folder["quota_files"],
+				})
+			}
+		}
+		value = result
+	}
+	switch v := value.(type) {
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return nil
+		}
+	case string:
+		if v == "" {
+			return nil
+		}
+	}
+	return value
+}
+
+func toTemplateMap(object any) (map[string]any, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]any)
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func validateTemplateSettings(templateType string, settings map[string]any) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	var fsConfig vfs.Filesystem
+	switch templateType {
+	case TemplateTypeUser:
+		var user User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid user template settings: %v", err))
+		}
+		fsConfig = user.FsConfig
+	default:
+		var folder vfs.BaseVirtualFolder
+		if err := json.Unmarshal(data, &folder); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid folder template settings: %v", err))
+		}
+		fsConfig = folder.FsConfig
+	}
+	secrets := []*kms.Secret{fsConfig.S3Config.AccessSecret, fsConfig.GCSConfig.Credentials,
+		fsConfig.AzBlobConfig.AccountKey, fsConfig.AzBlobConfig.SASURL, fsConfig.CryptConfig.Passphrase,
+		fsConfig.SFTPConfig.Password, fsConfig.SFTPConfig.PrivateKey, fsConfig.SFTPConfig.KeyPassphrase,
+		fsConfig.HTTPConfig.Password, fsConfig.HTTPConfig.APIKey, fsConfig.HTTPConfig.OAuth2.ClientSecret}
+	for _, secret := range secrets {
+		if secret != nil && !secret.IsEmpty() {
+			return util.NewValidationError("secrets cannot be defined in a template, set them for each object")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,625 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported tenant object types
+const (
+	TenantObjectRole      = actionObjectRole
+	TenantObjectGroup     = actionObjectGroup
+	TenantObjectFolder    = actionObjectFolder
+	TenantObjectEventRule = actionObjectEventRule
+)
+
+// TenantBranding defines the WebClient customizations for the users of a tenant.
+// Empty values mean the binding configuration is used
+type TenantBranding struct {
+	// Name defines the text to show as HTML title
+	Name string `json:"name,omitempty"`
+	// ShortName defines the name to show next to the logo image
+	ShortName string `json:"short_name,omitempty"`
+	// Path to the logo relative to the configured static files path
+	LogoPath string `json:"logo_path,omitempty"`
+	// Path to the favicon relative to the configured static files path
+	FaviconPath string `json:"favicon_path,omitempty"`
+}
+
+func (b *TenantBranding) validate() {
+	b.Name = strings.TrimSpace(b.Name)
+	b.ShortName = strings.TrimSpace(b.ShortName)
+	if b.LogoPath != "" {
+		b.LogoPath = util.CleanPath(b.LogoPath)
+	}
+	if b.FaviconPath != "" {
+		b.FaviconPath = util.CleanPath(b.FaviconPath)
+	}
+}
+
+// TenantMembers defines the objects that belong to a tenant
+type TenantMembers struct {
+	// Users with one of these roles belong to the tenant
+	Roles      []string `json:"roles,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Folders    []string `json:"folders,omitempty"`
+	EventRules []string `json:"event_rules,omitempty"`
+}
+
+func (m *TenantMembers) validate() {
+	m.Roles = util.RemoveDuplicates(m.Roles, true)
+	m.Groups = util.RemoveDuplicates(m.Groups, true)
+	m.Folders = util.RemoveDuplicates(m.Folders, true)
+	m.EventRules = util.RemoveDuplicates(m.EventRules, true)
+}
+
+func (m *TenantMembers) getACopy() TenantMembers {
+	roles := make([]string, len(m.Roles))
+	copy(roles, m.Roles)
+	groups := make([]string, len(m.Groups))
+	copy(groups, m.Groups)
+	folders := make([]string, len(m.Folders))
+	copy(folders, m.Folders)
+	rules := make([]string, len(m.EventRules))
+	copy(rules, m.EventRules)
+
+	return TenantMembers{
+		Roles:      roles,
+		Groups:     groups,
+		Folders:    folders,
+		EventRules: rules,
+	}
+}
+
+func (m *TenantMembers) getList(objectType string) []string {
+	switch objectType {
+	case actionObjectRole:
+		return m.Roles
+	case actionObjectGroup:
+		return m.Groups
+	case actionObjectFolder:
+		return m.Folders
+	case actionObjectEventRule:
+		return m.EventRules
+	default:
+		return nil
+	}
+}
+
+func (m *TenantMembers) setList(objectType string, names []string) {
+	switch objectType {
+	case actionObjectRole:
+		m.Roles = names
+	case actionObjectGroup:
+		m.Groups = names
+	case actionObjectFolder:
+		m.Folders = names
+	case actionObjectEventRule:
+		m.EventRules = names
+	}
+}
+
+// Tenant defines an isolated set of roles, groups, folders and event rules
+// managed by dedicated administrators
+type Tenant struct {
+	// Data provider unique identifier
+	ID int64 `json:"id"`
+	// Tenant name
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// Objects belonging to the tenant
+	Members TenantMembers `json:"members"`
+	// Maximum size, as bytes, that can be allocated to the tenant users. 0 means unlimited
+	QuotaSize int64 `json:"quota_size,omitempty"`
+	// Maximum number of files that can be allocated to the tenant users. 0 means unlimited
+	QuotaFiles int `json:"quota_files,omitempty"`
+	// Maximum number of concurrent sessions for the tenant users. 0 means unlimited
+	MaxSessions int `json:"max_sessions,omitempty"`
+	// WebClient branding for the tenant users
+	Branding TenantBranding `json:"branding"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// RenderAsJSON implements the renderer interface used within plugins
+func (t *Tenant) RenderAsJSON(reload bool) ([]byte, error) {
+	if reload {
+		tenant, err := provider.tenantExists(t.Name)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to reload tenant before rendering as json: %v", err)
+			return nil, err
+		}
+		return json.Marshal(tenant)
+	}
+	return json.Marshal(t)
+}
+
+func (t *Tenant) validate() error {
+	if t.Name == "" {
+		return util.NewValidationError("name is mandatory")
+	}
+	if len(t.Name) > 255 {
+		return util.NewValidationError("name is too long, 255 is the maximum length allowed")
+	}
+	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(t.Name) {
+		return util.NewValidationError(fmt.Sprintf("name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", t.Name))
+	}
+	if t.QuotaSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid quota size: %d", t.QuotaSize))
+	}
+	if t.QuotaFiles < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid quota files: %d", t.QuotaFiles))
+	}
+	if t.MaxSessions < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max sessions: %d", t.MaxSessions))
+	}
+	t.Members.validate()
+	t.Branding.validate()
+	return nil
+}
+
+func (t *Tenant) getACopy() Tenant {
+	return Tenant{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		Members:     t.Members.getACopy(),
+		QuotaSize:   t.QuotaSize,
+		QuotaFiles:  t.QuotaFiles,
+		MaxSessions: t.MaxSessions,
+		Branding:    t.Branding,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
+
+// HasRole returns true if the specified role belongs to the tenant
+func (t *Tenant) HasRole(role string) bool {
+	return role != "" && util.Contains(t.Members.Roles, role)
+}
+
+// HasGroup returns true if the specified group belongs to the tenant
+func (t *Tenant) HasGroup(name string) bool {
+	return util.Contains(t.Members.Groups, name)
+}
+
+// HasFolder returns true if the specified folder belongs to the tenant
+func (t *Tenant) HasFolder(name string) bool {
+	return util.Contains(t.Members.Folders, name)
+}
+
+// HasEventRule returns true if the specified event rule belongs to the tenant
+func (t *Tenant) HasEventRule(name string) bool {
+	return util.Contains(t.Members.EventRules, name)
+}
+
+// HasMember returns true if the object with the specified type and name belongs to the tenant
+func (t *Tenant) HasMember(objectType, name string) bool {
+	if name == "" {
+		return false
+	}
+	return util.Contains(t.Members.getList(objectType), name)
+}
+
+// GetMembersAsString returns a string representation for the tenant members
+func (t *Tenant) GetMembersAsString() string {
+	var sb strings.Builder
+	if len(t.Members.Roles) > 0 {
+		sb.WriteString(fmt.Sprintf("Roles: %d. ", len(t.Members.Roles)))
+	}
+	if len(t.Members.Groups) > 0 {
+		sb.WriteString(fmt.Sprintf("Groups: %d. ", len(t.Members.Groups)))
+	}
+	if len(t.Members.Folders) > 0 {
+		sb.WriteString(fmt.Sprintf("Folders: %d. ", len(t.Members.Folders)))
+	}
+	if len(t.Members.EventRules) > 0 {
+		sb.WriteString(fmt.Sprintf("Event rules: %d. ", len(t.Members.EventRules)))
+	}
+	return sb.String()
+}
+
+// GetLimitsAsString returns a string representation for the tenant limits
+func (t *Tenant) GetLimitsAsString() string {
+	var result []string
+	if t.QuotaSize > 0 {
+		result = append(result, fmt.Sprintf("Size: %s", util.ByteCountIEC(t.QuotaSize)))
+	}
+	if t.QuotaFiles > 0 {
+		result = append(result, fmt.Sprintf("Files: %d", t.QuotaFiles))
+	}
+	if t.MaxSessions > 0 {
+		result = append(result, fmt.Sprintf("Sessions: %d", t.MaxSessions))
+	}
+	return strings.Join(result, ". ")
+}
+
+// addMember adds the object with the specified type and name to the tenant
+func (t *Tenant) addMember(objectType, name string) {
+	names := t.Members.getList(objectType)
+	if !util.Contains(names, name) {
+		t.Members.setList(objectType, append(names, name))
+	}
+}
+
+// TenantExists returns the tenant with the given name if it exists
+func TenantExists(name string) (Tenant, error) {
+	name = config.convertName(name)
+	return provider.tenantExists(name)
+}
+
+// GetTenants returns an array of tenants respecting limit and offset
+func GetTenants(limit, offset int, order string) ([]Tenant, error) {
+	return provider.getTenants(limit, offset, order)
+}
+
+// AddTenant adds a new tenant
+func AddTenant(tenant *Tenant, executor, ipAddress, role string) error {
+	tenant.Name = config.convertName(tenant.Name)
+	if err := validateTenantMembers(tenant); err != nil {
+		return err
+	}
+	err := provider.addTenant(tenant)
+	if err == nil {
+		executeAuditedAction(operationAdd, executor, ipAddress, actionObjectTenant, tenant.Name, role, nil, tenant)
+	}
+	return err
+}
+
+// UpdateTenant updates an existing tenant
+func UpdateTenant(tenant *Tenant, executor, ipAddress, role string) error {
+	if err := validateTenantMembers(tenant); err != nil {
+		return err
+	}
+	before := getAuditLogSnapshot(tenant)
+	err := provider.updateTenant(tenant)
+	if err == nil {
+		executeAuditedAction(operationUpdate, executor, ipAddress, actionObjectTenant, tenant.Name, role, before, tenant)
+	}
+	return err
+}
+
+// DeleteTenant deletes an existing tenant, tenants with associated admins cannot be removed
+func DeleteTenant(name, executor, ipAddress, role string) error {
+	name = config.convertName(name)
+	tenant, err := provider.tenantExists(name)
+	if err != nil {
+		return err
+	}
+	admins, err := provider.dumpAdmins()
+	if err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if admin.Filters.Tenant == tenant.Name {
+			return util.NewValidationError(fmt.Sprintf("the tenant %q is referenced, it cannot be removed", tenant.Name))
+		}
+	}
+	before := getAuditLogSnapshot(&tenant)
+	err = provider.deleteTenant(tenant)
+	if err == nil {
+		executeAuditedAction(operationDelete, executor, ipAddress, actionObjectTenant, tenant.Name, role, before, &tenant)
+	}
+	return err
+}
+
+// AddToTenant adds the object with the specified type and name to the given tenant.
+// Supported object types are "group", "folder" and "event_rule"
+func AddToTenant(tenantName, objectType, objectName, executor, ipAddress string) error {
+	tenant, err := TenantExists(tenantName)
+	if err != nil {
+		return err
+	}
+	if !util.Contains([]string{actionObjectGroup, actionObjectFolder, actionObjectEventRule}, objectType) {
+		return util.NewValidationError(fmt.Sprintf("unsupported tenant object type %q", objectType))
+	}
+	if util.Contains(tenant.Members.getList(objectType), objectName) {
+		return nil
+	}
+	tenant.addMember(objectType, objectName)
+	return UpdateTenant(&tenant, executor, ipAddress, "")
+}
+
+// GetTenantForRole returns the tenant the specified role belongs to, if any
+func GetTenantForRole(role string) (Tenant, bool) {
+	if role == "" {
+		return Tenant{}, false
+	}
+	return getTenantForObject(actionObjectRole, role)
+}
+
+// GetTenantForObject returns the tenant the object with the specified type and name
+// belongs to, if any. Supported object types are "group", "folder" and "event_rule"
+func GetTenantForObject(objectType, name string) (Tenant, bool) {
+	return getTenantForObject(objectType, name)
+}
+
+func getTenantForObject(objectType, name string) (Tenant, bool) {
+	tenants, err := provider.dumpTenants()
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get tenants: %v", err)
+		return Tenant{}, false
+	}
+	for _, tenant := range tenants {
+		if util.Contains(tenant.Members.getList(objectType), name) {
+			return tenant, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// GetTenantUsers returns the users of the specified tenant respecting limit and offset
+func GetTenantUsers(tenant *Tenant, limit, offset int, order string) ([]User, error) {
+	var usernames []string
+	for _, roleName := range tenant.Members.Roles {
+		role, err := provider.roleExists(roleName)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		usernames = append(usernames, role.Users...)
+	}
+	users := make([]User, 0, limit)
+	for _, username := range getTenantPage(usernames, limit, offset, order) {
+		user, err := provider.userExists(username, "")
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		user.PrepareForRendering()
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// GetTenantGroups returns the groups of the specified tenant respecting limit and offset
+func GetTenantGroups(tenant *Tenant, limit, offset int, order string) ([]Group, error) {
+	groups := make([]Group, 0, limit)
+	for _, name := range getTenantPage(tenant.Members.Groups, limit, offset, order) {
+		group, err := provider.groupExists(name)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		group.PrepareForRendering()
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// GetTenantFolders returns the folders of the specified tenant respecting limit and offset
+func GetTenantFolders(tenant *Tenant, limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	for _, name := range getTenantPage(tenant.Members.Folders, limit, offset, order) {
+		folder, err := provider.getFolderByName(name)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		folder.PrepareForRendering()
+		folders = append(folders, folder)
+	}
+	return folders, nil
+}
+
+// GetTenantEventRules returns the event rules of the specified tenant respecting limit and offset
+func GetTenantEventRules(tenant *Tenant, limit, offset int, order string) ([]EventRule, error) {
+	rules := make([]EventRule, 0, limit)
+	for _, name := range getTenantPage(tenant.Members.EventRules, limit, offset, order) {
+		rule, err := provider.eventRuleExists(name)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		rule.PrepareForRendering()
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// getTenantPage returns the sorted names respecting limit and offset
+func getTenantPage(names []string, limit, offset int, order string) []string {
+	if limit <= 0 || offset >= len(names) {
+		return nil
+	}
+	sorted := util.RemoveDuplicates(append([]string(nil), names...), false)
+	if order == OrderDESC {
+		sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	} else {
+		sort.Strings(sorted)
+	}
+	if offset >= len(sorted) {
+		return nil
+	}
+	end := offset + limit
+	if end > len(sorted) {
+		end = len(sorted)
+	}
+	return sorted[offset:end]
+}
+
+// validateTenantMembers checks that the tenant members do not belong to other tenants
+func validateTenantMembers(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	if err := checkTenantMembersExist(&tenant.Members); err != nil {
+		return err
+	}
+	tenants, err := provider.dumpTenants()
+	if err != nil {
+		return err
+	}
+	for _, t := range tenants {
+		if t.Name == tenant.Name {
+			continue
+		}
+		for _, objectType := range []string{actionObjectRole, actionObjectGroup, actionObjectFolder, actionObjectEventRule} {
+			for _, name := range tenant.Members.getList(objectType) {
+				if util.Contains(t.Members.getList(objectType), name) {
+					return util.NewValidationError(fmt.Sprintf("%s %q already belongs to the tenant %q",
+						strings.ReplaceAll(objectType, "_", " "), name, t.Name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkTenantMembersExist(members *TenantMembers) error {
+	check := func(objectType string, exists func(string) error) error {
+		for _, name := range members.getList(objectType) {
+			if err := exists(name); err != nil {
+				if errors.Is(err, util.ErrNotFound) {
+					return util.NewValidationError(fmt.Sprintf("%s %q does not exist",
+						strings.ReplaceAll(objectType, "_", " "), name))
+				}
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(actionObjectRole, func(name string) error {
+		_, err := provider.roleExists(name)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := check(actionObjectGroup, func(name string) error {
+		_, err := provider.groupExists(name)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := check(actionObjectFolder, func(name string) error {
+		_, err := provider.getFolderByName(name)
+		return err
+	}); err != nil {
+		return err
+	}
+	return check(actionObjectEventRule, func(name string) error {
+		_, err := provider.eventRuleExists(name)
+		return err
+	})
+}
+
+// validateAdminTenant checks that the tenant, the admin belongs to, exists and that
+// the admin role and groups belong to the same tenant
+func validateAdminTenant(admin *Admin) error {
+	if admin.Filters.Tenant == "" {
+		return nil
+	}
+	tenant, err := provider.tenantExists(admin.Filters.Tenant)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return util.NewValidationError(fmt.Sprintf("tenant %q does not exist", admin.Filters.Tenant))
+		}
+		return err
+	}
+	if admin.Role != "" && !tenant.HasRole(admin.Role) {
+		return util.NewValidationError(fmt.Sprintf("role %q does not belong to the tenant %q", admin.Role, tenant.Name))
+	}
+	for _, g := range admin.Groups {
+		if !tenant.HasGroup(g.Name) {
+			return util.NewValidationError(fmt.Sprintf("group %q does not belong to the tenant %q", g.Name, tenant.Name))
+		}
+	}
+	return nil
+}
+
+// removeFromTenants removes the deleted object from the tenant it belongs to, if any
+func removeFromTenants(objectType, name string) {
+	tenant, ok := getTenantForObject(objectType, name)
+	if !ok {
+		return
+	}
+	tenant.Members.setList(objectType, util.Remove(tenant.Members.getList(objectType), name))
+	if err := provider.updateTenant(&tenant); err != nil {
+		providerLog(logger.LevelError, "unable to remove %s %q from tenant %q: %v", objectType, name, tenant.Name, err)
+	}
+}
+
+// checkTenantLimits checks that the quota allocated to the users of the tenant,
+// the specified user belongs to, does not exceed the tenant limits
+func checkTenantLimits(user *User) error {
+	tenant, ok := GetTenantForRole(user.Role)
+	if !ok || (tenant.QuotaSize == 0 && tenant.QuotaFiles == 0) {
+		return nil
+	}
+	if tenant.QuotaSize > 0 && user.QuotaSize <= 0 {
+		return util.NewValidationError(fmt.Sprintf("the tenant %q has a quota size limit, the user quota size is required",
+			tenant.Name))
+	}
+	if tenant.QuotaFiles > 0 && user.QuotaFiles <= 0 {
+		return util.NewValidationError(fmt.Sprintf("the tenant %q has a quota files limit, the user quota files is required",
+			tenant.Name))
+	}
+	quotaSize := user.QuotaSize
+	quotaFiles := user.QuotaFiles
+	for _, roleName := range tenant.Members.Roles {
+		role, err := provider.roleExists(roleName)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		for _, username := range role.Users {
+			if username == user.Username {
+				continue
+			}
+			u, err := provider.userExists(username, "")
+			if err != nil {
+				if errors.Is(err, util.ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			quotaSize += u.QuotaSize
+			quotaFiles += u.QuotaFiles
+		}
+	}
+	if tenant.QuotaSize > 0 && quotaSize > tenant.QuotaSize {
+		return util.NewValidationError(fmt.Sprintf("the quota size allocated to the users of the tenant %q would be %s, the limit is %s",
+			tenant.Name, util.ByteCountIEC(quotaSize), util.ByteCountIEC(tenant.QuotaSize)))
+	}
+	if tenant.QuotaFiles > 0 && quotaFiles > tenant.QuotaFiles {
+		return util.NewValidationError(fmt.Sprintf("the quota files allocated to the users of the tenant %q would be %d, the limit is %d",
+			tenant.Name, quotaFiles, tenant.QuotaFiles))
+	}
+	return nil
+}
@@ -15,6 +15,8 @@
 package dataprovider
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,6 +31,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
@@ -86,6 +89,17 @@ const (
 	LoginMethodTLSCertificate         = "TLSCertificate"
 	LoginMethodTLSCertificateAndPwd   = "TLSCertificate+password"
 	LoginMethodIDP                    = "IDP"
+	LoginMethodKerberos               = "kerberos"
+)
+
+// Supported approval modes for logins from IP addresses not included in the
+// allowed list
+const (
+	// the user receives an email with a link to approve the new IP address,
+	// admins can approve the pending requests too
+	IPApprovalModeEmail = "email"
+	// the pending requests must be approved by an admin
+	IPApprovalModeAdmin = "admin"
 )
 
 var (
@@ -115,6 +129,150 @@ type UserTOTPConfig struct {
 	Protocols []string `json:"protocols,omitempty"`
 }
 
+// RememberedDevicesPolicy defines if and how browsers can be remembered after a
+// successful second factor authentication to the web UIs. Unset values inherit
+// the global configuration
+type RememberedDevicesPolicy struct {
+	// Number of days a browser can be remembered. 0 means use the global setting,
+	// -1 means that browsers cannot be remembered
+	Days int `json:"days,omitempty"`
+	// 0 means use the global setting, 1 a remembered browser is trusted only from
+	// the IP address used to complete the second factor authentication, 2 means
+	// not bound to the IP address
+	BindToIP int `json:"bind_to_ip,omitempty"`
+	// 0 means use the global setting, 1 a remembered browser is trusted only if its
+	// user agent does not change, 2 means not bound to the user agent
+	BindToUserAgent int `json:"bind_to_user_agent,omitempty"`
+}
+
+func (p *RememberedDevicesPolicy) validate() error {
+	if p.Days < -1 {
+		return util.NewValidationError(fmt.Sprintf("invalid remembered devices days: %d", p.Days))
+	}
+	if p.BindToIP < 0 || p.BindToIP > 2 {
+		return util.NewValidationError(fmt.Sprintf("invalid remembered devices IP binding: %d", p.BindToIP))
+	}
+	if p.BindToUserAgent < 0 || p.BindToUserAgent > 2 {
+		return util.NewValidationError(fmt.Sprintf("invalid remembered devices user agent binding: %d",
+			p.BindToUserAgent))
+	}
+	return nil
+}
+
+// merge sets the unset values from the specified policy
+func (p *RememberedDevicesPolicy) merge(policy RememberedDevicesPolicy) {
+	if p.Days == 0 {
+		p.Days = policy.Days
+	}
+	if p.BindToIP == 0 {
+		p.BindToIP = policy.BindToIP
+	}
+	if p.BindToUserAgent == 0 {
+		p.BindToUserAgent = policy.BindToUserAgent
+	}
+}
+
+// UnionFolder defines a union filesystem mounted on a virtual path. The filesystem
+// mounted on the virtual path is the writable upper layer, the specified virtual
+// folders are merged below it as read-only lower layers
+type UnionFolder struct {
+	// Virtual path, it must be "/" or the virtual path of a virtual folder
+	VirtualPath string `json:"virtual_path"`
+	// Names of the virtual folders to use as lower layers, in order of precedence
+	Folders []string `json:"folders"`
+}
+
+// TrashDirName is the name of the directory, inside the root of each filesystem,
+// where deleted files are moved if the trash is enabled
+const TrashDirName = ".trash"
+
+// UserTrashConfig defines the trash configuration for a user
+type UserTrashConfig struct {
+	// If enabled, deleted files are moved to the trash directory instead of being removed
+	Enabled bool `json:"enabled,omitempty"`
+	// Number of days to keep deleted files in the trash. Older files are removed
+	// by the "Trash purge" event action. 0 means no automatic purge
+	Retention int `json:"retention,omitempty"`
+}
+
+// UserSSHAlgorithms defines the SSH algorithms allowed for a user.
+// An empty list means no restriction
+type UserSSHAlgorithms struct {
+	KexAlgorithms []string `json:"kex_algorithms,omitempty"`
+	Ciphers       []string `json:"ciphers,omitempty"`
+	MACs          []string `json:"macs,omitempty"`
+}
+
+// IsRestricted returns true if at least one algorithm restriction is set
+func (a *UserSSHAlgorithms) IsRestricted() bool {
+	return len(a.KexAlgorithms) > 0 || len(a.Ciphers) > 0 || len(a.MACs) > 0
+}
+
+// GetKexAlgorithmsAsString returns the allowed KEX algorithms as comma separated string
+func (a *UserSSHAlgorithms) GetKexAlgorithmsAsString() string {
+	return strings.Join(a.KexAlgorithms, ",")
+}
+
+// GetCiphersAsString returns the allowed ciphers as comma separated string
+func (a *UserSSHAlgorithms) GetCiphersAsString() string {
+	return strings.Join(a.Ciphers, ",")
+}
+
+// GetMACsAsString returns the allowed MAC algorithms as comma separated string
+func (a *UserSSHAlgorithms) GetMACsAsString() string {
+	return strings.Join(a.MACs, ",")
+}
+
+// merge sets the algorithm lists not restricted yet from the given ones
+func (a *UserSSHAlgorithms) merge(algos UserSSHAlgorithms) {
+	copied := algos.getACopy()
+	if len(a.KexAlgorithms) == 0 {
+		a.KexAlgorithms = copied.KexAlgorithms
+	}
+	if len(a.Ciphers) == 0 {
+		a.Ciphers = copied.Ciphers
+	}
+	if len(a.MACs) == 0 {
+		a.MACs = copied.MACs
+	}
+}
+
+func (a *UserSSHAlgorithms) getACopy() UserSSHAlgorithms {
+	kexs := make([]string, len(a.KexAlgorithms))
+	copy(kexs, a.KexAlgorithms)
+	ciphers := make([]string, len(a.Ciphers))
+	copy(ciphers, a.Ciphers)
+	macs := make([]string, len(a.MACs))
+	copy(macs, a.MACs)
+
+	return UserSSHAlgorithms{
+		KexAlgorithms: kexs,
+		Ciphers:       ciphers,
+		MACs:          macs,
+	}
+}
+
+// S3AccessKey defines an access key for the S3 compatible API
+type S3AccessKey struct {
+	// Access key ID, it must be unique across all users
+	AccessKeyID string `json:"access_key_id"`
+	// Secret access key, it is stored encrypted
+	SecretAccessKey *kms.Secret `json:"secret_access_key,omitempty"`
+	// Optional description
+	Description string `json:"description,omitempty"`
+}
+
+func (k *S3AccessKey) getACopy() S3AccessKey {
+	if k.SecretAccessKey == nil {
+		k.SecretAccessKey = kms.NewEmptySecret()
+	}
+	return S3AccessKey{
+		AccessKeyID:     k.AccessKeyID,
+		SecretAccessKey: k.SecretAccessKey.Clone(),
+		Description:     k.Description,
+	}
+}
+
 // UserFilters defines additional restrictions for a user
 // TODO: rename to UserOptions in v3
 type UserFilters struct {
@@ -127,6 +285,72 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// Union filesystems, they allow to merge read-only virtual folders
+	// below the filesystem mounted on a virtual path
+	UnionFolders []UnionFolder `json:"union_folders,omitempty"`
+	// Trash configuration
+	Trash UserTrashConfig `json:"trash,omitempty"`
+	// Destinations, in host:port format, allowed for SSH local port forwarding.
+	// The port can be "*" to allow any port on the specified host
+	AllowedTCPForwards []string `json:"allowed_tcp_forwards,omitempty"`
+	// SSH algorithms allowed for this user, they are checked against the
+	// ones negotiated for the connection when the user logs in
+	SSHAlgorithms UserSSHAlgorithms `json:"ssh_algorithms,omitempty"`
+	// Access keys for the S3 compatible API
+	S3AccessKeys []S3AccessKey `json:"s3_access_keys,omitempty"`
+	// Virtual paths that can be browsed and downloaded over HTTP without
+	// authentication. Only supported for anonymous users
+	AnonymousHTTPPaths []string `json:"anonymous_http_paths,omitempty"`
+	// Bandwidth limits that apply only on the specified days and time of day.
+	// They override the default and per-source bandwidth limits while active
+	BandwidthSchedules []BandwidthSchedule `json:"bandwidth_schedules,omitempty"`
+	// Maximum number of concurrent transfers, across all the user's connections.
+	// 0 means unlimited
+	MaxTransfers int `json:"max_transfers,omitempty"`
+	// Maximum time, in seconds, a new transfer waits for a free slot if the maximum
+	// number of concurrent transfers is reached. 0 means the transfer fails immediately
+	TransfersQueueTimeout int `json:"transfers_queue_timeout,omitempty"`
+	// Maximum number of entries, files and directories, allowed inside a single
+	// directory. It is checked for new uploads and directories. 0 means unlimited
+	MaxDirEntries int `json:"max_dir_entries,omitempty"`
+	// Maximum depth allowed for new files and directories, for example "/a/b/file.txt"
+	// has depth 3. 0 means unlimited
+	MaxPathDepth int `json:"max_path_depth,omitempty"`
+	// Per-directory restrictions based on the content type detected for
+	// the uploaded data
+	ContentTypes []ContentTypesFilter `json:"content_types,omitempty"`
+	// Names of the DLP policies used to inspect the uploaded files.
+	// The policies are defined in the configuration file
+	DLPPolicies []string `json:"dlp_policies,omitempty"`
+	// Time windows in which the user is allowed to login. Empty means no restriction
+	AccessTimeWindows []AccessTimeWindow `json:"access_time_windows,omitempty"`
+	// IANA time zone name, for example "Europe/Rome", used to evaluate the
+	// access time windows. Empty means UTC
+	AccessTimeZone string `json:"access_time_zone,omitempty"`
+	// If enabled, the active sessions are terminated outside the access time windows
+	DisconnectOutsideAccessTime bool `json:"disconnect_outside_access_time,omitempty"`
+	// Countries and autonomous systems allowed or denied to login.
+	// They are ignored if GeoIP is not configured
+	GeoIP geoip.Filter `json:"geoip,omitempty"`
+	// Client TLS certificates allowed for this user, defined as SHA-256 fingerprints
+	// or subject distinguished names. If set, the user can login using a client
+	// certificate matching one of them even if the TLS username is not enabled
+	TLSCertPins []string `json:"tls_cert_pins,omitempty"`
+	// FIDO2/WebAuthn security keys registered as second factor for the WebClient
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// If enabled, the user must use a security key as second factor for the WebClient,
+	// TOTP is not accepted
+	RequireWebAuthn bool `json:"require_webauthn,omitempty"`
+	// Policy for the browsers remembered after the second factor authentication
+	// to the WebClient
+	RememberedDevices RememberedDevicesPolicy `json:"remembered_devices,omitempty"`
+	// Approval mode for logins, with valid credentials, from IP addresses not
+	// included in the allowed list. After the approval, the IP address is added
+	// to the allowed list. Empty means disabled
+	IPApproval string `json:"ip_approval,omitempty"`
+	// Template applied to the user, it is used to detect and re-apply
+	// the template changes
+	Template TemplateReference `json:"template,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -144,6 +368,10 @@ type User struct {
 	fsCache map[string]vfs.Fs `json:"-"`
 	// true if group settings are already applied for this user
 	groupSettingsApplied bool `json:"-"`
+	// aggregate bandwidth caps from the primary group
+	aggregateBandwidth aggregateBandwidth `json:"-"`
+	// account lifecycle thresholds from the primary group
+	lifecycle lifecycleThresholds `json:"-"`
 	// in multi node setups we mark the user as deleted to be able to update the webdav cache
 	DeletedAt int64 `json:"-"`
 }
@@ -169,11 +397,16 @@ func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 			return nil, err
 		}
 		forbiddenSelfUsers = append(forbiddenSelfUsers, u.Username)
-		return vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
+		sftpFs, err := vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
+		if err != nil {
+			return nil, err
+		}
+		return vfs.NewCompressedFs(sftpFs, "", u.GetHomeDir(), u.FsConfig.SFTPConfig.Compression), nil
 	case sdk.HTTPFilesystemProvider:
 		return vfs.NewHTTPFs(connectionID, u.GetHomeDir(), "", u.FsConfig.HTTPConfig)
 	default:
-		return vfs.NewOsFs(connectionID, u.GetHomeDir(), ""), nil
+		return vfs.NewCompressedFs(vfs.NewOsFs(connectionID, u.GetHomeDir(), ""), "", u.GetHomeDir(),
+			u.FsConfig.OSConfig.Compression), nil
 	}
 }
 
@@ -342,6 +575,9 @@ func (u *User) CheckLoginConditions() error {
 		return fmt.Errorf("user %q is expired, expiration timestamp: %v current timestamp: %v", u.Username,
 			u.ExpirationDate, util.GetTimeAsMsSinceEpoch(time.Now()))
 	}
+	if !u.IsLoginTimeAllowed(time.Now()) {
+		return fmt.Errorf("user %q is not allowed to login at this time", u.Username)
+	}
 	return nil
 }
 
@@ -357,6 +593,11 @@ func (u *User) hideConfidentialData() {
 			code.Secret.Hide()
 		}
 	}
+	for _, key := range u.Filters.S3AccessKeys {
+		if key.SecretAccessKey != nil {
+			key.SecretAccessKey.Hide()
+		}
+	}
 }
 
 // GetSubDirPermissions returns permissions for sub directories
@@ -416,6 +657,15 @@ func (u *User) PrepareForRendering() {
 	}
 }
 
+// HideFsConfigs removes the filesystem configurations for the user and the
+// virtual folders, only the storage providers are preserved
+func (u *User) HideFsConfigs() {
+	u.FsConfig.HideConfig()
+	for idx := range u.VirtualFolders {
+		u.VirtualFolders[idx].FsConfig.HideConfig()
+	}
+}
+
 // HasRedactedSecret returns true if the user has a redacted secret
 func (u *User) hasRedactedSecret() bool {
 	if u.FsConfig.HasRedactedSecret() {
@@ -429,6 +679,12 @@ func (u *User) hasRedactedSecret() bool {
 		}
 	}
 
+	for _, key := range u.Filters.S3AccessKeys {
+		if key.SecretAccessKey != nil && key.SecretAccessKey.IsRedacted() {
+			return true
+		}
+	}
+
 	return u.Filters.TOTPConfig.Secret.IsRedacted()
 }
 
@@ -462,6 +718,25 @@ func (u *User) IsTLSUsernameVerificationEnabled() bool {
 	return false
 }
 
+// IsTLSCertificateAuthEnabled returns true if the user can login using
+// a client TLS certificate
+func (u *User) IsTLSCertificateAuthEnabled() bool {
+	return u.IsTLSUsernameVerificationEnabled() || len(u.Filters.TLSCertPins) > 0
+}
+
+// isTLSCertPinned returns true if the specified certificate matches
+// the fingerprint or the subject of a pinned certificate
+func (u *User) isTLSCertPinned(tlsCert *x509.Certificate) bool {
+	fingerprint := getTLSCertFingerprint(tlsCert)
+	subject := tlsCert.Subject.String()
+	for _, pin := range u.Filters.TLSCertPins {
+		if pin == fingerprint || pin == subject {
+			return true
+		}
+	}
+	return false
+}
+
 // SetEmptySecrets sets to empty any user secret
 func (u *User) SetEmptySecrets() {
 	u.FsConfig.SetEmptySecrets()
@@ -566,6 +841,9 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 				forbiddenSelfUsers = append(forbiddenSelfUsers, forbiddens...)
 			}
 			fs, err := folder.GetFilesystem(connectionID, forbiddenSelfUsers)
+			if err == nil {
+				fs, err = u.getUnionFs(folder.VirtualPath, fs, connectionID)
+			}
 			if err == nil {
 				u.fsCache[folder.VirtualPath] = fs
 			}
@@ -580,10 +858,49 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 	if err != nil {
 		return fs, err
 	}
+	fs, err = u.getUnionFs("/", fs, connectionID)
+	if err != nil {
+		return fs, err
+	}
 	u.fsCache["/"] = fs
 	return fs, err
 }
 
+// getUnionFs returns a union filesystem using the specified fs as upper layer if
+// a union folder is defined for the given virtual path, otherwise fs is returned
+func (u *User) getUnionFs(virtualPath string, fs vfs.Fs, connectionID string) (vfs.Fs, error) {
+	for _, union := range u.Filters.UnionFolders {
+		if union.VirtualPath != virtualPath {
+			continue
+		}
+		lowers := make([]vfs.Fs, 0, len(union.Folders))
+		for _, name := range union.Folders {
+			folder, err := provider.getFolderByName(name)
+			if err == nil {
+				vfolder := vfs.VirtualFolder{
+					BaseVirtualFolder: folder,
+					VirtualPath:       virtualPath,
+				}
+				var lower vfs.Fs
+				lower, err = vfolder.GetFilesystem(connectionID, []string{u.Username})
+				if err == nil {
+					lowers = append(lowers, lower)
+					continue
+				}
+			}
+			providerLog(logger.LevelError, "unable to get lower layer %q for union folder %q, user %q: %v",
+				name, virtualPath, u.Username, err)
+			for _, lower := range lowers {
+				lower.Close()
+			}
+			fs.Close()
+			return nil, err
+		}
+		return vfs.NewUnionFs(connectionID, virtualPath, fs, lowers), nil
+	}
+	return fs, nil
+}
+
 // GetVirtualFolderForPath returns the virtual folder containing the specified virtual path.
 // If the path is not inside a virtual folder an error is returned
 func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, error) {
@@ -701,6 +1018,7 @@ func (u *User) hasVirtualDirs() bool {
 
 // FilterListDir adds virtual folders and remove hidden items from the given files list
 func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os.FileInfo {
+	dirContents = u.filterTrashDir(dirContents, virtualPath)
 	filter := u.getPatternsFilterForPath(virtualPath)
 	if !u.hasVirtualDirs() && filter.DenyPolicy != sdk.DenyPolicyHide {
 		return dirContents
@@ -750,6 +1068,39 @@ func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os
 	return dirContents
 }
 
+// IsTrashPath returns true if the trash is enabled and the specified virtual path
+// is the trash directory of a filesystem or it is inside it
+func (u *User) IsTrashPath(virtualPath string) bool {
+	if !u.Filters.Trash.Enabled {
+		return false
+	}
+	trashPath := path.Join(u.GetTrashMountPath(virtualPath), TrashDirName)
+	return virtualPath == trashPath || strings.HasPrefix(virtualPath, trashPath+"/")
+}
+
+// GetTrashMountPath returns the virtual path where the filesystem containing
+// the specified virtual path is mounted, the trash directory is inside it
+func (u *User) GetTrashMountPath(virtualPath string) string {
+	if folder, err := u.GetVirtualFolderForPath(virtualPath); err == nil {
+		return folder.VirtualPath
+	}
+	return "/"
+}
+
+// filterTrashDir removes the trash directory from the contents of the
+// directory where a filesystem is mounted
+func (u *User) filterTrashDir(dirContents []os.FileInfo, virtualPath string) []os.FileInfo {
+	if !u.IsTrashPath(path.Join(virtualPath, TrashDirName)) {
+		return dirContents
+	}
+	for idx, fi := range dirContents {
+		if fi.Name() == TrashDirName {
+			return append(dirContents[:idx], dirContents[idx+1:]...)
+		}
+	}
+	return dirContents
+}
+
 // IsMappedPath returns true if the specified filesystem path has a virtual folder mapping.
 // The filesystem path must be cleaned before calling this method
 func (u *User) IsMappedPath(fsPath string) bool {
@@ -948,7 +1299,7 @@ func (u *User) IsPartialAuth(loginMethod string) bool {
 	}
 	for _, method := range u.GetAllowedLoginMethods() {
 		if method == LoginMethodTLSCertificate || method == LoginMethodTLSCertificateAndPwd ||
-			method == SSHLoginMethodPassword {
+			method == SSHLoginMethodPassword || method == LoginMethodKerberos {
 			continue
 		}
 		if method == LoginMethodPassword && util.Contains(u.Filters.DeniedLoginMethods, SSHLoginMethodPassword) {
@@ -1023,6 +1374,9 @@ func (u *User) getMinPasswordEntropy() float64 {
 // IsFileAllowed returns true if the specified file is allowed by the file restrictions filters.
 // The second parameter returned is the deny policy
 func (u *User) IsFileAllowed(virtualPath string) (bool, int) {
+	if u.IsTrashPath(virtualPath) {
+		return false, sdk.DenyPolicyHide
+	}
 	dirPath := path.Dir(virtualPath)
 	if u.isDirHidden(dirPath) {
 		return false, sdk.DenyPolicyHide
@@ -1031,12 +1385,39 @@ func (u *User) IsFileAllowed(virtualPath string) (bool, int) {
 	return filter.CheckAllowed(path.Base(virtualPath)), filter.DenyPolicy
 }
 
+// GetContentTypesFilter returns the content types filter for the specified
+// file, if any. The filter defined for the nearest parent directory is used
+func (u *User) GetContentTypesFilter(virtualPath string) (ContentTypesFilter, bool) {
+	if len(u.Filters.ContentTypes) == 0 {
+		return ContentTypesFilter{}, false
+	}
+	for _, dir := range util.GetDirsForVirtualPath(path.Dir(virtualPath)) {
+		for _, f := range u.Filters.ContentTypes {
+			if f.Path == dir {
+				return f, true
+			}
+		}
+	}
+	return ContentTypesFilter{}, false
+}
+
+// GetContentTypesAsString returns the content types filters, one per line,
+// as "path::allowed types::denied types"
+func (u *User) GetContentTypesAsString() string {
+	var sb strings.Builder
+	for _, f := range u.Filters.ContentTypes {
+		sb.WriteString(fmt.Sprintf("%s::%s::%s\n", f.Path, strings.Join(f.AllowedTypes, ","),
+			strings.Join(f.DeniedTypes, ",")))
+	}
+	return sb.String()
+}
+
 // CanManageMFA returns true if the user can add a multi-factor authentication configuration
 func (u *User) CanManageMFA() bool {
 	if util.Contains(u.Filters.WebClient, sdk.WebClientMFADisabled) {
 		return false
 	}
-	return len(mfa.GetAvailableTOTPConfigs()) > 0
+	return len(mfa.GetAvailableTOTPConfigs()) > 0 || mfa.IsWebAuthnEnabled()
 }
 
 func (u *User) isExternalAuthCached() bool {
@@ -1202,8 +1583,42 @@ func (u *User) GetBandwidthForIP(clientIP, connectionID string) (int64, int64) {
 // IsLoginFromAddrAllowed returns true if the login is allowed from the specified remoteAddr.
 // If AllowedIP is defined only the specified IP/Mask can login.
 // If DeniedIP is defined the specified IP/Mask cannot login.
-// If an IP is both allowed and denied then login will be allowed
+// If an IP is both allowed and denied then login will be allowed.
+// The GeoIP restrictions, if any, must be satisfied too
 func (u *User) IsLoginFromAddrAllowed(remoteAddr string) bool {
+	if !u.isLoginFromIPAllowed(remoteAddr) {
+		return false
+	}
+	if !u.Filters.GeoIP.IsAllowed(util.GetIPFromRemoteAddress(remoteAddr)) {
+		logger.Debug(logSender, "", "login for user %q denied by the GeoIP filters, remote address: %q",
+			u.Username, remoteAddr)
+		return false
+	}
+	return true
+}
+
+// CanRequestIPApproval returns true if a login from the specified remoteAddr,
+// denied because the IP address is not included in the allowed list, can be
+// approved adding the IP address to the allowed list
+func (u *User) CanRequestIPApproval(remoteAddr string) bool {
+	if u.Filters.IPApproval == "" || len(u.Filters.AllowedIP) == 0 {
+		return false
+	}
+	ip := util.GetIPFromRemoteAddress(remoteAddr)
+	remoteIP := net.ParseIP(ip)
+	if remoteIP == nil || u.isLoginFromIPAllowed(remoteAddr) {
+		return false
+	}
+	for _, IPMask := range u.Filters.DeniedIP {
+		_, IPNet, err := net.ParseCIDR(IPMask)
+		if err != nil || IPNet.Contains(remoteIP) {
+			return false
+		}
+	}
+	return u.Filters.GeoIP.IsAllowed(ip)
+}
+
+func (u *User) isLoginFromIPAllowed(remoteAddr string) bool {
 	if len(u.Filters.AllowedIP) == 0 && len(u.Filters.DeniedIP) == 0 {
 		return true
 	}
@@ -1536,6 +1951,116 @@ func (u *User) GetAllowedIPAsString() string {
 	return strings.Join(u.Filters.AllowedIP, ",")
 }
 
+// GetAllowedTCPForwardsAsString returns the allowed SSH forwarding destinations
+// as comma separated string
+func (u *User) GetAllowedTCPForwardsAsString() string {
+	return strings.Join(u.Filters.AllowedTCPForwards, ",")
+}
+
+// GetDLPPoliciesAsString returns the DLP policies as comma separated string
+func (u *User) GetDLPPoliciesAsString() string {
+	return strings.Join(u.Filters.DLPPolicies, ",")
+}
+
+// GetTLSCertPins returns the pinned TLS certificates. An empty pin is returned
+// if none is defined so the web admin can render an empty field
+func (u *User) GetTLSCertPins() []string {
+	if len(u.Filters.TLSCertPins) == 0 {
+		return []string{""}
+	}
+	return u.Filters.TLSCertPins
+}
+
+// GetGeoIPAllowedCountriesAsString returns the GeoIP allowed countries as comma separated string
+func (u *User) GetGeoIPAllowedCountriesAsString() string {
+	return strings.Join(u.Filters.GeoIP.AllowedCountries, ",")
+}
+
+// GetGeoIPDeniedCountriesAsString returns the GeoIP denied countries as comma separated string
+func (u *User) GetGeoIPDeniedCountriesAsString() string {
+	return strings.Join(u.Filters.GeoIP.DeniedCountries, ",")
+}
+
+// GetGeoIPAllowedASNsAsString returns the GeoIP allowed ASNs as comma separated string
+func (u *User) GetGeoIPAllowedASNsAsString() string {
+	return getASNsAsString(u.Filters.GeoIP.AllowedASNs)
+}
+
+// GetGeoIPDeniedASNsAsString returns the GeoIP denied ASNs as comma separated string
+func (u *User) GetGeoIPDeniedASNsAsString() string {
+	return getASNsAsString(u.Filters.GeoIP.DeniedASNs)
+}
+
+func getASNsAsString(asns []uint) string {
+	result := make([]string, 0, len(asns))
+	for _, asn := range asns {
+		result = append(result, strconv.FormatUint(uint64(asn), 10))
+	}
+	return strings.Join(result, ",")
+}
+
+// IsTCPForwardAllowed returns true if SSH local port forwarding to the
+// specified host and port is allowed
+func (u *User) IsTCPForwardAllowed(host string, port uint32) bool {
+	for _, allowed := range u.Filters.AllowedTCPForwards {
+		allowedHost, allowedPort, err := net.SplitHostPort(allowed)
+		if err != nil || !strings.EqualFold(allowedHost, host) {
+			continue
+		}
+		if allowedPort == "*" || allowedPort == strconv.FormatUint(uint64(port), 10) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAnonymousHTTPPathsAsString returns the paths that can be browsed over
+// HTTP without authentication as comma separated string
+func (u *User) GetAnonymousHTTPPathsAsString() string {
+	return strings.Join(u.Filters.AnonymousHTTPPaths, ",")
+}
+
+// IsAnonymousHTTPPathAllowed returns true if the specified virtual path can be
+// browsed and downloaded over HTTP without authentication
+func (u *User) IsAnonymousHTTPPathAllowed(virtualPath string) bool {
+	if !u.Filters.IsAnonymous {
+		return false
+	}
+	for _, p := range u.Filters.AnonymousHTTPPaths {
+		if isVirtualPathInside(virtualPath, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAnonymousHTTPPathVisible returns true if the specified virtual path can be
+// browsed over HTTP without authentication or if it is a parent directory of
+// an allowed path, parent directories are visible to allow navigation
+func (u *User) IsAnonymousHTTPPathVisible(virtualPath string) bool {
+	if u.IsAnonymousHTTPPathAllowed(virtualPath) {
+		return true
+	}
+	if !u.Filters.IsAnonymous {
+		return false
+	}
+	for _, p := range u.Filters.AnonymousHTTPPaths {
+		if isVirtualPathInside(p, virtualPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVirtualPathInside returns true if virtualPath is dir or one of its
+// sub paths. Both paths must be cleaned
+func isVirtualPathInside(virtualPath, dir string) bool {
+	if dir == "/" || virtualPath == dir {
+		return true
+	}
+	return strings.HasPrefix(virtualPath, dir+"/")
+}
+
 // GetDeniedIPAsString returns the denied IP as comma separated string
 func (u *User) GetDeniedIPAsString() string {
 	return strings.Join(u.Filters.DeniedIP, ",")
@@ -1574,12 +2099,28 @@ func (u *User) SetEmptySecretsIfNil() {
 	if u.Filters.TOTPConfig.Secret == nil {
 		u.Filters.TOTPConfig.Secret = kms.NewEmptySecret()
 	}
+	for idx := range u.Filters.S3AccessKeys {
+		key := &u.Filters.S3AccessKeys[idx]
+		if key.SecretAccessKey == nil {
+			key.SecretAccessKey = kms.NewEmptySecret()
+		}
+	}
 }
 
 func (u *User) hasMainDataTransferLimits() bool {
 	return u.UploadDataTransfer > 0 || u.DownloadDataTransfer > 0 || u.TotalDataTransfer > 0
 }
 
+// GetS3AccessKey returns the S3 access key with the specified ID, if any
+func (u *User) GetS3AccessKey(accessKeyID string) (S3AccessKey, bool) {
+	for _, key := range u.Filters.S3AccessKeys {
+		if key.AccessKeyID == accessKeyID {
+			return key, true
+		}
+	}
+	return S3AccessKey{}, false
+}
+
 // HasPrimaryGroup returns true if the user has the specified primary group
 func (u *User) HasPrimaryGroup(name string) bool {
 	for _, g := range u.Groups {
@@ -1629,7 +2170,7 @@ func (u *User) applyGroupSettings(groupsMapping map[string]Group) {
 	replacer := u.getGroupPlacehodersReplacer()
 	for _, g := range u.Groups {
 		if g.Type == sdk.GroupTypePrimary {
-			if group, ok := groupsMapping[g.Name]; ok {
+			if group, ok := getResolvedGroup(g.Name, groupsMapping); ok {
 				u.mergeWithPrimaryGroup(group, replacer)
 			} else {
 				providerLog(logger.LevelError, "mapping not found for user %s, group %s", u.Username, g.Name)
@@ -1639,7 +2180,7 @@ func (u *User) applyGroupSettings(groupsMapping map[string]Group) {
 	}
 	for _, g := range u.Groups {
 		if g.Type == sdk.GroupTypeSecondary {
-			if group, ok := groupsMapping[g.Name]; ok {
+			if group, ok := getResolvedGroup(g.Name, groupsMapping); ok {
 				u.mergeAdditiveProperties(group, sdk.GroupTypeSecondary, replacer)
 			} else {
 				providerLog(logger.LevelError, "mapping not found for user %s, group %s", u.Username, g.Name)
@@ -1671,6 +2212,7 @@ func (u *User) LoadAndApplyGroupSettings() error {
 	if err != nil {
 		return fmt.Errorf("unable to get groups: %w", err)
 	}
+	groups = getGroupsWithIncludedSettings(groups)
 	replacer := u.getGroupPlacehodersReplacer()
 	// make sure to always merge with the primary group first
 	for idx, g := range groups {
@@ -1739,6 +2281,25 @@ func (u *User) mergeWithPrimaryGroup(group Group, replacer *strings.Replacer) {
 	if u.DownloadBandwidth == 0 {
 		u.DownloadBandwidth = group.UserSettings.DownloadBandwidth
 	}
+	if len(u.Filters.BandwidthSchedules) == 0 {
+		u.Filters.BandwidthSchedules = copyBandwidthSchedules(group.UserSettings.BandwidthSchedules)
+	}
+	if len(u.Filters.AccessTimeWindows) == 0 && len(group.UserSettings.AccessTimeWindows) > 0 {
+		u.Filters.AccessTimeWindows = copyAccessTimeWindows(group.UserSettings.AccessTimeWindows)
+		u.Filters.AccessTimeZone = group.UserSettings.AccessTimeZone
+		u.Filters.DisconnectOutsideAccessTime = group.UserSettings.DisconnectOutsideAccessTime
+	}
+	u.Filters.RememberedDevices.merge(group.UserSettings.RememberedDevices)
+	u.Filters.SSHAlgorithms.merge(group.UserSettings.SSHAlgorithms)
+	u.aggregateBandwidth = aggregateBandwidth{
+		group:    group.Name,
+		upload:   group.UserSettings.AggregateUploadBandwidth,
+		download: group.UserSettings.AggregateDownloadBandwidth,
+	}
+	u.lifecycle = lifecycleThresholds{
+		expirationWarning: group.UserSettings.ExpirationWarningThreshold,
+		inactivity:        group.UserSettings.InactivityThreshold,
+	}
 	if !u.hasMainDataTransferLimits() {
 		u.UploadDataTransfer = group.UserSettings.UploadDataTransfer
 		u.DownloadDataTransfer = group.UserSettings.DownloadDataTransfer
@@ -1808,6 +2369,14 @@ func (u *User) mergeAdditiveProperties(group Group, groupType int, replacer *str
 	u.Filters.DeniedProtocols = append(u.Filters.DeniedProtocols, group.UserSettings.Filters.DeniedProtocols...)
 	u.Filters.WebClient = append(u.Filters.WebClient, group.UserSettings.Filters.WebClient...)
 	u.Filters.TwoFactorAuthProtocols = append(u.Filters.TwoFactorAuthProtocols, group.UserSettings.Filters.TwoFactorAuthProtocols...)
+	if group.UserSettings.RequireWebAuthn {
+		u.Filters.RequireWebAuthn = true
+	}
+	for _, policy := range group.UserSettings.DLPPolicies {
+		if !util.Contains(u.Filters.DLPPolicies, policy) {
+			u.Filters.DLPPolicies = append(u.Filters.DLPPolicies, policy)
+		}
+	}
 }
 
 func (u *User) mergeVirtualFolders(group Group, groupType int, replacer *strings.Replacer) {
@@ -1908,6 +2477,31 @@ func (u *User) getACopy() User {
 		BaseUserFilters: copyBaseUserFilters(u.Filters.BaseUserFilters),
 	}
 	filters.RequirePasswordChange = u.Filters.RequirePasswordChange
+	filters.Trash = u.Filters.Trash
+	filters.MaxTransfers = u.Filters.MaxTransfers
+	filters.TransfersQueueTimeout = u.Filters.TransfersQueueTimeout
+	filters.MaxDirEntries = u.Filters.MaxDirEntries
+	filters.MaxPathDepth = u.Filters.MaxPathDepth
+	filters.AllowedTCPForwards = make([]string, len(u.Filters.AllowedTCPForwards))
+	copy(filters.AllowedTCPForwards, u.Filters.AllowedTCPForwards)
+	filters.AnonymousHTTPPaths = make([]string, len(u.Filters.AnonymousHTTPPaths))
+	copy(filters.AnonymousHTTPPaths, u.Filters.AnonymousHTTPPaths)
+	filters.BandwidthSchedules = copyBandwidthSchedules(u.Filters.BandwidthSchedules)
+	filters.ContentTypes = copyContentTypesFilters(u.Filters.ContentTypes)
+	filters.DLPPolicies = make([]string, len(u.Filters.DLPPolicies))
+	copy(filters.DLPPolicies, u.Filters.DLPPolicies)
+	filters.AccessTimeWindows = copyAccessTimeWindows(u.Filters.AccessTimeWindows)
+	filters.AccessTimeZone = u.Filters.AccessTimeZone
+	filters.DisconnectOutsideAccessTime = u.Filters.DisconnectOutsideAccessTime
+	filters.GeoIP = u.Filters.GeoIP.GetACopy()
+	filters.TLSCertPins = make([]string, len(u.Filters.TLSCertPins))
+	copy(filters.TLSCertPins, u.Filters.TLSCertPins)
+	filters.SSHAlgorithms = u.Filters.SSHAlgorithms.getACopy()
+	filters.WebAuthnCredentials = copyWebAuthnCredentials(u.Filters.WebAuthnCredentials)
+	filters.RequireWebAuthn = u.Filters.RequireWebAuthn
+	filters.RememberedDevices = u.Filters.RememberedDevices
+	filters.IPApproval = u.Filters.IPApproval
+	filters.Template = u.Filters.Template
 	filters.TOTPConfig.Enabled = u.Filters.TOTPConfig.Enabled
 	filters.TOTPConfig.ConfigName = u.Filters.TOTPConfig.ConfigName
 	filters.TOTPConfig.Secret = u.Filters.TOTPConfig.Secret.Clone()
@@ -1923,6 +2517,19 @@ func (u *User) getACopy() User {
 			Used:   code.Used,
 		})
 	}
+	filters.S3AccessKeys = make([]S3AccessKey, 0, len(u.Filters.S3AccessKeys))
+	for idx := range u.Filters.S3AccessKeys {
+		filters.S3AccessKeys = append(filters.S3AccessKeys, u.Filters.S3AccessKeys[idx].getACopy())
+	}
+	filters.UnionFolders = make([]UnionFolder, 0, len(u.Filters.UnionFolders))
+	for _, f := range u.Filters.UnionFolders {
+		folders := make([]string, len(f.Folders))
+		copy(folders, f.Folders)
+		filters.UnionFolders = append(filters.UnionFolders, UnionFolder{
+			VirtualPath: f.VirtualPath,
+			Folders:     folders,
+		})
+	}
 
 	return User{
 		BaseUser: sdk.BaseUser{
@@ -1965,6 +2572,8 @@ func (u *User) getACopy() User {
 		Groups:               groups,
 		FsConfig:             u.FsConfig.GetACopy(),
 		groupSettingsApplied: u.groupSettingsApplied,
+		aggregateBandwidth:   u.aggregateBandwidth,
+		lifecycle:            u.lifecycle,
 	}
 }
 
@@ -1972,3 +2581,18 @@ func (u *User) getACopy() User {
 func (u *User) GetEncryptionAdditionalData() string {
 	return u.Username
 }
+
+// getTLSCertFingerprint returns the SHA-256 fingerprint of the specified certificate
+// as uppercase hex bytes separated by colons, the same format used by OpenSSL
+func getTLSCertFingerprint(tlsCert *x509.Certificate) string {
+	hash := sha256.Sum256(tlsCert.Raw)
+	return formatTLSCertFingerprint(hash[:])
+}
+
+func formatTLSCertFingerprint(hash []byte) string {
+	parts := make([]string, len(hash))
+	for idx, b := range hash {
+		parts[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
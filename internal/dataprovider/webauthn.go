@@ -0,0 +1,124 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// WebAuthnCredential defines a FIDO2/WebAuthn security key registered as
+// second factor for the web UIs
+type WebAuthnCredential struct {
+	// Credential ID as returned by the authenticator
+	ID []byte `json:"id"`
+	// User defined name to identify the security key
+	Name string `json:"name"`
+	// Public key, COSE encoded
+	PublicKey []byte `json:"public_key"`
+	// Attestation format used at registration time
+	AttestationType string `json:"attestation_type,omitempty"`
+	// Authenticator model identifier
+	AAGUID []byte `json:"aaguid,omitempty"`
+	// Signature counter, used to detect cloned authenticators
+	SignCount uint32 `json:"sign_count,omitempty"`
+	// Transports supported by the authenticator, for example "usb", "nfc"
+	Transports []string `json:"transports,omitempty"`
+	// Registration time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// Last use as unix timestamp in milliseconds
+	LastUseAt int64 `json:"last_use_at,omitempty"`
+}
+
+func (c *WebAuthnCredential) getACopy() WebAuthnCredential {
+	transports := make([]string, len(c.Transports))
+	copy(transports, c.Transports)
+	return WebAuthnCredential{
+		ID:              bytes.Clone(c.ID),
+		Name:            c.Name,
+		PublicKey:       bytes.Clone(c.PublicKey),
+		AttestationType: c.AttestationType,
+		AAGUID:          bytes.Clone(c.AAGUID),
+		SignCount:       c.SignCount,
+		Transports:      transports,
+		CreatedAt:       c.CreatedAt,
+		LastUseAt:       c.LastUseAt,
+	}
+}
+
+func copyWebAuthnCredentials(credentials []WebAuthnCredential) []WebAuthnCredential {
+	result := make([]WebAuthnCredential, 0, len(credentials))
+	for idx := range credentials {
+		result = append(result, credentials[idx].getACopy())
+	}
+	return result
+}
+
+func validateWebAuthnCredentials(credentials []WebAuthnCredential) error {
+	for idx := range credentials {
+		c := &credentials[idx]
+		if len(c.ID) == 0 || len(c.PublicKey) == 0 {
+			return util.NewValidationError("webauthn: credential ID and public key are mandatory")
+		}
+		if c.Name == "" {
+			return util.NewValidationError("webauthn: credential name is mandatory")
+		}
+		if len(c.Name) > 255 {
+			return util.NewValidationError(fmt.Sprintf("webauthn: credential name %q is too long", c.Name))
+		}
+		for j := idx + 1; j < len(credentials); j++ {
+			if bytes.Equal(c.ID, credentials[j].ID) {
+				return util.NewValidationError("webauthn: duplicate credential ID")
+			}
+			if c.Name == credentials[j].Name {
+				return util.NewValidationError(fmt.Sprintf("webauthn: duplicate credential name %q", c.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// HasWebAuthnCredentials returns true if the user has at least a registered security key
+// and security keys are enabled
+func (u *User) HasWebAuthnCredentials() bool {
+	return mfa.IsWebAuthnEnabled() && len(u.Filters.WebAuthnCredentials) > 0
+}
+
+// MustUseWebAuthn returns true if the user must use a security key as second
+// factor for the web UIs. It must be called on a user merged with its groups
+func (u *User) MustUseWebAuthn() bool {
+	if !mfa.IsWebAuthnEnabled() {
+		return false
+	}
+	return u.Filters.RequireWebAuthn || mfa.IsWebAuthnRequiredForRole(u.Role)
+}
+
+// HasWebAuthnCredentials returns true if the admin has at least a registered security key
+// and security keys are enabled
+func (a *Admin) HasWebAuthnCredentials() bool {
+	return mfa.IsWebAuthnEnabled() && len(a.Filters.WebAuthnCredentials) > 0
+}
+
+// MustUseWebAuthn returns true if the admin must use a security key as second
+// factor for the WebAdmin
+func (a *Admin) MustUseWebAuthn() bool {
+	if !mfa.IsWebAuthnEnabled() {
+		return false
+	}
+	return a.Filters.RequireWebAuthn || mfa.IsWebAuthnRequiredForRole(a.Role)
+}
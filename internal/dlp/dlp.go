@@ -0,0 +1,551 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package dlp implements the data loss prevention inspection for uploaded files.
+// Policies use built-in detectors, custom regular expressions or an external
+// gRPC classifier to find sensitive data
+package dlp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "github.com/drakkan/sftpgo/v2/internal/dlp/proto"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported built-in detectors
+const (
+	DetectorCreditCard = "credit_card"
+	DetectorSSN        = "ssn"
+)
+
+// Supported policy actions
+const (
+	ActionBlock  = "block"
+	ActionTag    = "tag"
+	ActionNotify = "notify"
+)
+
+const (
+	chunkSize                = 65536
+	overlapSize              = 1024
+	defaultClassifierTimeout = 30
+)
+
+var (
+	supportedDetectors = []string{DetectorCreditCard, DetectorSSN}
+	supportedActions   = []string{ActionBlock, ActionTag, ActionNotify}
+	policyNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	creditCardRegex    = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	ssnRegex           = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+)
+
+// ClassifierConfig defines the external gRPC classifier for a policy
+type ClassifierConfig struct {
+	// Address of the classifier service, in host:port format.
+	// Leave empty to disable
+	Address string `json:"address" mapstructure:"address"`
+	// Set to true to connect to the classifier using TLS.
+	// The system CA pool is used to verify the server certificate
+	UseTLS bool `json:"use_tls" mapstructure:"use_tls"`
+	// Timeout, in seconds, for each classification. 0 means the default, 30 seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+}
+
+func (c *ClassifierConfig) isEnabled() bool {
+	return c.Address != ""
+}
+
+func (c *ClassifierConfig) getTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultClassifierTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// Policy defines the detectors used to inspect the uploaded files and the
+// actions to execute if a violation is found
+type Policy struct {
+	// Unique name, users and groups refer to policies by name.
+	// Only letters, numbers, "-", "_" and "." are allowed
+	Name string `json:"name" mapstructure:"name"`
+	// Built-in detectors, supported values: "credit_card", "ssn"
+	Detectors []string `json:"detectors" mapstructure:"detectors"`
+	// Custom regular expressions, using the Go syntax
+	Patterns []string `json:"patterns" mapstructure:"patterns"`
+	// Minimum number of matches, across all the detectors and patterns,
+	// to consider the file a violation. 0 means 1
+	MinMatches int `json:"min_matches" mapstructure:"min_matches"`
+	// Optional external classifier
+	Classifier ClassifierConfig `json:"classifier" mapstructure:"classifier"`
+	// Actions to execute on violations: "block", "tag", "notify"
+	Actions []string `json:"actions" mapstructure:"actions"`
+}
+
+func (p *Policy) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("policy name is mandatory")
+	}
+	if !policyNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("invalid policy name %q, only letters, numbers, \"-\", \"_\" and \".\" are allowed", p.Name)
+	}
+	p.Detectors = util.RemoveDuplicates(p.Detectors, true)
+	for _, d := range p.Detectors {
+		if !util.Contains(supportedDetectors, d) {
+			return fmt.Errorf("policy %q: invalid detector %q", p.Name, d)
+		}
+	}
+	p.Actions = util.RemoveDuplicates(p.Actions, true)
+	if len(p.Actions) == 0 {
+		return fmt.Errorf("policy %q: at least one action is required", p.Name)
+	}
+	for _, a := range p.Actions {
+		if !util.Contains(supportedActions, a) {
+			return fmt.Errorf("policy %q: invalid action %q", p.Name, a)
+		}
+	}
+	if len(p.Detectors) == 0 && len(p.Patterns) == 0 && !p.Classifier.isEnabled() {
+		return fmt.Errorf("policy %q: at least a detector, a pattern or a classifier is required", p.Name)
+	}
+	if p.MinMatches <= 0 {
+		p.MinMatches = 1
+	}
+	return nil
+}
+
+// Config defines the DLP configuration
+type Config struct {
+	// Inspection policies, they apply to the users and groups they are assigned to
+	Policies []Policy `json:"policies" mapstructure:"policies"`
+	// Maximum size, in MB, of the files to inspect. Larger files are not inspected.
+	// 0 means no limit
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Set to true to reject the uploads, for policies with the block action,
+	// if the inspection fails, for example if the classifier is unavailable
+	RejectOnError bool `json:"reject_on_error" mapstructure:"reject_on_error"`
+}
+
+// IsEnabled returns true if at least a policy is defined
+func (c *Config) IsEnabled() bool {
+	return len(c.Policies) > 0
+}
+
+type detector struct {
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+type policy struct {
+	Policy
+	detectors []detector
+	conn      *grpc.ClientConn
+	client    pb.ClassifierClient
+}
+
+// Inspector inspects the uploaded files using the configured policies
+type Inspector struct {
+	policies      map[string]*policy
+	maxSize       int64
+	rejectOnError bool
+}
+
+// NewInspector validates the given configuration and returns a new Inspector
+func NewInspector(c Config) (*Inspector, error) {
+	inspector := &Inspector{
+		policies:      make(map[string]*policy),
+		maxSize:       c.MaxSize * 1048576,
+		rejectOnError: c.RejectOnError,
+	}
+	for _, p := range c.Policies {
+		if err := p.validate(); err != nil {
+			inspector.Close()
+			return nil, err
+		}
+		if _, ok := inspector.policies[p.Name]; ok {
+			inspector.Close()
+			return nil, fmt.Errorf("duplicate policy %q", p.Name)
+		}
+		compiled, err := newPolicy(p)
+		if err != nil {
+			inspector.Close()
+			return nil, err
+		}
+		inspector.policies[p.Name] = compiled
+	}
+	return inspector, nil
+}
+
+func newPolicy(p Policy) (*policy, error) {
+	result := &policy{Policy: p}
+	for _, d := range p.Detectors {
+		switch d {
+		case DetectorCreditCard:
+			result.detectors = append(result.detectors, detector{re: creditCardRegex, validate: isValidCreditCard})
+		case DetectorSSN:
+			result.detectors = append(result.detectors, detector{re: ssnRegex, validate: isValidSSN})
+		}
+	}
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: invalid pattern %q: %w", p.Name, pattern, err)
+		}
+		result.detectors = append(result.detectors, detector{re: re})
+	}
+	if p.Classifier.isEnabled() {
+		creds := insecure.NewCredentials()
+		if p.Classifier.UseTLS {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		conn, err := grpc.Dial(p.Classifier.Address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: unable to create the classifier client: %w", p.Name, err)
+		}
+		result.conn = conn
+		result.client = pb.NewClassifierClient(conn)
+	}
+	return result, nil
+}
+
+// Close releases the connections to the classifiers
+func (i *Inspector) Close() {
+	for _, p := range i.policies {
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	}
+}
+
+// GetPolicies returns the defined policies among the specified ones
+func (i *Inspector) GetPolicies(names []string) []string {
+	var result []string
+	for _, name := range names {
+		if _, ok := i.policies[name]; ok && !util.Contains(result, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// HasAction returns true if at least one of the specified policies has the given action
+func (i *Inspector) HasAction(names []string, action string) bool {
+	for _, name := range names {
+		if p, ok := i.policies[name]; ok && util.Contains(p.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSizeAllowed returns true if a file with the specified size can be inspected
+func (i *Inspector) IsSizeAllowed(size int64) bool {
+	return i.maxSize <= 0 || size <= i.maxSize
+}
+
+// RejectOnError returns true if the uploads must be rejected if the inspection fails
+func (i *Inspector) RejectOnError() bool {
+	return i.rejectOnError
+}
+
+// Metadata defines the information about the inspected file sent to the classifiers
+type Metadata struct {
+	Username    string
+	VirtualPath string
+	Protocol    string
+}
+
+// Violation defines a policy violation
+type Violation struct {
+	Policy  string
+	Actions []string
+	// number of matches for the detectors and patterns
+	Matches int
+	// labels and reason returned by the classifier, if any
+	Labels []string
+	Reason string
+}
+
+// HasAction returns true if the violated policy has the specified action
+func (v *Violation) HasAction(action string) bool {
+	return util.Contains(v.Actions, action)
+}
+
+// Result defines the inspection result
+type Result struct {
+	Violations []Violation
+}
+
+// HasAction returns true if at least a violated policy has the specified action
+func (r *Result) HasAction(action string) bool {
+	for _, v := range r.Violations {
+		if v.HasAction(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPolicies returns the names of the violated policies with the specified action
+func (r *Result) GetPolicies(action string) []string {
+	var result []string
+	for _, v := range r.Violations {
+		if v.HasAction(action) {
+			result = append(result, v.Policy)
+		}
+	}
+	return result
+}
+
+// GetLabels returns the labels for the violated policies with the specified action
+func (r *Result) GetLabels(action string) []string {
+	var result []string
+	for _, v := range r.Violations {
+		if v.HasAction(action) {
+			result = append(result, v.Labels...)
+		}
+	}
+	return util.RemoveDuplicates(result, false)
+}
+
+// Describe returns a human readable description of the violations with the specified action
+func (r *Result) Describe(action string) string {
+	var descriptions []string
+	for _, v := range r.Violations {
+		if !v.HasAction(action) {
+			continue
+		}
+		desc := fmt.Sprintf("policy %q", v.Policy)
+		if v.Matches > 0 {
+			desc += fmt.Sprintf(", matches: %d", v.Matches)
+		}
+		if len(v.Labels) > 0 {
+			desc += fmt.Sprintf(", labels: %s", strings.Join(v.Labels, ","))
+		}
+		if v.Reason != "" {
+			desc += fmt.Sprintf(", reason: %s", v.Reason)
+		}
+		descriptions = append(descriptions, desc)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+type inspection struct {
+	policy    *policy
+	matches   int
+	stream    pb.Classifier_ClassifyClient
+	cancelFn  context.CancelFunc
+	violation *pb.ClassifyResponse
+	err       error
+}
+
+func (s *inspection) startClassifier(meta Metadata) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), s.policy.Classifier.getTimeout())
+	s.cancelFn = cancelFn
+	stream, err := s.policy.client.Classify(ctx)
+	if err == nil {
+		err = stream.Send(&pb.ClassifyRequest{
+			Request: &pb.ClassifyRequest_Metadata{
+				Metadata: &pb.ClassifyMetadata{
+					Username: meta.Username,
+					Path:     meta.VirtualPath,
+					Policy:   s.policy.Name,
+					Protocol: meta.Protocol,
+				},
+			},
+		})
+	}
+	if err != nil {
+		s.setClassifierError(err)
+		return
+	}
+	s.stream = stream
+}
+
+func (s *inspection) setClassifierError(err error) {
+	s.err = fmt.Errorf("policy %q: classifier error: %w", s.policy.Name, err)
+	s.stream = nil
+}
+
+// scan counts the matches in data. The first overlap bytes were already
+// scanned, they are included to find the matches across chunk boundaries
+func (s *inspection) scan(data []byte, overlap int) {
+	if s.matches >= s.policy.MinMatches {
+		return
+	}
+	for _, d := range s.policy.detectors {
+		for _, loc := range d.re.FindAllIndex(data, -1) {
+			if loc[1] <= overlap {
+				continue
+			}
+			if d.validate != nil && !d.validate(string(data[loc[0]:loc[1]])) {
+				continue
+			}
+			s.matches++
+		}
+	}
+}
+
+func (s *inspection) send(data []byte) {
+	if s.stream == nil {
+		return
+	}
+	if err := s.stream.Send(&pb.ClassifyRequest{Request: &pb.ClassifyRequest_Data{Data: data}}); err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.setClassifierError(err)
+			return
+		}
+		// the server closed the stream, the error, if any, is returned by CloseAndRecv
+	}
+}
+
+func (s *inspection) finish() {
+	if s.stream != nil {
+		resp, err := s.stream.CloseAndRecv()
+		if err != nil {
+			s.setClassifierError(err)
+		} else if resp.GetViolation() {
+			s.violation = resp
+		}
+	}
+	if s.cancelFn != nil {
+		s.cancelFn()
+	}
+}
+
+func (s *inspection) getViolation() (Violation, bool) {
+	if s.matches < s.policy.MinMatches && s.violation == nil {
+		return Violation{}, false
+	}
+	v := Violation{
+		Policy:  s.policy.Name,
+		Actions: s.policy.Actions,
+	}
+	if s.matches >= s.policy.MinMatches {
+		v.Matches = s.matches
+	}
+	if s.violation != nil {
+		v.Labels = s.violation.GetLabels()
+		v.Reason = s.violation.GetReason()
+	}
+	return v, true
+}
+
+// Inspect reads the content from r and checks it against the specified policies.
+// Unknown policies are ignored. The returned error, if any, reports the policies
+// that cannot be fully evaluated, the violations found are returned anyway
+func (i *Inspector) Inspect(r io.Reader, policies []string, meta Metadata) (*Result, error) {
+	var inspections []*inspection
+	for _, name := range i.GetPolicies(policies) {
+		s := &inspection{policy: i.policies[name]}
+		if s.policy.client != nil {
+			s.startClassifier(meta)
+		}
+		inspections = append(inspections, s)
+	}
+	var errRead error
+	buf := make([]byte, overlapSize+chunkSize)
+	overlap := 0
+	for {
+		n, err := io.ReadFull(r, buf[overlap:])
+		if n > 0 {
+			data := buf[:overlap+n]
+			for _, s := range inspections {
+				s.scan(data, overlap)
+				s.send(data[overlap:])
+			}
+			tail := len(data) - overlapSize
+			if tail < 0 {
+				tail = 0
+			}
+			overlap = copy(buf, data[tail:])
+		}
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				errRead = err
+			}
+			break
+		}
+	}
+	result := &Result{}
+	var errs []error
+	if errRead != nil {
+		errs = append(errs, fmt.Errorf("unable to read the file: %w", errRead))
+	}
+	for _, s := range inspections {
+		if errRead != nil && s.stream != nil {
+			s.stream.CloseSend() //nolint:errcheck
+			s.stream = nil
+		}
+		s.finish()
+		if v, ok := s.getViolation(); ok {
+			result.Violations = append(result.Violations, v)
+		}
+		if s.err != nil {
+			errs = append(errs, s.err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// isValidCreditCard returns true if the specified number, ignoring
+// the separators, has a valid length and checksum
+func isValidCreditCard(s string) bool {
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits = append(digits, s[i]-'0')
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i])
+		if (len(digits)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isValidSSN returns true if the specified US social security number, in
+// the AAA-GG-SSSS format, uses valid area, group and serial numbers
+func isValidSSN(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 {
+		return false
+	}
+	area, group, serial := parts[0], parts[1], parts[2]
+	if area == "000" || area == "666" || area[0] == '9' {
+		return false
+	}
+	return group != "00" && serial != "0000"
+}
@@ -0,0 +1,305 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dlp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	pb "github.com/drakkan/sftpgo/v2/internal/dlp/proto"
+)
+
+const (
+	testCreditCard = "4111 1111 1111 1111"
+	testSSN        = "123-45-6789"
+)
+
+type fakeClassifier struct {
+	pb.UnimplementedClassifierServer
+	keyword  string
+	metadata chan *pb.ClassifyMetadata
+}
+
+func (c *fakeClassifier) Classify(stream pb.Classifier_ClassifyServer) error {
+	var data []byte
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if meta := req.GetMetadata(); meta != nil {
+			c.metadata <- meta
+			continue
+		}
+		data = append(data, req.GetData()...)
+	}
+	resp := &pb.ClassifyResponse{}
+	if bytes.Contains(data, []byte(c.keyword)) {
+		resp.Violation = true
+		resp.Labels = []string{"confidential"}
+		resp.Reason = "keyword found"
+	}
+	return stream.SendAndClose(resp)
+}
+
+func startFakeClassifier(t *testing.T, keyword string) (string, chan *pb.ClassifyMetadata) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	classifier := &fakeClassifier{
+		keyword:  keyword,
+		metadata: make(chan *pb.ClassifyMetadata, 10),
+	}
+	server := grpc.NewServer()
+	pb.RegisterClassifierServer(server, classifier)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String(), classifier.metadata
+}
+
+func TestPolicyValidation(t *testing.T) {
+	_, err := NewInspector(Config{Policies: []Policy{{Name: " ", Detectors: []string{DetectorSSN}}}})
+	assert.ErrorContains(t, err, "name is mandatory")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "a b", Detectors: []string{DetectorSSN}}}})
+	assert.ErrorContains(t, err, "invalid policy name")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "p", Detectors: []string{"iban"},
+		Actions: []string{ActionBlock}}}})
+	assert.ErrorContains(t, err, "invalid detector")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "p", Detectors: []string{DetectorSSN}}}})
+	assert.ErrorContains(t, err, "at least one action")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "p", Detectors: []string{DetectorSSN},
+		Actions: []string{"quarantine"}}}})
+	assert.ErrorContains(t, err, "invalid action")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "p", Actions: []string{ActionTag}}}})
+	assert.ErrorContains(t, err, "at least a detector")
+	_, err = NewInspector(Config{Policies: []Policy{{Name: "p", Patterns: []string{"[a-"},
+		Actions: []string{ActionTag}}}})
+	assert.ErrorContains(t, err, "invalid pattern")
+	_, err = NewInspector(Config{Policies: []Policy{
+		{Name: "p", Detectors: []string{DetectorSSN}, Actions: []string{ActionTag}},
+		{Name: "p", Detectors: []string{DetectorCreditCard}, Actions: []string{ActionTag}},
+	}})
+	assert.ErrorContains(t, err, "duplicate policy")
+
+	c := Config{
+		Policies: []Policy{
+			{
+				Name:      "p1",
+				Detectors: []string{DetectorSSN, " ssn "},
+				Actions:   []string{ActionBlock, ActionNotify},
+			},
+			{
+				Name:       "p2",
+				Patterns:   []string{"secret"},
+				MinMatches: 2,
+				Actions:    []string{ActionTag},
+			},
+		},
+		MaxSize: 1,
+	}
+	assert.True(t, c.IsEnabled())
+	inspector, err := NewInspector(c)
+	require.NoError(t, err)
+	defer inspector.Close()
+
+	assert.Len(t, inspector.policies["p1"].Detectors, 1)
+	assert.Equal(t, 1, inspector.policies["p1"].MinMatches)
+	assert.Equal(t, []string{"p2", "p1"}, inspector.GetPolicies([]string{"p2", "unknown", "p1", "p2"}))
+	assert.Len(t, inspector.GetPolicies(nil), 0)
+	assert.True(t, inspector.HasAction([]string{"p1"}, ActionBlock))
+	assert.False(t, inspector.HasAction([]string{"p2", "unknown"}, ActionBlock))
+	assert.True(t, inspector.IsSizeAllowed(1048576))
+	assert.False(t, inspector.IsSizeAllowed(1048577))
+	assert.False(t, inspector.RejectOnError())
+	assert.False(t, (&Config{}).IsEnabled())
+}
+
+func TestValidators(t *testing.T) {
+	assert.True(t, isValidCreditCard(testCreditCard))
+	assert.True(t, isValidCreditCard("5500-0000-0000-0004"))
+	assert.True(t, isValidCreditCard("378282246310005"))
+	assert.False(t, isValidCreditCard("4111 1111 1111 1112"))
+	assert.False(t, isValidCreditCard("1234567890"))
+	assert.False(t, isValidCreditCard("12345678901234567890"))
+
+	assert.True(t, isValidSSN(testSSN))
+	assert.False(t, isValidSSN("000-45-6789"))
+	assert.False(t, isValidSSN("666-45-6789"))
+	assert.False(t, isValidSSN("912-45-6789"))
+	assert.False(t, isValidSSN("123-00-6789"))
+	assert.False(t, isValidSSN("123-45-0000"))
+	assert.False(t, isValidSSN("123456789"))
+}
+
+func TestInspectDetectors(t *testing.T) {
+	inspector, err := NewInspector(Config{
+		Policies: []Policy{
+			{
+				Name:       "pci",
+				Detectors:  []string{DetectorCreditCard},
+				MinMatches: 2,
+				Actions:    []string{ActionBlock, ActionNotify},
+			},
+			{
+				Name:      "pii",
+				Detectors: []string{DetectorSSN},
+				Patterns:  []string{`(?i)top secret`},
+				Actions:   []string{ActionTag},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer inspector.Close()
+
+	policies := []string{"pci", "pii"}
+	result, err := inspector.Inspect(strings.NewReader("nothing to see here 4111 1111 1111 1112 000-12-3456"),
+		policies, Metadata{})
+	require.NoError(t, err)
+	assert.Len(t, result.Violations, 0)
+	assert.False(t, result.HasAction(ActionBlock))
+
+	// a single credit card number is not enough for the pci policy
+	result, err = inspector.Inspect(strings.NewReader("card: "+testCreditCard+" ssn: "+testSSN), policies, Metadata{})
+	require.NoError(t, err)
+	require.Len(t, result.Violations, 1)
+	assert.Equal(t, "pii", result.Violations[0].Policy)
+	assert.Equal(t, 1, result.Violations[0].Matches)
+	assert.True(t, result.HasAction(ActionTag))
+	assert.False(t, result.HasAction(ActionBlock))
+	assert.Equal(t, []string{"pii"}, result.GetPolicies(ActionTag))
+	assert.Len(t, result.GetPolicies(ActionNotify), 0)
+	// the matches are detected across chunk boundaries and only counted once
+	content := bytes.Repeat([]byte("a "), (chunkSize-10)/2)
+	content = append(content, []byte(testCreditCard+" TOP SECRET ")...)
+	content = append(content, bytes.Repeat([]byte("b "), chunkSize/2)...)
+	content = append(content, []byte("5500-0000-0000-0004")...)
+	result, err = inspector.Inspect(bytes.NewReader(content), policies, Metadata{})
+	require.NoError(t, err)
+	require.Len(t, result.Violations, 2)
+	assert.Equal(t, "pci", result.Violations[0].Policy)
+	assert.Equal(t, 2, result.Violations[0].Matches)
+	assert.Equal(t, "pii", result.Violations[1].Policy)
+	assert.Equal(t, 1, result.Violations[1].Matches)
+	assert.True(t, result.HasAction(ActionBlock))
+	assert.Equal(t, []string{"pci"}, result.GetPolicies(ActionNotify))
+	assert.Contains(t, result.Describe(ActionNotify), `policy "pci", matches: 2`)
+	assert.NotContains(t, result.Describe(ActionNotify), "pii")
+	// only the specified policies are used
+	result, err = inspector.Inspect(bytes.NewReader(content), []string{"pii"}, Metadata{})
+	require.NoError(t, err)
+	require.Len(t, result.Violations, 1)
+
+	_, err = inspector.Inspect(iotestErrReader{}, policies, Metadata{})
+	assert.ErrorContains(t, err, "unable to read the file")
+}
+
+func TestInspectClassifier(t *testing.T) {
+	addr, metadataCh := startFakeClassifier(t, "confidential")
+	inspector, err := NewInspector(Config{
+		Policies: []Policy{
+			{
+				Name:      "classifier",
+				Detectors: []string{DetectorSSN},
+				Classifier: ClassifierConfig{
+					Address: addr,
+					Timeout: 5,
+				},
+				Actions: []string{ActionBlock},
+			},
+		},
+		RejectOnError: true,
+	})
+	require.NoError(t, err)
+	defer inspector.Close()
+	assert.True(t, inspector.RejectOnError())
+
+	meta := Metadata{
+		Username:    "user",
+		VirtualPath: "/dir/file.txt",
+		Protocol:    "SFTP",
+	}
+	result, err := inspector.Inspect(strings.NewReader("public document"), []string{"classifier"}, meta)
+	require.NoError(t, err)
+	assert.Len(t, result.Violations, 0)
+	select {
+	case received := <-metadataCh:
+		assert.Equal(t, "user", received.GetUsername())
+		assert.Equal(t, "/dir/file.txt", received.GetPath())
+		assert.Equal(t, "classifier", received.GetPolicy())
+		assert.Equal(t, "SFTP", received.GetProtocol())
+	case <-time.After(2 * time.Second):
+		t.Fatal("classifier metadata not received")
+	}
+
+	content := bytes.Repeat([]byte("data "), chunkSize)
+	content = append(content, []byte("confidential")...)
+	result, err = inspector.Inspect(bytes.NewReader(content), []string{"classifier"}, meta)
+	require.NoError(t, err)
+	require.Len(t, result.Violations, 1)
+	assert.Equal(t, 0, result.Violations[0].Matches)
+	assert.Equal(t, []string{"confidential"}, result.Violations[0].Labels)
+	assert.Equal(t, "keyword found", result.Violations[0].Reason)
+	assert.Equal(t, []string{"confidential"}, result.GetLabels(ActionBlock))
+	assert.Contains(t, result.Describe(ActionBlock), "labels: confidential, reason: keyword found")
+	assert.Len(t, metadataCh, 1)
+}
+
+func TestInspectClassifierError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	err = listener.Close()
+	require.NoError(t, err)
+
+	inspector, err := NewInspector(Config{
+		Policies: []Policy{
+			{
+				Name:      "p",
+				Detectors: []string{DetectorSSN},
+				Classifier: ClassifierConfig{
+					Address: addr,
+					Timeout: 1,
+				},
+				Actions: []string{ActionTag},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer inspector.Close()
+	// the violations found by the detectors are returned even if the classifier fails
+	result, err := inspector.Inspect(strings.NewReader(testSSN), []string{"p"}, Metadata{})
+	assert.ErrorContains(t, err, `policy "p": classifier error`)
+	require.Len(t, result.Violations, 1)
+	assert.Equal(t, 1, result.Violations[0].Matches)
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read(_ []byte) (int, error) {
+	return 0, errors.New("read error")
+}
@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: classifier.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Classifier_Classify_FullMethodName = "/sftpgo.dlp.v1.Classifier/Classify"
+)
+
+// ClassifierClient is the client API for Classifier service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ClassifierClient interface {
+	// Classify inspects a file. The first message must contain the file
+	// metadata, the following ones the file contents
+	Classify(ctx context.Context, opts ...grpc.CallOption) (Classifier_ClassifyClient, error)
+}
+
+type classifierClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClassifierClient(cc grpc.ClientConnInterface) ClassifierClient {
+	return &classifierClient{cc}
+}
+
+func (c *classifierClient) Classify(ctx context.Context, opts ...grpc.CallOption) (Classifier_ClassifyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Classifier_ServiceDesc.Streams[0], Classifier_Classify_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &classifierClassifyClient{stream}
+	return x, nil
+}
+
+type Classifier_ClassifyClient interface {
+	Send(*ClassifyRequest) error
+	CloseAndRecv() (*ClassifyResponse, error)
+	grpc.ClientStream
+}
+
+type classifierClassifyClient struct {
+	grpc.ClientStream
+}
+
+func (x *classifierClassifyClient) Send(m *ClassifyRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *classifierClassifyClient) CloseAndRecv() (*ClassifyResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ClassifyResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ClassifierServer is the server API for Classifier service.
+// All implementations must embed UnimplementedClassifierServer
+// for forward compatibility
+type ClassifierServer interface {
+	// Classify inspects a file. The first message must contain the file
+	// metadata, the following ones the file contents
+	Classify(Classifier_ClassifyServer) error
+	mustEmbedUnimplementedClassifierServer()
+}
+
+// UnimplementedClassifierServer must be embedded to have forward compatible implementations.
+type UnimplementedClassifierServer struct {
+}
+
+func (UnimplementedClassifierServer) Classify(Classifier_ClassifyServer) error {
+	return status.Errorf(codes.Unimplemented, "method Classify not implemented")
+}
+func (UnimplementedClassifierServer) mustEmbedUnimplementedClassifierServer() {}
+
+// UnsafeClassifierServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClassifierServer will
+// result in compilation errors.
+type UnsafeClassifierServer interface {
+	mustEmbedUnimplementedClassifierServer()
+}
+
+func RegisterClassifierServer(s grpc.ServiceRegistrar, srv ClassifierServer) {
+	s.RegisterService(&Classifier_ServiceDesc, srv)
+}
+
+func _Classifier_Classify_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClassifierServer).Classify(&classifierClassifyServer{stream})
+}
+
+type Classifier_ClassifyServer interface {
+	SendAndClose(*ClassifyResponse) error
+	Recv() (*ClassifyRequest, error)
+	grpc.ServerStream
+}
+
+type classifierClassifyServer struct {
+	grpc.ServerStream
+}
+
+func (x *classifierClassifyServer) SendAndClose(m *ClassifyResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *classifierClassifyServer) Recv() (*ClassifyRequest, error) {
+	m := new(ClassifyRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Classifier_ServiceDesc is the grpc.ServiceDesc for Classifier service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Classifier_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sftpgo.dlp.v1.Classifier",
+	HandlerType: (*ClassifierServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Classify",
+			Handler:       _Classifier_Classify_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "classifier.proto",
+}
@@ -19,16 +19,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
+	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -81,6 +88,15 @@ type Binding struct {
 	// connection is requested and this can, depending on the DNS configuration, take a noticeable
 	// amount of time. Enable this setting only if you have a dynamic IP address
 	PassiveHost string `json:"passive_host" mapstructure:"passive_host"`
+	// PassiveIPHook defines an absolute path to an external program or an HTTP URL used to get
+	// the IP address for passive connections. It is executed each time a passive connection is
+	// requested and it is useful behind a NAT with a dynamic public IP address.
+	// The program must print the IP address to stdout, the HTTP URL must return it in the
+	// response body
+	PassiveIPHook string `json:"passive_ip_hook" mapstructure:"passive_ip_hook"`
+	// Port range for passive data connections for this binding.
+	// If not specified the global passive port range is used
+	PassivePortRange PortRange `json:"passive_port_range" mapstructure:"passive_port_range"`
 	// Set to 1 to require client certificate authentication.
 	// Set to 2 to require a client certificate and verfify it if given. In this mode
 	// the client is allowed not to send a certificate.
@@ -107,9 +123,49 @@ type Binding struct {
 	// Please note that disabling the security checks you will make the FTP service vulnerable to bounce attacks
 	// on active data connections, so change the default value only if you are on a trusted/internal network
 	ActiveConnectionsSecurity int `json:"active_connections_security" mapstructure:"active_connections_security"`
+	// TLSSessionReuse defines the TLS session resumption requirements for data connections.
+	// Supported values:
+	// - 0 data connections are not required to resume the TLS session. This is the default
+	// - 1 data connections must resume a TLS session established with this server
+	// - 2 data connections not resuming a TLS session are logged but allowed, useful for
+	//   clients that cannot reuse TLS sessions
+	TLSSessionReuse int `json:"tls_session_reuse" mapstructure:"tls_session_reuse"`
+	// Maximum upload bandwidth as KB/s shared by all the transfers on this binding, 0 means unlimited.
+	// Active transfers get a fair share of the available bandwidth
+	AggregateUploadBandwidth int64 `json:"aggregate_upload_bandwidth" mapstructure:"aggregate_upload_bandwidth"`
+	// Maximum download bandwidth as KB/s shared by all the transfers on this binding, 0 means unlimited
+	AggregateDownloadBandwidth int64 `json:"aggregate_download_bandwidth" mapstructure:"aggregate_download_bandwidth"`
+	// Name of the LDAP directory to use for password authentication on this binding.
+	// Leave empty to use the configured authentication methods
+	LDAPDirectory string `json:"ldap_directory" mapstructure:"ldap_directory"`
+	// Name of the RADIUS server to use for password authentication on this binding.
+	// It cannot be used together with an LDAP directory
+	RADIUSServer string `json:"radius_server" mapstructure:"radius_server"`
+	// Countries and autonomous systems allowed or denied to connect to this binding.
+	// They are ignored if GeoIP is not configured
+	GeoIP geoip.Filter `json:"geoip" mapstructure:"geoip"`
 	// Debug enables the FTP debug mode. In debug mode, every FTP command will be logged
-	Debug   bool `json:"debug" mapstructure:"debug"`
-	ciphers []uint16
+	Debug bool `json:"debug" mapstructure:"debug"`
+	// Virtual hosts allow to serve different host names from this binding with
+	// their own certificates and allowed user roles. FTP clients are matched
+	// using the server name requested with TLS SNI
+	VirtualHosts []VirtualHost `json:"virtual_hosts" mapstructure:"virtual_hosts"`
+	ciphers      []uint16
+}
+
+// VirtualHost defines the configuration for a host name served by a binding.
+// Connections are matched against the server name requested by the client
+// using TLS SNI, plain text connections never match a virtual host
+type VirtualHost struct {
+	// Host name, for example "ftp.example.com"
+	Host string `json:"host" mapstructure:"host"`
+	// Certificate and matching private key for this host, if empty the binding
+	// ones will be used
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Users must have one of these roles to login using this host.
+	// Leave empty to allow any user
+	AllowedRoles []string `json:"allowed_roles" mapstructure:"allowed_roles"`
 }
 
 func (b *Binding) setCiphers() {
@@ -119,10 +175,53 @@ func (b *Binding) setCiphers() {
 	}
 }
 
+func (b *Binding) isTLSSessionReuseEnabled() bool {
+	return b.TLSSessionReuse == 1 || b.TLSSessionReuse == 2
+}
+
 func (b *Binding) isMutualTLSEnabled() bool {
 	return b.ClientAuthType == 1 || b.ClientAuthType == 2
 }
 
+func (b *Binding) checkVirtualHosts() error {
+	hosts := make(map[string]bool)
+	for idx := range b.VirtualHosts {
+		vh := &b.VirtualHosts[idx]
+		vh.Host = strings.ToLower(strings.TrimSpace(vh.Host))
+		if vh.Host == "" {
+			return fmt.Errorf("virtual host %d for binding %q: host is mandatory", idx, b.GetAddress())
+		}
+		if hosts[vh.Host] {
+			return fmt.Errorf("virtual host %q for binding %q is duplicated", vh.Host, b.GetAddress())
+		}
+		hosts[vh.Host] = true
+		vh.AllowedRoles = util.RemoveDuplicates(vh.AllowedRoles, false)
+	}
+	return nil
+}
+
+func (b *Binding) getVirtualHostsCertIDs() map[string]string {
+	certIDs := make(map[string]string)
+	for _, vh := range b.VirtualHosts {
+		if getConfigPath(vh.CertificateFile, "") != "" && getConfigPath(vh.CertificateKeyFile, "") != "" {
+			certIDs[strings.ToLower(vh.Host)] = common.GetVirtualHostCertificateID(b.GetAddress(), vh.Host)
+		}
+	}
+	return certIDs
+}
+
+// isRoleAllowed returns true if users with the specified role can login
+// using the specified host
+func (b *Binding) isRoleAllowed(host, role string) bool {
+	host = strings.ToLower(host)
+	for _, vh := range b.VirtualHosts {
+		if vh.Host == host {
+			return len(vh.AllowedRoles) == 0 || util.Contains(vh.AllowedRoles, role)
+		}
+	}
+	return true
+}
+
 // GetAddress returns the binding address
 func (b *Binding) GetAddress() string {
 	return fmt.Sprintf("%s:%d", b.Address, b.Port)
@@ -140,10 +239,52 @@ func (b *Binding) checkSecuritySettings() error {
 	if b.ActiveConnectionsSecurity < 0 || b.ActiveConnectionsSecurity > 1 {
 		return fmt.Errorf("invalid active_connections_security: %v", b.ActiveConnectionsSecurity)
 	}
+	if b.TLSSessionReuse < 0 || b.TLSSessionReuse > 2 {
+		return fmt.Errorf("invalid tls_session_reuse: %v", b.TLSSessionReuse)
+	}
+	return nil
+}
+
+func (b *Binding) checkPassivePortRange() error {
+	if b.PassivePortRange.Start == 0 && b.PassivePortRange.End == 0 {
+		return nil
+	}
+	if !b.PassivePortRange.isValid() {
+		return fmt.Errorf("invalid passive port range: %d-%d", b.PassivePortRange.Start, b.PassivePortRange.End)
+	}
 	return nil
 }
 
+// getPassivePortRange returns the passive port range for this binding, if any,
+// or the global one
+func (b *Binding) getPassivePortRange(globalRange PortRange) *ftpserver.PortRange {
+	portRange := globalRange
+	if b.PassivePortRange.isValid() {
+		portRange = b.PassivePortRange
+	}
+	if !portRange.isValid() {
+		return nil
+	}
+	return &ftpserver.PortRange{
+		Start: portRange.Start,
+		End:   portRange.End,
+	}
+}
+
+func (b *Binding) checkAuthSources() error {
+	if b.LDAPDirectory != "" && b.RADIUSServer != "" {
+		return fmt.Errorf("binding %q: an LDAP directory and a RADIUS server cannot be used together", b.GetAddress())
+	}
+	if err := dataprovider.CheckLDAPDirectory(b.LDAPDirectory); err != nil {
+		return err
+	}
+	return dataprovider.CheckRADIUSServer(b.RADIUSServer)
+}
+
 func (b *Binding) checkPassiveIP() error {
+	if b.PassiveIPHook != "" && !strings.HasPrefix(b.PassiveIPHook, "http") && !filepath.IsAbs(b.PassiveIPHook) {
+		return fmt.Errorf("invalid passive IP hook %q", b.PassiveIPHook)
+	}
 	if b.ForcePassiveIP != "" {
 		ip, err := parsePassiveIP(b.ForcePassiveIP)
 		if err != nil {
@@ -178,6 +319,9 @@ func (b *Binding) getPassiveIP(cc ftpserver.ClientContext) (string, error) {
 	if b.ForcePassiveIP != "" {
 		return b.ForcePassiveIP, nil
 	}
+	if b.PassiveIPHook != "" {
+		return b.executePassiveIPHook(cc)
+	}
 	if b.PassiveHost != "" {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -194,6 +338,65 @@ func (b *Binding) getPassiveIP(cc ftpserver.ClientContext) (string, error) {
 	return strings.Split(cc.LocalAddr().String(), ":")[0], nil
 }
 
+func (b *Binding) executePassiveIPHook(cc ftpserver.ClientContext) (string, error) {
+	clientIP := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	localIP := util.GetIPFromRemoteAddress(cc.LocalAddr().String())
+	startTime := time.Now()
+	var out []byte
+
+	if strings.HasPrefix(b.PassiveIPHook, "http") {
+		u, err := url.Parse(b.PassiveIPHook)
+		if err != nil {
+			logger.Error(logSender, "", "invalid passive IP hook %q: %v", b.PassiveIPHook, err)
+			return "", err
+		}
+		q := u.Query()
+		q.Add("client_ip", clientIP)
+		q.Add("local_ip", localIP)
+		u.RawQuery = q.Encode()
+
+		resp, err := httpclient.Get(u.String())
+		if err != nil {
+			logger.Error(logSender, "", "unable to execute passive IP hook: %v", err)
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			logger.Error(logSender, "", "passive IP hook returned unexpected status code: %d", resp.StatusCode)
+			return "", fmt.Errorf("unexpected status code from passive IP hook: %d", resp.StatusCode)
+		}
+		out, err = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			logger.Error(logSender, "", "unable to read the passive IP hook response: %v", err)
+			return "", err
+		}
+	} else {
+		timeout, env, args := command.GetConfig(b.PassiveIPHook, command.HookFTPPassiveIP)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, b.PassiveIPHook, args...)
+		cmd.Env = append(env,
+			fmt.Sprintf("SFTPGO_FTP_CLIENT_IP=%s", clientIP),
+			fmt.Sprintf("SFTPGO_FTP_LOCAL_IP=%s", localIP))
+		var err error
+		out, err = cmd.Output()
+		if err != nil {
+			logger.Error(logSender, "", "unable to execute passive IP hook: %v", err)
+			return "", err
+		}
+	}
+	ip, err := parsePassiveIP(strings.TrimSpace(string(out)))
+	if err != nil {
+		logger.Error(logSender, "", "invalid IP returned by the passive IP hook: %v", err)
+		return "", err
+	}
+	logger.Debug(logSender, "", "passive IP hook executed, client IP %q, passive IP %q, elapsed: %s",
+		clientIP, ip, time.Since(startTime))
+	return ip, nil
+}
+
 func (b *Binding) passiveIPResolver(cc ftpserver.ClientContext) (string, error) {
 	if len(b.PassiveIPOverrides) > 0 {
 		clientIP := net.ParseIP(util.GetIPFromRemoteAddress(cc.RemoteAddr().String()))
@@ -241,6 +444,10 @@ type PortRange struct {
 	End int `json:"end" mapstructure:"end"`
 }
 
+func (p *PortRange) isValid() bool {
+	return p.Start > 0 && p.End > p.Start
+}
+
 // ServiceStatus defines the service status
 type ServiceStatus struct {
 	IsActive         bool      `json:"is_active"`
@@ -268,6 +475,9 @@ type Configuration struct {
 	// CARevocationLists defines a set a revocation lists, one for each root CA, to be used to check
 	// if a client certificate has been revoked
 	CARevocationLists []string `json:"ca_revocation_lists" mapstructure:"ca_revocation_lists"`
+	// RevocationCheck defines the OCSP checks for client certificates and if certificates
+	// whose revocation status cannot be determined must be rejected
+	RevocationCheck common.RevocationConfig `json:"revocation_check" mapstructure:"revocation_check"`
 	// Do not impose the port 20 for active data transfer. Enabling this option allows to run SFTPGo with less privilege
 	ActiveTransfersPortNon20 bool `json:"active_transfers_port_non_20" mapstructure:"active_transfers_port_non_20"`
 	// Set to true to disable active FTP
@@ -315,6 +525,17 @@ func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
 				ID:   binding.GetAddress(),
 			})
 		}
+		for _, vh := range binding.VirtualHosts {
+			certificateFile := getConfigPath(vh.CertificateFile, configDir)
+			certificateKeyFile := getConfigPath(vh.CertificateKeyFile, configDir)
+			if certificateFile != "" && certificateKeyFile != "" {
+				keyPairs = append(keyPairs, common.TLSKeyPair{
+					Cert: certificateFile,
+					Key:  certificateKeyFile,
+					ID:   common.GetVirtualHostCertificateID(binding.GetAddress(), vh.Host),
+				})
+			}
+		}
 	}
 	var certificateFile, certificateKeyFile string
 	if c.acmeDomain != "" {
@@ -383,6 +604,9 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := mgr.LoadCRLs(); err != nil {
 			return err
 		}
+		if err := mgr.SetRevocationConfig(c.RevocationCheck); err != nil {
+			return err
+		}
 		certMgr = mgr
 	}
 	serviceStatus = ServiceStatus{
@@ -424,6 +648,14 @@ func ReloadCertificateMgr() error {
 	return nil
 }
 
+// ReloadRevocationData reloads the CRLs and removes the cached OCSP responses
+func ReloadRevocationData() error {
+	if certMgr != nil {
+		return certMgr.ReloadRevocationData()
+	}
+	return nil
+}
+
 // GetStatus returns the server status
 func GetStatus() ServiceStatus {
 	return serviceStatus
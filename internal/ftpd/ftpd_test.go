@@ -15,13 +15,16 @@
 package ftpd_test
 
 import (
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"net"
@@ -62,6 +65,7 @@ const (
 	ftpServerAddr   = "127.0.0.1:2121"
 	sftpServerAddr  = "127.0.0.1:2122"
 	ftpSrvAddrTLS   = "127.0.0.1:2124" // ftp server with implicit tls
+	ftpSrvAddrSess  = "127.0.0.1:2125" // ftp server requiring TLS session resumption
 	defaultUsername = "test_user_ftp"
 	defaultPassword = "test_password"
 	osWindows       = "windows"
@@ -412,6 +416,11 @@ func TestMain(m *testing.M) {
 			Port:    2124,
 			TLSMode: 2,
 		},
+		{
+			Port:            2125,
+			TLSMode:         1,
+			TLSSessionReuse: 1,
+		},
 	}
 	ftpdConf.CertificateFile = certPath
 	ftpdConf.CertificateKeyFile = keyPath
@@ -431,6 +440,7 @@ func TestMain(m *testing.M) {
 	}()
 
 	waitTCPListening(ftpdConf.Bindings[0].GetAddress())
+	waitTCPListening(ftpdConf.Bindings[1].GetAddress())
 	waitNoConnections()
 	startHTTPFs()
 
@@ -914,7 +924,7 @@ func TestAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.LoginMethodKerberos}, user.Filters.DeniedLoginMethods)
 
 	user.Password = emptyPwdPlaceholder
 	client, err := getFTPClient(user, true, nil)
@@ -1420,7 +1430,7 @@ func TestPreLoginHookReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.LoginMethodKerberos}, user.Filters.DeniedLoginMethods)
 	// now the same with an existing user
 	client, err = getFTPClient(u, false, nil)
 	if assert.NoError(t, err) {
@@ -3248,6 +3258,23 @@ func TestHASH(t *testing.T) {
 			assert.Equal(t, ftp.StatusFile, code)
 			assert.Contains(t, response, hash)
 
+			content, err := os.ReadFile(testFilePath)
+			assert.NoError(t, err)
+			md5Hash := md5.Sum(content)
+			code, response, err = client.SendCustomCommand(fmt.Sprintf("XMD5 %v", testFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+			assert.Contains(t, response, hex.EncodeToString(md5Hash[:]))
+
+			code, response, err = client.SendCustomCommand(fmt.Sprintf("XCRC %v 10 100", testFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+			assert.Contains(t, response, fmt.Sprintf("%08x", crc32.ChecksumIEEE(content[10:100])))
+
+			code, _, err = client.SendCustomCommand(fmt.Sprintf("XSHA512 %v 100 10", testFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusFileUnavailable, code)
+
 			err = client.Quit()
 			assert.NoError(t, err)
 
@@ -3267,6 +3294,38 @@ func TestHASH(t *testing.T) {
 		}
 	}
 
+	// hashing requires the download permission and files allowed by the filters
+	localUser, _, err = httpdtest.GetUserByUsername(localUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	localUser.Permissions["/"] = []string{dataprovider.PermListItems}
+	localUser.Permissions["/sub"] = []string{dataprovider.PermAny}
+	localUser.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/sub",
+			DeniedPatterns: []string{"*.denied"},
+		},
+	}
+	localUser, _, err = httpdtest.UpdateUser(localUser, http.StatusOK, "")
+	assert.NoError(t, err)
+	for _, name := range []string{testFileName, path.Join("sub", testFileName), path.Join("sub", "file.denied")} {
+		err = createTestFile(filepath.Join(localUser.GetHomeDir(), name), 100)
+		assert.NoError(t, err)
+	}
+	client, err := getFTPClientImplicitTLS(localUser)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCustomCommand(fmt.Sprintf("XSHA256 %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand(fmt.Sprintf("XSHA256 %v", path.Join("sub", testFileName)))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+		code, _, err = client.SendCustomCommand("XSHA256 sub/file.denied")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
 	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
@@ -3279,6 +3338,61 @@ func TestHASH(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTLSSessionReuse(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+
+	for _, tlsVersion := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		tlsConfig := &tls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true, // use this for tests only
+			MinVersion:         tlsVersion,
+			MaxVersion:         tlsVersion,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+		client, err := ftp.Dial(ftpSrvAddrSess, ftp.DialWithTimeout(5*time.Second),
+			ftp.DialWithExplicitTLS(tlsConfig))
+		if assert.NoError(t, err) {
+			err = client.Login(defaultUsername, defaultPassword)
+			assert.NoError(t, err)
+			err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+			assert.NoError(t, err)
+			err = ftpDownloadFile(testFileName, localDownloadPath, testFileSize, client, 0)
+			assert.NoError(t, err)
+			_, err = client.List("/")
+			assert.NoError(t, err)
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+		// data connections not resuming the TLS session are refused
+		tlsConfig.ClientSessionCache = nil
+		client, err = ftp.Dial(ftpSrvAddrSess, ftp.DialWithTimeout(5*time.Second),
+			ftp.DialWithExplicitTLS(tlsConfig))
+		if assert.NoError(t, err) {
+			err = client.Login(defaultUsername, defaultPassword)
+			assert.NoError(t, err)
+			_, err = client.List("/")
+			assert.Error(t, err)
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.Remove(localDownloadPath)
+	assert.NoError(t, err)
+}
+
 func TestCombine(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -3438,6 +3552,67 @@ func TestClientCertificateAuth(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestClientCertificatePinnedAuth(t *testing.T) {
+	tlsCert, err := tls.X509KeyPair([]byte(client1Crt), []byte(client1Key))
+	require.NoError(t, err)
+	x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	require.NoError(t, err)
+	fingerprint := sha256.Sum256(x509Cert.Raw)
+	// the certificate CN does not match the username
+	u := getTestUser()
+	u.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword}
+	u.Filters.TLSCertPins = []string{hex.EncodeToString(fingerprint[:])}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Len(t, user.Filters.TLSCertPins, 1)
+	tlsConfig := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, tlsCert)
+	client, err := getFTPClient(user, true, tlsConfig)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	user.Filters.TLSCertPins = []string{"CN=" + tlsClient2Username}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getFTPClient(user, true, tlsConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not pinned")
+	}
+	// pinned certificates are checked together with the TLS username
+	user.Filters.TLSUsername = sdk.TLSUsernameCN
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getFTPClient(user, true, tlsConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not pinned")
+	}
+
+	user.Filters.TLSUsername = sdk.TLSUsernameNone
+	user.Filters.TLSCertPins = []string{x509Cert.Subject.String()}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getFTPClient(user, true, tlsConfig)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestClientCertificateAndPwdAuth(t *testing.T) {
 	u := getTestUser()
 	u.Username = tlsClient1Username
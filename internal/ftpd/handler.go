@@ -15,6 +15,7 @@
 package ftpd
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +36,13 @@ import (
 var (
 	errNotImplemented   = errors.New("not implemented")
 	errCOMBNotSupported = errors.New("COMB is not supported for this filesystem")
+	hashAlgos           = map[ftpserver.HASHAlgo]string{
+		ftpserver.HASHAlgoCRC32:  "crc32",
+		ftpserver.HASHAlgoMD5:    "md5",
+		ftpserver.HASHAlgoSHA1:   "sha1",
+		ftpserver.HASHAlgoSHA256: "sha256",
+		ftpserver.HASHAlgoSHA512: "sha512",
+	}
 )
 
 // Connection details for an FTP connection.
@@ -163,6 +171,9 @@ func (c *Connection) Stat(name string) (os.FileInfo, error) {
 
 	fi, err := c.DoStat(name, 0, true)
 	if err != nil {
+		if archive, ok := c.GetArchiveDownload(name); ok {
+			return archive.FileInfo(), nil
+		}
 		if c.isListDirWithWildcards(path.Base(name)) {
 			c.doWildcardListDir = true
 			return vfs.NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
@@ -308,6 +319,26 @@ func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 	return c.ListDir(name)
 }
 
+// ComputeHash implements ClientDriverExtensionHasher.
+// The checksums stored while uploading are used, if available, instead of reading the whole file
+func (c *Connection) ComputeHash(name string, algo ftpserver.HASHAlgo, startOffset, endOffset int64) (string, error) {
+	c.UpdateLastActivity()
+
+	hashAlgo, ok := hashAlgos[algo]
+	if !ok {
+		return "", c.GetOpUnsupportedError()
+	}
+	if startOffset < 0 || endOffset < startOffset || (endOffset == startOffset && startOffset > 0) {
+		return "", c.GetGenericError(fmt.Errorf("invalid hash range %d-%d", startOffset, endOffset))
+	}
+	result, err := c.ComputeFileHash(name, hashAlgo, startOffset, endOffset-startOffset, 0)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to compute %s hash for file %q: %v", hashAlgo, name, err)
+		return "", err
+	}
+	return hex.EncodeToString(result), nil
+}
+
 // GetHandle implements ClientDriverExtentionFileTransfer
 func (c *Connection) GetHandle(name string, flags int, offset int64) (ftpserver.FileTransfer, error) {
 	c.UpdateLastActivity()
@@ -328,6 +359,9 @@ func (c *Connection) GetHandle(name string, flags int, offset int64) (ftpserver.
 }
 
 func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int64) (ftpserver.FileTransfer, error) {
+	if archive, ok := c.GetArchiveDownload(ftpPath); ok {
+		return c.downloadArchive(archive, offset)
+	}
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(ftpPath)) {
 		return nil, c.GetPermissionDeniedError()
 	}
@@ -361,6 +395,18 @@ func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int6
 	return t, nil
 }
 
+func (c *Connection) downloadArchive(archive *common.ArchiveDownload, offset int64) (ftpserver.FileTransfer, error) {
+	if offset != 0 {
+		c.Log(logger.LevelDebug, "unable to download archive %q from offset %d", archive.VirtualPath, offset)
+		return nil, common.ErrArchiveOffset
+	}
+	r, err := c.OpenArchiveDownload(archive)
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveTransfer(c, r), nil
+}
+
 func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (ftpserver.FileTransfer, error) {
 	if ok, _ := c.User.IsFileAllowed(ftpPath); !ok {
 		c.Log(logger.LevelWarn, "writing file %q is not allowed", ftpPath)
@@ -404,6 +450,9 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, ftpserver.ErrStorageExceeded
 	}
+	if err := c.CheckDirLimits(requestPath); err != nil {
+		return nil, err
+	}
 	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
 		return nil, ftpserver.ErrFileNameNotAllowed
@@ -443,8 +492,10 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	// so if we don't have O_TRUNC is a resume.
 	isResume := flags&os.O_TRUNC == 0
 	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
-	// will return false in this case and we deny the upload before
-	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, fs.IsUploadResumeSupported())
+	// will return false in this case and we deny the upload before.
+	// GCS does not support resume but allows to append data using APPE
+	isAppend := isResume && flags&os.O_APPEND != 0 && vfs.HasAppendUploadSupport(fs)
+	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, fs.IsUploadResumeSupported() || isAppend)
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
@@ -483,7 +534,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 				}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"io/fs"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -447,6 +449,13 @@ func TestInitialization(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "invalid active_connections_security")
 	}
+	binding.ActiveConnectionsSecurity = 1
+	binding.TLSSessionReuse = 100
+	server = NewServer(c, configDir, binding, 0)
+	_, err = server.GetSettings()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid tls_session_reuse")
+	}
 	binding = Binding{
 		Port:           2121,
 		ForcePassiveIP: "192.168.1",
@@ -553,6 +562,62 @@ func TestServerGetSettings(t *testing.T) {
 	common.Config = oldConfig
 }
 
+func TestBindingAuthSources(t *testing.T) {
+	b := Binding{
+		Port: 2121,
+	}
+	assert.NoError(t, b.checkAuthSources())
+	b.LDAPDirectory = "ldap"
+	b.RADIUSServer = "radius"
+	err := b.checkAuthSources()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot be used together")
+	}
+	b.LDAPDirectory = ""
+	err = b.checkAuthSources()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not defined")
+	}
+	b.RADIUSServer = ""
+	b.LDAPDirectory = "ldap"
+	err = b.checkAuthSources()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not defined")
+	}
+}
+
+func TestBindingVirtualHosts(t *testing.T) {
+	b := Binding{
+		Port: 2121,
+		VirtualHosts: []VirtualHost{
+			{
+				Host:         " Files.Example.com ",
+				AllowedRoles: []string{"role1", "role1", "role2"},
+			},
+			{
+				Host: "Other.example.com",
+			},
+		},
+	}
+	err := b.checkVirtualHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, "files.example.com", b.VirtualHosts[0].Host)
+	assert.Len(t, b.VirtualHosts[0].AllowedRoles, 2)
+	assert.True(t, b.isRoleAllowed("files.example.com", "role2"))
+	assert.False(t, b.isRoleAllowed("files.example.com", "role3"))
+	assert.False(t, b.isRoleAllowed("files.example.com", ""))
+	assert.True(t, b.isRoleAllowed("other.example.com", "role3"))
+	assert.True(t, b.isRoleAllowed("", "role3"))
+	assert.Len(t, b.getVirtualHostsCertIDs(), 0)
+
+	b.VirtualHosts = append(b.VirtualHosts, VirtualHost{Host: "FILES.example.com"})
+	err = b.checkVirtualHosts()
+	assert.ErrorContains(t, err, "is duplicated")
+	b.VirtualHosts = []VirtualHost{{Host: " "}}
+	err = b.checkVirtualHosts()
+	assert.ErrorContains(t, err, "host is mandatory")
+}
+
 func TestUserInvalidParams(t *testing.T) {
 	u := dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -1139,3 +1204,92 @@ func TestPassiveHost(t *testing.T) {
 	assert.NoError(t, err, ip)
 	assert.Equal(t, "127.0.0.1", ip)
 }
+
+func TestPassiveIPHook(t *testing.T) {
+	b := Binding{
+		PassiveIPHook: "relative path",
+	}
+	err := b.checkPassiveIP()
+	assert.Error(t, err)
+
+	mockCC := mockFTPClientContext{
+		remoteIP: "192.168.1.10",
+		localIP:  "192.168.1.3",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("client_ip") {
+		case "192.168.1.10":
+			assert.Equal(t, "192.168.1.3", r.URL.Query().Get("local_ip"))
+			_, _ = w.Write([]byte("203.0.113.1\n"))
+		case "192.168.1.11":
+			_, _ = w.Write([]byte("invalid ip"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	b.PassiveIPHook = ts.URL
+	err = b.checkPassiveIP()
+	assert.NoError(t, err)
+	passiveIP, err := b.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", passiveIP)
+	mockCC.remoteIP = "192.168.1.11"
+	_, err = b.passiveIPResolver(mockCC)
+	assert.Error(t, err)
+	mockCC.remoteIP = "192.168.1.12"
+	_, err = b.passiveIPResolver(mockCC)
+	assert.Error(t, err)
+	// the forced passive IP takes precedence
+	b.ForcePassiveIP = "203.0.113.2"
+	passiveIP, err = b.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	hookPath := filepath.Join(os.TempDir(), "passive_ip_hook.sh")
+	err = os.WriteFile(hookPath, []byte("#!/bin/sh\n\necho \"203.0.113.3\"\n"), os.ModePerm)
+	assert.NoError(t, err)
+	b = Binding{
+		PassiveIPHook: hookPath,
+	}
+	err = b.checkPassiveIP()
+	assert.NoError(t, err)
+	passiveIP, err = b.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.3", passiveIP)
+	err = os.WriteFile(hookPath, []byte("#!/bin/sh\n\nexit 1\n"), os.ModePerm)
+	assert.NoError(t, err)
+	_, err = b.passiveIPResolver(mockCC)
+	assert.Error(t, err)
+	err = os.Remove(hookPath)
+	assert.NoError(t, err)
+}
+
+func TestPassivePortRange(t *testing.T) {
+	globalRange := PortRange{
+		Start: 50000,
+		End:   50100,
+	}
+	b := Binding{}
+	assert.NoError(t, b.checkPassivePortRange())
+	portRange := b.getPassivePortRange(globalRange)
+	require.NotNil(t, portRange)
+	assert.Equal(t, 50000, portRange.Start)
+	assert.Equal(t, 50100, portRange.End)
+	assert.Nil(t, b.getPassivePortRange(PortRange{}))
+	b.PassivePortRange = PortRange{
+		Start: 51000,
+		End:   51010,
+	}
+	assert.NoError(t, b.checkPassivePortRange())
+	portRange = b.getPassivePortRange(globalRange)
+	require.NotNil(t, portRange)
+	assert.Equal(t, 51000, portRange.Start)
+	assert.Equal(t, 51010, portRange.End)
+	b.PassivePortRange.End = 51000
+	assert.Error(t, b.checkPassivePortRange())
+}
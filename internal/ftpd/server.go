@@ -28,12 +28,35 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
 
+var (
+	errTLSSessionNotResumed = errors.New("TLS session resumption is required for data connections")
+)
+
+// controlConn identifies the connections accepted on the binding listener
+// so that they can be distinguished from data connections while handshaking TLS
+type controlConn struct {
+	net.Conn
+}
+
+type controlListener struct {
+	net.Listener
+}
+
+func (l *controlListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &controlConn{Conn: conn}, nil
+}
+
 // Server implements the ftpserverlib MainDriver interface
 type Server struct {
 	ID               int
@@ -42,8 +65,11 @@ type Server struct {
 	statusBanner     string
 	binding          Binding
 	tlsConfig        *tls.Config
+	dataTLSConfig    *tls.Config
 	mu               sync.RWMutex
 	verifiedTLSConns map[uint32]bool
+	// server names requested using TLS SNI on control connections, keyed by remote address
+	serverNames map[string]string
 }
 
 // NewServer returns a new FTP server driver
@@ -56,6 +82,7 @@ func NewServer(config *Configuration, configDir string, binding Binding, id int)
 		binding:          binding,
 		ID:               id,
 		verifiedTLSConns: make(map[uint32]bool),
+		serverNames:      make(map[string]string),
 	}
 	if config.BannerFile != "" {
 		bannerFilePath := config.BannerFile
@@ -95,6 +122,27 @@ func (s *Server) cleanTLSConnVerification(id uint32) {
 	delete(s.verifiedTLSConns, id)
 }
 
+func (s *Server) getServerName(remoteAddr string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.serverNames[remoteAddr]
+}
+
+func (s *Server) setServerName(remoteAddr, serverName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.serverNames[remoteAddr] = serverName
+}
+
+func (s *Server) cleanServerName(remoteAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.serverNames, remoteAddr)
+}
+
 // GetSettings returns FTP server settings
 func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	if err := s.binding.checkPassiveIP(); err != nil {
@@ -103,24 +151,36 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	if err := s.binding.checkSecuritySettings(); err != nil {
 		return nil, err
 	}
-	var portRange *ftpserver.PortRange
-	if s.config.PassivePortRange.Start > 0 && s.config.PassivePortRange.End > s.config.PassivePortRange.Start {
-		portRange = &ftpserver.PortRange{
-			Start: s.config.PassivePortRange.Start,
-			End:   s.config.PassivePortRange.End,
-		}
+	if err := s.binding.checkPassivePortRange(); err != nil {
+		return nil, err
+	}
+	if err := s.binding.checkAuthSources(); err != nil {
+		return nil, err
+	}
+	if err := s.binding.GeoIP.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid GeoIP filter for binding %q: %w", s.binding.GetAddress(), err)
 	}
+	if err := s.binding.checkVirtualHosts(); err != nil {
+		return nil, err
+	}
+	portRange := s.binding.getPassivePortRange(s.config.PassivePortRange)
 	var ftpListener net.Listener
-	if s.binding.HasProxy() {
+	if s.binding.HasProxy() || s.needsControlListener() {
 		listener, err := net.Listen("tcp", s.binding.GetAddress())
 		if err != nil {
 			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
 			return nil, err
 		}
-		ftpListener, err = common.Config.GetProxyListener(listener)
-		if err != nil {
-			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-			return nil, err
+		ftpListener = listener
+		if s.binding.HasProxy() {
+			ftpListener, err = common.Config.GetProxyListener(listener)
+			if err != nil {
+				logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+				return nil, err
+			}
+		}
+		if s.needsControlListener() {
+			ftpListener = &controlListener{Listener: ftpListener}
 		}
 		if s.binding.TLSMode == 2 && s.tlsConfig != nil {
 			ftpListener = tls.NewListener(ftpListener, s.tlsConfig)
@@ -164,6 +224,9 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %q is banned", ipAddr)
 		return "Access denied: banned client IP", common.ErrConnectionDenied
 	}
+	if !common.IsAllowedByGeoIP(&s.binding.GeoIP, ipAddr, common.ProtocolFTP) {
+		return "Access denied", common.ErrConnectionDenied
+	}
 	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolFTP); err != nil {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection not allowed from ip %q: %v", ipAddr, err)
 		return "Access denied", err
@@ -189,6 +252,7 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 // ClientDisconnected is called when the user disconnects, even if he never authenticated
 func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 	s.cleanTLSConnVerification(cc.ID())
+	s.cleanServerName(cc.RemoteAddr().String())
 	connID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
 	common.Connections.Remove(connID)
 	common.Connections.RemoveClientConnection(util.GetIPFromRemoteAddress(cc.RemoteAddr().String()))
@@ -201,12 +265,26 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 		loginMethod = dataprovider.LoginMethodTLSCertificateAndPwd
 	}
 	ipAddr := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
-	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolFTP)
+	var user dataprovider.User
+	var err error
+	if s.binding.RADIUSServer != "" {
+		user, err = dataprovider.CheckRADIUSAuth(username, password, ipAddr, common.ProtocolFTP, s.binding.RADIUSServer)
+	} else {
+		user, err = dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolFTP,
+			s.binding.LDAPDirectory)
+	}
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
 		return nil, dataprovider.ErrInvalidCredentials
 	}
+	if user.Filters.IsAnonymous {
+		if _, err := common.CheckAnonymousAccess(ipAddr, common.ProtocolFTP, true); err != nil {
+			logger.Info(logSender, fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID()),
+				"anonymous login for user %q denied: %v", user.Username, err)
+			return nil, err
+		}
+	}
 
 	connection, err := s.validateUser(user, cc, loginMethod)
 
@@ -216,7 +294,8 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 		return nil, err
 	}
 	setStartDirectory(user.Filters.StartDirectory, cc)
-	connection.Log(logger.LevelInfo, "User %q logged in with %q from ip %q", user.Username, loginMethod, ipAddr)
+	connection.Log(logger.LevelInfo, "User %q logged in with %q from ip %q, country %q", user.Username, loginMethod,
+		ipAddr, geoip.GetCountry(ipAddr))
 	dataprovider.UpdateLastLogin(&user)
 	return connection, nil
 }
@@ -264,7 +343,7 @@ func (s *Server) VerifyConnection(cc ftpserver.ClientContext, user string, tlsCo
 				updateLoginMetrics(&dbUser, ipAddr, dataprovider.LoginMethodTLSCertificate, err)
 				return nil, dataprovider.ErrInvalidCredentials
 			}
-			if dbUser.IsTLSUsernameVerificationEnabled() {
+			if dbUser.IsTLSCertificateAuthEnabled() {
 				dbUser, err = dataprovider.CheckUserAndTLSCert(user, ipAddr, common.ProtocolFTP, state.PeerCertificates[0])
 				if err != nil {
 					return nil, err
@@ -300,7 +379,7 @@ func (s *Server) buildTLSConfig() {
 			certID = s.binding.GetAddress()
 		}
 		s.tlsConfig = &tls.Config{
-			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			GetCertificate:           certMgr.GetCertificateFuncForHosts(certID, s.binding.getVirtualHostsCertIDs()),
 			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
 			CipherSuites:             s.binding.ciphers,
 			PreferServerCipherSuites: true,
@@ -317,6 +396,13 @@ func (s *Server) buildTLSConfig() {
 				s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
+		if s.binding.isTLSSessionReuseEnabled() {
+			s.dataTLSConfig = s.tlsConfig.Clone()
+			s.dataTLSConfig.VerifyConnection = s.verifyTLSDataConnection
+		}
+		if s.needsControlListener() {
+			s.tlsConfig.GetConfigForClient = s.getTLSConfigForClient
+		}
 	}
 }
 
@@ -328,6 +414,39 @@ func (s *Server) GetTLSConfig() (*tls.Config, error) {
 	return nil, errors.New("no TLS certificate configured")
 }
 
+// needsControlListener returns true if control connections must be distinguished
+// from data connections while handshaking TLS
+func (s *Server) needsControlListener() bool {
+	return s.binding.isTLSSessionReuseEnabled() || len(s.binding.VirtualHosts) > 0
+}
+
+// getTLSConfigForClient returns the TLS configuration for data connections.
+// Control connections use the default configuration, the requested server
+// name is recorded to match the configured virtual hosts
+func (s *Server) getTLSConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if _, ok := hello.Conn.(*controlConn); ok {
+		if len(s.binding.VirtualHosts) > 0 {
+			s.setServerName(hello.Conn.RemoteAddr().String(), hello.ServerName)
+		}
+		return nil, nil
+	}
+	return s.dataTLSConfig, nil
+}
+
+func (s *Server) verifyTLSDataConnection(state tls.ConnectionState) error {
+	if !state.DidResume {
+		if s.binding.TLSSessionReuse == 1 {
+			logger.Debug(logSender, "", "TLS data connection refused, the TLS session was not resumed")
+			return errTLSSessionNotResumed
+		}
+		logger.Debug(logSender, "", "TLS data connection allowed without resuming the TLS session")
+	}
+	if s.binding.isMutualTLSEnabled() {
+		return s.verifyTLSConnection(state)
+	}
+	return nil
+}
+
 func (s *Server) verifyTLSConnection(state tls.ConnectionState) error {
 	if certMgr != nil {
 		var clientCrt *x509.Certificate
@@ -344,11 +463,7 @@ func (s *Server) verifyTLSConnection(state tls.ConnectionState) error {
 			return errors.New("TLS connection cannot be verified: unable to get verification chain")
 		}
 		for _, verifiedChain := range state.VerifiedChains {
-			var caCrt *x509.Certificate
-			if len(verifiedChain) > 0 {
-				caCrt = verifiedChain[len(verifiedChain)-1]
-			}
-			if certMgr.IsRevoked(clientCrt, caCrt) {
+			if certMgr.IsChainRevoked(verifiedChain) {
 				logger.Debug(logSender, "", "tls handshake error, client certificate %q has beed revoked", clientCrtName)
 				return common.ErrCrtRevoked
 			}
@@ -369,6 +484,11 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		logger.Info(logSender, connectionID, "cannot login user %q, protocol FTP is not allowed", user.Username)
 		return nil, fmt.Errorf("protocol FTP is not allowed for user %q", user.Username)
 	}
+	if serverName := s.getServerName(cc.RemoteAddr().String()); !s.binding.isRoleAllowed(serverName, user.Role) {
+		logger.Info(logSender, connectionID, "cannot login user %q, role %q is not allowed for host %q",
+			user.Username, user.Role, serverName)
+		return nil, fmt.Errorf("login for user %q is not allowed on this host", user.Username)
+	}
 	if !user.IsLoginMethodAllowed(loginMethod, common.ProtocolFTP, nil) {
 		logger.Info(logSender, connectionID, "cannot login user %q, %v login method is not allowed",
 			user.Username, loginMethod)
@@ -389,6 +509,7 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 	}
 	remoteAddr := cc.RemoteAddr().String()
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
+		common.RequestIPApproval(&user, remoteAddr, common.ProtocolFTP)
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
@@ -404,6 +525,10 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			cc.LocalAddr().String(), remoteAddr, user),
 		clientContext: cc,
 	}
+	if s.binding.AggregateUploadBandwidth > 0 || s.binding.AggregateDownloadBandwidth > 0 {
+		connection.SetBindingBandwidth(s.binding.GetAddress(), s.binding.AggregateUploadBandwidth,
+			s.binding.AggregateDownloadBandwidth)
+	}
 	err = common.Connections.Swap(connection)
 	if err != nil {
 		errClose := user.CloseFs()
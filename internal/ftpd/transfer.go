@@ -77,7 +77,13 @@ func (t *transfer) Read(p []byte) (n int, err error) {
 func (t *transfer) Write(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
+	if err := t.CheckUploadContent(p, t.BytesReceived.Load()); err != nil {
+		t.TransferError(err)
+		return 0, err
+	}
+
 	n, err = t.writer.Write(p)
+	t.UpdateChecksums(p[:n], t.BytesReceived.Load())
 	t.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -148,3 +154,42 @@ func (t *transfer) setFinished() error {
 	t.isFinished = true
 	return nil
 }
+
+// archiveTransfer implements the ftpserver.FileTransfer interface for archive downloads.
+// The transfer details are tracked for each archived file and not for the archive itself
+type archiveTransfer struct {
+	conn   *Connection
+	reader *pipeat.PipeReaderAt
+}
+
+func newArchiveTransfer(conn *Connection, reader *pipeat.PipeReaderAt) *archiveTransfer {
+	return &archiveTransfer{
+		conn:   conn,
+		reader: reader,
+	}
+}
+
+// Read reads the archive contents
+func (t *archiveTransfer) Read(p []byte) (int, error) {
+	t.conn.UpdateLastActivity()
+
+	return t.reader.Read(p)
+}
+
+// Write is not supported for archive downloads
+func (t *archiveTransfer) Write(_ []byte) (int, error) {
+	return 0, common.ErrOpUnsupported
+}
+
+// Seek is not supported for archive downloads, they cannot be resumed
+func (t *archiveTransfer) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		return 0, nil
+	}
+	return 0, common.ErrArchiveOffset
+}
+
+// Close stops the archive generation, if not already completed
+func (t *archiveTransfer) Close() error {
+	return t.reader.Close()
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nofuse && (linux || darwin || freebsd)
+// +build !nofuse
+// +build linux darwin freebsd
+
+// Package fusemount exposes the filesystem of an SFTPGo user as a local,
+// read-only, FUSE mount
+package fusemount
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	logSender    = "fusemount"
+	protocolFUSE = "FUSE"
+	cacheTimeout = time.Second
+)
+
+func init() {
+	version.AddFeature("+fuse")
+}
+
+// Server is an active FUSE mount
+type Server struct {
+	mountPoint string
+	server     *fuse.Server
+	connection *common.BaseConnection
+}
+
+// Mount exposes the filesystem of the specified user at the given mount point.
+// The user's virtual folders, permissions and file patterns are applied, as for
+// the other protocols, so the mount point shows the files exactly as the user sees
+// them. The mount is read-only
+func Mount(mountPoint string, user dataprovider.User) (*Server, error) {
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("the mount point must be a directory")
+	}
+	connID := xid.New().String()
+	if err := user.CheckFsRoot(connID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		return nil, err
+	}
+	connection := common.NewBaseConnection(connID, protocolFUSE, "", "", user)
+	timeout := cacheTimeout
+	server, err := fs.Mount(mountPoint, &node{connection: connection, virtualPath: "/"}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:        "sftpgo",
+			Name:          "sftpgo",
+			Options:       []string{"ro"},
+			DisableXAttrs: true,
+			DirectMount:   true,
+		},
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NegativeTimeout: &timeout,
+		UID:             uint32(os.Getuid()),
+		GID:             uint32(os.Getgid()),
+	})
+	if err != nil {
+		errClose := connection.CloseFS()
+		logger.Warn(logSender, connID, "unable to mount %q: %v, close fs error: %v", mountPoint, err, errClose)
+		return nil, err
+	}
+	connection.Log(logger.LevelInfo, "filesystem for user %q mounted at %q", user.Username, mountPoint)
+	return &Server{
+		mountPoint: mountPoint,
+		server:     server,
+		connection: connection,
+	}, nil
+}
+
+// GetMountPoint returns the directory where the filesystem is mounted
+func (s *Server) GetMountPoint() string {
+	return s.mountPoint
+}
+
+// Unmount unmounts the filesystem. It fails if the mount point is in use
+func (s *Server) Unmount() error {
+	return s.server.Unmount()
+}
+
+// Wait blocks until the filesystem is unmounted
+func (s *Server) Wait() {
+	s.server.Wait()
+	err := s.connection.CloseFS()
+	s.connection.Log(logger.LevelInfo, "filesystem unmounted from %q, close fs error: %v", s.mountPoint, err)
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build nofuse || !(linux || darwin || freebsd)
+// +build nofuse !linux,!darwin,!freebsd
+
+package fusemount
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+func init() {
+	version.AddFeature("-fuse")
+}
+
+// Server is an active FUSE mount
+type Server struct{}
+
+// Mount returns an error, FUSE is disabled at build time or not supported
+// on this platform
+func Mount(_ string, _ dataprovider.User) (*Server, error) {
+	return nil, errors.New("FUSE disabled at build time or not supported on this platform")
+}
+
+// GetMountPoint returns an empty string
+func (s *Server) GetMountPoint() string {
+	return ""
+}
+
+// Unmount does nothing
+func (s *Server) Unmount() error {
+	return nil
+}
+
+// Wait does nothing
+func (s *Server) Wait() {}
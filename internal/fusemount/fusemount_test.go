@@ -0,0 +1,227 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nofuse && (linux || darwin || freebsd)
+// +build !nofuse
+// +build linux darwin freebsd
+
+package fusemount
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestMountLocalFs(t *testing.T) {
+	user := getTestUser(t)
+	user.Permissions["/denied"] = []string{dataprovider.PermListItems}
+	user.Permissions["/nolist"] = []string{dataprovider.PermDownload}
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.log"},
+			DenyPolicy:     sdk.DenyPolicyHide,
+		},
+	}
+	for _, dir := range []string{"denied", "nolist", "sub"} {
+		err := os.Mkdir(filepath.Join(user.HomeDir, dir), os.ModePerm)
+		require.NoError(t, err)
+	}
+	content := getRandomContent(t, 3*1024*1024+17)
+	err := os.WriteFile(filepath.Join(user.HomeDir, "sub", "file.dat"), content, 0644)
+	require.NoError(t, err)
+	for _, name := range []string{"file.log", filepath.Join("denied", "file.txt")} {
+		err = os.WriteFile(filepath.Join(user.HomeDir, name), []byte("content"), 0644)
+		require.NoError(t, err)
+	}
+	mountPoint := t.TempDir()
+	server := mountFs(t, mountPoint, user)
+	assert.Equal(t, mountPoint, server.GetMountPoint())
+
+	entries, err := os.ReadDir(mountPoint)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		for _, entry := range entries {
+			assert.True(t, entry.IsDir(), entry.Name())
+		}
+	}
+	info, err := os.Stat(filepath.Join(mountPoint, "sub", "file.dat"))
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode().IsRegular())
+		assert.Equal(t, int64(len(content)), info.Size())
+	}
+	data, err := os.ReadFile(filepath.Join(mountPoint, "sub", "file.dat"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	_, err = os.Stat(filepath.Join(mountPoint, "file.log"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = os.ReadFile(filepath.Join(mountPoint, "denied", "file.txt"))
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = os.ReadDir(filepath.Join(mountPoint, "nolist"))
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	err = os.WriteFile(filepath.Join(mountPoint, "sub", "file.dat"), []byte("content"), 0644)
+	assert.ErrorIs(t, err, syscall.EROFS)
+	err = os.Mkdir(filepath.Join(mountPoint, "newdir"), os.ModePerm)
+	assert.ErrorIs(t, err, syscall.EROFS)
+	err = os.Remove(filepath.Join(mountPoint, "sub", "file.dat"))
+	assert.ErrorIs(t, err, syscall.EROFS)
+
+	unmountFs(t, server)
+	_, err = os.Stat(filepath.Join(mountPoint, "sub"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMountStreamingFs(t *testing.T) {
+	// the encrypted local filesystem returns a stream, as the cloud backends
+	user := getTestUser(t)
+	user.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("passphrase")
+	user.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name:       "vfolder",
+				MappedPath: t.TempDir(),
+			},
+			VirtualPath: "/vdir",
+		},
+	}
+	content := getRandomContent(t, 5*1024*1024+100)
+	writeFile(t, user, "/file.dat", content)
+	writeFile(t, user, "/vdir/file.txt", []byte("content"))
+
+	mountPoint := t.TempDir()
+	server := mountFs(t, mountPoint, user)
+
+	info, err := os.Stat(filepath.Join(mountPoint, "file.dat"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(content)), info.Size())
+	}
+	data, err := os.ReadFile(filepath.Join(mountPoint, "file.dat"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	data, err = os.ReadFile(filepath.Join(mountPoint, "vdir", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+
+	f, err := os.Open(filepath.Join(mountPoint, "file.dat"))
+	require.NoError(t, err)
+	buf := make([]byte, 64*1024)
+	for _, offset := range []int64{4 * 1024 * 1024, 1024, 1024 + 64*1024, 3 * 1024 * 1024, int64(len(content) - 100)} {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+		}
+		assert.Equal(t, content[offset:offset+int64(n)], buf[:n], "offset %d", offset)
+	}
+	err = f.Close()
+	assert.NoError(t, err)
+
+	unmountFs(t, server)
+}
+
+func TestMountErrors(t *testing.T) {
+	user := getTestUser(t)
+	_, err := Mount(filepath.Join(t.TempDir(), "missing"), user)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	mountPoint := filepath.Join(t.TempDir(), "file")
+	err = os.WriteFile(mountPoint, []byte("content"), 0644)
+	require.NoError(t, err)
+	_, err = Mount(mountPoint, user)
+	assert.Error(t, err)
+	// invalid filesystem configuration
+	user.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	user.FsConfig.CryptConfig.Passphrase = kms.NewEmptySecret()
+	_, err = Mount(t.TempDir(), user)
+	assert.Error(t, err)
+}
+
+func TestErrorConversion(t *testing.T) {
+	assert.Equal(t, syscall.Errno(0), toErrno(nil))
+	assert.Equal(t, syscall.ENOENT, toErrno(common.ErrNotExist))
+	assert.Equal(t, syscall.ENOENT, toErrno(os.ErrNotExist))
+	assert.Equal(t, syscall.ENOENT, toErrno(util.NewRecordNotFoundError("not found")))
+	assert.Equal(t, syscall.EACCES, toErrno(common.ErrPermissionDenied))
+	assert.Equal(t, syscall.EACCES, toErrno(os.ErrPermission))
+	assert.Equal(t, syscall.ENOTSUP, toErrno(common.ErrOpUnsupported))
+	assert.Equal(t, syscall.EIO, toErrno(errors.New("generic error")))
+}
+
+func getTestUser(t *testing.T) dataprovider.User {
+	return dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "test_user_fuse",
+			HomeDir:  t.TempDir(),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+}
+
+func getRandomContent(t *testing.T, size int) []byte {
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	return content
+}
+
+func writeFile(t *testing.T, user dataprovider.User, name string, content []byte) {
+	fs, err := user.GetFilesystemForPath(name, "")
+	require.NoError(t, err)
+	fsPath, err := fs.ResolvePath(name)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(fsPath), os.ModePerm))
+	f, w, _, err := fs.Create(fsPath, 0)
+	require.NoError(t, err)
+	var dst io.WriteCloser = f
+	if w != nil {
+		dst = w
+	}
+	_, err = io.Copy(dst, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, dst.Close())
+	require.NoError(t, user.CloseFs())
+}
+
+func mountFs(t *testing.T, mountPoint string, user dataprovider.User) *Server {
+	server, err := Mount(mountPoint, user)
+	if err != nil {
+		t.Skipf("unable to mount the filesystem, FUSE is probably not available: %v", err)
+	}
+	return server
+}
+
+func unmountFs(t *testing.T, server *Server) {
+	err := server.Unmount()
+	assert.NoError(t, err)
+	server.Wait()
+}
@@ -0,0 +1,258 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nofuse && (linux || darwin || freebsd)
+// +build !nofuse
+// +build linux darwin freebsd
+
+package fusemount
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/eikenb/pipeat"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// maxSkipSize is the maximum number of bytes we read and discard, instead of
+// reopening the file, if a read request is ahead of the current stream position
+const maxSkipSize = 2 * 1024 * 1024
+
+var (
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.FileReader    = (*fileHandle)(nil)
+	_ fs.FileReleaser  = (*fileHandle)(nil)
+)
+
+// node is a file or directory identified by its virtual path
+type node struct {
+	fs.Inode
+
+	connection  *common.BaseConnection
+	virtualPath string
+}
+
+func (n *node) Getattr(_ context.Context, _ fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.connection.UpdateLastActivity()
+
+	info, err := n.connection.DoStat(n.virtualPath, 0, true)
+	if err != nil {
+		return toErrno(err)
+	}
+	fillAttr(info, &out.Attr)
+	return 0
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	n.connection.UpdateLastActivity()
+
+	virtualPath := path.Join(n.virtualPath, name)
+	info, err := n.connection.DoStat(virtualPath, 0, true)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	fillAttr(info, &out.Attr)
+	child := &node{
+		connection:  n.connection,
+		virtualPath: virtualPath,
+	}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT}), 0
+}
+
+func (n *node) Readdir(_ context.Context) (fs.DirStream, syscall.Errno) {
+	n.connection.UpdateLastActivity()
+
+	files, err := n.connection.ListDir(n.virtualPath)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	entries := make([]fuse.DirEntry, 0, len(files))
+	for _, info := range files {
+		entry := fuse.DirEntry{
+			Name: info.Name(),
+		}
+		// symlinks are followed by lookups, so we don't know their type here
+		if info.Mode()&os.ModeSymlink == 0 {
+			entry.Mode = getFileType(info)
+		}
+		entries = append(entries, entry)
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *node) Open(_ context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	n.connection.UpdateLastActivity()
+
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_APPEND|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	if !n.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(n.virtualPath)) {
+		return nil, 0, syscall.EACCES
+	}
+	if ok, policy := n.connection.User.IsFileAllowed(n.virtualPath); !ok {
+		n.connection.Log(logger.LevelWarn, "reading file %q is not allowed", n.virtualPath)
+		return nil, 0, toErrno(n.connection.GetErrorForDeniedFile(policy))
+	}
+	fs, fsPath, err := n.connection.GetFsAndResolvedPath(n.virtualPath)
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	return &fileHandle{
+		connection:  n.connection,
+		fs:          fs,
+		fsPath:      fsPath,
+		virtualPath: n.virtualPath,
+	}, 0, 0
+}
+
+// fileHandle reads a file using the vfs implementation. Cloud backends return
+// a stream starting at the requested offset, so the stream is reopened if the
+// kernel asks for a different position
+type fileHandle struct {
+	sync.Mutex
+	connection  *common.BaseConnection
+	fs          vfs.Fs
+	fsPath      string
+	virtualPath string
+	file        vfs.File
+	reader      *pipeat.PipeReaderAt
+	cancelFn    func()
+	offset      int64
+}
+
+func (h *fileHandle) Read(_ context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.connection.UpdateLastActivity()
+
+	n, err := h.readAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		h.connection.Log(logger.LevelDebug, "unable to read %q, offset %d: %+v", h.virtualPath, off, err)
+		return nil, toErrno(h.connection.GetFsError(h.fs, err))
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *fileHandle) readAt(dest []byte, off int64) (int, error) {
+	if h.file == nil && h.reader != nil && off > h.offset && off-h.offset <= maxSkipSize {
+		skipped, err := io.CopyN(io.Discard, h.reader, off-h.offset)
+		h.offset += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	if h.file == nil && (h.reader == nil || off != h.offset) {
+		if err := h.open(off); err != nil {
+			return 0, err
+		}
+	}
+	if h.file != nil {
+		return h.file.ReadAt(dest, off)
+	}
+	n, err := io.ReadFull(h.reader, dest)
+	h.offset += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (h *fileHandle) open(offset int64) error {
+	h.close()
+
+	file, reader, cancelFn, err := h.fs.Open(h.fsPath, offset)
+	if err != nil {
+		return err
+	}
+	h.file = file
+	h.reader = reader
+	h.cancelFn = cancelFn
+	h.offset = offset
+	return nil
+}
+
+func (h *fileHandle) close() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+	if h.reader != nil {
+		h.reader.Close()
+		h.reader = nil
+	}
+	if h.cancelFn != nil {
+		h.cancelFn()
+		h.cancelFn = nil
+	}
+}
+
+func (h *fileHandle) Release(_ context.Context) syscall.Errno {
+	h.Lock()
+	defer h.Unlock()
+
+	h.close()
+	return 0
+}
+
+func getFileType(info os.FileInfo) uint32 {
+	switch {
+	case info.IsDir():
+		return syscall.S_IFDIR
+	case info.Mode()&os.ModeSymlink != 0:
+		return syscall.S_IFLNK
+	default:
+		return syscall.S_IFREG
+	}
+}
+
+func fillAttr(info os.FileInfo, out *fuse.Attr) {
+	out.Mode = getFileType(info) | uint32(info.Mode().Perm())
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	out.Nlink = 1
+	modTime := info.ModTime()
+	out.SetTimes(&modTime, &modTime, &modTime)
+}
+
+func toErrno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, common.ErrNotExist), errors.Is(err, os.ErrNotExist), errors.Is(err, util.ErrNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, common.ErrPermissionDenied), errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, common.ErrOpUnsupported):
+		return syscall.ENOTSUP
+	default:
+		return syscall.EIO
+	}
+}
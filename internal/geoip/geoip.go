@@ -0,0 +1,332 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package geoip implements country and autonomous system lookups using
+// MaxMind DB files, for example the free GeoLite2 databases, and the
+// filters to allow or deny clients based on their country or ASN
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "geoip"
+)
+
+var (
+	mu         sync.RWMutex
+	config     Config
+	configDir  string
+	countryDB  *maxminddb.Reader
+	asnDB      *maxminddb.Reader
+	errInvalid = errors.New("invalid country code")
+)
+
+// Config defines the GeoIP configuration
+type Config struct {
+	// Path to a MaxMind DB file with country data, for example GeoLite2-Country.mmdb.
+	// City databases are supported too. Leave empty to disable country lookups
+	CountryDBPath string `json:"country_db_path" mapstructure:"country_db_path"`
+	// Path to a MaxMind DB file with autonomous system data, for example GeoLite2-ASN.mmdb.
+	// Leave empty to disable ASN lookups
+	ASNDBPath string `json:"asn_db_path" mapstructure:"asn_db_path"`
+}
+
+// Initialize opens the configured databases. Relative paths are
+// resolved against the configuration directory
+func (c *Config) Initialize(dir string) error {
+	country, err := openDB(c.CountryDBPath, dir)
+	if err != nil {
+		return fmt.Errorf("unable to open the GeoIP country database: %w", err)
+	}
+	asn, err := openDB(c.ASNDBPath, dir)
+	if err != nil {
+		if country != nil {
+			country.Close()
+		}
+		return fmt.Errorf("unable to open the GeoIP ASN database: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	closeDBs()
+	config = *c
+	configDir = dir
+	countryDB = country
+	asnDB = asn
+	if countryDB != nil || asnDB != nil {
+		logger.Info(logSender, "", "GeoIP initialized, country database: %q, ASN database: %q",
+			c.CountryDBPath, c.ASNDBPath)
+	}
+	logger.SetCountryResolver(GetCountry)
+	return nil
+}
+
+// Reload reopens the configured databases, it allows to pick up
+// updated database files without restarting the service
+func Reload() error {
+	mu.RLock()
+	c := config
+	dir := configDir
+	mu.RUnlock()
+
+	if c.CountryDBPath == "" && c.ASNDBPath == "" {
+		return nil
+	}
+	return c.Initialize(dir)
+}
+
+// IsEnabled returns true if at least one database is configured
+func IsEnabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return countryDB != nil || asnDB != nil
+}
+
+func openDB(path, dir string) (*maxminddb.Reader, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !util.IsFileInputValid(path) {
+		return nil, fmt.Errorf("invalid database path %q", path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return maxminddb.Open(path)
+}
+
+func closeDBs() {
+	if countryDB != nil {
+		countryDB.Close()
+		countryDB = nil
+	}
+	if asnDB != nil {
+		asnDB.Close()
+		asnDB = nil
+	}
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Info defines the GeoIP data for an IP address
+type Info struct {
+	// ISO 3166-1 alpha-2 country code, empty if unknown
+	Country string
+	// Autonomous system number, 0 if unknown
+	ASN uint
+	// Autonomous system organization
+	Organization string
+}
+
+// Lookup returns the GeoIP data for the specified IP address.
+// Empty values are returned if GeoIP is disabled or the address is not found
+func Lookup(ip string) Info {
+	var info Info
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if countryDB == nil && asnDB == nil {
+		return info
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return info
+	}
+	if countryDB != nil {
+		var record countryRecord
+		if err := countryDB.Lookup(parsedIP, &record); err != nil {
+			logger.Debug(logSender, "", "unable to lookup the country for IP %q: %v", ip, err)
+		} else {
+			info.Country = record.Country.ISOCode
+		}
+	}
+	if asnDB != nil {
+		var record asnRecord
+		if err := asnDB.Lookup(parsedIP, &record); err != nil {
+			logger.Debug(logSender, "", "unable to lookup the ASN for IP %q: %v", ip, err)
+		} else {
+			info.ASN = record.Number
+			info.Organization = record.Organization
+		}
+	}
+	return info
+}
+
+// GetCountry returns the ISO country code for the specified IP address or an
+// empty string if GeoIP is disabled or the country is unknown
+func GetCountry(ip string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if countryDB == nil {
+		return ""
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ""
+	}
+	var record countryRecord
+	if err := countryDB.Lookup(parsedIP, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Filter defines country and autonomous system based restrictions.
+// Denied countries and ASNs have precedence over the allowed ones.
+// If allowed countries or ASNs are defined, clients whose country or ASN
+// is known must match them. Unknown addresses, for example private ones,
+// are not matched by any rule
+type Filter struct {
+	// ISO 3166-1 alpha-2 country codes allowed to connect
+	AllowedCountries []string `json:"allowed_countries,omitempty" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes not allowed to connect
+	DeniedCountries []string `json:"denied_countries,omitempty" mapstructure:"denied_countries"`
+	// Autonomous system numbers allowed to connect
+	AllowedASNs []uint `json:"allowed_asns,omitempty" mapstructure:"allowed_asns"`
+	// Autonomous system numbers not allowed to connect
+	DeniedASNs []uint `json:"denied_asns,omitempty" mapstructure:"denied_asns"`
+}
+
+// IsEmpty returns true if no restriction is defined
+func (f *Filter) IsEmpty() bool {
+	return len(f.AllowedCountries) == 0 && len(f.DeniedCountries) == 0 &&
+		len(f.AllowedASNs) == 0 && len(f.DeniedASNs) == 0
+}
+
+// Validate validates and normalizes the filter
+func (f *Filter) Validate() error {
+	var err error
+
+	f.AllowedCountries, err = NormalizeCountries(f.AllowedCountries)
+	if err != nil {
+		return fmt.Errorf("invalid allowed countries: %w", err)
+	}
+	f.DeniedCountries, err = NormalizeCountries(f.DeniedCountries)
+	if err != nil {
+		return fmt.Errorf("invalid denied countries: %w", err)
+	}
+	f.AllowedASNs = NormalizeASNs(f.AllowedASNs)
+	f.DeniedASNs = NormalizeASNs(f.DeniedASNs)
+	return nil
+}
+
+// IsAllowed returns true if the specified IP address is allowed by this filter.
+// If GeoIP is disabled, all the addresses are allowed
+func (f *Filter) IsAllowed(ip string) bool {
+	if f.IsEmpty() || !IsEnabled() {
+		return true
+	}
+	info := Lookup(ip)
+	return f.isInfoAllowed(info)
+}
+
+func (f *Filter) isInfoAllowed(info Info) bool {
+	if info.Country != "" {
+		if util.Contains(f.DeniedCountries, info.Country) {
+			return false
+		}
+		if len(f.AllowedCountries) > 0 && !util.Contains(f.AllowedCountries, info.Country) {
+			return false
+		}
+	}
+	if info.ASN > 0 {
+		if util.Contains(f.DeniedASNs, info.ASN) {
+			return false
+		}
+		if len(f.AllowedASNs) > 0 && !util.Contains(f.AllowedASNs, info.ASN) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetACopy returns a copy
+func (f *Filter) GetACopy() Filter {
+	return Filter{
+		AllowedCountries: copySlice(f.AllowedCountries),
+		DeniedCountries:  copySlice(f.DeniedCountries),
+		AllowedASNs:      copySlice(f.AllowedASNs),
+		DeniedASNs:       copySlice(f.DeniedASNs),
+	}
+}
+
+func copySlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	result := make([]T, len(s))
+	copy(result, s)
+	return result
+}
+
+// NormalizeCountries validates the specified country codes and returns them
+// uppercased, sorted and without duplicates
+func NormalizeCountries(countries []string) ([]string, error) {
+	var result []string
+	for _, c := range countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return nil, fmt.Errorf("%w %q, ISO 3166-1 alpha-2 codes are required", errInvalid, c)
+		}
+		if !util.Contains(result, c) {
+			result = append(result, c)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// NormalizeASNs returns the specified autonomous system numbers sorted and
+// without duplicates and zero values
+func NormalizeASNs(asns []uint) []uint {
+	var result []uint
+	for _, asn := range asns {
+		if asn > 0 && !util.Contains(result, asn) {
+			result = append(result, asn)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
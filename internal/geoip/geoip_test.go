@@ -0,0 +1,324 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbRecord is a record of the binary search tree, it points to another
+// node, to a data section entry or nowhere
+type mmdbRecord struct {
+	node   *mmdbNode
+	data   int
+	isData bool
+}
+
+type mmdbNode struct {
+	records [2]mmdbRecord
+	number  int
+}
+
+func encodeMMDBValue(buf *bytes.Buffer, value any) {
+	writeCtrl := func(dataType, size int) {
+		// sizes from 29 to 284 require an additional byte
+		var extraSize []byte
+		if size >= 29 {
+			extraSize = []byte{byte(size - 29)}
+			size = 29
+		}
+		if dataType > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(dataType - 7))
+		} else {
+			buf.WriteByte(byte(dataType<<5 | size))
+		}
+		buf.Write(extraSize)
+	}
+	writeUint := func(dataType int, v uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], v)
+		data := bytes.TrimLeft(b[:], "\x00")
+		writeCtrl(dataType, len(data))
+		buf.Write(data)
+	}
+
+	switch v := value.(type) {
+	case string:
+		writeCtrl(2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(5, uint64(v))
+	case uint32:
+		writeUint(6, uint64(v))
+	case uint64:
+		writeUint(9, v)
+	case []string:
+		writeCtrl(11, len(v))
+		for _, s := range v {
+			encodeMMDBValue(buf, s)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeCtrl(7, len(keys))
+		for _, k := range keys {
+			encodeMMDBValue(buf, k)
+			encodeMMDBValue(buf, v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// writeTestMMDB writes an IPv4 only MaxMind DB file, with 24 bit records,
+// containing the specified networks
+func writeTestMMDB(t *testing.T, path, dbType string, networks map[string]map[string]any) {
+	root := &mmdbNode{}
+	var data bytes.Buffer
+
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		offset := data.Len()
+		encodeMMDBValue(&data, record)
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				node.records[bit] = mmdbRecord{data: offset, isData: true}
+				break
+			}
+			if node.records[bit].node == nil {
+				node.records[bit] = mmdbRecord{node: &mmdbNode{}}
+			}
+			node = node.records[bit].node
+		}
+	}
+	// number the nodes in breadth first order
+	nodes := []*mmdbNode{root}
+	for i := 0; i < len(nodes); i++ {
+		nodes[i].number = i
+		for _, r := range nodes[i].records {
+			if r.node != nil {
+				nodes = append(nodes, r.node)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	var file bytes.Buffer
+	for _, n := range nodes {
+		for _, r := range n.records {
+			value := nodeCount
+			if r.node != nil {
+				value = r.node.number
+			} else if r.isData {
+				value = nodeCount + 16 + r.data
+			}
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDBValue(&file, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               dbType,
+		"description":                 map[string]any{"en": "SFTPGo test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []string{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})
+	err := os.WriteFile(path, file.Bytes(), 0600)
+	require.NoError(t, err)
+}
+
+func writeTestDatabases(t *testing.T, dir string) {
+	writeTestMMDB(t, filepath.Join(dir, "country.mmdb"), "GeoLite2-Country", map[string]map[string]any{
+		"81.2.69.0/24": {
+			"country": map[string]any{"iso_code": "IT"},
+		},
+		"2.125.160.0/20": {
+			"country": map[string]any{"iso_code": "GB"},
+		},
+		"89.160.20.0/25": {
+			"country": map[string]any{"iso_code": "SE"},
+		},
+	})
+	writeTestMMDB(t, filepath.Join(dir, "asn.mmdb"), "GeoLite2-ASN", map[string]map[string]any{
+		"81.2.69.0/24": {
+			"autonomous_system_number":       uint32(12345),
+			"autonomous_system_organization": "Test ISP",
+		},
+		"89.160.20.0/25": {
+			"autonomous_system_number":       uint32(29518),
+			"autonomous_system_organization": "Other ISP",
+		},
+	})
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir)
+
+	c := Config{}
+	err := c.Initialize(dir)
+	require.NoError(t, err)
+	assert.False(t, IsEnabled())
+	assert.Equal(t, Info{}, Lookup("81.2.69.10"))
+	assert.Empty(t, GetCountry("81.2.69.10"))
+	assert.NoError(t, Reload())
+
+	c.CountryDBPath = "country.mmdb"
+	c.ASNDBPath = filepath.Join(dir, "asn.mmdb")
+	err = c.Initialize(dir)
+	require.NoError(t, err)
+	assert.True(t, IsEnabled())
+
+	info := Lookup("81.2.69.10")
+	assert.Equal(t, "IT", info.Country)
+	assert.Equal(t, uint(12345), info.ASN)
+	assert.Equal(t, "Test ISP", info.Organization)
+	assert.Equal(t, "IT", GetCountry("81.2.69.10"))
+	info = Lookup("2.125.170.1")
+	assert.Equal(t, "GB", info.Country)
+	assert.Equal(t, uint(0), info.ASN)
+	assert.Equal(t, "SE", GetCountry("89.160.20.100"))
+	assert.Empty(t, GetCountry("89.160.20.200"))
+	assert.Equal(t, Info{}, Lookup("192.168.1.1"))
+	assert.Equal(t, Info{}, Lookup("invalid"))
+	assert.Empty(t, GetCountry("invalid"))
+	// IPv6 lookups are not supported by IPv4 only databases
+	assert.Equal(t, Info{}, Lookup("2001:db8::1"))
+	assert.Empty(t, GetCountry("2001:db8::1"))
+
+	err = Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, "IT", GetCountry("81.2.69.10"))
+
+	c.ASNDBPath = "missing.mmdb"
+	err = c.Initialize(dir)
+	assert.Error(t, err)
+	// the previous databases are still in use
+	assert.Equal(t, uint(12345), Lookup("81.2.69.10").ASN)
+	c.CountryDBPath = "../invalid"
+	err = c.Initialize(dir)
+	assert.Error(t, err)
+	c.CountryDBPath = "asn.mmdb"
+	c.ASNDBPath = ""
+	err = c.Initialize(dir)
+	assert.NoError(t, err)
+	// an ASN database has no country data
+	assert.Empty(t, GetCountry("81.2.69.10"))
+	assert.Equal(t, uint(0), Lookup("81.2.69.10").ASN)
+
+	c = Config{}
+	err = c.Initialize(dir)
+	assert.NoError(t, err)
+	assert.False(t, IsEnabled())
+}
+
+func TestGeoIPFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir)
+
+	f := Filter{}
+	assert.True(t, f.IsEmpty())
+	assert.True(t, f.IsAllowed("81.2.69.10"))
+	f.DeniedCountries = []string{"it"}
+	assert.False(t, f.IsEmpty())
+	// GeoIP is disabled
+	assert.True(t, f.IsAllowed("81.2.69.10"))
+
+	c := Config{
+		CountryDBPath: "country.mmdb",
+		ASNDBPath:     "asn.mmdb",
+	}
+	err := c.Initialize(dir)
+	require.NoError(t, err)
+	defer func() {
+		c := Config{}
+		assert.NoError(t, c.Initialize(dir))
+	}()
+
+	err = f.Validate()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"IT"}, f.DeniedCountries)
+	assert.False(t, f.IsAllowed("81.2.69.10"))
+	assert.True(t, f.IsAllowed("2.125.170.1"))
+	// unknown addresses are allowed
+	assert.True(t, f.IsAllowed("192.168.1.1"))
+
+	f = Filter{
+		AllowedCountries: []string{" se", "gb", "SE", ""},
+	}
+	err = f.Validate()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GB", "SE"}, f.AllowedCountries)
+	assert.False(t, f.IsAllowed("81.2.69.10"))
+	assert.True(t, f.IsAllowed("2.125.170.1"))
+	assert.True(t, f.IsAllowed("89.160.20.10"))
+	assert.True(t, f.IsAllowed("10.1.1.1"))
+	f.DeniedASNs = []uint{29518, 0, 29518}
+	err = f.Validate()
+	require.NoError(t, err)
+	assert.Equal(t, []uint{29518}, f.DeniedASNs)
+	assert.False(t, f.IsAllowed("89.160.20.10"))
+	assert.True(t, f.IsAllowed("2.125.170.1"))
+
+	f = Filter{
+		AllowedASNs: []uint{12345},
+	}
+	assert.True(t, f.IsAllowed("81.2.69.10"))
+	assert.False(t, f.IsAllowed("89.160.20.10"))
+	// no ASN data
+	assert.True(t, f.IsAllowed("2.125.170.1"))
+
+	cp := f.GetACopy()
+	cp.AllowedASNs[0] = 1
+	assert.Equal(t, uint(12345), f.AllowedASNs[0])
+	assert.Nil(t, cp.AllowedCountries)
+
+	f = Filter{
+		AllowedCountries: []string{"ITA"},
+	}
+	assert.Error(t, f.Validate())
+	f = Filter{
+		DeniedCountries: []string{"1T"},
+	}
+	assert.Error(t, f.Validate())
+	countries, err := NormalizeCountries([]string{"fr", "de", "fr"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DE", "FR"}, countries)
+	assert.Equal(t, []uint{1, 2}, NormalizeASNs([]uint{2, 1, 0, 2}))
+}
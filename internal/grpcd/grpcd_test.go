@@ -0,0 +1,892 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	grpcServerAddr     = "127.0.0.1:9095"
+	grpcTLSServerAddr  = "127.0.0.1:9096"
+	grpcMTLSServerAddr = "127.0.0.1:9097"
+	httpBaseURL        = "http://127.0.0.1:8074"
+	userTokenPath      = "/api/v2/user/token"
+	userSessionsPath   = "/api/v2/user/sessions"
+	defaultUsername    = "test_user_grpc"
+	defaultPassword    = "test_password"
+	signingPassphrase  = "grpc test signing passphrase"
+)
+
+var (
+	configDir     = filepath.Join(".", "..", "..")
+	allPerms      = []string{dataprovider.PermAny}
+	homeBasePath  string
+	logFilePath   string
+	certsPath     string
+	caCertPool    *x509.CertPool
+	clientCert    tls.Certificate
+	invalidCert   tls.Certificate
+	serverCrtPath string
+	serverKeyPath string
+	caCrtPath     string
+)
+
+func TestMain(m *testing.M) {
+	logFilePath = filepath.Join(configDir, "sftpgo_grpcd_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	os.Setenv("SFTPGO_DATA_PROVIDER__CREATE_DEFAULT_ADMIN", "1")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_USERNAME", "admin")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_PASSWORD", "password")
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting GRPCD tests, provider: %v", providerConf.Driver)
+	commonConf := config.GetCommonConfig()
+	homeBasePath = os.TempDir()
+
+	certsPath, err = os.MkdirTemp("", "grpcd_certs")
+	if err != nil {
+		logger.ErrorToConsole("error creating certs dir: %v", err)
+		os.Exit(1)
+	}
+	if err := generateCertificates(); err != nil {
+		logger.ErrorToConsole("error generating certificates: %v", err)
+		os.Exit(1)
+	}
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+
+	err = common.Initialize(commonConf, 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir) //nolint:errcheck
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+
+	httpdConf := config.GetHTTPDConfig()
+	httpdConf.Bindings[0].Port = 8074
+	httpdConf.SigningPassphrase = signingPassphrase
+	httpdtest.SetBaseURL(httpBaseURL)
+
+	grpcdConf := config.GetGRPCDConfig()
+	grpcdConf.CertificateFile = serverCrtPath
+	grpcdConf.CertificateKeyFile = serverKeyPath
+	grpcdConf.CACertificates = []string{caCrtPath}
+	grpcdConf.SigningPassphrase = signingPassphrase
+	grpcdConf.Bindings = []grpcd.Binding{
+		{
+			Address: "127.0.0.1",
+			Port:    9095,
+		},
+		{
+			Address:        "127.0.0.1",
+			Port:           9096,
+			EnableTLS:      true,
+			ClientAuthType: 2,
+		},
+		{
+			Address:        "127.0.0.1",
+			Port:           9097,
+			EnableTLS:      true,
+			ClientAuthType: 1,
+		},
+	}
+
+	status := grpcd.GetStatus()
+	if status.IsActive {
+		logger.ErrorToConsole("grpc server is already active")
+		os.Exit(1)
+	}
+
+	go func() {
+		logger.Debug("grpcdTesting", "", "initializing gRPC server with config %+v", grpcdConf)
+		if err := grpcdConf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start gRPC server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := httpdConf.Initialize(configDir, 0); err != nil {
+			logger.ErrorToConsole("could not start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	waitTCPListening(grpcServerAddr)
+	waitTCPListening(grpcTLSServerAddr)
+	waitTCPListening(grpcMTLSServerAddr)
+	waitTCPListening(httpdConf.Bindings[0].GetAddress())
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.RemoveAll(certsPath)
+	os.Exit(exitCode)
+}
+
+func TestInitialization(t *testing.T) {
+	cfg := grpcd.Configuration{
+		Bindings: []grpcd.Binding{
+			{
+				Port: 0,
+			},
+		},
+	}
+	err := cfg.Initialize(configDir)
+	assert.ErrorIs(t, err, common.ErrNoBinding)
+	cfg.Bindings[0].Port = 9098
+	cfg.CertificateFile = "missing.crt"
+	cfg.CertificateKeyFile = "missing.key"
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.CertificateFile = serverCrtPath
+	cfg.CertificateKeyFile = serverKeyPath
+	cfg.CACertificates = []string{"missing_ca.crt"}
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.CACertificates = nil
+	cfg.CARevocationLists = []string{"missing.crl"}
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.CARevocationLists = nil
+	cfg.Bindings[0].Address = "127.0.0.1"
+	cfg.Bindings[0].Port = 9095
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	err = grpcd.ReloadCertificateMgr()
+	assert.NoError(t, err)
+
+	status := grpcd.GetStatus()
+	assert.True(t, status.IsActive)
+}
+
+func TestBasicOperations(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+	ctx := getAuthContext(t, defaultUsername, defaultPassword)
+
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+	assert.NoError(t, err)
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/missing/dir"})
+	assert.Error(t, err)
+
+	content := make([]byte, 150*1024+13)
+	_, err = rand.Read(content)
+	assert.NoError(t, err)
+	size, err := uploadFile(ctx, client, "/dir/file.dat", content, 32*1024, int64(len(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	info, err := client.Stat(ctx, &proto.StatRequest{Path: "dir/file.dat"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "file.dat", info.GetName())
+		assert.Equal(t, int64(len(content)), info.GetSize())
+		assert.False(t, info.GetIsDir())
+		assert.True(t, os.FileMode(info.GetMode()).IsRegular())
+		assert.InDelta(t, time.Now().UnixMilli(), info.GetModTime(), 60000)
+	}
+	info, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	if assert.NoError(t, err) {
+		assert.True(t, info.GetIsDir())
+		assert.True(t, os.FileMode(info.GetMode()).IsDir())
+	}
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/missing"})
+	assertCode(t, err, codes.NotFound)
+
+	data, err := downloadFile(ctx, client, "/dir/file.dat", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	data, err = downloadFile(ctx, client, "/dir/file.dat", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, content[1000:], data)
+	_, err = downloadFile(ctx, client, "/dir/file.dat", -1)
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = downloadFile(ctx, client, "/dir", 0)
+	assertCode(t, err, codes.Unimplemented)
+	_, err = downloadFile(ctx, client, "/missing", 0)
+	assertCode(t, err, codes.NotFound)
+	// overwrite
+	size, err = uploadFile(ctx, client, "/dir/file.dat", content[:100], 32*1024, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+	// empty file
+	size, err = uploadFile(ctx, client, "/empty", nil, 32*1024, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	// a file cannot overwrite a directory
+	_, err = uploadFile(ctx, client, "/dir", content[:100], 32*1024, 0)
+	assertCode(t, err, codes.Unimplemented)
+
+	entries, err := listDir(ctx, client, "/")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		for _, entry := range entries {
+			switch entry.GetName() {
+			case "dir":
+				assert.True(t, entry.GetIsDir())
+			case "empty":
+				assert.False(t, entry.GetIsDir())
+				assert.Equal(t, int64(0), entry.GetSize())
+			default:
+				t.Errorf("unexpected entry %q", entry.GetName())
+			}
+		}
+	}
+	_, err = listDir(ctx, client, "/missing")
+	assertCode(t, err, codes.NotFound)
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(100), user.UsedQuotaSize)
+	// a non empty directory cannot be removed
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/dir"})
+	assert.Error(t, err)
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/dir/file.dat"})
+	assert.NoError(t, err)
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/dir"})
+	assert.NoError(t, err)
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/dir"})
+	assertCode(t, err, codes.NotFound)
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.UsedQuotaFiles)
+	assert.Equal(t, int64(0), user.UsedQuotaSize)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUploadErrors(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 1000
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+	ctx := getAuthContext(t, defaultUsername, defaultPassword)
+
+	content := make([]byte, 2000)
+	_, err = rand.Read(content)
+	assert.NoError(t, err)
+	// the upload size is checked before starting the upload
+	_, err = uploadFile(ctx, client, "/file.dat", content, 512, int64(len(content)))
+	assertCode(t, err, codes.ResourceExhausted)
+	_, err = uploadFile(ctx, client, "/file.dat", content, 512, 0)
+	assertCode(t, err, codes.ResourceExhausted)
+	_, err = uploadFile(ctx, client, "/file.dat", content[:500], 512, 0)
+	assert.NoError(t, err)
+	// missing metadata
+	stream, err := client.Upload(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Data{Data: content}})
+	assert.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assertCode(t, err, codes.InvalidArgument)
+	// duplicate metadata
+	stream, err = client.Upload(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Metadata{
+			Metadata: &proto.UploadMetadata{Path: "/file1.dat"},
+		}})
+		assert.NoError(t, err)
+	}
+	_, err = stream.CloseAndRecv()
+	assertCode(t, err, codes.InvalidArgument)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPermissions(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/"] = []string{dataprovider.PermListItems}
+	u.Permissions["/sub"] = []string{dataprovider.PermUpload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub", "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+	ctx := getAuthContext(t, defaultUsername, defaultPassword)
+
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/file.txt"})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.Remove(ctx, &proto.RemoveRequest{Path: "/sub/dir"})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = downloadFile(ctx, client, "/file.txt", 0)
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = uploadFile(ctx, client, "/file1.txt", []byte("content"), 1024, 0)
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = uploadFile(ctx, client, "/sub/file.txt", []byte("content"), 1024, 0)
+	assert.NoError(t, err)
+	_, err = uploadFile(ctx, client, "/sub/file.txt", []byte("content"), 1024, 0)
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = listDir(ctx, client, "/sub")
+	assertCode(t, err, codes.PermissionDenied)
+	entries, err := listDir(ctx, client, "/")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.txt"},
+			DenyPolicy:     sdk.DenyPolicyHide,
+		},
+	}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	// the user signature changed, a new token is required
+	ctx = getAuthContext(t, defaultUsername, defaultPassword)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/file.txt"})
+	assertCode(t, err, codes.NotFound)
+	entries, err = listDir(ctx, client, "/")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+
+	_, err = client.Stat(context.Background(), &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic abc")
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+	// admin tokens are not accepted
+	adminToken, _, err := httpdtest.GetToken("admin", "password")
+	assert.NoError(t, err)
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+adminToken)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	ctx = getAuthContext(t, defaultUsername, defaultPassword)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assert.NoError(t, err)
+
+	user.Filters.DeniedProtocols = []string{common.ProtocolGRPC}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	// the token is now invalid, the user signature changed
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+	ctx = getAuthContext(t, defaultUsername, defaultPassword)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	user.Filters.DeniedProtocols = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	ctx = getAuthContext(t, defaultUsername, defaultPassword)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestJWTAuthenticationRevokedSession(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+
+	token, err := getUserToken(defaultUsername, defaultPassword)
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assert.NoError(t, err)
+	// revoke the web session using the REST API
+	req, err := http.NewRequest(http.MethodGet, httpBaseURL+userSessionsPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	require.NoError(t, err)
+	var sessions []map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&sessions)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	var sessionID string
+	for _, s := range sessions {
+		if current, ok := s["current"].(bool); ok && current {
+			sessionID = s["id"].(string)
+		}
+	}
+	require.NotEmpty(t, sessionID)
+	req, err = http.NewRequest(http.MethodDelete, fmt.Sprintf("%s%s/%s", httpBaseURL, userSessionsPath, sessionID), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = httpclient.GetHTTPClient().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	// the token is rejected by the gRPC service too
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assertCode(t, err, codes.Unauthenticated)
+	// a new token works
+	ctx = getAuthContext(t, defaultUsername, defaultPassword)
+	_, err = client.Stat(ctx, &proto.StatRequest{Path: "/"})
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	u := getTestUser()
+	u.Filters.TLSUsername = sdk.TLSUsernameCN
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	// client certificate required
+	conn, err := grpc.Dial(grpcMTLSServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:      caCertPool,
+		Certificates: []tls.Certificate{clientCert},
+		MinVersion:   tls.VersionTLS12,
+	})))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+	_, err = uploadFile(context.Background(), client, "/file.dat", []byte("content"), 1024, 0)
+	assert.NoError(t, err)
+	data, err := downloadFile(context.Background(), client, "/file.dat", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	// JWT is not accepted if a client certificate is required
+	for _, cert := range [][]tls.Certificate{nil, {invalidCert}} {
+		conn1, err := grpc.Dial(grpcMTLSServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      caCertPool,
+			Certificates: cert,
+			MinVersion:   tls.VersionTLS12,
+		})))
+		require.NoError(t, err)
+		client1 := proto.NewFileTransferClient(conn1)
+		_, err = client1.Stat(getAuthContext(t, defaultUsername, defaultPassword), &proto.StatRequest{Path: "/"})
+		assertCode(t, err, codes.Unavailable)
+		conn1.Close()
+	}
+	// client certificate or JWT
+	conn2, err := grpc.Dial(grpcTLSServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:    caCertPool,
+		MinVersion: tls.VersionTLS12,
+	})))
+	require.NoError(t, err)
+	defer conn2.Close()
+	client2 := proto.NewFileTransferClient(conn2)
+	_, err = client2.Stat(context.Background(), &proto.StatRequest{Path: "/file.dat"})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = client2.Stat(getAuthContext(t, defaultUsername, defaultPassword), &proto.StatRequest{Path: "/file.dat"})
+	assert.NoError(t, err)
+	conn3, err := grpc.Dial(grpcTLSServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:      caCertPool,
+		Certificates: []tls.Certificate{clientCert},
+		MinVersion:   tls.VersionTLS12,
+	})))
+	require.NoError(t, err)
+	defer conn3.Close()
+	client3 := proto.NewFileTransferClient(conn3)
+	_, err = client3.Stat(context.Background(), &proto.StatRequest{Path: "/file.dat"})
+	assert.NoError(t, err)
+	// TLS username verification disabled
+	user.Filters.TLSUsername = sdk.TLSUsernameNone
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = client3.Stat(context.Background(), &proto.StatRequest{Path: "/file.dat"})
+	assertCode(t, err, codes.Unauthenticated)
+	// login method not allowed
+	user.Filters.TLSUsername = sdk.TLSUsernameCN
+	user.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodTLSCertificate}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = client.Stat(context.Background(), &proto.StatRequest{Path: "/file.dat"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestConnectionsAndLimits(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewFileTransferClient(conn)
+	ctx := getAuthContext(t, defaultUsername, defaultPassword)
+
+	stream, err := client.Upload(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Metadata{
+		Metadata: &proto.UploadMetadata{Path: "/file.dat"},
+	}})
+	assert.NoError(t, err)
+	err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Data{Data: []byte("data")}})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		stats := common.Connections.GetStats("")
+		return len(stats) == 1 && len(stats[0].Transfers) == 1
+	}, 2*time.Second, 50*time.Millisecond)
+	stats := common.Connections.GetStats("")
+	require.Len(t, stats, 1)
+	assert.Equal(t, common.ProtocolGRPC, stats[0].Protocol)
+	assert.Equal(t, "Upload", stats[0].Command)
+	assert.Contains(t, stats[0].ClientVersion, "grpc-go")
+	common.Connections.Close(stats[0].ConnectionID, "")
+	_, err = stream.CloseAndRecv()
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		return len(common.Connections.GetStats("")) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if assert.Error(t, err) {
+		assert.Equal(t, code, status.Code(err), err.Error())
+	}
+}
+
+func uploadFile(ctx context.Context, client proto.FileTransferClient, name string, content []byte, chunkSize int,
+	size int64,
+) (int64, error) {
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Metadata{
+		Metadata: &proto.UploadMetadata{Path: name, Size: size},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	for offset := 0; offset < len(content); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		err = stream.Send(&proto.UploadRequest{Request: &proto.UploadRequest_Data{Data: content[offset:end]}})
+		if err == io.EOF {
+			// the server closed the stream, the error is returned by CloseAndRecv
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetSize(), nil
+}
+
+func downloadFile(ctx context.Context, client proto.FileTransferClient, name string, offset int64) ([]byte, error) {
+	stream, err := client.Download(ctx, &proto.DownloadRequest{Path: name, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(resp.GetData())
+	}
+}
+
+func listDir(ctx context.Context, client proto.FileTransferClient, name string) ([]*proto.FileInfo, error) {
+	stream, err := client.List(ctx, &proto.ListRequest{Path: name})
+	if err != nil {
+		return nil, err
+	}
+	var result []*proto.FileInfo
+	for {
+		info, err := stream.Recv()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+}
+
+func getAuthContext(t *testing.T, username, password string) context.Context {
+	token, err := getUserToken(username, password)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func getUserToken(username, password string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, httpBaseURL+userTokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, password)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	responseHolder := make(map[string]any)
+	if err := json.NewDecoder(resp.Body).Decode(&responseHolder); err != nil {
+		return "", err
+	}
+	return responseHolder["access_token"].(string), nil
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:       defaultUsername,
+			Password:       defaultPassword,
+			HomeDir:        filepath.Join(homeBasePath, defaultUsername),
+			Status:         1,
+			ExpirationDate: 0,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = allPerms
+	return user
+}
+
+// generateCertificates creates a CA, a server certificate, a client certificate signed by the CA
+// and a client certificate signed by a different CA
+func generateCertificates() error {
+	caCert, caKey, err := generateCA("grpc test CA")
+	if err != nil {
+		return err
+	}
+	caCrtPath = filepath.Join(certsPath, "ca.crt")
+	if err := writePEM(caCrtPath, "CERTIFICATE", caCert.Raw); err != nil {
+		return err
+	}
+	caCertPool = x509.NewCertPool()
+	caCertPool.AddCert(caCert)
+
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverCert, serverKey, err := generateCertificate(serverTemplate, caCert, caKey)
+	if err != nil {
+		return err
+	}
+	serverCrtPath = filepath.Join(certsPath, "server.crt")
+	serverKeyPath = filepath.Join(certsPath, "server.key")
+	if err := writePEM(serverCrtPath, "CERTIFICATE", serverCert.Certificate[0]); err != nil {
+		return err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(serverKeyPath, "EC PRIVATE KEY", keyBytes); err != nil {
+		return err
+	}
+
+	clientTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: defaultUsername},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientCert, _, err = generateCertificate(clientTemplate, caCert, caKey)
+	if err != nil {
+		return err
+	}
+	otherCACert, otherCAKey, err := generateCA("grpc test invalid CA")
+	if err != nil {
+		return err
+	}
+	invalidCert, _, err = generateCertificate(clientTemplate, otherCACert, otherCAKey)
+	return err
+}
+
+func generateCA(name string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func generateCertificate(template, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) (tls.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, key, nil
+}
+
+func writePEM(name, blockType string, data []byte) error {
+	return os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600)
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
@@ -0,0 +1,430 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// size of the data chunks sent for downloads
+	downloadChunkSize = 64 * 1024
+)
+
+// Connection details for a gRPC call
+type Connection struct {
+	*common.BaseConnection
+	localAddr     string
+	remoteAddr    string
+	method        string
+	clientVersion string
+}
+
+// GetClientVersion returns the connected client's version.
+func (c *Connection) GetClientVersion() string {
+	return c.clientVersion
+}
+
+// GetLocalAddress returns local connection address
+func (c *Connection) GetLocalAddress() string {
+	return c.localAddr
+}
+
+// GetRemoteAddress returns the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	return c.remoteAddr
+}
+
+// Disconnect closes the active transfer
+func (c *Connection) Disconnect() (err error) {
+	return c.SignalTransfersAbort()
+}
+
+// GetCommand returns the gRPC method
+func (c *Connection) GetCommand() string {
+	return c.method
+}
+
+// Stat implements the FileTransfer Stat method
+func (s *grpcServer) Stat(ctx context.Context, req *proto.StatRequest) (*proto.FileInfo, error) {
+	connection, err := getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connection.UpdateLastActivity()
+
+	name := util.CleanPath(req.GetPath())
+	info, err := connection.DoStat(name, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	return getFileInfo(info, path.Base(name)), nil
+}
+
+// List implements the FileTransfer List method
+func (s *grpcServer) List(req *proto.ListRequest, stream proto.FileTransfer_ListServer) error {
+	connection, err := getConnection(stream.Context())
+	if err != nil {
+		return err
+	}
+	connection.UpdateLastActivity()
+
+	contents, err := connection.ListDir(util.CleanPath(req.GetPath()))
+	if err != nil {
+		return err
+	}
+	for _, info := range contents {
+		if err := stream.Send(getFileInfo(info, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mkdir implements the FileTransfer Mkdir method
+func (s *grpcServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.MkdirResponse, error) {
+	connection, err := getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connection.UpdateLastActivity()
+
+	if err := connection.CreateDir(util.CleanPath(req.GetPath()), true); err != nil {
+		return nil, err
+	}
+	return &proto.MkdirResponse{}, nil
+}
+
+// Remove implements the FileTransfer Remove method
+func (s *grpcServer) Remove(ctx context.Context, req *proto.RemoveRequest) (*proto.RemoveResponse, error) {
+	connection, err := getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connection.UpdateLastActivity()
+
+	name := util.CleanPath(req.GetPath())
+	fs, p, err := connection.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Lstat(p)
+	if err != nil {
+		connection.Log(logger.LevelDebug, "failed to remove %q: stat error: %+v", p, err)
+		return nil, connection.GetFsError(fs, err)
+	}
+	if info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+		err = connection.RemoveDir(name)
+	} else {
+		err = connection.RemoveFile(fs, p, name, info)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proto.RemoveResponse{}, nil
+}
+
+// Download implements the FileTransfer Download method
+func (s *grpcServer) Download(req *proto.DownloadRequest, stream proto.FileTransfer_DownloadServer) error {
+	connection, err := getConnection(stream.Context())
+	if err != nil {
+		return err
+	}
+	if req.GetOffset() < 0 {
+		return status.Error(codes.InvalidArgument, "the offset cannot be negative")
+	}
+	file, err := connection.getFileReader(util.CleanPath(req.GetPath()), req.GetOffset())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if errSend := stream.Send(&proto.DownloadResponse{Data: buf[:n]}); errSend != nil {
+				return closeWithError(file, errSend)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return closeWithError(file, err)
+		}
+	}
+	return file.Close()
+}
+
+// Upload implements the FileTransfer Upload method
+func (s *grpcServer) Upload(stream proto.FileTransfer_UploadServer) error {
+	connection, err := getConnection(stream.Context())
+	if err != nil {
+		return err
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	metadata := req.GetMetadata()
+	if metadata == nil || metadata.GetPath() == "" {
+		return errNoMetadata
+	}
+	file, err := connection.getFileWriter(util.CleanPath(metadata.GetPath()), metadata.GetSize())
+	if err != nil {
+		return err
+	}
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if file.AbortTransfer.Load() {
+				return closeWithError(file, file.GetAbortError())
+			}
+			break
+		}
+		if err != nil {
+			return closeWithError(file, err)
+		}
+		data := req.GetData()
+		if req.GetMetadata() != nil {
+			return closeWithError(file, status.Error(codes.InvalidArgument, "unexpected upload metadata"))
+		}
+		n, err := file.Write(data)
+		size += int64(n)
+		if err != nil {
+			return closeWithError(file, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&proto.UploadResponse{Size: size})
+}
+
+func (c *Connection) getFileReader(name string, offset int64) (*grpcFile, error) {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return nil, c.GetReadQuotaExceededError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	if ok, policy := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "reading file %q is not allowed", name)
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(p)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to stat file %q: %v", p, err)
+		return nil, c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, "cannot download %q, it is not a regular file", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, name, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", name, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	file, r, cancelFn, err := fs.Open(p, offset)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q for reading: %+v", p, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, name, common.TransferDownload,
+		0, 0, 0, 0, false, fs, transferQuota)
+	return newGRPCFile(baseTransfer, nil, r), nil
+}
+
+func (c *Connection) getFileWriter(name string, uploadSize int64) (*grpcFile, error) {
+	c.UpdateLastActivity()
+
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+
+	stat, statErr := fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return nil, c.GetPermissionDeniedError()
+		}
+		return c.handleUploadFile(fs, p, filePath, name, true, 0, uploadSize)
+	}
+
+	if statErr != nil {
+		c.Log(logger.LevelError, "error performing file stat %q: %+v", p, statErr)
+		return nil, c.GetFsError(fs, statErr)
+	}
+
+	// This happen if we upload a file that has the same name of an existing directory
+	if stat.IsDir() {
+		c.Log(logger.LevelError, "attempted to open a directory for writing to: %q", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		_, _, err = fs.Rename(p, filePath)
+		if err != nil {
+			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %+v",
+				p, filePath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+	}
+
+	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size(), uploadSize)
+}
+
+func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool,
+	fileSize, uploadSize int64,
+) (*grpcFile, error) {
+	diskQuota, transferQuota := c.HasSpace(isNewFile, false, requestPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+	if isNewFile {
+		if err := c.CheckDirLimits(requestPath); err != nil {
+			return nil, err
+		}
+	}
+	_, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile && maxWriteSize > 0 {
+		maxWriteSize += fileSize
+	}
+	if uploadSize > 0 && maxWriteSize > 0 && uploadSize > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying file write, upload size %d exceeds the allowed size %d", uploadSize, maxWriteSize)
+		return nil, common.ErrQuotaExceeded
+	}
+
+	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelError, "error opening existing file, source: %q, err: %+v", filePath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	initialSize := int64(0)
+	truncatedSize := int64(0) // bytes truncated and not included in quota
+	if !isNewFile {
+		if vfs.HasTruncateSupport(fs) {
+			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+			if err == nil {
+				dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
+				if vfolder.IsIncludedInUserQuota() {
+					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+				}
+			} else {
+				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+			}
+		} else {
+			initialSize = fileSize
+			truncatedSize = fileSize
+		}
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	return newGRPCFile(baseTransfer, w, nil), nil
+}
+
+func closeWithError(file *grpcFile, err error) error {
+	file.TransferError(err)
+	file.Close() //nolint:errcheck
+	return err
+}
+
+func getFileInfo(info os.FileInfo, name string) *proto.FileInfo {
+	return &proto.FileInfo{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    uint32(info.Mode()),
+		ModTime: util.GetTimeAsMsSinceEpoch(info.ModTime()),
+		IsDir:   info.IsDir(),
+	}
+}
+
+// getRPCError converts the specified error to a gRPC status error
+func getRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, common.ErrNotExist), errors.Is(err, os.ErrNotExist), errors.Is(err, util.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, common.ErrPermissionDenied), errors.Is(err, os.ErrPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, common.ErrQuotaExceeded), errors.Is(err, common.ErrReadQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, common.ErrOpUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, common.ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, common.ErrTransferAborted):
+		return status.Error(codes.Aborted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestRPCErrors(t *testing.T) {
+	assert.NoError(t, getRPCError(nil))
+	assert.Equal(t, errNoCredentials, getRPCError(errNoCredentials))
+	for err, code := range map[error]codes.Code{
+		common.ErrNotExist:                          codes.NotFound,
+		os.ErrNotExist:                              codes.NotFound,
+		util.NewRecordNotFoundError("user"):         codes.NotFound,
+		common.ErrPermissionDenied:                  codes.PermissionDenied,
+		fmt.Errorf("wrapped: %w", os.ErrPermission): codes.PermissionDenied,
+		common.ErrQuotaExceeded:                     codes.ResourceExhausted,
+		common.ErrReadQuotaExceeded:                 codes.ResourceExhausted,
+		common.ErrOpUnsupported:                     codes.Unimplemented,
+		common.ErrShuttingDown:                      codes.Unavailable,
+		common.ErrTransferAborted:                   codes.Aborted,
+		errors.New("generic error"):                 codes.Internal,
+	} {
+		assert.Equal(t, code, status.Code(getRPCError(err)), err.Error())
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	assert.Empty(t, getBearerToken(context.Background()))
+	for val, expected := range map[string]string{
+		"Bearer abc":   "abc",
+		"bearer  abc ": "abc",
+		"Basic abc":    "",
+		"Bearer ":      "",
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, val))
+		assert.Equal(t, expected, getBearerToken(ctx), val)
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	s := &grpcServer{
+		config: &Configuration{
+			signingKey: []byte("secret"),
+		},
+	}
+	getToken := func(claims map[string]any) string {
+		token := jwt.New()
+		for k, v := range claims {
+			require.NoError(t, token.Set(k, v))
+		}
+		payload, err := jwt.Sign(token, jwt.WithKey(jwa.HS256, s.config.signingKey))
+		require.NoError(t, err)
+		return string(payload)
+	}
+	claims := map[string]any{
+		jwt.AudienceKey:   []string{tokenAudienceAPI, "127.0.0.1"},
+		jwt.SubjectKey:    "signature",
+		jwt.ExpirationKey: time.Now().Add(time.Minute),
+		claimUsernameKey:  "user",
+	}
+	subject, err := s.validateToken(getToken(claims), "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", subject.Username)
+	assert.Equal(t, "signature", subject.Signature)
+	_, err = s.validateToken(getToken(claims), "127.0.0.2")
+	assert.Error(t, err)
+	s.config.TokenValidation = 1
+	_, err = s.validateToken(getToken(claims), "127.0.0.2")
+	assert.NoError(t, err)
+	claims[claimMustChangePwd] = true
+	_, err = s.validateToken(getToken(claims), "127.0.0.1")
+	assert.Error(t, err)
+	delete(claims, claimMustChangePwd)
+	claims[claimMustSet2FA] = true
+	_, err = s.validateToken(getToken(claims), "127.0.0.1")
+	assert.Error(t, err)
+	delete(claims, claimMustSet2FA)
+	delete(claims, claimUsernameKey)
+	_, err = s.validateToken(getToken(claims), "127.0.0.1")
+	assert.Error(t, err)
+	claims[claimUsernameKey] = "user"
+	claims[jwt.ExpirationKey] = time.Now().Add(-time.Minute)
+	_, err = s.validateToken(getToken(claims), "127.0.0.1")
+	assert.Error(t, err)
+	claims[jwt.ExpirationKey] = time.Now().Add(time.Minute)
+	claims[jwt.AudienceKey] = []string{"WebClient"}
+	_, err = s.validateToken(getToken(claims), "127.0.0.1")
+	assert.Error(t, err)
+	_, err = s.validateToken("invalid", "127.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestValidateRevokedToken(t *testing.T) {
+	s := &grpcServer{
+		config: &Configuration{
+			signingKey: []byte("secret"),
+		},
+	}
+	getToken := func(issuedAt time.Time, sessionID string) string {
+		token := jwt.New()
+		require.NoError(t, token.Set(jwt.AudienceKey, []string{tokenAudienceAPI, "127.0.0.1"}))
+		require.NoError(t, token.Set(jwt.SubjectKey, "signature"))
+		require.NoError(t, token.Set(jwt.IssuedAtKey, issuedAt))
+		require.NoError(t, token.Set(jwt.ExpirationKey, time.Now().Add(time.Minute)))
+		require.NoError(t, token.Set(claimUsernameKey, "user"))
+		require.NoError(t, token.Set(claimSessionID, sessionID))
+		payload, err := jwt.Sign(token, jwt.WithKey(jwa.HS256, s.config.signingKey))
+		require.NoError(t, err)
+		return string(payload)
+	}
+	sessionID := xid.New().String()
+	err := common.WebSessions.Add(&common.WebSession{
+		ID:        sessionID,
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Minute)),
+	})
+	require.NoError(t, err)
+	_, err = s.validateToken(getToken(time.Now(), sessionID), "127.0.0.1")
+	assert.NoError(t, err)
+	err = common.RevokeWebSession(sessionID)
+	require.NoError(t, err)
+	_, err = s.validateToken(getToken(time.Now(), sessionID), "127.0.0.1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "revoked")
+	}
+	_, err = s.validateToken(getToken(time.Now(), "other"), "127.0.0.1")
+	assert.NoError(t, err)
+
+	err = common.WebSessions.SetRevokedBefore(time.Now().Add(-2 * time.Second))
+	require.NoError(t, err)
+	_, err = s.validateToken(getToken(time.Now().Add(-time.Minute), "other"), "127.0.0.1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "revoked")
+	}
+	_, err = s.validateToken(getToken(time.Now(), "other"), "127.0.0.1")
+	assert.NoError(t, err)
+
+	err = common.WebSessions.SetRevokedBefore(time.Time{})
+	assert.NoError(t, err)
+	err = common.WebSessions.Delete(sessionID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,397 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: filetransfer.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FileTransfer_Stat_FullMethodName     = "/sftpgo.filetransfer.v1.FileTransfer/Stat"
+	FileTransfer_List_FullMethodName     = "/sftpgo.filetransfer.v1.FileTransfer/List"
+	FileTransfer_Mkdir_FullMethodName    = "/sftpgo.filetransfer.v1.FileTransfer/Mkdir"
+	FileTransfer_Remove_FullMethodName   = "/sftpgo.filetransfer.v1.FileTransfer/Remove"
+	FileTransfer_Download_FullMethodName = "/sftpgo.filetransfer.v1.FileTransfer/Download"
+	FileTransfer_Upload_FullMethodName   = "/sftpgo.filetransfer.v1.FileTransfer/Upload"
+)
+
+// FileTransferClient is the client API for FileTransfer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileTransferClient interface {
+	// Stat returns the information about a file or directory
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error)
+	// List streams the contents of a directory
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileTransfer_ListClient, error)
+	// Mkdir creates a directory, the parent directory must exist
+	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error)
+	// Remove deletes a file or an empty directory
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	// Download streams the contents of a file starting from the requested offset
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileTransfer_DownloadClient, error)
+	// Upload creates or overwrites a file. The first message must contain the
+	// upload metadata, the following ones the file contents
+	Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransfer_UploadClient, error)
+}
+
+type fileTransferClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileTransferClient(cc grpc.ClientConnInterface) FileTransferClient {
+	return &fileTransferClient{cc}
+}
+
+func (c *fileTransferClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, FileTransfer_Stat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileTransfer_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransfer_ServiceDesc.Streams[0], FileTransfer_List_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransfer_ListClient interface {
+	Recv() (*FileInfo, error)
+	grpc.ClientStream
+}
+
+type fileTransferListClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferListClient) Recv() (*FileInfo, error) {
+	m := new(FileInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferClient) Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error) {
+	out := new(MkdirResponse)
+	err := c.cc.Invoke(ctx, FileTransfer_Mkdir_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
+	out := new(RemoveResponse)
+	err := c.cc.Invoke(ctx, FileTransfer_Remove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileTransfer_DownloadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransfer_ServiceDesc.Streams[1], FileTransfer_Download_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferDownloadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransfer_DownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferClient) Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransfer_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransfer_ServiceDesc.Streams[2], FileTransfer_Upload_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferUploadClient{stream}
+	return x, nil
+}
+
+type FileTransfer_UploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*UploadResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileTransferUploadClient) CloseAndRecv() (*UploadResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FileTransferServer is the server API for FileTransfer service.
+// All implementations must embed UnimplementedFileTransferServer
+// for forward compatibility
+type FileTransferServer interface {
+	// Stat returns the information about a file or directory
+	Stat(context.Context, *StatRequest) (*FileInfo, error)
+	// List streams the contents of a directory
+	List(*ListRequest, FileTransfer_ListServer) error
+	// Mkdir creates a directory, the parent directory must exist
+	Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error)
+	// Remove deletes a file or an empty directory
+	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	// Download streams the contents of a file starting from the requested offset
+	Download(*DownloadRequest, FileTransfer_DownloadServer) error
+	// Upload creates or overwrites a file. The first message must contain the
+	// upload metadata, the following ones the file contents
+	Upload(FileTransfer_UploadServer) error
+	mustEmbedUnimplementedFileTransferServer()
+}
+
+// UnimplementedFileTransferServer must be embedded to have forward compatible implementations.
+type UnimplementedFileTransferServer struct {
+}
+
+func (UnimplementedFileTransferServer) Stat(context.Context, *StatRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileTransferServer) List(*ListRequest, FileTransfer_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedFileTransferServer) Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedFileTransferServer) Remove(context.Context, *RemoveRequest) (*RemoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedFileTransferServer) Download(*DownloadRequest, FileTransfer_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedFileTransferServer) Upload(FileTransfer_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedFileTransferServer) mustEmbedUnimplementedFileTransferServer() {}
+
+// UnsafeFileTransferServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileTransferServer will
+// result in compilation errors.
+type UnsafeFileTransferServer interface {
+	mustEmbedUnimplementedFileTransferServer()
+}
+
+func RegisterFileTransferServer(s grpc.ServiceRegistrar, srv FileTransferServer) {
+	s.RegisterService(&FileTransfer_ServiceDesc, srv)
+}
+
+func _FileTransfer_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTransfer_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransfer_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServer).List(m, &fileTransferListServer{stream})
+}
+
+type FileTransfer_ListServer interface {
+	Send(*FileInfo) error
+	grpc.ServerStream
+}
+
+type fileTransferListServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferListServer) Send(m *FileInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransfer_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTransfer_Mkdir_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServer).Mkdir(ctx, req.(*MkdirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransfer_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTransfer_Remove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransfer_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServer).Download(m, &fileTransferDownloadServer{stream})
+}
+
+type FileTransfer_DownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileTransferDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransfer_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileTransferServer).Upload(&fileTransferUploadServer{stream})
+}
+
+type FileTransfer_UploadServer interface {
+	SendAndClose(*UploadResponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type fileTransferUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferUploadServer) SendAndClose(m *UploadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileTransferUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FileTransfer_ServiceDesc is the grpc.ServiceDesc for FileTransfer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileTransfer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sftpgo.filetransfer.v1.FileTransfer",
+	HandlerType: (*FileTransferServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler:    _FileTransfer_Stat_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _FileTransfer_Mkdir_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _FileTransfer_Remove_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _FileTransfer_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _FileTransfer_Download_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _FileTransfer_Upload_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "filetransfer.proto",
+}
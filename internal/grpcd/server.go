@@ -0,0 +1,445 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/rs/xid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	loginMethodJWT      = "jwt-token"
+	tokenAudienceAPI    = "APIUser"
+	claimUsernameKey    = "username"
+	claimMustChangePwd  = "chpwd"
+	claimMustSet2FA     = "2fa_required"
+	claimSessionID      = "sid"
+	authorizationHeader = "authorization"
+	bearerPrefix        = "bearer "
+)
+
+var (
+	errNoCredentials    = status.Error(codes.Unauthenticated, "no credentials provided")
+	errInvalidToken     = status.Error(codes.Unauthenticated, "invalid token")
+	errAccessDenied     = status.Error(codes.PermissionDenied, "access denied")
+	errTooManyRequests  = status.Error(codes.ResourceExhausted, "too many requests")
+	errInternalError    = status.Error(codes.Internal, "internal server error")
+	errServiceOverload  = status.Error(codes.Unavailable, "service unavailable")
+	errNoMetadata       = status.Error(codes.InvalidArgument, "the first upload message must contain the metadata")
+	errConnectionNotSet = errors.New("connection not found in context")
+)
+
+type connectionKey struct{}
+
+type grpcServer struct {
+	proto.UnimplementedFileTransferServer
+	config  *Configuration
+	binding Binding
+}
+
+func (s *grpcServer) listenAndServe() error {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+		grpc.ConnectionTimeout(30 * time.Second),
+	}
+	if certMgr != nil && s.binding.EnableTLS {
+		certID := common.DefaultTLSKeyPaidID
+		if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+			certID = s.binding.GetAddress()
+		}
+		tlsConfig := &tls.Config{
+			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
+			NextProtos:               []string{"h2"},
+			CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
+			PreferServerCipherSuites: true,
+		}
+		logger.Debug(logSender, "", "configured TLS cipher suites for binding %q: %v, certID: %v",
+			s.binding.GetAddress(), tlsConfig.CipherSuites, certID)
+		if s.binding.isMutualTLSEnabled() {
+			tlsConfig.ClientCAs = certMgr.GetRootCAs()
+			tlsConfig.VerifyConnection = s.verifyTLSConnection
+			switch s.binding.ClientAuthType {
+			case 1:
+				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			case 2:
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else {
+		s.binding.EnableTLS = false
+		s.binding.ClientAuthType = 0
+	}
+	server := grpc.NewServer(opts...)
+	proto.RegisterFileTransferServer(server, s)
+
+	util.CheckTCP4Port(s.binding.Port)
+	listener, err := net.Listen("tcp", s.binding.GetAddress())
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+		return err
+	}
+	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+	logger.Info(logSender, "", "server listener registered, address: %v TLS enabled: %v",
+		listener.Addr().String(), s.binding.EnableTLS)
+	return server.Serve(listener)
+}
+
+func (s *grpcServer) verifyTLSConnection(state tls.ConnectionState) error {
+	if certMgr != nil {
+		var clientCrt *x509.Certificate
+		var clientCrtName string
+		if len(state.PeerCertificates) > 0 {
+			clientCrt = state.PeerCertificates[0]
+			clientCrtName = clientCrt.Subject.String()
+		}
+		if len(state.VerifiedChains) == 0 {
+			if s.binding.ClientAuthType == 2 {
+				return nil
+			}
+			logger.Warn(logSender, "", "TLS connection cannot be verified: unable to get verification chain")
+			return errors.New("TLS connection cannot be verified: unable to get verification chain")
+		}
+		for _, verifiedChain := range state.VerifiedChains {
+			var caCrt *x509.Certificate
+			if len(verifiedChain) > 0 {
+				caCrt = verifiedChain[len(verifiedChain)-1]
+			}
+			if certMgr.IsRevoked(clientCrt, caCrt) {
+				logger.Debug(logSender, "", "tls handshake error, client certificate %q has been revoked", clientCrtName)
+				return common.ErrCrtRevoked
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *grpcServer) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	var resp any
+	err := s.serve(ctx, info.FullMethod, func(connection *Connection) error {
+		var err error
+		resp, err = handler(context.WithValue(ctx, connectionKey{}, connection), req)
+		return err
+	})
+	return resp, err
+}
+
+func (s *grpcServer) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return s.serve(ss.Context(), info.FullMethod, func(connection *Connection) error {
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), connectionKey{}, connection),
+		})
+	})
+}
+
+// serve checks the client address, authenticates the user and executes
+// the specified function with a new connection for the authenticated user
+func (s *grpcServer) serve(ctx context.Context, fullMethod string, fn func(*Connection) error) (err error) {
+	startTime := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in %s: %q stack trace: %v", fullMethod, r, string(debug.Stack()))
+			err = errInternalError
+		}
+	}()
+
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	ipAddr := util.GetIPFromRemoteAddress(remoteAddr)
+
+	common.Connections.AddClientConnection(ipAddr)
+	defer common.Connections.RemoveClientConnection(ipAddr)
+
+	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolGRPC); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolGRPC, "", "connection not allowed from ip %q: %v", ipAddr, err)
+		return errServiceOverload
+	}
+	if common.IsBanned(ipAddr, common.ProtocolGRPC) {
+		return errAccessDenied
+	}
+	if _, err := common.LimitRate(common.ProtocolGRPC, ipAddr); err != nil {
+		return errTooManyRequests
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolGRPC); err != nil {
+		return errAccessDenied
+	}
+	user, loginMethod, err := s.authenticate(ctx, ipAddr)
+	if err != nil {
+		if errors.Is(err, common.ErrNoCredentials) {
+			return errNoCredentials
+		}
+		return errInvalidToken
+	}
+
+	connectionID, err := s.validateUser(&user, remoteAddr)
+	if err != nil {
+		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		return errAccessDenied
+	}
+	if err = user.CheckFsRoot(connectionID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, loginMethod, common.ErrInternalFailure)
+		return errInternalError
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolGRPC, s.binding.GetAddress(),
+			remoteAddr, user),
+		localAddr:     s.binding.GetAddress(),
+		remoteAddr:    remoteAddr,
+		method:        fullMethod[strings.LastIndex(fullMethod, "/")+1:],
+		clientVersion: getClientVersion(ctx),
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable add connection: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		return errServiceOverload
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	updateLoginMetrics(&user, ipAddr, loginMethod, nil)
+	dataprovider.UpdateLastLogin(&user)
+
+	err = getRPCError(fn(connection))
+	connection.Log(logger.LevelDebug, "method %q completed, elapsed: %d ms, error: %v", connection.method,
+		time.Since(startTime).Milliseconds(), err)
+	return err
+}
+
+func (s *grpcServer) authenticate(ctx context.Context, ip string) (dataprovider.User, string, error) {
+	if s.binding.isMutualTLSEnabled() {
+		if tlsCert := getClientCertificate(ctx); tlsCert != nil {
+			return s.authenticateWithTLSCert(tlsCert, ip)
+		}
+	}
+	if s.binding.ClientAuthType == 1 {
+		return dataprovider.User{}, dataprovider.LoginMethodTLSCertificate, common.ErrNoCredentials
+	}
+	return s.authenticateWithJWT(ctx, ip)
+}
+
+func (s *grpcServer) authenticateWithTLSCert(tlsCert *x509.Certificate, ip string) (dataprovider.User, string, error) {
+	loginMethod := dataprovider.LoginMethodTLSCertificate
+	user, err := dataprovider.CheckUserAndTLSCert(tlsCert.Subject.CommonName, ip, common.ProtocolGRPC, tlsCert)
+	if err == nil && !user.IsLoginMethodAllowed(loginMethod, common.ProtocolGRPC, nil) {
+		err = fmt.Errorf("login method %q is not allowed for user %q", loginMethod, user.Username)
+	}
+	if err != nil {
+		user.Username = tlsCert.Subject.CommonName
+		updateLoginMetrics(&user, ip, loginMethod, err)
+		return user, loginMethod, err
+	}
+	return user, loginMethod, nil
+}
+
+func (s *grpcServer) authenticateWithJWT(ctx context.Context, ip string) (dataprovider.User, string, error) {
+	var user dataprovider.User
+
+	token := getBearerToken(ctx)
+	if token == "" {
+		return user, loginMethodJWT, common.ErrNoCredentials
+	}
+	if len(s.config.signingKey) == 0 {
+		logger.Debug(logSender, "", "JWT authentication is disabled, no signing passphrase configured")
+		return user, loginMethodJWT, common.ErrNoCredentials
+	}
+	subject, err := s.validateToken(token, ip)
+	if err != nil {
+		logger.Debug(logSender, "", "invalid token from ip %q: %v", ip, err)
+		updateLoginMetrics(&user, ip, loginMethodJWT, dataprovider.ErrInvalidCredentials)
+		return user, loginMethodJWT, err
+	}
+	user, err = dataprovider.GetUserWithGroupSettings(subject.Username, "")
+	if err == nil && user.GetSignature() != subject.Signature {
+		err = errors.New("the token signature does not match the user")
+	}
+	if err == nil {
+		err = user.CheckLoginConditions()
+	}
+	if err != nil {
+		user.Username = subject.Username
+		updateLoginMetrics(&user, ip, loginMethodJWT, err)
+		return user, loginMethodJWT, err
+	}
+	return user, loginMethodJWT, nil
+}
+
+type tokenSubject struct {
+	Username  string
+	Signature string
+}
+
+// validateToken validates a token issued by the REST API and returns the
+// username and the user signature it was issued for
+func (s *grpcServer) validateToken(tokenString, ip string) (tokenSubject, error) {
+	var subject tokenSubject
+
+	token, err := jwt.ParseString(tokenString, jwt.WithKey(jwa.HS256, s.config.signingKey), jwt.WithValidate(true),
+		jwt.WithAudience(tokenAudienceAPI))
+	if err != nil {
+		return subject, err
+	}
+	if s.config.TokenValidation != 1 && !util.Contains(token.Audience(), ip) {
+		return subject, fmt.Errorf("the token with id %q is not valid for the ip address %q", token.JwtID(), ip)
+	}
+	sessionID, _ := token.PrivateClaims()[claimSessionID].(string)
+	if common.IsTokenRevoked(token.IssuedAt(), sessionID) {
+		return subject, fmt.Errorf("the token with id %q was revoked", token.JwtID())
+	}
+	for _, claim := range []string{claimMustChangePwd, claimMustSet2FA} {
+		if val, ok := token.Get(claim); ok {
+			if v, ok := val.(bool); ok && v {
+				return subject, fmt.Errorf("the token with id %q is restricted, claim %q", token.JwtID(), claim)
+			}
+		}
+	}
+	if val, ok := token.Get(claimUsernameKey); ok {
+		if v, ok := val.(string); ok {
+			subject.Username = v
+		}
+	}
+	subject.Signature = token.Subject()
+	if subject.Username == "" || subject.Signature == "" {
+		return subject, errors.New("the token has no username or signature")
+	}
+	return subject, nil
+}
+
+func (s *grpcServer) validateUser(user *dataprovider.User, remoteAddr string) (string, error) {
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolGRPC, connID)
+
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %q has an invalid home dir: %q. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return connID, fmt.Errorf("cannot login user with invalid home dir: %q", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolGRPC) {
+		logger.Info(logSender, connectionID, "cannot login user %q, protocol gRPC is not allowed", user.Username)
+		return connID, fmt.Errorf("protocol gRPC is not allowed for user %q", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(remoteAddr) {
+		common.RequestIPApproval(user, remoteAddr, common.ProtocolGRPC)
+		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
+			user.Username, remoteAddr)
+		return connID, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
+	}
+	return connID, nil
+}
+
+// serverStream wraps a grpc.ServerStream to return a context including the connection
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func getConnection(ctx context.Context) (*Connection, error) {
+	connection, ok := ctx.Value(connectionKey{}).(*Connection)
+	if !ok {
+		return nil, errConnectionNotSet
+	}
+	return connection, nil
+}
+
+func getClientCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+	return tlsInfo.State.PeerCertificates[0]
+}
+
+func getBearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, val := range md.Get(authorizationHeader) {
+		if len(val) > len(bearerPrefix) && strings.EqualFold(val[:len(bearerPrefix)], bearerPrefix) {
+			return strings.TrimSpace(val[len(bearerPrefix):])
+		}
+	}
+	return ""
+}
+
+func getClientVersion(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if val := md.Get("user-agent"); len(val) > 0 {
+		return val[0]
+	}
+	return ""
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error) {
+	metric.AddLoginAttempt(loginMethod)
+	if err != nil && err != common.ErrInternalFailure && err != common.ErrNoCredentials {
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolGRPC, err.Error())
+		event := common.HostEventLoginFailed
+		if errors.Is(err, util.ErrNotFound) {
+			event = common.HostEventUserNotFound
+		}
+		common.AddDefenderEvent(ip, common.ProtocolGRPC, event)
+	}
+	metric.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolGRPC, err)
+}
@@ -104,6 +104,7 @@ func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
 	admin.Filters.TOTPConfig = dataprovider.AdminTOTPConfig{
 		Enabled: false,
 	}
+	admin.Filters.WebAuthnCredentials = nil
 	if err := dataprovider.UpdateAdmin(&admin, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -157,6 +158,7 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		updatedAdmin.Password = admin.Password
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
+	updatedAdmin.Filters.WebAuthnCredentials = admin.Filters.WebAuthnCredentials
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
 	err = dataprovider.UpdateAdmin(&updatedAdmin, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
@@ -0,0 +1,142 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getAuditLogSearchParamsFromRequest(r *http.Request) (dataprovider.AuditLogSearch, error) {
+	s := dataprovider.AuditLogSearch{
+		Limit: 100,
+		Order: dataprovider.OrderDESC,
+	}
+	if _, ok := r.URL.Query()["limit"]; ok {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			return s, util.NewValidationError(fmt.Sprintf("invalid limit: %v", err))
+		}
+		s.Limit = limit
+	}
+	if _, ok := r.URL.Query()["offset"]; ok {
+		offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+		if err != nil {
+			return s, util.NewValidationError(fmt.Sprintf("invalid offset: %v", err))
+		}
+		s.Offset = offset
+	}
+	if _, ok := r.URL.Query()["order"]; ok {
+		s.Order = r.URL.Query().Get("order")
+	}
+	if _, ok := r.URL.Query()["start_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("start_timestamp"), 10, 64)
+		if err != nil {
+			return s, util.NewValidationError(fmt.Sprintf("invalid start_timestamp: %v", err))
+		}
+		s.StartTimestamp = ts
+	}
+	if _, ok := r.URL.Query()["end_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("end_timestamp"), 10, 64)
+		if err != nil {
+			return s, util.NewValidationError(fmt.Sprintf("invalid end_timestamp: %v", err))
+		}
+		s.EndTimestamp = ts
+	}
+	s.Actions = getCommaSeparatedQueryParam(r, "actions")
+	s.ObjectTypes = getCommaSeparatedQueryParam(r, "object_types")
+	s.ObjectName = r.URL.Query().Get("object_name")
+	s.Username = r.URL.Query().Get("username")
+	s.IP = r.URL.Query().Get("ip")
+
+	return s, s.Validate()
+}
+
+func searchAuditLogs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if !dataprovider.IsAuditLogEnabled() {
+		sendAPIResponse(w, r, nil, "The audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	filters, err := getAuditLogSearchParamsFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	filters.Role = getRoleFilterForEventSearch(r, claims.Role)
+
+	if getBoolQueryParam(r, "csv_export") {
+		filters.Limit = 100
+		filters.Offset = 0
+		// ascending order ensures that entries added while exporting do not shift the pages
+		filters.Order = dataprovider.OrderASC
+		if err := exportAuditLogs(w, &filters); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	entries, err := dataprovider.SearchAuditLogs(&filters)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func exportAuditLogs(w http.ResponseWriter, filters *dataprovider.AuditLogSearch) error {
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=auditlogs-%s.csv", time.Now().Format("2006-01-02T15-04-05")))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+
+	entry := dataprovider.AuditLogEntry{}
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write(entry.GetCSVHeader())
+	if err != nil {
+		return err
+	}
+	for {
+		entries, err := dataprovider.SearchAuditLogs(filters)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := csvWriter.Write(e.GetCSVData()); err != nil {
+				return err
+			}
+		}
+		if len(entries) < filters.Limit {
+			break
+		}
+		filters.Offset += len(entries)
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,636 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	bulkOperationCreate  = "create"
+	bulkOperationUpdate  = "update"
+	bulkOperationDisable = "disable"
+	bulkOperationDelete  = "delete"
+)
+
+const (
+	bulkRowStatusOK             = "ok"
+	bulkRowStatusFailed         = "failed"
+	bulkRowStatusRolledBack     = "rolled_back"
+	bulkRowStatusRollbackFailed = "rollback_failed"
+	bulkRowStatusSkipped        = "skipped"
+	bulkJobStatusRunning        = "running"
+	bulkJobStatusCompleted      = "completed"
+)
+
+const (
+	maxBulkRequestSize   = 32 * 1048576 // 32 MB
+	maxBulkRows          = 10000
+	bulkAsyncThreshold   = 500
+	defaultBulkBatchSize = 100
+	maxBulkBatchSize     = 1000
+	bulkJobsRetention    = time.Hour
+)
+
+var bulkJobs = &bulkJobsManager{
+	jobs: make(map[string]*bulkUsersJob),
+}
+
+// bulkUserRow is a single row of a bulk request, data contains the user fields
+// as they would be sent to the single user REST API
+type bulkUserRow struct {
+	row      int
+	username string
+	data     map[string]any
+}
+
+type bulkUserResult struct {
+	Row      int    `json:"row"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type bulkUsersJobStatus struct {
+	ID            string           `json:"id"`
+	Operation     string           `json:"operation"`
+	Admin         string           `json:"admin"`
+	Status        string           `json:"status"`
+	Transactional bool             `json:"transactional"`
+	BatchSize     int              `json:"batch_size"`
+	Total         int              `json:"total"`
+	Processed     int              `json:"processed"`
+	Succeeded     int              `json:"succeeded"`
+	Failed        int              `json:"failed"`
+	StartedAt     int64            `json:"started_at"`
+	CompletedAt   int64            `json:"completed_at,omitempty"`
+	Results       []bulkUserResult `json:"results,omitempty"`
+}
+
+type bulkUsersJob struct {
+	sync.RWMutex
+	status    bulkUsersJobStatus
+	role      string
+	ipAddress string
+	// admin is the admin performing the operation, used to apply the
+	// same restrictions applied to the single user REST API
+	admin dataprovider.Admin
+}
+
+func (j *bulkUsersJob) getStatus(withResults bool) bulkUsersJobStatus {
+	j.RLock()
+	defer j.RUnlock()
+
+	status := j.status
+	if withResults {
+		status.Results = make([]bulkUserResult, len(j.status.Results))
+		copy(status.Results, j.status.Results)
+	} else {
+		status.Results = nil
+	}
+	return status
+}
+
+func (j *bulkUsersJob) isExpired() bool {
+	j.RLock()
+	defer j.RUnlock()
+
+	return j.status.CompletedAt > 0 &&
+		time.Since(util.GetTimeFromMsecSinceEpoch(j.status.CompletedAt)) > bulkJobsRetention
+}
+
+func (j *bulkUsersJob) run(rows []bulkUserRow) {
+	for start := 0; start < len(rows); start += j.status.BatchSize {
+		end := start + j.status.BatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		results := j.processBatch(rows[start:end])
+
+		j.Lock()
+		for _, res := range results {
+			if res.Status == bulkRowStatusOK {
+				j.status.Succeeded++
+			} else {
+				j.status.Failed++
+			}
+		}
+		j.status.Processed += len(results)
+		j.status.Results = append(j.status.Results, results...)
+		j.Unlock()
+	}
+
+	j.Lock()
+	j.status.Status = bulkJobStatusCompleted
+	j.status.CompletedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	logger.Debug(logSender, "", "bulk users job %q, operation %q completed, succeeded: %d, failed: %d",
+		j.status.ID, j.status.Operation, j.status.Succeeded, j.status.Failed)
+	j.Unlock()
+}
+
+// processBatch processes the given rows. For transactional jobs the first
+// failure reverts the changes already applied within the batch and the
+// remaining rows are skipped
+func (j *bulkUsersJob) processBatch(rows []bulkUserRow) []bulkUserResult {
+	results := make([]bulkUserResult, 0, len(rows))
+	undoFns := make(map[int]func() error)
+	var commitFns []func()
+	failed := false
+
+	for _, row := range rows {
+		res := bulkUserResult{
+			Row:      row.row,
+			Username: row.username,
+		}
+		if failed {
+			res.Status = bulkRowStatusSkipped
+			res.Error = "skipped due to a previous failure in the same batch"
+			results = append(results, res)
+			continue
+		}
+		undo, commit, err := j.processRow(row)
+		if err != nil {
+			res.Status = bulkRowStatusFailed
+			res.Error = err.Error()
+			if j.status.Transactional {
+				failed = true
+				commitFns = nil
+				j.rollback(results, undoFns)
+			}
+		} else {
+			res.Status = bulkRowStatusOK
+			undoFns[len(results)] = undo
+			if commit != nil {
+				commitFns = append(commitFns, commit)
+			}
+		}
+		results = append(results, res)
+	}
+	for _, fn := range commitFns {
+		fn()
+	}
+	return results
+}
+
+func (j *bulkUsersJob) rollback(results []bulkUserResult, undoFns map[int]func() error) {
+	for idx := len(results) - 1; idx >= 0; idx-- {
+		undo, ok := undoFns[idx]
+		if !ok {
+			continue
+		}
+		if err := undo(); err != nil {
+			logger.Warn(logSender, "", "bulk users job %q, unable to roll back row %d, username %q: %v",
+				j.status.ID, results[idx].Row, results[idx].Username, err)
+			results[idx].Status = bulkRowStatusRollbackFailed
+			results[idx].Error = err.Error()
+			continue
+		}
+		results[idx].Status = bulkRowStatusRolledBack
+	}
+}
+
+// processRow applies the job operation to the given row. It returns a function
+// to revert the change and an optional function to execute once the batch is
+// committed
+func (j *bulkUsersJob) processRow(row bulkUserRow) (func() error, func(), error) {
+	if row.username == "" {
+		return nil, nil, util.NewValidationError("username is mandatory")
+	}
+	switch j.status.Operation {
+	case bulkOperationCreate:
+		return j.createUser(row)
+	case bulkOperationUpdate:
+		return j.updateUser(row)
+	case bulkOperationDisable:
+		return j.disableUser(row)
+	default:
+		return j.deleteUser(row)
+	}
+}
+
+func (j *bulkUsersJob) createUser(row bulkUserRow) (func() error, func(), error) {
+	var user dataprovider.User
+	if j.admin.Filters.Preferences.DefaultUsersExpiration > 0 {
+		user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour *
+			time.Duration(j.admin.Filters.Preferences.DefaultUsersExpiration)))
+	}
+	user, err := dataprovider.GetPatchedUser(&user, row.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Filters.Template.Name != "" {
+		if err := dataprovider.ApplyTemplateToUser(&user); err != nil {
+			return nil, nil, err
+		}
+	}
+	if j.role != "" {
+		user.Role = j.role
+	}
+	user.LastPasswordChange = 0
+	user.Filters.RecoveryCodes = nil
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled: false,
+	}
+	user.Filters.WebAuthnCredentials = nil
+	if j.admin.Filters.HideFsConfig {
+		if err := restoreFoldersFsConfig(user.VirtualFolders); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := dataprovider.AddUser(&user, j.status.Admin, j.ipAddress, j.role); err != nil {
+		return nil, nil, err
+	}
+	undo := func() error {
+		return dataprovider.DeleteUser(user.Username, j.status.Admin, j.ipAddress, j.role)
+	}
+	return undo, nil, nil
+}
+
+func (j *bulkUsersJob) updateUser(row bulkUserRow) (func() error, func(), error) {
+	user, err := dataprovider.UserExists(row.username, j.role)
+	if err != nil {
+		return nil, nil, err
+	}
+	updatedUser, err := dataprovider.GetPatchedUser(&user, row.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	updatedUser.ID = user.ID
+	updatedUser.Username = user.Username
+	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
+	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
+	updatedUser.LastPasswordChange = user.LastPasswordChange
+	if j.admin.Filters.HideFsConfig {
+		updatedUser.HomeDir = user.HomeDir
+		updatedUser.FsConfig = user.FsConfig
+		if err := restoreFoldersFsConfig(updatedUser.VirtualFolders); err != nil {
+			return nil, nil, err
+		}
+	}
+	if j.role != "" {
+		updatedUser.Role = j.role
+	}
+	if err := dataprovider.UpdateUser(&updatedUser, j.status.Admin, j.ipAddress, j.role); err != nil {
+		return nil, nil, err
+	}
+	return j.getRestoreUserFn(user), nil, nil
+}
+
+func (j *bulkUsersJob) disableUser(row bulkUserRow) (func() error, func(), error) {
+	user, err := dataprovider.UserExists(row.username, j.role)
+	if err != nil {
+		return nil, nil, err
+	}
+	updatedUser, err := dataprovider.GetPatchedUser(&user, map[string]any{"status": 0})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := dataprovider.UpdateUser(&updatedUser, j.status.Admin, j.ipAddress, j.role); err != nil {
+		return nil, nil, err
+	}
+	commit := func() {
+		disconnectUser(user.Username, j.status.Admin, j.role)
+	}
+	return j.getRestoreUserFn(user), commit, nil
+}
+
+func (j *bulkUsersJob) deleteUser(row bulkUserRow) (func() error, func(), error) {
+	user, err := dataprovider.UserExists(row.username, j.role)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := dataprovider.DeleteUser(user.Username, j.status.Admin, j.ipAddress, j.role); err != nil {
+		return nil, nil, err
+	}
+	undo := func() error {
+		return dataprovider.AddUser(&user, j.status.Admin, j.ipAddress, j.role)
+	}
+	commit := func() {
+		disconnectUser(user.Username, j.status.Admin, j.role)
+	}
+	return undo, commit, nil
+}
+
+func (j *bulkUsersJob) getRestoreUserFn(user dataprovider.User) func() error {
+	return func() error {
+		return dataprovider.UpdateUser(&user, j.status.Admin, j.ipAddress, j.role)
+	}
+}
+
+type bulkJobsManager struct {
+	sync.RWMutex
+	jobs map[string]*bulkUsersJob
+}
+
+func (m *bulkJobsManager) add(job *bulkUsersJob) {
+	m.Lock()
+	defer m.Unlock()
+
+	for id, j := range m.jobs {
+		if j.isExpired() {
+			delete(m.jobs, id)
+		}
+	}
+	m.jobs[job.status.ID] = job
+}
+
+func (m *bulkJobsManager) get(id, admin string) (bulkUsersJobStatus, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok || job.status.Admin != admin || job.isExpired() {
+		return bulkUsersJobStatus{}, util.NewRecordNotFoundError(fmt.Sprintf("bulk job %q does not exist", id))
+	}
+	return job.getStatus(true), nil
+}
+
+func (m *bulkJobsManager) list(admin string) []bulkUsersJobStatus {
+	m.RLock()
+	defer m.RUnlock()
+
+	result := make([]bulkUsersJobStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if job.status.Admin == admin && !job.isExpired() {
+			result = append(result, job.getStatus(false))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt > result[j].StartedAt
+	})
+	return result
+}
+
+func getBulkUsersJobs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	render.JSON(w, r, bulkJobs.list(claims.Username))
+}
+
+func getBulkUsersJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	job, err := bulkJobs.get(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+func bulkCreateUsers(w http.ResponseWriter, r *http.Request) {
+	handleBulkUsers(w, r, bulkOperationCreate)
+}
+
+func bulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	handleBulkUsers(w, r, bulkOperationUpdate)
+}
+
+func bulkDisableUsers(w http.ResponseWriter, r *http.Request) {
+	handleBulkUsers(w, r, bulkOperationDisable)
+}
+
+func bulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	handleBulkUsers(w, r, bulkOperationDelete)
+}
+
+func handleBulkUsers(w http.ResponseWriter, r *http.Request, operation string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	batchSize := defaultBulkBatchSize
+	if val := r.URL.Query().Get("batch_size"); val != "" {
+		batchSize, err = strconv.Atoi(val)
+		if err != nil || batchSize < 1 || batchSize > maxBulkBatchSize {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Invalid batch_size, it must be between 1 and %d", maxBulkBatchSize),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	var rows []bulkUserRow
+	if isCSVContentType(r) {
+		rows, err = getBulkUserRowsFromCSV(r.Body)
+	} else {
+		rows, err = getBulkUserRowsFromJSON(r.Body)
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		sendAPIResponse(w, r, nil, "No users to process", http.StatusBadRequest)
+		return
+	}
+	if len(rows) > maxBulkRows {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Too many users, the limit is %d per request", maxBulkRows),
+			http.StatusBadRequest)
+		return
+	}
+
+	job := &bulkUsersJob{
+		status: bulkUsersJobStatus{
+			ID:            util.GenerateUniqueID(),
+			Operation:     operation,
+			Admin:         claims.Username,
+			Status:        bulkJobStatusRunning,
+			Transactional: getBoolQueryParam(r, "transactional"),
+			BatchSize:     batchSize,
+			Total:         len(rows),
+			StartedAt:     util.GetTimeAsMsSinceEpoch(time.Now()),
+		},
+		role:      claims.Role,
+		ipAddress: util.GetIPFromRemoteAddress(r.RemoteAddr),
+		admin:     admin,
+	}
+	bulkJobs.add(job)
+
+	if getBoolQueryParam(r, "async") || len(rows) > bulkAsyncThreshold {
+		go job.run(rows)
+
+		w.Header().Add("Location", fmt.Sprintf("%s/bulk/jobs/%s", userPath, url.PathEscape(job.status.ID)))
+		ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusAccepted)
+		render.JSON(w, r.WithContext(ctx), job.getStatus(false))
+		return
+	}
+	job.run(rows)
+	render.JSON(w, r, job.getStatus(true))
+}
+
+func isCSVContentType(r *http.Request) bool {
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(contentType, "text/csv")
+}
+
+func getBulkUserRowsFromJSON(reader io.Reader) ([]bulkUserRow, error) {
+	var items []map[string]any
+	if err := json.NewDecoder(reader).Decode(&items); err != nil {
+		return nil, fmt.Errorf("unable to decode JSON array: %w", err)
+	}
+	rows := make([]bulkUserRow, 0, len(items))
+	for idx, item := range items {
+		username, _ := item["username"].(string)
+		rows = append(rows, bulkUserRow{
+			row:      idx + 1,
+			username: username,
+			data:     item,
+		})
+	}
+	return rows, nil
+}
+
+func getBulkUserRowsFromCSV(reader io.Reader) ([]bulkUserRow, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+	hasUsername := false
+	for idx, column := range header {
+		header[idx] = strings.ToLower(strings.TrimSpace(column))
+		if err := setBulkUserCSVField(make(map[string]any), header[idx], ""); err != nil {
+			return nil, err
+		}
+		if header[idx] == "username" {
+			hasUsername = true
+		}
+	}
+	if !hasUsername {
+		return nil, errors.New("the CSV header must include the username column")
+	}
+	var rows []bulkUserRow
+	for {
+		record, err := csvReader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("unable to read CSV record: %w", err)
+		}
+		row := bulkUserRow{
+			row:  len(rows) + 1,
+			data: make(map[string]any),
+		}
+		for idx, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if err := setBulkUserCSVField(row.data, header[idx], value); err != nil {
+				return nil, fmt.Errorf("row %d: %w", row.row, err)
+			}
+		}
+		row.username, _ = row.data["username"].(string)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// setBulkUserCSVField converts a CSV column to the matching user field.
+// An empty value only validates the column name
+func setBulkUserCSVField(data map[string]any, column, value string) error {
+	switch column {
+	case "username", "password", "email", "description", "home_dir", "role", "additional_info":
+		data[column] = value
+	case "status", "quota_files", "max_sessions", "uid", "gid", "quota_size", "expiration_date",
+		"upload_bandwidth", "download_bandwidth":
+		if value == "" {
+			return nil
+		}
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for column %q: %w", value, column, err)
+		}
+		data[column] = val
+	case "primary_group", "secondary_groups":
+		groupType := sdk.GroupTypeSecondary
+		if column == "primary_group" {
+			groupType = sdk.GroupTypePrimary
+		}
+		groups, _ := data["groups"].([]any)
+		for _, name := range getBulkCSVList(value) {
+			groups = append(groups, map[string]any{
+				"name": name,
+				"type": groupType,
+			})
+		}
+		data["groups"] = groups
+	case "permissions":
+		data[column] = map[string]any{
+			"/": getBulkCSVList(value),
+		}
+	case "public_keys":
+		data[column] = getBulkCSVList(value)
+	case "template":
+		data["filters"] = map[string]any{
+			"template": map[string]any{
+				"name": value,
+			},
+		}
+	default:
+		return fmt.Errorf("unsupported CSV column %q", column)
+	}
+	return nil
+}
+
+func getBulkCSVList(value string) []any {
+	var result []any
+	for _, val := range strings.Split(value, ";") {
+		val = strings.TrimSpace(val)
+		if val != "" {
+			result = append(result, val)
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const maxChangeEventsWait = 60
+
+func getChangeEvents(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if !dataprovider.IsChangeEventsEnabled() {
+		sendAPIResponse(w, r, nil, "The change events stream is disabled", http.StatusNotFound)
+		return
+	}
+	// the stream includes the objects of any role, so it cannot be filtered for role administrators
+	if claims.Role != "" {
+		sendAPIResponse(w, r, nil, "The change events stream is only available to global administrators",
+			http.StatusForbidden)
+		return
+	}
+	var cursor int64
+	limit := 100
+	wait := 0
+	if _, ok := r.URL.Query()["cursor"]; ok {
+		cursor, err = strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+		if err != nil {
+			err = util.NewValidationError(fmt.Sprintf("invalid cursor: %v", err))
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["limit"]; ok {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			err = util.NewValidationError(fmt.Sprintf("invalid limit: %v", err))
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["wait"]; ok {
+		wait, err = strconv.Atoi(r.URL.Query().Get("wait"))
+		if err != nil || wait < 0 || wait > maxChangeEventsWait {
+			err = util.NewValidationError(fmt.Sprintf("invalid wait, it must be between 0 and %d seconds",
+				maxChangeEventsWait))
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+
+	batch, err := dataprovider.GetChangeEvents(r.Context(), cursor, limit, time.Duration(wait)*time.Second)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, batch)
+}
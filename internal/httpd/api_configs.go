@@ -15,12 +15,16 @@
 package httpd
 
 import (
+	"fmt"
 	"net/http"
+	"path/filepath"
 
 	"github.com/go-chi/render"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 type smtpTestRequest struct {
@@ -55,3 +59,97 @@ func testSMTPConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, nil, "SMTP connection OK", http.StatusOK)
 }
+
+func getOIDCMappings(w http.ResponseWriter, r *http.Request) {
+	mappings, err := dataprovider.GetOIDCMappings()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, mappings)
+}
+
+func updateOIDCMappings(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var mappings []dataprovider.OIDCMapping
+	err = render.DecodeJSON(r.Body, &mappings)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateOIDCMappings(mappings, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "OIDC mappings updated", http.StatusOK)
+}
+
+func getBackupConfigs(w http.ResponseWriter, r *http.Request) {
+	backups, err := dataprovider.GetBackupConfigs()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	backups.HideConfidentialData()
+	render.JSON(w, r, backups)
+}
+
+func updateBackupConfigs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	current, err := dataprovider.GetBackupConfigs()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	var backups dataprovider.BackupConfigs
+	err = render.DecodeJSON(r.Body, &backups)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	// we use the new secrets if plain or empty, otherwise the old values
+	current.SetEmptySecretsIfNil()
+	backups.SetEmptySecretsIfNil()
+	if backups.Passphrase.IsNotPlainAndNotEmpty() {
+		backups.Passphrase = current.Passphrase
+	}
+	if backups.Remote != nil {
+		fsConfig := vfs.Filesystem{}
+		if current.Remote != nil {
+			fsConfig = current.Remote.Filesystem
+		}
+		fsConfig.SetEmptySecretsIfNil()
+		updateEncryptedSecrets(&backups.Remote.Filesystem, fsConfig.S3Config.AccessSecret, fsConfig.AzBlobConfig.AccountKey,
+			fsConfig.AzBlobConfig.SASURL, fsConfig.GCSConfig.Credentials, fsConfig.CryptConfig.Passphrase,
+			fsConfig.SFTPConfig.Password, fsConfig.SFTPConfig.PrivateKey, fsConfig.SFTPConfig.KeyPassphrase,
+			fsConfig.HTTPConfig.Password, fsConfig.HTTPConfig.APIKey, fsConfig.HTTPConfig.OAuth2.ClientSecret)
+	}
+	err = dataprovider.UpdateBackupConfigs(&backups, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Backup configs updated", http.StatusOK)
+}
+
+func runScheduledBackup(w http.ResponseWriter, r *http.Request) {
+	outputFile, err := dataprovider.ExecuteScheduledBackup()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, fmt.Sprintf("Backup saved to %q", filepath.Base(outputFile)), http.StatusOK)
+}
@@ -117,6 +117,14 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+	case dataprovider.ActionTypeFilesystem:
+		updatedAction.Options.FsConfig.PGP.KeepSecrets(&action.Options.FsConfig.PGP)
+	case dataprovider.ActionTypeMessageBroker:
+		if updatedAction.Options.BrokerConfig.Password.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.BrokerConfig.Password = action.Options.BrokerConfig.Password
+		}
+	case dataprovider.ActionTypeCloudFunction:
+		updatedAction.Options.FunctionConfig.KeepSecrets(&action.Options.FunctionConfig)
 	}
 
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
@@ -150,7 +158,21 @@ func getEventRules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rules, err := dataprovider.GetEventRules(limit, offset, order)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var rules []dataprovider.EventRule
+	tenant, ok, err := getAdminTenant(&claims)
+	if err == nil {
+		if ok {
+			rules, err = dataprovider.GetTenantEventRules(&tenant, limit, offset, order)
+		} else {
+			rules, err = dataprovider.GetEventRules(limit, offset, order)
+		}
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -193,11 +215,19 @@ func addEventRule(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if err := checkTenantNewObject(&claims, dataprovider.TenantObjectEventRule, rule.Name); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := dataprovider.AddEventRule(&rule, claims.Username, ipAddr, claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if err := addObjectToAdminTenant(&claims, dataprovider.TenantObjectEventRule, rule.Name, ipAddr); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	w.Header().Add("Location", fmt.Sprintf("%s/%s", eventRulesPath, url.PathEscape(rule.Name)))
 	renderEventRule(w, r, rule.Name, http.StatusCreated)
 }
@@ -259,3 +289,35 @@ func runOnDemandRule(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, nil, "Event rule started", http.StatusAccepted)
 }
+
+func dryRunEventRule(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var req common.EventDryRunRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	trace, err := common.DryRunEventRule(getURLParam(r, "name"), req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, trace)
+}
+
+func dryRunEventAction(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var req common.EventDryRunRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	trace, err := common.DryRunEventAction(getURLParam(r, "name"), req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, trace)
+}
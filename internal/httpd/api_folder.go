@@ -34,11 +34,30 @@ func getFolders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	folders, err := dataprovider.GetFolders(limit, offset, order, false)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var folders []vfs.BaseVirtualFolder
+	tenant, ok, err := getAdminTenant(&claims)
+	if err == nil {
+		if ok {
+			folders, err = dataprovider.GetTenantFolders(&tenant, limit, offset, order)
+		} else {
+			folders, err = dataprovider.GetFolders(limit, offset, order, false)
+		}
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
 	}
+	if isFsConfigHidden(r) {
+		for idx := range folders {
+			folders[idx].FsConfig.HideConfig()
+		}
+	}
 	render.JSON(w, r, folders)
 }
 
@@ -57,10 +76,19 @@ func addFolder(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if err := checkTenantNewObject(&claims, dataprovider.TenantObjectFolder, folder.Name); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	if err := dataprovider.AddFolder(&folder, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	err = addObjectToAdminTenant(&claims, dataprovider.TenantObjectFolder, folder.Name, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	w.Header().Add("Location", fmt.Sprintf("%s/%s", folderPath, url.PathEscape(folder.Name)))
 	renderFolder(w, r, folder.Name, http.StatusCreated)
 }
@@ -92,7 +120,12 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
 		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
 		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.SFTPConfig.KeyPassphrase,
-		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey)
+		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey, folder.FsConfig.HTTPConfig.OAuth2.ClientSecret)
+	updateCredentialsRotationTime(&updatedFolder.FsConfig, &folder.FsConfig)
+	if isFsConfigHidden(r) {
+		updatedFolder.MappedPath = folder.MappedPath
+		updatedFolder.FsConfig = folder.FsConfig
+	}
 
 	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, claims.Username,
 		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
@@ -110,6 +143,9 @@ func renderFolder(w http.ResponseWriter, r *http.Request, name string, status in
 		return
 	}
 	folder.PrepareForRendering()
+	if isFsConfigHidden(r) {
+		folder.FsConfig.HideConfig()
+	}
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), folder)
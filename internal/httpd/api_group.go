@@ -32,12 +32,30 @@ func getGroups(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 
-	groups, err := dataprovider.GetGroups(limit, offset, order, false)
+	var groups []dataprovider.Group
+	tenant, ok, err := getAdminTenant(&claims)
+	if err == nil {
+		if ok {
+			groups, err = dataprovider.GetTenantGroups(&tenant, limit, offset, order)
+		} else {
+			groups, err = dataprovider.GetGroups(limit, offset, order, false)
+		}
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
 	}
+	if isFsConfigHidden(r) {
+		for idx := range groups {
+			groups[idx].HideFsConfigs()
+		}
+	}
 	render.JSON(w, r, groups)
 }
 
@@ -55,11 +73,30 @@ func addGroup(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if isFsConfigHidden(r) {
+		if err := restoreFoldersFsConfig(group.VirtualFolders); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	if err := checkTenantNewObject(&claims, dataprovider.TenantObjectGroup, group.Name); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if err := checkTenantGroup(&claims, &group); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.AddGroup(&group, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	err = addObjectToAdminTenant(&claims, dataprovider.TenantObjectGroup, group.Name, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	w.Header().Add("Location", fmt.Sprintf("%s/%s", groupPath, url.PathEscape(group.Name)))
 	renderGroup(w, r, group.Name, http.StatusCreated)
 }
@@ -89,6 +126,7 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	currentSFTPKeyPassphrase := group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase
 	currentHTTPPassword := group.UserSettings.FsConfig.HTTPConfig.Password
 	currentHTTPAPIKey := group.UserSettings.FsConfig.HTTPConfig.APIKey
+	currentHTTPOAuth2Secret := group.UserSettings.FsConfig.HTTPConfig.OAuth2.ClientSecret
 
 	var updatedGroup dataprovider.Group
 	err = render.DecodeJSON(r.Body, &updatedGroup)
@@ -101,7 +139,20 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	updatedGroup.UserSettings.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
 		currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-		currentHTTPPassword, currentHTTPAPIKey)
+		currentHTTPPassword, currentHTTPAPIKey, currentHTTPOAuth2Secret)
+	updateCredentialsRotationTime(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
+	if isFsConfigHidden(r) {
+		updatedGroup.UserSettings.HomeDir = group.UserSettings.HomeDir
+		updatedGroup.UserSettings.FsConfig = group.UserSettings.FsConfig
+		if err := restoreFoldersFsConfig(updatedGroup.VirtualFolders); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	if err := checkTenantGroup(&claims, &updatedGroup); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
 		claims.Role)
 	if err != nil {
@@ -118,6 +169,9 @@ func renderGroup(w http.ResponseWriter, r *http.Request, name string, status int
 		return
 	}
 	group.PrepareForRendering()
+	if isFsConfigHidden(r) {
+		group.HideFsConfigs()
+	}
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), group)
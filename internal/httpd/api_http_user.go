@@ -25,6 +25,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/render"
 	"github.com/rs/xid"
@@ -32,6 +33,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -210,6 +212,34 @@ func getUserFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getUserFileInfo(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested path", getMappedStatusCode(err))
+		return
+	}
+	res := make(map[string]any)
+	res["name"] = info.Name()
+	res["mode"] = info.Mode()
+	res["last_modified"] = info.ModTime().UTC().Format(time.RFC3339)
+	if info.Mode().IsRegular() {
+		res["size"] = info.Size()
+		checksums, err := connection.GetChecksums(name)
+		if err == nil && len(checksums) > 0 {
+			res["checksums"] = checksums
+		}
+	}
+	render.JSON(w, r, res)
+}
+
 func setFileDirMetadata(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -501,6 +531,51 @@ func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, err, "Profile updated", http.StatusOK)
 }
 
+func issueUserSSHCertificate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !canIssueSSHCertificate(&user) {
+		sendAPIResponse(w, r, nil, "SSH certificates are not allowed for this user", http.StatusForbidden)
+		return
+	}
+	var req sshCertificateRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	cert, expiresAt, err := sftpd.IssueUserCertificate(user.Username, req.PublicKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, sshCertificateResponse{
+		Certificate: cert,
+		ExpiresAt:   util.GetTimeAsMsSinceEpoch(expiresAt),
+	})
+}
+
+// canIssueSSHCertificate returns true if the built-in SSH user CA is enabled
+// and the user can login using SSH certificates
+func canIssueSSHCertificate(user *dataprovider.User) bool {
+	if !sftpd.IsUserCAEnabled() {
+		return false
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolSSH) {
+		return false
+	}
+	return user.IsLoginMethodAllowed(dataprovider.SSHLoginMethodPublicKey, common.ProtocolSSH, nil)
+}
+
 func changeUserPassword(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -533,7 +608,7 @@ func doChangeUserPassword(r *http.Request, currentPassword, newPassword, confirm
 		return errors.New("invalid token claims")
 	}
 	_, err = dataprovider.CheckUserAndPass(claims.Username, currentPassword, util.GetIPFromRemoteAddress(r.RemoteAddr),
-		getProtocolFromRequest(r))
+		getProtocolFromRequest(r), "")
 	if err != nil {
 		return util.NewValidationError("current password does not match")
 	}
@@ -561,3 +636,79 @@ func setModificationTimeFromHeader(r *http.Request, c *Connection, filePath stri
 		}
 	}
 }
+
+func getUserTrash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	items, err := connection.ListTrash()
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to list the trash", getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, items)
+}
+
+func getUserPartialDownloads(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	render.JSON(w, r, connection.GetPartialDownloads())
+}
+
+func deleteUserPartialDownload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if err := connection.RemovePartialDownload(name); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to remove the partial download for %q", name),
+			getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Partial download removed", http.StatusOK)
+}
+
+func restoreUserTrashItem(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	itemID := getURLParam(r, "id")
+	if err := connection.RestoreFromTrash(itemID); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore trash item %q", itemID), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Item restored", http.StatusOK)
+}
+
+func deleteUserTrashItem(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	itemID := getURLParam(r, "id")
+	if err := connection.DeleteFromTrash(itemID); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to delete trash item %q", itemID), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Item deleted", http.StatusOK)
+}
@@ -29,9 +29,11 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
+	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
 func validateBackupFile(outputFile string) (string, error) {
@@ -121,6 +123,11 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	objectType, objectName, preview, err := getLoaddataObjectOptions(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 
 	content, err := io.ReadAll(r.Body)
 	if err != nil || len(content) == 0 {
@@ -130,6 +137,10 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if objectType != "" {
+		restoreObjectFromBackup(w, r, content, "", scanQuota, mode, objectType, objectName, preview, claims)
+		return
+	}
 	if err := restoreBackup(content, "", scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -149,6 +160,11 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	objectType, objectName, preview, err := getLoaddataObjectOptions(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 	if !filepath.IsAbs(inputFile) {
 		sendAPIResponse(w, r, fmt.Errorf("invalid input_file %q: it must be an absolute path", inputFile), "",
 			http.StatusBadRequest)
@@ -170,6 +186,10 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if objectType != "" {
+		restoreObjectFromBackup(w, r, content, inputFile, scanQuota, mode, objectType, objectName, preview, claims)
+		return
+	}
 	if err := restoreBackup(content, inputFile, scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -195,6 +215,10 @@ func restoreBackup(content []byte, inputFile string, scanQuota, mode int, execut
 		return err
 	}
 
+	if err = RestoreTemplates(dump.Templates, inputFile, mode, executor, ipAddress, role); err != nil {
+		return err
+	}
+
 	if err = RestoreFolders(dump.Folders, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
 		return err
 	}
@@ -494,10 +518,32 @@ func RestoreRoles(roles []dataprovider.Role, inputFile string, mode int, executo
 	return nil
 }
 
+// RestoreTemplates restores the specified templates, parents are restored before their children
+func RestoreTemplates(templates []dataprovider.Template, inputFile string, mode int, executor, ipAddress, role string) error {
+	for _, template := range dataprovider.SortTemplatesByInheritance(templates) {
+		t, err := dataprovider.TemplateExists(template.Name)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing template %q not updated", t.Name)
+				continue
+			}
+			template.ID = t.ID
+			err = dataprovider.UpdateTemplate(&template, executor, ipAddress, role)
+			logger.Debug(logSender, "", "restoring existing template: %q, dump file: %q, error: %v", template.Name, inputFile, err)
+		} else {
+			err = dataprovider.AddTemplate(&template, executor, ipAddress, role)
+			logger.Debug(logSender, "", "adding new template: %q, dump file: %q, error: %v", template.Name, inputFile, err)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to restore template %q: %w", template.Name, err)
+		}
+	}
+	return nil
+}
+
 // RestoreGroups restores the specified groups
 func RestoreGroups(groups []dataprovider.Group, inputFile string, mode int, executor, ipAddress, role string) error {
-	for idx := range groups {
-		group := groups[idx]
+	for _, group := range dataprovider.SortGroupsByInclusion(groups) {
 		g, err := dataprovider.GroupExists(group.Name)
 		if err == nil {
 			if mode == 1 {
@@ -552,3 +598,25 @@ func RestoreUsers(users []dataprovider.User, inputFile string, mode, scanQuota i
 	}
 	return nil
 }
+
+func reloadRevocationData(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	if err := ftpd.ReloadRevocationData(); err != nil {
+		logger.Error(logSender, "", "unable to reload FTP revocation data: %v", err)
+		sendAPIResponse(w, r, err, "Unable to reload FTP revocation data", http.StatusInternalServerError)
+		return
+	}
+	if err := webdavd.ReloadRevocationData(); err != nil {
+		logger.Error(logSender, "", "unable to reload WebDAV revocation data: %v", err)
+		sendAPIResponse(w, r, err, "Unable to reload WebDAV revocation data", http.StatusInternalServerError)
+		return
+	}
+	if err := ReloadRevocationData(); err != nil {
+		logger.Error(logSender, "", "unable to reload HTTP revocation data: %v", err)
+		sendAPIResponse(w, r, err, "Unable to reload HTTP revocation data", http.StatusInternalServerError)
+		return
+	}
+	logger.Info(logSender, "", "revocation data reloaded")
+	sendAPIResponse(w, r, nil, "Revocation data reloaded", http.StatusOK)
+}
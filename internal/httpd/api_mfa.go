@@ -15,12 +15,15 @@
 package httpd
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/render"
+	"github.com/go-webauthn/webauthn/protocol"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
@@ -30,6 +33,8 @@ import (
 
 var (
 	errRecoveryCodeForbidden = errors.New("recovery codes are not available with two-factor authentication disabled")
+	errWebAuthnKeyRequired   = errors.New("a security key is required for this account")
+	errWebAuthnOnlyWebUI     = errors.New("security keys can only be used in the web UIs, enable TOTP to use the REST API")
 )
 
 type generateTOTPRequest struct {
@@ -49,6 +54,11 @@ type validateTOTPRequest struct {
 	Secret     string `json:"secret"`
 }
 
+type webAuthnRegistrationRequest struct {
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
 type recoveryCode struct {
 	Code string `json:"code"`
 	Used bool   `json:"used"`
@@ -157,7 +167,7 @@ func getRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !user.Filters.TOTPConfig.Enabled {
+		if !user.Filters.TOTPConfig.Enabled && len(user.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -168,7 +178,7 @@ func getRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !admin.Filters.TOTPConfig.Enabled {
+		if !admin.Filters.TOTPConfig.Enabled && len(admin.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -208,7 +218,7 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !user.Filters.TOTPConfig.Enabled {
+		if !user.Filters.TOTPConfig.Enabled && len(user.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -223,7 +233,7 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !admin.Filters.TOTPConfig.Enabled {
+		if !admin.Filters.TOTPConfig.Enabled && len(admin.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -237,6 +247,211 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, recoveryCodes)
 }
 
+func beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	isAdmin := !claims.hasUserAudience()
+	var credentials []dataprovider.WebAuthnCredential
+	if isAdmin {
+		admin, err := dataprovider.AdminExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credentials = admin.Filters.WebAuthnCredentials
+	} else {
+		user, err := dataprovider.UserExists(claims.Username, "")
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credentials = user.Filters.WebAuthnCredentials
+	}
+	creation, err := startWebAuthnRegistration(claims.Username, isAdmin, credentials)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to start the security key registration", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, creation)
+}
+
+func finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req webAuthnRegistrationRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if claims.hasUserAudience() {
+		user, err := dataprovider.UserExists(claims.Username, "")
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credential, err := completeWebAuthnRegistration(user.Username, false, user.Filters.WebAuthnCredentials, &req)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		user.Filters.WebAuthnCredentials = append(user.Filters.WebAuthnCredentials, credential)
+		if user.CountUnusedRecoveryCodes() < 5 {
+			user.Filters.RecoveryCodes = generateAccountRecoveryCodes()
+		}
+		if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr, user.Role); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	} else {
+		admin, err := dataprovider.AdminExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credential, err := completeWebAuthnRegistration(admin.Username, true, admin.Filters.WebAuthnCredentials, &req)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		admin.Filters.WebAuthnCredentials = append(admin.Filters.WebAuthnCredentials, credential)
+		if admin.CountUnusedRecoveryCodes() < 5 {
+			admin.Filters.RecoveryCodes = generateAccountRecoveryCodes()
+		}
+		if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr, admin.Role); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	sendAPIResponse(w, r, nil, "Security key registered", http.StatusOK)
+}
+
+func deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id, err := base64.RawURLEncoding.DecodeString(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid security key ID", http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if claims.hasUserAudience() {
+		user, userMerged, err := dataprovider.GetUserVariants(claims.Username, "")
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credentials, err := removeWebAuthnCredential(user.Filters.WebAuthnCredentials, id, userMerged.MustUseWebAuthn())
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		user.Filters.WebAuthnCredentials = credentials
+		if len(credentials) == 0 && !user.Filters.TOTPConfig.Enabled {
+			user.Filters.RecoveryCodes = nil
+		}
+		if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr, user.Role); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	} else {
+		admin, err := dataprovider.AdminExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		credentials, err := removeWebAuthnCredential(admin.Filters.WebAuthnCredentials, id, admin.MustUseWebAuthn())
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		admin.Filters.WebAuthnCredentials = credentials
+		if len(credentials) == 0 && !admin.Filters.TOTPConfig.Enabled {
+			admin.Filters.RecoveryCodes = nil
+		}
+		if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr, admin.Role); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	sendAPIResponse(w, r, nil, "Security key deleted", http.StatusOK)
+}
+
+func startWebAuthnRegistration(username string, isAdmin bool, credentials []dataprovider.WebAuthnCredential,
+) (*protocol.CredentialCreation, error) {
+	creation, session, err := mfa.BeginWebAuthnRegistration(getWebAuthnUser(username, isAdmin, credentials))
+	if err != nil {
+		return nil, err
+	}
+	err = webAuthnSessionsMgr.Add(newWebAuthnSession(
+		getWebAuthnSessionKey(webAuthnCeremonyRegistration, username, isAdmin), session))
+	return creation, err
+}
+
+func completeWebAuthnRegistration(username string, isAdmin bool, credentials []dataprovider.WebAuthnCredential,
+	req *webAuthnRegistrationRequest,
+) (dataprovider.WebAuthnCredential, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return dataprovider.WebAuthnCredential{}, util.NewValidationError("a name for the security key is required")
+	}
+	for _, c := range credentials {
+		if c.Name == req.Name {
+			return dataprovider.WebAuthnCredential{}, util.NewValidationError(
+				fmt.Sprintf("a security key named %q already exists", req.Name))
+		}
+	}
+	key := getWebAuthnSessionKey(webAuthnCeremonyRegistration, username, isAdmin)
+	session, err := webAuthnSessionsMgr.Get(key)
+	if err != nil {
+		return dataprovider.WebAuthnCredential{}, util.NewValidationError("no pending security key registration")
+	}
+	webAuthnSessionsMgr.Delete(key) //nolint:errcheck
+	credential, err := mfa.FinishWebAuthnRegistration(getWebAuthnUser(username, isAdmin, credentials),
+		session.Data, req.Credential)
+	if err != nil {
+		return dataprovider.WebAuthnCredential{}, util.NewValidationError(err.Error())
+	}
+	return newWebAuthnCredential(credential, req.Name), nil
+}
+
+func removeWebAuthnCredential(credentials []dataprovider.WebAuthnCredential, id []byte, isRequired bool,
+) ([]dataprovider.WebAuthnCredential, error) {
+	result := make([]dataprovider.WebAuthnCredential, 0, len(credentials))
+	for _, c := range credentials {
+		if string(c.ID) != string(id) {
+			result = append(result, c)
+		}
+	}
+	if len(result) == len(credentials) {
+		return nil, util.NewRecordNotFoundError("security key not found")
+	}
+	if len(result) == 0 && isRequired {
+		return nil, util.NewValidationError("at least a security key is required for this account")
+	}
+	return result, nil
+}
+
+func generateAccountRecoveryCodes() []dataprovider.RecoveryCode {
+	recoveryCodes := make([]dataprovider.RecoveryCode, 0, 12)
+	for i := 0; i < 12; i++ {
+		recoveryCodes = append(recoveryCodes, dataprovider.RecoveryCode{Secret: kms.NewPlainSecret(getNewRecoveryCode())})
+	}
+	return recoveryCodes
+}
+
 func getNewRecoveryCode() string {
 	return fmt.Sprintf("RC-%v", strings.ToUpper(util.GenerateUniqueID()))
 }
@@ -268,7 +483,7 @@ func saveUserTOTPConfig(username string, r *http.Request, recoveryCodes []datapr
 		if user.CountUnusedRecoveryCodes() < 5 && user.Filters.TOTPConfig.Enabled {
 			user.Filters.RecoveryCodes = recoveryCodes
 		}
-	} else {
+	} else if len(user.Filters.WebAuthnCredentials) == 0 {
 		user.Filters.RecoveryCodes = nil
 	}
 	return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr), user.Role)
@@ -289,7 +504,7 @@ func saveAdminTOTPConfig(username string, r *http.Request, recoveryCodes []datap
 		if admin.CountUnusedRecoveryCodes() < 5 && admin.Filters.TOTPConfig.Enabled {
 			admin.Filters.RecoveryCodes = recoveryCodes
 		}
-	} else {
+	} else if len(admin.Filters.WebAuthnCredentials) == 0 {
 		admin.Filters.RecoveryCodes = nil
 	}
 	if admin.Filters.TOTPConfig.Secret == nil || !admin.Filters.TOTPConfig.Secret.IsPlain() {
@@ -89,6 +89,19 @@ func startFolderQuotaScan(w http.ResponseWriter, r *http.Request) {
 	doStartFolderQuotaScan(w, r, getURLParam(r, "name"))
 }
 
+func startQuotaReconciliation(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if dataprovider.GetQuotaTracking() == 0 {
+		sendAPIResponse(w, r, nil, "Quota tracking is disabled!", http.StatusForbidden)
+		return
+	}
+	if !common.StartQuotaReconciliation() {
+		sendAPIResponse(w, r, nil, "A quota reconciliation is already in progress", http.StatusConflict)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Reconciliation started", http.StatusAccepted)
+}
+
 func updateUserTransferQuotaUsage(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
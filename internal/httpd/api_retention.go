@@ -55,6 +55,7 @@ func startRetentionCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	check.DryRun = getBoolQueryParam(r, "dry_run")
 	check.Notifications = getCommaSeparatedQueryParam(r, "notifications")
 	for _, notification := range check.Notifications {
 		if notification == common.RetentionCheckNotificationEmail {
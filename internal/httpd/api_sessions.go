@@ -0,0 +1,224 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type tokensRevocationRequest struct {
+	// unix timestamp in milliseconds, 0 means now
+	IssuedBefore int64 `json:"issued_before"`
+}
+
+func setCurrentWebSession(sessions []common.WebSession, currentID string) {
+	for idx := range sessions {
+		sessions[idx].Current = currentID != "" && sessions[idx].ID == currentID
+	}
+}
+
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var isAdmin bool
+	switch r.URL.Query().Get("type") {
+	case "", "user":
+	case "admin":
+		if !claims.hasPerm(dataprovider.PermAdminManageAdmins) || claims.Role != "" {
+			sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		isAdmin = true
+	default:
+		sendAPIResponse(w, r, nil, "Invalid session type", http.StatusBadRequest)
+		return
+	}
+	sessions, err := getWebSessions(r.URL.Query().Get("username"), claims.Role, isAdmin)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	setCurrentWebSession(sessions, claims.SessionID)
+	render.JSON(w, r, sessions)
+}
+
+func revokeSession(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	session, err := common.WebSessions.Get(getURLParam(r, "id"))
+	if err != nil || session.Revoked {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if claims.Role != "" && (session.IsAdmin || session.Role != claims.Role) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if session.IsAdmin && !claims.hasPerm(dataprovider.PermAdminManageAdmins) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := doRevokeWebSession(r, session, claims.Username, claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Session revoked", http.StatusOK)
+}
+
+func revokeTokens(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req tokensRevocationRequest
+	if r.ContentLength != 0 {
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	issuedBefore := time.Now()
+	if req.IssuedBefore > 0 {
+		issuedBefore = util.GetTimeFromMsecSinceEpoch(req.IssuedBefore)
+		if issuedBefore.After(time.Now()) {
+			sendAPIResponse(w, r, errors.New("the revocation time cannot be in the future"), "",
+				http.StatusBadRequest)
+			return
+		}
+	}
+	if err := common.WebSessions.SetRevokedBefore(issuedBefore); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	dataprovider.AddAuditLogEntry(&dataprovider.AuditLogEntry{
+		Action:   dataprovider.AuditActionTokensRevocation,
+		Username: claims.Username,
+		Role:     claims.Role,
+		IP:       util.GetIPFromRemoteAddress(r.RemoteAddr),
+		Protocol: common.ProtocolHTTP,
+		Info:     fmt.Sprintf("tokens issued before %s revoked", issuedBefore.UTC().Format(time.RFC3339)),
+	})
+	sendAPIResponse(w, r, nil, "Tokens revoked", http.StatusOK)
+}
+
+func getAdminSessions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	sessions, err := getWebSessions(claims.Username, "", true)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	setCurrentWebSession(sessions, claims.SessionID)
+	render.JSON(w, r, sessions)
+}
+
+func revokeAdminSession(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	session, err := common.WebSessions.Get(getURLParam(r, "id"))
+	if err != nil || session.Revoked || !session.IsAdmin || session.Username != claims.Username {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := doRevokeWebSession(r, session, claims.Username, claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Session revoked", http.StatusOK)
+}
+
+func getUserSessions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	sessions, err := getWebSessions(claims.Username, "", false)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	setCurrentWebSession(sessions, claims.SessionID)
+	render.JSON(w, r, sessions)
+}
+
+func revokeUserSession(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	session, err := common.WebSessions.Get(getURLParam(r, "id"))
+	if err != nil || session.Revoked || session.IsAdmin || session.Username != claims.Username {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := doRevokeWebSession(r, session, claims.Username, claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Session revoked", http.StatusOK)
+}
+
+func doRevokeWebSession(r *http.Request, session *common.WebSession, executor, role string) error {
+	if err := common.RevokeWebSession(session.ID); err != nil {
+		return err
+	}
+	objectType := "user"
+	if session.IsAdmin {
+		objectType = "admin"
+	}
+	dataprovider.AddAuditLogEntry(&dataprovider.AuditLogEntry{
+		Action:     dataprovider.AuditActionSessionRevoke,
+		ObjectType: objectType,
+		ObjectName: session.Username,
+		Username:   executor,
+		Role:       role,
+		IP:         util.GetIPFromRemoteAddress(r.RemoteAddr),
+		Protocol:   common.ProtocolHTTP,
+		Info:       fmt.Sprintf("session %q, audience %q, IP %q", session.ID, session.Audience, session.IP),
+	})
+	return nil
+}
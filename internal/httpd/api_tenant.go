@@ -0,0 +1,237 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// tenantObjectUser identifies users, they belong to a tenant through their role
+const tenantObjectUser = "user"
+
+func getTenants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+
+	tenants, err := dataprovider.GetTenants(limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, tenants)
+}
+
+func addTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var tenant dataprovider.Tenant
+	err = render.DecodeJSON(r.Body, &tenant)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddTenant(&tenant, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		w.Header().Add("Location", fmt.Sprintf("%s/%s", tenantsPath, url.PathEscape(tenant.Name)))
+		renderTenant(w, r, tenant.Name, http.StatusCreated)
+	}
+}
+
+func updateTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	name := getURLParam(r, "name")
+	tenant, err := dataprovider.TenantExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	var updatedTenant dataprovider.Tenant
+	err = render.DecodeJSON(r.Body, &updatedTenant)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+
+	updatedTenant.ID = tenant.ID
+	updatedTenant.Name = tenant.Name
+	err = dataprovider.UpdateTenant(&updatedTenant, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Tenant updated", http.StatusOK)
+}
+
+func renderTenant(w http.ResponseWriter, r *http.Request, name string, status int) {
+	tenant, err := dataprovider.TenantExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if status != http.StatusOK {
+		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+		render.JSON(w, r.WithContext(ctx), tenant)
+	} else {
+		render.JSON(w, r, tenant)
+	}
+}
+
+func getTenantByName(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	name := getURLParam(r, "name")
+	renderTenant(w, r, name, http.StatusOK)
+}
+
+func deleteTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	err = dataprovider.DeleteTenant(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "Tenant deleted", http.StatusOK)
+}
+
+// getAdminTenant returns the tenant for the admin identified by the specified claims.
+// The boolean result is false for admins not restricted to a tenant
+func getAdminTenant(claims *jwtTokenClaims) (dataprovider.Tenant, bool, error) {
+	if claims.Tenant == "" {
+		return dataprovider.Tenant{}, false, nil
+	}
+	tenant, err := dataprovider.TenantExists(claims.Tenant)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return tenant, true, util.NewGenericError(fmt.Sprintf("the tenant %q no longer exists", claims.Tenant))
+		}
+		return tenant, true, err
+	}
+	return tenant, true, nil
+}
+
+// getRequestTenant returns the tenant for the admin that issued the specified request
+func getRequestTenant(r *http.Request) (dataprovider.Tenant, bool, error) {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return dataprovider.Tenant{}, false, err
+	}
+	return getAdminTenant(&claims)
+}
+
+// isTenantObject returns true if the object with the specified type and name
+// belongs to the given tenant
+func isTenantObject(tenant *dataprovider.Tenant, objectType, name string) (bool, error) {
+	if objectType != tenantObjectUser {
+		return tenant.HasMember(objectType, name), nil
+	}
+	user, err := dataprovider.UserExists(name, "")
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return tenant.HasRole(user.Role), nil
+}
+
+// checkTenantUser returns an error if a tenant admin tries to assign a role, groups
+// or folders outside the tenant to a user
+func checkTenantUser(claims *jwtTokenClaims, user *dataprovider.User) error {
+	tenant, ok, err := getAdminTenant(claims)
+	if err != nil || !ok {
+		return err
+	}
+	if !tenant.HasRole(user.Role) {
+		return util.NewValidationError(fmt.Sprintf("users must have a role belonging to the tenant %q", tenant.Name))
+	}
+	for _, group := range user.Groups {
+		if !tenant.HasGroup(group.Name) {
+			return util.NewValidationError(fmt.Sprintf("group %q does not belong to the tenant %q", group.Name, tenant.Name))
+		}
+	}
+	return checkTenantFolders(&tenant, user.VirtualFolders)
+}
+
+// checkTenantGroup returns an error if a tenant admin tries to assign folders
+// outside the tenant to a group
+func checkTenantGroup(claims *jwtTokenClaims, group *dataprovider.Group) error {
+	tenant, ok, err := getAdminTenant(claims)
+	if err != nil || !ok {
+		return err
+	}
+	return checkTenantFolders(&tenant, group.VirtualFolders)
+}
+
+func checkTenantFolders(tenant *dataprovider.Tenant, folders []vfs.VirtualFolder) error {
+	for _, folder := range folders {
+		if !tenant.HasFolder(folder.Name) {
+			return util.NewValidationError(fmt.Sprintf("folder %q does not belong to the tenant %q", folder.Name, tenant.Name))
+		}
+	}
+	return nil
+}
+
+// checkTenantNewObject returns an error if a tenant admin tries to create an object
+// whose name is already assigned to another tenant
+func checkTenantNewObject(claims *jwtTokenClaims, objectType, name string) error {
+	if claims.Tenant == "" {
+		return nil
+	}
+	if tenant, ok := dataprovider.GetTenantForObject(objectType, name); ok && tenant.Name != claims.Tenant {
+		return util.NewValidationError(fmt.Sprintf("%s %q already belongs to another tenant", objectType, name))
+	}
+	return nil
+}
+
+// addObjectToAdminTenant adds a newly created object to the tenant of the admin, if any
+func addObjectToAdminTenant(claims *jwtTokenClaims, objectType, name, ipAddress string) error {
+	if claims.Tenant == "" {
+		return nil
+	}
+	return dataprovider.AddToTenant(claims.Tenant, objectType, name, claims.Username, ipAddress)
+}
@@ -46,14 +46,35 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role)
+	users, err := getAdminUsers(&claims, limit, offset, order)
 	if err == nil {
+		if isFsConfigHidden(r) {
+			for idx := range users {
+				users[idx].HideFsConfigs()
+			}
+		}
 		render.JSON(w, r, users)
 	} else {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 	}
 }
 
+// getAdminUsers returns the users visible to the admin identified by the given claims
+func getAdminUsers(claims *jwtTokenClaims, limit, offset int, order string) ([]dataprovider.User, error) {
+	if !claims.hasPerm(dataprovider.PermAdminViewUsers) {
+		groups := claims.getAdmin().GetGrantedGroups(dataprovider.PermAdminViewUsers)
+		return dataprovider.GetGroupsUsers(groups, limit, offset, order, claims.Role)
+	}
+	tenant, ok, err := getAdminTenant(claims)
+	if err != nil {
+		return nil, err
+	}
+	if ok && claims.Role == "" {
+		return dataprovider.GetTenantUsers(&tenant, limit, offset, order)
+	}
+	return dataprovider.GetUsers(limit, offset, order, claims.Role)
+}
+
 func getUserByUsername(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -72,6 +93,9 @@ func renderUser(w http.ResponseWriter, r *http.Request, username, role string, s
 		return
 	}
 	user.PrepareForRendering()
+	if isFsConfigHidden(r) {
+		user.HideFsConfigs()
+	}
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), user)
@@ -102,6 +126,12 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if user.Filters.Template.Name != "" {
+		if err := dataprovider.ApplyTemplateToUser(&user); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
 	if claims.Role != "" {
 		user.Role = claims.Role
 	}
@@ -110,6 +140,17 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
+	user.Filters.WebAuthnCredentials = nil
+	if admin.Filters.HideFsConfig {
+		if err := restoreFoldersFsConfig(user.VirtualFolders); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	if err := checkTenantUser(&claims, &user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.AddUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -136,6 +177,7 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
+	user.Filters.WebAuthnCredentials = nil
 	if err := dataprovider.UpdateUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -143,6 +185,37 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "2FA disabled", http.StatusOK)
 }
 
+func setUserPassword(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req userPwdSet
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		sendAPIResponse(w, r, nil, "Please set a password", http.StatusBadRequest)
+		return
+	}
+	username := getURLParam(r, "username")
+	user, err := dataprovider.UserExists(username, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	user.Password = req.Password
+	user.Filters.RequirePasswordChange = req.RequirePasswordChange
+	if err := dataprovider.UpdateUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Password updated", http.StatusOK)
+}
+
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -178,15 +251,31 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
 		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.SFTPConfig.KeyPassphrase,
-		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey)
+		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey, user.FsConfig.HTTPConfig.OAuth2.ClientSecret)
+	updateCredentialsRotationTime(&updatedUser.FsConfig, &user.FsConfig)
+	updateS3AccessKeysSecrets(&updatedUser, &user)
+	if isFsConfigHidden(r) {
+		updatedUser.HomeDir = user.HomeDir
+		updatedUser.FsConfig = user.FsConfig
+		if err := restoreFoldersFsConfig(updatedUser.VirtualFolders); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
 	if claims.Role != "" {
 		updatedUser.Role = claims.Role
 	}
+	restoreGrantedUserFields(&claims, &updatedUser, &user)
+	if err := checkTenantUser(&claims, &updatedUser); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.UpdateUser(&updatedUser, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -269,9 +358,23 @@ func disconnectUser(username, admin, role string) {
 	}
 }
 
+// updateS3AccessKeysSecrets keeps the existing secret for the access keys
+// submitted without a new plain text secret
+func updateS3AccessKeysSecrets(updatedUser, user *dataprovider.User) {
+	for idx := range updatedUser.Filters.S3AccessKeys {
+		key := &updatedUser.Filters.S3AccessKeys[idx]
+		if !key.SecretAccessKey.IsNotPlainAndNotEmpty() {
+			continue
+		}
+		if current, ok := user.GetS3AccessKey(key.AccessKeyID); ok {
+			key.SecretAccessKey = current.SecretAccessKey
+		}
+	}
+}
+
 func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
 	currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-	currentHTTPPassword, currentHTTPAPIKey *kms.Secret) {
+	currentHTTPPassword, currentHTTPAPIKey, currentHTTPOAuth2Secret *kms.Secret) {
 	// we use the new access secret if plain or empty, otherwise the old value
 	switch fsConfig.Provider {
 	case sdk.S3FilesystemProvider:
@@ -298,7 +401,19 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, cur
 	case sdk.SFTPFilesystemProvider:
 		updateSFTPFsEncryptedSecrets(fsConfig, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase)
 	case sdk.HTTPFilesystemProvider:
-		updateHTTPFsEncryptedSecrets(fsConfig, currentHTTPPassword, currentHTTPAPIKey)
+		updateHTTPFsEncryptedSecrets(fsConfig, currentHTTPPassword, currentHTTPAPIKey, currentHTTPOAuth2Secret)
+	}
+}
+
+// updateCredentialsRotationTime preserves the last credentials rotation time,
+// it is not sent by the WebAdmin and could be omitted by API clients
+func updateCredentialsRotationTime(fsConfig, currentFsConfig *vfs.Filesystem) {
+	if fsConfig.Provider != currentFsConfig.Provider {
+		return
+	}
+	rotation := fsConfig.GetCredentialsRotation()
+	if rotation != nil && rotation.RotatedAt == 0 {
+		rotation.RotatedAt = currentFsConfig.GetCredentialsRotation().RotatedAt
 	}
 }
 
@@ -316,11 +431,16 @@ func updateSFTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentSFTPPassword,
 	}
 }
 
-func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentHTTPPassword, currentHTTPAPIKey *kms.Secret) {
+func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentHTTPPassword, currentHTTPAPIKey,
+	currentHTTPOAuth2Secret *kms.Secret,
+) {
 	if fsConfig.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 		fsConfig.HTTPConfig.Password = currentHTTPPassword
 	}
 	if fsConfig.HTTPConfig.APIKey.IsNotPlainAndNotEmpty() {
 		fsConfig.HTTPConfig.APIKey = currentHTTPAPIKey
 	}
+	if fsConfig.HTTPConfig.OAuth2.ClientSecret.IsNotPlainAndNotEmpty() {
+		fsConfig.HTTPConfig.OAuth2.ClientSecret = currentHTTPOAuth2Secret
+	}
 }
@@ -22,6 +22,7 @@ import (
 	"io"
 	"io/fs"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -43,6 +44,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 type pwdChange struct {
@@ -55,6 +57,11 @@ type pwdReset struct {
 	Password string `json:"password"`
 }
 
+type userPwdSet struct {
+	Password              string `json:"password"`
+	RequirePasswordChange bool   `json:"require_password_change"`
+}
+
 type baseProfile struct {
 	Email           string `json:"email,omitempty"`
 	Description     string `json:"description,omitempty"`
@@ -70,6 +77,15 @@ type userProfile struct {
 	PublicKeys []string `json:"public_keys,omitempty"`
 }
 
+type sshCertificateRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
+type sshCertificateResponse struct {
+	Certificate string `json:"certificate"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
 func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message string, code int) {
 	var errorString string
 	if errors.Is(err, util.ErrNotFound) {
@@ -164,11 +180,55 @@ func getActiveConnections(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
+	stats, err := getConnectionsStats(&claims)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, stats)
+}
+
+func getActiveTransfers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	stats, err := getConnectionsStats(&claims)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, getTransfersProgress(stats))
+}
+
+// getConnectionsStats returns the active connections visible to the admin identified
+// by the specified claims. Tenant admins only see the local connections of their tenant users
+func getConnectionsStats(claims *jwtTokenClaims) ([]common.ConnectionStatus, error) {
+	tenant, ok, err := getAdminTenant(claims)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if claims.Role != "" {
+			return common.Connections.GetStats(claims.Role), nil
+		}
+		return common.Connections.GetStatsForRoles(tenant.Members.Roles), nil
+	}
 	stats := common.Connections.GetStats(claims.Role)
 	if claims.NodeID == "" {
 		stats = append(stats, getNodesConnections(claims.Username, claims.Role)...)
 	}
-	render.JSON(w, r, stats)
+	return stats, nil
+}
+
+func getTransfersProgress(stats []common.ConnectionStatus) []common.TransferProgress {
+	transfers := make([]common.TransferProgress, 0, len(stats))
+	for idx := range stats {
+		transfers = append(transfers, stats[idx].GetTransfersProgress()...)
+	}
+	return transfers
 }
 
 func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
@@ -184,6 +244,23 @@ func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	node := r.URL.Query().Get("node")
+	if claims.Tenant != "" {
+		tenant, _, err := getAdminTenant(&claims)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		roles := tenant.Members.Roles
+		if claims.Role != "" {
+			roles = []string{claims.Role}
+		}
+		if (node == "" || node == dataprovider.GetNodeName()) && common.Connections.CloseForRoles(connectionID, roles) {
+			sendAPIResponse(w, r, nil, "Connection closed", http.StatusOK)
+		} else {
+			sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+		}
+		return
+	}
 	if node == "" || node == dataprovider.GetNodeName() {
 		if common.Connections.Close(connectionID, claims.Role) {
 			sendAPIResponse(w, r, nil, "Connection closed", http.StatusOK)
@@ -437,6 +514,10 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	}
 	defer reader.Close()
 
+	reader.SetExpectedSize(size)
+	if share == nil && r.Method == http.MethodGet {
+		reader.SetResumableDownload(offset, info.Size(), info.ModTime())
+	}
 	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
 	if checkPreconditions(w, r, info.ModTime()) {
 		return 0, fmt.Errorf("%v", http.StatusText(http.StatusPreconditionFailed))
@@ -462,6 +543,7 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 				dataprovider.UpdateShareLastUse(share, -1) //nolint:errcheck
 			}
 			connection.Log(logger.LevelDebug, "error reading file to download: %v", err)
+			reader.TransferError(err)
 			panic(http.ErrAbortHandler)
 		}
 	}
@@ -619,14 +701,46 @@ func updateLoginMetrics(user *dataprovider.User, loginMethod, ip string, err err
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, protocol, err)
 }
 
+// getRequestHost returns the lower cased host requested by the client, without the port.
+// The server name requested using TLS SNI is used if the Host header is empty
+func getRequestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" && r.TLS != nil {
+		host = r.TLS.ServerName
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// isRoleAllowedForRequest returns true if users with the specified role can
+// login using the virtual host the request is addressed to
+func isRoleAllowedForRequest(r *http.Request, role string) bool {
+	allowedRoles, ok := r.Context().Value(allowedRolesKey).([]string)
+	if !ok || len(allowedRoles) == 0 {
+		return true
+	}
+	return util.Contains(allowedRoles, role)
+}
+
 func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID string, checkSessions bool) error {
 	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolHTTP) {
 		logger.Info(logSender, connectionID, "cannot login user %q, protocol HTTP is not allowed", user.Username)
 		return fmt.Errorf("protocol HTTP is not allowed for user %q", user.Username)
 	}
-	if !isLoggedInWithOIDC(r) && !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, common.ProtocolHTTP, nil) {
-		logger.Info(logSender, connectionID, "cannot login user %q, password login method is not allowed", user.Username)
-		return fmt.Errorf("login method password is not allowed for user %q", user.Username)
+	if !isRoleAllowedForRequest(r, user.Role) {
+		logger.Info(logSender, connectionID, "cannot login user %q, role %q is not allowed for host %q",
+			user.Username, user.Role, r.Host)
+		return fmt.Errorf("login for user %q is not allowed on this host", user.Username)
+	}
+	if !isLoggedInWithOIDC(r) {
+		loginMethod := getHTTPClientLoginMethod(r)
+		if !user.IsLoginMethodAllowed(loginMethod, common.ProtocolHTTP, nil) {
+			logger.Info(logSender, connectionID, "cannot login user %q, %s login method is not allowed",
+				user.Username, loginMethod)
+			return fmt.Errorf("login method %s is not allowed for user %q", loginMethod, user.Username)
+		}
 	}
 	if checkSessions && user.MaxSessions > 0 {
 		activeSessions := common.Connections.GetActiveSessions(user.Username)
@@ -637,6 +751,7 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 		}
 	}
 	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		common.RequestIPApproval(user, r.RemoteAddr, common.ProtocolHTTP)
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v", user.Username, r.RemoteAddr)
 		return fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, r.RemoteAddr)
 	}
@@ -771,3 +886,53 @@ func getProtocolFromRequest(r *http.Request) string {
 	}
 	return common.ProtocolHTTP
 }
+
+// isFsConfigHidden returns true if the admin that issued the request cannot
+// view or change the filesystem configurations
+func isFsConfigHidden(r *http.Request) bool {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		return true
+	}
+	return claims.HideFsConfig
+}
+
+// restoreGrantedUserFields restores the stored configuration of a user updated
+// by an admin allowed by a group grant. Only the account details, the status
+// and the credentials can be changed using a grant, everything else, for
+// example groups, role, home directory, permissions, filesystem and filters,
+// is preserved so the user cannot escape the granted groups
+func restoreGrantedUserFields(claims *jwtTokenClaims, updatedUser, user *dataprovider.User) {
+	if claims.hasPerm(dataprovider.PermAdminChangeUsers) {
+		return
+	}
+	changed := *updatedUser
+	*updatedUser = *user
+	updatedUser.Status = changed.Status
+	updatedUser.ExpirationDate = changed.ExpirationDate
+	updatedUser.Password = changed.Password
+	updatedUser.PublicKeys = changed.PublicKeys
+	updatedUser.Email = changed.Email
+	updatedUser.Description = changed.Description
+	updatedUser.AdditionalInfo = changed.AdditionalInfo
+	updatedUser.Filters.RequirePasswordChange = changed.Filters.RequirePasswordChange
+}
+
+// restoreFoldersFsConfig replaces the filesystem configuration of the given
+// virtual folders with the stored one, so they cannot be changed while
+// updating users or groups. Folders not yet defined are left unchanged
+func restoreFoldersFsConfig(folders []vfs.VirtualFolder) error {
+	for idx := range folders {
+		folder := &folders[idx]
+		stored, err := dataprovider.GetFolderByName(folder.Name)
+		if err != nil {
+			if errors.Is(err, util.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		folder.MappedPath = stored.MappedPath
+		folder.FsConfig = stored.FsConfig
+	}
+	return nil
+}
@@ -18,12 +18,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/rs/xid"
 
+	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -40,6 +42,7 @@ const (
 	tokenAudienceAPI              tokenAudience = "API"
 	tokenAudienceAPIUser          tokenAudience = "APIUser"
 	tokenAudienceCSRF             tokenAudience = "CSRF"
+	tokenAudienceRememberedDevice tokenAudience = "RememberedDevice"
 )
 
 type tokenValidation = int
@@ -53,12 +56,17 @@ const (
 	claimUsernameKey                = "username"
 	claimPermissionsKey             = "permissions"
 	claimRole                       = "role"
+	claimTenant                     = "tenant"
 	claimAPIKey                     = "api_key"
 	claimNodeID                     = "node_id"
 	claimMustChangePasswordKey      = "chpwd"
 	claimMustSetSecondFactorKey     = "2fa_required"
 	claimRequiredTwoFactorProtocols = "2fa_protos"
 	claimHideUserPageSection        = "hus"
+	claimHideFsConfig               = "hfs"
+	claimGrants                     = "grants"
+	claimLoginMethod                = "lm"
+	claimSessionID                  = "sid"
 	basicRealm                      = "Basic realm=\"SFTPGo\""
 	jwtCookieKey                    = "jwt"
 )
@@ -77,6 +85,7 @@ type jwtTokenClaims struct {
 	Username                   string
 	Permissions                []string
 	Role                       string
+	Tenant                     string
 	Signature                  string
 	Audience                   []string
 	APIKeyID                   string
@@ -85,6 +94,50 @@ type jwtTokenClaims struct {
 	MustChangePassword         bool
 	RequiredTwoFactorProtocols []string
 	HideUserPageSections       int
+	HideFsConfig               bool
+	Grants                     map[string][]string
+	LoginMethod                string
+	SessionID                  string
+}
+
+// setAdminRestrictions sets the admin restrictions not covered by the global permissions
+func (c *jwtTokenClaims) setAdminRestrictions(admin *dataprovider.Admin) {
+	c.HideFsConfig = admin.Filters.HideFsConfig
+	c.Grants = nil
+	for _, grant := range admin.Filters.Grants {
+		if c.Grants == nil {
+			c.Grants = make(map[string][]string)
+		}
+		c.Grants[grant.Group] = grant.Permissions
+	}
+}
+
+// getAdmin returns an admin with the fields stored in the claims
+func (c *jwtTokenClaims) getAdmin() *dataprovider.Admin {
+	admin := &dataprovider.Admin{}
+	admin.Username = c.Username
+	admin.Permissions = c.Permissions
+	admin.Filters.Preferences.HideUserPageSections = c.HideUserPageSections
+	admin.Role = c.Role
+	admin.Filters.Tenant = c.Tenant
+	admin.Filters.HideFsConfig = c.HideFsConfig
+	admin.Filters.Grants = c.getGrants()
+	return admin
+}
+
+// getGrants returns the permission grants as defined in the admin filters
+func (c *jwtTokenClaims) getGrants() []dataprovider.AdminPermissionGrant {
+	grants := make([]dataprovider.AdminPermissionGrant, 0, len(c.Grants))
+	for group, permissions := range c.Grants {
+		grants = append(grants, dataprovider.AdminPermissionGrant{
+			Group:       group,
+			Permissions: permissions,
+		})
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].Group < grants[j].Group
+	})
+	return grants
 }
 
 func (c *jwtTokenClaims) hasUserAudience() bool {
@@ -105,6 +158,9 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.Role != "" {
 		claims[claimRole] = c.Role
 	}
+	if c.Tenant != "" {
+		claims[claimTenant] = c.Tenant
+	}
 	if c.APIKeyID != "" {
 		claims[claimAPIKey] = c.APIKeyID
 	}
@@ -124,6 +180,18 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.HideUserPageSections > 0 {
 		claims[claimHideUserPageSection] = c.HideUserPageSections
 	}
+	if c.HideFsConfig {
+		claims[claimHideFsConfig] = c.HideFsConfig
+	}
+	if len(c.Grants) > 0 {
+		claims[claimGrants] = c.Grants
+	}
+	if c.LoginMethod != "" {
+		claims[claimLoginMethod] = c.LoginMethod
+	}
+	if c.SessionID != "" {
+		claims[claimSessionID] = c.SessionID
+	}
 
 	return claims
 }
@@ -187,6 +255,10 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 		c.Role = c.decodeString(val)
 	}
 
+	if val, ok := token[claimTenant]; ok {
+		c.Tenant = c.decodeString(val)
+	}
+
 	permissions := token[claimPermissionsKey]
 	c.Permissions = c.decodeSliceString(permissions)
 
@@ -202,12 +274,43 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 		c.RequiredTwoFactorProtocols = c.decodeSliceString(val)
 	}
 
+	if val, ok := token[claimLoginMethod]; ok {
+		c.LoginMethod = c.decodeString(val)
+	}
+
+	if val, ok := token[claimSessionID]; ok {
+		c.SessionID = c.decodeString(val)
+	}
+
 	if val, ok := token[claimHideUserPageSection]; ok {
 		switch v := val.(type) {
 		case float64:
 			c.HideUserPageSections = int(v)
 		}
 	}
+
+	if val, ok := token[claimHideFsConfig]; ok {
+		c.HideFsConfig = c.decodeBoolean(val)
+	}
+
+	if val, ok := token[claimGrants]; ok {
+		c.Grants = c.decodeGrants(val)
+	}
+}
+
+func (c *jwtTokenClaims) decodeGrants(val any) map[string][]string {
+	switch v := val.(type) {
+	case map[string]any:
+		result := make(map[string][]string)
+		for group, permissions := range v {
+			result[group] = c.decodeSliceString(permissions)
+		}
+		return result
+	case map[string][]string:
+		return v
+	default:
+		return nil
+	}
 }
 
 func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
@@ -232,15 +335,24 @@ func (c *jwtTokenClaims) hasPerm(perm string) bool {
 }
 
 func (c *jwtTokenClaims) createToken(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, ip string) (jwt.Token, string, error) {
+	trackSession := canTrackWebSession(c, audience)
+	if trackSession && c.SessionID == "" {
+		c.SessionID = xid.New().String()
+	}
 	claims := c.asMap()
 	now := time.Now().UTC()
 
 	claims[jwt.JwtIDKey] = xid.New().String()
+	claims[jwt.IssuedAtKey] = now
 	claims[jwt.NotBeforeKey] = now.Add(-30 * time.Second)
 	claims[jwt.ExpirationKey] = now.Add(tokenDuration)
 	claims[jwt.AudienceKey] = []string{audience, ip}
 
-	return tokenAuth.Encode(claims)
+	token, tokenString, err := tokenAuth.Encode(claims)
+	if err == nil && trackSession {
+		trackWebSession(c, audience, ip, token.Expiration())
+	}
+	return token, tokenString, err
 }
 
 func (c *jwtTokenClaims) createTokenResponse(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, ip string) (map[string]any, error) {
@@ -339,6 +451,13 @@ func isTokenInvalidated(r *http.Request) bool {
 }
 
 func invalidateToken(r *http.Request) {
+	if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+		tokenClaims := jwtTokenClaims{}
+		tokenClaims.Decode(claims)
+		if tokenClaims.SessionID != "" {
+			common.WebSessions.Delete(tokenClaims.SessionID) //nolint:errcheck
+		}
+	}
 	tokenString := jwtauth.TokenFromHeader(r)
 	if tokenString != "" {
 		invalidatedJWTTokens.Store(tokenString, time.Now().Add(tokenDuration).UTC())
@@ -364,18 +483,13 @@ func getUserFromToken(r *http.Request) *dataprovider.User {
 }
 
 func getAdminFromToken(r *http.Request) *dataprovider.Admin {
-	admin := &dataprovider.Admin{}
 	_, claims, err := jwtauth.FromContext(r.Context())
 	if err != nil {
-		return admin
+		return &dataprovider.Admin{}
 	}
 	tokenClaims := jwtTokenClaims{}
 	tokenClaims.Decode(claims)
-	admin.Username = tokenClaims.Username
-	admin.Permissions = tokenClaims.Permissions
-	admin.Filters.Preferences.HideUserPageSections = tokenClaims.HideUserPageSections
-	admin.Role = tokenClaims.Role
-	return admin
+	return tokenClaims.getAdmin()
 }
 
 func createCSRFToken(ip string) string {
@@ -83,7 +83,13 @@ func (f *httpdFile) Write(p []byte) (n int, err error) {
 
 	f.Connection.UpdateLastActivity()
 
+	if err := f.CheckUploadContent(p, f.BytesReceived.Load()); err != nil {
+		f.TransferError(err)
+		return 0, err
+	}
+
 	n, err = f.writer.Write(p)
+	f.UpdateChecksums(p[:n], f.BytesReceived.Load())
 	f.BytesReceived.Add(int64(n))
 
 	if err == nil {
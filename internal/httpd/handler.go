@@ -94,7 +94,7 @@ func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 	return c.ListDir(name)
 }
 
-func (c *Connection) getFileReader(name string, offset int64, method string) (io.ReadCloser, error) {
+func (c *Connection) getFileReader(name string, offset int64, method string) (*httpdFile, error) {
 	c.UpdateLastActivity()
 
 	transferQuota := c.GetTransferQuota()
@@ -193,6 +193,11 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
 	}
+	if isNewFile {
+		if err := c.CheckDirLimits(requestPath); err != nil {
+			return nil, err
+		}
+	}
 	_, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, os.O_TRUNC)
 	if err != nil {
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
@@ -213,7 +218,7 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 				}
@@ -315,6 +320,10 @@ func (t *throttledReader) HasSizeLimit() bool {
 	return false
 }
 
+func (t *throttledReader) GetExpectedSize() int64 {
+	return 0
+}
+
 func (t *throttledReader) Truncate(fsPath string, size int64) (int64, error) {
 	return 0, vfs.ErrVfsUnsupported
 }
@@ -39,6 +39,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
@@ -53,6 +54,7 @@ const (
 	userTokenPath                         = "/api/v2/user/token"
 	userLogoutPath                        = "/api/v2/user/logout"
 	activeConnectionsPath                 = "/api/v2/connections"
+	activeTransfersPath                   = "/api/v2/transfers"
 	quotasBasePath                        = "/api/v2/quotas"
 	userPath                              = "/api/v2/users"
 	versionPath                           = "/api/v2/version"
@@ -65,6 +67,7 @@ const (
 	adminPath                             = "/api/v2/admins"
 	adminPwdPath                          = "/api/v2/admin/changepwd"
 	adminProfilePath                      = "/api/v2/admin/profile"
+	adminSessionsPath                     = "/api/v2/admin/sessions"
 	userPwdPath                           = "/api/v2/user/changepwd"
 	userDirsPath                          = "/api/v2/user/dirs"
 	userFilesPath                         = "/api/v2/user/files"
@@ -72,6 +75,9 @@ const (
 	userStreamZipPath                     = "/api/v2/user/streamzip"
 	userUploadFilePath                    = "/api/v2/user/files/upload"
 	userFilesDirsMetadataPath             = "/api/v2/user/files/metadata"
+	userTrashPath                         = "/api/v2/user/trash"
+	userPartialDownloadsPath              = "/api/v2/user/partial-downloads"
+	userTusPath                           = "/api/v2/user/tus"
 	apiKeysPath                           = "/api/v2/apikeys"
 	adminTOTPConfigsPath                  = "/api/v2/admin/totp/configs"
 	adminTOTPGeneratePath                 = "/api/v2/admin/totp/generate"
@@ -84,18 +90,33 @@ const (
 	userTOTPSavePath                      = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath              = "/api/v2/user/2fa/recoverycodes"
 	userProfilePath                       = "/api/v2/user/profile"
+	userSessionsPath                      = "/api/v2/user/sessions"
+	userSSHCertificatePath                = "/api/v2/user/sshcert"
 	userSharesPath                        = "/api/v2/user/shares"
 	retentionBasePath                     = "/api/v2/retention/users"
 	retentionChecksPath                   = "/api/v2/retention/users/checks"
 	metadataBasePath                      = "/api/v2/metadata/users"
 	metadataChecksPath                    = "/api/v2/metadata/users/checks"
+	replicationStatusPath                 = "/api/v2/replication/status"
+	deadLettersPath                       = "/api/v2/deadletters"
 	fsEventsPath                          = "/api/v2/events/fs"
 	providerEventsPath                    = "/api/v2/events/provider"
+	auditLogsPath                         = "/api/v2/auditlogs"
+	changeEventsPath                      = "/api/v2/changes"
+	deletedObjectsPath                    = "/api/v2/deleted"
+	sessionsPath                          = "/api/v2/sessions"
+	ipApprovalsPath                       = "/api/v2/ipapprovals"
 	sharesPath                            = "/api/v2/shares"
 	eventActionsPath                      = "/api/v2/eventactions"
 	eventRulesPath                        = "/api/v2/eventrules"
 	rolesPath                             = "/api/v2/roles"
+	tenantsPath                           = "/api/v2/tenants"
+	templatesPath                         = "/api/v2/templates"
 	ipListsPath                           = "/api/v2/iplists"
+	oidcMappingsPath                      = "/api/v2/oidc/mappings"
+	backupConfigsPath                     = "/api/v2/backups/config"
+	backupRunPath                         = "/api/v2/backups/run"
+	tlsRevocationReloadPath               = "/api/v2/tls/revocation/reload"
 	healthzPath                           = "/healthz"
 	robotsTxtPath                         = "/robots.txt"
 	webRootPathDefault                    = "/"
@@ -108,6 +129,7 @@ const (
 	webOIDCRedirectPathDefault            = "/web/oidc/redirect"
 	webAdminTwoFactorPathDefault          = "/web/admin/twofactor"
 	webAdminTwoFactorRecoveryPathDefault  = "/web/admin/twofactor-recovery"
+	webAdminTwoFactorWebAuthnPathDefault  = "/web/admin/twofactor-webauthn"
 	webLogoutPathDefault                  = "/web/admin/logout"
 	webUsersPathDefault                   = "/web/admin/users"
 	webUserPathDefault                    = "/web/admin/user"
@@ -133,12 +155,16 @@ const (
 	webAdminEventRulePathDefault          = "/web/admin/eventrule"
 	webAdminEventActionsPathDefault       = "/web/admin/eventactions"
 	webAdminEventActionPathDefault        = "/web/admin/eventaction"
+	webAdminDeadLettersPathDefault        = "/web/admin/deadletters"
 	webAdminRolesPathDefault              = "/web/admin/roles"
 	webAdminRolePathDefault               = "/web/admin/role"
+	webAdminTenantsPathDefault            = "/web/admin/tenants"
+	webAdminTenantPathDefault             = "/web/admin/tenant"
 	webAdminTOTPGeneratePathDefault       = "/web/admin/totp/generate"
 	webAdminTOTPValidatePathDefault       = "/web/admin/totp/validate"
 	webAdminTOTPSavePathDefault           = "/web/admin/totp/save"
 	webAdminRecoveryCodesPathDefault      = "/web/admin/recoverycodes"
+	webAdminWebAuthnPathDefault           = "/web/admin/webauthn"
 	webTemplateUserDefault                = "/web/admin/template/user"
 	webTemplateFolderDefault              = "/web/admin/template/folder"
 	webDefenderPathDefault                = "/web/admin/defender"
@@ -151,29 +177,38 @@ const (
 	webConfigsPathDefault                 = "/web/admin/configs"
 	webClientLoginPathDefault             = "/web/client/login"
 	webClientOIDCLoginPathDefault         = "/web/client/oidclogin"
+	webClientKerberosLoginPathDefault     = "/web/client/kerberoslogin"
 	webClientTwoFactorPathDefault         = "/web/client/twofactor"
 	webClientTwoFactorRecoveryPathDefault = "/web/client/twofactor-recovery"
+	webClientTwoFactorWebAuthnPathDefault = "/web/client/twofactor-webauthn"
 	webClientFilesPathDefault             = "/web/client/files"
 	webClientFilePathDefault              = "/web/client/file"
 	webClientFileActionsPathDefault       = "/web/client/file-actions"
 	webClientSharesPathDefault            = "/web/client/shares"
+	webClientTrashPathDefault             = "/web/client/trash"
+	webClientPartialDownloadsPathDefault  = "/web/client/partial-downloads"
 	webClientSharePathDefault             = "/web/client/share"
 	webClientEditFilePathDefault          = "/web/client/editfile"
 	webClientDirsPathDefault              = "/web/client/dirs"
 	webClientDownloadZipPathDefault       = "/web/client/downloadzip"
 	webClientProfilePathDefault           = "/web/client/profile"
 	webClientMFAPathDefault               = "/web/client/mfa"
+	webClientSSHCertPathDefault           = "/web/client/sshcert"
 	webClientTOTPGeneratePathDefault      = "/web/client/totp/generate"
 	webClientTOTPValidatePathDefault      = "/web/client/totp/validate"
 	webClientTOTPSavePathDefault          = "/web/client/totp/save"
 	webClientRecoveryCodesPathDefault     = "/web/client/recoverycodes"
+	webClientWebAuthnPathDefault          = "/web/client/webauthn"
 	webChangeClientPwdPathDefault         = "/web/client/changepwd"
 	webClientLogoutPathDefault            = "/web/client/logout"
 	webClientPubSharesPathDefault         = "/web/client/pubshares"
+	webClientAnonymousPathDefault         = "/web/client/anonymous"
 	webClientForgotPwdPathDefault         = "/web/client/forgot-password"
 	webClientResetPwdPathDefault          = "/web/client/reset-password"
+	webClientIPApprovalPathDefault        = "/web/client" + common.IPApprovalWebClientPath
 	webClientViewPDFPathDefault           = "/web/client/viewpdf"
 	webClientGetPDFPathDefault            = "/web/client/getpdf"
+	webClientPreviewPathDefault           = "/web/client/preview"
 	webStaticFilesPathDefault             = "/static"
 	webOpenAPIPathDefault                 = "/openapi"
 	// MaxRestoreSize defines the max size for the loaddata input file
@@ -204,6 +239,7 @@ var (
 	webAdminLoginPath              string
 	webAdminTwoFactorPath          string
 	webAdminTwoFactorRecoveryPath  string
+	webAdminTwoFactorWebAuthnPath  string
 	webLogoutPath                  string
 	webUsersPath                   string
 	webUserPath                    string
@@ -226,12 +262,16 @@ var (
 	webAdminEventRulePath          string
 	webAdminEventActionsPath       string
 	webAdminEventActionPath        string
+	webAdminDeadLettersPath        string
 	webAdminRolesPath              string
 	webAdminRolePath               string
+	webAdminTenantsPath            string
+	webAdminTenantPath             string
 	webAdminTOTPGeneratePath       string
 	webAdminTOTPValidatePath       string
 	webAdminTOTPSavePath           string
 	webAdminRecoveryCodesPath      string
+	webAdminWebAuthnPath           string
 	webChangeAdminPwdPath          string
 	webAdminForgotPwdPath          string
 	webAdminResetPwdPath           string
@@ -247,12 +287,16 @@ var (
 	webDefenderHostsPath           string
 	webClientLoginPath             string
 	webClientOIDCLoginPath         string
+	webClientKerberosLoginPath     string
 	webClientTwoFactorPath         string
 	webClientTwoFactorRecoveryPath string
+	webClientTwoFactorWebAuthnPath string
 	webClientFilesPath             string
 	webClientFilePath              string
 	webClientFileActionsPath       string
 	webClientSharesPath            string
+	webClientTrashPath             string
+	webClientPartialDownloadsPath  string
 	webClientSharePath             string
 	webClientEditFilePath          string
 	webClientDirsPath              string
@@ -260,16 +304,21 @@ var (
 	webClientProfilePath           string
 	webChangeClientPwdPath         string
 	webClientMFAPath               string
+	webClientSSHCertPath           string
 	webClientTOTPGeneratePath      string
 	webClientTOTPValidatePath      string
 	webClientTOTPSavePath          string
 	webClientRecoveryCodesPath     string
+	webClientWebAuthnPath          string
 	webClientPubSharesPath         string
+	webClientAnonymousPath         string
 	webClientLogoutPath            string
 	webClientForgotPwdPath         string
 	webClientResetPwdPath          string
+	webClientIPApprovalPath        string
 	webClientViewPDFPath           string
 	webClientGetPDFPath            string
+	webClientPreviewPath           string
 	webStaticFilesPath             string
 	webOpenAPIPath                 string
 	// max upload size for http clients, 1GB by default
@@ -477,6 +526,9 @@ type Binding struct {
 	EnabledLoginMethods int `json:"enabled_login_methods" mapstructure:"enabled_login_methods"`
 	// you also need to provide a certificate for enabling HTTPS
 	EnableHTTPS bool `json:"enable_https" mapstructure:"enable_https"`
+	// Enable HTTP/3 on the UDP port matching the TCP one, HTTPS must be enabled.
+	// HTTP/3 is advertised to clients using the Alt-Svc header
+	EnableHTTP3 bool `json:"enable_http3" mapstructure:"enable_http3"`
 	// Certificate and matching private key for this specific binding, if empty the global
 	// ones will be used, if any
 	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
@@ -524,10 +576,44 @@ type Binding struct {
 	// Security defines security headers to add to HTTP responses and allows to restrict allowed hosts
 	Security SecurityConf `json:"security" mapstructure:"security"`
 	// Branding defines customizations to suit your brand
-	Branding         Branding `json:"branding" mapstructure:"branding"`
+	Branding Branding `json:"branding" mapstructure:"branding"`
+	// Name of the LDAP directory to use for password authentication on this binding,
+	// it applies to the WebClient login and to the REST API user token.
+	// Leave empty to use the configured authentication methods
+	LDAPDirectory string `json:"ldap_directory" mapstructure:"ldap_directory"`
+	// Name of the Kerberos service to use for SPNEGO authentication on this binding,
+	// it applies to the WebClient login and to the REST API user token.
+	// Leave empty to disable Kerberos authentication
+	KerberosService string `json:"kerberos_service" mapstructure:"kerberos_service"`
+	// Countries and autonomous systems allowed or denied to connect to this binding.
+	// They are ignored if GeoIP is not configured
+	GeoIP geoip.Filter `json:"geoip" mapstructure:"geoip"`
+	// Virtual hosts allow to serve different host names from this binding with
+	// their own certificates, branding, OIDC configuration and allowed user roles
+	VirtualHosts     []VirtualHost `json:"virtual_hosts" mapstructure:"virtual_hosts"`
 	allowHeadersFrom []func(net.IP) bool
 }
 
+// VirtualHost defines the configuration for a host name served by a binding.
+// Requests are matched against the Host header or, if it is empty, against
+// the server name requested by the client using TLS SNI
+type VirtualHost struct {
+	// Host name, for example "files.example.com"
+	Host string `json:"host" mapstructure:"host"`
+	// Certificate and matching private key for this host, if empty the binding
+	// ones will be used. HTTPS must be enabled on the binding
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Branding for this host, it replaces the binding one
+	Branding Branding `json:"branding" mapstructure:"branding"`
+	// OIDC configuration for this host, it replaces the binding one.
+	// Leave empty to disable OIDC for this host
+	OIDC OIDC `json:"oidc" mapstructure:"oidc"`
+	// Users must have one of these roles to login using this host.
+	// Leave empty to allow any user
+	AllowedRoles []string `json:"allowed_roles" mapstructure:"allowed_roles"`
+}
+
 func (b *Binding) checkWebClientIntegrations() {
 	var integrations []WebClientIntegration
 	for _, integration := range b.WebClientIntegrations {
@@ -637,17 +723,55 @@ func (b *Binding) checkLoginMethods() error {
 			return errors.New("no login method available for WebAdmin UI")
 		}
 	}
-	if b.isWebClientLoginFormDisabled() && b.isWebClientOIDCLoginDisabled() {
+	if b.isWebClientLoginFormDisabled() && b.isWebClientOIDCLoginDisabled() && b.KerberosService == "" {
 		return errors.New("no login method available for WebClient UI")
 	}
 	if !b.isWebClientOIDCLoginDisabled() {
-		if b.isWebClientLoginFormDisabled() && !b.OIDC.isEnabled() {
+		if b.isWebClientLoginFormDisabled() && !b.OIDC.isEnabled() && b.KerberosService == "" {
 			return errors.New("no login method available for WebClient UI")
 		}
 	}
 	return nil
 }
 
+func (b *Binding) checkVirtualHosts() error {
+	hosts := make(map[string]bool)
+	for idx := range b.VirtualHosts {
+		vh := &b.VirtualHosts[idx]
+		vh.Host = strings.ToLower(strings.TrimSpace(vh.Host))
+		if vh.Host == "" {
+			return fmt.Errorf("virtual host %d for binding %q: host is mandatory", idx, b.GetAddress())
+		}
+		if hosts[vh.Host] {
+			return fmt.Errorf("virtual host %q for binding %q is duplicated", vh.Host, b.GetAddress())
+		}
+		hosts[vh.Host] = true
+		vh.AllowedRoles = util.RemoveDuplicates(vh.AllowedRoles, false)
+	}
+	return nil
+}
+
+// getVirtualHostBinding returns a copy of the binding with the branding and
+// the OIDC configuration of the specified virtual host
+func (b *Binding) getVirtualHostBinding(vh *VirtualHost) Binding {
+	binding := *b
+	binding.Branding = vh.Branding
+	binding.OIDC = vh.OIDC
+	binding.VirtualHosts = nil
+	binding.checkBranding()
+	return binding
+}
+
+func (b *Binding) getVirtualHostsCertIDs() map[string]string {
+	certIDs := make(map[string]string)
+	for _, vh := range b.VirtualHosts {
+		if getConfigPath(vh.CertificateFile, "") != "" && getConfigPath(vh.CertificateKeyFile, "") != "" {
+			certIDs[strings.ToLower(vh.Host)] = common.GetVirtualHostCertificateID(b.GetAddress(), vh.Host)
+		}
+	}
+	return certIDs
+}
+
 func (b *Binding) showAdminLoginURL() bool {
 	if !b.EnableWebAdmin {
 		return false
@@ -710,6 +834,42 @@ type SetupConfig struct {
 	InstallationCodeHint string `json:"installation_code_hint" mapstructure:"installation_code_hint"`
 }
 
+// TusConfig defines the configuration for resumable uploads using the tus protocol
+type TusConfig struct {
+	// Set to true to enable the tus resumable upload protocol for the REST API
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to the directory where incomplete uploads are stored.
+	// This can be an absolute path or a path relative to the config dir
+	UploadsPath string `json:"uploads_path" mapstructure:"uploads_path"`
+	// Incomplete uploads expire, and are removed, if they don't receive data for
+	// the configured number of hours. 0 means the default: 24 hours
+	Expiration int `json:"expiration" mapstructure:"expiration"`
+}
+
+// RememberedDevicesConfig defines the configuration to skip the second factor
+// authentication, for the web UIs, on trusted browsers
+type RememberedDevicesConfig struct {
+	// Number of days a browser can be remembered after a successful second factor
+	// authentication to the WebAdmin. 0 means disabled
+	WebAdminDays int `json:"webadmin_days" mapstructure:"webadmin_days"`
+	// Number of days a browser can be remembered after a successful second factor
+	// authentication to the WebClient. 0 means disabled
+	WebClientDays int `json:"webclient_days" mapstructure:"webclient_days"`
+	// If enabled, a remembered browser is trusted only from the IP address used
+	// to complete the second factor authentication
+	BindToIP bool `json:"bind_to_ip" mapstructure:"bind_to_ip"`
+	// If enabled, a remembered browser is trusted only if its user agent does not change
+	BindToUserAgent bool `json:"bind_to_user_agent" mapstructure:"bind_to_user_agent"`
+}
+
+func (c *RememberedDevicesConfig) validate() error {
+	if c.WebAdminDays < 0 || c.WebClientDays < 0 {
+		return fmt.Errorf("invalid remembered devices days, WebAdmin: %d, WebClient: %d",
+			c.WebAdminDays, c.WebClientDays)
+	}
+	return nil
+}
+
 // CorsConfig defines the CORS configuration
 type CorsConfig struct {
 	AllowedOrigins       []string `json:"allowed_origins" mapstructure:"allowed_origins"`
@@ -752,6 +912,9 @@ type Conf struct {
 	// CARevocationLists defines a set a revocation lists, one for each root CA, to be used to check
 	// if a client certificate has been revoked
 	CARevocationLists []string `json:"ca_revocation_lists" mapstructure:"ca_revocation_lists"`
+	// RevocationCheck defines the OCSP checks for client certificates and if certificates
+	// whose revocation status cannot be determined must be rejected
+	RevocationCheck common.RevocationConfig `json:"revocation_check" mapstructure:"revocation_check"`
 	// SigningPassphrase defines the passphrase to use to derive the signing key for JWT and CSRF tokens.
 	// If empty a random signing key will be generated each time SFTPGo starts. If you set a
 	// signing passphrase you should consider rotating it periodically for added security
@@ -767,8 +930,15 @@ type Conf struct {
 	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Initial setup configuration
 	Setup SetupConfig `json:"setup" mapstructure:"setup"`
+	// Resumable uploads configuration
+	Tus TusConfig `json:"tus" mapstructure:"tus"`
 	// If enabled, the link to the sponsors section will not appear on the setup screen page
 	HideSupportLink bool `json:"hide_support_link" mapstructure:"hide_support_link"`
+	// Remembered devices allow to skip the second factor authentication, for the
+	// web UIs, on trusted browsers
+	RememberedDevices RememberedDevicesConfig `json:"remembered_devices" mapstructure:"remembered_devices"`
+	// Captcha and progressive delays for the web login and password reset forms
+	LoginProtection LoginProtectionConfig `json:"login_protection" mapstructure:"login_protection"`
 	acmeDomain      string
 }
 
@@ -831,6 +1001,17 @@ func (c *Conf) getRedacted() Conf {
 		if binding.OIDC.ClientSecret != "" {
 			binding.OIDC.ClientSecret = redacted
 		}
+		virtualHosts := make([]VirtualHost, 0, len(binding.VirtualHosts))
+		for _, vh := range binding.VirtualHosts {
+			if vh.OIDC.ClientID != "" {
+				vh.OIDC.ClientID = redacted
+			}
+			if vh.OIDC.ClientSecret != "" {
+				vh.OIDC.ClientSecret = redacted
+			}
+			virtualHosts = append(virtualHosts, vh)
+		}
+		binding.VirtualHosts = virtualHosts
 		conf.Bindings = append(conf.Bindings, binding)
 	}
 	return conf
@@ -849,6 +1030,17 @@ func (c *Conf) getKeyPairs(configDir string) []common.TLSKeyPair {
 				ID:   binding.GetAddress(),
 			})
 		}
+		for _, vh := range binding.VirtualHosts {
+			certificateFile := getConfigPath(vh.CertificateFile, configDir)
+			certificateKeyFile := getConfigPath(vh.CertificateKeyFile, configDir)
+			if certificateFile != "" && certificateKeyFile != "" {
+				keyPairs = append(keyPairs, common.TLSKeyPair{
+					Cert: certificateFile,
+					Key:  certificateKeyFile,
+					ID:   common.GetVirtualHostCertificateID(binding.GetAddress(), vh.Host),
+				})
+			}
+		}
 	}
 	var certificateFile, certificateKeyFile string
 	if c.acmeDomain != "" {
@@ -915,6 +1107,7 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 	logger.Info(logSender, "", "initializing HTTP server with config %+v", c.getRedacted())
 	configurationDir = configDir
 	resetCodesMgr = newResetCodeManager(isShared)
+	webAuthnSessionsMgr = newWebAuthnSessionManager(isShared)
 	oidcMgr = newOIDCManager(isShared)
 	staticFilesPath := util.FindSharedDataPath(c.StaticFilesPath, configDir)
 	templatesPath := util.FindSharedDataPath(c.TemplatesPath, configDir)
@@ -934,6 +1127,13 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 	} else {
 		logger.Info(logSender, "", "built-in web client interface disabled")
 	}
+	if c.Tus.Enabled {
+		mgr, err := newTusManager(getConfigPath(c.Tus.UploadsPath, configDir), c.Tus.Expiration)
+		if err != nil {
+			return err
+		}
+		tusMgr = mgr
+	}
 	keyPairs := c.getKeyPairs(configDir)
 	if len(keyPairs) > 0 {
 		mgr, err := common.NewCertManager(keyPairs, configDir, logSender)
@@ -948,10 +1148,22 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 		if err := mgr.LoadCRLs(); err != nil {
 			return err
 		}
+		if err := mgr.SetRevocationConfig(c.RevocationCheck); err != nil {
+			return err
+		}
 		certMgr = mgr
 	}
 
 	csrfTokenAuth = jwtauth.New(jwa.HS256.String(), getSigningKey(c.SigningPassphrase), nil)
+	if err := c.RememberedDevices.validate(); err != nil {
+		return err
+	}
+	rememberedDevices = c.RememberedDevices
+	rememberedDeviceTokenAuth = jwtauth.New(jwa.HS256.String(), getSigningKey(c.SigningPassphrase), nil)
+	if err := c.LoginProtection.validate(); err != nil {
+		return err
+	}
+	loginProtection = c.LoginProtection
 	hideSupportLink = c.HideSupportLink
 
 	exitChannel := make(chan error, 1)
@@ -963,6 +1175,18 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 		if err := binding.parseAllowedProxy(); err != nil {
 			return err
 		}
+		if err := dataprovider.CheckLDAPDirectory(binding.LDAPDirectory); err != nil {
+			return err
+		}
+		if err := dataprovider.CheckKerberosService(binding.KerberosService); err != nil {
+			return err
+		}
+		if err := binding.GeoIP.Validate(); err != nil {
+			return fmt.Errorf("invalid GeoIP filter for binding %q: %w", binding.GetAddress(), err)
+		}
+		if err := binding.checkVirtualHosts(); err != nil {
+			return err
+		}
 		binding.checkWebClientIntegrations()
 		binding.checkBranding()
 		binding.Security.updateProxyHeaders()
@@ -978,6 +1202,10 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 			}
 			server := newHttpdServer(b, staticFilesPath, c.SigningPassphrase, c.Cors, openAPIPath)
 			server.setShared(isShared)
+			if err := server.addVirtualHosts(); err != nil {
+				exitChannel <- err
+				return
+			}
 
 			exitChannel <- server.listenAndServe()
 		}(binding)
@@ -1007,6 +1235,14 @@ func ReloadCertificateMgr() error {
 	return nil
 }
 
+// ReloadRevocationData reloads the CRLs and removes the cached OCSP responses
+func ReloadRevocationData() error {
+	if certMgr != nil {
+		return certMgr.ReloadRevocationData()
+	}
+	return nil
+}
+
 func getConfigPath(name, configDir string) string {
 	if !util.IsFileInputValid(name) {
 		return ""
@@ -1064,13 +1300,18 @@ func updateWebClientURLs(baseURL string) {
 	webOIDCRedirectPath = path.Join(baseURL, webOIDCRedirectPathDefault)
 	webClientLoginPath = path.Join(baseURL, webClientLoginPathDefault)
 	webClientOIDCLoginPath = path.Join(baseURL, webClientOIDCLoginPathDefault)
+	webClientKerberosLoginPath = path.Join(baseURL, webClientKerberosLoginPathDefault)
 	webClientTwoFactorPath = path.Join(baseURL, webClientTwoFactorPathDefault)
 	webClientTwoFactorRecoveryPath = path.Join(baseURL, webClientTwoFactorRecoveryPathDefault)
+	webClientTwoFactorWebAuthnPath = path.Join(baseURL, webClientTwoFactorWebAuthnPathDefault)
 	webClientFilesPath = path.Join(baseURL, webClientFilesPathDefault)
 	webClientFilePath = path.Join(baseURL, webClientFilePathDefault)
 	webClientFileActionsPath = path.Join(baseURL, webClientFileActionsPathDefault)
 	webClientSharesPath = path.Join(baseURL, webClientSharesPathDefault)
+	webClientTrashPath = path.Join(baseURL, webClientTrashPathDefault)
+	webClientPartialDownloadsPath = path.Join(baseURL, webClientPartialDownloadsPathDefault)
 	webClientPubSharesPath = path.Join(baseURL, webClientPubSharesPathDefault)
+	webClientAnonymousPath = path.Join(baseURL, webClientAnonymousPathDefault)
 	webClientSharePath = path.Join(baseURL, webClientSharePathDefault)
 	webClientEditFilePath = path.Join(baseURL, webClientEditFilePathDefault)
 	webClientDirsPath = path.Join(baseURL, webClientDirsPathDefault)
@@ -1079,14 +1320,18 @@ func updateWebClientURLs(baseURL string) {
 	webChangeClientPwdPath = path.Join(baseURL, webChangeClientPwdPathDefault)
 	webClientLogoutPath = path.Join(baseURL, webClientLogoutPathDefault)
 	webClientMFAPath = path.Join(baseURL, webClientMFAPathDefault)
+	webClientSSHCertPath = path.Join(baseURL, webClientSSHCertPathDefault)
 	webClientTOTPGeneratePath = path.Join(baseURL, webClientTOTPGeneratePathDefault)
 	webClientTOTPValidatePath = path.Join(baseURL, webClientTOTPValidatePathDefault)
 	webClientTOTPSavePath = path.Join(baseURL, webClientTOTPSavePathDefault)
 	webClientRecoveryCodesPath = path.Join(baseURL, webClientRecoveryCodesPathDefault)
+	webClientWebAuthnPath = path.Join(baseURL, webClientWebAuthnPathDefault)
 	webClientForgotPwdPath = path.Join(baseURL, webClientForgotPwdPathDefault)
 	webClientResetPwdPath = path.Join(baseURL, webClientResetPwdPathDefault)
+	webClientIPApprovalPath = path.Join(baseURL, webClientIPApprovalPathDefault)
 	webClientViewPDFPath = path.Join(baseURL, webClientViewPDFPathDefault)
 	webClientGetPDFPath = path.Join(baseURL, webClientGetPDFPathDefault)
+	webClientPreviewPath = path.Join(baseURL, webClientPreviewPathDefault)
 }
 
 func updateWebAdminURLs(baseURL string) {
@@ -1102,6 +1347,7 @@ func updateWebAdminURLs(baseURL string) {
 	webAdminOIDCLoginPath = path.Join(baseURL, webAdminOIDCLoginPathDefault)
 	webAdminTwoFactorPath = path.Join(baseURL, webAdminTwoFactorPathDefault)
 	webAdminTwoFactorRecoveryPath = path.Join(baseURL, webAdminTwoFactorRecoveryPathDefault)
+	webAdminTwoFactorWebAuthnPath = path.Join(baseURL, webAdminTwoFactorWebAuthnPathDefault)
 	webLogoutPath = path.Join(baseURL, webLogoutPathDefault)
 	webUsersPath = path.Join(baseURL, webUsersPathDefault)
 	webUserPath = path.Join(baseURL, webUserPathDefault)
@@ -1127,12 +1373,16 @@ func updateWebAdminURLs(baseURL string) {
 	webAdminEventRulePath = path.Join(baseURL, webAdminEventRulePathDefault)
 	webAdminEventActionsPath = path.Join(baseURL, webAdminEventActionsPathDefault)
 	webAdminEventActionPath = path.Join(baseURL, webAdminEventActionPathDefault)
+	webAdminDeadLettersPath = path.Join(baseURL, webAdminDeadLettersPathDefault)
 	webAdminRolesPath = path.Join(baseURL, webAdminRolesPathDefault)
 	webAdminRolePath = path.Join(baseURL, webAdminRolePathDefault)
+	webAdminTenantsPath = path.Join(baseURL, webAdminTenantsPathDefault)
+	webAdminTenantPath = path.Join(baseURL, webAdminTenantPathDefault)
 	webAdminTOTPGeneratePath = path.Join(baseURL, webAdminTOTPGeneratePathDefault)
 	webAdminTOTPValidatePath = path.Join(baseURL, webAdminTOTPValidatePathDefault)
 	webAdminTOTPSavePath = path.Join(baseURL, webAdminTOTPSavePathDefault)
 	webAdminRecoveryCodesPath = path.Join(baseURL, webAdminRecoveryCodesPathDefault)
+	webAdminWebAuthnPath = path.Join(baseURL, webAdminWebAuthnPathDefault)
 	webTemplateUser = path.Join(baseURL, webTemplateUserDefault)
 	webTemplateFolder = path.Join(baseURL, webTemplateFolderDefault)
 	webDefenderHostsPath = path.Join(baseURL, webDefenderHostsPathDefault)
@@ -1150,8 +1400,11 @@ func updateWebAdminURLs(baseURL string) {
 // GetHTTPRouter returns an HTTP handler suitable to use for test cases
 func GetHTTPRouter(b Binding) http.Handler {
 	server := newHttpdServer(b, filepath.Join("..", "..", "static"), "", CorsConfig{}, filepath.Join("..", "..", "openapi"))
-	server.initializeRouter()
-	return server.router
+	if err := server.addVirtualHosts(); err != nil {
+		logger.Error(logSender, "", "unable to add virtual hosts: %v", err)
+	}
+	server.initializeRouters()
+	return server
 }
 
 // the ticker cannot be started/stopped from multiple goroutines
@@ -1170,6 +1423,12 @@ func startCleanupTicker(duration time.Duration) {
 				counter++
 				cleanupExpiredJWTTokens()
 				resetCodesMgr.Cleanup()
+				webAuthnSessionsMgr.Cleanup()
+				common.WebSessions.Cleanup()
+				loginFailures.cleanup()
+				if tusMgr != nil {
+					tusMgr.cleanup()
+				}
 				if counter%2 == 0 {
 					oidcMgr.cleanup()
 				}
@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -3013,11 +3014,13 @@ func TestDecodeToken(t *testing.T) {
 		claimNodeID:                 nodeID,
 		claimMustChangePasswordKey:  false,
 		claimMustSetSecondFactorKey: true,
+		claimLoginMethod:            dataprovider.LoginMethodKerberos,
 	}
 	c := jwtTokenClaims{}
 	c.Decode(token)
 	assert.Equal(t, defaultAdminUsername, c.Username)
 	assert.Equal(t, nodeID, c.NodeID)
+	assert.Equal(t, dataprovider.LoginMethodKerberos, c.LoginMethod)
 	assert.False(t, c.MustChangePassword)
 	assert.True(t, c.MustSetTwoFactorAuth)
 
@@ -3035,6 +3038,93 @@ func TestDecodeToken(t *testing.T) {
 	assert.Equal(t, token, claims)
 }
 
+func TestKerberosLogin(t *testing.T) {
+	server := httpdServer{
+		binding: Binding{
+			Port:            8080,
+			KerberosService: "missing",
+		},
+		tokenAuth: jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil),
+	}
+	negotiateHeader := "Negotiate " + base64.StdEncoding.EncodeToString([]byte("token"))
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, webClientKerberosLoginPath, nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:2345"
+	server.handleWebClientKerberosLogin(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "Negotiate", rr.Header().Get("WWW-Authenticate"))
+	assert.Contains(t, rr.Body.String(), "Kerberos authentication is not available")
+
+	rr = httptest.NewRecorder()
+	req.Header.Set("Authorization", negotiateHeader)
+	server.handleWebClientKerberosLogin(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Kerberos authentication failed")
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, userTokenPath, nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:2345"
+	server.getUserToken(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Negotiate", basicRealm}, rr.Header().Values("WWW-Authenticate"))
+
+	rr = httptest.NewRecorder()
+	req.Header.Set("Authorization", negotiateHeader)
+	server.getUserToken(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Negotiate"}, rr.Header().Values("WWW-Authenticate"))
+	// without a Kerberos service the Negotiate header is ignored
+	server.binding.KerberosService = ""
+	rr = httptest.NewRecorder()
+	server.getUserToken(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{basicRealm}, rr.Header().Values("WWW-Authenticate"))
+}
+
+func TestHTTPClientLoginMethod(t *testing.T) {
+	tokenAuth := jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil)
+	req, err := http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.LoginMethodPassword, getHTTPClientLoginMethod(req))
+	ctx := context.WithValue(req.Context(), loginMethodKey, dataprovider.LoginMethodKerberos)
+	assert.Equal(t, dataprovider.LoginMethodKerberos, getHTTPClientLoginMethod(req.WithContext(ctx)))
+
+	c := jwtTokenClaims{
+		Username:  "user",
+		Signature: "sig",
+	}
+	token, _, err := tokenAuth.Encode(c.asMap())
+	assert.NoError(t, err)
+	ctx = jwtauth.NewContext(req.Context(), token, nil)
+	assert.Equal(t, dataprovider.LoginMethodPassword, getHTTPClientLoginMethod(req.WithContext(ctx)))
+	c.LoginMethod = dataprovider.LoginMethodKerberos
+	token, _, err = tokenAuth.Encode(c.asMap())
+	assert.NoError(t, err)
+	ctx = jwtauth.NewContext(req.Context(), token, nil)
+	assert.Equal(t, dataprovider.LoginMethodKerberos, getHTTPClientLoginMethod(req.WithContext(ctx)))
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+		},
+		Filters: dataprovider.UserFilters{
+			BaseUserFilters: sdk.BaseUserFilters{
+				DeniedLoginMethods: []string{dataprovider.LoginMethodKerberos},
+			},
+		},
+	}
+	req.RemoteAddr = "127.0.0.1:2345"
+	err = checkHTTPClientUser(&user, req, xid.New().String(), false)
+	assert.NoError(t, err)
+	err = checkHTTPClientUser(&user, req.WithContext(ctx), xid.New().String(), false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "login method kerberos is not allowed")
+	}
+}
+
 func TestEventRoleFilter(t *testing.T) {
 	defaultVal := "default"
 	req, err := http.NewRequest(http.MethodGet, fsEventsPath+"?role=role1", nil)
@@ -3215,3 +3305,198 @@ func isSharedProviderSupported() bool {
 		return false
 	}
 }
+
+func TestTusMetadata(t *testing.T) {
+	metadata, err := parseTusMetadata("")
+	assert.NoError(t, err)
+	assert.Len(t, metadata, 0)
+	metadata, err = parseTusMetadata("path L2RpciBhL2ZpbGUudHh0, is_confidential,filename ZmlsZS50eHQ=")
+	assert.NoError(t, err)
+	assert.Len(t, metadata, 3)
+	assert.Equal(t, "/dir a/file.txt", metadata["path"])
+	assert.Equal(t, "file.txt", metadata["filename"])
+	assert.Empty(t, metadata["is_confidential"])
+	_, err = parseTusMetadata("path a b")
+	assert.Error(t, err)
+	_, err = parseTusMetadata("path ###")
+	assert.Error(t, err)
+	_, err = parseTusMetadata(",")
+	assert.Error(t, err)
+}
+
+func TestTusManager(t *testing.T) {
+	_, err := newTusManager("", 0)
+	assert.Error(t, err)
+	uploadsPath := filepath.Join(os.TempDir(), "tus_test")
+	mgr, err := newTusManager(uploadsPath, 0)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(tusDefaultExpiration)*time.Hour, mgr.expiration)
+
+	upload := &tusUpload{
+		ID:       "upload1",
+		Username: "user",
+		Path:     "/file.txt",
+		Size:     5,
+	}
+	mgr.updateExpiration(upload)
+	err = mgr.add(upload)
+	assert.NoError(t, err)
+	err = mgr.add(upload)
+	assert.Error(t, err)
+	_, err = mgr.get(upload.ID, "other user")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	_, err = mgr.get("../upload1", upload.Username)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	_, err = mgr.get("missing", upload.Username)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	assert.True(t, mgr.lock(upload.ID))
+	assert.False(t, mgr.lock(upload.ID))
+	err = mgr.writeData(upload, strings.NewReader("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), upload.Offset)
+	// data written after the saved offset are discarded
+	err = os.WriteFile(mgr.getDataPath(upload.ID), []byte("abcxxxx"), 0600)
+	assert.NoError(t, err)
+	err = mgr.writeData(upload, strings.NewReader("defg"))
+	assert.ErrorIs(t, err, errTusLengthExceeded)
+	data, err := os.ReadFile(mgr.getDataPath(upload.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abcde"), data)
+	saved, err := mgr.get(upload.ID, upload.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), saved.Offset)
+	// busy uploads are not removed
+	upload.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Minute))
+	err = mgr.save(upload)
+	assert.NoError(t, err)
+	mgr.cleanup()
+	assert.FileExists(t, mgr.getInfoPath(upload.ID))
+	mgr.unlock(upload.ID)
+	_, err = mgr.get(upload.ID, upload.Username)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	mgr.cleanup()
+	assert.NoFileExists(t, mgr.getInfoPath(upload.ID))
+	assert.NoFileExists(t, mgr.getDataPath(upload.ID))
+	// invalid info files are ignored
+	err = os.WriteFile(mgr.getInfoPath("invalid"), []byte("{"), 0600)
+	assert.NoError(t, err)
+	mgr.cleanup()
+	assert.FileExists(t, mgr.getInfoPath("invalid"))
+	err = mgr.writeData(&tusUpload{ID: "missing"}, strings.NewReader("a"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	err = os.RemoveAll(uploadsPath)
+	assert.NoError(t, err)
+}
+
+func TestLoginProtection(t *testing.T) {
+	oldConfig := loginProtection
+	oldProviders := captchaProviders
+	defer func() {
+		loginProtection = oldConfig
+		captchaProviders = oldProviders
+		loginFailures = newLoginFailuresTracker()
+	}()
+
+	c := LoginProtectionConfig{
+		CaptchaProvider: "unknown",
+	}
+	err := c.validate()
+	assert.ErrorContains(t, err, "unsupported captcha provider")
+	c.CaptchaProvider = " Turnstile "
+	err = c.validate()
+	assert.ErrorContains(t, err, "site and secret keys are required")
+	c.CaptchaSiteKey = "site key"
+	c.CaptchaSecretKey = "secret key"
+	c.CaptchaAfterFailures = -1
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid captcha after failures")
+	c.CaptchaAfterFailures = 2
+	c.BaseDelay = -1
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid login delays")
+	c.BaseDelay = 100
+	c.MaxDelay = 50
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid observation time")
+	c.ObservationTime = 10
+	err = c.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, captchaProviderTurnstile, c.CaptchaProvider)
+	assert.Equal(t, 100, c.MaxDelay)
+
+	c.MaxDelay = 350
+	assert.Equal(t, time.Duration(0), c.getDelay(0))
+	assert.Equal(t, 100*time.Millisecond, c.getDelay(1))
+	assert.Equal(t, 200*time.Millisecond, c.getDelay(2))
+	assert.Equal(t, 350*time.Millisecond, c.getDelay(3))
+	assert.Equal(t, 350*time.Millisecond, c.getDelay(100))
+
+	var captchaSuccess bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		assert.NoError(t, err)
+		assert.Equal(t, "secret key", r.Form.Get("secret"))
+		assert.Equal(t, "captcha response", r.Form.Get("response"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success": %t}`, captchaSuccess)
+	}))
+	defer server.Close()
+
+	captchaProviders = map[string]captchaProviderInfo{
+		captchaProviderTurnstile: {
+			ScriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+			CSSClass:      "cf-turnstile",
+			ResponseField: "cf-turnstile-response",
+			VerifyURL:     server.URL,
+		},
+	}
+	c.BaseDelay = 10
+	c.MaxDelay = 20
+	loginProtection = c
+	loginFailures = newLoginFailuresTracker()
+	ip := "192.168.1.10"
+	username := "login_protection_user"
+
+	assert.Nil(t, loginProtection.getCaptchaData(ip))
+	req, err := http.NewRequest(http.MethodPost, webClientLoginPath, nil)
+	require.NoError(t, err)
+	req.Form = url.Values{}
+	assert.NoError(t, checkLoginProtection(req, ip, username))
+	onLoginFailure(ip, username)
+	onLoginFailure("192.168.1.11", username)
+	// the captcha is required for the username, not yet for the IP
+	assert.Nil(t, loginProtection.getCaptchaData(ip))
+	assert.ErrorIs(t, checkLoginProtection(req, "192.168.1.12", username), errCaptcha)
+	// after a failed captcha verification the widget is shown for this IP too
+	captcha := loginProtection.getCaptchaData("192.168.1.12")
+	if assert.NotNil(t, captcha) {
+		assert.Equal(t, "site key", captcha.SiteKey)
+		assert.Equal(t, "cf-turnstile", captcha.CSSClass)
+	}
+	req.Form.Set("cf-turnstile-response", "captcha response")
+	assert.ErrorIs(t, checkLoginProtection(req, ip, username), errCaptcha)
+	captchaSuccess = true
+	assert.NoError(t, checkLoginProtection(req, ip, username))
+	onLoginSuccess(username)
+	// the failed captcha verification raised the failures for the IP
+	assert.Equal(t, 2, loginFailures.get(ip, username))
+	assert.Equal(t, 0, loginFailures.get("", username))
+	assert.NoError(t, checkLoginProtection(req, ip, username))
+
+	onPasswordResetRequest(ip, "")
+	assert.Equal(t, 3, loginFailures.get(ip, ""))
+	assert.NotNil(t, loginProtection.getCaptchaData(ip))
+	loginProtection.ObservationTime = -1
+	loginFailures.cleanup()
+	assert.Equal(t, 0, loginFailures.get(ip, username))
+	assert.Len(t, loginFailures.entries, 0)
+
+	loginProtection = LoginProtectionConfig{}
+	onLoginFailure(ip, username)
+	onPasswordResetRequest(ip, username)
+	assert.Len(t, loginFailures.entries, 0)
+	assert.NoError(t, checkLoginProtection(req, ip, username))
+	assert.Nil(t, loginProtection.getCaptchaData(ip))
+}
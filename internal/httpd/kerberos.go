@@ -0,0 +1,129 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var loginMethodKey = &contextKey{"login method"}
+
+// getHTTPClientLoginMethod returns the login method used by the WebClient/REST
+// API user. It is Kerberos if the user is logging in using SPNEGO or if the JWT
+// token was issued after a Kerberos login, password otherwise
+func getHTTPClientLoginMethod(r *http.Request) string {
+	if loginMethod, ok := r.Context().Value(loginMethodKey).(string); ok {
+		return loginMethod
+	}
+	claims, err := getTokenClaims(r)
+	if err == nil && claims.LoginMethod != "" {
+		return claims.LoginMethod
+	}
+	return dataprovider.LoginMethodPassword
+}
+
+func (s *httpdServer) isKerberosRequest(r *http.Request) bool {
+	if s.binding.KerberosService == "" {
+		return false
+	}
+	_, ok, _ := kerberos.GetNegotiateToken(r.Header.Get("Authorization"))
+	return ok
+}
+
+// authenticateKerberos validates the SPNEGO token sent by the client and returns
+// the mapped SFTPGo user and a request with the Kerberos login method in its context.
+// Failed logins are recorded
+func (s *httpdServer) authenticateKerberos(w http.ResponseWriter, r *http.Request, ipAddr string,
+) (dataprovider.User, *http.Request, error) {
+	var user dataprovider.User
+	loginMethod := dataprovider.LoginMethodKerberos
+
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+		updateLoginMetrics(&user, loginMethod, ipAddr, err)
+		return user, r, err
+	}
+	service, err := dataprovider.GetKerberosService(s.binding.KerberosService)
+	if err != nil {
+		updateLoginMetrics(&user, loginMethod, ipAddr, common.ErrInternalFailure)
+		return user, r, err
+	}
+	token, _, err := kerberos.GetNegotiateToken(r.Header.Get("Authorization"))
+	if err != nil {
+		updateLoginMetrics(&user, loginMethod, ipAddr, err)
+		return user, r, dataprovider.ErrInvalidCredentials
+	}
+	principal, response, err := service.AcceptSPNEGO(token)
+	if err != nil {
+		updateLoginMetrics(&user, loginMethod, ipAddr, err)
+		return user, r, dataprovider.ErrInvalidCredentials
+	}
+	user, err = dataprovider.CheckKerberosAuth("", principal, ipAddr, common.ProtocolHTTP, s.binding.KerberosService)
+	if err != nil {
+		user.Username = principal
+		updateLoginMetrics(&user, loginMethod, ipAddr, err)
+		return user, r, dataprovider.ErrInvalidCredentials
+	}
+	if len(response) > 0 {
+		w.Header().Set(common.HTTPAuthenticationHeader, kerberos.GetNegotiateHeader(response))
+	}
+	ctx := context.WithValue(r.Context(), loginMethodKey, loginMethod)
+	return user, r.WithContext(ctx), nil
+}
+
+func (s *httpdServer) handleWebClientKerberosLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+
+	if !s.isKerberosRequest(r) {
+		// ask the browser to send a SPNEGO token, the login page is displayed
+		// if Kerberos is not available on the client side
+		w.Header().Set(common.HTTPAuthenticationHeader, kerberos.NegotiateScheme)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		s.renderClientLoginPage(w, "Kerberos authentication is not available", ipAddr)
+		return
+	}
+	user, r, err := s.authenticateKerberos(w, r, ipAddr)
+	if err != nil {
+		s.renderClientLoginPage(w, fmt.Sprintf("Kerberos authentication failed: %v", err), ipAddr)
+		return
+	}
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, xid.New().String())
+	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodKerberos, ipAddr, err)
+		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		return
+	}
+
+	defer user.CloseFs() //nolint:errcheck
+	err = user.CheckFsRoot(connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateLoginMetrics(&user, dataprovider.LoginMethodKerberos, ipAddr, common.ErrInternalFailure)
+		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		return
+	}
+	s.loginUser(w, r, &user, connectionID, ipAddr, false, s.renderClientLoginPage)
+}
@@ -0,0 +1,355 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// supported captcha providers
+const (
+	captchaProviderHCaptcha  = "hcaptcha"
+	captchaProviderTurnstile = "turnstile"
+	captchaProviderReCaptcha = "recaptcha"
+)
+
+var (
+	loginProtection LoginProtectionConfig
+	loginFailures   = newLoginFailuresTracker()
+	errCaptcha      = errors.New("please complete the captcha verification")
+	// captchaProviders defines, for each supported provider, the widget to
+	// embed in the web pages and how to verify the submitted response
+	captchaProviders = map[string]captchaProviderInfo{
+		captchaProviderHCaptcha: {
+			ScriptURL:     "https://js.hcaptcha.com/1/api.js",
+			CSSClass:      "h-captcha",
+			ResponseField: "h-captcha-response",
+			VerifyURL:     "https://api.hcaptcha.com/siteverify",
+		},
+		captchaProviderTurnstile: {
+			ScriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+			CSSClass:      "cf-turnstile",
+			ResponseField: "cf-turnstile-response",
+			VerifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		},
+		captchaProviderReCaptcha: {
+			ScriptURL:     "https://www.google.com/recaptcha/api.js",
+			CSSClass:      "g-recaptcha",
+			ResponseField: "g-recaptcha-response",
+			VerifyURL:     "https://www.google.com/recaptcha/api/siteverify",
+		},
+	}
+)
+
+// LoginProtectionConfig defines the configuration to protect the web login
+// and password reset forms against brute force and credential stuffing attacks.
+// Failures are tracked in memory, per IP address and per username, so that
+// attacks rotating IP addresses are detected too
+type LoginProtectionConfig struct {
+	// Captcha provider. Supported values: "hcaptcha", "turnstile", "recaptcha".
+	// Empty means disabled
+	CaptchaProvider string `json:"captcha_provider" mapstructure:"captcha_provider"`
+	// Site key provided by the captcha service
+	CaptchaSiteKey string `json:"captcha_site_key" mapstructure:"captcha_site_key"`
+	// Secret key provided by the captcha service
+	CaptchaSecretKey string `json:"captcha_secret_key" mapstructure:"captcha_secret_key"`
+	// Number of failures, per IP address or per username, after which a captcha
+	// verification is required. 0 means the captcha is always required
+	CaptchaAfterFailures int `json:"captcha_after_failures" mapstructure:"captcha_after_failures"`
+	// Delay, in milliseconds, added to the response after the first failure.
+	// The delay doubles after each subsequent failure. 0 means disabled
+	BaseDelay int `json:"base_delay" mapstructure:"base_delay"`
+	// Maximum delay, in milliseconds
+	MaxDelay int `json:"max_delay" mapstructure:"max_delay"`
+	// Failures older than this number of minutes are forgotten
+	ObservationTime int `json:"observation_time" mapstructure:"observation_time"`
+}
+
+func (c *LoginProtectionConfig) isCaptchaEnabled() bool {
+	return c.CaptchaProvider != ""
+}
+
+func (c *LoginProtectionConfig) isEnabled() bool {
+	return c.isCaptchaEnabled() || c.BaseDelay > 0
+}
+
+func (c *LoginProtectionConfig) validate() error {
+	c.CaptchaProvider = strings.ToLower(strings.TrimSpace(c.CaptchaProvider))
+	if c.isCaptchaEnabled() {
+		if _, ok := captchaProviders[c.CaptchaProvider]; !ok {
+			return fmt.Errorf("unsupported captcha provider %q", c.CaptchaProvider)
+		}
+		if c.CaptchaSiteKey == "" || c.CaptchaSecretKey == "" {
+			return fmt.Errorf("site and secret keys are required for the captcha provider %q", c.CaptchaProvider)
+		}
+	}
+	if c.CaptchaAfterFailures < 0 {
+		return fmt.Errorf("invalid captcha after failures: %d", c.CaptchaAfterFailures)
+	}
+	if c.BaseDelay < 0 || c.MaxDelay < 0 {
+		return fmt.Errorf("invalid login delays, base: %d, max: %d", c.BaseDelay, c.MaxDelay)
+	}
+	if c.MaxDelay < c.BaseDelay {
+		c.MaxDelay = c.BaseDelay
+	}
+	if c.isEnabled() && c.ObservationTime <= 0 {
+		return fmt.Errorf("invalid observation time: %d", c.ObservationTime)
+	}
+	return nil
+}
+
+func (c *LoginProtectionConfig) isCaptchaRequired(ip, username string) bool {
+	if !c.isCaptchaEnabled() {
+		return false
+	}
+	if c.CaptchaAfterFailures == 0 {
+		return true
+	}
+	return loginFailures.get(ip, username) >= c.CaptchaAfterFailures
+}
+
+func (c *LoginProtectionConfig) getDelay(failures int) time.Duration {
+	if c.BaseDelay <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := int64(c.BaseDelay)
+	for i := 1; i < failures && delay < int64(c.MaxDelay); i++ {
+		delay *= 2
+	}
+	delay = min(delay, int64(c.MaxDelay))
+	return time.Duration(delay) * time.Millisecond
+}
+
+func (c *LoginProtectionConfig) getCaptchaData(ip string) *captchaData {
+	if !c.isCaptchaRequired(ip, "") {
+		return nil
+	}
+	provider := captchaProviders[c.CaptchaProvider]
+	return &captchaData{
+		SiteKey:   c.CaptchaSiteKey,
+		ScriptURL: provider.ScriptURL,
+		CSSClass:  provider.CSSClass,
+	}
+}
+
+func (c *LoginProtectionConfig) verifyCaptcha(r *http.Request, ip string) error {
+	provider := captchaProviders[c.CaptchaProvider]
+	response := strings.TrimSpace(r.Form.Get(provider.ResponseField))
+	if response == "" {
+		return errCaptcha
+	}
+	form := url.Values{}
+	form.Set("secret", c.CaptchaSecretKey)
+	form.Set("response", response)
+	form.Set("remoteip", ip)
+	resp, err := httpclient.Post(provider.VerifyURL, "application/x-www-form-urlencoded",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		logger.Warn(logSender, "", "unable to verify %s captcha response: %v", c.CaptchaProvider, err)
+		return errCaptcha
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Warn(logSender, "", "unexpected status code from the %s verification endpoint: %d",
+			c.CaptchaProvider, resp.StatusCode)
+		return errCaptcha
+	}
+	var result captchaVerifyResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&result); err != nil {
+		logger.Warn(logSender, "", "unable to decode %s captcha verification response: %v", c.CaptchaProvider, err)
+		return errCaptcha
+	}
+	if !result.Success {
+		logger.Debug(logSender, "", "%s captcha verification failed for ip %q, error codes: %v",
+			c.CaptchaProvider, ip, result.ErrorCodes)
+		return errCaptcha
+	}
+	return nil
+}
+
+type captchaProviderInfo struct {
+	ScriptURL     string
+	CSSClass      string
+	ResponseField string
+	VerifyURL     string
+}
+
+// captchaData defines the captcha widget to render in the web pages
+type captchaData struct {
+	SiteKey   string
+	ScriptURL string
+	CSSClass  string
+}
+
+type captchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+type loginFailuresEntry struct {
+	count       int
+	lastFailure time.Time
+}
+
+type loginFailuresTracker struct {
+	sync.RWMutex
+	entries map[string]*loginFailuresEntry
+}
+
+func newLoginFailuresTracker() *loginFailuresTracker {
+	return &loginFailuresTracker{
+		entries: make(map[string]*loginFailuresEntry),
+	}
+}
+
+func (t *loginFailuresTracker) getKeys(ip, username string) []string {
+	var keys []string
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+func (t *loginFailuresTracker) isExpired(e *loginFailuresEntry) bool {
+	return time.Since(e.lastFailure) > time.Duration(loginProtection.ObservationTime)*time.Minute
+}
+
+// get returns the higher number of failures for the specified IP address and username
+func (t *loginFailuresTracker) get(ip, username string) int {
+	t.RLock()
+	defer t.RUnlock()
+
+	result := 0
+	for _, key := range t.getKeys(ip, username) {
+		if e, ok := t.entries[key]; ok && !t.isExpired(e) {
+			result = max(result, e.count)
+		}
+	}
+	return result
+}
+
+// add records a failure for the specified IP address and username and
+// returns the updated number of failures
+func (t *loginFailuresTracker) add(ip, username string) int {
+	t.Lock()
+	defer t.Unlock()
+
+	result := 0
+	for _, key := range t.getKeys(ip, username) {
+		e, ok := t.entries[key]
+		if !ok || t.isExpired(e) {
+			e = &loginFailuresEntry{}
+			t.entries[key] = e
+		}
+		e.count++
+		e.lastFailure = time.Now()
+		result = max(result, e.count)
+	}
+	return result
+}
+
+// setAtLeast raises the failures for the specified IP address to the
+// specified value, if lower
+func (t *loginFailuresTracker) setAtLeast(ip string, count int) {
+	t.Lock()
+	defer t.Unlock()
+
+	key := "ip:" + ip
+	e, ok := t.entries[key]
+	if !ok || t.isExpired(e) {
+		e = &loginFailuresEntry{}
+		t.entries[key] = e
+	}
+	e.count = max(e.count, count)
+	e.lastFailure = time.Now()
+}
+
+func (t *loginFailuresTracker) reset(username string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.entries, "user:"+username)
+}
+
+func (t *loginFailuresTracker) cleanup() {
+	t.Lock()
+	defer t.Unlock()
+
+	for k, e := range t.entries {
+		if t.isExpired(e) {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// checkLoginProtection must be called before validating the submitted
+// credentials, it returns an error if a captcha verification is required
+// and the submitted response is not valid
+func checkLoginProtection(r *http.Request, ip, username string) error {
+	if !loginProtection.isCaptchaRequired(ip, username) {
+		return nil
+	}
+	if err := loginProtection.verifyCaptcha(r, ip); err != nil {
+		// the captcha could be required because of the failures for the
+		// username, we want to show the widget from now on for this IP too
+		loginFailures.setAtLeast(ip, loginProtection.CaptchaAfterFailures)
+		return err
+	}
+	return nil
+}
+
+// onLoginFailure records a failed attempt and delays the response
+func onLoginFailure(ip, username string) {
+	if !loginProtection.isEnabled() {
+		return
+	}
+	failures := loginFailures.add(ip, username)
+	if delay := loginProtection.getDelay(failures); delay > 0 {
+		logger.Debug(logSender, "", "delaying the response for ip %q, username %q, failures: %d, delay: %s",
+			ip, username, failures, delay)
+		time.Sleep(delay)
+	}
+}
+
+func onLoginSuccess(username string) {
+	if !loginProtection.isEnabled() {
+		return
+	}
+	loginFailures.reset(username)
+}
+
+// onPasswordResetRequest records a password reset request. The response does
+// not reveal if the account exists, so each request counts as a failure
+func onPasswordResetRequest(ip, username string) {
+	if !loginProtection.isEnabled() {
+		return
+	}
+	loginFailures.add(ip, username)
+}
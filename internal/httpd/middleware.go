@@ -33,6 +33,7 @@ import (
 
 var (
 	forwardedProtoKey = &contextKey{"forwarded proto"}
+	allowedRolesKey   = &contextKey{"allowed roles"}
 	errInvalidToken   = errors.New("invalid JWT token")
 )
 
@@ -81,6 +82,11 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 		doRedirect("Your token is no longer valid", nil)
 		return errInvalidToken
 	}
+	if sessionID, _ := token.PrivateClaims()[claimSessionID].(string); common.IsTokenRevoked(token.IssuedAt(), sessionID) {
+		logger.Debug(logSender, "", "the token with id %q has been revoked", token.JwtID())
+		doRedirect("Your session has been revoked", nil)
+		return errInvalidToken
+	}
 	// a user with a partial token will be always redirected to the appropriate two factor auth page
 	if err := checkPartialAuth(w, r, audience, token.Audience()); err != nil {
 		return err
@@ -90,6 +96,14 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 		doRedirect("Your token audience is not valid", nil)
 		return errInvalidToken
 	}
+	if audience == tokenAudienceAPIUser || audience == tokenAudienceWebClient {
+		role, _ := token.PrivateClaims()[claimRole].(string)
+		if !isRoleAllowedForRequest(r, role) {
+			logger.Debug(logSender, "", "the token with id %q is not valid for the host %q", token.JwtID(), r.Host)
+			doRedirect("Your token is not valid", nil)
+			return errInvalidToken
+		}
+	}
 	if tokenValidationMode != tokenValidationNoIPMatch {
 		ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 		if !util.Contains(token.Audience(), ipAddr) {
@@ -113,7 +127,7 @@ func (s *httpdServer) validateJWTPartialToken(w http.ResponseWriter, r *http.Req
 		notFoundFunc(w, r, nil)
 		return errInvalidToken
 	}
-	if isTokenInvalidated(r) {
+	if isTokenInvalidated(r) || common.IsTokenRevoked(token.IssuedAt(), "") {
 		notFoundFunc(w, r, nil)
 		return errInvalidToken
 	}
@@ -276,6 +290,48 @@ func (s *httpdServer) checkPerm(perm string) func(next http.Handler) http.Handle
 			tokenClaims.Decode(claims)
 
 			if !tokenClaims.hasPerm(perm) {
+				logPermissionDenied(r, &tokenClaims, perm)
+				if isWebRequest(r) {
+					s.renderForbiddenPage(w, r, "You don't have permission for this action")
+				} else {
+					sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkUserPerm allows the request if the admin has the specified permission or
+// if the permission is granted on at least one group of the user identified by
+// the specified URL parameter. If the parameter is empty the request is allowed
+// if the permission is granted on any group, the handler must filter the results
+func (s *httpdServer) checkUserPerm(perm, param string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := getTokenClaims(r)
+			if err != nil {
+				if isWebRequest(r) {
+					s.renderBadRequestPage(w, r, err)
+				} else {
+					sendAPIResponse(w, r, err, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				}
+				return
+			}
+			if claims.hasPerm(perm) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			admin := claims.getAdmin()
+			allowed := admin.HasGrantedPermission(perm)
+			if allowed && param != "" {
+				user, err := dataprovider.UserExists(getURLParam(r, param), claims.Role)
+				allowed = err == nil && admin.HasPermissionOnGroups(perm, user.Groups)
+			}
+			if !allowed {
+				logPermissionDenied(r, &claims, perm)
 				if isWebRequest(r) {
 					s.renderForbiddenPage(w, r, "You don't have permission for this action")
 				} else {
@@ -289,6 +345,77 @@ func (s *httpdServer) checkPerm(perm string) func(next http.Handler) http.Handle
 	}
 }
 
+// checkTenantObject restricts the admins bound to a tenant to the objects belonging
+// to their tenant. The object name is read from the specified URL parameter
+func (s *httpdServer) checkTenantObject(objectType, param string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := getTokenClaims(r)
+			if err != nil {
+				if isWebRequest(r) {
+					s.renderBadRequestPage(w, r, err)
+				} else {
+					sendAPIResponse(w, r, err, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				}
+				return
+			}
+			tenant, ok, err := getAdminTenant(&claims)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err == nil {
+				ok, err = isTenantObject(&tenant, objectType, getURLParam(r, param))
+				if err == nil && !ok {
+					err = util.NewRecordNotFoundError(fmt.Sprintf("%s %q does not exist", objectType, getURLParam(r, param)))
+				}
+			}
+			if err != nil {
+				if isWebRequest(r) {
+					s.renderNotFoundPage(w, r, err)
+				} else {
+					sendAPIResponse(w, r, err, "", getRespStatus(err))
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forbidTenantAdmins denies the access to the global resources for the admins bound to a tenant
+func (s *httpdServer) forbidTenantAdmins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := getTokenClaims(r)
+		if err != nil || claims.Tenant != "" {
+			if isWebRequest(r) {
+				s.renderForbiddenPage(w, r, "You don't have permission for this action")
+			} else {
+				sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logPermissionDenied records, in the application and audit logs, the admin
+// requests denied for missing permissions
+func logPermissionDenied(r *http.Request, claims *jwtTokenClaims, perm string) {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	logger.Info(logSender, "", "permission %q denied for admin %q, API key %q, role %q, ip %q, request: %s %s",
+		perm, claims.Username, claims.APIKeyID, claims.Role, ipAddr, r.Method, r.URL.Path)
+	dataprovider.AddAuditLogEntry(&dataprovider.AuditLogEntry{
+		Action:   dataprovider.AuditActionPermissionDenied,
+		Username: claims.Username,
+		Role:     claims.Role,
+		IP:       ipAddr,
+		Protocol: getProtocolFromRequest(r),
+		APIKeyID: claims.APIKeyID,
+		Info:     fmt.Sprintf("permission %q denied, request: %s %s", perm, r.Method, r.URL.Path),
+	})
+}
+
 func verifyCSRFHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get(csrfHeaderToken)
@@ -386,6 +513,19 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 				sendAPIResponse(w, r, fmt.Errorf("the provided api key cannot be authenticated"), "", http.StatusUnauthorized)
 				return
 			}
+			if !k.IsAllowedFromIP(util.GetIPFromRemoteAddress(r.RemoteAddr)) {
+				logger.Debug(logSender, "", "api key %q is not allowed from IP %q", keyID, r.RemoteAddr)
+				sendAPIResponse(w, r, errors.New("the provided api key is not allowed from your IP address"), "",
+					http.StatusForbidden)
+				return
+			}
+			if !isRequestAllowedForAPIKey(&k, r) {
+				logger.Debug(logSender, "", "api key %q, access scopes %v, does not allow %s %q", keyID,
+					k.Filters.AccessScopes, r.Method, r.URL.Path)
+				sendAPIResponse(w, r, errors.New("the provided api key does not allow this request"), "",
+					http.StatusForbidden)
+				return
+			}
 			if scope == dataprovider.APIKeyScopeAdmin {
 				if k.Admin != "" {
 					apiUser = k.Admin
@@ -419,12 +559,47 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 					dataprovider.LoginMethodPassword, util.GetIPFromRemoteAddress(r.RemoteAddr), nil)
 			}
 			dataprovider.UpdateAPIKeyLastUse(&k) //nolint:errcheck
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+				dataprovider.AddAuditLogEntry(&dataprovider.AuditLogEntry{
+					Action:   dataprovider.AuditActionAPIKeyRequest,
+					Username: apiUser,
+					IP:       util.GetIPFromRemoteAddress(r.RemoteAddr),
+					Protocol: common.ProtocolHTTP,
+					APIKeyID: keyID,
+					Info:     fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+				})
+			}
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// isRequestAllowedForAPIKey returns true if the access scopes of the given
+// API key allow the request
+func isRequestAllowedForAPIKey(k *dataprovider.APIKey, r *http.Request) bool {
+	if !k.IsMethodAllowed(r.Method) {
+		return false
+	}
+	var allowedPaths []string
+	if k.HasAccessScope(dataprovider.APIKeyAccessUsers) {
+		allowedPaths = append(allowedPaths, userPath, groupPath, folderPath)
+	}
+	if k.HasAccessScope(dataprovider.APIKeyAccessEvents) {
+		allowedPaths = append(allowedPaths, fsEventsPath, providerEventsPath, auditLogsPath, changeEventsPath,
+			eventActionsPath, eventRulesPath)
+	}
+	if len(allowedPaths) == 0 {
+		return true
+	}
+	for _, p := range allowedPaths {
+		if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func forbidAPIKeyAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, err := getTokenClaims(r)
@@ -461,8 +636,10 @@ func authenticateAdminWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTA
 		Permissions: admin.Permissions,
 		Signature:   admin.GetSignature(),
 		Role:        admin.Role,
+		Tenant:      admin.Filters.Tenant,
 		APIKeyID:    keyID,
 	}
+	c.setAdminRestrictions(&admin)
 
 	resp, err := c.createTokenResponse(tokenAuth, tokenAudienceAPI, ipAddr)
 	if err != nil {
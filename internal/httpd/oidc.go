@@ -84,6 +84,13 @@ type OIDC struct {
 	// If set, the `RoleField` is ignored and the SFTPGo role is assumed based on
 	// the login link used
 	ImplicitRoles bool `json:"implicit_roles" mapstructure:"implicit_roles"`
+	// Optional ID token claims field with the user groups. If set, the groups and
+	// the role of the SFTPGo users and the role of the SFTPGo admins are synchronized
+	// on every login using the OpenID Connect mappings table.
+	// Users that do not exist are created if at least a mapping matches
+	GroupsField string `json:"groups_field" mapstructure:"groups_field"`
+	// If set, users and admins not matching any mapping are denied
+	RequireGroupMapping bool `json:"require_group_mapping" mapstructure:"require_group_mapping"`
 	// Scopes required by the OAuth provider to retrieve information about the authenticated user.
 	// The "openid" scope is required.
 	// Refer to your OAuth provider documentation for more information about this
@@ -195,25 +202,29 @@ func newOIDCPendingAuth(audience tokenAudience) oidcPendingAuth {
 }
 
 type oidcToken struct {
-	AccessToken          string          `json:"access_token"`
-	TokenType            string          `json:"token_type,omitempty"`
-	RefreshToken         string          `json:"refresh_token,omitempty"`
-	ExpiresAt            int64           `json:"expires_at,omitempty"`
-	SessionID            string          `json:"session_id"`
-	IDToken              string          `json:"id_token"`
-	Nonce                string          `json:"nonce"`
-	Username             string          `json:"username"`
-	Permissions          []string        `json:"permissions"`
-	HideUserPageSections int             `json:"hide_user_page_sections,omitempty"`
-	TokenRole            string          `json:"token_role,omitempty"` // SFTPGo role name
-	Role                 any             `json:"role"`                 // oidc user role: SFTPGo user or admin
-	CustomFields         *map[string]any `json:"custom_fields,omitempty"`
-	Cookie               string          `json:"cookie"`
-	UsedAt               int64           `json:"used_at"`
-}
-
-func (t *oidcToken) parseClaims(claims map[string]any, usernameField, roleField string, customFields []string,
-	forcedRole string,
+	AccessToken          string              `json:"access_token"`
+	TokenType            string              `json:"token_type,omitempty"`
+	RefreshToken         string              `json:"refresh_token,omitempty"`
+	ExpiresAt            int64               `json:"expires_at,omitempty"`
+	SessionID            string              `json:"session_id"`
+	IDToken              string              `json:"id_token"`
+	Nonce                string              `json:"nonce"`
+	Username             string              `json:"username"`
+	Permissions          []string            `json:"permissions"`
+	HideUserPageSections int                 `json:"hide_user_page_sections,omitempty"`
+	HideFsConfig         bool                `json:"hide_fs_config,omitempty"`
+	Grants               map[string][]string `json:"grants,omitempty"`
+	TokenRole            string              `json:"token_role,omitempty"`   // SFTPGo role name
+	TokenTenant          string              `json:"token_tenant,omitempty"` // SFTPGo tenant name, admins only
+	Role                 any                 `json:"role"`                   // oidc user role: SFTPGo user or admin
+	Groups               []string            `json:"groups,omitempty"`       // values of the groups claim
+	CustomFields         *map[string]any     `json:"custom_fields,omitempty"`
+	Cookie               string              `json:"cookie"`
+	UsedAt               int64               `json:"used_at"`
+}
+
+func (t *oidcToken) parseClaims(claims map[string]any, usernameField, roleField, groupsField string,
+	customFields []string, forcedRole string,
 ) error {
 	getClaimsFields := func() []string {
 		keys := make([]string, 0, len(claims))
@@ -234,6 +245,7 @@ func (t *oidcToken) parseClaims(claims map[string]any, usernameField, roleField
 	} else {
 		t.getRoleFromField(claims, roleField)
 	}
+	t.getGroupsFromField(claims, groupsField)
 	t.CustomFields = nil
 	if len(customFields) > 0 {
 		for _, field := range customFields {
@@ -257,38 +269,65 @@ func (t *oidcToken) parseClaims(claims map[string]any, usernameField, roleField
 }
 
 func (t *oidcToken) getRoleFromField(claims map[string]any, roleField string) {
-	if roleField != "" {
-		role, ok := claims[roleField]
-		if ok {
-			t.Role = role
-			return
-		}
-		if !strings.Contains(roleField, ".") {
-			return
-		}
+	if role, ok := getClaimValue(claims, roleField); ok {
+		t.Role = role
+	}
+}
 
-		getStructValue := func(outer any, field string) (any, bool) {
-			switch val := outer.(type) {
-			case map[string]any:
-				res, ok := val[field]
-				return res, ok
+func (t *oidcToken) getGroupsFromField(claims map[string]any, groupsField string) {
+	t.Groups = nil
+	groups, ok := getClaimValue(claims, groupsField)
+	if !ok {
+		return
+	}
+	switch v := groups.(type) {
+	case string:
+		if v != "" {
+			t.Groups = []string{v}
+		}
+	case []any:
+		for _, g := range v {
+			if val, ok := g.(string); ok && val != "" {
+				t.Groups = append(t.Groups, val)
 			}
-			return nil, false
 		}
+	}
+}
 
-		for idx, field := range strings.Split(roleField, ".") {
-			if idx == 0 {
-				role, ok = getStructValue(claims, field)
-			} else {
-				role, ok = getStructValue(role, field)
-			}
-			if !ok {
-				return
-			}
+// getClaimValue returns the value for the specified claims field, nested
+// fields can be specified using the dot notation
+func getClaimValue(claims map[string]any, field string) (any, bool) {
+	if field == "" {
+		return nil, false
+	}
+	val, ok := claims[field]
+	if ok {
+		return val, true
+	}
+	if !strings.Contains(field, ".") {
+		return nil, false
+	}
+
+	getStructValue := func(outer any, field string) (any, bool) {
+		switch val := outer.(type) {
+		case map[string]any:
+			res, ok := val[field]
+			return res, ok
 		}
+		return nil, false
+	}
 
-		t.Role = role
+	for idx, f := range strings.Split(field, ".") {
+		if idx == 0 {
+			val, ok = getStructValue(claims, f)
+		} else {
+			val, ok = getStructValue(val, f)
+		}
+		if !ok {
+			return nil, false
+		}
 	}
+	return val, true
 }
 
 func (t *oidcToken) isAdmin() bool {
@@ -380,6 +419,13 @@ func (t *oidcToken) refresh(config OAuth2Config, verifier OIDCTokenVerifier, r *
 	return nil
 }
 
+func (t *oidcToken) setAdminRestrictions(admin *dataprovider.Admin) {
+	claims := jwtTokenClaims{}
+	claims.setAdminRestrictions(admin)
+	t.HideFsConfig = claims.HideFsConfig
+	t.Grants = claims.Grants
+}
+
 func (t *oidcToken) refreshUser(r *http.Request) error {
 	if t.isAdmin() {
 		admin, err := dataprovider.AdminExists(t.Username)
@@ -391,7 +437,9 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 		}
 		t.Permissions = admin.Permissions
 		t.TokenRole = admin.Role
+		t.TokenTenant = admin.Filters.Tenant
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+		t.setAdminRestrictions(&admin)
 		return nil
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(t.Username, "")
@@ -409,22 +457,34 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 	return nil
 }
 
-func (t *oidcToken) getUser(r *http.Request) error {
+func (t *oidcToken) getUser(r *http.Request, config *OIDC) error {
 	if t.isAdmin() {
 		admin, err := dataprovider.AdminExists(t.Username)
 		if err != nil {
 			return err
 		}
+		if config.GroupsField != "" {
+			if err := dataprovider.SyncOIDCAdmin(&admin, t.Groups, config.RequireGroupMapping); err != nil {
+				return err
+			}
+		}
 		if err := admin.CanLogin(util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 			return err
 		}
 		t.Permissions = admin.Permissions
 		t.TokenRole = admin.Role
+		t.TokenTenant = admin.Filters.Tenant
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+		t.setAdminRestrictions(&admin)
 		dataprovider.UpdateAdminLastLogin(&admin)
 		return nil
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if config.GroupsField != "" {
+		if err := dataprovider.SyncOIDCUser(t.Username, t.Groups, config.RequireGroupMapping); err != nil {
+			return err
+		}
+	}
 	user, err := dataprovider.GetUserAfterIDPAuth(t.Username, ipAddr, common.ProtocolOIDC, t.CustomFields)
 	if err != nil {
 		return err
@@ -521,7 +581,10 @@ func (s *httpdServer) oidcTokenAuthenticator(audience tokenAudience) func(next h
 				Username:             token.Username,
 				Permissions:          token.Permissions,
 				Role:                 token.TokenRole,
+				Tenant:               token.TokenTenant,
 				HideUserPageSections: token.HideUserPageSections,
+				HideFsConfig:         token.HideFsConfig,
+				Grants:               token.Grants,
 			}
 			_, tokenString, err := jwtTokenClaims.createToken(s.tokenAuth, audience, util.GetIPFromRemoteAddress(r.RemoteAddr))
 			if err != nil {
@@ -643,7 +706,7 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 	if !oauth2Token.Expiry.IsZero() {
 		token.ExpiresAt = util.GetTimeAsMsSinceEpoch(oauth2Token.Expiry)
 	}
-	err = token.parseClaims(claims, s.binding.OIDC.UsernameField, s.binding.OIDC.RoleField,
+	err = token.parseClaims(claims, s.binding.OIDC.UsernameField, s.binding.OIDC.RoleField, s.binding.OIDC.GroupsField,
 		s.binding.OIDC.CustomFields, s.binding.OIDC.getForcedRole(authReq.Audience))
 	if err != nil {
 		logger.Debug(logSender, "", "unable to parse oidc token claims: %v", err)
@@ -670,7 +733,7 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
-	err = token.getUser(r)
+	err = token.getUser(r, &s.binding.OIDC)
 	if err != nil {
 		logger.Debug(logSender, "", "unable to get the sftpgo user associated with oidc token: %v", err)
 		setFlashMessage(w, r, "Unable to get the user associated with the OpenID token")
@@ -839,7 +839,7 @@ func TestOIDCToken(t *testing.T) {
 	}
 	req, err := http.NewRequest(http.MethodGet, webUsersPath, nil)
 	assert.NoError(t, err)
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "is disabled")
 	}
@@ -849,7 +849,7 @@ func TestOIDCToken(t *testing.T) {
 	username := "test_oidc_user"
 	token.Username = username
 	token.Role = ""
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.ErrorIs(t, err, util.ErrNotFound)
 	}
@@ -872,7 +872,7 @@ func TestOIDCToken(t *testing.T) {
 	}
 	err = dataprovider.AddUser(&user, "", "", "")
 	assert.NoError(t, err)
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "is disabled")
 	}
@@ -883,7 +883,7 @@ func TestOIDCToken(t *testing.T) {
 	err = dataprovider.UpdateUser(&user, "", "", "")
 	assert.NoError(t, err)
 
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "protocol HTTP is not allowed")
 	}
@@ -899,14 +899,14 @@ func TestOIDCToken(t *testing.T) {
 	}
 	err = dataprovider.UpdateUser(&user, "", "", "")
 	assert.NoError(t, err)
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "SFTP loop")
 	}
 
 	common.Config.PostConnectHook = fmt.Sprintf("http://%v/404", oidcMockAddr)
 
-	err = token.getUser(req)
+	err = token.getUser(req, &OIDC{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "access denied")
 	}
@@ -1321,6 +1321,210 @@ func TestParseAdminRole(t *testing.T) {
 	}
 }
 
+func TestOIDCGroupsField(t *testing.T) {
+	claims := map[string]any{
+		"groups": []any{"g1", 2, "", "g2"},
+		"group":  "g3",
+		"params": map[string]any{
+			"groups": []any{"g4"},
+		},
+	}
+	token := oidcToken{}
+	token.getGroupsFromField(claims, "groups")
+	assert.Equal(t, []string{"g1", "g2"}, token.Groups)
+	token.getGroupsFromField(claims, "group")
+	assert.Equal(t, []string{"g3"}, token.Groups)
+	token.getGroupsFromField(claims, "params.groups")
+	assert.Equal(t, []string{"g4"}, token.Groups)
+	token.getGroupsFromField(claims, "missing")
+	assert.Nil(t, token.Groups)
+	token.getGroupsFromField(claims, "")
+	assert.Nil(t, token.Groups)
+
+	claims["preferred_username"] = "user"
+	err := token.parseClaims(claims, "preferred_username", "", "groups", nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g1", "g2"}, token.Groups)
+}
+
+func TestOIDCGroupsSync(t *testing.T) {
+	role := dataprovider.Role{
+		Name: "oidc_role",
+	}
+	err := dataprovider.AddRole(&role, "", "", "")
+	assert.NoError(t, err)
+	group1 := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "oidc_group1",
+		},
+	}
+	err = dataprovider.AddGroup(&group1, "", "", "")
+	assert.NoError(t, err)
+	group2 := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "oidc_group2",
+		},
+	}
+	err = dataprovider.AddGroup(&group2, "", "", "")
+	assert.NoError(t, err)
+
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{{ClaimValue: " "}}, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{{ClaimValue: "c1"}}, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{
+		{
+			ClaimValue: "c1",
+			Groups: []sdk.GroupMapping{
+				{Name: group1.Name, Type: sdk.GroupTypePrimary},
+				{Name: group2.Name, Type: sdk.GroupTypePrimary},
+			},
+		},
+	}, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{
+		{
+			ClaimValue: "c1",
+			Groups:     []sdk.GroupMapping{{Name: group1.Name, Type: 4}},
+		},
+	}, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{
+		{ClaimValue: "c1", Role: role.Name},
+		{ClaimValue: "c1", Role: role.Name},
+	}, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.UpdateOIDCMappings([]dataprovider.OIDCMapping{
+		{
+			ClaimValue: "c1",
+			Groups:     []sdk.GroupMapping{{Name: group1.Name, Type: sdk.GroupTypePrimary}},
+			Role:       role.Name,
+		},
+		{
+			ClaimValue: "c2",
+			Groups: []sdk.GroupMapping{
+				{Name: group2.Name, Type: sdk.GroupTypePrimary},
+				{Name: group1.Name, Type: sdk.GroupTypeSecondary},
+			},
+		},
+	}, "", "", "")
+	assert.NoError(t, err)
+	mappings, err := dataprovider.GetOIDCMappings()
+	assert.NoError(t, err)
+	assert.Len(t, mappings, 2)
+
+	config := &OIDC{
+		GroupsField: "groups",
+	}
+	req, err := http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:1234"
+	username := "test_oidc_sync_user"
+	token := oidcToken{
+		Username: username,
+		Groups:   []string{"c2", "c1"},
+	}
+	// the users base dir is not set
+	err = token.getUser(req, config)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to create user")
+	}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "", "")
+	assert.NoError(t, err)
+	err = token.getUser(req, config)
+	assert.NoError(t, err)
+	assert.Equal(t, role.Name, token.TokenRole)
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, role.Name, user.Role)
+	if assert.Len(t, user.Groups, 2) {
+		for _, g := range user.Groups {
+			switch g.Name {
+			case group1.Name:
+				assert.Equal(t, sdk.GroupTypePrimary, g.Type)
+			case group2.Name:
+				assert.Equal(t, sdk.GroupTypeSecondary, g.Type)
+			default:
+				t.Errorf("unexpected group %q", g.Name)
+			}
+		}
+	}
+	// the mappings are evaluated in table order, nothing changes
+	token.Groups = []string{"c1", "c2"}
+	err = token.getUser(req, config)
+	assert.NoError(t, err)
+
+	token.Groups = []string{"c3"}
+	config.RequireGroupMapping = true
+	err = token.getUser(req, config)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not match any oidc mapping")
+	}
+	config.RequireGroupMapping = false
+	err = token.getUser(req, config)
+	assert.NoError(t, err)
+	assert.Empty(t, token.TokenRole)
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.Role)
+	assert.Len(t, user.Groups, 0)
+
+	admin := dataprovider.Admin{
+		Username:    "test_oidc_sync_admin",
+		Password:    "p",
+		Permissions: []string{dataprovider.PermAdminAddUsers, dataprovider.PermAdminChangeUsers},
+		Status:      1,
+	}
+	err = dataprovider.AddAdmin(&admin, "", "", "")
+	assert.NoError(t, err)
+	token = oidcToken{
+		Username: admin.Username,
+		Role:     "admin",
+		Groups:   []string{"c1"},
+	}
+	err = token.getUser(req, config)
+	assert.NoError(t, err)
+	assert.Equal(t, role.Name, token.TokenRole)
+	admin, err = dataprovider.AdminExists(admin.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, role.Name, admin.Role)
+	token.Groups = nil
+	config.RequireGroupMapping = true
+	err = token.getUser(req, config)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not match any oidc mapping")
+	}
+	config.RequireGroupMapping = false
+	err = token.getUser(req, config)
+	assert.NoError(t, err)
+	assert.Empty(t, token.TokenRole)
+
+	err = dataprovider.UpdateOIDCMappings(nil, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteAdmin(admin.Username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(group1.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(group2.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteRole(role.Name, "", "", "")
+	assert.NoError(t, err)
+}
+
 func TestOIDCWithLoginFormsDisabled(t *testing.T) {
 	oidcMgr, ok := oidcMgr.(*memoryOIDCManager)
 	require.True(t, ok)
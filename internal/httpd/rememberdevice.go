@@ -0,0 +1,258 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	rememberedDeviceCookieKey = "mfa_device"
+	rememberDeviceFormField   = "remember_device"
+	claimSecondFactor         = "fp"
+	claimDevicePassword       = "pw"
+	claimDeviceIP             = "ip"
+	claimDeviceUserAgent      = "ua"
+)
+
+var (
+	rememberedDevices         RememberedDevicesConfig
+	rememberedDeviceTokenAuth *jwtauth.JWTAuth
+)
+
+// rememberedDeviceOwner defines the account a browser is remembered for and the
+// policy to apply
+type rememberedDeviceOwner struct {
+	username string
+	// hashed password, a remembered browser is no longer valid if the password changes
+	password        string
+	days            int
+	bindToIP        bool
+	bindToUserAgent bool
+}
+
+func newRememberedDeviceOwner(audience tokenAudience, username, password string,
+	policy dataprovider.RememberedDevicesPolicy,
+) rememberedDeviceOwner {
+	owner := rememberedDeviceOwner{
+		username:        username,
+		password:        password,
+		days:            rememberedDevices.WebClientDays,
+		bindToIP:        rememberedDevices.BindToIP,
+		bindToUserAgent: rememberedDevices.BindToUserAgent,
+	}
+	if audience == tokenAudienceWebAdmin {
+		owner.days = rememberedDevices.WebAdminDays
+	}
+	switch {
+	case policy.Days < 0:
+		owner.days = 0
+	case policy.Days > 0:
+		owner.days = policy.Days
+	}
+	if policy.BindToIP > 0 {
+		owner.bindToIP = policy.BindToIP == 1
+	}
+	if policy.BindToUserAgent > 0 {
+		owner.bindToUserAgent = policy.BindToUserAgent == 1
+	}
+	return owner
+}
+
+// getUserRememberedDeviceOwner returns the remembered device owner for the
+// specified user, group settings must be already applied
+func getUserRememberedDeviceOwner(user *dataprovider.User) rememberedDeviceOwner {
+	return newRememberedDeviceOwner(tokenAudienceWebClient, user.Username, user.Password,
+		user.Filters.RememberedDevices)
+}
+
+func getAdminRememberedDeviceOwner(admin *dataprovider.Admin) rememberedDeviceOwner {
+	return newRememberedDeviceOwner(tokenAudienceWebAdmin, admin.Username, admin.Password,
+		admin.Filters.RememberedDevices)
+}
+
+func getRememberedDeviceCookiePath(audience tokenAudience) string {
+	if audience == tokenAudienceWebAdmin {
+		return webBaseAdminPath
+	}
+	return webBaseClientPath
+}
+
+func getFingerprint(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(h[:])
+}
+
+// getTOTPFingerprint returns an identifier for the TOTP secret, a remembered
+// device is no longer valid if the TOTP secret changes
+func getTOTPFingerprint(configName string, secret *kms.Secret) string {
+	if secret == nil {
+		return ""
+	}
+	if err := secret.TryDecrypt(); err != nil {
+		logger.Warn(logSender, "", "unable to decrypt TOTP secret: %v", err)
+		return ""
+	}
+	return getFingerprint("totp:" + configName + ":" + secret.GetPayload())
+}
+
+func getWebAuthnFingerprint(id []byte) string {
+	return getFingerprint("webauthn:" + encodeWebAuthnCredentialID(id))
+}
+
+// getUserSecondFactors returns the identifiers of the second factors that can
+// be used by the specified user to login to the WebClient
+func getUserSecondFactors(user *dataprovider.User) []string {
+	var result []string
+	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) &&
+		!user.MustUseWebAuthn() {
+		if fp := getTOTPFingerprint(user.Filters.TOTPConfig.ConfigName, user.Filters.TOTPConfig.Secret); fp != "" {
+			result = append(result, fp)
+		}
+	}
+	for _, c := range user.Filters.WebAuthnCredentials {
+		result = append(result, getWebAuthnFingerprint(c.ID))
+	}
+	return result
+}
+
+// getAdminSecondFactors returns the identifiers of the second factors that can
+// be used by the specified admin to login to the WebAdmin
+func getAdminSecondFactors(admin *dataprovider.Admin) []string {
+	var result []string
+	if admin.Filters.TOTPConfig.Enabled && !admin.MustUseWebAuthn() {
+		if fp := getTOTPFingerprint(admin.Filters.TOTPConfig.ConfigName, admin.Filters.TOTPConfig.Secret); fp != "" {
+			result = append(result, fp)
+		}
+	}
+	for _, c := range admin.Filters.WebAuthnCredentials {
+		result = append(result, getWebAuthnFingerprint(c.ID))
+	}
+	return result
+}
+
+// setRememberedDeviceCookie sets a signed cookie that allows to skip the second
+// factor authentication, for the specified account, until it expires. The cookie
+// is set only if requested and enabled for the account
+func setRememberedDeviceCookie(w http.ResponseWriter, r *http.Request, audience tokenAudience,
+	owner rememberedDeviceOwner, secondFactor, ip string,
+) {
+	if owner.days <= 0 || secondFactor == "" || r.Form.Get(rememberDeviceFormField) == "" {
+		return
+	}
+	duration := time.Duration(owner.days) * 24 * time.Hour
+	now := time.Now().UTC()
+	claims := map[string]any{
+		jwt.SubjectKey:      owner.username,
+		jwt.AudienceKey:     []string{tokenAudienceRememberedDevice, audience},
+		jwt.IssuedAtKey:     now,
+		jwt.ExpirationKey:   now.Add(duration),
+		claimSecondFactor:   secondFactor,
+		claimDevicePassword: getFingerprint("password:" + owner.password),
+	}
+	if owner.bindToIP {
+		claims[claimDeviceIP] = ip
+	}
+	if owner.bindToUserAgent {
+		claims[claimDeviceUserAgent] = getFingerprint(r.UserAgent())
+	}
+	_, tokenString, err := rememberedDeviceTokenAuth.Encode(claims)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to create remembered device token for %q: %v", owner.username, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberedDeviceCookieKey,
+		Value:    tokenString,
+		Path:     getRememberedDeviceCookiePath(audience),
+		Expires:  now.Add(duration),
+		MaxAge:   int(duration / time.Second),
+		HttpOnly: true,
+		Secure:   isTLS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	logger.Debug(logSender, "", "device remembered for %q, audience %q, days: %d", owner.username, audience,
+		owner.days)
+}
+
+// isRememberedDevice returns true if the request has a valid remembered device
+// cookie for the specified account and one of its current second factors
+func isRememberedDevice(r *http.Request, audience tokenAudience, owner rememberedDeviceOwner,
+	secondFactors []string, ip string,
+) bool {
+	if owner.days <= 0 || rememberedDeviceTokenAuth == nil || len(secondFactors) == 0 {
+		return false
+	}
+	cookie, err := r.Cookie(rememberedDeviceCookieKey)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	username := owner.username
+	token, err := jwtauth.VerifyToken(rememberedDeviceTokenAuth, cookie.Value)
+	if err != nil || token == nil {
+		logger.Debug(logSender, "", "invalid remembered device token for %q: %v", username, err)
+		return false
+	}
+	if !util.Contains(token.Audience(), tokenAudienceRememberedDevice) || !util.Contains(token.Audience(), audience) {
+		return false
+	}
+	if token.Subject() != username {
+		return false
+	}
+	if common.IsTokenRevoked(token.IssuedAt(), "") {
+		logger.Debug(logSender, "", "remembered device for %q was issued before the tokens revocation time", username)
+		return false
+	}
+	// the policy could be changed, the remembered browser cannot be trusted longer
+	// than currently allowed
+	if time.Since(token.IssuedAt()) > time.Duration(owner.days)*24*time.Hour {
+		logger.Debug(logSender, "", "remembered device for %q expired for the current policy", username)
+		return false
+	}
+	claims := token.PrivateClaims()
+	if secondFactor, ok := claims[claimSecondFactor].(string); !ok || !util.Contains(secondFactors, secondFactor) {
+		logger.Debug(logSender, "", "remembered device for %q refers to a second factor no longer available", username)
+		return false
+	}
+	if val, ok := claims[claimDevicePassword].(string); !ok || val != getFingerprint("password:"+owner.password) {
+		logger.Debug(logSender, "", "remembered device for %q refers to a password no longer valid", username)
+		return false
+	}
+	if owner.bindToIP {
+		if val, ok := claims[claimDeviceIP].(string); !ok || val != ip {
+			logger.Debug(logSender, "", "remembered device for %q used from a different IP %q", username, ip)
+			return false
+		}
+	}
+	if owner.bindToUserAgent {
+		if val, ok := claims[claimDeviceUserAgent].(string); !ok || val != getFingerprint(r.UserAgent()) {
+			logger.Debug(logSender, "", "remembered device for %q used with a different user agent", username)
+			return false
+		}
+	}
+	return true
+}
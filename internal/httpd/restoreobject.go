@@ -0,0 +1,407 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported object types for selective restores
+const (
+	RestoreObjectTypeUser   = "user"
+	RestoreObjectTypeGroup  = "group"
+	RestoreObjectTypeFolder = "folder"
+)
+
+// Supported restore actions
+const (
+	RestoreActionAdd    = "add"
+	RestoreActionUpdate = "update"
+	RestoreActionNone   = "none"
+)
+
+var (
+	restoreObjectTypes = []string{RestoreObjectTypeUser, RestoreObjectTypeGroup, RestoreObjectTypeFolder}
+	// fields updated at runtime, they are not restored or not relevant for the diff
+	restoreIgnoredUserFields = []string{"id", "password", "used_quota_size", "used_quota_files", "last_quota_update",
+		"used_upload_data_transfer", "used_download_data_transfer", "last_login", "created_at", "updated_at",
+		"first_download", "first_upload", "last_password_change"}
+	restoreIgnoredGroupFields  = []string{"id", "created_at", "updated_at", "users", "admins"}
+	restoreIgnoredFolderFields = []string{"id", "used_quota_size", "used_quota_files", "last_quota_update", "users",
+		"groups"}
+)
+
+// RestoreObjectChange defines a field that differs between the existing
+// object and the one to restore. Values are rendered as JSON
+type RestoreObjectChange struct {
+	Field    string `json:"field"`
+	Current  any    `json:"current,omitempty"`
+	Restored any    `json:"restored,omitempty"`
+}
+
+// RestoreObjectPreview describes what restoring a single object from a dump
+// changes. Confidential data are redacted
+type RestoreObjectPreview struct {
+	ObjectType string                `json:"object_type"`
+	ObjectName string                `json:"object_name"`
+	Action     string                `json:"action"`
+	Changes    []RestoreObjectChange `json:"changes,omitempty"`
+	Warnings   []string              `json:"warnings,omitempty"`
+	// referenced objects that must be restored first
+	missingDependencies []string
+}
+
+// PreviewRestoreObject returns the changes that restoring the object with the
+// specified type and name from the given dump would apply
+func PreviewRestoreObject(dump *dataprovider.BackupData, objectType, objectName string, mode int) (RestoreObjectPreview, error) {
+	preview := RestoreObjectPreview{
+		ObjectType: objectType,
+		ObjectName: objectName,
+	}
+	var err error
+	switch objectType {
+	case RestoreObjectTypeUser:
+		err = previewRestoreUser(dump, &preview)
+	case RestoreObjectTypeGroup:
+		err = previewRestoreGroup(dump, &preview)
+	case RestoreObjectTypeFolder:
+		err = previewRestoreFolder(dump, &preview)
+	default:
+		err = util.NewValidationError(fmt.Sprintf("unsupported object type %q, supported types: %s",
+			objectType, strings.Join(restoreObjectTypes, ", ")))
+	}
+	if err != nil {
+		return preview, err
+	}
+	if preview.Action == RestoreActionUpdate && mode == 1 {
+		preview.Action = RestoreActionNone
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%s %q exists and it is not updated in mode 1",
+			objectType, objectName))
+	}
+	return preview, nil
+}
+
+// RestoreObject restores the object with the specified type and name from the
+// given dump and returns the applied changes
+func RestoreObject(dump *dataprovider.BackupData, objectType, objectName, inputFile string, mode, scanQuota int,
+	executor, ipAddress, role string,
+) (RestoreObjectPreview, error) {
+	preview, err := PreviewRestoreObject(dump, objectType, objectName, mode)
+	if err != nil {
+		return preview, err
+	}
+	if len(preview.missingDependencies) > 0 && preview.Action != RestoreActionNone {
+		return preview, util.NewValidationError(fmt.Sprintf("unable to restore %s %q: %s", objectType, objectName,
+			strings.Join(preview.missingDependencies, ", ")))
+	}
+	switch objectType {
+	case RestoreObjectTypeUser:
+		user, _ := getDumpUser(dump, objectName)
+		err = RestoreUsers([]dataprovider.User{user}, inputFile, mode, scanQuota, executor, ipAddress, role)
+	case RestoreObjectTypeGroup:
+		group, _ := getDumpGroup(dump, objectName)
+		err = RestoreGroups([]dataprovider.Group{group}, inputFile, mode, executor, ipAddress, role)
+	case RestoreObjectTypeFolder:
+		folder, _ := getDumpFolder(dump, objectName)
+		err = RestoreFolders([]vfs.BaseVirtualFolder{folder}, inputFile, mode, scanQuota, executor, ipAddress, role)
+	}
+	if err != nil {
+		return preview, err
+	}
+	logger.Debug(logSender, "", "%s %q restored, action: %s, dump file: %q", objectType, objectName,
+		preview.Action, inputFile)
+	return preview, nil
+}
+
+func getDumpUser(dump *dataprovider.BackupData, username string) (dataprovider.User, error) {
+	for idx := range dump.Users {
+		if dump.Users[idx].Username == username {
+			return dump.Users[idx], nil
+		}
+	}
+	return dataprovider.User{}, util.NewRecordNotFoundError(fmt.Sprintf("user %q not found in the backup", username))
+}
+
+func getDumpGroup(dump *dataprovider.BackupData, name string) (dataprovider.Group, error) {
+	for idx := range dump.Groups {
+		if dump.Groups[idx].Name == name {
+			return dump.Groups[idx], nil
+		}
+	}
+	return dataprovider.Group{}, util.NewRecordNotFoundError(fmt.Sprintf("group %q not found in the backup", name))
+}
+
+func getDumpFolder(dump *dataprovider.BackupData, name string) (vfs.BaseVirtualFolder, error) {
+	for idx := range dump.Folders {
+		if dump.Folders[idx].Name == name {
+			return dump.Folders[idx], nil
+		}
+	}
+	return vfs.BaseVirtualFolder{}, util.NewRecordNotFoundError(fmt.Sprintf("folder %q not found in the backup", name))
+}
+
+func previewRestoreUser(dump *dataprovider.BackupData, preview *RestoreObjectPreview) error {
+	user, err := getDumpUser(dump, preview.ObjectName)
+	if err != nil {
+		return err
+	}
+	if user.Role != "" {
+		if _, err := dataprovider.RoleExists(user.Role); err != nil {
+			preview.missingDependencies = append(preview.missingDependencies, fmt.Sprintf("role %q does not exist", user.Role))
+		}
+	}
+	for _, g := range user.Groups {
+		if _, err := dataprovider.GroupExists(g.Name); err != nil {
+			preview.missingDependencies = append(preview.missingDependencies, fmt.Sprintf("group %q does not exist", g.Name))
+		}
+	}
+	preview.Warnings = append(preview.Warnings, preview.missingDependencies...)
+	current, err := dataprovider.UserExists(user.Username, "")
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			preview.Action = RestoreActionAdd
+			return nil
+		}
+		return err
+	}
+	preview.Action = RestoreActionUpdate
+	if current.Password != user.Password {
+		preview.Changes = append(preview.Changes, RestoreObjectChange{Field: "password"})
+	}
+	var currentCopy, restoredCopy dataprovider.User
+	if err := cloneForRestorePreview(&current, &currentCopy); err != nil {
+		return err
+	}
+	if err := cloneForRestorePreview(&user, &restoredCopy); err != nil {
+		return err
+	}
+	currentCopy.PrepareForRendering()
+	currentCopy.VirtualFolders = getVirtualFoldersForRestorePreview(currentCopy.VirtualFolders)
+	restoredCopy.PrepareForRendering()
+	restoredCopy.VirtualFolders = getVirtualFoldersForRestorePreview(restoredCopy.VirtualFolders)
+	return appendRestoreChanges(preview, &currentCopy, &restoredCopy, restoreIgnoredUserFields)
+}
+
+func previewRestoreGroup(dump *dataprovider.BackupData, preview *RestoreObjectPreview) error {
+	group, err := getDumpGroup(dump, preview.ObjectName)
+	if err != nil {
+		return err
+	}
+	current, err := dataprovider.GroupExists(group.Name)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			preview.Action = RestoreActionAdd
+			return nil
+		}
+		return err
+	}
+	preview.Action = RestoreActionUpdate
+	var currentCopy, restoredCopy dataprovider.Group
+	if err := cloneForRestorePreview(&current, &currentCopy); err != nil {
+		return err
+	}
+	if err := cloneForRestorePreview(&group, &restoredCopy); err != nil {
+		return err
+	}
+	currentCopy.PrepareForRendering()
+	currentCopy.VirtualFolders = getVirtualFoldersForRestorePreview(currentCopy.VirtualFolders)
+	restoredCopy.PrepareForRendering()
+	restoredCopy.VirtualFolders = getVirtualFoldersForRestorePreview(restoredCopy.VirtualFolders)
+	return appendRestoreChanges(preview, &currentCopy, &restoredCopy, restoreIgnoredGroupFields)
+}
+
+func previewRestoreFolder(dump *dataprovider.BackupData, preview *RestoreObjectPreview) error {
+	folder, err := getDumpFolder(dump, preview.ObjectName)
+	if err != nil {
+		return err
+	}
+	current, err := dataprovider.GetFolderByName(folder.Name)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			preview.Action = RestoreActionAdd
+			return nil
+		}
+		return err
+	}
+	preview.Action = RestoreActionUpdate
+	var currentCopy, restoredCopy vfs.BaseVirtualFolder
+	if err := cloneForRestorePreview(&current, &currentCopy); err != nil {
+		return err
+	}
+	if err := cloneForRestorePreview(&folder, &restoredCopy); err != nil {
+		return err
+	}
+	currentCopy.PrepareForRendering()
+	restoredCopy.PrepareForRendering()
+	return appendRestoreChanges(preview, &currentCopy, &restoredCopy, restoreIgnoredFolderFields)
+}
+
+// cloneForRestorePreview deep copies src into dst, so confidential data can be
+// hidden without modifying the objects to restore
+func cloneForRestorePreview(src, dst any) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// getVirtualFoldersForRestorePreview returns the virtual folders mapping
+// without the folders details, folders are restored separately
+func getVirtualFoldersForRestorePreview(folders []vfs.VirtualFolder) []vfs.VirtualFolder {
+	result := make([]vfs.VirtualFolder, 0, len(folders))
+	for _, folder := range folders {
+		result = append(result, vfs.VirtualFolder{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name: folder.Name,
+			},
+			VirtualPath:    folder.VirtualPath,
+			QuotaSize:      folder.QuotaSize,
+			QuotaFiles:     folder.QuotaFiles,
+			UserQuotaSize:  folder.UserQuotaSize,
+			UserQuotaFiles: folder.UserQuotaFiles,
+		})
+	}
+	return result
+}
+
+func appendRestoreChanges(preview *RestoreObjectPreview, current, restored any, ignoredFields []string) error {
+	currentFields, err := getFieldsForRestorePreview(current)
+	if err != nil {
+		return err
+	}
+	restoredFields, err := getFieldsForRestorePreview(restored)
+	if err != nil {
+		return err
+	}
+	var changes []RestoreObjectChange
+	diffRestoreFields("", currentFields, restoredFields, ignoredFields, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	preview.Changes = append(preview.Changes, changes...)
+	return nil
+}
+
+func getFieldsForRestorePreview(obj any) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// diffRestoreFields compares the specified fields recursively, nested objects
+// are reported using a dotted notation, lists are compared as a whole
+func diffRestoreFields(prefix string, current, restored map[string]any, ignoredFields []string,
+	changes *[]RestoreObjectChange,
+) {
+	keys := make(map[string]bool)
+	for k := range current {
+		keys[k] = true
+	}
+	for k := range restored {
+		keys[k] = true
+	}
+	for key := range keys {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+		if util.Contains(ignoredFields, field) {
+			continue
+		}
+		currentValue := current[key]
+		restoredValue := restored[key]
+		currentMap, isCurrentMap := currentValue.(map[string]any)
+		restoredMap, isRestoredMap := restoredValue.(map[string]any)
+		if isCurrentMap && isRestoredMap {
+			diffRestoreFields(field, currentMap, restoredMap, ignoredFields, changes)
+			continue
+		}
+		if !reflect.DeepEqual(currentValue, restoredValue) {
+			*changes = append(*changes, RestoreObjectChange{
+				Field:    field,
+				Current:  currentValue,
+				Restored: restoredValue,
+			})
+		}
+	}
+}
+
+func getLoaddataObjectOptions(r *http.Request) (string, string, bool, error) {
+	objectType := strings.TrimSpace(r.URL.Query().Get("object-type"))
+	objectName := strings.TrimSpace(r.URL.Query().Get("object-name"))
+	var preview bool
+	if val := r.URL.Query().Get("preview"); val != "" {
+		var err error
+		preview, err = strconv.ParseBool(val)
+		if err != nil {
+			return objectType, objectName, preview, fmt.Errorf("invalid preview: %v", err)
+		}
+	}
+	if objectType == "" {
+		if objectName != "" || preview {
+			return objectType, objectName, preview, errors.New("object-type is required to restore a single object")
+		}
+		return objectType, objectName, preview, nil
+	}
+	if !util.Contains(restoreObjectTypes, objectType) {
+		return objectType, objectName, preview, fmt.Errorf("invalid object-type %q", objectType)
+	}
+	if objectName == "" {
+		return objectType, objectName, preview, errors.New("object-name is required to restore a single object")
+	}
+	return objectType, objectName, preview, nil
+}
+
+func restoreObjectFromBackup(w http.ResponseWriter, r *http.Request, content []byte, inputFile string,
+	scanQuota, mode int, objectType, objectName string, preview bool, claims jwtTokenClaims,
+) {
+	dump, err := dataprovider.ParseDumpData(content)
+	if err != nil {
+		err = util.NewValidationError(fmt.Sprintf("unable to parse backup content: %v", err))
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	var result RestoreObjectPreview
+	if preview {
+		result, err = PreviewRestoreObject(&dump, objectType, objectName, mode)
+	} else {
+		result, err = RestoreObject(&dump, objectType, objectName, inputFile, mode, scanQuota, claims.Username,
+			util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
@@ -40,6 +40,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/acme"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
@@ -65,6 +66,8 @@ type httpdServer struct {
 	tokenAuth         *jwtauth.JWTAuth
 	signingPassphrase string
 	cors              CorsConfig
+	virtualHosts      map[string]*httpdServer
+	allowedRoles      []string
 }
 
 func newHttpdServer(b Binding, staticFilesPath, signingPassphrase string, cors CorsConfig,
@@ -90,10 +93,50 @@ func (s *httpdServer) setShared(value int) {
 	s.isShared = value
 }
 
-func (s *httpdServer) listenAndServe() error {
+// addVirtualHosts creates a server, sharing the binding listener, for each
+// configured virtual host
+func (s *httpdServer) addVirtualHosts() error {
+	s.virtualHosts = make(map[string]*httpdServer)
+	for idx := range s.binding.VirtualHosts {
+		vh := &s.binding.VirtualHosts[idx]
+		b := s.binding.getVirtualHostBinding(vh)
+		if err := b.OIDC.initialize(); err != nil {
+			return fmt.Errorf("unable to initialize OIDC for virtual host %q: %w", vh.Host, err)
+		}
+		if err := b.checkLoginMethods(); err != nil {
+			return fmt.Errorf("virtual host %q: %w", vh.Host, err)
+		}
+		server := newHttpdServer(b, s.staticFilesPath, s.signingPassphrase, s.cors, s.openAPIPath)
+		server.setShared(s.isShared)
+		server.allowedRoles = vh.AllowedRoles
+		s.virtualHosts[strings.ToLower(vh.Host)] = server
+	}
+	return nil
+}
+
+func (s *httpdServer) initializeRouters() {
 	s.initializeRouter()
+	for _, server := range s.virtualHosts {
+		server.initializeRouter()
+	}
+}
+
+// ServeHTTP dispatches the request to the matching virtual host, if any
+func (s *httpdServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if server, ok := s.virtualHosts[getRequestHost(r)]; ok {
+		if len(server.allowedRoles) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), allowedRolesKey, server.allowedRoles))
+		}
+		server.router.ServeHTTP(w, r)
+		return
+	}
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *httpdServer) listenAndServe() error {
+	s.initializeRouters()
 	httpServer := &http.Server{
-		Handler:           s.router,
+		Handler:           s,
 		ReadHeaderTimeout: 30 * time.Second,
 		ReadTimeout:       60 * time.Second,
 		WriteTimeout:      60 * time.Second,
@@ -107,7 +150,7 @@ func (s *httpdServer) listenAndServe() error {
 			certID = s.binding.GetAddress()
 		}
 		config := &tls.Config{
-			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			GetCertificate:           certMgr.GetCertificateFuncForHosts(certID, s.binding.getVirtualHostsCertIDs()),
 			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
 			NextProtos:               []string{"http/1.1", "h2"},
 			CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
@@ -121,6 +164,9 @@ func (s *httpdServer) listenAndServe() error {
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
+		if s.binding.EnableHTTP3 {
+			return util.HTTPListenAndServeWithHTTP3(httpServer, s.binding.Address, s.binding.Port, logSender)
+		}
 		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
 	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
@@ -139,11 +185,7 @@ func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
 			return errors.New("TLS connection cannot be verified: unable to get verification chain")
 		}
 		for _, verifiedChain := range state.VerifiedChains {
-			var caCrt *x509.Certificate
-			if len(verifiedChain) > 0 {
-				caCrt = verifiedChain[len(verifiedChain)-1]
-			}
-			if certMgr.IsRevoked(clientCrt, caCrt) {
+			if certMgr.IsChainRevoked(verifiedChain) {
 				logger.Debug(logSender, "", "tls handshake error, client certificate %q has been revoked", clientCrtName)
 				return common.ErrCrtRevoked
 			}
@@ -169,6 +211,7 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, error, ip str
 		StaticURL:    webStaticFilesPath,
 		Branding:     s.binding.Branding.WebClient,
 		FormDisabled: s.binding.isWebClientLoginFormDisabled(),
+		Captcha:      loginProtection.getCaptchaData(ip),
 	}
 	if s.binding.showAdminLoginURL() {
 		data.AltLoginURL = webAdminLoginPath
@@ -180,6 +223,9 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, error, ip str
 	if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
 		data.OpenIDLoginURL = webClientOIDCLoginPath
 	}
+	if s.binding.KerberosService != "" {
+		data.KerberosLoginURL = webClientKerberosLoginPath
+	}
 	renderClientTemplate(w, templateClientLogin, data)
 }
 
@@ -244,6 +290,10 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		s.renderClientLoginPage(w, err.Error(), ipAddr)
 		return
 	}
+	if err := checkLoginProtection(r, ipAddr, username); err != nil {
+		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		return
+	}
 
 	if err := common.Config.ExecutePostConnectHook(ipAddr, protocol); err != nil {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
@@ -252,12 +302,14 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol)
+	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol, s.binding.LDAPDirectory)
 	if err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+		onLoginFailure(ipAddr, username)
 		s.renderClientLoginPage(w, dataprovider.ErrInvalidCredentials.Error(), ipAddr)
 		return
 	}
+	onLoginSuccess(username)
 	connectionID := fmt.Sprintf("%v_%v", protocol, xid.New().String())
 	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
@@ -289,11 +341,17 @@ func (s *httpdServer) handleWebClientPasswordResetPost(w http.ResponseWriter, r
 		s.renderClientForbiddenPage(w, r, err.Error())
 		return
 	}
+	if err := checkLoginProtection(r, ipAddr, ""); err != nil {
+		s.renderClientResetPwdPage(w, err.Error(), ipAddr)
+		return
+	}
 	_, user, err := handleResetPassword(r, r.Form.Get("code"), r.Form.Get("password"), false)
 	if err != nil {
+		onLoginFailure(ipAddr, "")
 		s.renderClientResetPwdPage(w, err.Error(), ipAddr)
 		return
 	}
+	onLoginSuccess(user.Username)
 	connectionID := fmt.Sprintf("%v_%v", getProtocolFromRequest(r), xid.New().String())
 	if err := checkHTTPClientUser(user, r, connectionID, true); err != nil {
 		s.renderClientResetPwdPage(w, fmt.Sprintf("Password reset successfully but unable to login: %v", err.Error()), ipAddr)
@@ -340,8 +398,9 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 		s.renderClientTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
 		return
 	}
-	if !userMerged.Filters.TOTPConfig.Enabled || !util.Contains(userMerged.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
-		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+	if (!userMerged.Filters.TOTPConfig.Enabled || !util.Contains(userMerged.Filters.TOTPConfig.Protocols, common.ProtocolHTTP)) &&
+		!userMerged.HasWebAuthnCredentials() {
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
 	for idx, code := range user.Filters.RecoveryCodes {
@@ -380,7 +439,7 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderClientTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
@@ -388,25 +447,30 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 	if username == "" || passcode == "" {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, common.ErrNoCredentials)
-		s.renderClientTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, err)
-		s.renderClientTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(username, "")
 	if err != nil {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, err)
-		s.renderClientTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
-		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
+		return
+	}
+	if user.MustUseWebAuthn() {
+		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+		s.renderClientTwoFactorPage(w, r, errWebAuthnKeyRequired.Error(), ipAddr)
 		return
 	}
 	err = user.Filters.TOTPConfig.Secret.Decrypt()
@@ -419,11 +483,13 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 		user.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
-		s.renderClientTwoFactorPage(w, "Invalid authentication code", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
 		return
 	}
+	setRememberedDeviceCookie(w, r, tokenAudienceWebClient, getUserRememberedDeviceOwner(&user),
+		getTOTPFingerprint(user.Filters.TOTPConfig.ConfigName, user.Filters.TOTPConfig.Secret), ipAddr)
 	connectionID := fmt.Sprintf("%s_%s", getProtocolFromRequest(r), xid.New().String())
-	s.loginUser(w, r, &user, connectionID, ipAddr, true, s.renderClientTwoFactorPage)
+	s.loginUser(w, r, &user, connectionID, ipAddr, true, s.getClientTwoFactorErrorFunc(r))
 }
 
 func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
@@ -457,7 +523,7 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 		s.renderTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
 		return
 	}
-	if !admin.Filters.TOTPConfig.Enabled {
+	if !admin.Filters.TOTPConfig.Enabled && !admin.HasWebAuthnCredentials() {
 		s.renderTwoFactorRecoveryPage(w, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
@@ -495,18 +561,18 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
 	passcode := r.Form.Get("passcode")
 	if username == "" || passcode == "" {
-		s.renderTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
 		err = handleDefenderEventLoginFailed(ipAddr, err)
-		s.renderTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	admin, err := dataprovider.AdminExists(username)
@@ -514,11 +580,16 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 		if errors.Is(err, util.ErrNotFound) {
 			handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
 		}
-		s.renderTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if !admin.Filters.TOTPConfig.Enabled {
-		s.renderTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+		s.renderTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
+		return
+	}
+	if admin.MustUseWebAuthn() {
+		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+		s.renderTwoFactorPage(w, r, errWebAuthnKeyRequired.Error(), ipAddr)
 		return
 	}
 	err = admin.Filters.TOTPConfig.Secret.Decrypt()
@@ -530,10 +601,347 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 		admin.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
 		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
-		s.renderTwoFactorPage(w, "Invalid authentication code", ipAddr)
+		s.renderTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
+		return
+	}
+	setRememberedDeviceCookie(w, r, tokenAudienceWebAdmin, getAdminRememberedDeviceOwner(&admin),
+		getTOTPFingerprint(admin.Filters.TOTPConfig.ConfigName, admin.Filters.TOTPConfig.Secret), ipAddr)
+	s.loginAdmin(w, r, &admin, true, s.getTwoFactorErrorFunc(r), ipAddr)
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthnBegin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, nil, "Invalid credentials", http.StatusBadRequest)
+		return
+	}
+	if !user.HasWebAuthnCredentials() {
+		sendAPIResponse(w, r, nil, "No security key registered", http.StatusBadRequest)
+		return
+	}
+	assertion, err := startWebAuthnLogin(user.Username, false, user.Filters.WebAuthnCredentials)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to start the security key authentication", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, assertion)
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthnPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		s.renderNotFoundPage(w, r, nil)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	username := claims.Username
+	credential := r.Form.Get("credential")
+	if username == "" || credential == "" {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, common.ErrNoCredentials)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, err)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	user, userMerged, err := dataprovider.GetUserVariants(username, "")
+	if err != nil {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, err)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !user.HasWebAuthnCredentials() {
+		updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		s.renderClientTwoFactorPage(w, r, "No security key registered", ipAddr)
 		return
 	}
-	s.loginAdmin(w, r, &admin, true, s.renderTwoFactorPage, ipAddr)
+	credentialID, err := checkWebAuthnLogin(user.Username, false, user.Filters.WebAuthnCredentials, credential)
+	if err != nil {
+		updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+		s.renderClientTwoFactorPage(w, r, "Security key authentication failed", ipAddr)
+		return
+	}
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr, user.Role); err != nil {
+		logger.Warn(logSender, "", "unable to update the security key usage for user %q: %v", user.Username, err)
+	}
+	userMerged.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
+	setRememberedDeviceCookie(w, r, tokenAudienceWebClient, getUserRememberedDeviceOwner(&userMerged),
+		getWebAuthnFingerprint(credentialID), ipAddr)
+	connectionID := fmt.Sprintf("%s_%s", getProtocolFromRequest(r), xid.New().String())
+	s.loginUser(w, r, &userMerged, connectionID, ipAddr, true, s.getClientTwoFactorErrorFunc(r))
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, nil, "Invalid credentials", http.StatusBadRequest)
+		return
+	}
+	if !user.MustUseWebAuthn() || user.HasWebAuthnCredentials() {
+		sendAPIResponse(w, r, nil, "Security key registration is not allowed here", http.StatusForbidden)
+		return
+	}
+	creation, err := startWebAuthnRegistration(user.Username, false, user.Filters.WebAuthnCredentials)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to start the security key registration", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, creation)
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthnRegisterPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		s.renderNotFoundPage(w, r, nil)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	username := claims.Username
+	req := webAuthnRegistrationRequest{
+		Name:       r.Form.Get("name"),
+		Credential: []byte(r.Form.Get("credential")),
+	}
+	if username == "" || len(req.Credential) == 0 {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, common.ErrNoCredentials)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, err)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	user, userMerged, err := dataprovider.GetUserVariants(username, "")
+	if err != nil {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
+			dataprovider.LoginMethodPassword, ipAddr, err)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !userMerged.MustUseWebAuthn() || userMerged.HasWebAuthnCredentials() {
+		updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		s.renderClientTwoFactorPage(w, r, "Security key registration is not allowed here", ipAddr)
+		return
+	}
+	if userMerged.Filters.TOTPConfig.Enabled && util.Contains(userMerged.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
+		if err := checkTOTPPasscode(userMerged.Filters.TOTPConfig.ConfigName,
+			userMerged.Filters.TOTPConfig.Secret, r.Form.Get("passcode")); err != nil {
+			updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+			s.renderClientTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
+			return
+		}
+	}
+	credential, err := completeWebAuthnRegistration(user.Username, false, user.Filters.WebAuthnCredentials, &req)
+	if err != nil {
+		updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	user.Filters.WebAuthnCredentials = append(user.Filters.WebAuthnCredentials, credential)
+	if user.CountUnusedRecoveryCodes() < 5 {
+		user.Filters.RecoveryCodes = generateAccountRecoveryCodes()
+	}
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr, user.Role); err != nil {
+		updateLoginMetrics(&userMerged, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		s.renderClientInternalServerErrorPage(w, r, err)
+		return
+	}
+	userMerged.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
+	setRememberedDeviceCookie(w, r, tokenAudienceWebClient, getUserRememberedDeviceOwner(&userMerged),
+		getWebAuthnFingerprint(credential.ID), ipAddr)
+	connectionID := fmt.Sprintf("%s_%s", getProtocolFromRequest(r), xid.New().String())
+	s.loginUser(w, r, &userMerged, connectionID, ipAddr, true, s.getClientTwoFactorErrorFunc(r))
+}
+
+func (s *httpdServer) handleWebAdminTwoFactorWebAuthnBegin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, nil, "Invalid credentials", http.StatusBadRequest)
+		return
+	}
+	if !admin.HasWebAuthnCredentials() {
+		sendAPIResponse(w, r, nil, "No security key registered", http.StatusBadRequest)
+		return
+	}
+	assertion, err := startWebAuthnLogin(admin.Username, true, admin.Filters.WebAuthnCredentials)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to start the security key authentication", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, assertion)
+}
+
+func (s *httpdServer) handleWebAdminTwoFactorWebAuthnPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		s.renderNotFoundPage(w, r, nil)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	username := claims.Username
+	credential := r.Form.Get("credential")
+	if username == "" || credential == "" {
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		err = handleDefenderEventLoginFailed(ipAddr, err)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		}
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !admin.HasWebAuthnCredentials() {
+		s.renderTwoFactorPage(w, r, "No security key registered", ipAddr)
+		return
+	}
+	credentialID, err := checkWebAuthnLogin(admin.Username, true, admin.Filters.WebAuthnCredentials, credential)
+	if err != nil {
+		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+		s.renderTwoFactorPage(w, r, "Security key authentication failed", ipAddr)
+		return
+	}
+	if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr, admin.Role); err != nil {
+		logger.Warn(logSender, "", "unable to update the security key usage for admin %q: %v", admin.Username, err)
+	}
+	setRememberedDeviceCookie(w, r, tokenAudienceWebAdmin, getAdminRememberedDeviceOwner(&admin),
+		getWebAuthnFingerprint(credentialID), ipAddr)
+	s.loginAdmin(w, r, &admin, true, s.getTwoFactorErrorFunc(r), ipAddr)
+}
+
+func (s *httpdServer) handleWebAdminTwoFactorWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, nil, "Invalid credentials", http.StatusBadRequest)
+		return
+	}
+	if !admin.MustUseWebAuthn() || admin.HasWebAuthnCredentials() {
+		sendAPIResponse(w, r, nil, "Security key registration is not allowed here", http.StatusForbidden)
+		return
+	}
+	creation, err := startWebAuthnRegistration(admin.Username, true, admin.Filters.WebAuthnCredentials)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to start the security key registration", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, creation)
+}
+
+func (s *httpdServer) handleWebAdminTwoFactorWebAuthnRegisterPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		s.renderNotFoundPage(w, r, nil)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	username := claims.Username
+	req := webAuthnRegistrationRequest{
+		Name:       r.Form.Get("name"),
+		Credential: []byte(r.Form.Get("credential")),
+	}
+	if username == "" || len(req.Credential) == 0 {
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		err = handleDefenderEventLoginFailed(ipAddr, err)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		}
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !admin.MustUseWebAuthn() || admin.HasWebAuthnCredentials() {
+		s.renderTwoFactorPage(w, r, "Security key registration is not allowed here", ipAddr)
+		return
+	}
+	if admin.Filters.TOTPConfig.Enabled {
+		if err := checkTOTPPasscode(admin.Filters.TOTPConfig.ConfigName,
+			admin.Filters.TOTPConfig.Secret, r.Form.Get("passcode")); err != nil {
+			handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+			s.renderTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
+			return
+		}
+	}
+	credential, err := completeWebAuthnRegistration(admin.Username, true, admin.Filters.WebAuthnCredentials, &req)
+	if err != nil {
+		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	admin.Filters.WebAuthnCredentials = append(admin.Filters.WebAuthnCredentials, credential)
+	if admin.CountUnusedRecoveryCodes() < 5 {
+		admin.Filters.RecoveryCodes = generateAccountRecoveryCodes()
+	}
+	if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr, admin.Role); err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	setRememberedDeviceCookie(w, r, tokenAudienceWebAdmin, getAdminRememberedDeviceOwner(&admin),
+		getWebAuthnFingerprint(credential.ID), ipAddr)
+	s.loginAdmin(w, r, &admin, true, s.getTwoFactorErrorFunc(r), ipAddr)
 }
 
 func (s *httpdServer) handleWebAdminLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -554,12 +962,18 @@ func (s *httpdServer) handleWebAdminLoginPost(w http.ResponseWriter, r *http.Req
 		s.renderAdminLoginPage(w, err.Error(), ipAddr)
 		return
 	}
+	if err := checkLoginProtection(r, ipAddr, username); err != nil {
+		s.renderAdminLoginPage(w, err.Error(), ipAddr)
+		return
+	}
 	admin, err := dataprovider.CheckAdminAndPass(username, password, ipAddr)
 	if err != nil {
 		err = handleDefenderEventLoginFailed(ipAddr, err)
+		onLoginFailure(ipAddr, username)
 		s.renderAdminLoginPage(w, err.Error(), ipAddr)
 		return
 	}
+	onLoginSuccess(username)
 	s.loginAdmin(w, r, &admin, false, s.renderAdminLoginPage, ipAddr)
 }
 
@@ -572,6 +986,7 @@ func (s *httpdServer) renderAdminLoginPage(w http.ResponseWriter, error, ip stri
 		StaticURL:    webStaticFilesPath,
 		Branding:     s.binding.Branding.WebAdmin,
 		FormDisabled: s.binding.isWebAdminLoginFormDisabled(),
+		Captcha:      loginProtection.getCaptchaData(ip),
 	}
 	if s.binding.showClientLoginURL() {
 		data.AltLoginURL = webClientLoginPath
@@ -637,8 +1052,13 @@ func (s *httpdServer) handleWebAdminPasswordResetPost(w http.ResponseWriter, r *
 		s.renderForbiddenPage(w, r, err.Error())
 		return
 	}
+	if err := checkLoginProtection(r, ipAddr, ""); err != nil {
+		s.renderResetPwdPage(w, err.Error(), ipAddr)
+		return
+	}
 	admin, _, err := handleResetPassword(r, r.Form.Get("code"), r.Form.Get("password"), true)
 	if err != nil {
+		onLoginFailure(ipAddr, "")
 		if e, ok := err.(*util.ValidationError); ok {
 			s.renderResetPwdPage(w, e.GetErrorString(), ipAddr)
 			return
@@ -646,6 +1066,7 @@ func (s *httpdServer) handleWebAdminPasswordResetPost(w http.ResponseWriter, r *
 		s.renderResetPwdPage(w, err.Error(), ipAddr)
 		return
 	}
+	onLoginSuccess(admin.Username)
 
 	s.loginAdmin(w, r, admin, false, s.renderResetPwdPage, ipAddr)
 }
@@ -704,6 +1125,7 @@ func (s *httpdServer) loginUser(
 	w http.ResponseWriter, r *http.Request, user *dataprovider.User, connectionID, ipAddr string,
 	isSecondFactorAuth bool, errorFunc func(w http.ResponseWriter, error, ip string),
 ) {
+	loginMethod := getHTTPClientLoginMethod(r)
 	c := jwtTokenClaims{
 		Username:                   user.Username,
 		Permissions:                user.Filters.WebClient,
@@ -713,17 +1135,22 @@ func (s *httpdServer) loginUser(
 		MustChangePassword:         user.MustChangePassword(),
 		RequiredTwoFactorProtocols: user.Filters.TwoFactorAuthProtocols,
 	}
+	if loginMethod != dataprovider.LoginMethodPassword {
+		c.LoginMethod = loginMethod
+	}
 
 	audience := tokenAudienceWebClient
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) &&
-		user.CanManageMFA() && !isSecondFactorAuth {
+	if (user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) ||
+		user.HasWebAuthnCredentials() || user.MustUseWebAuthn()) && user.CanManageMFA() && !isSecondFactorAuth &&
+		!isRememberedDevice(r, tokenAudienceWebClient, getUserRememberedDeviceOwner(user), getUserSecondFactors(user),
+			ipAddr) {
 		audience = tokenAudienceWebClientPartial
 	}
 
 	err := c.createAndSetCookie(w, r, s.tokenAuth, audience, ipAddr)
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to set user login cookie %v", err)
-		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		updateLoginMetrics(user, loginMethod, ipAddr, common.ErrInternalFailure)
 		errorFunc(w, err.Error(), ipAddr)
 		return
 	}
@@ -734,7 +1161,7 @@ func (s *httpdServer) loginUser(
 		http.Redirect(w, r, webClientTwoFactorPath, http.StatusFound)
 		return
 	}
-	updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, err)
+	updateLoginMetrics(user, loginMethod, ipAddr, err)
 	dataprovider.UpdateLastLogin(user)
 	http.Redirect(w, r, webClientFilesPath, http.StatusFound)
 }
@@ -748,12 +1175,17 @@ func (s *httpdServer) loginAdmin(
 		Username:             admin.Username,
 		Permissions:          admin.Permissions,
 		Role:                 admin.Role,
+		Tenant:               admin.Filters.Tenant,
 		Signature:            admin.GetSignature(),
 		HideUserPageSections: admin.Filters.Preferences.HideUserPageSections,
 	}
+	c.setAdminRestrictions(admin)
 
 	audience := tokenAudienceWebAdmin
-	if admin.Filters.TOTPConfig.Enabled && admin.CanManageMFA() && !isSecondFactorAuth {
+	if (admin.Filters.TOTPConfig.Enabled || admin.HasWebAuthnCredentials() || admin.MustUseWebAuthn()) &&
+		admin.CanManageMFA() && !isSecondFactorAuth &&
+		!isRememberedDevice(r, tokenAudienceWebAdmin, getAdminRememberedDeviceOwner(admin), getAdminSecondFactors(admin),
+			ipAddr) {
 		audience = tokenAudienceWebAdminPartial
 	}
 
@@ -787,12 +1219,26 @@ func (s *httpdServer) logout(w http.ResponseWriter, r *http.Request) {
 func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if s.isKerberosRequest(r) {
+		user, r, err := s.authenticateKerberos(w, r, ipAddr)
+		if err != nil {
+			w.Header().Set(common.HTTPAuthenticationHeader, kerberos.NegotiateScheme)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+		s.checkAndSendUserToken(w, r, ipAddr, user)
+		return
+	}
 	username, password, ok := r.BasicAuth()
 	protocol := common.ProtocolHTTP
 	if !ok {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, common.ErrNoCredentials)
-		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		if s.binding.KerberosService != "" {
+			w.Header().Set(common.HTTPAuthenticationHeader, kerberos.NegotiateScheme)
+		}
+		w.Header().Add(common.HTTPAuthenticationHeader, basicRealm)
 		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -809,7 +1255,7 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
-	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol)
+	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol, s.binding.LDAPDirectory)
 	if err != nil {
 		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
@@ -817,26 +1263,44 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 			http.StatusUnauthorized)
 		return
 	}
-	connectionID := fmt.Sprintf("%v_%v", protocol, xid.New().String())
+	s.checkAndSendUserToken(w, r, ipAddr, user)
+}
+
+// checkAndSendUserToken checks the login conditions and the TOTP passcode, if
+// required, for an authenticated user and sends the REST API token
+func (s *httpdServer) checkAndSendUserToken(w http.ResponseWriter, r *http.Request, ipAddr string, user dataprovider.User) {
+	loginMethod := getHTTPClientLoginMethod(r)
+	authHeader := basicRealm
+	if loginMethod == dataprovider.LoginMethodKerberos {
+		authHeader = kerberos.NegotiateScheme
+	}
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, xid.New().String())
 	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
-		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+		updateLoginMetrics(&user, loginMethod, ipAddr, err)
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
 
+	if user.HasWebAuthnCredentials() && user.CanManageMFA() &&
+		(!user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP)) {
+		logger.Debug(logSender, "", "security keys registered for user %q and TOTP not enabled, authentication refused", user.Username)
+		updateLoginMetrics(&user, loginMethod, ipAddr, dataprovider.ErrInvalidCredentials)
+		sendAPIResponse(w, r, errWebAuthnOnlyWebUI, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
 		passcode := r.Header.Get(otpHeaderCode)
 		if passcode == "" {
 			logger.Debug(logSender, "", "TOTP enabled for user %q and not passcode provided, authentication refused", user.Username)
-			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
-			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+			w.Header().Set(common.HTTPAuthenticationHeader, authHeader)
+			updateLoginMetrics(&user, loginMethod, ipAddr, dataprovider.ErrInvalidCredentials)
 			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
 		}
-		err = user.Filters.TOTPConfig.Secret.Decrypt()
+		err := user.Filters.TOTPConfig.Secret.Decrypt()
 		if err != nil {
-			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+			updateLoginMetrics(&user, loginMethod, ipAddr, common.ErrInternalFailure)
 			sendAPIResponse(w, r, fmt.Errorf("unable to decrypt TOTP secret: %w", err), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
@@ -844,8 +1308,8 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 			user.Filters.TOTPConfig.Secret.GetPayload())
 		if !match || err != nil {
 			logger.Debug(logSender, "invalid passcode for user %q, match? %v, err: %v", user.Username, match, err)
-			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
-			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+			w.Header().Set(common.HTTPAuthenticationHeader, authHeader)
+			updateLoginMetrics(&user, loginMethod, ipAddr, dataprovider.ErrInvalidCredentials)
 			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
@@ -853,10 +1317,10 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defer user.CloseFs() //nolint:errcheck
-	err = user.CheckFsRoot(connectionID)
+	err := user.CheckFsRoot(connectionID)
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
-		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		updateLoginMetrics(&user, loginMethod, ipAddr, common.ErrInternalFailure)
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -865,6 +1329,7 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *httpdServer) generateAndSendUserToken(w http.ResponseWriter, r *http.Request, ipAddr string, user dataprovider.User) {
+	loginMethod := getHTTPClientLoginMethod(r)
 	c := jwtTokenClaims{
 		Username:                   user.Username,
 		Permissions:                user.Filters.WebClient,
@@ -874,14 +1339,17 @@ func (s *httpdServer) generateAndSendUserToken(w http.ResponseWriter, r *http.Re
 		MustChangePassword:         user.MustChangePassword(),
 		RequiredTwoFactorProtocols: user.Filters.TwoFactorAuthProtocols,
 	}
+	if loginMethod != dataprovider.LoginMethodPassword {
+		c.LoginMethod = loginMethod
+	}
 
 	resp, err := c.createTokenResponse(s.tokenAuth, tokenAudienceAPIUser, ipAddr)
 	if err != nil {
-		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		updateLoginMetrics(&user, loginMethod, ipAddr, common.ErrInternalFailure)
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+	updateLoginMetrics(&user, loginMethod, ipAddr, err)
 	dataprovider.UpdateLastLogin(&user)
 
 	render.JSON(w, r, resp)
@@ -902,6 +1370,12 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if admin.HasWebAuthnCredentials() && !admin.Filters.TOTPConfig.Enabled {
+		logger.Debug(logSender, "", "security keys registered for admin %q and TOTP not enabled, authentication refused", admin.Username)
+		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+		sendAPIResponse(w, r, errWebAuthnOnlyWebUI, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if admin.Filters.TOTPConfig.Enabled {
 		passcode := r.Header.Get(otpHeaderCode)
 		if passcode == "" {
@@ -937,8 +1411,10 @@ func (s *httpdServer) generateAndSendToken(w http.ResponseWriter, r *http.Reques
 		Username:    admin.Username,
 		Permissions: admin.Permissions,
 		Role:        admin.Role,
+		Tenant:      admin.Filters.Tenant,
 		Signature:   admin.GetSignature(),
 	}
+	c.setAdminRestrictions(&admin)
 
 	resp, err := c.createTokenResponse(s.tokenAuth, tokenAudienceAPI, ip)
 
@@ -1014,7 +1490,9 @@ func (s *httpdServer) refreshAdminToken(w http.ResponseWriter, r *http.Request,
 	}
 	tokenClaims.Permissions = admin.Permissions
 	tokenClaims.Role = admin.Role
+	tokenClaims.Tenant = admin.Filters.Tenant
 	tokenClaims.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+	tokenClaims.setAdminRestrictions(&admin)
 	logger.Debug(logSender, "", "cookie refreshed for admin %q", admin.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebAdmin, ipAddr) //nolint:errcheck
 }
@@ -1077,6 +1555,10 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 			s.sendForbiddenResponse(w, r, "your IP address is banned")
 			return
 		}
+		if !common.IsAllowedByGeoIP(&s.binding.GeoIP, ipAddr, common.ProtocolHTTP) {
+			s.sendForbiddenResponse(w, r, "your location is not allowed")
+			return
+		}
 		if delay, err := common.LimitRate(common.ProtocolHTTP, ipAddr); err != nil {
 			delay += 499999999 * time.Nanosecond
 			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
@@ -1256,6 +1738,8 @@ func (s *httpdServer) initializeRouter() {
 			router.With(forbidAPIKeyAuthentication).Get(logoutPath, s.logout)
 			router.With(forbidAPIKeyAuthentication).Get(adminProfilePath, getAdminProfile)
 			router.With(forbidAPIKeyAuthentication).Put(adminProfilePath, updateAdminProfile)
+			router.With(forbidAPIKeyAuthentication).Get(adminSessionsPath, getAdminSessions)
+			router.With(forbidAPIKeyAuthentication).Delete(adminSessionsPath+"/{id}", revokeAdminSession)
 			router.With(forbidAPIKeyAuthentication).Put(adminPwdPath, changeAdminPassword)
 			// admin TOTP APIs
 			router.With(forbidAPIKeyAuthentication).Get(adminTOTPConfigsPath, getTOTPConfigs)
@@ -1271,38 +1755,86 @@ func (s *httpdServer) initializeRouter() {
 					render.JSON(w, r, getServicesStatus())
 				})
 
+			router.With(s.checkPerm(dataprovider.PermAdminViewServerStatus), s.forbidTenantAdmins).
+				Get(replicationStatusPath, getReplicationStatus)
 			router.With(s.checkPerm(dataprovider.PermAdminViewConnections)).Get(activeConnectionsPath, getActiveConnections)
+			router.With(s.checkPerm(dataprovider.PermAdminViewConnections)).Get(activeTransfersPath, getActiveTransfers)
 			router.With(s.checkPerm(dataprovider.PermAdminCloseConnections)).
 				Delete(activeConnectionsPath+"/{connectionID}", handleCloseConnection)
+			router.With(s.checkPerm(dataprovider.PermAdminViewConnections), s.forbidTenantAdmins).Get(sessionsPath, getSessions)
+			router.With(s.checkPerm(dataprovider.PermAdminCloseConnections), s.forbidTenantAdmins).
+				Delete(sessionsPath+"/{id}", revokeSession)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem), s.forbidTenantAdmins).
+				Post(sessionsPath+"/revoke", revokeTokens)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.forbidTenantAdmins).
+				Get(ipApprovalsPath, getIPApprovalRequests)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.forbidTenantAdmins).
+				Get(ipApprovalsPath+"/{id}", getIPApprovalRequestByID)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.forbidTenantAdmins).
+				Post(ipApprovalsPath+"/{id}/approve", approveIPRequest)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.forbidTenantAdmins).
+				Delete(ipApprovalsPath+"/{id}", rejectIPRequest)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotasBasePath+"/users/scans", getUsersQuotaScans)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotasBasePath+"/users/{username}/scan", startUserQuotaScan)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotasBasePath+"/folders/scans", getFoldersQuotaScans)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotasBasePath+"/folders/{name}/scan", startFolderQuotaScan)
-			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath, getUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), s.forbidTenantAdmins).
+				Post(quotasBasePath+"/reconcile", startQuotaReconciliation)
+			router.With(s.checkUserPerm(dataprovider.PermAdminViewUsers, "")).Get(userPath, getUsers)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(userPath, addUser)
-			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
-			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}/2fa/disable", disableUser2FA)
+			router.With(s.checkUserPerm(dataprovider.PermAdminViewUsers, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Get(userPath+"/{username}", getUserByUsername)
+			router.With(s.checkUserPerm(dataprovider.PermAdminChangeUsers, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Put(userPath+"/{username}", updateUser)
+			router.With(s.checkUserPerm(dataprovider.PermAdminDeleteUsers, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Delete(userPath+"/{username}", deleteUser)
+			router.With(s.checkUserPerm(dataprovider.PermAdminChangeUsers, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Put(userPath+"/{username}/2fa/disable", disableUser2FA)
+			router.With(s.checkUserPerm(dataprovider.PermAdminResetUserPwds, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Put(userPath+"/{username}/password", setUserPassword)
+			router.With(s.checkPerm(dataprovider.PermAdminAddUsers), s.forbidTenantAdmins).
+				Post(userPath+"/bulk/create", bulkCreateUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.forbidTenantAdmins).
+				Post(userPath+"/bulk/update", bulkUpdateUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.forbidTenantAdmins).
+				Post(userPath+"/bulk/disable", bulkDisableUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), s.forbidTenantAdmins).
+				Post(userPath+"/bulk/delete", bulkDeleteUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.forbidTenantAdmins).
+				Get(userPath+"/bulk/jobs", getBulkUsersJobs)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.forbidTenantAdmins).
+				Get(userPath+"/bulk/jobs/{id}", getBulkUsersJob)
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath, getFolders)
-			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath+"/{name}", getFolderByName)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name")).
+				Get(folderPath+"/{name}", getFolderByName)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(folderPath, addFolder)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(folderPath+"/{name}", updateFolder)
-			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(folderPath+"/{name}", deleteFolder)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name")).
+				Put(folderPath+"/{name}", updateFolder)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name")).
+				Delete(folderPath+"/{name}", deleteFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath, getGroups)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath+"/{name}", getGroupByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name")).
+				Get(groupPath+"/{name}", getGroupByName)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(groupPath, addGroup)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Put(groupPath+"/{name}", updateGroup)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Delete(groupPath+"/{name}", deleteGroup)
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name")).
+				Put(groupPath+"/{name}", updateGroup)
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name")).
+				Delete(groupPath+"/{name}", deleteGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/usage",
-				updateUserQuotaUsage)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/transfer-usage",
-				updateUserTransferQuotaUsage)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/folders/{name}/usage",
-				updateFolderQuotaUsage)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(tlsRevocationReloadPath, reloadRevocationData)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(deletedObjectsPath, getDeletedObjects)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(deletedObjectsPath+"/{id}/restore",
+				restoreDeletedObject)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(deletedObjectsPath+"/{id}",
+				purgeDeletedObject)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(tenantObjectUser, "username")).
+				Put(quotasBasePath+"/users/{username}/usage", updateUserQuotaUsage)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(tenantObjectUser, "username")).
+				Put(quotasBasePath+"/users/{username}/transfer-usage", updateUserTransferQuotaUsage)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name")).
+				Put(quotasBasePath+"/folders/{name}/usage", updateFolderQuotaUsage)
 			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts, getDefenderHosts)
 			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts+"/{id}", getDefenderHostByID)
 			router.With(s.checkPerm(dataprovider.PermAdminManageDefender)).Delete(defenderHosts+"/{id}", deleteDefenderHostByID)
@@ -1322,6 +1854,10 @@ func (s *httpdServer) initializeRouter() {
 				Get(fsEventsPath, searchFsEvents)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
 				Get(providerEventsPath, searchProviderEvents)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
+				Get(auditLogsPath, searchAuditLogs)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
+				Get(changeEventsPath, getChangeEvents)
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
 				Get(apiKeysPath, getAPIKeys)
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
@@ -1332,27 +1868,65 @@ func (s *httpdServer) initializeRouter() {
 				Put(apiKeysPath+"/{id}", updateAPIKey)
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
 				Delete(apiKeysPath+"/{id}", deleteAPIKey)
+			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
+				Post(apiKeysPath+"/{id}/rotate", rotateAPIKey)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventActionsPath, getEventActions)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventActionsPath+"/{name}", getEventActionByName)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventActionsPath, addEventAction)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Put(eventActionsPath+"/{name}", updateEventAction)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventActionsPath+"/{name}", deleteEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Post(eventActionsPath, addEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Put(eventActionsPath+"/{name}", updateEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Delete(eventActionsPath+"/{name}", deleteEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Post(eventActionsPath+"/dryrun/{name}", dryRunEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Get(deadLettersPath, getDeadLetters)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Get(deadLettersPath+"/{id}", getDeadLetterByID)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Post(deadLettersPath+"/{id}/retry", retryDeadLetter)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).
+				Delete(deadLettersPath+"/{id}", deleteDeadLetter)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath, getEventRules)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath+"/{name}", getEventRuleByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Get(eventRulesPath+"/{name}", getEventRuleByName)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath, addEventRule)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Put(eventRulesPath+"/{name}", updateEventRule)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventRulesPath+"/{name}", deleteEventRule)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath+"/run/{name}", runOnDemandRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Put(eventRulesPath+"/{name}", updateEventRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Delete(eventRulesPath+"/{name}", deleteEventRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Post(eventRulesPath+"/run/{name}", runOnDemandRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Post(eventRulesPath+"/dryrun/{name}", dryRunEventRule)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Get(rolesPath, getRoles)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Post(rolesPath, addRole)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Get(rolesPath+"/{name}", getRoleByName)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Put(rolesPath+"/{name}", updateRole)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Delete(rolesPath+"/{name}", deleteRole)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Get(tenantsPath, getTenants)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Post(tenantsPath, addTenant)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Get(tenantsPath+"/{name}", getTenantByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Put(tenantsPath+"/{name}", updateTenant)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Delete(tenantsPath+"/{name}", deleteTenant)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), compressor.Handler).Get(ipListsPath+"/{type}", getIPListEntries)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Post(ipListsPath+"/{type}", addIPListEntry)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Get(ipListsPath+"/{type}/{ipornet}", getIPListEntry)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Put(ipListsPath+"/{type}/{ipornet}", updateIPListEntry)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Delete(ipListsPath+"/{type}/{ipornet}", deleteIPListEntry)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(oidcMappingsPath, getOIDCMappings)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Put(oidcMappingsPath, updateOIDCMappings)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(backupConfigsPath, getBackupConfigs)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Put(backupConfigsPath, updateBackupConfigs)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(backupRunPath, runScheduledBackup)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(templatesPath, getTemplates)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(templatesPath, addTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(templatesPath+"/{name}", getTemplateByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Put(templatesPath+"/{name}", updateTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(templatesPath+"/{name}", deleteTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(templatesPath+"/{name}/resolved", getResolvedTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(templatesPath+"/{name}/apply", applyTemplate)
 		})
 
 		s.router.Get(userTokenPath, s.getUserToken)
@@ -1367,6 +1941,10 @@ func (s *httpdServer) initializeRouter() {
 				Put(userPwdPath, changeUserPassword)
 			router.With(forbidAPIKeyAuthentication).Get(userProfilePath, getUserProfile)
 			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).Put(userProfilePath, updateUserProfile)
+			router.With(forbidAPIKeyAuthentication).Get(userSessionsPath, getUserSessions)
+			router.With(forbidAPIKeyAuthentication).Delete(userSessionsPath+"/{id}", revokeUserSession)
+			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).
+				Post(userSSHCertificatePath, issueUserSSHCertificate)
 			// user TOTP APIs
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
 				Get(userTOTPConfigsPath, getTOTPConfigs)
@@ -1389,6 +1967,7 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Delete(userDirsPath, deleteUserDir)
 			router.With(s.checkAuthRequirements).Get(userFilesPath, getUserFile)
+			router.With(s.checkAuthRequirements).Get(userFilesPath+"/info", getUserFileInfo)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Post(userFilesPath, uploadUserFiles)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
@@ -1400,6 +1979,13 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Post(userFileActionsPath+"/copy", copyUserFsEntry)
 			router.With(s.checkAuthRequirements).Post(userStreamZipPath, getUserFilesAsZipStream)
+			router.With(s.checkAuthRequirements).Get(userTrashPath, getUserTrash)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userTrashPath+"/{id}/restore", restoreUserTrashItem)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Delete(userTrashPath+"/{id}", deleteUserTrashItem)
+			router.With(s.checkAuthRequirements).Get(userPartialDownloadsPath, getUserPartialDownloads)
+			router.With(s.checkAuthRequirements).Delete(userPartialDownloadsPath, deleteUserPartialDownload)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Get(userSharesPath, getShares)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
@@ -1414,6 +2000,18 @@ func (s *httpdServer) initializeRouter() {
 				Post(userUploadFilePath, uploadUserFile)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Patch(userFilesDirsMetadataPath, setFileDirMetadata)
+			if tusMgr != nil {
+				router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Options(userTusPath, getTusOptions)
+				router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Post(userTusPath, createTusUpload)
+				router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Head(userTusPath+"/{id}", getTusUpload)
+				router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Patch(userTusPath+"/{id}", uploadTusData)
+				router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Delete(userTusPath+"/{id}", deleteTusUpload)
+			}
 		})
 
 		if s.renderOpenAPI {
@@ -1464,9 +2062,14 @@ func (s *httpdServer) setupWebClientRoutes() {
 			http.Redirect(w, r, webClientLoginPath, http.StatusFound)
 		})
 		s.router.Get(webClientLoginPath, s.handleClientWebLogin)
+		s.router.Get(webClientIPApprovalPath+"/{id}", s.handleWebClientIPApproval)
+		s.router.Post(webClientIPApprovalPath+"/{id}", s.handleWebClientIPApprovalPost)
 		if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
 			s.router.Get(webClientOIDCLoginPath, s.handleWebClientOIDCLogin)
 		}
+		if s.binding.KerberosService != "" {
+			s.router.Get(webClientKerberosLoginPath, s.handleWebClientKerberosLogin)
+		}
 		if !s.binding.isWebClientLoginFormDisabled() {
 			s.router.Post(webClientLoginPath, s.handleWebClientLoginPost)
 			s.router.Get(webClientForgotPwdPath, s.handleWebClientForgotPwd)
@@ -1485,6 +2088,18 @@ func (s *httpdServer) setupWebClientRoutes() {
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
 				Post(webClientTwoFactorRecoveryPath, s.handleWebClientTwoFactorRecoveryPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial), verifyCSRFHeader).
+				Post(webClientTwoFactorWebAuthnPath+"/begin", s.handleWebClientTwoFactorWebAuthnBegin)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
+				Post(webClientTwoFactorWebAuthnPath, s.handleWebClientTwoFactorWebAuthnPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial), verifyCSRFHeader).
+				Post(webClientTwoFactorWebAuthnPath+"/register/begin", s.handleWebClientTwoFactorWebAuthnRegisterBegin)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
+				Post(webClientTwoFactorWebAuthnPath+"/register", s.handleWebClientTwoFactorWebAuthnRegisterPost)
 		}
 		// share routes available to external users
 		s.router.Get(webClientPubSharesPath+"/{id}/login", s.handleClientShareLoginGet)
@@ -1496,6 +2111,10 @@ func (s *httpdServer) setupWebClientRoutes() {
 		s.router.With(compressor.Handler).Get(webClientPubSharesPath+"/{id}/dirs", s.handleShareGetDirContents)
 		s.router.Post(webClientPubSharesPath+"/{id}", s.uploadFilesToShare)
 		s.router.Post(webClientPubSharesPath+"/{id}/{name}", s.uploadFileToShare)
+		// read-only areas available to unauthenticated users
+		s.router.Get(webClientAnonymousPath+"/{username}/browse", s.handleAnonymousGetFiles)
+		s.router.With(compressor.Handler).Get(webClientAnonymousPath+"/{username}/dirs", s.handleAnonymousGetDirContents)
+		s.router.Get(webClientAnonymousPath+"/{username}/partial", s.handleAnonymousPartialDownload)
 
 		s.router.Group(func(router chi.Router) {
 			if s.binding.OIDC.isEnabled() {
@@ -1508,6 +2127,7 @@ func (s *httpdServer) setupWebClientRoutes() {
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientFilesPath, s.handleClientGetFiles)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientViewPDFPath, s.handleClientViewPDF)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientGetPDFPath, s.handleClientGetPDF)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientPreviewPath, s.handleClientGetPreview)
 			router.With(s.checkAuthRequirements, s.refreshCookie, verifyCSRFHeader).Get(webClientFilePath, getUserFile)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
 				Post(webClientFilePath, uploadUserFile)
@@ -1529,6 +2149,8 @@ func (s *httpdServer) setupWebClientRoutes() {
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientProfilePath,
 				s.handleClientGetProfile)
 			router.With(s.checkAuthRequirements).Post(webClientProfilePath, s.handleWebClientProfilePost)
+			router.With(s.checkAuthRequirements, verifyCSRFHeader).
+				Post(webClientSSHCertPath, issueUserSSHCertificate)
 			router.With(s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
 				Get(webChangeClientPwdPath, s.handleWebClientChangePwd)
 			router.With(s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
@@ -1545,6 +2167,12 @@ func (s *httpdServer) setupWebClientRoutes() {
 				Get(webClientRecoveryCodesPath, getRecoveryCodes)
 			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
 				Post(webClientRecoveryCodesPath, generateRecoveryCodes)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Post(webClientWebAuthnPath+"/register/begin", beginWebAuthnRegistration)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Post(webClientWebAuthnPath+"/register", finishWebAuthnRegistration)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Delete(webClientWebAuthnPath+"/{id}", deleteWebAuthnCredential)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
 				Get(webClientSharesPath, s.handleClientGetShares)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
@@ -1557,6 +2185,15 @@ func (s *httpdServer) setupWebClientRoutes() {
 				Post(webClientSharePath+"/{id}", s.handleClientUpdateSharePost)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), verifyCSRFHeader).
 				Delete(webClientSharePath+"/{id}", deleteShare)
+			router.With(s.checkAuthRequirements, s.refreshCookie).
+				Get(webClientTrashPath, s.handleClientGetTrash)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+				Post(webClientTrashPath+"/{id}/restore", restoreUserTrashItem)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+				Delete(webClientTrashPath+"/{id}", deleteUserTrashItem)
+			router.With(s.checkAuthRequirements).Get(webClientPartialDownloadsPath, getUserPartialDownloads)
+			router.With(s.checkAuthRequirements, verifyCSRFHeader).
+				Delete(webClientPartialDownloadsPath, deleteUserPartialDownload)
 		})
 	}
 }
@@ -1587,6 +2224,18 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial)).
 				Post(webAdminTwoFactorRecoveryPath, s.handleWebAdminTwoFactorRecoveryPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial), verifyCSRFHeader).
+				Post(webAdminTwoFactorWebAuthnPath+"/begin", s.handleWebAdminTwoFactorWebAuthnBegin)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial)).
+				Post(webAdminTwoFactorWebAuthnPath, s.handleWebAdminTwoFactorWebAuthnPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial), verifyCSRFHeader).
+				Post(webAdminTwoFactorWebAuthnPath+"/register/begin", s.handleWebAdminTwoFactorWebAuthnRegisterBegin)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial)).
+				Post(webAdminTwoFactorWebAuthnPath+"/register", s.handleWebAdminTwoFactorWebAuthnRegisterPost)
 			s.router.Get(webAdminForgotPwdPath, s.handleWebAdminForgotPwd)
 			s.router.Post(webAdminForgotPwdPath, s.handleWebAdminForgotPwdPost)
 			s.router.Get(webAdminResetPwdPath, s.handleWebAdminPasswordReset)
@@ -1613,29 +2262,37 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			router.With(verifyCSRFHeader, s.requireBuiltinLogin, s.refreshCookie).Get(webAdminRecoveryCodesPath,
 				getRecoveryCodes)
 			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminRecoveryCodesPath, generateRecoveryCodes)
-
-			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminWebAuthnPath+"/register/begin",
+				beginWebAuthnRegistration)
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminWebAuthnPath+"/register",
+				finishWebAuthnRegistration)
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Delete(webAdminWebAuthnPath+"/{id}",
+				deleteWebAuthnCredential)
+
+			router.With(s.checkUserPerm(dataprovider.PermAdminViewUsers, ""), s.refreshCookie).
 				Get(webUsersPath, s.handleGetWebUsers)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers), s.refreshCookie).
 				Get(webUserPath, s.handleWebAddUserGet)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.refreshCookie).
-				Get(webUserPath+"/{username}", s.handleWebUpdateUserGet)
+			router.With(s.checkUserPerm(dataprovider.PermAdminChangeUsers, "username"), s.checkTenantObject(tenantObjectUser, "username"),
+				s.refreshCookie).Get(webUserPath+"/{username}", s.handleWebUpdateUserGet)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(webUserPath, s.handleWebAddUserPost)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(webUserPath+"/{username}",
-				s.handleWebUpdateUserPost)
+			router.With(s.checkUserPerm(dataprovider.PermAdminChangeUsers, "username"), s.checkTenantObject(tenantObjectUser, "username")).
+				Post(webUserPath+"/{username}", s.handleWebUpdateUserPost)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
 				Get(webGroupsPath, s.handleWebGetGroups)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
 				Get(webGroupPath, s.handleWebAddGroupGet)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(webGroupPath, s.handleWebAddGroupPost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name"), s.refreshCookie).
 				Get(webGroupPath+"/{name}", s.handleWebUpdateGroupGet)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(webGroupPath+"/{name}",
-				s.handleWebUpdateGroupPost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), verifyCSRFHeader).
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name")).
+				Post(webGroupPath+"/{name}", s.handleWebUpdateGroupPost)
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.checkTenantObject(dataprovider.TenantObjectGroup, "name"), verifyCSRFHeader).
 				Delete(webGroupPath+"/{name}", deleteGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminViewConnections), s.refreshCookie).
 				Get(webConnectionsPath, s.handleWebGetConnections)
+			router.With(s.checkPerm(dataprovider.PermAdminViewConnections)).
+				Get(webConnectionsPath+"/transfers", s.handleWebTransfersProgress)
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
 				Get(webFoldersPath, s.handleWebGetFolders)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers), s.refreshCookie).
@@ -1656,16 +2313,22 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				Delete(webAdminPath+"/{username}", deleteAdmin)
 			router.With(s.checkPerm(dataprovider.PermAdminCloseConnections), verifyCSRFHeader).
 				Delete(webConnectionsPath+"/{connectionID}", handleCloseConnection)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.refreshCookie).
+			router.With(s.checkPerm(dataprovider.PermAdminCloseConnections), s.forbidTenantAdmins, verifyCSRFHeader).
+				Delete(webConnectionsPath+"/sessions/{id}", revokeSession)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem), s.forbidTenantAdmins, verifyCSRFHeader).
+				Post(webConnectionsPath+"/sessions/revoke", revokeTokens)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name"), s.refreshCookie).
 				Get(webFolderPath+"/{name}", s.handleWebUpdateFolderGet)
-			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(webFolderPath+"/{name}",
-				s.handleWebUpdateFolderPost)
-			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name")).
+				Post(webFolderPath+"/{name}", s.handleWebUpdateFolderPost)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), s.checkTenantObject(dataprovider.TenantObjectFolder, "name"), verifyCSRFHeader).
 				Delete(webFolderPath+"/{name}", deleteFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 				Post(webScanVFolderPath+"/{name}", startFolderQuotaScan)
-			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
-				Delete(webUserPath+"/{username}", deleteUser)
+			router.With(s.checkUserPerm(dataprovider.PermAdminDeleteUsers, "username"), s.checkTenantObject(tenantObjectUser, "username"),
+				verifyCSRFHeader).Delete(webUserPath+"/{username}", deleteUser)
+			router.With(s.checkUserPerm(dataprovider.PermAdminResetUserPwds, "username"), s.checkTenantObject(tenantObjectUser, "username"),
+				verifyCSRFHeader).Put(webUserPath+"/{username}/password", setUserPassword)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 				Post(webQuotaScanPath+"/{username}", startUserQuotaScan)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(webMaintenancePath, s.handleWebMaintenance)
@@ -1685,28 +2348,38 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				Get(webAdminEventActionsPath, s.handleWebGetEventActions)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
 				Get(webAdminEventActionPath, s.handleWebAddEventActionGet)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventActionPath,
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).Post(webAdminEventActionPath,
 				s.handleWebAddEventActionPost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, s.refreshCookie).
 				Get(webAdminEventActionPath+"/{name}", s.handleWebUpdateEventActionGet)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventActionPath+"/{name}",
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins).Post(webAdminEventActionPath+"/{name}",
 				s.handleWebUpdateEventActionPost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), verifyCSRFHeader).
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, verifyCSRFHeader).
 				Delete(webAdminEventActionPath+"/{name}", deleteEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, verifyCSRFHeader).
+				Post(webAdminEventActionPath+"/dryrun/{name}", dryRunEventAction)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, s.refreshCookie).
+				Get(webAdminDeadLettersPath, s.handleWebGetDeadLetters)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, verifyCSRFHeader).
+				Post(webAdminDeadLettersPath+"/{id}/retry", retryDeadLetter)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.forbidTenantAdmins, verifyCSRFHeader).
+				Delete(webAdminDeadLettersPath+"/{id}", deleteDeadLetter)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
 				Get(webAdminEventRulesPath, s.handleWebGetEventRules)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
 				Get(webAdminEventRulePath, s.handleWebAddEventRuleGet)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventRulePath,
 				s.handleWebAddEventRulePost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name"), s.refreshCookie).
 				Get(webAdminEventRulePath+"/{name}", s.handleWebUpdateEventRuleGet)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventRulePath+"/{name}",
-				s.handleWebUpdateEventRulePost)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), verifyCSRFHeader).
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name")).
+				Post(webAdminEventRulePath+"/{name}", s.handleWebUpdateEventRulePost)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name"), verifyCSRFHeader).
 				Delete(webAdminEventRulePath+"/{name}", deleteEventRule)
-			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), verifyCSRFHeader).
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name"), verifyCSRFHeader).
 				Post(webAdminEventRulePath+"/run/{name}", runOnDemandRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.checkTenantObject(dataprovider.TenantObjectEventRule, "name"), verifyCSRFHeader).
+				Post(webAdminEventRulePath+"/dryrun/{name}", dryRunEventRule)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.refreshCookie).
 				Get(webAdminRolesPath, s.handleWebGetRoles)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.refreshCookie).
@@ -1718,6 +2391,17 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				s.handleWebUpdateRolePost)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles), verifyCSRFHeader).
 				Delete(webAdminRolePath+"/{name}", deleteRole)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants), s.refreshCookie).
+				Get(webAdminTenantsPath, s.handleWebGetTenants)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants), s.refreshCookie).
+				Get(webAdminTenantPath, s.handleWebAddTenantGet)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Post(webAdminTenantPath, s.handleWebAddTenantPost)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants), s.refreshCookie).
+				Get(webAdminTenantPath+"/{name}", s.handleWebUpdateTenantGet)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants)).Post(webAdminTenantPath+"/{name}",
+				s.handleWebUpdateTenantPost)
+			router.With(s.checkPerm(dataprovider.PermAdminManageTenants), verifyCSRFHeader).
+				Delete(webAdminTenantPath+"/{name}", deleteTenant)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), s.refreshCookie).Get(webEventsPath,
 				s.handleWebGetEvents)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler, s.refreshCookie).
@@ -0,0 +1,108 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	transfersProgressInterval = 1 * time.Second
+	transfersWriteTimeout     = 10 * time.Second
+)
+
+// handleWebTransfersProgress streams the active transfers progress over a websocket.
+// The stream is closed after tokenDuration, the web page will reconnect using a
+// refreshed token
+func (s *httpdServer) handleWebTransfersProgress(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		sendAPIResponse(w, r, nil, "Websocket connections are not supported", http.StatusBadRequest)
+		return
+	}
+	server := websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			streamTransfersProgress(ws, &claims)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkWebSocketOrigin allows websocket connections from the same host only
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		logger.Debug(logSender, "", "websocket origin %v not allowed for host %q", origin, r.Host)
+		return errors.New("websocket origin not allowed")
+	}
+	config.Origin = origin
+	return nil
+}
+
+func streamTransfersProgress(ws *websocket.Conn, claims *jwtTokenClaims) {
+	done := make(chan struct{})
+	// we don't expect any message from the client, we read to detect a closed connection
+	go func() {
+		defer close(done)
+
+		var msg string
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(transfersProgressInterval)
+	defer ticker.Stop()
+	expiration := time.NewTimer(tokenDuration)
+	defer expiration.Stop()
+
+	for {
+		stats, err := getConnectionsStats(claims)
+		if err != nil {
+			logger.Debug(logSender, "", "unable to get connections for admin %q: %v", claims.Username, err)
+			return
+		}
+		if err := ws.SetWriteDeadline(time.Now().Add(transfersWriteTimeout)); err != nil {
+			return
+		}
+		if err := websocket.JSON.Send(ws, getTransfersProgress(stats)); err != nil {
+			logger.Debug(logSender, "", "unable to send transfers progress to admin %q: %v", claims.Username, err)
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-expiration.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,587 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,creation-with-upload,expiration,termination"
+	tusContentType       = "application/offset+octet-stream"
+	tusResumableHeader   = "Tus-Resumable"
+	tusUploadOffset      = "Upload-Offset"
+	tusUploadLength      = "Upload-Length"
+	tusUploadMetadata    = "Upload-Metadata"
+	tusUploadExpires     = "Upload-Expires"
+	tusInfoFileSuffix    = ".info"
+	tusDefaultExpiration = 24
+)
+
+var (
+	tusMgr               *tusManager
+	errTusLengthExceeded = errors.New("the uploaded data exceed the declared upload length")
+	tusUploadIDRegex     = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+)
+
+// tusUpload defines the state of a resumable upload
+type tusUpload struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// Path is the virtual path of the target file
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	// Metadata is the Upload-Metadata header, as sent by the client
+	Metadata  string `json:"metadata,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (u *tusUpload) isExpired() bool {
+	return u.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (u *tusUpload) getExpiresAsString() string {
+	return util.GetTimeFromMsecSinceEpoch(u.ExpiresAt).UTC().Format(http.TimeFormat)
+}
+
+// tusManager stores the incomplete uploads inside a local directory.
+// For each upload we have a data file, named as the upload ID, and a JSON
+// encoded info file
+type tusManager struct {
+	mu          sync.Mutex
+	uploadsPath string
+	expiration  time.Duration
+	// uploads currently receiving data or being removed
+	busy map[string]bool
+}
+
+func newTusManager(uploadsPath string, expiration int) (*tusManager, error) {
+	if uploadsPath == "" {
+		return nil, errors.New("tus: the uploads path is required")
+	}
+	if expiration <= 0 {
+		expiration = tusDefaultExpiration
+	}
+	if err := os.MkdirAll(uploadsPath, 0700); err != nil {
+		return nil, fmt.Errorf("tus: unable to create uploads dir %q: %w", uploadsPath, err)
+	}
+	logger.Info(logSender, "", "tus resumable uploads enabled, uploads path %q, expiration %d hours",
+		uploadsPath, expiration)
+	return &tusManager{
+		uploadsPath: uploadsPath,
+		expiration:  time.Duration(expiration) * time.Hour,
+		busy:        make(map[string]bool),
+	}, nil
+}
+
+func (m *tusManager) getDataPath(id string) string {
+	return filepath.Join(m.uploadsPath, id)
+}
+
+func (m *tusManager) getInfoPath(id string) string {
+	return filepath.Join(m.uploadsPath, id+tusInfoFileSuffix)
+}
+
+func (m *tusManager) updateExpiration(upload *tusUpload) {
+	upload.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(m.expiration))
+}
+
+// lock marks the upload with the given ID as busy, it returns false if it is
+// already busy
+func (m *tusManager) lock(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy[id] {
+		return false
+	}
+	m.busy[id] = true
+	return true
+}
+
+func (m *tusManager) unlock(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.busy, id)
+}
+
+func (m *tusManager) add(upload *tusUpload) error {
+	f, err := os.OpenFile(m.getDataPath(upload.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := m.save(upload); err != nil {
+		os.Remove(m.getDataPath(upload.ID))
+		return err
+	}
+	return nil
+}
+
+func (m *tusManager) save(upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	infoPath := m.getInfoPath(upload.ID)
+	tmpPath := infoPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, infoPath)
+}
+
+// get returns the upload with the given ID if it exists, it is not expired
+// and it is owned by the specified user
+func (m *tusManager) get(id, username string) (*tusUpload, error) {
+	if !tusUploadIDRegex.MatchString(id) {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q does not exist", id))
+	}
+	data, err := os.ReadFile(m.getInfoPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q does not exist", id))
+		}
+		return nil, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if upload.Username != username || upload.isExpired() {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q does not exist", id))
+	}
+	return &upload, nil
+}
+
+func (m *tusManager) remove(id string) {
+	if err := os.Remove(m.getInfoPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(logSender, "", "tus: unable to remove info file for upload %q: %v", id, err)
+	}
+	if err := os.Remove(m.getDataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(logSender, "", "tus: unable to remove data file for upload %q: %v", id, err)
+	}
+}
+
+// writeData appends the data read from the specified reader to the upload
+// and updates its offset. The offset is updated even if an error occurs so
+// the client can resume the upload from the last received byte
+func (m *tusManager) writeData(upload *tusUpload, reader io.Reader) error {
+	f, err := os.OpenFile(m.getDataPath(upload.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// remove any data written after the last saved offset, for example
+	// if we were unable to update the info file
+	if err := f.Truncate(upload.Offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	remaining := upload.Size - upload.Offset
+	written, errCopy := io.Copy(f, io.LimitReader(reader, remaining+1))
+	if written > remaining {
+		written = remaining
+		errCopy = errTusLengthExceeded
+		if err := f.Truncate(upload.Offset + written); err != nil {
+			return err
+		}
+	}
+	upload.Offset += written
+	m.updateExpiration(upload)
+	if err := m.save(upload); err != nil {
+		return err
+	}
+	return errCopy
+}
+
+// cleanup removes the expired uploads
+func (m *tusManager) cleanup() {
+	entries, err := os.ReadDir(m.uploadsPath)
+	if err != nil {
+		logger.Warn(logSender, "", "tus: unable to read uploads dir %q: %v", m.uploadsPath, err)
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), tusInfoFileSuffix)
+		if !ok || entry.IsDir() || !m.lock(id) {
+			continue
+		}
+		data, err := os.ReadFile(m.getInfoPath(id))
+		if err == nil {
+			var upload tusUpload
+			err = json.Unmarshal(data, &upload)
+			if err == nil && upload.isExpired() {
+				logger.Debug(logSender, "", "tus: removing expired upload %q for user %q, path %q",
+					id, upload.Username, upload.Path)
+				m.remove(id)
+			}
+		}
+		if err != nil {
+			logger.Warn(logSender, "", "tus: unable to read info for upload %q: %v", id, err)
+		}
+		m.unlock(id)
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header. It consists of one or
+// more comma separated key-value pairs, the key and the value are separated
+// by a space, the value is base64 encoded and it is optional
+func parseTusMetadata(header string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.Contains(value, " ") {
+			return nil, fmt.Errorf("invalid upload metadata %q", pair)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload metadata value for key %q: %w", key, err)
+		}
+		result[key] = string(decoded)
+	}
+	return result, nil
+}
+
+// checkTusUpload checks if the user can upload a file with the specified
+// size to the specified path
+func (c *Connection) checkTusUpload(name string, size int64) error {
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return c.GetPermissionDeniedError()
+	}
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return err
+	}
+	isNewFile := true
+	fileSize := int64(0)
+	stat, err := fs.Lstat(p)
+	if err == nil {
+		if stat.IsDir() {
+			c.Log(logger.LevelError, "attempted to upload to directory %q", p)
+			return c.GetOpUnsupportedError()
+		}
+		if stat.Mode().IsRegular() {
+			isNewFile = false
+			fileSize = stat.Size()
+		}
+	} else if !fs.IsNotExist(err) {
+		c.Log(logger.LevelError, "error performing file stat %q: %+v", p, err)
+		return c.GetFsError(fs, err)
+	}
+	if isNewFile {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return c.GetPermissionDeniedError()
+		}
+	} else if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return c.GetPermissionDeniedError()
+	}
+	diskQuota, transferQuota := c.HasSpace(isNewFile, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying resumable upload due to quota limits")
+		return common.ErrQuotaExceeded
+	}
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if maxWriteSize > 0 && size > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying resumable upload, size %d exceeds the allowed size %d", size, maxWriteSize)
+		return common.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// completeTusUpload writes the uploaded data to the target file using the
+// user's filesystem, quota limits and event hooks are applied as for any
+// other upload
+func (c *Connection) completeTusUpload(upload *tusUpload) error {
+	f, err := os.Open(tusMgr.getDataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.User.CheckFsRoot(c.ID) //nolint:errcheck
+	// the upload bandwidth was already applied while receiving the data
+	c.User.UploadBandwidth = 0
+	writer, err := c.getFileWriter(upload.Path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, f)
+	if err != nil {
+		writer.Close() //nolint:errcheck
+		return err
+	}
+	return writer.Close()
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	if r.Header.Get(tusResumableHeader) != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		sendAPIResponse(w, r, nil, "Unsupported tus protocol version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func getTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	if maxUploadFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileSize)
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		sendAPIResponse(w, r, nil, "Deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get(tusUploadLength), 10, 64)
+	if err != nil || size < 0 {
+		sendAPIResponse(w, r, err, "Invalid upload length", http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get(tusUploadMetadata))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		filePath = metadata["path"]
+	}
+	if filePath == "" {
+		sendAPIResponse(w, r, errors.New("please set a file path"), "", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	filePath = connection.User.GetCleanedPath(filePath)
+	if getBoolQueryParam(r, "mkdir_parents") {
+		if err = connection.CheckParentDirs(path.Dir(filePath)); err != nil {
+			sendAPIResponse(w, r, err, "Error checking parent directories", getMappedStatusCode(err))
+			return
+		}
+	}
+	if err = connection.checkTusUpload(filePath, size); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to upload file %q", filePath), getMappedStatusCode(err))
+		return
+	}
+	upload := &tusUpload{
+		ID:        util.GenerateUniqueID(),
+		Username:  connection.User.Username,
+		Path:      filePath,
+		Size:      size,
+		Metadata:  r.Header.Get(tusUploadMetadata),
+		CreatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	tusMgr.updateExpiration(upload)
+	if !tusMgr.lock(upload.ID) {
+		sendAPIResponse(w, r, nil, "Unable to lock the upload", http.StatusInternalServerError)
+		return
+	}
+	defer tusMgr.unlock(upload.ID)
+
+	if err = tusMgr.add(upload); err != nil {
+		connection.Log(logger.LevelError, "unable to add resumable upload for file %q: %v", filePath, err)
+		sendAPIResponse(w, r, err, "Unable to create the upload", http.StatusInternalServerError)
+		return
+	}
+	connection.Log(logger.LevelDebug, "resumable upload %q created for file %q, size: %d", upload.ID, filePath, size)
+	w.Header().Set("Location", path.Join(userTusPath, upload.ID))
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == tusContentType || size == 0 {
+		handleTusData(w, r, connection, upload, http.StatusCreated)
+		return
+	}
+	w.Header().Set(tusUploadExpires, upload.getExpiresAsString())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getTusUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	upload, err := tusMgr.get(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		w.WriteHeader(getRespStatus(err))
+		return
+	}
+	w.Header().Set(tusUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(tusUploadLength, strconv.FormatInt(upload.Size, 10))
+	w.Header().Set(tusUploadExpires, upload.getExpiresAsString())
+	if upload.Metadata != "" {
+		w.Header().Set(tusUploadMetadata, upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func uploadTusData(w http.ResponseWriter, r *http.Request) {
+	if maxUploadFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileSize)
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != tusContentType {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported content type %q", contentType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(tusUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		sendAPIResponse(w, r, err, "Invalid upload offset", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	id := getURLParam(r, "id")
+	if !tusMgr.lock(id) {
+		sendAPIResponse(w, r, nil, "The upload is already in progress", http.StatusLocked)
+		return
+	}
+	defer tusMgr.unlock(id)
+
+	upload, err := tusMgr.get(id, connection.User.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if offset != upload.Offset {
+		w.Header().Set(tusUploadOffset, strconv.FormatInt(upload.Offset, 10))
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Upload offset mismatch, expected %d, got %d", upload.Offset, offset),
+			http.StatusConflict)
+		return
+	}
+	handleTusData(w, r, connection, upload, http.StatusNoContent)
+}
+
+func handleTusData(w http.ResponseWriter, r *http.Request, connection *Connection, upload *tusUpload, status int) {
+	transferQuota := connection.GetTransferQuota()
+	if !transferQuota.HasUploadSpace() {
+		connection.Log(logger.LevelInfo, "denying file write due to transfer quota limits")
+		sendAPIResponse(w, r, common.ErrQuotaExceeded, "Denying file write due to transfer quota limits",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	t := newThrottledReader(r.Body, connection.User.UploadBandwidth, connection)
+	err := tusMgr.writeData(upload, t)
+	connection.RemoveTransfer(t)
+	w.Header().Set(tusUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(tusUploadExpires, upload.getExpiresAsString())
+	if err != nil {
+		connection.Log(logger.LevelDebug, "error receiving data for resumable upload %q, offset %d: %v",
+			upload.ID, upload.Offset, err)
+		statusCode := getMappedStatusCode(err)
+		if errors.Is(err, errTusLengthExceeded) {
+			statusCode = http.StatusBadRequest
+		}
+		sendAPIResponse(w, r, err, "Error receiving the upload data", statusCode)
+		return
+	}
+	if upload.Offset == upload.Size {
+		// if we fail here the upload is not removed, so the client can retry
+		// sending an empty request with the final offset
+		if err := connection.completeTusUpload(upload); err != nil {
+			connection.Log(logger.LevelError, "unable to complete resumable upload %q for file %q: %v",
+				upload.ID, upload.Path, err)
+			sendAPIResponse(w, r, err, fmt.Sprintf("Error saving file %q", upload.Path), getMappedStatusCode(err))
+			return
+		}
+		tusMgr.remove(upload.ID)
+		setModificationTimeFromHeader(r, connection, upload.Path)
+		connection.Log(logger.LevelDebug, "resumable upload %q completed for file %q", upload.ID, upload.Path)
+	}
+	w.WriteHeader(status)
+}
+
+func deleteTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	if !tusMgr.lock(id) {
+		sendAPIResponse(w, r, nil, "The upload is in progress", http.StatusLocked)
+		return
+	}
+	defer tusMgr.unlock(id)
+
+	if _, err := tusMgr.get(id, claims.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	tusMgr.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
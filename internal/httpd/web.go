@@ -36,30 +36,54 @@ const (
 	templateForgotPassword    = "forgot-password.html"
 	templateResetPassword     = "reset-password.html"
 	templateCommonCSS         = "sftpgo.css"
+	templateCommonWebAuthn    = "webauthn.html"
+	templateCommonCaptcha     = "captcha.html"
 )
 
 type loginPage struct {
-	CurrentURL     string
-	Version        string
-	Error          string
-	CSRFToken      string
-	StaticURL      string
-	AltLoginURL    string
-	AltLoginName   string
-	ForgotPwdURL   string
-	OpenIDLoginURL string
-	Branding       UIBranding
-	FormDisabled   bool
+	CurrentURL       string
+	Version          string
+	Error            string
+	CSRFToken        string
+	StaticURL        string
+	AltLoginURL      string
+	AltLoginName     string
+	ForgotPwdURL     string
+	OpenIDLoginURL   string
+	KerberosLoginURL string
+	Branding         UIBranding
+	FormDisabled     bool
+	Captcha          *captchaData
 }
 
 type twoFactorPage struct {
-	CurrentURL  string
-	Version     string
-	Error       string
-	CSRFToken   string
-	StaticURL   string
-	RecoveryURL string
-	Branding    UIBranding
+	CurrentURL       string
+	Version          string
+	Error            string
+	CSRFToken        string
+	StaticURL        string
+	RecoveryURL      string
+	WebAuthnURL      string
+	Branding         UIBranding
+	TOTPEnabled      bool
+	TOTPAllowed      bool
+	WebAuthnLogin    bool
+	WebAuthnRegister bool
+	RememberDays     int
+}
+
+// setSecondFactors sets the second factors available to complete the login.
+// If a security key is required TOTP alone is not accepted, if no key is
+// registered yet, the account must register one, and provide the TOTP
+// passcode if enabled, to complete the login
+func (p *twoFactorPage) setSecondFactors(totpEnabled, hasWebAuthnCredentials, webAuthnRequired bool) {
+	p.TOTPEnabled = totpEnabled
+	p.TOTPAllowed = totpEnabled && !webAuthnRequired
+	p.WebAuthnLogin = hasWebAuthnCredentials
+	p.WebAuthnRegister = webAuthnRequired && !hasWebAuthnCredentials
+	if !totpEnabled && !hasWebAuthnCredentials {
+		p.RecoveryURL = ""
+	}
 }
 
 type forgotPwdPage struct {
@@ -69,6 +93,7 @@ type forgotPwdPage struct {
 	StaticURL  string
 	Title      string
 	Branding   UIBranding
+	Captcha    *captchaData
 }
 
 type resetPwdPage struct {
@@ -78,6 +103,7 @@ type resetPwdPage struct {
 	StaticURL  string
 	Title      string
 	Branding   UIBranding
+	Captcha    *captchaData
 }
 
 func getSliceFromDelimitedValues(values, delimiter string) []string {
@@ -33,8 +33,11 @@ import (
 	sdkkms "github.com/sftpgo/sdk/kms"
 
 	"github.com/drakkan/sftpgo/v2/internal/acme"
+	"github.com/drakkan/sftpgo/v2/internal/broker"
+	"github.com/drakkan/sftpgo/v2/internal/cloudfunc"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
@@ -87,8 +90,11 @@ const (
 	templateEventRule        = "eventrule.html"
 	templateEventActions     = "eventactions.html"
 	templateEventAction      = "eventaction.html"
+	templateDeadLetters      = "deadletters.html"
 	templateRoles            = "roles.html"
 	templateRole             = "role.html"
+	templateTenants          = "tenants.html"
+	templateTenant           = "tenant.html"
 	templateEvents           = "events.html"
 	templateMessage          = "message.html"
 	templateStatus           = "status.html"
@@ -110,7 +116,9 @@ const (
 	pageGroupsTitle          = "Groups"
 	pageEventRulesTitle      = "Event rules"
 	pageEventActionsTitle    = "Event actions"
+	pageDeadLettersTitle     = "Dead letters"
 	pageRolesTitle           = "Roles"
+	pageTenantsTitle         = "Tenants"
 	pageProfileTitle         = "My profile"
 	pageChangePwdTitle       = "Change password"
 	pageMaintenanceTitle     = "Maintenance"
@@ -157,8 +165,11 @@ type basePage struct {
 	EventRuleURL        string
 	EventActionsURL     string
 	EventActionURL      string
+	DeadLettersURL      string
 	RolesURL            string
 	RoleURL             string
+	TenantsURL          string
+	TenantURL           string
 	FolderQuotaScanURL  string
 	StatusURL           string
 	MaintenanceURL      string
@@ -170,7 +181,9 @@ type basePage struct {
 	GroupsTitle         string
 	EventRulesTitle     string
 	EventActionsTitle   string
+	DeadLettersTitle    string
 	RolesTitle          string
+	TenantsTitle        string
 	StatusTitle         string
 	MaintenanceTitle    string
 	DefenderTitle       string
@@ -214,6 +227,11 @@ type rolesPage struct {
 	Roles []dataprovider.Role
 }
 
+type tenantsPage struct {
+	basePage
+	Tenants []dataprovider.Tenant
+}
+
 type eventRulesPage struct {
 	basePage
 	Rules []dataprovider.EventRule
@@ -224,9 +242,15 @@ type eventActionsPage struct {
 	Actions []dataprovider.BaseEventAction
 }
 
+type deadLettersPage struct {
+	basePage
+	DeadLetters []common.DeadLetter
+}
+
 type connectionsPage struct {
 	basePage
 	Connections []common.ConnectionStatus
+	Sessions    []common.WebSession
 }
 
 type statusPage struct {
@@ -264,11 +288,12 @@ type userPage struct {
 
 type adminPage struct {
 	basePage
-	Admin  *dataprovider.Admin
-	Groups []dataprovider.Group
-	Roles  []dataprovider.Role
-	Error  string
-	IsAdd  bool
+	Admin   *dataprovider.Admin
+	Groups  []dataprovider.Group
+	Roles   []dataprovider.Role
+	Tenants []dataprovider.Tenant
+	Error   string
+	IsAdd   bool
 }
 
 type profilePage struct {
@@ -292,6 +317,9 @@ type mfaPage struct {
 	ValidateTOTPURL string
 	SaveTOTPURL     string
 	RecCodesURL     string
+	WebAuthnEnabled bool
+	WebAuthnURL     string
+	WebAuthnKeys    []webAuthnCredentialInfo
 }
 
 type maintenancePage struct {
@@ -359,15 +387,32 @@ type rolePage struct {
 	Mode  genericPageMode
 }
 
+type tenantPage struct {
+	basePage
+	Tenant     *dataprovider.Tenant
+	Roles      []dataprovider.Role
+	Groups     []dataprovider.Group
+	Folders    []vfs.BaseVirtualFolder
+	EventRules []dataprovider.EventRule
+	Error      string
+	Mode       genericPageMode
+}
+
 type eventActionPage struct {
 	basePage
-	Action         dataprovider.BaseEventAction
-	ActionTypes    []dataprovider.EnumMapping
-	FsActions      []dataprovider.EnumMapping
-	HTTPMethods    []string
-	RedactedSecret string
-	Error          string
-	Mode           genericPageMode
+	Action                      dataprovider.BaseEventAction
+	ActionTypes                 []dataprovider.EnumMapping
+	FsActions                   []dataprovider.EnumMapping
+	ReplicationConflictPolicies []dataprovider.EnumMapping
+	ChecksumManifestModes       []dataprovider.EnumMapping
+	ChecksumAlgorithms          []string
+	RemoteEndpoints             []string
+	HTTPMethods                 []string
+	BrokerProtocols             []string
+	FunctionProviders           []string
+	RedactedSecret              string
+	Error                       string
+	Mode                        genericPageMode
 }
 
 type eventRulePage struct {
@@ -379,6 +424,7 @@ type eventRulePage struct {
 	Protocols       []string
 	ProviderEvents  []string
 	ProviderObjects []string
+	RunOnConditions []dataprovider.EnumMapping
 	Error           string
 	Mode            genericPageMode
 	IsShared        bool
@@ -392,10 +438,11 @@ type eventsPage struct {
 
 type configsPage struct {
 	basePage
-	Configs        dataprovider.Configs
-	ConfigSection  int
-	RedactedSecret string
-	Error          string
+	Configs                 dataprovider.Configs
+	ConfigSection           int
+	RedactedSecret          string
+	RemoteEndpointProtocols []dataprovider.EnumMapping
+	Error                   string
 }
 
 type messagePage struct {
@@ -479,6 +526,7 @@ func loadAdminTemplates(templatesPath string) {
 	eventRulesPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateSharedComponents),
 		filepath.Join(templatesPath, templateAdminDir, templateEventRules),
 	}
 	eventRulePaths := []string{
@@ -489,8 +537,14 @@ func loadAdminTemplates(templatesPath string) {
 	eventActionsPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateSharedComponents),
 		filepath.Join(templatesPath, templateAdminDir, templateEventActions),
 	}
+	deadLettersPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateDeadLetters),
+	}
 	eventActionPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -505,6 +559,7 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBaseLogin),
 		filepath.Join(templatesPath, templateAdminDir, templateLogin),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	maintenancePaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
@@ -530,11 +585,13 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateMFA),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonWebAuthn),
 	}
 	twoFactorPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBaseLogin),
 		filepath.Join(templatesPath, templateAdminDir, templateTwoFactor),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonWebAuthn),
 	}
 	twoFactorRecoveryPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
@@ -549,10 +606,12 @@ func loadAdminTemplates(templatesPath string) {
 	forgotPwdPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateCommonDir, templateForgotPassword),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	resetPwdPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateCommonDir, templateResetPassword),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	rolesPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
@@ -564,6 +623,16 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateRole),
 	}
+	tenantsPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateTenants),
+	}
+	tenantPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateTenant),
+	}
 	eventsPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -598,6 +667,7 @@ func loadAdminTemplates(templatesPath string) {
 	eventRuleTmpl := util.LoadTemplate(fsBaseTpl, eventRulePaths...)
 	eventActionsTmpl := util.LoadTemplate(nil, eventActionsPaths...)
 	eventActionTmpl := util.LoadTemplate(nil, eventActionPaths...)
+	deadLettersTmpl := util.LoadTemplate(nil, deadLettersPaths...)
 	statusTmpl := util.LoadTemplate(nil, statusPaths...)
 	loginTmpl := util.LoadTemplate(nil, loginPaths...)
 	profileTmpl := util.LoadTemplate(nil, profilePaths...)
@@ -614,6 +684,8 @@ func loadAdminTemplates(templatesPath string) {
 	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
 	rolesTmpl := util.LoadTemplate(nil, rolesPaths...)
 	roleTmpl := util.LoadTemplate(nil, rolePaths...)
+	tenantsTmpl := util.LoadTemplate(nil, tenantsPaths...)
+	tenantTmpl := util.LoadTemplate(fsBaseTpl, tenantPaths...)
 	eventsTmpl := util.LoadTemplate(nil, eventsPaths...)
 	configsTmpl := util.LoadTemplate(nil, configsPaths...)
 
@@ -631,6 +703,7 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateEventRule] = eventRuleTmpl
 	adminTemplates[templateEventActions] = eventActionsTmpl
 	adminTemplates[templateEventAction] = eventActionTmpl
+	adminTemplates[templateDeadLetters] = deadLettersTmpl
 	adminTemplates[templateStatus] = statusTmpl
 	adminTemplates[templateLogin] = loginTmpl
 	adminTemplates[templateProfile] = profileTmpl
@@ -647,6 +720,8 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateResetPassword] = resetPwdTmpl
 	adminTemplates[templateRoles] = rolesTmpl
 	adminTemplates[templateRole] = roleTmpl
+	adminTemplates[templateTenants] = tenantsTmpl
+	adminTemplates[templateTenant] = tenantTmpl
 	adminTemplates[templateEvents] = eventsTmpl
 	adminTemplates[templateConfigs] = configsTmpl
 }
@@ -658,6 +733,9 @@ func isEventManagerResource(currentURL string) bool {
 	if currentURL == webAdminEventActionsPath {
 		return true
 	}
+	if currentURL == webAdminDeadLettersPath {
+		return true
+	}
 	if currentURL == webAdminEventRulePath || strings.HasPrefix(currentURL, webAdminEventRulePath+"/") {
 		return true
 	}
@@ -716,8 +794,11 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 		EventRuleURL:        webAdminEventRulePath,
 		EventActionsURL:     webAdminEventActionsPath,
 		EventActionURL:      webAdminEventActionPath,
+		DeadLettersURL:      webAdminDeadLettersPath,
 		RolesURL:            webAdminRolesPath,
 		RoleURL:             webAdminRolePath,
+		TenantsURL:          webAdminTenantsPath,
+		TenantURL:           webAdminTenantPath,
 		QuotaScanURL:        webQuotaScanPath,
 		ConnectionsURL:      webConnectionsPath,
 		StatusURL:           webStatusPath,
@@ -731,7 +812,9 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 		GroupsTitle:         pageGroupsTitle,
 		EventRulesTitle:     pageEventRulesTitle,
 		EventActionsTitle:   pageEventActionsTitle,
+		DeadLettersTitle:    pageDeadLettersTitle,
 		RolesTitle:          pageRolesTitle,
+		TenantsTitle:        pageTenantsTitle,
 		StatusTitle:         pageStatusTitle,
 		MaintenanceTitle:    pageMaintenanceTitle,
 		DefenderTitle:       pageDefenderTitle,
@@ -801,6 +884,7 @@ func (s *httpdServer) renderForgotPwdPage(w http.ResponseWriter, error, ip strin
 		StaticURL:  webStaticFilesPath,
 		Title:      pageForgotPwdTitle,
 		Branding:   s.binding.Branding.WebAdmin,
+		Captcha:    loginProtection.getCaptchaData(ip),
 	}
 	renderAdminTemplate(w, templateForgotPassword, data)
 }
@@ -813,11 +897,12 @@ func (s *httpdServer) renderResetPwdPage(w http.ResponseWriter, error, ip string
 		StaticURL:  webStaticFilesPath,
 		Title:      pageResetPwdTitle,
 		Branding:   s.binding.Branding.WebAdmin,
+		Captcha:    loginProtection.getCaptchaData(ip),
 	}
 	renderAdminTemplate(w, templateResetPassword, data)
 }
 
-func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := twoFactorPage{
 		CurrentURL:  webAdminTwoFactorPath,
 		Version:     version.Get().Version,
@@ -825,11 +910,25 @@ func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, error, ip strin
 		CSRFToken:   createCSRFToken(ip),
 		StaticURL:   webStaticFilesPath,
 		RecoveryURL: webAdminTwoFactorRecoveryPath,
+		WebAuthnURL: webAdminTwoFactorWebAuthnPath,
 		Branding:    s.binding.Branding.WebAdmin,
 	}
+	if claims, err := getTokenClaims(r); err == nil && claims.Username != "" {
+		if admin, err := dataprovider.AdminExists(claims.Username); err == nil {
+			data.setSecondFactors(admin.Filters.TOTPConfig.Enabled, admin.HasWebAuthnCredentials(),
+				admin.MustUseWebAuthn())
+			data.RememberDays = getAdminRememberedDeviceOwner(&admin).days
+		}
+	}
 	renderAdminTemplate(w, templateTwoFactor, data)
 }
 
+func (s *httpdServer) getTwoFactorErrorFunc(r *http.Request) func(w http.ResponseWriter, error, ip string) {
+	return func(w http.ResponseWriter, error, ip string) {
+		s.renderTwoFactorPage(w, r, error, ip)
+	}
+}
+
 func (s *httpdServer) renderTwoFactorRecoveryPage(w http.ResponseWriter, error, ip string) {
 	data := twoFactorPage{
 		CurrentURL: webAdminTwoFactorRecoveryPath,
@@ -850,6 +949,8 @@ func (s *httpdServer) renderMFAPage(w http.ResponseWriter, r *http.Request) {
 		ValidateTOTPURL: webAdminTOTPValidatePath,
 		SaveTOTPURL:     webAdminTOTPSavePath,
 		RecCodesURL:     webAdminRecoveryCodesPath,
+		WebAuthnEnabled: mfa.IsWebAuthnEnabled(),
+		WebAuthnURL:     webAdminWebAuthnPath,
 	}
 	admin, err := dataprovider.AdminExists(data.LoggedAdmin.Username)
 	if err != nil {
@@ -857,6 +958,7 @@ func (s *httpdServer) renderMFAPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data.TOTPConfig = admin.Filters.TOTPConfig
+	data.WebAuthnKeys = getWebAuthnCredentialsInfo(admin.Filters.WebAuthnCredentials)
 	renderAdminTemplate(w, templateMFA, data)
 }
 
@@ -908,11 +1010,12 @@ func (s *httpdServer) renderConfigsPage(w http.ResponseWriter, r *http.Request,
 		configs.ACME.HTTP01Challenge.Port = 80
 	}
 	data := configsPage{
-		basePage:       s.getBasePageData(pageConfigsTitle, webConfigsPath, r),
-		Configs:        configs,
-		ConfigSection:  section,
-		RedactedSecret: redactedSecret,
-		Error:          error,
+		basePage:                s.getBasePageData(pageConfigsTitle, webConfigsPath, r),
+		Configs:                 configs,
+		ConfigSection:           section,
+		RedactedSecret:          redactedSecret,
+		RemoteEndpointProtocols: dataprovider.RemoteEndpointProtocols,
+		Error:                   error,
 	}
 
 	renderAdminTemplate(w, templateConfigs, data)
@@ -941,6 +1044,10 @@ func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Re
 	if err != nil {
 		return
 	}
+	tenants, err := s.getWebTenants(w, r, 10)
+	if err != nil {
+		return
+	}
 	currentURL := webAdminPath
 	title := "Add a new admin"
 	if !isAdd {
@@ -952,6 +1059,7 @@ func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Re
 		Admin:    admin,
 		Groups:   groups,
 		Roles:    roles,
+		Tenants:  tenants,
 		Error:    error,
 		IsAdd:    isAdd,
 	}
@@ -978,6 +1086,13 @@ func (s *httpdServer) getUserPageTitleAndURL(mode userPageMode, username string)
 func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, user *dataprovider.User,
 	mode userPageMode, errorString string, admin *dataprovider.Admin,
 ) {
+	// the filesystem section is hidden only while updating, for new users there
+	// is nothing to hide
+	hideFsConfig := isFsConfigHidden(r)
+	if hideFsConfig {
+		user.HideFsConfigs()
+		hideFsConfig = mode == userPageModeUpdate
+	}
 	user.SetEmptySecretsIfNil()
 	title, currentURL := s.getUserPageTitleAndURL(mode, user.Username)
 	if user.Password != "" && user.IsPasswordHashed() {
@@ -1033,7 +1148,7 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 			Filesystem:      user.FsConfig,
 			IsUserPage:      true,
 			IsGroupPage:     false,
-			IsHidden:        basePage.LoggedAdmin.Filters.Preferences.HideFilesystem(),
+			IsHidden:        basePage.LoggedAdmin.Filters.Preferences.HideFilesystem() || hideFsConfig,
 			HasUsersBaseDir: dataprovider.HasUsersBaseDir(),
 			DirPath:         user.HomeDir,
 		},
@@ -1083,6 +1198,47 @@ func (s *httpdServer) renderRolePage(w http.ResponseWriter, r *http.Request, rol
 	renderAdminTemplate(w, templateRole, data)
 }
 
+func (s *httpdServer) renderTenantPage(w http.ResponseWriter, r *http.Request, tenant dataprovider.Tenant,
+	mode genericPageMode, error string,
+) {
+	var title, currentURL string
+	switch mode {
+	case genericPageModeAdd:
+		title = "Add a new tenant"
+		currentURL = webAdminTenantPath
+	case genericPageModeUpdate:
+		title = "Update tenant"
+		currentURL = fmt.Sprintf("%s/%s", webAdminTenantPath, url.PathEscape(tenant.Name))
+	}
+	roles, err := s.getWebRoles(w, r, 10, true)
+	if err != nil {
+		return
+	}
+	groups, err := s.getWebGroups(w, r, defaultQueryLimit, true)
+	if err != nil {
+		return
+	}
+	folders, err := s.getWebVirtualFolders(w, r, defaultQueryLimit, true)
+	if err != nil {
+		return
+	}
+	rules, err := s.getWebEventRules(w, r, defaultQueryLimit)
+	if err != nil {
+		return
+	}
+	data := tenantPage{
+		basePage:   s.getBasePageData(title, currentURL, r),
+		Error:      error,
+		Tenant:     &tenant,
+		Roles:      roles,
+		Groups:     groups,
+		Folders:    folders,
+		EventRules: rules,
+		Mode:       mode,
+	}
+	renderAdminTemplate(w, templateTenant, data)
+}
+
 func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, group dataprovider.Group,
 	mode genericPageMode, error string,
 ) {
@@ -1090,6 +1246,10 @@ func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, gr
 	if err != nil {
 		return
 	}
+	hideFsConfig := isFsConfigHidden(r)
+	if hideFsConfig {
+		group.HideFsConfigs()
+	}
 	group.SetEmptySecretsIfNil()
 	group.UserSettings.FsConfig.RedactedSecret = redactedSecret
 	var title, currentURL string
@@ -1119,6 +1279,7 @@ func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, gr
 			Filesystem:      group.UserSettings.FsConfig,
 			IsUserPage:      false,
 			IsGroupPage:     true,
+			IsHidden:        hideFsConfig && mode == genericPageModeUpdate,
 			HasUsersBaseDir: false,
 			DirPath:         group.UserSettings.HomeDir,
 		},
@@ -1148,16 +1309,35 @@ func (s *httpdServer) renderEventActionPage(w http.ResponseWriter, r *http.Reque
 	if action.Options.PwdExpirationConfig.Threshold == 0 {
 		action.Options.PwdExpirationConfig.Threshold = 10
 	}
+	if action.Options.ChecksumConfig.Algorithm == "" {
+		action.Options.ChecksumConfig.Algorithm = "sha256"
+	}
+	var remoteEndpoints []string
+	if configs, err := dataprovider.GetConfigs(); err == nil {
+		for _, endpoint := range configs.RemoteEndpoints {
+			remoteEndpoints = append(remoteEndpoints, endpoint.Name)
+		}
+	}
+	// the configured endpoint could be deleted, we still want to display it
+	if action.Options.PushConfig.Endpoint != "" && !util.Contains(remoteEndpoints, action.Options.PushConfig.Endpoint) {
+		remoteEndpoints = append(remoteEndpoints, action.Options.PushConfig.Endpoint)
+	}
 
 	data := eventActionPage{
-		basePage:       s.getBasePageData(title, currentURL, r),
-		Action:         action,
-		ActionTypes:    dataprovider.EventActionTypes,
-		FsActions:      dataprovider.FsActionTypes,
-		HTTPMethods:    dataprovider.SupportedHTTPActionMethods,
-		RedactedSecret: redactedSecret,
-		Error:          error,
-		Mode:           mode,
+		basePage:                    s.getBasePageData(title, currentURL, r),
+		Action:                      action,
+		ActionTypes:                 dataprovider.EventActionTypes,
+		FsActions:                   dataprovider.FsActionTypes,
+		ReplicationConflictPolicies: dataprovider.ReplicationConflictPolicies,
+		ChecksumManifestModes:       dataprovider.ChecksumManifestModes,
+		ChecksumAlgorithms:          dataprovider.SupportedChecksumManifestAlgorithms,
+		RemoteEndpoints:             remoteEndpoints,
+		HTTPMethods:                 dataprovider.SupportedHTTPActionMethods,
+		BrokerProtocols:             broker.SupportedProtocols,
+		FunctionProviders:           cloudfunc.SupportedProviders,
+		RedactedSecret:              redactedSecret,
+		Error:                       error,
+		Mode:                        mode,
 	}
 	renderAdminTemplate(w, templateEventAction, data)
 }
@@ -1188,6 +1368,7 @@ func (s *httpdServer) renderEventRulePage(w http.ResponseWriter, r *http.Request
 		Protocols:       dataprovider.SupportedRuleConditionProtocols,
 		ProviderEvents:  dataprovider.SupportedProviderEvents,
 		ProviderObjects: dataprovider.SupporteRuleConditionProviderObjects,
+		RunOnConditions: dataprovider.ActionRunOnConditions,
 		Error:           error,
 		Mode:            mode,
 		IsShared:        s.isShared > 0,
@@ -1210,6 +1391,10 @@ func (s *httpdServer) renderFolderPage(w http.ResponseWriter, r *http.Request, f
 		title = "Folder template"
 		currentURL = webTemplateFolder
 	}
+	hideFsConfig := isFsConfigHidden(r)
+	if hideFsConfig {
+		folder.FsConfig.HideConfig()
+	}
 	folder.FsConfig.RedactedSecret = redactedSecret
 	folder.FsConfig.SetEmptySecretsIfNil()
 
@@ -1222,6 +1407,7 @@ func (s *httpdServer) renderFolderPage(w http.ResponseWriter, r *http.Request, f
 			Filesystem:      folder.FsConfig,
 			IsUserPage:      false,
 			IsGroupPage:     false,
+			IsHidden:        hideFsConfig && mode == folderPageModeUpdate,
 			HasUsersBaseDir: false,
 			DirPath:         folder.MappedPath,
 		},
@@ -1288,6 +1474,8 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 	folderNames := r.Form["vfolder_name"]
 	folderQuotaSizes := r.Form["vfolder_quota_size"]
 	folderQuotaFiles := r.Form["vfolder_quota_files"]
+	folderUserQuotaSizes := r.Form["vfolder_user_quota_size"]
+	folderUserQuotaFiles := r.Form["vfolder_user_quota_files"]
 	for idx, p := range folderPaths {
 		p = strings.TrimSpace(p)
 		name := ""
@@ -1315,6 +1503,18 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 					vfolder.QuotaFiles = quotaFiles
 				}
 			}
+			if len(folderUserQuotaSizes) > idx {
+				quotaSize, err := util.ParseBytes(folderUserQuotaSizes[idx])
+				if err == nil {
+					vfolder.UserQuotaSize = quotaSize
+				}
+			}
+			if len(folderUserQuotaFiles) > idx {
+				quotaFiles, err := strconv.Atoi(strings.TrimSpace(folderUserQuotaFiles[idx]))
+				if err == nil {
+					vfolder.UserQuotaFiles = quotaFiles
+				}
+			}
 			virtualFolders = append(virtualFolders, vfolder)
 		}
 	}
@@ -1424,6 +1624,110 @@ func getBandwidthLimitsFromPostFields(r *http.Request) ([]sdk.BandwidthLimit, er
 	return result, nil
 }
 
+func getBandwidthSchedulesFromPostFields(r *http.Request) ([]dataprovider.BandwidthSchedule, error) {
+	var result []dataprovider.BandwidthSchedule
+
+	for k := range r.Form {
+		if strings.HasPrefix(k, "bandwidth_schedule_from") {
+			from := strings.TrimSpace(r.Form.Get(k))
+			idx := strings.TrimPrefix(k, "bandwidth_schedule_from")
+			to := strings.TrimSpace(r.Form.Get(fmt.Sprintf("bandwidth_schedule_to%s", idx)))
+			if from == "" && to == "" {
+				continue
+			}
+			schedule := dataprovider.BandwidthSchedule{
+				From: from,
+				To:   to,
+			}
+			for _, val := range r.Form[fmt.Sprintf("bandwidth_schedule_days%s", idx)] {
+				day, err := strconv.Atoi(val)
+				if err != nil {
+					return result, fmt.Errorf("invalid bandwidth_schedule_days%s %q: %w", idx, val, err)
+				}
+				schedule.Days = append(schedule.Days, day)
+			}
+			ul := r.Form.Get(fmt.Sprintf("bandwidth_schedule_ul%s", idx))
+			dl := r.Form.Get(fmt.Sprintf("bandwidth_schedule_dl%s", idx))
+			if ul != "" {
+				bandwidthUL, err := strconv.ParseInt(ul, 10, 64)
+				if err != nil {
+					return result, fmt.Errorf("invalid bandwidth_schedule_ul%s %q: %w", idx, ul, err)
+				}
+				schedule.UploadBandwidth = bandwidthUL
+			}
+			if dl != "" {
+				bandwidthDL, err := strconv.ParseInt(dl, 10, 64)
+				if err != nil {
+					return result, fmt.Errorf("invalid bandwidth_schedule_dl%s %q: %w", idx, dl, err)
+				}
+				schedule.DownloadBandwidth = bandwidthDL
+			}
+			result = append(result, schedule)
+		}
+	}
+
+	return result, nil
+}
+
+func getAccessTimeWindowsFromPostFields(r *http.Request) ([]dataprovider.AccessTimeWindow, error) {
+	var result []dataprovider.AccessTimeWindow
+
+	for k := range r.Form {
+		if strings.HasPrefix(k, "access_time_from") {
+			from := strings.TrimSpace(r.Form.Get(k))
+			idx := strings.TrimPrefix(k, "access_time_from")
+			to := strings.TrimSpace(r.Form.Get(fmt.Sprintf("access_time_to%s", idx)))
+			if from == "" && to == "" {
+				continue
+			}
+			window := dataprovider.AccessTimeWindow{
+				From: from,
+				To:   to,
+			}
+			for _, val := range r.Form[fmt.Sprintf("access_time_days%s", idx)] {
+				day, err := strconv.Atoi(val)
+				if err != nil {
+					return result, fmt.Errorf("invalid access_time_days%s %q: %w", idx, val, err)
+				}
+				window.Days = append(window.Days, day)
+			}
+			result = append(result, window)
+		}
+	}
+
+	return result, nil
+}
+
+func getASNsFromPostField(r *http.Request, field string) ([]uint, error) {
+	var result []uint
+
+	for _, val := range getSliceFromDelimitedValues(r.Form.Get(field), ",") {
+		asn, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", field, val, err)
+		}
+		result = append(result, uint(asn))
+	}
+	return result, nil
+}
+
+func getGeoIPFilterFromPostFields(r *http.Request) (geoip.Filter, error) {
+	allowedASNs, err := getASNsFromPostField(r, "geoip_allowed_asns")
+	if err != nil {
+		return geoip.Filter{}, err
+	}
+	deniedASNs, err := getASNsFromPostField(r, "geoip_denied_asns")
+	if err != nil {
+		return geoip.Filter{}, err
+	}
+	return geoip.Filter{
+		AllowedCountries: getSliceFromDelimitedValues(r.Form.Get("geoip_allowed_countries"), ","),
+		DeniedCountries:  getSliceFromDelimitedValues(r.Form.Get("geoip_denied_countries"), ","),
+		AllowedASNs:      allowedASNs,
+		DeniedASNs:       deniedASNs,
+	}, nil
+}
+
 func getPatterDenyPolicyFromString(policy string) int {
 	denyPolicy := sdk.DenyPolicyDefault
 	if policy == "1" {
@@ -1432,6 +1736,22 @@ func getPatterDenyPolicyFromString(policy string) int {
 	return denyPolicy
 }
 
+func getContentTypesFromPostField(r *http.Request) ([]dataprovider.ContentTypesFilter, error) {
+	var result []dataprovider.ContentTypesFilter
+	for _, line := range getSliceFromDelimitedValues(r.Form.Get("content_types"), "\n") {
+		fields := strings.Split(line, "::")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid content types filter %q, the expected format is path::allowed types::denied types", line)
+		}
+		result = append(result, dataprovider.ContentTypesFilter{
+			Path:         strings.TrimSpace(fields[0]),
+			AllowedTypes: getSliceFromDelimitedValues(fields[1], ","),
+			DeniedTypes:  getSliceFromDelimitedValues(fields[2], ","),
+		})
+	}
+	return result, nil
+}
+
 func getFilePatternsFromPostField(r *http.Request) []sdk.PatternsFilter {
 	var result []sdk.PatternsFilter
 
@@ -1595,6 +1915,70 @@ func getSecretFromFormField(r *http.Request, field string) *kms.Secret {
 	return secret
 }
 
+// getStorageClassRulesFromPostField parses the storage class rules, one per line,
+// in the format "pattern,min size,storage class"
+func getStorageClassRulesFromPostField(r *http.Request, field string) ([]vfs.StorageClassRule, error) {
+	var rules []vfs.StorageClassRule
+	for _, line := range getSliceFromDelimitedValues(r.Form.Get(field), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid storage class rule %q, the expected format is pattern,min size,storage class", line)
+		}
+		minSize := int64(0)
+		if val := strings.TrimSpace(fields[1]); val != "" {
+			size, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min size for storage class rule %q: %w", line, err)
+			}
+			minSize = size
+		}
+		rules = append(rules, vfs.StorageClassRule{
+			Pattern:      strings.TrimSpace(fields[0]),
+			MinSize:      minSize,
+			StorageClass: strings.TrimSpace(fields[2]),
+		})
+	}
+	return rules, nil
+}
+
+func getCloudRetryPolicyFromPostFields(r *http.Request, prefix string) (vfs.CloudRetryPolicy, error) {
+	var policy vfs.CloudRetryPolicy
+	fields := []struct {
+		name  string
+		value *int
+	}{
+		{name: "max_retries", value: &policy.MaxRetries},
+		{name: "retry_base_delay", value: &policy.RetryBaseDelay},
+		{name: "retry_max_delay", value: &policy.RetryMaxDelay},
+	}
+	for _, field := range fields {
+		val := strings.TrimSpace(r.Form.Get(prefix + "_" + field.name))
+		if val == "" {
+			continue
+		}
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s %s: %w", prefix, strings.ReplaceAll(field.name, "_", " "), err)
+		}
+		*field.value = v
+	}
+	return policy, nil
+}
+
+func getCredentialsRotationFromPostFields(r *http.Request, prefix string) (vfs.CredentialsRotation, error) {
+	var rotation vfs.CredentialsRotation
+	val := strings.TrimSpace(r.Form.Get(prefix + "_rotation_interval"))
+	if val == "" {
+		return rotation, nil
+	}
+	interval, err := strconv.Atoi(val)
+	if err != nil {
+		return rotation, fmt.Errorf("invalid %s rotation interval: %w", prefix, err)
+	}
+	rotation.RotationInterval = interval
+	return rotation, nil
+}
+
 func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	var err error
 	config := vfs.S3FsConfig{}
@@ -1605,6 +1989,10 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	config.AccessSecret = getSecretFromFormField(r, "s3_access_secret")
 	config.Endpoint = strings.TrimSpace(r.Form.Get("s3_endpoint"))
 	config.StorageClass = strings.TrimSpace(r.Form.Get("s3_storage_class"))
+	config.StorageClassRules, err = getStorageClassRulesFromPostField(r, "s3_storage_class_rules")
+	if err != nil {
+		return config, err
+	}
 	config.ACL = strings.TrimSpace(r.Form.Get("s3_acl"))
 	config.KeyPrefix = r.Form.Get("s3_key_prefix")
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("s3_upload_part_size"), 10, 64)
@@ -1624,6 +2012,7 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 		return config, fmt.Errorf("invalid s3 download concurrency: %w", err)
 	}
 	config.ForcePathStyle = r.Form.Get("s3_force_path_style") != ""
+	config.EmulateSymlinks = r.Form.Get("s3_emulate_symlinks") != ""
 	config.DownloadPartMaxTime, err = strconv.Atoi(r.Form.Get("s3_download_part_max_time"))
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 download part max time: %w", err)
@@ -1632,6 +2021,14 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 upload part max time: %w", err)
 	}
+	config.CloudRetryPolicy, err = getCloudRetryPolicyFromPostFields(r, "s3")
+	if err != nil {
+		return config, err
+	}
+	config.CredentialsRotation, err = getCredentialsRotationFromPostFields(r, "s3")
+	if err != nil {
+		return config, err
+	}
 	return config, nil
 }
 
@@ -1641,7 +2038,20 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 
 	config.Bucket = strings.TrimSpace(r.Form.Get("gcs_bucket"))
 	config.StorageClass = strings.TrimSpace(r.Form.Get("gcs_storage_class"))
+	config.StorageClassRules, err = getStorageClassRulesFromPostField(r, "gcs_storage_class_rules")
+	if err != nil {
+		return config, err
+	}
 	config.ACL = strings.TrimSpace(r.Form.Get("gcs_acl"))
+	config.EmulateSymlinks = r.Form.Get("gcs_emulate_symlinks") != ""
+	config.CloudRetryPolicy, err = getCloudRetryPolicyFromPostFields(r, "gcs")
+	if err != nil {
+		return config, err
+	}
+	config.CredentialsRotation, err = getCredentialsRotationFromPostFields(r, "gcs")
+	if err != nil {
+		return config, err
+	}
 	config.KeyPrefix = r.Form.Get("gcs_key_prefix")
 	uploadPartSize, err := strconv.ParseInt(r.Form.Get("gcs_upload_part_size"), 10, 64)
 	if err == nil {
@@ -1688,6 +2098,11 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	fingerprintsFormValue := r.Form.Get("sftp_fingerprints")
 	config.Fingerprints = getSliceFromDelimitedValues(fingerprintsFormValue, "\n")
 	config.Prefix = r.Form.Get("sftp_prefix")
+	config.ProxyURL = strings.TrimSpace(r.Form.Get("sftp_proxy_url"))
+	config.JumpHost = strings.TrimSpace(r.Form.Get("sftp_jump_host"))
+	config.JumpHostUsername = r.Form.Get("sftp_jump_host_username")
+	config.JumpHostFingerprints = getSliceFromDelimitedValues(r.Form.Get("sftp_jump_host_fingerprints"), "\n")
+	config.Compression = r.Form.Get("sftp_compression")
 	config.DisableCouncurrentReads = r.Form.Get("sftp_disable_concurrent_reads") != ""
 	config.BufferSize, err = strconv.ParseInt(r.Form.Get("sftp_buffer_size"), 10, 64)
 	if r.Form.Get("sftp_equality_check_mode") != "" {
@@ -1708,6 +2123,10 @@ func getHTTPFsConfig(r *http.Request) vfs.HTTPFsConfig {
 	config.SkipTLSVerify = r.Form.Get("http_skip_tls_verify") != ""
 	config.Password = getSecretFromFormField(r, "http_password")
 	config.APIKey = getSecretFromFormField(r, "http_api_key")
+	config.OAuth2.TokenURL = strings.TrimSpace(r.Form.Get("http_oauth2_token_url"))
+	config.OAuth2.ClientID = strings.TrimSpace(r.Form.Get("http_oauth2_client_id"))
+	config.OAuth2.ClientSecret = getSecretFromFormField(r, "http_oauth2_client_secret")
+	config.OAuth2.Scopes = getSliceFromDelimitedValues(r.Form.Get("http_oauth2_scopes"), ",")
 	if r.Form.Get("http_equality_check_mode") != "" {
 		config.EqualityCheckMode = 1
 	} else {
@@ -1727,6 +2146,7 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.KeyPrefix = r.Form.Get("az_key_prefix")
 	config.AccessTier = strings.TrimSpace(r.Form.Get("az_access_tier"))
 	config.UseEmulator = r.Form.Get("az_use_emulator") != ""
+	config.EmulateSymlinks = r.Form.Get("az_emulate_symlinks") != ""
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("az_upload_part_size"), 10, 64)
 	if err != nil {
 		return config, fmt.Errorf("invalid azure upload part size: %w", err)
@@ -1743,6 +2163,31 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid azure download concurrency: %w", err)
 	}
+	config.CloudRetryPolicy, err = getCloudRetryPolicyFromPostFields(r, "az")
+	if err != nil {
+		return config, err
+	}
+	config.CredentialsRotation, err = getCredentialsRotationFromPostFields(r, "az")
+	if err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func getOSConfig(r *http.Request) (vfs.OSFsConfig, error) {
+	var err error
+	config := vfs.OSFsConfig{
+		Compression: r.Form.Get("osfs_compression"),
+		Umask:       strings.TrimSpace(r.Form.Get("osfs_umask")),
+	}
+	config.UID, err = getOptionalIntFromPostField(r, "osfs_uid")
+	if err != nil {
+		return config, fmt.Errorf("invalid folder uid: %w", err)
+	}
+	config.GID, err = getOptionalIntFromPostField(r, "osfs_gid")
+	if err != nil {
+		return config, fmt.Errorf("invalid folder gid: %w", err)
+	}
 	return config, nil
 }
 
@@ -1750,6 +2195,12 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 	var fs vfs.Filesystem
 	fs.Provider = sdk.GetProviderByName(r.Form.Get("fs_provider"))
 	switch fs.Provider {
+	case sdk.LocalFilesystemProvider:
+		config, err := getOSConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.OSConfig = config
 	case sdk.S3FilesystemProvider:
 		config, err := getS3Config(r)
 		if err != nil {
@@ -1807,6 +2258,30 @@ func getAdminHiddenUserPageSections(r *http.Request) int {
 	return result
 }
 
+func getRememberedDevicesPolicyFromPostFields(r *http.Request) (dataprovider.RememberedDevicesPolicy, error) {
+	var policy dataprovider.RememberedDevicesPolicy
+	var err error
+	if val := r.Form.Get("remembered_devices_days"); val != "" {
+		policy.Days, err = strconv.Atoi(val)
+		if err != nil {
+			return policy, fmt.Errorf("invalid remembered devices days: %w", err)
+		}
+	}
+	if val := r.Form.Get("remembered_devices_bind_ip"); val != "" {
+		policy.BindToIP, err = strconv.Atoi(val)
+		if err != nil {
+			return policy, fmt.Errorf("invalid remembered devices IP binding: %w", err)
+		}
+	}
+	if val := r.Form.Get("remembered_devices_bind_ua"); val != "" {
+		policy.BindToUserAgent, err = strconv.Atoi(val)
+		if err != nil {
+			return policy, fmt.Errorf("invalid remembered devices user agent binding: %w", err)
+		}
+	}
+	return policy, nil
+}
+
 func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	var admin dataprovider.Admin
 	err := r.ParseForm()
@@ -1823,8 +2298,15 @@ func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	admin.Email = r.Form.Get("email")
 	admin.Status = status
 	admin.Role = r.Form.Get("role")
+	admin.Filters.Tenant = r.Form.Get("tenant")
 	admin.Filters.AllowList = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
 	admin.Filters.AllowAPIKeyAuth = r.Form.Get("allow_api_key_auth") != ""
+	admin.Filters.RequireWebAuthn = r.Form.Get("require_webauthn") != ""
+	admin.Filters.HideFsConfig = r.Form.Get("hide_fs_config") != ""
+	admin.Filters.RememberedDevices, err = getRememberedDevicesPolicyFromPostFields(r)
+	if err != nil {
+		return admin, err
+	}
 	admin.AdditionalInfo = r.Form.Get("additional_info")
 	admin.Description = r.Form.Get("description")
 	admin.Filters.Preferences.HideUserPageSections = getAdminHiddenUserPageSections(r)
@@ -1856,7 +2338,20 @@ func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 				admin.Groups = append(admin.Groups, group)
 			}
 		}
+		if strings.HasPrefix(k, "grant_group") {
+			groupName := strings.TrimSpace(r.Form.Get(k))
+			if groupName != "" {
+				idx := strings.TrimPrefix(k, "grant_group")
+				admin.Filters.Grants = append(admin.Filters.Grants, dataprovider.AdminPermissionGrant{
+					Group:       groupName,
+					Permissions: r.Form[fmt.Sprintf("grant_permissions%s", idx)],
+				})
+			}
+		}
 	}
+	sort.Slice(admin.Filters.Grants, func(i, j int) bool {
+		return admin.Filters.Grants[i].Group < admin.Filters.Grants[j].Group
+	})
 	return admin, nil
 }
 
@@ -2067,6 +2562,46 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 	if err != nil {
 		return user, err
 	}
+	trashRetention, err := strconv.Atoi(r.Form.Get("trash_retention"))
+	if err != nil {
+		return user, fmt.Errorf("invalid trash retention: %w", err)
+	}
+	maxTransfers, err := strconv.Atoi(r.Form.Get("max_transfers"))
+	if err != nil {
+		return user, fmt.Errorf("invalid max transfers: %w", err)
+	}
+	transfersQueueTimeout, err := strconv.Atoi(r.Form.Get("transfers_queue_timeout"))
+	if err != nil {
+		return user, fmt.Errorf("invalid transfers queue timeout: %w", err)
+	}
+	maxDirEntries, err := strconv.Atoi(r.Form.Get("max_dir_entries"))
+	if err != nil {
+		return user, fmt.Errorf("invalid max dir entries: %w", err)
+	}
+	maxPathDepth, err := strconv.Atoi(r.Form.Get("max_path_depth"))
+	if err != nil {
+		return user, fmt.Errorf("invalid max path depth: %w", err)
+	}
+	bandwidthSchedules, err := getBandwidthSchedulesFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	rememberedDevices, err := getRememberedDevicesPolicyFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	accessTimeWindows, err := getAccessTimeWindowsFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	geoIPFilter, err := getGeoIPFilterFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	contentTypes, err := getContentTypesFromPostField(r)
+	if err != nil {
+		return user, err
+	}
 	user = dataprovider.User{
 		BaseUser: sdk.BaseUser{
 			Username:             r.Form.Get("username"),
@@ -2094,6 +2629,28 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		Filters: dataprovider.UserFilters{
 			BaseUserFilters:       filters,
 			RequirePasswordChange: r.Form.Get("require_password_change") != "",
+			Trash: dataprovider.UserTrashConfig{
+				Enabled:   r.Form.Get("trash_enabled") != "",
+				Retention: trashRetention,
+			},
+			AllowedTCPForwards:          getSliceFromDelimitedValues(r.Form.Get("allowed_tcp_forwards"), ","),
+			SSHAlgorithms:               getSSHAlgorithmsFromPostFields(r),
+			AnonymousHTTPPaths:          getSliceFromDelimitedValues(r.Form.Get("anonymous_http_paths"), ","),
+			BandwidthSchedules:          bandwidthSchedules,
+			MaxTransfers:                maxTransfers,
+			TransfersQueueTimeout:       transfersQueueTimeout,
+			MaxDirEntries:               maxDirEntries,
+			MaxPathDepth:                maxPathDepth,
+			ContentTypes:                contentTypes,
+			DLPPolicies:                 getSliceFromDelimitedValues(r.Form.Get("dlp_policies"), ","),
+			AccessTimeWindows:           accessTimeWindows,
+			AccessTimeZone:              strings.TrimSpace(r.Form.Get("access_time_zone")),
+			DisconnectOutsideAccessTime: r.Form.Get("disconnect_outside_access_time") != "",
+			GeoIP:                       geoIPFilter,
+			IPApproval:                  r.Form.Get("ip_approval"),
+			TLSCertPins:                 r.Form["tls_cert_pins"],
+			RequireWebAuthn:             r.Form.Get("require_webauthn") != "",
+			RememberedDevices:           rememberedDevices,
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 		FsConfig:       fsConfig,
@@ -2126,6 +2683,26 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 	if err != nil {
 		return group, fmt.Errorf("invalid download bandwidth: %w", err)
 	}
+	aggregateUL, err := strconv.ParseInt(r.Form.Get("aggregate_upload_bandwidth"), 10, 64)
+	if err != nil {
+		return group, fmt.Errorf("invalid aggregate upload bandwidth: %w", err)
+	}
+	aggregateDL, err := strconv.ParseInt(r.Form.Get("aggregate_download_bandwidth"), 10, 64)
+	if err != nil {
+		return group, fmt.Errorf("invalid aggregate download bandwidth: %w", err)
+	}
+	bandwidthSchedules, err := getBandwidthSchedulesFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
+	rememberedDevices, err := getRememberedDevicesPolicyFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
+	accessTimeWindows, err := getAccessTimeWindowsFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
 	dataTransferUL, dataTransferDL, dataTransferTotal, err := getTransferLimits(r)
 	if err != nil {
 		return group, err
@@ -2134,6 +2711,14 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 	if err != nil {
 		return group, fmt.Errorf("invalid expires in: %w", err)
 	}
+	expirationWarningThreshold, err := getOptionalIntFromPostField(r, "expiration_warning_threshold")
+	if err != nil {
+		return group, fmt.Errorf("invalid expiration warning threshold: %w", err)
+	}
+	inactivityThreshold, err := getOptionalIntFromPostField(r, "inactivity_threshold")
+	if err != nil {
+		return group, fmt.Errorf("invalid inactivity threshold: %w", err)
+	}
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		return group, err
@@ -2162,13 +2747,34 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 				ExpiresIn:            expiresIn,
 				Filters:              filters,
 			},
-			FsConfig: fsConfig,
+			FsConfig:                    fsConfig,
+			BandwidthSchedules:          bandwidthSchedules,
+			AggregateUploadBandwidth:    aggregateUL,
+			AggregateDownloadBandwidth:  aggregateDL,
+			DLPPolicies:                 getSliceFromDelimitedValues(r.Form.Get("dlp_policies"), ","),
+			ExpirationWarningThreshold:  expirationWarningThreshold,
+			InactivityThreshold:         inactivityThreshold,
+			AccessTimeWindows:           accessTimeWindows,
+			AccessTimeZone:              strings.TrimSpace(r.Form.Get("access_time_zone")),
+			DisconnectOutsideAccessTime: r.Form.Get("disconnect_outside_access_time") != "",
+			RequireWebAuthn:             r.Form.Get("require_webauthn") != "",
+			RememberedDevices:           rememberedDevices,
+			SSHAlgorithms:               getSSHAlgorithmsFromPostFields(r),
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
+		IncludedGroups: getSliceFromDelimitedValues(r.Form.Get("included_groups"), ","),
 	}
 	return group, nil
 }
 
+func getSSHAlgorithmsFromPostFields(r *http.Request) dataprovider.UserSSHAlgorithms {
+	return dataprovider.UserSSHAlgorithms{
+		KexAlgorithms: getSliceFromDelimitedValues(r.Form.Get("ssh_kex_algorithms"), ","),
+		Ciphers:       getSliceFromDelimitedValues(r.Form.Get("ssh_ciphers"), ","),
+		MACs:          getSliceFromDelimitedValues(r.Form.Get("ssh_macs"), ","),
+	}
+}
+
 func getKeyValsFromPostFields(r *http.Request, key, val string) []dataprovider.KeyValue {
 	var res []dataprovider.KeyValue
 	for k := range r.Form {
@@ -2204,6 +2810,7 @@ func getFoldersRetentionFromPostFields(r *http.Request) ([]dataprovider.FolderRe
 					Retention:             retention,
 					DeleteEmptyDirs:       util.Contains(options, "1"),
 					IgnoreUserPermissions: util.Contains(options, "2"),
+					ArchiveFolder:         strings.TrimSpace(r.Form.Get(fmt.Sprintf("folder_retention_archive%s", idx))),
 				})
 			}
 		}
@@ -2272,16 +2879,64 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 	if err != nil {
 		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid password expiration threshold: %w", err)
 	}
-	var emailAttachments []string
-	if r.Form.Get("email_attachments") != "" {
-		emailAttachments = getSliceFromDelimitedValues(r.Form.Get("email_attachments"), ",")
+	lifecycleExpirationThreshold, err := getOptionalIntFromPostField(r, "lifecycle_expiration_threshold")
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid account expiration threshold: %w", err)
 	}
-	var cmdArgs []string
-	if r.Form.Get("cmd_arguments") != "" {
-		cmdArgs = getSliceFromDelimitedValues(r.Form.Get("cmd_arguments"), ",")
+	lifecycleInactivityThreshold, err := getOptionalIntFromPostField(r, "lifecycle_inactivity_threshold")
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid inactivity threshold: %w", err)
 	}
-	options := dataprovider.BaseEventActionOptions{
-		HTTPConfig: dataprovider.EventActionHTTPConfig{
+	brokerTimeout, err := strconv.Atoi(r.Form.Get("broker_timeout"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid message broker timeout: %w", err)
+	}
+	functionTimeout, err := strconv.Atoi(r.Form.Get("function_timeout"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid cloud function timeout: %w", err)
+	}
+	replicationConflictPolicy, err := strconv.Atoi(r.Form.Get("replication_conflict_policy"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid replication conflict policy: %w", err)
+	}
+	replicationMaxRetries, err := strconv.Atoi(r.Form.Get("replication_max_retries"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid replication max retries: %w", err)
+	}
+	thumbnailWidth, err := strconv.Atoi(r.Form.Get("thumbnail_width"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid thumbnail width: %w", err)
+	}
+	thumbnailHeight, err := strconv.Atoi(r.Form.Get("thumbnail_height"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid thumbnail height: %w", err)
+	}
+	thumbnailQuality, err := strconv.Atoi(r.Form.Get("thumbnail_quality"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid thumbnail quality: %w", err)
+	}
+	thumbnailMaxFileSize, err := strconv.ParseInt(r.Form.Get("thumbnail_max_file_size"), 10, 64)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid thumbnail max file size: %w", err)
+	}
+	thumbnailTimeout, err := strconv.Atoi(r.Form.Get("thumbnail_timeout"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid thumbnail PDF command timeout: %w", err)
+	}
+	checksumMode, err := strconv.Atoi(r.Form.Get("checksum_mode"))
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid checksum manifest mode: %w", err)
+	}
+	var emailAttachments []string
+	if r.Form.Get("email_attachments") != "" {
+		emailAttachments = getSliceFromDelimitedValues(r.Form.Get("email_attachments"), ",")
+	}
+	var cmdArgs []string
+	if r.Form.Get("cmd_arguments") != "" {
+		cmdArgs = getSliceFromDelimitedValues(r.Form.Get("cmd_arguments"), ",")
+	}
+	options := dataprovider.BaseEventActionOptions{
+		HTTPConfig: dataprovider.EventActionHTTPConfig{
 			Endpoint:        r.Form.Get("http_endpoint"),
 			Username:        r.Form.Get("http_username"),
 			Password:        getSecretFromFormField(r, "http_password"),
@@ -2292,21 +2947,25 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 			QueryParameters: getKeyValsFromPostFields(r, "http_query_key", "http_query_val"),
 			Body:            r.Form.Get("http_body"),
 			Parts:           getHTTPPartsFromPostFields(r),
+			TextTemplate:    r.Form.Get("http_text_template") != "",
 		},
 		CmdConfig: dataprovider.EventActionCommandConfig{
-			Cmd:     r.Form.Get("cmd_path"),
-			Args:    cmdArgs,
-			Timeout: cmdTimeout,
-			EnvVars: getKeyValsFromPostFields(r, "cmd_env_key", "cmd_env_val"),
+			Cmd:          r.Form.Get("cmd_path"),
+			Args:         cmdArgs,
+			Timeout:      cmdTimeout,
+			EnvVars:      getKeyValsFromPostFields(r, "cmd_env_key", "cmd_env_val"),
+			TextTemplate: r.Form.Get("cmd_text_template") != "",
 		},
 		EmailConfig: dataprovider.EventActionEmailConfig{
-			Recipients:  getSliceFromDelimitedValues(r.Form.Get("email_recipients"), ","),
-			Subject:     r.Form.Get("email_subject"),
-			Body:        r.Form.Get("email_body"),
-			Attachments: emailAttachments,
+			Recipients:   getSliceFromDelimitedValues(r.Form.Get("email_recipients"), ","),
+			Subject:      r.Form.Get("email_subject"),
+			Body:         r.Form.Get("email_body"),
+			Attachments:  emailAttachments,
+			TextTemplate: r.Form.Get("email_text_template") != "",
 		},
 		RetentionConfig: dataprovider.EventActionDataRetentionConfig{
 			Folders: foldersRetention,
+			DryRun:  r.Form.Get("retention_dry_run") != "",
 		},
 		FsConfig: dataprovider.EventActionFilesystemConfig{
 			Type:    fsActionType,
@@ -2319,9 +2978,71 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 				Name:  r.Form.Get("fs_compress_name"),
 				Paths: getSliceFromDelimitedValues(r.Form.Get("fs_compress_paths"), ","),
 			},
+			PGP: dataprovider.EventActionFsPGP{
+				Paths:      getKeyValsFromPostFields(r, "fs_pgp_source", "fs_pgp_target"),
+				PublicKeys: getSecretFromFormField(r, "fs_pgp_public_keys"),
+				PrivateKey: getSecretFromFormField(r, "fs_pgp_private_key"),
+				Passphrase: getSecretFromFormField(r, "fs_pgp_passphrase"),
+			},
 		},
 		PwdExpirationConfig: dataprovider.EventActionPasswordExpiration{
-			Threshold: pwdExpirationThreshold,
+			Threshold:    pwdExpirationThreshold,
+			NotifyAdmins: r.Form.Get("pwd_expiration_notify_admins") != "",
+		},
+		BrokerConfig: dataprovider.EventActionBrokerConfig{
+			Protocol:      r.Form.Get("broker_protocol"),
+			Endpoint:      r.Form.Get("broker_endpoint"),
+			Topic:         r.Form.Get("broker_topic"),
+			RoutingKey:    r.Form.Get("broker_routing_key"),
+			Username:      r.Form.Get("broker_username"),
+			Password:      getSecretFromFormField(r, "broker_password"),
+			UseTLS:        r.Form.Get("broker_use_tls") != "",
+			SkipTLSVerify: r.Form.Get("broker_skip_tls_verify") != "",
+			Timeout:       brokerTimeout,
+			Body:          r.Form.Get("broker_body"),
+		},
+		FunctionConfig: dataprovider.EventActionFunctionConfig{
+			Provider:         r.Form.Get("function_provider"),
+			Function:         strings.TrimSpace(r.Form.Get("function_name")),
+			Region:           strings.TrimSpace(r.Form.Get("function_region")),
+			AccessKey:        strings.TrimSpace(r.Form.Get("function_access_key")),
+			AccessSecret:     getSecretFromFormField(r, "function_access_secret"),
+			RoleARN:          strings.TrimSpace(r.Form.Get("function_role_arn")),
+			Endpoint:         strings.TrimSpace(r.Form.Get("function_endpoint")),
+			Credentials:      getSecretFromFormField(r, "function_credentials"),
+			Async:            r.Form.Get("function_async") != "",
+			Timeout:          functionTimeout,
+			Body:             r.Form.Get("function_body"),
+			ResultErrorField: strings.TrimSpace(r.Form.Get("function_result_error_field")),
+		},
+		ReplicationConfig: dataprovider.EventActionReplication{
+			Folders:        getSliceFromDelimitedValues(r.Form.Get("replication_folders"), ","),
+			TargetPath:     strings.TrimSpace(r.Form.Get("replication_target_path")),
+			ConflictPolicy: replicationConflictPolicy,
+			MaxRetries:     replicationMaxRetries,
+		},
+		ThumbnailConfig: dataprovider.EventActionThumbnail{
+			Width:       thumbnailWidth,
+			Height:      thumbnailHeight,
+			Quality:     thumbnailQuality,
+			MaxFileSize: thumbnailMaxFileSize,
+			PDFCommand:  strings.TrimSpace(r.Form.Get("thumbnail_pdf_command")),
+			Timeout:     thumbnailTimeout,
+		},
+		ChecksumConfig: dataprovider.EventActionChecksumManifest{
+			Mode:         checksumMode,
+			Algorithm:    r.Form.Get("checksum_algorithm"),
+			ManifestPath: strings.TrimSpace(r.Form.Get("checksum_manifest_path")),
+			Template:     r.Form.Get("checksum_template"),
+		},
+		PushConfig: dataprovider.EventActionPush{
+			Endpoint:   r.Form.Get("push_endpoint"),
+			TargetPath: strings.TrimSpace(r.Form.Get("push_target_path")),
+		},
+		LifecycleConfig: dataprovider.EventActionAccountLifecycle{
+			ExpirationThreshold: lifecycleExpirationThreshold,
+			InactivityThreshold: lifecycleInactivityThreshold,
+			NotifyAdmins:        r.Form.Get("lifecycle_notify_admins") != "",
 		},
 	}
 	return options, nil
@@ -2421,10 +3142,19 @@ func getEventRuleConditionsFromPostFields(r *http.Request) (dataprovider.EventCo
 	if err != nil {
 		return dataprovider.EventConditions{}, fmt.Errorf("invalid max file size: %w", err)
 	}
+	content, err := getEventRuleContentConditionsFromPostFields(r)
+	if err != nil {
+		return dataprovider.EventConditions{}, err
+	}
+	quotaThreshold, err := getOptionalIntFromPostField(r, "quota_threshold")
+	if err != nil {
+		return dataprovider.EventConditions{}, fmt.Errorf("invalid quota threshold: %w", err)
+	}
 	conditions := dataprovider.EventConditions{
 		FsEvents:       r.Form["fs_events"],
 		ProviderEvents: r.Form["provider_events"],
 		Schedules:      schedules,
+		QuotaThreshold: quotaThreshold,
 		Options: dataprovider.ConditionOptions{
 			Names:               names,
 			GroupNames:          groupNames,
@@ -2434,12 +3164,44 @@ func getEventRuleConditionsFromPostFields(r *http.Request) (dataprovider.EventCo
 			ProviderObjects:     r.Form["provider_objects"],
 			MinFileSize:         minFileSize,
 			MaxFileSize:         maxFileSize,
+			Content:             content,
 			ConcurrentExecution: r.Form.Get("concurrent_execution") != "",
 		},
 	}
 	return conditions, nil
 }
 
+func getEventRuleContentConditionsFromPostFields(r *http.Request) (dataprovider.FileContentConditions, error) {
+	minLines, err := getOptionalIntFromPostField(r, "content_min_lines")
+	if err != nil {
+		return dataprovider.FileContentConditions{}, fmt.Errorf("invalid min lines: %w", err)
+	}
+	maxLines, err := getOptionalIntFromPostField(r, "content_max_lines")
+	if err != nil {
+		return dataprovider.FileContentConditions{}, fmt.Errorf("invalid max lines: %w", err)
+	}
+	scanSize, err := getOptionalIntFromPostField(r, "content_scan_size")
+	if err != nil {
+		return dataprovider.FileContentConditions{}, fmt.Errorf("invalid content scan size: %w", err)
+	}
+	return dataprovider.FileContentConditions{
+		MIMETypes: getSliceFromDelimitedValues(r.Form.Get("content_mime_types"), ","),
+		MinLines:  minLines,
+		MaxLines:  maxLines,
+		CSVHeader: getSliceFromDelimitedValues(r.Form.Get("content_csv_header"), ","),
+		Pattern:   strings.TrimSpace(r.Form.Get("content_pattern")),
+		ScanSize:  scanSize,
+	}, nil
+}
+
+func getOptionalIntFromPostField(r *http.Request, name string) (int, error) {
+	val := strings.TrimSpace(r.Form.Get(name))
+	if val == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(val)
+}
+
 func getEventRuleActionsFromPostFields(r *http.Request) ([]dataprovider.EventAction, error) {
 	var actions []dataprovider.EventAction
 	for k := range r.Form {
@@ -2452,6 +3214,18 @@ func getEventRuleActionsFromPostFields(r *http.Request) ([]dataprovider.EventAct
 					return actions, fmt.Errorf("invalid order: %w", err)
 				}
 				options := r.Form[fmt.Sprintf("action_options%s", idx)]
+				runOn, err := getOptionalIntFromPostField(r, fmt.Sprintf("action_run_on%s", idx))
+				if err != nil {
+					return actions, fmt.Errorf("invalid run on condition: %w", err)
+				}
+				retries, err := getOptionalIntFromPostField(r, fmt.Sprintf("action_retries%s", idx))
+				if err != nil {
+					return actions, fmt.Errorf("invalid retries: %w", err)
+				}
+				retryDelay, err := getOptionalIntFromPostField(r, fmt.Sprintf("action_retry_delay%s", idx))
+				if err != nil {
+					return actions, fmt.Errorf("invalid retry delay: %w", err)
+				}
 				actions = append(actions, dataprovider.EventAction{
 					BaseEventAction: dataprovider.BaseEventAction{
 						Name: name,
@@ -2461,6 +3235,10 @@ func getEventRuleActionsFromPostFields(r *http.Request) ([]dataprovider.EventAct
 						IsFailureAction: util.Contains(options, "1"),
 						StopOnFailure:   util.Contains(options, "2"),
 						ExecuteSync:     util.Contains(options, "3"),
+						DependsOn:       strings.TrimSpace(r.Form.Get(fmt.Sprintf("action_depends_on%s", idx))),
+						RunOn:           runOn,
+						Retries:         retries,
+						RetryDelay:      retryDelay,
 					},
 				})
 			}
@@ -2513,6 +3291,45 @@ func getRoleFromPostFields(r *http.Request) (dataprovider.Role, error) {
 	}, nil
 }
 
+func getTenantFromPostFields(r *http.Request) (dataprovider.Tenant, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return dataprovider.Tenant{}, err
+	}
+	quotaSize, err := util.ParseBytes(r.Form.Get("quota_size"))
+	if err != nil {
+		return dataprovider.Tenant{}, fmt.Errorf("invalid quota size: %w", err)
+	}
+	quotaFiles, err := strconv.Atoi(r.Form.Get("quota_files"))
+	if err != nil {
+		return dataprovider.Tenant{}, fmt.Errorf("invalid quota files: %w", err)
+	}
+	maxSessions, err := strconv.Atoi(r.Form.Get("max_sessions"))
+	if err != nil {
+		return dataprovider.Tenant{}, fmt.Errorf("invalid max sessions: %w", err)
+	}
+
+	return dataprovider.Tenant{
+		Name:        r.Form.Get("name"),
+		Description: r.Form.Get("description"),
+		Members: dataprovider.TenantMembers{
+			Roles:      r.Form["roles"],
+			Groups:     r.Form["groups"],
+			Folders:    r.Form["folders"],
+			EventRules: r.Form["event_rules"],
+		},
+		QuotaSize:   quotaSize,
+		QuotaFiles:  quotaFiles,
+		MaxSessions: maxSessions,
+		Branding: dataprovider.TenantBranding{
+			Name:        r.Form.Get("branding_name"),
+			ShortName:   r.Form.Get("branding_short_name"),
+			LogoPath:    r.Form.Get("branding_logo_path"),
+			FaviconPath: r.Form.Get("branding_favicon_path"),
+		},
+	}, nil
+}
+
 func getIPListEntryFromPostFields(r *http.Request, listType dataprovider.IPListType) (dataprovider.IPListEntry, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -2578,6 +3395,51 @@ func getACMEConfigsFromPostFields(r *http.Request) *dataprovider.ACMEConfigs {
 	}
 }
 
+// getRemoteEndpointsFromPostFields parses the remote endpoints, redacted secrets
+// are restored from the stored endpoint with the same original name
+func getRemoteEndpointsFromPostFields(r *http.Request, stored []dataprovider.RemoteEndpoint) []dataprovider.RemoteEndpoint {
+	var res []dataprovider.RemoteEndpoint
+	for k := range r.Form {
+		if strings.HasPrefix(k, "remote_endpoint_name") {
+			name := strings.TrimSpace(r.Form.Get(k))
+			if name == "" {
+				continue
+			}
+			idx := strings.TrimPrefix(k, "remote_endpoint_name")
+			protocol, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("remote_endpoint_protocol%s", idx)))
+			if err != nil {
+				protocol = 0
+			}
+			endpoint := dataprovider.RemoteEndpoint{
+				Name:         name,
+				Protocol:     protocol,
+				Endpoint:     strings.TrimSpace(r.Form.Get(fmt.Sprintf("remote_endpoint_address%s", idx))),
+				Username:     strings.TrimSpace(r.Form.Get(fmt.Sprintf("remote_endpoint_username%s", idx))),
+				Password:     getSecretFromFormField(r, fmt.Sprintf("remote_endpoint_password%s", idx)),
+				PrivateKey:   getSecretFromFormField(r, fmt.Sprintf("remote_endpoint_private_key%s", idx)),
+				Fingerprints: getSliceFromDelimitedValues(r.Form.Get(fmt.Sprintf("remote_endpoint_fingerprints%s", idx)), "\n"),
+			}
+			origName := r.Form.Get(fmt.Sprintf("remote_endpoint_orig_name%s", idx))
+			for _, e := range stored {
+				if origName != "" && e.Name == origName {
+					if endpoint.Password.IsNotPlainAndNotEmpty() {
+						endpoint.Password = e.Password
+					}
+					if endpoint.PrivateKey.IsNotPlainAndNotEmpty() {
+						endpoint.PrivateKey = e.PrivateKey
+					}
+					break
+				}
+			}
+			res = append(res, endpoint)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
 func getSMTPConfigsFromPostFields(r *http.Request) *dataprovider.SMTPConfigs {
 	port, err := strconv.Atoi(r.Form.Get("smtp_port"))
 	if err != nil {
@@ -2625,7 +3487,13 @@ func (s *httpdServer) handleWebAdminForgotPwdPost(w http.ResponseWriter, r *http
 		s.renderForbiddenPage(w, r, err.Error())
 		return
 	}
-	err = handleForgotPassword(r, r.Form.Get("username"), true)
+	username := r.Form.Get("username")
+	if err := checkLoginProtection(r, ipAddr, username); err != nil {
+		s.renderForgotPwdPage(w, err.Error(), ipAddr)
+		return
+	}
+	onPasswordResetRequest(ipAddr, username)
+	err = handleForgotPassword(r, username, true)
 	if err != nil {
 		if e, ok := err.(*util.ValidationError); ok {
 			s.renderForgotPwdPage(w, e.GetErrorString(), ipAddr)
@@ -2648,7 +3516,7 @@ func (s *httpdServer) handleWebAdminPasswordReset(w http.ResponseWriter, r *http
 
 func (s *httpdServer) handleWebAdminTwoFactor(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderTwoFactorPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderTwoFactorPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminTwoFactorRecovery(w http.ResponseWriter, r *http.Request) {
@@ -2880,6 +3748,7 @@ func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Re
 		updatedAdmin.Password = admin.Password
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
+	updatedAdmin.Filters.WebAuthnCredentials = admin.Filters.WebAuthnCredentials
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -2937,7 +3806,7 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 	}
 	users := make([]dataprovider.User, 0, limit)
 	for {
-		u, err := dataprovider.GetUsers(limit, len(users), dataprovider.OrderASC, claims.Role)
+		u, err := getAdminUsers(&claims, limit, len(users), dataprovider.OrderASC)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return
@@ -2947,6 +3816,11 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 			break
 		}
 	}
+	if isFsConfigHidden(r) {
+		for idx := range users {
+			users[idx].HideFsConfigs()
+		}
+	}
 	data := usersPage{
 		basePage: s.getBasePageData(pageUsersTitle, webUsersPath, r),
 		Users:    users,
@@ -3050,6 +3924,7 @@ func (s *httpdServer) handleWebTemplateUserGet(w http.ResponseWriter, r *http.Re
 		if err == nil {
 			user.SetEmptySecrets()
 			user.PublicKeys = nil
+			user.Filters.TLSCertPins = nil
 			user.Email = ""
 			user.Description = ""
 			if user.ExpirationDate == 0 && admin.Filters.Preferences.DefaultUsersExpiration > 0 {
@@ -3200,6 +4075,11 @@ func (s *httpdServer) handleWebAddUserPost(w http.ResponseWriter, r *http.Reques
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
+	user.Filters.WebAuthnCredentials = nil
+	if err := checkTenantUser(&claims, &user); err != nil {
+		s.renderUserPage(w, r, &user, userPageModeAdd, err.Error(), nil)
+		return
+	}
 	err = dataprovider.AddUser(&user, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderUserPage(w, r, &user, userPageModeAdd, err.Error(), nil)
@@ -3238,6 +4118,10 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
+	// union folders and S3 access keys cannot be configured using the web admin, preserve them
+	updatedUser.Filters.UnionFolders = user.Filters.UnionFolders
+	updatedUser.Filters.S3AccessKeys = user.Filters.S3AccessKeys
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
@@ -3246,7 +4130,8 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
 		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.SFTPConfig.KeyPassphrase,
-		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey)
+		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey, user.FsConfig.HTTPConfig.OAuth2.ClientSecret)
+	updateCredentialsRotationTime(&updatedUser.FsConfig, &user.FsConfig)
 
 	updatedUser = getUserFromTemplate(updatedUser, userTemplateFields{
 		Username:   updatedUser.Username,
@@ -3256,7 +4141,16 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	if claims.Role != "" {
 		updatedUser.Role = claims.Role
 	}
+	restoreGrantedUserFields(&claims, &updatedUser, &user)
+	if isFsConfigHidden(r) {
+		updatedUser.HomeDir = user.HomeDir
+		updatedUser.FsConfig = user.FsConfig
+	}
 
+	if err := checkTenantUser(&claims, &updatedUser); err != nil {
+		s.renderUserPage(w, r, &updatedUser, userPageModeUpdate, err.Error(), nil)
+		return
+	}
 	err = dataprovider.UpdateUser(&updatedUser, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderUserPage(w, r, &updatedUser, userPageModeUpdate, err.Error(), nil)
@@ -3284,11 +4178,32 @@ func (s *httpdServer) handleWebGetConnections(w http.ResponseWriter, r *http.Req
 		s.renderBadRequestPage(w, r, errors.New("invalid token claims"))
 		return
 	}
-	connectionStats := common.Connections.GetStats(claims.Role)
-	connectionStats = append(connectionStats, getNodesConnections(claims.Username, claims.Role)...)
+	connectionStats, err := getConnectionsStats(&claims)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	var sessions []common.WebSession
+	if claims.Tenant == "" {
+		sessions, err = getWebSessions("", claims.Role, false)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return
+		}
+	}
+	if claims.hasPerm(dataprovider.PermAdminManageAdmins) && claims.Role == "" && claims.Tenant == "" {
+		adminSessions, err := getWebSessions("", "", true)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return
+		}
+		sessions = append(sessions, adminSessions...)
+	}
+	setCurrentWebSession(sessions, claims.SessionID)
 	data := connectionsPage{
 		basePage:    s.getBasePageData(pageConnectionsTitle, webConnectionsPath, r),
 		Connections: connectionStats,
+		Sessions:    sessions,
 	}
 	renderAdminTemplate(w, templateConnections, data)
 }
@@ -3329,7 +4244,14 @@ func (s *httpdServer) handleWebAddFolderPost(w http.ResponseWriter, r *http.Requ
 	folder.FsConfig = fsConfig
 	folder = getFolderFromTemplate(folder, folder.Name)
 
+	if err := checkTenantNewObject(&claims, dataprovider.TenantObjectFolder, folder.Name); err != nil {
+		s.renderFolderPage(w, r, folder, folderPageModeAdd, err.Error())
+		return
+	}
 	err = dataprovider.AddFolder(&folder, claims.Username, ipAddr, claims.Role)
+	if err == nil {
+		err = addObjectToAdminTenant(&claims, dataprovider.TenantObjectFolder, folder.Name, ipAddr)
+	}
 	if err == nil {
 		http.Redirect(w, r, webFoldersPath, http.StatusSeeOther)
 	} else {
@@ -3395,9 +4317,14 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
 		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
 		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.SFTPConfig.KeyPassphrase,
-		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey)
+		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey, folder.FsConfig.HTTPConfig.OAuth2.ClientSecret)
+	updateCredentialsRotationTime(&updatedFolder.FsConfig, &folder.FsConfig)
 
 	updatedFolder = getFolderFromTemplate(updatedFolder, updatedFolder.Name)
+	if isFsConfigHidden(r) {
+		updatedFolder.MappedPath = folder.MappedPath
+		updatedFolder.FsConfig = folder.FsConfig
+	}
 
 	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, claims.Username, ipAddr, claims.Role)
 	if err != nil {
@@ -3408,9 +4335,19 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 }
 
 func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]vfs.BaseVirtualFolder, error) {
+	tenant, isTenantAdmin, err := getRequestTenant(r)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return nil, err
+	}
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	for {
-		f, err := dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal)
+		var f []vfs.BaseVirtualFolder
+		if isTenantAdmin {
+			f, err = dataprovider.GetTenantFolders(&tenant, limit, len(folders), dataprovider.OrderASC)
+		} else {
+			f, err = dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal)
+		}
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return folders, err
@@ -3437,6 +4374,11 @@ func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return
 	}
+	if isFsConfigHidden(r) {
+		for idx := range folders {
+			folders[idx].FsConfig.HideConfig()
+		}
+	}
 
 	data := foldersPage{
 		basePage: s.getBasePageData(pageFoldersTitle, webFoldersPath, r),
@@ -3446,9 +4388,19 @@ func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request
 }
 
 func (s *httpdServer) getWebGroups(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]dataprovider.Group, error) {
+	tenant, isTenantAdmin, err := getRequestTenant(r)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return nil, err
+	}
 	groups := make([]dataprovider.Group, 0, limit)
 	for {
-		f, err := dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal)
+		var f []dataprovider.Group
+		if isTenantAdmin {
+			f, err = dataprovider.GetTenantGroups(&tenant, limit, len(groups), dataprovider.OrderASC)
+		} else {
+			f, err = dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal)
+		}
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return groups, err
@@ -3475,6 +4427,11 @@ func (s *httpdServer) handleWebGetGroups(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return
 	}
+	if isFsConfigHidden(r) {
+		for idx := range groups {
+			groups[idx].HideFsConfigs()
+		}
+	}
 
 	data := groupsPage{
 		basePage: s.getBasePageData(pageGroupsTitle, webGroupsPath, r),
@@ -3505,11 +4462,23 @@ func (s *httpdServer) handleWebAddGroupPost(w http.ResponseWriter, r *http.Reque
 		s.renderForbiddenPage(w, r, err.Error())
 		return
 	}
+	if err := checkTenantNewObject(&claims, dataprovider.TenantObjectGroup, group.Name); err != nil {
+		s.renderGroupPage(w, r, group, genericPageModeAdd, err.Error())
+		return
+	}
+	if err := checkTenantGroup(&claims, &group); err != nil {
+		s.renderGroupPage(w, r, group, genericPageModeAdd, err.Error())
+		return
+	}
 	err = dataprovider.AddGroup(&group, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderGroupPage(w, r, group, genericPageModeAdd, err.Error())
 		return
 	}
+	if err := addObjectToAdminTenant(&claims, dataprovider.TenantObjectGroup, group.Name, ipAddr); err != nil {
+		s.renderGroupPage(w, r, group, genericPageModeAdd, err.Error())
+		return
+	}
 	http.Redirect(w, r, webGroupsPath, http.StatusSeeOther)
 }
 
@@ -3561,8 +4530,17 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 		group.UserSettings.FsConfig.GCSConfig.Credentials, group.UserSettings.FsConfig.CryptConfig.Passphrase,
 		group.UserSettings.FsConfig.SFTPConfig.Password, group.UserSettings.FsConfig.SFTPConfig.PrivateKey,
 		group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase, group.UserSettings.FsConfig.HTTPConfig.Password,
-		group.UserSettings.FsConfig.HTTPConfig.APIKey)
+		group.UserSettings.FsConfig.HTTPConfig.APIKey, group.UserSettings.FsConfig.HTTPConfig.OAuth2.ClientSecret)
+	updateCredentialsRotationTime(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
+	if isFsConfigHidden(r) {
+		updatedGroup.UserSettings.HomeDir = group.UserSettings.HomeDir
+		updatedGroup.UserSettings.FsConfig = group.UserSettings.FsConfig
+	}
 
+	if err := checkTenantGroup(&claims, &updatedGroup); err != nil {
+		s.renderGroupPage(w, r, updatedGroup, genericPageModeUpdate, err.Error())
+		return
+	}
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderGroupPage(w, r, updatedGroup, genericPageModeUpdate, err.Error())
@@ -3588,6 +4566,21 @@ func (s *httpdServer) getWebEventActions(w http.ResponseWriter, r *http.Request,
 	return actions, nil
 }
 
+func (s *httpdServer) handleWebGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	letters, err := common.GetDeadLetters()
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+
+	data := deadLettersPage{
+		basePage:    s.getBasePageData(pageDeadLettersTitle, webAdminDeadLettersPath, r),
+		DeadLetters: letters,
+	}
+	renderAdminTemplate(w, templateDeadLetters, data)
+}
+
 func (s *httpdServer) handleWebGetEventActions(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	limit := defaultQueryLimit
@@ -3689,6 +4682,14 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+	case dataprovider.ActionTypeFilesystem:
+		updatedAction.Options.FsConfig.PGP.KeepSecrets(&action.Options.FsConfig.PGP)
+	case dataprovider.ActionTypeMessageBroker:
+		if updatedAction.Options.BrokerConfig.Password.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.BrokerConfig.Password = action.Options.BrokerConfig.Password
+		}
+	case dataprovider.ActionTypeCloudFunction:
+		updatedAction.Options.FunctionConfig.KeepSecrets(&action.Options.FunctionConfig)
 	}
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, ipAddr, claims.Role)
 	if err != nil {
@@ -3698,26 +4699,44 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 	http.Redirect(w, r, webAdminEventActionsPath, http.StatusSeeOther)
 }
 
-func (s *httpdServer) handleWebGetEventRules(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	limit := defaultQueryLimit
-	if _, ok := r.URL.Query()["qlimit"]; ok {
-		if lim, err := strconv.Atoi(r.URL.Query().Get("qlimit")); err == nil {
-			limit = lim
-		}
+func (s *httpdServer) getWebEventRules(w http.ResponseWriter, r *http.Request, limit int) ([]dataprovider.EventRule, error) {
+	tenant, isTenantAdmin, err := getRequestTenant(r)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return nil, err
 	}
 	rules := make([]dataprovider.EventRule, 0, limit)
 	for {
-		res, err := dataprovider.GetEventRules(limit, len(rules), dataprovider.OrderASC)
+		var res []dataprovider.EventRule
+		if isTenantAdmin {
+			res, err = dataprovider.GetTenantEventRules(&tenant, limit, len(rules), dataprovider.OrderASC)
+		} else {
+			res, err = dataprovider.GetEventRules(limit, len(rules), dataprovider.OrderASC)
+		}
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
-			return
+			return rules, err
 		}
 		rules = append(rules, res...)
 		if len(res) < limit {
 			break
 		}
 	}
+	return rules, nil
+}
+
+func (s *httpdServer) handleWebGetEventRules(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit := defaultQueryLimit
+	if _, ok := r.URL.Query()["qlimit"]; ok {
+		if lim, err := strconv.Atoi(r.URL.Query().Get("qlimit")); err == nil {
+			limit = lim
+		}
+	}
+	rules, err := s.getWebEventRules(w, r, limit)
+	if err != nil {
+		return
+	}
 
 	data := eventRulesPage{
 		basePage: s.getBasePageData(pageEventRulesTitle, webAdminEventRulesPath, r),
@@ -3753,10 +4772,18 @@ func (s *httpdServer) handleWebAddEventRulePost(w http.ResponseWriter, r *http.R
 		s.renderForbiddenPage(w, r, err.Error())
 		return
 	}
+	if err = checkTenantNewObject(&claims, dataprovider.TenantObjectEventRule, rule.Name); err != nil {
+		s.renderEventRulePage(w, r, rule, genericPageModeAdd, err.Error())
+		return
+	}
 	if err = dataprovider.AddEventRule(&rule, claims.Username, ipAddr, claims.Role); err != nil {
 		s.renderEventRulePage(w, r, rule, genericPageModeAdd, err.Error())
 		return
 	}
+	if err = addObjectToAdminTenant(&claims, dataprovider.TenantObjectEventRule, rule.Name, ipAddr); err != nil {
+		s.renderEventRulePage(w, r, rule, genericPageModeAdd, err.Error())
+		return
+	}
 	http.Redirect(w, r, webAdminEventRulesPath, http.StatusSeeOther)
 }
 
@@ -3822,6 +4849,20 @@ func (s *httpdServer) getWebRoles(w http.ResponseWriter, r *http.Request, limit
 			break
 		}
 	}
+	tenant, isTenantAdmin, err := getRequestTenant(r)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return roles, err
+	}
+	if isTenantAdmin {
+		tenantRoles := make([]dataprovider.Role, 0, len(tenant.Members.Roles))
+		for _, role := range roles {
+			if tenant.HasRole(role.Name) {
+				tenantRoles = append(tenantRoles, role)
+			}
+		}
+		return tenantRoles, nil
+	}
 	return roles, nil
 }
 
@@ -3916,6 +4957,113 @@ func (s *httpdServer) handleWebUpdateRolePost(w http.ResponseWriter, r *http.Req
 	http.Redirect(w, r, webAdminRolesPath, http.StatusSeeOther)
 }
 
+func (s *httpdServer) getWebTenants(w http.ResponseWriter, r *http.Request, limit int) ([]dataprovider.Tenant, error) {
+	tenants := make([]dataprovider.Tenant, 0, limit)
+	for {
+		res, err := dataprovider.GetTenants(limit, len(tenants), dataprovider.OrderASC)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return tenants, err
+		}
+		tenants = append(tenants, res...)
+		if len(res) < limit {
+			break
+		}
+	}
+	return tenants, nil
+}
+
+func (s *httpdServer) handleWebGetTenants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenants, err := s.getWebTenants(w, r, 10)
+	if err != nil {
+		return
+	}
+	data := tenantsPage{
+		basePage: s.getBasePageData(pageTenantsTitle, webAdminTenantsPath, r),
+		Tenants:  tenants,
+	}
+	renderAdminTemplate(w, templateTenants, data)
+}
+
+func (s *httpdServer) handleWebAddTenantGet(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	s.renderTenantPage(w, r, dataprovider.Tenant{}, genericPageModeAdd, "")
+}
+
+func (s *httpdServer) handleWebAddTenantPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant, err := getTenantFromPostFields(r)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeAdd, err.Error())
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderBadRequestPage(w, r, errors.New("invalid token claims"))
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderForbiddenPage(w, r, err.Error())
+		return
+	}
+	err = dataprovider.AddTenant(&tenant, claims.Username, ipAddr, claims.Role)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeAdd, err.Error())
+		return
+	}
+	http.Redirect(w, r, webAdminTenantsPath, http.StatusSeeOther)
+}
+
+func (s *httpdServer) handleWebUpdateTenantGet(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant, err := dataprovider.TenantExists(getURLParam(r, "name"))
+	if err == nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeUpdate, "")
+	} else if errors.Is(err, util.ErrNotFound) {
+		s.renderNotFoundPage(w, r, err)
+	} else {
+		s.renderInternalServerErrorPage(w, r, err)
+	}
+}
+
+func (s *httpdServer) handleWebUpdateTenantPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderBadRequestPage(w, r, errors.New("invalid token claims"))
+		return
+	}
+	tenant, err := dataprovider.TenantExists(getURLParam(r, "name"))
+	if errors.Is(err, util.ErrNotFound) {
+		s.renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+
+	updatedTenant, err := getTenantFromPostFields(r)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeUpdate, err.Error())
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderForbiddenPage(w, r, err.Error())
+		return
+	}
+	updatedTenant.ID = tenant.ID
+	updatedTenant.Name = tenant.Name
+	err = dataprovider.UpdateTenant(&updatedTenant, claims.Username, ipAddr, claims.Role)
+	if err != nil {
+		s.renderTenantPage(w, r, updatedTenant, genericPageModeUpdate, err.Error())
+		return
+	}
+	http.Redirect(w, r, webAdminTenantsPath, http.StatusSeeOther)
+}
+
 func (s *httpdServer) handleWebGetEvents(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -4091,6 +5239,9 @@ func (s *httpdServer) handleWebConfigsPost(w http.ResponseWriter, r *http.Reques
 			smtpConfigs.Password = configs.SMTP.Password
 		}
 		configs.SMTP = smtpConfigs
+	case "remote_endpoints_submit":
+		configSection = 4
+		configs.RemoteEndpoints = getRemoteEndpointsFromPostFields(r, configs.RemoteEndpoints)
 	default:
 		s.renderBadRequestPage(w, r, errors.New("unsupported form action"))
 		return
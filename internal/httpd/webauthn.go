@@ -0,0 +1,291 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// WebAuthn ceremonies
+const (
+	webAuthnCeremonyRegistration = "registration"
+	webAuthnCeremonyLogin        = "login"
+)
+
+var (
+	webAuthnSessionsMgr webAuthnSessionManager
+)
+
+type webAuthnSessionManager interface {
+	Add(session *webAuthnSession) error
+	Get(key string) (*webAuthnSession, error)
+	Delete(key string) error
+	Cleanup()
+}
+
+func newWebAuthnSessionManager(isShared int) webAuthnSessionManager {
+	if isShared == 1 {
+		logger.Info(logSender, "", "using provider WebAuthn session manager")
+		return &dbWebAuthnSessionManager{}
+	}
+	logger.Info(logSender, "", "using memory WebAuthn session manager")
+	return &memoryWebAuthnSessionManager{}
+}
+
+// webAuthnSession stores the data of a pending registration or login ceremony.
+// There is at most one pending ceremony of each type for each account
+type webAuthnSession struct {
+	Key       string               `json:"key"`
+	Data      webauthn.SessionData `json:"data"`
+	ExpiresAt time.Time            `json:"expires_at"`
+}
+
+func getWebAuthnSessionKey(ceremony, username string, isAdmin bool) string {
+	accountType := "user"
+	if isAdmin {
+		accountType = "admin"
+	}
+	h := sha256.Sum256([]byte(ceremony + ":" + accountType + ":" + username))
+	return "webauthn_" + hex.EncodeToString(h[:])
+}
+
+func newWebAuthnSession(key string, data *webauthn.SessionData) *webAuthnSession {
+	return &webAuthnSession{
+		Key:       key,
+		Data:      *data,
+		ExpiresAt: data.Expires.UTC(),
+	}
+}
+
+func (s *webAuthnSession) isExpired() bool {
+	return s.ExpiresAt.Before(time.Now().UTC())
+}
+
+type memoryWebAuthnSessionManager struct {
+	sessions sync.Map
+}
+
+func (m *memoryWebAuthnSessionManager) Add(session *webAuthnSession) error {
+	m.sessions.Store(session.Key, session)
+	return nil
+}
+
+func (m *memoryWebAuthnSessionManager) Get(key string) (*webAuthnSession, error) {
+	s, ok := m.sessions.Load(key)
+	if !ok {
+		return nil, util.NewRecordNotFoundError("WebAuthn session not found")
+	}
+	session := s.(*webAuthnSession)
+	if session.isExpired() {
+		return nil, util.NewRecordNotFoundError("WebAuthn session expired")
+	}
+	return session, nil
+}
+
+func (m *memoryWebAuthnSessionManager) Delete(key string) error {
+	m.sessions.Delete(key)
+	return nil
+}
+
+func (m *memoryWebAuthnSessionManager) Cleanup() {
+	m.sessions.Range(func(key, value any) bool {
+		s, ok := value.(*webAuthnSession)
+		if !ok || s.isExpired() {
+			m.sessions.Delete(key)
+		}
+		return true
+	})
+}
+
+type dbWebAuthnSessionManager struct{}
+
+func (m *dbWebAuthnSessionManager) Add(session *webAuthnSession) error {
+	s := dataprovider.Session{
+		Key:       session.Key,
+		Data:      session,
+		Type:      dataprovider.SessionTypeWebAuthn,
+		Timestamp: util.GetTimeAsMsSinceEpoch(session.ExpiresAt),
+	}
+	return dataprovider.AddSharedSession(s)
+}
+
+func (m *dbWebAuthnSessionManager) Get(key string) (*webAuthnSession, error) {
+	s, err := dataprovider.GetSharedSession(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		return nil, util.NewRecordNotFoundError("WebAuthn session expired")
+	}
+	if val, ok := s.Data.([]byte); ok {
+		session := &webAuthnSession{}
+		err := json.Unmarshal(val, session)
+		return session, err
+	}
+	logger.Error(logSender, "", "invalid WebAuthn session data type %T", s.Data)
+	return nil, util.NewRecordNotFoundError("invalid WebAuthn session")
+}
+
+func (m *dbWebAuthnSessionManager) Delete(key string) error {
+	return dataprovider.DeleteSharedSession(key)
+}
+
+func (m *dbWebAuthnSessionManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeWebAuthn, time.Now()) //nolint:errcheck
+}
+
+func getWebAuthnUser(username string, isAdmin bool, credentials []dataprovider.WebAuthnCredential) *mfa.WebAuthnUser {
+	result := make([]webauthn.Credential, 0, len(credentials))
+	for _, c := range credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		result = append(result, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return mfa.NewWebAuthnUser(username, isAdmin, result)
+}
+
+func newWebAuthnCredential(c *webauthn.Credential, name string) dataprovider.WebAuthnCredential {
+	transports := make([]string, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transports = append(transports, string(t))
+	}
+	return dataprovider.WebAuthnCredential{
+		ID:              c.ID,
+		Name:            name,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		Transports:      transports,
+		CreatedAt:       util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+}
+
+// updateWebAuthnCredentialUsage updates the sign counter and the last use for the
+// credential used to login and returns false if the credential is not found
+func updateWebAuthnCredentialUsage(credentials []dataprovider.WebAuthnCredential, c *webauthn.Credential) bool {
+	for idx := range credentials {
+		if string(credentials[idx].ID) == string(c.ID) {
+			credentials[idx].SignCount = c.Authenticator.SignCount
+			credentials[idx].LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			return true
+		}
+	}
+	return false
+}
+
+func encodeWebAuthnCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func startWebAuthnLogin(username string, isAdmin bool, credentials []dataprovider.WebAuthnCredential,
+) (*protocol.CredentialAssertion, error) {
+	assertion, session, err := mfa.BeginWebAuthnLogin(getWebAuthnUser(username, isAdmin, credentials))
+	if err != nil {
+		return nil, err
+	}
+	err = webAuthnSessionsMgr.Add(newWebAuthnSession(
+		getWebAuthnSessionKey(webAuthnCeremonyLogin, username, isAdmin), session))
+	return assertion, err
+}
+
+// checkWebAuthnLogin validates the assertion for a pending login ceremony,
+// updates the usage for the matching credential in the given slice and returns
+// its ID
+func checkWebAuthnLogin(username string, isAdmin bool, credentials []dataprovider.WebAuthnCredential,
+	assertion string,
+) ([]byte, error) {
+	key := getWebAuthnSessionKey(webAuthnCeremonyLogin, username, isAdmin)
+	session, err := webAuthnSessionsMgr.Get(key)
+	if err != nil {
+		return nil, errors.New("no pending security key authentication")
+	}
+	webAuthnSessionsMgr.Delete(key) //nolint:errcheck
+	credential, err := mfa.FinishWebAuthnLogin(getWebAuthnUser(username, isAdmin, credentials),
+		session.Data, []byte(assertion))
+	if err != nil {
+		return nil, err
+	}
+	if !updateWebAuthnCredentialUsage(credentials, credential) {
+		return nil, errors.New("security key not found")
+	}
+	return credential.ID, nil
+}
+
+func checkTOTPPasscode(configName string, secret *kms.Secret, passcode string) error {
+	if passcode == "" {
+		return dataprovider.ErrInvalidCredentials
+	}
+	if err := secret.Decrypt(); err != nil {
+		return err
+	}
+	match, err := mfa.ValidateTOTPPasscode(configName, passcode, secret.GetPayload())
+	if err != nil {
+		return err
+	}
+	if !match {
+		return dataprovider.ErrInvalidCredentials
+	}
+	return nil
+}
+
+type webAuthnCredentialInfo struct {
+	ID        string
+	Name      string
+	CreatedAt string
+	LastUseAt string
+}
+
+func getWebAuthnCredentialsInfo(credentials []dataprovider.WebAuthnCredential) []webAuthnCredentialInfo {
+	result := make([]webAuthnCredentialInfo, 0, len(credentials))
+	for _, c := range credentials {
+		info := webAuthnCredentialInfo{
+			ID:        encodeWebAuthnCredentialID(c.ID),
+			Name:      c.Name,
+			CreatedAt: util.GetTimeFromMsecSinceEpoch(c.CreatedAt).Format(webDateTimeFormat),
+		}
+		if c.LastUseAt > 0 {
+			info.LastUseAt = util.GetTimeFromMsecSinceEpoch(c.LastUseAt).Format(webDateTimeFormat)
+		}
+		result = append(result, info)
+	}
+	return result
+}
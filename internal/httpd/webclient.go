@@ -59,20 +59,25 @@ const (
 	templateClientEditFile          = "editfile.html"
 	templateClientShare             = "share.html"
 	templateClientShares            = "shares.html"
+	templateClientTrash             = "trash.html"
 	templateClientViewPDF           = "viewpdf.html"
 	templateShareLogin              = "sharelogin.html"
 	templateShareFiles              = "sharefiles.html"
 	templateUploadToShare           = "shareupload.html"
+	templateClientIPApproval        = "ipapproval.html"
 	pageClientFilesTitle            = "My Files"
 	pageClientSharesTitle           = "Shares"
+	pageClientTrashTitle            = "Trash"
 	pageClientProfileTitle          = "My Profile"
 	pageClientChangePwdTitle        = "Change password"
 	pageClient2FATitle              = "Two-factor auth"
 	pageClientEditFileTitle         = "Edit file"
 	pageClientForgotPwdTitle        = "SFTPGo WebClient - Forgot password"
 	pageClientResetPwdTitle         = "SFTPGo WebClient - Reset password"
+	pageClientIPApprovalTitle       = "SFTPGo WebClient - IP address approval"
 	pageExtShareTitle               = "Shared files"
 	pageUploadToShareTitle          = "Upload to share"
+	pageAnonymousFilesTitle         = "Anonymous files"
 )
 
 // condResult is the result of an HTTP request precondition check.
@@ -121,6 +126,17 @@ type dirMapping struct {
 	Href    string
 }
 
+type ipApprovalPage struct {
+	CurrentURL string
+	Error      string
+	Success    string
+	CSRFToken  string
+	StaticURL  string
+	Title      string
+	Branding   UIBranding
+	Request    *common.IPApprovalRequest
+}
+
 type viewPDFPage struct {
 	Title     string
 	URL       string
@@ -152,9 +168,19 @@ type filesPage struct {
 	CanDelete       bool
 	CanDownload     bool
 	CanShare        bool
+	HasTrash        bool
+	TrashURL        string
 	Error           string
 	Paths           []dirMapping
 	HasIntegrations bool
+	FolderQuota     *folderQuotaInfo
+}
+
+// folderQuotaInfo defines the per-user quota usage inside a virtual folder
+type folderQuotaInfo struct {
+	VirtualPath string
+	Size        string
+	Files       string
 }
 
 type shareLoginPage struct {
@@ -197,6 +223,7 @@ type clientProfilePage struct {
 	AllowAPIKeyAuth bool
 	Email           string
 	Description     string
+	SSHCertURL      string
 	Error           string
 }
 
@@ -214,6 +241,9 @@ type clientMFAPage struct {
 	SaveTOTPURL     string
 	RecCodesURL     string
 	Protocols       []string
+	WebAuthnEnabled bool
+	WebAuthnURL     string
+	WebAuthnKeys    []webAuthnCredentialInfo
 }
 
 type clientSharesPage struct {
@@ -222,6 +252,12 @@ type clientSharesPage struct {
 	BasePublicSharesURL string
 }
 
+type clientTrashPage struct {
+	baseClientPage
+	Items     []common.TrashItem
+	CanModify bool
+}
+
 type clientSharePage struct {
 	baseClientPage
 	Share *dataprovider.Share
@@ -256,6 +292,11 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientShares),
 	}
+	trashPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientTrash),
+	}
 	sharePaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
@@ -275,6 +316,7 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
 		filepath.Join(templatesPath, templateClientDir, templateClientLogin),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	messagePath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
@@ -285,11 +327,13 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientMFA),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonWebAuthn),
 	}
 	twoFactorPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
 		filepath.Join(templatesPath, templateClientDir, templateClientTwoFactor),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonWebAuthn),
 	}
 	twoFactorRecoveryPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
@@ -299,15 +343,21 @@ func loadClientTemplates(templatesPath string) {
 	forgotPwdPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateCommonDir, templateForgotPassword),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	resetPwdPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateCommonDir, templateResetPassword),
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCaptcha),
 	}
 	viewPDFPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientViewPDF),
 	}
+	ipApprovalPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateClientDir, templateClientIPApproval),
+	}
 	shareLoginPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
@@ -336,9 +386,11 @@ func loadClientTemplates(templatesPath string) {
 	shareLoginTmpl := util.LoadTemplate(nil, shareLoginPath...)
 	sharesTmpl := util.LoadTemplate(nil, sharesPaths...)
 	shareTmpl := util.LoadTemplate(nil, sharePaths...)
+	trashTmpl := util.LoadTemplate(nil, trashPaths...)
 	forgotPwdTmpl := util.LoadTemplate(nil, forgotPwdPaths...)
 	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
 	viewPDFTmpl := util.LoadTemplate(nil, viewPDFPaths...)
+	ipApprovalTmpl := util.LoadTemplate(nil, ipApprovalPaths...)
 	shareFilesTmpl := util.LoadTemplate(nil, shareFilesPath...)
 	shareUploadTmpl := util.LoadTemplate(nil, shareUploadPath...)
 
@@ -353,9 +405,11 @@ func loadClientTemplates(templatesPath string) {
 	clientTemplates[templateClientEditFile] = editFileTmpl
 	clientTemplates[templateClientShares] = sharesTmpl
 	clientTemplates[templateClientShare] = shareTmpl
+	clientTemplates[templateClientTrash] = trashTmpl
 	clientTemplates[templateForgotPassword] = forgotPwdTmpl
 	clientTemplates[templateResetPassword] = resetPwdTmpl
 	clientTemplates[templateClientViewPDF] = viewPDFTmpl
+	clientTemplates[templateClientIPApproval] = ipApprovalTmpl
 	clientTemplates[templateShareLogin] = shareLoginTmpl
 	clientTemplates[templateShareFiles] = shareFilesTmpl
 	clientTemplates[templateUploadToShare] = shareUploadTmpl
@@ -367,6 +421,7 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		csrfToken = createCSRFToken(util.GetIPFromRemoteAddress(r.RemoteAddr))
 	}
 	v := version.Get()
+	user := getUserFromToken(r)
 
 	return baseClientPage{
 		Title:        title,
@@ -385,9 +440,32 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		ProfileTitle: pageClientProfileTitle,
 		Version:      fmt.Sprintf("%v-%v", v.Version, v.CommitHash),
 		CSRFToken:    csrfToken,
-		LoggedUser:   getUserFromToken(r),
-		Branding:     s.binding.Branding.WebClient,
+		LoggedUser:   user,
+		Branding:     s.getUserBranding(user),
+	}
+}
+
+// getUserBranding returns the WebClient branding for the specified user,
+// the customizations defined for the user's tenant, if any, override the binding ones
+func (s *httpdServer) getUserBranding(user *dataprovider.User) UIBranding {
+	branding := s.binding.Branding.WebClient
+	tenant, ok := dataprovider.GetTenantForRole(user.Role)
+	if !ok {
+		return branding
+	}
+	if tenant.Branding.Name != "" {
+		branding.Name = tenant.Branding.Name
+	}
+	if tenant.Branding.ShortName != "" {
+		branding.ShortName = tenant.Branding.ShortName
+	}
+	if tenant.Branding.LogoPath != "" {
+		branding.LogoPath = tenant.Branding.LogoPath
 	}
+	if tenant.Branding.FaviconPath != "" {
+		branding.FaviconPath = tenant.Branding.FaviconPath
+	}
+	return branding
 }
 
 func (s *httpdServer) renderClientForgotPwdPage(w http.ResponseWriter, error, ip string) {
@@ -398,6 +476,7 @@ func (s *httpdServer) renderClientForgotPwdPage(w http.ResponseWriter, error, ip
 		StaticURL:  webStaticFilesPath,
 		Title:      pageClientForgotPwdTitle,
 		Branding:   s.binding.Branding.WebClient,
+		Captcha:    loginProtection.getCaptchaData(ip),
 	}
 	renderClientTemplate(w, templateForgotPassword, data)
 }
@@ -410,10 +489,27 @@ func (s *httpdServer) renderClientResetPwdPage(w http.ResponseWriter, error, ip
 		StaticURL:  webStaticFilesPath,
 		Title:      pageClientResetPwdTitle,
 		Branding:   s.binding.Branding.WebClient,
+		Captcha:    loginProtection.getCaptchaData(ip),
 	}
 	renderClientTemplate(w, templateResetPassword, data)
 }
 
+func (s *httpdServer) renderClientIPApprovalPage(w http.ResponseWriter, r *http.Request, req *common.IPApprovalRequest,
+	error, success string,
+) {
+	data := ipApprovalPage{
+		CurrentURL: r.URL.Path,
+		Error:      error,
+		Success:    success,
+		CSRFToken:  createCSRFToken(util.GetIPFromRemoteAddress(r.RemoteAddr)),
+		StaticURL:  webStaticFilesPath,
+		Title:      pageClientIPApprovalTitle,
+		Branding:   s.binding.Branding.WebClient,
+		Request:    req,
+	}
+	renderClientTemplate(w, templateClientIPApproval, data)
+}
+
 func (s *httpdServer) renderShareLoginPage(w http.ResponseWriter, currentURL, error, ip string) {
 	data := shareLoginPage{
 		CurrentURL: currentURL,
@@ -467,7 +563,7 @@ func (s *httpdServer) renderClientNotFoundPage(w http.ResponseWriter, r *http.Re
 	s.renderClientMessagePage(w, r, page404Title, page404Body, http.StatusNotFound, err, "")
 }
 
-func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := twoFactorPage{
 		CurrentURL:  webClientTwoFactorPath,
 		Version:     version.Get().Version,
@@ -475,11 +571,26 @@ func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, error, ip
 		CSRFToken:   createCSRFToken(ip),
 		StaticURL:   webStaticFilesPath,
 		RecoveryURL: webClientTwoFactorRecoveryPath,
+		WebAuthnURL: webClientTwoFactorWebAuthnPath,
 		Branding:    s.binding.Branding.WebClient,
 	}
+	if claims, err := getTokenClaims(r); err == nil && claims.Username != "" {
+		if user, err := dataprovider.GetUserWithGroupSettings(claims.Username, ""); err == nil {
+			data.setSecondFactors(user.Filters.TOTPConfig.Enabled &&
+				util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP),
+				user.HasWebAuthnCredentials(), user.MustUseWebAuthn())
+			data.RememberDays = getUserRememberedDeviceOwner(&user).days
+		}
+	}
 	renderClientTemplate(w, templateTwoFactor, data)
 }
 
+func (s *httpdServer) getClientTwoFactorErrorFunc(r *http.Request) func(w http.ResponseWriter, error, ip string) {
+	return func(w http.ResponseWriter, error, ip string) {
+		s.renderClientTwoFactorPage(w, r, error, ip)
+	}
+}
+
 func (s *httpdServer) renderClientTwoFactorRecoveryPage(w http.ResponseWriter, error, ip string) {
 	data := twoFactorPage{
 		CurrentURL: webClientTwoFactorRecoveryPath,
@@ -501,6 +612,8 @@ func (s *httpdServer) renderClientMFAPage(w http.ResponseWriter, r *http.Request
 		SaveTOTPURL:     webClientTOTPSavePath,
 		RecCodesURL:     webClientRecoveryCodesPath,
 		Protocols:       dataprovider.MFAProtocols,
+		WebAuthnEnabled: mfa.IsWebAuthnEnabled(),
+		WebAuthnURL:     webClientWebAuthnPath,
 	}
 	user, err := dataprovider.UserExists(data.LoggedUser.Username, "")
 	if err != nil {
@@ -508,6 +621,7 @@ func (s *httpdServer) renderClientMFAPage(w http.ResponseWriter, r *http.Request
 		return
 	}
 	data.TOTPConfig = user.Filters.TOTPConfig
+	data.WebAuthnKeys = getWebAuthnCredentialsInfo(user.Filters.WebAuthnCredentials)
 	renderClientTemplate(w, templateClientMFA, data)
 }
 
@@ -610,12 +724,36 @@ func (s *httpdServer) renderFilesPage(w http.ResponseWriter, r *http.Request, di
 		CanDelete:       user.CanDeleteFromWeb(dirName),
 		CanDownload:     user.HasPerm(dataprovider.PermDownload, dirName),
 		CanShare:        user.CanManageShares(),
+		HasTrash:        user.Filters.Trash.Enabled,
+		TrashURL:        webClientTrashPath,
 		HasIntegrations: hasIntegrations,
 		Paths:           getDirMapping(dirName, webClientFilesPath),
+		FolderQuota:     getFolderQuotaInfo(&user, dirName),
 	}
 	renderClientTemplate(w, templateClientFiles, data)
 }
 
+func getFolderQuotaInfo(user *dataprovider.User, dirName string) *folderQuotaInfo {
+	vfolder, err := user.GetVirtualFolderForPath(dirName)
+	if err != nil || !vfolder.HasUserQuotaRestrictions(true) {
+		return nil
+	}
+	usedFiles, usedSize, err := dataprovider.GetUsedUserVirtualFolderQuota(user.Username, vfolder.Name)
+	if err != nil {
+		return nil
+	}
+	info := &folderQuotaInfo{
+		VirtualPath: vfolder.VirtualPath,
+	}
+	if vfolder.UserQuotaSize > 0 {
+		info.Size = fmt.Sprintf("%s/%s", util.ByteCountSI(usedSize), util.ByteCountSI(vfolder.UserQuotaSize))
+	}
+	if vfolder.UserQuotaFiles > 0 {
+		info.Files = fmt.Sprintf("%d/%d", usedFiles, vfolder.UserQuotaFiles)
+	}
+	return info
+}
+
 func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Request, error string) {
 	data := clientProfilePage{
 		baseClientPage: s.getBaseClientPageData(pageClientProfileTitle, webClientProfilePath, r),
@@ -631,6 +769,9 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 	data.Email = user.Email
 	data.Description = user.Description
 	data.CanSubmit = userMerged.CanChangeAPIKeyAuth() || userMerged.CanManagePublicKeys() || userMerged.CanChangeInfo()
+	if canIssueSSHCertificate(&userMerged) {
+		data.SSHCertURL = webClientSSHCertPath
+	}
 	renderClientTemplate(w, templateClientProfile, data)
 }
 
@@ -1187,6 +1328,55 @@ func (s *httpdServer) handleClientUpdateSharePost(w http.ResponseWriter, r *http
 	}
 }
 
+func (s *httpdServer) handleClientGetTrash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, "Invalid token claims")
+		return
+	}
+
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		s.renderClientMessagePage(w, r, "Unable to retrieve your user", "", getRespStatus(err), nil, "")
+		return
+	}
+	if !user.Filters.Trash.Enabled {
+		s.renderClientForbiddenPage(w, r, "The trash is not enabled for your account")
+		return
+	}
+
+	connID := xid.New().String()
+	protocol := getProtocolFromRequest(r)
+	connectionID := fmt.Sprintf("%v_%v", protocol, connID)
+	if err := checkHTTPClientUser(&user, r, connectionID, false); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	items, err := connection.ListTrash()
+	if err != nil {
+		s.renderClientInternalServerErrorPage(w, r, err)
+		return
+	}
+	data := clientTrashPage{
+		baseClientPage: s.getBaseClientPageData(pageClientTrashTitle, webClientTrashPath, r),
+		Items:          items,
+		CanModify:      !util.Contains(user.Filters.WebClient, sdk.WebClientWriteDisabled),
+	}
+	renderClientTemplate(w, templateClientTrash, data)
+}
+
 func (s *httpdServer) handleClientGetShares(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -1284,7 +1474,7 @@ func (s *httpdServer) handleWebClientMFA(w http.ResponseWriter, r *http.Request)
 
 func (s *httpdServer) handleWebClientTwoFactor(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderClientTwoFactorPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientTwoFactorPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebClientTwoFactorRecovery(w http.ResponseWriter, r *http.Request) {
@@ -1348,6 +1538,11 @@ func (s *httpdServer) handleWebClientForgotPwdPost(w http.ResponseWriter, r *htt
 		return
 	}
 	username := r.Form.Get("username")
+	if err := checkLoginProtection(r, ipAddr, username); err != nil {
+		s.renderClientForgotPwdPage(w, err.Error(), ipAddr)
+		return
+	}
+	onPasswordResetRequest(ipAddr, username)
 	err = handleForgotPassword(r, username, false)
 	if err != nil {
 		if e, ok := err.(*util.ValidationError); ok {
@@ -1369,6 +1564,45 @@ func (s *httpdServer) handleWebClientPasswordReset(w http.ResponseWriter, r *htt
 	s.renderClientResetPwdPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
+func (s *httpdServer) handleWebClientIPApproval(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	req, err := common.GetIPApprovalRequest(getURLParam(r, "id"), "")
+	if err != nil || req.Mode != dataprovider.IPApprovalModeEmail {
+		s.renderClientIPApprovalPage(w, r, nil, "This approval request does not exist or it has expired", "")
+		return
+	}
+	s.renderClientIPApprovalPage(w, r, &req, "", "")
+}
+
+func (s *httpdServer) handleWebClientIPApprovalPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientIPApprovalPage(w, r, nil, err.Error(), "")
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	req, err := common.GetIPApprovalRequest(getURLParam(r, "id"), "")
+	if err != nil || req.Mode != dataprovider.IPApprovalModeEmail {
+		s.renderClientIPApprovalPage(w, r, nil, "This approval request does not exist or it has expired", "")
+		return
+	}
+	req, err = common.ApproveIPRequest(req.ID, dataprovider.ActionExecutorSelf, ipAddr, "")
+	if err != nil {
+		errMsg := err.Error()
+		if e, ok := err.(*util.ValidationError); ok {
+			errMsg = e.GetErrorString()
+		}
+		s.renderClientIPApprovalPage(w, r, &req, errMsg, "")
+		return
+	}
+	s.renderClientIPApprovalPage(w, r, nil, "",
+		fmt.Sprintf("The IP address %s was added to the allowed list of the user %q", req.IP, req.Username))
+}
+
 func (s *httpdServer) handleClientViewPDF(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	name := r.URL.Query().Get("path")
@@ -1457,6 +1691,56 @@ func (s *httpdServer) handleClientGetPDF(w http.ResponseWriter, r *http.Request)
 	downloadFile(w, r, connection, name, info, true, nil) //nolint:errcheck
 }
 
+func (s *httpdServer) handleClientGetPreview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, "Invalid token claims")
+		return
+	}
+	name := r.URL.Query().Get("path")
+	if name == "" {
+		s.renderClientBadRequestPage(w, r, errors.New("no file specified"))
+		return
+	}
+	name = common.GetPreviewPath(name)
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		s.renderClientMessagePage(w, r, "Unable to retrieve your user", "", getRespStatus(err), nil, "")
+		return
+	}
+
+	connID := xid.New().String()
+	protocol := getProtocolFromRequest(r)
+	connectionID := fmt.Sprintf("%v_%v", protocol, connID)
+	if err := checkHTTPClientUser(&user, r, connectionID, false); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		s.renderClientMessagePage(w, r, "Unable to get preview", "", getRespStatus(err), err, "")
+		return
+	}
+	if info.IsDir() {
+		s.renderClientMessagePage(w, r, "Invalid preview", fmt.Sprintf("%q is not a file", name),
+			http.StatusBadRequest, nil, "")
+		return
+	}
+	downloadFile(w, r, connection, name, info, true, nil) //nolint:errcheck
+}
+
 func (s *httpdServer) handleClientShareLoginGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	s.renderShareLoginPage(w, r.RequestURI, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
@@ -1499,3 +1783,208 @@ func (s *httpdServer) handleClientShareLoginPost(w http.ResponseWriter, r *http.
 	s.renderClientMessagePage(w, r, "Share Login OK", "Share login successful, you can now use your link",
 		http.StatusOK, nil, "")
 }
+
+func (s *httpdServer) renderAnonymousFilesPage(w http.ResponseWriter, r *http.Request, dirName, error string,
+	user *dataprovider.User,
+) {
+	baseURL := path.Join(webClientAnonymousPath, url.PathEscape(user.Username))
+	currentURL := path.Join(baseURL, "browse")
+	data := shareFilesPage{
+		baseClientPage: s.getBaseClientPageData(pageAnonymousFilesTitle, currentURL, r),
+		CurrentDir:     url.QueryEscape(dirName),
+		DirsURL:        path.Join(baseURL, "dirs"),
+		FilesURL:       currentURL,
+		DownloadURL:    path.Join(baseURL, "partial"),
+		Error:          error,
+		Paths:          getDirMapping(dirName, currentURL),
+		Scope:          dataprovider.ShareScopeRead,
+	}
+	renderClientTemplate(w, templateShareFiles, data)
+}
+
+// checkAnonymousHTTPAccess returns a connection for the anonymous user specified
+// in the URL if unauthenticated HTTP access is allowed for it. Rate limiters
+// for anonymous users are always applied, the defender score is added only if
+// addDefenderEvent is true, this way the AJAX requests done by the web pages
+// are not counted
+func (s *httpdServer) checkAnonymousHTTPAccess(w http.ResponseWriter, r *http.Request, addDefenderEvent bool,
+) (*Connection, error) {
+	isWebClient := isWebClientRequest(r)
+	renderError := func(err error, message string, statusCode int) {
+		if isWebClient {
+			s.renderClientMessagePage(w, r, "Unable to access the anonymous area", message, statusCode, err, "")
+		} else {
+			sendAPIResponse(w, r, err, message, statusCode)
+		}
+	}
+
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	user, err := dataprovider.GetAnonymousHTTPUser(getURLParam(r, "username"))
+	if err != nil {
+		statusCode := getRespStatus(err)
+		if statusCode == http.StatusNotFound {
+			common.AddDefenderEvent(ipAddr, common.ProtocolHTTP, common.HostEventUserNotFound)
+			err = errors.New("anonymous area does not exist")
+		}
+		renderError(err, "", statusCode)
+		return nil, err
+	}
+	connID := xid.New().String()
+	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		logger.Info(logSender, connID, "anonymous access for user %q is not allowed from this address: %v",
+			user.Username, r.RemoteAddr)
+		err = fmt.Errorf("access is not allowed from this address: %v: %w", r.RemoteAddr, os.ErrPermission)
+		renderError(err, "", http.StatusForbidden)
+		return nil, err
+	}
+	if delay, err := common.CheckAnonymousAccess(ipAddr, common.ProtocolHTTP, addDefenderEvent); err != nil {
+		delay += 499999999 * time.Nanosecond
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+		w.Header().Set("X-Retry-In", delay.String())
+		renderError(err, "", http.StatusTooManyRequests)
+		return nil, err
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolHTTP, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	return connection, nil
+}
+
+func (s *httpdServer) handleAnonymousGetFiles(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := s.checkAnonymousHTTPAccess(w, r, true)
+	if err != nil {
+		return
+	}
+	user := &connection.User
+	name := user.GetCleanedPath(r.URL.Query().Get("path"))
+	if !user.IsAnonymousHTTPPathVisible(name) {
+		s.renderClientMessagePage(w, r, "Invalid path", "", http.StatusForbidden,
+			fmt.Errorf("path %q is not available for anonymous access: %w", name, os.ErrPermission), "")
+		return
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	var info os.FileInfo
+	if name == "/" {
+		info = vfs.NewFileInfo(name, true, 0, time.Unix(0, 0), false)
+	} else {
+		info, err = connection.Stat(name, 1)
+	}
+	if err != nil {
+		s.renderAnonymousFilesPage(w, r, path.Dir(name), err.Error(), user)
+		return
+	}
+	if info.IsDir() {
+		s.renderAnonymousFilesPage(w, r, name, "", user)
+		return
+	}
+	if !user.IsAnonymousHTTPPathAllowed(name) {
+		s.renderAnonymousFilesPage(w, r, path.Dir(name), "You are not allowed to download this file", user)
+		return
+	}
+	if status, err := downloadFile(w, r, connection, name, info, false, nil); err != nil && status > 0 {
+		s.renderAnonymousFilesPage(w, r, path.Dir(name), err.Error(), user)
+	}
+}
+
+func (s *httpdServer) handleAnonymousGetDirContents(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := s.checkAnonymousHTTPAccess(w, r, false)
+	if err != nil {
+		return
+	}
+	user := &connection.User
+	name := user.GetCleanedPath(r.URL.Query().Get("path"))
+	if !user.IsAnonymousHTTPPathVisible(name) {
+		sendAPIResponse(w, r, nil, "Path not available for anonymous access", http.StatusForbidden)
+		return
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		sendAPIResponse(w, r, err, "Unable to add connection", http.StatusTooManyRequests)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	contents, err := connection.ReadDir(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
+		return
+	}
+	browseURL := path.Join(webClientAnonymousPath, url.PathEscape(user.Username), "browse")
+	results := make([]map[string]string, 0, len(contents))
+	for _, info := range contents {
+		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		if !user.IsAnonymousHTTPPathVisible(path.Join(name, info.Name())) {
+			continue
+		}
+		if !info.IsDir() && !user.IsAnonymousHTTPPathAllowed(path.Join(name, info.Name())) {
+			continue
+		}
+		res := make(map[string]string)
+		if info.IsDir() {
+			res["type"] = "1"
+			res["size"] = ""
+		} else {
+			res["type"] = "2"
+			res["size"] = util.ByteCountIEC(info.Size())
+		}
+		res["meta"] = fmt.Sprintf("%v_%v", res["type"], info.Name())
+		res["name"] = info.Name()
+		res["url"] = getFileObjectURL(name, info.Name(), browseURL)
+		res["last_modified"] = getFileObjectModTime(info.ModTime())
+		results = append(results, res)
+	}
+
+	render.JSON(w, r, results)
+}
+
+func (s *httpdServer) handleAnonymousPartialDownload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := s.checkAnonymousHTTPAccess(w, r, true)
+	if err != nil {
+		return
+	}
+	user := &connection.User
+	name := user.GetCleanedPath(r.URL.Query().Get("path"))
+	files := r.URL.Query().Get("files")
+	var filesList []string
+	err = json.Unmarshal([]byte(files), &filesList)
+	if err != nil {
+		s.renderClientMessagePage(w, r, "Unable to get files list", "", http.StatusInternalServerError, err, "")
+		return
+	}
+	for _, f := range filesList {
+		p := util.CleanPath(path.Join(name, f))
+		if !user.IsAnonymousHTTPPathAllowed(p) {
+			s.renderClientMessagePage(w, r, "Invalid path", "", http.StatusForbidden,
+				fmt.Errorf("path %q is not available for anonymous access: %w", p, os.ErrPermission), "")
+			return
+		}
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	transferQuota := connection.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		err = connection.GetReadQuotaExceededError()
+		connection.Log(logger.LevelInfo, "denying anonymous read due to quota limits")
+		s.renderClientMessagePage(w, r, "Denying read due to quota limits", "", getMappedStatusCode(err), err, "")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"",
+		getCompressedFileName(connection.GetUsername(), filesList)))
+	renderCompressedFiles(w, connection, name, filesList, nil)
+}
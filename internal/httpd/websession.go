@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"sort"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	// audiences for which a web session is tracked
+	webSessionAudiences = []tokenAudience{tokenAudienceWebAdmin, tokenAudienceWebClient, tokenAudienceAPI,
+		tokenAudienceAPIUser}
+)
+
+// trackWebSession adds or updates the web session associated with the
+// specified claims. API keys, node and OIDC tokens are not tracked, they are
+// generated for each request
+func trackWebSession(c *jwtTokenClaims, audience tokenAudience, ip string, expiresAt time.Time) {
+	if c.SessionID == "" {
+		return
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	session, err := common.WebSessions.Get(c.SessionID)
+	if err != nil {
+		session = &common.WebSession{
+			ID:        c.SessionID,
+			Username:  c.Username,
+			IsAdmin:   audience == tokenAudienceWebAdmin || audience == tokenAudienceAPI,
+			Audience:  audience,
+			CreatedAt: now,
+		}
+	}
+	session.Role = c.Role
+	session.IP = ip
+	session.UpdatedAt = now
+	session.ExpiresAt = util.GetTimeAsMsSinceEpoch(expiresAt)
+	if err := common.WebSessions.Add(session); err != nil {
+		logger.Warn(logSender, "", "unable to track web session %q for %q: %v", session.ID, session.Username, err)
+	}
+}
+
+func canTrackWebSession(c *jwtTokenClaims, audience tokenAudience) bool {
+	return c.Signature != "" && c.APIKeyID == "" && c.NodeID == "" && util.Contains(webSessionAudiences, audience)
+}
+
+// getWebSessions returns the active web sessions, filtered by the specified
+// username, account type and role, if not empty
+func getWebSessions(username, role string, isAdmin bool) ([]common.WebSession, error) {
+	sessions, err := common.WebSessions.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]common.WebSession, 0, len(sessions))
+	for _, s := range sessions {
+		if s.Revoked || s.IsAdmin != isAdmin {
+			continue
+		}
+		if username != "" && s.Username != username {
+			continue
+		}
+		if role != "" && s.Role != role {
+			continue
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Username == result[j].Username {
+			return result[i].CreatedAt < result[j].CreatedAt
+		}
+		return result[i].Username < result[j].Username
+	})
+	return result, nil
+}
@@ -33,6 +33,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
@@ -60,9 +61,12 @@ const (
 	apiKeysPath           = "/api/v2/apikeys"
 	retentionBasePath     = "/api/v2/retention/users"
 	retentionChecksPath   = "/api/v2/retention/users/checks"
+	replicationStatusPath = "/api/v2/replication/status"
+	deadLettersPath       = "/api/v2/deadletters"
 	eventActionsPath      = "/api/v2/eventactions"
 	eventRulesPath        = "/api/v2/eventrules"
 	rolesPath             = "/api/v2/roles"
+	tenantsPath           = "/api/v2/tenants"
 	ipListsPath           = "/api/v2/iplists"
 )
 
@@ -479,6 +483,115 @@ func GetRoles(limit, offset int64, expectedStatusCode int) ([]dataprovider.Role,
 	return roles, body, err
 }
 
+// AddTenant adds a new tenant and checks the received HTTP Status code against expectedStatusCode.
+func AddTenant(tenant dataprovider.Tenant, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var newTenant dataprovider.Tenant
+	var body []byte
+	asJSON, _ := json.Marshal(tenant)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(tenantsPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return newTenant, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusCreated {
+		body, _ = getResponseBody(resp)
+		return newTenant, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newTenant)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkTenant(tenant, newTenant)
+	}
+	return newTenant, body, err
+}
+
+// UpdateTenant updates an existing tenant and checks the received HTTP Status code against expectedStatusCode
+func UpdateTenant(tenant dataprovider.Tenant, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var newTenant dataprovider.Tenant
+	var body []byte
+
+	asJSON, _ := json.Marshal(tenant)
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(tenantsPath, url.PathEscape(tenant.Name)),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return newTenant, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newTenant, body, err
+	}
+	if err == nil {
+		newTenant, body, err = GetTenantByName(tenant.Name, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkTenant(tenant, newTenant)
+	}
+	return newTenant, body, err
+}
+
+// RemoveTenant removes an existing tenant and checks the received HTTP Status code against expectedStatusCode.
+func RemoveTenant(tenant dataprovider.Tenant, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(tenantsPath, url.PathEscape(tenant.Name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetTenantByName gets a tenant by name and checks the received HTTP Status code against expectedStatusCode.
+func GetTenantByName(name string, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var tenant dataprovider.Tenant
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(tenantsPath, url.PathEscape(name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return tenant, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &tenant)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return tenant, body, err
+}
+
+// GetTenants returns a list of tenants and checks the received HTTP Status code against expectedStatusCode.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetTenants(limit, offset int64, expectedStatusCode int) ([]dataprovider.Tenant, []byte, error) {
+	var tenants []dataprovider.Tenant
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(tenantsPath), limit, offset)
+	if err != nil {
+		return tenants, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return tenants, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &tenants)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return tenants, body, err
+}
+
 // AddIPListEntry adds a new IP list entry and checks the received HTTP Status code against expectedStatusCode.
 func AddIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) (dataprovider.IPListEntry, []byte, error) {
 	var newEntry dataprovider.IPListEntry
@@ -829,6 +942,32 @@ func RemoveAPIKey(apiKey dataprovider.APIKey, expectedStatusCode int) ([]byte, e
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// RotateAPIKey generates a new key for an existing API key and checks the received HTTP Status code
+// against expectedStatusCode. The returned API key contains the new key
+func RotateAPIKey(apiKey dataprovider.APIKey, expectedStatusCode int) (dataprovider.APIKey, []byte, error) {
+	var newAPIKey dataprovider.APIKey
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(apiKeysPath, url.PathEscape(apiKey.KeyID), "rotate"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return newAPIKey, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK || err != nil {
+		body, _ = getResponseBody(resp)
+		return newAPIKey, body, err
+	}
+	response := make(map[string]string)
+	err = render.DecodeJSON(resp.Body, &response)
+	if err == nil {
+		newAPIKey, body, err = GetAPIKeyByID(apiKey.KeyID, http.StatusOK)
+	}
+	newAPIKey.Key = response["key"]
+
+	return newAPIKey, body, err
+}
+
 // GetAPIKeyByID gets a API key by ID and checks the received HTTP Status code against expectedStatusCode.
 func GetAPIKeyByID(keyID string, expectedStatusCode int) (dataprovider.APIKey, []byte, error) {
 	var apiKey dataprovider.APIKey
@@ -1116,6 +1255,20 @@ func StartQuotaScan(user dataprovider.User, expectedStatusCode int) ([]byte, err
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// StartQuotaReconciliation starts a new quota reconciliation run
+// and checks the received HTTP Status code against expectedStatusCode.
+func StartQuotaReconciliation(expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(quotasBasePath, "reconcile"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // UpdateQuotaUsage updates the user used quota limits and checks the received
 // HTTP Status code against expectedStatusCode.
 func UpdateQuotaUsage(user dataprovider.User, mode string, expectedStatusCode int) ([]byte, error) {
@@ -1172,6 +1325,88 @@ func GetRetentionChecks(expectedStatusCode int) ([]common.ActiveRetentionChecks,
 	return checks, body, err
 }
 
+// GetReplicationStatus returns the replications status
+func GetReplicationStatus(expectedStatusCode int) (common.ReplicationStatus, []byte, error) {
+	var status common.ReplicationStatus
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(replicationStatusPath), nil, "", getDefaultToken())
+	if err != nil {
+		return status, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &status)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return status, body, err
+}
+
+// GetDeadLetters returns the dead letters and checks the received HTTP Status code against expectedStatusCode.
+func GetDeadLetters(expectedStatusCode int) ([]common.DeadLetter, []byte, error) {
+	var letters []common.DeadLetter
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(deadLettersPath), nil, "", getDefaultToken())
+	if err != nil {
+		return letters, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &letters)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return letters, body, err
+}
+
+// GetDeadLetterByID gets a dead letter by id and checks the received HTTP Status code against expectedStatusCode.
+func GetDeadLetterByID(id string, expectedStatusCode int) (common.DeadLetter, []byte, error) {
+	var letter common.DeadLetter
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(deadLettersPath, url.PathEscape(id)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return letter, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &letter)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return letter, body, err
+}
+
+// RetryDeadLetter executes the failed action for the given dead letter and checks the received HTTP Status code
+// against expectedStatusCode.
+func RetryDeadLetter(id string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(deadLettersPath, url.PathEscape(id), "retry"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// RemoveDeadLetter removes a dead letter and checks the received HTTP Status code against expectedStatusCode.
+func RemoveDeadLetter(id string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(deadLettersPath, url.PathEscape(id)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // StartRetentionCheck starts a new retention check
 func StartRetentionCheck(username string, retention []dataprovider.FolderRetention, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -1596,6 +1831,9 @@ func checkEventAction(expected, actual dataprovider.BaseEventAction) error {
 	if expected.Options.PwdExpirationConfig.Threshold != actual.Options.PwdExpirationConfig.Threshold {
 		return errors.New("password expiration threshold mismatch")
 	}
+	if expected.Options.PwdExpirationConfig.NotifyAdmins != actual.Options.PwdExpirationConfig.NotifyAdmins {
+		return errors.New("password expiration notify admins mismatch")
+	}
 	if err := compareEventActionCmdConfigFields(expected.Options.CmdConfig, actual.Options.CmdConfig); err != nil {
 		return err
 	}
@@ -1608,6 +1846,27 @@ func checkEventAction(expected, actual dataprovider.BaseEventAction) error {
 	if err := compareEventActionFsConfigFields(expected.Options.FsConfig, actual.Options.FsConfig); err != nil {
 		return err
 	}
+	if err := compareEventActionBrokerConfigFields(expected.Options.BrokerConfig, actual.Options.BrokerConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionFunctionConfigFields(expected.Options.FunctionConfig, actual.Options.FunctionConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionReplicationConfigFields(expected.Options.ReplicationConfig, actual.Options.ReplicationConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionThumbnailConfigFields(expected.Options.ThumbnailConfig, actual.Options.ThumbnailConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionChecksumConfigFields(expected.Options.ChecksumConfig, actual.Options.ChecksumConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionPushConfigFields(expected.Options.PushConfig, actual.Options.PushConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionLifecycleConfigFields(expected.Options.LifecycleConfig, actual.Options.LifecycleConfig); err != nil {
+		return err
+	}
 	return compareEventActionHTTPConfigFields(expected.Options.HTTPConfig, actual.Options.HTTPConfig)
 }
 
@@ -1684,6 +1943,33 @@ func checkEventConditionOptions(expected, actual dataprovider.ConditionOptions)
 	if expected.MaxFileSize != actual.MaxFileSize {
 		return errors.New("condition max file size mismatch")
 	}
+	return checkEventConditionContent(expected.Content, actual.Content)
+}
+
+func checkEventConditionContent(expected, actual dataprovider.FileContentConditions) error {
+	if len(expected.MIMETypes) != len(actual.MIMETypes) {
+		return errors.New("condition content MIME types mismatch")
+	}
+	for _, v := range expected.MIMETypes {
+		if !util.Contains(actual.MIMETypes, v) {
+			return errors.New("condition content MIME types content mismatch")
+		}
+	}
+	if expected.MinLines != actual.MinLines {
+		return errors.New("condition content min lines mismatch")
+	}
+	if expected.MaxLines != actual.MaxLines {
+		return errors.New("condition content max lines mismatch")
+	}
+	if strings.Join(expected.CSVHeader, ",") != strings.Join(actual.CSVHeader, ",") {
+		return errors.New("condition content CSV header mismatch")
+	}
+	if expected.Pattern != actual.Pattern {
+		return errors.New("condition content pattern mismatch")
+	}
+	if expected.ScanSize != actual.ScanSize {
+		return errors.New("condition content scan size mismatch")
+	}
 	return nil
 }
 
@@ -1704,6 +1990,9 @@ func checkEventConditions(expected, actual dataprovider.EventConditions) error {
 			return errors.New("provider events content mismatch")
 		}
 	}
+	if expected.QuotaThreshold != actual.QuotaThreshold {
+		return errors.New("quota threshold mismatch")
+	}
 	if err := checkEventConditionOptions(expected.Options, actual.Options); err != nil {
 		return err
 	}
@@ -1719,7 +2008,9 @@ func checkEventRuleActions(expected, actual []dataprovider.EventAction) error {
 		found := false
 		for _, ac := range actual {
 			if ex.Name == ac.Name && ex.Order == ac.Order && ex.Options.ExecuteSync == ac.Options.ExecuteSync &&
-				ex.Options.IsFailureAction == ac.Options.IsFailureAction && ex.Options.StopOnFailure == ac.Options.StopOnFailure {
+				ex.Options.IsFailureAction == ac.Options.IsFailureAction && ex.Options.StopOnFailure == ac.Options.StopOnFailure &&
+				ex.Options.DependsOn == ac.Options.DependsOn && ex.Options.RunOn == ac.Options.RunOn &&
+				ex.Options.Retries == ac.Options.Retries && ex.Options.RetryDelay == ac.Options.RetryDelay {
 				found = true
 				break
 			}
@@ -1815,6 +2106,64 @@ func checkRole(expected, actual dataprovider.Role) error {
 	return nil
 }
 
+func checkTenant(expected, actual dataprovider.Tenant) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual tenant ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("tenant ID mismatch")
+		}
+	}
+	if dataprovider.ConvertName(expected.Name) != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if expected.QuotaSize != actual.QuotaSize || expected.QuotaFiles != actual.QuotaFiles {
+		return errors.New("quota mismatch")
+	}
+	if expected.MaxSessions != actual.MaxSessions {
+		return errors.New("max sessions mismatch")
+	}
+	if expected.Branding != actual.Branding {
+		return errors.New("branding mismatch")
+	}
+	if err := checkTenantMembers(expected.Members.Roles, actual.Members.Roles); err != nil {
+		return fmt.Errorf("roles %w", err)
+	}
+	if err := checkTenantMembers(expected.Members.Groups, actual.Members.Groups); err != nil {
+		return fmt.Errorf("groups %w", err)
+	}
+	if err := checkTenantMembers(expected.Members.Folders, actual.Members.Folders); err != nil {
+		return fmt.Errorf("folders %w", err)
+	}
+	if err := checkTenantMembers(expected.Members.EventRules, actual.Members.EventRules); err != nil {
+		return fmt.Errorf("event rules %w", err)
+	}
+	if actual.CreatedAt == 0 {
+		return errors.New("created_at unset")
+	}
+	if actual.UpdatedAt == 0 {
+		return errors.New("updated_at unset")
+	}
+	return nil
+}
+
+func checkTenantMembers(expected, actual []string) error {
+	if len(expected) != len(actual) {
+		return errors.New("mismatch")
+	}
+	for _, v := range expected {
+		if !util.Contains(actual, v) {
+			return errors.New("content mismatch")
+		}
+	}
+	return nil
+}
+
 func checkGroup(expected, actual dataprovider.Group) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1841,6 +2190,44 @@ func checkGroup(expected, actual dataprovider.Group) error {
 		actual.UserSettings.BaseGroupUserSettings); err != nil {
 		return err
 	}
+	if len(expected.IncludedGroups) != len(actual.IncludedGroups) {
+		return errors.New("included groups mismatch")
+	}
+	for idx := range expected.IncludedGroups {
+		if dataprovider.ConvertName(expected.IncludedGroups[idx]) != actual.IncludedGroups[idx] {
+			return errors.New("included groups mismatch")
+		}
+	}
+	if expected.UserSettings.AggregateUploadBandwidth != actual.UserSettings.AggregateUploadBandwidth {
+		return errors.New("aggregate upload bandwidth mismatch")
+	}
+	if expected.UserSettings.AggregateDownloadBandwidth != actual.UserSettings.AggregateDownloadBandwidth {
+		return errors.New("aggregate download bandwidth mismatch")
+	}
+	if expected.UserSettings.ExpirationWarningThreshold != actual.UserSettings.ExpirationWarningThreshold {
+		return errors.New("expiration warning threshold mismatch")
+	}
+	if expected.UserSettings.RememberedDevices != actual.UserSettings.RememberedDevices {
+		return errors.New("remembered devices mismatch")
+	}
+	if err := compareSSHAlgorithms(expected.UserSettings.SSHAlgorithms, actual.UserSettings.SSHAlgorithms); err != nil {
+		return err
+	}
+	if expected.UserSettings.InactivityThreshold != actual.UserSettings.InactivityThreshold {
+		return errors.New("inactivity threshold mismatch")
+	}
+	if err := compareBandwidthSchedules(expected.UserSettings.BandwidthSchedules,
+		actual.UserSettings.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := compareAccessTime(expected.UserSettings.AccessTimeWindows, actual.UserSettings.AccessTimeWindows,
+		expected.UserSettings.AccessTimeZone, actual.UserSettings.AccessTimeZone,
+		expected.UserSettings.DisconnectOutsideAccessTime, actual.UserSettings.DisconnectOutsideAccessTime); err != nil {
+		return err
+	}
+	if err := compareDLPPolicies(expected.UserSettings.DLPPolicies, actual.UserSettings.DLPPolicies); err != nil {
+		return err
+	}
 	if err := compareVirtualFolders(expected.VirtualFolders, actual.VirtualFolders); err != nil {
 		return err
 	}
@@ -1850,6 +2237,153 @@ func checkGroup(expected, actual dataprovider.Group) error {
 	return compareFsConfig(&expected.UserSettings.FsConfig, &actual.UserSettings.FsConfig)
 }
 
+func compareBandwidthSchedules(expected, actual []dataprovider.BandwidthSchedule) error {
+	if len(expected) != len(actual) {
+		return errors.New("bandwidth schedules mismatch")
+	}
+	for idx, s := range expected {
+		if s.From != actual[idx].From || s.To != actual[idx].To {
+			return errors.New("bandwidth schedule time mismatch")
+		}
+		if s.UploadBandwidth != actual[idx].UploadBandwidth || s.DownloadBandwidth != actual[idx].DownloadBandwidth {
+			return errors.New("bandwidth schedule limits mismatch")
+		}
+		if len(s.Days) != len(actual[idx].Days) {
+			return errors.New("bandwidth schedule days mismatch")
+		}
+		for _, day := range s.Days {
+			if !util.Contains(actual[idx].Days, day) {
+				return errors.New("bandwidth schedule days content mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+func compareGeoIPFilter(expected, actual geoip.Filter) error {
+	if len(expected.AllowedCountries) != len(actual.AllowedCountries) {
+		return errors.New("GeoIP allowed countries mismatch")
+	}
+	for _, c := range expected.AllowedCountries {
+		if !util.Contains(actual.AllowedCountries, strings.ToUpper(c)) {
+			return errors.New("GeoIP allowed countries content mismatch")
+		}
+	}
+	if len(expected.DeniedCountries) != len(actual.DeniedCountries) {
+		return errors.New("GeoIP denied countries mismatch")
+	}
+	for _, c := range expected.DeniedCountries {
+		if !util.Contains(actual.DeniedCountries, strings.ToUpper(c)) {
+			return errors.New("GeoIP denied countries content mismatch")
+		}
+	}
+	if len(expected.AllowedASNs) != len(actual.AllowedASNs) {
+		return errors.New("GeoIP allowed ASNs mismatch")
+	}
+	for _, asn := range expected.AllowedASNs {
+		if !util.Contains(actual.AllowedASNs, asn) {
+			return errors.New("GeoIP allowed ASNs content mismatch")
+		}
+	}
+	if len(expected.DeniedASNs) != len(actual.DeniedASNs) {
+		return errors.New("GeoIP denied ASNs mismatch")
+	}
+	for _, asn := range expected.DeniedASNs {
+		if !util.Contains(actual.DeniedASNs, asn) {
+			return errors.New("GeoIP denied ASNs content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareAccessTime(expected, actual []dataprovider.AccessTimeWindow, expectedTZ, actualTZ string,
+	expectedDisconnect, actualDisconnect bool,
+) error {
+	if expectedTZ != actualTZ {
+		return errors.New("access time zone mismatch")
+	}
+	if expectedDisconnect != actualDisconnect {
+		return errors.New("disconnect outside access time mismatch")
+	}
+	if len(expected) != len(actual) {
+		return errors.New("access time windows mismatch")
+	}
+	for idx, w := range expected {
+		if w.From != actual[idx].From || w.To != actual[idx].To {
+			return errors.New("access time window mismatch")
+		}
+		if len(w.Days) != len(actual[idx].Days) {
+			return errors.New("access time window days mismatch")
+		}
+		for _, day := range w.Days {
+			if !util.Contains(actual[idx].Days, day) {
+				return errors.New("access time window days content mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+func compareTLSCertPins(expected, actual []string) error {
+	if len(expected) != len(actual) {
+		return errors.New("TLS certificate pins mismatch")
+	}
+	// fingerprints are normalized to uppercase and colon separated
+	normalize := func(pin string) string {
+		return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+	}
+	for _, pin := range expected {
+		found := false
+		for _, p := range actual {
+			if normalize(pin) == normalize(p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("TLS certificate pins content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareDLPPolicies(expected, actual []string) error {
+	if len(expected) != len(actual) {
+		return errors.New("DLP policies mismatch")
+	}
+	for _, policy := range expected {
+		if !util.Contains(actual, policy) {
+			return errors.New("DLP policies content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareContentTypesFilters(expected, actual []dataprovider.ContentTypesFilter) error {
+	if len(expected) != len(actual) {
+		return errors.New("content types filters mismatch")
+	}
+	for idx, f := range expected {
+		if f.Path != actual[idx].Path {
+			return errors.New("content types filter path mismatch")
+		}
+		if len(f.AllowedTypes) != len(actual[idx].AllowedTypes) || len(f.DeniedTypes) != len(actual[idx].DeniedTypes) {
+			return errors.New("content types filter mismatch")
+		}
+		for _, t := range f.AllowedTypes {
+			if !util.Contains(actual[idx].AllowedTypes, strings.ToLower(t)) {
+				return errors.New("allowed content types mismatch")
+			}
+		}
+		for _, t := range f.DeniedTypes {
+			if !util.Contains(actual[idx].DeniedTypes, strings.ToLower(t)) {
+				return errors.New("denied content types mismatch")
+			}
+		}
+	}
+	return nil
+}
+
 func checkFolder(expected *vfs.BaseVirtualFolder, actual *vfs.BaseVirtualFolder) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1903,6 +2437,22 @@ func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if expected.Admin != actual.Admin {
 		return errors.New("admin mismatch")
 	}
+	if len(expected.Filters.AccessScopes) != len(actual.Filters.AccessScopes) {
+		return errors.New("access scopes mismatch")
+	}
+	for _, accessScope := range expected.Filters.AccessScopes {
+		if !util.Contains(actual.Filters.AccessScopes, accessScope) {
+			return errors.New("access scopes content mismatch")
+		}
+	}
+	if len(expected.Filters.AllowList) != len(actual.Filters.AllowList) {
+		return errors.New("allow list mismatch")
+	}
+	for _, ipMask := range expected.Filters.AllowList {
+		if !util.Contains(actual.Filters.AllowList, ipMask) {
+			return errors.New("allow list content mismatch")
+		}
+	}
 
 	return nil
 }
@@ -1960,6 +2510,42 @@ func compareAdminFilters(expected, actual dataprovider.AdminFilters) error {
 	if expected.Preferences.DefaultUsersExpiration != actual.Preferences.DefaultUsersExpiration {
 		return errors.New("default users expiration mismatch")
 	}
+	if expected.Tenant != actual.Tenant {
+		return errors.New("tenant mismatch")
+	}
+	if expected.RememberedDevices != actual.RememberedDevices {
+		return errors.New("remembered devices mismatch")
+	}
+	if expected.HideFsConfig != actual.HideFsConfig {
+		return errors.New("hide fs config mismatch")
+	}
+	return compareAdminGrants(expected.Grants, actual.Grants)
+}
+
+func compareAdminGrants(expected, actual []dataprovider.AdminPermissionGrant) error {
+	if len(expected) != len(actual) {
+		return errors.New("grants mismatch")
+	}
+	for _, e := range expected {
+		found := false
+		for _, a := range actual {
+			if e.Group != a.Group {
+				continue
+			}
+			found = true
+			if len(e.Permissions) != len(a.Permissions) {
+				return fmt.Errorf("permissions mismatch for grant %q", e.Group)
+			}
+			for _, p := range e.Permissions {
+				if !util.Contains(a.Permissions, p) {
+					return fmt.Errorf("permissions content mismatch for grant %q", e.Group)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("grant for group %q not found", e.Group)
+		}
+	}
 	return nil
 }
 
@@ -2010,6 +2596,66 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if expected.Filters.RequirePasswordChange != actual.Filters.RequirePasswordChange {
 		return errors.New("require_password_change mismatch")
 	}
+	if expected.Filters.Trash != actual.Filters.Trash {
+		return errors.New("trash mismatch")
+	}
+	if expected.Filters.MaxTransfers != actual.Filters.MaxTransfers {
+		return errors.New("max transfers mismatch")
+	}
+	if expected.Filters.TransfersQueueTimeout != actual.Filters.TransfersQueueTimeout {
+		return errors.New("transfers queue timeout mismatch")
+	}
+	if expected.Filters.MaxDirEntries != actual.Filters.MaxDirEntries {
+		return errors.New("max dir entries mismatch")
+	}
+	if expected.Filters.MaxPathDepth != actual.Filters.MaxPathDepth {
+		return errors.New("max path depth mismatch")
+	}
+	if expected.Filters.RememberedDevices != actual.Filters.RememberedDevices {
+		return errors.New("remembered devices mismatch")
+	}
+	if len(expected.Filters.AllowedTCPForwards) != len(actual.Filters.AllowedTCPForwards) {
+		return errors.New("allowed TCP forwards mismatch")
+	}
+	for _, forward := range expected.Filters.AllowedTCPForwards {
+		if !util.Contains(actual.Filters.AllowedTCPForwards, forward) {
+			return errors.New("allowed TCP forwards content mismatch")
+		}
+	}
+	if len(expected.Filters.AnonymousHTTPPaths) != len(actual.Filters.AnonymousHTTPPaths) {
+		return errors.New("anonymous HTTP paths mismatch")
+	}
+	for _, p := range expected.Filters.AnonymousHTTPPaths {
+		if !util.Contains(actual.Filters.AnonymousHTTPPaths, p) {
+			return errors.New("anonymous HTTP paths content mismatch")
+		}
+	}
+	if err := compareBandwidthSchedules(expected.Filters.BandwidthSchedules, actual.Filters.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := compareAccessTime(expected.Filters.AccessTimeWindows, actual.Filters.AccessTimeWindows,
+		expected.Filters.AccessTimeZone, actual.Filters.AccessTimeZone,
+		expected.Filters.DisconnectOutsideAccessTime, actual.Filters.DisconnectOutsideAccessTime); err != nil {
+		return err
+	}
+	if err := compareContentTypesFilters(expected.Filters.ContentTypes, actual.Filters.ContentTypes); err != nil {
+		return err
+	}
+	if err := compareDLPPolicies(expected.Filters.DLPPolicies, actual.Filters.DLPPolicies); err != nil {
+		return err
+	}
+	if err := compareGeoIPFilter(expected.Filters.GeoIP, actual.Filters.GeoIP); err != nil {
+		return err
+	}
+	if expected.Filters.IPApproval != actual.Filters.IPApproval {
+		return errors.New("IP approval mismatch")
+	}
+	if err := compareTLSCertPins(expected.Filters.TLSCertPins, actual.Filters.TLSCertPins); err != nil {
+		return err
+	}
+	if err := compareSSHAlgorithms(expected.Filters.SSHAlgorithms, actual.Filters.SSHAlgorithms); err != nil {
+		return err
+	}
 	if err := compareUserPermissions(expected.Permissions, actual.Permissions); err != nil {
 		return err
 	}
@@ -2028,6 +2674,19 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	return compareEqualsUserFields(expected, actual)
 }
 
+func compareSSHAlgorithms(expected, actual dataprovider.UserSSHAlgorithms) error {
+	if !checkFilterMatch(expected.KexAlgorithms, actual.KexAlgorithms) {
+		return errors.New("SSH KEX algorithms mismatch")
+	}
+	if !checkFilterMatch(expected.Ciphers, actual.Ciphers) {
+		return errors.New("SSH ciphers mismatch")
+	}
+	if !checkFilterMatch(expected.MACs, actual.MACs) {
+		return errors.New("SSH MACs mismatch")
+	}
+	return nil
+}
+
 func compareUserPermissions(expected map[string][]string, actual map[string][]string) error {
 	if len(expected) != len(actual) {
 		return errors.New("permissions mismatch")
@@ -2105,6 +2764,9 @@ func compareVirtualFolders(expected []vfs.VirtualFolder, actual []vfs.VirtualFol
 				if (v.QuotaFiles) != (v1.QuotaFiles) {
 					return errors.New("vfolder quota files mismatch")
 				}
+				if v.UserQuotaSize != v1.UserQuotaSize || v.UserQuotaFiles != v1.UserQuotaFiles {
+					return errors.New("vfolder user quota mismatch")
+				}
 				found = true
 				break
 			}
@@ -2120,6 +2782,18 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.Provider != actual.Provider {
 		return errors.New("fs provider mismatch")
 	}
+	if expected.OSConfig.UID != actual.OSConfig.UID {
+		return errors.New("fs uid mismatch")
+	}
+	if expected.OSConfig.GID != actual.OSConfig.GID {
+		return errors.New("fs gid mismatch")
+	}
+	if expected.OSConfig.Umask != actual.OSConfig.Umask {
+		return errors.New("fs umask mismatch")
+	}
+	if expected.OSConfig.Compression != actual.OSConfig.Compression {
+		return errors.New("fs compression mismatch")
+	}
 	if err := compareS3Config(expected, actual); err != nil {
 		return err
 	}
@@ -2163,6 +2837,9 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	if expected.S3Config.ACL != actual.S3Config.ACL {
 		return errors.New("fs S3 ACL mismatch")
 	}
+	if err := compareStorageClassRules(expected.S3Config.StorageClassRules, actual.S3Config.StorageClassRules); err != nil {
+		return fmt.Errorf("fs S3 %w", err)
+	}
 	if expected.S3Config.UploadPartSize != actual.S3Config.UploadPartSize {
 		return errors.New("fs S3 upload part size mismatch")
 	}
@@ -2178,6 +2855,15 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	if expected.S3Config.ForcePathStyle != actual.S3Config.ForcePathStyle {
 		return errors.New("fs S3 force path style mismatch")
 	}
+	if expected.S3Config.EmulateSymlinks != actual.S3Config.EmulateSymlinks {
+		return errors.New("fs S3 emulate symlinks mismatch")
+	}
+	if expected.S3Config.CloudRetryPolicy != actual.S3Config.CloudRetryPolicy {
+		return errors.New("fs S3 retry policy mismatch")
+	}
+	if expected.S3Config.RotationInterval != actual.S3Config.RotationInterval {
+		return errors.New("fs S3 rotation interval mismatch")
+	}
 	if expected.S3Config.DownloadPartMaxTime != actual.S3Config.DownloadPartMaxTime {
 		return errors.New("fs S3 download part max time mismatch")
 	}
@@ -2191,6 +2877,18 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	return nil
 }
 
+func compareStorageClassRules(expected, actual []vfs.StorageClassRule) error {
+	if len(expected) != len(actual) {
+		return errors.New("storage class rules mismatch")
+	}
+	for idx := range expected {
+		if expected[idx] != actual[idx] {
+			return errors.New("storage class rules content mismatch")
+		}
+	}
+	return nil
+}
+
 func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.Bucket != actual.GCSConfig.Bucket {
 		return errors.New("GCS bucket mismatch")
@@ -2201,6 +2899,9 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.ACL != actual.GCSConfig.ACL {
 		return errors.New("GCS ACL mismatch")
 	}
+	if err := compareStorageClassRules(expected.GCSConfig.StorageClassRules, actual.GCSConfig.StorageClassRules); err != nil {
+		return fmt.Errorf("GCS %w", err)
+	}
 	if expected.GCSConfig.KeyPrefix != actual.GCSConfig.KeyPrefix &&
 		expected.GCSConfig.KeyPrefix+"/" != actual.GCSConfig.KeyPrefix {
 		return errors.New("GCS key prefix mismatch")
@@ -2214,6 +2915,15 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.UploadPartMaxTime != actual.GCSConfig.UploadPartMaxTime {
 		return errors.New("GCS upload part max time mismatch")
 	}
+	if expected.GCSConfig.EmulateSymlinks != actual.GCSConfig.EmulateSymlinks {
+		return errors.New("GCS emulate symlinks mismatch")
+	}
+	if expected.GCSConfig.CloudRetryPolicy != actual.GCSConfig.CloudRetryPolicy {
+		return errors.New("GCS retry policy mismatch")
+	}
+	if expected.GCSConfig.RotationInterval != actual.GCSConfig.RotationInterval {
+		return errors.New("GCS rotation interval mismatch")
+	}
 	return nil
 }
 
@@ -2236,6 +2946,15 @@ func compareHTTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if err := checkEncryptedSecret(expected.HTTPConfig.APIKey, actual.HTTPConfig.APIKey); err != nil {
 		return fmt.Errorf("HTTPFs API key mismatch: %v", err)
 	}
+	if expected.HTTPConfig.OAuth2.TokenURL != actual.HTTPConfig.OAuth2.TokenURL {
+		return errors.New("HTTPFs OAuth2 token URL mismatch")
+	}
+	if expected.HTTPConfig.OAuth2.ClientID != actual.HTTPConfig.OAuth2.ClientID {
+		return errors.New("HTTPFs OAuth2 client id mismatch")
+	}
+	if err := checkEncryptedSecret(expected.HTTPConfig.OAuth2.ClientSecret, actual.HTTPConfig.OAuth2.ClientSecret); err != nil {
+		return fmt.Errorf("HTTPFs OAuth2 client secret mismatch: %v", err)
+	}
 	return nil
 }
 
@@ -2255,6 +2974,15 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.SFTPConfig.EqualityCheckMode != actual.SFTPConfig.EqualityCheckMode {
 		return errors.New("SFTPFs equality_check_mode mismatch")
 	}
+	if expected.SFTPConfig.ProxyURL != actual.SFTPConfig.ProxyURL {
+		return errors.New("SFTPFs proxy_url mismatch")
+	}
+	if expected.SFTPConfig.JumpHostUsername != actual.SFTPConfig.JumpHostUsername {
+		return errors.New("SFTPFs jump_host_username mismatch")
+	}
+	if expected.SFTPConfig.Compression != actual.SFTPConfig.Compression {
+		return errors.New("SFTPFs compression mismatch")
+	}
 	if err := checkEncryptedSecret(expected.SFTPConfig.Password, actual.SFTPConfig.Password); err != nil {
 		return fmt.Errorf("SFTPFs password mismatch: %v", err)
 	}
@@ -2318,6 +3046,15 @@ func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.AzBlobConfig.AccessTier != actual.AzBlobConfig.AccessTier {
 		return errors.New("azure Blob access tier mismatch")
 	}
+	if expected.AzBlobConfig.EmulateSymlinks != actual.AzBlobConfig.EmulateSymlinks {
+		return errors.New("azure Blob emulate symlinks mismatch")
+	}
+	if expected.AzBlobConfig.CloudRetryPolicy != actual.AzBlobConfig.CloudRetryPolicy {
+		return errors.New("azure Blob retry policy mismatch")
+	}
+	if expected.AzBlobConfig.RotationInterval != actual.AzBlobConfig.RotationInterval {
+		return errors.New("azure Blob rotation interval mismatch")
+	}
 	return nil
 }
 
@@ -2623,12 +3360,171 @@ func compareEventActionHTTPConfigFields(expected, actual dataprovider.EventActio
 	if expected.Body != actual.Body {
 		return errors.New("http body mismatch")
 	}
+	if expected.TextTemplate != actual.TextTemplate {
+		return errors.New("http text template mismatch")
+	}
 	if len(expected.Parts) != len(actual.Parts) {
 		return errors.New("http parts mismatch")
 	}
 	return compareHTTPparts(expected.Parts, actual.Parts)
 }
 
+func compareEventActionBrokerConfigFields(expected, actual dataprovider.EventActionBrokerConfig) error {
+	if expected.Protocol != actual.Protocol {
+		return errors.New("message broker protocol mismatch")
+	}
+	if expected.Endpoint != actual.Endpoint {
+		return errors.New("message broker endpoint mismatch")
+	}
+	if expected.Topic != actual.Topic {
+		return errors.New("message broker topic mismatch")
+	}
+	if expected.RoutingKey != actual.RoutingKey {
+		return errors.New("message broker routing key mismatch")
+	}
+	if expected.Username != actual.Username {
+		return errors.New("message broker username mismatch")
+	}
+	if err := checkEncryptedSecret(expected.Password, actual.Password); err != nil {
+		return err
+	}
+	if expected.UseTLS != actual.UseTLS {
+		return errors.New("message broker use TLS mismatch")
+	}
+	if expected.SkipTLSVerify != actual.SkipTLSVerify {
+		return errors.New("message broker skip TLS verify mismatch")
+	}
+	if expected.Timeout != actual.Timeout {
+		return errors.New("message broker timeout mismatch")
+	}
+	if expected.Body != actual.Body {
+		return errors.New("message broker body mismatch")
+	}
+	return nil
+}
+
+func compareEventActionFunctionConfigFields(expected, actual dataprovider.EventActionFunctionConfig) error {
+	if expected.Provider != actual.Provider {
+		return errors.New("cloud function provider mismatch")
+	}
+	if expected.Function != actual.Function {
+		return errors.New("cloud function mismatch")
+	}
+	if expected.Region != actual.Region {
+		return errors.New("cloud function region mismatch")
+	}
+	if expected.AccessKey != actual.AccessKey {
+		return errors.New("cloud function access key mismatch")
+	}
+	if err := checkEncryptedSecret(expected.AccessSecret, actual.AccessSecret); err != nil {
+		return err
+	}
+	if expected.RoleARN != actual.RoleARN {
+		return errors.New("cloud function role ARN mismatch")
+	}
+	if expected.Endpoint != actual.Endpoint {
+		return errors.New("cloud function endpoint mismatch")
+	}
+	if err := checkEncryptedSecret(expected.Credentials, actual.Credentials); err != nil {
+		return err
+	}
+	if expected.Async != actual.Async {
+		return errors.New("cloud function async mismatch")
+	}
+	if expected.Timeout != actual.Timeout {
+		return errors.New("cloud function timeout mismatch")
+	}
+	if expected.Body != actual.Body {
+		return errors.New("cloud function body mismatch")
+	}
+	if expected.ResultErrorField != actual.ResultErrorField {
+		return errors.New("cloud function result error field mismatch")
+	}
+	return nil
+}
+
+func compareEventActionReplicationConfigFields(expected, actual dataprovider.EventActionReplication) error {
+	if len(expected.Folders) != len(actual.Folders) {
+		return errors.New("replication folders mismatch")
+	}
+	for _, folder := range expected.Folders {
+		if !util.Contains(actual.Folders, folder) {
+			return errors.New("replication folders content mismatch")
+		}
+	}
+	if expected.TargetPath != actual.TargetPath {
+		return errors.New("replication target path mismatch")
+	}
+	if expected.ConflictPolicy != actual.ConflictPolicy {
+		return errors.New("replication conflict policy mismatch")
+	}
+	if expected.MaxRetries != actual.MaxRetries {
+		return errors.New("replication max retries mismatch")
+	}
+	return nil
+}
+
+func compareEventActionThumbnailConfigFields(expected, actual dataprovider.EventActionThumbnail) error {
+	if expected.Width != actual.Width {
+		return errors.New("thumbnail width mismatch")
+	}
+	if expected.Height != actual.Height {
+		return errors.New("thumbnail height mismatch")
+	}
+	if expected.Quality != 0 && expected.Quality != actual.Quality {
+		return errors.New("thumbnail quality mismatch")
+	}
+	if expected.MaxFileSize != actual.MaxFileSize {
+		return errors.New("thumbnail max file size mismatch")
+	}
+	if expected.PDFCommand != actual.PDFCommand {
+		return errors.New("thumbnail PDF command mismatch")
+	}
+	if expected.Timeout != actual.Timeout {
+		return errors.New("thumbnail timeout mismatch")
+	}
+	return nil
+}
+
+func compareEventActionChecksumConfigFields(expected, actual dataprovider.EventActionChecksumManifest) error {
+	if expected.Mode != actual.Mode {
+		return errors.New("checksum manifest mode mismatch")
+	}
+	if expected.Algorithm != "" && expected.Algorithm != actual.Algorithm {
+		return errors.New("checksum manifest algorithm mismatch")
+	}
+	if expected.ManifestPath != actual.ManifestPath {
+		return errors.New("checksum manifest path mismatch")
+	}
+	if expected.Template != actual.Template {
+		return errors.New("checksum manifest template mismatch")
+	}
+	return nil
+}
+
+func compareEventActionPushConfigFields(expected, actual dataprovider.EventActionPush) error {
+	if expected.Endpoint != actual.Endpoint {
+		return errors.New("push endpoint mismatch")
+	}
+	if expected.TargetPath != actual.TargetPath {
+		return errors.New("push target path mismatch")
+	}
+	return nil
+}
+
+func compareEventActionLifecycleConfigFields(expected, actual dataprovider.EventActionAccountLifecycle) error {
+	if expected.ExpirationThreshold != actual.ExpirationThreshold {
+		return errors.New("lifecycle expiration threshold mismatch")
+	}
+	if expected.InactivityThreshold != actual.InactivityThreshold {
+		return errors.New("lifecycle inactivity threshold mismatch")
+	}
+	if expected.NotifyAdmins != actual.NotifyAdmins {
+		return errors.New("lifecycle notify admins mismatch")
+	}
+	return nil
+}
+
 func compareEventActionEmailConfigFields(expected, actual dataprovider.EventActionEmailConfig) error {
 	if len(expected.Recipients) != len(actual.Recipients) {
 		return errors.New("email recipients mismatch")
@@ -2644,6 +3540,9 @@ func compareEventActionEmailConfigFields(expected, actual dataprovider.EventActi
 	if expected.Body != actual.Body {
 		return errors.New("email body mismatch")
 	}
+	if expected.TextTemplate != actual.TextTemplate {
+		return errors.New("email text template mismatch")
+	}
 	if len(expected.Attachments) != len(actual.Attachments) {
 		return errors.New("email attachments mismatch")
 	}
@@ -2670,6 +3569,22 @@ func compareEventActionFsCompressFields(expected, actual dataprovider.EventActio
 	return nil
 }
 
+func compareEventActionFsPGPFields(expected, actual dataprovider.EventActionFsPGP) error {
+	if err := compareKeyValues(expected.Paths, actual.Paths); err != nil {
+		return errors.New("fs PGP paths mismatch")
+	}
+	if err := checkEncryptedSecret(expected.PublicKeys, actual.PublicKeys); err != nil {
+		return fmt.Errorf("fs PGP public keys mismatch: %w", err)
+	}
+	if err := checkEncryptedSecret(expected.PrivateKey, actual.PrivateKey); err != nil {
+		return fmt.Errorf("fs PGP private key mismatch: %w", err)
+	}
+	if err := checkEncryptedSecret(expected.Passphrase, actual.Passphrase); err != nil {
+		return fmt.Errorf("fs PGP passphrase mismatch: %w", err)
+	}
+	return nil
+}
+
 func compareEventActionFsConfigFields(expected, actual dataprovider.EventActionFilesystemConfig) error {
 	if expected.Type != actual.Type {
 		return errors.New("fs type mismatch")
@@ -2704,7 +3619,10 @@ func compareEventActionFsConfigFields(expected, actual dataprovider.EventActionF
 			return errors.New("fs exist content mismatch")
 		}
 	}
-	return compareEventActionFsCompressFields(expected.Compress, actual.Compress)
+	if err := compareEventActionFsCompressFields(expected.Compress, actual.Compress); err != nil {
+		return err
+	}
+	return compareEventActionFsPGPFields(expected.PGP, actual.PGP)
 }
 
 func compareEventActionCmdConfigFields(expected, actual dataprovider.EventActionCommandConfig) error {
@@ -2725,10 +3643,16 @@ func compareEventActionCmdConfigFields(expected, actual dataprovider.EventAction
 	if err := compareKeyValues(expected.EnvVars, actual.EnvVars); err != nil {
 		return errors.New("cmd env vars mismatch")
 	}
+	if expected.TextTemplate != actual.TextTemplate {
+		return errors.New("cmd text template mismatch")
+	}
 	return nil
 }
 
 func compareEventActionDataRetentionFields(expected, actual dataprovider.EventActionDataRetentionConfig) error {
+	if expected.DryRun != actual.DryRun {
+		return errors.New("retention dry run mismatch")
+	}
 	if len(expected.Folders) != len(actual.Folders) {
 		return errors.New("retention folders mismatch")
 	}
@@ -2746,6 +3670,9 @@ func compareEventActionDataRetentionFields(expected, actual dataprovider.EventAc
 				if f1.IgnoreUserPermissions != f2.IgnoreUserPermissions {
 					return fmt.Errorf("ignore_user_permissions mismatch for folder %s", f1.Path)
 				}
+				if f1.ArchiveFolder != f2.ArchiveFolder {
+					return fmt.Errorf("archive_folder mismatch for folder %s", f1.Path)
+				}
 				break
 			}
 		}
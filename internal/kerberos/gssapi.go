@@ -0,0 +1,136 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package kerberos
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+var tokenIDAPRep = []byte{0x02, 0x00}
+
+// getMechanism returns the mechanism of an initial context token, RFC 2743 section 3.1
+func getMechanism(token []byte) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.UnmarshalWithParams(token, &oid, "application,explicit,tag:0"); err != nil {
+		return nil, fmt.Errorf("%w: invalid initial context token: %v", ErrInvalidToken, err)
+	}
+	return oid, nil
+}
+
+// acceptKRB5Token accepts a Kerberos V5 GSS-API initial context token and
+// returns the established context and, if mutual authentication is requested,
+// the response token
+func (c *Config) acceptKRB5Token(token []byte) (*secContext, []byte, error) {
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(token); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !krb5Token.IsAPReq() {
+		return nil, nil, fmt.Errorf("%w: an AP-REQ was expected", ErrInvalidToken)
+	}
+	ctx, err := c.acceptAPReq(&krb5Token.APReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ctx.mutual {
+		return ctx, nil, nil
+	}
+	rep, err := ctx.getAPRep()
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, nil, err
+	}
+	response = append(response, tokenIDAPRep...)
+	response = append(response, rep...)
+	return ctx, asn1tools.AddASNAppTag(response, 0), nil
+}
+
+// verifyMIC verifies a MIC token, RFC 4121 section 4.2.6.1, sent by the initiator
+func (s *secContext) verifyMIC(message, token []byte) error {
+	var mic gssapi.MICToken
+	if err := mic.Unmarshal(token, false); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if mic.Flags&gssapi.MICTokenFlagAcceptorSubkey != 0 {
+		return fmt.Errorf("%w: unexpected MIC token flags %#x", ErrInvalidToken, mic.Flags)
+	}
+	mic.Payload = message
+	if _, err := mic.Verify(s.getSigningKey(), keyusage.GSSAPI_INITIATOR_SIGN); err != nil {
+		return fmt.Errorf("kerberos: MIC verification failed: %w", err)
+	}
+	return nil
+}
+
+// GSSAPIServer implements the GSS-API acceptor for the SSH gssapi-with-mic
+// authentication. It holds the state of a single security context so a new
+// instance is required for each connection
+type GSSAPIServer struct {
+	config *Config
+	ctx    *secContext
+}
+
+// NewGSSAPIServer returns a new GSS-API acceptor using the given service
+func NewGSSAPIServer(config *Config) *GSSAPIServer {
+	return &GSSAPIServer{
+		config: config,
+	}
+}
+
+// Clone returns a new acceptor, without any security context, for the same service
+func (s *GSSAPIServer) Clone() *GSSAPIServer {
+	return NewGSSAPIServer(s.config)
+}
+
+// AcceptSecContext accepts the Kerberos V5 token sent by the client and returns
+// the client principal name as srcName
+func (s *GSSAPIServer) AcceptSecContext(token []byte) ([]byte, string, bool, error) {
+	oid, err := getMechanism(token)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if !oid.Equal(gssapi.OIDKRB5.OID()) {
+		return nil, "", false, fmt.Errorf("%w: unsupported mechanism %s", ErrInvalidToken, oid)
+	}
+	ctx, response, err := s.config.acceptKRB5Token(token)
+	if err != nil {
+		return nil, "", false, err
+	}
+	s.ctx = ctx
+	return response, ctx.principal, false, nil
+}
+
+// VerifyMIC verifies the MIC sent by the client for the given message
+func (s *GSSAPIServer) VerifyMIC(micField []byte, micToken []byte) error {
+	if s.ctx == nil {
+		return errors.New("kerberos: no security context established")
+	}
+	return s.ctx.verifyMIC(micField, micToken)
+}
+
+// DeleteSecContext releases the security context
+func (s *GSSAPIServer) DeleteSecContext() error {
+	s.ctx = nil
+	return nil
+}
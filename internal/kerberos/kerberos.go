@@ -0,0 +1,227 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package kerberos implements a Kerberos V5 acceptor, based on gokrb5, to
+// authenticate users using a service keytab. GSS-API (RFC 4121) is supported
+// for SSH gssapi-with-mic and SPNEGO (RFC 4178, RFC 4559) for HTTP
+package kerberos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	defaultMaxClockSkew = 300
+)
+
+// ErrInvalidToken defines the error returned if the received token is not valid
+var ErrInvalidToken = errors.New("kerberos: invalid token")
+
+// Config defines a Kerberos service
+type Config struct {
+	// Unique name, bindings refer to the service to use by name
+	Name string `json:"name" mapstructure:"name"`
+	// Path to the service keytab. It must contain the keys for the service
+	// principals, for example host/sftp.example.com for SSH and
+	// HTTP/sftp.example.com for HTTP and WebDAV
+	Keytab string `json:"keytab" mapstructure:"keytab"`
+	// Realms allowed for the client principals. If empty only the realms
+	// of the keytab principals are allowed
+	Realms []string `json:"realms" mapstructure:"realms"`
+	// If true the realm is kept and the SFTPGo username is principal@REALM,
+	// by default the realm is removed and the username is the principal name
+	KeepRealm bool `json:"keep_realm" mapstructure:"keep_realm"`
+	// Maximum allowed clock skew as seconds, default 300
+	MaxClockSkew int `json:"max_clock_skew" mapstructure:"max_clock_skew"`
+	settings     *service.Settings
+}
+
+// Validate validates the configuration and loads the keytab
+func (c *Config) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return errors.New("kerberos: name is mandatory")
+	}
+	if c.Keytab == "" {
+		return fmt.Errorf("kerberos %q: a keytab is required", c.Name)
+	}
+	kt, err := keytab.Load(c.Keytab)
+	if err != nil {
+		return fmt.Errorf("kerberos %q: unable to load keytab %q: %w", c.Name, c.Keytab, err)
+	}
+	if len(kt.Entries) == 0 {
+		return fmt.Errorf("kerberos %q: the keytab %q has no entries", c.Name, c.Keytab)
+	}
+	if len(c.Realms) == 0 {
+		c.Realms = getKeytabRealms(kt)
+	}
+	if c.MaxClockSkew <= 0 {
+		c.MaxClockSkew = defaultMaxClockSkew
+	}
+	// the PAC is not required to map the principal to an SFTPGo user
+	c.settings = service.NewSettings(kt, service.MaxClockSkew(c.getMaxClockSkew()), service.DecodePAC(false))
+	return nil
+}
+
+// GetUsername returns the SFTPGo username for the given principal name in the
+// form name@REALM. An error is returned if the realm is not allowed
+func (c *Config) GetUsername(principal string) (string, error) {
+	idx := strings.LastIndex(principal, "@")
+	if idx <= 0 || idx == len(principal)-1 {
+		return "", fmt.Errorf("kerberos: invalid principal %q", principal)
+	}
+	name := principal[:idx]
+	realm := principal[idx+1:]
+	if !c.isRealmAllowed(realm) {
+		return "", fmt.Errorf("kerberos: realm %q is not allowed", realm)
+	}
+	if c.KeepRealm {
+		return principal, nil
+	}
+	return name, nil
+}
+
+func (c *Config) isRealmAllowed(realm string) bool {
+	for _, r := range c.Realms {
+		if strings.EqualFold(r, realm) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) getMaxClockSkew() time.Duration {
+	return time.Duration(c.MaxClockSkew) * time.Second
+}
+
+// secContext is an established security context
+type secContext struct {
+	principal  string
+	sessionKey types.EncryptionKey
+	subKey     types.EncryptionKey
+	ctime      time.Time
+	cusec      int
+	mutual     bool
+}
+
+// acceptAPReq validates the given AP-REQ, the ticket, the authenticator and the
+// replay cache are checked by gokrb5, and returns the established context
+func (c *Config) acceptAPReq(req *messages.APReq) (*secContext, error) {
+	if c.settings == nil {
+		return nil, errors.New("kerberos: the service is not initialized")
+	}
+	ok, creds, err := service.VerifyAPREQ(req, c.settings)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos: unable to verify AP-REQ: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: AP-REQ not valid", ErrInvalidToken)
+	}
+	if !c.isRealmAllowed(creds.Domain()) {
+		return nil, fmt.Errorf("kerberos: realm %q is not allowed", creds.Domain())
+	}
+	auth := req.Authenticator
+	ctx := &secContext{
+		principal:  creds.CName().PrincipalNameString() + "@" + creds.Domain(),
+		sessionKey: req.Ticket.DecryptedEncPart.Key,
+		subKey:     auth.SubKey,
+		ctime:      auth.CTime,
+		cusec:      auth.Cusec,
+		mutual:     types.IsFlagSet(&req.APOptions, flags.APOptionMutualRequired),
+	}
+	// GSS-API checksum, RFC 4121 section 4.1.1
+	if auth.Cksum.CksumType == chksumtype.GSSAPI && len(auth.Cksum.Checksum) >= 24 {
+		if binary.LittleEndian.Uint32(auth.Cksum.Checksum[20:24])&gssapi.ContextFlagMutual != 0 {
+			ctx.mutual = true
+		}
+	}
+	return ctx, nil
+}
+
+type encAPRepPart struct {
+	CTime time.Time `asn1:"generalized,explicit,tag:0"`
+	Cusec int       `asn1:"explicit,tag:1"`
+}
+
+// getAPRep returns the AP-REP message for mutual authentication, gokrb5 only
+// supports the AP-REP on the initiator side
+func (s *secContext) getAPRep() ([]byte, error) {
+	encPart, err := asn1.Marshal(encAPRepPart{
+		CTime: s.ctime,
+		Cusec: s.cusec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(encPart, asnAppTag.EncAPRepPart),
+		s.sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := asn1.Marshal(messages.APRep{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1tools.AddASNAppTag(rep, asnAppTag.APREP), nil
+}
+
+// getSigningKey returns the key to use for per-message tokens, since we never
+// send an acceptor subkey this is the initiator subkey, if any, or the
+// session key
+func (s *secContext) getSigningKey() types.EncryptionKey {
+	if len(s.subKey.KeyValue) > 0 {
+		return s.subKey
+	}
+	return s.sessionKey
+}
+
+func getKeytabRealms(kt *keytab.Keytab) []string {
+	var realms []string
+	for _, e := range kt.Entries {
+		found := false
+		for _, r := range realms {
+			if strings.EqualFold(r, e.Principal.Realm) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			realms = append(realms, e.Principal.Realm)
+		}
+	}
+	return realms
+}
@@ -0,0 +1,408 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package kerberos
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/http.keytab is a keytab generated using MIT Kerberos ktutil, it is
+// the one used in the gokrb5 test suite. It contains the AES128 and AES256 keys,
+// versions 1 and 2, for HTTP/host.test.gokrb5@TEST.GOKRB5
+const (
+	testKeytab  = "testdata/http.keytab"
+	testRealm   = "TEST.GOKRB5"
+	testService = "HTTP/host.test.gokrb5"
+)
+
+func TestValidate(t *testing.T) {
+	c := Config{}
+	assert.Error(t, c.Validate())
+	c.Name = "krb"
+	assert.Error(t, c.Validate())
+	c.Keytab = filepath.Join(os.TempDir(), "missing.keytab")
+	assert.Error(t, c.Validate())
+	keytabPath := filepath.Join(t.TempDir(), "test.keytab")
+	err := os.WriteFile(keytabPath, []byte{0x05, 0x02, 0x00}, 0600)
+	require.NoError(t, err)
+	c.Keytab = keytabPath
+	assert.Error(t, c.Validate())
+	// a keytab with a single zero length record
+	err = os.WriteFile(keytabPath, []byte{0x05, 0x02, 0x00, 0x00, 0x00, 0x00}, 0600)
+	require.NoError(t, err)
+	err = c.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no entries")
+	}
+	c.Keytab = testKeytab
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, []string{testRealm}, c.Realms)
+	assert.Equal(t, defaultMaxClockSkew, c.MaxClockSkew)
+	assert.Len(t, c.settings.Keytab.Entries, 4)
+
+	username, err := c.GetUsername("alice@" + testRealm)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	username, err = c.GetUsername("alice@test.gokrb5")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	_, err = c.GetUsername("alice@OTHER.COM")
+	assert.Error(t, err)
+	_, err = c.GetUsername("alice")
+	assert.Error(t, err)
+	_, err = c.GetUsername("alice@")
+	assert.Error(t, err)
+	c.KeepRealm = true
+	username, err = c.GetUsername("alice@" + testRealm)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@"+testRealm, username)
+}
+
+func TestGSSAPIServer(t *testing.T) {
+	c := getTestConfig(t)
+	for _, etype := range []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96} {
+		s := NewGSSAPIServer(c)
+		token, sessionKey, subKey := buildGSSToken(t, c, etype, authenticatorOptions{
+			subKey: true,
+			mutual: true,
+		})
+		response, srcName, needContinue, err := s.AcceptSecContext(token)
+		require.NoError(t, err)
+		assert.False(t, needContinue)
+		assert.Equal(t, "alice@"+testRealm, srcName)
+		checkAPRep(t, response, sessionKey)
+
+		message := []byte("message to sign")
+		assert.NoError(t, s.VerifyMIC(message, buildMIC(t, subKey, message, 0)))
+		assert.Error(t, s.VerifyMIC([]byte("other message"), buildMIC(t, subKey, message, 0)))
+		// the session key must not be used if a subkey is provided
+		assert.Error(t, s.VerifyMIC(message, buildMIC(t, sessionKey, message, 0)))
+		assert.ErrorIs(t, s.VerifyMIC(message, buildMIC(t, subKey, message, gssapi.MICTokenFlagSentByAcceptor)),
+			ErrInvalidToken)
+		assert.ErrorIs(t, s.VerifyMIC(message, buildMIC(t, subKey, message, gssapi.MICTokenFlagAcceptorSubkey)),
+			ErrInvalidToken)
+		mic := buildMIC(t, subKey, message, 0)
+		mic[0] = 0x05
+		assert.ErrorIs(t, s.VerifyMIC(message, mic), ErrInvalidToken)
+		mic = buildMIC(t, subKey, message, 0)
+		mic[4] = 0x00
+		assert.ErrorIs(t, s.VerifyMIC(message, mic), ErrInvalidToken)
+		assert.ErrorIs(t, s.VerifyMIC(message, mic[:10]), ErrInvalidToken)
+		assert.NoError(t, s.DeleteSecContext())
+		assert.Error(t, s.VerifyMIC(message, buildMIC(t, subKey, message, 0)))
+
+		// without subkey and mutual authentication
+		s = s.Clone()
+		assert.Nil(t, s.ctx)
+		token, sessionKey, _ = buildGSSToken(t, c, etype, authenticatorOptions{})
+		response, _, _, err = s.AcceptSecContext(token)
+		require.NoError(t, err)
+		assert.Nil(t, response)
+		assert.NoError(t, s.VerifyMIC(message, buildMIC(t, sessionKey, message, 0)))
+		// the same token cannot be replayed
+		_, _, _, err = s.AcceptSecContext(token)
+		assert.Error(t, err)
+	}
+}
+
+func TestAcceptErrors(t *testing.T) {
+	c := getTestConfig(t)
+	s := NewGSSAPIServer(c)
+
+	_, _, _, err := s.AcceptSecContext([]byte("invalid"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, _, err = s.AcceptSecContext(buildInitialContextToken(t, gssapi.OIDSPNEGO.OID(), []byte{0x01, 0x00}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, _, err = s.AcceptSecContext(buildInitialContextToken(t, gssapi.OIDKRB5.OID(), []byte{0x01}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, _, err = s.AcceptSecContext(buildInitialContextToken(t, gssapi.OIDKRB5.OID(), []byte{0x01, 0x00, 0x01}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	// a KRB-ERROR token
+	_, _, _, err = s.AcceptSecContext(buildInitialContextToken(t, gssapi.OIDKRB5.OID(), []byte{0x03, 0x00}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	token, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 1, IsCompound: true})
+	require.NoError(t, err)
+	_, _, _, err = s.AcceptSecContext(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	tests := []struct {
+		name string
+		opts authenticatorOptions
+	}{
+		{name: "wrong service", opts: authenticatorOptions{service: "host/host.test.gokrb5"}},
+		{name: "wrong key", opts: authenticatorOptions{wrongServiceKey: true}},
+		{name: "wrong key version", opts: authenticatorOptions{kvno: 3}},
+		{name: "expired ticket", opts: authenticatorOptions{endTime: time.Now().Add(-time.Hour)}},
+		{name: "future ticket", opts: authenticatorOptions{startTime: time.Now().Add(time.Hour)}},
+		{name: "clock skew", opts: authenticatorOptions{ctime: time.Now().Add(-time.Hour)}},
+		{name: "client mismatch", opts: authenticatorOptions{authenticatorClient: "bob"}},
+		{name: "realm not allowed", opts: authenticatorOptions{clientRealm: "OTHER.COM"}},
+	}
+	for _, tc := range tests {
+		token, _, _ := buildGSSToken(t, c, etypeID.AES128_CTS_HMAC_SHA1_96, tc.opts)
+		_, _, _, err := s.AcceptSecContext(token)
+		assert.Error(t, err, tc.name)
+	}
+	// the previous key version is still accepted
+	token, _, _ = buildGSSToken(t, c, etypeID.AES128_CTS_HMAC_SHA1_96, authenticatorOptions{kvno: 1})
+	_, _, _, err = s.AcceptSecContext(token)
+	assert.NoError(t, err)
+	// allowed realms
+	c.Realms = []string{"OTHER.COM"}
+	token, _, _ = buildGSSToken(t, c, etypeID.AES128_CTS_HMAC_SHA1_96, authenticatorOptions{clientRealm: "OTHER.COM"})
+	_, srcName, _, err := s.AcceptSecContext(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@OTHER.COM", srcName)
+	token, _, _ = buildGSSToken(t, c, etypeID.AES128_CTS_HMAC_SHA1_96, authenticatorOptions{})
+	_, _, _, err = s.AcceptSecContext(token)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not allowed")
+	}
+
+	token, _, _ = buildGSSToken(t, c, etypeID.AES128_CTS_HMAC_SHA1_96, authenticatorOptions{})
+	c.settings = nil
+	_, _, _, err = s.AcceptSecContext(token)
+	assert.Error(t, err)
+}
+
+func TestSPNEGO(t *testing.T) {
+	c := getTestConfig(t)
+
+	for _, mech := range []gssapi.OIDName{gssapi.OIDMSLegacyKRB5, gssapi.OIDKRB5} {
+		krb5Token, sessionKey, _ := buildGSSToken(t, c, etypeID.AES256_CTS_HMAC_SHA1_96,
+			authenticatorOptions{mutual: true})
+		principal, response, err := c.AcceptSPNEGO(buildSPNEGOToken(t, mech.OID(), krb5Token))
+		require.NoError(t, err)
+		assert.Equal(t, "alice@"+testRealm, principal)
+		var resp spnego.NegTokenResp
+		err = resp.Unmarshal(response)
+		require.NoError(t, err)
+		assert.Equal(t, spnego.NegStateAcceptCompleted, resp.State())
+		assert.True(t, resp.SupportedMech.Equal(mech.OID()))
+		checkAPRep(t, resp.ResponseToken, sessionKey)
+	}
+	// raw Kerberos token
+	krb5Token, _, _ := buildGSSToken(t, c, etypeID.AES256_CTS_HMAC_SHA1_96, authenticatorOptions{})
+	principal, response, err := c.AcceptSPNEGO(krb5Token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@"+testRealm, principal)
+	assert.Nil(t, response)
+	// replayed token
+	_, _, err = c.AcceptSPNEGO(krb5Token)
+	assert.Error(t, err)
+
+	_, _, err = c.AcceptSPNEGO([]byte("invalid"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	// NTLM is not supported
+	krb5Token, _, _ = buildGSSToken(t, c, etypeID.AES256_CTS_HMAC_SHA1_96, authenticatorOptions{})
+	_, _, err = c.AcceptSPNEGO(buildSPNEGOToken(t, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}, krb5Token))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, err = c.AcceptSPNEGO(buildSPNEGOToken(t, gssapi.OIDKRB5.OID(), nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, err = c.AcceptSPNEGO(buildSPNEGOToken(t, gssapi.OIDKRB5.OID(), []byte("invalid")))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, err = c.AcceptSPNEGO(buildInitialContextToken(t, gssapi.OIDSPNEGO.OID(), []byte{0x30, 0x00}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, _, err = c.AcceptSPNEGO(buildInitialContextToken(t, asn1.ObjectIdentifier{1, 2, 3}, nil))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	// a NegTokenResp is not expected
+	negTokenResp := spnego.NegTokenResp{
+		NegState:      asn1.Enumerated(spnego.NegStateAcceptIncomplete),
+		SupportedMech: gssapi.OIDKRB5.OID(),
+	}
+	respBytes, err := negTokenResp.Marshal()
+	require.NoError(t, err)
+	_, _, err = c.AcceptSPNEGO(buildInitialContextToken(t, gssapi.OIDSPNEGO.OID(), respBytes))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	krb5Token, _, _ = buildGSSToken(t, c, etypeID.AES256_CTS_HMAC_SHA1_96, authenticatorOptions{wrongServiceKey: true})
+	_, _, err = c.AcceptSPNEGO(buildSPNEGOToken(t, gssapi.OIDKRB5.OID(), krb5Token))
+	assert.Error(t, err)
+}
+
+func TestNegotiateHeader(t *testing.T) {
+	token, ok, err := GetNegotiateToken("Basic dXNlcjpwYXNz")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, token)
+	token, ok, err = GetNegotiateToken("negotiate " + base64.StdEncoding.EncodeToString([]byte("token")))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("token"), token)
+	_, ok, err = GetNegotiateToken("Negotiate")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, ok, err = GetNegotiateToken("Negotiate invalid base64")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	assert.Equal(t, "Negotiate", GetNegotiateHeader(nil))
+	assert.Equal(t, "Negotiate "+base64.StdEncoding.EncodeToString([]byte("response")),
+		GetNegotiateHeader([]byte("response")))
+}
+
+func getTestConfig(t *testing.T) *Config {
+	c := &Config{
+		Name:   "test",
+		Keytab: testKeytab,
+	}
+	require.NoError(t, c.Validate())
+	return c
+}
+
+type authenticatorOptions struct {
+	service             string
+	kvno                int
+	wrongServiceKey     bool
+	clientRealm         string
+	authenticatorClient string
+	startTime           time.Time
+	endTime             time.Time
+	ctime               time.Time
+	subKey              bool
+	mutual              bool
+}
+
+// buildGSSToken acts as the KDC and the client and returns an initial context
+// token for the test service, the session key and the initiator subkey, if any
+func buildGSSToken(t *testing.T, c *Config, etype int32, opts authenticatorOptions,
+) ([]byte, types.EncryptionKey, types.EncryptionKey) {
+	if opts.service == "" {
+		opts.service = testService
+	}
+	if opts.kvno == 0 {
+		opts.kvno = 2
+	}
+	if opts.clientRealm == "" {
+		opts.clientRealm = testRealm
+	}
+	if opts.authenticatorClient == "" {
+		opts.authenticatorClient = "alice"
+	}
+	if opts.startTime.IsZero() {
+		opts.startTime = time.Now()
+	}
+	if opts.endTime.IsZero() {
+		opts.endTime = time.Now().Add(10 * time.Hour)
+	}
+	kt := c.settings.Keytab
+	if opts.wrongServiceKey || opts.kvno > 2 {
+		kt = keytab.New()
+		err := kt.AddEntry(testService, testRealm, "wrong password", time.Now(), uint8(opts.kvno), etype)
+		require.NoError(t, err)
+	}
+	ticketKt := kt
+	if opts.service != testService {
+		// the ticket is issued for a service that is not in the keytab
+		ticketKt = keytab.New()
+		err := ticketKt.AddEntry(opts.service, testRealm, "password", time.Now(), uint8(opts.kvno), etype)
+		require.NoError(t, err)
+	}
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "alice")
+	tkt, sessionKey, err := messages.NewTicket(cname, opts.clientRealm,
+		types.NewPrincipalName(nametype.KRB_NT_SRV_INST, opts.service), testRealm, types.NewKrbFlags(), ticketKt,
+		etype, opts.kvno, time.Now(), opts.startTime, opts.endTime, opts.endTime)
+	require.NoError(t, err)
+
+	auth, err := types.NewAuthenticator(opts.clientRealm, types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL,
+		opts.authenticatorClient))
+	require.NoError(t, err)
+	if !opts.ctime.IsZero() {
+		auth.CTime = opts.ctime.UTC()
+	}
+	// GSS-API checksum, RFC 4121 section 4.1.1
+	cksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(cksum, 16)
+	if opts.mutual {
+		binary.LittleEndian.PutUint32(cksum[20:], gssapi.ContextFlagMutual)
+	}
+	auth.Cksum = types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: cksum}
+	if opts.subKey {
+		err = auth.GenerateSeqNumberAndSubKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32)
+		require.NoError(t, err)
+	}
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	require.NoError(t, err)
+	req, err := apReq.Marshal()
+	require.NoError(t, err)
+	return buildInitialContextToken(t, gssapi.OIDKRB5.OID(), append([]byte{0x01, 0x00}, req...)), sessionKey,
+		auth.SubKey
+}
+
+func buildInitialContextToken(t *testing.T, oid asn1.ObjectIdentifier, inner []byte) []byte {
+	token, err := asn1.Marshal(oid)
+	require.NoError(t, err)
+	return asn1tools.AddASNAppTag(append(token, inner...), 0)
+}
+
+func buildSPNEGOToken(t *testing.T, mech asn1.ObjectIdentifier, mechToken []byte) []byte {
+	token := spnego.SPNEGOToken{
+		Init: true,
+		NegTokenInit: spnego.NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{mech, gssapi.OIDKRB5.OID()},
+			MechTokenBytes: mechToken,
+		},
+	}
+	b, err := token.Marshal()
+	require.NoError(t, err)
+	return b
+}
+
+func buildMIC(t *testing.T, key types.EncryptionKey, message []byte, flags byte) []byte {
+	mic := gssapi.MICToken{
+		Flags:     flags,
+		SndSeqNum: 1,
+		Payload:   message,
+	}
+	err := mic.SetChecksum(key, keyusage.GSSAPI_INITIATOR_SIGN)
+	require.NoError(t, err)
+	b, err := mic.Marshal()
+	require.NoError(t, err)
+	return b
+}
+
+// checkAPRep parses the AP-REP token, as the initiator does, and decrypts it
+func checkAPRep(t *testing.T, token []byte, sessionKey types.EncryptionKey) {
+	var krb5Token spnego.KRB5Token
+	err := krb5Token.Unmarshal(token)
+	require.NoError(t, err)
+	require.True(t, krb5Token.IsAPRep())
+	plaintext, err := crypto.DecryptEncPart(krb5Token.APRep.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	require.NoError(t, err)
+	var encPart messages.EncAPRepPart
+	err = encPart.Unmarshal(plaintext)
+	require.NoError(t, err)
+	assert.False(t, encPart.CTime.IsZero())
+	assert.WithinDuration(t, time.Now(), encPart.CTime, time.Minute)
+}
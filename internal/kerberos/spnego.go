@@ -0,0 +1,107 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package kerberos
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// NegotiateScheme is the HTTP authentication scheme for SPNEGO
+const NegotiateScheme = "Negotiate"
+
+// GetNegotiateToken returns the token from the given HTTP Authorization header
+// value. It returns false if the header does not use the Negotiate scheme
+func GetNegotiateToken(header string) ([]byte, bool, error) {
+	scheme, value, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, NegotiateScheme) {
+		return nil, false, nil
+	}
+	token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: unable to decode the Negotiate token: %v", ErrInvalidToken, err)
+	}
+	if len(token) == 0 {
+		return nil, true, fmt.Errorf("%w: empty Negotiate token", ErrInvalidToken)
+	}
+	return token, true, nil
+}
+
+// GetNegotiateHeader returns the WWW-Authenticate header value for the given
+// response token
+func GetNegotiateHeader(token []byte) string {
+	if len(token) == 0 {
+		return NegotiateScheme
+	}
+	return NegotiateScheme + " " + base64.StdEncoding.EncodeToString(token)
+}
+
+// AcceptSPNEGO accepts the token received in an HTTP Negotiate authorization
+// header, RFC 4559. Both SPNEGO tokens with an optimistic Kerberos V5 token and
+// raw Kerberos V5 tokens are supported. It returns the client principal name
+// and the token to send back to the client, if any
+func (c *Config) AcceptSPNEGO(token []byte) (string, []byte, error) {
+	oid, err := getMechanism(token)
+	if err != nil {
+		return "", nil, err
+	}
+	if oid.Equal(gssapi.OIDKRB5.OID()) {
+		ctx, response, err := c.acceptKRB5Token(token)
+		if err != nil {
+			return "", nil, err
+		}
+		return ctx.principal, response, nil
+	}
+	if !oid.Equal(gssapi.OIDSPNEGO.OID()) {
+		return "", nil, fmt.Errorf("%w: unsupported mechanism %s", ErrInvalidToken, oid)
+	}
+	var spnegoToken spnego.SPNEGOToken
+	if err := spnegoToken.Unmarshal(token); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !spnegoToken.Init {
+		return "", nil, fmt.Errorf("%w: a NegTokenInit was expected", ErrInvalidToken)
+	}
+	init := spnegoToken.NegTokenInit
+	// only the optimistic token for the preferred mechanism is supported,
+	// there is no mechanism negotiation
+	if len(init.MechTypes) == 0 || len(init.MechTokenBytes) == 0 {
+		return "", nil, fmt.Errorf("%w: no mechanism token", ErrInvalidToken)
+	}
+	mech := init.MechTypes[0]
+	if !mech.Equal(gssapi.OIDKRB5.OID()) && !mech.Equal(gssapi.OIDMSLegacyKRB5.OID()) {
+		return "", nil, fmt.Errorf("%w: unsupported preferred mechanism %s, only Kerberos is supported",
+			ErrInvalidToken, mech)
+	}
+	ctx, response, err := c.acceptKRB5Token(init.MechTokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	resp := spnego.NegTokenResp{
+		NegState:      asn1.Enumerated(spnego.NegStateAcceptCompleted),
+		SupportedMech: mech,
+		ResponseToken: response,
+	}
+	b, err := resp.Marshal()
+	if err != nil {
+		return "", nil, err
+	}
+	return ctx.principal, b, nil
+}
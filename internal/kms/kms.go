@@ -18,6 +18,7 @@ package kms
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -58,7 +59,43 @@ type Secrets struct {
 	URL             string `json:"url" mapstructure:"url"`
 	MasterKeyPath   string `json:"master_key_path" mapstructure:"master_key_path"`
 	MasterKeyString string `json:"master_key" mapstructure:"master_key"`
-	masterKey       string
+	// PKCS11 defines the configuration to unwrap the master key using a PKCS#11 token
+	PKCS11 PKCS11Config `json:"pkcs11" mapstructure:"pkcs11"`
+	// Vault defines the configuration for the HashiCorp Vault transit secrets engine
+	Vault     VaultConfig `json:"vault" mapstructure:"vault"`
+	masterKey string
+}
+
+// PKCS11Config defines the configuration for a PKCS#11 token (HSM) used to
+// protect the master key. If enabled, the configured master key must be
+// wrapped (encrypted) using the AES key stored inside the token
+type PKCS11Config struct {
+	// Absolute path to the PKCS#11 module (shared library) to load
+	ModulePath string `json:"module_path" mapstructure:"module_path"`
+	// Label of the token containing the wrapping key
+	TokenLabel string `json:"token_label" mapstructure:"token_label"`
+	// User PIN for the token
+	Pin string `json:"pin" mapstructure:"pin"`
+	// Label of the AES secret key used to wrap the master key
+	KeyLabel string `json:"key_label" mapstructure:"key_label"`
+}
+
+// IsEnabled returns true if the master key is protected using a PKCS#11 token
+func (c *PKCS11Config) IsEnabled() bool {
+	return c.ModulePath != ""
+}
+
+func (c *PKCS11Config) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.TokenLabel == "" {
+		return errors.New("pkcs11: token label is required")
+	}
+	if c.KeyLabel == "" {
+		return errors.New("pkcs11: key label is required")
+	}
+	return nil
 }
 
 type registeredSecretProvider struct {
@@ -88,6 +125,12 @@ func RegisterSecretProvider(scheme string, encryptedStatus sdkkms.SecretStatus,
 	}
 }
 
+// SecretRewrapper defines the interface for the secret providers that can
+// re-encrypt secrets using the latest key version without exposing the plaintext
+type SecretRewrapper interface {
+	Rewrap() (bool, error)
+}
+
 // NewSecret builds a new Secret using the provided arguments
 func NewSecret(status sdkkms.SecretStatus, payload, key, data string) *Secret {
 	return config.newSecret(status, payload, key, data)
@@ -105,15 +148,40 @@ func NewPlainSecret(payload string) *Secret {
 
 // Initialize configures the KMS support
 func (c *Configuration) Initialize() error {
-	if c.Secrets.MasterKeyString != "" {
-		c.Secrets.masterKey = c.Secrets.MasterKeyString
-	}
-	if c.Secrets.masterKey == "" && c.Secrets.MasterKeyPath != "" {
+	masterKey := c.Secrets.MasterKeyString
+	if masterKey == "" && c.Secrets.MasterKeyPath != "" {
 		mKey, err := os.ReadFile(c.Secrets.MasterKeyPath)
 		if err != nil {
 			return err
 		}
-		c.Secrets.masterKey = strings.TrimSpace(string(mKey))
+		masterKey = strings.TrimSpace(string(mKey))
+	}
+	if c.Secrets.PKCS11.IsEnabled() {
+		if err := c.Secrets.PKCS11.validate(); err != nil {
+			return err
+		}
+		if masterKey == "" {
+			return errors.New("pkcs11: a wrapped master key is required")
+		}
+		mKey, err := unwrapMasterKey(c.Secrets.PKCS11, masterKey)
+		if err != nil {
+			return fmt.Errorf("pkcs11: unable to unwrap the master key: %w", err)
+		}
+		masterKey = mKey
+		logger.Info(logSender, "", "master key unwrapped using the PKCS#11 token %q", c.Secrets.PKCS11.TokenLabel)
+	}
+	c.Secrets.masterKey = masterKey
+	if err := c.Secrets.Vault.validate(); err != nil {
+		return err
+	}
+	vault = nil
+	if c.Secrets.Vault.IsEnabled() {
+		client, err := newVaultClient(c.Secrets.Vault)
+		if err != nil {
+			return err
+		}
+		vault = client
+		logger.Info(logSender, "", "Vault transit secrets engine configured, address: %q", c.Secrets.Vault.Address)
 	}
 	config = *c
 	if config.Secrets.URL == "" {
@@ -422,6 +490,21 @@ func (s *Secret) TryDecrypt() error {
 	return nil
 }
 
+// Rewrap re-encrypts an encrypted Secret object using the latest key version,
+// if supported by the provider. It returns true if the secret was updated
+func (s *Secret) Rewrap() (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.provider.IsEncrypted() {
+		return false, nil
+	}
+	if rewrapper, ok := s.provider.(SecretRewrapper); ok {
+		return rewrapper.Rewrap()
+	}
+	return false, nil
+}
+
 func isSecretStatusValid(status string) bool {
 	for idx := range validSecretStatuses {
 		if validSecretStatuses[idx] == status {
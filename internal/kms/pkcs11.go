@@ -0,0 +1,189 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nopkcs11 && cgo
+// +build !nopkcs11,cgo
+
+package kms
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	pkcs11GCMIVSize      = 12
+	pkcs11GCMTagBits     = 128
+	pkcs11GCMMinSize     = pkcs11GCMIVSize + pkcs11GCMTagBits/8
+	pkcs11AdditionalData = "sftpgo master key"
+)
+
+func init() {
+	version.AddFeature("+pkcs11")
+}
+
+// WrapMasterKey encrypts the given master key using the AES key stored inside
+// the configured PKCS#11 token. The returned value is base64 encoded and can be
+// used as master key, or stored inside the master key file, if the PKCS#11
+// support is enabled
+func WrapMasterKey(c PKCS11Config, masterKey string) (string, error) {
+	if !c.IsEnabled() {
+		return "", errors.New("pkcs11: module path is required")
+	}
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	if masterKey == "" {
+		return "", errors.New("pkcs11: the master key cannot be empty")
+	}
+	var result string
+	err := c.withKey(func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		iv := make([]byte, pkcs11GCMIVSize)
+		if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+			return err
+		}
+		params := pkcs11.NewGCMParams(iv, []byte(pkcs11AdditionalData), pkcs11GCMTagBits)
+		defer params.Free()
+
+		mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := ctx.EncryptInit(session, mechanism, key); err != nil {
+			return fmt.Errorf("unable to initialize encryption: %w", err)
+		}
+		ciphertext, err := ctx.Encrypt(session, []byte(masterKey))
+		if err != nil {
+			return fmt.Errorf("unable to encrypt: %w", err)
+		}
+		// some HSMs ignore the provided IV and generate their own
+		if usedIV := params.IV(); len(usedIV) == pkcs11GCMIVSize {
+			iv = usedIV
+		}
+		result = base64.StdEncoding.EncodeToString(append(iv, ciphertext...))
+		return nil
+	})
+	return result, err
+}
+
+func unwrapMasterKey(c PKCS11Config, wrappedKey string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid wrapped master key: %w", err)
+	}
+	if len(data) <= pkcs11GCMMinSize {
+		return "", errMalformedCiphertext
+	}
+	var result string
+	err = c.withKey(func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		params := pkcs11.NewGCMParams(data[:pkcs11GCMIVSize], []byte(pkcs11AdditionalData), pkcs11GCMTagBits)
+		defer params.Free()
+
+		mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := ctx.DecryptInit(session, mechanism, key); err != nil {
+			return fmt.Errorf("unable to initialize decryption: %w", err)
+		}
+		plaintext, err := ctx.Decrypt(session, data[pkcs11GCMIVSize:])
+		if err != nil {
+			return fmt.Errorf("unable to decrypt: %w", err)
+		}
+		result = string(plaintext)
+		return nil
+	})
+	return result, err
+}
+
+// withKey loads the PKCS#11 module, opens a session with the configured token
+// and executes the given function using the configured wrapping key
+func (c *PKCS11Config) withKey(fn func(*pkcs11.Ctx, pkcs11.SessionHandle, pkcs11.ObjectHandle) error) error {
+	ctx := pkcs11.New(c.ModulePath)
+	if ctx == nil {
+		return fmt.Errorf("unable to load the PKCS#11 module %q", c.ModulePath)
+	}
+	defer ctx.Destroy()
+
+	if err := ctx.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize the PKCS#11 module %q: %w", c.ModulePath, err)
+	}
+	defer ctx.Finalize() //nolint:errcheck
+
+	slot, err := c.findSlot(ctx)
+	if err != nil {
+		return err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("unable to open a session with token %q: %w", c.TokenLabel, err)
+	}
+	defer ctx.CloseSession(session) //nolint:errcheck
+
+	if c.Pin != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, c.Pin); err != nil {
+			var p11Err pkcs11.Error
+			if !errors.As(err, &p11Err) || p11Err != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+				return fmt.Errorf("unable to login to token %q: %w", c.TokenLabel, err)
+			}
+		}
+		defer ctx.Logout(session) //nolint:errcheck
+	}
+	key, err := c.findKey(ctx, session)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, session, key)
+}
+
+func (c *PKCS11Config) findSlot(ctx *pkcs11.Ctx) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(info.Label) == c.TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no PKCS#11 token found with label %q", c.TokenLabel)
+}
+
+func (c *PKCS11Config) findKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, c.KeyLabel),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("unable to search for key %q: %w", c.KeyLabel, err)
+	}
+	objects, _, err := ctx.FindObjects(session, 1)
+	if errFinal := ctx.FindObjectsFinal(session); err == nil {
+		err = errFinal
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to search for key %q: %w", c.KeyLabel, err)
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("no AES key found with label %q in token %q", c.KeyLabel, c.TokenLabel)
+	}
+	return objects[0], nil
+}
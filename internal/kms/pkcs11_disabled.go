@@ -0,0 +1,39 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build nopkcs11 || !cgo
+// +build nopkcs11 !cgo
+
+package kms
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+var errPKCS11Disabled = errors.New("PKCS#11 support disabled at build time")
+
+func init() {
+	version.AddFeature("-pkcs11")
+}
+
+// WrapMasterKey returns an error, PKCS#11 support is disabled
+func WrapMasterKey(_ PKCS11Config, _ string) (string, error) {
+	return "", errPKCS11Disabled
+}
+
+func unwrapMasterKey(_ PKCS11Config, _ string) (string, error) {
+	return "", errPKCS11Disabled
+}
@@ -0,0 +1,408 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sdkkms "github.com/sftpgo/sdk/kms"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	vaultDefaultTransitMountPath = "transit"
+	vaultDefaultAppRoleMountPath = "approle"
+)
+
+var (
+	errVaultNotConfigured = errors.New("vault: the transit secrets engine is not configured")
+	vault                 *vaultClient
+)
+
+func init() {
+	RegisterSecretProvider(sdkkms.SchemeVaultTransit, sdkkms.SecretStatusVaultTransit, newVaultSecret)
+}
+
+// VaultConfig defines the configuration for the HashiCorp Vault transit secrets engine.
+// You have to configure a token or the AppRole credentials
+type VaultConfig struct {
+	// Vault server address, for example https://vault.example.com:8200.
+	// Leave empty to disable the built-in Vault support
+	Address string `json:"address" mapstructure:"address"`
+	// Vault namespace, Vault Enterprise only
+	Namespace string `json:"namespace" mapstructure:"namespace"`
+	// Mount path for the transit secrets engine. Default: "transit"
+	MountPath string `json:"mount_path" mapstructure:"mount_path"`
+	// Token to use for authentication. If the token is renewable it will be
+	// automatically renewed before its expiration
+	Token string `json:"token" mapstructure:"token"`
+	// AppRole credentials, they are used if no token is provided
+	AppRole VaultAppRoleConfig `json:"approle" mapstructure:"approle"`
+}
+
+// VaultAppRoleConfig defines the credentials for the Vault AppRole auth method
+type VaultAppRoleConfig struct {
+	RoleID   string `json:"role_id" mapstructure:"role_id"`
+	SecretID string `json:"secret_id" mapstructure:"secret_id"`
+	// Mount path for the AppRole auth method. Default: "approle"
+	MountPath string `json:"mount_path" mapstructure:"mount_path"`
+}
+
+// IsEnabled returns true if the built-in Vault support is enabled
+func (c *VaultConfig) IsEnabled() bool {
+	return c.Address != ""
+}
+
+func (c *VaultConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if !strings.HasPrefix(c.Address, "http://") && !strings.HasPrefix(c.Address, "https://") {
+		return fmt.Errorf("vault: invalid address %q", c.Address)
+	}
+	c.Address = strings.TrimRight(c.Address, "/")
+	if c.MountPath == "" {
+		c.MountPath = vaultDefaultTransitMountPath
+	}
+	c.MountPath = strings.Trim(c.MountPath, "/")
+	if c.Token == "" {
+		if c.AppRole.RoleID == "" || c.AppRole.SecretID == "" {
+			return errors.New("vault: a token or the AppRole credentials are required")
+		}
+		if c.AppRole.MountPath == "" {
+			c.AppRole.MountPath = vaultDefaultAppRoleMountPath
+		}
+		c.AppRole.MountPath = strings.Trim(c.AppRole.MountPath, "/")
+	}
+	return nil
+}
+
+type vaultAuthResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+type vaultDataResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+		TTL        int64  `json:"ttl"`
+		Renewable  bool   `json:"renewable"`
+	} `json:"data"`
+}
+
+type vaultErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+type vaultClient struct {
+	sync.Mutex
+	config     VaultConfig
+	token      string
+	renewable  bool
+	ttl        time.Duration
+	expiration time.Time
+}
+
+func newVaultClient(config VaultConfig) (*vaultClient, error) {
+	c := &vaultClient{
+		config: config,
+	}
+	if config.Token != "" {
+		if err := c.lookupToken(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := c.login(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// getToken returns a valid token, the token is renewed, or a new one is
+// requested using the AppRole credentials, if it is about to expire
+func (c *vaultClient) getToken() (string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.expiration.IsZero() || time.Until(c.expiration) > c.ttl/3 {
+		return c.token, nil
+	}
+	if c.renewable {
+		err := c.renewToken()
+		if err == nil {
+			return c.token, nil
+		}
+		logger.Warn(logSender, "", "unable to renew the Vault token: %v", err)
+	}
+	if c.config.Token != "" {
+		// a static token cannot be replaced, try to use it anyway
+		return c.token, nil
+	}
+	if err := c.login(); err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+func (c *vaultClient) setLease(token string, leaseDuration int64, renewable bool) {
+	c.token = token
+	c.renewable = renewable
+	c.ttl = time.Duration(leaseDuration) * time.Second
+	if leaseDuration > 0 {
+		c.expiration = time.Now().Add(c.ttl)
+	} else {
+		c.expiration = time.Time{}
+	}
+}
+
+func (c *vaultClient) login() error {
+	var resp vaultAuthResponse
+	body := map[string]string{
+		"role_id":   c.config.AppRole.RoleID,
+		"secret_id": c.config.AppRole.SecretID,
+	}
+	path := fmt.Sprintf("auth/%s/login", c.config.AppRole.MountPath)
+	if err := c.doRequest(http.MethodPost, path, "", body, &resp); err != nil {
+		return fmt.Errorf("vault: unable to login using AppRole: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return errors.New("vault: AppRole login returned an empty token")
+	}
+	c.setLease(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	logger.Debug(logSender, "", "Vault AppRole login completed, lease duration: %ds, renewable: %t",
+		resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+func (c *vaultClient) lookupToken() error {
+	var resp vaultDataResponse
+	if err := c.doRequest(http.MethodGet, "auth/token/lookup-self", c.config.Token, nil, &resp); err != nil {
+		return fmt.Errorf("vault: unable to lookup the token: %w", err)
+	}
+	c.setLease(c.config.Token, resp.Data.TTL, resp.Data.Renewable)
+	return nil
+}
+
+func (c *vaultClient) renewToken() error {
+	var resp vaultAuthResponse
+	if err := c.doRequest(http.MethodPost, "auth/token/renew-self", c.token, map[string]string{}, &resp); err != nil {
+		return err
+	}
+	token := resp.Auth.ClientToken
+	if token == "" {
+		token = c.token
+	}
+	c.setLease(token, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	logger.Debug(logSender, "", "Vault token renewed, lease duration: %ds", resp.Auth.LeaseDuration)
+	return nil
+}
+
+func (c *vaultClient) transit(operation, keyName string, body map[string]string) (vaultDataResponse, error) {
+	var resp vaultDataResponse
+	if keyName == "" {
+		return resp, errors.New("vault: the transit key name is required")
+	}
+	token, err := c.getToken()
+	if err != nil {
+		return resp, err
+	}
+	path := fmt.Sprintf("%s/%s/%s", c.config.MountPath, operation, keyName)
+	if err := c.doRequest(http.MethodPost, path, token, body, &resp); err != nil {
+		return resp, fmt.Errorf("vault: unable to %s: %w", operation, err)
+	}
+	return resp, nil
+}
+
+func (c *vaultClient) doRequest(method, path, token string, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.config.Address, path), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+	client := httpclient.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		var errResp vaultErrorResponse
+		json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&errResp) //nolint:errcheck
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.Join(errResp.Errors, ", "))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+type vaultSecret struct {
+	BaseSecret
+	keyName string
+}
+
+func newVaultSecret(base BaseSecret, url, _ string) SecretProvider {
+	return &vaultSecret{
+		BaseSecret: base,
+		keyName:    strings.Trim(strings.TrimPrefix(url, sdkkms.SchemeVaultTransit+"://"), "/"),
+	}
+}
+
+func (s *vaultSecret) Name() string {
+	return "VaultTransit"
+}
+
+func (s *vaultSecret) IsEncrypted() bool {
+	return s.Status == sdkkms.SecretStatusVaultTransit
+}
+
+func (s *vaultSecret) Encrypt() error {
+	if s.Status != sdkkms.SecretStatusPlain {
+		return ErrWrongSecretStatus
+	}
+	if s.Payload == "" {
+		return ErrInvalidSecret
+	}
+	if vault == nil {
+		return errVaultNotConfigured
+	}
+	resp, err := vault.transit("encrypt", s.keyName, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString([]byte(s.Payload)),
+	})
+	if err != nil {
+		return err
+	}
+	if getVaultKeyVersion(resp.Data.Ciphertext) == 0 {
+		return errMalformedCiphertext
+	}
+	s.Status = sdkkms.SecretStatusVaultTransit
+	s.Payload = resp.Data.Ciphertext
+	s.Key = ""
+	s.Mode = 0
+	return nil
+}
+
+func (s *vaultSecret) Decrypt() error {
+	if !s.IsEncrypted() {
+		return ErrWrongSecretStatus
+	}
+	if vault == nil {
+		return errVaultNotConfigured
+	}
+	resp, err := vault.transit("decrypt", s.keyName, map[string]string{
+		"ciphertext": s.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return err
+	}
+	s.Status = sdkkms.SecretStatusPlain
+	s.Payload = string(plaintext)
+	s.Key = ""
+	s.AdditionalData = ""
+	s.Mode = 0
+	return nil
+}
+
+// Rewrap re-encrypts the secret using the latest version of the transit key,
+// the plaintext is never exposed to SFTPGo. It returns true if the key version
+// changed
+func (s *vaultSecret) Rewrap() (bool, error) {
+	if !s.IsEncrypted() {
+		return false, ErrWrongSecretStatus
+	}
+	if vault == nil {
+		return false, errVaultNotConfigured
+	}
+	resp, err := vault.transit("rewrap", s.keyName, map[string]string{
+		"ciphertext": s.Payload,
+	})
+	if err != nil {
+		return false, err
+	}
+	newVersion := getVaultKeyVersion(resp.Data.Ciphertext)
+	if newVersion == 0 {
+		return false, errMalformedCiphertext
+	}
+	if newVersion == getVaultKeyVersion(s.Payload) {
+		return false, nil
+	}
+	s.Payload = resp.Data.Ciphertext
+	return true, nil
+}
+
+func (s *vaultSecret) Clone() SecretProvider {
+	baseSecret := BaseSecret{
+		Status:         s.Status,
+		Payload:        s.Payload,
+		Key:            s.Key,
+		AdditionalData: s.AdditionalData,
+		Mode:           s.Mode,
+	}
+	return &vaultSecret{
+		BaseSecret: baseSecret,
+		keyName:    s.keyName,
+	}
+}
+
+// getVaultKeyVersion returns the key version from a transit ciphertext in the
+// form "vault:v<version>:<ciphertext>", 0 means invalid ciphertext
+func getVaultKeyVersion(ciphertext string) int {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil || version < 0 {
+		return 0
+	}
+	return version
+}
@@ -0,0 +1,401 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package ldap authenticates users against LDAP servers and Active Directory
+// using the go-ldap client
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultTimeout        = 10
+	defaultUserFilter     = "(uid={{Username}})"
+	defaultGroupAttribute = "memberOf"
+	defaultGroupFilter    = "(|(member={{DN}})(uniqueMember={{DN}})(memberUid={{Username}}))"
+	defaultEmailTemplate  = "{{Attribute:mail}}"
+	placeholderUsername   = "{{Username}}"
+	placeholderDN         = "{{DN}}"
+	maxSearchResultLimit  = 1000
+)
+
+// Group mapping types, they match the SFTPGo group types
+const (
+	GroupTypePrimary    = 1
+	GroupTypeSecondary  = 2
+	GroupTypeMembership = 3
+)
+
+var (
+	// ErrInvalidCredentials defines the error returned if the user does not exist
+	// or the password is wrong
+	ErrInvalidCredentials = errors.New("invalid LDAP credentials")
+	attributeRegex        = regexp.MustCompile(`{{Attribute:([^}]+)}}`)
+)
+
+// GroupMapping maps an LDAP group to an SFTPGo group
+type GroupMapping struct {
+	// LDAP group, you can use the full distinguished name or the value of its
+	// first component, for example the common name. Matching is case insensitive
+	LDAPGroup string `json:"ldap_group" mapstructure:"ldap_group"`
+	// SFTPGo group name
+	Group string `json:"group" mapstructure:"group"`
+	// Group type: 1 primary, 2 secondary, 3 membership only
+	Type int `json:"type" mapstructure:"type"`
+}
+
+// Config defines an LDAP directory to authenticate users against
+type Config struct {
+	// Unique name, bindings refer to the directory to use by name
+	Name string `json:"name" mapstructure:"name"`
+	// Server URL, for example ldap://ldap.example.com or ldaps://ldap.example.com:636
+	URL string `json:"url" mapstructure:"url"`
+	// Upgrade plain text connections using StartTLS
+	StartTLS bool `json:"start_tls" mapstructure:"start_tls"`
+	// Disable the server certificate verification, only for testing
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// Path to a PEM encoded CA certificates bundle to verify the server certificate.
+	// If empty the system root CAs are used
+	CACertificate string `json:"ca_certificate" mapstructure:"ca_certificate"`
+	// Timeout for connection and each operation as seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Template for the user distinguished name, for example
+	// "uid={{Username}},ou=users,dc=example,dc=com". If set the user is bound
+	// directly, no search is done
+	UserDNTemplate string `json:"user_dn_template" mapstructure:"user_dn_template"`
+	// Service account to search for users, leave empty for anonymous search
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// Base DN for the user search
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// Filter to search for users, for Active Directory use "(sAMAccountName={{Username}})"
+	UserFilter string `json:"user_filter" mapstructure:"user_filter"`
+	// Attribute listing the groups the user is member of. Default "memberOf"
+	GroupAttribute string `json:"group_attribute" mapstructure:"group_attribute"`
+	// If set groups are also searched within this base DN using GroupFilter
+	GroupBaseDN string `json:"group_base_dn" mapstructure:"group_base_dn"`
+	GroupFilter string `json:"group_filter" mapstructure:"group_filter"`
+	// LDAP groups to SFTPGo groups mappings
+	GroupMappings []GroupMapping `json:"group_mappings" mapstructure:"group_mappings"`
+	// If true, users not member of at least a mapped group are denied
+	RequireGroupMapping bool `json:"require_group_mapping" mapstructure:"require_group_mapping"`
+	// Templates for the user fields, "{{Username}}", "{{DN}}" and "{{Attribute:<name>}}"
+	// placeholders are supported
+	HomeDir    string `json:"home_dir" mapstructure:"home_dir"`
+	Email      string `json:"email" mapstructure:"email"`
+	QuotaSize  string `json:"quota_size" mapstructure:"quota_size"`
+	QuotaFiles string `json:"quota_files" mapstructure:"quota_files"`
+	// Permissions for the root directory of new users, default "*"
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+	rootCAs     *x509.CertPool
+	host        string
+}
+
+// Validate validates the configuration and sets the default values
+func (c *Config) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return errors.New("ldap: name is mandatory")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("ldap %q: invalid URL %q", c.Name, c.URL)
+	}
+	switch u.Scheme {
+	case "ldap":
+	case "ldaps":
+		if c.StartTLS {
+			return fmt.Errorf("ldap %q: StartTLS is not supported for ldaps URLs", c.Name)
+		}
+	default:
+		return fmt.Errorf("ldap %q: unsupported URL scheme %q", c.Name, u.Scheme)
+	}
+	c.host = u.Hostname()
+	if c.CACertificate != "" {
+		pemCerts, err := os.ReadFile(c.CACertificate)
+		if err != nil {
+			return fmt.Errorf("ldap %q: unable to read CA certificate: %w", c.Name, err)
+		}
+		c.rootCAs = x509.NewCertPool()
+		if !c.rootCAs.AppendCertsFromPEM(pemCerts) {
+			return fmt.Errorf("ldap %q: no valid CA certificate found in %q", c.Name, c.CACertificate)
+		}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.UserDNTemplate != "" {
+		if !strings.Contains(c.UserDNTemplate, placeholderUsername) {
+			return fmt.Errorf("ldap %q: the user DN template must contain the %s placeholder", c.Name, placeholderUsername)
+		}
+	} else {
+		if c.BaseDN == "" {
+			return fmt.Errorf("ldap %q: a base DN or a user DN template is required", c.Name)
+		}
+		if c.UserFilter == "" {
+			c.UserFilter = defaultUserFilter
+		}
+		if _, err := goldap.CompileFilter(c.getUserFilter("user")); err != nil {
+			return fmt.Errorf("ldap %q: %w", c.Name, err)
+		}
+	}
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = defaultGroupAttribute
+	}
+	if c.GroupBaseDN != "" {
+		if c.GroupFilter == "" {
+			c.GroupFilter = defaultGroupFilter
+		}
+		if _, err := goldap.CompileFilter(c.getGroupFilter("user", "cn=user")); err != nil {
+			return fmt.Errorf("ldap %q: %w", c.Name, err)
+		}
+	}
+	if c.Email == "" {
+		c.Email = defaultEmailTemplate
+	}
+	if len(c.Permissions) == 0 {
+		c.Permissions = []string{"*"}
+	}
+	return c.validateGroupMappings()
+}
+
+func (c *Config) validateGroupMappings() error {
+	for idx := range c.GroupMappings {
+		m := &c.GroupMappings[idx]
+		m.LDAPGroup = strings.TrimSpace(m.LDAPGroup)
+		m.Group = strings.TrimSpace(m.Group)
+		if m.LDAPGroup == "" || m.Group == "" {
+			return fmt.Errorf("ldap %q: group mappings require both the LDAP and the SFTPGo group", c.Name)
+		}
+		if m.Type < GroupTypePrimary || m.Type > GroupTypeMembership {
+			return fmt.Errorf("ldap %q: invalid type %d for group mapping %q", c.Name, m.Type, m.LDAPGroup)
+		}
+	}
+	if c.RequireGroupMapping && len(c.GroupMappings) == 0 {
+		return fmt.Errorf("ldap %q: group mappings are required if require_group_mapping is set", c.Name)
+	}
+	return nil
+}
+
+func (c *Config) getUserFilter(username string) string {
+	return strings.ReplaceAll(c.UserFilter, placeholderUsername, goldap.EscapeFilter(username))
+}
+
+func (c *Config) getGroupFilter(username, dn string) string {
+	filter := strings.ReplaceAll(c.GroupFilter, placeholderUsername, goldap.EscapeFilter(username))
+	return strings.ReplaceAll(filter, placeholderDN, goldap.EscapeFilter(dn))
+}
+
+func (c *Config) getTLSConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         c.host,
+		RootCAs:            c.rootCAs,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipTLSVerify, //nolint:gosec
+	}
+}
+
+func (c *Config) getTimeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *Config) connect() (*goldap.Conn, error) {
+	conn, err := goldap.DialURL(c.URL, goldap.DialWithDialer(&net.Dialer{Timeout: c.getTimeout()}),
+		goldap.DialWithTLSConfig(c.getTLSConfig()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to LDAP server %q: %w", c.URL, err)
+	}
+	conn.SetTimeout(c.getTimeout())
+	if c.StartTLS {
+		if err := conn.StartTLS(c.getTLSConfig()); err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *Config) search(conn *goldap.Conn, baseDN string, scope int, filter string, attributes []string,
+) ([]*goldap.Entry, error) {
+	res, err := conn.Search(goldap.NewSearchRequest(baseDN, scope, goldap.NeverDerefAliases, maxSearchResultLimit,
+		c.Timeout, false, filter, attributes, nil))
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+// Result is the result of a successful authentication
+type Result struct {
+	goldap.Entry
+	// Distinguished names of the groups the user is member of
+	Groups []string
+}
+
+// Authenticate checks the given credentials and returns the user entry and groups
+func (c *Config) Authenticate(username, password string) (*Result, error) {
+	// an empty password means unauthenticated bind and it is usually successful
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	attributes := []string{"*", c.GroupAttribute}
+	var entry *goldap.Entry
+	if c.UserDNTemplate != "" {
+		dn := strings.ReplaceAll(c.UserDNTemplate, placeholderUsername, goldap.EscapeDN(username))
+		if err := c.bindUser(conn, dn, password); err != nil {
+			return nil, err
+		}
+		entries, err := c.search(conn, dn, goldap.ScopeBaseObject, "(objectClass=*)", attributes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the entry for user %q: %w", username, err)
+		}
+		if len(entries) != 1 {
+			return nil, fmt.Errorf("unable to read the entry for user %q", username)
+		}
+		entry = entries[0]
+	} else {
+		if err := c.bindService(conn); err != nil {
+			return nil, err
+		}
+		entries, err := c.search(conn, c.BaseDN, goldap.ScopeWholeSubtree, c.getUserFilter(username), attributes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to search for user %q: %w", username, err)
+		}
+		if len(entries) == 0 {
+			return nil, ErrInvalidCredentials
+		}
+		if len(entries) > 1 {
+			return nil, fmt.Errorf("the search for user %q returned %d entries", username, len(entries))
+		}
+		entry = entries[0]
+		if err := c.bindUser(conn, entry.DN, password); err != nil {
+			return nil, err
+		}
+	}
+	result := &Result{
+		Entry:  *entry,
+		Groups: entry.GetEqualFoldAttributeValues(c.GroupAttribute),
+	}
+	if c.GroupBaseDN != "" {
+		if c.UserDNTemplate == "" && c.BindDN != "" {
+			// the user may not be allowed to search for groups
+			if err := c.bindService(conn); err != nil {
+				return nil, err
+			}
+		}
+		groups, err := c.search(conn, c.GroupBaseDN, goldap.ScopeWholeSubtree, c.getGroupFilter(username, entry.DN),
+			[]string{"1.1"})
+		if err != nil {
+			return nil, fmt.Errorf("unable to search groups for user %q: %w", username, err)
+		}
+		for _, g := range groups {
+			result.Groups = append(result.Groups, g.DN)
+		}
+	}
+	if c.RequireGroupMapping && len(c.GetGroupMappings(result.Groups)) == 0 {
+		return nil, fmt.Errorf("user %q is not member of any mapped group", username)
+	}
+	return result, nil
+}
+
+// bindService binds as the service account, an empty bind DN means anonymous search
+func (c *Config) bindService(conn *goldap.Conn) error {
+	var err error
+	if c.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(c.BindDN, c.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to bind as %q: %w", c.BindDN, err)
+	}
+	return nil
+}
+
+func (c *Config) bindUser(conn *goldap.Conn, dn, password string) error {
+	err := conn.Bind(dn, password)
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("unable to bind as %q: %w", dn, err)
+	}
+	return nil
+}
+
+// GetGroupMappings returns the mappings matching the given LDAP groups.
+// At most one primary group is returned
+func (c *Config) GetGroupMappings(groups []string) []GroupMapping {
+	var result []GroupMapping
+	hasPrimary := false
+	for _, m := range c.GroupMappings {
+		if m.Type == GroupTypePrimary && hasPrimary {
+			continue
+		}
+		for _, g := range groups {
+			if isGroupMatch(m.LDAPGroup, g) {
+				if m.Type == GroupTypePrimary {
+					hasPrimary = true
+				}
+				result = append(result, m)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func isGroupMatch(mapping, group string) bool {
+	if strings.EqualFold(mapping, group) {
+		return true
+	}
+	if strings.Contains(mapping, "=") {
+		return false
+	}
+	// match the value of the first DN component, for example the group CN
+	rdn, _, _ := strings.Cut(group, ",")
+	_, value, ok := strings.Cut(rdn, "=")
+	return ok && strings.EqualFold(strings.TrimSpace(value), mapping)
+}
+
+// RenderTemplate replaces the supported placeholders in the given template
+func (c *Config) RenderTemplate(template, username string, entry *goldap.Entry) string {
+	if template == "" {
+		return ""
+	}
+	result := strings.ReplaceAll(template, placeholderUsername, username)
+	result = strings.ReplaceAll(result, placeholderDN, entry.DN)
+	return attributeRegex.ReplaceAllStringFunc(result, func(s string) string {
+		match := attributeRegex.FindStringSubmatch(s)
+		return entry.GetEqualFoldAttributeValue(match[1])
+	})
+}
@@ -0,0 +1,452 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package ldap
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	serviceDN       = "cn=service,dc=example,dc=com"
+	servicePassword = "service_pwd"
+	userPassword    = "user_pwd"
+)
+
+// mockServer is a minimal LDAP server supporting bind, search and StartTLS
+type mockServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	certDER   []byte
+	passwords map[string]string
+	entries   []*goldap.Entry
+	groups    []*goldap.Entry
+}
+
+func newMockServer(t *testing.T, useTLS bool) *mockServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &mockServer{
+		listener: l,
+		passwords: map[string]string{
+			serviceDN:                                 servicePassword,
+			"uid=user1,ou=users,dc=example,dc=com":    userPassword,
+			"uid=user\\,2,ou=users,dc=example,dc=com": userPassword,
+		},
+		entries: []*goldap.Entry{
+			goldap.NewEntry("uid=user1,ou=users,dc=example,dc=com", map[string][]string{
+				"uid":           {"user1"},
+				"mail":          {"user1@example.com"},
+				"homeDirectory": {"/home/user1"},
+				"memberOf":      {"cn=sftp,ou=groups,dc=example,dc=com"},
+			}),
+			goldap.NewEntry("uid=user\\,2,ou=users,dc=example,dc=com", map[string][]string{
+				"uid": {"user,2"},
+			}),
+			goldap.NewEntry("uid=dup,ou=users,dc=example,dc=com", map[string][]string{
+				"uid": {"dup"},
+			}),
+			goldap.NewEntry("uid=dup,ou=others,dc=example,dc=com", map[string][]string{
+				"uid": {"dup"},
+			}),
+		},
+		groups: []*goldap.Entry{
+			goldap.NewEntry("cn=admins,ou=groups,dc=example,dc=com", map[string][]string{
+				"member": {"uid=user1,ou=users,dc=example,dc=com"},
+			}),
+		},
+	}
+	s.tlsConfig, s.certDER = getTestTLSConfig(t)
+	if useTLS {
+		s.listener = tls.NewListener(l, s.tlsConfig)
+	}
+	go s.serve()
+	return s
+}
+
+func (s *mockServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *mockServer) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *mockServer) handle(c net.Conn) {
+	defer c.Close()
+	reader := bufio.NewReader(c)
+	for {
+		msg, err := ber.ReadPacket(reader)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id, _ := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+		if op.ClassType != ber.ClassApplication {
+			return
+		}
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			dn, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := int64(goldap.LDAPResultSuccess)
+			if dn != "" || password != "" {
+				if expected, ok := s.passwords[dn]; !ok || expected != password {
+					code = goldap.LDAPResultInvalidCredentials
+				}
+			}
+			s.write(c, id, getResult(goldap.ApplicationBindResponse, code))
+		case goldap.ApplicationUnbindRequest:
+			return
+		case goldap.ApplicationExtendedRequest:
+			s.write(c, id, getResult(goldap.ApplicationExtendedResponse, goldap.LDAPResultSuccess))
+			tlsConn := tls.Server(c, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			c = tlsConn
+			reader = bufio.NewReader(c)
+		case goldap.ApplicationSearchRequest:
+			baseDN, _ := op.Children[0].Value.(string)
+			scope, _ := op.Children[1].Value.(int64)
+			entries := s.entries
+			if strings.HasPrefix(baseDN, "ou=groups") {
+				entries = s.groups
+			}
+			for _, e := range entries {
+				if scope == goldap.ScopeBaseObject && e.DN != baseDN {
+					continue
+				}
+				if scope == goldap.ScopeWholeSubtree && (!strings.HasSuffix(e.DN, baseDN) || !matchFilter(op.Children[6], e)) {
+					continue
+				}
+				s.write(c, id, getEntryPacket(e))
+			}
+			s.write(c, id, getResult(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess))
+		}
+	}
+}
+
+func (s *mockServer) write(c net.Conn, id int64, op *ber.Packet) {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	msg.AppendChild(op)
+	c.Write(msg.Bytes()) //nolint:errcheck
+}
+
+func getResult(op ber.Tag, code int64) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+func getEntryPacket(e *goldap.Entry) *ber.Packet {
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for _, attr := range e.Attributes {
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range attr.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.Name, ""))
+		a.AppendChild(vals)
+		attrs.AppendChild(a)
+	}
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, ""))
+	p.AppendChild(attrs)
+	return p
+}
+
+// matchFilter supports equality and or filters
+func matchFilter(filter *ber.Packet, e *goldap.Entry) bool {
+	switch filter.Tag {
+	case goldap.FilterOr:
+		for _, f := range filter.Children {
+			if matchFilter(f, e) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterEqualityMatch:
+		name, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range e.GetEqualFoldAttributeValues(name) {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getTestTLSConfig(t *testing.T) (*tls.Config, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{der},
+				PrivateKey:  key,
+			},
+		},
+		MinVersion: tls.VersionTLS12,
+	}, der
+}
+
+func TestValidate(t *testing.T) {
+	c := Config{}
+	assert.Error(t, c.Validate())
+	c.Name = "ldap"
+	c.URL = "http://127.0.0.1"
+	assert.Error(t, c.Validate())
+	c.URL = "ldaps://"
+	assert.Error(t, c.Validate())
+	c.URL = "ldaps://127.0.0.1"
+	c.StartTLS = true
+	assert.Error(t, c.Validate())
+	c.StartTLS = false
+	assert.Error(t, c.Validate())
+	c.BaseDN = "dc=example,dc=com"
+	c.UserFilter = "(uid={{Username}}"
+	assert.Error(t, c.Validate())
+	c.UserFilter = ""
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "127.0.0.1", c.host)
+	assert.Equal(t, defaultUserFilter, c.UserFilter)
+	assert.Equal(t, defaultGroupAttribute, c.GroupAttribute)
+	assert.Equal(t, defaultTimeout, c.Timeout)
+	assert.Equal(t, []string{"*"}, c.Permissions)
+	c.URL = "ldap://[::1]:1389"
+	c.UserDNTemplate = "uid=user,dc=example,dc=com"
+	assert.Error(t, c.Validate())
+	c.UserDNTemplate = "uid={{Username}},dc=example,dc=com"
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "::1", c.host)
+	c.GroupBaseDN = "ou=groups,dc=example,dc=com"
+	c.GroupFilter = "(member={{DN}}"
+	assert.Error(t, c.Validate())
+	c.GroupFilter = ""
+	require.NoError(t, c.Validate())
+	assert.Equal(t, defaultGroupFilter, c.GroupFilter)
+	c.RequireGroupMapping = true
+	assert.Error(t, c.Validate())
+	c.GroupMappings = []GroupMapping{{LDAPGroup: "sftp", Group: " "}}
+	assert.Error(t, c.Validate())
+	c.GroupMappings = []GroupMapping{{LDAPGroup: "sftp", Group: "group1"}}
+	assert.Error(t, c.Validate())
+	c.GroupMappings[0].Type = GroupTypeSecondary
+	require.NoError(t, c.Validate())
+
+	c.CACertificate = filepath.Join(os.TempDir(), "missing_ldap_ca.pem")
+	assert.Error(t, c.Validate())
+	err := os.WriteFile(c.CACertificate, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	assert.Error(t, c.Validate())
+	err = os.Remove(c.CACertificate)
+	assert.NoError(t, err)
+}
+
+func TestGroupMappingsAndTemplates(t *testing.T) {
+	c := Config{
+		GroupMappings: []GroupMapping{
+			{LDAPGroup: "cn=admins,ou=groups,dc=example,dc=com", Group: "admins", Type: GroupTypePrimary},
+			{LDAPGroup: "SFTP", Group: "sftp", Type: GroupTypePrimary},
+			{LDAPGroup: "sftp", Group: "sftp_secondary", Type: GroupTypeSecondary},
+			{LDAPGroup: "other", Group: "other", Type: GroupTypeMembership},
+		},
+	}
+	mappings := c.GetGroupMappings([]string{"cn=sftp,ou=groups,dc=example,dc=com", "CN=Admins,OU=Groups,DC=example,DC=com"})
+	require.Len(t, mappings, 2)
+	assert.Equal(t, "admins", mappings[0].Group)
+	assert.Equal(t, "sftp_secondary", mappings[1].Group)
+	assert.Len(t, c.GetGroupMappings([]string{"cn=others,dc=example,dc=com", "invalid"}), 0)
+
+	entry := goldap.NewEntry("uid=user1,dc=example,dc=com", map[string][]string{
+		"homeDirectory": {"/home/user1"},
+	})
+	assert.Equal(t, "/home/user1/uid=user1,dc=example,dc=com/user1/",
+		c.RenderTemplate("{{Attribute:homedirectory}}/{{DN}}/{{Username}}/{{Attribute:missing}}", "user1", entry))
+	assert.Empty(t, c.RenderTemplate("", "user1", entry))
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newMockServer(t, false)
+	defer server.listener.Close()
+
+	c := Config{
+		Name:                "test",
+		URL:                 "ldap://" + server.address(),
+		BaseDN:              "dc=example,dc=com",
+		BindDN:              serviceDN,
+		BindPassword:        servicePassword,
+		GroupBaseDN:         "ou=groups,dc=example,dc=com",
+		RequireGroupMapping: true,
+		GroupMappings: []GroupMapping{
+			{LDAPGroup: "admins", Group: "admins", Type: GroupTypeMembership},
+		},
+	}
+	require.NoError(t, c.Validate())
+	result, err := c.Authenticate("user1", userPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "uid=user1,ou=users,dc=example,dc=com", result.DN)
+	assert.Equal(t, []string{"cn=sftp,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}, result.Groups)
+	assert.Equal(t, "user1@example.com", c.RenderTemplate(c.Email, "user1", &result.Entry))
+
+	_, err = c.Authenticate("user1", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate("user1", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate("missing", userPassword)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate("dup", userPassword)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "returned 2 entries")
+	}
+	// the filter value is escaped
+	_, err = c.Authenticate("*", userPassword)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate("user,2", userPassword)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not member of any mapped group")
+	}
+	c.RequireGroupMapping = false
+	result, err = c.Authenticate("user,2", userPassword)
+	require.NoError(t, err)
+	assert.Empty(t, result.Groups)
+	// wrong service account password
+	c.BindPassword = "wrong"
+	_, err = c.Authenticate("user1", userPassword)
+	var ldapErr *goldap.Error
+	if assert.ErrorAs(t, err, &ldapErr) {
+		assert.Equal(t, uint16(goldap.LDAPResultInvalidCredentials), ldapErr.ResultCode)
+	}
+	assert.NotErrorIs(t, err, ErrInvalidCredentials)
+	// bind as user, with StartTLS
+	c = Config{
+		Name:           "test",
+		URL:            "ldap://" + server.address(),
+		StartTLS:       true,
+		UserDNTemplate: "uid={{Username}},ou=users,dc=example,dc=com",
+	}
+	require.NoError(t, c.Validate())
+	_, err = c.Authenticate("user1", userPassword)
+	assert.Error(t, err, "the self signed certificate must be rejected")
+	c.SkipTLSVerify = true
+	result, err = c.Authenticate("user1", userPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "/home/user1", result.GetEqualFoldAttributeValue("homedirectory"))
+	assert.Equal(t, []string{"cn=sftp,ou=groups,dc=example,dc=com"}, result.Groups)
+	result, err = c.Authenticate("user,2", userPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "uid=user\\,2,ou=users,dc=example,dc=com", result.DN)
+	_, err = c.Authenticate("user1", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	c.URL = "ldap://127.0.0.1:1"
+	c.Timeout = 1
+	require.NoError(t, c.Validate())
+	_, err = c.Authenticate("user1", userPassword)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect")
+	}
+}
+
+func TestAuthenticateLDAPS(t *testing.T) {
+	server := newMockServer(t, true)
+	defer server.listener.Close()
+
+	c := Config{
+		Name:           "test",
+		URL:            "ldaps://" + server.address(),
+		UserDNTemplate: "uid={{Username}},ou=users,dc=example,dc=com",
+	}
+	require.NoError(t, c.Validate())
+	_, err := c.Authenticate("user1", userPassword)
+	if assert.Error(t, err, "the self signed certificate must be rejected") {
+		assert.Contains(t, err.Error(), "unable to connect")
+	}
+	c.CACertificate = filepath.Join(t.TempDir(), "ldap_ca.pem")
+	writeCACertificate(t, c.CACertificate, server)
+	require.NoError(t, c.Validate())
+	result, err := c.Authenticate("user1", userPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "uid=user1,ou=users,dc=example,dc=com", result.DN)
+	_, err = c.Authenticate("user1", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	// the certificate is valid for 127.0.0.1 only
+	c.URL = strings.Replace(c.URL, "127.0.0.1", "localhost", 1)
+	require.NoError(t, c.Validate())
+	_, err = c.Authenticate("user1", userPassword)
+	assert.Error(t, err)
+	// the CA is also used for StartTLS
+	startTLSServer := newMockServer(t, false)
+	defer startTLSServer.listener.Close()
+
+	c.URL = "ldap://" + startTLSServer.address()
+	c.StartTLS = true
+	require.NoError(t, c.Validate())
+	_, err = c.Authenticate("user1", userPassword)
+	assert.Error(t, err)
+	writeCACertificate(t, c.CACertificate, startTLSServer)
+	require.NoError(t, c.Validate())
+	_, err = c.Authenticate("user1", userPassword)
+	assert.NoError(t, err)
+}
+
+func writeCACertificate(t *testing.T, name string, server *mockServer) {
+	err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.certDER}), 0600)
+	require.NoError(t, err)
+}
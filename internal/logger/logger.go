@@ -28,6 +28,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	ftpserverlog "github.com/fclairamb/go-log"
@@ -51,9 +52,10 @@ const (
 )
 
 var (
-	logger        zerolog.Logger
-	consoleLogger zerolog.Logger
-	rollingLogger *lumberjack.Logger
+	logger          zerolog.Logger
+	consoleLogger   zerolog.Logger
+	rollingLogger   *lumberjack.Logger
+	countryResolver atomic.Pointer[func(string) string]
 )
 
 func init() {
@@ -250,17 +252,28 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 // a client abort or a time out if the login does not happen in two minutes.
 // These logs are useful for better integration with Fail2ban and similar tools.
 func ConnectionFailedLog(user, ip, loginType, protocol, errorString string) {
-	logger.Debug().
+	ev := logger.Debug().
 		Timestamp().
 		Str("sender", "connection_failed").
-		Str("client_ip", ip).
-		Str("username", user).
+		Str("client_ip", ip)
+	if resolver := countryResolver.Load(); resolver != nil {
+		if country := (*resolver)(ip); country != "" {
+			ev.Str("country", country)
+		}
+	}
+	ev.Str("username", user).
 		Str("login_type", loginType).
 		Str("protocol", protocol).
 		Str("error", errorString).
 		Send()
 }
 
+// SetCountryResolver sets the function used to add the client country
+// to the connection failed logs
+func SetCountryResolver(fn func(ip string) string) {
+	countryResolver.Store(&fn)
+}
+
 func isLogFilePathValid(logFilePath string) bool {
 	cleanInput := filepath.Clean(logFilePath)
 	if cleanInput == "." || cleanInput == ".." {
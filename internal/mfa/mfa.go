@@ -29,6 +29,7 @@ var (
 type ServiceStatus struct {
 	IsActive    bool         `json:"is_active"`
 	TOTPConfigs []TOTPConfig `json:"totp_configs"`
+	WebAuthn    bool         `json:"webauthn"`
 }
 
 // GetStatus returns the service status
@@ -40,6 +41,8 @@ func GetStatus() ServiceStatus {
 type Config struct {
 	// Time-based one time passwords configurations
 	TOTP []TOTPConfig `json:"totp" mapstructure:"totp"`
+	// FIDO2/WebAuthn security keys configuration
+	WebAuthn WebAuthnConfig `json:"webauthn" mapstructure:"webauthn"`
 }
 
 // Initialize configures the MFA support
@@ -47,6 +50,7 @@ func (c *Config) Initialize() error {
 	totpConfigs = nil
 	serviceStatus.IsActive = false
 	serviceStatus.TOTPConfigs = nil
+	serviceStatus.WebAuthn = false
 	totp := make(map[string]bool)
 	for _, totpConfig := range c.TOTP {
 		totpConfig := totpConfig //pin
@@ -63,6 +67,14 @@ func (c *Config) Initialize() error {
 		serviceStatus.IsActive = true
 		serviceStatus.TOTPConfigs = append(serviceStatus.TOTPConfigs, totpConfig)
 	}
+	if err := c.WebAuthn.initialize(); err != nil {
+		totpConfigs = nil
+		return err
+	}
+	if IsWebAuthnEnabled() {
+		serviceStatus.IsActive = true
+		serviceStatus.WebAuthn = true
+	}
 	startCleanupTicker(2 * time.Minute)
 	return nil
 }
@@ -141,3 +141,62 @@ func generatePasscode(secret string, algo otp.Algorithm) (string, error) {
 		Algorithm: algo,
 	})
 }
+
+func TestWebAuthnConfig(t *testing.T) {
+	config := Config{
+		WebAuthn: WebAuthnConfig{
+			RPID:      "sftpgo.example.com",
+			RPOrigins: []string{"sftpgo.example.com"},
+		},
+	}
+	err := config.Initialize()
+	assert.Error(t, err)
+	assert.False(t, IsWebAuthnEnabled())
+	config.WebAuthn.RPOrigins = nil
+	config.WebAuthn.Timeout = -1
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.WebAuthn.Timeout = 0
+	config.WebAuthn.RequiredRoles = []string{"role1"}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.True(t, IsWebAuthnEnabled())
+	assert.True(t, GetStatus().IsActive)
+	assert.True(t, GetStatus().WebAuthn)
+	assert.True(t, IsWebAuthnRequiredForRole("role1"))
+	assert.False(t, IsWebAuthnRequiredForRole("role2"))
+	assert.False(t, IsWebAuthnRequiredForRole(""))
+	assert.Equal(t, []string{"https://sftpgo.example.com"}, config.WebAuthn.RPOrigins)
+	assert.Equal(t, 300, config.WebAuthn.Timeout)
+
+	user := NewWebAuthnUser("user", false, nil)
+	admin := NewWebAuthnUser("user", true, nil)
+	assert.NotEqual(t, user.WebAuthnID(), admin.WebAuthnID())
+	assert.Equal(t, "user", user.WebAuthnName())
+	creation, session, err := BeginWebAuthnRegistration(user)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, creation.Response.Challenge)
+	assert.Equal(t, user.WebAuthnID(), session.UserID)
+	_, err = FinishWebAuthnRegistration(user, *session, []byte("{}"))
+	assert.Error(t, err)
+	_, err = FinishWebAuthnRegistration(admin, *session, []byte("{}"))
+	assert.Error(t, err)
+	_, _, err = BeginWebAuthnLogin(user)
+	assert.Error(t, err, "login without credentials must fail")
+	_, err = FinishWebAuthnLogin(user, *session, []byte("{}"))
+	assert.Error(t, err)
+
+	config.WebAuthn.RPID = ""
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.False(t, IsWebAuthnEnabled())
+	assert.False(t, GetStatus().IsActive)
+	_, _, err = BeginWebAuthnRegistration(user)
+	assert.Error(t, err)
+	_, err = FinishWebAuthnRegistration(user, *session, nil)
+	assert.Error(t, err)
+	_, _, err = BeginWebAuthnLogin(user)
+	assert.Error(t, err)
+	_, err = FinishWebAuthnLogin(user, *session, nil)
+	assert.Error(t, err)
+}
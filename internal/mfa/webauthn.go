@@ -0,0 +1,245 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mfa
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var (
+	webAuthn         *webauthn.WebAuthn
+	webAuthnRequired map[string]bool
+)
+
+// WebAuthnConfig defines the configuration for FIDO2/WebAuthn security keys
+// as second factor for the web UIs
+type WebAuthnConfig struct {
+	// Relying Party identifier, usually the domain name used to reach the web UIs,
+	// for example "sftpgo.example.com". Empty means disabled
+	RPID string `json:"rp_id" mapstructure:"rp_id"`
+	// Relying Party name displayed by the browser
+	RPDisplayName string `json:"rp_display_name" mapstructure:"rp_display_name"`
+	// Allowed origins, for example "https://sftpgo.example.com:8443".
+	// If empty "https://" + rp_id is allowed
+	RPOrigins []string `json:"rp_origins" mapstructure:"rp_origins"`
+	// Timeout, in seconds, for registration and login ceremonies. 0 means default (300 seconds)
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Admins and users with these roles must use a security key as second factor for the web UIs
+	RequiredRoles []string `json:"required_roles" mapstructure:"required_roles"`
+}
+
+func (c *WebAuthnConfig) isEnabled() bool {
+	return c.RPID != ""
+}
+
+func (c *WebAuthnConfig) validate() error {
+	if c.RPDisplayName == "" {
+		c.RPDisplayName = "SFTPGo"
+	}
+	if len(c.RPOrigins) == 0 {
+		c.RPOrigins = []string{"https://" + c.RPID}
+	}
+	for _, origin := range c.RPOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("webauthn: invalid origin %q", origin)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("webauthn: invalid timeout %d", c.Timeout)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 300
+	}
+	return nil
+}
+
+func (c *WebAuthnConfig) initialize() error {
+	webAuthn = nil
+	webAuthnRequired = nil
+	if !c.isEnabled() {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	timeout := time.Duration(c.Timeout) * time.Second
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          c.RPID,
+		RPDisplayName: c.RPDisplayName,
+		RPOrigins:     c.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: protocol.VerificationDiscouraged,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login: webauthn.TimeoutConfig{
+				Enforce:    true,
+				Timeout:    timeout,
+				TimeoutUVD: timeout,
+			},
+			Registration: webauthn.TimeoutConfig{
+				Enforce:    true,
+				Timeout:    timeout,
+				TimeoutUVD: timeout,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("webauthn: %w", err)
+	}
+	webAuthn = w
+	webAuthnRequired = make(map[string]bool)
+	for _, role := range c.RequiredRoles {
+		webAuthnRequired[role] = true
+	}
+	return nil
+}
+
+// WebAuthnUser defines an SFTPGo account, user or admin, for the WebAuthn ceremonies
+type WebAuthnUser struct {
+	id          []byte
+	name        string
+	credentials []webauthn.Credential
+}
+
+// NewWebAuthnUser returns a WebAuthn user for the given account.
+// The user handle is derived from the account type and name so users and admins
+// with the same name have different handles
+func NewWebAuthnUser(username string, isAdmin bool, credentials []webauthn.Credential) *WebAuthnUser {
+	accountType := "user"
+	if isAdmin {
+		accountType = "admin"
+	}
+	id := sha256.Sum256([]byte(accountType + ":" + username))
+	return &WebAuthnUser{
+		id:          id[:],
+		name:        username,
+		credentials: credentials,
+	}
+}
+
+// WebAuthnID implements webauthn.User
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	return u.id
+}
+
+// WebAuthnName implements webauthn.User
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.name
+}
+
+// WebAuthnDisplayName implements webauthn.User
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	return u.name
+}
+
+// WebAuthnIcon implements webauthn.User
+func (u *WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials implements webauthn.User
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// IsWebAuthnEnabled returns true if security keys are supported
+func IsWebAuthnEnabled() bool {
+	return webAuthn != nil
+}
+
+// IsWebAuthnRequiredForRole returns true if a security key is required for accounts
+// with the specified role
+func IsWebAuthnRequiredForRole(role string) bool {
+	if role == "" || webAuthn == nil {
+		return false
+	}
+	return webAuthnRequired[role]
+}
+
+// BeginWebAuthnRegistration starts the registration of a new security key for the
+// specified user. The already registered keys are excluded
+func BeginWebAuthnRegistration(user *WebAuthnUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	if webAuthn == nil {
+		return nil, nil, errors.New("webauthn: not enabled")
+	}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, c := range user.credentials {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	return webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation))
+}
+
+// FinishWebAuthnRegistration validates the authenticator response, as JSON body,
+// and returns the new credential
+func FinishWebAuthnRegistration(user *WebAuthnUser, session webauthn.SessionData, body []byte) (*webauthn.Credential, error) {
+	if webAuthn == nil {
+		return nil, errors.New("webauthn: not enabled")
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, getWebAuthnError(err)
+	}
+	credential, err := webAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return nil, getWebAuthnError(err)
+	}
+	return credential, nil
+}
+
+// BeginWebAuthnLogin starts a login ceremony for the specified user
+func BeginWebAuthnLogin(user *WebAuthnUser) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	if webAuthn == nil {
+		return nil, nil, errors.New("webauthn: not enabled")
+	}
+	return webAuthn.BeginLogin(user)
+}
+
+// FinishWebAuthnLogin validates the authenticator assertion, as JSON body,
+// and returns the used credential with the updated sign counter
+func FinishWebAuthnLogin(user *WebAuthnUser, session webauthn.SessionData, body []byte) (*webauthn.Credential, error) {
+	if webAuthn == nil {
+		return nil, errors.New("webauthn: not enabled")
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, getWebAuthnError(err)
+	}
+	credential, err := webAuthn.ValidateLogin(user, session, parsed)
+	if err != nil {
+		return nil, getWebAuthnError(err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return nil, errors.New("webauthn: the signature counter is not valid, the authenticator may be cloned")
+	}
+	return credential, nil
+}
+
+func getWebAuthnError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return fmt.Errorf("webauthn: %s: %s", protocolErr.Details, protocolErr.DevInfo)
+	}
+	return fmt.Errorf("webauthn: %w", err)
+}
@@ -0,0 +1,373 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package radius authenticates users against RADIUS servers using PAP or
+// MS-CHAPv2, Access-Challenge responses are supported
+package radius
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+// Supported authentication types
+const (
+	AuthTypePAP      = "pap"
+	AuthTypeMSCHAPv2 = "mschapv2"
+)
+
+const (
+	defaultPort          = "1812"
+	defaultTimeout       = 5
+	defaultNASIdentifier = "sftpgo"
+	maxChallenges        = 5
+	messageAuthLen       = 16
+)
+
+var (
+	// ErrInvalidCredentials defines the error returned if the RADIUS server
+	// rejects the authentication request
+	ErrInvalidCredentials = errors.New("invalid RADIUS credentials")
+	// ErrChallengeNotSupported defines the error returned if the RADIUS server
+	// sends a challenge and the client is not able to answer
+	ErrChallengeNotSupported = errors.New("RADIUS challenge not supported")
+)
+
+// Challenger asks the user to answer to an Access-Challenge.
+// The message is the Reply-Message sent by the RADIUS server, if any
+type Challenger func(message string) (string, error)
+
+// Config defines a RADIUS server
+type Config struct {
+	// Unique name for this server, the bindings reference it using this name
+	Name string `json:"name" mapstructure:"name"`
+	// Server address as host:port. The default port is 1812
+	Server string `json:"server" mapstructure:"server"`
+	// Shared secret
+	Secret string `json:"secret" mapstructure:"secret"`
+	// Authentication type: "pap" or "mschapv2". Default: "pap".
+	// The responses to Access-Challenge requests are always sent using PAP
+	AuthType string `json:"auth_type" mapstructure:"auth_type"`
+	// Timeout in seconds to wait for a response. Default: 5
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Number of retransmissions if no response is received
+	Retries int `json:"retries" mapstructure:"retries"`
+	// Value for the NAS-Identifier attribute. Default: "sftpgo"
+	NASIdentifier string `json:"nas_identifier" mapstructure:"nas_identifier"`
+	// If enabled the responses without a valid Message-Authenticator attribute are
+	// rejected. Enable this setting if your RADIUS server supports it
+	RequireMessageAuthenticator bool `json:"require_message_authenticator" mapstructure:"require_message_authenticator"`
+	// If enabled users authenticated by the RADIUS server and not existing within
+	// SFTPGo are automatically created. Users are created with a random password and
+	// the home directory inside "users_base_dir"
+	CreateUsers bool `json:"create_users" mapstructure:"create_users"`
+	// Permissions for the root directory of the automatically created users. Default: "*"
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+}
+
+// Validate validates the configuration and sets the default values
+func (c *Config) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return errors.New("radius: name is mandatory")
+	}
+	if c.Server == "" {
+		return fmt.Errorf("radius %q: server is mandatory", c.Name)
+	}
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		c.Server = net.JoinHostPort(c.Server, defaultPort)
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("radius %q: shared secret is mandatory", c.Name)
+	}
+	c.AuthType = strings.ToLower(strings.TrimSpace(c.AuthType))
+	switch c.AuthType {
+	case "":
+		c.AuthType = AuthTypePAP
+	case AuthTypePAP, AuthTypeMSCHAPv2:
+	default:
+		return fmt.Errorf("radius %q: unsupported authentication type %q", c.Name, c.AuthType)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	}
+	if c.NASIdentifier == "" {
+		c.NASIdentifier = defaultNASIdentifier
+	}
+	if len(c.Permissions) == 0 {
+		c.Permissions = []string{"*"}
+	}
+	return nil
+}
+
+// Authenticate checks the given credentials. The client IP is sent as
+// Calling-Station-Id. If the server sends an Access-Challenge the challenger
+// is used to get the user response, a nil challenger means challenges are not
+// supported
+func (c *Config) Authenticate(username, password, ip string, challenger Challenger) error {
+	if username == "" || password == "" {
+		return ErrInvalidCredentials
+	}
+	var state []byte
+	authType := c.AuthType
+	for round := 0; ; round++ {
+		req, mschap, err := c.newRequest(username, password, ip, authType, state)
+		if err != nil {
+			return err
+		}
+		resp, err := c.exchange(req)
+		if err != nil {
+			return err
+		}
+		switch resp.Code {
+		case radius.CodeAccessAccept:
+			if mschap != nil {
+				return mschap.verifySuccess(resp, username, password)
+			}
+			return nil
+		case radius.CodeAccessReject:
+			return ErrInvalidCredentials
+		case radius.CodeAccessChallenge:
+			if challenger == nil {
+				return ErrChallengeNotSupported
+			}
+			if round >= maxChallenges {
+				return fmt.Errorf("too many RADIUS challenges for user %q", username)
+			}
+			state = rfc2865.State_Get(resp)
+			password, err = challenger(getReplyMessage(resp))
+			if err != nil {
+				return err
+			}
+			if password == "" {
+				return ErrInvalidCredentials
+			}
+			authType = AuthTypePAP
+		default:
+			return fmt.Errorf("unexpected RADIUS response code %v", resp.Code)
+		}
+	}
+}
+
+type mschapRequest struct {
+	authenticatorChallenge []byte
+	peerChallenge          []byte
+	ntResponse             []byte
+}
+
+func (m *mschapRequest) verifySuccess(resp *radius.Packet, username, password string) error {
+	success := microsoft.MSCHAP2Success_Get(resp)
+	// the value is the identifier followed by "S=<authenticator response>"
+	if len(success) < 43 {
+		return errors.New("missing or invalid MS-CHAP2-Success attribute")
+	}
+	expected, err := rfc2759.GenerateAuthenticatorResponse(m.authenticatorChallenge, m.peerChallenge, m.ntResponse,
+		getMSCHAPUsername(username), []byte(password))
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(string(success[1:43]), expected) {
+		return errors.New("invalid MS-CHAPv2 authenticator response")
+	}
+	return nil
+}
+
+// getMSCHAPUsername returns the username used to compute the MS-CHAPv2 challenge
+// hash, it must not include the domain, if any
+func getMSCHAPUsername(username string) []byte {
+	if idx := strings.LastIndex(username, `\`); idx >= 0 {
+		username = username[idx+1:]
+	}
+	return []byte(username)
+}
+
+func (c *Config) newRequest(username, password, ip, authType string, state []byte,
+) (*radius.Packet, *mschapRequest, error) {
+	req := radius.New(radius.CodeAccessRequest, []byte(c.Secret))
+	// the Message-Authenticator should be the first attribute, RFC 3579 does
+	// not require this but some recent guidelines do. The value is set once
+	// the packet is complete
+	if err := rfc2869.MessageAuthenticator_Set(req, make([]byte, messageAuthLen)); err != nil {
+		return nil, nil, err
+	}
+	if err := rfc2865.UserName_SetString(req, username); err != nil {
+		return nil, nil, err
+	}
+	if err := rfc2865.ServiceType_Set(req, rfc2865.ServiceType_Value_LoginUser); err != nil {
+		return nil, nil, err
+	}
+	if err := rfc2865.NASIdentifier_SetString(req, c.NASIdentifier); err != nil {
+		return nil, nil, err
+	}
+	if ip != "" {
+		if err := rfc2865.CallingStationID_SetString(req, ip); err != nil {
+			return nil, nil, err
+		}
+	}
+	if state != nil {
+		if err := rfc2865.State_Set(req, state); err != nil {
+			return nil, nil, err
+		}
+	}
+	var mschap *mschapRequest
+	switch authType {
+	case AuthTypeMSCHAPv2:
+		var err error
+		mschap, err = addMSCHAPv2Response(req, username, password)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		if err := rfc2865.UserPassword_SetString(req, password); err != nil {
+			return nil, nil, err
+		}
+	}
+	mac, err := getMessageAuthenticator(req, req.Authenticator)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := rfc2869.MessageAuthenticator_Set(req, mac); err != nil {
+		return nil, nil, err
+	}
+	return req, mschap, nil
+}
+
+func addMSCHAPv2Response(req *radius.Packet, username, password string) (*mschapRequest, error) {
+	mschap := &mschapRequest{
+		authenticatorChallenge: make([]byte, 16),
+		peerChallenge:          make([]byte, 16),
+	}
+	if _, err := rand.Read(mschap.authenticatorChallenge); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(mschap.peerChallenge); err != nil {
+		return nil, err
+	}
+	ntResponse, err := rfc2759.GenerateNTResponse(mschap.authenticatorChallenge, mschap.peerChallenge,
+		getMSCHAPUsername(username), []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	mschap.ntResponse = ntResponse
+	// Ident, Flags, Peer-Challenge, Reserved, NT-Response
+	response := make([]byte, 0, 50)
+	response = append(response, req.Identifier, 0)
+	response = append(response, mschap.peerChallenge...)
+	response = append(response, make([]byte, 8)...)
+	response = append(response, mschap.ntResponse...)
+	if err := microsoft.MSCHAPChallenge_Add(req, mschap.authenticatorChallenge); err != nil {
+		return nil, err
+	}
+	if err := microsoft.MSCHAP2Response_Add(req, response); err != nil {
+		return nil, err
+	}
+	return mschap, nil
+}
+
+func (c *Config) exchange(req *radius.Packet) (*radius.Packet, error) {
+	timeout := time.Duration(c.Timeout) * time.Second
+	client := &radius.Client{
+		Retry:           timeout,
+		MaxPacketErrors: 1,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Duration(c.Retries+1))
+	defer cancel()
+
+	resp, err := client.Exchange(ctx, req, c.Server)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("no response from RADIUS server %q", c.Server)
+		}
+		var nonAuthenticErr *radius.NonAuthenticResponseError
+		if errors.As(err, &nonAuthenticErr) {
+			return nil, fmt.Errorf("invalid response authenticator from RADIUS server %q, please check the shared secret",
+				c.Server)
+		}
+		return nil, err
+	}
+	if err := verifyMessageAuthenticator(resp, req.Authenticator, c.RequireMessageAuthenticator); err != nil {
+		return nil, fmt.Errorf("no valid response from RADIUS server %q: %w", c.Server, err)
+	}
+	return resp, nil
+}
+
+// getMessageAuthenticator returns the HMAC-MD5 of the given packet, as defined
+// in RFC 3579 section 3.2, using the given request authenticator and a zeroed
+// Message-Authenticator attribute
+func getMessageAuthenticator(p *radius.Packet, requestAuthenticator [16]byte) ([]byte, error) {
+	q := *p
+	q.Authenticator = requestAuthenticator
+	q.Attributes = append(radius.Attributes(nil), p.Attributes...)
+	if err := rfc2869.MessageAuthenticator_Set(&q, make([]byte, messageAuthLen)); err != nil {
+		return nil, err
+	}
+	data, err := q.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(md5.New, p.Secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// verifyMessageAuthenticator checks the Message-Authenticator of the response,
+// if present, for the request with the given authenticator
+func verifyMessageAuthenticator(resp *radius.Packet, requestAuthenticator [16]byte, required bool) error {
+	values, err := rfc2869.MessageAuthenticator_Gets(resp)
+	if err != nil {
+		return err
+	}
+	switch len(values) {
+	case 0:
+		if required {
+			return errors.New("the response does not contain a message authenticator")
+		}
+		return nil
+	case 1:
+	default:
+		return errors.New("the response contains multiple message authenticators")
+	}
+	if len(values[0]) != messageAuthLen {
+		return errors.New("invalid message authenticator length")
+	}
+	expected, err := getMessageAuthenticator(resp, requestAuthenticator)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, values[0]) {
+		return errors.New("invalid message authenticator")
+	}
+	return nil
+}
+
+func getReplyMessage(resp *radius.Packet) string {
+	messages, _ := rfc2865.ReplyMessage_GetStrings(resp)
+	return strings.Join(messages, "\n")
+}
@@ -0,0 +1,343 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	testSecret   = "testing123"
+	testUsername = "radiususer"
+	testPassword = "radiuspwd"
+	testPIN      = "1234"
+	testOTP      = "987654"
+)
+
+// mockServer answers using the given mode, the received requests and the
+// result of their Message-Authenticator validation are recorded
+type mockServer struct {
+	conn                    net.PacketConn
+	noMessageAuthenticator  atomic.Bool
+	badMessageAuthenticator atomic.Bool
+	badMSCHAPSuccess        atomic.Bool
+	requests                chan *mockRequest
+}
+
+type mockRequest struct {
+	packet           *radius.Packet
+	messageAuthError error
+}
+
+func startMockServer(t *testing.T, secret string) *mockServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &mockServer{
+		conn:     conn,
+		requests: make(chan *mockRequest, 100),
+	}
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		Handler:      radius.HandlerFunc(s.handle),
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go server.Serve(conn) //nolint:errcheck
+	return s
+}
+
+// lastRequest returns the last received request and discards the previous ones
+func (s *mockServer) lastRequest() *mockRequest {
+	var req *mockRequest
+	for {
+		select {
+		case req = <-s.requests:
+		default:
+			return req
+		}
+	}
+}
+
+func (s *mockServer) handle(w radius.ResponseWriter, r *radius.Request) {
+	select {
+	case s.requests <- &mockRequest{
+		packet:           r.Packet,
+		messageAuthError: verifyMessageAuthenticator(r.Packet, r.Authenticator, true),
+	}:
+	default:
+	}
+	username := rfc2865.UserName_GetString(r.Packet)
+	if username == "silent" {
+		return
+	}
+	resp := s.getResponse(r.Packet, username)
+	if !s.noMessageAuthenticator.Load() {
+		if err := rfc2869.MessageAuthenticator_Set(resp, make([]byte, messageAuthLen)); err != nil {
+			return
+		}
+		mac, err := getMessageAuthenticator(resp, r.Authenticator)
+		if err != nil {
+			return
+		}
+		if s.badMessageAuthenticator.Load() {
+			mac[0] ^= 0xff
+		}
+		if err := rfc2869.MessageAuthenticator_Set(resp, mac); err != nil {
+			return
+		}
+	}
+	w.Write(resp) //nolint:errcheck
+}
+
+func (s *mockServer) getResponse(req *radius.Packet, username string) *radius.Packet {
+	resp := req.Response(radius.CodeAccessReject)
+	if _, ok := req.Lookup(rfc2865.UserPassword_Type); ok {
+		switch password := rfc2865.UserPassword_GetString(req); {
+		case password == testPassword:
+			resp.Code = radius.CodeAccessAccept
+		case password == testPIN:
+			resp.Code = radius.CodeAccessChallenge
+			rfc2865.State_SetString(resp, "state1")                //nolint:errcheck
+			rfc2865.ReplyMessage_AddString(resp, "Enter your OTP") //nolint:errcheck
+		case password == testOTP && rfc2865.State_GetString(req) == "state1":
+			resp.Code = radius.CodeAccessAccept
+		}
+		return resp
+	}
+	challenge := microsoft.MSCHAPChallenge_Get(req)
+	response := microsoft.MSCHAP2Response_Get(req)
+	if len(challenge) != 16 || len(response) != 50 {
+		return resp
+	}
+	peerChallenge := response[2:18]
+	ntResponse := response[26:50]
+	expected, err := rfc2759.GenerateNTResponse(challenge, peerChallenge, []byte(username), []byte(testPassword))
+	if err != nil || !bytes.Equal(ntResponse, expected) {
+		return resp
+	}
+	password := testPassword
+	if s.badMSCHAPSuccess.Load() {
+		password = "wrong"
+	}
+	authResponse, err := rfc2759.GenerateAuthenticatorResponse(challenge, peerChallenge, ntResponse, []byte(username),
+		[]byte(password))
+	if err != nil {
+		return resp
+	}
+	resp.Code = radius.CodeAccessAccept
+	microsoft.MSCHAP2Success_Add(resp, append([]byte{response[0]}, []byte(authResponse)...)) //nolint:errcheck
+	return resp
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestMSCHAPv2(t *testing.T) {
+	// test vectors from RFC 2759 section 9.2
+	username := "User"
+	password := "clientPass"
+	m := &mschapRequest{
+		authenticatorChallenge: mustDecodeHex(t, "5B5D7C7D7B3F2F3E3C2C602132262628"),
+		peerChallenge:          mustDecodeHex(t, "21402324255E262A28295F2B3A337C7E"),
+		ntResponse:             mustDecodeHex(t, "82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF"),
+	}
+	assert.Equal(t, []byte(username), getMSCHAPUsername(username))
+	assert.Equal(t, []byte(username), getMSCHAPUsername(`DOMAIN\`+username))
+
+	resp := radius.New(radius.CodeAccessAccept, []byte(testSecret))
+	assert.ErrorContains(t, m.verifySuccess(resp, username, password), "missing or invalid MS-CHAP2-Success")
+	err := microsoft.MSCHAP2Success_Add(resp, []byte("\x00S=407a5589115fd0d6209f510fe9c04566932cda56"))
+	require.NoError(t, err)
+	assert.NoError(t, m.verifySuccess(resp, username, password))
+	assert.NoError(t, m.verifySuccess(resp, `DOMAIN\`+username, password))
+	assert.ErrorContains(t, m.verifySuccess(resp, username, "wrong"), "invalid MS-CHAPv2 authenticator")
+}
+
+func TestMessageAuthenticator(t *testing.T) {
+	c := Config{
+		Name:   "test",
+		Server: "127.0.0.1",
+		Secret: testSecret,
+	}
+	require.NoError(t, c.Validate())
+	req, _, err := c.newRequest(testUsername, testPassword, "", AuthTypePAP, nil)
+	require.NoError(t, err)
+	// the Message-Authenticator is the first attribute and it is computed on
+	// the wire data with the attribute value set to zero
+	require.Equal(t, rfc2869.MessageAuthenticator_Type, req.Attributes[0].Type)
+	data, err := req.Encode()
+	require.NoError(t, err)
+	received := bytes.Clone(data[22:38])
+	copy(data[22:38], make([]byte, messageAuthLen))
+	mac := hmac.New(md5.New, []byte(testSecret))
+	mac.Write(data)
+	assert.Equal(t, mac.Sum(nil), received)
+	assert.NoError(t, verifyMessageAuthenticator(req, req.Authenticator, true))
+	// a different request authenticator
+	var authenticator [16]byte
+	assert.ErrorContains(t, verifyMessageAuthenticator(req, authenticator, true), "invalid message authenticator")
+	// a different secret
+	req.Secret = []byte("wrong secret")
+	assert.ErrorContains(t, verifyMessageAuthenticator(req, req.Authenticator, true), "invalid message authenticator")
+	req.Secret = []byte(testSecret)
+	// an attribute modified after signing
+	err = rfc2865.NASIdentifier_SetString(req, "modified")
+	require.NoError(t, err)
+	assert.ErrorContains(t, verifyMessageAuthenticator(req, req.Authenticator, true), "invalid message authenticator")
+
+	resp := req.Response(radius.CodeAccessAccept)
+	assert.NoError(t, verifyMessageAuthenticator(resp, req.Authenticator, false))
+	assert.ErrorContains(t, verifyMessageAuthenticator(resp, req.Authenticator, true), "does not contain")
+	err = rfc2869.MessageAuthenticator_Set(resp, make([]byte, 8))
+	require.NoError(t, err)
+	assert.ErrorContains(t, verifyMessageAuthenticator(resp, req.Authenticator, false), "invalid message authenticator length")
+	value, err := getMessageAuthenticator(resp, req.Authenticator)
+	require.NoError(t, err)
+	err = rfc2869.MessageAuthenticator_Set(resp, value)
+	require.NoError(t, err)
+	assert.NoError(t, verifyMessageAuthenticator(resp, req.Authenticator, true))
+	err = rfc2869.MessageAuthenticator_Add(resp, value)
+	require.NoError(t, err)
+	assert.ErrorContains(t, verifyMessageAuthenticator(resp, req.Authenticator, false), "multiple message authenticators")
+}
+
+func TestValidate(t *testing.T) {
+	c := Config{}
+	assert.Error(t, c.Validate())
+	c.Name = "test"
+	assert.Error(t, c.Validate())
+	c.Server = "127.0.0.1"
+	assert.Error(t, c.Validate())
+	c.Secret = testSecret
+	c.AuthType = "chap"
+	assert.Error(t, c.Validate())
+	c.AuthType = " MSCHAPv2"
+	c.Retries = -1
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, "127.0.0.1:1812", c.Server)
+	assert.Equal(t, AuthTypeMSCHAPv2, c.AuthType)
+	assert.Equal(t, defaultTimeout, c.Timeout)
+	assert.Equal(t, 0, c.Retries)
+	assert.Equal(t, defaultNASIdentifier, c.NASIdentifier)
+	assert.Equal(t, []string{"*"}, c.Permissions)
+	c.AuthType = ""
+	c.Server = "[::1]:1645"
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, AuthTypePAP, c.AuthType)
+	assert.Equal(t, "[::1]:1645", c.Server)
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := startMockServer(t, testSecret)
+	c := Config{
+		Name:    "test",
+		Server:  s.conn.LocalAddr().String(),
+		Secret:  testSecret,
+		Timeout: 1,
+	}
+	require.NoError(t, c.Validate())
+
+	assert.ErrorIs(t, c.Authenticate(testUsername, "", "", nil), ErrInvalidCredentials)
+	assert.NoError(t, c.Authenticate(testUsername, testPassword, "192.168.1.2", nil))
+	req := s.lastRequest()
+	require.NotNil(t, req)
+	assert.Equal(t, "192.168.1.2", rfc2865.CallingStationID_GetString(req.packet))
+	assert.Equal(t, defaultNASIdentifier, rfc2865.NASIdentifier_GetString(req.packet))
+	assert.Equal(t, rfc2865.ServiceType_Value_LoginUser, rfc2865.ServiceType_Get(req.packet))
+	assert.NoError(t, req.messageAuthError)
+	assert.ErrorIs(t, c.Authenticate(testUsername, "wrong", "", nil), ErrInvalidCredentials)
+	assert.Error(t, c.Authenticate(testUsername, strings.Repeat("a", 129), "", nil))
+	// challenge
+	assert.ErrorIs(t, c.Authenticate(testUsername, testPIN, "", nil), ErrChallengeNotSupported)
+	var challengeMessage string
+	err := c.Authenticate(testUsername, testPIN, "", func(message string) (string, error) {
+		challengeMessage = message
+		return testOTP, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Enter your OTP", challengeMessage)
+	req = s.lastRequest()
+	require.NotNil(t, req)
+	assert.Equal(t, "state1", rfc2865.State_GetString(req.packet))
+	assert.NoError(t, req.messageAuthError)
+	err = c.Authenticate(testUsername, testPIN, "", func(message string) (string, error) {
+		return "", nil
+	})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	err = c.Authenticate(testUsername, testPIN, "", func(message string) (string, error) {
+		return "", errors.New("client error")
+	})
+	assert.ErrorContains(t, err, "client error")
+	err = c.Authenticate(testUsername, testPIN, "", func(message string) (string, error) {
+		return testPIN, nil
+	})
+	assert.ErrorContains(t, err, "too many RADIUS challenges")
+	// MS-CHAPv2
+	c.AuthType = AuthTypeMSCHAPv2
+	assert.NoError(t, c.Authenticate(testUsername, testPassword, "", nil))
+	req = s.lastRequest()
+	require.NotNil(t, req)
+	_, ok := req.packet.Lookup(rfc2865.UserPassword_Type)
+	assert.False(t, ok)
+	assert.NoError(t, req.messageAuthError)
+	assert.ErrorIs(t, c.Authenticate(testUsername, "wrong", "", nil), ErrInvalidCredentials)
+	s.badMSCHAPSuccess.Store(true)
+	assert.ErrorContains(t, c.Authenticate(testUsername, testPassword, "", nil), "invalid MS-CHAPv2 authenticator")
+	s.badMSCHAPSuccess.Store(false)
+	// Message-Authenticator
+	c.AuthType = AuthTypePAP
+	s.noMessageAuthenticator.Store(true)
+	assert.NoError(t, c.Authenticate(testUsername, testPassword, "", nil))
+	c.RequireMessageAuthenticator = true
+	assert.ErrorContains(t, c.Authenticate(testUsername, testPassword, "", nil), "does not contain a message authenticator")
+	s.noMessageAuthenticator.Store(false)
+	assert.NoError(t, c.Authenticate(testUsername, testPassword, "", nil))
+	// an invalid Message-Authenticator is rejected even if it is not required
+	s.badMessageAuthenticator.Store(true)
+	assert.ErrorContains(t, c.Authenticate(testUsername, testPassword, "", nil), "invalid message authenticator")
+	c.RequireMessageAuthenticator = false
+	assert.ErrorContains(t, c.Authenticate(testUsername, testPassword, "", nil), "invalid message authenticator")
+	s.badMessageAuthenticator.Store(false)
+	// wrong secret
+	c.Secret = "wrong secret"
+	assert.ErrorContains(t, c.Authenticate(testUsername, testPassword, "", nil), "invalid response authenticator")
+	req = s.lastRequest()
+	require.NotNil(t, req)
+	assert.ErrorContains(t, req.messageAuthError, "invalid message authenticator")
+	// no response
+	c.Secret = testSecret
+	c.Retries = 1
+	assert.ErrorContains(t, c.Authenticate("silent", testPassword, "", nil), "no response")
+}
@@ -0,0 +1,1167 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	s3Namespace       = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat      = "2006-01-02T15:04:05.000Z"
+	defaultMaxKeys    = 1000
+	maxXMLBodySize    = 1024 * 1024
+	storageClass      = "STANDARD"
+	contentTypeBinary = "application/octet-stream"
+)
+
+// subresources we don't support, a request including one of them is rejected
+// so the client doesn't assume the operation succeeded
+var unsupportedSubresources = []string{"accelerate", "acl", "analytics", "attributes", "cors", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification",
+	"object-lock", "ownershipControls", "policy", "policyStatus", "publicAccessBlock", "replication",
+	"requestPayment", "restore", "retention", "select", "tagging", "torrent", "versionId", "versioning",
+	"versions", "website"}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type locationConstraint struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type objectInfo struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *owner `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResultV2 struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []objectInfo   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listBucketResultV1 struct {
+	XMLName        xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	Contents       []objectInfo   `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	Parts []completePart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// listEntry is an object or a common prefix returned within a listing
+type listEntry struct {
+	key      string
+	info     os.FileInfo
+	isPrefix bool
+}
+
+// listParams defines the parameters for listing the objects in a bucket
+type listParams struct {
+	prefix       string
+	delimiter    string
+	startAfter   string
+	maxKeys      int
+	encodingType string
+}
+
+func (p *listParams) encode(s string) string {
+	if p.encodingType == "" {
+		return s
+	}
+	return awsURIEncode(s, false)
+}
+
+// s3Request holds the state for an authenticated request
+type s3Request struct {
+	server     *s3Server
+	w          http.ResponseWriter
+	r          *http.Request
+	conn       *Connection
+	sig        *signature
+	requestID  string
+	startTime  time.Time
+	respStatus int
+}
+
+func (req *s3Request) handle() {
+	bucket, key, err := parseRequestPath(req.r.URL.Path)
+	if err != nil {
+		req.sendError(err, errInternalError)
+		return
+	}
+	query := req.r.URL.Query()
+	for _, name := range unsupportedSubresources {
+		if query.Has(name) {
+			req.sendError(errNotImplemented, errNotImplemented)
+			return
+		}
+	}
+	switch {
+	case bucket == "":
+		if req.r.Method != http.MethodGet {
+			req.sendError(errMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		req.listBuckets()
+	case key == "":
+		req.handleBucket(bucket, query)
+	default:
+		req.handleObject(bucket, key, query)
+	}
+}
+
+func (req *s3Request) handleBucket(bucket string, query url.Values) {
+	switch req.r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			req.getBucketLocation(bucket)
+		case query.Has("uploads"):
+			req.sendError(errNotImplemented, errNotImplemented)
+		case query.Get("list-type") == "2":
+			req.listObjectsV2(bucket, query)
+		default:
+			req.listObjectsV1(bucket, query)
+		}
+	case http.MethodHead:
+		if err := req.checkBucket(bucket); err != nil {
+			req.sendError(err, errNoSuchBucket)
+			return
+		}
+		req.sendStatus(http.StatusOK)
+	case http.MethodPut:
+		req.createBucket(bucket)
+	case http.MethodDelete:
+		req.deleteBucket(bucket)
+	case http.MethodPost:
+		if query.Has("delete") {
+			req.sendError(errNotImplemented, errNotImplemented)
+			return
+		}
+		req.sendError(errMethodNotAllowed, errMethodNotAllowed)
+	default:
+		req.sendError(errMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+func (req *s3Request) handleObject(bucket, key string, query url.Values) {
+	hasUploadID := query.Has("uploadId")
+	switch req.r.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasUploadID {
+			req.sendError(errNotImplemented, errNotImplemented)
+			return
+		}
+		req.getObject(bucket, key)
+	case http.MethodPut:
+		if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+			req.sendError(errNotImplemented, errNotImplemented)
+			return
+		}
+		if hasUploadID {
+			req.uploadPart(bucket, key, query)
+			return
+		}
+		req.putObject(bucket, key)
+	case http.MethodDelete:
+		if hasUploadID {
+			req.abortMultipartUpload(bucket, key, query.Get("uploadId"))
+			return
+		}
+		req.deleteObject(bucket, key)
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			req.createMultipartUpload(bucket, key)
+		case hasUploadID:
+			req.completeMultipartUpload(bucket, key, query.Get("uploadId"))
+		default:
+			req.sendError(errMethodNotAllowed, errMethodNotAllowed)
+		}
+	default:
+		req.sendError(errMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+func (req *s3Request) sendError(err error, notExistErr apiError) {
+	apiErr := getAPIError(err, notExistErr)
+	if apiErr.StatusCode >= http.StatusInternalServerError {
+		req.conn.Log(logger.LevelError, "request %s %q failed: %v", req.r.Method, req.r.URL.Path, err)
+	}
+	writeError(req.w, req.r, req.requestID, req.startTime, apiErr)
+}
+
+func (req *s3Request) sendXML(v any) {
+	writeLog(req.r, req.requestID, req.startTime, http.StatusOK, nil)
+	writeXML(req.w, http.StatusOK, v)
+}
+
+func (req *s3Request) sendStatus(status int) {
+	writeLog(req.r, req.requestID, req.startTime, status, nil)
+	req.w.WriteHeader(status)
+}
+
+// checkBucket returns an error if the specified bucket does not exist
+func (req *s3Request) checkBucket(bucket string) error {
+	info, err := req.conn.DoStat(getBucketPath(bucket), 0, false)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errNoSuchBucket
+	}
+	return nil
+}
+
+// getNotExistError returns the error to use if the requested object does not
+// exist, it depends on the bucket existence
+func (req *s3Request) getNotExistError(bucket string) apiError {
+	if err := req.checkBucket(bucket); err != nil && req.conn.IsNotExistError(err) {
+		return errNoSuchBucket
+	}
+	return errNoSuchKey
+}
+
+func (req *s3Request) getOwner() owner {
+	return owner{
+		ID:          req.conn.User.Username,
+		DisplayName: req.conn.User.Username,
+	}
+}
+
+// getPayloadReader returns a reader for the request body that verifies the
+// payload signature, the content length and the Content-MD5 header, if any
+func (req *s3Request) getPayloadReader() (*contentReader, error) {
+	reader, err := req.sig.getPayloadReader(req.r.Body)
+	if err != nil {
+		return nil, err
+	}
+	size := req.r.ContentLength
+	if req.sig.isStreaming() {
+		size = -1
+		if decodedLength := req.r.Header.Get("X-Amz-Decoded-Content-Length"); decodedLength != "" {
+			size, err = strconv.ParseInt(decodedLength, 10, 64)
+			if err != nil || size < 0 {
+				return nil, errInvalidArgument
+			}
+		}
+	}
+	var expectedMD5 []byte
+	if contentMD5 := req.r.Header.Get("Content-Md5"); contentMD5 != "" {
+		expectedMD5, err = base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expectedMD5) != md5.Size {
+			return nil, errInvalidDigest
+		}
+	}
+	return &contentReader{
+		reader:         reader,
+		hash:           md5.New(),
+		expectedSize:   size,
+		expectedMD5:    expectedMD5,
+		expectedSHA256: req.sig.getPayloadSHA256(),
+	}, nil
+}
+
+func (req *s3Request) readXMLBody(v any) error {
+	reader, err := req.getPayloadReader()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxXMLBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBodySize {
+		return errMalformedXML
+	}
+	if v == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+func (req *s3Request) listBuckets() {
+	files, err := req.conn.ListDir("/")
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	result := listAllMyBucketsResult{
+		Owner:   req.getOwner(),
+		Buckets: []bucketInfo{},
+	}
+	for _, info := range files {
+		if !info.IsDir() {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketInfo{
+			Name:         info.Name(),
+			CreationDate: info.ModTime().UTC().Format(s3TimeFormat),
+		})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Name < result.Buckets[j].Name
+	})
+	req.sendXML(result)
+}
+
+func (req *s3Request) getBucketLocation(bucket string) {
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	result := locationConstraint{}
+	// an empty location means us-east-1
+	if req.server.config.Region != defaultRegion {
+		result.Location = req.server.config.Region
+	}
+	req.sendXML(result)
+}
+
+func (req *s3Request) createBucket(bucket string) {
+	// the body can contain the bucket location, it is ignored
+	if err := req.readXMLBody(nil); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	bucketPath := getBucketPath(bucket)
+	info, err := req.conn.DoStat(bucketPath, 0, false)
+	if err == nil {
+		if info.IsDir() {
+			req.sendError(errBucketAlreadyOwnedByYou, errNoSuchBucket)
+			return
+		}
+		req.sendError(errInvalidBucket, errNoSuchBucket)
+		return
+	}
+	if !req.conn.IsNotExistError(err) {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	if err := req.conn.CreateDir(bucketPath, true); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	req.w.Header().Set("Location", bucketPath)
+	req.sendStatus(http.StatusOK)
+}
+
+func (req *s3Request) deleteBucket(bucket string) {
+	bucketPath := getBucketPath(bucket)
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	files, err := req.conn.ListDir(bucketPath)
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	if len(files) > 0 {
+		req.sendError(errBucketNotEmpty, errNoSuchBucket)
+		return
+	}
+	if err := req.conn.RemoveDir(bucketPath); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	req.sendStatus(http.StatusNoContent)
+}
+
+func (req *s3Request) getListParams(query url.Values) (listParams, error) {
+	params := listParams{
+		prefix:       query.Get("prefix"),
+		delimiter:    query.Get("delimiter"),
+		maxKeys:      defaultMaxKeys,
+		encodingType: query.Get("encoding-type"),
+	}
+	if params.encodingType != "" && params.encodingType != "url" {
+		return params, errInvalidArgument
+	}
+	if query.Has("max-keys") {
+		maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+		if err != nil || maxKeys < 0 {
+			return params, errInvalidArgument
+		}
+		if maxKeys < defaultMaxKeys {
+			params.maxKeys = maxKeys
+		}
+	}
+	return params, nil
+}
+
+func (req *s3Request) listObjectsV2(bucket string, query url.Values) {
+	params, err := req.getListParams(query)
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	continuationToken := query.Get("continuation-token")
+	params.startAfter = query.Get("start-after")
+	if continuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(continuationToken)
+		if err != nil {
+			req.sendError(errInvalidArgument, errNoSuchBucket)
+			return
+		}
+		params.startAfter = string(token)
+	}
+	entries, isTruncated, err := req.listObjects(bucket, params)
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	result := listBucketResultV2{
+		Name:              bucket,
+		Prefix:            params.encode(params.prefix),
+		Delimiter:         params.encode(params.delimiter),
+		MaxKeys:           params.maxKeys,
+		KeyCount:          len(entries),
+		IsTruncated:       isTruncated,
+		ContinuationToken: continuationToken,
+		StartAfter:        params.encode(query.Get("start-after")),
+		EncodingType:      params.encodingType,
+	}
+	if isTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+	}
+	result.Contents, result.CommonPrefixes = req.getListResults(bucket, entries, params,
+		query.Get("fetch-owner") == "true")
+	req.sendXML(result)
+}
+
+func (req *s3Request) listObjectsV1(bucket string, query url.Values) {
+	params, err := req.getListParams(query)
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	params.startAfter = query.Get("marker")
+	entries, isTruncated, err := req.listObjects(bucket, params)
+	if err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	result := listBucketResultV1{
+		Name:         bucket,
+		Prefix:       params.encode(params.prefix),
+		Marker:       params.encode(params.startAfter),
+		Delimiter:    params.encode(params.delimiter),
+		MaxKeys:      params.maxKeys,
+		IsTruncated:  isTruncated,
+		EncodingType: params.encodingType,
+	}
+	if isTruncated {
+		result.NextMarker = params.encode(entries[len(entries)-1].key)
+	}
+	result.Contents, result.CommonPrefixes = req.getListResults(bucket, entries, params, true)
+	req.sendXML(result)
+}
+
+func (req *s3Request) getListResults(bucket string, entries []listEntry, params listParams,
+	fetchOwner bool,
+) ([]objectInfo, []commonPrefix) {
+	var contents []objectInfo
+	var prefixes []commonPrefix
+
+	for _, entry := range entries {
+		if entry.isPrefix {
+			prefixes = append(prefixes, commonPrefix{Prefix: params.encode(entry.key)})
+			continue
+		}
+		obj := objectInfo{
+			Key:          params.encode(entry.key),
+			LastModified: entry.info.ModTime().UTC().Format(s3TimeFormat),
+			ETag:         getSyntheticETag(getObjectPath(bucket, entry.key), entry.info),
+			Size:         entry.info.Size(),
+			StorageClass: storageClass,
+		}
+		if fetchOwner {
+			o := req.getOwner()
+			obj.Owner = &o
+		}
+		contents = append(contents, obj)
+	}
+	return contents, prefixes
+}
+
+// listObjects returns the sorted objects and common prefixes, after the
+// configured start key, and a boolean indicating if the result is truncated
+func (req *s3Request) listObjects(bucket string, params listParams) ([]listEntry, bool, error) {
+	if err := req.checkBucket(bucket); err != nil {
+		return nil, false, err
+	}
+	if params.maxKeys == 0 {
+		return nil, false, nil
+	}
+	var baseDir string
+	if idx := strings.LastIndex(params.prefix, "/"); idx >= 0 {
+		baseDir = params.prefix[:idx+1]
+	}
+	var entries []listEntry
+	if params.delimiter == "/" {
+		files, err := req.listDir(bucket, baseDir)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, info := range files {
+			key := baseDir + info.Name()
+			if !strings.HasPrefix(key, params.prefix) {
+				continue
+			}
+			if info.IsDir() {
+				entries = append(entries, listEntry{key: key + "/", isPrefix: true})
+			} else {
+				entries = append(entries, listEntry{key: key, info: info})
+			}
+		}
+	} else {
+		files, err := req.walkDir(bucket, baseDir, params.prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = groupByDelimiter(files, params.prefix, params.delimiter)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	if params.startAfter != "" {
+		idx := sort.Search(len(entries), func(i int) bool {
+			return entries[i].key > params.startAfter
+		})
+		entries = entries[idx:]
+	}
+	if len(entries) > params.maxKeys {
+		return entries[:params.maxKeys], true, nil
+	}
+	return entries, false, nil
+}
+
+// listDir lists the specified directory relative to the bucket. A missing
+// directory is not an error, it means no matching objects
+func (req *s3Request) listDir(bucket, dir string) ([]os.FileInfo, error) {
+	files, err := req.conn.ListDir(getObjectPath(bucket, dir))
+	if err != nil {
+		if req.conn.IsNotExistError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// walkDir returns the files, recursively, inside the specified directory
+// relative to the bucket, with a key matching the specified prefix.
+// Directories that cannot be listed below the starting one are skipped
+func (req *s3Request) walkDir(bucket, dir, prefix string) ([]listEntry, error) {
+	var result []listEntry
+
+	files, err := req.listDir(bucket, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range files {
+		key := dir + info.Name()
+		if info.IsDir() {
+			if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				continue
+			}
+			entries, err := req.walkDir(bucket, key+"/", prefix)
+			if err != nil {
+				req.conn.Log(logger.LevelDebug, "unable to list directory %q: %v", key, err)
+				continue
+			}
+			result = append(result, entries...)
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, listEntry{key: key, info: info})
+		}
+	}
+	return result, nil
+}
+
+// groupByDelimiter rolls up the keys containing the delimiter, after the
+// prefix, into common prefixes
+func groupByDelimiter(files []listEntry, prefix, delimiter string) []listEntry {
+	if delimiter == "" {
+		return files
+	}
+	var result []listEntry
+	prefixes := make(map[string]bool)
+	for _, entry := range files {
+		idx := strings.Index(entry.key[len(prefix):], delimiter)
+		if idx < 0 {
+			result = append(result, entry)
+			continue
+		}
+		commonPrefix := entry.key[:len(prefix)+idx+len(delimiter)]
+		if !prefixes[commonPrefix] {
+			prefixes[commonPrefix] = true
+			result = append(result, listEntry{key: commonPrefix, isPrefix: true})
+		}
+	}
+	return result
+}
+
+func (req *s3Request) getObject(bucket, key string) {
+	objectPath := getObjectPath(bucket, key)
+	if !req.conn.User.HasPerm(dataprovider.PermListItems, path.Dir(objectPath)) {
+		req.sendError(req.conn.GetPermissionDeniedError(), errNoSuchKey)
+		return
+	}
+	info, err := req.conn.DoStat(objectPath, 0, true)
+	if err != nil {
+		req.sendError(err, req.getNotExistError(bucket))
+		return
+	}
+	// keys ending with a slash are directories
+	if info.IsDir() != strings.HasSuffix(key, "/") {
+		req.sendError(errNoSuchKey, errNoSuchKey)
+		return
+	}
+	req.w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	req.w.Header().Set("ETag", req.getETag(objectPath, info))
+	if info.IsDir() {
+		req.w.Header().Set("Content-Type", "application/x-directory")
+		req.w.Header().Set("Content-Length", "0")
+		req.sendStatus(http.StatusOK)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(objectPath))
+	if contentType == "" {
+		contentType = contentTypeBinary
+	}
+	req.w.Header().Set("Content-Type", contentType)
+	req.w.Header().Set("Accept-Ranges", "bytes")
+
+	size := info.Size()
+	offset, length, isRange, err := parseRange(req.r.Header.Get("Range"), size)
+	if err != nil {
+		req.w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	status := http.StatusOK
+	if isRange {
+		status = http.StatusPartialContent
+		req.w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	}
+	if req.r.Method == http.MethodHead {
+		// we don't open the file, it could start a download from a cloud backend
+		if err := req.checkReadPermissions(objectPath); err != nil {
+			req.sendError(err, errNoSuchKey)
+			return
+		}
+		req.w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		req.sendStatus(status)
+		return
+	}
+	reader, err := req.conn.getFileReader(objectPath, offset, req.r.Method)
+	if err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	reader.SetExpectedSize(length)
+	req.w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	writeLog(req.r, req.requestID, req.startTime, status, nil)
+	req.w.WriteHeader(status)
+	_, err = io.CopyN(req.w, reader, length)
+	if errClose := reader.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		req.conn.Log(logger.LevelDebug, "error sending object %q: %v", objectPath, err)
+	}
+}
+
+func (req *s3Request) putObject(bucket, key string) {
+	objectPath := getObjectPath(bucket, key)
+	reader, err := req.getPayloadReader()
+	if err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		// an empty object ending with a slash creates a directory
+		if n, _ := io.Copy(io.Discard, io.LimitReader(reader, 1)); n > 0 {
+			req.sendError(errInvalidArgument, errNoSuchKey)
+			return
+		}
+		if err := req.conn.CheckParentDirs(objectPath); err != nil {
+			req.sendError(err, errNoSuchKey)
+			return
+		}
+		req.w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(reader.hash.Sum(nil))))
+		req.sendStatus(http.StatusOK)
+		return
+	}
+	if reader.expectedMD5 != nil && reader.expectedSHA256 != nil && reader.expectedSize >= 0 &&
+		req.conn.IsIdenticalUpload(objectPath, reader.expectedSize, map[string][]byte{
+			vfs.ChecksumMD5:    reader.expectedMD5,
+			vfs.ChecksumSHA256: reader.expectedSHA256,
+		}) {
+		// the data are not read, the client gets the reply before sending the whole body
+		req.w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(reader.expectedMD5)))
+		req.sendStatus(http.StatusOK)
+		return
+	}
+	if err := req.conn.CheckParentDirs(path.Dir(objectPath)); err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	if err := req.writeObject(objectPath, reader); err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	req.w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(reader.hash.Sum(nil))))
+	req.sendStatus(http.StatusOK)
+}
+
+// writeObject writes the data read from the specified reader to the specified
+// path. The upload is discarded if the reader returns an error
+func (req *s3Request) writeObject(objectPath string, reader io.Reader) error {
+	writer, err := req.conn.getFileWriter(objectPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		writer.TransferError(err)
+		writer.Close() //nolint:errcheck
+		return err
+	}
+	return writer.Close()
+}
+
+func (req *s3Request) deleteObject(bucket, key string) {
+	objectPath := getObjectPath(bucket, key)
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	var err error
+	if strings.HasSuffix(key, "/") {
+		err = req.removeEmptyDir(objectPath)
+	} else {
+		err = req.conn.removeObject(objectPath)
+	}
+	// deleting a missing object is not an error
+	if err != nil && !req.conn.IsNotExistError(err) {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	req.sendStatus(http.StatusNoContent)
+}
+
+func (req *s3Request) removeEmptyDir(dirPath string) error {
+	files, err := req.conn.ListDir(dirPath)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return errDirectoryNotEmpty
+	}
+	return req.conn.RemoveDir(dirPath)
+}
+
+func (req *s3Request) createMultipartUpload(bucket, key string) {
+	objectPath := getObjectPath(bucket, key)
+	if strings.HasSuffix(key, "/") {
+		req.sendError(errInvalidArgument, errNoSuchKey)
+		return
+	}
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	if ok, _ := req.conn.User.IsFileAllowed(objectPath); !ok {
+		req.conn.Log(logger.LevelWarn, "writing file %q is not allowed", objectPath)
+		req.sendError(req.conn.GetPermissionDeniedError(), errNoSuchKey)
+		return
+	}
+	if !req.conn.User.HasAnyPerm([]string{dataprovider.PermUpload, dataprovider.PermOverwrite}, path.Dir(objectPath)) {
+		req.sendError(req.conn.GetPermissionDeniedError(), errNoSuchKey)
+		return
+	}
+	upload, err := multipartMgr.create(req.conn.User.Username, objectPath)
+	if err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	req.conn.Log(logger.LevelDebug, "multipart upload %q created for path %q", upload.ID, objectPath)
+	req.sendXML(initiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: upload.ID,
+	})
+}
+
+func (req *s3Request) uploadPart(bucket, key string, query url.Values) {
+	objectPath := getObjectPath(bucket, key)
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		req.sendError(errInvalidArgument, errNoSuchUpload)
+		return
+	}
+	reader, err := req.getPayloadReader()
+	if err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	upload, err := multipartMgr.get(query.Get("uploadId"), req.conn.User.Username, objectPath)
+	if err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	diskQuota, transferQuota := req.conn.HasSpace(true, false, objectPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		req.conn.Log(logger.LevelInfo, "denying multipart upload part due to quota limits")
+		req.sendError(common.ErrQuotaExceeded, errNoSuchUpload)
+		return
+	}
+	maxSize := int64(maxPartSize)
+	if maxWriteSize, _ := req.conn.GetMaxWriteSize(diskQuota, false, 0, false); maxWriteSize > 0 && maxWriteSize < maxSize {
+		maxSize = maxWriteSize
+	}
+	part, err := multipartMgr.writePart(upload, partNumber, reader, maxSize)
+	if err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	req.w.Header().Set("ETag", fmt.Sprintf("%q", part.ETag))
+	req.sendStatus(http.StatusOK)
+}
+
+func (req *s3Request) completeMultipartUpload(bucket, key, uploadID string) {
+	objectPath := getObjectPath(bucket, key)
+	var request completeMultipartUpload
+	if err := req.readXMLBody(&request); err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	if len(request.Parts) == 0 {
+		req.sendError(errMalformedXML, errNoSuchUpload)
+		return
+	}
+	if !multipartMgr.lock(uploadID) {
+		req.sendError(errNoSuchUpload, errNoSuchUpload)
+		return
+	}
+	defer multipartMgr.unlock(uploadID)
+
+	upload, err := multipartMgr.get(uploadID, req.conn.User.Username, objectPath)
+	if err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	parts := make([]multipartPart, 0, len(request.Parts))
+	etagsHash := md5.New()
+	for idx, p := range request.Parts {
+		if idx > 0 && p.PartNumber <= request.Parts[idx-1].PartNumber {
+			req.sendError(errInvalidPartOrder, errNoSuchUpload)
+			return
+		}
+		part, ok := upload.Parts[p.PartNumber]
+		if !ok || strings.ToLower(strings.Trim(p.ETag, `"`)) != part.ETag {
+			req.sendError(errInvalidPart, errNoSuchUpload)
+			return
+		}
+		etag, err := hex.DecodeString(part.ETag)
+		if err != nil {
+			req.sendError(err, errNoSuchUpload)
+			return
+		}
+		etagsHash.Write(etag)
+		parts = append(parts, part)
+	}
+	if err := req.checkBucket(bucket); err != nil {
+		req.sendError(err, errNoSuchBucket)
+		return
+	}
+	if err := req.conn.CheckParentDirs(path.Dir(objectPath)); err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	reader, closeFn, err := multipartMgr.getReader(upload, parts)
+	if err != nil {
+		req.sendError(err, errInvalidPart)
+		return
+	}
+	err = req.writeObject(objectPath, reader)
+	closeFn()
+	if err != nil {
+		req.sendError(err, errNoSuchKey)
+		return
+	}
+	multipartMgr.remove(upload.ID)
+	req.conn.Log(logger.LevelDebug, "multipart upload %q completed for path %q, parts: %d", upload.ID,
+		objectPath, len(parts))
+
+	scheme := "http"
+	if req.r.TLS != nil {
+		scheme = "https"
+	}
+	req.sendXML(completeMultipartUploadResult{
+		Location: fmt.Sprintf("%s://%s%s", scheme, req.r.Host, awsURIEncode(objectPath, false)),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     fmt.Sprintf("%q", fmt.Sprintf("%s-%d", hex.EncodeToString(etagsHash.Sum(nil)), len(parts))),
+	})
+}
+
+func (req *s3Request) abortMultipartUpload(bucket, key, uploadID string) {
+	objectPath := getObjectPath(bucket, key)
+	if !multipartMgr.lock(uploadID) {
+		req.sendError(errNoSuchUpload, errNoSuchUpload)
+		return
+	}
+	defer multipartMgr.unlock(uploadID)
+
+	upload, err := multipartMgr.get(uploadID, req.conn.User.Username, objectPath)
+	if err != nil {
+		req.sendError(err, errNoSuchUpload)
+		return
+	}
+	multipartMgr.remove(upload.ID)
+	req.conn.Log(logger.LevelDebug, "multipart upload %q aborted for path %q", upload.ID, objectPath)
+	req.sendStatus(http.StatusNoContent)
+}
+
+func (req *s3Request) checkReadPermissions(objectPath string) error {
+	if !req.conn.User.HasPerm(dataprovider.PermDownload, path.Dir(objectPath)) {
+		return req.conn.GetPermissionDeniedError()
+	}
+	if ok, policy := req.conn.User.IsFileAllowed(objectPath); !ok {
+		return req.conn.GetErrorForDeniedFile(policy)
+	}
+	return nil
+}
+
+// getETag returns the stored MD5 checksum, if available, or a synthetic ETag
+func (req *s3Request) getETag(objectPath string, info os.FileInfo) string {
+	if !info.IsDir() {
+		checksums, err := req.conn.GetChecksums(objectPath)
+		if err == nil && checksums["md5"] != "" {
+			return fmt.Sprintf("%q", checksums["md5"])
+		}
+	}
+	return getSyntheticETag(objectPath, info)
+}
+
+// contentReader verifies the size and the MD5 of the read data when EOF is
+// reached. The SHA-256 of signed payloads is verified by the wrapped reader
+type contentReader struct {
+	reader         io.Reader
+	hash           hash.Hash
+	read           int64
+	expectedSize   int64
+	expectedMD5    []byte
+	expectedSHA256 []byte
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+	if r.expectedSize >= 0 && r.read > r.expectedSize {
+		return n, errIncompleteBody
+	}
+	if errors.Is(err, io.EOF) {
+		if r.expectedSize >= 0 && r.read != r.expectedSize {
+			return n, errIncompleteBody
+		}
+		if r.expectedMD5 != nil && !bytes.Equal(r.hash.Sum(nil), r.expectedMD5) {
+			return n, errBadDigest
+		}
+	}
+	return n, err
+}
+
+// getSyntheticETag returns an ETag based on the path, the size and the
+// modification time. It has the multipart format, so clients don't try
+// to compare it with the MD5 of the content
+func getSyntheticETag(objectPath string, info os.FileInfo) string {
+	h := md5.Sum([]byte(fmt.Sprintf("%s:%d:%d", objectPath, info.Size(), info.ModTime().UnixNano())))
+	return fmt.Sprintf("\"%s-1\"", hex.EncodeToString(h[:]))
+}
+
+// parseRange parses a single range as specified in the Range header. It
+// returns the offset, the length and a boolean indicating if the range applies.
+// Unsupported ranges are ignored and the full object is returned
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, size, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, true, nil
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, size, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// parseRequestPath returns the bucket and the key from a path-style request
+func parseRequestPath(p string) (string, string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if bucket == "" {
+		if key != "" {
+			return "", "", errInvalidBucket
+		}
+		return "", "", nil
+	}
+	if bucket == "." || bucket == ".." {
+		return "", "", errInvalidBucket
+	}
+	if key == "" {
+		return bucket, "", nil
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", errInvalidKey
+		}
+	}
+	return bucket, key, nil
+}
+
+func getBucketPath(bucket string) string {
+	return "/" + bucket
+}
+
+func getObjectPath(bucket, key string) string {
+	return path.Join("/", bucket, key)
+}
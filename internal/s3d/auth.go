@@ -0,0 +1,542 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRegion                   = "us-east-1"
+	signV4Algorithm                 = "AWS4-HMAC-SHA256"
+	signV4ChunkAlgorithm            = "AWS4-HMAC-SHA256-PAYLOAD"
+	signV4Service                   = "s3"
+	signV4Terminator                = "aws4_request"
+	amzDateFormat                   = "20060102T150405Z"
+	amzShortDateFormat              = "20060102"
+	unsignedPayload                 = "UNSIGNED-PAYLOAD"
+	streamingSignedPayload          = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256                     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew                    = 15 * time.Minute
+	maxPresignedExpiration          = 7 * 24 * 60 * 60
+	maxChunkHeaderLength            = 4096
+	maxChunkTrailers                = 16
+)
+
+// signature holds the SigV4 parameters parsed from a request
+type signature struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	isPresigned   bool
+	signingKey    []byte
+}
+
+func (s *signature) getScope() string {
+	return strings.Join([]string{s.date, s.region, s.service, signV4Terminator}, "/")
+}
+
+func (s *signature) parseCredential(credential string, malformedErr apiError) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 {
+		return malformedErr
+	}
+	s.accessKeyID = parts[0]
+	s.date = parts[1]
+	s.region = parts[2]
+	s.service = parts[3]
+	if s.accessKeyID == "" || s.service != signV4Service || parts[4] != signV4Terminator {
+		return malformedErr
+	}
+	if _, err := time.Parse(amzShortDateFormat, s.date); err != nil {
+		return malformedErr
+	}
+	return nil
+}
+
+func (s *signature) parseSignedHeaders(value string, malformedErr apiError) error {
+	if value == "" {
+		return malformedErr
+	}
+	s.signedHeaders = strings.Split(value, ";")
+	for _, h := range s.signedHeaders {
+		if h == "" || h != strings.ToLower(h) {
+			return malformedErr
+		}
+	}
+	if !sort.StringsAreSorted(s.signedHeaders) {
+		return malformedErr
+	}
+	return nil
+}
+
+// validate checks the request date and the credential scope
+func (s *signature) validate(region string, now time.Time) (time.Time, error) {
+	reqTime, err := time.Parse(amzDateFormat, s.amzDate)
+	if err != nil {
+		if s.isPresigned {
+			return reqTime, errAuthorizationQueryMalformed
+		}
+		return reqTime, errAuthorizationHeaderMalformed
+	}
+	if reqTime.Format(amzShortDateFormat) != s.date {
+		return reqTime, errSignatureDoesNotMatch
+	}
+	if s.region != region {
+		return reqTime, apiError{
+			Code:       errAuthorizationHeaderMalformed.Code,
+			Message:    fmt.Sprintf("The authorization header is malformed; the region %q is wrong; expecting %q", s.region, region),
+			StatusCode: errAuthorizationHeaderMalformed.StatusCode,
+		}
+	}
+	if !s.isPresigned {
+		if reqTime.Before(now.Add(-maxClockSkew)) || reqTime.After(now.Add(maxClockSkew)) {
+			return reqTime, errRequestTimeTooSkewed
+		}
+	}
+	return reqTime, nil
+}
+
+// parseSignature parses the SigV4 parameters from the Authorization header or,
+// for presigned URLs, from the query string
+func parseSignature(r *http.Request) (*signature, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		if !strings.HasPrefix(authHeader, signV4Algorithm+" ") {
+			return nil, errSignatureVersionNotSupported
+		}
+		return parseHeaderSignature(r, strings.TrimPrefix(authHeader, signV4Algorithm+" "))
+	}
+	query := r.URL.Query()
+	if query.Has("X-Amz-Algorithm") {
+		return parsePresignedSignature(r, query)
+	}
+	if query.Has("AWSAccessKeyId") {
+		return nil, errSignatureVersionNotSupported
+	}
+	return nil, errAccessDenied
+}
+
+func parseHeaderSignature(r *http.Request, value string) (*signature, error) {
+	sig := &signature{}
+	var hasCredential, hasSignedHeaders bool
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errAuthorizationHeaderMalformed
+		}
+		switch key {
+		case "Credential":
+			if err := sig.parseCredential(val, errAuthorizationHeaderMalformed); err != nil {
+				return nil, err
+			}
+			hasCredential = true
+		case "SignedHeaders":
+			if err := sig.parseSignedHeaders(val, errAuthorizationHeaderMalformed); err != nil {
+				return nil, err
+			}
+			hasSignedHeaders = true
+		case "Signature":
+			sig.signature = val
+		}
+	}
+	if !hasCredential || !hasSignedHeaders || sig.signature == "" {
+		return nil, errAuthorizationHeaderMalformed
+	}
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	if sig.amzDate == "" {
+		if date, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+			sig.amzDate = date.UTC().Format(amzDateFormat)
+		}
+	}
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, errMissingContentSHA256
+	}
+	return sig, nil
+}
+
+func parsePresignedSignature(r *http.Request, query url.Values) (*signature, error) {
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, errSignatureVersionNotSupported
+	}
+	sig := &signature{
+		isPresigned: true,
+		amzDate:     query.Get("X-Amz-Date"),
+		signature:   query.Get("X-Amz-Signature"),
+		payloadHash: unsignedPayload,
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential"), errAuthorizationQueryMalformed); err != nil {
+		return nil, err
+	}
+	if err := sig.parseSignedHeaders(query.Get("X-Amz-SignedHeaders"), errAuthorizationQueryMalformed); err != nil {
+		return nil, err
+	}
+	if sig.signature == "" {
+		return nil, errAuthorizationQueryMalformed
+	}
+	if hash := query.Get("X-Amz-Content-Sha256"); hash != "" {
+		sig.payloadHash = hash
+	}
+	reqTime, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil {
+		return nil, errAuthorizationQueryMalformed
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxPresignedExpiration {
+		return nil, errAuthorizationQueryMalformed
+	}
+	now := time.Now().UTC()
+	if reqTime.After(now.Add(maxClockSkew)) {
+		return nil, errRequestTimeTooSkewed
+	}
+	if now.After(reqTime.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredPresignRequest
+	}
+	return sig, nil
+}
+
+// verify checks the request signature using the specified secret access key
+func (s *signature) verify(r *http.Request, secret string) error {
+	s.signingKey = getSigningKey(secret, s.date, s.region, s.service)
+	canonicalRequest := getCanonicalRequest(r, s)
+	stringToSign := strings.Join([]string{signV4Algorithm, s.amzDate, s.getScope(),
+		getSHA256Hex([]byte(canonicalRequest))}, "\n")
+	expected := hex.EncodeToString(getHMACSHA256(s.signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(s.signature)) {
+		return errSignatureDoesNotMatch
+	}
+	return nil
+}
+
+// getPayloadReader returns a reader for the request body that verifies and/or
+// decodes the payload according to the signed payload hash
+func (s *signature) getPayloadReader(body io.Reader) (io.Reader, error) {
+	switch s.payloadHash {
+	case unsignedPayload:
+		return body, nil
+	case streamingSignedPayload:
+		if s.isPresigned {
+			return nil, errNotImplemented
+		}
+		return newChunkedReader(body, s, true), nil
+	case streamingUnsignedPayloadTrailer:
+		return newChunkedReader(body, s, false), nil
+	}
+	if strings.HasPrefix(s.payloadHash, "STREAMING-") {
+		return nil, errNotImplemented
+	}
+	expected, err := hex.DecodeString(s.payloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		return nil, errContentSHA256Mismatch
+	}
+	return &sha256Reader{
+		reader:   body,
+		hash:     sha256.New(),
+		expected: expected,
+	}, nil
+}
+
+// isStreaming returns true if the payload uses the aws-chunked encoding
+func (s *signature) isStreaming() bool {
+	return s.payloadHash == streamingSignedPayload || s.payloadHash == streamingUnsignedPayloadTrailer
+}
+
+// getPayloadSHA256 returns the signed SHA-256 of the payload, if any.
+// Unsigned and streaming payloads have no SHA-256
+func (s *signature) getPayloadSHA256() []byte {
+	if s.payloadHash == unsignedPayload || strings.HasPrefix(s.payloadHash, "STREAMING-") {
+		return nil
+	}
+	result, err := hex.DecodeString(s.payloadHash)
+	if err != nil || len(result) != sha256.Size {
+		return nil
+	}
+	return result
+}
+
+func getCanonicalRequest(r *http.Request, s *signature) string {
+	var sb strings.Builder
+
+	sb.WriteString(r.Method)
+	sb.WriteString("\n")
+	uri := awsURIEncode(r.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+	sb.WriteString(uri)
+	sb.WriteString("\n")
+	sb.WriteString(getCanonicalQueryString(r.URL.Query(), s.isPresigned))
+	sb.WriteString("\n")
+	for _, h := range s.signedHeaders {
+		sb.WriteString(h)
+		sb.WriteString(":")
+		sb.WriteString(getCanonicalHeaderValue(r, h))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	sb.WriteString(strings.Join(s.signedHeaders, ";"))
+	sb.WriteString("\n")
+	sb.WriteString(s.payloadHash)
+
+	return sb.String()
+}
+
+func getCanonicalQueryString(query url.Values, isPresigned bool) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		if isPresigned && key == "X-Amz-Signature" {
+			continue
+		}
+		encodedKey := awsURIEncode(key, true)
+		for _, val := range values {
+			params = append(params, encodedKey+"="+awsURIEncode(val, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func getCanonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values("Content-Length")
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	case "transfer-encoding":
+		values = r.Header.Values("Transfer-Encoding")
+		if len(values) == 0 {
+			values = r.TransferEncoding
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	for idx := range values {
+		values[idx] = strings.Join(strings.Fields(values[idx]), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// awsURIEncode encodes the specified string as required by SigV4
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return sb.String()
+}
+
+func getSigningKey(secret, date, region, service string) []byte {
+	key := getHMACSHA256([]byte("AWS4"+secret), []byte(date))
+	key = getHMACSHA256(key, []byte(region))
+	key = getHMACSHA256(key, []byte(service))
+	return getHMACSHA256(key, []byte(signV4Terminator))
+}
+
+func getHMACSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func getSHA256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// sha256Reader verifies the SHA256 of the read data when EOF is reached
+type sha256Reader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+func (r *sha256Reader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && !bytes.Equal(r.hash.Sum(nil), r.expected) {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked payload. For signed payloads
+// the signature of each chunk is verified
+type chunkedReader struct {
+	reader        *bufio.Reader
+	sig           *signature
+	signed        bool
+	prevSignature string
+	chunkSig      string
+	remaining     int64
+	isLastChunk   bool
+	hash          hash.Hash
+	err           error
+}
+
+func newChunkedReader(body io.Reader, sig *signature, signed bool) *chunkedReader {
+	return &chunkedReader{
+		reader:        bufio.NewReader(body),
+		sig:           sig,
+		signed:        signed,
+		prevSignature: sig.signature,
+		hash:          sha256.New(),
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readChunkHeader()
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		c.remaining = 0
+		return n, err
+	}
+	if c.remaining == 0 {
+		c.err = c.finishChunk()
+		if c.err != nil {
+			return n, c.err
+		}
+	}
+	return n, nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		data, isPrefix, err := c.reader.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, data...)
+		if len(line) > maxChunkHeaderLength {
+			return "", errIncompleteBody
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func (c *chunkedReader) readChunkHeader() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, params, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 {
+		return errIncompleteBody
+	}
+	c.chunkSig = ""
+	if c.signed {
+		key, val, ok := strings.Cut(params, "=")
+		if !ok || key != "chunk-signature" || val == "" {
+			return errSignatureDoesNotMatch
+		}
+		c.chunkSig = val
+	}
+	c.hash.Reset()
+	c.remaining = size
+	if size == 0 {
+		c.isLastChunk = true
+		if err := c.finishChunk(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return nil
+}
+
+// finishChunk verifies the chunk signature and reads the chunk terminator.
+// For the last chunk the trailing headers, if any, are discarded
+func (c *chunkedReader) finishChunk() error {
+	if c.signed {
+		stringToSign := strings.Join([]string{signV4ChunkAlgorithm, c.sig.amzDate, c.sig.getScope(),
+			c.prevSignature, emptySHA256, hex.EncodeToString(c.hash.Sum(nil))}, "\n")
+		expected := hex.EncodeToString(getHMACSHA256(c.sig.signingKey, []byte(stringToSign)))
+		if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+			return errSignatureDoesNotMatch
+		}
+		c.prevSignature = c.chunkSig
+	}
+	if !c.isLastChunk {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line != "" {
+			return errIncompleteBody
+		}
+		return nil
+	}
+	for idx := 0; idx < maxChunkTrailers; idx++ {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+	return errIncompleteBody
+}
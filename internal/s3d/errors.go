@@ -0,0 +1,133 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// apiError defines an error as returned by the S3 API
+type apiError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e apiError) Error() string {
+	return e.Message
+}
+
+var (
+	errAccessDenied       = apiError{"AccessDenied", "Access Denied.", http.StatusForbidden}
+	errInvalidAccessKeyID = apiError{"InvalidAccessKeyId",
+		"The AWS access key Id you provided does not exist in our records.", http.StatusForbidden}
+	errSignatureDoesNotMatch = apiError{"SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided. Check your key and signing method.",
+		http.StatusForbidden}
+	errAuthorizationHeaderMalformed = apiError{"AuthorizationHeaderMalformed",
+		"The authorization header is malformed.", http.StatusBadRequest}
+	errAuthorizationQueryMalformed = apiError{"AuthorizationQueryParametersError",
+		"The authorization query parameters are malformed.", http.StatusBadRequest}
+	errSignatureVersionNotSupported = apiError{"InvalidRequest",
+		"The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.",
+		http.StatusBadRequest}
+	errMissingContentSHA256 = apiError{"InvalidRequest",
+		"Missing required header for this request: x-amz-content-sha256", http.StatusBadRequest}
+	errRequestTimeTooSkewed = apiError{"RequestTimeTooSkewed",
+		"The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errExpiredPresignRequest = apiError{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errContentSHA256Mismatch = apiError{"XAmzContentSHA256Mismatch",
+		"The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errBadDigest = apiError{"BadDigest", "The Content-MD5 you specified did not match what we received.",
+		http.StatusBadRequest}
+	errInvalidDigest  = apiError{"InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest}
+	errIncompleteBody = apiError{"IncompleteBody",
+		"You did not provide the number of bytes specified by the Content-Length HTTP header.", http.StatusBadRequest}
+	errEntityTooLarge = apiError{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.",
+		http.StatusBadRequest}
+	errNoSuchBucket  = apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound}
+	errNoSuchKey     = apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload  = apiError{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errInvalidBucket = apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errInvalidKey    = apiError{"InvalidArgument",
+		"Object keys with empty, \".\" or \"..\" path segments are not supported.", http.StatusBadRequest}
+	errInvalidPart = apiError{"InvalidPart",
+		"One or more of the specified parts could not be found or the specified entity tag might not have matched the part's entity tag.",
+		http.StatusBadRequest}
+	errInvalidPartOrder = apiError{"InvalidPartOrder",
+		"The list of parts was not in ascending order. Parts must be ordered by part number.", http.StatusBadRequest}
+	errMalformedXML = apiError{"MalformedXML",
+		"The XML you provided was not well-formed or did not validate against our published schema.",
+		http.StatusBadRequest}
+	errInvalidArgument = apiError{"InvalidArgument", "Invalid Argument.", http.StatusBadRequest}
+	errInvalidRange    = apiError{"InvalidRange", "The requested range is not satisfiable.",
+		http.StatusRequestedRangeNotSatisfiable}
+	errBucketAlreadyOwnedByYou = apiError{"BucketAlreadyOwnedByYou",
+		"Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict}
+	errBucketNotEmpty = apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty.",
+		http.StatusConflict}
+	errDirectoryNotEmpty = apiError{"DirectoryNotEmpty",
+		"The object you tried to delete is a directory that is not empty.", http.StatusConflict}
+	errQuotaExceeded = apiError{"QuotaExceeded", "The quota configured for your account has been exceeded.",
+		http.StatusForbidden}
+	errNotImplemented = apiError{"NotImplemented",
+		"A header or parameter you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errMethodNotAllowed = apiError{"MethodNotAllowed",
+		"The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errSlowDown           = apiError{"SlowDown", "Please reduce your request rate.", http.StatusServiceUnavailable}
+	errServiceUnavailable = apiError{"ServiceUnavailable", "Please reduce your request rate.",
+		http.StatusServiceUnavailable}
+	errInternalError = apiError{"InternalError", "We encountered an internal error. Please try again.",
+		http.StatusInternalServerError}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// getAPIError returns the S3 error matching the specified error.
+// notExistErr is returned for not exist errors
+func getAPIError(err error, notExistErr apiError) apiError {
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, common.ErrNotExist) || errors.Is(err, os.ErrNotExist) || errors.Is(err, util.ErrNotFound) {
+		return notExistErr
+	}
+	if errors.Is(err, common.ErrPermissionDenied) || errors.Is(err, os.ErrPermission) {
+		return errAccessDenied
+	}
+	if errors.Is(err, common.ErrQuotaExceeded) || errors.Is(err, common.ErrReadQuotaExceeded) {
+		return errQuotaExceeded
+	}
+	if errors.Is(err, common.ErrOpUnsupported) {
+		return errNotImplemented
+	}
+	if errors.Is(err, common.ErrShuttingDown) {
+		return errServiceUnavailable
+	}
+	return errInternalError
+}
@@ -0,0 +1,312 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	multipartInfoFile          = "upload.json"
+	multipartPartSuffix        = ".part"
+	multipartDefaultExpiration = 24
+	maxPartNumber              = 10000
+	maxPartSize                = 5 * 1024 * 1024 * 1024
+)
+
+// multipartPart defines an uploaded part
+type multipartPart struct {
+	Number       int    `json:"number"`
+	ETag         string `json:"etag"`
+	Size         int64  `json:"size"`
+	LastModified int64  `json:"last_modified"`
+}
+
+// multipartUpload defines the state of a multipart upload
+type multipartUpload struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// Path is the virtual path of the target file
+	Path      string                `json:"path"`
+	CreatedAt int64                 `json:"created_at"`
+	Parts     map[int]multipartPart `json:"parts"`
+}
+
+func (u *multipartUpload) getSortedParts() []multipartPart {
+	parts := make([]multipartPart, 0, len(u.Parts))
+	for _, part := range u.Parts {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+	return parts
+}
+
+// multipartManager stores the parts of the incomplete multipart uploads inside
+// a local directory. Each upload has its own directory, named as the upload ID,
+// containing the parts and a JSON encoded info file
+type multipartManager struct {
+	mu          sync.Mutex
+	uploadsPath string
+	expiration  time.Duration
+	// uploads being completed or removed
+	busy map[string]bool
+}
+
+func newMultipartManager(uploadsPath string, expiration int) (*multipartManager, error) {
+	if uploadsPath == "" {
+		return nil, errors.New("s3d: the multipart uploads path is required")
+	}
+	if expiration <= 0 {
+		expiration = multipartDefaultExpiration
+	}
+	if err := os.MkdirAll(uploadsPath, 0700); err != nil {
+		return nil, fmt.Errorf("s3d: unable to create multipart uploads dir %q: %w", uploadsPath, err)
+	}
+	return &multipartManager{
+		uploadsPath: uploadsPath,
+		expiration:  time.Duration(expiration) * time.Hour,
+		busy:        make(map[string]bool),
+	}, nil
+}
+
+func (m *multipartManager) getUploadPath(id string) string {
+	return filepath.Join(m.uploadsPath, id)
+}
+
+func (m *multipartManager) getInfoPath(id string) string {
+	return filepath.Join(m.uploadsPath, id, multipartInfoFile)
+}
+
+func (m *multipartManager) getPartPath(id string, number int) string {
+	return filepath.Join(m.uploadsPath, id, strconv.Itoa(number)+multipartPartSuffix)
+}
+
+// lock marks the upload with the given ID as busy, it returns false if it is
+// already busy
+func (m *multipartManager) lock(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy[id] {
+		return false
+	}
+	m.busy[id] = true
+	return true
+}
+
+func (m *multipartManager) unlock(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.busy, id)
+}
+
+func (m *multipartManager) create(username, virtualPath string) (*multipartUpload, error) {
+	upload := &multipartUpload{
+		ID:        xid.New().String(),
+		Username:  username,
+		Path:      virtualPath,
+		CreatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+		Parts:     make(map[int]multipartPart),
+	}
+	if err := os.Mkdir(m.getUploadPath(upload.ID), 0700); err != nil {
+		return nil, err
+	}
+	if err := m.save(upload); err != nil {
+		m.remove(upload.ID)
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (m *multipartManager) save(upload *multipartUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	infoPath := m.getInfoPath(upload.ID)
+	tmpPath := infoPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, infoPath)
+}
+
+func (m *multipartManager) load(id string) (*multipartUpload, error) {
+	if _, err := xid.FromString(id); err != nil {
+		return nil, errNoSuchUpload
+	}
+	data, err := os.ReadFile(m.getInfoPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errNoSuchUpload
+		}
+		return nil, err
+	}
+	var upload multipartUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if upload.Parts == nil {
+		upload.Parts = make(map[int]multipartPart)
+	}
+	return &upload, nil
+}
+
+// get returns the upload with the given ID if it exists, it is not expired
+// and it is owned by the specified user for the specified path
+func (m *multipartManager) get(id, username, virtualPath string) (*multipartUpload, error) {
+	upload, err := m.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Username != username || upload.Path != virtualPath || m.isExpired(upload) {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (m *multipartManager) isExpired(upload *multipartUpload) bool {
+	return upload.CreatedAt+m.expiration.Milliseconds() < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (m *multipartManager) remove(id string) {
+	if err := os.RemoveAll(m.getUploadPath(id)); err != nil {
+		logger.Warn(logSender, "", "unable to remove multipart upload %q: %v", id, err)
+	}
+}
+
+// writePart stores the data read from the specified reader as the part with
+// the given number. An existing part with the same number is replaced.
+// maxSize is the maximum allowed size for the part
+func (m *multipartManager) writePart(upload *multipartUpload, number int, reader io.Reader,
+	maxSize int64,
+) (multipartPart, error) {
+	var part multipartPart
+
+	f, err := os.CreateTemp(m.getUploadPath(upload.ID), ".tmp*")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return part, errNoSuchUpload
+		}
+		return part, err
+	}
+	h := md5.New()
+	written, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(reader, maxSize+1))
+	errClose := f.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err == nil && written > maxSize {
+		err = errEntityTooLarge
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return part, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy[upload.ID] {
+		os.Remove(f.Name())
+		return part, errNoSuchUpload
+	}
+	// reload the upload, other parts could be added concurrently
+	current, err := m.load(upload.ID)
+	if err != nil {
+		os.Remove(f.Name())
+		return part, err
+	}
+	if err := os.Rename(f.Name(), m.getPartPath(upload.ID, number)); err != nil {
+		os.Remove(f.Name())
+		return part, err
+	}
+	part = multipartPart{
+		Number:       number,
+		ETag:         hex.EncodeToString(h.Sum(nil)),
+		Size:         written,
+		LastModified: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	current.Parts[number] = part
+	return part, m.save(current)
+}
+
+// getReader returns a reader for the concatenation of the specified parts.
+// The returned function must be called to close the opened files
+func (m *multipartManager) getReader(upload *multipartUpload, parts []multipartPart) (io.Reader, func(), error) {
+	var files []*os.File
+	closeFn := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		f, err := os.Open(m.getPartPath(upload.ID, part.Number))
+		if err != nil {
+			closeFn()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		readers = append(readers, io.LimitReader(f, part.Size))
+	}
+	return io.MultiReader(readers...), closeFn, nil
+}
+
+// cleanup removes the expired uploads
+func (m *multipartManager) cleanup() {
+	entries, err := os.ReadDir(m.uploadsPath)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read multipart uploads dir %q: %v", m.uploadsPath, err)
+		return
+	}
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || !m.lock(id) {
+			continue
+		}
+		upload, err := m.load(id)
+		if err == nil {
+			if m.isExpired(upload) {
+				logger.Debug(logSender, "", "removing expired multipart upload %q for user %q, path %q",
+					id, upload.Username, upload.Path)
+				m.remove(id)
+			}
+		} else if info, errInfo := entry.Info(); errInfo == nil && time.Since(info.ModTime()) > m.expiration {
+			logger.Debug(logSender, "", "removing invalid multipart upload %q: %v", id, err)
+			m.remove(id)
+		}
+		m.unlock(id)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	loginMethod = "s3-access-key"
+	xmlHeader   = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+)
+
+type s3Server struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *s3Server) listenAndServe() error {
+	httpServer := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 16, // 64KB
+		ErrorLog:          log.New(&logger.StdLoggerWrapper{Sender: logSender}, "", 0),
+	}
+	if certMgr != nil && s.binding.EnableHTTPS {
+		serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+		certID := common.DefaultTLSKeyPaidID
+		if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+			certID = s.binding.GetAddress()
+		}
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
+			NextProtos:               []string{"http/1.1", "h2"},
+			CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
+			PreferServerCipherSuites: true,
+		}
+		logger.Debug(logSender, "", "configured TLS cipher suites for binding %q: %v, certID: %v",
+			s.binding.GetAddress(), httpServer.TLSConfig.CipherSuites, certID)
+		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+	}
+	s.binding.EnableHTTPS = false
+	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := xid.New().String()
+	startTime := time.Now()
+	w.Header().Set("x-amz-request-id", requestID)
+
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if rcv == http.ErrAbortHandler {
+				panic(rcv)
+			}
+			logger.Error(logSender, "", "panic in ServeHTTP: %q stack trace: %v", rcv, string(debug.Stack()))
+			writeError(w, r, requestID, startTime, errInternalError)
+		}
+	}()
+
+	ipAddr := s.checkRemoteAddress(r)
+
+	common.Connections.AddClientConnection(ipAddr)
+	defer common.Connections.RemoveClientConnection(ipAddr)
+
+	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolS3); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolS3, "", "connection not allowed from ip %q: %v", ipAddr, err)
+		writeError(w, r, requestID, startTime, errServiceUnavailable)
+		return
+	}
+	if common.IsBanned(ipAddr, common.ProtocolS3) {
+		writeError(w, r, requestID, startTime, errAccessDenied)
+		return
+	}
+	delay, err := common.LimitRate(common.ProtocolS3, ipAddr)
+	if err != nil {
+		delay += 499999999 * time.Nanosecond
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+		writeError(w, r, requestID, startTime, errSlowDown)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolS3); err != nil {
+		writeError(w, r, requestID, startTime, errAccessDenied)
+		return
+	}
+	user, sig, err := s.authenticate(r, ipAddr)
+	if err != nil {
+		writeError(w, r, requestID, startTime, getAPIError(err, errAccessDenied))
+		return
+	}
+
+	connectionID, err := s.validateUser(&user, r)
+	if err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		writeError(w, r, requestID, startTime, errAccessDenied)
+		return
+	}
+	if err = user.CheckFsRoot(connectionID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, common.ErrInternalFailure)
+		writeError(w, r, requestID, startTime, errInternalError)
+		return
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolS3, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable add connection: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, err)
+		writeError(w, r, requestID, startTime, errSlowDown)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	updateLoginMetrics(&user, ipAddr, nil)
+	dataprovider.UpdateLastLogin(&user)
+
+	req := &s3Request{
+		server:     s,
+		w:          w,
+		r:          r,
+		conn:       connection,
+		sig:        sig,
+		requestID:  requestID,
+		startTime:  startTime,
+		respStatus: http.StatusOK,
+	}
+	req.handle()
+}
+
+func (s *s3Server) authenticate(r *http.Request, ip string) (dataprovider.User, *signature, error) {
+	var user dataprovider.User
+
+	sig, err := parseSignature(r)
+	if err != nil {
+		return user, nil, err
+	}
+	user, secret, err := dataprovider.GetUserWithS3AccessKey(sig.accessKeyID)
+	if err != nil {
+		if user.Username == "" {
+			user.Username = sig.accessKeyID
+		}
+		updateLoginMetrics(&user, ip, err)
+		if errors.Is(err, util.ErrNotFound) {
+			return user, nil, errInvalidAccessKeyID
+		}
+		return user, nil, errAccessDenied
+	}
+	if _, err := sig.validate(s.config.Region, time.Now().UTC()); err != nil {
+		return user, nil, err
+	}
+	if err := sig.verify(r, secret); err != nil {
+		updateLoginMetrics(&user, ip, dataprovider.ErrInvalidCredentials)
+		return user, nil, err
+	}
+	return user, sig, nil
+}
+
+func (s *s3Server) validateUser(user *dataprovider.User, r *http.Request) (string, error) {
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolS3, connID)
+
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %q has an invalid home dir: %q. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return connID, fmt.Errorf("cannot login user with invalid home dir: %q", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolS3) {
+		logger.Info(logSender, connectionID, "cannot login user %q, protocol S3 is not allowed", user.Username)
+		return connID, fmt.Errorf("protocol S3 is not allowed for user %q", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		common.RequestIPApproval(user, r.RemoteAddr, common.ProtocolS3)
+		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
+			user.Username, r.RemoteAddr)
+		return connID, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, r.RemoteAddr)
+	}
+	return connID, nil
+}
+
+func (s *s3Server) checkRemoteAddress(r *http.Request) string {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	var ip net.IP
+	isUnixSocket := filepath.IsAbs(s.binding.Address)
+	if !isUnixSocket {
+		ip = net.ParseIP(ipAddr)
+	}
+	if isUnixSocket || ip != nil {
+		for _, allow := range s.binding.allowHeadersFrom {
+			if allow(ip) {
+				parsedIP := util.GetRealIP(r, s.binding.ClientIPProxyHeader, s.binding.ClientIPHeaderDepth)
+				if parsedIP != "" {
+					ipAddr = parsedIP
+					r.RemoteAddr = ipAddr
+				}
+				break
+			}
+		}
+	}
+	return ipAddr
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, requestID string, startTime time.Time, apiErr apiError) {
+	writeLog(r, requestID, startTime, apiErr.StatusCode, apiErr)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(apiErr.StatusCode)
+		return
+	}
+	writeXML(w, apiErr.StatusCode, errorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		logger.Error(logSender, "", "unable to marshal XML response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(xmlHeader)+len(data)))
+	w.WriteHeader(status)
+	w.Write([]byte(xmlHeader)) //nolint:errcheck
+	w.Write(data)              //nolint:errcheck
+}
+
+func writeLog(r *http.Request, requestID string, startTime time.Time, status int, err error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	fields := map[string]any{
+		"remote_addr": r.RemoteAddr,
+		"proto":       r.Proto,
+		"method":      r.Method,
+		"user_agent":  r.UserAgent(),
+		"uri":         fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI),
+		"request_id":  requestID,
+	}
+	fields["elapsed_ms"] = time.Since(startTime).Nanoseconds() / 1000000
+	if contentLength := r.Header.Get("Content-Length"); contentLength != "" {
+		fields["content_length"] = contentLength
+	}
+	if status != 0 {
+		fields["resp_status"] = status
+	}
+	logger.GetLogger().Info().
+		Timestamp().
+		Str("sender", logSender).
+		Fields(fields).
+		Err(err).
+		Send()
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
+	metric.AddLoginAttempt(loginMethod)
+	if err != nil && err != common.ErrInternalFailure && err != common.ErrNoCredentials {
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolS3, err.Error())
+		event := common.HostEventLoginFailed
+		if errors.Is(err, util.ErrNotFound) {
+			event = common.HostEventUserNotFound
+		}
+		common.AddDefenderEvent(ip, common.ProtocolS3, event)
+	}
+	metric.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolS3, err)
+}
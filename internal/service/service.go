@@ -27,6 +27,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/config"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/fusemount"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -62,6 +63,8 @@ type Service struct {
 	LoadDataQuotaScan int
 	Shutdown          chan bool
 	Error             error
+	fuseMountPoint    string
+	fuseServer        *fusemount.Server
 }
 
 func (s *Service) initLogger() {
@@ -103,7 +106,8 @@ func (s *Service) Start(disableAWSInstallationCode bool) error {
 			return err
 		}
 	}
-	if !config.HasServicesToStart() {
+	// in portable mode a FUSE mount can be the only service
+	if !config.HasServicesToStart() && s.fuseMountPoint == "" {
 		infoString := "no service configured, nothing to do"
 		logger.Info(logSender, "", infoString)
 		logger.InfoToConsole(infoString)
@@ -154,6 +158,13 @@ func (s *Service) initializeServices(disableAWSInstallationCode bool) error {
 		logger.ErrorToConsole("unable to initialize SMTP configuration: %v", err)
 		return err
 	}
+	geoIPConfig := config.GetGeoIPConfig()
+	err = geoIPConfig.Initialize(s.ConfigDir)
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize GeoIP: %v", err)
+		logger.ErrorToConsole("unable to initialize GeoIP: %v", err)
+		return err
+	}
 	err = common.Initialize(config.GetCommonConfig(), providerConf.GetShared())
 	if err != nil {
 		logger.Error(logSender, "", "%v", err)
@@ -212,6 +223,10 @@ func (s *Service) startServices() {
 	ftpdConf := config.GetFTPDConfig()
 	httpdConf := config.GetHTTPDConfig()
 	webDavDConf := config.GetWebDAVDConfig()
+	s3dConf := config.GetS3DConfig()
+	tftpdConf := config.GetTFTPDConfig()
+	grpcdConf := config.GetGRPCDConfig()
+	smtpdConf := config.GetSMTPDConfig()
 	telemetryConf := config.GetTelemetryConfig()
 
 	if sftpdConf.ShouldBind() {
@@ -270,6 +285,54 @@ func (s *Service) startServices() {
 	} else {
 		logger.Info(logSender, "", "WebDAV server not started, disabled in config file")
 	}
+	if s3dConf.ShouldBind() {
+		go func() {
+			if err := s3dConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start S3 API server: %v", err)
+				logger.ErrorToConsole("could not start S3 API server: %v", err)
+				s.Error = err
+			}
+			s.Shutdown <- true
+		}()
+	} else {
+		logger.Info(logSender, "", "S3 API server not started, disabled in config file")
+	}
+	if tftpdConf.ShouldBind() {
+		go func() {
+			if err := tftpdConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start TFTP server: %v", err)
+				logger.ErrorToConsole("could not start TFTP server: %v", err)
+				s.Error = err
+			}
+			s.Shutdown <- true
+		}()
+	} else {
+		logger.Info(logSender, "", "TFTP server not started, disabled in config file")
+	}
+	if grpcdConf.ShouldBind() {
+		go func() {
+			if err := grpcdConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start gRPC server: %v", err)
+				logger.ErrorToConsole("could not start gRPC server: %v", err)
+				s.Error = err
+			}
+			s.Shutdown <- true
+		}()
+	} else {
+		logger.Info(logSender, "", "gRPC server not started, disabled in config file")
+	}
+	if smtpdConf.ShouldBind() {
+		go func() {
+			if err := smtpdConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start SMTP server: %v", err)
+				logger.ErrorToConsole("could not start SMTP server: %v", err)
+				s.Error = err
+			}
+			s.Shutdown <- true
+		}()
+	} else {
+		logger.Info(logSender, "", "SMTP server not started, disabled in config file")
+	}
 	if telemetryConf.ShouldBind() {
 		go func() {
 			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
@@ -363,6 +426,10 @@ func (s *Service) restoreDump(dump *dataprovider.BackupData) error {
 	if err != nil {
 		return fmt.Errorf("unable to restore roles from file %q: %v", s.LoadDataFrom, err)
 	}
+	err = httpd.RestoreTemplates(dump.Templates, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	if err != nil {
+		return fmt.Errorf("unable to restore templates from file %q: %v", s.LoadDataFrom, err)
+	}
 	err = httpd.RestoreFolders(dump.Folders, s.LoadDataFrom, s.LoadDataMode, s.LoadDataQuotaScan, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
 		return fmt.Errorf("unable to restore folders from file %q: %v", s.LoadDataFrom, err)
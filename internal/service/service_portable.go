@@ -20,13 +20,17 @@ package service
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/config"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/fusemount"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
@@ -37,10 +41,11 @@ import (
 
 // StartPortableMode starts the service in portable mode
 func (s *Service) StartPortableMode(sftpdPort, ftpPort, webdavPort int, enabledSSHCommands []string,
-	ftpsCert, ftpsKey, webDavCert, webDavKey string) error {
+	ftpsCert, ftpsKey, webDavCert, webDavKey, fuseMountPoint string) error {
 	if s.PortableMode != 1 {
 		return fmt.Errorf("service is not configured for portable mode")
 	}
+	s.fuseMountPoint = fuseMountPoint
 	err := config.LoadConfig(s.ConfigDir, s.ConfigFile)
 	if err != nil {
 		fmt.Printf("error loading configuration file: %v using defaults\n", err)
@@ -116,6 +121,12 @@ func (s *Service) StartPortableMode(sftpdPort, ftpPort, webdavPort int, enabledS
 	if err != nil {
 		return err
 	}
+	if s.fuseMountPoint != "" {
+		if err := s.mountPortableFs(); err != nil {
+			logger.ErrorToConsole("unable to mount the portable filesystem at %q: %v", s.fuseMountPoint, err)
+			return err
+		}
+	}
 
 	logger.InfoToConsole("Portable mode ready, user: %q, password: %q, public keys: %v, directory: %q, "+
 		"permissions: %+v, enabled ssh commands: %v file patterns filters: %+v %v", s.PortableUser.Username,
@@ -137,11 +148,55 @@ func (s *Service) getServiceOptionalInfoString() string {
 		if config.GetWebDAVDConfig().CertificateFile != "" && config.GetWebDAVDConfig().CertificateKeyFile != "" {
 			scheme = "https"
 		}
-		info.WriteString(fmt.Sprintf("WebDAV URL: %v://<your IP>:%v/", scheme, config.GetWebDAVDConfig().Bindings[0].Port))
+		info.WriteString(fmt.Sprintf("WebDAV URL: %v://<your IP>:%v/ ", scheme, config.GetWebDAVDConfig().Bindings[0].Port))
+	}
+	if s.fuseServer != nil {
+		info.WriteString(fmt.Sprintf("FUSE mount point: %q", s.fuseServer.GetMountPoint()))
 	}
 	return info.String()
 }
 
+// mountPortableFs exposes the portable user's filesystem as a local FUSE mount.
+// The filesystem is unmounted on interrupt and the service stops once it is unmounted
+func (s *Service) mountPortableFs() error {
+	mountPoint := s.fuseMountPoint
+	user, err := dataprovider.GetUserWithGroupSettings(s.PortableUser.Username, "")
+	if err != nil {
+		return err
+	}
+	server, err := fusemount.Mount(mountPoint, user)
+	if err != nil {
+		return err
+	}
+	s.fuseServer = server
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	unmounted := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-sig:
+				if err := server.Unmount(); err != nil {
+					logger.ErrorToConsole("unable to unmount %q, make sure it is not in use: %v", mountPoint, err)
+				}
+			case <-unmounted:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		server.Wait()
+		signal.Stop(sig)
+		close(unmounted)
+		logger.InfoToConsole("%q unmounted, exiting", mountPoint)
+		s.Stop()
+	}()
+	return nil
+}
+
 func (s *Service) getPortableDirToServe() string {
 	switch s.PortableUser.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
@@ -25,10 +25,14 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
+	"github.com/drakkan/sftpgo/v2/internal/smtpd"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
@@ -68,6 +72,18 @@ func handleSIGHUP() {
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
 	}
+	err = s3d.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading S3 API cert manager: %v", err)
+	}
+	err = grpcd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading gRPC cert manager: %v", err)
+	}
+	err = smtpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading SMTP server cert manager: %v", err)
+	}
 	err = telemetry.ReloadCertificateMgr()
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
@@ -76,6 +92,10 @@ func handleSIGHUP() {
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading common configs: %v", err)
 	}
+	err = geoip.Reload()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading GeoIP databases: %v", err)
+	}
 	err = sftpd.Reload()
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
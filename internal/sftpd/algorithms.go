@@ -0,0 +1,243 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// maximum number of bytes to inspect while looking for the client KEXINIT,
+	// the version line and the packet must fit in this limit
+	maxKexInitInspectSize = 35000
+)
+
+var (
+	aeadCiphers = []string{"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"}
+	// inbound connections indexed by local and remote address, they allow to
+	// get the negotiated algorithms from the authentication callbacks
+	kexInitConns sync.Map
+)
+
+// clientKexInitMsg is the SSH_MSG_KEXINIT message, see RFC 4253 section 7.1
+type clientKexInitMsg struct {
+	Cookie                  [16]byte `sshtype:"20"`
+	KexAlgos                []string
+	ServerHostKeyAlgos      []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+	LanguagesClientServer   []string
+	LanguagesServerClient   []string
+	FirstKexFollows         bool
+	Reserved                uint32
+}
+
+// negotiatedAlgorithms defines the algorithms agreed during the initial key exchange.
+// MACs are empty if an AEAD cipher is used
+type negotiatedAlgorithms struct {
+	Kex               string
+	CipherClientToSrv string
+	CipherSrvToClient string
+	MACClientToSrv    string
+	MACSrvToClient    string
+}
+
+func (a *negotiatedAlgorithms) String() string {
+	return fmt.Sprintf("kex %q, ciphers %q/%q, MACs %q/%q", a.Kex, a.CipherClientToSrv, a.CipherSrvToClient,
+		a.MACClientToSrv, a.MACSrvToClient)
+}
+
+// checkUser returns an error if the negotiated algorithms are not allowed for the specified user
+func (a *negotiatedAlgorithms) checkUser(user *dataprovider.User) error {
+	allowed := &user.Filters.SSHAlgorithms
+	if len(allowed.KexAlgorithms) > 0 && !util.Contains(allowed.KexAlgorithms, a.Kex) {
+		return fmt.Errorf("key-exchange algorithm %q is not allowed", a.Kex)
+	}
+	if len(allowed.Ciphers) > 0 {
+		for _, cipher := range []string{a.CipherClientToSrv, a.CipherSrvToClient} {
+			if !util.Contains(allowed.Ciphers, cipher) {
+				return fmt.Errorf("cipher %q is not allowed", cipher)
+			}
+		}
+	}
+	if len(allowed.MACs) > 0 {
+		for _, mac := range []string{a.MACClientToSrv, a.MACSrvToClient} {
+			if mac != "" && !util.Contains(allowed.MACs, mac) {
+				return fmt.Errorf("MAC algorithm %q is not allowed", mac)
+			}
+		}
+	}
+	return nil
+}
+
+func findCommonAlgorithm(client, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+func getNegotiatedAlgorithms(msg *clientKexInitMsg, config *ssh.ServerConfig) *negotiatedAlgorithms {
+	kexs := config.KeyExchanges
+	if len(kexs) == 0 {
+		kexs = preferredKexAlgos
+	}
+	ciphers := config.Ciphers
+	if len(ciphers) == 0 {
+		ciphers = preferredCiphers
+	}
+	macs := config.MACs
+	if len(macs) == 0 {
+		macs = preferredMACs
+	}
+	result := &negotiatedAlgorithms{
+		Kex:               findCommonAlgorithm(msg.KexAlgos, kexs),
+		CipherClientToSrv: findCommonAlgorithm(msg.CiphersClientServer, ciphers),
+		CipherSrvToClient: findCommonAlgorithm(msg.CiphersServerClient, ciphers),
+	}
+	if !util.Contains(aeadCiphers, result.CipherClientToSrv) {
+		result.MACClientToSrv = findCommonAlgorithm(msg.MACsClientServer, macs)
+	}
+	if !util.Contains(aeadCiphers, result.CipherSrvToClient) {
+		result.MACSrvToClient = findCommonAlgorithm(msg.MACsServerClient, macs)
+	}
+	return result
+}
+
+// kexInitConn wraps an inbound connection and parses the first KEXINIT
+// packet sent by the client, it is sent in clear text after the version line
+type kexInitConn struct {
+	net.Conn
+	config *ssh.ServerConfig
+	mu     sync.RWMutex
+	buf    bytes.Buffer
+	done   bool
+	algos  *negotiatedAlgorithms
+}
+
+func newKexInitConn(conn net.Conn, config *ssh.ServerConfig) *kexInitConn {
+	return &kexInitConn{
+		Conn:   conn,
+		config: config,
+	}
+}
+
+func (c *kexInitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.inspect(p[:n])
+	}
+	return n, err
+}
+
+func (c *kexInitConn) inspect(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	c.buf.Write(data)
+	msg, ok := parseClientKexInit(c.buf.Bytes())
+	if !ok && c.buf.Len() < maxKexInitInspectSize {
+		return
+	}
+	if msg != nil {
+		c.algos = getNegotiatedAlgorithms(msg, c.config)
+	}
+	c.done = true
+	c.buf = bytes.Buffer{}
+}
+
+func (c *kexInitConn) getNegotiatedAlgorithms() *negotiatedAlgorithms {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.algos
+}
+
+func (c *kexInitConn) getKey() string {
+	return getKexInitConnKey(c.LocalAddr(), c.RemoteAddr())
+}
+
+func getKexInitConnKey(localAddr, remoteAddr net.Addr) string {
+	return localAddr.String() + "|" + remoteAddr.String()
+}
+
+// parseClientKexInit parses the client version line and the following KEXINIT
+// packet. It returns false if more data are required, a nil message and true
+// if the data are invalid
+func parseClientKexInit(data []byte) (*clientKexInitMsg, bool) {
+	// skip the version line and any other line sent before it
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			return nil, false
+		}
+		line := data[:idx]
+		data = data[idx+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+	if len(data) < 5 {
+		return nil, false
+	}
+	packetLen := binary.BigEndian.Uint32(data[:4])
+	if packetLen > maxKexInitInspectSize {
+		return nil, true
+	}
+	if uint32(len(data)-4) < packetLen {
+		return nil, false
+	}
+	paddingLen := uint32(data[4])
+	if paddingLen+1 > packetLen {
+		return nil, true
+	}
+	payload := data[5 : 4+packetLen-paddingLen]
+	var msg clientKexInitMsg
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return nil, true
+	}
+	return &msg, true
+}
+
+func getConnectionNegotiatedAlgorithms(conn ssh.ConnMetadata) *negotiatedAlgorithms {
+	if conn == nil {
+		return nil
+	}
+	val, ok := kexInitConns.Load(getKexInitConnKey(conn.LocalAddr(), conn.RemoteAddr()))
+	if !ok {
+		return nil
+	}
+	return val.(*kexInitConn).getNegotiatedAlgorithms()
+}
@@ -0,0 +1,395 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	sftpPacketVersion       = 2
+	sftpPacketOpen          = 3
+	sftpPacketClose         = 4
+	sftpPacketStatus        = 101
+	sftpPacketHandle        = 102
+	sftpPacketExtended      = 200
+	sftpPacketExtendedReply = 201
+	// maximum size for the inspected packets, it matches the one used by the SFTP library
+	sftpMaxInspectedPacketSize = 256 * 1024
+	checkFileExtension         = "check-file"
+	checkFileNameRequest       = "check-file-name"
+	checkFileHandleRequest     = "check-file-handle"
+	checkFileMinBlockSize      = 256
+)
+
+// SFTP status codes
+const (
+	sftpStatusNoSuchFile       = 2
+	sftpStatusPermissionDenied = 3
+	sftpStatusFailure          = 4
+	sftpStatusBadMessage       = 5
+	sftpStatusOpUnsupported    = 8
+)
+
+var (
+	errInvalidSFTPPacket = errors.New("invalid SFTP packet")
+)
+
+// checkFileChannel wraps an SFTP channel and handles the "check-file-name" and
+// "check-file-handle" extended requests, not supported by the SFTP library.
+// All the other packets are passed through. The handles returned for open requests
+// are tracked, so the file path for "check-file-handle" requests can be resolved.
+// Outgoing packets are written to the channel as a whole, so the responses to the
+// check-file requests, sent from separate goroutines, cannot be interleaved with them
+type checkFileChannel struct {
+	channel        io.ReadWriteCloser
+	connection     *Connection
+	startDirectory string
+	// read side, accessed only from the goroutine reading the SFTP packets
+	header        [5]byte
+	readBuf       []byte
+	readRemaining uint32
+	// write side, the SFTP library serializes its writes
+	writeBuf     []byte
+	writeMu      sync.Mutex
+	mu           sync.Mutex
+	openRequests map[uint32]string
+	handles      map[string]string
+}
+
+func newCheckFileChannel(channel io.ReadWriteCloser, connection *Connection, startDirectory string) *checkFileChannel {
+	if startDirectory == "" {
+		startDirectory = "/"
+	}
+	return &checkFileChannel{
+		channel:        channel,
+		connection:     connection,
+		startDirectory: startDirectory,
+		openRequests:   make(map[uint32]string),
+		handles:        make(map[string]string),
+	}
+}
+
+func (c *checkFileChannel) Read(p []byte) (int, error) {
+	for {
+		if len(c.readBuf) > 0 {
+			n := copy(p, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			return n, nil
+		}
+		if c.readRemaining > 0 {
+			if uint32(len(p)) > c.readRemaining {
+				p = p[:c.readRemaining]
+			}
+			n, err := c.channel.Read(p)
+			c.readRemaining -= uint32(n)
+			return n, err
+		}
+		if err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readPacket reads the header for the next packet. Open, close and extended
+// packets are read and inspected, the other ones are passed through
+func (c *checkFileChannel) readPacket() error {
+	if _, err := io.ReadFull(c.channel, c.header[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(c.header[:4])
+	if length == 0 {
+		return errInvalidSFTPPacket
+	}
+	switch c.header[4] {
+	case sftpPacketOpen, sftpPacketClose, sftpPacketExtended:
+		if length > sftpMaxInspectedPacketSize {
+			break
+		}
+		packet := make([]byte, 4+length)
+		copy(packet, c.header[:])
+		if _, err := io.ReadFull(c.channel, packet[len(c.header):]); err != nil {
+			return err
+		}
+		if c.inspectRequest(packet) {
+			c.readBuf = packet
+		}
+		return nil
+	}
+	c.readBuf = c.header[:]
+	c.readRemaining = length - 1
+	return nil
+}
+
+// inspectRequest returns false if the request was handled here
+// and so it must not be passed to the SFTP library
+func (c *checkFileChannel) inspectRequest(packet []byte) bool {
+	id, data, err := unmarshalUint32(packet[5:])
+	if err != nil {
+		return true
+	}
+	value, data, err := unmarshalString(data)
+	if err != nil {
+		return true
+	}
+	switch packet[4] {
+	case sftpPacketOpen:
+		c.mu.Lock()
+		c.openRequests[id] = c.cleanPath(value)
+		c.mu.Unlock()
+	case sftpPacketClose:
+		c.mu.Lock()
+		delete(c.handles, value)
+		c.mu.Unlock()
+	case sftpPacketExtended:
+		if value == checkFileNameRequest || value == checkFileHandleRequest {
+			go c.handleCheckFile(id, value, data)
+			return false
+		}
+	}
+	return true
+}
+
+func (c *checkFileChannel) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(c.writeBuf) < 4 {
+			toCopy := 4 - len(c.writeBuf)
+			if toCopy > len(p) {
+				toCopy = len(p)
+			}
+			c.writeBuf = append(c.writeBuf, p[:toCopy]...)
+			p = p[toCopy:]
+		}
+		if len(c.writeBuf) < 4 {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(c.writeBuf)) + 4
+		toCopy := size - len(c.writeBuf)
+		if toCopy > len(p) {
+			toCopy = len(p)
+		}
+		c.writeBuf = append(c.writeBuf, p[:toCopy]...)
+		p = p[toCopy:]
+		if len(c.writeBuf) == size {
+			err := c.writePacket(c.inspectResponse(c.writeBuf))
+			c.writeBuf = c.writeBuf[:0]
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *checkFileChannel) writePacket(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.channel.Write(packet)
+	return err
+}
+
+// inspectResponse tracks the handles for the open requests and
+// adds the check-file extension to the version packet
+func (c *checkFileChannel) inspectResponse(packet []byte) []byte {
+	if len(packet) < 5 {
+		return packet
+	}
+	switch packet[4] {
+	case sftpPacketVersion:
+		packet = marshalString(packet, checkFileExtension)
+		packet = marshalString(packet, strings.Join(common.SupportedFileHashes, ","))
+		binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	case sftpPacketHandle, sftpPacketStatus:
+		id, data, err := unmarshalUint32(packet[5:])
+		if err != nil {
+			return packet
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		name, ok := c.openRequests[id]
+		if !ok {
+			return packet
+		}
+		delete(c.openRequests, id)
+		if packet[4] == sftpPacketHandle {
+			if handle, _, err := unmarshalString(data); err == nil {
+				c.handles[handle] = name
+			}
+		}
+	}
+	return packet
+}
+
+func (c *checkFileChannel) Close() error {
+	return c.channel.Close()
+}
+
+func (c *checkFileChannel) cleanPath(name string) string {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if !path.IsAbs(name) {
+		return path.Join(c.startDirectory, name)
+	}
+	return name
+}
+
+func (c *checkFileChannel) handleCheckFile(id uint32, request string, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, c.connection.GetID(), "panic in handleCheckFile: %q", r)
+		}
+	}()
+
+	target, data, err := unmarshalString(data)
+	if err != nil {
+		c.sendStatus(id, sftpStatusBadMessage, err)
+		return
+	}
+	algos, data, err := unmarshalString(data)
+	if err != nil {
+		c.sendStatus(id, sftpStatusBadMessage, err)
+		return
+	}
+	offset, data, err := unmarshalUint64(data)
+	if err != nil {
+		c.sendStatus(id, sftpStatusBadMessage, err)
+		return
+	}
+	length, data, err := unmarshalUint64(data)
+	if err != nil {
+		c.sendStatus(id, sftpStatusBadMessage, err)
+		return
+	}
+	blockSize, _, err := unmarshalUint32(data)
+	if err != nil {
+		c.sendStatus(id, sftpStatusBadMessage, err)
+		return
+	}
+	if blockSize > 0 && blockSize < checkFileMinBlockSize {
+		c.sendStatus(id, sftpStatusFailure, errors.New("invalid block size"))
+		return
+	}
+	name := c.cleanPath(target)
+	if request == checkFileHandleRequest {
+		c.mu.Lock()
+		handleName, ok := c.handles[target]
+		c.mu.Unlock()
+		if !ok {
+			c.sendStatus(id, sftpStatusFailure, errors.New("invalid handle"))
+			return
+		}
+		name = handleName
+	}
+	algo := ""
+	for _, a := range strings.Split(algos, ",") {
+		if util.Contains(common.SupportedFileHashes, strings.TrimSpace(a)) {
+			algo = strings.TrimSpace(a)
+			break
+		}
+	}
+	if algo == "" {
+		c.sendStatus(id, sftpStatusOpUnsupported, errors.New("no supported hash algorithm"))
+		return
+	}
+	hash, err := c.connection.ComputeFileHash(name, algo, int64(offset), int64(length), int64(blockSize))
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "%s request for %q failed: %v", request, name, err)
+		c.sendStatus(id, getSFTPStatusCode(err), err)
+		return
+	}
+	c.connection.Log(logger.LevelDebug, "%s request for %q completed, algo %s, offset %d, length %d, block size %d",
+		request, name, algo, offset, length, blockSize)
+
+	packet := make([]byte, 4, 4+1+4+4+len(checkFileExtension)+4+len(algo)+len(hash))
+	packet = append(packet, sftpPacketExtendedReply)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = marshalString(packet, checkFileExtension)
+	packet = marshalString(packet, algo)
+	packet = append(packet, hash...)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	if err := c.writePacket(packet); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to send %s response: %v", request, err)
+	}
+}
+
+func (c *checkFileChannel) sendStatus(id, code uint32, err error) {
+	packet := make([]byte, 4, 4+1+4+4+4+len(err.Error())+4)
+	packet = append(packet, sftpPacketStatus)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = binary.BigEndian.AppendUint32(packet, code)
+	packet = marshalString(packet, err.Error())
+	packet = marshalString(packet, "")
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	if err := c.writePacket(packet); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to send status response: %v", err)
+	}
+}
+
+func getSFTPStatusCode(err error) uint32 {
+	switch {
+	case errors.Is(err, sftp.ErrSSHFxNoSuchFile):
+		return sftpStatusNoSuchFile
+	case errors.Is(err, sftp.ErrSSHFxPermissionDenied):
+		return sftpStatusPermissionDenied
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return sftpStatusOpUnsupported
+	default:
+		return sftpStatusFailure
+	}
+}
+
+func marshalString(b []byte, value string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func unmarshalUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errInvalidSFTPPacket
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func unmarshalUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errInvalidSFTPPacket
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+func unmarshalString(b []byte) (string, []byte, error) {
+	length, b, err := unmarshalUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(b)) < length {
+		return "", nil, errInvalidSFTPPacket
+	}
+	return string(b[:length]), b[length:], nil
+}
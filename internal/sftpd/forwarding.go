@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	forwardDialTimeout = 10 * time.Second
+)
+
+// directTCPIPPayload is the extra data sent with a direct-tcpip channel
+// open request, see RFC 4254 section 7.2
+type directTCPIPPayload struct {
+	Host       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// forwardReader updates the SSH connection last activity while data are
+// transferred, so a forwarded connection is not considered idle
+type forwardReader struct {
+	r             io.Reader
+	sshConnection *common.SSHConnection
+}
+
+func (r *forwardReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sshConnection.UpdateLastActivity()
+	}
+	return n, err
+}
+
+func (c *Configuration) handleDirectTCPIP(newChannel ssh.NewChannel, user *dataprovider.User,
+	sshConnection *common.SSHConnection,
+) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handleDirectTCPIP: %q stack trace: %v", r, string(debug.Stack()))
+		}
+	}()
+
+	connectionID := sshConnection.GetID()
+	var payload directTCPIPPayload
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, connectionID, "invalid direct-tcpip payload: %v", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload") //nolint:errcheck
+		return
+	}
+	addr := net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10))
+	if !c.AllowTCPForwarding || !user.IsTCPForwardAllowed(payload.Host, payload.Port) {
+		logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
+			"TCP forwarding to %q not allowed for user %q, enabled: %t", addr, user.Username, c.AllowTCPForwarding)
+		newChannel.Reject(ssh.Prohibited, "port forwarding is not allowed") //nolint:errcheck
+		return
+	}
+	conn, err := net.DialTimeout("tcp", addr, forwardDialTimeout)
+	if err != nil {
+		logger.Log(logger.LevelWarn, common.ProtocolSSH, connectionID, "unable to connect to %q: %v", addr, err)
+		newChannel.Reject(ssh.ConnectionFailed, "unable to connect to the requested destination") //nolint:errcheck
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		logger.Log(logger.LevelWarn, common.ProtocolSSH, connectionID, "could not accept a direct-tcpip channel: %v", err)
+		return
+	}
+	defer channel.Close()
+
+	go ssh.DiscardRequests(requests)
+
+	logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
+		"TCP forwarding started for user %q, destination %q, originator \"%s:%d\"", user.Username, addr,
+		payload.OriginAddr, payload.OriginPort)
+	sshConnection.UpdateLastActivity()
+
+	var wg sync.WaitGroup
+	var sent, received int64
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		received, _ = io.Copy(channel, &forwardReader{r: conn, sshConnection: sshConnection})
+		channel.CloseWrite() //nolint:errcheck
+	}()
+	go func() {
+		defer wg.Done()
+
+		sent, _ = io.Copy(conn, &forwardReader{r: channel, sshConnection: sshConnection})
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite() //nolint:errcheck
+		}
+	}()
+	wg.Wait()
+
+	logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
+		"TCP forwarding ended for user %q, destination %q, bytes sent: %d, bytes received: %d", user.Username, addr,
+		sent, received)
+}
@@ -74,6 +74,10 @@ func (c *Connection) GetCommand() string {
 func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	c.UpdateLastActivity()
 
+	if archive, ok := c.GetArchiveDownload(request.Filepath); ok {
+		return c.OpenArchiveDownload(archive)
+	}
+
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
@@ -233,6 +237,9 @@ func (c *Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 
 		s, err := c.DoStat(request.Filepath, 0, true)
 		if err != nil {
+			if archive, ok := c.GetArchiveDownload(request.Filepath); ok {
+				return listerAt([]os.FileInfo{archive.FileInfo()}), nil
+			}
 			return nil, err
 		}
 
@@ -275,6 +282,9 @@ func (c *Connection) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
 
 	s, err := c.DoStat(request.Filepath, 1, true)
 	if err != nil {
+		if archive, ok := c.GetArchiveDownload(request.Filepath); ok {
+			return listerAt([]os.FileInfo{archive.FileInfo()}), nil
+		}
 		return nil, err
 	}
 
@@ -400,6 +410,9 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, pflags sftp.FileOpenFl
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, c.GetQuotaExceededError()
 	}
+	if err := c.CheckDirLimits(requestPath); err != nil {
+		return nil, err
+	}
 
 	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
@@ -442,8 +455,10 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	isResume := !isTruncate
 	// if there is a size limit the remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before.
-	// For Cloud FS GetMaxWriteSize will return unsupported operation
-	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, fs.IsUploadResumeSupported())
+	// For Cloud FS GetMaxWriteSize will return unsupported operation, GCS supports
+	// resume if the client passed the APPEND flag
+	isAppend := isResume && pflags.Append && !fs.IsUploadResumeSupported() && vfs.HasAppendUploadSupport(fs)
+	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, fs.IsUploadResumeSupported() || isAppend)
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
@@ -463,7 +478,11 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		}
 	}
 
-	file, w, cancelFn, err := fs.Create(filePath, osFlags)
+	createFlags := osFlags
+	if isAppend {
+		createFlags |= os.O_APPEND
+	}
+	file, w, cancelFn, err := fs.Create(filePath, createFlags)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, os flags %v, pflags: %+v, source: %q, err: %+v",
 			osFlags, pflags, filePath, err)
@@ -484,7 +503,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		if isTruncate && vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 				}
@@ -0,0 +1,149 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	// minimum interval between two checks for host certificates changes
+	hostCertsCheckInterval = time.Minute
+)
+
+// hostKeysManager holds the private host keys and the host certificates.
+// Host certificates are reloaded if the related files change, SSH server
+// configurations including the current certificates are built on demand
+type hostKeysManager struct {
+	sync.RWMutex
+	keys         []ssh.Signer
+	certPaths    []string
+	certSigners  []ssh.Signer
+	certsInfo    map[string]fs.FileInfo
+	lastCheck    time.Time
+	checkEnabled bool
+	configs      map[*ssh.ServerConfig]*ssh.ServerConfig
+}
+
+func newHostKeysManager(keys []ssh.Signer, certPaths []string) *hostKeysManager {
+	return &hostKeysManager{
+		keys:         keys,
+		certPaths:    certPaths,
+		certsInfo:    make(map[string]fs.FileInfo),
+		checkEnabled: len(certPaths) > 0,
+		configs:      make(map[*ssh.ServerConfig]*ssh.ServerConfig),
+	}
+}
+
+func (m *hostKeysManager) loadCertificates() error {
+	certsInfo := make(map[string]fs.FileInfo)
+	for _, certPath := range m.certPaths {
+		info, err := os.Stat(certPath)
+		if err == nil {
+			certsInfo[certPath] = info
+		}
+	}
+	certs, err := loadHostCertificates(m.certPaths)
+	if err != nil {
+		return err
+	}
+	var signers []ssh.Signer
+	for _, cert := range certs {
+		for _, key := range m.keys {
+			signer, err := ssh.NewCertSigner(cert, key)
+			if err == nil {
+				signers = append(signers, signer)
+				logger.Info(logSender, "", "Host certificate loaded for host key type %q, fingerprint %q",
+					key.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()))
+			}
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.certSigners = signers
+	m.certsInfo = certsInfo
+	m.lastCheck = time.Now()
+	m.configs = make(map[*ssh.ServerConfig]*ssh.ServerConfig)
+	return nil
+}
+
+// checkCertificates reloads the host certificates if the related files changed
+func (m *hostKeysManager) checkCertificates() {
+	m.Lock()
+	if !m.checkEnabled || time.Since(m.lastCheck) < hostCertsCheckInterval {
+		m.Unlock()
+		return
+	}
+	m.lastCheck = time.Now()
+	oldInfo := m.certsInfo
+	m.Unlock()
+
+	isChanged := false
+	for _, certPath := range m.certPaths {
+		info, err := os.Stat(certPath)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to stat host certificate %q: %v", certPath, err)
+			return
+		}
+		old, ok := oldInfo[certPath]
+		if !ok || info.Size() != old.Size() || !info.ModTime().Equal(old.ModTime()) {
+			logger.Debug(logSender, "", "change detected for host certificate %q, reload required", certPath)
+			isChanged = true
+		}
+	}
+	if isChanged {
+		if err := m.loadCertificates(); err != nil {
+			logger.Warn(logSender, "", "unable to reload host certificates, the previous ones will be used: %v", err)
+		}
+	}
+}
+
+// getServerConfig returns a copy of the specified configuration with the
+// host keys and the current host certificates
+func (m *hostKeysManager) getServerConfig(serverConfig *ssh.ServerConfig) *ssh.ServerConfig {
+	m.checkCertificates()
+
+	m.RLock()
+	config, ok := m.configs[serverConfig]
+	m.RUnlock()
+	if ok {
+		return config
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if config, ok := m.configs[serverConfig]; ok {
+		return config
+	}
+	cfg := *serverConfig
+	for _, key := range m.keys {
+		cfg.AddHostKey(key)
+	}
+	for _, signer := range m.certSigners {
+		cfg.AddHostKey(signer)
+	}
+	m.configs[serverConfig] = &cfg
+	return &cfg
+}
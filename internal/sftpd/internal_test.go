@@ -16,14 +16,19 @@ package sftpd
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -37,6 +42,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -265,6 +271,28 @@ func TestReadWriteErrors(t *testing.T) {
 	assert.Len(t, conn.GetTransfers(), 0)
 }
 
+func TestPipeWriterAtOffset(t *testing.T) {
+	r, w, err := pipeat.Pipe()
+	assert.NoError(t, err)
+	pipeWriter := vfs.NewPipeWriterAtOffset(w, 10)
+	go func() {
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("testdata"), data)
+		pipeWriter.Done(r.Close())
+	}()
+	_, err = pipeWriter.WriteAt([]byte("test"), 5)
+	assert.Error(t, err)
+	n, err := pipeWriter.WriteAt([]byte("test"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	n, err = pipeWriter.WriteAt([]byte("data"), 14)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	err = pipeWriter.Close()
+	assert.NoError(t, err)
+}
+
 func TestUnsupportedListOP(t *testing.T) {
 	conn := common.NewBaseConnection("", common.ProtocolSFTP, "", "", dataprovider.User{})
 	sftpConn := Connection{
@@ -866,6 +894,323 @@ func TestRsyncOptions(t *testing.T) {
 	assert.EqualError(t, err, errUnsupportedConfig.Error())
 }
 
+func TestRsyncArgs(t *testing.T) {
+	err := validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", ".", "/"})
+	assert.NoError(t, err)
+	err = validateRsyncArgs([]string{"--server", "--sender", "-vlogDtprze.iLsfxC", ".", "/dir"})
+	assert.NoError(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", "--timeout=30", ".", "."})
+	assert.NoError(t, err)
+	err = validateRsyncArgs([]string{"-vlogDtprze.iLsfxC", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "--sender", "-vlogDtprze.iLsfxC", ".", "/etc/passwd", "/dir"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", "--temp-dir", "/tmp", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", "--log-file=/tmp/log", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-T", "/tmp", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "--sender", "-logDtpre.iLsfxC", "--delete", "--partial",
+		"--bwlimit=100", "--safe-links", ".", "/dir"})
+	assert.NoError(t, err)
+	err = validateRsyncArgs([]string{"--server", "-e.iLsfxC", ".", "/dir"})
+	assert.NoError(t, err)
+	// attached short option values
+	err = validateRsyncArgs([]string{"--server", "-T/tmp", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-M--log-file=/tmp/log", ".", "/"})
+	assert.Error(t, err)
+	// clustered short options
+	err = validateRsyncArgs([]string{"--server", "-vT", "/tmp", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-aM", "--log-file=/tmp/log", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprse.iLsfxC", ".", "/"})
+	assert.Error(t, err)
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLs/fxC", ".", "/"})
+	assert.Error(t, err)
+	// abbreviated long options
+	for _, opt := range []string{"--temp-d=/etc", "--log-fi=/tmp/log", "--backup-d=", "--files-fr=/tmp/list",
+		"--partial=/tmp", "--temp-dir=/tmp", "--protect", "--timeout", "--time=30"} {
+		err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", opt, ".", "/"})
+		assert.Error(t, err, opt)
+	}
+	err = validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", "/tmp", ".", "/"})
+	assert.Error(t, err)
+
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Permissions: permissions,
+			HomeDir:     os.TempDir(),
+		},
+	}
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
+	}
+	sshCmd := sshCommand{
+		command:    "rsync",
+		connection: conn,
+		args:       []string{"--server", "-vlogDtprze.iLsfxC", "--backup-dir=/tmp", ".", "/"},
+	}
+	_, err = sshCmd.getSystemCommand()
+	assert.EqualError(t, err, errUnsupportedConfig.Error())
+}
+
+func TestGitArgs(t *testing.T) {
+	err := validateGitArgs([]string{"/repo.git"})
+	assert.NoError(t, err)
+	err = validateGitArgs([]string{"--strict", "--timeout=30", "/repo.git"})
+	assert.NoError(t, err)
+	err = validateGitArgs(nil)
+	assert.Error(t, err)
+	err = validateGitArgs([]string{"/etc", "/repo.git"})
+	assert.Error(t, err)
+
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Permissions: permissions,
+			HomeDir:     os.TempDir(),
+		},
+	}
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
+	}
+	sshCmd := sshCommand{
+		command:    "git-receive-pack",
+		connection: conn,
+		args:       []string{"/repo.git"},
+	}
+	cmd, err := sshCmd.getSystemCommand()
+	assert.NoError(t, err)
+	for _, env := range gitEnv {
+		assert.True(t, util.Contains(cmd.cmd.Env, env), "env %q not found", env)
+	}
+	sshCmd.args = []string{"--upload-archive", "/repo.git"}
+	_, err = sshCmd.getSystemCommand()
+	assert.EqualError(t, err, errUnsupportedConfig.Error())
+}
+
+func TestGitRepositoryConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git command not found, unable to execute this test")
+	}
+	repoPath := filepath.Join(os.TempDir(), "repo")
+	err := os.MkdirAll(repoPath, os.ModePerm)
+	assert.NoError(t, err)
+	defer os.RemoveAll(repoPath)
+
+	err = checkGitRepositoryConfig(repoPath)
+	assert.NoError(t, err)
+	configPath := filepath.Join(repoPath, "config")
+	err = os.WriteFile(configPath, []byte("[core]\n\tbare = true\n[receive]\n\tdenyDeletes = true\n"), 0666)
+	assert.NoError(t, err)
+	err = checkGitRepositoryConfig(repoPath + string(os.PathSeparator))
+	assert.NoError(t, err)
+	for _, config := range []string{
+		"[tar \"tar.xz\"]\n\tcommand = xz -c\n",
+		"[TAR \"Evil\"]\n\tCommand = sh\n",
+		"[core]\n\talternateRefsCommand = sh\n",
+		"[core]\n\tsshCommand = sh\n",
+		"[credential]\n\thelper = sh\n",
+		"[diff \"x\"]\n\ttextconv = sh\n",
+		"[filter \"x\"]\n\tclean = sh\n",
+		"[remote \"origin\"]\n\tuploadpack = sh\n",
+		"[include]\n\tpath = /tmp/config\n",
+		"[includeIf \"gitdir:/\"]\n\tpath = /tmp/config\n",
+		"[invalid",
+	} {
+		err = os.WriteFile(configPath, []byte(config), 0666)
+		assert.NoError(t, err)
+		err = checkGitRepositoryConfig(repoPath)
+		assert.Error(t, err, "config %q must be rejected", config)
+	}
+	err = os.Remove(configPath)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(repoPath, ".git"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(repoPath, ".git", "config"), []byte("[core]\n\tpager = sh\n"), 0666)
+	assert.NoError(t, err)
+	err = checkGitRepositoryConfig(repoPath)
+	assert.Error(t, err)
+	err = os.RemoveAll(filepath.Join(repoPath, ".git"))
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(repoPath, ".git"), []byte("gitdir: /tmp/repo"), 0666)
+	assert.NoError(t, err)
+	err = checkGitRepositoryConfig(repoPath)
+	assert.Error(t, err)
+	err = os.Remove(filepath.Join(repoPath, ".git"))
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(repoPath, "commondir"), []byte("/tmp/repo"), 0666)
+	assert.NoError(t, err)
+	err = checkGitRepositoryConfig(repoPath)
+	assert.Error(t, err)
+}
+
+func TestClientKexInit(t *testing.T) {
+	msg := clientKexInitMsg{
+		KexAlgos:                []string{"curve25519-sha256", "ext-info-c"},
+		ServerHostKeyAlgos:      []string{ssh.KeyAlgoED25519},
+		CiphersClientServer:     []string{"aes128-gcm@openssh.com", "aes256-ctr"},
+		CiphersServerClient:     []string{"aes256-ctr"},
+		MACsClientServer:        []string{"hmac-sha2-256-etm@openssh.com"},
+		MACsServerClient:        []string{"hmac-sha2-512", "hmac-sha2-256"},
+		CompressionClientServer: []string{"none"},
+		CompressionServerClient: []string{"none"},
+	}
+	payload := ssh.Marshal(&msg)
+	paddingLen := 8
+	packet := make([]byte, 5, 5+len(payload)+paddingLen)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+paddingLen))
+	packet[4] = byte(paddingLen)
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, paddingLen)...)
+	data := append([]byte("SSH-2.0-Go\r\n"), packet...)
+
+	_, ok := parseClientKexInit(data[:5])
+	assert.False(t, ok)
+	_, ok = parseClientKexInit(data[:len(data)-1])
+	assert.False(t, ok)
+	parsed, ok := parseClientKexInit(data)
+	assert.True(t, ok)
+	if assert.NotNil(t, parsed) {
+		assert.Equal(t, msg.KexAlgos, parsed.KexAlgos)
+		assert.Equal(t, msg.MACsServerClient, parsed.MACsServerClient)
+	}
+	invalidData := append([]byte("SSH-2.0-Go\r\n"), 0, 0, 0, 5, 10, 1, 2, 3, 4)
+	parsed, ok = parseClientKexInit(invalidData)
+	assert.True(t, ok)
+	assert.Nil(t, parsed)
+
+	config := &ssh.ServerConfig{}
+	config.MACs = []string{"hmac-sha2-256"}
+	conn := newKexInitConn(&net.TCPConn{}, config)
+	conn.inspect(data[:10])
+	assert.Nil(t, conn.getNegotiatedAlgorithms())
+	conn.inspect(data[10:])
+	algos := conn.getNegotiatedAlgorithms()
+	if assert.NotNil(t, algos) {
+		assert.Equal(t, "curve25519-sha256", algos.Kex)
+		assert.Equal(t, "aes128-gcm@openssh.com", algos.CipherClientToSrv)
+		assert.Equal(t, "aes256-ctr", algos.CipherSrvToClient)
+		assert.Empty(t, algos.MACClientToSrv)
+		assert.Equal(t, "hmac-sha2-256", algos.MACSrvToClient)
+	}
+	user := dataprovider.User{}
+	assert.NoError(t, algos.checkUser(&user))
+	user.Filters.SSHAlgorithms.KexAlgorithms = []string{"curve25519-sha256"}
+	user.Filters.SSHAlgorithms.MACs = []string{"hmac-sha2-256"}
+	assert.NoError(t, algos.checkUser(&user))
+	user.Filters.SSHAlgorithms.Ciphers = []string{"aes256-ctr"}
+	assert.Error(t, algos.checkUser(&user))
+	user.Filters.SSHAlgorithms.Ciphers = []string{"aes256-ctr", "aes128-gcm@openssh.com"}
+	assert.NoError(t, algos.checkUser(&user))
+	user.Filters.SSHAlgorithms.MACs = []string{"hmac-sha2-512"}
+	assert.Error(t, algos.checkUser(&user))
+	user.Filters.SSHAlgorithms.KexAlgorithms = []string{"ecdh-sha2-nistp256"}
+	assert.Error(t, algos.checkUser(&user))
+}
+
+func TestBindingAlgorithms(t *testing.T) {
+	serverConfig := &ssh.ServerConfig{}
+	serverConfig.Ciphers = []string{"aes128-gcm@openssh.com"}
+	b := Binding{
+		Port: 2022,
+	}
+	config, err := b.getServerConfig(serverConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, serverConfig, config)
+
+	b.KexAlgorithms = []string{"diffie-hellman-group14-sha1"}
+	b.Ciphers = []string{"aes128-cbc", "aes128-cbc"}
+	b.MACs = []string{"hmac-sha1"}
+	config, err = b.getServerConfig(serverConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"diffie-hellman-group14-sha1"}, config.KeyExchanges)
+	assert.Equal(t, []string{"aes128-cbc"}, config.Ciphers)
+	assert.Equal(t, []string{"hmac-sha1"}, config.MACs)
+	assert.Equal(t, []string{"aes128-gcm@openssh.com"}, serverConfig.Ciphers)
+
+	b.MACs = []string{"not a MAC"}
+	_, err = b.getServerConfig(serverConfig)
+	assert.Error(t, err)
+	b.MACs = nil
+	b.KexAlgorithms = []string{"not a KEX"}
+	_, err = b.getServerConfig(serverConfig)
+	assert.Error(t, err)
+}
+
+func TestBindingLDAPDirectory(t *testing.T) {
+	c := Configuration{}
+	serverConfig := &ssh.ServerConfig{}
+	b := Binding{
+		Port: 2022,
+	}
+	config, err := c.getBindingServerConfig(&b, serverConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, serverConfig, config)
+
+	b.LDAPDirectory = "missing"
+	_, err = c.getBindingServerConfig(&b, serverConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not defined")
+	}
+}
+
+func TestBindingRADIUSServer(t *testing.T) {
+	c := Configuration{}
+	serverConfig := &ssh.ServerConfig{}
+	b := Binding{
+		Port:          2022,
+		LDAPDirectory: "ldap",
+		RADIUSServer:  "radius",
+	}
+	_, err := c.getBindingServerConfig(&b, serverConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot be used together")
+	}
+	b.LDAPDirectory = ""
+	_, err = c.getBindingServerConfig(&b, serverConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not defined")
+	}
+}
+
+func TestBindingKerberosService(t *testing.T) {
+	c := Configuration{}
+	serverConfig := &ssh.ServerConfig{}
+	b := Binding{
+		Port:            2022,
+		KerberosService: "krb",
+	}
+	_, err := c.getBindingServerConfig(&b, serverConfig)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not defined")
+	}
+	// a new GSSAPI acceptor is used for each connection
+	srv := kerberos.NewGSSAPIServer(&kerberos.Config{})
+	serverConfig.GSSAPIWithMICConfig = &ssh.GSSAPIWithMICConfig{
+		AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+		Server: srv,
+	}
+	config := getGSSAPIServerConfig(serverConfig)
+	assert.False(t, config == serverConfig)
+	assert.NotNil(t, config.GSSAPIWithMICConfig.AllowLogin)
+	assert.False(t, config.GSSAPIWithMICConfig.Server == srv)
+	assert.True(t, serverConfig.GSSAPIWithMICConfig.Server == srv)
+	serverConfig.GSSAPIWithMICConfig.Server = nil
+	config = getGSSAPIServerConfig(serverConfig)
+	assert.True(t, config == serverConfig)
+}
+
 func TestSystemCommandSizeForPath(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
@@ -1960,17 +2305,112 @@ func TestLoadModuli(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHostCertificatesReload(t *testing.T) {
+	_, caPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	caSigner, err := ssh.NewSignerFromKey(caPrivKey)
+	require.NoError(t, err)
+	_, hostPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivKey)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(os.TempDir(), "host_cert_reload.pub")
+	writeCert := func(serial uint64) {
+		cert := &ssh.Certificate{
+			Key:             hostSigner.PublicKey(),
+			Serial:          serial,
+			CertType:        ssh.HostCert,
+			KeyId:           fmt.Sprintf("host cert %d", serial),
+			ValidPrincipals: []string{"localhost"},
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		err := cert.SignCert(rand.Reader, caSigner)
+		require.NoError(t, err)
+		err = os.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0600)
+		require.NoError(t, err)
+	}
+	getHostCertSerial := func(config *ssh.ServerConfig) uint64 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer serverConn.Close()
+
+			ssh.NewServerConn(serverConn, config) //nolint:errcheck
+		}()
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer clientConn.Close()
+
+		var serial uint64
+		clientConfig := &ssh.ClientConfig{
+			User:              "user",
+			HostKeyAlgorithms: []string{ssh.CertAlgoED25519v01},
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				if cert, ok := key.(*ssh.Certificate); ok {
+					serial = cert.Serial
+				}
+				return errors.New("stop handshake")
+			},
+		}
+		_, _, _, err = ssh.NewClientConn(clientConn, "localhost:22", clientConfig)
+		assert.Error(t, err)
+		return serial
+	}
+
+	writeCert(1)
+	m := newHostKeysManager([]ssh.Signer{hostSigner}, []string{certPath})
+	err = m.loadCertificates()
+	require.NoError(t, err)
+	require.Len(t, m.certSigners, 1)
+	baseConfig := &ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	config := m.getServerConfig(baseConfig)
+	assert.Equal(t, uint64(1), getHostCertSerial(config))
+	assert.Equal(t, config, m.getServerConfig(baseConfig))
+	// changes are detected only after the check interval
+	writeCert(22)
+	assert.Equal(t, config, m.getServerConfig(baseConfig))
+	m.lastCheck = time.Now().Add(-2 * hostCertsCheckInterval)
+	config = m.getServerConfig(baseConfig)
+	assert.Equal(t, uint64(22), getHostCertSerial(config))
+	// an invalid certificate is ignored and the previous one is used
+	err = os.WriteFile(certPath, []byte("invalid cert"), 0600)
+	require.NoError(t, err)
+	m.lastCheck = time.Now().Add(-2 * hostCertsCheckInterval)
+	config = m.getServerConfig(baseConfig)
+	assert.Equal(t, uint64(22), getHostCertSerial(config))
+	// missing certificate file
+	err = os.Remove(certPath)
+	require.NoError(t, err)
+	m.lastCheck = time.Now().Add(-2 * hostCertsCheckInterval)
+	assert.Equal(t, config, m.getServerConfig(baseConfig))
+	// without certificates no check is done
+	m = newHostKeysManager([]ssh.Signer{hostSigner}, nil)
+	err = m.loadCertificates()
+	require.NoError(t, err)
+	assert.Len(t, m.certSigners, 0)
+	assert.False(t, m.checkEnabled)
+	assert.Equal(t, uint64(0), getHostCertSerial(m.getServerConfig(baseConfig)))
+}
+
 func TestLoadHostKeys(t *testing.T) {
-	serverConfig := &ssh.ServerConfig{}
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	err := c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = os.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1982,7 +2422,7 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
@@ -1998,16 +2438,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		err = c.checkAndLoadHostKeys(keysDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -2067,7 +2507,7 @@ func TestSFTPSubSystem(t *testing.T) {
 
 func TestRecoverer(t *testing.T) {
 	c := Configuration{}
-	c.AcceptInboundConnection(nil, nil)
+	c.AcceptInboundConnection(nil, nil, nil)
 	connID := "connectionID"
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolSFTP, "", "", dataprovider.User{}),
@@ -2094,14 +2534,14 @@ func TestListernerAcceptErrors(t *testing.T) {
 	errFake := errors.New("a fake error")
 	listener := newFakeListener(errFake)
 	c := Configuration{}
-	err := c.serve(listener, nil)
+	err := c.serve(listener, nil, nil)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
 
 	errNetFake := &fakeNetError{error: errFake}
 	listener = newFakeListener(errNetFake)
-	err = c.serve(listener, nil)
+	err = c.serve(listener, nil, nil)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
@@ -2201,6 +2641,109 @@ func TestLoadRevokedUserCertsFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserCertificateAuthority(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	testPubKey := string(ssh.MarshalAuthorizedKey(sshPub))
+	ca := userCertificateAuthority{}
+	assert.False(t, ca.isEnabled())
+	_, err = ca.issue("user", testPubKey)
+	assert.ErrorIs(t, err, util.ErrMethodDisabled)
+
+	caKeyPath := filepath.Join(os.TempDir(), "test_user_ca")
+	err = util.GenerateEd25519Keys(caKeyPath)
+	require.NoError(t, err)
+	keyBytes, err := os.ReadFile(caKeyPath)
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	require.NoError(t, err)
+	ca.set(signer, 10*time.Minute)
+	assert.True(t, ca.isEnabled())
+	_, err = ca.issue("", testPubKey)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = ca.issue("user", "invalid key")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	cert, err := ca.issue("user", testPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user"}, cert.ValidPrincipals)
+	assert.Equal(t, uint32(ssh.UserCert), cert.CertType)
+	assert.Equal(t, signer.PublicKey().Marshal(), cert.SignatureKey.Marshal())
+	assert.LessOrEqual(t, cert.ValidBefore, uint64(time.Now().Add(10*time.Minute).Unix()))
+	assert.Less(t, cert.ValidAfter, uint64(time.Now().Unix()))
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), signer.PublicKey().Marshal())
+		},
+	}
+	err = checker.CheckCert("user", cert)
+	assert.NoError(t, err)
+	_, err = ca.issue("user", string(ssh.MarshalAuthorizedKey(cert)))
+	assert.ErrorIs(t, err, util.ErrValidation)
+
+	err = os.Remove(caKeyPath)
+	assert.NoError(t, err)
+	err = os.Remove(caKeyPath + ".pub")
+	assert.NoError(t, err)
+}
+
+func TestLoadUserCA(t *testing.T) {
+	userCA.mu.RLock()
+	signer := userCA.signer
+	validity := userCA.validity
+	userCA.mu.RUnlock()
+	defer userCA.set(signer, validity)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	configDir := filepath.Join(os.TempDir(), "user_ca_dir")
+	err = os.MkdirAll(configDir, os.ModePerm)
+	require.NoError(t, err)
+	c := Configuration{}
+	err = c.loadUserCA(configDir)
+	assert.NoError(t, err)
+	assert.Len(t, c.parsedUserCAKeys, 0)
+	c.UserCAKey = "."
+	err = c.loadUserCA(configDir)
+	assert.Error(t, err)
+	c.UserCAKey = " user_ca "
+	c.UserCertValidity = 0
+	err = c.loadUserCA(configDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "user_ca", c.UserCAKey)
+	assert.Equal(t, defaultUserCertValidity, c.UserCertValidity)
+	assert.FileExists(t, filepath.Join(configDir, "user_ca"))
+	assert.Len(t, c.parsedUserCAKeys, 1)
+	assert.True(t, IsUserCAEnabled())
+	certificate, expiresAt, err := IssueUserCertificate("user", string(ssh.MarshalAuthorizedKey(sshPub)))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(certificate, ssh.CertAlgoED25519v01))
+	assert.WithinDuration(t, time.Now().Add(time.Duration(defaultUserCertValidity)*time.Minute), expiresAt,
+		time.Minute)
+	// the existing key is loaded and not overwritten
+	c = Configuration{
+		UserCAKey: "user_ca",
+	}
+	err = c.loadUserCA(configDir)
+	assert.NoError(t, err)
+	if assert.Len(t, c.parsedUserCAKeys, 1) {
+		userCA.mu.RLock()
+		assert.Equal(t, userCA.signer.PublicKey().Marshal(), c.parsedUserCAKeys[0].Marshal())
+		userCA.mu.RUnlock()
+	}
+	err = os.WriteFile(filepath.Join(configDir, "invalid_ca"), []byte("invalid key"), 0600)
+	assert.NoError(t, err)
+	c.UserCAKey = "invalid_ca"
+	err = c.loadUserCA(configDir)
+	assert.Error(t, err)
+
+	err = os.RemoveAll(configDir)
+	assert.NoError(t, err)
+}
+
 func TestMaxUserSessions(t *testing.T) {
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", dataprovider.User{
@@ -23,6 +23,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -75,7 +76,11 @@ func (c *scpCommand) handle() (err error) {
 		if err != nil {
 			return err
 		}
-		err = c.handleDownload(destPath)
+		if hasGlobPattern(destPath) {
+			err = c.handleGlobDownload(destPath)
+		} else {
+			err = c.handleDownload(destPath)
+		}
 		if err != nil {
 			return err
 		}
@@ -167,6 +172,10 @@ func (c *scpCommand) handleCreateDir(fs vfs.Fs, dirPath string) error {
 	if err == nil && info.IsDir() {
 		return nil
 	}
+	if err = c.connection.CheckDirLimits(dirPath); err != nil {
+		c.sendErrorMessage(nil, err)
+		return err
+	}
 
 	err = c.createDir(fs, p)
 	if err != nil {
@@ -234,6 +243,12 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		c.sendErrorMessage(nil, err)
 		return err
 	}
+	if isNewFile {
+		if err := c.connection.CheckDirLimits(requestPath); err != nil {
+			c.sendErrorMessage(nil, err)
+			return err
+		}
+	}
 	_, err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath,
 		fileSize, os.O_TRUNC)
 	if err != nil {
@@ -258,7 +273,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.connection.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateUserVirtualFolderQuota(&c.connection.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.connection.User, 0, -fileSize, false) //nolint:errcheck
 				}
@@ -278,6 +293,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	baseTransfer.SetExpectedSize(sizeToRead)
 	t := newTransfer(baseTransfer, w, nil, nil)
 
 	return c.getUploadFileData(sizeToRead, t)
@@ -545,6 +561,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, p, filePath,
 		common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+	baseTransfer.SetExpectedSize(stat.Size())
 	t := newTransfer(baseTransfer, nil, r, nil)
 
 	err = c.sendDownloadFileData(fs, p, stat, t)
@@ -559,6 +576,72 @@ func (c *scpCommand) handleDownload(filePath string) error {
 	return err
 }
 
+// handleGlobDownload expands, as a shell would do, the wildcards in the last
+// element of the requested path and downloads all the matching entries.
+// Listing the parent directory requires the list permission, files hidden or
+// denied by the filters are not matched and the download permission is checked
+// for each match as for any other download
+func (c *scpCommand) handleGlobDownload(pattern string) error {
+	if _, err := c.connection.DoStat(pattern, 0, false); err == nil {
+		// the name contains wildcards but it exists, there is nothing to expand
+		return c.handleDownload(pattern)
+	}
+	dirPath := path.Dir(pattern)
+	namePattern := path.Base(pattern)
+	if hasGlobPattern(dirPath) {
+		err := fmt.Errorf("wildcards are only supported in the last path element: %q", pattern)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+	if _, err := path.Match(namePattern, ""); err != nil {
+		err = fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+	files, err := c.connection.ListDir(dirPath)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to expand pattern %q: %v", pattern, err)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+	var matches []string
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") && !strings.HasPrefix(namePattern, ".") {
+			continue
+		}
+		if ok, _ := path.Match(namePattern, file.Name()); !ok {
+			continue
+		}
+		if file.IsDir() && !c.isRecursive() {
+			c.connection.Log(logger.LevelDebug, "skipping directory %q matching pattern %q, the copy is not recursive",
+				file.Name(), pattern)
+			continue
+		}
+		matchPath := path.Join(dirPath, file.Name())
+		if !file.IsDir() {
+			if ok, _ := c.connection.User.IsFileAllowed(matchPath); !ok {
+				c.connection.Log(logger.LevelDebug, "skipping file %q matching pattern %q, not allowed", matchPath, pattern)
+				continue
+			}
+		}
+		matches = append(matches, matchPath)
+	}
+	if len(matches) == 0 {
+		c.connection.Log(logger.LevelDebug, "no match for pattern %q", pattern)
+		err = fmt.Errorf("no matches found for %q", pattern)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+	sort.Strings(matches)
+	c.connection.Log(logger.LevelDebug, "pattern %q expanded to %d entries", pattern, len(matches))
+	for _, match := range matches {
+		if err := c.handleDownload(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *scpCommand) sendFileTime() bool {
 	return c.hasFlag("p")
 }
@@ -763,6 +846,10 @@ func (c *scpCommand) getFileUploadDestPath(fs vfs.Fs, scpDestPath, fileName stri
 	return path.Join(scpDestPath, fileName)
 }
 
+func hasGlobPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
 func getFileModeAsString(fileMode os.FileMode, isDir bool) string {
 	var defaultMode string
 	if isDir {
@@ -36,6 +36,8 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -52,6 +54,9 @@ const (
 )
 
 var (
+	// limits@openssh.com and fsync@openssh.com are not advertised: pkg/sftp
+	// request server does not dispatch them to our handlers and replies with
+	// SSH_FX_OP_UNSUPPORTED, clients fall back to their defaults
 	sftpExtensions        = []string{"statvfs@openssh.com"}
 	supportedHostKeyAlgos = []string{
 		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
@@ -119,6 +124,27 @@ type Binding struct {
 	Port int `json:"port" mapstructure:"port"`
 	// Apply the proxy configuration, if any, for this binding
 	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+	// KEX algorithms for this binding, they override the global ones.
+	// Leave empty to use the global configuration
+	KexAlgorithms []string `json:"kex_algorithms" mapstructure:"kex_algorithms"`
+	// Ciphers for this binding, they override the global ones.
+	// Leave empty to use the global configuration
+	Ciphers []string `json:"ciphers" mapstructure:"ciphers"`
+	// MAC algorithms for this binding, they override the global ones.
+	// Leave empty to use the global configuration
+	MACs []string `json:"macs" mapstructure:"macs"`
+	// Name of the LDAP directory to use for password authentication on this binding.
+	// Leave empty to use the configured authentication methods
+	LDAPDirectory string `json:"ldap_directory" mapstructure:"ldap_directory"`
+	// Name of the RADIUS server to use for password and keyboard interactive authentication
+	// on this binding. It cannot be used together with an LDAP directory
+	RADIUSServer string `json:"radius_server" mapstructure:"radius_server"`
+	// Name of the Kerberos service to use for gssapi-with-mic authentication on this binding.
+	// Leave empty to disable GSSAPI authentication
+	KerberosService string `json:"kerberos_service" mapstructure:"kerberos_service"`
+	// Countries and autonomous systems allowed or denied to connect to this binding.
+	// They are ignored if GeoIP is not configured
+	GeoIP geoip.Filter `json:"geoip" mapstructure:"geoip"`
 }
 
 // GetAddress returns the binding address
@@ -136,6 +162,48 @@ func (b *Binding) HasProxy() bool {
 	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
 }
 
+func (b *Binding) hasAlgorithmsOverride() bool {
+	return len(b.KexAlgorithms) > 0 || len(b.Ciphers) > 0 || len(b.MACs) > 0
+}
+
+// getServerConfig returns the server configuration to use for this binding.
+// If the binding overrides some algorithms a shallow copy of the global
+// configuration is returned
+func (b *Binding) getServerConfig(serverConfig *ssh.ServerConfig) (*ssh.ServerConfig, error) {
+	if !b.hasAlgorithmsOverride() {
+		return serverConfig, nil
+	}
+	config := *serverConfig
+	if len(b.KexAlgorithms) > 0 {
+		b.KexAlgorithms = util.RemoveDuplicates(b.KexAlgorithms, true)
+		for _, kex := range b.KexAlgorithms {
+			if !util.Contains(supportedKexAlgos, kex) {
+				return nil, fmt.Errorf("binding %q: unsupported key-exchange algorithm %q", b.GetAddress(), kex)
+			}
+		}
+		config.KeyExchanges = b.KexAlgorithms
+	}
+	if len(b.Ciphers) > 0 {
+		b.Ciphers = util.RemoveDuplicates(b.Ciphers, true)
+		for _, cipher := range b.Ciphers {
+			if !util.Contains(supportedCiphers, cipher) {
+				return nil, fmt.Errorf("binding %q: unsupported cipher %q", b.GetAddress(), cipher)
+			}
+		}
+		config.Ciphers = b.Ciphers
+	}
+	if len(b.MACs) > 0 {
+		b.MACs = util.RemoveDuplicates(b.MACs, true)
+		for _, mac := range b.MACs {
+			if !util.Contains(supportedMACs, mac) {
+				return nil, fmt.Errorf("binding %q: unsupported MAC algorithm %q", b.GetAddress(), mac)
+			}
+		}
+		config.MACs = b.MACs
+	}
+	return &config, nil
+}
+
 // Configuration for the SFTP server
 type Configuration struct {
 	// Identification string used by the server
@@ -181,6 +249,13 @@ type Configuration struct {
 	// Example content:
 	// ["SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es","SHA256:119+8cL/HH+NLMawRsJx6CzPF1I3xC+jpM60bQHXGE8"]
 	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
+	// Private key for the built-in SSH user certificate authority. If set, users can request
+	// short-lived certificates after logging in to the WebClient or the REST API and the
+	// issued certificates are trusted for SSH logins. A new key is generated if the file does
+	// not exist. The path can be absolute or relative to the configuration directory
+	UserCAKey string `json:"user_ca_key" mapstructure:"user_ca_key"`
+	// Validity, in minutes, for the user certificates issued by the built-in CA
+	UserCertValidity int `json:"user_cert_validity" mapstructure:"user_cert_validity"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -219,9 +294,14 @@ type Configuration struct {
 	// The prefix is only applied to SFTP requests, SCP and other SSH commands will be automatically disabled if
 	// you configure a prefix.
 	// This setting can help some migrations from OpenSSH. It is not recommended for general usage.
-	FolderPrefix     string `json:"folder_prefix" mapstructure:"folder_prefix"`
-	certChecker      *ssh.CertChecker
-	parsedUserCAKeys []ssh.PublicKey
+	FolderPrefix string `json:"folder_prefix" mapstructure:"folder_prefix"`
+	// AllowTCPForwarding specifies whether SSH local port forwarding (direct-tcpip channels)
+	// is allowed. Each user can only connect to the destinations explicitly allowed
+	// in the user's configuration
+	AllowTCPForwarding bool `json:"allow_tcp_forwarding" mapstructure:"allow_tcp_forwarding"`
+	certChecker        *ssh.CertChecker
+	hostKeys           *hostKeysManager
+	parsedUserCAKeys   []ssh.PublicKey
 }
 
 type authenticationError struct {
@@ -291,15 +371,7 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 	}
 
 	if c.PasswordAuthentication {
-		serverConfig.PasswordCallback = func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			sp, err := c.validatePasswordCredentials(conn, pass)
-			if err != nil {
-				return nil, newAuthenticationError(fmt.Errorf("could not validate password credentials: %w", err),
-					dataprovider.SSHLoginMethodPassword)
-			}
-
-			return sp, nil
-		}
+		serverConfig.PasswordCallback = c.getPasswordCallback("", "")
 		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.LoginMethodPassword)
 	}
 	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodPublicKey)
@@ -307,6 +379,80 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 	return serverConfig
 }
 
+func (c *Configuration) getPasswordCallback(ldapDirectory, radiusServer string,
+) func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+		sp, err := c.validatePasswordCredentials(conn, pass, ldapDirectory, radiusServer)
+		if err != nil {
+			return nil, newAuthenticationError(fmt.Errorf("could not validate password credentials: %w", err),
+				dataprovider.SSHLoginMethodPassword)
+		}
+
+		return sp, nil
+	}
+}
+
+// getBindingServerConfig returns the server configuration to use for the given binding
+func (c *Configuration) getBindingServerConfig(b *Binding, serverConfig *ssh.ServerConfig) (*ssh.ServerConfig, error) {
+	bindingConfig, err := b.getServerConfig(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	if b.LDAPDirectory == "" && b.RADIUSServer == "" && b.KerberosService == "" {
+		return bindingConfig, nil
+	}
+	if b.LDAPDirectory != "" && b.RADIUSServer != "" {
+		return nil, fmt.Errorf("binding %q: an LDAP directory and a RADIUS server cannot be used together", b.GetAddress())
+	}
+	if err := dataprovider.CheckLDAPDirectory(b.LDAPDirectory); err != nil {
+		return nil, fmt.Errorf("binding %q: %w", b.GetAddress(), err)
+	}
+	if err := dataprovider.CheckRADIUSServer(b.RADIUSServer); err != nil {
+		return nil, fmt.Errorf("binding %q: %w", b.GetAddress(), err)
+	}
+	if err := dataprovider.CheckKerberosService(b.KerberosService); err != nil {
+		return nil, fmt.Errorf("binding %q: %w", b.GetAddress(), err)
+	}
+	config := *bindingConfig
+	if config.PasswordCallback != nil {
+		config.PasswordCallback = c.getPasswordCallback(b.LDAPDirectory, b.RADIUSServer)
+	}
+	if b.RADIUSServer != "" {
+		// keyboard interactive authentication is required to answer RADIUS challenges
+		config.KeyboardInteractiveCallback = c.getKeyboardInteractiveCallback(b.RADIUSServer)
+	}
+	if b.KerberosService != "" {
+		service, err := dataprovider.GetKerberosService(b.KerberosService)
+		if err != nil {
+			return nil, fmt.Errorf("binding %q: %w", b.GetAddress(), err)
+		}
+		config.GSSAPIWithMICConfig = &ssh.GSSAPIWithMICConfig{
+			AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+				return c.validateGSSAPICredentials(conn, srcName, b.KerberosService)
+			},
+			Server: kerberos.NewGSSAPIServer(service),
+		}
+		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.LoginMethodKerberos)
+	}
+	return &config, nil
+}
+
+// getGSSAPIServerConfig returns a copy of the given server configuration with
+// a new GSSAPI acceptor. The acceptor holds the security context so it cannot
+// be shared between connections
+func getGSSAPIServerConfig(serverConfig *ssh.ServerConfig) *ssh.ServerConfig {
+	srv, ok := serverConfig.GSSAPIWithMICConfig.Server.(*kerberos.GSSAPIServer)
+	if !ok {
+		return serverConfig
+	}
+	config := *serverConfig
+	config.GSSAPIWithMICConfig = &ssh.GSSAPIWithMICConfig{
+		AllowLogin: serverConfig.GSSAPIWithMICConfig.AllowLogin,
+		Server:     srv.Clone(),
+	}
+	return &config
+}
+
 func (c *Configuration) updateSupportedAuthentications() {
 	serviceStatus.Authentications = util.RemoveDuplicates(serviceStatus.Authentications, false)
 
@@ -367,11 +513,15 @@ func (c *Configuration) Initialize(configDir string) error {
 		return common.ErrNoBinding
 	}
 
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
+	if err := c.checkAndLoadHostKeys(configDir); err != nil {
 		serviceStatus.HostKeys = nil
 		return err
 	}
 
+	if err := c.loadUserCA(configDir); err != nil {
+		return err
+	}
+
 	if err := c.initializeCertChecker(configDir); err != nil {
 		return err
 	}
@@ -388,16 +538,31 @@ func (c *Configuration) Initialize(configDir string) error {
 	c.checkSSHCommands()
 	c.checkFolderPrefix()
 
+	bindingConfigs := make(map[int]*ssh.ServerConfig)
+	for idx := range c.Bindings {
+		if !c.Bindings[idx].IsValid() {
+			continue
+		}
+		if err := c.Bindings[idx].GeoIP.Validate(); err != nil {
+			return fmt.Errorf("invalid GeoIP filter for binding %q: %w", c.Bindings[idx].GetAddress(), err)
+		}
+		bindingConfig, err := c.getBindingServerConfig(&c.Bindings[idx], serverConfig)
+		if err != nil {
+			return err
+		}
+		bindingConfigs[idx] = bindingConfig
+	}
+
 	exitChannel := make(chan error, 1)
 	serviceStatus.Bindings = nil
 
-	for _, binding := range c.Bindings {
+	for idx, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
 		}
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
 
-		go func(binding Binding) {
+		go func(binding Binding, serverConfig *ssh.ServerConfig) {
 			addr := binding.GetAddress()
 			util.CheckTCP4Port(binding.Port)
 			listener, err := net.Listen("tcp", addr)
@@ -417,8 +582,8 @@ func (c *Configuration) Initialize(configDir string) error {
 				listener = proxyListener
 			}
 
-			exitChannel <- c.serve(listener, serverConfig)
-		}(binding)
+			exitChannel <- c.serve(listener, serverConfig, &binding.GeoIP)
+		}(binding, bindingConfigs[idx])
 	}
 
 	serviceStatus.IsActive = true
@@ -428,7 +593,7 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
-func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig, geoIPFilter *geoip.Filter) error {
 	logger.Info(logSender, "", "server listener registered, address: %s", listener.Addr().String())
 	var tempDelay time.Duration // how long to sleep on accept failure
 
@@ -454,7 +619,7 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 		}
 		tempDelay = 0
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		go c.AcceptInboundConnection(conn, serverConfig, geoIPFilter)
 	}
 }
 
@@ -556,8 +721,15 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 			}
 		}
 	}
-	serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
-		sp, err := c.validateKeyboardInteractiveCredentials(conn, client)
+	serverConfig.KeyboardInteractiveCallback = c.getKeyboardInteractiveCallback("")
+
+	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyboardInteractive)
+}
+
+func (c *Configuration) getKeyboardInteractiveCallback(radiusServer string,
+) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		sp, err := c.validateKeyboardInteractiveCredentials(conn, client, radiusServer)
 		if err != nil {
 			return nil, newAuthenticationError(fmt.Errorf("could not validate keyboard interactive credentials: %w", err),
 				dataprovider.SSHLoginMethodKeyboardInteractive)
@@ -565,15 +737,16 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 
 		return sp, nil
 	}
-
-	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyboardInteractive)
 }
 
-func canAcceptConnection(ip string) bool {
+func canAcceptConnection(ip string, geoIPFilter *geoip.Filter) bool {
 	if common.IsBanned(ip, common.ProtocolSSH) {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %q is banned", ip)
 		return false
 	}
+	if !common.IsAllowedByGeoIP(geoIPFilter, ip, common.ProtocolSSH) {
+		return false
+	}
 	if err := common.Connections.IsNewConnectionAllowed(ip, common.ProtocolSSH); err != nil {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection not allowed from ip %q: %v", ip, err)
 		return false
@@ -589,7 +762,8 @@ func canAcceptConnection(ip string) bool {
 }
 
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
-func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
+// The GeoIP filter, if any, is the one configured for the binding that accepted the connection
+func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig, geoIPFilter *geoip.Filter) {
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error(logSender, "", "panic in AcceptInboundConnection: %q stack trace: %v", r, string(debug.Stack()))
@@ -600,7 +774,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	common.Connections.AddClientConnection(ipAddr)
 	defer common.Connections.RemoveClientConnection(ipAddr)
 
-	if !canAcceptConnection(ipAddr) {
+	if !canAcceptConnection(ipAddr, geoIPFilter) {
 		conn.Close()
 		return
 	}
@@ -608,7 +782,17 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if c.hostKeys != nil {
+		config = c.hostKeys.getServerConfig(config)
+	}
+	if config.GSSAPIWithMICConfig != nil {
+		config = getGSSAPIServerConfig(config)
+	}
+	// the client KEXINIT is inspected to check the per-user algorithm restrictions at login time
+	kexConn := newKexInitConn(conn, config)
+	kexInitConns.Store(kexConn.getKey(), kexConn)
+	sconn, chans, reqs, err := ssh.NewServerConn(kexConn, config)
+	kexInitConns.Delete(kexConn.getKey())
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection: %v", err)
 		checkAuthError(ipAddr, err)
@@ -634,8 +818,8 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	}
 
 	logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
-		"User %q logged in with %q, from ip %q, client version %q", user.Username, loginType,
-		ipAddr, string(sconn.ClientVersion()))
+		"User %q logged in with %q, from ip %q, country %q, client version %q", user.Username, loginType,
+		ipAddr, geoip.GetCountry(ipAddr), string(sconn.ClientVersion()))
 	dataprovider.UpdateLastLogin(&user)
 
 	sshConnection := common.NewSSHConnection(connectionID, conn)
@@ -647,6 +831,10 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	channelCounter := int64(0)
 	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go c.handleDirectTCPIP(newChannel, &user, sshConnection)
+			continue
+		}
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
@@ -721,7 +909,8 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	defer common.Connections.Remove(connection.GetID())
 
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, c.createHandlers(connection), sftp.WithRSAllocator(),
+	sftpChannel := newCheckFileChannel(channel, connection, connection.User.Filters.StartDirectory)
+	server := sftp.NewRequestServer(sftpChannel, c.createHandlers(connection), sftp.WithRSAllocator(),
 		sftp.WithStartDirectory(connection.User.Filters.StartDirectory))
 
 	defer server.Close()
@@ -812,10 +1001,24 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 	}
 	remoteAddr := conn.RemoteAddr().String()
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
+		common.RequestIPApproval(user, remoteAddr, common.ProtocolSSH)
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
 	}
+	if user.Filters.SSHAlgorithms.IsRestricted() {
+		algos := getConnectionNegotiatedAlgorithms(conn)
+		if algos == nil {
+			logger.Info(logSender, connectionID, "cannot login user %q, unable to detect the negotiated SSH algorithms",
+				user.Username)
+			return nil, fmt.Errorf("unable to detect the negotiated SSH algorithms for user %q", user.Username)
+		}
+		if err := algos.checkUser(user); err != nil {
+			logger.Info(logSender, connectionID, "cannot login user %q, negotiated algorithms: %s, %v",
+				user.Username, algos, err)
+			return nil, fmt.Errorf("login for user %q is not allowed: %w", user.Username, err)
+		}
+	}
 
 	json, err := json.Marshal(user)
 	if err != nil {
@@ -973,15 +1176,12 @@ func (c *Configuration) loadModuli(configDir string) {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) checkAndLoadHostKeys(configDir string) error {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
 		return err
 	}
-	hostCertificates, err := c.loadHostCertificates(configDir)
-	if err != nil {
-		return err
-	}
 	serviceStatus.HostKeys = nil
+	var keys []ssh.Signer
 	for _, hostKey := range c.HostKeys {
 		hostKey = strings.TrimSpace(hostKey)
 		if !util.IsFileInputValid(hostKey) {
@@ -1011,17 +1211,13 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		logger.Info(logSender, "", "Host key %q loaded, type %q, fingerprint %q", hostKey,
 			private.PublicKey().Type(), k.Fingerprint)
 
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(private)
-		for _, cert := range hostCertificates {
-			signer, err := ssh.NewCertSigner(cert, private)
-			if err == nil {
-				serverConfig.AddHostKey(signer)
-				logger.Info(logSender, "", "Host certificate loaded for host key %q, fingerprint %q",
-					hostKey, ssh.FingerprintSHA256(signer.PublicKey()))
-			}
-		}
+		keys = append(keys, private)
 	}
+	hostKeys := newHostKeysManager(keys, c.getHostCertificatePaths(configDir))
+	if err := hostKeys.loadCertificates(); err != nil {
+		return err
+	}
+	c.hostKeys = hostKeys
 	var fp []string
 	for idx := range serviceStatus.HostKeys {
 		h := &serviceStatus.HostKeys[idx]
@@ -1031,8 +1227,8 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 	return nil
 }
 
-func (c *Configuration) loadHostCertificates(configDir string) ([]*ssh.Certificate, error) {
-	var certs []*ssh.Certificate
+func (c *Configuration) getHostCertificatePaths(configDir string) []string {
+	var paths []string
 	for _, certPath := range c.HostCertificates {
 		certPath = strings.TrimSpace(certPath)
 		if !util.IsFileInputValid(certPath) {
@@ -1043,6 +1239,14 @@ func (c *Configuration) loadHostCertificates(configDir string) ([]*ssh.Certifica
 		if !filepath.IsAbs(certPath) {
 			certPath = filepath.Join(configDir, certPath)
 		}
+		paths = append(paths, certPath)
+	}
+	return paths
+}
+
+func loadHostCertificates(paths []string) ([]*ssh.Certificate, error) {
+	var certs []*ssh.Certificate
+	for _, certPath := range paths {
 		certBytes, err := os.ReadFile(certPath)
 		if err != nil {
 			return certs, fmt.Errorf("unable to load host certificate %q: %w", certPath, err)
@@ -1184,7 +1388,8 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 	return sshPerm, err
 }
 
-func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass []byte, ldapDirectory, radiusServer string,
+) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var sshPerm *ssh.Permissions
@@ -1194,7 +1399,12 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 		method = dataprovider.SSHLoginMethodKeyAndPassword
 	}
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
-	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
+	if radiusServer != "" {
+		user, err = dataprovider.CheckRADIUSAuth(conn.User(), string(pass), ipAddr, common.ProtocolSSH, radiusServer)
+	} else {
+		user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH, ldapDirectory)
+	}
+	if err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
 	}
 	user.Username = conn.User()
@@ -1202,7 +1412,9 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 	return sshPerm, err
 }
 
-func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge,
+	radiusServer string,
+) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var sshPerm *ssh.Permissions
@@ -1212,8 +1424,31 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
 	}
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
-	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
-		ipAddr, common.ProtocolSSH); err == nil {
+	if radiusServer != "" {
+		user, err = dataprovider.CheckRADIUSKeyboardInteractiveAuth(conn.User(), ipAddr, common.ProtocolSSH, radiusServer,
+			client)
+	} else {
+		user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
+			ipAddr, common.ProtocolSSH)
+	}
+	if err == nil {
+		sshPerm, err = loginUser(&user, method, "", conn)
+	}
+	user.Username = conn.User()
+	updateLoginMetrics(&user, ipAddr, method, err)
+	return sshPerm, err
+}
+
+func (c *Configuration) validateGSSAPICredentials(conn ssh.ConnMetadata, principal, service string,
+) (*ssh.Permissions, error) {
+	var err error
+	var user dataprovider.User
+	var sshPerm *ssh.Permissions
+
+	method := dataprovider.LoginMethodKerberos
+	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	user, err = dataprovider.CheckKerberosAuth(conn.User(), principal, ipAddr, common.ProtocolSSH, service)
+	if err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
 	}
 	user.Username = conn.User()
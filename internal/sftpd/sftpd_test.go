@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -46,6 +47,8 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -61,9 +64,12 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/config"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/ldap"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -75,6 +81,11 @@ const (
 	sftpSrvAddr2222     = "127.0.0.1:2222"
 	defaultUsername     = "test_user_sftp"
 	defaultPassword     = "test_password"
+	ldapMockPassword    = "ldap_password"
+	radiusMockSecret    = "radius_secret"
+	radiusMockPassword  = "radius_password"
+	radiusMockPIN       = "radius_pin"
+	radiusMockOTP       = "123456"
 	defaultSFTPUsername = "test_sftpfs_user"
 	testPubKey          = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0= nicola@p1"
 	testPubKey1         = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQCd60+/j+y8f0tLftihWV1YN9RSahMI9btQMDIMqts/jeNbD8jgoogM3nhF7KxfcaMKURuD47KC4Ey6iAJUJ0sWkSNNxOcIYuvA+5MlspfZDsa8Ag76Fe1vyz72WeHMHMeh/hwFo2TeIeIXg480T1VI6mzfDrVp2GzUx0SS0dMsQBjftXkuVR8YOiOwMCAH2a//M1OrvV7d/NBk6kBN0WnuIBb2jKm15PAA7+jQQG7tzwk2HedNH3jeL5GH31xkSRwlBczRK0xsCQXehAlx6cT/e/s44iJcJTHfpPKoSk6UAhPJYe7Z1QnuoawY9P9jQaxpyeImBZxxUEowhjpj2avBxKdRGBVK8R7EL8tSOeLbhdyWe5Mwc1+foEbq9Zz5j5Kd+hn3Wm1UnsGCrXUUUoZp1jnlNl0NakCto+5KmqnT9cHxaY+ix2RLUWAZyVFlRq71OYux1UHJnEJPiEI1/tr4jFBSL46qhQZv/TfpkfVW8FLz0lErfqu0gQEZnNHr3Fc= nicola@p1"
@@ -262,6 +273,7 @@ func TestMain(m *testing.M) {
 	sftpdConf.LoginBannerFile = loginBannerFileName
 	// we need to test all supported ssh commands
 	sftpdConf.EnabledSSHCommands = []string{"*"}
+	sftpdConf.AllowTCPForwarding = true
 
 	keyIntAuthPath = filepath.Join(homeBasePath, "keyintauth.sh")
 	err = os.WriteFile(keyIntAuthPath, getKeyboardInteractiveScriptContent([]string{"1", "2"}, 0, false, 1), os.ModePerm)
@@ -436,6 +448,12 @@ func TestInitialization(t *testing.T) {
 		assert.Contains(t, err.Error(), "unsupported key-exchange algorithm")
 	}
 	sftpdConf.KexAlgorithms = nil
+	sftpdConf.Bindings[0].Ciphers = []string{"not a cipher"}
+	err = sftpdConf.Initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported cipher")
+	}
+	sftpdConf.Bindings[0].Ciphers = nil
 	sftpdConf.HostKeyAlgorithms = []string{"not a host key algo"}
 	err = sftpdConf.Initialize(configDir)
 	if assert.Error(t, err) {
@@ -775,6 +793,113 @@ func TestSFTPFsEscapeHomeDir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSFTPFsPoolRecovery(t *testing.T) {
+	usePubKey := false
+	forwarder := newTCPForwarder(t, sftpServerAddr)
+	baseUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestSFTPUser(usePubKey)
+	u.FsConfig.SFTPConfig.Endpoint = forwarder.address
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	checkLogin := func() {
+		conn, client, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			err = checkBasicSFTP(client)
+			assert.NoError(t, err)
+			client.Close()
+			conn.Close()
+		}
+		assert.Eventually(t, func() bool {
+			return common.Connections.GetActiveSessions(defaultSFTPUsername) == 0
+		}, 1*time.Second, 50*time.Millisecond)
+	}
+	checkLogin()
+	// the pooled connection is reused
+	checkLogin()
+	assert.Equal(t, int32(1), forwarder.numConns.Load())
+	// the upstream connection hangs, the health check must evict it
+	forwarder.freeze()
+	checkLogin()
+	assert.Equal(t, int32(2), forwarder.numConns.Load())
+	// the upstream connection is closed
+	forwarder.closeConns()
+	checkLogin()
+	assert.Equal(t, int32(3), forwarder.numConns.Load())
+	checkLogin()
+	assert.Equal(t, int32(3), forwarder.numConns.Load())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(baseUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(baseUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSFTPFsPoolConcurrentHealthCheck(t *testing.T) {
+	usePubKey := false
+	forwarder := newTCPForwarder(t, sftpServerAddr)
+	baseUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestSFTPUser(usePubKey)
+	u.FsConfig.SFTPConfig.Endpoint = forwarder.address
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// this user connects directly to the same SFTP server, so it uses another pooled connection
+	u = getTestSFTPUser(usePubKey)
+	u.Username = defaultSFTPUsername + "_direct"
+	directUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	checkLogin := func(user dataprovider.User) error {
+		conn, client, err := getSftpClient(user, usePubKey)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer client.Close()
+
+		return checkBasicSFTP(client)
+	}
+	err = checkLogin(user)
+	assert.NoError(t, err)
+	err = checkLogin(directUser)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), forwarder.numConns.Load())
+	// the upstream connection hangs, the health check takes the whole timeout
+	forwarder.freeze()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			assert.NoError(t, checkLogin(user))
+		}()
+		time.Sleep(300 * time.Millisecond)
+	}
+	// the check in progress must not block the other sessions
+	startTime := time.Now()
+	err = checkLogin(directUser)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(startTime), 2*time.Second)
+	wg.Wait()
+	// the concurrent sessions shared the same check and the same new connection
+	assert.Equal(t, int32(2), forwarder.numConns.Load())
+	assert.Eventually(t, func() bool {
+		return common.Connections.GetActiveSessions(defaultSFTPUsername) == 0
+	}, 1*time.Second, 50*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(directUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(baseUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(baseUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestGroupSettingsOverride(t *testing.T) {
 	usePubKey := true
 	g := getTestGroup()
@@ -2585,6 +2710,235 @@ func TestDeniedLoginMethods(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTCPForwarding(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) //nolint:errcheck
+			}()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.AllowedTCPForwards = []string{"127.0.0.1:" + port}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		forwardConn, err := conn.Dial("tcp", listener.Addr().String())
+		if assert.NoError(t, err) {
+			_, err = forwardConn.Write([]byte("ping"))
+			assert.NoError(t, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(forwardConn, buf)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("ping"), buf)
+			err = forwardConn.Close()
+			assert.NoError(t, err)
+		}
+		_, err = conn.Dial("tcp", "127.0.0.1:1")
+		assert.Error(t, err)
+		_, err = conn.Dial("tcp", "localhost:"+port)
+		assert.Error(t, err)
+	}
+	user.Filters.AllowedTCPForwards = []string{"127.0.0.1:*"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:*"}, user.Filters.AllowedTCPForwards)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		forwardConn, err := conn.Dial("tcp", listener.Addr().String())
+		if assert.NoError(t, err) {
+			err = forwardConn.Close()
+			assert.NoError(t, err)
+		}
+	}
+
+	user.Filters.AllowedTCPForwards = []string{"127.0.0.1"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.Filters.AllowedTCPForwards = []string{"127.0.0.1:70000"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUserSSHAlgorithms(t *testing.T) {
+	u := getTestUser(false)
+	u.Filters.SSHAlgorithms.KexAlgorithms = []string{"ecdh-sha2-nistp384"}
+	u.Filters.SSHAlgorithms.Ciphers = []string{"aes256-ctr"}
+	u.Filters.SSHAlgorithms.MACs = []string{"hmac-sha2-256"}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	dial := func(kexs, ciphers, macs []string) error {
+		config := &ssh.ClientConfig{
+			Config: ssh.Config{
+				KeyExchanges: kexs,
+				Ciphers:      ciphers,
+				MACs:         macs,
+			},
+			User: user.Username,
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				return nil
+			},
+			Auth:    []ssh.AuthMethod{ssh.Password(defaultPassword)},
+			Timeout: 5 * time.Second,
+		}
+		conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	err = dial(nil, nil, nil)
+	assert.Error(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256"})
+	assert.NoError(t, err)
+	err = dial([]string{"curve25519-sha256@libssh.org"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256"})
+	assert.Error(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256-etm@openssh.com"})
+	assert.Error(t, err)
+	// MACs are not negotiated for AEAD ciphers
+	user.Filters.SSHAlgorithms.Ciphers = []string{"aes128-gcm@openssh.com"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes128-gcm@openssh.com"}, []string{"hmac-sha2-256-etm@openssh.com"})
+	assert.NoError(t, err)
+
+	user.Filters.SSHAlgorithms.Ciphers = []string{"not a cipher"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.Filters.SSHAlgorithms.Ciphers = nil
+	user.Filters.SSHAlgorithms.MACs = []string{"not a MAC"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.Filters.SSHAlgorithms.MACs = nil
+	user.Filters.SSHAlgorithms.KexAlgorithms = []string{"not a KEX"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestGroupSSHAlgorithms(t *testing.T) {
+	g1 := getTestGroup()
+	g1.Name = "ssh_algos_base"
+	g1.UserSettings.SSHAlgorithms.MACs = []string{"hmac-sha2-256"}
+	g1.UserSettings.SSHAlgorithms.Ciphers = []string{"aes128-gcm@openssh.com"}
+	group1, _, err := httpdtest.AddGroup(g1, http.StatusCreated)
+	assert.NoError(t, err)
+	g2 := getTestGroup()
+	g2.UserSettings.SSHAlgorithms.KexAlgorithms = []string{"ecdh-sha2-nistp384"}
+	g2.UserSettings.SSHAlgorithms.Ciphers = []string{"aes256-ctr"}
+	g2.IncludedGroups = []string{group1.Name}
+	group2, _, err := httpdtest.AddGroup(g2, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(false)
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group2.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	dial := func(kexs, ciphers, macs []string) error {
+		config := &ssh.ClientConfig{
+			Config: ssh.Config{
+				KeyExchanges: kexs,
+				Ciphers:      ciphers,
+				MACs:         macs,
+			},
+			User: user.Username,
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				return nil
+			},
+			Auth:    []ssh.AuthMethod{ssh.Password(defaultPassword)},
+			Timeout: 5 * time.Second,
+		}
+		conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	// the KEX and cipher lists are defined in the primary group, the MACs in the included group
+	err = dial(nil, nil, nil)
+	assert.Error(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256"})
+	assert.NoError(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes128-gcm@openssh.com"}, []string{"hmac-sha2-256"})
+	assert.Error(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256-etm@openssh.com"})
+	assert.Error(t, err)
+	// the user restrictions override the group ones
+	user.Filters.SSHAlgorithms.Ciphers = []string{"aes128-gcm@openssh.com"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes128-gcm@openssh.com"}, []string{"hmac-sha2-256"})
+	assert.NoError(t, err)
+	err = dial([]string{"ecdh-sha2-nistp384"}, []string{"aes256-ctr"}, []string{"hmac-sha2-256"})
+	assert.Error(t, err)
+	err = dial([]string{"curve25519-sha256"}, []string{"aes128-gcm@openssh.com"}, []string{"hmac-sha2-256"})
+	assert.Error(t, err)
+	// secondary groups are ignored
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group2.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user.Filters.SSHAlgorithms.Ciphers = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = dial(nil, nil, nil)
+	assert.NoError(t, err)
+
+	group2.UserSettings.SSHAlgorithms.MACs = []string{"not a MAC"}
+	_, resp, err := httpdtest.UpdateGroup(group2, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "unsupported MAC algorithm")
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group1, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestLoginWithIPFilters(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -2655,7 +3009,7 @@ func TestLoginAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.LoginMethodKerberos}, user.Filters.DeniedLoginMethods)
 	_, _, err = getSftpClient(user, usePubKey)
 	assert.Error(t, err)
 
@@ -3645,61 +3999,78 @@ func TestLoginExternalAuthPwdAndPubKey(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestExternalAuthMultiStepLoginKeyAndPwd(t *testing.T) {
-	if runtime.GOOS == osWindows {
-		t.Skip("this test is not available on Windows")
-	}
-	u := getTestUser(true)
-	u.Password = defaultPassword
-	u.Filters.DeniedLoginMethods = append(u.Filters.DeniedLoginMethods, []string{
-		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
-		dataprovider.SSHLoginMethodPublicKey,
-		dataprovider.LoginMethodPassword,
-		dataprovider.SSHLoginMethodKeyboardInteractive,
-	}...)
+func TestLoginLDAPAuth(t *testing.T) {
+	ldapAddr := startLDAPMockServer(t)
+	g := getTestGroup()
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
 
-	err := dataprovider.Close()
+	err = dataprovider.Close()
 	assert.NoError(t, err)
 	err = config.LoadConfig(configDir, "")
 	assert.NoError(t, err)
 	providerConf := config.GetProviderConf()
-	err = os.WriteFile(extAuthPath, getExtAuthScriptContent(u, false, false, ""), os.ModePerm)
-	assert.NoError(t, err)
-	providerConf.ExternalAuthHook = extAuthPath
-	providerConf.ExternalAuthScope = 0
+	providerConf.LDAPDirectories = []ldap.Config{
+		{
+			Name:           "testldap",
+			URL:            "ldap://" + ldapAddr,
+			Timeout:        5,
+			UserDNTemplate: "uid={{Username}},ou=people,dc=example,dc=com",
+			HomeDir:        filepath.Join(homeBasePath, "{{Username}}"),
+			QuotaSize:      "{{Attribute:quota}}",
+			Permissions:    []string{dataprovider.PermListItems, dataprovider.PermDownload},
+			GroupMappings: []ldap.GroupMapping{
+				{
+					LDAPGroup: "sftp",
+					Group:     group.Name,
+					Type:      sdk.GroupTypeSecondary,
+				},
+			},
+		},
+	}
 	err = dataprovider.Initialize(providerConf, configDir, true)
 	assert.NoError(t, err)
 
-	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	_, err = dataprovider.CheckUserAndPass(defaultUsername, "wrong", "127.0.0.1", common.ProtocolSSH, "testldap")
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	_, err = dataprovider.CheckUserAndPass(defaultUsername, ldapMockPassword, "127.0.0.1", common.ProtocolSSH, "missing")
+	assert.Error(t, err)
+	user, err := dataprovider.CheckUserAndPass(defaultUsername, ldapMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"testldap")
 	assert.NoError(t, err)
-	authMethods := []ssh.AuthMethod{
-		ssh.PublicKeys(signer),
-		ssh.Password(defaultPassword),
+	assert.Equal(t, filepath.Join(homeBasePath, defaultUsername), user.HomeDir)
+	assert.Equal(t, "ldapuser@example.com", user.Email)
+	assert.Equal(t, int64(1000000), user.QuotaSize)
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
+	if assert.Len(t, user.Groups, 1) {
+		assert.Equal(t, group.Name, user.Groups[0].Name)
+		assert.Equal(t, sdk.GroupTypeSecondary, user.Groups[0].Type)
 	}
-	conn, client, err := getCustomAuthSftpClient(u, authMethods, "")
+	// the existing user is updated and the settings not managed by LDAP are preserved
+	user.Description = "ldap user"
+	user.Groups = nil
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user, err = dataprovider.CheckUserAndPass(defaultUsername, ldapMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"testldap")
+	assert.NoError(t, err)
+	assert.Equal(t, "ldap user", user.Description)
+	assert.Len(t, user.Groups, 1)
+	// the SFTP binding does not use LDAP, the password stored after the LDAP login is accepted
+	user.Password = ldapMockPassword
+	conn, client, err := getSftpClient(user, false)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
-		assert.NoError(t, checkBasicSFTP(client))
-	}
-	// wrong sequence should fail
-	authMethods = []ssh.AuthMethod{
-		ssh.Password(defaultPassword),
-		ssh.PublicKeys(signer),
+		_, err = client.ReadDir(".")
+		assert.NoError(t, err)
 	}
-	_, _, err = getCustomAuthSftpClient(u, authMethods, "")
-	assert.Error(t, err)
-
-	// public key only auth must fail
-	_, _, err = getSftpClient(u, true)
-	assert.Error(t, err)
-	// password only auth must fail
-	_, _, err = getSftpClient(u, false)
-	assert.Error(t, err)
 
-	_, err = httpdtest.RemoveUser(u, http.StatusOK)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	err = os.RemoveAll(u.GetHomeDir())
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
 	assert.NoError(t, err)
 
 	err = dataprovider.Close()
@@ -3709,16 +4080,284 @@ func TestExternalAuthMultiStepLoginKeyAndPwd(t *testing.T) {
 	providerConf = config.GetProviderConf()
 	err = dataprovider.Initialize(providerConf, configDir, true)
 	assert.NoError(t, err)
-	err = os.Remove(extAuthPath)
-	assert.NoError(t, err)
 }
 
-func TestExternalAuthEmptyResponse(t *testing.T) {
-	if runtime.GOOS == osWindows {
-		t.Skip("this test is not available on Windows")
-	}
-	usePubKey := false
-	u := getTestUser(usePubKey)
+func TestLoginRADIUSAuth(t *testing.T) {
+	radiusAddr := startRADIUSMockServer(t)
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.UsersBaseDir = homeBasePath
+	providerConf.RADIUSServers = []radius.Config{
+		{
+			Name:    "testradius",
+			Server:  radiusAddr,
+			Secret:  radiusMockSecret,
+			Timeout: 5,
+		},
+		{
+			Name:        "testradiusprovisioning",
+			Server:      radiusAddr,
+			Secret:      radiusMockSecret,
+			Timeout:     5,
+			CreateUsers: true,
+			Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload},
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	// the user does not exist and cannot be created
+	_, err = dataprovider.CheckRADIUSAuth(defaultUsername, radiusMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"testradius")
+	assert.Error(t, err)
+	_, err = dataprovider.CheckRADIUSAuth(defaultUsername, radiusMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"missing")
+	assert.Error(t, err)
+	_, err = dataprovider.CheckRADIUSAuth(defaultUsername, "wrong", "127.0.0.1", common.ProtocolSSH,
+		"testradiusprovisioning")
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	_, err = dataprovider.GetUserWithGroupSettings(defaultUsername, "")
+	assert.Error(t, err)
+	user, err := dataprovider.CheckRADIUSAuth(defaultUsername, radiusMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"testradiusprovisioning")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(homeBasePath, defaultUsername), user.HomeDir)
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
+	// the existing user can now login using the server without provisioning
+	_, err = dataprovider.CheckRADIUSAuth(defaultUsername, radiusMockPassword, "127.0.0.1", common.ProtocolSSH,
+		"testradius")
+	assert.NoError(t, err)
+	// challenges are not supported for password authentication
+	_, err = dataprovider.CheckRADIUSAuth(defaultUsername, radiusMockPIN, "127.0.0.1", common.ProtocolSSH,
+		"testradius")
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	// keyboard interactive authentication forwards the challenges
+	var prompts []string
+	getClient := func(answers ...string) ssh.KeyboardInteractiveChallenge {
+		prompts = nil
+		return func(_, _ string, questions []string, _ []bool) ([]string, error) {
+			prompts = append(prompts, questions...)
+			if len(answers) == 0 {
+				return nil, errors.New("no more answers")
+			}
+			answer := answers[0]
+			answers = answers[1:]
+			return []string{answer}, nil
+		}
+	}
+	_, err = dataprovider.CheckRADIUSKeyboardInteractiveAuth(defaultUsername, "127.0.0.1", common.ProtocolSSH,
+		"testradius", getClient(radiusMockPIN, radiusMockOTP))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Password: ", "Enter your OTP"}, prompts)
+	_, err = dataprovider.CheckRADIUSKeyboardInteractiveAuth(defaultUsername, "127.0.0.1", common.ProtocolSSH,
+		"testradius", getClient(radiusMockPIN, "000000"))
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	_, err = dataprovider.CheckRADIUSKeyboardInteractiveAuth(defaultUsername, "127.0.0.1", common.ProtocolSSH,
+		"testradius", getClient(radiusMockPIN))
+	assert.Error(t, err)
+	_, err = dataprovider.CheckRADIUSKeyboardInteractiveAuth(defaultUsername, "127.0.0.1", common.ProtocolSSH,
+		"testradius", getClient(radiusMockPassword))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Password: "}, prompts)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestLoginKerberosAuth(t *testing.T) {
+	keytabPath := filepath.Join(os.TempDir(), "sftpgo.keytab")
+	writeTestKeytab(t, keytabPath, "host/localhost", "EXAMPLE.COM")
+	defer os.Remove(keytabPath)
+
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.KerberosServices = []kerberos.Config{
+		{
+			Name:   "testkrb",
+			Keytab: filepath.Join(os.TempDir(), "missing.keytab"),
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.KerberosServices = []kerberos.Config{
+		{
+			Name:   "testkrb",
+			Keytab: keytabPath,
+		},
+		{
+			Name:   "testkrb",
+			Keytab: keytabPath,
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicated")
+	}
+	providerConf.KerberosServices = []kerberos.Config{
+		{
+			Name:   "testkrb",
+			Keytab: keytabPath,
+		},
+		{
+			Name:      "testkrbrealm",
+			Keytab:    keytabPath,
+			KeepRealm: true,
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.CheckKerberosService(""))
+	assert.NoError(t, dataprovider.CheckKerberosService("testkrb"))
+	assert.Error(t, dataprovider.CheckKerberosService("missing"))
+
+	principal := defaultUsername + "@EXAMPLE.COM"
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, principal, "127.0.0.1", common.ProtocolSSH, "testkrb")
+	assert.Error(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(false), http.StatusCreated)
+	assert.NoError(t, err)
+
+	authUser, err := dataprovider.CheckKerberosAuth(defaultUsername, principal, "127.0.0.1", common.ProtocolSSH,
+		"testkrb")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Username, authUser.Username)
+	// the username is derived from the principal if not provided
+	authUser, err = dataprovider.CheckKerberosAuth("", principal, "127.0.0.1", common.ProtocolHTTP, "testkrb")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Username, authUser.Username)
+	_, err = dataprovider.CheckKerberosAuth("otheruser", principal, "127.0.0.1", common.ProtocolSSH, "testkrb")
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, defaultUsername+"@OTHER.COM", "127.0.0.1",
+		common.ProtocolSSH, "testkrb")
+	assert.Error(t, err)
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, defaultUsername, "127.0.0.1", common.ProtocolSSH,
+		"testkrb")
+	assert.Error(t, err)
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, principal, "127.0.0.1", common.ProtocolSSH, "missing")
+	assert.Error(t, err)
+	// the realm is part of the username
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, principal, "127.0.0.1", common.ProtocolSSH,
+		"testkrbrealm")
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+	_, err = dataprovider.CheckKerberosAuth("", principal, "127.0.0.1", common.ProtocolHTTP, "testkrbrealm")
+	assert.Error(t, err)
+
+	user.Status = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = dataprovider.CheckKerberosAuth(defaultUsername, principal, "127.0.0.1", common.ProtocolSSH, "testkrb")
+	assert.Error(t, err)
+	// Kerberos does not complete a multi-step authentication
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndKeyboardInt}
+	assert.True(t, user.IsPartialAuth(dataprovider.SSHLoginMethodPublicKey))
+	assert.True(t, user.IsLoginMethodAllowed(dataprovider.LoginMethodKerberos, common.ProtocolSSH, nil))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestExternalAuthMultiStepLoginKeyAndPwd(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	u := getTestUser(true)
+	u.Password = defaultPassword
+	u.Filters.DeniedLoginMethods = append(u.Filters.DeniedLoginMethods, []string{
+		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+		dataprovider.SSHLoginMethodPublicKey,
+		dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive,
+	}...)
+
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	err = os.WriteFile(extAuthPath, getExtAuthScriptContent(u, false, false, ""), os.ModePerm)
+	assert.NoError(t, err)
+	providerConf.ExternalAuthHook = extAuthPath
+	providerConf.ExternalAuthScope = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	assert.NoError(t, err)
+	authMethods := []ssh.AuthMethod{
+		ssh.PublicKeys(signer),
+		ssh.Password(defaultPassword),
+	}
+	conn, client, err := getCustomAuthSftpClient(u, authMethods, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// wrong sequence should fail
+	authMethods = []ssh.AuthMethod{
+		ssh.Password(defaultPassword),
+		ssh.PublicKeys(signer),
+	}
+	_, _, err = getCustomAuthSftpClient(u, authMethods, "")
+	assert.Error(t, err)
+
+	// public key only auth must fail
+	_, _, err = getSftpClient(u, true)
+	assert.Error(t, err)
+	// password only auth must fail
+	_, _, err = getSftpClient(u, false)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(u, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	err = os.Remove(extAuthPath)
+	assert.NoError(t, err)
+}
+
+func TestExternalAuthEmptyResponse(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	usePubKey := false
+	u := getTestUser(usePubKey)
 	u.QuotaFiles = 1000
 	err := dataprovider.Close()
 	assert.NoError(t, err)
@@ -4136,7 +4775,7 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.LoginMethodKerberos}, user.Filters.DeniedLoginMethods)
 
 	// test again, the user now exists
 	_, _, err = getSftpClient(u, usePubKey)
@@ -8308,7 +8947,7 @@ func TestUserAllowedLoginMethods(t *testing.T) {
 		dataprovider.SSHLoginMethodKeyboardInteractive,
 	}
 	allowedMethods = user.GetAllowedLoginMethods()
-	assert.Equal(t, 4, len(allowedMethods))
+	assert.Equal(t, 5, len(allowedMethods))
 
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndKeyboardInt))
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndPassword))
@@ -8513,6 +9152,127 @@ func TestGetVirtualFolderForPath(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCheckFileExtension(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(65535)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	fileContents, err := os.ReadFile(testFilePath)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		algos, ok := client.HasExtension("check-file")
+		assert.True(t, ok)
+		assert.Contains(t, algos, "sha256")
+
+		session, err := conn.NewSession()
+		assert.NoError(t, err)
+		defer session.Close()
+		w, err := session.StdinPipe()
+		assert.NoError(t, err)
+		r, err := session.StdoutPipe()
+		assert.NoError(t, err)
+		err = session.RequestSubsystem("sftp")
+		assert.NoError(t, err)
+		// init packet, version 3
+		err = sendSFTPPacket(w, 1, binary.BigEndian.AppendUint32(nil, 3))
+		assert.NoError(t, err)
+		packetType, _, err := readSFTPPacket(r)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(2), packetType)
+
+		packetType, payload, err := sendCheckFileRequest(w, r, 1, "check-file-name", testFileName, "unknown,sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(201), packetType)
+		expected := sha256.Sum256(fileContents)
+		assert.Equal(t, getCheckFileReply("sha256", expected[:]), payload[4:])
+		// hash the file in blocks
+		packetType, payload, err = sendCheckFileRequest(w, r, 2, "check-file-name", "/"+testFileName, "sha512", 100, 1000, 512)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(201), packetType)
+		var blockHashes []byte
+		for _, block := range [][]byte{fileContents[100:612], fileContents[612:1100]} {
+			h := sha512.Sum512(block)
+			blockHashes = append(blockHashes, h[:]...)
+		}
+		assert.Equal(t, getCheckFileReply("sha512", blockHashes), payload[4:])
+		// open the file and use its handle
+		openPayload := binary.BigEndian.AppendUint32(nil, 3)
+		openPayload = appendSFTPString(openPayload, testFileName)
+		openPayload = binary.BigEndian.AppendUint32(openPayload, 1) // read
+		openPayload = binary.BigEndian.AppendUint32(openPayload, 0) // attrs
+		err = sendSFTPPacket(w, 3, openPayload)
+		assert.NoError(t, err)
+		packetType, payload, err = readSFTPPacket(r)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(102), packetType)
+		handle := string(payload[8:])
+		packetType, payload, err = sendCheckFileRequest(w, r, 4, "check-file-handle", handle, "sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(201), packetType)
+		assert.Equal(t, getCheckFileReply("sha256", expected[:]), payload[4:])
+		// invalid block size
+		packetType, _, err = sendCheckFileRequest(w, r, 5, "check-file-name", testFileName, "sha256", 0, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(101), packetType)
+		// unsupported algorithm
+		packetType, payload, err = sendCheckFileRequest(w, r, 6, "check-file-name", testFileName, "crc32", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(101), packetType)
+		assert.Equal(t, uint32(8), binary.BigEndian.Uint32(payload[4:]))
+		// missing file
+		packetType, payload, err = sendCheckFileRequest(w, r, 7, "check-file-name", "missing", "sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(101), packetType)
+		assert.Equal(t, uint32(2), binary.BigEndian.Uint32(payload[4:]))
+		// invalid handle
+		packetType, _, err = sendCheckFileRequest(w, r, 8, "check-file-handle", "invalid", "sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(101), packetType)
+	}
+	user.Permissions["/"] = []string{dataprovider.PermListItems}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		session, err := conn.NewSession()
+		assert.NoError(t, err)
+		defer session.Close()
+		w, err := session.StdinPipe()
+		assert.NoError(t, err)
+		r, err := session.StdoutPipe()
+		assert.NoError(t, err)
+		err = session.RequestSubsystem("sftp")
+		assert.NoError(t, err)
+		err = sendSFTPPacket(w, 1, binary.BigEndian.AppendUint32(nil, 3))
+		assert.NoError(t, err)
+		_, _, err = readSFTPPacket(r)
+		assert.NoError(t, err)
+		packetType, payload, err := sendCheckFileRequest(w, r, 1, "check-file-name", testFileName, "sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint8(101), packetType)
+		assert.Equal(t, uint32(3), binary.BigEndian.Uint32(payload[4:]))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+}
+
 func TestStatVFS(t *testing.T) {
 	usePubKey := false
 	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
@@ -9661,6 +10421,81 @@ func TestGitErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGitRepositoryConfigCommands(t *testing.T) {
+	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
+		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	repoName := "testrepo"
+	clonePath := filepath.Join(homeBasePath, repoName)
+	markerPath := filepath.Join(homeBasePath, "git_command_executed")
+	err = os.RemoveAll(markerPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	out, err := initGitRepo(filepath.Join(user.HomeDir, repoName))
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = addFileToGitRepo(clonePath, 128)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = pushToGitRepo(clonePath)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	configPath := path.Join("/", repoName, "config")
+	cleanConfig, err := os.ReadFile(filepath.Join(user.HomeDir, repoName, "config"))
+	assert.NoError(t, err)
+
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		for _, config := range []string{
+			fmt.Sprintf("[tar \"evil\"]\n\tcommand = touch %s\n\tremote = true\n", markerPath),
+			fmt.Sprintf("[core]\n\talternateRefsCommand = touch %s\n", markerPath),
+			fmt.Sprintf("[include]\n\tpath = %s\n", filepath.Join(homeBasePath, "gitconfig")),
+		} {
+			f, err := client.Create(configPath)
+			if assert.NoError(t, err) {
+				_, err = f.Write(append(cleanConfig, []byte(config)...))
+				assert.NoError(t, err)
+				err = f.Close()
+				assert.NoError(t, err)
+			}
+			out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+			assert.Error(t, err, "clone must fail with config %q, out: %v", config, string(out))
+			out, err = archiveGitRepo(homeBasePath, "/"+repoName, user.Username)
+			assert.Error(t, err, "archive must fail with config %q, out: %v", config, string(out))
+			assert.NoFileExists(t, markerPath)
+			err = os.RemoveAll(clonePath)
+			assert.NoError(t, err)
+		}
+		f, err := client.Create(configPath)
+		if assert.NoError(t, err) {
+			_, err = f.Write(cleanConfig)
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+}
+
 // Start SCP tests
 func TestSCPBasicHandling(t *testing.T) {
 	if scpPath == "" {
@@ -9972,6 +10807,77 @@ func TestSCPPatternsFilter(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSCPGlobDownload(t *testing.T) {
+	if scpPath == "" {
+		t.Skip("scp command not found, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(65535)
+	for _, name := range []string{"a.gz", "b.gz", "c.txt", ".hidden.gz", path.Join("sub.gz", "file.dat")} {
+		err = createTestFile(filepath.Join(user.GetHomeDir(), name), testFileSize)
+		assert.NoError(t, err)
+	}
+	localDir := filepath.Join(homeBasePath, "scp_glob_download")
+	err = os.MkdirAll(localDir, os.ModePerm)
+	assert.NoError(t, err)
+	remoteDownPath := fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/*.gz")
+	err = scpDownload(localDir, remoteDownPath, false, false)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(localDir, "a.gz"))
+	assert.FileExists(t, filepath.Join(localDir, "b.gz"))
+	assert.NoFileExists(t, filepath.Join(localDir, "c.txt"))
+	assert.NoFileExists(t, filepath.Join(localDir, ".hidden.gz"))
+	assert.NoDirExists(t, filepath.Join(localDir, "sub.gz"))
+	err = os.RemoveAll(localDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(localDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = scpDownload(localDir, remoteDownPath, false, true)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(localDir, "a.gz"))
+	assert.FileExists(t, filepath.Join(localDir, "b.gz"))
+	assert.FileExists(t, filepath.Join(localDir, "sub.gz", "file.dat"))
+	err = scpDownload(localDir, fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/*.zip"), false, false)
+	assert.Error(t, err)
+	err = scpDownload(localDir, fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/s*/file.dat"), false, false)
+	assert.Error(t, err)
+	// files denied by the filters are not matched
+	err = os.RemoveAll(localDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(localDir, os.ModePerm)
+	assert.NoError(t, err)
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"b.*"},
+		},
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = scpDownload(localDir, remoteDownPath, false, false)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(localDir, "a.gz"))
+	assert.NoFileExists(t, filepath.Join(localDir, "b.gz"))
+	// expanding a pattern requires the list permission
+	user.Permissions["/"] = []string{dataprovider.PermDownload}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = scpDownload(localDir, remoteDownPath, false, false)
+	assert.Error(t, err)
+	err = scpDownload(localDir, fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/a.gz"), false, false)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localDir)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSCPTransferQuotaLimits(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -10741,6 +11647,291 @@ func waitTCPListening(address string) {
 	}
 }
 
+// tcpForwarder forwards the accepted connections to the target address.
+// The forwarded connections can be frozen, the data are discarded without
+// closing the connections, or closed to simulate upstream failures
+type tcpForwarder struct {
+	address  string
+	numConns atomic.Int32
+	mu       sync.Mutex
+	conns    []net.Conn
+	frozen   []*atomic.Bool
+}
+
+func newTCPForwarder(t *testing.T, target string) *tcpForwarder {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start the TCP forwarder: %v", err)
+	}
+	f := &tcpForwarder{
+		address: listener.Addr().String(),
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		f.closeConns()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			f.numConns.Add(1)
+			frozen := &atomic.Bool{}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn, upstream)
+			f.frozen = append(f.frozen, frozen)
+			f.mu.Unlock()
+			go f.forward(conn, upstream, frozen)
+			go f.forward(upstream, conn, frozen)
+		}
+	}()
+	return f
+}
+
+func (f *tcpForwarder) forward(dst, src net.Conn, frozen *atomic.Bool) {
+	defer dst.Close()
+	defer src.Close()
+
+	buf := make([]byte, 32768)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !frozen.Load() {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// freeze discards the data for the current connections
+func (f *tcpForwarder) freeze() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, frozen := range f.frozen {
+		frozen.Store(true)
+	}
+}
+
+func (f *tcpForwarder) closeConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+	f.frozen = nil
+}
+
+// encodeBER encodes a BER element, children are concatenated
+func encodeBER(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	result := []byte{tag}
+	switch {
+	case len(content) < 0x80:
+		result = append(result, byte(len(content)))
+	case len(content) <= 0xff:
+		result = append(result, 0x81, byte(len(content)))
+	default:
+		result = append(result, 0x82, byte(len(content)>>8), byte(len(content)))
+	}
+	return append(result, content...)
+}
+
+func encodeLDAPAttribute(name, value string) []byte {
+	return encodeBER(0x30, encodeBER(0x04, []byte(name)), encodeBER(0x31, encodeBER(0x04, []byte(value))))
+}
+
+func encodeLDAPResult(messageID []byte, op byte, code byte) []byte {
+	return encodeBER(0x30, encodeBER(0x02, messageID), encodeBER(op, encodeBER(0x0a, []byte{code}),
+		encodeBER(0x04), encodeBER(0x04)))
+}
+
+// startLDAPMockServer starts a minimal LDAP server that accepts simple binds with
+// the ldapMockPassword and returns a fixed entry for any search
+func startLDAPMockServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start the LDAP mock server: %v", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleLDAPMockConn(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func handleLDAPMockConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		length := int(header[1])
+		if header[1]&0x80 != 0 {
+			lenBytes := make([]byte, int(header[1]&0x7f))
+			if _, err := io.ReadFull(reader, lenBytes); err != nil {
+				return
+			}
+			length = 0
+			for _, b := range lenBytes {
+				length = length<<8 | int(b)
+			}
+		}
+		content := make([]byte, length)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return
+		}
+		// content: messageID (INTEGER) followed by the protocol operation
+		idLen := int(content[1])
+		messageID := content[2 : 2+idLen]
+		op := content[2+idLen]
+		switch op {
+		case 0x60: // bind request
+			code := byte(49)
+			if bytes.HasSuffix(content, []byte(ldapMockPassword)) {
+				code = 0
+			}
+			conn.Write(encodeLDAPResult(messageID, 0x61, code)) //nolint:errcheck
+		case 0x63: // search request
+			entry := encodeBER(0x30, encodeBER(0x02, messageID), encodeBER(0x64,
+				encodeBER(0x04, []byte("uid="+defaultUsername+",ou=people,dc=example,dc=com")),
+				encodeBER(0x30,
+					encodeLDAPAttribute("mail", "ldapuser@example.com"),
+					encodeLDAPAttribute("quota", "1MB"),
+					encodeLDAPAttribute("memberOf", "cn=sftp,ou=groups,dc=example,dc=com"),
+				)))
+			conn.Write(entry)                                //nolint:errcheck
+			conn.Write(encodeLDAPResult(messageID, 0x65, 0)) //nolint:errcheck
+		default: // unbind or unsupported operation
+			return
+		}
+	}
+}
+
+// writeTestKeytab writes a keytab with an AES256 key for the given service principal
+func writeTestKeytab(t *testing.T, keytabPath, principal, realm string) {
+	kt := keytab.New()
+	err := kt.AddEntry(principal, realm, defaultPassword, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	assert.NoError(t, err)
+	data, err := kt.Marshal()
+	assert.NoError(t, err)
+	err = os.WriteFile(keytabPath, data, 0600)
+	assert.NoError(t, err)
+}
+
+// startRADIUSMockServer starts a minimal RADIUS server. The radiusMockPassword is
+// accepted, the radiusMockPIN triggers a challenge that must be answered with the
+// radiusMockOTP
+func startRADIUSMockServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start the RADIUS mock server: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := handleRADIUSMockRequest(buf[:n]); response != nil {
+				conn.WriteTo(response, addr) //nolint:errcheck
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func handleRADIUSMockRequest(request []byte) []byte {
+	if len(request) < 20 {
+		return nil
+	}
+	authenticator := request[4:20]
+	var password, state []byte
+	attrs := request[20:]
+	for len(attrs) >= 2 && int(attrs[1]) >= 2 && int(attrs[1]) <= len(attrs) {
+		switch attrs[0] {
+		case 2: // User-Password
+			password = decryptRADIUSMockPassword(attrs[2:attrs[1]], authenticator)
+		case 24: // State
+			state = attrs[2:attrs[1]]
+		}
+		attrs = attrs[attrs[1]:]
+	}
+	code := byte(3) // Access-Reject
+	var responseAttrs []byte
+	switch {
+	case state != nil:
+		if string(password) == radiusMockOTP && string(state) == "otp-state" {
+			code = 2
+		}
+	case string(password) == radiusMockPassword:
+		code = 2
+	case string(password) == radiusMockPIN:
+		code = 11 // Access-Challenge
+		responseAttrs = append(responseAttrs, 18, byte(2+len("Enter your OTP")))
+		responseAttrs = append(responseAttrs, "Enter your OTP"...)
+		responseAttrs = append(responseAttrs, 24, byte(2+len("otp-state")))
+		responseAttrs = append(responseAttrs, "otp-state"...)
+	}
+	response := make([]byte, 20, 20+len(responseAttrs))
+	response[0] = code
+	response[1] = request[1]
+	binary.BigEndian.PutUint16(response[2:], uint16(20+len(responseAttrs)))
+	response = append(response, responseAttrs...)
+	hash := md5.New()
+	hash.Write(response[:4])
+	hash.Write(authenticator)
+	hash.Write(responseAttrs)
+	hash.Write([]byte(radiusMockSecret))
+	copy(response[4:20], hash.Sum(nil))
+	return response
+}
+
+func decryptRADIUSMockPassword(data, authenticator []byte) []byte {
+	result := make([]byte, len(data))
+	last := authenticator
+	for i := 0; i+16 <= len(data); i += 16 {
+		hash := md5.New()
+		hash.Write([]byte(radiusMockSecret))
+		hash.Write(last)
+		b := hash.Sum(nil)
+		for j := 0; j < 16; j++ {
+			result[i+j] = data[i+j] ^ b[j]
+		}
+		last = data[i : i+16]
+	}
+	return bytes.TrimRight(result, "\x00")
+}
+
 func getTestGroup() dataprovider.Group {
 	return dataprovider.Group{
 		BaseGroup: sdk.BaseGroup{
@@ -10926,6 +12117,60 @@ func getCustomAuthSftpClient(user dataprovider.User, authMethods []ssh.AuthMetho
 	return conn, sftpClient, err
 }
 
+func appendSFTPString(b []byte, value string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func sendSFTPPacket(w io.Writer, packetType uint8, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, packetType)
+	packet = append(packet, payload...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readSFTPPacket(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header)-1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+func sendCheckFileRequest(w io.Writer, r io.Reader, id uint32, request, target, algos string, offset, length uint64,
+	blockSize uint32,
+) (uint8, []byte, error) {
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	payload = appendSFTPString(payload, request)
+	payload = appendSFTPString(payload, target)
+	payload = appendSFTPString(payload, algos)
+	payload = binary.BigEndian.AppendUint64(payload, offset)
+	payload = binary.BigEndian.AppendUint64(payload, length)
+	payload = binary.BigEndian.AppendUint32(payload, blockSize)
+	if err := sendSFTPPacket(w, 200, payload); err != nil {
+		return 0, nil, err
+	}
+	packetType, response, err := readSFTPPacket(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(response) != id {
+		return 0, nil, fmt.Errorf("unexpected response id %d, expected %d", binary.BigEndian.Uint32(response), id)
+	}
+	return packetType, response, nil
+}
+
+func getCheckFileReply(algo string, hash []byte) []byte {
+	reply := appendSFTPString(nil, "check-file")
+	reply = appendSFTPString(reply, algo)
+	return append(reply, hash...)
+}
+
 func createTestFile(path string, size int64) error {
 	baseDir := filepath.Dir(path)
 	if _, err := os.Stat(baseDir); errors.Is(err, fs.ErrNotExist) {
@@ -11275,6 +12520,15 @@ func cloneGitRepo(basePath, remotePath, username string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+func archiveGitRepo(basePath, remotePath, username string) ([]byte, error) {
+	remoteURL := fmt.Sprintf("ssh://%v@127.0.0.1:2022%v", username, remotePath)
+	cmd := exec.Command(gitPath, "archive", "--remote", remoteURL, "--format", "evil", "HEAD")
+	cmd.Dir = basePath
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GIT_SSH=%v", gitWrapPath))
+	return cmd.CombinedOutput()
+}
+
 func addFileToGitRepo(repoPath string, fileSize int64) ([]byte, error) {
 	path := filepath.Join(repoPath, "test")
 	err := createTestFile(path, fileSize)
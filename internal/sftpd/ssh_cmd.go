@@ -26,10 +26,12 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/google/shlex"
 	"github.com/sftpgo/sdk"
@@ -50,6 +52,48 @@ const (
 
 var (
 	errUnsupportedConfig = errors.New("command unsupported for this configuration")
+	// rsync options that allow to access paths not checked against the user's
+	// home directory and permissions or to run a different program. Long options
+	// can be abbreviated, so any prefix of these options is rejected too
+	rsyncDeniedOptions = []string{"--daemon", "--config", "--dparam", "--log-file", "--temp-dir", "--backup-dir",
+		"--partial-dir", "--compare-dest", "--copy-dest", "--link-dest", "--files-from", "--exclude-from",
+		"--include-from", "--write-batch", "--only-write-batch", "--read-batch", "--rsync-path", "--remote-option",
+		"--copy-devices", "--write-devices", "--protect-args", "--secluded-args"}
+	// short options, without argument, that rsync clients send to the server.
+	// "-s" is not allowed, the arguments would be sent using the protocol stream
+	// bypassing our checks. "e" is the last option in a cluster, it is followed by
+	// the client capabilities
+	rsyncAllowedShortOptions = "vqbunlLkKWHDgotUNOJpAXrdcmxzSRIyEiCh8P0a"
+	// long options, without argument, allowed in server mode
+	rsyncAllowedLongOptions = []string{"--server", "--sender", "--delete", "--delete-before", "--delete-during",
+		"--delete-delay", "--delete-after", "--delete-excluded", "--delete-missing-args", "--ignore-missing-args",
+		"--ignore-errors", "--force", "--partial", "--delay-updates", "--prune-empty-dirs", "--inplace",
+		"--append", "--append-verify", "--existing", "--ignore-existing", "--remove-source-files",
+		"--size-only", "--numeric-ids", "--safe-links", "--munge-links", "--copy-unsafe-links", "--fake-super",
+		"--no-implied-dirs", "--mkpath", "--open-noatime", "--preallocate", "--fsync", "--list-only",
+		"--from0", "--old-compress", "--new-compress", "--no-compress"}
+	// long options, that require an argument, allowed in server mode. The
+	// argument must be provided using the "--option=value" form
+	rsyncAllowedLongValueOptions = []string{"--timeout", "--bwlimit", "--max-delete", "--max-size", "--min-size",
+		"--max-alloc", "--modify-window", "--compress-level", "--compress-choice", "--checksum-choice",
+		"--checksum-seed", "--block-size", "--suffix", "--chmod", "--usermap", "--groupmap", "--chown",
+		"--info", "--debug", "--stop-after", "--stop-at", "--iconv"}
+	gitAllowedOptions = []string{"--strict", "--no-strict", "--stateless-rpc", "--advertise-refs",
+		"--http-backend-info-refs"}
+	// repository hooks and fsmonitor commands could be uploaded by the user and
+	// executed by git as the SFTPGo process user, we disable them using
+	// environment configuration that overrides the repository configuration
+	gitEnv = []string{"GIT_CONFIG_COUNT=3", "GIT_CONFIG_KEY_0=core.hooksPath", "GIT_CONFIG_VALUE_0=" + os.DevNull,
+		"GIT_CONFIG_KEY_1=core.fsmonitor", "GIT_CONFIG_VALUE_1=false",
+		"GIT_CONFIG_KEY_2=uploadarch.allowUnreachable", "GIT_CONFIG_VALUE_2=false"}
+	// repository configuration keys, with this suffix, that define commands
+	// to execute. Some of them, for example "tar.<format>.command", cannot be
+	// overridden using the environment since the subsection is user defined
+	gitDeniedConfigKeySuffixes = []string{"command", "cmd", "program", "helper", "driver", "textconv", "editor",
+		"pager", "askpass", "gitproxy", "hookspath", "fsmonitor", "packobjectshook", "uploadpack", "receivepack"}
+	// repository configuration sections that define commands or include
+	// other configuration files
+	gitDeniedConfigSections = []string{"filter", "include", "includeif"}
 )
 
 type sshCommand struct {
@@ -192,7 +236,7 @@ func (c *sshCommand) handleSFTPGoRemove() error {
 func (c *sshCommand) updateQuota(sshDestPath string, filesNum int, filesSize int64) {
 	vfolder, err := c.connection.User.GetVirtualFolderForPath(sshDestPath)
 	if err == nil {
-		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, filesNum, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateUserVirtualFolderQuota(&c.connection.User, &vfolder, filesNum, filesSize, false) //nolint:errcheck
 		if vfolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.connection.User, filesNum, filesSize, false) //nolint:errcheck
 		}
@@ -424,7 +468,21 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	if err := c.isSystemCommandAllowed(); err != nil {
 		return command, errUnsupportedConfig
 	}
+	if isGitCommand(c.command) {
+		if err := validateGitArgs(c.args); err != nil {
+			c.connection.Log(logger.LevelInfo, "git command %q not allowed, args: %+v, err: %v", c.command, c.args, err)
+			return command, errUnsupportedConfig
+		}
+		if err := checkGitRepositoryConfig(fsPath); err != nil {
+			c.connection.Log(logger.LevelInfo, "git command %q not allowed for repository %q: %v", c.command, fsPath, err)
+			return command, errUnsupportedConfig
+		}
+	}
 	if c.command == "rsync" {
+		if err := validateRsyncArgs(c.args); err != nil {
+			c.connection.Log(logger.LevelInfo, "rsync command not allowed, args: %+v, err: %v", c.args, err)
+			return command, errUnsupportedConfig
+		}
 		// we cannot avoid that rsync creates symlinks so if the user has the permission
 		// to create symlinks we add the option --safe-links to the received rsync command if
 		// it is not already set. This should prevent to create symlinks that point outside
@@ -444,6 +502,9 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	c.connection.Log(logger.LevelDebug, "new system command %q, with args: %+v fs path %q quota check path %q",
 		c.command, args, fsPath, quotaPath)
 	cmd := exec.Command(c.command, args...)
+	if isGitCommand(c.command) {
+		cmd.Env = append(os.Environ(), gitEnv...)
+	}
 	uid := c.connection.User.GetUID()
 	gid := c.connection.User.GetGID()
 	cmd = wrapCmd(cmd, uid, gid)
@@ -454,6 +515,145 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	return command, nil
 }
 
+// validateRsyncArgs checks the arguments received for the rsync command.
+// Only rsync running in server mode is allowed, the path is the last argument
+// and it is the only one resolved and checked, so multiple paths and options
+// referencing other paths are rejected
+func validateRsyncArgs(args []string) error {
+	if len(args) < 3 || args[0] != "--server" {
+		return errors.New("rsync must run in server mode with a single path")
+	}
+	for idx, arg := range args[:len(args)-1] {
+		if arg == "." {
+			if idx != len(args)-2 {
+				return errors.New("multiple paths are not supported")
+			}
+			return nil
+		}
+		if err := validateRsyncOption(arg); err != nil {
+			return err
+		}
+	}
+	return errors.New("rsync must run in server mode with a single path")
+}
+
+func validateRsyncOption(arg string) error {
+	if strings.HasPrefix(arg, "--") {
+		name, _, hasValue := strings.Cut(arg, "=")
+		if hasValue {
+			if util.Contains(rsyncAllowedLongValueOptions, name) {
+				return nil
+			}
+		} else if util.Contains(rsyncAllowedLongOptions, name) {
+			return nil
+		}
+		for _, opt := range rsyncDeniedOptions {
+			if strings.HasPrefix(opt, name) {
+				return fmt.Errorf("option %q is not allowed, it matches %q", arg, opt)
+			}
+		}
+		return fmt.Errorf("option %q is not allowed", arg)
+	}
+	if len(arg) < 2 || arg[0] != '-' {
+		return fmt.Errorf("argument %q is not allowed", arg)
+	}
+	// short options can be clustered, we have to check each of them
+	for idx, c := range arg[1:] {
+		if c == 'e' {
+			// the remaining characters are the client capabilities
+			if strings.ContainsFunc(arg[idx+2:], func(r rune) bool {
+				return r != '.' && !unicode.IsLetter(r)
+			}) {
+				return fmt.Errorf("option %q is not allowed", arg)
+			}
+			return nil
+		}
+		if !strings.ContainsRune(rsyncAllowedShortOptions, c) {
+			return fmt.Errorf("option %q is not allowed, short option %q is not supported", arg, c)
+		}
+	}
+	return nil
+}
+
+func isGitCommand(command string) bool {
+	return strings.HasPrefix(command, "git-")
+}
+
+// validateGitArgs checks the arguments received for the git commands.
+// The repository path is required and it must be the last argument
+func validateGitArgs(args []string) error {
+	if len(args) == 0 {
+		return errors.New("the repository path is required")
+	}
+	for _, arg := range args[:len(args)-1] {
+		if !util.Contains(gitAllowedOptions, arg) && !strings.HasPrefix(arg, "--timeout=") {
+			return fmt.Errorf("argument %q is not allowed", arg)
+		}
+	}
+	return nil
+}
+
+// checkGitRepositoryConfig checks the configuration files inside the repository,
+// they could be uploaded by the user. Repositories that define commands are
+// rejected. Git files and common dirs are not supported since they could point
+// to a configuration outside the repository
+func checkGitRepositoryConfig(repoPath string) error {
+	repoPath = strings.TrimSuffix(repoPath, string(os.PathSeparator))
+	// git looks for the repository using these suffixes
+	for _, suffix := range []string{"", "/.git", ".git", ".git/.git"} {
+		gitDir := repoPath + filepath.FromSlash(suffix)
+		fi, err := os.Lstat(gitDir)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%q is not a directory", gitDir)
+		}
+		if _, err := os.Lstat(filepath.Join(gitDir, "commondir")); err == nil {
+			return fmt.Errorf("%q defines a common dir", gitDir)
+		}
+		for _, name := range []string{"config", "config.worktree"} {
+			if err := checkGitConfigFile(filepath.Join(gitDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkGitConfigFile(name string) error {
+	if _, err := os.Lstat(name); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// listing the configuration does not execute any command
+	out, err := exec.Command("git", "config", "--file", name, "--no-includes", "--name-only", "--list").Output()
+	if err != nil {
+		return fmt.Errorf("unable to parse %q: %w", name, err)
+	}
+	for _, key := range strings.Split(string(out), "\n") {
+		if key == "" {
+			continue
+		}
+		// the section and the variable name are case insensitive, git
+		// returns them lowercase, the subsection, if any, is in the middle
+		key = strings.ToLower(key)
+		section, _, _ := strings.Cut(key, ".")
+		if util.Contains(gitDeniedConfigSections, section) {
+			return fmt.Errorf("config key %q is not allowed in %q", key, name)
+		}
+		variable := key[strings.LastIndex(key, ".")+1:]
+		for _, suffix := range gitDeniedConfigKeySuffixes {
+			if strings.HasSuffix(variable, suffix) {
+				return fmt.Errorf("config key %q is not allowed in %q", key, name)
+			}
+		}
+	}
+	return nil
+}
+
 // for the supported commands, the destination path, if any, is the last argument
 func (c *sshCommand) getDestPath() string {
 	if len(c.args) == 0 {
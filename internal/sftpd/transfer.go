@@ -130,8 +130,13 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 		t.TransferError(err)
 		return 0, err
 	}
+	if err := t.CheckUploadContent(p, off); err != nil {
+		t.TransferError(err)
+		return 0, err
+	}
 
 	n, err = t.writerAt.WriteAt(p, off)
+	t.UpdateChecksums(p[:n], off)
 	t.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -0,0 +1,164 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultUserCertValidity = 60 // minutes
+	// issued certificates are valid from a few minutes in the past to tolerate clock skews
+	userCertClockSkew = 5 * time.Minute
+)
+
+var (
+	userCA userCertificateAuthority
+)
+
+// userCertificateAuthority is the built-in CA used to issue short-lived SSH user certificates
+type userCertificateAuthority struct {
+	mu       sync.RWMutex
+	signer   ssh.Signer
+	validity time.Duration
+}
+
+func (a *userCertificateAuthority) set(signer ssh.Signer, validity time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.signer = signer
+	a.validity = validity
+}
+
+func (a *userCertificateAuthority) isEnabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.signer != nil
+}
+
+func (a *userCertificateAuthority) issue(username, publicKey string) (*ssh.Certificate, error) {
+	a.mu.RLock()
+	signer := a.signer
+	validity := a.validity
+	a.mu.RUnlock()
+
+	if signer == nil {
+		return nil, util.NewMethodDisabledError("the SSH user certificate authority is not configured")
+	}
+	if username == "" {
+		return nil, util.NewValidationError("a username is required to issue an SSH user certificate")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid public key: %v", err))
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, util.NewValidationError("a public key is required, certificates are not accepted")
+	}
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, fmt.Errorf("unable to generate the certificate serial: %w", err)
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("sftpgo:%s", username),
+		ValidPrincipals: []string{username},
+		ValidAfter:      uint64(now.Add(-userCertClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("unable to sign the SSH user certificate: %w", err)
+	}
+	logger.Info(logSender, "", "SSH user certificate issued for user %q, key fingerprint %q, serial %d, valid until %s",
+		username, ssh.FingerprintSHA256(key), cert.Serial, now.Add(validity).UTC().Format(time.RFC3339))
+	return cert, nil
+}
+
+// IsUserCAEnabled returns true if the built-in SSH user certificate authority is configured
+func IsUserCAEnabled() bool {
+	return userCA.isEnabled()
+}
+
+// IssueUserCertificate issues a short-lived SSH user certificate, signed by the built-in
+// certificate authority, for the specified public key in authorized_keys format.
+// The username is the only valid principal. The certificate is returned in
+// authorized_keys format together with its expiration
+func IssueUserCertificate(username, publicKey string) (string, time.Time, error) {
+	cert, err := userCA.issue(username, publicKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	certBytes := bytes.TrimSpace(ssh.MarshalAuthorizedKey(cert))
+	return string(certBytes), time.Unix(int64(cert.ValidBefore), 0), nil
+}
+
+// loadUserCA loads the private key for the built-in user certificate authority.
+// A new Ed25519 key is generated if the configured file does not exist
+func (c *Configuration) loadUserCA(configDir string) error {
+	c.UserCAKey = strings.TrimSpace(c.UserCAKey)
+	if c.UserCAKey == "" {
+		return nil
+	}
+	if !util.IsFileInputValid(c.UserCAKey) {
+		return fmt.Errorf("invalid user CA key: %q", c.UserCAKey)
+	}
+	keyPath := c.UserCAKey
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	if _, err := os.Stat(keyPath); errors.Is(err, fs.ErrNotExist) {
+		logger.Info(logSender, "", "user CA key %q does not exist, try to create a new one", keyPath)
+		logger.InfoToConsole("user CA key %q does not exist, try to create a new one", keyPath)
+		if err := util.GenerateEd25519Keys(keyPath); err != nil {
+			return fmt.Errorf("unable to create user CA key %q: %w", keyPath, err)
+		}
+	}
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("unable to load user CA key %q: %w", keyPath, err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return fmt.Errorf("unable to parse user CA key %q: %w", keyPath, err)
+	}
+	if c.UserCertValidity <= 0 {
+		c.UserCertValidity = defaultUserCertValidity
+	}
+	c.parsedUserCAKeys = append(c.parsedUserCAKeys, signer.PublicKey())
+	userCA.set(signer, time.Duration(c.UserCertValidity)*time.Minute)
+	logger.Info(logSender, "", "user CA key %q loaded, fingerprint %q, certificate validity: %d minutes", keyPath,
+		ssh.FingerprintSHA256(signer.PublicKey()), c.UserCertValidity)
+	return nil
+}
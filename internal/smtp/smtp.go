@@ -49,6 +49,8 @@ const (
 	templateEmailDir           = "email"
 	templatePasswordReset      = "reset-password.html"
 	templatePasswordExpiration = "password-expiration.html"
+	templateAccountExpiration  = "account-expiration.html"
+	templateAccountLifecycle   = "account-lifecycle-admin.html"
 	dialTimeout                = 10 * time.Second
 )
 
@@ -341,9 +343,15 @@ func loadTemplates(templatesPath string) {
 	pwdResetTmpl := util.LoadTemplate(nil, passwordResetPath)
 	passwordExpirationPath := filepath.Join(templatesPath, templatePasswordExpiration)
 	pwdExpirationTmpl := util.LoadTemplate(nil, passwordExpirationPath)
+	accountExpirationPath := filepath.Join(templatesPath, templateAccountExpiration)
+	accountExpirationTmpl := util.LoadTemplate(nil, accountExpirationPath)
+	accountLifecyclePath := filepath.Join(templatesPath, templateAccountLifecycle)
+	accountLifecycleTmpl := util.LoadTemplate(nil, accountLifecyclePath)
 
 	emailTemplates[templatePasswordReset] = pwdResetTmpl
 	emailTemplates[templatePasswordExpiration] = pwdExpirationTmpl
+	emailTemplates[templateAccountExpiration] = accountExpirationTmpl
+	emailTemplates[templateAccountLifecycle] = accountLifecycleTmpl
 }
 
 // RenderPasswordResetTemplate executes the password reset template
@@ -362,6 +370,23 @@ func RenderPasswordExpirationTemplate(buf *bytes.Buffer, data any) error {
 	return emailTemplates[templatePasswordExpiration].Execute(buf, data)
 }
 
+// RenderAccountExpirationTemplate executes the account expiration template
+func RenderAccountExpirationTemplate(buf *bytes.Buffer, data any) error {
+	if !IsEnabled() {
+		return errors.New("smtp: not configured")
+	}
+	return emailTemplates[templateAccountExpiration].Execute(buf, data)
+}
+
+// RenderAccountLifecycleTemplate executes the template used to notify admins
+// about account lifecycle events
+func RenderAccountLifecycleTemplate(buf *bytes.Buffer, data any) error {
+	if !IsEnabled() {
+		return errors.New("smtp: not configured")
+	}
+	return emailTemplates[templateAccountLifecycle].Execute(buf, data)
+}
+
 // SendEmail tries to send an email using the specified parameters.
 func SendEmail(to []string, subject, body string, contentType EmailContentType, attachments ...*mail.File) error {
 	return config.sendEmail(to, subject, body, contentType, attachments...)
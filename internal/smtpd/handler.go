@@ -0,0 +1,177 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package smtpd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// maximum number of attempts to find a file name not already used
+	maxUniqueNameAttempts = 100
+)
+
+// Connection details for an SMTP message delivery
+type Connection struct {
+	*common.BaseConnection
+	localAddr  string
+	remoteAddr net.Addr
+}
+
+// GetClientVersion returns the connected client's version.
+// SMTP clients does not send a version
+func (c *Connection) GetClientVersion() string {
+	return ""
+}
+
+// GetLocalAddress returns local connection address
+func (c *Connection) GetLocalAddress() string {
+	return c.localAddr
+}
+
+// GetRemoteAddress returns the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	return c.remoteAddr.String()
+}
+
+// Disconnect closes the active transfer
+func (c *Connection) Disconnect() (err error) {
+	return c.SignalTransfersAbort()
+}
+
+// GetCommand returns the SMTP command, we only store files while handling DATA
+func (c *Connection) GetCommand() string {
+	return "DATA"
+}
+
+// storeAttachments stores the attachments inside the specified virtual directory.
+// Existing files are never overwritten, a numeric suffix is added to the name instead
+func (c *Connection) storeAttachments(dir string, attachments []attachment) error {
+	if err := c.CheckParentDirs(dir); err != nil {
+		c.Log(logger.LevelError, "unable to check/create the directory %q: %v", dir, err)
+		return err
+	}
+	for _, a := range attachments {
+		name, err := c.getUniqueName(dir, a.name)
+		if err != nil {
+			return err
+		}
+		if err := c.storeAttachment(name, a.data); err != nil {
+			c.Log(logger.LevelError, "unable to store attachment %q: %v", name, err)
+			return err
+		}
+		c.Log(logger.LevelInfo, "attachment stored as %q, size: %d, content type: %q", name, len(a.data),
+			a.contentType)
+	}
+	return nil
+}
+
+func (c *Connection) getUniqueName(dir, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := path.Join(dir, name)
+	for i := 1; i <= maxUniqueNameAttempts; i++ {
+		_, err := c.DoStat(candidate, 0, false)
+		if c.IsNotExistError(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = path.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
+	}
+	return "", fmt.Errorf("unable to find an unused name for attachment %q", name)
+}
+
+func (c *Connection) storeAttachment(name string, data []byte) error {
+	file, err := c.getFileWriter(name, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, bytes.NewReader(data)); err != nil {
+		return closeWithError(file, err)
+	}
+	return file.Close()
+}
+
+func (c *Connection) getFileWriter(name string, uploadSize int64) (*smtpFile, error) {
+	c.UpdateLastActivity()
+
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+
+	diskQuota, transferQuota := c.HasSpace(true, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+	if err := c.CheckDirLimits(name); err != nil {
+		return nil, err
+	}
+	_, err = common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, p, name, 0, os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", name, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, 0, fs.IsUploadResumeSupported())
+	if maxWriteSize > 0 && uploadSize > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying file write, upload size %d exceeds the allowed size %d", uploadSize, maxWriteSize)
+		return nil, common.ErrQuotaExceeded
+	}
+
+	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelError, "error creating file %q: %+v", filePath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, filePath, name,
+		common.TransferUpload, 0, 0, maxWriteSize, 0, true, fs, transferQuota)
+	return newSMTPFile(baseTransfer, w), nil
+}
+
+func closeWithError(file *smtpFile, err error) error {
+	file.TransferError(err)
+	file.Close() //nolint:errcheck
+	return err
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package smtpd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: sender@example.com\r\n" +
+	"To: inbox@files.example.com\r\n" +
+	"Subject: =?UTF-8?Q?r=C3=A9sum=C3=A9?=\r\n" +
+	"Message-Id: <id1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"body text\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>body text</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"../../report.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxi LGMK\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; name=\"=?UTF-8?B?bm90ZcOoLnR4dA==?=\"\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"caf=C3=A8\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	msg, err := parseMessage([]byte(testMessage), 10)
+	require.NoError(t, err)
+	assert.Equal(t, "<id1@example.com>", msg.messageID)
+	assert.Equal(t, "résumé", msg.subject)
+	assert.Equal(t, 0, msg.ignored)
+	require.Len(t, msg.attachments, 2)
+	assert.Equal(t, "report.csv", msg.attachments[0].name)
+	assert.Equal(t, "application/octet-stream", msg.attachments[0].contentType)
+	assert.Equal(t, []byte("a,b,c\n"), msg.attachments[0].data)
+	assert.Equal(t, "noteè.txt", msg.attachments[1].name)
+	assert.Equal(t, []byte("cafè"), msg.attachments[1].data)
+
+	msg, err = parseMessage([]byte(testMessage), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, msg.ignored)
+	require.Len(t, msg.attachments, 1)
+	assert.Equal(t, "report.csv", msg.attachments[0].name)
+
+	msg, err = parseMessage([]byte("Subject: test\r\n\r\nplain body"), 10)
+	require.NoError(t, err)
+	assert.Len(t, msg.attachments, 0)
+
+	_, err = parseMessage([]byte("invalid message"), 10)
+	assert.Error(t, err)
+
+	data := strings.Replace(testMessage, "--outer--\r\n", "", 1)
+	_, err = parseMessage([]byte(data), 10)
+	assert.Error(t, err)
+}
+
+func TestMultipartDepth(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("Subject: nested\r\n")
+	for i := 0; i <= maxMultipartDepth; i++ {
+		sb.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"b%d\"\r\n\r\n--b%d\r\n", i, i))
+	}
+	sb.WriteString("Content-Disposition: attachment; filename=\"deep.txt\"\r\n\r\ndeep\r\n")
+	for i := maxMultipartDepth; i >= 0; i-- {
+		sb.WriteString(fmt.Sprintf("--b%d--\r\n", i))
+	}
+	msg, err := parseMessage([]byte(sb.String()), 10)
+	require.NoError(t, err)
+	assert.Len(t, msg.attachments, 0)
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	assert.Equal(t, "file.txt", sanitizeFileName("file.txt"))
+	assert.Equal(t, "file.txt", sanitizeFileName("/tmp/file.txt"))
+	assert.Equal(t, "file.txt", sanitizeFileName("C:\\Users\\file.txt"))
+	assert.Equal(t, "file.txt", sanitizeFileName("fi\x00le\n.txt"))
+	assert.Equal(t, "attachment", sanitizeFileName(".."))
+	assert.Equal(t, "attachment", sanitizeFileName("/"))
+	assert.Equal(t, "attachment", sanitizeFileName("\\"))
+}
+
+func TestBase64Cleaner(t *testing.T) {
+	r := &base64Cleaner{r: bytes.NewReader([]byte(" \r\n\t YW Jj\r\n"))}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("YWJj"), data)
+}
+
+func TestMappings(t *testing.T) {
+	m := Mapping{}
+	assert.Error(t, m.validate())
+	m.Address = "invalid"
+	assert.Error(t, m.validate())
+	m.Address = "Inbox@files.example.com"
+	assert.Error(t, m.validate())
+	m.Username = "user"
+	m.AllowedSenders = []string{"[a-"}
+	assert.Error(t, m.validate())
+	m.AllowedSenders = nil
+	require.NoError(t, m.validate())
+	assert.Equal(t, "/", m.Path)
+	assert.True(t, m.isSenderAllowed("anyone@example.com"))
+
+	m.Path = "inbox/../mail/"
+	m.AllowedSenders = []string{"*@Partner.com", "admin@example.com"}
+	require.NoError(t, m.validate())
+	assert.Equal(t, "/mail", m.Path)
+	assert.True(t, m.isSenderAllowed("john@partner.com"))
+	assert.True(t, m.isSenderAllowed("ADMIN@example.com"))
+	assert.False(t, m.isSenderAllowed("john@example.com"))
+	assert.False(t, m.isSenderAllowed(""))
+
+	c := Configuration{
+		Mappings: []Mapping{m},
+	}
+	_, ok := c.getMapping("inbox@files.example.com")
+	assert.True(t, ok)
+	_, ok = c.getMapping("other@files.example.com")
+	assert.False(t, ok)
+}
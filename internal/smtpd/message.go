@@ -0,0 +1,190 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+	"unicode"
+)
+
+const (
+	// nested multipart messages deeper than this limit are not inspected
+	maxMultipartDepth = 10
+)
+
+var errTooManyAttachments = errors.New("too many attachments")
+
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+type message struct {
+	messageID   string
+	subject     string
+	attachments []attachment
+	// number of the ignored attachments, if the limit is exceeded
+	ignored int
+}
+
+// parseMessage parses the raw message and extracts its attachments
+func parseMessage(data []byte, maxAttachments int) (*message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse message: %w", err)
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	m := &message{
+		messageID: msg.Header.Get("Message-Id"),
+		subject:   subject,
+	}
+	err = m.walkPart(textproto.MIMEHeader(msg.Header), msg.Body, maxAttachments, 0)
+	if errors.Is(err, errTooManyAttachments) {
+		err = nil
+	}
+	return m, err
+}
+
+func (m *message) walkPart(header textproto.MIMEHeader, body io.Reader, maxAttachments, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMultipartDepth || params["boundary"] == "" {
+			return nil
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			// we don't use NextPart since it transparently decodes quoted-printable
+			// contents and removes the Content-Transfer-Encoding header
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to parse multipart message: %w", err)
+			}
+			if err := m.walkPart(part.Header, part, maxAttachments, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	name := getAttachmentName(header, params)
+	if name == "" {
+		return nil
+	}
+	if len(m.attachments) >= maxAttachments {
+		m.ignored++
+		return errTooManyAttachments
+	}
+	data, err := io.ReadAll(getPartDecoder(header, body))
+	if err != nil {
+		return fmt.Errorf("unable to decode attachment %q: %w", name, err)
+	}
+	m.attachments = append(m.attachments, attachment{
+		name:        name,
+		contentType: mediaType,
+		data:        data,
+	})
+	return nil
+}
+
+func getPartDecoder(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// getAttachmentName returns the sanitized name for parts with a file name,
+// an empty string means the part is not an attachment, for example the message body
+func getAttachmentName(header textproto.MIMEHeader, contentTypeParams map[string]string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = contentTypeParams["name"]
+	}
+	if name == "" {
+		return ""
+	}
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return sanitizeFileName(name)
+}
+
+// sanitizeFileName removes any path component and the control characters
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSpace(name)
+	switch name {
+	case ".", "..", "/":
+		return "attachment"
+	}
+	return name
+}
+
+// base64Cleaner removes the characters not allowed in base64 encoded contents.
+// The standard decoder ignores only new lines but some clients add other whitespaces
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	j := 0
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		p[j] = p[i]
+		j++
+	}
+	if j == 0 && n > 0 && err == nil {
+		return c.Read(p)
+	}
+	return j, err
+}
@@ -0,0 +1,294 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package smtpd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	smtpserver "github.com/mhale/smtpd"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	appName        = "SFTPGo"
+	sessionTimeout = 5 * time.Minute
+)
+
+type smtpServer struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *smtpServer) hasCertificate() bool {
+	if certMgr == nil {
+		return false
+	}
+	if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+		return true
+	}
+	return getConfigPath(s.config.CertificateFile, "") != "" && getConfigPath(s.config.CertificateKeyFile, "") != ""
+}
+
+func (s *smtpServer) getTLSConfig() *tls.Config {
+	certID := common.DefaultTLSKeyPaidID
+	if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+		certID = s.binding.GetAddress()
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate:           certMgr.GetCertificateFunc(certID),
+		MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
+		CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
+		PreferServerCipherSuites: true,
+	}
+	logger.Debug(logSender, "", "configured TLS cipher suites for binding %q: %v, certID: %v",
+		s.binding.GetAddress(), tlsConfig.CipherSuites, certID)
+	return tlsConfig
+}
+
+func (s *smtpServer) listenAndServe() error {
+	hostname := s.config.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	server := &smtpserver.Server{
+		Appname:     appName,
+		Hostname:    hostname,
+		Handler:     s.handleMessage,
+		HandlerRcpt: s.handleRcpt,
+		MaxSize:     s.config.MaxMessageSize * 1024 * 1024,
+		Timeout:     sessionTimeout,
+	}
+	if s.hasCertificate() {
+		server.TLSConfig = s.getTLSConfig()
+		server.TLSRequired = s.binding.TLSMode == TLSModeRequired
+	} else if s.binding.TLSMode != TLSModeOptional {
+		logger.Error(logSender, "", "unable to enable TLS for binding %q, no certificate configured", s.binding.GetAddress())
+		return fmt.Errorf("TLS mode %d requires a certificate, binding %q", s.binding.TLSMode, s.binding.GetAddress())
+	}
+
+	util.CheckTCP4Port(s.binding.Port)
+	listener, err := net.Listen("tcp", s.binding.GetAddress())
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+		return err
+	}
+	var ln net.Listener = &smtpListener{Listener: listener}
+	if s.binding.TLSMode == TLSModeImplicit {
+		ln = tls.NewListener(ln, server.TLSConfig)
+	}
+	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+	logger.Info(logSender, "", "server listener registered, address: %v TLS mode: %v, TLS enabled: %v",
+		listener.Addr().String(), s.binding.TLSMode, server.TLSConfig != nil)
+	return server.Serve(ln)
+}
+
+// handleRcpt accepts only the mapped recipients for the allowed senders
+func (s *smtpServer) handleRcpt(remoteAddr net.Addr, from, to string) bool {
+	ipAddr := util.GetIPFromRemoteAddress(remoteAddr.String())
+	mapping, ok := s.config.getMapping(to)
+	if !ok {
+		logger.Debug(logSender, "", "recipient %q rejected for ip %q, no mapping defined", to, ipAddr)
+		common.AddDefenderEvent(ipAddr, common.ProtocolSMTP, common.HostEventUserNotFound)
+		return false
+	}
+	if !mapping.isSenderAllowed(from) {
+		logger.Info(logSender, "", "recipient %q rejected for ip %q, sender %q not allowed", to, ipAddr, from)
+		common.AddDefenderEvent(ipAddr, common.ProtocolSMTP, common.HostEventLoginFailed)
+		return false
+	}
+	return true
+}
+
+// handleMessage stores the attachments of the received message for each mapped recipient.
+// If an error is returned a temporary failure is sent to the client that can retry later
+func (s *smtpServer) handleMessage(remoteAddr net.Addr, from string, to []string, data []byte) error {
+	msg, err := parseMessage(data, s.config.MaxAttachments)
+	if err != nil {
+		logger.Info(logSender, "", "unable to parse message from %q, ip %q: %v", from, remoteAddr.String(), err)
+		return err
+	}
+	if msg.ignored > 0 {
+		logger.Warn(logSender, "", "message %q from %q has too many attachments, %d ignored", msg.messageID,
+			from, msg.ignored)
+	}
+	if len(msg.attachments) == 0 {
+		logger.Info(logSender, "", "message %q from %q to %v has no attachments, nothing to store", msg.messageID,
+			from, to)
+		return nil
+	}
+	delivered := make(map[string]bool)
+	for _, rcpt := range to {
+		mapping, ok := s.config.getMapping(rcpt)
+		if !ok {
+			continue
+		}
+		// the same user and directory can be mapped to multiple addresses
+		key := mapping.Username + ":" + mapping.Path
+		if delivered[key] {
+			continue
+		}
+		if err := s.deliver(mapping, from, remoteAddr, msg); err != nil {
+			return err
+		}
+		delivered[key] = true
+	}
+	return nil
+}
+
+func (s *smtpServer) deliver(mapping Mapping, from string, remoteAddr net.Addr, msg *message) error {
+	user, connID, err := s.getUser(mapping.Username, remoteAddr)
+	if err != nil {
+		return err
+	}
+	if err = user.CheckFsRoot(connID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		return err
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolSMTP, s.binding.GetAddress(),
+			remoteAddr.String(), user),
+		localAddr:  s.binding.GetAddress(),
+		remoteAddr: remoteAddr,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connID, "unable add connection: %v close fs error: %v", err, errClose)
+		return err
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	dataprovider.UpdateLastLogin(&user)
+
+	connection.Log(logger.LevelInfo, "storing %d attachments for message %q from %q, subject %q, recipient %q",
+		len(msg.attachments), msg.messageID, from, msg.subject, mapping.Address)
+	return connection.storeAttachments(mapping.Path, msg.attachments)
+}
+
+func (s *smtpServer) getUser(username string, remoteAddr net.Addr) (dataprovider.User, string, error) {
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolSMTP, connID)
+
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get user %q: %v", username, err)
+		return user, connID, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		logger.Info(logSender, connectionID, "cannot login user %q: %v", user.Username, err)
+		return user, connID, err
+	}
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %q has an invalid home dir: %q. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return user, connID, fmt.Errorf("cannot login user with invalid home dir: %q", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolSMTP) {
+		logger.Info(logSender, connectionID, "cannot login user %q, protocol SMTP is not allowed", user.Username)
+		return user, connID, fmt.Errorf("protocol SMTP is not allowed for user %q", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(remoteAddr.String()) {
+		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
+			user.Username, remoteAddr.String())
+		return user, connID, fmt.Errorf("login for user %q is not allowed from this address: %v",
+			user.Username, remoteAddr.String())
+	}
+	return user, connID, nil
+}
+
+// smtpListener wraps the accepted connections to track them and to apply the
+// defender, rate limiters, allow and block lists and the post-connect hook
+type smtpListener struct {
+	net.Listener
+}
+
+func (l *smtpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	common.Connections.AddClientConnection(ipAddr)
+	return &smtpConn{
+		Conn:   conn,
+		ipAddr: ipAddr,
+	}, nil
+}
+
+// smtpConn checks if the connection is allowed before the first read or write,
+// so the checks, including the post-connect hook, don't block the accept loop
+type smtpConn struct {
+	net.Conn
+	ipAddr    string
+	checkOnce sync.Once
+	checkErr  error
+	closeOnce sync.Once
+}
+
+func (c *smtpConn) check() error {
+	c.checkOnce.Do(func() {
+		c.checkErr = checkConnection(c.ipAddr)
+	})
+	return c.checkErr
+}
+
+func (c *smtpConn) Read(p []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *smtpConn) Write(p []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *smtpConn) Close() error {
+	c.closeOnce.Do(func() {
+		common.Connections.RemoveClientConnection(c.ipAddr)
+	})
+	return c.Conn.Close()
+}
+
+func checkConnection(ipAddr string) error {
+	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolSMTP); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolSMTP, "", "connection not allowed from ip %q: %v", ipAddr, err)
+		return err
+	}
+	if common.IsBanned(ipAddr, common.ProtocolSMTP) {
+		logger.Log(logger.LevelDebug, common.ProtocolSMTP, "", "connection refused, ip %q is banned", ipAddr)
+		return errors.New("connection refused, your IP is banned")
+	}
+	if delay, err := common.LimitRate(common.ProtocolSMTP, ipAddr); err != nil {
+		logger.Debug(logSender, "", "connection from ip %q rate limited, delay: %v", ipAddr, delay)
+		return err
+	}
+	return common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolSMTP)
+}
@@ -0,0 +1,286 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package smtpd implements an SMTP server to receive files by email.
+// Each configured recipient address is mapped to an SFTPGo user and the
+// attachments of the received messages are stored inside a directory of that user
+package smtpd
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender             = "smtpd"
+	defaultMaxMessageSize = 25
+	defaultMaxAttachments = 20
+)
+
+// TLS modes
+const (
+	// STARTTLS is supported if a certificate is configured
+	TLSModeOptional = iota
+	// STARTTLS is required before sending messages
+	TLSModeRequired
+	// Implicit TLS, the connection is encrypted from the start
+	TLSModeImplicit
+)
+
+var (
+	certMgr       *common.CertManager
+	serviceStatus ServiceStatus
+)
+
+// ServiceStatus defines the service status
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Binding defines the configuration for a network listener
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving requests
+	Port int `json:"port" mapstructure:"port"`
+	// 0 means STARTTLS is supported if a certificate is configured, 1 means STARTTLS
+	// is required, 2 means implicit TLS. You need to provide a certificate for modes 1 and 2
+	TLSMode int `json:"tls_mode" mapstructure:"tls_mode"`
+	// Certificate and matching private key for this specific binding, if empty the global
+	// ones will be used, if any
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Defines the minimum TLS version. 13 means TLS 1.3, default is TLS 1.2
+	MinTLSVersion int `json:"min_tls_version" mapstructure:"min_tls_version"`
+	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
+	// If CipherSuites is nil/empty, a default list of secure cipher suites
+	// is used, with a preference order based on hardware performance.
+	// Note that TLS 1.3 ciphersuites are not configurable.
+	// The supported ciphersuites names are defined here:
+	//
+	// https://github.com/golang/go/blob/master/src/crypto/tls/cipher_suites.go#L52
+	//
+	// any invalid name will be silently ignored.
+	// The order matters, the ciphers listed first will be the preferred ones.
+	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+}
+
+// GetAddress returns the binding address
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// IsValid returns true if the binding port is > 0
+func (b *Binding) IsValid() bool {
+	return b.Port > 0
+}
+
+// Mapping defines a recipient address and the user and directory
+// where the attachments of the messages sent to that address are stored
+type Mapping struct {
+	// Recipient address, for example "partner@files.example.com". The match is case insensitive
+	Address string `json:"address" mapstructure:"address"`
+	// The SFTPGo user used to store the attachments.
+	// Users permissions, allowed IPs, quotas and filters are enforced
+	Username string `json:"username" mapstructure:"username"`
+	// Virtual directory, inside the user's home, where the attachments are stored.
+	// It is created if missing. Default: "/"
+	Path string `json:"path" mapstructure:"path"`
+	// Shell like patterns for the allowed senders, for example "*@partner.com".
+	// Empty means any sender is allowed
+	AllowedSenders []string `json:"allowed_senders" mapstructure:"allowed_senders"`
+}
+
+func (m *Mapping) isSenderAllowed(sender string) bool {
+	if len(m.AllowedSenders) == 0 {
+		return true
+	}
+	sender = strings.ToLower(sender)
+	for _, pattern := range m.AllowedSenders {
+		if matched, err := path.Match(strings.ToLower(pattern), sender); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mapping) validate() error {
+	if m.Address == "" || !strings.Contains(m.Address, "@") {
+		return fmt.Errorf("invalid recipient address %q", m.Address)
+	}
+	if m.Username == "" {
+		return fmt.Errorf("no user defined for recipient address %q", m.Address)
+	}
+	for _, pattern := range m.AllowedSenders {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid allowed sender pattern %q for recipient address %q: %w", pattern, m.Address, err)
+		}
+	}
+	m.Path = util.CleanPath(m.Path)
+	return nil
+}
+
+// Configuration defines the configuration for the SMTP server
+type Configuration struct {
+	// Addresses and ports to bind to
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// Hostname used in the greeting and in the Received header. Empty means the system hostname
+	Hostname string `json:"hostname" mapstructure:"hostname"`
+	// Maximum message size as MB, attachments are base64 encoded so they are about 33%
+	// bigger inside the message. Default: 25
+	MaxMessageSize int `json:"max_message_size" mapstructure:"max_message_size"`
+	// Maximum number of attachments stored for each message, the exceeding ones are ignored. Default: 20
+	MaxAttachments int `json:"max_attachments" mapstructure:"max_attachments"`
+	// Mappings between recipient addresses and users. Messages to unmapped addresses are rejected
+	Mappings []Mapping `json:"mappings" mapstructure:"mappings"`
+	// If files containing a certificate and matching private key for the server are provided you
+	// can enable TLS connections for the configured bindings
+	// Certificate and key files can be reloaded on demand sending a "SIGHUP" signal on Unix based systems and a
+	// "paramchange" request to the running service on Windows.
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+}
+
+// GetStatus returns the server status
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// ShouldBind returns true if there is at least a valid binding
+func (c *Configuration) ShouldBind() bool {
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getMapping returns the mapping for the specified recipient address, if any
+func (c *Configuration) getMapping(recipient string) (Mapping, bool) {
+	for _, m := range c.Mappings {
+		if strings.EqualFold(m.Address, recipient) {
+			return m, true
+		}
+	}
+	return Mapping{}, false
+}
+
+func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
+	var keyPairs []common.TLSKeyPair
+
+	for _, binding := range c.Bindings {
+		certificateFile := getConfigPath(binding.CertificateFile, configDir)
+		certificateKeyFile := getConfigPath(binding.CertificateKeyFile, configDir)
+		if certificateFile != "" && certificateKeyFile != "" {
+			keyPairs = append(keyPairs, common.TLSKeyPair{
+				Cert: certificateFile,
+				Key:  certificateKeyFile,
+				ID:   binding.GetAddress(),
+			})
+		}
+	}
+	certificateFile := getConfigPath(c.CertificateFile, configDir)
+	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if certificateFile != "" && certificateKeyFile != "" {
+		keyPairs = append(keyPairs, common.TLSKeyPair{
+			Cert: certificateFile,
+			Key:  certificateKeyFile,
+			ID:   common.DefaultTLSKeyPaidID,
+		})
+	}
+	return keyPairs
+}
+
+// Initialize configures and starts the SMTP server
+func (c *Configuration) Initialize(configDir string) error {
+	logger.Info(logSender, "", "initializing SMTP server with config %+v", *c)
+	if !c.ShouldBind() {
+		return common.ErrNoBinding
+	}
+	if len(c.Mappings) == 0 {
+		return errors.New("no recipient address mapping defined")
+	}
+	for idx := range c.Mappings {
+		if err := c.Mappings[idx].validate(); err != nil {
+			return err
+		}
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.MaxAttachments <= 0 {
+		c.MaxAttachments = defaultMaxAttachments
+	}
+
+	keyPairs := c.getKeyPairs(configDir)
+	if len(keyPairs) > 0 {
+		mgr, err := common.NewCertManager(keyPairs, configDir, logSender)
+		if err != nil {
+			return err
+		}
+		certMgr = mgr
+	}
+
+	serviceStatus = ServiceStatus{
+		Bindings: nil,
+	}
+
+	exitChannel := make(chan error, 1)
+
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+
+		go func(binding Binding) {
+			server := smtpServer{
+				config:  c,
+				binding: binding,
+			}
+			exitChannel <- server.listenAndServe()
+		}(binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return <-exitChannel
+}
+
+// ReloadCertificateMgr reloads the certificate manager
+func ReloadCertificateMgr() error {
+	if certMgr != nil {
+		return certMgr.Reload()
+	}
+	return nil
+}
+
+func getConfigPath(name, configDir string) string {
+	if !util.IsFileInputValid(name) {
+		return ""
+	}
+	if name != "" && !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name)
+	}
+	return name
+}
@@ -0,0 +1,359 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package smtpd_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtpd"
+)
+
+const (
+	smtpServerAddr  = "127.0.0.1:2527"
+	defaultUsername = "test_user_smtp"
+	defaultPassword = "test_password"
+	missingUsername = "missing_user_smtp"
+	inboxAddress    = "inbox@files.example.com"
+	partnerAddress  = "partner@files.example.com"
+	missingAddress  = "missing@files.example.com"
+)
+
+var (
+	configDir    = filepath.Join(".", "..", "..")
+	allPerms     = []string{dataprovider.PermAny}
+	homeBasePath string
+	logFilePath  string
+)
+
+func TestMain(m *testing.M) {
+	logFilePath = filepath.Join(configDir, "sftpgo_smtpd_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	os.Setenv("SFTPGO_DATA_PROVIDER__CREATE_DEFAULT_ADMIN", "1")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_USERNAME", "admin")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_PASSWORD", "password")
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting SMTPD tests, provider: %v", providerConf.Driver)
+	commonConf := config.GetCommonConfig()
+	homeBasePath = os.TempDir()
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+
+	err = common.Initialize(commonConf, 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir) //nolint:errcheck
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+
+	httpdConf := config.GetHTTPDConfig()
+	httpdConf.Bindings[0].Port = 8073
+	httpdtest.SetBaseURL("http://127.0.0.1:8073")
+
+	smtpdConf := config.GetSMTPDConfig()
+	smtpdConf.Hostname = "files.example.com"
+	smtpdConf.MaxMessageSize = 1
+	smtpdConf.MaxAttachments = 2
+	smtpdConf.Bindings = []smtpd.Binding{
+		{
+			Address: "127.0.0.1",
+			Port:    2527,
+		},
+	}
+	smtpdConf.Mappings = []smtpd.Mapping{
+		{
+			Address:  inboxAddress,
+			Username: defaultUsername,
+			Path:     "/inbox",
+		},
+		{
+			Address:        partnerAddress,
+			Username:       defaultUsername,
+			Path:           "/inbox",
+			AllowedSenders: []string{"*@partner.com"},
+		},
+		{
+			Address:  missingAddress,
+			Username: missingUsername,
+		},
+	}
+
+	status := smtpd.GetStatus()
+	if status.IsActive {
+		logger.ErrorToConsole("smtp server is already active")
+		os.Exit(1)
+	}
+
+	go func() {
+		logger.Debug("smtpdTesting", "", "initializing SMTP server with config %+v", smtpdConf)
+		if err := smtpdConf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start SMTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := httpdConf.Initialize(configDir, 0); err != nil {
+			logger.ErrorToConsole("could not start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	waitTCPListening(smtpServerAddr)
+	waitTCPListening(httpdConf.Bindings[0].GetAddress())
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestInitialization(t *testing.T) {
+	cfg := smtpd.Configuration{
+		Bindings: []smtpd.Binding{
+			{
+				Port: 0,
+			},
+		},
+	}
+	err := cfg.Initialize(configDir)
+	assert.ErrorIs(t, err, common.ErrNoBinding)
+	cfg.Bindings[0].Address = "127.0.0.1"
+	cfg.Bindings[0].Port = 2528
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.Mappings = []smtpd.Mapping{
+		{
+			Address: "invalid",
+		},
+	}
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.Mappings[0].Address = inboxAddress
+	cfg.Mappings[0].Username = defaultUsername
+	cfg.Bindings[0].TLSMode = smtpd.TLSModeRequired
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.Bindings[0].TLSMode = smtpd.TLSModeOptional
+	cfg.CertificateFile = "missing.crt"
+	cfg.CertificateKeyFile = "missing.key"
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.CertificateFile = ""
+	cfg.CertificateKeyFile = ""
+	cfg.Bindings[0].Port = 2527
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+
+	status := smtpd.GetStatus()
+	assert.True(t, status.IsActive)
+}
+
+func TestReceiveAttachments(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	msg := getTestMessage(map[string][]byte{
+		"report.csv": []byte("a,b,c\n"),
+	})
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{inboxAddress}, msg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "inbox", "report.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a,b,c\n"), content)
+	// existing files are never overwritten, the same recipient added
+	// twice must store a single copy
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{inboxAddress, inboxAddress}, msg)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "report_1.csv"))
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "report_2.csv"))
+	// messages without attachments are accepted but nothing is stored
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{inboxAddress},
+		[]byte("Subject: test\r\n\r\nplain body\r\n"))
+	assert.NoError(t, err)
+	// the exceeding attachments are ignored
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{inboxAddress}, getTestMessage(map[string][]byte{
+		"file1.txt": []byte("1"),
+		"file2.txt": []byte("2"),
+		"file3.txt": []byte("3"),
+	}))
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(user.GetHomeDir(), "inbox"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, user.UsedQuotaFiles)
+	assert.Greater(t, user.LastLogin, int64(0))
+	assert.Len(t, common.Connections.GetStats(""), 0)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestRejectedMessages(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/inbox"] = []string{dataprovider.PermListItems}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	msg := getTestMessage(map[string][]byte{
+		"file.txt": []byte("content"),
+	})
+	// unmapped recipient
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{"unknown@files.example.com"}, msg)
+	assert.Error(t, err)
+	// sender not allowed
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@example.com", []string{partnerAddress}, msg)
+	assert.Error(t, err)
+	// the mapped user does not exist
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{missingAddress}, msg)
+	assert.Error(t, err)
+	// upload permission denied
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{partnerAddress}, msg)
+	assert.Error(t, err)
+	// message too big
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{inboxAddress}, getTestMessage(map[string][]byte{
+		"big.bin": make([]byte, 1024*1024),
+	}))
+	assert.Error(t, err)
+
+	user.Permissions["/inbox"] = allPerms
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/inbox",
+			DeniedPatterns: []string{"*.exe"},
+		},
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{partnerAddress}, getTestMessage(map[string][]byte{
+		"file.exe": []byte("content"),
+	}))
+	assert.Error(t, err)
+
+	user.Filters.FilePatterns = nil
+	user.Filters.DeniedProtocols = []string{common.ProtocolSMTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{partnerAddress}, msg)
+	assert.Error(t, err)
+
+	user.Filters.DeniedProtocols = nil
+	user.QuotaFiles = 1
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{partnerAddress}, msg)
+	assert.NoError(t, err)
+	err = smtp.SendMail(smtpServerAddr, nil, "sender@partner.com", []string{partnerAddress}, msg)
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "file.txt"))
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "file_1.txt"))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func getTestMessage(attachments map[string][]byte) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: sender@example.com\r\n")
+	sb.WriteString("Subject: files\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: multipart/mixed; boundary=\"sep\"\r\n\r\n")
+	sb.WriteString("--sep\r\nContent-Type: text/plain\r\n\r\nsee attached files\r\n")
+	for name, data := range attachments {
+		sb.WriteString("--sep\r\n")
+		sb.WriteString("Content-Type: application/octet-stream\r\n")
+		sb.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=%q\r\n", name))
+		sb.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > 76 {
+			sb.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		sb.WriteString(encoded + "\r\n")
+	}
+	sb.WriteString("--sep--\r\n")
+	return []byte(sb.String())
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:       defaultUsername,
+			Password:       defaultPassword,
+			HomeDir:        filepath.Join(homeBasePath, defaultUsername),
+			Status:         1,
+			ExpirationDate: 0,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = allPerms
+	return user
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Connection details for a TFTP transfer
+type Connection struct {
+	*common.BaseConnection
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	command    string
+}
+
+// GetClientVersion returns the connected client's version.
+// TFTP clients does not send a version
+func (c *Connection) GetClientVersion() string {
+	return ""
+}
+
+// GetLocalAddress returns local connection address
+func (c *Connection) GetLocalAddress() string {
+	return c.localAddr.String()
+}
+
+// GetRemoteAddress returns the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	return c.remoteAddr.String()
+}
+
+// Disconnect closes the active transfer
+func (c *Connection) Disconnect() (err error) {
+	return c.SignalTransfersAbort()
+}
+
+// GetCommand returns the TFTP request type, RRQ or WRQ
+func (c *Connection) GetCommand() string {
+	return c.command
+}
+
+// handleReadRequest sends the requested file to the client
+func (c *Connection) handleReadRequest(t *transfer, name string, opts transferOptions) error {
+	file, size, err := c.getFileReader(name)
+	if err != nil {
+		t.sendError(getErrorCode(err), err.Error())
+		return err
+	}
+	if opts.tsize >= 0 {
+		opts.tsize = size
+	}
+	if opts.hasOACK() {
+		if _, err := t.exchange(newOACKPacket(opts), isACK(0)); err != nil {
+			return closeWithError(file, err)
+		}
+	}
+	data := make([]byte, opts.blockSize)
+	block := uint16(0)
+	for {
+		n, err := io.ReadFull(file, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.sendError(getErrorCode(err), err.Error())
+			return closeWithError(file, err)
+		}
+		block++
+		if _, err := t.exchange(newDataPacket(block, data[:n]), isACK(block)); err != nil {
+			return closeWithError(file, err)
+		}
+		if n < opts.blockSize {
+			break
+		}
+	}
+	return file.Close()
+}
+
+// handleWriteRequest receives a file from the client
+func (c *Connection) handleWriteRequest(t *transfer, name string, opts transferOptions) error {
+	file, err := c.getFileWriter(name, opts.tsize)
+	if err != nil {
+		t.sendError(getErrorCode(err), err.Error())
+		return err
+	}
+	reply := newACKPacket(0)
+	if opts.hasOACK() {
+		reply = newOACKPacket(opts)
+	}
+	block := uint16(1)
+	for {
+		p, err := t.exchange(reply, isData(block))
+		if err != nil {
+			return closeWithError(file, err)
+		}
+		_, data, _ := parseDataPacket(p)
+		if _, err := file.Write(data); err != nil {
+			t.sendError(getErrorCode(err), err.Error())
+			return closeWithError(file, err)
+		}
+		reply = newACKPacket(block)
+		if len(data) < opts.blockSize {
+			break
+		}
+		block++
+	}
+	// the last ACK is sent after closing the file so any error can be reported
+	if err := file.Close(); err != nil {
+		t.sendError(getErrorCode(err), err.Error())
+		return err
+	}
+	return t.send(reply)
+}
+
+func (c *Connection) getFileReader(name string) (*tftpFile, int64, error) {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return nil, 0, c.GetReadQuotaExceededError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(name)) {
+		return nil, 0, c.GetPermissionDeniedError()
+	}
+
+	if ok, policy := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "reading file %q is not allowed", name)
+		return nil, 0, c.GetErrorForDeniedFile(policy)
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := fs.Stat(p)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to stat file %q: %+v", p, err)
+		return nil, 0, c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, "cannot read %q, it is not a regular file", p)
+		return nil, 0, c.GetNotExistError()
+	}
+
+	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, name, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", name, err)
+		return nil, 0, c.GetPermissionDeniedError()
+	}
+
+	file, r, cancelFn, err := fs.Open(p, 0)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q for reading: %+v", p, err)
+		return nil, 0, c.GetFsError(fs, err)
+	}
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, name, common.TransferDownload,
+		0, 0, 0, 0, false, fs, transferQuota)
+	return newTFTPFile(baseTransfer, nil, r), info.Size(), nil
+}
+
+func (c *Connection) getFileWriter(name string, size int64) (*tftpFile, error) {
+	c.UpdateLastActivity()
+
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+
+	stat, statErr := fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return nil, c.GetPermissionDeniedError()
+		}
+		return c.handleUploadFile(fs, p, filePath, name, true, 0, size)
+	}
+
+	if statErr != nil {
+		c.Log(logger.LevelError, "error performing file stat %q: %+v", p, statErr)
+		return nil, c.GetFsError(fs, statErr)
+	}
+
+	// This happen if we upload a file that has the same name of an existing directory
+	if stat.IsDir() {
+		c.Log(logger.LevelError, "attempted to open a directory for writing to: %q", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		_, _, err = fs.Rename(p, filePath)
+		if err != nil {
+			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %+v",
+				p, filePath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+	}
+
+	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size(), size)
+}
+
+func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool,
+	fileSize, uploadSize int64,
+) (*tftpFile, error) {
+	diskQuota, transferQuota := c.HasSpace(isNewFile, false, requestPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, c.GetQuotaExceededError()
+	}
+	if isNewFile {
+		if err := c.CheckDirLimits(requestPath); err != nil {
+			return nil, err
+		}
+	}
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	// the client can send the upload size using the "tsize" option
+	if uploadSize > 0 && maxWriteSize > 0 && uploadSize > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying file write, the upload size %d exceeds the allowed size %d",
+			uploadSize, maxWriteSize)
+		return nil, c.GetQuotaExceededError()
+	}
+	_, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelError, "error opening existing file, source: %q, err: %+v", filePath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	initialSize := int64(0)
+	truncatedSize := int64(0) // bytes truncated and not included in quota
+	if !isNewFile {
+		if vfs.HasTruncateSupport(fs) {
+			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+			if err == nil {
+				dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
+				if vfolder.IsIncludedInUserQuota() {
+					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+				}
+			} else {
+				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+			}
+		} else {
+			initialSize = fileSize
+			truncatedSize = fileSize
+		}
+		if maxWriteSize > 0 {
+			maxWriteSize += fileSize
+		}
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	return newTFTPFile(baseTransfer, w, nil), nil
+}
+
+func closeWithError(file *tftpFile, err error) error {
+	file.TransferError(err)
+	file.Close() //nolint:errcheck
+	return err
+}
+
+// getErrorCode returns the TFTP error code for the specified error
+func getErrorCode(err error) uint16 {
+	switch {
+	case errors.Is(err, common.ErrNotExist), errors.Is(err, os.ErrNotExist):
+		return errCodeFileNotFound
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return errCodeDiskFull
+	case errors.Is(err, common.ErrPermissionDenied), errors.Is(err, os.ErrPermission),
+		errors.Is(err, common.ErrReadQuotaExceeded):
+		return errCodeAccessViolation
+	default:
+		return errCodeNotDefined
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+func TestParseRequest(t *testing.T) {
+	for _, p := range [][]byte{
+		nil,
+		{0, 1, 0},
+		append([]byte{0, 3}, "file\x00octet\x00"...),
+		append([]byte{0, 1}, "file\x00octet"...),
+		append([]byte{0, 1}, "file\x00"...),
+		append([]byte{0, 1}, "\x00octet\x00"...),
+		append([]byte{0, 1}, "file\x00octet\x00blksize\x00"...),
+	} {
+		_, err := parseRequest(p)
+		assert.ErrorIs(t, err, errInvalidPacket, "packet %v", p)
+	}
+	req, err := parseRequest(append([]byte{0, 2}, "dir/file\x00OCTET\x00BLKSIZE\x001024\x00tsize\x00100\x00"...))
+	require.NoError(t, err)
+	assert.Equal(t, "WRQ", req.getOperation())
+	assert.Equal(t, "dir/file", req.filename)
+	assert.Equal(t, modeOctet, req.mode)
+	assert.Equal(t, map[string]string{"blksize": "1024", "tsize": "100"}, req.options)
+	req.opcode = opRRQ
+	assert.Equal(t, "RRQ", req.getOperation())
+}
+
+func TestNegotiateOptions(t *testing.T) {
+	req := &request{
+		opcode:  opRRQ,
+		options: map[string]string{},
+	}
+	opts := req.negotiateOptions(3)
+	assert.False(t, opts.hasOACK())
+	assert.Equal(t, defaultBlockSize, opts.blockSize)
+	assert.Equal(t, 3, opts.timeout)
+	assert.Equal(t, int64(-1), opts.tsize)
+
+	req.options = map[string]string{
+		optBlockSize:    "4",
+		optTimeout:      "256",
+		optTransferSize: "-1",
+		"windowsize":    "8",
+	}
+	opts = req.negotiateOptions(3)
+	assert.False(t, opts.hasOACK())
+	assert.Equal(t, defaultBlockSize, opts.blockSize)
+	assert.Equal(t, 3, opts.timeout)
+
+	req.options = map[string]string{
+		optTransferSize: "0",
+		optBlockSize:    "100000",
+		optTimeout:      "10",
+	}
+	opts = req.negotiateOptions(3)
+	assert.True(t, opts.hasOACK())
+	assert.Equal(t, maxBlockSize, opts.blockSize)
+	assert.Equal(t, 10, opts.timeout)
+	assert.Equal(t, int64(0), opts.tsize)
+	assert.Equal(t, []string{optBlockSize, optTimeout, optTransferSize}, opts.acked)
+	opts.tsize = 1234
+	p := newOACKPacket(opts)
+	assert.Equal(t, append([]byte{0, 6}, fmt.Sprintf("blksize\x00%d\x00timeout\x0010\x00tsize\x001234\x00", maxBlockSize)...), p)
+}
+
+func TestPackets(t *testing.T) {
+	block, data, err := parseDataPacket(newDataPacket(3, []byte("data")))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(3), block)
+	assert.Equal(t, []byte("data"), data)
+	_, _, err = parseDataPacket(newACKPacket(3))
+	assert.ErrorIs(t, err, errInvalidPacket)
+
+	block, err = parseACKPacket(newACKPacket(65535))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(65535), block)
+	_, err = parseACKPacket([]byte{0, 4, 0})
+	assert.ErrorIs(t, err, errInvalidPacket)
+
+	code, msg, err := parseErrorPacket(newErrorPacket(errCodeDiskFull, "quota exceeded"))
+	assert.NoError(t, err)
+	assert.Equal(t, errCodeDiskFull, code)
+	assert.Equal(t, "quota exceeded", msg)
+	_, _, err = parseErrorPacket(newDataPacket(1, nil))
+	assert.ErrorIs(t, err, errInvalidPacket)
+}
+
+func TestErrorCodes(t *testing.T) {
+	assert.Equal(t, errCodeFileNotFound, getErrorCode(common.ErrNotExist))
+	assert.Equal(t, errCodeFileNotFound, getErrorCode(fmt.Errorf("wrapped: %w", os.ErrNotExist)))
+	assert.Equal(t, errCodeDiskFull, getErrorCode(common.ErrQuotaExceeded))
+	assert.Equal(t, errCodeAccessViolation, getErrorCode(common.ErrPermissionDenied))
+	assert.Equal(t, errCodeAccessViolation, getErrorCode(os.ErrPermission))
+	assert.Equal(t, errCodeAccessViolation, getErrorCode(common.ErrReadQuotaExceeded))
+	assert.Equal(t, errCodeNotDefined, getErrorCode(errors.New("generic error")))
+}
+
+func TestBindingValidation(t *testing.T) {
+	b := Binding{}
+	assert.False(t, b.IsValid())
+	b.Port = 69
+	assert.False(t, b.IsValid())
+	b.Username = "user"
+	assert.True(t, b.IsValid())
+	assert.Equal(t, ":69", b.GetAddress())
+	b.Address = "127.0.0.1"
+	assert.Equal(t, "127.0.0.1:69", b.GetAddress())
+
+	c := Configuration{}
+	assert.False(t, c.ShouldBind())
+	c.Bindings = []Binding{b}
+	assert.True(t, c.ShouldBind())
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// opcodes, RFC 1350 and RFC 2347
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// error codes, RFC 1350 and RFC 2347
+const (
+	errCodeNotDefined       uint16 = 0
+	errCodeFileNotFound     uint16 = 1
+	errCodeAccessViolation  uint16 = 2
+	errCodeDiskFull         uint16 = 3
+	errCodeIllegalOperation uint16 = 4
+	errCodeUnknownTID       uint16 = 5
+)
+
+const (
+	defaultBlockSize = 512
+	minBlockSize     = 8
+	maxBlockSize     = 65464
+	maxPacketSize    = maxBlockSize + 4
+	modeOctet        = "octet"
+	modeNetASCII     = "netascii"
+	optBlockSize     = "blksize"
+	optTimeout       = "timeout"
+	optTransferSize  = "tsize"
+)
+
+var errInvalidPacket = errors.New("invalid TFTP packet")
+
+type request struct {
+	opcode   uint16
+	filename string
+	mode     string
+	options  map[string]string
+}
+
+// parseRequest parses a read or write request
+func parseRequest(p []byte) (*request, error) {
+	if len(p) < 4 {
+		return nil, errInvalidPacket
+	}
+	opcode := binary.BigEndian.Uint16(p)
+	if opcode != opRRQ && opcode != opWRQ {
+		return nil, fmt.Errorf("%w: unexpected opcode %d", errInvalidPacket, opcode)
+	}
+	fields := bytes.Split(p[2:], []byte{0})
+	// the packet must end with a 0 byte so the last field is always empty
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errInvalidPacket
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields)%2 != 0 {
+		return nil, errInvalidPacket
+	}
+	req := &request{
+		opcode:   opcode,
+		filename: string(fields[0]),
+		mode:     strings.ToLower(string(fields[1])),
+		options:  make(map[string]string),
+	}
+	if req.filename == "" {
+		return nil, fmt.Errorf("%w: empty filename", errInvalidPacket)
+	}
+	for i := 2; i < len(fields); i += 2 {
+		req.options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return req, nil
+}
+
+func (r *request) getOperation() string {
+	if r.opcode == opRRQ {
+		return "RRQ"
+	}
+	return "WRQ"
+}
+
+// transferOptions defines the negotiated options for a transfer
+type transferOptions struct {
+	blockSize int
+	timeout   int
+	tsize     int64
+	// options to include in the OACK packet, in the order they must be sent
+	acked []string
+}
+
+func (o *transferOptions) hasOACK() bool {
+	return len(o.acked) > 0
+}
+
+func (o *transferOptions) getOACKOptions() map[string]string {
+	opts := make(map[string]string)
+	for _, name := range o.acked {
+		switch name {
+		case optBlockSize:
+			opts[name] = strconv.Itoa(o.blockSize)
+		case optTimeout:
+			opts[name] = strconv.Itoa(o.timeout)
+		case optTransferSize:
+			opts[name] = strconv.FormatInt(o.tsize, 10)
+		}
+	}
+	return opts
+}
+
+// negotiateOptions parses the options requested by the client. Unknown or
+// invalid options are ignored as allowed by RFC 2347
+func (r *request) negotiateOptions(defaultTimeout int) transferOptions {
+	opts := transferOptions{
+		blockSize: defaultBlockSize,
+		timeout:   defaultTimeout,
+		tsize:     -1,
+	}
+	for _, name := range []string{optBlockSize, optTimeout, optTransferSize} {
+		val, ok := r.options[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case optBlockSize:
+			size, err := strconv.Atoi(val)
+			if err != nil || size < minBlockSize {
+				continue
+			}
+			if size > maxBlockSize {
+				size = maxBlockSize
+			}
+			opts.blockSize = size
+		case optTimeout:
+			timeout, err := strconv.Atoi(val)
+			if err != nil || timeout < 1 || timeout > 255 {
+				continue
+			}
+			opts.timeout = timeout
+		case optTransferSize:
+			size, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || size < 0 {
+				continue
+			}
+			opts.tsize = size
+		}
+		opts.acked = append(opts.acked, name)
+	}
+	return opts
+}
+
+func newDataPacket(block uint16, data []byte) []byte {
+	p := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint16(p, opDATA)
+	binary.BigEndian.PutUint16(p[2:], block)
+	copy(p[4:], data)
+	return p
+}
+
+func newACKPacket(block uint16) []byte {
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint16(p, opACK)
+	binary.BigEndian.PutUint16(p[2:], block)
+	return p
+}
+
+func newErrorPacket(code uint16, message string) []byte {
+	p := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(p, opERROR)
+	binary.BigEndian.PutUint16(p[2:], code)
+	p = append(p, message...)
+	return append(p, 0)
+}
+
+func newOACKPacket(options transferOptions) []byte {
+	p := make([]byte, 2, 64)
+	binary.BigEndian.PutUint16(p, opOACK)
+	values := options.getOACKOptions()
+	for _, name := range options.acked {
+		p = append(p, name...)
+		p = append(p, 0)
+		p = append(p, values[name]...)
+		p = append(p, 0)
+	}
+	return p
+}
+
+// parseDataPacket returns the block number and the data of a DATA packet
+func parseDataPacket(p []byte) (uint16, []byte, error) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opDATA {
+		return 0, nil, errInvalidPacket
+	}
+	return binary.BigEndian.Uint16(p[2:]), p[4:], nil
+}
+
+// parseACKPacket returns the block number of an ACK packet
+func parseACKPacket(p []byte) (uint16, error) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opACK {
+		return 0, errInvalidPacket
+	}
+	return binary.BigEndian.Uint16(p[2:]), nil
+}
+
+// parseErrorPacket returns the code and the message of an ERROR packet
+func parseErrorPacket(p []byte) (uint16, string, error) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opERROR {
+		return 0, "", errInvalidPacket
+	}
+	return binary.BigEndian.Uint16(p[2:]), string(bytes.TrimRight(p[4:], "\x00")), nil
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type tftpServer struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *tftpServer) listenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", s.binding.GetAddress())
+	if err != nil {
+		logger.Error(logSender, "", "unable to resolve address %q: %v", s.binding.GetAddress(), err)
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		logger.Error(logSender, "", "unable to listen on address %q: %v", s.binding.GetAddress(), err)
+		return err
+	}
+	defer conn.Close()
+
+	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+	logger.Info(logSender, "", "server listener registered, address: %v, user: %q", conn.LocalAddr().String(),
+		s.binding.Username)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			logger.Warn(logSender, "", "unable to read request: %v", err)
+			continue
+		}
+		p := make([]byte, n)
+		copy(p, buf[:n])
+		go s.handleRequest(addr.IP, remoteAddr, p)
+	}
+}
+
+// handleRequest handles a read or write request. As mandated by RFC 1350 the
+// transfer uses a new UDP socket bound to an ephemeral port
+func (s *tftpServer) handleRequest(localIP net.IP, remoteAddr *net.UDPAddr, p []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handleRequest: %q stack trace: %v", r, string(debug.Stack()))
+		}
+	}()
+
+	ipAddr := remoteAddr.IP.String()
+
+	common.Connections.AddClientConnection(ipAddr)
+	defer common.Connections.RemoveClientConnection(ipAddr)
+
+	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolTFTP); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolTFTP, "", "connection not allowed from ip %q: %v", ipAddr, err)
+		return
+	}
+	if common.IsBanned(ipAddr, common.ProtocolTFTP) {
+		logger.Log(logger.LevelDebug, common.ProtocolTFTP, "", "connection refused, ip %q is banned", ipAddr)
+		return
+	}
+	if delay, err := common.LimitRate(common.ProtocolTFTP, ipAddr); err != nil {
+		logger.Debug(logSender, "", "connection from ip %q rate limited, delay: %v", ipAddr, delay)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolTFTP); err != nil {
+		return
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		logger.Error(logSender, "", "unable to create the transfer socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	t := newTransfer(conn, remoteAddr, s.config.Retries)
+
+	req, err := parseRequest(p)
+	if err != nil {
+		logger.Debug(logSender, "", "invalid request from ip %q: %v", ipAddr, err)
+		common.AddDefenderEvent(ipAddr, common.ProtocolTFTP, common.HostEventNoLoginTried)
+		t.sendError(errCodeIllegalOperation, "invalid request")
+		return
+	}
+	if req.mode != modeOctet && req.mode != modeNetASCII {
+		logger.Debug(logSender, "", "unsupported mode %q from ip %q", req.mode, ipAddr)
+		t.sendError(errCodeIllegalOperation, fmt.Sprintf("unsupported mode %q", req.mode))
+		return
+	}
+
+	user, connID, err := s.getUser(remoteAddr)
+	if err != nil {
+		common.AddDefenderEvent(ipAddr, common.ProtocolTFTP, common.HostEventLoginFailed)
+		t.sendError(errCodeAccessViolation, "access denied")
+		return
+	}
+	if err = user.CheckFsRoot(connID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		t.sendError(errCodeNotDefined, common.ErrInternalFailure.Error())
+		return
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolTFTP, conn.LocalAddr().String(),
+			remoteAddr.String(), user),
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: remoteAddr,
+		command:    req.getOperation(),
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connID, "unable add connection: %v close fs error: %v", err, errClose)
+		t.sendError(errCodeNotDefined, err.Error())
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	dataprovider.UpdateLastLogin(&user)
+
+	opts := req.negotiateOptions(s.config.Timeout)
+	t.timeout = time.Duration(opts.timeout) * time.Second
+	connection.Log(logger.LevelDebug, "%s request for file %q, mode %q, options %v", req.getOperation(),
+		req.filename, req.mode, req.options)
+
+	if req.opcode == opRRQ {
+		err = connection.handleReadRequest(t, util.CleanPath(req.filename), opts)
+	} else {
+		err = connection.handleWriteRequest(t, util.CleanPath(req.filename), opts)
+	}
+	if err != nil {
+		connection.Log(logger.LevelDebug, "%s request for file %q failed: %v", req.getOperation(), req.filename, err)
+	}
+}
+
+func (s *tftpServer) getUser(remoteAddr *net.UDPAddr) (dataprovider.User, string, error) {
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolTFTP, connID)
+
+	user, err := dataprovider.GetUserWithGroupSettings(s.binding.Username, "")
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get user %q: %v", s.binding.Username, err)
+		return user, connID, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		logger.Info(logSender, connectionID, "cannot login user %q: %v", user.Username, err)
+		return user, connID, err
+	}
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %q has an invalid home dir: %q. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return user, connID, fmt.Errorf("cannot login user with invalid home dir: %q", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolTFTP) {
+		logger.Info(logSender, connectionID, "cannot login user %q, protocol TFTP is not allowed", user.Username)
+		return user, connID, fmt.Errorf("protocol TFTP is not allowed for user %q", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(remoteAddr.String()) {
+		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
+			user.Username, remoteAddr.String())
+		return user, connID, fmt.Errorf("login for user %q is not allowed from this address: %v",
+			user.Username, remoteAddr.String())
+	}
+	return user, connID, nil
+}
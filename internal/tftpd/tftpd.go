@@ -0,0 +1,127 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package tftpd implements a TFTP server on top of SFTPGo users.
+// TFTP has no authentication, each binding is mapped to an SFTPGo user
+// and all the requests received on that binding are executed as that user
+package tftpd
+
+import (
+	"fmt"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	logSender      = "tftpd"
+	defaultTimeout = 5
+	defaultRetries = 5
+)
+
+var (
+	serviceStatus ServiceStatus
+)
+
+// ServiceStatus defines the service status
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Binding defines the configuration for a network listener
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving requests
+	Port int `json:"port" mapstructure:"port"`
+	// The SFTPGo user used for all the requests received on this binding.
+	// Users permissions, allowed IPs, quotas and filters are enforced
+	Username string `json:"username" mapstructure:"username"`
+}
+
+// GetAddress returns the binding address
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// IsValid returns true if the binding port is > 0 and a user is defined
+func (b *Binding) IsValid() bool {
+	return b.Port > 0 && b.Username != ""
+}
+
+// Configuration defines the configuration for the TFTP server
+type Configuration struct {
+	// Addresses and ports to bind to
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// Retransmission timeout as seconds. Clients can request a different
+	// value using the "timeout" option. Default: 5
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Number of retransmissions before aborting a transfer. Default: 5
+	Retries int `json:"retries" mapstructure:"retries"`
+}
+
+// GetStatus returns the server status
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// ShouldBind returns true if there is at least a valid binding
+func (c *Configuration) ShouldBind() bool {
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Initialize configures and starts the TFTP server
+func (c *Configuration) Initialize(configDir string) error {
+	logger.Info(logSender, "", "initializing TFTP server with config %+v", *c)
+	if !c.ShouldBind() {
+		return common.ErrNoBinding
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Retries <= 0 {
+		c.Retries = defaultRetries
+	}
+
+	serviceStatus = ServiceStatus{
+		Bindings: nil,
+	}
+
+	exitChannel := make(chan error, 1)
+
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+
+		go func(binding Binding) {
+			server := tftpServer{
+				config:  c,
+				binding: binding,
+			}
+			exitChannel <- server.listenAndServe()
+		}(binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return <-exitChannel
+}
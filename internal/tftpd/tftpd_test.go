@@ -0,0 +1,675 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/tftpd"
+)
+
+const (
+	tftpServerPort             = 6969
+	tftpMissingUserPort        = 6970
+	defaultUsername            = "test_user_tftp"
+	defaultPassword            = "test_password"
+	missingUsername            = "missing_user_tftp"
+	opRRQ               uint16 = 1
+	opWRQ               uint16 = 2
+	opDATA              uint16 = 3
+	opACK               uint16 = 4
+	opERROR             uint16 = 5
+	opOACK              uint16 = 6
+)
+
+var (
+	configDir    = filepath.Join(".", "..", "..")
+	allPerms     = []string{dataprovider.PermAny}
+	homeBasePath string
+	logFilePath  string
+)
+
+// tftpError is an error sent by the server
+type tftpError struct {
+	code    uint16
+	message string
+}
+
+func (e *tftpError) Error() string {
+	return fmt.Sprintf("TFTP error, code: %d, message: %q", e.code, e.message)
+}
+
+func TestMain(m *testing.M) {
+	logFilePath = filepath.Join(configDir, "sftpgo_tftpd_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	os.Setenv("SFTPGO_DATA_PROVIDER__CREATE_DEFAULT_ADMIN", "1")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_USERNAME", "admin")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_PASSWORD", "password")
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting TFTPD tests, provider: %v", providerConf.Driver)
+	commonConf := config.GetCommonConfig()
+	homeBasePath = os.TempDir()
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+
+	err = common.Initialize(commonConf, 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir) //nolint:errcheck
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+
+	httpdConf := config.GetHTTPDConfig()
+	httpdConf.Bindings[0].Port = 8075
+	httpdtest.SetBaseURL("http://127.0.0.1:8075")
+
+	tftpdConf := config.GetTFTPDConfig()
+	tftpdConf.Timeout = 1
+	tftpdConf.Retries = 1
+	tftpdConf.Bindings = []tftpd.Binding{
+		{
+			Address:  "127.0.0.1",
+			Port:     tftpServerPort,
+			Username: defaultUsername,
+		},
+		{
+			Address:  "127.0.0.1",
+			Port:     tftpMissingUserPort,
+			Username: missingUsername,
+		},
+	}
+
+	status := tftpd.GetStatus()
+	if status.IsActive {
+		logger.ErrorToConsole("tftp server is already active")
+		os.Exit(1)
+	}
+
+	go func() {
+		logger.Debug("tftpdTesting", "", "initializing TFTP server with config %+v", tftpdConf)
+		if err := tftpdConf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start TFTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := httpdConf.Initialize(configDir, 0); err != nil {
+			logger.ErrorToConsole("could not start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	waitTFTPListening(len(tftpdConf.Bindings))
+	waitTCPListening(httpdConf.Bindings[0].GetAddress())
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestInitialization(t *testing.T) {
+	cfg := tftpd.Configuration{
+		Bindings: []tftpd.Binding{
+			{
+				Port: 6971,
+			},
+		},
+	}
+	err := cfg.Initialize(configDir)
+	assert.ErrorIs(t, err, common.ErrNoBinding)
+	cfg.Bindings[0].Username = defaultUsername
+	cfg.Bindings[0].Address = "not an ip"
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+	cfg.Bindings[0].Address = "127.0.0.1"
+	cfg.Bindings[0].Port = tftpServerPort
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+
+	status := tftpd.GetStatus()
+	assert.True(t, status.IsActive)
+}
+
+func TestBasicTransfers(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	for _, blockSize := range []int{0, 8, 1024, 1428} {
+		content := make([]byte, 65536+17)
+		_, err = rand.Read(content)
+		assert.NoError(t, err)
+		options := make(map[string]string)
+		if blockSize > 0 {
+			options["blksize"] = strconv.Itoa(blockSize)
+			options["tsize"] = strconv.Itoa(len(content))
+		}
+		fileName := fmt.Sprintf("config_%d.cfg", blockSize)
+		err = tftpPut(tftpServerPort, fileName, content, options)
+		assert.NoError(t, err)
+		data, err := tftpGet(tftpServerPort, fileName, options)
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		// overwrite the existing file
+		err = tftpPut(tftpServerPort, "/"+fileName, content[:1024], options)
+		assert.NoError(t, err)
+		data, err = tftpGet(tftpServerPort, fileName, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, content[:1024], data)
+	}
+	// empty file
+	err = tftpPut(tftpServerPort, "empty.cfg", nil, nil)
+	assert.NoError(t, err)
+	data, err := tftpGet(tftpServerPort, "empty.cfg", nil)
+	assert.NoError(t, err)
+	assert.Len(t, data, 0)
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, user.UsedQuotaFiles)
+	assert.Equal(t, int64(4*1024), user.UsedQuotaSize)
+	// file not found
+	_, err = tftpGet(tftpServerPort, "missing.cfg", nil)
+	assertTFTPError(t, err, 1)
+	// a directory cannot be downloaded
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "backups"), os.ModePerm)
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "backups", nil)
+	assertTFTPError(t, err, 1)
+	// uploads in existing sub directories
+	err = tftpPut(tftpServerPort, "backups/router1.cfg", []byte("hostname router1"), nil)
+	assert.NoError(t, err)
+	data, err = tftpGet(tftpServerPort, "/backups/router1.cfg", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hostname router1"), data)
+	// upload to missing directory
+	err = tftpPut(tftpServerPort, "/missing/router1.cfg", []byte("hostname router1"), nil)
+	assertTFTPError(t, err, 1)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPermissions(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	u.Permissions["/upload"] = []string{dataprovider.PermUpload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "upload"), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = tftpPut(tftpServerPort, "test.cfg", []byte("content"), nil)
+	assertTFTPError(t, err, 2)
+	err = tftpPut(tftpServerPort, "upload/test.cfg", []byte("content"), nil)
+	assert.NoError(t, err)
+	// overwrite is not allowed
+	err = tftpPut(tftpServerPort, "upload/test.cfg", []byte("content"), nil)
+	assertTFTPError(t, err, 2)
+	// download is not allowed
+	_, err = tftpGet(tftpServerPort, "upload/test.cfg", nil)
+	assertTFTPError(t, err, 2)
+
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/",
+			DeniedPatterns:  []string{"*.txt"},
+			AllowedPatterns: []string{},
+		},
+	}
+	user.Filters.DeniedProtocols = []string{common.ProtocolSSH}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "file.txt", nil)
+	assertTFTPError(t, err, 2)
+
+	user.Filters.DeniedProtocols = []string{common.ProtocolTFTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "upload/test.cfg", nil)
+	assertTFTPError(t, err, 2)
+
+	user.Filters.DeniedProtocols = nil
+	user.Filters.DeniedIP = []string{"127.0.0.0/8"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "upload/test.cfg", nil)
+	assertTFTPError(t, err, 2)
+
+	user.Filters.DeniedIP = nil
+	user.Status = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "upload/test.cfg", nil)
+	assertTFTPError(t, err, 2)
+	// the user for this binding does not exist
+	_, err = tftpGet(tftpMissingUserPort, "upload/test.cfg", nil)
+	assertTFTPError(t, err, 2)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestQuota(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 1024
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	content := make([]byte, 2048)
+	_, err = rand.Read(content)
+	assert.NoError(t, err)
+	// the upload size is known in advance using the tsize option
+	err = tftpPut(tftpServerPort, "test.cfg", content, map[string]string{"tsize": "2048"})
+	assertTFTPError(t, err, 3)
+	err = tftpPut(tftpServerPort, "test.cfg", content, nil)
+	assertTFTPError(t, err, 3)
+	err = tftpPut(tftpServerPort, "test.cfg", content[:512], nil)
+	assert.NoError(t, err)
+
+	user.QuotaSize = 0
+	user.Filters.MaxUploadFileSize = 100
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = tftpPut(tftpServerPort, "test1.cfg", content, map[string]string{"tsize": "2048"})
+	assertTFTPError(t, err, 3)
+
+	user.Filters.MaxUploadFileSize = 0
+	user.DownloadDataTransfer = 1
+	user.UsedDownloadDataTransfer = 2 * 1024 * 1024
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = dataprovider.UpdateUserTransferQuota(&user, 0, 2*1024*1024, true)
+	assert.NoError(t, err)
+	_, err = tftpGet(tftpServerPort, "test.cfg", nil)
+	assertTFTPError(t, err, 2)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestInvalidRequests(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tftpServerPort}
+
+	for _, p := range [][]byte{
+		{0, 4, 0, 1},
+		{0, 1, 'a'},
+		append([]byte{0, 1}, "file\x00octet"...),
+		append([]byte{0, 1}, "\x00octet\x00"...),
+		append([]byte{0, 2}, "file\x00mail\x00"...),
+	} {
+		_, err = conn.WriteToUDP(p, serverAddr)
+		assert.NoError(t, err)
+		reply, _, err := readPacket(conn)
+		assert.NoError(t, err)
+		assertTFTPError(t, reply, 4)
+	}
+	// unexpected packet during a transfer
+	_, err = conn.WriteToUDP(newRequest(opWRQ, "file.cfg", nil), serverAddr)
+	assert.NoError(t, err)
+	reply, addr, err := readPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 4, 0, 0}, reply)
+	_, err = conn.WriteToUDP([]byte{0, 4, 0, 1}, addr)
+	assert.NoError(t, err)
+	reply, _, err = readPacket(conn)
+	assert.NoError(t, err)
+	assertTFTPError(t, reply, 4)
+	// the client does not send the data
+	_, err = conn.WriteToUDP(newRequest(opWRQ, "file1.cfg", nil), serverAddr)
+	assert.NoError(t, err)
+	reply, _, err = readPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 4, 0, 0}, reply)
+	// the ACK is retransmitted
+	reply, _, err = readPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 4, 0, 0}, reply)
+	assert.Eventually(t, func() bool {
+		return len(common.Connections.GetStats("")) == 0
+	}, 3*time.Second, 100*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestAbortedTransfers(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	content := make([]byte, 2048)
+	_, err = rand.Read(content)
+	assert.NoError(t, err)
+	err = tftpPut(tftpServerPort, "file.cfg", content, nil)
+	assert.NoError(t, err)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tftpServerPort}
+
+	_, err = conn.WriteToUDP(newRequest(opRRQ, "file.cfg", nil), serverAddr)
+	assert.NoError(t, err)
+	reply, addr, err := readPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, opDATA, binary.BigEndian.Uint16(reply))
+	// packets from an unknown transfer ID are rejected
+	otherConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer otherConn.Close()
+	_, err = otherConn.WriteToUDP([]byte{0, 4, 0, 1}, addr)
+	assert.NoError(t, err)
+	reply, _, err = readPacket(otherConn)
+	assert.NoError(t, err)
+	assertTFTPError(t, reply, 5)
+	// a duplicate ACK is ignored
+	_, err = conn.WriteToUDP([]byte{0, 4, 0, 0}, addr)
+	assert.NoError(t, err)
+	_, err = conn.WriteToUDP([]byte{0, 4, 0, 1}, addr)
+	assert.NoError(t, err)
+	reply, _, err = readPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, opDATA, binary.BigEndian.Uint16(reply))
+	assert.Equal(t, uint16(2), binary.BigEndian.Uint16(reply[2:]))
+	// abort the transfer
+	_, err = conn.WriteToUDP(append([]byte{0, 5, 0, 0}, "aborted\x00"...), addr)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(common.Connections.GetStats("")) == 0
+	}, 3*time.Second, 100*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func assertTFTPError(t *testing.T, v any, code uint16) {
+	t.Helper()
+
+	var err error
+	switch val := v.(type) {
+	case []byte:
+		err = parseError(val)
+	case error:
+		err = val
+	}
+	var tftpErr *tftpError
+	if assert.True(t, errors.As(err, &tftpErr), "unexpected error: %v", err) {
+		assert.Equal(t, code, tftpErr.code, tftpErr.message)
+	}
+}
+
+func parseError(p []byte) error {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opERROR {
+		return nil
+	}
+	return &tftpError{
+		code:    binary.BigEndian.Uint16(p[2:]),
+		message: string(bytes.TrimRight(p[4:], "\x00")),
+	}
+}
+
+func newRequest(opcode uint16, name string, options map[string]string) []byte {
+	p := binary.BigEndian.AppendUint16(nil, opcode)
+	p = append(p, name...)
+	p = append(p, 0)
+	p = append(p, "octet"...)
+	p = append(p, 0)
+	for k, v := range options {
+		p = append(p, k...)
+		p = append(p, 0)
+		p = append(p, v...)
+		p = append(p, 0)
+	}
+	return p
+}
+
+func readPacket(conn *net.UDPConn) ([]byte, *net.UDPAddr, error) {
+	buf := make([]byte, 65536)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, nil, err
+	}
+	n, addr, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf[:n], addr, nil
+}
+
+func getNegotiatedBlockSize(p []byte) int {
+	fields := bytes.Split(p[2:], []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if string(fields[i]) == "blksize" {
+			size, err := strconv.Atoi(string(fields[i+1]))
+			if err == nil {
+				return size
+			}
+		}
+	}
+	return 512
+}
+
+// tftpGet is a minimal TFTP client used to download a file
+func tftpGet(port int, name string, options map[string]string) ([]byte, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP(newRequest(opRRQ, name, options), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return nil, err
+	}
+	blockSize := 512
+	expected := uint16(1)
+	var result []byte
+	for {
+		p, addr, err := readPacket(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch binary.BigEndian.Uint16(p) {
+		case opERROR:
+			return nil, parseError(p)
+		case opOACK:
+			blockSize = getNegotiatedBlockSize(p)
+			if _, err := conn.WriteToUDP([]byte{0, 4, 0, 0}, addr); err != nil {
+				return nil, err
+			}
+		case opDATA:
+			block := binary.BigEndian.Uint16(p[2:])
+			if _, err := conn.WriteToUDP(binary.BigEndian.AppendUint16([]byte{0, 4}, block), addr); err != nil {
+				return nil, err
+			}
+			if block != expected {
+				continue
+			}
+			result = append(result, p[4:]...)
+			if len(p[4:]) < blockSize {
+				return result, nil
+			}
+			expected++
+		default:
+			return nil, fmt.Errorf("unexpected packet: %v", p)
+		}
+	}
+}
+
+// tftpPut is a minimal TFTP client used to upload a file
+func tftpPut(port int, name string, content []byte, options map[string]string) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP(newRequest(opWRQ, name, options), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return err
+	}
+	blockSize := 512
+	p, addr, err := readPacket(conn)
+	if err != nil {
+		return err
+	}
+	switch binary.BigEndian.Uint16(p) {
+	case opERROR:
+		return parseError(p)
+	case opOACK:
+		blockSize = getNegotiatedBlockSize(p)
+	case opACK:
+		if binary.BigEndian.Uint16(p[2:]) != 0 {
+			return fmt.Errorf("unexpected ACK: %v", p)
+		}
+	default:
+		return fmt.Errorf("unexpected packet: %v", p)
+	}
+	block := uint16(0)
+	for offset := 0; ; offset += blockSize {
+		end := offset + blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		block++
+		data := newDataPacket(block, content[offset:end])
+		if _, err := conn.WriteToUDP(data, addr); err != nil {
+			return err
+		}
+		p, _, err := readPacket(conn)
+		if err != nil {
+			return err
+		}
+		switch binary.BigEndian.Uint16(p) {
+		case opERROR:
+			return parseError(p)
+		case opACK:
+			if binary.BigEndian.Uint16(p[2:]) != block {
+				return fmt.Errorf("unexpected ACK: %v, block %d", p, block)
+			}
+		default:
+			return fmt.Errorf("unexpected packet: %v", p)
+		}
+		if end-offset < blockSize {
+			return nil
+		}
+	}
+}
+
+func newDataPacket(block uint16, data []byte) []byte {
+	p := binary.BigEndian.AppendUint16(nil, opDATA)
+	p = binary.BigEndian.AppendUint16(p, block)
+	return append(p, data...)
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:       defaultUsername,
+			Password:       defaultPassword,
+			HomeDir:        filepath.Join(homeBasePath, defaultUsername),
+			Status:         1,
+			ExpirationDate: 0,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = allPerms
+	return user
+}
+
+func waitTFTPListening(numBindings int) {
+	for {
+		status := tftpd.GetStatus()
+		if len(status.Bindings) < numBindings {
+			logger.WarnToConsole("tftp server not listening")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tftp server now listening")
+		break
+	}
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
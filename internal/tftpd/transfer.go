@@ -0,0 +1,148 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tftpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	errTimeout     = errors.New("timeout waiting for the client")
+	errRetransmit  = errors.New("retransmission requested")
+	errStalePacket = errors.New("stale packet")
+)
+
+// transfer handles the packets exchanged with the client, on the transfer
+// socket, for a single read or write request
+type transfer struct {
+	conn       *net.UDPConn
+	remoteAddr *net.UDPAddr
+	retries    int
+	timeout    time.Duration
+	buf        []byte
+}
+
+func newTransfer(conn *net.UDPConn, remoteAddr *net.UDPAddr, retries int) *transfer {
+	return &transfer{
+		conn:       conn,
+		remoteAddr: remoteAddr,
+		retries:    retries,
+		timeout:    defaultTimeout * time.Second,
+		buf:        make([]byte, maxPacketSize),
+	}
+}
+
+func (t *transfer) send(p []byte) error {
+	_, err := t.conn.WriteToUDP(p, t.remoteAddr)
+	return err
+}
+
+func (t *transfer) sendError(code uint16, message string) {
+	t.send(newErrorPacket(code, message)) //nolint:errcheck
+}
+
+// receive returns the next packet sent by the client. Packets received from
+// other addresses are rejected with an "unknown transfer ID" error
+func (t *transfer) receive(deadline time.Time) ([]byte, error) {
+	for {
+		if err := t.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		n, addr, err := t.conn.ReadFromUDP(t.buf)
+		if err != nil {
+			return nil, err
+		}
+		if !addr.IP.Equal(t.remoteAddr.IP) || addr.Port != t.remoteAddr.Port {
+			t.conn.WriteToUDP(newErrorPacket(errCodeUnknownTID, "unknown transfer ID"), addr) //nolint:errcheck
+			continue
+		}
+		return t.buf[:n], nil
+	}
+}
+
+// exchange sends the specified packet and waits for the expected reply. The
+// packet is retransmitted if no valid reply is received within the timeout.
+// The returned packet is valid until the next call
+func (t *transfer) exchange(p []byte, isExpected func([]byte) error) ([]byte, error) {
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if err := t.send(p); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(t.timeout)
+		for {
+			reply, err := t.receive(deadline)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if code, message, err := parseErrorPacket(reply); err == nil {
+				return nil, fmt.Errorf("transfer aborted by the client, code: %d, message: %q", code, message)
+			}
+			err = isExpected(reply)
+			if err == nil {
+				return reply, nil
+			}
+			if errors.Is(err, errRetransmit) {
+				break
+			}
+			if errors.Is(err, errInvalidPacket) {
+				t.sendError(errCodeIllegalOperation, err.Error())
+				return nil, err
+			}
+			// stale packet, for example a duplicate ACK, ignore it as suggested in
+			// RFC 1123 to avoid the Sorcerer's Apprentice Syndrome
+		}
+	}
+	return nil, errTimeout
+}
+
+// isACK returns a function to check if a packet is the ACK for the specified block
+func isACK(block uint16) func([]byte) error {
+	return func(p []byte) error {
+		ack, err := parseACKPacket(p)
+		if err != nil {
+			return err
+		}
+		if ack != block {
+			return errStalePacket
+		}
+		return nil
+	}
+}
+
+// isData returns a function to check if a packet is the DATA packet for the
+// specified block. A duplicate of the previous block means that our ACK was
+// lost and so a retransmission is requested
+func isData(block uint16) func([]byte) error {
+	return func(p []byte) error {
+		n, _, err := parseDataPacket(p)
+		if err != nil {
+			return err
+		}
+		if n == block-1 {
+			return errRetransmit
+		}
+		if n != block {
+			return errStalePacket
+		}
+		return nil
+	}
+}
@@ -46,6 +46,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/lithammer/shortuuid/v3"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rs/xid"
 	"golang.org/x/crypto/ssh"
 
@@ -581,6 +583,49 @@ func HTTPListenAndServe(srv *http.Server, address string, port int, isTLS bool,
 	return srv.Serve(listener)
 }
 
+// HTTPListenAndServeWithHTTP3 is like HTTPListenAndServe but it also serves
+// HTTP/3 requests on the UDP port matching the TCP one. The Alt-Svc header
+// is added to the HTTP/1.1 and HTTP/2 responses to advertise the HTTP/3
+// endpoint. The TLS configuration must be already set in the server.
+// HTTP/3 is not available for Unix-domain sockets
+func HTTPListenAndServeWithHTTP3(srv *http.Server, address string, port int, logSender string) error {
+	if filepath.IsAbs(address) && runtime.GOOS != osWindows {
+		logger.Warn(logSender, "", "HTTP/3 is not supported for Unix-domain socket %q, only TCP is enabled", address)
+		return HTTPListenAndServe(srv, address, port, true, logSender)
+	}
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", address, port))
+	if err != nil {
+		return err
+	}
+	h3Server := &http3.Server{
+		Handler:        srv.Handler,
+		TLSConfig:      srv.TLSConfig.Clone(),
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+		Port:           port,
+		QuicConfig: &quic.Config{
+			MaxIdleTimeout: srv.IdleTimeout,
+		},
+	}
+	defer h3Server.Close()
+
+	handler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3Server.SetQuicHeaders(w.Header()) //nolint:errcheck
+		handler.ServeHTTP(w, r)
+	})
+
+	logger.Info(logSender, "", "HTTP/3 listener registered, address: %v", conn.LocalAddr().String())
+	go func() {
+		defer conn.Close()
+
+		if err := h3Server.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(logSender, "", "HTTP/3 listener %v stopped: %v", conn.LocalAddr().String(), err)
+		}
+	}()
+
+	return HTTPListenAndServe(srv, address, port, true, logSender)
+}
+
 // GetTLSCiphersFromNames returns the TLS ciphers from the specified names
 func GetTLSCiphersFromNames(cipherNames []string) []uint16 {
 	var ciphers []uint16
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -40,10 +41,12 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/eikenb/pipeat"
 	"github.com/google/uuid"
 	"github.com/pkg/sftp"
 
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -54,6 +57,8 @@ import (
 const (
 	azureDefaultEndpoint = "blob.core.windows.net"
 	azFolderKey          = "hdi_isfolder"
+	// prefix for the metadata keys used to store checksums, hyphens are not allowed
+	azureChecksumMetadataPrefix = "sftpgo_"
 )
 
 // AzureBlobFs is a Fs implementation for Azure Blob storage.
@@ -66,6 +71,7 @@ type AzureBlobFs struct {
 	containerClient *container.Client
 	ctxTimeout      time.Duration
 	ctxLongTimeout  time.Duration
+	listingCache    *listingCache
 }
 
 func init() {
@@ -88,6 +94,7 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 		config:         &config,
 		ctxTimeout:     30 * time.Second,
 		ctxLongTimeout: 90 * time.Second,
+		listingCache:   newListingCache(),
 	}
 	if err := fs.config.validate(); err != nil {
 		return fs, err
@@ -168,20 +175,44 @@ func (fs *AzureBlobFs) ConnectionID() string {
 	return fs.connectionID
 }
 
-// Stat returns a FileInfo describing the named file
+// Stat returns a FileInfo describing the named file.
+// If symlinks emulation is enabled, links are followed
 func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Lstat(name)
+	if err != nil || !fs.config.EmulateSymlinks {
+		return info, err
+	}
+	return statSymlink(fs, fs.config.KeyPrefix, name, info)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *AzureBlobFs) Lstat(name string) (os.FileInfo, error) {
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
+	if info, ok := fs.listingCache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.stat(name)
+	if err == nil {
+		fs.listingCache.setStat(name, info)
+	}
+	return info, err
+}
 
+func (fs *AzureBlobFs) stat(name string) (os.FileInfo, error) {
 	attrs, err := fs.headObject(name)
 	if err == nil {
 		contentType := util.GetStringFromPointer(attrs.ContentType)
 		isDir := checkDirectoryMarkers(contentType, attrs.Metadata)
 		metric.AZListObjectsCompleted(nil)
+		if fs.config.EmulateSymlinks && !isDir && isSymlinkContentType(contentType) {
+			return newSymlinkFileInfo(name, util.GetIntFromPointer(attrs.ContentLength),
+				util.GetTimeFromPointer(attrs.LastModified)), nil
+		}
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir,
 			util.GetIntFromPointer(attrs.ContentLength),
 			util.GetTimeFromPointer(attrs.LastModified), false))
@@ -200,24 +231,49 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 	return nil, os.ErrNotExist
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *AzureBlobFs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
+	var size int64
+	var version string
+	cacheKey := getReadCacheKey(fs.getStorageID(), name)
+	if isReadCacheEnabled() {
+		if attrs, err := fs.headObject(name); err == nil && attrs.ETag != nil {
+			size = util.GetIntFromPointer(attrs.ContentLength)
+			version = string(*attrs.ETag)
+			if r, cancelFn, ok := openFromReadCache(fs, fs.localTempDir, cacheKey, offset, size, version); ok {
+				return nil, r, cancelFn, nil
+			}
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	ctx, cancelFn := context.WithCancel(context.Background())
+	var writer io.WriterAt = w
+	var cacheWriter *readCacheWriter
+	if offset == 0 && version != "" {
+		if cw, ok := newReadCacheWriter(w, cacheKey, size, version); ok {
+			writer = cw
+			cacheWriter = cw
+		}
+	}
 
 	go func() {
 		defer cancelFn()
 
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartDownload(ctx, blockBlob, offset, w)
+		err := fs.handleMultipartDownload(ctx, blockBlob, offset, writer)
+		if cacheWriter != nil {
+			cacheWriter.Done(err)
+		}
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, w.GetWrittenBytes(), err)
 		metric.AZTransferCompleted(w.GetWrittenBytes(), 1, err)
@@ -226,8 +282,17 @@ func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReader
 	return nil, r, cancelFn, nil
 }
 
-// Create creates or opens the named file for writing
+// Create creates or opens the named file for writing.
+// If upload staging is enabled, a local staged file is returned
 func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if flag != -1 && isUploadStagingEnabled() {
+		return createStagedFile(name, fs.localTempDir, flag, fs.streamUpload)
+	}
+	return fs.streamUpload(name, flag)
+}
+
+func (fs *AzureBlobFs) streamUpload(name string, flag int) (File, *PipeWriter, func(), error) {
+	fs.listingCache.invalidate()
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -255,6 +320,7 @@ func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(),
 
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
 		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers, metadata)
+		fs.listingCache.invalidate()
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, r.GetReadedBytes(), err)
@@ -269,7 +335,10 @@ func (fs *AzureBlobFs) Rename(source, target string) (int, int64, error) {
 	if source == target {
 		return -1, -1, nil
 	}
-	fi, err := fs.Stat(source)
+	fs.listingCache.invalidate()
+	defer fs.listingCache.invalidate()
+
+	fi, err := fs.stat(source)
 	if err != nil {
 		return -1, -1, err
 	}
@@ -278,6 +347,8 @@ func (fs *AzureBlobFs) Rename(source, target string) (int, int64, error) {
 
 // Remove removes the named file or (empty) directory.
 func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
+	defer fs.listingCache.invalidate()
+
 	if isDir {
 		hasContents, err := fs.hasContents(name)
 		if err != nil {
@@ -288,23 +359,15 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 		}
 	}
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
-
 	blobBlock := fs.containerClient.NewBlockBlobClient(name)
 	var deletSnapshots blob.DeleteSnapshotsOptionType
 	if !isDir {
 		deletSnapshots = blob.DeleteSnapshotsOptionTypeInclude
 	}
-	_, err := blobBlock.Delete(ctx, &blob.DeleteOptions{
-		DeleteSnapshots: &deletSnapshots,
-	})
+	err := fs.deleteBlob(blobBlock, deletSnapshots)
 	if err != nil && isDir {
 		if fs.isBadRequestError(err) {
-			deletSnapshots = blob.DeleteSnapshotsOptionTypeInclude
-			_, err = blobBlock.Delete(ctx, &blob.DeleteOptions{
-				DeleteSnapshots: &deletSnapshots,
-			})
+			err = fs.deleteBlob(blobBlock, blob.DeleteSnapshotsOptionTypeInclude)
 		}
 	}
 	metric.AZDeleteObjectCompleted(err)
@@ -318,7 +381,7 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *AzureBlobFs) Mkdir(name string) error {
-	_, err := fs.Stat(name)
+	_, err := fs.stat(name)
 	if !fs.IsNotExist(err) {
 		return err
 	}
@@ -326,13 +389,38 @@ func (fs *AzureBlobFs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*AzureBlobFs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are supported only if emulation is enabled
+func (fs *AzureBlobFs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	linkTarget, err := validateSymlinkTarget(fs.config.KeyPrefix, source, target)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.stat(target); err == nil {
+		return fmt.Errorf("cannot create symlink, %q already exists", target)
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	defer fs.listingCache.invalidate()
+
+	return fs.writeSymlink(target, linkTarget)
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*AzureBlobFs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *AzureBlobFs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	target, ok, err := fs.readSymlink(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%q is not a symlink", name)
+	}
+	return fs.GetRelativePath(getSymlinkKey(fs.config.KeyPrefix, target)), nil
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -351,7 +439,7 @@ func (fs *AzureBlobFs) Chtimes(name string, atime, mtime time.Time, isUploading
 		return ErrVfsUnsupported
 	}
 	if !isUploading {
-		info, err := fs.Stat(name)
+		info, err := fs.stat(name)
 		if err != nil {
 			return err
 		}
@@ -359,6 +447,7 @@ func (fs *AzureBlobFs) Chtimes(name string, atime, mtime time.Time, isUploading
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.listingCache.invalidate()
 
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
@@ -374,6 +463,16 @@ func (*AzureBlobFs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, dirname)
+		if err != nil {
+			return nil, err
+		}
+		dirname = resolved
+	}
+	if result, ok := fs.listingCache.getDir(dirname); ok {
+		return result, nil
+	}
 	var result []os.FileInfo
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
@@ -384,7 +483,7 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 	prefixes := make(map[string]bool)
 
-	pager := fs.containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+	pager := fs.newHierarchyPager(container.ListBlobsHierarchyOptions{
 		Include: container.ListBlobsInclude{
 			//Metadata: true,
 		},
@@ -420,12 +519,14 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			name = strings.TrimPrefix(name, prefix)
 			size := int64(0)
 			isDir := false
+			isSymlink := false
 			modTime := time.Unix(0, 0)
 			if blobItem.Properties != nil {
 				size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
 				modTime = util.GetTimeFromPointer(blobItem.Properties.LastModified)
 				contentType := util.GetStringFromPointer(blobItem.Properties.ContentType)
 				isDir = checkDirectoryMarkers(contentType, blobItem.Metadata)
+				isSymlink = fs.config.EmulateSymlinks && !isDir && isSymlinkContentType(contentType)
 				if isDir {
 					// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
 					if _, ok := prefixes[name]; ok {
@@ -437,10 +538,15 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				modTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
+			if isSymlink {
+				result = append(result, newSymlinkFileInfo(name, size, modTime))
+				continue
+			}
 			result = append(result, NewFileInfo(name, isDir, size, modTime, false))
 		}
 	}
 	metric.AZListObjectsCompleted(nil)
+	fs.listingCache.setDir(dirname, result)
 
 	return result, nil
 }
@@ -493,6 +599,14 @@ func (*AzureBlobFs) IsNotSupported(err error) bool {
 	return err == ErrVfsUnsupported
 }
 
+func isAzRetryableError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return isRetryableStatusCode(respErr.StatusCode)
+	}
+	return false
+}
+
 func (*AzureBlobFs) isBadRequestError(err error) bool {
 	if err == nil {
 		return false
@@ -524,7 +638,7 @@ func (fs *AzureBlobFs) getFileNamesInPrefix(fsPrefix string) (map[string]bool, e
 		prefix = strings.TrimPrefix(fsPrefix, "/")
 	}
 
-	pager := fs.containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+	pager := fs.newHierarchyPager(container.ListBlobsHierarchyOptions{
 		Include: container.ListBlobsInclude{
 			//Metadata: true,
 		},
@@ -570,7 +684,7 @@ func (fs *AzureBlobFs) GetDirSize(dirname string) (int, int64, error) {
 	size := int64(0)
 	prefix := fs.getPrefix(dirname)
 
-	pager := fs.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+	pager := fs.newFlatPager(container.ListBlobsFlatOptions{
 		Include: container.ListBlobsInclude{
 			Metadata: true,
 		},
@@ -639,7 +753,7 @@ func (fs *AzureBlobFs) GetRelativePath(name string) string {
 // directory in the tree, including root
 func (fs *AzureBlobFs) Walk(root string, walkFn filepath.WalkFunc) error {
 	prefix := fs.getPrefix(root)
-	pager := fs.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+	pager := fs.newFlatPager(container.ListBlobsFlatOptions{
 		Include: container.ListBlobsInclude{
 			Metadata: true,
 		},
@@ -698,24 +812,240 @@ func (fs *AzureBlobFs) ResolvePath(virtualPath string) (string, error) {
 	if !path.IsAbs(virtualPath) {
 		virtualPath = path.Clean("/" + virtualPath)
 	}
-	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+	name := fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/"))
+	if fs.config.EmulateSymlinks {
+		return resolveSymlinkParents(fs, fs.config.KeyPrefix, name)
+	}
+	return name, nil
 }
 
 // CopyFile implements the FsFileCopier interface
 func (fs *AzureBlobFs) CopyFile(source, target string, srcSize int64) error {
+	defer fs.listingCache.invalidate()
+
 	return fs.copyFileInternal(source, target)
 }
 
-func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, error) {
+// SetChecksums implements the FsChecksummer interface.
+// The MD5 checksum is verified against the one reported by Azure, if any,
+// and the checksums are stored as blob metadata
+func (fs *AzureBlobFs) SetChecksums(name string, checksums map[string]string) error {
+	defer fs.listingCache.invalidate()
+
+	props, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if len(props.ContentMD5) > 0 {
+		if err := verifyChecksum(name, ChecksumMD5, checksums, hex.EncodeToString(props.ContentMD5)); err != nil {
+			return err
+		}
+	}
+	metadata := make(map[string]*string)
+	for k, v := range props.Metadata {
+		metadata[k] = v
+	}
+	for algo, value := range checksums {
+		metadata[azureChecksumMetadataPrefix+algo] = util.NilIfEmpty(value)
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetMetadata(ctx, metadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: props.ETag,
+			},
+		},
+	})
+	return err
+}
+
+// GetChecksums implements the FsChecksummer interface
+func (fs *AzureBlobFs) GetChecksums(name string) (map[string]string, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string)
+	for k, v := range props.Metadata {
+		metadata[k] = util.GetStringFromPointer(v)
+	}
+	result := getChecksumsFromMetadata(metadata, azureChecksumMetadataPrefix)
+	if len(props.ContentMD5) > 0 {
+		result[ChecksumMD5] = hex.EncodeToString(props.ContentMD5)
+	}
+	return result, nil
+}
+
+// SetMetadata implements the FsMetadataSetter interface.
+// The metadata are added to the existing blob metadata, hyphens in the
+// keys are replaced with underscores
+func (fs *AzureBlobFs) SetMetadata(name string, metadata map[string]string) error {
+	defer fs.listingCache.invalidate()
+
+	props, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	blobMetadata := make(map[string]*string)
+	for k, v := range props.Metadata {
+		blobMetadata[k] = v
+	}
+	for k, v := range metadata {
+		blobMetadata[azureChecksumMetadataPrefix+strings.ReplaceAll(k, "-", "_")] = util.NilIfEmpty(v)
+	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	resp, err := fs.containerClient.NewBlockBlobClient(name).GetProperties(ctx, &blob.GetPropertiesOptions{})
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetMetadata(ctx, blobMetadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: props.ETag,
+			},
+		},
+	})
+	return err
+}
+
+func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, error) {
+	var resp blob.GetPropertiesResponse
+	err := fs.config.retry(context.Background(), fs, "head blob", isAzRetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		var err error
+		resp, err = fs.containerClient.NewBlockBlobClient(name).GetProperties(ctx, &blob.GetPropertiesOptions{})
+		return err
+	})
 
 	metric.AZHeadObjectCompleted(err)
 	return resp, err
 }
 
+func (fs *AzureBlobFs) deleteBlob(blobBlock *blockblob.Client, deleteSnapshots blob.DeleteSnapshotsOptionType) error {
+	return fs.config.retry(context.Background(), fs, "delete blob", isAzRetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		_, err := blobBlock.Delete(ctx, &blob.DeleteOptions{
+			DeleteSnapshots: &deleteSnapshots,
+		})
+		return err
+	})
+}
+
+func (fs *AzureBlobFs) newHierarchyPager(options container.ListBlobsHierarchyOptions) *azPager[container.ListBlobsHierarchyResponse] {
+	return newAzPager(fs, func(marker *string) *runtime.Pager[container.ListBlobsHierarchyResponse] {
+		options.Marker = marker
+		return fs.containerClient.NewListBlobsHierarchyPager("/", &options)
+	}, func(resp container.ListBlobsHierarchyResponse) *string {
+		return resp.NextMarker
+	})
+}
+
+func (fs *AzureBlobFs) newFlatPager(options container.ListBlobsFlatOptions) *azPager[container.ListBlobsFlatResponse] {
+	return newAzPager(fs, func(marker *string) *runtime.Pager[container.ListBlobsFlatResponse] {
+		options.Marker = marker
+		return fs.containerClient.NewListBlobsFlatPager(&options)
+	}, func(resp container.ListBlobsFlatResponse) *string {
+		return resp.NextMarker
+	})
+}
+
+func newAzPager[T any](fs *AzureBlobFs, newPager func(marker *string) *runtime.Pager[T],
+	getMarker func(T) *string,
+) *azPager[T] {
+	return &azPager[T]{
+		fs:        fs,
+		newPager:  newPager,
+		getMarker: getMarker,
+		pager:     newPager(nil),
+	}
+}
+
+// azPager returns the listing pages. A pager cannot fetch its first page again
+// after an error, so transient errors are retried using a new pager that resumes
+// the listing from the last marker
+type azPager[T any] struct {
+	fs        *AzureBlobFs
+	newPager  func(marker *string) *runtime.Pager[T]
+	getMarker func(T) *string
+	pager     *runtime.Pager[T]
+	marker    *string
+}
+
+func (p *azPager[T]) More() bool {
+	return p.pager.More()
+}
+
+func (p *azPager[T]) NextPage(ctx context.Context) (T, error) {
+	var resp T
+	err := p.fs.config.retry(ctx, p.fs, "list blobs", isAzRetryableError, func() error {
+		var err error
+		resp, err = p.pager.NextPage(ctx)
+		if err != nil {
+			p.pager = p.newPager(p.marker)
+			return err
+		}
+		p.marker = p.getMarker(resp)
+		return nil
+	})
+	return resp, err
+}
+
+func (fs *AzureBlobFs) readSymlink(name string) (string, bool, error) {
+	if info, ok := fs.listingCache.getStat(name); ok && info.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if !isSymlinkContentType(util.GetStringFromPointer(attrs.ContentType)) ||
+		util.GetIntFromPointer(attrs.ContentLength) > maxSymlinkTargetSize {
+		return "", false, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.containerClient.NewBlockBlobClient(name).DownloadStream(ctx, nil)
+	if err != nil {
+		metric.AZTransferCompleted(0, 1, err)
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	target, err := readSymlinkTarget(resp.Body)
+	metric.AZTransferCompleted(int64(len(target)), 1, err)
+	return target, err == nil, err
+}
+
+func (fs *AzureBlobFs) writeSymlink(name, linkTarget string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	contentType := symlinkContentType
+	options := &blockblob.UploadOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: &contentType,
+		},
+	}
+	if fs.config.AccessTier != "" {
+		options.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	}
+	body := &bytesReaderWrapper{
+		Reader: bytes.NewReader([]byte(linkTarget)),
+	}
+	_, err := fs.containerClient.NewBlockBlobClient(name).Upload(ctx, body, options)
+	metric.AZTransferCompleted(int64(len(linkTarget)), 0, err)
+	fsLog(fs, logger.LevelDebug, "symlink %q -> %q written, err: %v", name, linkTarget, err)
+	return err
+}
+
 // GetMimeType returns the content type
 func (fs *AzureBlobFs) GetMimeType(name string) (string, error) {
 	response, err := fs.headObject(name)
@@ -789,7 +1119,12 @@ func (fs *AzureBlobFs) copyFileInternal(source, target string) error {
 
 	srcBlob := fs.containerClient.NewBlockBlobClient(source)
 	dstBlob := fs.containerClient.NewBlockBlobClient(target)
-	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions())
+	var resp blob.StartCopyFromURLResponse
+	err := fs.config.retry(ctx, fs, "copy blob", isAzRetryableError, func() error {
+		var err error
+		resp, err = dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions())
+		return err
+	})
 	if err != nil {
 		metric.AZCopyObjectCompleted(err)
 		return err
@@ -826,7 +1161,18 @@ func (fs *AzureBlobFs) renameInternal(source, target string, fi os.FileInfo) (in
 	var numFiles int
 	var filesSize int64
 
-	if fi.IsDir() {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		linkTarget, ok, err := fs.readSymlink(source)
+		if err != nil {
+			return numFiles, filesSize, err
+		}
+		if !ok {
+			return numFiles, filesSize, fmt.Errorf("%q is not a symlink", source)
+		}
+		if err := fs.writeSymlink(target, linkTarget); err != nil {
+			return numFiles, filesSize, err
+		}
+	} else if fi.IsDir() {
 		if renameMode == 0 {
 			hasContents, err := fs.hasContents(source)
 			if err != nil {
@@ -892,7 +1238,7 @@ func (fs *AzureBlobFs) hasContents(name string) (bool, error) {
 	prefix := fs.getPrefix(name)
 
 	maxResults := int32(1)
-	pager := fs.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+	pager := fs.newFlatPager(container.ListBlobsFlatOptions{
 		MaxResults: &maxResults,
 		Prefix:     &prefix,
 	})
@@ -1245,3 +1591,47 @@ func (b *bufferAllocator) free() {
 	b.available = nil
 	b.finalized = true
 }
+
+// rotateAzBlobCredentials generates a new container SAS URL signed using the
+// account key. The SAS URL expires after two rotation intervals so it remains
+// valid if a rotation fails. Previously generated SAS URLs cannot be revoked,
+// they remain valid until they expire, so no revoke function is returned
+func rotateAzBlobCredentials(_ context.Context, config *AzBlobFsConfig) (func(context.Context) error, error) {
+	if config.AccountName == "" || config.AccountKey.IsEmpty() || config.Container == "" {
+		return nil, errCredentialsRotationNotSupported
+	}
+	accountKey := config.AccountKey.Clone()
+	if err := accountKey.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt account key: %w", err)
+	}
+	credential, err := container.NewSharedKeyCredential(config.AccountName, accountKey.GetPayload())
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = azureDefaultEndpoint
+	}
+	protocol := sas.ProtocolHTTPS
+	if config.UseEmulator {
+		endpoint = fmt.Sprintf("%s/%s", endpoint, config.AccountName)
+		protocol = sas.ProtocolHTTPSandHTTP
+	} else {
+		endpoint = fmt.Sprintf("https://%s.%s/", config.AccountName, endpoint)
+	}
+	now := time.Now().UTC()
+	permissions := sas.ContainerPermissions{Read: true, Add: true, Create: true, Write: true, Delete: true, List: true}
+	params, err := sas.BlobSignatureValues{
+		Protocol:      protocol,
+		StartTime:     now.Add(-5 * time.Minute),
+		ExpiryTime:    now.Add(time.Duration(2*max(config.RotationInterval, 1)) * 24 * time.Hour),
+		Permissions:   permissions.String(),
+		ContainerName: config.Container,
+	}.SignWithSharedKey(credential)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign SAS URL: %w", err)
+	}
+	sasURL := fmt.Sprintf("%s?%s", runtime.JoinPaths(endpoint, config.Container), params.Encode())
+	config.SASURL = kms.NewPlainSecret(sasURL)
+	return nil, nil
+}
@@ -18,6 +18,7 @@
 package vfs
 
 import (
+	"context"
 	"errors"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -31,3 +32,7 @@ func init() {
 func NewAzBlobFs(_, _, _ string, _ AzBlobFsConfig) (Fs, error) {
 	return nil, errors.New("Azure Blob Storage disabled at build time")
 }
+
+func rotateAzBlobCredentials(_ context.Context, _ *AzBlobFsConfig) (func(context.Context) error, error) {
+	return nil, errCredentialsRotationNotSupported
+}
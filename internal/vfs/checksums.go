@@ -0,0 +1,92 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supported checksum algorithms
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+	ChecksumCRC32C = "crc32c"
+)
+
+const (
+	// prefix for the metadata keys used to store checksums and custom metadata
+	checksumMetadataPrefix = "sftpgo-"
+	// prefix for the extended attributes used to store checksums and custom
+	// metadata on the local filesystem
+	checksumXattrPrefix = "user.sftpgo."
+)
+
+var (
+	// ErrChecksumMismatch is returned if the checksums computed while uploading a file
+	// do not match the ones reported by the storage backend
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// SupportedChecksums defines the supported checksum algorithms
+	SupportedChecksums = []string{ChecksumSHA256, ChecksumMD5, ChecksumCRC32C}
+)
+
+// verifyChecksum compares the computed checksum for the given algorithm, if any,
+// with the one reported by the storage backend, if any
+func verifyChecksum(name, algo string, computed map[string]string, reported string) error {
+	value, ok := computed[algo]
+	if !ok || reported == "" {
+		return nil
+	}
+	if !strings.EqualFold(value, reported) {
+		return fmt.Errorf("%w for %q, algorithm %s, computed: %s, reported: %s", ErrChecksumMismatch, name,
+			algo, value, reported)
+	}
+	return nil
+}
+
+// getChecksumsFromMetadata returns the checksums stored in the specified metadata
+func getChecksumsFromMetadata(metadata map[string]string, prefix string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range metadata {
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, prefix) || v == "" {
+			continue
+		}
+		algo := strings.TrimPrefix(key, prefix)
+		for _, supported := range SupportedChecksums {
+			if algo == supported {
+				result[algo] = v
+			}
+		}
+	}
+	return result
+}
+
+// base64ToHex converts a base64 encoded checksum, as reported by some storage
+// backends, to the hex encoding used by SFTPGo. It returns an empty string if
+// the value cannot be decoded
+func base64ToHex(value string) string {
+	if value == "" {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(decoded)
+}
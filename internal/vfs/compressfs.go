@@ -0,0 +1,416 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/eikenb/pipeat"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported compression algorithms
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const (
+	// compressedFsName is the name for the Fs implementation with transparent compression
+	compressedFsName = "compressedfs"
+	// The uncompressed size is appended to each compressed file. For zstd we use a
+	// skippable frame, for gzip an empty member with an extra field, so the stored
+	// files can still be decompressed using the standard tools
+	zstdSizeTrailerLen = 20
+	gzipSizeTrailerLen = 34
+)
+
+var (
+	// SupportedCompressions defines the supported compression algorithms
+	SupportedCompressions = []string{CompressionGzip, CompressionZstd}
+	zstdSizeTrailerMagic  = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x0c, 0x00, 0x00, 0x00, 'S', 'G', 'S', 'Z'}
+	gzipSizeTrailerMagic  = []byte{0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x0c, 0x00,
+		'S', 'Z', 0x08, 0x00}
+	// empty deflate block, CRC-32 and size for an empty input
+	gzipSizeTrailerEnd = []byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+)
+
+func validateCompression(algo string) error {
+	if algo != "" && !util.Contains(SupportedCompressions, algo) {
+		return fmt.Errorf("unsupported compression algorithm %q", algo)
+	}
+	return nil
+}
+
+func getSizeTrailer(algo string, size int64) []byte {
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], uint64(size))
+	if algo == CompressionZstd {
+		trailer := make([]byte, 0, zstdSizeTrailerLen)
+		trailer = append(trailer, zstdSizeTrailerMagic...)
+		return append(trailer, sizeBuf[:]...)
+	}
+	trailer := make([]byte, 0, gzipSizeTrailerLen)
+	trailer = append(trailer, gzipSizeTrailerMagic...)
+	trailer = append(trailer, sizeBuf[:]...)
+	return append(trailer, gzipSizeTrailerEnd...)
+}
+
+// parseSizeTrailer returns the uncompressed size and the compression algorithm
+// from the end of the specified data, ok is false if no valid trailer is found
+func parseSizeTrailer(data []byte) (int64, string, bool) {
+	if len(data) >= zstdSizeTrailerLen {
+		trailer := data[len(data)-zstdSizeTrailerLen:]
+		if bytes.HasPrefix(trailer, zstdSizeTrailerMagic) {
+			size := int64(binary.LittleEndian.Uint64(trailer[len(zstdSizeTrailerMagic):]))
+			return size, CompressionZstd, size >= 0
+		}
+	}
+	if len(data) >= gzipSizeTrailerLen {
+		trailer := data[len(data)-gzipSizeTrailerLen:]
+		if bytes.HasPrefix(trailer, gzipSizeTrailerMagic) && bytes.HasSuffix(trailer, gzipSizeTrailerEnd) {
+			size := int64(binary.LittleEndian.Uint64(trailer[len(gzipSizeTrailerMagic):]))
+			return size, CompressionGzip, size >= 0
+		}
+	}
+	return 0, "", false
+}
+
+// CompressedFs is a Fs implementation that wraps a local or SFTP Fs and
+// transparently compresses files on upload and decompresses them on download.
+// The reported sizes are the uncompressed ones. Files not compressed by SFTPGo,
+// for example files stored before enabling compression, are returned as is
+type CompressedFs struct {
+	Fs
+	mountPath    string
+	localTempDir string
+	algo         string
+}
+
+// NewCompressedFs returns a CompressedFs wrapping the specified Fs.
+// If no compression algorithm is specified fs is returned unchanged
+func NewCompressedFs(fs Fs, mountPath, localTempDir, algo string) Fs {
+	if algo == "" {
+		return fs
+	}
+	if tempPath != "" {
+		localTempDir = tempPath
+	}
+	return &CompressedFs{
+		Fs:           fs,
+		mountPath:    getMountPath(mountPath),
+		localTempDir: localTempDir,
+		algo:         algo,
+	}
+}
+
+// Name returns the name for the Fs implementation
+func (fs *CompressedFs) Name() string {
+	return fmt.Sprintf("%s (%s) on %s", compressedFsName, fs.algo, fs.Fs.Name())
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *CompressedFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *CompressedFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Open opens the named file for reading
+func (fs *CompressedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	_, algo, ok := fs.getUncompressedSize(name, info.Size())
+	if !ok {
+		return fs.Fs.Open(name, offset)
+	}
+	src, err := fs.openRaw(name, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		src.Close()
+		return nil, nil, nil, err
+	}
+
+	go func() {
+		n, err := decompress(w, src, algo, offset)
+		w.CloseWithError(err) //nolint:errcheck
+		src.Close()
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %d, err: %v", name, n, err)
+	}()
+
+	return nil, r, nil, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *CompressedFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	f, w, cancelFn, err := fs.Fs.Create(name, flag)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var dst io.WriteCloser
+	if f != nil {
+		dst = f
+	} else {
+		dst = w
+	}
+	r, pw, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		dst.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(pw)
+
+	go func() {
+		n, err := compress(dst, r, fs.algo)
+		errClose := dst.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Truncate changes the size of the named file
+func (*CompressedFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *CompressedFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	list, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	for idx, info := range list {
+		list[idx] = fs.convertFileInfo(fs.Join(dirname, info.Name()), info)
+	}
+	return list, nil
+}
+
+// IsUploadResumeSupported returns false, compressed files cannot be resumed
+func (*CompressedFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their uncompressed size
+func (fs *CompressedFs) ScanRootDirContents() (int, int64, error) {
+	rootDir, err := fs.Fs.ResolvePath(path.Join("/", fs.mountPath))
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.GetDirSize(rootDir)
+}
+
+// GetDirSize returns the number of files and the uncompressed size for a folder
+// including any subfolders
+func (fs *CompressedFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs.Fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+				if numFiles%1000 == 0 {
+					fsLog(fs, logger.LevelDebug, "dirname %q scan in progress, files: %d, size: %d", dirname, numFiles, size)
+				}
+			}
+			return err
+		})
+	}
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *CompressedFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.Fs.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.convertFileInfo(walkedPath, info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type
+func (fs *CompressedFs) GetMimeType(name string) (string, error) {
+	f, r, _, err := fs.Open(name, 0)
+	if err != nil {
+		return "", err
+	}
+	if f != nil {
+		// not compressed
+		f.Close()
+		return fs.Fs.GetMimeType(name)
+	}
+	defer r.Close()
+
+	var buf [512]byte
+	n, err := io.ReadFull(r, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// openRaw opens the named file, as stored, for reading
+func (fs *CompressedFs) openRaw(name string, offset int64) (io.ReadCloser, error) {
+	f, r, _, err := fs.Fs.Open(name, offset)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		return f, nil
+	}
+	return r, nil
+}
+
+// getUncompressedSize reads the size trailer for the named file, ok is false
+// if the file was not compressed by SFTPGo
+func (fs *CompressedFs) getUncompressedSize(name string, size int64) (int64, string, bool) {
+	if size < zstdSizeTrailerLen {
+		return 0, "", false
+	}
+	offset := size - gzipSizeTrailerLen
+	if offset < 0 {
+		offset = 0
+	}
+	r, err := fs.openRaw(name, offset)
+	if err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to open %q to read the size trailer: %v", name, err)
+		return 0, "", false
+	}
+	defer r.Close()
+
+	buf := make([]byte, size-offset)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to read the size trailer for %q: %v", name, err)
+		return 0, "", false
+	}
+	return parseSizeTrailer(buf)
+}
+
+func (fs *CompressedFs) convertFileInfo(name string, info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	size, _, ok := fs.getUncompressedSize(name, info.Size())
+	if !ok {
+		return info
+	}
+	return &compressedFileInfo{
+		FileInfo: info,
+		size:     size,
+	}
+}
+
+// compressedFileInfo reports the uncompressed size and preserves all the
+// other attributes of the stored file
+type compressedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+// Size returns the uncompressed size
+func (fi *compressedFileInfo) Size() int64 {
+	return fi.size
+}
+
+func compress(dst io.Writer, src io.Reader, algo string) (int64, error) {
+	var enc io.WriteCloser
+	if algo == CompressionZstd {
+		zstdEnc, err := zstd.NewWriter(dst, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return 0, err
+		}
+		enc = zstdEnc
+	} else {
+		enc = gzip.NewWriter(dst)
+	}
+	n, err := io.Copy(enc, src)
+	errClose := enc.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return n, err
+	}
+	_, err = dst.Write(getSizeTrailer(algo, n))
+	return n, err
+}
+
+func decompress(dst io.Writer, src io.Reader, algo string, offset int64) (int64, error) {
+	var reader io.Reader
+	br := bufio.NewReader(src)
+	if algo == CompressionZstd {
+		dec, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return 0, err
+		}
+		defer dec.Close()
+		reader = dec
+	} else {
+		dec, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, err
+		}
+		defer dec.Close()
+		reader = dec
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+			return 0, err
+		}
+	}
+	return io.Copy(dst, reader)
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxCredentialsRotationInterval = 365
+)
+
+var (
+	errCredentialsRotationNotSupported = errors.New("credentials rotation is not supported for this filesystem")
+)
+
+// CredentialsRotation defines how often the credentials for a Cloud Storage
+// backend are automatically rotated using the provider APIs:
+//   - S3, a new access key is created for the IAM user and the previous one is deleted
+//   - GCS, a new key is created for the service account and the previous one is deleted
+//   - Azure Blob, a new SAS URL is generated and signed using the account key
+type CredentialsRotation struct {
+	// Rotation interval as days. 0 means disabled
+	RotationInterval int `json:"rotation_interval,omitempty"`
+	// Last rotation as unix timestamp in milliseconds. It is set when the
+	// rotation is enabled and updated after each successful rotation
+	RotatedAt int64 `json:"rotated_at,omitempty"`
+}
+
+func (r *CredentialsRotation) validate() error {
+	if r.RotationInterval < 0 || r.RotationInterval > maxCredentialsRotationInterval {
+		return fmt.Errorf("invalid rotation_interval %d, it must be between 0 and %d",
+			r.RotationInterval, maxCredentialsRotationInterval)
+	}
+	if !r.IsEnabled() {
+		r.RotatedAt = 0
+		return nil
+	}
+	// the rotation interval starts when the rotation is enabled
+	if r.RotatedAt <= 0 {
+		r.RotatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	return nil
+}
+
+// IsEnabled returns true if the automatic credentials rotation is enabled
+func (r *CredentialsRotation) IsEnabled() bool {
+	return r.RotationInterval > 0
+}
+
+// IsRotationDue returns true if the rotation is enabled and the rotation
+// interval elapsed since the last rotation
+func (r *CredentialsRotation) IsRotationDue(now time.Time) bool {
+	if !r.IsEnabled() {
+		return false
+	}
+	lastRotation := util.GetTimeFromMsecSinceEpoch(r.RotatedAt)
+	return now.Sub(lastRotation) >= time.Duration(r.RotationInterval)*24*time.Hour
+}
+
+// GetRotatedAtAsString returns the last rotation time as string
+func (r CredentialsRotation) GetRotatedAtAsString() string {
+	if r.RotatedAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(r.RotatedAt).UTC().Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+// GetCredentialsRotation returns the credentials rotation settings for
+// the configured provider, nil if the provider does not support rotation
+func (f *Filesystem) GetCredentialsRotation() *CredentialsRotation {
+	switch f.Provider {
+	case sdk.S3FilesystemProvider:
+		return &f.S3Config.CredentialsRotation
+	case sdk.GCSFilesystemProvider:
+		return &f.GCSConfig.CredentialsRotation
+	case sdk.AzureBlobFilesystemProvider:
+		return &f.AzBlobConfig.CredentialsRotation
+	default:
+		return nil
+	}
+}
+
+// RotateCredentials rotates the credentials for the configured Cloud Storage
+// provider. The new credentials are set as plain text secrets, they will be
+// encrypted when the filesystem configuration is saved. The previous
+// credentials are still valid, if the returned function is not nil it must be
+// called, after saving the new credentials, to revoke them
+func (f *Filesystem) RotateCredentials(ctx context.Context) (func(context.Context) error, error) {
+	var revoke func(context.Context) error
+	var err error
+
+	switch f.Provider {
+	case sdk.S3FilesystemProvider:
+		revoke, err = rotateS3Credentials(ctx, &f.S3Config)
+	case sdk.GCSFilesystemProvider:
+		revoke, err = rotateGCSCredentials(ctx, &f.GCSConfig)
+	case sdk.AzureBlobFilesystemProvider:
+		revoke, err = rotateAzBlobCredentials(ctx, &f.AzBlobConfig)
+	default:
+		return nil, errCredentialsRotationNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.GetCredentialsRotation().RotatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return revoke, nil
+}
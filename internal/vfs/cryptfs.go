@@ -229,6 +229,15 @@ func (fs *CryptFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	return result, nil
 }
 
+// CopyFile implements the FsFileCopier interface.
+// The encryption key is derived from the master key and the nonce stored
+// in the file header, it does not depend on the file path, so the encrypted
+// file can be copied as is. Callers must ensure that source and target use
+// the same passphrase
+func (fs *CryptFs) CopyFile(source, target string, srcSize int64) error {
+	return fs.OsFs.CopyFile(source, target, srcSize)
+}
+
 // IsUploadResumeSupported returns false sio does not support random access writes
 func (*CryptFs) IsUploadResumeSupported() bool {
 	return false
@@ -26,6 +26,7 @@ import (
 type Filesystem struct {
 	RedactedSecret string                 `json:"-"`
 	Provider       sdk.FilesystemProvider `json:"provider"`
+	OSConfig       OSFsConfig             `json:"osconfig,omitempty"`
 	S3Config       S3FsConfig             `json:"s3config,omitempty"`
 	GCSConfig      GCSFsConfig            `json:"gcsconfig,omitempty"`
 	AzBlobConfig   AzBlobFsConfig         `json:"azblobconfig,omitempty"`
@@ -46,6 +47,7 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
 	f.HTTPConfig.Password = kms.NewEmptySecret()
 	f.HTTPConfig.APIKey = kms.NewEmptySecret()
+	f.HTTPConfig.OAuth2.ClientSecret = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -80,6 +82,9 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.HTTPConfig.APIKey == nil {
 		f.HTTPConfig.APIKey = kms.NewEmptySecret()
 	}
+	if f.HTTPConfig.OAuth2.ClientSecret == nil {
+		f.HTTPConfig.OAuth2.ClientSecret = kms.NewEmptySecret()
+	}
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -105,6 +110,26 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	f.HTTPConfig.setNilSecretsIfEmpty()
 }
 
+// RewrapSecrets re-encrypts the secrets using the latest key version, if
+// supported by the configured KMS provider. It returns true if at least one
+// secret was updated
+func (f *Filesystem) RewrapSecrets() (bool, error) {
+	f.SetEmptySecretsIfNil()
+	var rewrapped bool
+	for _, secret := range []*kms.Secret{f.S3Config.AccessSecret, f.GCSConfig.Credentials, f.AzBlobConfig.AccountKey,
+		f.AzBlobConfig.SASURL, f.CryptConfig.Passphrase, f.SFTPConfig.Password, f.SFTPConfig.PrivateKey,
+		f.SFTPConfig.KeyPassphrase, f.HTTPConfig.Password, f.HTTPConfig.APIKey, f.HTTPConfig.OAuth2.ClientSecret} {
+		updated, err := secret.Rewrap()
+		if err != nil {
+			return rewrapped, err
+		}
+		if updated {
+			rewrapped = true
+		}
+	}
+	return rewrapped, nil
+}
+
 // IsEqual returns true if the fs is equal to other
 func (f *Filesystem) IsEqual(other Filesystem) bool {
 	if f.Provider != other.Provider {
@@ -124,7 +149,7 @@ func (f *Filesystem) IsEqual(other Filesystem) bool {
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isEqual(other.HTTPConfig)
 	default:
-		return true
+		return f.OSConfig.isEqual(other.OSConfig)
 	}
 }
 
@@ -169,6 +194,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.S3Config.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
@@ -179,6 +205,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.GCSConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.S3Config = S3FsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
@@ -189,6 +216,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.AzBlobConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.S3Config = S3FsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
@@ -199,6 +227,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.CryptConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.S3Config = S3FsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
@@ -209,6 +238,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.SFTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.S3Config = S3FsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
@@ -219,6 +249,7 @@ func (f *Filesystem) Validate(additionalData string) error {
 		if err := f.HTTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
 			return err
 		}
+		f.OSConfig = OSFsConfig{}
 		f.S3Config = S3FsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
@@ -227,6 +258,9 @@ func (f *Filesystem) Validate(additionalData string) error {
 		return nil
 	default:
 		f.Provider = sdk.LocalFilesystemProvider
+		if err := f.OSConfig.Validate(); err != nil {
+			return err
+		}
 		f.S3Config = S3FsConfig{}
 		f.GCSConfig = GCSFsConfig{}
 		f.AzBlobConfig = AzBlobFsConfig{}
@@ -264,7 +298,10 @@ func (f *Filesystem) HasRedactedSecret() bool {
 		if f.HTTPConfig.Password.IsRedacted() {
 			return true
 		}
-		return f.HTTPConfig.APIKey.IsRedacted()
+		if f.HTTPConfig.APIKey.IsRedacted() {
+			return true
+		}
+		return f.HTTPConfig.OAuth2.ClientSecret.IsRedacted()
 	}
 
 	return false
@@ -288,11 +325,25 @@ func (f *Filesystem) HideConfidentialData() {
 	}
 }
 
+// HideConfig removes the whole configuration, and so the credentials,
+// only the storage provider is preserved
+func (f *Filesystem) HideConfig() {
+	*f = Filesystem{
+		Provider: f.Provider,
+	}
+}
+
 // GetACopy returns a filesystem copy
 func (f *Filesystem) GetACopy() Filesystem {
 	f.SetEmptySecretsIfNil()
 	fs := Filesystem{
 		Provider: f.Provider,
+		OSConfig: OSFsConfig{
+			Compression: f.OSConfig.Compression,
+			UID:         f.OSConfig.UID,
+			GID:         f.OSConfig.GID,
+			Umask:       f.OSConfig.Umask,
+		},
 		S3Config: S3FsConfig{
 			BaseS3FsConfig: sdk.BaseS3FsConfig{
 				Bucket:              f.S3Config.Bucket,
@@ -311,7 +362,11 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartMaxTime:   f.S3Config.UploadPartMaxTime,
 				ForcePathStyle:      f.S3Config.ForcePathStyle,
 			},
-			AccessSecret: f.S3Config.AccessSecret.Clone(),
+			AccessSecret:        f.S3Config.AccessSecret.Clone(),
+			StorageClassRules:   copyStorageClassRules(f.S3Config.StorageClassRules),
+			EmulateSymlinks:     f.S3Config.EmulateSymlinks,
+			CloudRetryPolicy:    f.S3Config.CloudRetryPolicy,
+			CredentialsRotation: f.S3Config.CredentialsRotation,
 		},
 		GCSConfig: GCSFsConfig{
 			BaseGCSFsConfig: sdk.BaseGCSFsConfig{
@@ -323,7 +378,11 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartSize:       f.GCSConfig.UploadPartSize,
 				UploadPartMaxTime:    f.GCSConfig.UploadPartMaxTime,
 			},
-			Credentials: f.GCSConfig.Credentials.Clone(),
+			Credentials:         f.GCSConfig.Credentials.Clone(),
+			StorageClassRules:   copyStorageClassRules(f.GCSConfig.StorageClassRules),
+			EmulateSymlinks:     f.GCSConfig.EmulateSymlinks,
+			CloudRetryPolicy:    f.GCSConfig.CloudRetryPolicy,
+			CredentialsRotation: f.GCSConfig.CredentialsRotation,
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -338,8 +397,11 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UseEmulator:         f.AzBlobConfig.UseEmulator,
 				AccessTier:          f.AzBlobConfig.AccessTier,
 			},
-			AccountKey: f.AzBlobConfig.AccountKey.Clone(),
-			SASURL:     f.AzBlobConfig.SASURL.Clone(),
+			AccountKey:          f.AzBlobConfig.AccountKey.Clone(),
+			SASURL:              f.AzBlobConfig.SASURL.Clone(),
+			EmulateSymlinks:     f.AzBlobConfig.EmulateSymlinks,
+			CloudRetryPolicy:    f.AzBlobConfig.CloudRetryPolicy,
+			CredentialsRotation: f.AzBlobConfig.CredentialsRotation,
 		},
 		CryptConfig: CryptFsConfig{
 			Passphrase: f.CryptConfig.Passphrase.Clone(),
@@ -353,9 +415,13 @@ func (f *Filesystem) GetACopy() Filesystem {
 				BufferSize:              f.SFTPConfig.BufferSize,
 				EqualityCheckMode:       f.SFTPConfig.EqualityCheckMode,
 			},
-			Password:      f.SFTPConfig.Password.Clone(),
-			PrivateKey:    f.SFTPConfig.PrivateKey.Clone(),
-			KeyPassphrase: f.SFTPConfig.KeyPassphrase.Clone(),
+			Password:         f.SFTPConfig.Password.Clone(),
+			PrivateKey:       f.SFTPConfig.PrivateKey.Clone(),
+			KeyPassphrase:    f.SFTPConfig.KeyPassphrase.Clone(),
+			ProxyURL:         f.SFTPConfig.ProxyURL,
+			JumpHost:         f.SFTPConfig.JumpHost,
+			JumpHostUsername: f.SFTPConfig.JumpHostUsername,
+			Compression:      f.SFTPConfig.Compression,
 		},
 		HTTPConfig: HTTPFsConfig{
 			BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
@@ -366,11 +432,24 @@ func (f *Filesystem) GetACopy() Filesystem {
 			},
 			Password: f.HTTPConfig.Password.Clone(),
 			APIKey:   f.HTTPConfig.APIKey.Clone(),
+			OAuth2: HTTPFsOAuth2Config{
+				TokenURL:     f.HTTPConfig.OAuth2.TokenURL,
+				ClientID:     f.HTTPConfig.OAuth2.ClientID,
+				ClientSecret: f.HTTPConfig.OAuth2.ClientSecret.Clone(),
+			},
 		},
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
 		copy(fs.SFTPConfig.Fingerprints, f.SFTPConfig.Fingerprints)
 	}
+	if len(f.HTTPConfig.OAuth2.Scopes) > 0 {
+		fs.HTTPConfig.OAuth2.Scopes = make([]string, len(f.HTTPConfig.OAuth2.Scopes))
+		copy(fs.HTTPConfig.OAuth2.Scopes, f.HTTPConfig.OAuth2.Scopes)
+	}
+	if len(f.SFTPConfig.JumpHostFingerprints) > 0 {
+		fs.SFTPConfig.JumpHostFingerprints = make([]string, len(f.SFTPConfig.JumpHostFingerprints))
+		copy(fs.SFTPConfig.JumpHostFingerprints, f.SFTPConfig.JumpHostFingerprints)
+	}
 	return fs
 }
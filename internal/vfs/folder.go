@@ -189,6 +189,11 @@ type VirtualFolder struct {
 	QuotaSize int64 `json:"quota_size"`
 	// Maximum number of files allowed. 0 means unlimited, -1 included in user quota
 	QuotaFiles int `json:"quota_files"`
+	// Maximum size allowed as bytes for each user inside this folder. 0 means unlimited.
+	// This limit is enforced in addition to the quota limits above
+	UserQuotaSize int64 `json:"user_quota_size,omitempty"`
+	// Maximum number of files allowed for each user inside this folder. 0 means unlimited
+	UserQuotaFiles int `json:"user_quota_files,omitempty"`
 }
 
 // GetFilesystem returns the filesystem for this folder
@@ -203,11 +208,16 @@ func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []
 	case sdk.CryptedFilesystemProvider:
 		return NewCryptFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.CryptConfig)
 	case sdk.SFTPFilesystemProvider:
-		return NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
+		fs, err := NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
+		if err != nil {
+			return nil, err
+		}
+		return NewCompressedFs(fs, v.VirtualPath, v.MappedPath, v.FsConfig.SFTPConfig.Compression), nil
 	case sdk.HTTPFilesystemProvider:
 		return NewHTTPFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.HTTPConfig)
 	default:
-		return NewOsFs(connectionID, v.MappedPath, v.VirtualPath), nil
+		return NewCompressedFs(newOsFsWithConfig(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.OSConfig),
+			v.VirtualPath, v.MappedPath, v.FsConfig.OSConfig.Compression), nil
 	}
 }
 
@@ -250,6 +260,12 @@ func (v *VirtualFolder) HasNoQuotaRestrictions(checkFiles bool) bool {
 	return false
 }
 
+// HasUserQuotaRestrictions returns true if per-user quota limits are defined
+// for this virtual folder
+func (v *VirtualFolder) HasUserQuotaRestrictions(checkFiles bool) bool {
+	return v.UserQuotaSize > 0 || (checkFiles && v.UserQuotaFiles > 0)
+}
+
 // GetACopy returns a copy
 func (v *VirtualFolder) GetACopy() VirtualFolder {
 	return VirtualFolder{
@@ -257,5 +273,7 @@ func (v *VirtualFolder) GetACopy() VirtualFolder {
 		VirtualPath:       v.VirtualPath,
 		QuotaSize:         v.QuotaSize,
 		QuotaFiles:        v.QuotaFiles,
+		UserQuotaSize:     v.UserQuotaSize,
+		UserQuotaFiles:    v.UserQuotaFiles,
 	}
 }
@@ -19,6 +19,10 @@ package vfs
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -26,6 +30,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,9 +38,11 @@ import (
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 	"google.golang.org/api/googleapi"
+	iam "google.golang.org/api/iam/v1"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -61,6 +68,7 @@ type GCSFs struct {
 	svc            *storage.Client
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	listingCache   *listingCache
 }
 
 func init() {
@@ -85,6 +93,7 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 		config:         &config,
 		ctxTimeout:     30 * time.Second,
 		ctxLongTimeout: 300 * time.Second,
+		listingCache:   newListingCache(),
 	}
 	if err = fs.config.validate(); err != nil {
 		return fs, err
@@ -112,24 +121,55 @@ func (fs *GCSFs) ConnectionID() string {
 	return fs.connectionID
 }
 
-// Stat returns a FileInfo describing the named file
+// Stat returns a FileInfo describing the named file.
+// If symlinks emulation is enabled, links are followed
 func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Lstat(name)
+	if err != nil || !fs.config.EmulateSymlinks {
+		return info, err
+	}
+	return statSymlink(fs, fs.config.KeyPrefix, name, info)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
-	return fs.getObjectStat(name)
-}
-
-// Lstat returns a FileInfo describing the named file
-func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
+	if info, ok := fs.listingCache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		fs.listingCache.setStat(name, info)
+	}
+	return info, err
 }
 
 // Open opens the named file for reading
 func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
+	var size int64
+	var version string
+	cacheKey := getReadCacheKey(fs.getStorageID(), name)
+	if isReadCacheEnabled() {
+		if attrs, err := fs.headObject(name); err == nil && attrs.ContentEncoding != "gzip" {
+			size = attrs.Size
+			version = strconv.FormatInt(attrs.Generation, 10)
+			if r, cancelFn, ok := openFromReadCache(fs, fs.localTempDir, cacheKey, offset, size, version); ok {
+				return nil, r, cancelFn, nil
+			}
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -148,11 +188,22 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 		cancelFn()
 		return nil, nil, nil, err
 	}
+	var writer io.Writer = w
+	var cacheWriter *readCacheWriter
+	if offset == 0 && version != "" && strconv.FormatInt(objectReader.Attrs.Generation, 10) == version {
+		if cw, ok := newReadCacheWriter(w, cacheKey, size, version); ok {
+			writer = cw
+			cacheWriter = cw
+		}
+	}
 	go func() {
 		defer cancelFn()
 		defer objectReader.Close()
 
-		n, err := io.Copy(w, objectReader)
+		n, err := io.Copy(writer, objectReader)
+		if cacheWriter != nil {
+			cacheWriter.Done(err)
+		}
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
 		metric.GCSTransferCompleted(n, 1, err)
@@ -160,16 +211,51 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 	return nil, r, cancelFn, nil
 }
 
-// Create creates or opens the named file for writing
+// Create creates or opens the named file for writing.
+// If upload staging is enabled, a local staged file is returned.
+// If the os.O_APPEND flag is set and the file exists, the uploaded data are
+// appended to the existing object using the compose API
 func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if flag != -1 && flag&os.O_APPEND != 0 {
+		return fs.streamUpload(name, flag)
+	}
+	if flag != -1 && isUploadStagingEnabled() {
+		return createStagedFile(name, fs.localTempDir, flag, fs.streamUpload)
+	}
+	return fs.streamUpload(name, flag)
+}
+
+func (fs *GCSFs) streamUpload(name string, flag int) (File, *PipeWriter, func(), error) {
+	var appendAttrs *storage.ObjectAttrs
+	if flag != -1 && flag&os.O_APPEND != 0 {
+		attrs, err := fs.headObject(name)
+		if err == nil {
+			if isSymlinkContentType(attrs.ContentType) {
+				return nil, nil, nil, ErrVfsUnsupported
+			}
+			appendAttrs = attrs
+		} else if !fs.IsNotExist(err) {
+			return nil, nil, nil, err
+		}
+	}
+	fs.listingCache.invalidate()
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	p := NewPipeWriter(w)
+	var p *PipeWriter
+	objectName := name
+	if appendAttrs != nil {
+		// the new data are uploaded to a temporary object and then composed
+		// with the existing one
+		objectName = getAppendObjectName(name)
+		p = NewPipeWriterAtOffset(w, appendAttrs.Size)
+	} else {
+		p = NewPipeWriter(w)
+	}
 	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
-	if flag == -1 {
+	obj := bkt.Object(objectName)
+	if flag == -1 || appendAttrs != nil {
 		obj = obj.If(storage.Conditions{DoesNotExist: true})
 	} else {
 		attrs, statErr := fs.headObject(name)
@@ -199,8 +285,15 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 	if contentType != "" {
 		objectWriter.ObjectAttrs.ContentType = contentType
 	}
-	if fs.config.StorageClass != "" {
-		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	storageClass := fs.config.StorageClass
+	if appendAttrs != nil {
+		// avoid early deletion charges for the temporary object
+		storageClass = ""
+	} else if flag != -1 {
+		storageClass = fs.getStorageClass(name, -1)
+	}
+	if storageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = storageClass
 	}
 	if fs.config.ACL != "" {
 		objectWriter.PredefinedACL = fs.config.ACL
@@ -213,10 +306,19 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 		if err == nil {
 			err = closeErr
 		}
+		if appendAttrs != nil {
+			if err == nil {
+				err = fs.composeAppend(name, objectName, appendAttrs)
+			}
+			fs.removeAppendObject(objectName)
+		} else if err == nil && flag != -1 {
+			fs.updateStorageClass(name, storageClass, n)
+		}
+		fs.listingCache.invalidate()
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
-			name, fs.config.ACL, n, err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, append: %t, err: %+v",
+			name, fs.config.ACL, n, appendAttrs != nil, err)
 		metric.GCSTransferCompleted(n, 0, err)
 	}()
 	return nil, p, cancelFn, nil
@@ -227,6 +329,9 @@ func (fs *GCSFs) Rename(source, target string) (int, int64, error) {
 	if source == target {
 		return -1, -1, nil
 	}
+	fs.listingCache.invalidate()
+	defer fs.listingCache.invalidate()
+
 	fi, err := fs.getObjectStat(source)
 	if err != nil {
 		return -1, -1, err
@@ -236,6 +341,8 @@ func (fs *GCSFs) Rename(source, target string) (int, int64, error) {
 
 // Remove removes the named file or (empty) directory.
 func (fs *GCSFs) Remove(name string, isDir bool) error {
+	defer fs.listingCache.invalidate()
+
 	if isDir {
 		hasContents, err := fs.hasContents(name)
 		if err != nil {
@@ -257,13 +364,10 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 			name, statErr)
 	}
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
-
-	err := obj.Delete(ctx)
+	err := fs.deleteObject(obj)
 	if isDir && fs.IsNotExist(err) {
 		// we can have directories without a trailing "/" (created using v2.1.0 and before)
-		err = fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")).Delete(ctx)
+		err = fs.deleteObject(fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")))
 	}
 	metric.GCSDeleteObjectCompleted(err)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
@@ -276,7 +380,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *GCSFs) Mkdir(name string) error {
-	_, err := fs.Stat(name)
+	_, err := fs.getObjectStat(name)
 	if !fs.IsNotExist(err) {
 		return err
 	}
@@ -284,13 +388,38 @@ func (fs *GCSFs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*GCSFs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are supported only if emulation is enabled
+func (fs *GCSFs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	linkTarget, err := validateSymlinkTarget(fs.config.KeyPrefix, source, target)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.getObjectStat(target); err == nil {
+		return fmt.Errorf("cannot create symlink, %q already exists", target)
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	defer fs.listingCache.invalidate()
+
+	return fs.writeSymlink(target, linkTarget)
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*GCSFs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *GCSFs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	target, ok, err := fs.readSymlink(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%q is not a symlink", name)
+	}
+	return fs.GetRelativePath(getSymlinkKey(fs.config.KeyPrefix, target)), nil
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -309,7 +438,7 @@ func (fs *GCSFs) Chtimes(name string, atime, mtime time.Time, isUploading bool)
 		return ErrVfsUnsupported
 	}
 	if !isUploading {
-		info, err := fs.Stat(name)
+		info, err := fs.getObjectStat(name)
 		if err != nil {
 			return err
 		}
@@ -317,6 +446,7 @@ func (fs *GCSFs) Chtimes(name string, atime, mtime time.Time, isUploading bool)
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.listingCache.invalidate()
 
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
@@ -332,6 +462,16 @@ func (*GCSFs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, dirname)
+		if err != nil {
+			return nil, err
+		}
+		dirname = resolved
+	}
+	if result, ok := fs.listingCache.getDir(dirname); ok {
+		return result, nil
+	}
 	var result []os.FileInfo
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
@@ -351,9 +491,7 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	it := bkt.Objects(ctx, query)
-	pager := iterator.NewPager(it, defaultGCSPageSize, "")
+	pager := fs.newPager(ctx, query, defaultGCSPageSize)
 
 	for {
 		var objects []*storage.ObjectAttrs
@@ -393,6 +531,10 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 				if t, ok := modTimes[name]; ok {
 					modTime = util.GetTimeFromMsecSinceEpoch(t)
 				}
+				if fs.config.EmulateSymlinks && !isDir && isSymlinkContentType(attrs.ContentType) {
+					result = append(result, newSymlinkFileInfo(name, attrs.Size, modTime))
+					continue
+				}
 				result = append(result, NewFileInfo(name, isDir, attrs.Size, modTime, false))
 			}
 		}
@@ -404,11 +546,13 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	metric.GCSListObjectsCompleted(nil)
+	fs.listingCache.setDir(dirname, result)
 	return result, nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on GCS
+// Resuming uploads is not supported on GCS, appending data to existing
+// objects is supported using the os.O_APPEND flag
 func (*GCSFs) IsUploadResumeSupported() bool {
 	return false
 }
@@ -437,6 +581,13 @@ func (*GCSFs) IsNotExist(err error) bool {
 	return false
 }
 
+func isGCSRetryableError(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return isRetryableStatusCode(e.Code)
+	}
+	return false
+}
+
 // IsPermission returns a boolean indicating whether the error is known to
 // report that permission is denied.
 func (*GCSFs) IsPermission(err error) bool {
@@ -490,9 +641,7 @@ func (fs *GCSFs) getFileNamesInPrefix(fsPrefix string) (map[string]bool, error)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	it := bkt.Objects(ctx, query)
-	pager := iterator.NewPager(it, defaultGCSPageSize, "")
+	pager := fs.newPager(ctx, query, defaultGCSPageSize)
 
 	for {
 		var objects []*storage.ObjectAttrs
@@ -548,9 +697,7 @@ func (fs *GCSFs) GetDirSize(dirname string) (int, int64, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	it := bkt.Objects(ctx, query)
-	pager := iterator.NewPager(it, defaultGCSPageSize, "")
+	pager := fs.newPager(ctx, query, defaultGCSPageSize)
 
 	for {
 		var objects []*storage.ObjectAttrs
@@ -628,9 +775,7 @@ func (fs *GCSFs) Walk(root string, walkFn filepath.WalkFunc) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	it := bkt.Objects(ctx, query)
-	pager := iterator.NewPager(it, defaultGCSPageSize, "")
+	pager := fs.newPager(ctx, query, defaultGCSPageSize)
 
 	for {
 		var objects []*storage.ObjectAttrs
@@ -684,12 +829,95 @@ func (fs *GCSFs) ResolvePath(virtualPath string) (string, error) {
 	if !path.IsAbs(virtualPath) {
 		virtualPath = path.Clean("/" + virtualPath)
 	}
-	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+	name := fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/"))
+	if fs.config.EmulateSymlinks {
+		return resolveSymlinkParents(fs, fs.config.KeyPrefix, name)
+	}
+	return name, nil
 }
 
 // CopyFile implements the FsFileCopier interface
 func (fs *GCSFs) CopyFile(source, target string, srcSize int64) error {
-	return fs.copyFileInternal(source, target)
+	defer fs.listingCache.invalidate()
+
+	return fs.copyFileInternal(source, target, srcSize)
+}
+
+// SetChecksums implements the FsChecksummer interface.
+// The MD5 and CRC32C checksums are verified against the ones reported by GCS
+// and the checksums are stored as object metadata
+func (fs *GCSFs) SetChecksums(name string, checksums map[string]string) error {
+	defer fs.listingCache.invalidate()
+
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if len(attrs.MD5) > 0 {
+		if err := verifyChecksum(name, ChecksumMD5, checksums, hex.EncodeToString(attrs.MD5)); err != nil {
+			return err
+		}
+	}
+	if err := verifyChecksum(name, ChecksumCRC32C, checksums, fmt.Sprintf("%08x", attrs.CRC32C)); err != nil {
+		return err
+	}
+	metadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	for algo, value := range checksums {
+		metadata[checksumMetadataPrefix+algo] = value
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	_, err = obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: metadata,
+	})
+	return err
+}
+
+// GetChecksums implements the FsChecksummer interface
+func (fs *GCSFs) GetChecksums(name string) (map[string]string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	result := getChecksumsFromMetadata(attrs.Metadata, checksumMetadataPrefix)
+	if len(attrs.MD5) > 0 {
+		result[ChecksumMD5] = hex.EncodeToString(attrs.MD5)
+	}
+	if attrs.ContentEncoding != "gzip" {
+		result[ChecksumCRC32C] = fmt.Sprintf("%08x", attrs.CRC32C)
+	}
+	return result, nil
+}
+
+// SetMetadata implements the FsMetadataSetter interface.
+// The metadata are added to the existing object metadata
+func (fs *GCSFs) SetMetadata(name string, metadata map[string]string) error {
+	defer fs.listingCache.invalidate()
+
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	objMetadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		objMetadata[k] = v
+	}
+	for k, v := range metadata {
+		objMetadata[checksumMetadataPrefix+k] = v
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	_, err = obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: objMetadata,
+	})
+	return err
 }
 
 func (fs *GCSFs) resolve(name, prefix, contentType string) (string, bool) {
@@ -711,6 +939,9 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
+		if fs.config.EmulateSymlinks && !isDir && isSymlinkContentType(attrs.ContentType) {
+			return newSymlinkFileInfo(name, objSize, objectModTime), nil
+		}
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, objSize, objectModTime, false))
 	}
 	if !fs.IsNotExist(err) {
@@ -732,7 +963,29 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 	return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, attrs.Size, attrs.Updated, false))
 }
 
-func (fs *GCSFs) copyFileInternal(source, target string) error {
+// getStorageClass returns the storage class to use for the specified object.
+// A negative size means unknown
+func (fs *GCSFs) getStorageClass(name string, size int64) string {
+	return getStorageClass(fs.config.StorageClassRules, fs.config.StorageClass,
+		"/"+strings.TrimPrefix(name, fs.config.KeyPrefix), size)
+}
+
+// updateStorageClass changes the storage class for the uploaded object, rewriting it
+// in place, if a storage class rule based on the file size matches
+func (fs *GCSFs) updateStorageClass(name, storageClass string, size int64) {
+	newStorageClass := fs.getStorageClass(name, size)
+	if newStorageClass == storageClass {
+		return
+	}
+	err := fs.copyFileInternal(name, name, size)
+	fsLog(fs, logger.LevelDebug, "storage class for %q changed from %q to %q, size: %d, err: %v",
+		name, storageClass, newStorageClass, size, err)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to change the storage class for %q: %v", name, err)
+	}
+}
+
+func (fs *GCSFs) copyFileInternal(source, target string, fileSize int64) error {
 	src := fs.svc.Bucket(fs.config.Bucket).Object(source)
 	dst := fs.svc.Bucket(fs.config.Bucket).Object(target)
 	attrs, statErr := fs.headObject(target)
@@ -745,12 +998,9 @@ func (fs *GCSFs) copyFileInternal(source, target string) error {
 			target, statErr)
 	}
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
-	defer cancelFn()
-
 	copier := dst.CopierFrom(src)
-	if fs.config.StorageClass != "" {
-		copier.StorageClass = fs.config.StorageClass
+	if storageClass := fs.getStorageClass(target, fileSize); storageClass != "" {
+		copier.StorageClass = storageClass
 	}
 	if fs.config.ACL != "" {
 		copier.PredefinedACL = fs.config.ACL
@@ -759,16 +1009,75 @@ func (fs *GCSFs) copyFileInternal(source, target string) error {
 	if contentType != "" {
 		copier.ContentType = contentType
 	}
-	_, err := copier.Run(ctx)
+	err := fs.config.retry(context.Background(), fs, "copy object", isGCSRetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+		defer cancelFn()
+
+		_, err := copier.Run(ctx)
+		return err
+	})
 	metric.GCSCopyObjectCompleted(err)
 	return err
 }
 
+// composeAppend appends the temporary object to the existing one. The
+// existing object must not be modified while the upload is in progress
+func (fs *GCSFs) composeAppend(name, tempName string, attrs *storage.ObjectAttrs) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	bkt := fs.svc.Bucket(fs.config.Bucket)
+	dst := bkt.Object(name).If(storage.Conditions{GenerationMatch: attrs.Generation})
+	composer := dst.ComposerFrom(bkt.Object(name).Generation(attrs.Generation), bkt.Object(tempName))
+	composer.ContentType = attrs.ContentType
+	composer.StorageClass = attrs.StorageClass
+	composer.CacheControl = attrs.CacheControl
+	composer.ContentDisposition = attrs.ContentDisposition
+	// the stored checksums are no longer valid
+	metadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		if !strings.HasPrefix(k, checksumMetadataPrefix) {
+			metadata[k] = v
+		}
+	}
+	if len(metadata) > 0 {
+		composer.Metadata = metadata
+	}
+	if fs.config.ACL != "" {
+		composer.PredefinedACL = fs.config.ACL
+	}
+	_, err := composer.Run(ctx)
+	metric.GCSCopyObjectCompleted(err)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to append %q to %q: %v", tempName, name, err)
+	}
+	return err
+}
+
+func (fs *GCSFs) removeAppendObject(name string) {
+	err := fs.deleteObject(fs.svc.Bucket(fs.config.Bucket).Object(name))
+	metric.GCSDeleteObjectCompleted(err)
+	if err != nil && !fs.IsNotExist(err) {
+		fsLog(fs, logger.LevelWarn, "unable to remove temporary append object %q: %v", name, err)
+	}
+}
+
 func (fs *GCSFs) renameInternal(source, target string, fi os.FileInfo) (int, int64, error) {
 	var numFiles int
 	var filesSize int64
 
-	if fi.IsDir() {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		linkTarget, ok, err := fs.readSymlink(source)
+		if err != nil {
+			return numFiles, filesSize, err
+		}
+		if !ok {
+			return numFiles, filesSize, fmt.Errorf("%q is not a symlink", source)
+		}
+		if err := fs.writeSymlink(target, linkTarget); err != nil {
+			return numFiles, filesSize, err
+		}
+	} else if fi.IsDir() {
 		if renameMode == 0 {
 			hasContents, err := fs.hasContents(source)
 			if err != nil {
@@ -798,7 +1107,7 @@ func (fs *GCSFs) renameInternal(source, target string, fi os.FileInfo) (int, int
 			}
 		}
 	} else {
-		if err := fs.copyFileInternal(source, target); err != nil {
+		if err := fs.copyFileInternal(source, target, fi.Size()); err != nil {
 			return numFiles, filesSize, err
 		}
 		numFiles++
@@ -841,10 +1150,8 @@ func (fs *GCSFs) hasContents(name string) (bool, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	it := bkt.Objects(ctx, query)
 	// if we have a dir object with a trailing slash it will be returned so we set the size to 2
-	pager := iterator.NewPager(it, 2, "")
+	pager := fs.newPager(ctx, query, 2)
 
 	var objects []*storage.ObjectAttrs
 	_, err = pager.NextPage(&objects)
@@ -879,16 +1186,122 @@ func (fs *GCSFs) getPrefix(name string) string {
 }
 
 func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	var attrs *storage.ObjectAttrs
+	err := fs.config.retry(context.Background(), fs, "head object", isGCSRetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
-	attrs, err := obj.Attrs(ctx)
+		var err error
+		attrs, err = obj.Attrs(ctx)
+		return err
+	})
 	metric.GCSHeadObjectCompleted(err)
 	return attrs, err
 }
 
+func (fs *GCSFs) deleteObject(obj *storage.ObjectHandle) error {
+	return fs.config.retry(context.Background(), fs, "delete object", isGCSRetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		return obj.Delete(ctx)
+	})
+}
+
+func (fs *GCSFs) newPager(ctx context.Context, query *storage.Query, pageSize int) *gcsPager {
+	return &gcsPager{
+		fs:       fs,
+		ctx:      ctx,
+		query:    query,
+		pageSize: pageSize,
+	}
+}
+
+// gcsPager returns the listing pages. An iterator cannot be used anymore after
+// an error, so transient errors are retried using a new iterator that resumes
+// the listing from the last page token
+type gcsPager struct {
+	fs        *GCSFs
+	ctx       context.Context
+	query     *storage.Query
+	pageSize  int
+	pageToken string
+	pager     *iterator.Pager
+}
+
+func (p *gcsPager) NextPage(objects *[]*storage.ObjectAttrs) (string, error) {
+	err := p.fs.config.retry(p.ctx, p.fs, "list objects", isGCSRetryableError, func() error {
+		if p.pager == nil {
+			it := p.fs.svc.Bucket(p.fs.config.Bucket).Objects(p.ctx, p.query)
+			p.pager = iterator.NewPager(it, p.pageSize, p.pageToken)
+		}
+		pageToken, err := p.pager.NextPage(objects)
+		if err != nil {
+			*objects = nil
+			p.pager = nil
+			return err
+		}
+		p.pageToken = pageToken
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.pageToken, nil
+}
+
+func (fs *GCSFs) readSymlink(name string) (string, bool, error) {
+	if info, ok := fs.listingCache.getStat(name); ok && info.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if !isSymlinkContentType(attrs.ContentType) || attrs.Size > maxSymlinkTargetSize {
+		return "", false, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objectReader, err := fs.svc.Bucket(fs.config.Bucket).Object(name).NewReader(ctx)
+	if err != nil {
+		metric.GCSTransferCompleted(0, 1, err)
+		return "", false, err
+	}
+	defer objectReader.Close()
+
+	target, err := readSymlinkTarget(objectReader)
+	metric.GCSTransferCompleted(int64(len(target)), 1, err)
+	return target, err == nil, err
+}
+
+func (fs *GCSFs) writeSymlink(name, linkTarget string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objectWriter := fs.svc.Bucket(fs.config.Bucket).Object(name).NewWriter(ctx)
+	objectWriter.ObjectAttrs.ContentType = symlinkContentType
+	if fs.config.StorageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		objectWriter.PredefinedACL = fs.config.ACL
+	}
+	_, err := io.WriteString(objectWriter, linkTarget)
+	closeErr := objectWriter.Close()
+	if err == nil {
+		err = closeErr
+	}
+	metric.GCSTransferCompleted(int64(len(linkTarget)), 0, err)
+	fsLog(fs, logger.LevelDebug, "symlink %q -> %q written, err: %v", name, linkTarget, err)
+	return err
+}
+
 // GetMimeType returns the content type
 func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	attrs, err := fs.headObject(name)
@@ -911,3 +1324,52 @@ func (*GCSFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
 func (fs *GCSFs) getStorageID() string {
 	return fmt.Sprintf("gs://%v", fs.config.Bucket)
 }
+
+// getAppendObjectName returns the name for the temporary object used to
+// append data to the specified object
+func getAppendObjectName(name string) string {
+	return path.Join(path.Dir(name), fmt.Sprintf(".sftpgo-append-%s-%s", util.GenerateUniqueID(), path.Base(name)))
+}
+
+type gcsServiceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+}
+
+// rotateGCSCredentials creates a new key for the service account associated
+// with the configured credentials. The returned function deletes the previous
+// key, it must be called after saving the new one
+func rotateGCSCredentials(ctx context.Context, config *GCSFsConfig) (func(context.Context) error, error) {
+	if config.AutomaticCredentials != 0 {
+		return nil, errCredentialsRotationNotSupported
+	}
+	credentials := config.Credentials.Clone()
+	if err := credentials.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt credentials: %w", err)
+	}
+	var account gcsServiceAccountKey
+	if err := json.Unmarshal([]byte(credentials.GetPayload()), &account); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKeyID == "" {
+		return nil, errors.New("the credentials are not a service account key")
+	}
+	svc, err := iam.NewService(ctx, option.WithCredentialsJSON([]byte(credentials.GetPayload())))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create IAM client: %w", err)
+	}
+	resource := "projects/-/serviceAccounts/" + account.ClientEmail
+	key, err := svc.Projects.ServiceAccounts.Keys.Create(resource, &iam.CreateServiceAccountKeyRequest{}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create service account key: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(key.PrivateKeyData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the new service account key: %w", err)
+	}
+	config.Credentials = kms.NewPlainSecret(string(data))
+	return func(ctx context.Context) error {
+		_, err := svc.Projects.ServiceAccounts.Keys.Delete(resource + "/keys/" + account.PrivateKeyID).Context(ctx).Do()
+		return err
+	}, nil
+}
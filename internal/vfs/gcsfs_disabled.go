@@ -18,6 +18,7 @@
 package vfs
 
 import (
+	"context"
 	"errors"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -31,3 +32,7 @@ func init() {
 func NewGCSFs(_, _, _ string, _ GCSFsConfig) (Fs, error) {
 	return nil, errors.New("Google Cloud Storage disabled at build time")
 }
+
+func rotateGCSCredentials(_ context.Context, _ *GCSFsConfig) (func(context.Context) error, error) {
+	return nil, errCredentialsRotationNotSupported
+}
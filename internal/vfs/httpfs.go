@@ -15,11 +15,13 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/fs"
 	"mime"
@@ -29,12 +31,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 	"github.com/sftpgo/sdk"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
@@ -49,13 +55,93 @@ const (
 
 var (
 	supportedEndpointSchema = []string{"http://", "https://"}
+	httpFsTokenSources      = newHTTPFsTokenSourcesCache()
 )
 
+// HTTPFsOAuth2Config defines the OAuth2 client credentials configuration for HTTPFs
+type HTTPFsOAuth2Config struct {
+	// OAuth2 token endpoint. Leave empty to disable OAuth2
+	TokenURL     string      `json:"token_url,omitempty"`
+	ClientID     string      `json:"client_id,omitempty"`
+	ClientSecret *kms.Secret `json:"client_secret,omitempty"`
+	Scopes       []string    `json:"scopes,omitempty"`
+}
+
+// IsEnabled returns true if OAuth2 authentication is configured
+func (c *HTTPFsOAuth2Config) IsEnabled() bool {
+	return c.TokenURL != ""
+}
+
+func (c *HTTPFsOAuth2Config) isEqual(other HTTPFsOAuth2Config) bool {
+	if c.TokenURL != other.TokenURL {
+		return false
+	}
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if len(c.Scopes) != len(other.Scopes) {
+		return false
+	}
+	for _, scope := range c.Scopes {
+		if !util.Contains(other.Scopes, scope) {
+			return false
+		}
+	}
+	return c.ClientSecret.IsEqual(other.ClientSecret)
+}
+
+func (c *HTTPFsOAuth2Config) validate() error {
+	c.TokenURL = strings.TrimSpace(c.TokenURL)
+	if !c.IsEnabled() {
+		c.ClientID = ""
+		c.ClientSecret = kms.NewEmptySecret()
+		c.Scopes = nil
+		return nil
+	}
+	if _, err := url.Parse(c.TokenURL); err != nil {
+		return fmt.Errorf("httpfs: invalid OAuth2 token URL: %w", err)
+	}
+	if !util.IsStringPrefixInSlice(c.TokenURL, supportedEndpointSchema) {
+		return errors.New("httpfs: invalid OAuth2 token URL schema: http and https are supported")
+	}
+	c.ClientID = strings.TrimSpace(c.ClientID)
+	if c.ClientID == "" {
+		return errors.New("httpfs: OAuth2 client id cannot be empty")
+	}
+	if c.ClientSecret.IsEmpty() {
+		return errors.New("httpfs: OAuth2 client secret cannot be empty")
+	}
+	if c.ClientSecret.IsEncrypted() && !c.ClientSecret.IsValid() {
+		return errors.New("httpfs: invalid encrypted OAuth2 client secret")
+	}
+	if !c.ClientSecret.IsValidInput() {
+		return errors.New("httpfs: invalid OAuth2 client secret")
+	}
+	c.Scopes = util.RemoveDuplicates(c.Scopes, true)
+	return nil
+}
+
+// getCacheKey returns a key that identifies the token source for this configuration
+func (c *HTTPFsOAuth2Config) getCacheKey(skipTLSVerify bool) string {
+	h := fnv.New64a()
+	var b bytes.Buffer
+
+	b.WriteString(c.TokenURL)
+	b.WriteString(c.ClientID)
+	b.WriteString(c.ClientSecret.GetPayload())
+	b.WriteString(strings.Join(c.Scopes, " "))
+	b.WriteString(strconv.FormatBool(skipTLSVerify))
+
+	h.Write(b.Bytes())
+	return strconv.FormatUint(h.Sum64(), 10)
+}
+
 // HTTPFsConfig defines the configuration for HTTP based filesystem
 type HTTPFsConfig struct {
 	sdk.BaseHTTPFsConfig
-	Password *kms.Secret `json:"password,omitempty"`
-	APIKey   *kms.Secret `json:"api_key,omitempty"`
+	Password *kms.Secret        `json:"password,omitempty"`
+	APIKey   *kms.Secret        `json:"api_key,omitempty"`
+	OAuth2   HTTPFsOAuth2Config `json:"oauth2,omitempty"`
 }
 
 func (c *HTTPFsConfig) isUnixDomainSocket() bool {
@@ -70,6 +156,9 @@ func (c *HTTPFsConfig) HideConfidentialData() {
 	if c.APIKey != nil {
 		c.APIKey.Hide()
 	}
+	if c.OAuth2.ClientSecret != nil {
+		c.OAuth2.ClientSecret.Hide()
+	}
 }
 
 func (c *HTTPFsConfig) setNilSecretsIfEmpty() {
@@ -79,6 +168,9 @@ func (c *HTTPFsConfig) setNilSecretsIfEmpty() {
 	if c.APIKey != nil && c.APIKey.IsEmpty() {
 		c.APIKey = nil
 	}
+	if c.OAuth2.ClientSecret != nil && c.OAuth2.ClientSecret.IsEmpty() {
+		c.OAuth2.ClientSecret = nil
+	}
 }
 
 func (c *HTTPFsConfig) setEmptyCredentialsIfNil() {
@@ -88,6 +180,9 @@ func (c *HTTPFsConfig) setEmptyCredentialsIfNil() {
 	if c.APIKey == nil {
 		c.APIKey = kms.NewEmptySecret()
 	}
+	if c.OAuth2.ClientSecret == nil {
+		c.OAuth2.ClientSecret = kms.NewEmptySecret()
+	}
 }
 
 func (c *HTTPFsConfig) isEqual(other HTTPFsConfig) bool {
@@ -105,7 +200,10 @@ func (c *HTTPFsConfig) isEqual(other HTTPFsConfig) bool {
 	if !c.Password.IsEqual(other.Password) {
 		return false
 	}
-	return c.APIKey.IsEqual(other.APIKey)
+	if !c.APIKey.IsEqual(other.APIKey) {
+		return false
+	}
+	return c.OAuth2.isEqual(other.OAuth2)
 }
 
 func (c *HTTPFsConfig) isSameResource(other HTTPFsConfig) bool {
@@ -152,6 +250,12 @@ func (c *HTTPFsConfig) validate() error {
 	if !c.APIKey.IsEmpty() && !c.APIKey.IsValidInput() {
 		return errors.New("httpfs: invalid API key")
 	}
+	if err := c.OAuth2.validate(); err != nil {
+		return err
+	}
+	if c.OAuth2.IsEnabled() && (c.Username != "" || !c.Password.IsEmpty()) {
+		return errors.New("httpfs: OAuth2 and basic authentication are mutually exclusive")
+	}
 	return nil
 }
 
@@ -172,6 +276,12 @@ func (c *HTTPFsConfig) ValidateAndEncryptCredentials(additionalData string) erro
 			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP fs API key: %v", err))
 		}
 	}
+	if c.OAuth2.ClientSecret.IsPlain() {
+		c.OAuth2.ClientSecret.SetAdditionalData(additionalData)
+		if err := c.OAuth2.ClientSecret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP fs OAuth2 client secret: %v", err))
+		}
+	}
 	return nil
 }
 
@@ -206,6 +316,11 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 			return nil, err
 		}
 	}
+	if !config.OAuth2.ClientSecret.IsEmpty() {
+		if err := config.OAuth2.ClientSecret.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
 	fs := &HTTPFs{
 		connectionID: connectionID,
 		localTempDir: localTempDir,
@@ -259,6 +374,12 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 	fs.client = &http.Client{
 		Transport: transport,
 	}
+	if config.OAuth2.IsEnabled() {
+		fs.client.Transport = &oauth2.Transport{
+			Source: httpFsTokenSources.get(&config.OAuth2, config.SkipTLSVerify),
+			Base:   transport,
+		}
+	}
 	return fs, nil
 }
 
@@ -671,7 +792,7 @@ func (fs *HTTPFs) sendHTTPRequest(ctx context.Context, method, base, name, query
 	if fs.config.APIKey.GetPayload() != "" {
 		req.Header.Set("X-API-KEY", fs.config.APIKey.GetPayload())
 	}
-	if fs.config.Username != "" || fs.config.Password.GetPayload() != "" {
+	if !fs.config.OAuth2.IsEnabled() && (fs.config.Username != "" || fs.config.Password.GetPayload() != "") {
 		req.SetBasicAuth(fs.config.Username, fs.config.Password.GetPayload())
 	}
 	resp, err := fs.client.Do(req.WithContext(ctx))
@@ -786,3 +907,47 @@ func (s *statVFSResponse) toSFTPStatVFS() *sftp.StatVFS {
 		Namemax: s.Namemax,
 	}
 }
+
+// httpFsTokenSourcesCache caches the OAuth2 token sources so the tokens are
+// shared among the HTTPFs instances with the same OAuth2 configuration and
+// are refreshed only when expired
+type httpFsTokenSourcesCache struct {
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+func newHTTPFsTokenSourcesCache() *httpFsTokenSourcesCache {
+	return &httpFsTokenSourcesCache{
+		sources: make(map[string]oauth2.TokenSource),
+	}
+}
+
+func (c *httpFsTokenSourcesCache) get(config *HTTPFsOAuth2Config, skipTLSVerify bool) oauth2.TokenSource {
+	key := config.getCacheKey(skipTLSVerify)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ts, ok := c.sources[key]; ok {
+		return ts
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if skipTLSVerify {
+		transport.TLSClientConfig = getInsecureTLSConfig()
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	})
+	ccConfig := &clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret.GetPayload(),
+		TokenURL:     config.TokenURL,
+		Scopes:       config.Scopes,
+	}
+	ts := ccConfig.TokenSource(ctx)
+	c.sources[key] = ts
+	logger.Debug(httpFsName, "", "added OAuth2 token source for token URL %q, client id %q, cached sources: %d",
+		config.TokenURL, config.ClientID, len(c.sources))
+	return ts
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	defaultListingCacheMaxEntries = 100
+)
+
+var (
+	listingCacheConfig ListingCacheConfig
+)
+
+// ListingCacheConfig defines the configuration for the directory listing and
+// stat cache used for Cloud Storage backends (S3, GCS, Azure Blob).
+// The cache is per connection: modifications made through the same connection
+// invalidate it, modifications made from other connections or outside SFTPGo
+// are visible after the configured TTL
+type ListingCacheConfig struct {
+	// Time to live, in seconds, for the cached entries. 0 means disabled
+	TTL int `json:"ttl" mapstructure:"ttl"`
+	// Maximum number of cached directory listings and stat results for each
+	// connection. 0 means the default (100)
+	MaxEntries int `json:"max_entries" mapstructure:"max_entries"`
+}
+
+func (c *ListingCacheConfig) isEnabled() bool {
+	return c.TTL > 0
+}
+
+func (c *ListingCacheConfig) getMaxEntries() int {
+	if c.MaxEntries <= 0 {
+		return defaultListingCacheMaxEntries
+	}
+	return c.MaxEntries
+}
+
+// SetListingCacheConfig sets the configuration for the directory listing cache
+func SetListingCacheConfig(config ListingCacheConfig) {
+	listingCacheConfig = config
+}
+
+type listingCacheEntry struct {
+	files     []os.FileInfo
+	info      os.FileInfo
+	expiresAt time.Time
+}
+
+// listingCache caches directory listings and stat results for a single
+// Fs instance. A nil listingCache is valid and caches nothing
+type listingCache struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.RWMutex
+	dirs       map[string]listingCacheEntry
+	stats      map[string]listingCacheEntry
+}
+
+func newListingCache() *listingCache {
+	if !listingCacheConfig.isEnabled() {
+		return nil
+	}
+	return &listingCache{
+		ttl:        time.Duration(listingCacheConfig.TTL) * time.Second,
+		maxEntries: listingCacheConfig.getMaxEntries(),
+		dirs:       make(map[string]listingCacheEntry),
+		stats:      make(map[string]listingCacheEntry),
+	}
+}
+
+func (c *listingCache) getDir(name string) ([]os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.dirs[name]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	// the caller could modify the returned slice
+	result := make([]os.FileInfo, len(entry.files))
+	copy(result, entry.files)
+	return result, true
+}
+
+func (c *listingCache) setDir(name string, files []os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeExpiredNoLock(c.dirs)
+	if len(c.dirs) >= c.maxEntries {
+		return
+	}
+	entry := listingCacheEntry{
+		files:     make([]os.FileInfo, len(files)),
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	copy(entry.files, files)
+	c.dirs[name] = entry
+}
+
+// getStat returns the cached stat result for the specified name. Files inside
+// a cached directory listing are returned too, directories are not returned from
+// listings since some providers return different metadata when listing them
+func (c *listingCache) getStat(name string) (os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	if entry, ok := c.stats[name]; ok && now.Before(entry.expiresAt) {
+		return entry.info, true
+	}
+	if entry, ok := c.dirs[path.Dir(name)]; ok && now.Before(entry.expiresAt) {
+		baseName := path.Base(name)
+		for _, info := range entry.files {
+			if info.Name() == baseName && !info.IsDir() {
+				return info, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (c *listingCache) setStat(name string, info os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeExpiredNoLock(c.stats)
+	if len(c.stats) >= c.maxEntries {
+		return
+	}
+	c.stats[name] = listingCacheEntry{
+		info:      info,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate removes all the cached entries, it must be called
+// after each modification made using the associated Fs
+func (c *listingCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirs = make(map[string]listingCacheEntry)
+	c.stats = make(map[string]listingCacheEntry)
+}
+
+func (c *listingCache) removeExpiredNoLock(entries map[string]listingCacheEntry) {
+	if len(entries) < c.maxEntries {
+		return
+	}
+	now := time.Now()
+	for k, v := range entries {
+		if now.After(v.expiresAt) {
+			delete(entries, k)
+		}
+	}
+}
@@ -55,6 +55,12 @@ type OsFs struct {
 	rootDir      string
 	// if not empty this fs is mouted as virtual folder in the specified path
 	mountPath string
+	// ownership and umask for new files and directories, they override
+	// the user settings and are only set for virtual folders
+	uid      int
+	gid      int
+	umask    os.FileMode
+	hasUmask bool
 }
 
 // NewOsFs returns an OsFs object that allows to interact with local Os filesystem
@@ -67,6 +73,55 @@ func NewOsFs(connectionID, rootDir, mountPath string) Fs {
 	}
 }
 
+func newOsFsWithConfig(connectionID, rootDir, mountPath string, config OSFsConfig) Fs {
+	fs := &OsFs{
+		name:         osFsName,
+		connectionID: connectionID,
+		rootDir:      rootDir,
+		mountPath:    getMountPath(mountPath),
+		uid:          config.UID,
+		gid:          config.GID,
+	}
+	if umask, err := config.getUmask(); err == nil && config.Umask != "" {
+		fs.umask = umask
+		fs.hasUmask = true
+	}
+	return fs
+}
+
+// getOwnership returns the uid and gid to use for new files and directories
+func (fs *OsFs) getOwnership(uid, gid int) (int, int) {
+	if fs.uid > 0 {
+		uid = fs.uid
+	}
+	if fs.gid > 0 {
+		gid = fs.gid
+	}
+	return uid, gid
+}
+
+// applyUmask sets the permissions for a new file or directory based on the configured umask
+func (fs *OsFs) applyUmask(name string) {
+	if !fs.hasUmask {
+		return
+	}
+	info, err := os.Lstat(name)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to stat path %q to apply the umask: %v", name, err)
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return
+	}
+	mode := os.FileMode(0666)
+	if info.IsDir() {
+		mode = os.ModePerm
+	}
+	if err := os.Chmod(name, mode&^fs.umask); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to apply the umask to path %q: %v", name, err)
+	}
+}
+
 // Name returns the name for the Fs implementation
 func (fs *OsFs) Name() string {
 	return fs.name
@@ -190,6 +245,84 @@ func (*OsFs) Truncate(name string, size int64) error {
 	return os.Truncate(name, size)
 }
 
+// CopyFile implements the FsFileCopier interface.
+// The data is copied inside the kernel, on Linux the file is cloned if the
+// underlying filesystem supports reflinks (XFS, Btrfs, ZFS), otherwise
+// copy_file_range is used
+func (fs *OsFs) CopyFile(source, target string, srcSize int64) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = copyFileContents(dst, src, info.Size())
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to copy %q -> %q, size: %d: %v", source, target, srcSize, err)
+		return err
+	}
+	return nil
+}
+
+// SetChecksums implements the FsChecksummer interface.
+// The checksums are stored as extended attributes, any previously stored
+// checksum not included in the given ones is removed
+func (*OsFs) SetChecksums(name string, checksums map[string]string) error {
+	for _, algo := range SupportedChecksums {
+		value, ok := checksums[algo]
+		if !ok {
+			if err := removeXattr(name, checksumXattrPrefix+algo); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setXattr(name, checksumXattrPrefix+algo, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChecksums implements the FsChecksummer interface
+func (*OsFs) GetChecksums(name string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, algo := range SupportedChecksums {
+		value, err := getXattr(name, checksumXattrPrefix+algo)
+		if err != nil {
+			if errors.Is(err, ErrVfsUnsupported) || errors.Is(err, os.ErrNotExist) {
+				return result, err
+			}
+			continue
+		}
+		if value != "" {
+			result[algo] = value
+		}
+	}
+	return result, nil
+}
+
+// SetMetadata implements the FsMetadataSetter interface.
+// The metadata are stored as extended attributes
+func (*OsFs) SetMetadata(name string, metadata map[string]string) error {
+	for k, v := range metadata {
+		if err := setXattr(name, checksumXattrPrefix+k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (*OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
@@ -0,0 +1,60 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func setXattr(name, attr, value string) error {
+	err := unix.Setxattr(name, attr, []byte(value), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrVfsUnsupported
+	}
+	return err
+}
+
+// removeXattr removes the specified attribute, a missing attribute is not an error
+func removeXattr(name, attr string) error {
+	err := unix.Removexattr(name, attr)
+	if err == nil || errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrVfsUnsupported
+	}
+	return err
+}
+
+func getXattr(name, attr string) (string, error) {
+	buf := make([]byte, 128)
+	n, err := unix.Getxattr(name, attr, buf)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return "", ErrVfsUnsupported
+		}
+		if errors.Is(err, unix.ENOENT) {
+			return "", os.ErrNotExist
+		}
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
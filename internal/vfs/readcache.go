@@ -0,0 +1,326 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	readCacheLogSender = "readcache"
+	readCacheTempExt   = ".tmp"
+)
+
+var (
+	readCache atomic.Pointer[diskReadCache]
+)
+
+// ReadCacheConfig defines the configuration for the local disk cache used to
+// store the files downloaded from the Cloud Storage backends (S3, GCS, Azure Blob).
+// Repeated downloads of the same unchanged files are served from the local disk.
+// The least recently used files are evicted when the size limit is reached
+type ReadCacheConfig struct {
+	// Absolute path to the local directory for the cached files.
+	// Leave empty to disable the cache. Any existing file inside this
+	// directory will be removed at startup
+	Path string `json:"path" mapstructure:"path"`
+	// Maximum size of the cache in MB
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Files bigger than this size, in MB, are not cached. 0 means that only
+	// the cache size limits the size of the cached files
+	MaxFileSize int64 `json:"max_file_size" mapstructure:"max_file_size"`
+}
+
+// IsEnabled returns true if the read cache is enabled
+func (c *ReadCacheConfig) IsEnabled() bool {
+	return c.Path != "" && c.MaxSize > 0
+}
+
+func (c *ReadCacheConfig) validate() error {
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("the read cache path %q must be an absolute path", c.Path)
+	}
+	if c.MaxFileSize < 0 {
+		return errors.New("the read cache max file size cannot be negative")
+	}
+	return nil
+}
+
+// SetReadCacheConfig sets the configuration for the local disk read cache
+func SetReadCacheConfig(config ReadCacheConfig) error {
+	if !config.IsEnabled() {
+		readCache.Store(nil)
+		return nil
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	cache, err := newDiskReadCache(config)
+	if err != nil {
+		return err
+	}
+	readCache.Store(cache)
+	logger.Info(readCacheLogSender, "", "read cache initialized, path %q, max size: %d MB, max file size: %d MB",
+		config.Path, config.MaxSize, config.MaxFileSize)
+	return nil
+}
+
+type readCacheItem struct {
+	key     string
+	path    string
+	size    int64
+	version string
+}
+
+type diskReadCache struct {
+	dir         string
+	maxSize     int64
+	maxFileSize int64
+	mu          sync.Mutex
+	items       map[string]*list.Element
+	lru         *list.List
+	size        int64
+}
+
+func newDiskReadCache(config ReadCacheConfig) (*diskReadCache, error) {
+	if err := os.RemoveAll(config.Path); err != nil {
+		return nil, fmt.Errorf("unable to clean the read cache path %q: %w", config.Path, err)
+	}
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the read cache path %q: %w", config.Path, err)
+	}
+	maxFileSize := config.MaxFileSize * 1048576
+	if maxFileSize == 0 || maxFileSize > config.MaxSize*1048576 {
+		maxFileSize = config.MaxSize * 1048576
+	}
+	return &diskReadCache{
+		dir:         config.Path,
+		maxSize:     config.MaxSize * 1048576,
+		maxFileSize: maxFileSize,
+		items:       make(map[string]*list.Element),
+		lru:         list.New(),
+	}, nil
+}
+
+func (c *diskReadCache) getFilePath(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:]))
+}
+
+// open returns the cached file for the specified key if its size and
+// version match the given ones
+func (c *diskReadCache) open(key string, size int64, version string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*readCacheItem)
+	if item.size != size || item.version != version {
+		c.removeElementNoLock(elem)
+		return nil, false
+	}
+	f, err := os.Open(item.path)
+	if err != nil {
+		logger.Warn(readCacheLogSender, "", "unable to open cached file %q: %v", item.path, err)
+		c.removeElementNoLock(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return f, true
+}
+
+func (c *diskReadCache) isCacheable(size int64) bool {
+	return size > 0 && size <= c.maxFileSize
+}
+
+// add adds the file at tempPath to the cache, the file is moved inside the cache
+// directory and the least recently used files are evicted if needed
+func (c *diskReadCache) add(key, tempPath string, size int64, version string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElementNoLock(elem)
+	}
+	for c.size+size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			break
+		}
+		c.removeElementNoLock(elem)
+	}
+	item := &readCacheItem{
+		key:     key,
+		path:    c.getFilePath(key),
+		size:    size,
+		version: version,
+	}
+	if err := os.Rename(tempPath, item.path); err != nil {
+		return err
+	}
+	c.items[key] = c.lru.PushFront(item)
+	c.size += size
+	return nil
+}
+
+func (c *diskReadCache) removeElementNoLock(elem *list.Element) {
+	item := elem.Value.(*readCacheItem)
+	c.lru.Remove(elem)
+	delete(c.items, item.key)
+	c.size -= item.size
+	if err := os.Remove(item.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(readCacheLogSender, "", "unable to remove cached file %q: %v", item.path, err)
+	}
+}
+
+// readCacheWriter writes the downloaded data to the pipe and to
+// a temporary file that will be added to the cache on success
+type readCacheWriter struct {
+	pipe    *pipeat.PipeWriterAt
+	file    *os.File
+	cache   *diskReadCache
+	key     string
+	size    int64
+	version string
+	failed  atomic.Bool
+}
+
+func (w *readCacheWriter) Write(p []byte) (int, error) {
+	if !w.failed.Load() {
+		if _, err := w.file.Write(p); err != nil {
+			w.failed.Store(true)
+		}
+	}
+	return w.pipe.Write(p)
+}
+
+func (w *readCacheWriter) WriteAt(p []byte, off int64) (int, error) {
+	if !w.failed.Load() {
+		if _, err := w.file.WriteAt(p, off); err != nil {
+			w.failed.Store(true)
+		}
+	}
+	return w.pipe.WriteAt(p, off)
+}
+
+// Done closes the temporary file and adds it to the cache if the download
+// completed successfully
+func (w *readCacheWriter) Done(err error) {
+	tempPath := w.file.Name()
+	if errClose := w.file.Close(); errClose != nil {
+		w.failed.Store(true)
+	}
+	if err == nil && !w.failed.Load() {
+		info, errStat := os.Stat(tempPath)
+		if errStat == nil && info.Size() == w.size {
+			if errAdd := w.cache.add(w.key, tempPath, w.size, w.version); errAdd == nil {
+				return
+			}
+		}
+	}
+	if errRemove := os.Remove(tempPath); errRemove != nil {
+		logger.Warn(readCacheLogSender, "", "unable to remove temporary file %q: %v", tempPath, errRemove)
+	}
+}
+
+// newReadCacheWriter returns a writer that also saves the downloaded data inside the
+// read cache, if enabled. The second return value is false if the file cannot be cached
+func newReadCacheWriter(pipe *pipeat.PipeWriterAt, key string, size int64, version string) (*readCacheWriter, bool) {
+	cache := readCache.Load()
+	if cache == nil || !cache.isCacheable(size) || version == "" {
+		return nil, false
+	}
+	f, err := os.CreateTemp(cache.dir, "*"+readCacheTempExt)
+	if err != nil {
+		logger.Warn(readCacheLogSender, "", "unable to create temporary file: %v", err)
+		return nil, false
+	}
+	return &readCacheWriter{
+		pipe:    pipe,
+		file:    f,
+		cache:   cache,
+		key:     key,
+		size:    size,
+		version: version,
+	}, true
+}
+
+// openFromReadCache returns a reader for the cached file with the specified key, size and version,
+// if any. The cached data are served starting from the specified offset
+func openFromReadCache(fs Fs, localTempDir, key string, offset, size int64, version string,
+) (*pipeat.PipeReaderAt, func(), bool) {
+	cache := readCache.Load()
+	if cache == nil || version == "" || offset > size {
+		return nil, nil, false
+	}
+	f, ok := cache.open(key, size, version)
+	if !ok {
+		return nil, nil, false
+	}
+	r, w, err := pipeat.PipeInDir(localTempDir)
+	if err != nil {
+		f.Close()
+		return nil, nil, false
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+		defer f.Close()
+
+		n, err := io.Copy(w, &contextReader{ctx: ctx, r: io.NewSectionReader(f, offset, size-offset)})
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download from read cache completed, key: %q size: %v, err: %+v", key, n, err)
+	}()
+
+	return r, cancelFn, true
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func isReadCacheEnabled() bool {
+	return readCache.Load() != nil
+}
+
+func getReadCacheKey(storageID, name string) string {
+	return storageID + "/" + name
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	defaultRetryBaseDelay = 200
+	defaultRetryMaxDelay  = 5000
+	maxCloudRetries       = 10
+)
+
+// CloudRetryPolicy defines how list, head, copy and delete operations are retried
+// if a Cloud Storage backend (S3, GCS, Azure Blob) returns a transient error, a
+// throttling (429) or a server error (5xx) response. Retries are delayed using
+// exponential backoff with full jitter
+type CloudRetryPolicy struct {
+	// Maximum number of retries for a failed operation. 0 means no retry
+	MaxRetries int `json:"max_retries,omitempty"`
+	// Maximum delay, as milliseconds, before the first retry. The delay doubles
+	// for each subsequent retry. 0 means the default (200)
+	RetryBaseDelay int `json:"retry_base_delay,omitempty"`
+	// Maximum delay, as milliseconds, between retries. 0 means the default (5000)
+	RetryMaxDelay int `json:"retry_max_delay,omitempty"`
+}
+
+func (p *CloudRetryPolicy) validate() error {
+	if p.MaxRetries < 0 || p.MaxRetries > maxCloudRetries {
+		return fmt.Errorf("invalid max_retries %d, it must be between 0 and %d", p.MaxRetries, maxCloudRetries)
+	}
+	if p.RetryBaseDelay < 0 {
+		return fmt.Errorf("invalid retry_base_delay: %d", p.RetryBaseDelay)
+	}
+	if p.RetryMaxDelay < 0 {
+		return fmt.Errorf("invalid retry_max_delay: %d", p.RetryMaxDelay)
+	}
+	if p.MaxRetries == 0 {
+		p.RetryBaseDelay = 0
+		p.RetryMaxDelay = 0
+		return nil
+	}
+	if p.getBaseDelay() > p.getMaxDelay() {
+		return fmt.Errorf("retry_base_delay %d cannot be greater than retry_max_delay %d",
+			p.getBaseDelay(), p.getMaxDelay())
+	}
+	return nil
+}
+
+func (p *CloudRetryPolicy) getBaseDelay() int64 {
+	if p.RetryBaseDelay > 0 {
+		return int64(p.RetryBaseDelay)
+	}
+	return defaultRetryBaseDelay
+}
+
+func (p *CloudRetryPolicy) getMaxDelay() int64 {
+	if p.RetryMaxDelay > 0 {
+		return int64(p.RetryMaxDelay)
+	}
+	return defaultRetryMaxDelay
+}
+
+// getDelay returns a random delay, up to the exponential backoff, before the
+// specified retry. Retries start from 0
+func (p *CloudRetryPolicy) getDelay(retry int) time.Duration {
+	maxDelay := p.getMaxDelay()
+	backoff := p.getBaseDelay()
+	for i := 0; i < retry && backoff < maxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDelay {
+		backoff = maxDelay
+	}
+	return time.Duration(rand.Int63n(backoff)+1) * time.Millisecond //nolint:gosec
+}
+
+// retry executes the given function and retries it, as defined by the policy,
+// while it returns an error that isRetryable reports as transient.
+// Retries stop if the given context is done
+func (p *CloudRetryPolicy) retry(ctx context.Context, fs Fs, operation string, isRetryable func(error) bool,
+	fn func() error,
+) error {
+	err := fn()
+	for retry := 0; err != nil && retry < p.MaxRetries && isRetryable(err); retry++ {
+		delay := p.getDelay(retry)
+		fsLog(fs, logger.LevelDebug, "%s failed with a transient error, retry %d/%d in %s: %v",
+			operation, retry+1, p.MaxRetries, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		err = fn()
+	}
+	return err
+}
+
+// isRetryableStatusCode returns true for throttling and server errors
+// except the ones that are not transient
+func isRetryableStatusCode(statusCode int) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return statusCode >= 500 && statusCode != http.StatusNotImplemented &&
+		statusCode != http.StatusHTTPVersionNotSupported
+}
@@ -19,8 +19,12 @@ package vfs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -35,6 +39,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -46,6 +51,7 @@ import (
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -57,6 +63,7 @@ const (
 	// using this mime type for directories improves compatibility with s3fs-fuse
 	s3DirMimeType        = "application/x-directory"
 	s3TransferBufferSize = 256 * 1024
+	iamAPIVersion        = "2010-05-08"
 )
 
 var (
@@ -68,10 +75,11 @@ type S3Fs struct {
 	connectionID string
 	localTempDir string
 	// if not empty this fs is mouted as virtual folder in the specified path
-	mountPath  string
-	config     *S3FsConfig
-	svc        *s3.Client
-	ctxTimeout time.Duration
+	mountPath    string
+	config       *S3FsConfig
+	svc          *s3.Client
+	ctxTimeout   time.Duration
+	listingCache *listingCache
 }
 
 func init() {
@@ -94,6 +102,7 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 		mountPath:    getMountPath(mountPath),
 		config:       &s3Config,
 		ctxTimeout:   30 * time.Second,
+		listingCache: newListingCache(),
 	}
 	if err := fs.config.validate(); err != nil {
 		return fs, err
@@ -151,8 +160,17 @@ func (fs *S3Fs) ConnectionID() string {
 	return fs.connectionID
 }
 
-// Stat returns a FileInfo describing the named file
+// Stat returns a FileInfo describing the named file.
+// If symlinks emulation is enabled, links are followed
 func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Lstat(name)
+	if err != nil || !fs.config.EmulateSymlinks {
+		return info, err
+	}
+	return statSymlink(fs, fs.config.KeyPrefix, name, info)
+}
+
+func (fs *S3Fs) stat(name string) (os.FileInfo, error) {
 	var result *FileInfo
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
@@ -169,6 +187,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 			_, err = fs.headObject(name + "/")
 			isDir = err == nil
 		}
+		if fs.config.EmulateSymlinks && isSymlinkContentType(util.GetStringFromPointer(obj.ContentType)) {
+			return newSymlinkFileInfo(name, obj.ContentLength, util.GetTimeFromPointer(obj.LastModified)), nil
+		}
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, obj.ContentLength,
 			util.GetTimeFromPointer(obj.LastModified), false))
 	}
@@ -201,15 +222,49 @@ func (fs *S3Fs) getStatForDir(name string) (os.FileInfo, error) {
 
 // Lstat returns a FileInfo describing the named file
 func (fs *S3Fs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
+	if info, ok := fs.listingCache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.stat(name)
+	if err == nil {
+		fs.listingCache.setStat(name, info)
+	}
+	return info, err
 }
 
 // Open opens the named file for reading
 func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
+	var size int64
+	var version string
+	cacheKey := getReadCacheKey(fs.getStorageID(), name)
+	if isReadCacheEnabled() {
+		if obj, err := fs.headObject(name); err == nil {
+			size = obj.ContentLength
+			version = util.GetStringFromPointer(obj.ETag)
+			if r, cancelFn, ok := openFromReadCache(fs, fs.localTempDir, cacheKey, offset, size, version); ok {
+				return nil, r, cancelFn, nil
+			}
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	var writer io.WriterAt = w
+	var cacheWriter *readCacheWriter
+	if offset == 0 && version != "" {
+		if cw, ok := newReadCacheWriter(w, cacheKey, size, version); ok {
+			writer = cw
+			cacheWriter = cw
+		}
+	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 	downloader := manager.NewDownloader(fs.svc, func(d *manager.Downloader) {
 		d.Concurrency = fs.config.DownloadConcurrency
@@ -229,11 +284,14 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 	go func() {
 		defer cancelFn()
 
-		n, err := downloader.Download(ctx, w, &s3.GetObjectInput{
+		n, err := downloader.Download(ctx, writer, &s3.GetObjectInput{
 			Bucket: aws.String(fs.config.Bucket),
 			Key:    aws.String(name),
 			Range:  streamRange,
 		})
+		if cacheWriter != nil {
+			cacheWriter.Done(err)
+		}
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
 		metric.S3TransferCompleted(n, 1, err)
@@ -241,8 +299,17 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 	return nil, r, cancelFn, nil
 }
 
-// Create creates or opens the named file for writing
+// Create creates or opens the named file for writing.
+// If upload staging is enabled, a local staged file is returned
 func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if flag != -1 && isUploadStagingEnabled() {
+		return createStagedFile(name, fs.localTempDir, flag, fs.streamUpload)
+	}
+	return fs.streamUpload(name, flag)
+}
+
+func (fs *S3Fs) streamUpload(name string, flag int) (File, *PipeWriter, func(), error) {
+	fs.listingCache.invalidate()
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -263,19 +330,25 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 		defer cancelFn()
 
 		var contentType string
+		storageClass := fs.config.StorageClass
 		if flag == -1 {
 			contentType = s3DirMimeType
 		} else {
 			contentType = mime.TypeByExtension(path.Ext(name))
+			storageClass = fs.getStorageClass(name, -1)
 		}
 		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
 			Bucket:       aws.String(fs.config.Bucket),
 			Key:          aws.String(name),
 			Body:         r,
 			ACL:          types.ObjectCannedACL(fs.config.ACL),
-			StorageClass: types.StorageClass(fs.config.StorageClass),
+			StorageClass: types.StorageClass(storageClass),
 			ContentType:  util.NilIfEmpty(contentType),
 		})
+		if err == nil && flag != -1 {
+			fs.updateStorageClass(name, storageClass, r.GetReadedBytes())
+		}
+		fs.listingCache.invalidate()
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
@@ -290,7 +363,10 @@ func (fs *S3Fs) Rename(source, target string) (int, int64, error) {
 	if source == target {
 		return -1, -1, nil
 	}
-	fi, err := fs.Stat(source)
+	fs.listingCache.invalidate()
+	defer fs.listingCache.invalidate()
+
+	fi, err := fs.stat(source)
 	if err != nil {
 		return -1, -1, err
 	}
@@ -299,6 +375,8 @@ func (fs *S3Fs) Rename(source, target string) (int, int64, error) {
 
 // Remove removes the named file or (empty) directory.
 func (fs *S3Fs) Remove(name string, isDir bool) error {
+	defer fs.listingCache.invalidate()
+
 	if isDir {
 		hasContents, err := fs.hasContents(name)
 		if err != nil {
@@ -311,12 +389,15 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 			name += "/"
 		}
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
+	err := fs.config.retry(context.Background(), fs, "delete object", isS3RetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
 
-	_, err := fs.svc.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(name),
+		_, err := fs.svc.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(fs.config.Bucket),
+			Key:    aws.String(name),
+		})
+		return err
 	})
 	metric.S3DeleteObjectCompleted(err)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
@@ -329,7 +410,7 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *S3Fs) Mkdir(name string) error {
-	_, err := fs.Stat(name)
+	_, err := fs.stat(name)
 	if !fs.IsNotExist(err) {
 		return err
 	}
@@ -337,13 +418,38 @@ func (fs *S3Fs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*S3Fs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are supported only if emulation is enabled
+func (fs *S3Fs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	linkTarget, err := validateSymlinkTarget(fs.config.KeyPrefix, source, target)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.stat(target); err == nil {
+		return fmt.Errorf("cannot create symlink, %q already exists", target)
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	defer fs.listingCache.invalidate()
+
+	return fs.writeSymlink(target, linkTarget)
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*S3Fs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *S3Fs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	target, ok, err := fs.readSymlink(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%q is not a symlink", name)
+	}
+	return fs.GetRelativePath(getSymlinkKey(fs.config.KeyPrefix, target)), nil
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -362,7 +468,7 @@ func (fs *S3Fs) Chtimes(name string, atime, mtime time.Time, isUploading bool) e
 		return ErrVfsUnsupported
 	}
 	if !isUploading {
-		info, err := fs.Stat(name)
+		info, err := fs.stat(name)
 		if err != nil {
 			return err
 		}
@@ -370,6 +476,8 @@ func (fs *S3Fs) Chtimes(name string, atime, mtime time.Time, isUploading bool) e
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.listingCache.invalidate()
+
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
 }
@@ -384,6 +492,16 @@ func (*S3Fs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		resolved, err := resolveSymlink(fs, fs.config.KeyPrefix, dirname)
+		if err != nil {
+			return nil, err
+		}
+		dirname = resolved
+	}
+	if result, ok := fs.listingCache.getDir(dirname); ok {
+		return result, nil
+	}
 	var result []os.FileInfo
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
@@ -404,7 +522,7 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		page, err := paginator.NextPage(ctx)
+		page, err := fs.nextPage(ctx, paginator)
 		if err != nil {
 			metric.S3ListObjectsCompleted(err)
 			return result, err
@@ -436,12 +554,21 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				objectModTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
+			if !isDir && fs.isSymlinkCandidate(fileObject.Size) {
+				// S3 doesn't return the content type when listing objects
+				obj, err := fs.headObject(util.GetStringFromPointer(fileObject.Key))
+				if err == nil && isSymlinkContentType(util.GetStringFromPointer(obj.ContentType)) {
+					result = append(result, newSymlinkFileInfo(name, fileObject.Size, objectModTime))
+					continue
+				}
+			}
 			result = append(result, NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size,
 				objectModTime, false))
 		}
 	}
 
 	metric.S3ListObjectsCompleted(nil)
+	fs.listingCache.setDir(dirname, result)
 	return result, nil
 }
 
@@ -474,6 +601,16 @@ func (*S3Fs) IsNotExist(err error) bool {
 	return false
 }
 
+func isS3RetryableError(err error) bool {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		if re.Response != nil {
+			return isRetryableStatusCode(re.Response.StatusCode)
+		}
+	}
+	return false
+}
+
 // IsPermission returns a boolean indicating whether the error is known to
 // report that permission is denied.
 func (*S3Fs) IsPermission(err error) bool {
@@ -529,7 +666,7 @@ func (fs *S3Fs) getFileNamesInPrefix(fsPrefix string) (map[string]bool, error) {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		page, err := paginator.NextPage(ctx)
+		page, err := fs.nextPage(ctx, paginator)
 		if err != nil {
 			metric.S3ListObjectsCompleted(err)
 			if err != nil {
@@ -571,7 +708,7 @@ func (fs *S3Fs) GetDirSize(dirname string) (int, int64, error) {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		page, err := paginator.NextPage(ctx)
+		page, err := fs.nextPage(ctx, paginator)
 		if err != nil {
 			metric.S3ListObjectsCompleted(err)
 			return numFiles, size, err
@@ -635,7 +772,7 @@ func (fs *S3Fs) Walk(root string, walkFn filepath.WalkFunc) error {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		page, err := paginator.NextPage(ctx)
+		page, err := fs.nextPage(ctx, paginator)
 		if err != nil {
 			metric.S3ListObjectsCompleted(err)
 			walkFn(root, NewFileInfo(root, true, 0, time.Unix(0, 0), false), err) //nolint:errcheck
@@ -677,14 +814,109 @@ func (fs *S3Fs) ResolvePath(virtualPath string) (string, error) {
 	if !path.IsAbs(virtualPath) {
 		virtualPath = path.Clean("/" + virtualPath)
 	}
-	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+	name := fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/"))
+	if fs.config.EmulateSymlinks {
+		return resolveSymlinkParents(fs, fs.config.KeyPrefix, name)
+	}
+	return name, nil
 }
 
 // CopyFile implements the FsFileCopier interface
 func (fs *S3Fs) CopyFile(source, target string, srcSize int64) error {
+	defer fs.listingCache.invalidate()
+
 	return fs.copyFileInternal(source, target, srcSize)
 }
 
+// SetChecksums implements the FsChecksummer interface.
+// The SHA256 checksum is verified against the one reported by S3, if any,
+// and the checksums are stored as object tags
+func (fs *S3Fs) SetChecksums(name string, checksums map[string]string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj, err := fs.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(name),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	metric.S3HeadObjectCompleted(err)
+	if err != nil {
+		return err
+	}
+	// multipart uploads report a checksum of checksums, we cannot compare it
+	if reported := util.GetStringFromPointer(obj.ChecksumSHA256); !strings.Contains(reported, "-") {
+		if err := verifyChecksum(name, ChecksumSHA256, checksums, base64ToHex(reported)); err != nil {
+			return err
+		}
+	}
+	tags := make([]types.Tag, 0, len(checksums))
+	for algo, value := range checksums {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(checksumMetadataPrefix + algo),
+			Value: aws.String(value),
+		})
+	}
+	_, err = fs.svc.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(fs.config.Bucket),
+		Key:     aws.String(name),
+		Tagging: &types.Tagging{TagSet: tags},
+	})
+	return err
+}
+
+// GetChecksums implements the FsChecksummer interface
+func (fs *S3Fs) GetChecksums(name string) (map[string]string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, tag := range resp.TagSet {
+		tags[util.GetStringFromPointer(tag.Key)] = util.GetStringFromPointer(tag.Value)
+	}
+	return getChecksumsFromMetadata(tags, checksumMetadataPrefix), nil
+}
+
+// SetMetadata implements the FsMetadataSetter interface.
+// The metadata are stored as object tags, the existing tags are preserved
+func (fs *S3Fs) SetMetadata(name string, metadata map[string]string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+	tags := make([]types.Tag, 0, len(resp.TagSet)+len(metadata))
+	for _, tag := range resp.TagSet {
+		if _, ok := metadata[strings.TrimPrefix(util.GetStringFromPointer(tag.Key), checksumMetadataPrefix)]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+	for k, v := range metadata {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(checksumMetadataPrefix + k),
+			Value: aws.String(v),
+		})
+	}
+	_, err = fs.svc.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(fs.config.Bucket),
+		Key:     aws.String(name),
+		Tagging: &types.Tagging{TagSet: tags},
+	})
+	return err
+}
+
 func (fs *S3Fs) resolve(name *string, prefix string) (string, bool) {
 	result := strings.TrimPrefix(util.GetStringFromPointer(name), prefix)
 	isDir := strings.HasSuffix(result, "/")
@@ -717,6 +949,28 @@ func (fs *S3Fs) setConfigDefaults() {
 	}
 }
 
+// getStorageClass returns the storage class to use for the specified object.
+// A negative size means unknown
+func (fs *S3Fs) getStorageClass(name string, size int64) string {
+	return getStorageClass(fs.config.StorageClassRules, fs.config.StorageClass,
+		"/"+strings.TrimPrefix(name, fs.config.KeyPrefix), size)
+}
+
+// updateStorageClass changes the storage class for the uploaded object, copying it
+// in place, if a storage class rule based on the file size matches
+func (fs *S3Fs) updateStorageClass(name, storageClass string, size int64) {
+	newStorageClass := fs.getStorageClass(name, size)
+	if newStorageClass == storageClass {
+		return
+	}
+	err := fs.copyFileInternal(name, name, size)
+	fsLog(fs, logger.LevelDebug, "storage class for %q changed from %q to %q, size: %d, err: %v",
+		name, storageClass, newStorageClass, size, err)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to change the storage class for %q: %v", name, err)
+	}
+}
+
 func (fs *S3Fs) copyFileInternal(source, target string, fileSize int64) error {
 	contentType := mime.TypeByExtension(path.Ext(source))
 	copySource := pathEscape(fs.Join(fs.config.Bucket, source))
@@ -728,16 +982,19 @@ func (fs *S3Fs) copyFileInternal(source, target string, fileSize int64) error {
 		metric.S3CopyObjectCompleted(err)
 		return err
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
+	err := fs.config.retry(context.Background(), fs, "copy object", isS3RetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
 
-	_, err := fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:       aws.String(fs.config.Bucket),
-		CopySource:   aws.String(copySource),
-		Key:          aws.String(target),
-		StorageClass: types.StorageClass(fs.config.StorageClass),
-		ACL:          types.ObjectCannedACL(fs.config.ACL),
-		ContentType:  util.NilIfEmpty(contentType),
+		_, err := fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:       aws.String(fs.config.Bucket),
+			CopySource:   aws.String(copySource),
+			Key:          aws.String(target),
+			StorageClass: types.StorageClass(fs.getStorageClass(target, fileSize)),
+			ACL:          types.ObjectCannedACL(fs.config.ACL),
+			ContentType:  util.NilIfEmpty(contentType),
+		})
+		return err
 	})
 
 	metric.S3CopyObjectCompleted(err)
@@ -748,7 +1005,18 @@ func (fs *S3Fs) renameInternal(source, target string, fi os.FileInfo) (int, int6
 	var numFiles int
 	var filesSize int64
 
-	if fi.IsDir() {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		linkTarget, ok, err := fs.readSymlink(source)
+		if err != nil {
+			return numFiles, filesSize, err
+		}
+		if !ok {
+			return numFiles, filesSize, fmt.Errorf("%q is not a symlink", source)
+		}
+		if err := fs.writeSymlink(target, linkTarget); err != nil {
+			return numFiles, filesSize, err
+		}
+	} else if fi.IsDir() {
 		if renameMode == 0 {
 			hasContents, err := fs.hasContents(source)
 			if err != nil {
@@ -822,7 +1090,7 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		page, err := paginator.NextPage(ctx)
+		page, err := fs.nextPage(ctx, paginator)
 		metric.S3ListObjectsCompleted(err)
 		if err != nil {
 			return false, err
@@ -849,7 +1117,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	res, err := fs.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
 		Bucket:       aws.String(fs.config.Bucket),
 		Key:          aws.String(target),
-		StorageClass: types.StorageClass(fs.config.StorageClass),
+		StorageClass: types.StorageClass(fs.getStorageClass(target, fileSize)),
 		ACL:          types.ObjectCannedACL(fs.config.ACL),
 		ContentType:  util.NilIfEmpty(contentType),
 	})
@@ -983,15 +1251,85 @@ func (fs *S3Fs) getPrefix(name string) string {
 }
 
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
+	var obj *s3.HeadObjectOutput
+	err := fs.config.retry(context.Background(), fs, "head object", isS3RetryableError, func() error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		var err error
+		obj, err = fs.svc.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(fs.config.Bucket),
+			Key:    aws.String(name),
+		})
+		return err
+	})
+	metric.S3HeadObjectCompleted(err)
+	return obj, err
+}
+
+// nextPage returns the next listing page retrying transient errors. The paginator
+// does not advance if a page cannot be fetched, so the same page is requested again
+func (fs *S3Fs) nextPage(ctx context.Context, paginator *s3.ListObjectsV2Paginator) (*s3.ListObjectsV2Output, error) {
+	var page *s3.ListObjectsV2Output
+	err := fs.config.retry(ctx, fs, "list objects", isS3RetryableError, func() error {
+		var err error
+		page, err = paginator.NextPage(ctx)
+		return err
+	})
+	return page, err
+}
+
+func (fs *S3Fs) isSymlinkCandidate(size int64) bool {
+	return fs.config.EmulateSymlinks && size > 0 && size <= maxSymlinkTargetSize
+}
+
+func (fs *S3Fs) readSymlink(name string) (string, bool, error) {
+	if info, ok := fs.listingCache.getStat(name); ok && info.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+	obj, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if !isSymlinkContentType(util.GetStringFromPointer(obj.ContentType)) || !fs.isSymlinkCandidate(obj.ContentLength) {
+		return "", false, nil
+	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	obj, err := fs.svc.HeadObject(ctx, &s3.HeadObjectInput{
+	out, err := fs.svc.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(fs.config.Bucket),
 		Key:    aws.String(name),
 	})
-	metric.S3HeadObjectCompleted(err)
-	return obj, err
+	if err != nil {
+		metric.S3TransferCompleted(0, 1, err)
+		return "", false, err
+	}
+	defer out.Body.Close()
+
+	target, err := readSymlinkTarget(out.Body)
+	metric.S3TransferCompleted(int64(len(target)), 1, err)
+	return target, err == nil, err
+}
+
+func (fs *S3Fs) writeSymlink(name, linkTarget string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(name),
+		Body:         strings.NewReader(linkTarget),
+		ACL:          types.ObjectCannedACL(fs.config.ACL),
+		StorageClass: types.StorageClass(fs.config.StorageClass),
+		ContentType:  aws.String(symlinkContentType),
+	})
+	metric.S3TransferCompleted(int64(len(linkTarget)), 0, err)
+	fsLog(fs, logger.LevelDebug, "symlink %q -> %q written, err: %v", name, linkTarget, err)
+	return err
 }
 
 // GetMimeType returns the content type
@@ -1049,3 +1387,105 @@ func pathEscape(in string) string {
 	u.Path = in
 	return strings.ReplaceAll(u.String(), "+", "%2B")
 }
+
+type iamAccessKey struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+}
+
+type iamCreateAccessKeyResponse struct {
+	AccessKey iamAccessKey `xml:"CreateAccessKeyResult>AccessKey"`
+}
+
+type iamErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// getIAMEndpoint returns the IAM endpoint and the signing region for the
+// AWS partition of the specified region
+func getIAMEndpoint(region string) (string, string) {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "https://iam.cn-north-1.amazonaws.com.cn/", "cn-north-1"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "https://iam.us-gov.amazonaws.com/", "us-gov-west-1"
+	default:
+		return "https://iam.amazonaws.com/", "us-east-1"
+	}
+}
+
+// doIAMRequest executes a request against the AWS IAM Query API and decodes
+// the XML response in result, if not nil
+func doIAMRequest(ctx context.Context, creds aws.Credentials, region string, params url.Values, result any) error {
+	params.Set("Version", iamAPIVersion)
+	body := params.Encode()
+	endpoint, signingRegion := getIAMEndpoint(region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	payloadHash := sha256.Sum256([]byte(body))
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "iam", signingRegion, time.Now())
+	if err != nil {
+		return fmt.Errorf("unable to sign IAM request: %w", err)
+	}
+	resp, err := getAWSHTTPClient(30, 10*time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1048576))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp iamErrorResponse
+		if xml.Unmarshal(data, &errResp) == nil && errResp.Code != "" {
+			return fmt.Errorf("IAM action %q failed: %s: %s", params.Get("Action"), errResp.Code, errResp.Message)
+		}
+		return fmt.Errorf("IAM action %q failed, unexpected status code: %d", params.Get("Action"), resp.StatusCode)
+	}
+	if result != nil {
+		return xml.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// rotateS3Credentials creates a new access key for the IAM user associated
+// with the configured access key. The returned function deletes the previous
+// access key, it must be called after saving the new one
+func rotateS3Credentials(ctx context.Context, config *S3FsConfig) (func(context.Context) error, error) {
+	if config.Endpoint != "" || config.AccessKey == "" {
+		return nil, errCredentialsRotationNotSupported
+	}
+	secret := config.AccessSecret.Clone()
+	if err := secret.TryDecrypt(); err != nil {
+		return nil, fmt.Errorf("unable to decrypt access secret: %w", err)
+	}
+	creds := aws.Credentials{
+		AccessKeyID:     config.AccessKey,
+		SecretAccessKey: secret.GetPayload(),
+	}
+	region := config.Region
+	var result iamCreateAccessKeyResponse
+	err := doIAMRequest(ctx, creds, region, url.Values{"Action": []string{"CreateAccessKey"}}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.AccessKey.AccessKeyID == "" || result.AccessKey.SecretAccessKey == "" {
+		return nil, errors.New("the IAM API returned an empty access key")
+	}
+	config.AccessKey = result.AccessKey.AccessKeyID
+	config.AccessSecret = kms.NewPlainSecret(result.AccessKey.SecretAccessKey)
+	// the previous key is still valid, we use it to sign the deletion request
+	// since a newly created key could not be immediately available
+	return func(ctx context.Context) error {
+		return doIAMRequest(ctx, creds, region, url.Values{
+			"Action":      []string{"DeleteAccessKey"},
+			"AccessKeyId": []string{creds.AccessKeyID},
+		}, nil)
+	}, nil
+}
@@ -18,6 +18,7 @@
 package vfs
 
 import (
+	"context"
 	"errors"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -31,3 +32,7 @@ func init() {
 func NewS3Fs(_, _, _ string, _ S3FsConfig) (Fs, error) {
 	return nil, errors.New("S3 disabled at build time")
 }
+
+func rotateS3Credentials(_ context.Context, _ *S3FsConfig) (func(context.Context) error, error) {
+	return nil, errCredentialsRotationNotSupported
+}
@@ -24,6 +24,7 @@ import (
 	"io/fs"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -39,6 +40,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
@@ -48,24 +50,76 @@ import (
 
 const (
 	// sftpFsName is the name for the SFTP Fs implementation
-	sftpFsName               = "sftpfs"
-	logSenderSFTPCache       = "sftpCache"
-	maxSessionsPerConnection = 5
+	sftpFsName                      = "sftpfs"
+	logSenderSFTPCache              = "sftpCache"
+	defaultMaxSessionsPerConnection = 5
+	defaultSFTPConnIdleTimeout      = 30 * time.Second
+	sftpConnHealthCheckTimeout      = 5 * time.Second
 )
 
 var (
+	sftpSupportedProxySchemes = []string{"socks5", "socks5h", "http"}
 	// ErrSFTPLoop defines the error to return if an SFTP loop is detected
-	ErrSFTPLoop    = errors.New("SFTP loop or nested local SFTP folders detected")
-	sftpConnsCache = newSFTPConnectionCache()
+	ErrSFTPLoop = errors.New("SFTP loop or nested local SFTP folders detected")
+	// ErrSFTPPoolExhausted defines the error to return if the maximum number
+	// of pooled connections to SFTP backends is reached
+	ErrSFTPPoolExhausted = errors.New("too many connections to SFTP backends")
+	sftpConnsCache       = newSFTPConnectionCache()
 )
 
+// SFTPFsPoolConfig defines the configuration for the pool of connections
+// shared among all the SFTPFs instances. Connections are keyed by endpoint
+// and credentials and multiplexed among multiple user sessions
+type SFTPFsPoolConfig struct {
+	// Maximum number of open connections to SFTP backends. 0 means unlimited
+	MaxConnections int `json:"max_connections" mapstructure:"max_connections"`
+	// Maximum number of sessions that can share the same connection.
+	// 0 means the default (5)
+	MaxSessionsPerConnection int `json:"max_sessions_per_connection" mapstructure:"max_sessions_per_connection"`
+	// Time in seconds after which a connection without active sessions is closed.
+	// 0 means the default (30)
+	IdleTimeout int `json:"idle_timeout" mapstructure:"idle_timeout"`
+}
+
+func (c *SFTPFsPoolConfig) getMaxSessionsPerConnection() int {
+	if c.MaxSessionsPerConnection <= 0 {
+		return defaultMaxSessionsPerConnection
+	}
+	return c.MaxSessionsPerConnection
+}
+
+func (c *SFTPFsPoolConfig) getIdleTimeout() time.Duration {
+	if c.IdleTimeout <= 0 {
+		return defaultSFTPConnIdleTimeout
+	}
+	return time.Duration(c.IdleTimeout) * time.Second
+}
+
+// SetSFTPFsPoolConfig sets the configuration for the pool of SFTP connections
+func SetSFTPFsPoolConfig(config SFTPFsPoolConfig) {
+	sftpConnsCache.setConfig(config)
+}
+
 // SFTPFsConfig defines the configuration for SFTP based filesystem
 type SFTPFsConfig struct {
 	sdk.BaseSFTPFsConfig
-	Password               *kms.Secret `json:"password,omitempty"`
-	PrivateKey             *kms.Secret `json:"private_key,omitempty"`
-	KeyPassphrase          *kms.Secret `json:"key_passphrase,omitempty"`
-	forbiddenSelfUsernames []string    `json:"-"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	PrivateKey    *kms.Secret `json:"private_key,omitempty"`
+	KeyPassphrase *kms.Secret `json:"key_passphrase,omitempty"`
+	// Optional proxy URL to use to reach the SFTP server.
+	// Supported schemes: socks5, socks5h, http
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// Optional jump host, as host:port, to use to reach the SFTP server.
+	// The jump host is authenticated using the same credentials as the SFTP server
+	JumpHost string `json:"jump_host,omitempty"`
+	// Username for the jump host, if empty the SFTP server username will be used
+	JumpHostUsername string `json:"jump_host_username,omitempty"`
+	// SHA256 fingerprints to use to validate the jump host key
+	JumpHostFingerprints []string `json:"jump_host_fingerprints,omitempty"`
+	// Compression algorithm to use to transparently compress uploaded files.
+	// Empty means no compression
+	Compression            string   `json:"compression,omitempty"`
+	forbiddenSelfUsernames []string `json:"-"`
 }
 
 // HideConfidentialData hides confidential data
@@ -117,6 +171,20 @@ func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
 			return false
 		}
 	}
+	if c.ProxyURL != other.ProxyURL || c.JumpHost != other.JumpHost || c.JumpHostUsername != other.JumpHostUsername {
+		return false
+	}
+	if c.Compression != other.Compression {
+		return false
+	}
+	if len(c.JumpHostFingerprints) != len(other.JumpHostFingerprints) {
+		return false
+	}
+	for _, fp := range c.JumpHostFingerprints {
+		if !util.Contains(other.JumpHostFingerprints, fp) {
+			return false
+		}
+	}
 	c.setEmptyCredentialsIfNil()
 	other.setEmptyCredentialsIfNil()
 	if !c.Password.IsEqual(other.Password) {
@@ -174,6 +242,12 @@ func (c *SFTPFsConfig) validate() error {
 	if err := c.validateCredentials(); err != nil {
 		return err
 	}
+	if err := c.validateProxy(); err != nil {
+		return err
+	}
+	if err := validateCompression(c.Compression); err != nil {
+		return err
+	}
 	if c.Prefix != "" {
 		c.Prefix = util.CleanPath(c.Prefix)
 	} else {
@@ -182,6 +256,35 @@ func (c *SFTPFsConfig) validate() error {
 	return nil
 }
 
+func (c *SFTPFsConfig) validateProxy() error {
+	c.ProxyURL = strings.TrimSpace(c.ProxyURL)
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		if !util.Contains(sftpSupportedProxySchemes, u.Scheme) {
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return errors.New("invalid proxy URL: host cannot be empty")
+		}
+	}
+	c.JumpHost = strings.TrimSpace(c.JumpHost)
+	if c.JumpHost == "" {
+		c.JumpHostUsername = ""
+		c.JumpHostFingerprints = nil
+		return nil
+	}
+	if !strings.Contains(c.JumpHost, ":") {
+		c.JumpHost += ":22"
+	}
+	if _, _, err := net.SplitHostPort(c.JumpHost); err != nil {
+		return fmt.Errorf("invalid jump host: %v", err)
+	}
+	return nil
+}
+
 func (c *SFTPFsConfig) validateCredentials() error {
 	if c.Password.IsEmpty() && c.PrivateKey.IsEmpty() {
 		return errors.New("credentials cannot be empty")
@@ -246,6 +349,10 @@ func (c *SFTPFsConfig) getUniqueID(partition int) uint64 {
 	b.WriteString(c.Password.GetPayload())
 	b.WriteString(c.PrivateKey.GetPayload())
 	b.WriteString(c.KeyPassphrase.GetPayload())
+	b.WriteString(c.ProxyURL)
+	b.WriteString(c.JumpHost)
+	b.WriteString(c.JumpHostUsername)
+	b.WriteString(strings.Join(c.JumpHostFingerprints, ""))
 	if allowSelfConnections != 0 {
 		b.WriteString(strings.Join(c.forbiddenSelfUsernames, ""))
 	}
@@ -293,14 +400,18 @@ func NewSFTPFs(connectionID, mountPath, localTempDir string, forbiddenSelfUserna
 		}
 	}
 	config.forbiddenSelfUsernames = forbiddenSelfUsernames
+	conn, err := sftpConnsCache.Get(&config, connectionID)
+	if err != nil {
+		return nil, err
+	}
 	sftpFs := &SFTPFs{
 		connectionID: connectionID,
 		mountPath:    getMountPath(mountPath),
 		localTempDir: localTempDir,
 		config:       &config,
-		conn:         sftpConnsCache.Get(&config, connectionID),
+		conn:         conn,
 	}
-	err := sftpFs.createConnection()
+	err = sftpFs.createConnection()
 	if err != nil {
 		sftpFs.Close() //nolint:errcheck
 	}
@@ -882,15 +993,25 @@ func (fs *SFTPFs) createConnection() error {
 	return nil
 }
 
+// sftpHealthCheck is a health check in progress for the specified client,
+// err is set before closing done
+type sftpHealthCheck struct {
+	client *sftp.Client
+	done   chan struct{}
+	err    error
+}
+
 type sftpConnection struct {
 	config       *SFTPFsConfig
 	logSender    string
 	sshClient    *ssh.Client
 	sftpClient   *sftp.Client
+	jumpClient   *ssh.Client
 	mu           sync.RWMutex
 	isConnected  bool
 	sessions     map[string]bool
 	lastActivity time.Time
+	healthCheck  *sftpHealthCheck
 }
 
 func newSFTPConnection(config *SFTPFsConfig, sessionID string) *sftpConnection {
@@ -909,15 +1030,32 @@ func (c *sftpConnection) OpenConnection() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.isConnected {
+		client, err := c.checkHealth()
+		if client != c.sftpClient {
+			// the connection was replaced while checking its health
+			if c.isConnected {
+				logger.Debug(c.logSender, "", "reusing connection")
+				return nil
+			}
+			return c.openConnNoLock()
+		}
+		if err == nil {
+			logger.Debug(c.logSender, "", "reusing connection")
+			return nil
+		}
+		if c.isConnected {
+			logger.Warn(c.logSender, "", "pooled connection is not healthy, evicting it: %v", err)
+			// closing the SFTP client waits for the pending requests, so we close
+			// the underlying SSH connection first
+			c.sshClient.Close()
+			c.closeNoLock() //nolint:errcheck
+		}
+	}
 	return c.openConnNoLock()
 }
 
 func (c *sftpConnection) openConnNoLock() error {
-	if c.isConnected {
-		logger.Debug(c.logSender, "", "reusing connection")
-		return nil
-	}
-
 	logger.Debug(c.logSender, "", "try to open a new connection")
 	clientConfig := &ssh.ClientConfig{
 		User: c.config.Username,
@@ -935,20 +1073,101 @@ func (c *sftpConnection) openConnNoLock() error {
 					return ErrSFTPLoop
 				}
 			}
-			if len(c.config.Fingerprints) > 0 {
-				for _, provided := range c.config.Fingerprints {
-					if provided == fp {
-						return nil
-					}
-				}
-				return fmt.Errorf("invalid fingerprint %q", fp)
-			}
-			logger.Log(logger.LevelWarn, c.logSender, "", "login without host key validation, please provide at least a fingerprint!")
-			return nil
+			return c.checkHostKey(fp, c.config.Fingerprints)
 		},
 		Timeout:       10 * time.Second,
 		ClientVersion: fmt.Sprintf("SSH-2.0-SFTPGo_%v", version.Get().Version),
 	}
+	authMethods, err := c.getAuthMethods()
+	if err != nil {
+		return err
+	}
+	clientConfig.Auth = authMethods
+	setSSHClientAlgorithms(clientConfig)
+	sshClient, err := c.dial(clientConfig)
+	if err != nil {
+		return fmt.Errorf("sftpfs: unable to connect: %w", err)
+	}
+	sftpClient, err := sftp.NewClient(sshClient, c.getClientOptions()...)
+	if err != nil {
+		sshClient.Close()
+		c.closeJumpClient()
+		return fmt.Errorf("sftpfs: unable to create SFTP client: %w", err)
+	}
+	c.sshClient = sshClient
+	c.sftpClient = sftpClient
+	c.isConnected = true
+	go c.Wait(sftpClient, sshClient, c.jumpClient)
+	return nil
+}
+
+// checkHealth sends a request to the SFTP server and waits for the response
+// until the health check timeout. It must be called with the lock held, the
+// lock is released while waiting for the response, so the sessions sharing
+// this connection are not blocked, and concurrent callers wait for the same
+// request. It returns the checked client
+func (c *sftpConnection) checkHealth() (*sftp.Client, error) {
+	client := c.sftpClient
+	check := c.healthCheck
+	if check == nil || check.client != client {
+		check = &sftpHealthCheck{
+			client: client,
+			done:   make(chan struct{}),
+		}
+		c.healthCheck = check
+		go func() {
+			err := checkSFTPClientHealth(client, sftpConnHealthCheckTimeout)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			check.err = err
+			if c.healthCheck == check {
+				c.healthCheck = nil
+			}
+			close(check.done)
+		}()
+	}
+	c.mu.Unlock()
+	<-check.done
+	c.mu.Lock()
+
+	return client, check.err
+}
+
+func checkSFTPClientHealth(client *sftp.Client, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Getwd()
+		errCh <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("no response after %s", timeout)
+	}
+}
+
+func (c *sftpConnection) checkHostKey(fp string, fingerprints []string) error {
+	if len(fingerprints) > 0 {
+		for _, provided := range fingerprints {
+			if provided == fp {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid fingerprint %q", fp)
+	}
+	logger.Log(logger.LevelWarn, c.logSender, "", "login without host key validation, please provide at least a fingerprint!")
+	return nil
+}
+
+func (c *sftpConnection) getAuthMethods() ([]ssh.AuthMethod, error) {
+	var authMethods []ssh.AuthMethod
 	if c.config.PrivateKey.GetPayload() != "" {
 		var signer ssh.Signer
 		var err error
@@ -959,38 +1178,86 @@ func (c *sftpConnection) openConnNoLock() error {
 			signer, err = ssh.ParsePrivateKey([]byte(c.config.PrivateKey.GetPayload()))
 		}
 		if err != nil {
-			return fmt.Errorf("sftpfs: unable to parse the private key: %w", err)
+			return nil, fmt.Errorf("sftpfs: unable to parse the private key: %w", err)
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	}
 	if c.config.Password.GetPayload() != "" {
-		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(c.config.Password.GetPayload()))
+		authMethods = append(authMethods, ssh.Password(c.config.Password.GetPayload()))
 	}
-	// add more ciphers, KEXs and MACs, they are negotiated according to the order
-	clientConfig.Ciphers = []string{"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
-		"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-cbc", "aes192-cbc", "aes256-cbc"}
-	clientConfig.KeyExchanges = []string{"curve25519-sha256", "curve25519-sha256@libssh.org",
-		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
-		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512", "diffie-hellman-group18-sha512",
-		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
-		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"}
-	clientConfig.MACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
-		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-512",
-		"hmac-sha1", "hmac-sha1-96"}
-	sshClient, err := ssh.Dial("tcp", c.config.Endpoint, clientConfig)
+	return authMethods, nil
+}
+
+// dial connects to the configured endpoint, through the proxy and/or the jump host if any
+func (c *sftpConnection) dial(clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if c.config.JumpHost == "" {
+		conn, err := c.dialTCP(c.config.Endpoint, clientConfig.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return newSSHClient(conn, c.config.Endpoint, clientConfig)
+	}
+	username := c.config.JumpHostUsername
+	if username == "" {
+		username = c.config.Username
+	}
+	jumpConfig := &ssh.ClientConfig{
+		User: username,
+		Auth: clientConfig.Auth,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			return c.checkHostKey(ssh.FingerprintSHA256(key), c.config.JumpHostFingerprints)
+		},
+		Timeout:       clientConfig.Timeout,
+		ClientVersion: clientConfig.ClientVersion,
+	}
+	setSSHClientAlgorithms(jumpConfig)
+	conn, err := c.dialTCP(c.config.JumpHost, clientConfig.Timeout)
 	if err != nil {
-		return fmt.Errorf("sftpfs: unable to connect: %w", err)
+		return nil, err
 	}
-	sftpClient, err := sftp.NewClient(sshClient, c.getClientOptions()...)
+	jumpClient, err := newSSHClient(conn, c.config.JumpHost, jumpConfig)
 	if err != nil {
-		sshClient.Close()
-		return fmt.Errorf("sftpfs: unable to create SFTP client: %w", err)
+		return nil, fmt.Errorf("unable to connect to jump host %q: %w", c.config.JumpHost, err)
+	}
+	logger.Debug(c.logSender, "", "connected to jump host %q", c.config.JumpHost)
+	targetConn, err := jumpClient.Dial("tcp", c.config.Endpoint)
+	if err != nil {
+		jumpClient.Close()
+		return nil, fmt.Errorf("unable to reach %q from jump host %q: %w", c.config.Endpoint, c.config.JumpHost, err)
+	}
+	sshClient, err := newSSHClient(targetConn, c.config.Endpoint, clientConfig)
+	if err != nil {
+		jumpClient.Close()
+		return nil, err
+	}
+	c.jumpClient = jumpClient
+	return sshClient, nil
+}
+
+func (c *sftpConnection) dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if c.config.ProxyURL == "" {
+		return dialer.Dial("tcp", addr)
+	}
+	proxyURL, err := url.Parse(c.config.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if proxyURL.Scheme == "http" {
+		return dialHTTPConnect(dialer, proxyURL, addr)
+	}
+	proxyDialer, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create proxy dialer: %w", err)
+	}
+	return proxyDialer.Dial("tcp", addr)
+}
+
+func (c *sftpConnection) closeJumpClient() {
+	if c.jumpClient != nil {
+		c.jumpClient.Close()
+		c.jumpClient = nil
 	}
-	c.sshClient = sshClient
-	c.sftpClient = sftpClient
-	c.isConnected = true
-	go c.Wait()
-	return nil
 }
 
 func (c *sftpConnection) getClientOptions() []sftp.ClientOption {
@@ -1017,7 +1284,9 @@ func (c *sftpConnection) getClient() (*sftp.Client, error) {
 	return c.sftpClient, err
 }
 
-func (c *sftpConnection) Wait() {
+// Wait waits for the specified clients to be closed, the connection is marked as
+// disconnected unless the clients were replaced by a new connection in the meantime
+func (c *sftpConnection) Wait(sftpClient *sftp.Client, sshClient, jumpClient *ssh.Client) {
 	done := make(chan struct{})
 
 	go func() {
@@ -1030,14 +1299,14 @@ func (c *sftpConnection) Wait() {
 			case <-ticker.C:
 				if watchdogInProgress.Load() {
 					logger.Error(c.logSender, "", "watchdog still in progress, closing hanging connection")
-					c.sshClient.Close()
+					sshClient.Close()
 					return
 				}
 				go func() {
 					watchdogInProgress.Store(true)
 					defer watchdogInProgress.Store(false)
 
-					_, err := c.sftpClient.Getwd()
+					_, err := sftpClient.Getwd()
 					if err != nil {
 						logger.Error(c.logSender, "", "watchdog error: %v", err)
 					}
@@ -1051,23 +1320,32 @@ func (c *sftpConnection) Wait() {
 
 	// we wait on the sftp client otherwise if the channel is closed but not the connection
 	// we don't detect the event.
-	err := c.sftpClient.Wait()
+	err := sftpClient.Wait()
 	logger.Log(logger.LevelDebug, c.logSender, "", "sftp channel closed: %v", err)
 	close(done)
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.isConnected = false
-	if c.sshClient != nil {
-		c.sshClient.Close()
+	sshClient.Close()
+	if jumpClient != nil {
+		jumpClient.Close()
 	}
+	if c.sftpClient != sftpClient {
+		return
+	}
+	c.isConnected = false
+	c.jumpClient = nil
 }
 
 func (c *sftpConnection) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	return c.closeNoLock()
+}
+
+func (c *sftpConnection) closeNoLock() error {
 	logger.Debug(c.logSender, "", "closing connection")
 	var sftpErr, sshErr error
 	if c.sftpClient != nil {
@@ -1076,10 +1354,11 @@ func (c *sftpConnection) Close() error {
 	if c.sshClient != nil {
 		sshErr = c.sshClient.Close()
 	}
+	c.closeJumpClient()
+	c.isConnected = false
 	if sftpErr != nil {
 		return sftpErr
 	}
-	c.isConnected = false
 	return sshErr
 }
 
@@ -1123,7 +1402,8 @@ func (c *sftpConnection) GetLastActivity() time.Time {
 type sftpConnectionsCache struct {
 	scheduler *cron.Cron
 	sync.RWMutex
-	items map[uint64]*sftpConnection
+	items  map[uint64]*sftpConnection
+	config SFTPFsPoolConfig
 }
 
 func newSFTPConnectionCache() *sftpConnectionsCache {
@@ -1137,23 +1417,31 @@ func newSFTPConnectionCache() *sftpConnectionsCache {
 	return c
 }
 
-func (c *sftpConnectionsCache) Get(config *SFTPFsConfig, sessionID string) *sftpConnection {
+func (c *sftpConnectionsCache) setConfig(config SFTPFsPoolConfig) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.config = config
+}
+
+func (c *sftpConnectionsCache) Get(config *SFTPFsConfig, sessionID string) (*sftpConnection, error) {
 	partition := 0
 	key := config.getUniqueID(partition)
 
 	c.Lock()
 	defer c.Unlock()
 
+	maxSessions := c.config.getMaxSessionsPerConnection()
 	var oldKey uint64
 	for {
 		if val, ok := c.items[key]; ok {
 			activeSessions := val.ActiveSessions()
-			if activeSessions < maxSessionsPerConnection || key == oldKey {
+			if activeSessions < maxSessions || key == oldKey {
 				logger.Debug(logSenderSFTPCache, "",
 					"reusing connection for session ID %q, key: %d, active sessions %d, active connections: %d",
 					sessionID, key, activeSessions+1, len(c.items))
 				val.AddSession(sessionID)
-				return val
+				return val, nil
 			}
 			partition++
 			oldKey = key
@@ -1162,14 +1450,54 @@ func (c *sftpConnectionsCache) Get(config *SFTPFsConfig, sessionID string) *sftp
 				"connection full, generated new key for partition: %d, active sessions: %d, key: %d, old key: %d",
 				partition, activeSessions, oldKey, key)
 		} else {
+			if c.config.MaxConnections > 0 && len(c.items) >= c.config.MaxConnections {
+				if !c.evictIdleNoLock() {
+					if partition > 0 {
+						// all the connections for this config are full, multiplex on the last one
+						key = oldKey
+						continue
+					}
+					logger.Warn(logSenderSFTPCache, "", "unable to add a connection for session ID %q, active connections: %d",
+						sessionID, len(c.items))
+					return nil, ErrSFTPPoolExhausted
+				}
+			}
 			conn := newSFTPConnection(config, sessionID)
 			c.items[key] = conn
 			logger.Debug(logSenderSFTPCache, "",
 				"adding new connection for session ID %q, partition: %d, key: %d, active connections: %d",
 				sessionID, partition, key, len(c.items))
-			return conn
+			return conn, nil
+		}
+	}
+}
+
+// evictIdleNoLock closes the least recently used connection without active sessions.
+// It returns false if all the connections have active sessions
+func (c *sftpConnectionsCache) evictIdleNoLock() bool {
+	var lruKey uint64
+	var lruActivity time.Time
+	found := false
+
+	for k, conn := range c.items {
+		if conn.ActiveSessions() > 0 {
+			continue
+		}
+		lastActivity := conn.GetLastActivity()
+		if !found || lastActivity.Before(lruActivity) {
+			lruKey = k
+			lruActivity = lastActivity
+			found = true
 		}
 	}
+	if found {
+		conn := c.items[lruKey]
+		delete(c.items, lruKey)
+		logger.Debug(logSenderSFTPCache, "", "evicted idle connection with key %d, active connections: %d",
+			lruKey, len(c.items))
+		go conn.Close() //nolint:errcheck
+	}
+	return found
 }
 
 func (c *sftpConnectionsCache) Remove(key uint64) {
@@ -1187,8 +1515,9 @@ func (c *sftpConnectionsCache) Remove(key uint64) {
 func (c *sftpConnectionsCache) Cleanup() {
 	c.RLock()
 
+	idleTimeout := c.config.getIdleTimeout()
 	for k, conn := range c.items {
-		if val := conn.GetLastActivity(); val.Before(time.Now().Add(-30 * time.Second)) {
+		if val := conn.GetLastActivity(); val.Before(time.Now().Add(-idleTimeout)) {
 			logger.Debug(conn.logSender, "", "removing inactive connection, last activity %s", val)
 
 			defer func(key uint64) {
@@ -1199,3 +1528,78 @@ func (c *sftpConnectionsCache) Cleanup() {
 
 	c.RUnlock()
 }
+
+func newSSHClient(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout)) //nolint:errcheck
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func setSSHClientAlgorithms(config *ssh.ClientConfig) {
+	// add more ciphers, KEXs and MACs, they are negotiated according to the order
+	config.Ciphers = []string{"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-cbc", "aes192-cbc", "aes256-cbc"}
+	config.KeyExchanges = []string{"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512", "diffie-hellman-group18-sha512",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"}
+	config.MACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
+		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-512",
+		"hmac-sha1", "hmac-sha1-96"}
+}
+
+// dialHTTPConnect establishes a tunnel to addr using the HTTP CONNECT method
+func dialHTTPConnect(dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		req.SetBasicAuth(proxyURL.User.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout)) //nolint:errcheck
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("proxy CONNECT failed: unexpected data after the response")
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+	return conn, nil
+}
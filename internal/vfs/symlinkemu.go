@@ -0,0 +1,165 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// symlinkContentType is the content type used to store emulated symlinks
+	symlinkContentType = "application/x-sftpgo-symlink"
+	// maxSymlinkTargetSize is the maximum allowed size for a symlink target
+	maxSymlinkTargetSize = 1024
+	// maxSymlinkHops is the maximum number of links to follow while
+	// resolving a path, like the Linux MAXSYMLINKS
+	maxSymlinkHops = 40
+)
+
+var (
+	errTooManySymlinks = errors.New("too many levels of symbolic links")
+)
+
+// symlinkEmulator is implemented by the object storage backends that can
+// emulate symbolic links
+type symlinkEmulator interface {
+	Lstat(name string) (os.FileInfo, error)
+	// readSymlink returns the target of the link stored at the specified key.
+	// The returned target is relative to the key prefix. ok is false if the
+	// key does not exist or if it is not a link
+	readSymlink(key string) (target string, ok bool, err error)
+}
+
+func isSymlinkContentType(contentType string) bool {
+	return contentType == symlinkContentType
+}
+
+func newSymlinkFileInfo(name string, size int64, modTime time.Time) *FileInfo {
+	info := NewFileInfo(name, false, size, modTime, false)
+	info.SetMode(os.ModeSymlink | 0777)
+	return info
+}
+
+// getSymlinkTarget returns the link target to store for the specified key
+func getSymlinkTarget(keyPrefix, key string) string {
+	return path.Clean("/" + strings.TrimPrefix(key, keyPrefix))
+}
+
+// getSymlinkKey returns the key for the specified link target
+func getSymlinkKey(keyPrefix, target string) string {
+	return strings.TrimPrefix(path.Join(keyPrefix, target), "/")
+}
+
+// readSymlinkTarget reads and validates a link target from r
+func readSymlinkTarget(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSymlinkTargetSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 || len(data) > maxSymlinkTargetSize {
+		return "", fmt.Errorf("invalid symlink target size: %d", len(data))
+	}
+	target := string(data)
+	if !path.IsAbs(target) {
+		return "", fmt.Errorf("invalid symlink target %q", target)
+	}
+	return path.Clean(target), nil
+}
+
+func validateSymlinkTarget(keyPrefix, source, target string) (string, error) {
+	linkTarget := getSymlinkTarget(keyPrefix, source)
+	if len(linkTarget) > maxSymlinkTargetSize {
+		return "", fmt.Errorf("symlink target %q is too long", linkTarget)
+	}
+	if getSymlinkTarget(keyPrefix, target) == linkTarget {
+		return "", fmt.Errorf("cannot create a symlink to itself: %q", target)
+	}
+	return linkTarget, nil
+}
+
+// resolveSymlink follows the links chain, if any, for the specified key and
+// returns the resolved key
+func resolveSymlink(e symlinkEmulator, keyPrefix, key string) (string, error) {
+	if getSymlinkTarget(keyPrefix, key) == "/" {
+		return key, nil
+	}
+	for hops := 0; ; hops++ {
+		target, ok, err := e.readSymlink(key)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return key, nil
+		}
+		if hops >= maxSymlinkHops {
+			return "", errTooManySymlinks
+		}
+		key = getSymlinkKey(keyPrefix, target)
+	}
+}
+
+// resolveSymlinkParents resolves the links in the parent components for the
+// specified key. The last component is not resolved, so the returned key can
+// still be a link
+func resolveSymlinkParents(e symlinkEmulator, keyPrefix, key string) (string, error) {
+	rel := getSymlinkTarget(keyPrefix, key)
+	if rel == "/" {
+		return key, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(rel, "/"), "/")
+	resolved := "/"
+	hops := 0
+	for _, p := range parts[:len(parts)-1] {
+		resolved = path.Join(resolved, p)
+		for {
+			target, ok, err := e.readSymlink(getSymlinkKey(keyPrefix, resolved))
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				break
+			}
+			hops++
+			if hops > maxSymlinkHops {
+				return "", errTooManySymlinks
+			}
+			resolved = target
+		}
+	}
+	return getSymlinkKey(keyPrefix, path.Join(resolved, parts[len(parts)-1])), nil
+}
+
+// statSymlink follows the link, if info describes a link, and returns the
+// target info using the link name
+func statSymlink(e symlinkEmulator, keyPrefix, name string, info os.FileInfo) (os.FileInfo, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return info, nil
+	}
+	target, err := resolveSymlink(e, keyPrefix, name)
+	if err != nil {
+		return nil, err
+	}
+	targetInfo, err := e.Lstat(target)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileInfo(name, targetInfo.IsDir(), targetInfo.Size(), targetInfo.ModTime(), false), nil
+}
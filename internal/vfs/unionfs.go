@@ -0,0 +1,729 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// unionFsName is the name for the union Fs implementation
+	unionFsName = "unionfs"
+	// files removed from the lower layers are hidden using a whiteout
+	// file with this prefix inside the upper layer
+	unionWhiteoutPrefix = ".wh."
+)
+
+var errUnionDirInLowerLayer = fmt.Errorf("%w: directories inside read-only layers cannot be removed or renamed",
+	ErrVfsUnsupported)
+
+// UnionFs is a Fs implementation that merges multiple filesystems under the
+// same path. The upper layer is writable, the lower layers are read-only and
+// they are looked up in order of precedence. Files inside the lower layers are
+// copied to the upper layer before they are modified and the files removed from
+// the lower layers are hidden using whiteout files inside the upper layer.
+// The paths used by this Fs are relative to the mount path, each layer
+// resolves them to its own paths
+type UnionFs struct {
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath string
+	upper     Fs
+	lowers    []Fs
+}
+
+// NewUnionFs returns a UnionFs object that allows to interact with the
+// specified layers. The layers must be mounted on the specified path
+func NewUnionFs(connectionID, mountPath string, upper Fs, lowers []Fs) Fs {
+	return &UnionFs{
+		connectionID: connectionID,
+		mountPath:    getMountPath(mountPath),
+		upper:        upper,
+		lowers:       lowers,
+	}
+}
+
+// Name returns the name for the Fs implementation
+func (fs *UnionFs) Name() string {
+	return fmt.Sprintf("%s upper layer %s, lower layers: %d", unionFsName, fs.upper.Name(), len(fs.lowers))
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *UnionFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *UnionFs) Stat(name string) (os.FileInfo, error) {
+	_, _, info, err := fs.lookup(name, false)
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *UnionFs) Lstat(name string) (os.FileInfo, error) {
+	_, _, info, err := fs.lookup(name, true)
+	return info, err
+}
+
+// Open opens the named file for reading
+func (fs *UnionFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	layer, layerPath, _, err := fs.lookup(name, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, r, cancelFn, err := layer.Open(layerPath, offset)
+	if f != nil {
+		f = &unionFile{File: f, name: name}
+	}
+	return f, r, cancelFn, err
+}
+
+// Create creates or opens the named file for writing inside the upper layer.
+// Files inside the lower layers are copied to the upper layer if their contents
+// are not truncated
+func (fs *UnionFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if err := fs.checkName(name, "create"); err != nil {
+		return nil, nil, nil, err
+	}
+	if flag != 0 && flag&os.O_TRUNC == 0 {
+		if err := fs.copyUp(name); err != nil && !fs.IsNotExist(err) {
+			return nil, nil, nil, err
+		}
+	}
+	upperPath, err := fs.prepareUpper(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, w, cancelFn, err := fs.upper.Create(upperPath, flag)
+	if f != nil {
+		f = &unionFile{File: f, name: name}
+	}
+	return f, w, cancelFn, err
+}
+
+// Rename renames (moves) source to target. Files inside the lower layers
+// are copied to the upper layer and then renamed, directories inside the
+// lower layers cannot be renamed
+func (fs *UnionFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	if err := fs.checkName(target, "rename"); err != nil {
+		return -1, -1, err
+	}
+	layer, _, info, err := fs.lookup(source, true)
+	if err != nil {
+		return -1, -1, err
+	}
+	inLowers := fs.existsInLowers(source)
+	if info.IsDir() && inLowers {
+		return -1, -1, errUnionDirInLowerLayer
+	}
+	if layer != fs.upper {
+		if err := fs.copyUp(source); err != nil {
+			return -1, -1, err
+		}
+	}
+	upperSource, err := fs.getLayerPath(fs.upper, source)
+	if err != nil {
+		return -1, -1, err
+	}
+	upperTarget, err := fs.prepareUpper(target)
+	if err != nil {
+		return -1, -1, err
+	}
+	numFiles, size, err := fs.upper.Rename(upperSource, upperTarget)
+	if err != nil {
+		return numFiles, size, err
+	}
+	if inLowers {
+		if err := fs.createWhiteout(source); err != nil {
+			return numFiles, size, err
+		}
+	}
+	return numFiles, size, nil
+}
+
+// Remove removes the named file or (empty) directory. Files inside the lower
+// layers are hidden, directories inside the lower layers cannot be removed
+func (fs *UnionFs) Remove(name string, isDir bool) error {
+	inLowers := fs.existsInLowers(name)
+	if isDir && inLowers {
+		return errUnionDirInLowerLayer
+	}
+	upperPath, err := fs.getLayerPath(fs.upper, name)
+	if err != nil {
+		return err
+	}
+	_, err = fs.upper.Lstat(upperPath)
+	if err == nil {
+		if err := fs.upper.Remove(upperPath, isDir); err != nil {
+			return err
+		}
+	} else if !fs.upper.IsNotExist(err) {
+		return err
+	} else if !inLowers || fs.isWhitedOut(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if inLowers {
+		return fs.createWhiteout(name)
+	}
+	return nil
+}
+
+// Mkdir creates a new directory inside the upper layer
+func (fs *UnionFs) Mkdir(name string) error {
+	if err := fs.checkName(name, "mkdir"); err != nil {
+		return err
+	}
+	if _, _, _, err := fs.lookup(name, true); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	upperPath, err := fs.prepareUpper(name)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Mkdir(upperPath)
+}
+
+// Symlink creates source as a symbolic link to target inside the upper layer
+func (fs *UnionFs) Symlink(source, target string) error {
+	if err := fs.checkName(target, "symlink"); err != nil {
+		return err
+	}
+	upperSource, err := fs.getLayerPath(fs.upper, source)
+	if err != nil {
+		return err
+	}
+	upperTarget, err := fs.prepareUpper(target)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Symlink(upperSource, upperTarget)
+}
+
+// Chown changes the numeric uid and gid of the named file
+func (fs *UnionFs) Chown(name string, uid int, gid int) error {
+	upperPath, err := fs.copyUpAndResolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Chown(upperPath, uid, gid)
+}
+
+// Chmod changes the mode of the named file to mode
+func (fs *UnionFs) Chmod(name string, mode os.FileMode) error {
+	upperPath, err := fs.copyUpAndResolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Chmod(upperPath, mode)
+}
+
+// Chtimes changes the access and modification times of the named file
+func (fs *UnionFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	upperPath, err := fs.copyUpAndResolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Chtimes(upperPath, atime, mtime, isUploading)
+}
+
+// Truncate changes the size of the named file
+func (fs *UnionFs) Truncate(name string, size int64) error {
+	upperPath, err := fs.copyUpAndResolve(name)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Truncate(upperPath, size)
+}
+
+// ReadDir reads the directory named by dirname and returns the merged list of
+// directory entries. Entries inside the upper layer take precedence
+func (fs *UnionFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	var result []os.FileInfo
+	var firstErr error
+	found := false
+	seen := make(map[string]bool)
+
+	for idx, layer := range fs.getLayers() {
+		layerPath, err := fs.getLayerPath(layer, dirname)
+		if err != nil {
+			return nil, err
+		}
+		files, err := layer.ReadDir(layerPath)
+		if err != nil {
+			if !layer.IsNotExist(err) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		found = true
+		for _, info := range files {
+			name := info.Name()
+			if strings.HasPrefix(name, unionWhiteoutPrefix) {
+				if idx == 0 {
+					seen[strings.TrimPrefix(name, unionWhiteoutPrefix)] = true
+				}
+				continue
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			result = append(result, info)
+		}
+	}
+	if !found {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// Readlink returns the destination of the named symbolic link
+func (fs *UnionFs) Readlink(name string) (string, error) {
+	layer, layerPath, _, err := fs.lookup(name, true)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := layer.Readlink(layerPath)
+	if err != nil {
+		return "", err
+	}
+	return fs.ResolvePath(layer.GetRelativePath(resolved))
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported
+func (fs *UnionFs) IsUploadResumeSupported() bool {
+	return fs.upper.IsUploadResumeSupported()
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported
+func (fs *UnionFs) IsAtomicUploadSupported() bool {
+	return fs.upper.IsAtomicUploadSupported()
+}
+
+// CheckRootPath creates the root directory of the upper layer if it does not exists
+func (fs *UnionFs) CheckRootPath(username string, uid int, gid int) bool {
+	return fs.upper.CheckRootPath(username, uid, gid)
+}
+
+// ResolvePath returns the path relative to the mount path, the layers
+// resolve this path to their own paths
+func (fs *UnionFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	return util.CleanPath(virtualPath), nil
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *UnionFs) IsNotExist(err error) bool {
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	for _, layer := range fs.getLayers() {
+		if layer.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *UnionFs) IsPermission(err error) bool {
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	for _, layer := range fs.getLayers() {
+		if layer.IsPermission(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (fs *UnionFs) IsNotSupported(err error) bool {
+	if errors.Is(err, ErrVfsUnsupported) {
+		return true
+	}
+	for _, layer := range fs.getLayers() {
+		if layer.IsNotSupported(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanRootDirContents returns the number of files and their size inside the
+// upper layer, the lower layers are read-only and so they are not included
+func (fs *UnionFs) ScanRootDirContents() (int, int64, error) {
+	return fs.upper.ScanRootDirContents()
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// inside the upper layer including any subfolders
+func (fs *UnionFs) GetDirSize(dirname string) (int, int64, error) {
+	upperPath, err := fs.getLayerPath(fs.upper, dirname)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.upper.GetDirSize(upperPath)
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*UnionFs) GetAtomicUploadPath(name string) string {
+	guid := xid.New().String()
+	return path.Join(path.Dir(name), ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *UnionFs) GetRelativePath(name string) string {
+	rel := util.CleanPath(name)
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the merged file tree rooted at root, calling walkFn for each
+// file or directory in the tree, including root
+func (fs *UnionFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = fs.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (fs *UnionFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	files, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		err = fs.walk(path.Join(name, fi.Name()), fi, walkFn)
+		if err != nil && (!fi.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Join joins any number of path elements into a single path
+func (*UnionFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*UnionFs) HasVirtualFolders() bool {
+	return false
+}
+
+// GetMimeType returns the content type
+func (fs *UnionFs) GetMimeType(name string) (string, error) {
+	layer, layerPath, _, err := fs.lookup(name, false)
+	if err != nil {
+		return "", err
+	}
+	return layer.GetMimeType(layerPath)
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+// inside the upper layer
+func (fs *UnionFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	upperPath, err := fs.getLayerPath(fs.upper, dirName)
+	if err != nil {
+		return nil, err
+	}
+	return fs.upper.GetAvailableDiskSize(upperPath)
+}
+
+// CheckMetadata checks the metadata consistency for the upper layer
+func (fs *UnionFs) CheckMetadata() error {
+	return fs.upper.CheckMetadata()
+}
+
+// Close closes all the layers
+func (fs *UnionFs) Close() error {
+	var result error
+	for _, layer := range fs.getLayers() {
+		if err := layer.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (fs *UnionFs) getLayers() []Fs {
+	layers := make([]Fs, 0, len(fs.lowers)+1)
+	layers = append(layers, fs.upper)
+	return append(layers, fs.lowers...)
+}
+
+// getLayerPath returns the path for the specified layer
+func (fs *UnionFs) getLayerPath(layer Fs, name string) (string, error) {
+	return layer.ResolvePath(fs.GetRelativePath(name))
+}
+
+// lookup returns the layer containing the specified name, the path for that
+// layer and the file info. The lower layers are skipped for whited out names
+func (fs *UnionFs) lookup(name string, lstat bool) (Fs, string, os.FileInfo, error) {
+	stat := func(layer Fs, layerPath string) (os.FileInfo, error) {
+		if lstat {
+			return layer.Lstat(layerPath)
+		}
+		return layer.Stat(layerPath)
+	}
+
+	upperPath, err := fs.getLayerPath(fs.upper, name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	info, err := stat(fs.upper, upperPath)
+	if err == nil {
+		return fs.upper, upperPath, info, nil
+	}
+	if !fs.upper.IsNotExist(err) {
+		return nil, "", nil, err
+	}
+	if fs.isWhitedOut(name) {
+		return nil, "", nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	for _, layer := range fs.lowers {
+		layerPath, err := fs.getLayerPath(layer, name)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		info, err := stat(layer, layerPath)
+		if err == nil {
+			return layer, layerPath, info, nil
+		}
+		if !layer.IsNotExist(err) {
+			return nil, "", nil, err
+		}
+	}
+	return nil, "", nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// existsInLowers returns true if the specified name exists inside a lower layer
+func (fs *UnionFs) existsInLowers(name string) bool {
+	for _, layer := range fs.lowers {
+		layerPath, err := fs.getLayerPath(layer, name)
+		if err != nil {
+			continue
+		}
+		if _, err := layer.Lstat(layerPath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (*UnionFs) getWhiteoutName(name string) string {
+	name = util.CleanPath(name)
+	return path.Join(path.Dir(name), unionWhiteoutPrefix+path.Base(name))
+}
+
+func (fs *UnionFs) isWhitedOut(name string) bool {
+	if util.CleanPath(name) == "/" {
+		return false
+	}
+	whiteoutPath, err := fs.getLayerPath(fs.upper, fs.getWhiteoutName(name))
+	if err != nil {
+		return false
+	}
+	_, err = fs.upper.Lstat(whiteoutPath)
+	return err == nil
+}
+
+func (fs *UnionFs) createWhiteout(name string) error {
+	whiteoutPath, err := fs.prepareUpper(fs.getWhiteoutName(name))
+	if err != nil {
+		return err
+	}
+	f, w, cancelFn, err := fs.upper.Create(whiteoutPath, 0)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to create whiteout for %q: %v", name, err)
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	if f != nil {
+		return f.Close()
+	}
+	return w.Close()
+}
+
+func (fs *UnionFs) removeWhiteout(name string) error {
+	whiteoutPath, err := fs.getLayerPath(fs.upper, fs.getWhiteoutName(name))
+	if err != nil {
+		return err
+	}
+	if err := fs.upper.Remove(whiteoutPath, false); err != nil && !fs.upper.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// prepareUpper creates the missing parent directories for the specified name
+// inside the upper layer, removes its whiteout, if any, and returns the path
+// for the upper layer
+func (fs *UnionFs) prepareUpper(name string) (string, error) {
+	name = util.CleanPath(name)
+	dirs := util.GetDirsForVirtualPath(path.Dir(name))
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if dirs[idx] == "/" {
+			continue
+		}
+		dirPath, err := fs.getLayerPath(fs.upper, dirs[idx])
+		if err != nil {
+			return "", err
+		}
+		if _, err := fs.upper.Stat(dirPath); err == nil {
+			continue
+		}
+		if err := fs.upper.Mkdir(dirPath); err != nil && !errors.Is(err, os.ErrExist) {
+			fsLog(fs, logger.LevelError, "unable to create dir %q inside the upper layer: %v", dirs[idx], err)
+			return "", err
+		}
+	}
+	if !strings.HasPrefix(path.Base(name), unionWhiteoutPrefix) {
+		if err := fs.removeWhiteout(name); err != nil {
+			return "", err
+		}
+	}
+	return fs.getLayerPath(fs.upper, name)
+}
+
+func (fs *UnionFs) copyUpAndResolve(name string) (string, error) {
+	if err := fs.copyUp(name); err != nil {
+		return "", err
+	}
+	return fs.getLayerPath(fs.upper, name)
+}
+
+// copyUp copies the specified file, if it exists only inside a lower layer,
+// to the upper layer. Directories are created inside the upper layer
+func (fs *UnionFs) copyUp(name string) error {
+	layer, layerPath, info, err := fs.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	if layer == fs.upper {
+		return nil
+	}
+	upperPath, err := fs.prepareUpper(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fs.upper.Mkdir(upperPath)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: cannot copy non regular file %q to the upper layer", ErrVfsUnsupported, name)
+	}
+	fsLog(fs, logger.LevelDebug, "copying %q to the upper layer, size: %d", name, info.Size())
+	f, r, cancelFn, err := layer.Open(layerPath, 0)
+	if err != nil {
+		return err
+	}
+	var reader io.ReadCloser
+	if f != nil {
+		reader = f
+	} else {
+		reader = r
+	}
+	defer func() {
+		reader.Close()
+		if cancelFn != nil {
+			cancelFn()
+		}
+	}()
+
+	dst, w, cancelUpload, err := fs.upper.Create(upperPath, 0)
+	if err != nil {
+		return err
+	}
+	var writer io.WriteCloser
+	if dst != nil {
+		writer = dst
+	} else {
+		writer = w
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil && cancelUpload != nil {
+		cancelUpload()
+	}
+	errClose := writer.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to copy %q to the upper layer: %v", name, err)
+		fs.upper.Remove(upperPath, false) //nolint:errcheck
+		return err
+	}
+	fs.upper.Chtimes(upperPath, info.ModTime(), info.ModTime(), false) //nolint:errcheck
+	return nil
+}
+
+func (fs *UnionFs) checkName(name, op string) error {
+	if strings.HasPrefix(path.Base(name), unionWhiteoutPrefix) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// unionFile wraps the files opened inside a layer so they report
+// the path used by the union Fs as name
+type unionFile struct {
+	File
+	name string
+}
+
+func (f *unionFile) Name() string {
+	return f.name
+}
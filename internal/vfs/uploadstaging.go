@@ -0,0 +1,332 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	uploadStagingLogSender = "uploadstaging"
+)
+
+var (
+	uploadStagingConfig UploadStagingConfig
+	errStagedFileClosed = errors.New("staged file already closed")
+)
+
+// UploadStagingConfig defines the configuration for staged uploads to the Cloud
+// Storage backends (S3, GCS, Azure Blob). Uploads to these backends are streamed,
+// so writes must be sequential. Staged uploads are written locally, in memory or
+// in a temporary file, so clients can write at arbitrary offsets and truncate
+// the file being uploaded. The staged file is uploaded when it is closed
+type UploadStagingConfig struct {
+	// Enable staged uploads
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Files are staged in memory up to this size, in MB, and then moved to a
+	// temporary file inside the local temp dir. 0 means always use temporary files
+	MemoryThreshold int64 `json:"memory_threshold" mapstructure:"memory_threshold"`
+}
+
+func (c *UploadStagingConfig) validate() error {
+	if c.MemoryThreshold < 0 {
+		return errors.New("the upload staging memory threshold cannot be negative")
+	}
+	return nil
+}
+
+// SetUploadStagingConfig sets the configuration for staged uploads
+func SetUploadStagingConfig(config UploadStagingConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	uploadStagingConfig = config
+	return nil
+}
+
+func isUploadStagingEnabled() bool {
+	return uploadStagingConfig.Enabled
+}
+
+// createStagedFile returns a local staged file that will be uploaded using the
+// specified create function when closed
+func createStagedFile(name, localTempDir string, flag int,
+	create func(name string, flag int) (File, *PipeWriter, func(), error),
+) (File, *PipeWriter, func(), error) {
+	f := &stagedFile{
+		name:      name,
+		tempDir:   localTempDir,
+		threshold: uploadStagingConfig.MemoryThreshold * 1048576,
+		upload: func(r io.Reader) error {
+			_, w, cancelFn, err := create(name, flag)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, r); err != nil {
+				if cancelFn != nil {
+					cancelFn()
+				}
+				w.Close() //nolint:errcheck
+				return err
+			}
+			return w.Close()
+		},
+	}
+	if f.threshold == 0 {
+		if err := f.spill(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return f, nil, f.abort, nil
+}
+
+// stagedFile is a File staged in memory, or in a temporary file above the
+// configured threshold, and uploaded on close. It supports random access
+// writes and truncation
+type stagedFile struct {
+	mu        sync.Mutex
+	name      string
+	tempDir   string
+	threshold int64
+	buf       []byte
+	file      *os.File
+	size      int64
+	offset    int64
+	aborted   bool
+	closed    bool
+	upload    func(r io.Reader) error
+}
+
+// spill moves the staged data from memory to a temporary file
+func (f *stagedFile) spill() error {
+	file, err := os.CreateTemp(f.tempDir, "staged")
+	if err != nil {
+		return err
+	}
+	if len(f.buf) > 0 {
+		if _, err := file.Write(f.buf); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+	}
+	f.file = file
+	f.buf = nil
+	return nil
+}
+
+func (f *stagedFile) ensureSize(size int64) error {
+	if f.file != nil {
+		return nil
+	}
+	if size > f.threshold {
+		return f.spill()
+	}
+	if size > int64(len(f.buf)) {
+		f.buf = append(f.buf, make([]byte, size-int64(len(f.buf)))...)
+	}
+	return nil
+}
+
+func (f *stagedFile) writeAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errStagedFileClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	end := off + int64(len(p))
+	if err := f.ensureSize(end); err != nil {
+		return 0, err
+	}
+	var n int
+	var err error
+	if f.file != nil {
+		n, err = f.file.WriteAt(p, off)
+	} else {
+		n = copy(f.buf[off:], p)
+	}
+	if off+int64(n) > f.size {
+		f.size = off + int64(n)
+	}
+	return n, err
+}
+
+func (f *stagedFile) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errStagedFileClosed
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if f.file != nil {
+		return f.file.ReadAt(p, off)
+	}
+	n := copy(p, f.buf[off:f.size])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write implements io.Writer
+func (f *stagedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt implements io.WriterAt
+func (f *stagedFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writeAt(p, off)
+}
+
+// Read implements io.Reader
+func (f *stagedFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt
+func (f *stagedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.readAt(p, off)
+}
+
+// Seek implements io.Seeker
+func (f *stagedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the staged file
+func (f *stagedFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return errStagedFileClosed
+	}
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	if err := f.ensureSize(size); err != nil {
+		return err
+	}
+	if f.file != nil {
+		if err := f.file.Truncate(size); err != nil {
+			return err
+		}
+	} else {
+		f.buf = f.buf[:size]
+	}
+	f.size = size
+	return nil
+}
+
+// Stat returns a FileInfo describing the staged file
+func (f *stagedFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return NewFileInfo(f.name, false, f.size, time.Now(), false), nil
+}
+
+// Name returns the name of the object to upload
+func (f *stagedFile) Name() string {
+	return f.name
+}
+
+// abort discards the staged data, nothing will be uploaded on close
+func (f *stagedFile) abort() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.aborted = true
+}
+
+// Close uploads the staged file, if not aborted, and removes the local data
+func (f *stagedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return errStagedFileClosed
+	}
+	f.closed = true
+	defer f.cleanup()
+
+	if f.aborted {
+		logger.Debug(uploadStagingLogSender, "", "staged upload for %q aborted, size: %d", f.name, f.size)
+		return nil
+	}
+	var r io.Reader
+	if f.file != nil {
+		r = io.NewSectionReader(f.file, 0, f.size)
+	} else {
+		r = bytes.NewReader(f.buf[:f.size])
+	}
+	err := f.upload(r)
+	logger.Debug(uploadStagingLogSender, "", "staged upload for %q completed, size: %d, in memory: %t, err: %v",
+		f.name, f.size, f.file == nil, err)
+	return err
+}
+
+func (f *stagedFile) cleanup() {
+	f.buf = nil
+	if f.file != nil {
+		f.file.Close()
+		if err := os.Remove(f.file.Name()); err != nil {
+			logger.Warn(uploadStagingLogSender, "", "unable to remove staged file %q: %v", f.file.Name(), err)
+		}
+	}
+}
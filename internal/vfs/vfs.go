@@ -19,11 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -139,6 +141,22 @@ type FsFileCopier interface {
 	CopyFile(source, target string, srcSize int64) error
 }
 
+// FsChecksummer is a Fs that can store the checksums computed while uploading files.
+// Checksums are hex encoded and keyed by algorithm
+type FsChecksummer interface {
+	Fs
+	SetChecksums(name string, checksums map[string]string) error
+	GetChecksums(name string) (map[string]string, error)
+}
+
+// FsMetadataSetter is a Fs that can store custom metadata for files.
+// The given keys are added to the existing metadata, replacing the
+// ones with the same name
+type FsMetadataSetter interface {
+	Fs
+	SetMetadata(name string, metadata map[string]string) error
+}
+
 // File defines an interface representing a SFTPGo file
 type File interface {
 	io.Reader
@@ -179,10 +197,103 @@ func (q *QuotaCheckResult) GetRemainingFiles() int {
 	return 0
 }
 
+// StorageClassRule defines a rule to select the storage class for the uploaded files.
+// Rules are evaluated in order and the first matching one is applied, if no rule
+// matches the storage class defined in the filesystem config is used
+type StorageClassRule struct {
+	// Shell-like pattern, as supported by path.Match. Patterns without a "/" are matched
+	// against the file name, for example "*.bak", the other ones against the full path
+	// relative to the key prefix, for example "/backups/*". Empty means any file
+	Pattern string `json:"pattern,omitempty"`
+	// The rule applies to files with at least this size, in bytes. The size is unknown
+	// when an upload starts, so the storage class is changed after the upload if needed
+	MinSize int64 `json:"min_size,omitempty"`
+	// Storage class to use for the matching files
+	StorageClass string `json:"storage_class"`
+}
+
+func (r *StorageClassRule) matches(name string, size int64) bool {
+	if r.MinSize > 0 && size < r.MinSize {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+	toMatch := name
+	if !strings.Contains(r.Pattern, "/") {
+		toMatch = path.Base(name)
+	}
+	matched, err := path.Match(strings.ToLower(r.Pattern), strings.ToLower(toMatch))
+	return err == nil && matched
+}
+
+func validateStorageClassRules(rules []StorageClassRule) error {
+	for idx := range rules {
+		rule := &rules[idx]
+		rule.Pattern = strings.TrimSpace(rule.Pattern)
+		rule.StorageClass = strings.TrimSpace(rule.StorageClass)
+		if rule.StorageClass == "" {
+			return fmt.Errorf("storage class rule %d: storage class cannot be empty", idx+1)
+		}
+		if rule.MinSize < 0 {
+			return fmt.Errorf("storage class rule %d: invalid min size %d", idx+1, rule.MinSize)
+		}
+		if _, err := path.Match(rule.Pattern, "a"); err != nil {
+			return fmt.Errorf("storage class rule %d: invalid pattern %q: %v", idx+1, rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func areStorageClassRulesEqual(rules, other []StorageClassRule) bool {
+	if len(rules) != len(other) {
+		return false
+	}
+	for idx := range rules {
+		if rules[idx] != other[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyStorageClassRules(rules []StorageClassRule) []StorageClassRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	result := make([]StorageClassRule, len(rules))
+	copy(result, rules)
+	return result
+}
+
+// getStorageClass returns the storage class for the specified path, relative to the
+// key prefix. A negative size means unknown, in this case the rules with a minimum
+// size are skipped
+func getStorageClass(rules []StorageClassRule, defaultClass, name string, size int64) string {
+	for _, rule := range rules {
+		if size < 0 && rule.MinSize > 0 {
+			continue
+		}
+		if rule.matches(name, size) {
+			return rule.StorageClass
+		}
+	}
+	return defaultClass
+}
+
 // S3FsConfig defines the configuration for S3 based filesystem
 type S3FsConfig struct {
 	sdk.BaseS3FsConfig
 	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
+	// Rules to select the storage class for the uploaded files
+	StorageClassRules []StorageClassRule `json:"storage_class_rules,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation. Links are stored as
+	// small objects containing the link target
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
+	// Retry policy for transient errors
+	CloudRetryPolicy
+	// Automatic credentials rotation
+	CredentialsRotation
 }
 
 // HideConfidentialData hides confidential data
@@ -214,6 +325,9 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.StorageClass != other.StorageClass {
 		return false
 	}
+	if !areStorageClassRulesEqual(c.StorageClassRules, other.StorageClassRules) {
+		return false
+	}
 	if c.ACL != other.ACL {
 		return false
 	}
@@ -224,6 +338,15 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.ForcePathStyle != other.ForcePathStyle {
 		return false
 	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
+	if c.CloudRetryPolicy != other.CloudRetryPolicy {
+		return false
+	}
+	if c.RotationInterval != other.RotationInterval {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -342,14 +465,48 @@ func (c *S3FsConfig) validate() error {
 		}
 	}
 	c.StorageClass = strings.TrimSpace(c.StorageClass)
+	if err := validateStorageClassRules(c.StorageClassRules); err != nil {
+		return err
+	}
 	c.ACL = strings.TrimSpace(c.ACL)
+	if err := c.CloudRetryPolicy.validate(); err != nil {
+		return err
+	}
+	if err := c.validateCredentialsRotation(); err != nil {
+		return err
+	}
 	return c.checkPartSizeAndConcurrency()
 }
 
+func (c *S3FsConfig) validateCredentialsRotation() error {
+	if err := c.CredentialsRotation.validate(); err != nil {
+		return err
+	}
+	if c.IsEnabled() {
+		// access keys are rotated using the AWS IAM API
+		if c.Endpoint != "" {
+			return errors.New("credentials rotation is only supported for AWS S3, the endpoint must be empty")
+		}
+		if c.AccessKey == "" {
+			return errors.New("credentials rotation requires an access key")
+		}
+	}
+	return nil
+}
+
 // GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
 type GCSFsConfig struct {
 	sdk.BaseGCSFsConfig
 	Credentials *kms.Secret `json:"credentials,omitempty"`
+	// Rules to select the storage class for the uploaded files
+	StorageClassRules []StorageClassRule `json:"storage_class_rules,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation. Links are stored as
+	// small objects containing the link target
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
+	// Retry policy for transient errors
+	CloudRetryPolicy
+	// Automatic credentials rotation
+	CredentialsRotation
 }
 
 // HideConfidentialData hides confidential data
@@ -387,6 +544,9 @@ func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
 	if c.StorageClass != other.StorageClass {
 		return false
 	}
+	if !areStorageClassRulesEqual(c.StorageClassRules, other.StorageClassRules) {
+		return false
+	}
 	if c.ACL != other.ACL {
 		return false
 	}
@@ -396,6 +556,15 @@ func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
 	if c.UploadPartMaxTime != other.UploadPartMaxTime {
 		return false
 	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
+	if c.CloudRetryPolicy != other.CloudRetryPolicy {
+		return false
+	}
+	if c.RotationInterval != other.RotationInterval {
+		return false
+	}
 	if c.Credentials == nil {
 		c.Credentials = kms.NewEmptySecret()
 	}
@@ -433,6 +602,9 @@ func (c *GCSFsConfig) validate() error {
 		return errors.New("invalid credentials")
 	}
 	c.StorageClass = strings.TrimSpace(c.StorageClass)
+	if err := validateStorageClassRules(c.StorageClassRules); err != nil {
+		return err
+	}
 	c.ACL = strings.TrimSpace(c.ACL)
 	if c.UploadPartSize < 0 {
 		c.UploadPartSize = 0
@@ -440,7 +612,13 @@ func (c *GCSFsConfig) validate() error {
 	if c.UploadPartMaxTime < 0 {
 		c.UploadPartMaxTime = 0
 	}
-	return nil
+	if err := c.CredentialsRotation.validate(); err != nil {
+		return err
+	}
+	if c.IsEnabled() && c.AutomaticCredentials != 0 {
+		return errors.New("credentials rotation is not supported with automatic credentials")
+	}
+	return c.CloudRetryPolicy.validate()
 }
 
 // AzBlobFsConfig defines the configuration for Azure Blob Storage based filesystem
@@ -451,6 +629,13 @@ type AzBlobFsConfig struct {
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
 	// Shared access signature URL, leave blank if using account/key
 	SASURL *kms.Secret `json:"sas_url,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation. Links are stored as
+	// small blobs containing the link target
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
+	// Retry policy for transient errors
+	CloudRetryPolicy
+	// Automatic credentials rotation
+	CredentialsRotation
 }
 
 // HideConfidentialData hides confidential data
@@ -503,6 +688,15 @@ func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
 	if c.AccessTier != other.AccessTier {
 		return false
 	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
+	if c.CloudRetryPolicy != other.CloudRetryPolicy {
+		return false
+	}
+	if c.RotationInterval != other.RotationInterval {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -622,9 +816,75 @@ func (c *AzBlobFsConfig) validate() error {
 	if !util.Contains(validAzAccessTier, c.AccessTier) {
 		return fmt.Errorf("invalid access tier %q, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
 	}
+	if err := c.CredentialsRotation.validate(); err != nil {
+		return err
+	}
+	// the SAS URL is generated and signed using the account key
+	if c.IsEnabled() && (c.AccountName == "" || c.AccountKey.IsEmpty() || c.Container == "") {
+		return errors.New("credentials rotation requires the account name, the account key and the container")
+	}
+	return c.CloudRetryPolicy.validate()
+}
+
+// OSFsConfig defines the configuration for the local filesystem
+type OSFsConfig struct {
+	// Compression algorithm to use to transparently compress uploaded files.
+	// Empty means no compression
+	Compression string `json:"compression,omitempty"`
+	// UID and GID to set for files and directories created inside a virtual folder,
+	// they override the user settings. 0 means the user settings are applied
+	UID int `json:"uid,omitempty"`
+	GID int `json:"gid,omitempty"`
+	// Umask, as octal string, for files and directories created inside a virtual
+	// folder, for example "027". Empty means the process umask is applied
+	Umask string `json:"umask,omitempty"`
+}
+
+func (c *OSFsConfig) isEqual(other OSFsConfig) bool {
+	return c.Compression == other.Compression && c.UID == other.UID && c.GID == other.GID &&
+		c.Umask == other.Umask
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *OSFsConfig) Validate() error {
+	if err := validateCompression(c.Compression); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate local fs config: %v", err))
+	}
+	if c.UID < 0 || c.UID > math.MaxInt32 {
+		return util.NewValidationError(fmt.Sprintf("could not validate local fs config: invalid uid %d", c.UID))
+	}
+	if c.GID < 0 || c.GID > math.MaxInt32 {
+		return util.NewValidationError(fmt.Sprintf("could not validate local fs config: invalid gid %d", c.GID))
+	}
+	c.Umask = strings.TrimSpace(c.Umask)
+	if _, err := c.getUmask(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate local fs config: invalid umask %q", c.Umask))
+	}
 	return nil
 }
 
+// ResetOwnership clears the uid, the gid and the umask, they are only
+// supported for virtual folders
+func (c *OSFsConfig) ResetOwnership() {
+	c.UID = 0
+	c.GID = 0
+	c.Umask = ""
+}
+
+func (c *OSFsConfig) getUmask() (os.FileMode, error) {
+	if c.Umask == "" {
+		return 0, nil
+	}
+	umask, err := strconv.ParseUint(c.Umask, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if umask > 0777 {
+		return 0, fmt.Errorf("umask %o out of range", umask)
+	}
+	return os.FileMode(umask), nil
+}
+
 // CryptFsConfig defines the configuration to store local files as encrypted
 type CryptFsConfig struct {
 	Passphrase *kms.Secret `json:"passphrase,omitempty"`
@@ -684,6 +944,7 @@ type PipeWriter struct {
 	writer *pipeat.PipeWriterAt
 	err    error
 	done   chan bool
+	offset int64
 }
 
 // NewPipeWriter initializes a new PipeWriter
@@ -695,6 +956,15 @@ func NewPipeWriter(w *pipeat.PipeWriterAt) *PipeWriter {
 	}
 }
 
+// NewPipeWriterAtOffset initializes a new PipeWriter for appending data to an
+// existing file of the specified size. WriteAt offsets are relative to the
+// beginning of the existing file
+func NewPipeWriterAtOffset(w *pipeat.PipeWriterAt, offset int64) *PipeWriter {
+	p := NewPipeWriter(w)
+	p.offset = offset
+	return p
+}
+
 // Close waits for the upload to end, closes the pipeat.PipeWriterAt and returns an error if any.
 func (p *PipeWriter) Close() error {
 	p.writer.Close() //nolint:errcheck // the returned error is always null
@@ -711,7 +981,10 @@ func (p *PipeWriter) Done(err error) {
 
 // WriteAt is a wrapper for pipeat WriteAt
 func (p *PipeWriter) WriteAt(data []byte, off int64) (int, error) {
-	return p.writer.WriteAt(data, off)
+	if off < p.offset {
+		return 0, fmt.Errorf("invalid write offset %d, minimum valid value: %d", off, p.offset)
+	}
+	return p.writer.WriteAt(data, off-p.offset)
 }
 
 // Write is a wrapper for pipeat Write
@@ -742,6 +1015,11 @@ func IsCryptOsFs(fs Fs) bool {
 	return fs.Name() == cryptFsName
 }
 
+// IsCompressedFs returns true if fs transparently compresses the stored files
+func IsCompressedFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), compressedFsName)
+}
+
 // IsSFTPFs returns true if fs is an SFTP filesystem
 func IsSFTPFs(fs Fs) bool {
 	return strings.HasPrefix(fs.Name(), sftpFsName)
@@ -776,11 +1054,23 @@ func IsLocalOrSFTPFs(fs Fs) bool {
 	return IsLocalOsFs(fs) || IsSFTPFs(fs)
 }
 
-// HasTruncateSupport returns true if the fs supports truncate files
+// HasTruncateSupport returns true if the fs supports truncate files.
+// Cloud Storage backends support truncating files being uploaded if
+// upload staging is enabled
 func HasTruncateSupport(fs Fs) bool {
+	if HasImplicitAtomicUploads(fs) {
+		return isUploadStagingEnabled()
+	}
 	return IsLocalOsFs(fs) || IsSFTPFs(fs) || IsHTTPFs(fs)
 }
 
+// HasAppendUploadSupport returns true if the fs does not support resuming
+// uploads but can append data to existing files. Uploads must be created
+// using the os.O_APPEND flag
+func HasAppendUploadSupport(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), gcsfsName)
+}
+
 // HasImplicitAtomicUploads returns true if the fs don't persists partial files on error
 func HasImplicitAtomicUploads(fs Fs) bool {
 	if strings.HasPrefix(fs.Name(), s3fsName) {
@@ -807,21 +1097,33 @@ func HasOpenRWSupport(fs Fs) bool {
 	return false
 }
 
+// getOsFs returns the local filesystem, if any, also if wrapped by a compressed filesystem
+func getOsFs(fs Fs) *OsFs {
+	if compressedFs, ok := fs.(*CompressedFs); ok {
+		fs = compressedFs.Fs
+	}
+	osFs, _ := fs.(*OsFs)
+	return osFs
+}
+
 // IsLocalOrCryptoFs returns true if fs is local or local encrypted
 func IsLocalOrCryptoFs(fs Fs) bool {
 	return IsLocalOsFs(fs) || IsCryptOsFs(fs)
 }
 
 // SetPathPermissions calls fs.Chown.
-// It does nothing for local filesystem on windows
+// It does nothing for local filesystem on windows.
+// The uid, gid and umask configured for local virtual folders take precedence
 func SetPathPermissions(fs Fs, path string, uid int, gid int) {
-	if uid == -1 && gid == -1 {
-		return
-	}
-	if IsLocalOsFs(fs) {
+	if osFs := getOsFs(fs); osFs != nil {
 		if runtime.GOOS == "windows" {
 			return
 		}
+		uid, gid = osFs.getOwnership(uid, gid)
+		osFs.applyUmask(path)
+	}
+	if uid == -1 && gid == -1 {
+		return
 	}
 	if err := fs.Chown(path, uid, gid); err != nil {
 		fsLog(fs, logger.LevelWarn, "error chowning path %v: %v", path, err)
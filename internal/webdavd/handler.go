@@ -16,6 +16,7 @@ package webdavd
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -211,6 +212,9 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
 	}
+	if err := c.CheckDirLimits(requestPath); err != nil {
+		return nil, err
+	}
 	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
@@ -272,7 +276,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 	if vfs.HasTruncateSupport(fs) {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+			dataprovider.UpdateUserVirtualFolderQuota(&c.User, &vfolder, 0, -fileSize, false) //nolint:errcheck
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 			}
@@ -293,3 +297,96 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
+
+// handlePartialUpdate writes the data read from the given reader to the existing
+// file at the specified path. The write offset is computed by getOffset from the
+// current file size. Partial updates are only supported for the local filesystem
+func (c *Connection) handlePartialUpdate(name string, reader io.Reader, size int64,
+	getOffset func(fileSize int64) (int64, error),
+) error {
+	c.UpdateLastActivity()
+
+	name = util.CleanPath(name)
+	fs, fsPath, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return err
+	}
+	if !vfs.IsLocalOsFs(fs) {
+		c.Log(logger.LevelDebug, "partial update for file %q not supported, fs: %q", name, fs.Name())
+		return c.GetOpUnsupportedError()
+	}
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return c.GetPermissionDeniedError()
+	}
+	stat, err := fs.Lstat(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to stat file %q for partial update: %v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	if !stat.Mode().IsRegular() {
+		c.Log(logger.LevelError, "attempted a partial update for a non regular file: %q", fsPath)
+		return c.GetOpUnsupportedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return c.GetPermissionDeniedError()
+	}
+	fileSize := stat.Size()
+	offset, err := getOffset(fileSize)
+	if err != nil {
+		return err
+	}
+	diskQuota, transferQuota := c.HasSpace(false, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return common.ErrQuotaExceeded
+	}
+	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, true, fileSize, fs.IsUploadResumeSupported())
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
+		return err
+	}
+	// the size to write is known, we deny the update before writing, a quota
+	// error while writing would remove the existing file
+	if (maxWriteSize > 0 && size > maxWriteSize) ||
+		(transferQuota.AllowedULSize > 0 && size > transferQuota.AllowedULSize) ||
+		(transferQuota.AllowedTotalSize > 0 && size > transferQuota.AllowedTotalSize) {
+		c.Log(logger.LevelInfo, "denying partial update of %d bytes due to quota limits", size)
+		return c.GetQuotaExceededError()
+	}
+	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, fsPath, name,
+		fileSize, os.O_WRONLY); err != nil {
+		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", name, err)
+		return c.GetPermissionDeniedError()
+	}
+	file, _, cancelFn, err := fs.Create(fsPath, os.O_WRONLY)
+	if err != nil {
+		c.Log(logger.LevelError, "error opening file %q for partial update: %+v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		c.Log(logger.LevelError, "unable to seek file %q to offset %d: %v", fsPath, offset, err)
+		file.Close() //nolint:errcheck
+		return c.GetFsError(fs, err)
+	}
+	c.Log(logger.LevelDebug, "partial update requested, file path %q, file size: %d, offset: %d, size: %d",
+		fsPath, fileSize, offset, size)
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, fsPath, name,
+		common.TransferUpload, 0, fileSize, maxWriteSize, 0, false, fs, transferQuota)
+	mtime := c.getModificationTime()
+	baseTransfer.SetTimes(fsPath, mtime, mtime)
+
+	f := newWebDavFile(baseTransfer, nil, nil)
+	n, err := io.Copy(f, reader)
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		f.TransferError(err)
+	}
+	if errClose := f.Close(); errClose != nil && err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,6 +29,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -333,6 +336,38 @@ func newMockOsFs(atomicUpload bool, connectionID, rootDir string, reader *pipeat
 	}
 }
 
+func TestBindingVirtualHosts(t *testing.T) {
+	b := Binding{
+		Port: 2121,
+		VirtualHosts: []VirtualHost{
+			{
+				Host:         " Files.Example.com ",
+				AllowedRoles: []string{"role1", "role1", "role2"},
+			},
+			{
+				Host: "Other.example.com",
+			},
+		},
+	}
+	err := b.checkVirtualHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, "files.example.com", b.VirtualHosts[0].Host)
+	assert.Len(t, b.VirtualHosts[0].AllowedRoles, 2)
+	assert.True(t, b.isRoleAllowed("files.example.com", "role2"))
+	assert.False(t, b.isRoleAllowed("files.example.com", "role3"))
+	assert.False(t, b.isRoleAllowed("files.example.com", ""))
+	assert.True(t, b.isRoleAllowed("other.example.com", "role3"))
+	assert.True(t, b.isRoleAllowed("", "role3"))
+	assert.Len(t, b.getVirtualHostsCertIDs(), 0)
+
+	b.VirtualHosts = append(b.VirtualHosts, VirtualHost{Host: "FILES.example.com"})
+	err = b.checkVirtualHosts()
+	assert.ErrorContains(t, err, "is duplicated")
+	b.VirtualHosts = []VirtualHost{{Host: " "}}
+	err = b.checkVirtualHosts()
+	assert.ErrorContains(t, err, "host is mandatory")
+}
+
 func TestUserInvalidParams(t *testing.T) {
 	u := &dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -1000,12 +1035,12 @@ func TestBasicUsersCache(t *testing.T) {
 
 	ipAddr := "127.0.0.1"
 
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.Error(t, err)
 
 	now := time.Now()
 	req.SetBasicAuth(username, password)
-	_, isCached, _, loginMethod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1015,14 +1050,14 @@ func TestBasicUsersCache(t *testing.T) {
 		assert.False(t, cachedUser.IsExpired())
 		assert.True(t, cachedUser.Expiration.After(now.Add(time.Duration(c.Cache.Users.ExpirationTime)*time.Minute)))
 		// authenticate must return the cached user now
-		authUser, isCached, _, _, err := server.authenticate(req, ipAddr)
+		authUser, isCached, _, err := server.authenticate(req, ipAddr)
 		assert.NoError(t, err)
 		assert.True(t, isCached)
 		assert.Equal(t, cachedUser.User, authUser)
 	}
 	// a wrong password must fail
 	req.SetBasicAuth(username, "wrong")
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.EqualError(t, err, dataprovider.ErrInvalidCredentials.Error())
 	req.SetBasicAuth(username, password)
 
@@ -1034,7 +1069,7 @@ func TestBasicUsersCache(t *testing.T) {
 		assert.True(t, cachedUser.IsExpired())
 	}
 	// now authenticate should get the user from the data provider and update the cache
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1061,7 +1096,7 @@ func TestBasicUsersCache(t *testing.T) {
 	_, ok = dataprovider.GetCachedWebDAVUser(username)
 	assert.False(t, ok)
 
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1131,12 +1166,12 @@ func TestCachedUserWithFolders(t *testing.T) {
 
 	ipAddr := "127.0.0.1"
 
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.Error(t, err)
 
 	now := time.Now()
 	req.SetBasicAuth(username, password)
-	_, isCached, _, loginMethod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1146,7 +1181,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 		assert.False(t, cachedUser.IsExpired())
 		assert.True(t, cachedUser.Expiration.After(now.Add(time.Duration(c.Cache.Users.ExpirationTime)*time.Minute)))
 		// authenticate must return the cached user now
-		authUser, isCached, _, _, err := server.authenticate(req, ipAddr)
+		authUser, isCached, _, err := server.authenticate(req, ipAddr)
 		assert.NoError(t, err)
 		assert.True(t, isCached)
 		assert.Equal(t, cachedUser.User, authUser)
@@ -1158,7 +1193,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	err = dataprovider.UpdateFolder(&folder, folder.Users, folder.Groups, "", "", "")
 	assert.NoError(t, err)
 
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.True(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1170,7 +1205,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	folder.MappedPath = filepath.Join(os.TempDir(), "anotherpath")
 	err = dataprovider.UpdateFolder(&folder, folder.Users, folder.Groups, "", "", "")
 	assert.NoError(t, err)
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1182,7 +1217,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	err = dataprovider.DeleteFolder(folderName, "", "", "")
 	assert.NoError(t, err)
 	// removing a used folder should invalidate the cache
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1263,7 +1298,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1271,7 +1306,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user2.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user2.Username, password+"2")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1279,7 +1314,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user3.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user3.Username, password+"3")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1295,7 +1330,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user4.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user4.Username, password+"4")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1315,7 +1350,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1334,7 +1369,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user2.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user2.Username, password+"2")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1353,7 +1388,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user3.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user3.Username, password+"3")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1376,7 +1411,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user4.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user4.Username, password+"4")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1386,7 +1421,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1435,7 +1470,6 @@ func TestUserCacheIsolation(t *testing.T) {
 		User:       user,
 		Expiration: time.Now().Add(24 * time.Hour),
 		Password:   password,
-		LockSystem: webdav.NewMemLS(),
 	}
 	cachedUser.User.FsConfig.S3Config.AccessSecret = kms.NewPlainSecret("test secret")
 	err = cachedUser.User.FsConfig.S3Config.AccessSecret.Encrypt()
@@ -1486,6 +1520,47 @@ func TestRecoverer(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 }
 
+func TestKerberosAuthentication(t *testing.T) {
+	c := &Configuration{
+		Bindings: []Binding{
+			{
+				Port:            9000,
+				KerberosService: "missing",
+			},
+		},
+	}
+	server := webDavServer{
+		config:  c,
+		binding: c.Bindings[0],
+	}
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Negotiate", "Basic realm=\"SFTPGo WebDAV\""}, rr.Header().Values("WWW-Authenticate"))
+	assert.True(t, server.isKerberosRequest(setNegotiateHeader(req, "token")))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, setNegotiateHeader(req, "token"))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	_, err = server.authenticateKerberos(rr, setNegotiateHeader(req, "token"), "127.0.0.1")
+	assert.Error(t, err)
+	// basic auth is used if no Kerberos service is defined for the binding
+	server.binding.KerberosService = ""
+	assert.False(t, server.isKerberosRequest(setNegotiateHeader(req, "token")))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Basic realm=\"SFTPGo WebDAV\""}, rr.Header().Values("WWW-Authenticate"))
+}
+
+func setNegotiateHeader(r *http.Request, token string) *http.Request {
+	req := r.Clone(r.Context())
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString([]byte(token)))
+	return req
+}
+
 func TestMimeCache(t *testing.T) {
 	cache := mimeCache{
 		maxSize:   0,
@@ -1573,6 +1648,162 @@ func TestVerifyTLSConnection(t *testing.T) {
 	certMgr = oldCertMgr
 }
 
+func TestLockManager(t *testing.T) {
+	testLockManager(t, newLockManager(0))
+	switch dataprovider.GetProviderStatus().Driver {
+	case dataprovider.MySQLDataProviderName, dataprovider.PGSQLDataProviderName,
+		dataprovider.CockroachDataProviderName, dataprovider.SQLiteDataProviderName:
+		testLockManager(t, newLockManager(1))
+	}
+}
+
+func testLockManager(t *testing.T, mgr *lockManager) {
+	now := time.Now()
+	user := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:  "lock_user",
+			CreatedAt: util.GetTimeAsMsSinceEpoch(now),
+		},
+	}
+	ls := mgr.getLockSystem(user)
+	token, err := ls.Create(now, webdav.LockDetails{
+		Root:     "dir",
+		Duration: time.Minute,
+		OwnerXML: "<owner>user</owner>",
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, lockTokenPrefix))
+	// the same paths for a different user, or for a recreated user with the same name, are not locked
+	otherUser := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:  user.Username,
+			CreatedAt: user.CreatedAt + 1,
+		},
+	}
+	otherToken, err := mgr.getLockSystem(otherUser).Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	assert.NoError(t, err)
+	// a lock on the same path or on a descendant of an infinite depth lock must fail
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/dir/", ZeroDepth: true, Duration: time.Minute})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/dir/sub/file", ZeroDepth: true, Duration: time.Minute})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/", Duration: time.Minute})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	// a zero depth lock on the parent is allowed
+	rootToken, err := ls.Create(now, webdav.LockDetails{Root: "/", ZeroDepth: true, Duration: -1})
+	assert.NoError(t, err)
+	tokenFound, _, details, err := ls.GetByName("/dir/file")
+	assert.NoError(t, err)
+	assert.Equal(t, token, tokenFound)
+	assert.Equal(t, "/dir", details.Root)
+	assert.Equal(t, "<owner>user</owner>", details.OwnerXML)
+	_, _, _, err = ls.GetByName("/otherdir") //nolint:dogsled
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	tokenFound, expiration, details, err := ls.GetByName("/")
+	assert.NoError(t, err)
+	assert.Equal(t, rootToken, tokenFound)
+	// infinite locks are limited to the max allowed duration
+	assert.Equal(t, maxLockDuration, details.Duration)
+	assert.True(t, expiration.After(now.Add(maxLockDuration-time.Minute)))
+
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: "invalid"})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls.Confirm(now, "/dir/file", "/otherdir/file", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	release, err := ls.Confirm(now, "/dir/file", "/dir/file1", webdav.Condition{Token: token})
+	assert.NoError(t, err)
+	// the lock is held
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls.Refresh(now, token, time.Minute)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	err = ls.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	release()
+
+	details, err = ls.Refresh(now, token, 2*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, details.Duration)
+	_, err = ls.Refresh(now, "invalid", time.Minute)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	// the lock is expired
+	_, err = ls.Confirm(now.Add(3*time.Minute), "/dir/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	err = ls.Unlock(now, token)
+	assert.NoError(t, err)
+	err = ls.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	// delete removes the locks for the resource and its descendants
+	deleter, ok := ls.(webdav.LockDeleter)
+	assert.True(t, ok)
+	token, err = ls.Create(now, webdav.LockDetails{Root: "/dir/sub/file", Duration: time.Minute})
+	assert.NoError(t, err)
+	err = deleter.Delete(now, "/dir1")
+	assert.NoError(t, err)
+	err = deleter.Delete(now, "/dir")
+	assert.NoError(t, err)
+	err = ls.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	err = deleter.Delete(now, "/")
+	assert.NoError(t, err)
+	err = ls.Unlock(now, rootToken)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	locks, err := mgr.getLocks(ls.(*userLockSystem).namespace, now)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+	// expired locks are removed
+	ls = mgr.getLockSystem(otherUser)
+	namespace := ls.(*userLockSystem).namespace
+	mgr.cleanup(now.Add(-2 * time.Minute))
+	locks, err = mgr.store.getLocks(namespace)
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, otherToken, locks[0].Token)
+	}
+	mgr.cleanup(now.Add(lockCleanupInterval))
+	locks, err = mgr.store.getLocks(namespace)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+}
+
+func TestPartialUpdateRanges(t *testing.T) {
+	for _, val := range []string{"", "bytes", "bytes=", "bytes=-", "bytes=-0", "bytes=3-2", "bytes=-1-2", "bytes=a-b",
+		"bytes=1-b", "append1"} {
+		_, err := parseUpdateRange(val)
+		assert.ErrorIs(t, err, errInvalidRange, val)
+	}
+	cRange, err := parseUpdateRange("bytes=3-")
+	assert.NoError(t, err)
+	assert.Equal(t, contentRange{start: 3, end: -1}, cRange)
+	assert.NoError(t, cRange.checkLength(10))
+	offset, err := cRange.getOffset(3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	_, err = cRange.getOffset(2)
+	assert.ErrorIs(t, err, errRangeNotSatisfiable)
+
+	for _, val := range []string{"", "bytes 0-1", "bytes=0-1/2", "bytes 0-/2", "bytes 1-0/2", "bytes 0-1/a",
+		"bytes 0-2/2", "bytes */2"} {
+		_, err := parseContentRange(val)
+		assert.ErrorIs(t, err, errInvalidRange, val)
+	}
+	cRange, err = parseContentRange("bytes 2-5/*")
+	assert.NoError(t, err)
+	assert.Equal(t, contentRange{start: 2, end: 5}, cRange)
+	assert.NoError(t, cRange.checkLength(4))
+	assert.ErrorIs(t, cRange.checkLength(3), errInvalidRange)
+
+	req, err := http.NewRequest(http.MethodPatch, "/", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, getPartialUpdateErrorStatus(req, nil))
+	assert.Equal(t, http.StatusInternalServerError, getPartialUpdateErrorStatus(req, errors.New("error")))
+	assert.Equal(t, http.StatusBadRequest, getPartialUpdateErrorStatus(req, io.ErrUnexpectedEOF))
+	assert.Equal(t, http.StatusForbidden, getPartialUpdateErrorStatus(req, os.ErrPermission))
+	assert.Equal(t, http.StatusMethodNotAllowed, getPartialUpdateErrorStatus(req, common.ErrOpUnsupported))
+	req.Method = http.MethodPut
+	assert.Equal(t, http.StatusBadRequest, getPartialUpdateErrorStatus(req, common.ErrOpUnsupported))
+}
+
 func TestMisc(t *testing.T) {
 	oldCertMgr := certMgr
 
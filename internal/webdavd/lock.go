@@ -0,0 +1,437 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	lockTokenPrefix     = "opaquelocktoken:"
+	lockSessionPrefix   = "webdav_locks_"
+	lockCleanupInterval = 10 * time.Minute
+)
+
+var (
+	// maxLockDuration is the maximum allowed lock timeout, infinite locks
+	// are limited to this duration too
+	maxLockDuration = 24 * time.Hour
+	lockMgr         = newLockManager(0)
+)
+
+// webDAVLock defines a lock granted to a user
+type webDAVLock struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"`
+	OwnerXML  string        `json:"owner_xml,omitempty"`
+	ZeroDepth bool          `json:"zero_depth,omitempty"`
+	ExpiresAt int64         `json:"expires_at"`
+}
+
+func (l *webDAVLock) isExpired(now time.Time) bool {
+	return l.ExpiresAt <= util.GetTimeAsMsSinceEpoch(now)
+}
+
+func (l *webDAVLock) setDuration(now time.Time, duration time.Duration) {
+	if duration < 0 || duration > maxLockDuration {
+		duration = maxLockDuration
+	}
+	l.Duration = duration
+	l.ExpiresAt = util.GetTimeAsMsSinceEpoch(now.Add(duration))
+}
+
+func (l *webDAVLock) getDetails() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      l.Root,
+		Duration:  l.Duration,
+		OwnerXML:  l.OwnerXML,
+		ZeroDepth: l.ZeroDepth,
+	}
+}
+
+// covers returns true if the lock applies to the named resource
+func (l *webDAVLock) covers(name string) bool {
+	if l.Root == name {
+		return true
+	}
+	if l.ZeroDepth {
+		return false
+	}
+	return l.Root == "/" || strings.HasPrefix(name, l.Root+"/")
+}
+
+// conflictsWith returns true if the lock prevents the creation of a new
+// lock with the specified root and depth
+func (l *webDAVLock) conflictsWith(root string, zeroDepth bool) bool {
+	if l.covers(root) {
+		return true
+	}
+	if zeroDepth {
+		return false
+	}
+	return root == "/" || strings.HasPrefix(l.Root, root+"/")
+}
+
+// lockStore persists the locks for each user
+type lockStore interface {
+	getLocks(namespace string) ([]webDAVLock, error)
+	setLocks(namespace string, locks []webDAVLock) error
+	cleanup(now time.Time)
+}
+
+type memoryLockStore struct {
+	locks map[string][]webDAVLock
+}
+
+func (s *memoryLockStore) getLocks(namespace string) ([]webDAVLock, error) {
+	return s.locks[namespace], nil
+}
+
+func (s *memoryLockStore) setLocks(namespace string, locks []webDAVLock) error {
+	if len(locks) == 0 {
+		delete(s.locks, namespace)
+		return nil
+	}
+	s.locks[namespace] = locks
+	return nil
+}
+
+func (s *memoryLockStore) cleanup(now time.Time) {
+	for namespace, locks := range s.locks {
+		s.setLocks(namespace, removeExpiredLocks(locks, now)) //nolint:errcheck
+	}
+}
+
+// dbLockStore stores the locks of each user as a shared session so they are
+// visible to all the SFTPGo instances using the same data provider
+type dbLockStore struct{}
+
+func (s *dbLockStore) getLocks(namespace string) ([]webDAVLock, error) {
+	session, err := dataprovider.GetSharedSession(lockSessionPrefix + namespace)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, util.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	val, ok := session.Data.([]byte)
+	if !ok {
+		logger.Error(logSender, "", "invalid locks data type %T for user %q", session.Data, namespace)
+		return nil, nil
+	}
+	var locks []webDAVLock
+	err = json.Unmarshal(val, &locks)
+	return locks, err
+}
+
+func (s *dbLockStore) setLocks(namespace string, locks []webDAVLock) error {
+	key := lockSessionPrefix + namespace
+	if len(locks) == 0 {
+		err := dataprovider.DeleteSharedSession(key)
+		if errors.Is(err, util.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	// the session expires when the last lock expires
+	var expiresAt int64
+	for _, l := range locks {
+		if l.ExpiresAt > expiresAt {
+			expiresAt = l.ExpiresAt
+		}
+	}
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       key,
+		Data:      locks,
+		Type:      dataprovider.SessionTypeWebDAVLock,
+		Timestamp: expiresAt,
+	})
+}
+
+func (s *dbLockStore) cleanup(now time.Time) {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeWebDAVLock, now) //nolint:errcheck
+}
+
+func removeExpiredLocks(locks []webDAVLock, now time.Time) []webDAVLock {
+	result := make([]webDAVLock, 0, len(locks))
+	for _, l := range locks {
+		if !l.isExpired(now) {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// lockManager implements the WebDAV locking for all the users. Each user has
+// its own namespace so the same path can be locked by different users.
+// The held tokens, locks confirmed by in-flight requests, are tracked locally
+type lockManager struct {
+	mu          sync.Mutex
+	store       lockStore
+	held        map[string]bool
+	lastCleanup int64
+}
+
+func newLockManager(isShared int) *lockManager {
+	var store lockStore
+	if isShared == 1 {
+		store = &dbLockStore{}
+	} else {
+		store = &memoryLockStore{
+			locks: make(map[string][]webDAVLock),
+		}
+	}
+	return &lockManager{
+		store: store,
+		held:  make(map[string]bool),
+	}
+}
+
+// getLockSystem returns the lock system for the specified user. The creation
+// time is part of the namespace so the locks of a deleted user are not
+// inherited by a new user with the same username
+func (m *lockManager) getLockSystem(user *dataprovider.User) webdav.LockSystem {
+	return &userLockSystem{
+		namespace: fmt.Sprintf("%s_%d", user.Username, user.CreatedAt),
+		mgr:       m,
+	}
+}
+
+func (m *lockManager) getLocks(namespace string, now time.Time) ([]webDAVLock, error) {
+	locks, err := m.store.getLocks(namespace)
+	if err != nil {
+		logger.Error(logSender, "", "unable to get locks for user %q: %v", namespace, err)
+		return nil, err
+	}
+	return removeExpiredLocks(locks, now), nil
+}
+
+func (m *lockManager) setLocks(namespace string, locks []webDAVLock) error {
+	err := m.store.setLocks(namespace, locks)
+	if err != nil {
+		logger.Error(logSender, "", "unable to save locks for user %q: %v", namespace, err)
+	}
+	return err
+}
+
+// cleanup removes the expired locks, it must be called with the mutex held
+func (m *lockManager) cleanup(now time.Time) {
+	if now.Sub(util.GetTimeFromMsecSinceEpoch(m.lastCleanup)) < lockCleanupInterval {
+		return
+	}
+	m.lastCleanup = util.GetTimeAsMsSinceEpoch(now)
+	logger.Debug(logSender, "", "removing expired locks")
+	m.store.cleanup(now)
+}
+
+type userLockSystem struct {
+	namespace string
+	mgr       *lockManager
+}
+
+// lookup returns the index of the lock matching one of the given conditions
+// and applying to the named resource, -1 if there is no such lock
+func (s *userLockSystem) lookup(locks []webDAVLock, name string, conditions ...webdav.Condition) int {
+	for _, c := range conditions {
+		if c.Token == "" || s.mgr.held[c.Token] {
+			continue
+		}
+		for idx := range locks {
+			if locks[idx].Token == c.Token && locks[idx].covers(name) {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// Confirm implements the webdav.LockSystem interface
+func (s *userLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	locks, err := s.mgr.getLocks(s.namespace, now)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		idx := s.lookup(locks, cleanLockName(name), conditions...)
+		if idx < 0 {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		if !util.Contains(tokens, locks[idx].Token) {
+			tokens = append(tokens, locks[idx].Token)
+		}
+	}
+	for _, token := range tokens {
+		s.mgr.held[token] = true
+	}
+	return func() {
+		s.mgr.mu.Lock()
+		defer s.mgr.mu.Unlock()
+
+		for _, token := range tokens {
+			delete(s.mgr.held, token)
+		}
+	}, nil
+}
+
+// Create implements the webdav.LockSystem interface
+func (s *userLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	s.mgr.cleanup(now)
+
+	locks, err := s.mgr.getLocks(s.namespace, now)
+	if err != nil {
+		return "", err
+	}
+	root := cleanLockName(details.Root)
+	for idx := range locks {
+		if locks[idx].conflictsWith(root, details.ZeroDepth) {
+			return "", webdav.ErrLocked
+		}
+	}
+	lock := webDAVLock{
+		Token:     lockTokenPrefix + util.GenerateUniqueID(),
+		Root:      root,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+	}
+	lock.setDuration(now, details.Duration)
+	locks = append(locks, lock)
+	if err := s.mgr.setLocks(s.namespace, locks); err != nil {
+		return "", err
+	}
+	logger.Debug(logSender, "", "lock %q created for user %q, root %q, duration: %s", lock.Token, s.namespace,
+		lock.Root, lock.Duration)
+	return lock.Token, nil
+}
+
+// Refresh implements the webdav.LockSystem interface
+func (s *userLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	locks, err := s.mgr.getLocks(s.namespace, now)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	for idx := range locks {
+		if locks[idx].Token != token {
+			continue
+		}
+		if s.mgr.held[token] {
+			return webdav.LockDetails{}, webdav.ErrLocked
+		}
+		locks[idx].setDuration(now, duration)
+		if err := s.mgr.setLocks(s.namespace, locks); err != nil {
+			return webdav.LockDetails{}, err
+		}
+		return locks[idx].getDetails(), nil
+	}
+	return webdav.LockDetails{}, webdav.ErrNoSuchLock
+}
+
+// Unlock implements the webdav.LockSystem interface
+func (s *userLockSystem) Unlock(now time.Time, token string) error {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	locks, err := s.mgr.getLocks(s.namespace, now)
+	if err != nil {
+		return err
+	}
+	for idx := range locks {
+		if locks[idx].Token != token {
+			continue
+		}
+		if s.mgr.held[token] {
+			return webdav.ErrLocked
+		}
+		locks = append(locks[:idx], locks[idx+1:]...)
+		logger.Debug(logSender, "", "lock %q removed for user %q", token, s.namespace)
+		return s.mgr.setLocks(s.namespace, locks)
+	}
+	return webdav.ErrNoSuchLock
+}
+
+// GetByName implements the webdav.LockSystem interface
+func (s *userLockSystem) GetByName(name string) (string, time.Time, webdav.LockDetails, error) {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	locks, err := s.mgr.getLocks(s.namespace, time.Now())
+	if err != nil {
+		return "", time.Time{}, webdav.LockDetails{}, err
+	}
+	name = cleanLockName(name)
+	for idx := range locks {
+		if locks[idx].covers(name) {
+			return locks[idx].Token, util.GetTimeFromMsecSinceEpoch(locks[idx].ExpiresAt), locks[idx].getDetails(), nil
+		}
+	}
+	return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+}
+
+// Delete implements the webdav.LockDeleter interface, it removes the locks
+// for the named resource and its descendants
+func (s *userLockSystem) Delete(now time.Time, name string) error {
+	s.mgr.mu.Lock()
+	defer s.mgr.mu.Unlock()
+
+	locks, err := s.mgr.getLocks(s.namespace, now)
+	if err != nil {
+		return err
+	}
+	name = cleanLockName(name)
+	result := make([]webDAVLock, 0, len(locks))
+	for _, l := range locks {
+		if l.Root == name || name == "/" || strings.HasPrefix(l.Root, name+"/") {
+			continue
+		}
+		result = append(result, l)
+	}
+	if len(result) == len(locks) {
+		return nil
+	}
+	return s.mgr.setLocks(s.namespace, result)
+}
+
+func cleanLockName(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
@@ -0,0 +1,312 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	partialUpdateContentType = "application/x-sabredav-partialupdate"
+	partialUpdateDAVClass    = "sabredav-partialupdate"
+	updateRangeHeader        = "X-Update-Range"
+	contentRangeHeader       = "Content-Range"
+)
+
+var (
+	errInvalidRange        = errors.New("invalid range")
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+	lockTokenRegex         = regexp.MustCompile(`<([^>]+)>`)
+)
+
+// contentRange defines a range to update within an existing file
+type contentRange struct {
+	start int64
+	// end is the last byte to update, inclusive, -1 means not specified
+	end int64
+	// fromEnd is the number of bytes to update at the end of the file
+	fromEnd  int64
+	isAppend bool
+}
+
+// getOffset returns the write offset for a file with the specified size
+func (r *contentRange) getOffset(fileSize int64) (int64, error) {
+	if r.isAppend {
+		return fileSize, nil
+	}
+	if r.fromEnd > 0 {
+		if r.fromEnd > fileSize {
+			return 0, fmt.Errorf("%w: the file size %d is less than %d", errRangeNotSatisfiable, fileSize, r.fromEnd)
+		}
+		return fileSize - r.fromEnd, nil
+	}
+	if r.start > fileSize {
+		return 0, fmt.Errorf("%w: start offset %d exceeds the file size %d", errRangeNotSatisfiable, r.start, fileSize)
+	}
+	return r.start, nil
+}
+
+// checkLength returns an error if the range length does not match the
+// request body size
+func (r *contentRange) checkLength(size int64) error {
+	if r.end >= 0 && r.end-r.start+1 != size {
+		return fmt.Errorf("%w: the data length %d is not consistent with the range %d-%d",
+			errInvalidRange, size, r.start, r.end)
+	}
+	if r.fromEnd > 0 && r.fromEnd != size {
+		return fmt.Errorf("%w: the data length %d is not consistent with the range -%d",
+			errInvalidRange, size, r.fromEnd)
+	}
+	return nil
+}
+
+func parseRangeBounds(val string) (int64, int64, error) {
+	start, end, ok := strings.Cut(val, "-")
+	if !ok || start == "" {
+		return 0, 0, errInvalidRange
+	}
+	startOffset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || startOffset < 0 {
+		return 0, 0, errInvalidRange
+	}
+	if end == "" {
+		return startOffset, -1, nil
+	}
+	endOffset, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || endOffset < startOffset {
+		return 0, 0, errInvalidRange
+	}
+	return startOffset, endOffset, nil
+}
+
+// parseUpdateRange parses the sabredav X-Update-Range header. The supported
+// values are "append", "bytes=<start>-<end>", "bytes=<start>-" and "bytes=-<n>"
+func parseUpdateRange(val string) (contentRange, error) {
+	val = strings.TrimSpace(val)
+	if val == "append" {
+		return contentRange{end: -1, isAppend: true}, nil
+	}
+	if !strings.HasPrefix(val, "bytes=") {
+		return contentRange{}, errInvalidRange
+	}
+	val = strings.TrimPrefix(val, "bytes=")
+	if strings.HasPrefix(val, "-") {
+		fromEnd, err := strconv.ParseInt(val[1:], 10, 64)
+		if err != nil || fromEnd <= 0 {
+			return contentRange{}, errInvalidRange
+		}
+		return contentRange{end: -1, fromEnd: fromEnd}, nil
+	}
+	start, end, err := parseRangeBounds(val)
+	if err != nil {
+		return contentRange{}, err
+	}
+	return contentRange{start: start, end: end}, nil
+}
+
+// parseContentRange parses a Content-Range header in the form
+// "bytes <start>-<end>/<size>", size can be "*" if unknown
+func parseContentRange(val string) (contentRange, error) {
+	val = strings.TrimSpace(val)
+	if !strings.HasPrefix(val, "bytes ") {
+		return contentRange{}, errInvalidRange
+	}
+	bounds, size, ok := strings.Cut(strings.TrimPrefix(val, "bytes "), "/")
+	if !ok {
+		return contentRange{}, errInvalidRange
+	}
+	start, end, err := parseRangeBounds(bounds)
+	if err != nil || end < 0 {
+		return contentRange{}, errInvalidRange
+	}
+	if size != "*" {
+		totalSize, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || end >= totalSize {
+			return contentRange{}, errInvalidRange
+		}
+	}
+	return contentRange{start: start, end: end}, nil
+}
+
+// isPartialUpdateSupported returns true if partial updates are supported
+// for the specified virtual path
+func isPartialUpdateSupported(connection *Connection, name string) bool {
+	fs, err := connection.User.GetFilesystemForPath(name, connection.GetID())
+	if err != nil {
+		return false
+	}
+	return vfs.IsLocalOsFs(fs)
+}
+
+// addPartialUpdateCapabilities advertises the partial update support in
+// the OPTIONS response
+func (s *webDavServer) addPartialUpdateCapabilities(w http.ResponseWriter, r *http.Request, connection *Connection) {
+	if r.Method != http.MethodOptions {
+		return
+	}
+	name := util.CleanPath(strings.TrimPrefix(r.URL.Path, s.binding.Prefix))
+	if !isPartialUpdateSupported(connection, name) {
+		return
+	}
+	if dav := w.Header().Get("DAV"); dav != "" {
+		w.Header().Set("DAV", dav+", "+partialUpdateDAVClass)
+	}
+	if allow := w.Header().Get("Allow"); strings.Contains(allow, "PUT") {
+		w.Header().Set("Allow", allow+", PATCH")
+	}
+}
+
+// servePartialUpdate handles sabredav-style PATCH requests and PUT requests
+// with a Content-Range header. It returns true if the request is a partial
+// update and so it is already handled
+func (s *webDavServer) servePartialUpdate(w http.ResponseWriter, r *http.Request, connection *Connection,
+	lockSystem webdav.LockSystem,
+) bool {
+	var cRange contentRange
+	var err error
+
+	switch r.Method {
+	case http.MethodPatch:
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType != partialUpdateContentType {
+			s.writePartialUpdateResponse(w, r, http.StatusUnsupportedMediaType,
+				fmt.Errorf("unsupported content type %q", contentType))
+			return true
+		}
+		if r.ContentLength < 0 {
+			s.writePartialUpdateResponse(w, r, http.StatusLengthRequired, errors.New("content length is required"))
+			return true
+		}
+		cRange, err = parseUpdateRange(r.Header.Get(updateRangeHeader))
+	case http.MethodPut:
+		if r.Header.Get(contentRangeHeader) == "" {
+			return false
+		}
+		cRange, err = parseContentRange(r.Header.Get(contentRangeHeader))
+		if err == nil && cRange.start == 0 {
+			// a ranged upload for a new file starting at 0 is a regular upload
+			name := strings.TrimPrefix(r.URL.Path, s.binding.Prefix)
+			if _, errStat := connection.Stat(r.Context(), name); connection.IsNotExistError(errStat) {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	if err == nil {
+		err = cRange.checkLength(r.ContentLength)
+	}
+	if err != nil {
+		s.writePartialUpdateResponse(w, r, http.StatusBadRequest, err)
+		return true
+	}
+	name := util.CleanPath(strings.TrimPrefix(r.URL.Path, s.binding.Prefix))
+	release, status, err := confirmPartialUpdateLocks(r, lockSystem, name)
+	if err != nil {
+		s.writePartialUpdateResponse(w, r, status, err)
+		return true
+	}
+	defer release()
+
+	err = connection.handlePartialUpdate(name, r.Body, r.ContentLength, cRange.getOffset)
+	s.writePartialUpdateResponse(w, r, getPartialUpdateErrorStatus(r, err), err)
+	return true
+}
+
+func (s *webDavServer) writePartialUpdateResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), status)
+	} else {
+		w.WriteHeader(status)
+	}
+	writeLog(r, status, err)
+}
+
+// confirmPartialUpdateLocks checks that the lock tokens in the If header, if
+// any, allow to modify the named resource. If no token is provided a temporary
+// lock is created to check that the resource is not locked
+func confirmPartialUpdateLocks(r *http.Request, lockSystem webdav.LockSystem, name string) (func(), int, error) {
+	now := time.Now()
+	ifHeader := r.Header.Get("If")
+	if ifHeader == "" {
+		token, err := lockSystem.Create(now, webdav.LockDetails{
+			Root:      name,
+			Duration:  -1,
+			ZeroDepth: true,
+		})
+		if err != nil {
+			if errors.Is(err, webdav.ErrLocked) {
+				return nil, http.StatusLocked, err
+			}
+			return nil, http.StatusInternalServerError, err
+		}
+		return func() {
+			lockSystem.Unlock(now, token) //nolint:errcheck
+		}, 0, nil
+	}
+	var conditions []webdav.Condition
+	for _, match := range lockTokenRegex.FindAllStringSubmatch(ifHeader, -1) {
+		conditions = append(conditions, webdav.Condition{Token: match[1]})
+	}
+	release, err := lockSystem.Confirm(now, name, "", conditions...)
+	if err != nil {
+		if errors.Is(err, webdav.ErrConfirmationFailed) {
+			return nil, http.StatusPreconditionFailed, webdav.ErrLocked
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	return release, 0, nil
+}
+
+func getPartialUpdateErrorStatus(r *http.Request, err error) int {
+	switch {
+	case err == nil:
+		return http.StatusNoContent
+	case errors.Is(err, errRangeNotSatisfiable):
+		return http.StatusRequestedRangeNotSatisfiable
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, common.ErrOpUnsupported):
+		if r.Method == http.MethodPut {
+			// RFC 9110, section 9.3.4: a PUT with a Content-Range header that
+			// cannot be handled as a partial update must be rejected
+			return http.StatusBadRequest
+		}
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/drakkan/webdav"
@@ -35,6 +36,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kerberos"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -77,7 +79,7 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 			certID = s.binding.GetAddress()
 		}
 		httpServer.TLSConfig = &tls.Config{
-			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			GetCertificate:           certMgr.GetCertificateFuncForHosts(certID, s.binding.getVirtualHostsCertIDs()),
 			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
 			NextProtos:               []string{"http/1.1", "h2"},
 			CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
@@ -95,9 +97,13 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 				httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
+		if s.binding.EnableHTTP3 {
+			return util.HTTPListenAndServeWithHTTP3(httpServer, s.binding.Address, s.binding.Port, logSender)
+		}
 		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
 	s.binding.EnableHTTPS = false
+	s.binding.EnableHTTP3 = false
 	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
 	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
 }
@@ -118,11 +124,7 @@ func (s *webDavServer) verifyTLSConnection(state tls.ConnectionState) error {
 			return errors.New("TLS connection cannot be verified: unable to get verification chain")
 		}
 		for _, verifiedChain := range state.VerifiedChains {
-			var caCrt *x509.Certificate
-			if len(verifiedChain) > 0 {
-				caCrt = verifiedChain[len(verifiedChain)-1]
-			}
-			if certMgr.IsRevoked(clientCrt, caCrt) {
+			if certMgr.IsChainRevoked(verifiedChain) {
 				logger.Debug(logSender, "", "tls handshake error, client certificate %q has been revoked", clientCrtName)
 				return common.ErrCrtRevoked
 			}
@@ -151,10 +153,40 @@ func (s *webDavServer) checkRequestMethod(ctx context.Context, r *http.Request,
 	return false
 }
 
+// serveArchiveDownload streams the requested directory as archive. It returns
+// true if the request is an archive download and so it is already handled
+func (s *webDavServer) serveArchiveDownload(w http.ResponseWriter, r *http.Request, connection *Connection) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	name := util.CleanPath(strings.TrimPrefix(r.URL.Path, s.binding.Prefix))
+	archive, ok := connection.GetArchiveDownload(name)
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", archive.GetContentType())
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		writeLog(r, http.StatusOK, nil)
+		return true
+	}
+	err := connection.WriteArchive(w, archive)
+	writeLog(r, http.StatusOK, err)
+	if err != nil {
+		// the response is already started, abort it so the client can detect the error
+		panic(http.ErrAbortHandler)
+	}
+	return true
+}
+
 // ServeHTTP implements the http.Handler interface
 func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
 			logger.Error(logSender, "", "panic in ServeHTTP: %q stack trace: %v", r, string(debug.Stack()))
 			http.Error(w, common.ErrGenericFailure.Error(), http.StatusInternalServerError)
 		}
@@ -174,6 +206,10 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
+	if !common.IsAllowedByGeoIP(&s.binding.GeoIP, ipAddr, common.ProtocolWebDAV) {
+		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
+		return
+	}
 	delay, err := common.LimitRate(common.ProtocolWebDAV, ipAddr)
 	if err != nil {
 		delay += 499999999 * time.Nanosecond
@@ -186,10 +222,21 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
-	user, isCached, lockSystem, loginMethod, err := s.authenticate(r, ipAddr)
+	var user dataprovider.User
+	var isCached bool
+	var loginMethod string
+	if s.isKerberosRequest(r) {
+		loginMethod = dataprovider.LoginMethodKerberos
+		user, err = s.authenticateKerberos(w, r, ipAddr)
+	} else {
+		user, isCached, loginMethod, err = s.authenticate(r, ipAddr)
+	}
 	if err != nil {
 		if !s.binding.DisableWWWAuthHeader {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
+			if s.binding.KerberosService != "" {
+				w.Header().Set("WWW-Authenticate", kerberos.NegotiateScheme)
+			}
+			w.Header().Add("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
 		}
 		http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
 		return
@@ -204,6 +251,16 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.Filters.IsAnonymous {
+		if delay, err := common.CheckAnonymousAccess(ipAddr, common.ProtocolWebDAV, !isCached); err != nil {
+			delay += 499999999 * time.Nanosecond
+			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+			w.Header().Set("X-Retry-In", delay.String())
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	if !isCached {
 		err = user.CheckFsRoot(connectionID)
 	} else {
@@ -238,6 +295,15 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	dataprovider.UpdateLastLogin(&user)
 
+	if s.serveArchiveDownload(w, r.WithContext(ctx), connection) {
+		return
+	}
+
+	lockSystem := lockMgr.getLockSystem(&user)
+	if s.servePartialUpdate(w, r.WithContext(ctx), connection, lockSystem) {
+		return
+	}
+
 	if s.checkRequestMethod(ctx, r, connection) {
 		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 		w.WriteHeader(http.StatusMultiStatus)
@@ -253,6 +319,7 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Logger:     writeLog,
 	}
 	handler.ServeHTTP(w, r.WithContext(ctx))
+	s.addPartialUpdateCapabilities(w, r, connection)
 }
 
 func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, string, string, *x509.Certificate, bool) {
@@ -262,11 +329,15 @@ func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, st
 	if s.binding.isMutualTLSEnabled() && r.TLS != nil {
 		if len(r.TLS.PeerCertificates) > 0 {
 			tlsCert = r.TLS.PeerCertificates[0]
-			if ok {
+			if ok && password != "" {
 				loginMethod = dataprovider.LoginMethodTLSCertificateAndPwd
 			} else {
+				// a username without a password allows to login using a pinned
+				// certificate whose CN does not match the username
 				loginMethod = dataprovider.LoginMethodTLSCertificate
-				username = tlsCert.Subject.CommonName
+				if !ok {
+					username = tlsCert.Subject.CommonName
+				}
 				password = ""
 			}
 			ok = true
@@ -275,49 +346,85 @@ func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, st
 	return username, password, loginMethod, tlsCert, ok
 }
 
-func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.User, bool, webdav.LockSystem, string, error) {
+func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.User, bool, string, error) {
 	var user dataprovider.User
 	var err error
 	username, password, loginMethod, tlsCert, ok := s.getCredentialsAndLoginMethod(r)
 	if !ok {
 		user.Username = username
-		return user, false, nil, loginMethod, common.ErrNoCredentials
+		return user, false, loginMethod, common.ErrNoCredentials
 	}
 	cachedUser, ok := dataprovider.GetCachedWebDAVUser(username)
 	if ok {
 		if cachedUser.IsExpired() {
 			dataprovider.RemoveCachedWebDAVUser(username)
 		} else {
-			if !cachedUser.User.IsTLSUsernameVerificationEnabled() {
+			if !cachedUser.User.IsTLSCertificateAuthEnabled() {
 				// for backward compatibility with 2.0.x we only check the password
 				tlsCert = nil
 				loginMethod = dataprovider.LoginMethodPassword
 			}
 			if err := dataprovider.CheckCachedUserCredentials(cachedUser, password, loginMethod, common.ProtocolWebDAV, tlsCert); err == nil {
-				return cachedUser.User, true, cachedUser.LockSystem, loginMethod, nil
+				return cachedUser.User, true, loginMethod, nil
 			}
 			updateLoginMetrics(&cachedUser.User, ip, loginMethod, dataprovider.ErrInvalidCredentials)
-			return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
+			return user, false, loginMethod, dataprovider.ErrInvalidCredentials
 		}
 	}
 	user, loginMethod, err = dataprovider.CheckCompositeCredentials(username, password, ip, loginMethod,
-		common.ProtocolWebDAV, tlsCert)
+		common.ProtocolWebDAV, tlsCert, s.binding.LDAPDirectory)
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ip, loginMethod, err)
-		return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
+		return user, false, loginMethod, dataprovider.ErrInvalidCredentials
 	}
-	lockSystem := webdav.NewMemLS()
 	cachedUser = &dataprovider.CachedUser{
-		User:       user,
-		Password:   password,
-		LockSystem: lockSystem,
+		User:     user,
+		Password: password,
 	}
 	if s.config.Cache.Users.ExpirationTime > 0 {
 		cachedUser.Expiration = time.Now().Add(time.Duration(s.config.Cache.Users.ExpirationTime) * time.Minute)
 	}
 	dataprovider.CacheWebDAVUser(cachedUser)
-	return user, false, lockSystem, loginMethod, nil
+	return user, false, loginMethod, nil
+}
+
+func (s *webDavServer) isKerberosRequest(r *http.Request) bool {
+	if s.binding.KerberosService == "" {
+		return false
+	}
+	_, ok, _ := kerberos.GetNegotiateToken(r.Header.Get("Authorization"))
+	return ok
+}
+
+func (s *webDavServer) authenticateKerberos(w http.ResponseWriter, r *http.Request, ip string) (dataprovider.User, error) {
+	var user dataprovider.User
+	loginMethod := dataprovider.LoginMethodKerberos
+
+	service, err := dataprovider.GetKerberosService(s.binding.KerberosService)
+	if err != nil {
+		return user, err
+	}
+	token, _, err := kerberos.GetNegotiateToken(r.Header.Get("Authorization"))
+	if err != nil {
+		updateLoginMetrics(&user, ip, loginMethod, err)
+		return user, dataprovider.ErrInvalidCredentials
+	}
+	principal, response, err := service.AcceptSPNEGO(token)
+	if err != nil {
+		updateLoginMetrics(&user, ip, loginMethod, err)
+		return user, dataprovider.ErrInvalidCredentials
+	}
+	user, err = dataprovider.CheckKerberosAuth("", principal, ip, common.ProtocolWebDAV, s.binding.KerberosService)
+	if err != nil {
+		user.Username = principal
+		updateLoginMetrics(&user, ip, loginMethod, err)
+		return user, dataprovider.ErrInvalidCredentials
+	}
+	if len(response) > 0 {
+		w.Header().Set("WWW-Authenticate", kerberos.GetNegotiateHeader(response))
+	}
+	return user, nil
 }
 
 func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, loginMethod string) (string, error) {
@@ -333,12 +440,18 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, lo
 		logger.Info(logSender, connectionID, "cannot login user %q, protocol DAV is not allowed", user.Username)
 		return connID, fmt.Errorf("protocol DAV is not allowed for user %q", user.Username)
 	}
+	if host := getRequestHost(r); !s.binding.isRoleAllowed(host, user.Role) {
+		logger.Info(logSender, connectionID, "cannot login user %q, role %q is not allowed for host %q",
+			user.Username, user.Role, host)
+		return connID, fmt.Errorf("login for user %q is not allowed on this host", user.Username)
+	}
 	if !user.IsLoginMethodAllowed(loginMethod, common.ProtocolWebDAV, nil) {
 		logger.Info(logSender, connectionID, "cannot login user %q, %v login method is not allowed",
 			user.Username, loginMethod)
 		return connID, fmt.Errorf("login method %v is not allowed for user %q", loginMethod, user.Username)
 	}
 	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		common.RequestIPApproval(user, r.RemoteAddr, common.ProtocolWebDAV)
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, r.RemoteAddr)
 		return connID, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, r.RemoteAddr)
@@ -346,6 +459,19 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, lo
 	return connID, nil
 }
 
+// getRequestHost returns the lower cased host requested by the client, without the port.
+// The server name requested using TLS SNI is used if the Host header is empty
+func getRequestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" && r.TLS != nil {
+		host = r.TLS.ServerName
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
 func (s *webDavServer) checkRemoteAddress(r *http.Request) string {
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	var ip net.IP
@@ -20,11 +20,13 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/geoip"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -98,6 +100,9 @@ type Binding struct {
 	Port int `json:"port" mapstructure:"port"`
 	// you also need to provide a certificate for enabling HTTPS
 	EnableHTTPS bool `json:"enable_https" mapstructure:"enable_https"`
+	// Enable HTTP/3 on the UDP port matching the TCP one, HTTPS must be enabled.
+	// HTTP/3 is advertised to clients using the Alt-Svc header
+	EnableHTTP3 bool `json:"enable_http3" mapstructure:"enable_http3"`
 	// Certificate and matching private key for this specific binding, if empty the global
 	// ones will be used, if any
 	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
@@ -133,7 +138,34 @@ type Binding struct {
 	// Do not add the WWW-Authenticate header after an authentication error,
 	// only the 401 status code will be sent
 	DisableWWWAuthHeader bool `json:"disable_www_auth_header" mapstructure:"disable_www_auth_header"`
-	allowHeadersFrom     []func(net.IP) bool
+	// Name of the LDAP directory to use for password authentication on this binding.
+	// Leave empty to use the configured authentication methods
+	LDAPDirectory string `json:"ldap_directory" mapstructure:"ldap_directory"`
+	// Name of the Kerberos service to use for SPNEGO authentication on this binding.
+	// Leave empty to disable Kerberos authentication
+	KerberosService string `json:"kerberos_service" mapstructure:"kerberos_service"`
+	// Countries and autonomous systems allowed or denied to connect to this binding.
+	// They are ignored if GeoIP is not configured
+	GeoIP geoip.Filter `json:"geoip" mapstructure:"geoip"`
+	// Virtual hosts allow to serve different host names from this binding with
+	// their own certificates and allowed user roles
+	VirtualHosts     []VirtualHost `json:"virtual_hosts" mapstructure:"virtual_hosts"`
+	allowHeadersFrom []func(net.IP) bool
+}
+
+// VirtualHost defines the configuration for a host name served by a binding.
+// Requests are matched against the Host header or, if it is empty, against
+// the server name requested by the client using TLS SNI
+type VirtualHost struct {
+	// Host name, for example "files.example.com"
+	Host string `json:"host" mapstructure:"host"`
+	// Certificate and matching private key for this host, if empty the binding
+	// ones will be used. HTTPS must be enabled on the binding
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Users must have one of these roles to login using this host.
+	// Leave empty to allow any user
+	AllowedRoles []string `json:"allowed_roles" mapstructure:"allowed_roles"`
 }
 
 func (b *Binding) parseAllowedProxy() error {
@@ -154,6 +186,44 @@ func (b *Binding) isMutualTLSEnabled() bool {
 	return b.ClientAuthType == 1 || b.ClientAuthType == 2
 }
 
+func (b *Binding) checkVirtualHosts() error {
+	hosts := make(map[string]bool)
+	for idx := range b.VirtualHosts {
+		vh := &b.VirtualHosts[idx]
+		vh.Host = strings.ToLower(strings.TrimSpace(vh.Host))
+		if vh.Host == "" {
+			return fmt.Errorf("virtual host %d for binding %q: host is mandatory", idx, b.GetAddress())
+		}
+		if hosts[vh.Host] {
+			return fmt.Errorf("virtual host %q for binding %q is duplicated", vh.Host, b.GetAddress())
+		}
+		hosts[vh.Host] = true
+		vh.AllowedRoles = util.RemoveDuplicates(vh.AllowedRoles, false)
+	}
+	return nil
+}
+
+func (b *Binding) getVirtualHostsCertIDs() map[string]string {
+	certIDs := make(map[string]string)
+	for _, vh := range b.VirtualHosts {
+		if getConfigPath(vh.CertificateFile, "") != "" && getConfigPath(vh.CertificateKeyFile, "") != "" {
+			certIDs[strings.ToLower(vh.Host)] = common.GetVirtualHostCertificateID(b.GetAddress(), vh.Host)
+		}
+	}
+	return certIDs
+}
+
+// isRoleAllowed returns true if users with the specified role can login
+// using the specified host
+func (b *Binding) isRoleAllowed(host, role string) bool {
+	for _, vh := range b.VirtualHosts {
+		if vh.Host == host {
+			return len(vh.AllowedRoles) == 0 || util.Contains(vh.AllowedRoles, role)
+		}
+	}
+	return true
+}
+
 // GetAddress returns the binding address
 func (b *Binding) GetAddress() string {
 	return fmt.Sprintf("%s:%d", b.Address, b.Port)
@@ -179,6 +249,9 @@ type Configuration struct {
 	// CARevocationLists defines a set a revocation lists, one for each root CA, to be used to check
 	// if a client certificate has been revoked
 	CARevocationLists []string `json:"ca_revocation_lists" mapstructure:"ca_revocation_lists"`
+	// RevocationCheck defines the OCSP checks for client certificates and if certificates
+	// whose revocation status cannot be determined must be rejected
+	RevocationCheck common.RevocationConfig `json:"revocation_check" mapstructure:"revocation_check"`
 	// CORS configuration
 	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Cache configuration
@@ -215,6 +288,17 @@ func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
 				ID:   binding.GetAddress(),
 			})
 		}
+		for _, vh := range binding.VirtualHosts {
+			certificateFile := getConfigPath(vh.CertificateFile, configDir)
+			certificateKeyFile := getConfigPath(vh.CertificateKeyFile, configDir)
+			if certificateFile != "" && certificateKeyFile != "" {
+				keyPairs = append(keyPairs, common.TLSKeyPair{
+					Cert: certificateFile,
+					Key:  certificateKeyFile,
+					ID:   common.GetVirtualHostCertificateID(binding.GetAddress(), vh.Host),
+				})
+			}
+		}
 	}
 	var certificateFile, certificateKeyFile string
 	if c.acmeDomain != "" {
@@ -268,6 +352,8 @@ func (c *Configuration) Initialize(configDir string) error {
 		return err
 	}
 	logger.Info(logSender, "", "initializing WebDAV server with config %+v", *c)
+	providerConf := dataprovider.GetProviderConfig()
+	lockMgr = newLockManager(providerConf.GetShared())
 	mimeTypeCache = mimeCache{
 		maxSize:   c.Cache.MimeTypes.MaxSize,
 		mimeTypes: make(map[string]string),
@@ -302,6 +388,9 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := mgr.LoadCRLs(); err != nil {
 			return err
 		}
+		if err := mgr.SetRevocationConfig(c.RevocationCheck); err != nil {
+			return err
+		}
 		certMgr = mgr
 	}
 	compressor := middleware.NewCompressor(5, "text/*")
@@ -320,6 +409,18 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := binding.parseAllowedProxy(); err != nil {
 			return err
 		}
+		if err := dataprovider.CheckLDAPDirectory(binding.LDAPDirectory); err != nil {
+			return err
+		}
+		if err := dataprovider.CheckKerberosService(binding.KerberosService); err != nil {
+			return err
+		}
+		if err := binding.GeoIP.Validate(); err != nil {
+			return fmt.Errorf("invalid GeoIP filter for binding %q: %w", binding.GetAddress(), err)
+		}
+		if err := binding.checkVirtualHosts(); err != nil {
+			return err
+		}
 
 		go func(binding Binding) {
 			server := webDavServer{
@@ -343,6 +444,14 @@ func ReloadCertificateMgr() error {
 	return nil
 }
 
+// ReloadRevocationData reloads the CRLs and removes the cached OCSP responses
+func ReloadRevocationData() error {
+	if certMgr != nil {
+		return certMgr.ReloadRevocationData()
+	}
+	return nil
+}
+
 func getConfigPath(name, configDir string) string {
 	if !util.IsFileInputValid(name) {
 		return ""
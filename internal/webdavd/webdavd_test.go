@@ -18,7 +18,10 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,6 +41,7 @@ import (
 	"time"
 
 	"github.com/minio/sio"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rs/zerolog"
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
@@ -364,6 +368,7 @@ func TestMain(m *testing.M) {
 		{
 			Port:               webDavTLSServerPort,
 			EnableHTTPS:        true,
+			EnableHTTP3:        true,
 			CertificateFile:    certPath,
 			CertificateKeyFile: keyPath,
 			ClientAuthType:     2,
@@ -944,6 +949,357 @@ func TestRenameWithLock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLockUnlock(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	client := getWebDavClient(user, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	err = uploadFileWithRawClient(testFilePath, testFileName, user.Username, defaultPassword,
+		false, testFileSize, client)
+	assert.NoError(t, err)
+
+	lockBody := `<?xml version="1.0" encoding="utf-8" ?><d:lockinfo xmlns:d="DAV:"><d:lockscope><d:exclusive/></d:lockscope><d:locktype><d:write/></d:locktype></d:lockinfo>`
+	fileURL := fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName)
+	req, err := http.NewRequest("LOCK", fileURL, bytes.NewReader([]byte(lockBody)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("Timeout", "Second-3600")
+	httpClient := httpclient.GetHTTPClient()
+	resp, err := httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	lockToken := resp.Header.Get("Lock-Token")
+	assert.True(t, strings.HasPrefix(lockToken, "<opaquelocktoken:"), lockToken)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// the lock must survive the removal of the user from the cache
+	dataprovider.RemoveCachedWebDAVUser(user.Username)
+	req, err = http.NewRequest(http.MethodPut, fileURL, bytes.NewReader([]byte("content")))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// a second lock must fail
+	req, err = http.NewRequest("LOCK", fileURL, bytes.NewReader([]byte(lockBody)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// the lock must be reported in the lock discovery
+	req, err = http.NewRequest("PROPFIND", fileURL, bytes.NewReader([]byte(`<?xml version="1.0" encoding="utf-8" ?><d:propfind xmlns:d="DAV:"><d:prop><d:lockdiscovery/></d:prop></d:propfind>`)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("Depth", "0")
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	response, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), strings.Trim(lockToken, "<>"))
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// refresh the lock
+	req, err = http.NewRequest("LOCK", fileURL, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("If", fmt.Sprintf("(%v)", lockToken))
+	req.Header.Set("Timeout", "Second-600")
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// upload using the lock token
+	req, err = http.NewRequest(http.MethodPut, fileURL, bytes.NewReader([]byte("content")))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("If", fmt.Sprintf("(%v)", lockToken))
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest("UNLOCK", fileURL, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("Lock-Token", lockToken)
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// the file is now unlocked
+	req, err = http.NewRequest(http.MethodPut, fileURL, bytes.NewReader([]byte("content")))
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// unlock again must fail
+	req, err = http.NewRequest("UNLOCK", fileURL, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	req.Header.Set("Lock-Token", lockToken)
+	resp, err = httpClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+}
+
+func TestPartialUpdate(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUserWithCryptFs()
+	u.Username += "_crypt"
+	cryptFsUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	client := getWebDavClient(user, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	err = client.Write(testFileName, []byte("0123456789"), os.ModePerm)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodOptions, fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("DAV"), "sabredav-partialupdate")
+	assert.Contains(t, resp.Header.Get("Allow"), "PATCH")
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+
+	partialUpdateHeaders := func(updateRange string) []dataprovider.KeyValue {
+		return []dataprovider.KeyValue{
+			{Key: "Content-Type", Value: "application/x-sabredav-partialupdate"},
+			{Key: "X-Update-Range", Value: updateRange},
+		}
+	}
+	status, err := sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("abc"),
+		dataprovider.KeyValue{Key: "X-Update-Range", Value: "bytes=2-4"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("abc"),
+		partialUpdateHeaders("bytes=2-4")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("XY"),
+		partialUpdateHeaders("append")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=-2")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=8-")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	content, err := client.Read(testFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "01abc567zzzz", string(content))
+	// invalid ranges
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=20-")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=-20")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=1-5")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("zz"),
+		partialUpdateHeaders("bytes=a-")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName+"_missing", user.Username, []byte("zz"),
+		partialUpdateHeaders("append")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+	// Content-Range PUT
+	status, err = sendPartialUpdate(http.MethodPut, testFileName, user.Username, []byte("AB"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 0-1/*"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = sendPartialUpdate(http.MethodPut, testFileName, user.Username, []byte("!!"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 12-13/14"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = sendPartialUpdate(http.MethodPut, testFileName, user.Username, []byte("!!"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 13-14/14"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, err = sendPartialUpdate(http.MethodPut, testFileName, user.Username, []byte("!!"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 30-31/*"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, status)
+	content, err = client.Read(testFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "ABabc567zzzz!!", string(content))
+	// a ranged upload starting at 0 for a new file is a regular upload
+	status, err = sendPartialUpdate(http.MethodPut, testFileName+"_new", user.Username, []byte("new"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 0-2/6"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status)
+	content, err = client.Read(testFileName + "_new")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	// partial updates must honor locks
+	lockBody := `<?xml version="1.0" encoding="utf-8" ?><d:lockinfo xmlns:d="DAV:"><d:lockscope><d:exclusive/></d:lockscope><d:locktype><d:write/></d:locktype></d:lockinfo>`
+	req, err = http.NewRequest("LOCK", fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName), bytes.NewReader([]byte(lockBody)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err = httpclient.GetHTTPClient().Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	lockToken := resp.Header.Get("Lock-Token")
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("ab"),
+		partialUpdateHeaders("bytes=0-1")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusLocked, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("ab"),
+		append(partialUpdateHeaders("bytes=0-1"), dataprovider.KeyValue{Key: "If", Value: "(<opaquelocktoken:invalid>)"})...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("ab"),
+		append(partialUpdateHeaders("bytes=0-1"), dataprovider.KeyValue{Key: "If", Value: fmt.Sprintf("(%s)", lockToken)})...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	content, err = client.Read(testFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "ababc567zzzz!!", string(content))
+	// quota limits are checked before writing
+	user.Filters.MaxUploadFileSize = 20
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, user.Username, []byte("0123456789"),
+		append(partialUpdateHeaders("append"), dataprovider.KeyValue{Key: "If", Value: fmt.Sprintf("(%s)", lockToken)})...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInsufficientStorage, status)
+	content, err = client.Read(testFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "ababc567zzzz!!", string(content))
+	// partial updates are not supported for encrypted filesystems
+	cryptClient := getWebDavClient(cryptFsUser, false, nil)
+	err = cryptClient.Write(testFileName, []byte("0123456789"), os.ModePerm)
+	assert.NoError(t, err)
+	status, err = sendPartialUpdate(http.MethodPatch, testFileName, cryptFsUser.Username, []byte("zz"),
+		partialUpdateHeaders("append")...)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+	status, err = sendPartialUpdate(http.MethodPut, testFileName, cryptFsUser.Username, []byte("!!"),
+		dataprovider.KeyValue{Key: "Content-Range", Value: "bytes 2-3/*"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(cryptFsUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(cryptFsUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestHTTP3(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	tlsConfig := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+	}
+	fileURL := fmt.Sprintf("https://%v/%v", webDavTLSServerAddr, testFileName)
+	content := []byte("HTTP/3 test content")
+	// the HTTP/3 endpoint is advertised over HTTP/1.1 and HTTP/2
+	req, err := http.NewRequest(http.MethodPut, fileURL, bytes.NewReader(content))
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err := getTLSHTTPClient(tlsConfig).Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Alt-Svc"), fmt.Sprintf(`h3=":%d"`, webDavTLSServerPort))
+		resp.Body.Close()
+	}
+	// plain HTTP bindings do not advertise HTTP/3
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err = httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Alt-Svc"))
+		resp.Body.Close()
+	}
+
+	transport := &http3.RoundTripper{
+		TLSClientConfig: tlsConfig,
+	}
+	defer transport.Close()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: transport,
+	}
+	req, err = http.NewRequest(http.MethodGet, fileURL, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err = client.Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 3, resp.ProtoMajor)
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		resp.Body.Close()
+	}
+	// invalid credentials must be rejected over HTTP/3 too
+	req, err = http.NewRequest(http.MethodGet, fileURL, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, "invalid pwd")
+	resp, err = client.Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPropPatch(t *testing.T) {
 	u := getTestUser()
 	u.Username = u.Username + "1"
@@ -1191,7 +1547,7 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.LoginMethodKerberos}, user.Filters.DeniedLoginMethods)
 
 	u.Password = emptyPwdPlaceholder
 	client = getWebDavClient(user, false, nil)
@@ -2684,6 +3040,60 @@ func TestClientCertificateAuth(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestClientCertificatePinnedAuth(t *testing.T) {
+	tlsCert, err := tls.X509KeyPair([]byte(client1Crt), []byte(client1Key))
+	assert.NoError(t, err)
+	x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	assert.NoError(t, err)
+	fingerprint := sha256.Sum256(x509Cert.Raw)
+	// the certificate CN does not match the username
+	u := getTestUser()
+	u.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword, dataprovider.LoginMethodTLSCertificateAndPwd}
+	u.Filters.TLSCertPins = []string{hex.EncodeToString(fingerprint[:])}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	tlsConfig := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, tlsCert)
+	// the username is taken from the certificate CN
+	resp, err := getTLSHTTPClient(tlsConfig).Get(fmt.Sprintf("https://%v/", webDavTLSServerAddr))
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	// the username is sent using basic auth without a password
+	user.Password = emptyPwdPlaceholder
+	client := getWebDavClient(user, true, tlsConfig)
+	err = checkBasicFunc(client)
+	assert.NoError(t, err)
+
+	user.Password = ""
+	user.Filters.TLSCertPins = []string{"CN=" + tlsClient2Username}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user.Password = emptyPwdPlaceholder
+	client = getWebDavClient(user, true, tlsConfig)
+	err = checkBasicFunc(client)
+	assert.Error(t, err)
+
+	user.Password = ""
+	user.Filters.TLSCertPins = []string{x509Cert.Subject.String()}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user.Password = emptyPwdPlaceholder
+	client = getWebDavClient(user, true, tlsConfig)
+	err = checkBasicFunc(client)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestWrongClientCertificate(t *testing.T) {
 	u := getTestUser()
 	u.Username = tlsClient2Username
@@ -3193,6 +3603,24 @@ func uploadFileWithRawClient(localSourcePath string, remoteDestPath string, user
 	return nil
 }*/
 
+func sendPartialUpdate(method, remoteDestPath, username string, content []byte, headers ...dataprovider.KeyValue) (int, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%v/%v", webDavServerAddr, remoteDestPath),
+		bytes.NewReader(content))
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(username, defaultPassword)
+	for _, kv := range headers {
+		req.Header.Set(kv.Key, kv.Value)
+	}
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
 func downloadFile(remoteSourcePath string, localDestPath string, expectedSize int64, client *gowebdav.Client) error {
 	downloadDest, err := os.Create(localDestPath)
 	if err != nil {